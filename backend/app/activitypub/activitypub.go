@@ -0,0 +1,468 @@
+// Package activitypub federates comment threads with the fediverse. Each post exposed as ActivityPub actor which
+// users of mastodon and other servers can follow, new comments of the post published to followers as notes and
+// listed in the outbox of the actor. Replies to the notes and mentions of the actor delivered to its inbox become
+// comments of the post, held for moderation by default. Requests between servers signed with http signatures.
+package activitypub
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"crypto/sha1" //nolint:gosec // used for keys of posts and user ids, not for security
+	"encoding/hex"
+	"encoding/json"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	log "github.com/go-pkgz/lgr"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/notify"
+	"github.com/umputun/remark42/backend/app/publicnet"
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// DataService defines subset of service.DataStore used to publish comments
+type DataService interface {
+	Get(locator store.Locator, commentID string, user store.User) (store.Comment, error)
+	Find(locator store.Locator, sortMethod string, user store.User) ([]store.Comment, error)
+	Info(locator store.Locator, readonlyAge int) (store.PostInfo, error)
+}
+
+// Creator creates comments going through the same checks as comments of web widget, implemented by rest api
+type Creator interface {
+	CreateComment(ctx context.Context, comment store.Comment) (store.Comment, error)
+}
+
+// Follower is a remote actor following the post
+type Follower struct {
+	ID    string `json:"id"`    // actor id, url of actor's document
+	Inbox string `json:"inbox"` // inbox of actor, new comments of the post delivered to it
+}
+
+// Store defines interface to keep federated posts, their followers and notes received from remote servers
+type Store interface {
+	AddPost(key string, locator store.Locator) error
+	Post(key string) (locator store.Locator, found bool, err error)
+	AddFollower(key string, follower Follower) error
+	RemoveFollower(key, actorID string) error
+	Followers(key string) ([]Follower, error)
+	AddNote(siteID, noteID, commentID string) error // links remote note to the comment made from it
+	NoteComment(siteID, noteID string) (commentID string, found bool, err error)
+	CommentNote(siteID, commentID string) (noteID string, found bool, err error)
+	Key() (pemKey []byte, err error) // private key of actors, nil if not saved yet
+	SetKey(pemKey []byte) error
+}
+
+// ErrDuplicateNote returned by AddNote if the note already linked to a comment
+var ErrDuplicateNote = errors.New("note already received")
+
+// Params of the service
+type Params struct {
+	URL      string        // root url of remark42, actors served under URL/api/v1/ap
+	Domain   string        // domain of actor handles, host of URL if empty
+	Moderate bool          // hold comments from remote servers for moderation
+	Timeout  time.Duration // timeout of requests to remote servers
+	Links    store.Links   // canonical links to threads of virtual locators
+}
+
+// Service serves actors of posts and their inboxes, implements http.Handler mounted to /api/v1/ap.
+// Implements notify.Destination to publish new comments to followers.
+type Service struct {
+	Params
+	Store       Store
+	DataService DataService
+	Creator     Creator
+
+	key       *rsa.PrivateKey
+	publicKey string
+	client    *http.Client
+	router    chi.Router
+	wg        sync.WaitGroup // deliveries made in background
+}
+
+const (
+	contentType   = "application/activity+json"
+	userPrefix    = "ap_" // user id prefix of remote actors
+	apPath        = "/api/v1/ap"
+	maxBodySize   = 1024 * 1024
+	outboxSize    = 20
+	publicAddress = "https://www.w3.org/ns/activitystreams#Public"
+	apContext     = "https://www.w3.org/ns/activitystreams"
+)
+
+// Object of ActivityPub, used for activities, notes and collections published
+type Object struct {
+	Context      interface{} `json:"@context,omitempty"`
+	ID           string      `json:"id"`
+	Type         string      `json:"type"`
+	Actor        string      `json:"actor,omitempty"`
+	AttributedTo string      `json:"attributedTo,omitempty"`
+	InReplyTo    string      `json:"inReplyTo,omitempty"`
+	Content      string      `json:"content,omitempty"`
+	Published    string      `json:"published,omitempty"`
+	URL          string      `json:"url,omitempty"`
+	To           []string    `json:"to,omitempty"`
+	Cc           []string    `json:"cc,omitempty"`
+	Object       interface{} `json:"object,omitempty"`
+	TotalItems   *int        `json:"totalItems,omitempty"`
+	OrderedItems []Object    `json:"orderedItems,omitempty"`
+}
+
+// Actor of ActivityPub, published for posts and fetched for remote users
+type Actor struct {
+	Context           interface{} `json:"@context,omitempty"`
+	ID                string      `json:"id"`
+	Type              string      `json:"type"`
+	PreferredUsername string      `json:"preferredUsername,omitempty"`
+	Name              string      `json:"name,omitempty"`
+	Summary           string      `json:"summary,omitempty"`
+	URL               string      `json:"url,omitempty"`
+	Inbox             string      `json:"inbox"`
+	Outbox            string      `json:"outbox,omitempty"`
+	Followers         string      `json:"followers,omitempty"`
+	PublicKey         struct {
+		ID           string `json:"id"`
+		Owner        string `json:"owner"`
+		PublicKeyPem string `json:"publicKeyPem"`
+	} `json:"publicKey"`
+}
+
+// NewService makes service with private key of actors loaded from store, generated on the first start
+func NewService(st Store, dataService DataService, params Params) (*Service, error) {
+	u, err := url.Parse(params.URL)
+	if err != nil || u.Host == "" {
+		return nil, errors.Errorf("invalid url %q", params.URL)
+	}
+	params.URL = strings.TrimSuffix(params.URL, "/")
+	if params.Domain == "" {
+		params.Domain = u.Host
+	}
+	if params.Timeout == 0 {
+		params.Timeout = 10 * time.Second
+	}
+	res := &Service{Params: params, Store: st, DataService: dataService, client: publicnet.NewClient(params.Timeout)}
+
+	pemKey, err := st.Key()
+	if err != nil {
+		return nil, errors.Wrap(err, "can't load key")
+	}
+	if pemKey == nil {
+		if res.key, pemKey, err = makeKey(); err != nil {
+			return nil, err
+		}
+		if err = st.SetKey(pemKey); err != nil {
+			return nil, errors.Wrap(err, "can't save key")
+		}
+	} else if res.key, err = parsePrivateKey(pemKey); err != nil {
+		return nil, err
+	}
+	if res.publicKey, err = publicKeyPEM(&res.key.PublicKey); err != nil {
+		return nil, err
+	}
+
+	res.router = chi.NewRouter()
+	res.router.Get("/post", res.postCtrl)
+	res.router.Route("/actor/{key}", func(r chi.Router) {
+		r.Get("/", res.actorCtrl)
+		r.Get("/outbox", res.outboxCtrl)
+		r.Get("/followers", res.followersCtrl)
+		r.Get("/note/{id}", res.noteCtrl)
+		r.Post("/inbox", res.inboxCtrl)
+	})
+	return res, nil
+}
+
+// ServeHTTP handles requests to actors
+func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+// Send publishes new comment to followers of the post, implements notify.Destination.
+// Comments of remote users not published, their servers do it.
+func (s *Service) Send(ctx context.Context, req notify.Request) error {
+	c := req.Comment
+	if req.Moderation != "" || c.ID == "" || c.Pending || c.Deleted || strings.HasPrefix(c.User.ID, userPrefix) {
+		return nil // held comment published on approval
+	}
+	key, err := s.register(c.Locator)
+	if err != nil {
+		return err
+	}
+	followers, err := s.Store.Followers(key)
+	if err != nil {
+		return errors.Wrapf(err, "can't get followers of %s", c.Locator.URL)
+	}
+	if len(followers) == 0 {
+		return nil
+	}
+
+	note := s.note(key, c, req.CommentLink(c.ID))
+	activity := Object{Context: apContext, ID: note.ID + "/activity", Type: "Create", Actor: s.actorURL(key),
+		To: note.To, Cc: note.Cc, Object: note}
+	errs := new(multierror.Error)
+	inboxes := map[string]bool{}
+	for _, f := range followers {
+		if inboxes[f.Inbox] {
+			continue
+		}
+		inboxes[f.Inbox] = true
+		if e := s.deliver(ctx, key, f.Inbox, activity); e != nil {
+			errs = multierror.Append(errs, e)
+		}
+	}
+	log.Printf("[DEBUG] comment %s published to %d inboxes, %d failed", c.ID, len(inboxes), errs.Len())
+	return errs.ErrorOrNil()
+}
+
+// SendVerification implements notify.Destination, verification messages not federated
+func (s *Service) SendVerification(_ context.Context, _ notify.VerificationRequest) error {
+	return nil
+}
+
+// String implements notify.Destination
+func (s *Service) String() string {
+	return "activitypub: " + s.Domain
+}
+
+// Close waits for deliveries in progress
+func (s *Service) Close() {
+	s.wg.Wait()
+}
+
+// WebFinger handles GET /.well-known/webfinger?resource=acct:key@domain, resolves handle of the post actor
+func (s *Service) WebFinger(w http.ResponseWriter, r *http.Request) {
+	resource := r.URL.Query().Get("resource")
+	key := strings.TrimPrefix(resource, "acct:")
+	if !strings.HasSuffix(key, "@"+s.Domain) {
+		http.Error(w, "Unknown domain", http.StatusNotFound)
+		return
+	}
+	key = strings.TrimSuffix(key, "@"+s.Domain)
+	if _, ok := s.post(w, key); !ok {
+		return
+	}
+	writeJSON(w, "application/jrd+json", map[string]interface{}{
+		"subject": "acct:" + key + "@" + s.Domain,
+		"links":   []map[string]string{{"rel": "self", "type": contentType, "href": s.actorURL(key)}},
+	})
+}
+
+// GET /post?site=siteID&url=post-url - returns actor and handle of the post, post should have comments
+func (s *Service) postCtrl(w http.ResponseWriter, r *http.Request) {
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	if _, err := s.DataService.Info(locator, 0); err != nil {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	key, err := s.register(locator)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, "application/json", map[string]string{"actor": s.actorURL(key), "handle": "@" + key + "@" + s.Domain})
+}
+
+// GET /actor/{key} - returns actor of the post
+func (s *Service) actorCtrl(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+	locator, ok := s.post(w, key)
+	if !ok {
+		return
+	}
+	actorURL := s.actorURL(key)
+	name := locator.URL
+	if comments, err := s.DataService.Find(locator, "-time", store.User{}); err == nil && len(comments) > 0 &&
+		comments[0].PostTitle != "" {
+		name = comments[0].PostTitle
+	}
+	actor := Actor{
+		Context:           []string{apContext, "https://w3id.org/security/v1"},
+		ID:                actorURL,
+		Type:              "Service",
+		PreferredUsername: key,
+		Name:              name,
+		Summary:           "Comments on " + html.EscapeString(locator.URL),
+		URL:               s.Links.Post(locator),
+		Inbox:             actorURL + "/inbox",
+		Outbox:            actorURL + "/outbox",
+		Followers:         actorURL + "/followers",
+	}
+	actor.PublicKey.ID, actor.PublicKey.Owner, actor.PublicKey.PublicKeyPem = actorURL+"#main-key", actorURL, s.publicKey
+	writeJSON(w, contentType, actor)
+}
+
+// GET /actor/{key}/outbox - returns the latest published comments of the post
+func (s *Service) outboxCtrl(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+	locator, ok := s.post(w, key)
+	if !ok {
+		return
+	}
+	comments, err := s.DataService.Find(locator, "-time", store.User{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	items := []Object{}
+	for _, c := range comments {
+		if c.Deleted || c.Pending || strings.HasPrefix(c.User.ID, userPrefix) {
+			continue
+		}
+		note := s.note(key, c, s.Links.Comment(c.Locator, c.ID))
+		items = append(items, Object{ID: note.ID + "/activity", Type: "Create", Actor: s.actorURL(key),
+			Published: note.Published, To: note.To, Cc: note.Cc, Object: note})
+		if len(items) >= outboxSize {
+			break
+		}
+	}
+	total := len(items)
+	writeJSON(w, contentType, Object{Context: apContext, ID: s.actorURL(key) + "/outbox", Type: "OrderedCollection",
+		TotalItems: &total, OrderedItems: items})
+}
+
+// GET /actor/{key}/followers - returns number of followers, list of them not exposed
+func (s *Service) followersCtrl(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+	if _, ok := s.post(w, key); !ok {
+		return
+	}
+	followers, err := s.Store.Followers(key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	total := len(followers)
+	writeJSON(w, contentType, Object{Context: apContext, ID: s.actorURL(key) + "/followers", Type: "OrderedCollection",
+		TotalItems: &total})
+}
+
+// GET /actor/{key}/note/{id} - returns published comment as note
+func (s *Service) noteCtrl(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+	locator, ok := s.post(w, key)
+	if !ok {
+		return
+	}
+	c, err := s.DataService.Get(locator, chi.URLParam(r, "id"), store.User{})
+	if err != nil || c.Deleted || c.Pending || strings.HasPrefix(c.User.ID, userPrefix) {
+		http.Error(w, "Note not found", http.StatusNotFound)
+		return
+	}
+	note := s.note(key, c, s.Links.Comment(c.Locator, c.ID))
+	note.Context = apContext
+	writeJSON(w, contentType, note)
+}
+
+// post returns locator of the post by key of its actor, responds with error if post unknown
+func (s *Service) post(w http.ResponseWriter, key string) (store.Locator, bool) {
+	locator, found, err := s.Store.Post(key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return store.Locator{}, false
+	}
+	if !found {
+		http.Error(w, "Actor not found", http.StatusNotFound)
+		return store.Locator{}, false
+	}
+	return locator, true
+}
+
+// register makes key of the post actor and saves it
+func (s *Service) register(locator store.Locator) (string, error) {
+	key := postKey(locator)
+	if err := s.Store.AddPost(key, locator); err != nil {
+		return "", errors.Wrapf(err, "can't register actor of %s", locator.URL)
+	}
+	return key, nil
+}
+
+// note makes note of the comment published by the post actor, name of the author goes first.
+// Replies to comments of remote users refer to their original notes.
+func (s *Service) note(key string, c store.Comment, link string) Object {
+	res := Object{
+		ID:           s.noteURL(key, c.ID),
+		Type:         "Note",
+		AttributedTo: s.actorURL(key),
+		Content:      "<p><strong>" + c.User.Name + "</strong></p>" + c.Text, // name escaped by sanitizer of comments,
+		Published:    c.Timestamp.UTC().Format(time.RFC3339),
+		URL:          link,
+		To:           []string{publicAddress},
+		Cc:           []string{s.actorURL(key) + "/followers"},
+	}
+	if c.ParentID != "" {
+		res.InReplyTo = s.noteURL(key, c.ParentID)
+		if noteID, found, err := s.Store.CommentNote(c.Locator.SiteID, c.ParentID); err == nil && found {
+			res.InReplyTo = noteID
+		}
+	}
+	return res
+}
+
+// deliver signed activity to the inbox
+func (s *Service) deliver(ctx context.Context, key, inbox string, activity interface{}) error {
+	data, err := json.Marshal(activity)
+	if err != nil {
+		return errors.Wrap(err, "can't marshal activity")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, inbox, bytes.NewReader(data))
+	if err != nil {
+		return errors.Wrapf(err, "can't make request to %s", inbox)
+	}
+	req.Header.Set("Content-Type", contentType)
+	if err = signRequest(req, data, s.actorURL(key)+"#main-key", s.key); err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "can't deliver to %s", inbox)
+	}
+	defer resp.Body.Close() // nolint
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxBodySize))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("can't deliver to %s, status %s", inbox, resp.Status)
+	}
+	return nil
+}
+
+// deliverAsync delivers activity in background, used for responses to remote servers
+func (s *Service) deliverAsync(key, inbox string, activity interface{}) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+		defer cancel()
+		if err := s.deliver(ctx, key, inbox, activity); err != nil {
+			log.Printf("[WARN] %v", err)
+		}
+	}()
+}
+
+func (s *Service) actorURL(key string) string {
+	return s.URL + apPath + "/actor/" + key
+}
+
+func (s *Service) noteURL(key, commentID string) string {
+	return s.actorURL(key) + "/note/" + url.PathEscape(commentID)
+}
+
+// postKey makes key of the post actor, used as user name in handle of the actor
+func postKey(locator store.Locator) string {
+	h := sha1.Sum([]byte(locator.SiteID + "!!" + locator.URL)) //nolint:gosec // not used for security
+	return hex.EncodeToString(h[:])[:16]
+}
+
+func writeJSON(w http.ResponseWriter, ct string, v interface{}) {
+	w.Header().Set("Content-Type", ct)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("[WARN] can't write response, %v", err)
+	}
+}
@@ -0,0 +1,436 @@
+package activitypub
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark42/backend/app/notify"
+	"github.com/umputun/remark42/backend/app/publicnet"
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/engine"
+	"github.com/umputun/remark42/backend/app/store/service"
+)
+
+func TestService_Actor(t *testing.T) {
+	svc, ts, dataStore := prepService(t, true)
+	locator := store.Locator{SiteID: "remark", URL: "https://example.com/post1"}
+
+	resp, err := http.Get(ts.URL + "/api/v1/ap/post?site=remark&url=https://example.com/post1")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "post without comments")
+	_ = resp.Body.Close()
+
+	_, err = dataStore.Create(store.Comment{Locator: locator, Text: "text", User: store.User{ID: "user1", Name: "User"},
+		PostTitle: "Post One"})
+	require.NoError(t, err)
+	res := map[string]string{}
+	getJSON(t, ts.URL+"/api/v1/ap/post?site=remark&url=https://example.com/post1", &res)
+	key := postKey(locator)
+	assert.Equal(t, map[string]string{"actor": svc.actorURL(key), "handle": "@" + key + "@" + svc.Domain}, res)
+
+	actor := Actor{}
+	getJSON(t, svc.actorURL(key), &actor)
+	assert.Equal(t, svc.actorURL(key), actor.ID)
+	assert.Equal(t, "Service", actor.Type)
+	assert.Equal(t, "Post One", actor.Name)
+	assert.Equal(t, locator.URL, actor.URL)
+	assert.Equal(t, svc.actorURL(key)+"/inbox", actor.Inbox)
+	assert.Equal(t, svc.actorURL(key)+"#main-key", actor.PublicKey.ID)
+	pub, err := parsePublicKey(actor.PublicKey.PublicKeyPem)
+	require.NoError(t, err)
+	assert.Equal(t, svc.key.PublicKey, *pub)
+
+	wf := struct {
+		Subject string `json:"subject"`
+		Links   []struct {
+			Href string `json:"href"`
+		} `json:"links"`
+	}{}
+	getJSON(t, ts.URL+"/.well-known/webfinger?resource=acct:"+key+"@"+svc.Domain, &wf)
+	assert.Equal(t, "acct:"+key+"@"+svc.Domain, wf.Subject)
+	require.Equal(t, 1, len(wf.Links))
+	assert.Equal(t, svc.actorURL(key), wf.Links[0].Href)
+
+	for _, u := range []string{"/.well-known/webfinger?resource=acct:" + key + "@other.com",
+		"/.well-known/webfinger?resource=acct:bad@" + svc.Domain, "/api/v1/ap/actor/bad"} {
+		resp, err = http.Get(ts.URL + u)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, u)
+		_ = resp.Body.Close()
+	}
+}
+
+func TestService_Outbox(t *testing.T) {
+	svc, _, dataStore := prepService(t, true)
+	locator := store.Locator{SiteID: "remark", URL: "https://example.com/post1"}
+	id1, err := dataStore.Create(store.Comment{Locator: locator, Text: "<p>first</p>", User: store.User{ID: "user1", Name: "User <1>"}})
+	require.NoError(t, err)
+	id2, err := dataStore.Create(store.Comment{Locator: locator, ParentID: id1, Text: "<p>second</p>",
+		User: store.User{ID: "user2", Name: "User2"}, Timestamp: time.Now().Add(time.Second)})
+	require.NoError(t, err)
+	_, err = dataStore.Create(store.Comment{Locator: locator, Text: "held", User: store.User{ID: "user2"}, Pending: true})
+	require.NoError(t, err)
+	_, err = dataStore.Create(store.Comment{Locator: locator, Text: "remote", User: store.User{ID: "ap_123"}})
+	require.NoError(t, err)
+	key, err := svc.register(locator)
+	require.NoError(t, err)
+
+	outbox := Object{}
+	getJSON(t, svc.actorURL(key)+"/outbox", &outbox)
+	assert.Equal(t, "OrderedCollection", outbox.Type)
+	require.NotNil(t, outbox.TotalItems)
+	assert.Equal(t, 2, *outbox.TotalItems)
+	require.Equal(t, 2, len(outbox.OrderedItems))
+	data, err := json.Marshal(outbox.OrderedItems[0].Object)
+	require.NoError(t, err)
+	n := Object{}
+	require.NoError(t, json.Unmarshal(data, &n))
+	assert.Equal(t, svc.noteURL(key, id2), n.ID)
+	assert.Equal(t, svc.noteURL(key, id1), n.InReplyTo)
+	assert.Equal(t, "<p><strong>User2</strong></p><p>second</p>", n.Content)
+	assert.Equal(t, locator.URL+"#remark42__comment-"+id2, n.URL)
+
+	n = Object{}
+	getJSON(t, svc.noteURL(key, id1), &n)
+	assert.Equal(t, "<p><strong>User &lt;1&gt;</strong></p><p>first</p>", n.Content)
+	assert.Equal(t, "", n.InReplyTo)
+
+	resp, err := http.Get(svc.noteURL(key, "bad"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	_ = resp.Body.Close()
+}
+
+func TestService_Inbox(t *testing.T) {
+	svc, _, dataStore := prepService(t, true)
+	remote := newRemoteServer(t)
+	locator := store.Locator{SiteID: "remark", URL: "https://example.com/post1"}
+	parentID, err := dataStore.Create(store.Comment{Locator: locator, Text: "parent", User: store.User{ID: "user1", Name: "User"}})
+	require.NoError(t, err)
+	key, err := svc.register(locator)
+	require.NoError(t, err)
+	inbox := svc.actorURL(key) + "/inbox"
+
+	// follow accepted
+	follow := map[string]interface{}{"id": remote.actorID + "/follow/1", "type": "Follow", "actor": remote.actorID,
+		"object": svc.actorURL(key)}
+	client := svc.client
+	svc.client = publicnet.NewClient(time.Second)
+	assert.Equal(t, http.StatusUnauthorized, remote.post(t, inbox, follow), "key of actor on loopback not fetched")
+	svc.client = client
+	assert.Equal(t, http.StatusAccepted, remote.post(t, inbox, follow))
+	accepted := remote.received(t)
+	assert.Equal(t, "Accept", accepted.Type)
+	assert.Equal(t, svc.actorURL(key), accepted.Actor)
+	followers, err := svc.Store.Followers(key)
+	require.NoError(t, err)
+	assert.Equal(t, []Follower{{ID: remote.actorID, Inbox: remote.inbox}}, followers)
+
+	// reply to comment held for moderation
+	reply := map[string]interface{}{"id": remote.actorID + "/create/1", "type": "Create", "actor": remote.actorID,
+		"object": map[string]interface{}{"id": remote.actorID + "/notes/1", "type": "Note", "attributedTo": remote.actorID,
+			"inReplyTo": svc.noteURL(key, parentID),
+			"content": `<p><span class="h-card"><a href="` + svc.actorURL(key) + `" class="u-url mention">@<span>` + key +
+				`</span></a></span> reply <a href="https://example.org/page">example.org/pa…</a></p><p>line2</p>`}}
+	assert.Equal(t, http.StatusAccepted, remote.post(t, inbox, reply))
+	assert.Equal(t, http.StatusAccepted, remote.post(t, inbox, reply), "duplicate ignored")
+	comments, err := dataStore.Find(locator, "time", store.User{Admin: true})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(comments))
+	c := comments[1]
+	assert.Equal(t, parentID, c.ParentID)
+	assert.True(t, c.Pending)
+	assert.Equal(t, "reply https://example.org/page\n\nline2", c.Orig)
+	assert.Equal(t, "alice@"+strings.TrimPrefix(remote.URL, "http://"), c.User.Name)
+	assert.True(t, strings.HasPrefix(c.User.ID, "ap_"))
+
+	// reply to the remote note
+	reply["object"] = map[string]interface{}{"id": remote.actorID + "/notes/2", "type": "Note",
+		"attributedTo": remote.actorID, "inReplyTo": remote.actorID + "/notes/1", "content": "<p>more</p>"}
+	assert.Equal(t, http.StatusAccepted, remote.post(t, inbox, reply))
+	comments, err = dataStore.Find(locator, "time", store.User{Admin: true})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(comments))
+	assert.Equal(t, c.ID, comments[2].ParentID)
+
+	// reply to unknown note rejected
+	reply["object"] = map[string]interface{}{"id": remote.actorID + "/notes/3", "type": "Note",
+		"attributedTo": remote.actorID, "inReplyTo": "https://other.com/notes/1", "content": "<p>text</p>"}
+	assert.Equal(t, http.StatusBadRequest, remote.post(t, inbox, reply))
+
+	// note of other actor rejected
+	reply["object"] = map[string]interface{}{"id": remote.actorID + "/notes/4", "type": "Note",
+		"attributedTo": "https://other.com/users/bob", "content": "<p>text</p>"}
+	assert.Equal(t, http.StatusBadRequest, remote.post(t, inbox, reply))
+
+	// unsigned activity rejected
+	data, err := json.Marshal(follow)
+	require.NoError(t, err)
+	resp, err := http.Post(inbox, contentType, bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	_ = resp.Body.Close()
+
+	// activity of other actor rejected
+	follow["actor"] = "https://other.com/users/bob"
+	assert.Equal(t, http.StatusUnauthorized, remote.post(t, inbox, follow))
+
+	// undo follow
+	follow["actor"] = remote.actorID
+	undo := map[string]interface{}{"id": remote.actorID + "/undo/1", "type": "Undo", "actor": remote.actorID, "object": follow}
+	assert.Equal(t, http.StatusAccepted, remote.post(t, inbox, undo))
+	followers, err = svc.Store.Followers(key)
+	require.NoError(t, err)
+	assert.Empty(t, followers)
+}
+
+func TestService_Send(t *testing.T) {
+	svc, _, dataStore := prepService(t, false)
+	remote := newRemoteServer(t)
+	locator := store.Locator{SiteID: "remark", URL: "https://example.com/post1"}
+	key, err := svc.register(locator)
+	require.NoError(t, err)
+	require.NoError(t, svc.Store.AddFollower(key, Follower{ID: remote.actorID, Inbox: remote.inbox}))
+	require.NoError(t, svc.Store.AddFollower(key, Follower{ID: remote.actorID + "2", Inbox: remote.inbox}))
+
+	comment := store.Comment{ID: "c1", Locator: locator, Text: "<p>text</p>", User: store.User{ID: "user1", Name: "User"},
+		Timestamp: time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)}
+	require.NoError(t, svc.Send(context.Background(), notify.Request{Comment: comment}))
+	created := remote.received(t)
+	assert.Equal(t, "Create", created.Type)
+	assert.Equal(t, svc.actorURL(key), created.Actor)
+	n := created.Object.(map[string]interface{})
+	assert.Equal(t, svc.noteURL(key, "c1"), n["id"])
+	assert.Equal(t, "2021-01-02T03:04:05Z", n["published"])
+	assert.Equal(t, "<p><strong>User</strong></p><p>text</p>", n["content"])
+	remote.none(t, "single delivery to shared inbox")
+
+	for _, req := range []notify.Request{
+		{Comment: store.Comment{ID: "c2", Locator: locator, Pending: true}},
+		{Comment: store.Comment{ID: "c3", Locator: locator, User: store.User{ID: "ap_123"}}},
+		{Comment: comment, Moderation: notify.ModerationApproved},
+	} {
+		require.NoError(t, svc.Send(context.Background(), req))
+	}
+	remote.none(t, "held, remote and moderation not published")
+
+	// reply to comment of remote user refers to the original note
+	require.NoError(t, svc.Store.AddNote("remark", remote.actorID+"/notes/1", "c4"))
+	comment.ID, comment.ParentID = "c5", "c4"
+	require.NoError(t, svc.Send(context.Background(), notify.Request{Comment: comment}))
+	n = remote.received(t).Object.(map[string]interface{})
+	assert.Equal(t, remote.actorID+"/notes/1", n["inReplyTo"])
+
+	// note of remote user not held without moderation
+	reply := map[string]interface{}{"id": remote.actorID + "/create/1", "type": "Create", "actor": remote.actorID,
+		"object": map[string]interface{}{"id": remote.actorID + "/notes/2", "type": "Note", "attributedTo": remote.actorID,
+			"content": "<p>hello</p>"}}
+	assert.Equal(t, http.StatusAccepted, remote.post(t, svc.actorURL(key)+"/inbox", reply))
+	comments, err := dataStore.Find(locator, "time", store.User{})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(comments), "not held without moderation")
+	assert.False(t, comments[0].Pending)
+
+	remote.lock.Lock()
+	remote.fail = true
+	remote.lock.Unlock()
+	comment.ID = "c6"
+	assert.Error(t, svc.Send(context.Background(), notify.Request{Comment: comment}))
+}
+
+func TestNewService(t *testing.T) {
+	st := prepStore(t)
+	svc, err := NewService(st, nil, Params{URL: "https://remark42.example.com/"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://remark42.example.com", svc.URL)
+	assert.Equal(t, "remark42.example.com", svc.Domain)
+
+	svc2, err := NewService(st, nil, Params{URL: "https://remark42.example.com", Domain: "example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, svc.key, svc2.key, "key loaded from store")
+	assert.Equal(t, "example.com", svc2.Domain)
+
+	_, err = NewService(st, nil, Params{URL: "remark42"})
+	assert.EqualError(t, err, `invalid url "remark42"`)
+}
+
+func TestNoteText(t *testing.T) {
+	tbl := []struct {
+		content, text string
+	}{
+		{"plain text", "plain text"},
+		{"<p>line1<br>line2</p><p></p><p>para2</p>", "line1\nline2\n\npara2"},
+		{`<p><span class="h-card"><a href="https://remark42.example.com/actor" class="u-url mention">@<span>key</span></a></span> hi</p>`, "hi"},
+		{`<p><a href="https://example.com/post" class="mention">@key</a> hi <span class="h-card"><a href="https://mastodon.social/@bob" class="u-url mention">@<span>bob</span></a></span></p>`, "hi @bob"},
+		{`<p><a href="https://mastodon.social/tags/go" class="mention hashtag">#<span>go</span></a> <a href="https://go.dev/doc/">go.dev/doc</a></p>`, "#go https://go.dev/doc/"},
+		{`<p>a &lt;b&gt; <a href="https://example.org/?a=1&amp;b=2">link</a></p>`, "a <b> https://example.org/?a=1&b=2"},
+	}
+	for i, tt := range tbl {
+		assert.Equal(t, tt.text, noteText(tt.content, "https://remark42.example.com/actor", "https://example.com/post"), "case #%d", i)
+	}
+}
+
+// remoteServer imitates server of remote actor, keeps activities delivered to the actor's inbox
+type remoteServer struct {
+	*httptest.Server
+	actorID string
+	inbox   string
+	key     *rsa.PrivateKey
+	fail    bool
+
+	lock       sync.Mutex
+	activities chan Object
+}
+
+func newRemoteServer(t *testing.T) *remoteServer {
+	key, _, err := makeKey()
+	require.NoError(t, err)
+	pub, err := publicKeyPEM(&key.PublicKey)
+	require.NoError(t, err)
+	res := &remoteServer{key: key, activities: make(chan Object, 10)}
+	res.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/users/alice":
+			actor := Actor{ID: res.actorID, Type: "Person", PreferredUsername: "alice", Inbox: res.inbox}
+			actor.PublicKey.ID, actor.PublicKey.Owner, actor.PublicKey.PublicKeyPem = res.actorID+"#main-key", res.actorID, pub
+			_ = json.NewEncoder(w).Encode(actor)
+		case r.Method == http.MethodPost && r.URL.Path == "/inbox":
+			res.lock.Lock()
+			fail := res.fail
+			res.lock.Unlock()
+			if fail {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			body, err := ioutil.ReadAll(r.Body)
+			assert.NoError(t, err)
+			sig, err := parseSignature(r)
+			assert.NoError(t, err)
+			assert.NoError(t, sig.verify(r, body, res.publicKeyOf(t, sig.keyID)))
+			act := Object{}
+			assert.NoError(t, json.Unmarshal(body, &act))
+			res.activities <- act
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	res.actorID, res.inbox = res.URL+"/users/alice", res.URL+"/inbox"
+	t.Cleanup(res.Close)
+	return res
+}
+
+// publicKeyOf fetches key of remark42 actor
+func (rs *remoteServer) publicKeyOf(t *testing.T, keyID string) *rsa.PublicKey {
+	actor := Actor{}
+	getJSON(t, strings.TrimSuffix(keyID, "#main-key"), &actor)
+	pub, err := parsePublicKey(actor.PublicKey.PublicKeyPem)
+	require.NoError(t, err)
+	return pub
+}
+
+// post signed activity of the actor to the inbox, returns status of response
+func (rs *remoteServer) post(t *testing.T, inbox string, activity interface{}) int {
+	data, err := json.Marshal(activity)
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, inbox, bytes.NewReader(data))
+	require.NoError(t, err)
+	require.NoError(t, signRequest(req, data, rs.actorID+"#main-key", rs.key))
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	return resp.StatusCode
+}
+
+func (rs *remoteServer) received(t *testing.T) Object {
+	select {
+	case act := <-rs.activities:
+		return act
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "no activity delivered")
+	}
+	return Object{}
+}
+
+func (rs *remoteServer) none(t *testing.T, msg string) {
+	select {
+	case act := <-rs.activities:
+		assert.Fail(t, "unexpected activity", "%s: %+v", msg, act)
+	default:
+	}
+}
+
+func getJSON(t *testing.T, u string, v interface{}) {
+	resp, err := http.Get(u)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode, u)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+}
+
+func prepService(t *testing.T, moderate bool) (*Service, *httptest.Server, *service.DataStore) {
+	dbFile := os.TempDir() + "/remark-activitypub-test.db"
+	_ = os.Remove(dbFile)
+	b, err := engine.NewBoltDB(bolt.Options{}, engine.BoltSite{FileName: dbFile, SiteID: "remark"})
+	require.NoError(t, err)
+	dataStore := &service.DataStore{Engine: b, AdminStore: admin.NewStaticStore("12345", nil, []string{}, ""),
+		MaxCommentSize: 1000, MaxVotes: -1}
+
+	router := chi.NewRouter()
+	ts := httptest.NewServer(router)
+	st := NewEngineStore(b, func() []string { return []string{"remark"} })
+	svc, err := NewService(st, dataStore, Params{URL: ts.URL, Moderate: moderate})
+	require.NoError(t, err)
+	svc.Creator = dataCreator{dataStore}
+	svc.client = &http.Client{Timeout: time.Second} // test servers are on loopback
+	router.Mount("/api/v1/ap", svc)
+	router.Get("/.well-known/webfinger", svc.WebFinger)
+
+	t.Cleanup(func() {
+		ts.Close()
+		svc.Close()
+		assert.NoError(t, dataStore.Close())
+		_ = os.Remove(dbFile)
+	})
+	return svc, ts, dataStore
+}
+
+func prepStore(t *testing.T) *EngineStore {
+	eng, err := engine.NewMemory("", "remark", "other")
+	require.NoError(t, err)
+	return NewEngineStore(eng, func() []string { return []string{"remark", "other"} })
+}
+
+// dataCreator saves comments to data store with basic checks, stands for shared creation path of rest api
+type dataCreator struct {
+	dataStore *service.DataStore
+}
+
+func (c dataCreator) CreateComment(_ context.Context, comment store.Comment) (store.Comment, error) {
+	comment.Orig = comment.Text
+	if err := c.dataStore.ValidateComment(&comment); err != nil {
+		return store.Comment{}, err
+	}
+	comment = store.NewCommentFormatter().Format(comment)
+	id, err := c.dataStore.Create(comment)
+	if err != nil {
+		return store.Comment{}, err
+	}
+	return c.dataStore.Get(comment.Locator, id, comment.User)
+}
@@ -0,0 +1,291 @@
+package activitypub
+
+import (
+	"context"
+	"crypto/sha1" //nolint:gosec // used for user id, the same way as other providers do
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-pkgz/auth/token"
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// activity received from remote server, object can be embedded or referred by id
+type activity struct {
+	ID     string          `json:"id"`
+	Type   string          `json:"type"`
+	Actor  string          `json:"actor"`
+	Object json.RawMessage `json:"object"`
+}
+
+// note received from remote server
+type note struct {
+	ID           string `json:"id"`
+	Type         string `json:"type"`
+	AttributedTo string `json:"attributedTo"`
+	InReplyTo    string `json:"inReplyTo"`
+	Content      string `json:"content"`
+}
+
+// POST /actor/{key}/inbox - accepts signed activities of remote actors. Follow and Undo of follow change followers
+// of the post, Create of note makes comment. Other activities ignored.
+func (s *Service) inboxCtrl(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+	locator, ok := s.post(w, key)
+	if !ok {
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	if err != nil {
+		http.Error(w, "Can't read body", http.StatusBadRequest)
+		return
+	}
+	act := activity{}
+	if err = json.Unmarshal(body, &act); err != nil {
+		http.Error(w, "Bad activity", http.StatusBadRequest)
+		return
+	}
+	sender, err := s.verify(r, body)
+	if err != nil {
+		log.Printf("[WARN] rejected %s activity of %s, %v", act.Type, act.Actor, err)
+		http.Error(w, "Bad signature", http.StatusUnauthorized)
+		return
+	}
+	if act.Actor != sender.ID {
+		http.Error(w, "Actor doesn't match signature", http.StatusUnauthorized)
+		return
+	}
+
+	switch act.Type {
+	case "Follow":
+		err = s.follow(key, sender, act, body)
+	case "Undo":
+		err = s.undo(key, sender, act)
+	case "Create":
+		err = s.reply(r.Context(), key, locator, sender, act)
+	default:
+		log.Printf("[DEBUG] ignored %s activity of %s", act.Type, act.Actor)
+	}
+	if err != nil {
+		log.Printf("[WARN] can't process %s activity of %s, %v", act.Type, act.Actor, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// follow adds sender to followers of the post and accepts the follow request
+func (s *Service) follow(key string, sender Actor, act activity, body []byte) error {
+	if objectID(act.Object) != s.actorURL(key) {
+		return errors.New("follow of other actor")
+	}
+	if sender.Inbox == "" {
+		return errors.New("no inbox of follower")
+	}
+	if err := s.Store.AddFollower(key, Follower{ID: sender.ID, Inbox: sender.Inbox}); err != nil {
+		return errors.Wrapf(err, "can't add follower %s", sender.ID)
+	}
+	log.Printf("[INFO] %s follows %s", sender.ID, s.actorURL(key))
+	s.deliverAsync(key, sender.Inbox, Object{Context: apContext, ID: s.actorURL(key) + "#accept-" + noteHash(act.ID),
+		Type: "Accept", Actor: s.actorURL(key), Object: json.RawMessage(body)})
+	return nil
+}
+
+// undo removes sender from followers of the post on undo of follow
+func (s *Service) undo(key string, sender Actor, act activity) error {
+	inner := activity{}
+	if err := json.Unmarshal(act.Object, &inner); err != nil || inner.Type != "Follow" {
+		return nil // undo of other activities, like likes, ignored
+	}
+	if err := s.Store.RemoveFollower(key, sender.ID); err != nil {
+		return errors.Wrapf(err, "can't remove follower %s", sender.ID)
+	}
+	log.Printf("[INFO] %s unfollows %s", sender.ID, s.actorURL(key))
+	return nil
+}
+
+// reply creates comment from the note of remote actor. Note should reply to the published comment or to other note
+// of the post, notes without reply mention the actor and become top-level comments.
+func (s *Service) reply(ctx context.Context, key string, locator store.Locator, sender Actor, act activity) error {
+	n := note{}
+	if err := json.Unmarshal(act.Object, &n); err != nil || n.Type != "Note" {
+		return nil // only notes become comments
+	}
+	if n.ID == "" || n.AttributedTo != sender.ID {
+		return errors.New("note not attributed to sender")
+	}
+	if _, found, err := s.Store.NoteComment(locator.SiteID, n.ID); err != nil || found {
+		return err // already received
+	}
+
+	parentID, err := s.parent(key, locator, n.InReplyTo)
+	if err != nil {
+		return err
+	}
+	text := noteText(n.Content, s.actorURL(key), s.Links.Post(locator))
+	if text == "" {
+		return errors.New("empty note")
+	}
+	comment := store.Comment{Locator: locator, ParentID: parentID, Text: text, User: remoteUser(sender), Pending: s.Moderate}
+	res, err := s.Creator.CreateComment(ctx, comment)
+	if err != nil {
+		return err
+	}
+	if err = s.Store.AddNote(locator.SiteID, n.ID, res.ID); err != nil {
+		log.Printf("[WARN] can't link note %s to comment %s, %v", n.ID, res.ID, err)
+	}
+	log.Printf("[INFO] comment %s created from note %s on %s, pending %v", res.ID, n.ID, locator.URL, res.Pending)
+	return nil
+}
+
+// parent returns id of the comment the note replies to, empty for note without reply
+func (s *Service) parent(key string, locator store.Locator, inReplyTo string) (string, error) {
+	if inReplyTo == "" {
+		return "", nil
+	}
+	if prefix := s.noteURL(key, ""); strings.HasPrefix(inReplyTo, prefix) {
+		id, err := url.PathUnescape(strings.TrimPrefix(inReplyTo, prefix))
+		if err != nil {
+			return "", errors.Wrapf(err, "bad note %s", inReplyTo)
+		}
+		if _, err = s.DataService.Get(locator, id, store.User{}); err != nil {
+			return "", errors.Wrapf(err, "can't get comment %s", id)
+		}
+		return id, nil
+	}
+	id, found, err := s.Store.NoteComment(locator.SiteID, inReplyTo)
+	if err != nil {
+		return "", errors.Wrapf(err, "can't get comment of note %s", inReplyTo)
+	}
+	if !found {
+		return "", errors.Errorf("reply to unknown note %s", inReplyTo)
+	}
+	return id, nil
+}
+
+// verify checks http signature of the request with the key of remote actor, returns the actor
+func (s *Service) verify(r *http.Request, body []byte) (Actor, error) {
+	sig, err := parseSignature(r)
+	if err != nil {
+		return Actor{}, err
+	}
+	actor, err := s.fetchActor(r.Context(), strings.SplitN(sig.keyID, "#", 2)[0])
+	if err != nil {
+		return Actor{}, err
+	}
+	if actor.PublicKey.ID != sig.keyID {
+		return Actor{}, errors.Errorf("key %s not owned by %s", sig.keyID, actor.ID)
+	}
+	pub, err := parsePublicKey(actor.PublicKey.PublicKeyPem)
+	if err != nil {
+		return Actor{}, err
+	}
+	if err = sig.verify(r, body, pub); err != nil {
+		return Actor{}, err
+	}
+	return actor, nil
+}
+
+// fetchActor loads document of remote actor
+func (s *Service) fetchActor(ctx context.Context, actorID string) (Actor, error) {
+	u, err := url.Parse(actorID)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return Actor{}, errors.Errorf("invalid actor %q", actorID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, actorID, nil)
+	if err != nil {
+		return Actor{}, errors.Wrapf(err, "can't make request to %s", actorID)
+	}
+	req.Header.Set("Accept", contentType)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return Actor{}, errors.Wrapf(err, "can't fetch actor %s", actorID)
+	}
+	defer resp.Body.Close() // nolint
+	if resp.StatusCode != http.StatusOK {
+		return Actor{}, errors.Errorf("can't fetch actor %s, status %s", actorID, resp.Status)
+	}
+	actor := Actor{}
+	if err = json.NewDecoder(io.LimitReader(resp.Body, maxBodySize)).Decode(&actor); err != nil {
+		return Actor{}, errors.Wrapf(err, "can't decode actor %s", actorID)
+	}
+	if actor.ID != actorID {
+		return Actor{}, errors.Errorf("actor %s fetched from %s", actor.ID, actorID)
+	}
+	return actor, nil
+}
+
+// remoteUser makes user of remote actor, named by the handle like user@example.com
+func remoteUser(actor Actor) store.User {
+	name := actor.ID
+	if u, err := url.Parse(actor.ID); err == nil && actor.PreferredUsername != "" {
+		name = actor.PreferredUsername + "@" + u.Host
+	}
+	return store.User{ID: userPrefix + token.HashID(sha1.New(), actor.ID), Name: name} //nolint:gosec // not used for security
+}
+
+// noteText converts html content of the note to comment text. Mentions of the post actor removed,
+// other links replaced by their urls as mastodon shortens text of links.
+func noteText(content, actorURL, postURL string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		return ""
+	}
+	doc.Find("a").Each(func(_ int, a *goquery.Selection) {
+		href, _ := a.Attr("href")
+		switch {
+		case a.HasClass("mention") && (href == actorURL || href == postURL):
+			if a.Parent().HasClass("h-card") {
+				a.Parent().Remove()
+				return
+			}
+			a.Remove()
+		case a.HasClass("mention") || a.HasClass("hashtag") || href == "":
+			a.ReplaceWithHtml(escapeText(a.Text()))
+		default:
+			a.ReplaceWithHtml(escapeText(href))
+		}
+	})
+	doc.Find("br").ReplaceWithHtml("\n")
+	paragraphs := []string{}
+	doc.Find("p").Each(func(_ int, p *goquery.Selection) {
+		if text := strings.TrimSpace(p.Text()); text != "" {
+			paragraphs = append(paragraphs, text)
+		}
+	})
+	if len(paragraphs) == 0 {
+		return strings.TrimSpace(doc.Text())
+	}
+	return strings.Join(paragraphs, "\n\n")
+}
+
+func escapeText(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+// objectID returns id of object referred by id or embedded
+func objectID(obj json.RawMessage) string {
+	var id string
+	if err := json.Unmarshal(obj, &id); err == nil {
+		return id
+	}
+	embedded := struct {
+		ID string `json:"id"`
+	}{}
+	_ = json.Unmarshal(obj, &embedded)
+	return embedded.ID
+}
+
+// noteHash makes short hash of the id, used for ids of local activities made in response
+func noteHash(id string) string {
+	return token.HashID(sha1.New(), id)[:16] //nolint:gosec // not used for security
+}
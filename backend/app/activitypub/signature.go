@@ -0,0 +1,192 @@
+package activitypub
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// requests signed with draft-cavage http signatures, the way mastodon and other servers do
+
+const maxClockSkew = 12 * time.Hour // max difference of date of signed request and local time
+
+// signature parsed from Signature header
+type signature struct {
+	keyID     string
+	algorithm string
+	headers   []string
+	sig       []byte
+}
+
+// signRequest signs request with private key, digest of body added for requests with body
+func signRequest(r *http.Request, body []byte, keyID string, key *rsa.PrivateKey) error {
+	r.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	headers := []string{"(request-target)", "host", "date"}
+	if body != nil {
+		r.Header.Set("Digest", digest(body))
+		headers = append(headers, "digest")
+	}
+	hash := sha256.Sum256([]byte(signingString(r, headers)))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		return errors.Wrap(err, "can't sign request")
+	}
+	r.Header.Set("Signature", fmt.Sprintf(`keyId=%q,algorithm="rsa-sha256",headers=%q,signature=%q`,
+		keyID, strings.Join(headers, " "), base64.StdEncoding.EncodeToString(sig)))
+	return nil
+}
+
+// parseSignature parses Signature header of the request
+func parseSignature(r *http.Request) (signature, error) {
+	header := r.Header.Get("Signature")
+	if header == "" {
+		return signature{}, errors.New("no signature")
+	}
+	res := signature{headers: []string{"date"}} // default by spec
+	for _, param := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		val := strings.Trim(kv[1], `"`)
+		switch kv[0] {
+		case "keyId":
+			res.keyID = val
+		case "algorithm":
+			res.algorithm = val
+		case "headers":
+			res.headers = strings.Fields(strings.ToLower(val))
+		case "signature":
+			sig, err := base64.StdEncoding.DecodeString(val)
+			if err != nil {
+				return signature{}, errors.Wrap(err, "can't decode signature")
+			}
+			res.sig = sig
+		}
+	}
+	if res.keyID == "" || len(res.sig) == 0 {
+		return signature{}, errors.New("incomplete signature")
+	}
+	return res, nil
+}
+
+// verify checks signature of the request with public key. Signature should cover request target, host and date,
+// and digest of body for requests with body. Date of request should be close to local time.
+func (s signature) verify(r *http.Request, body []byte, key *rsa.PublicKey) error {
+	if s.algorithm != "" && s.algorithm != "rsa-sha256" && s.algorithm != "hs2019" {
+		return errors.Errorf("unsupported signature algorithm %q", s.algorithm)
+	}
+	required := []string{"(request-target)", "host", "date"}
+	if len(body) > 0 {
+		required = append(required, "digest")
+	}
+	for _, h := range required {
+		if !contains(s.headers, h) {
+			return errors.Errorf("%s not signed", h)
+		}
+	}
+	if len(body) > 0 && r.Header.Get("Digest") != digest(body) {
+		return errors.New("digest mismatch")
+	}
+	date, err := http.ParseTime(r.Header.Get("Date"))
+	if err != nil {
+		return errors.Wrap(err, "bad date")
+	}
+	if skew := time.Since(date); skew > maxClockSkew || skew < -maxClockSkew {
+		return errors.Errorf("date %s is too far from now", r.Header.Get("Date"))
+	}
+	hash := sha256.Sum256([]byte(signingString(r, s.headers)))
+	return errors.Wrap(rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], s.sig), "bad signature")
+}
+
+// signingString makes string signed by the headers listed, pseudo-header (request-target) is method and path
+func signingString(r *http.Request, headers []string) string {
+	lines := make([]string, 0, len(headers))
+	for _, h := range headers {
+		switch h {
+		case "(request-target)":
+			lines = append(lines, h+": "+strings.ToLower(r.Method)+" "+r.URL.RequestURI())
+		case "host":
+			host := r.Host
+			if host == "" {
+				host = r.URL.Host
+			}
+			lines = append(lines, h+": "+host)
+		default:
+			lines = append(lines, h+": "+r.Header.Get(h))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func digest(body []byte) string {
+	hash := sha256.Sum256(body)
+	return "SHA-256=" + base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// makeKey generates private key of actors, returned with encoded pem
+func makeKey() (*rsa.PrivateKey, []byte, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "can't generate key")
+	}
+	return key, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), nil
+}
+
+// parsePrivateKey decodes private key of actors from pem
+func parsePrivateKey(pemKey []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(pemKey)
+	if block == nil {
+		return nil, errors.New("no pem block of private key")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	return key, errors.Wrap(err, "can't parse private key")
+}
+
+// publicKeyPEM encodes public key published by actors
+func publicKeyPEM(key *rsa.PublicKey) (string, error) {
+	data, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", errors.Wrap(err, "can't marshal public key")
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: data})), nil
+}
+
+// parsePublicKey decodes public key of remote actor, pkix or pkcs1 encoded
+func parsePublicKey(pemKey string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(pemKey))
+	if block == nil {
+		return nil, errors.New("no pem block of public key")
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't parse public key")
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not rsa public key")
+	}
+	return rsaKey, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package activitypub
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignature(t *testing.T) {
+	key, pemKey, err := makeKey()
+	require.NoError(t, err)
+	parsed, err := parsePrivateKey(pemKey)
+	require.NoError(t, err)
+	assert.Equal(t, key, parsed)
+	other, _, err := makeKey()
+	require.NoError(t, err)
+
+	body := []byte(`{"type":"Follow"}`)
+	req, err := http.NewRequest(http.MethodPost, "https://example.com/inbox?a=1", bytes.NewReader(body))
+	require.NoError(t, err)
+	require.NoError(t, signRequest(req, body, "https://example.com/actor#main-key", key))
+	assert.Equal(t, "SHA-256=GYwYnH3BiO6aICFt0ThC5bUIJ4byvqdpWtR8m5fNkww=", req.Header.Get("Digest"))
+
+	sig, err := parseSignature(req)
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/actor#main-key", sig.keyID)
+	assert.Equal(t, []string{"(request-target)", "host", "date", "digest"}, sig.headers)
+	assert.NoError(t, sig.verify(req, body, &key.PublicKey))
+	assert.EqualError(t, sig.verify(req, body, &other.PublicKey), "bad signature: crypto/rsa: verification error")
+	assert.EqualError(t, sig.verify(req, []byte(`{"type":"Undo"}`), &key.PublicKey), "digest mismatch")
+
+	req.Header.Set("Date", time.Now().Add(-13*time.Hour).UTC().Format(http.TimeFormat))
+	assert.Contains(t, sig.verify(req, body, &key.PublicKey).Error(), "is too far from now")
+
+	sig.headers = []string{"date", "digest"}
+	assert.EqualError(t, sig.verify(req, body, &key.PublicKey), "(request-target) not signed")
+
+	req.Header.Del("Signature")
+	_, err = parseSignature(req)
+	assert.EqualError(t, err, "no signature")
+	req.Header.Set("Signature", `keyId="https://example.com/actor#main-key",headers="date"`)
+	_, err = parseSignature(req)
+	assert.EqualError(t, err, "incomplete signature")
+}
+
+func TestParsePublicKey(t *testing.T) {
+	key, _, err := makeKey()
+	require.NoError(t, err)
+	pemKey, err := publicKeyPEM(&key.PublicKey)
+	require.NoError(t, err)
+	pub, err := parsePublicKey(pemKey)
+	require.NoError(t, err)
+	assert.Equal(t, key.PublicKey, *pub)
+
+	_, err = parsePublicKey("bad")
+	assert.EqualError(t, err, "no pem block of public key")
+}
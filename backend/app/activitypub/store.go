@@ -0,0 +1,139 @@
+package activitypub
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+const keyName = "actors" // record of private key of actors
+
+// EngineStore implements Store with records of store engine, posts, followers and notes kept along with comments
+// of their site. Key of post actor doesn't include site, so post looked up by key in all sites. Private key of actors
+// shared by all sites and kept in each of them.
+type EngineStore struct {
+	posts     engine.Records // keyed by post key
+	followers engine.Records // keyed by postKey!!actorID
+	notes     engine.Records // keyed by noteID
+	comments  engine.Records // keyed by commentID, reverse index of notes
+	keys      engine.Records // single record of private key
+	sites     func() []string
+}
+
+// NewEngineStore makes store of federated posts kept by eng for sites
+func NewEngineStore(eng engine.Interface, sites func() []string) *EngineStore {
+	return &EngineStore{
+		posts:     engine.Records{Engine: eng, Kind: engine.FederatedPosts},
+		followers: engine.Records{Engine: eng, Kind: engine.FederatedFollowers},
+		notes:     engine.Records{Engine: eng, Kind: engine.FederatedNotes},
+		comments:  engine.Records{Engine: eng, Kind: engine.FederatedComments},
+		keys:      engine.Records{Engine: eng, Kind: engine.FederatedKeys},
+		sites:     sites,
+	}
+}
+
+// AddPost links key of the post actor to the post, does nothing if already linked
+func (e *EngineStore) AddPost(key string, locator store.Locator) error {
+	_, err := e.posts.Add(locator.SiteID, key, locator)
+	return errors.Wrapf(err, "can't put post %s", key)
+}
+
+// Post returns locator of the post by key of its actor
+func (e *EngineStore) Post(key string) (locator store.Locator, found bool, err error) {
+	for _, siteID := range e.sites() {
+		if found, err = e.posts.Get(siteID, key, &locator); err != nil || found {
+			return locator, found, err
+		}
+	}
+	return store.Locator{}, false, nil
+}
+
+// AddFollower of the post, replaces inbox of known follower
+func (e *EngineStore) AddFollower(key string, follower Follower) error {
+	locator, found, err := e.Post(key)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errors.Errorf("unknown post %s", key)
+	}
+	return errors.Wrapf(e.followers.Set(locator.SiteID, key+"!!"+follower.ID, follower),
+		"can't put follower %s of %s", follower.ID, key)
+}
+
+// RemoveFollower of the post, does nothing for unknown follower
+func (e *EngineStore) RemoveFollower(key, actorID string) error {
+	locator, found, err := e.Post(key)
+	if err != nil || !found {
+		return err
+	}
+	_, err = e.followers.Delete(locator.SiteID, key+"!!"+actorID)
+	return errors.Wrapf(err, "can't delete follower %s of %s", actorID, key)
+}
+
+// Followers of the post
+func (e *EngineStore) Followers(key string) (res []Follower, err error) {
+	locator, found, err := e.Post(key)
+	if err != nil || !found {
+		return nil, err
+	}
+	err = e.followers.List(locator.SiteID, key+"!!", func(_ string, unmarshal func(v interface{}) error) error {
+		f := Follower{}
+		if err := unmarshal(&f); err != nil {
+			return err
+		}
+		res = append(res, f)
+		return nil
+	})
+	return res, err
+}
+
+// AddNote links remote note to the comment, ErrDuplicateNote if the note linked already
+func (e *EngineStore) AddNote(siteID, noteID, commentID string) error {
+	added, err := e.notes.Add(siteID, noteID, commentID)
+	if err != nil {
+		return errors.Wrapf(err, "can't put note %s", noteID)
+	}
+	if !added {
+		return ErrDuplicateNote
+	}
+	return errors.Wrapf(e.comments.Set(siteID, commentID, noteID), "can't put note of %s", commentID)
+}
+
+// NoteComment returns id of the comment made from remote note
+func (e *EngineStore) NoteComment(siteID, noteID string) (commentID string, found bool, err error) {
+	found, err = e.notes.Get(siteID, noteID, &commentID)
+	return commentID, found, err
+}
+
+// CommentNote returns id of remote note the comment made from
+func (e *EngineStore) CommentNote(siteID, commentID string) (noteID string, found bool, err error) {
+	found, err = e.comments.Get(siteID, commentID, &noteID)
+	return noteID, found, err
+}
+
+// Key returns private key of actors in pem, nil if not saved in any site
+func (e *EngineStore) Key() (pemKey []byte, err error) {
+	for _, siteID := range e.sites() {
+		var key string
+		found, err := e.keys.Get(siteID, keyName, &key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't get key of %s", siteID)
+		}
+		if found {
+			return []byte(key), nil
+		}
+	}
+	return nil, nil
+}
+
+// SetKey saves private key of actors in pem to each site
+func (e *EngineStore) SetKey(pemKey []byte) error {
+	for _, siteID := range e.sites() {
+		if err := e.keys.Set(siteID, keyName, string(pemKey)); err != nil {
+			return errors.Wrapf(err, "can't put key to %s", siteID)
+		}
+	}
+	return nil
+}
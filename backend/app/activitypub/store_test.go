@@ -0,0 +1,59 @@
+package activitypub
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestEngineStore(t *testing.T) {
+	st := prepStore(t)
+
+	locator := store.Locator{SiteID: "remark", URL: "https://example.com/post1"}
+	require.NoError(t, st.AddPost("key1", locator))
+	require.NoError(t, st.AddPost("key1", store.Locator{SiteID: "remark", URL: "https://example.com/post2"}), "kept")
+	res, found, err := st.Post("key1")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, locator, res)
+	_, found, err = st.Post("key2")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	require.NoError(t, st.AddFollower("key1", Follower{ID: "actor1", Inbox: "inbox1"}))
+	require.NoError(t, st.AddFollower("key1", Follower{ID: "actor2", Inbox: "inbox2"}))
+	require.NoError(t, st.AddFollower("key1", Follower{ID: "actor1", Inbox: "inbox3"}))
+	followers, err := st.Followers("key1")
+	require.NoError(t, err)
+	assert.Equal(t, []Follower{{ID: "actor1", Inbox: "inbox3"}, {ID: "actor2", Inbox: "inbox2"}}, followers)
+	require.NoError(t, st.RemoveFollower("key1", "actor1"))
+	require.NoError(t, st.RemoveFollower("key2", "actor1"))
+	followers, err = st.Followers("key1")
+	require.NoError(t, err)
+	assert.Equal(t, []Follower{{ID: "actor2", Inbox: "inbox2"}}, followers)
+
+	require.NoError(t, st.AddNote("remark", "note1", "c1"))
+	assert.Equal(t, ErrDuplicateNote, st.AddNote("remark", "note1", "c2"))
+	commentID, found, err := st.NoteComment("remark", "note1")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "c1", commentID)
+	noteID, found, err := st.CommentNote("remark", "c1")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "note1", noteID)
+	_, found, err = st.NoteComment("other", "note1")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	key, err := st.Key()
+	require.NoError(t, err)
+	assert.Nil(t, key)
+	require.NoError(t, st.SetKey([]byte("pem")))
+	key, err = st.Key()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("pem"), key)
+}
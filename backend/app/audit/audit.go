@@ -0,0 +1,118 @@
+// Package audit keeps append-only log of moderation actions, like deletion of comments or blocking of users,
+// with the moderator made the action. Entries never updated or removed, so moderators sharing the site
+// are accountable for their actions.
+package audit
+
+import (
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// Action defines type of moderation action
+type Action string
+
+// enum of all recorded actions
+const (
+	ActionDelete      = Action("delete")       // comment deleted
+	ActionDeleteUser  = Action("delete_user")  // all comments of the user deleted
+	ActionBlock       = Action("block")        // user blocked
+	ActionUnblock     = Action("unblock")      // user unblocked
+	ActionVerify      = Action("verify")       // user verified
+	ActionUnverify    = Action("unverify")     // verification of user removed
+	ActionShadowBan   = Action("shadowban")    // user shadow-banned
+	ActionUnshadowBan = Action("unshadowban")  // shadow-ban of user lifted
+	ActionApprove     = Action("approve")      // pending comment approved
+	ActionSpam        = Action("spam")         // comment deleted as spam
+	ActionPin         = Action("pin")          // comment pinned
+	ActionUnpin       = Action("unpin")        // comment unpinned
+	ActionEdit        = Action("edit")         // comment edited by admin
+	ActionDismiss     = Action("dismiss")      // reports of comment dismissed
+	ActionUndelete    = Action("undelete")     // deleted comment restored from trash
+	ActionBlockHash   = Action("blockhash")    // comments with ip or user-agent hash blocked
+	ActionUnblockHash = Action("unblockhash")  // block of ip or user-agent hash removed
+	ActionRotateJWT   = Action("rotate_jwt")   // key signing JWT rotated
+	ActionLogout      = Action("logout")       // all sessions of user revoked
+	ActionTrust       = Action("trust")        // trust level of user set, the level kept as reason
+	ActionLink        = Action("link")         // identity linked to user, canonical user kept as reason
+	ActionUnlink      = Action("unlink")       // link of identity removed
+	ActionCreateToken = Action("create_token") // api token created, scope kept as reason
+	ActionRevokeToken = Action("revoke_token") // api token revoked
+)
+
+// Entry is a single moderation action
+type Entry struct {
+	ID        string    `json:"id"`
+	SiteID    string    `json:"site"`
+	Actor     string    `json:"actor"`                // id of moderator made the action
+	ActorName string    `json:"actor_name,omitempty"` // name of moderator at the time of the action
+	Action    Action    `json:"action"`
+	Target    string    `json:"target"`        // id of comment or user
+	URL       string    `json:"url,omitempty"` // post of the comment
+	Reason    string    `json:"reason,omitempty"`
+	Timestamp time.Time `json:"time"`
+}
+
+// Filter of listed entries, empty fields match all entries
+type Filter struct {
+	Actor  string
+	Action Action
+	Target string
+	From   time.Time // inclusive
+	To     time.Time // exclusive
+	Limit  int       // max number of entries, 0 for unlimited
+}
+
+// Store defines interface of append-only log
+type Store interface {
+	Add(entry Entry) error
+	List(siteID string, filter Filter, fn func(Entry) error) error // entries of the site, the most recent first
+}
+
+// Service records and lists moderation actions
+type Service struct {
+	store Store
+	now   func() time.Time
+}
+
+// NewService makes audit service with the store
+func NewService(st Store) *Service {
+	return &Service{store: st, now: time.Now}
+}
+
+// Record adds the entry, with generated id and current time. Errors of the store only logged,
+// as moderation action already made. Safe to call on nil Service.
+func (s *Service) Record(entry Entry) {
+	if s == nil {
+		return
+	}
+	entry.ID = uuid.New().String()
+	entry.Timestamp = s.now()
+	log.Printf("[INFO] audit: %s %s of %s on %s by %s", entry.Action, entry.Target, entry.URL, entry.SiteID, entry.Actor)
+	if err := s.store.Add(entry); err != nil {
+		log.Printf("[WARN] can't record audit entry %+v, %v", entry, err)
+	}
+}
+
+// List returns entries of the site matched by filter, the most recent first
+func (s *Service) List(siteID string, filter Filter) ([]Entry, error) {
+	res := []Entry{}
+	err := s.store.List(siteID, filter, func(e Entry) error {
+		res = append(res, e)
+		return nil
+	})
+	return res, errors.Wrapf(err, "can't list audit entries of %s", siteID)
+}
+
+// Export passes entries of the site matched by filter to fn, the most recent first
+func (s *Service) Export(siteID string, filter Filter, fn func(Entry) error) error {
+	return errors.Wrapf(s.store.List(siteID, filter, fn), "can't export audit entries of %s", siteID)
+}
+
+// match checks if entry matched by filter, time range not checked
+func (f Filter) match(e Entry) bool {
+	return (f.Actor == "" || f.Actor == e.Actor) && (f.Action == "" || f.Action == e.Action) &&
+		(f.Target == "" || f.Target == e.Target)
+}
@@ -0,0 +1,87 @@
+package audit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+func TestService_RecordList(t *testing.T) {
+	var s *Service
+	s.Record(Entry{SiteID: "site", Action: ActionDelete}) // nil service ignored
+
+	s = prepService(t)
+	ts := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { ts = ts.Add(time.Minute); return ts }
+
+	s.Record(Entry{SiteID: "site", Actor: "admin1", Action: ActionDelete, Target: "c1", URL: "https://example.com/1", Reason: "spam"})
+	s.Record(Entry{SiteID: "site", Actor: "admin2", Action: ActionBlock, Target: "user1"})
+	s.Record(Entry{SiteID: "site", Actor: "admin1", Action: ActionBlock, Target: "user2"})
+	s.Record(Entry{SiteID: "site2", Actor: "admin1", Action: ActionPin, Target: "c2"})
+
+	res, err := s.List("site", Filter{})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(res))
+	assert.Equal(t, "user2", res[0].Target, "the most recent first")
+	assert.Equal(t, "c1", res[2].Target)
+	assert.Equal(t, "spam", res[2].Reason)
+	assert.Equal(t, "https://example.com/1", res[2].URL)
+	assert.Equal(t, time.Date(2021, 5, 1, 10, 1, 0, 0, time.UTC), res[2].Timestamp.UTC())
+	assert.NotEmpty(t, res[2].ID)
+
+	res, err = s.List("site", Filter{Actor: "admin1"})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(res))
+	assert.Equal(t, ActionBlock, res[0].Action)
+	assert.Equal(t, ActionDelete, res[1].Action)
+
+	res, err = s.List("site", Filter{Action: ActionBlock, Limit: 1})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, "user2", res[0].Target)
+
+	res, err = s.List("site", Filter{Target: "user1"})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, "admin2", res[0].Actor)
+
+	res, err = s.List("site", Filter{From: time.Date(2021, 5, 1, 10, 2, 0, 0, time.UTC),
+		To: time.Date(2021, 5, 1, 10, 3, 0, 0, time.UTC)})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res), "from inclusive, to exclusive")
+	assert.Equal(t, "user1", res[0].Target)
+
+	res, err = s.List("site2", Filter{})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, ActionPin, res[0].Action)
+
+	res, err = s.List("site3", Filter{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(res))
+}
+
+func TestService_Export(t *testing.T) {
+	s := prepService(t)
+	for i := 0; i < 5; i++ {
+		s.Record(Entry{SiteID: "site", Actor: "admin", Action: ActionVerify, Target: "user"})
+	}
+	count := 0
+	err := s.Export("site", Filter{}, func(Entry) error { count++; return nil })
+	require.NoError(t, err)
+	assert.Equal(t, 5, count)
+
+	err = s.Export("site", Filter{}, func(Entry) error { return errors.New("failed") })
+	assert.EqualError(t, err, "can't export audit entries of site: failed")
+}
+
+func prepService(t *testing.T) *Service {
+	eng, err := engine.NewMemory("", "site", "site2", "site3")
+	require.NoError(t, err)
+	return NewService(NewEngineStore(eng))
+}
@@ -0,0 +1,67 @@
+package audit
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// EngineStore implements Store with records of store engine, entries kept along with comments of their site
+type EngineStore struct {
+	records engine.Records // keyed by timestamp!!id
+}
+
+// NewEngineStore makes store of audit entries kept by eng
+func NewEngineStore(eng engine.Interface) *EngineStore {
+	return &EngineStore{records: engine.Records{Engine: eng, Kind: engine.Audit}}
+}
+
+// Add entry to the log
+func (e *EngineStore) Add(entry Entry) error {
+	err := e.records.Set(entry.SiteID, auditKey(entry.Timestamp, entry.ID), entry)
+	return errors.Wrapf(err, "can't put audit entry %s", entry.ID)
+}
+
+// List passes entries of the site matched by filter to fn, the most recent first
+func (e *EngineStore) List(siteID string, filter Filter, fn func(Entry) error) error {
+	// records listed in key order, entries of the range collected and passed back from the most recent
+	entries := []Entry{}
+	err := e.records.List(siteID, "", func(key string, unmarshal func(v interface{}) error) error {
+		if !filter.From.IsZero() && key < auditKey(filter.From, "") {
+			return nil
+		}
+		if !filter.To.IsZero() && key >= auditKey(filter.To, "") {
+			return nil
+		}
+		entry := Entry{}
+		if err := unmarshal(&entry); err != nil {
+			return err
+		}
+		if filter.match(entry) {
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	for i := len(entries) - 1; i >= 0; i-- {
+		if err := fn(entries[i]); err != nil {
+			return err
+		}
+		count++
+		if filter.Limit > 0 && count >= filter.Limit {
+			break
+		}
+	}
+	return nil
+}
+
+// auditKey sorted by time within the site, fixed-width timestamp keeps lexicographical order
+func auditKey(ts time.Time, id string) string {
+	return ts.UTC().Format("20060102150405.000000000") + "!!" + id
+}
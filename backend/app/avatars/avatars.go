@@ -0,0 +1,171 @@
+// Package avatars resolves avatars of users without picture supplied by auth provider. The auth library stores
+// generated identicon for such users, Service wraps avatar store to detect it and replaces the identicon with avatar
+// from the first source with an avatar for the user, i.e. Gravatar or Libravatar by hashed email.
+// Sources tried in per-site order, default order used for sites not configured.
+package avatars
+
+import (
+	"bytes"
+	"image"
+	_ "image/gif" // decoders of avatars from sources
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/go-pkgz/auth/avatar"
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+	"golang.org/x/image/draw"
+)
+
+// Source of avatars, returns ErrNotFound if it has no avatar for the user
+type Source interface {
+	Name() string
+	Avatar(userID, email string) ([]byte, error)
+}
+
+// ErrNotFound returned by source without avatar for the user
+var ErrNotFound = errors.New("avatar not found")
+
+// Params of the service
+type Params struct {
+	Order       []string            // default order of sources
+	Sites       map[string][]string // order of sources per site
+	ResizeLimit int                 // same as avatar resize limit of auth service
+	Sources     []Source            // custom sources, in addition to built-in gravatar, libravatar and identicon
+}
+
+// Service implements avatar.Store over another store, identicons generated by auth library for users without
+// picture remembered on Put and replaced by Resolve
+type Service struct {
+	avatar.Store
+	Params
+
+	sources map[string]Source
+
+	lock      sync.Mutex
+	generated map[string]bool // users with identicon put, waiting for Resolve
+}
+
+// NewService makes service for the store, all sources in order should be known
+func NewService(st avatar.Store, params Params) (*Service, error) {
+	res := &Service{Store: st, Params: params, generated: map[string]bool{}, sources: map[string]Source{}}
+	for _, src := range append([]Source{NewGravatar(), NewLibravatar(), Identicon{}}, params.Sources...) {
+		res.sources[src.Name()] = src
+	}
+
+	check := func(order []string) error {
+		for _, name := range order {
+			if _, ok := res.sources[name]; !ok {
+				return errors.Errorf("unknown avatar source %q", name)
+			}
+		}
+		return nil
+	}
+	if err := check(params.Order); err != nil {
+		return nil, err
+	}
+	for siteID, order := range params.Sites {
+		if err := check(order); err != nil {
+			return nil, errors.Wrapf(err, "invalid avatar sources of %s", siteID)
+		}
+	}
+	return res, nil
+}
+
+// Put avatar of the user to the store, remembers if the avatar is identicon generated by auth library
+func (s *Service) Put(userID string, reader io.Reader) (avatarID string, err error) {
+	if reader == nil {
+		return s.Store.Put(userID, reader)
+	}
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return "", errors.Wrapf(err, "can't read avatar of %s", userID)
+	}
+
+	generated := s.isIdenticon(userID, data)
+	s.lock.Lock()
+	if generated {
+		s.generated[userID] = true
+	} else {
+		delete(s.generated, userID)
+	}
+	s.lock.Unlock()
+
+	return s.Store.Put(userID, bytes.NewReader(data))
+}
+
+// SiteOrder returns order of sources for the site
+func (s *Service) SiteOrder(siteID string) []string {
+	if order, ok := s.Sites[siteID]; ok {
+		return order
+	}
+	return s.Order
+}
+
+// Resolve replaces identicon of the user with avatar from the first source of the site having one.
+// Does nothing if the last avatar put for the user wasn't generated, returns name of the source used.
+func (s *Service) Resolve(siteID, userID, email string) string {
+	s.lock.Lock()
+	generated := s.generated[userID]
+	delete(s.generated, userID)
+	s.lock.Unlock()
+	if !generated {
+		return ""
+	}
+
+	for _, name := range s.SiteOrder(siteID) {
+		data, err := s.sources[name].Avatar(userID, strings.ToLower(strings.TrimSpace(email)))
+		if err != nil {
+			log.Printf("[DEBUG] no avatar from %s for %s, %v", name, userID, err)
+			continue
+		}
+		if _, err = s.Store.Put(userID, bytes.NewReader(resize(data, s.ResizeLimit))); err != nil {
+			log.Printf("[WARN] can't save avatar from %s for %s, %v", name, userID, err)
+			return ""
+		}
+		log.Printf("[DEBUG] avatar of %s set from %s", userID, name)
+		return name
+	}
+	return ""
+}
+
+// isIdenticon checks if data is identicon made by auth library for the user, resized the same way as auth does
+func (s *Service) isIdenticon(userID string, data []byte) bool {
+	ident, err := avatar.GenerateAvatar(userID)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(data, resize(ident, s.ResizeLimit))
+}
+
+// resize image to fit the limit, repeats resizing of auth avatar proxy.
+// Returns original data if resizing not needed or failed.
+func resize(data []byte, limit int) []byte {
+	if limit <= 0 {
+		return data
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return data
+	}
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	if w <= limit && h <= limit || w <= 0 || h <= 0 {
+		return data
+	}
+	newW, newH := w*limit/h, limit
+	if w > h {
+		newW, newH = limit, h*limit/w
+	}
+	m := image.NewRGBA(image.Rect(0, 0, newW, newH))
+	draw.BiLinear.Scale(m, m.Bounds(), src, src.Bounds(), draw.Src, nil)
+
+	var out bytes.Buffer
+	if err = png.Encode(&out, m); err != nil {
+		return data
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,151 @@
+package avatars
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/go-pkgz/auth/avatar"
+	"github.com/go-pkgz/auth/logger"
+	"github.com/go-pkgz/auth/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_Resolve(t *testing.T) {
+	pic := testImage(t, 100)
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		assert.Equal(t, "404", r.URL.Query().Get("d"))
+		if strings.HasSuffix(r.URL.Path, "/"+NewGravatar().hash("user@example.com")) {
+			_, _ = w.Write(pic)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	svc, st, teardown := prepService(t, Params{Order: []string{"gravatar", "identicon"},
+		Sites: map[string][]string{"site2": {"libravatar", "identicon"}}})
+	defer teardown()
+	svc.sources["gravatar"].(*Hashed).URL = ts.URL + "/gravatar/"
+	svc.sources["libravatar"].(*Hashed).URL = ts.URL + "/libravatar/"
+	proxy := avatar.Proxy{Store: svc, URL: "http://example.com", RoutePath: "/avatar", L: logger.NoOp}
+
+	// user without picture gets gravatar
+	ava, err := proxy.Put(token.User{ID: "github_1"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "gravatar", svc.Resolve("site1", "github_1", " User@Example.com"))
+	assert.Equal(t, pic, load(t, st, ava))
+	assert.Equal(t, "", svc.Resolve("site1", "github_1", "user@example.com"), "resolved once")
+
+	// no gravatar for the email, identicon kept
+	ava, err = proxy.Put(token.User{ID: "github_2"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "identicon", svc.Resolve("site1", "github_2", "other@example.com"))
+	ident, err := avatar.GenerateAvatar("github_2")
+	require.NoError(t, err)
+	assert.Equal(t, ident, load(t, st, ava))
+
+	// site with own order, libravatar only
+	_, err = proxy.Put(token.User{ID: "github_3"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "identicon", svc.Resolve("site2", "github_3", "user@example.com"))
+
+	// user with picture not resolved
+	picSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write(pic) }))
+	defer picSrv.Close()
+	before := atomic.LoadInt32(&hits)
+	_, err = proxy.Put(token.User{ID: "github_4", Picture: picSrv.URL}, &http.Client{})
+	require.NoError(t, err)
+	assert.Equal(t, "", svc.Resolve("site1", "github_4", "user@example.com"))
+	assert.Equal(t, before, atomic.LoadInt32(&hits), "sources not called")
+}
+
+func TestService_ResolveResized(t *testing.T) {
+	pic := testImage(t, 100)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write(pic) }))
+	defer ts.Close()
+
+	svc, st, teardown := prepService(t, Params{Order: []string{"gravatar"}, ResizeLimit: 50})
+	defer teardown()
+	svc.sources["gravatar"].(*Hashed).URL = ts.URL + "/"
+	proxy := avatar.Proxy{Store: svc, URL: "http://example.com", RoutePath: "/avatar", ResizeLimit: 50, L: logger.NoOp}
+
+	ava, err := proxy.Put(token.User{ID: "github_1"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "gravatar", svc.Resolve("site1", "github_1", "user@example.com"), "resized identicon detected")
+	img, _, err := image.Decode(bytes.NewReader(load(t, st, ava)))
+	require.NoError(t, err)
+	assert.Equal(t, 50, img.Bounds().Dx(), "avatar from source resized")
+}
+
+func TestNewService(t *testing.T) {
+	_, err := NewService(avatar.NewNoOp(), Params{Order: []string{"gravatar", "libravatar", "identicon"}})
+	assert.NoError(t, err)
+	_, err = NewService(avatar.NewNoOp(), Params{Order: []string{"gravatar", "blah"}})
+	assert.EqualError(t, err, `unknown avatar source "blah"`)
+	_, err = NewService(avatar.NewNoOp(), Params{Sites: map[string][]string{"site1": {"blah"}}})
+	assert.EqualError(t, err, `invalid avatar sources of site1: unknown avatar source "blah"`)
+	_, err = NewService(avatar.NewNoOp(), Params{Order: []string{"custom"}, Sources: []Source{Identicon{}}})
+	assert.Error(t, err)
+}
+
+func TestHashed_Avatar(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/" + NewLibravatar().hash("text@example.com"):
+			_, _ = w.Write([]byte("some text"))
+		case "/" + NewLibravatar().hash("err@example.com"):
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	src := NewLibravatar()
+	src.URL = ts.URL + "/"
+	assert.Equal(t, "libravatar", src.Name())
+	_, err := src.Avatar("user", "")
+	assert.Equal(t, ErrNotFound, err, "no email")
+	_, err = src.Avatar("user", "user@example.com")
+	assert.Equal(t, ErrNotFound, err)
+	_, err = src.Avatar("user", "text@example.com")
+	assert.EqualError(t, err, "not an image from libravatar")
+	_, err = src.Avatar("user", "err@example.com")
+	assert.Error(t, err)
+}
+
+func prepService(t *testing.T, params Params) (svc *Service, st avatar.Store, teardown func()) {
+	dir, err := ioutil.TempDir("", "avatars")
+	require.NoError(t, err)
+	st = avatar.NewLocalFS(dir)
+	svc, err = NewService(st, params)
+	require.NoError(t, err)
+	return svc, st, func() { _ = os.RemoveAll(dir) }
+}
+
+func load(t *testing.T, st avatar.Store, avatarURL string) []byte {
+	r, _, err := st.Get(path.Base(avatarURL))
+	require.NoError(t, err)
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	return data
+}
+
+func testImage(t *testing.T, size int) []byte {
+	buf := bytes.Buffer{}
+	require.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, size, size))))
+	return buf.Bytes()
+}
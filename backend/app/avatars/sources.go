@@ -0,0 +1,84 @@
+package avatars
+
+import (
+	"crypto/md5" // nolint
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-pkgz/auth/avatar"
+	"github.com/pkg/errors"
+)
+
+const maxAvatarSize = 1024 * 1024
+
+// Hashed is a source of avatars served by hash of user's email, like Gravatar and Libravatar
+type Hashed struct {
+	URL    string // base url, hash of email appended
+	Client *http.Client
+
+	name string
+	hash func(email string) string
+}
+
+// NewGravatar makes Gravatar source
+func NewGravatar() *Hashed {
+	return &Hashed{name: "gravatar", URL: "https://www.gravatar.com/avatar/", Client: &http.Client{Timeout: 5 * time.Second},
+		hash: func(email string) string {
+			h := md5.Sum([]byte(email)) // nolint
+			return hex.EncodeToString(h[:])
+		}}
+}
+
+// NewLibravatar makes Libravatar source
+func NewLibravatar() *Hashed {
+	return &Hashed{name: "libravatar", URL: "https://seccdn.libravatar.org/avatar/", Client: &http.Client{Timeout: 5 * time.Second},
+		hash: func(email string) string {
+			h := sha256.Sum256([]byte(email))
+			return hex.EncodeToString(h[:])
+		}}
+}
+
+// Name of the source
+func (h *Hashed) Name() string { return h.name }
+
+// Avatar loads avatar by hash of email, service asked to respond with 404 instead of default image
+func (h *Hashed) Avatar(_, email string) ([]byte, error) {
+	if !strings.Contains(email, "@") {
+		return nil, ErrNotFound
+	}
+	resp, err := h.Client.Get(h.URL + h.hash(email) + "?d=404&s=300")
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't load avatar from %s", h.name)
+	}
+	defer resp.Body.Close() // nolint
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("can't load avatar from %s, status %s", h.name, resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxAvatarSize))
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't read avatar from %s", h.name)
+	}
+	if !strings.HasPrefix(http.DetectContentType(data), "image/") {
+		return nil, errors.Errorf("not an image from %s", h.name)
+	}
+	return data, nil
+}
+
+// Identicon is a source of avatars generated by user id, the same way as auth library does
+type Identicon struct{}
+
+// Name of the source
+func (Identicon) Name() string { return "identicon" }
+
+// Avatar generates identicon for the user
+func (Identicon) Avatar(userID, _ string) ([]byte, error) {
+	return avatar.GenerateAvatar(userID)
+}
@@ -0,0 +1,105 @@
+// Package bookmarks keeps comments saved by users for later, across posts of the site. Each comment keeps
+// the number of users bookmarked it, so frontends can show saved state and popularity of answers.
+package bookmarks
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Bookmark of the comment saved by the user
+type Bookmark struct {
+	SiteID    string    `json:"site"`
+	UserID    string    `json:"user_id"`
+	URL       string    `json:"url"`
+	CommentID string    `json:"id"`
+	Time      time.Time `json:"time"`
+}
+
+// Store defines interface to keep bookmarks and counts of them per comment
+type Store interface {
+	Add(b Bookmark) (added bool, err error)                            // existing bookmark not changed, added false
+	Delete(siteID, userID, commentID string) (deleted bool, err error) // missing bookmark ignored, deleted false
+	List(siteID, userID string) ([]Bookmark, error)                    // bookmarks of the user on the site
+	Counts(siteID, url string) (map[string]int, error)                 // numbers of bookmarks by comment id of the post
+}
+
+// Service saves and lists bookmarks of users
+type Service struct {
+	store   Store
+	maxSize int
+	now     func() time.Time
+
+	lock sync.Mutex // serializes additions checked against max size
+}
+
+// NewService makes bookmarks service, maxSize limits number of bookmarks per user on a site, 1000 by default
+func NewService(st Store, maxSize int) *Service {
+	if maxSize <= 0 {
+		maxSize = 1000
+	}
+	return &Service{store: st, maxSize: maxSize, now: time.Now}
+}
+
+// Add bookmark of the comment for the user, bookmarking already saved comment is not an error
+func (s *Service) Add(siteID, userID, url, commentID string) (Bookmark, error) {
+	if siteID == "" || userID == "" || url == "" || commentID == "" {
+		return Bookmark{}, errors.New("site, user, url and comment id are required")
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	list, err := s.store.List(siteID, userID)
+	if err != nil {
+		return Bookmark{}, errors.Wrapf(err, "can't get bookmarks of %s", userID)
+	}
+	for _, b := range list {
+		if b.CommentID == commentID {
+			return b, nil
+		}
+	}
+	if len(list) >= s.maxSize {
+		return Bookmark{}, errors.Errorf("too many bookmarks, max %d", s.maxSize)
+	}
+	b := Bookmark{SiteID: siteID, UserID: userID, URL: url, CommentID: commentID, Time: s.now()}
+	if _, err = s.store.Add(b); err != nil {
+		return Bookmark{}, errors.Wrapf(err, "can't save bookmark of %s", userID)
+	}
+	return b, nil
+}
+
+// Delete bookmark of the comment for the user
+func (s *Service) Delete(siteID, userID, commentID string) error {
+	_, err := s.store.Delete(siteID, userID, commentID)
+	return errors.Wrapf(err, "can't delete bookmark of %s", userID)
+}
+
+// List bookmarks of the user on the site, the most recent first
+func (s *Service) List(siteID, userID string) ([]Bookmark, error) {
+	list, err := s.store.List(siteID, userID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't get bookmarks of %s", userID)
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Time.After(list[j].Time) })
+	return list, nil
+}
+
+// Post returns numbers of bookmarks by comment id on the post and ids of comments bookmarked by the user
+func (s *Service) Post(siteID, userID, url string) (counts map[string]int, saved []string, err error) {
+	if counts, err = s.store.Counts(siteID, url); err != nil {
+		return nil, nil, errors.Wrapf(err, "can't get bookmark counts of %s", url)
+	}
+	list, err := s.List(siteID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	saved = []string{}
+	for _, b := range list {
+		if b.URL == url {
+			saved = append(saved, b.CommentID)
+		}
+	}
+	return counts, saved, nil
+}
@@ -0,0 +1,104 @@
+package bookmarks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+func TestService_AddAndList(t *testing.T) {
+	st := prepStore(t)
+
+	svc := NewService(st, 3)
+	ts := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	svc.now = func() time.Time { return ts }
+
+	_, err := svc.Add("site1", "user1", "", "c1")
+	assert.EqualError(t, err, "site, user, url and comment id are required")
+
+	b, err := svc.Add("site1", "user1", "https://example.com/1", "c1")
+	require.NoError(t, err)
+	assert.Equal(t, Bookmark{SiteID: "site1", UserID: "user1", URL: "https://example.com/1", CommentID: "c1", Time: ts}, b)
+
+	ts = ts.Add(time.Minute)
+	_, err = svc.Add("site1", "user1", "https://example.com/2", "c2")
+	require.NoError(t, err)
+	b, err = svc.Add("site1", "user1", "https://example.com/1", "c1")
+	require.NoError(t, err)
+	assert.Equal(t, ts.Add(-time.Minute), b.Time, "already saved, not changed")
+	_, err = svc.Add("site1", "user2", "https://example.com/1", "c1")
+	require.NoError(t, err)
+	_, err = svc.Add("site2", "user1", "https://example.com/1", "c1")
+	require.NoError(t, err)
+
+	list, err := svc.List("site1", "user1")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(list))
+	assert.Equal(t, "c2", list[0].CommentID, "the most recent first")
+	assert.Equal(t, "c1", list[1].CommentID)
+
+	_, err = svc.Add("site1", "user1", "https://example.com/1", "c3")
+	require.NoError(t, err)
+	_, err = svc.Add("site1", "user1", "https://example.com/1", "c4")
+	assert.EqualError(t, err, "too many bookmarks, max 3")
+
+	counts, saved, err := svc.Post("site1", "user1", "https://example.com/1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"c1": 2, "c3": 1}, counts)
+	assert.Equal(t, []string{"c3", "c1"}, saved, "the most recent first")
+
+	require.NoError(t, svc.Delete("site1", "user1", "c1"))
+	require.NoError(t, svc.Delete("site1", "user1", "c1"), "missing bookmark ignored")
+	require.NoError(t, svc.Delete("site1", "user2", "c1"))
+	counts, saved, err = svc.Post("site1", "user1", "https://example.com/1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"c3": 1}, counts, "zero count removed")
+	assert.Equal(t, []string{"c3"}, saved)
+
+	counts, saved, err = svc.Post("site1", "user3", "https://example.com/2")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"c2": 1}, counts)
+	assert.Equal(t, []string{}, saved)
+}
+
+func TestEngineStore(t *testing.T) {
+	st := prepStore(t)
+	added, err := st.Add(Bookmark{SiteID: "site1", UserID: "user1", URL: "https://example.com/1", CommentID: "c1"})
+	require.NoError(t, err)
+	assert.True(t, added)
+	added, err = st.Add(Bookmark{SiteID: "site1", UserID: "user1", URL: "https://example.com/1", CommentID: "c1"})
+	require.NoError(t, err)
+	assert.False(t, added)
+	added, err = st.Add(Bookmark{SiteID: "site1", UserID: "user2", URL: "https://example.com/1", CommentID: "c1"})
+	require.NoError(t, err)
+	assert.True(t, added)
+
+	list, err := st.List("site1", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(list))
+	counts, err := st.Counts("site1", "https://example.com/1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"c1": 2}, counts)
+	deleted, err := st.Delete("site1", "user1", "c1")
+	require.NoError(t, err)
+	assert.True(t, deleted)
+	deleted, err = st.Delete("site1", "user1", "c1")
+	require.NoError(t, err)
+	assert.False(t, deleted)
+	counts, err = st.Counts("site1", "https://example.com/1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"c1": 1}, counts)
+	counts, err = st.Counts("site2", "https://example.com/1")
+	require.NoError(t, err)
+	assert.Empty(t, counts, "counts are per site")
+}
+
+func prepStore(t *testing.T) *EngineStore {
+	eng, err := engine.NewMemory("", "site1", "site2")
+	require.NoError(t, err)
+	return NewEngineStore(eng)
+}
@@ -0,0 +1,75 @@
+package bookmarks
+
+import (
+	"strings"
+
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// EngineStore implements Store with records of store engine, bookmarks kept along with comments of the site
+type EngineStore struct {
+	bookmarks engine.Records // keyed by userID!!commentID
+	index     engine.Records // keyed by url!!commentID!!userID, to count bookmarks of comments of the post
+}
+
+// NewEngineStore makes store of bookmarks kept by eng
+func NewEngineStore(eng engine.Interface) *EngineStore {
+	return &EngineStore{bookmarks: engine.Records{Engine: eng, Kind: engine.Bookmarks},
+		index: engine.Records{Engine: eng, Kind: engine.BookmarksIndex}}
+}
+
+// Add bookmark and count it for the comment, existing bookmark not changed
+func (e *EngineStore) Add(bm Bookmark) (added bool, err error) {
+	if added, err = e.bookmarks.Add(bm.SiteID, bookmarkKey(bm.UserID, bm.CommentID), bm); err != nil || !added {
+		return false, err
+	}
+	return true, e.index.Set(bm.SiteID, indexKey(bm.URL, bm.CommentID, bm.UserID), struct{}{})
+}
+
+// Delete bookmark and uncount it for the comment, missing bookmark ignored
+func (e *EngineStore) Delete(siteID, userID, commentID string) (deleted bool, err error) {
+	bm := Bookmark{}
+	found, err := e.bookmarks.Get(siteID, bookmarkKey(userID, commentID), &bm)
+	if err != nil || !found {
+		return false, err
+	}
+	if _, err = e.bookmarks.Delete(siteID, bookmarkKey(userID, commentID)); err != nil {
+		return false, err
+	}
+	_, err = e.index.Delete(siteID, indexKey(bm.URL, commentID, userID))
+	return true, err
+}
+
+// List bookmarks of the user on the site
+func (e *EngineStore) List(siteID, userID string) (res []Bookmark, err error) {
+	res = []Bookmark{}
+	err = e.bookmarks.List(siteID, userID+"!!", func(_ string, unmarshal func(v interface{}) error) error {
+		bm := Bookmark{}
+		if err := unmarshal(&bm); err != nil {
+			return err
+		}
+		res = append(res, bm)
+		return nil
+	})
+	return res, err
+}
+
+// Counts of bookmarks by comment id of the post, comments without bookmarks not included
+func (e *EngineStore) Counts(siteID, url string) (res map[string]int, err error) {
+	res = map[string]int{}
+	prefix := url + "!!"
+	err = e.index.List(siteID, prefix, func(key string, _ func(v interface{}) error) error {
+		commentID := strings.SplitN(strings.TrimPrefix(key, prefix), "!!", 2)[0]
+		res[commentID]++
+		return nil
+	})
+	return res, err
+}
+
+func bookmarkKey(userID, commentID string) string {
+	return userID + "!!" + commentID
+}
+
+func indexKey(url, commentID, userID string) string {
+	return url + "!!" + commentID + "!!" + userID
+}
@@ -0,0 +1,101 @@
+// Package captcha verifies captcha tokens of anonymous users with hCaptcha, reCAPTCHA or Turnstile,
+// so sites can allow anonymous comments without bot spam. Captcha enabled per site, with min score
+// for providers returning scores.
+package captcha
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+)
+
+// Result of token verification
+type Result struct {
+	Success bool
+	Score   float64 // likelihood of human in [0, 1], 1 for providers without scores
+}
+
+// Verifier defines interface of captcha provider
+type Verifier interface {
+	fmt.Stringer
+	Verify(ctx context.Context, token, remoteIP string) (Result, error)
+}
+
+// errors returned by Check
+var (
+	ErrRequired = errors.New("captcha required")
+	ErrFailed   = errors.New("captcha verification failed")
+)
+
+// Params of Service
+type Params struct {
+	SiteKey string                                               // public key of the widget, used by frontend
+	Timeout time.Duration                                        // timeout of a single verification, default 5s
+	Sites   func(siteID string) (enabled bool, minScore float64) // captcha settings of the site
+}
+
+// Service checks captcha tokens of sites with captcha enabled. Tokens not verified are rejected,
+// including errors of provider, as accepting them would let bots in while provider is down.
+type Service struct {
+	Params
+	verifier Verifier
+}
+
+// NewService makes captcha service with verifier
+func NewService(verifier Verifier, params Params) *Service {
+	if params.Timeout <= 0 {
+		params.Timeout = 5 * time.Second
+	}
+	log.Printf("[INFO] captcha verifier %s", verifier)
+	return &Service{Params: params, verifier: verifier}
+}
+
+// Enabled checks if captcha required on the site, safe to call on nil Service
+func (s *Service) Enabled(siteID string) bool {
+	if s == nil {
+		return false
+	}
+	if s.Sites == nil {
+		return true
+	}
+	enabled, _ := s.Sites(siteID)
+	return enabled
+}
+
+// Check verifies token of the site, nil if captcha not enabled for the site. Safe to call on nil Service.
+func (s *Service) Check(siteID, token, remoteIP string) error {
+	if !s.Enabled(siteID) {
+		return nil
+	}
+	if token == "" {
+		return ErrRequired
+	}
+	var minScore float64
+	if s.Sites != nil {
+		_, minScore = s.Sites(siteID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+	res, err := s.verifier.Verify(ctx, token, remoteIP)
+	if err != nil {
+		log.Printf("[WARN] can't verify captcha on %s with %s, %v", siteID, s.verifier, err)
+		return ErrFailed
+	}
+	if !res.Success || res.Score < minScore {
+		log.Printf("[INFO] captcha on %s rejected, success=%v, score=%.2f", siteID, res.Success, res.Score)
+		return ErrFailed
+	}
+	return nil
+}
+
+// Provider returns name of captcha provider, empty for nil Service
+func (s *Service) Provider() string {
+	if s == nil {
+		return ""
+	}
+	return s.verifier.String()
+}
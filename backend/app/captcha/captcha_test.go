@@ -0,0 +1,57 @@
+package captcha
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestService_Check(t *testing.T) {
+	v := &mockVerifier{res: Result{Success: true, Score: 0.4}}
+	sites := func(siteID string) (bool, float64) {
+		switch siteID {
+		case "strict":
+			return true, 0.5
+		case "open":
+			return false, 0
+		}
+		return true, 0
+	}
+	svc := NewService(v, Params{Sites: sites})
+	assert.Equal(t, "mock", svc.Provider())
+
+	assert.NoError(t, svc.Check("open", "", "1.2.3.4"), "captcha disabled")
+	assert.Equal(t, ErrRequired, svc.Check("remark", "", "1.2.3.4"))
+	assert.NoError(t, svc.Check("remark", "token", "1.2.3.4"))
+	assert.Equal(t, "token", v.token)
+	assert.Equal(t, "1.2.3.4", v.ip)
+	assert.Equal(t, ErrFailed, svc.Check("strict", "token", "1.2.3.4"), "score below min")
+
+	v.res = Result{Success: false, Score: 1}
+	assert.Equal(t, ErrFailed, svc.Check("remark", "token", "1.2.3.4"))
+	v.res, v.err = Result{Success: true, Score: 1}, errors.New("failed")
+	assert.Equal(t, ErrFailed, svc.Check("remark", "token", "1.2.3.4"), "errors rejected")
+
+	svc = NewService(v, Params{})
+	assert.True(t, svc.Enabled("any"), "enabled for all sites without settings")
+
+	svc = nil
+	assert.False(t, svc.Enabled("remark"))
+	assert.NoError(t, svc.Check("remark", "", ""))
+	assert.Equal(t, "", svc.Provider())
+}
+
+type mockVerifier struct {
+	res       Result
+	err       error
+	token, ip string
+}
+
+func (m *mockVerifier) Verify(_ context.Context, token, remoteIP string) (Result, error) {
+	m.token, m.ip = token, remoteIP
+	return m.res, m.err
+}
+
+func (m *mockVerifier) String() string { return "mock" }
@@ -0,0 +1,93 @@
+package captcha
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// enum of providers supported by SiteVerify
+const (
+	HCaptcha  = "hcaptcha"
+	ReCaptcha = "recaptcha"
+	Turnstile = "turnstile"
+)
+
+var siteVerifyAPI = map[string]string{
+	HCaptcha:  "https://hcaptcha.com/siteverify",
+	ReCaptcha: "https://www.google.com/recaptcha/api/siteverify",
+	Turnstile: "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+}
+
+// SiteVerify verifies tokens with siteverify api, the same for hCaptcha, reCAPTCHA and Turnstile.
+// Token posted as form with secret and ip of user, response is {"success": true, "score": 0.9}.
+type SiteVerify struct {
+	Provider string // hcaptcha, recaptcha or turnstile
+	Secret   string
+	API      string // optional, default siteverify url of the provider
+	Client   http.Client
+}
+
+// Verify token with siteverify call. Score of hCaptcha is a risk, it is inverted to likelihood of human.
+func (v *SiteVerify) Verify(ctx context.Context, token, remoteIP string) (Result, error) {
+	api := v.API
+	if api == "" {
+		api = siteVerifyAPI[v.Provider]
+	}
+	if api == "" {
+		return Result{}, errors.Errorf("unknown captcha provider %q", v.Provider)
+	}
+
+	form := url.Values{"secret": {v.Secret}, "response": {token}}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, api, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Result{}, errors.Wrap(err, "can't make siteverify request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		return Result{}, errors.Wrapf(err, "siteverify request to %s failed", api)
+	}
+	defer resp.Body.Close() // nolint
+	if resp.StatusCode != http.StatusOK {
+		return Result{}, errors.Errorf("siteverify request to %s failed, status %s", api, resp.Status)
+	}
+
+	body := struct {
+		Success    bool     `json:"success"`
+		Score      *float64 `json:"score"`
+		ErrorCodes []string `json:"error-codes"`
+	}{}
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return Result{}, errors.Wrap(err, "can't decode siteverify response")
+	}
+	if !body.Success {
+		// invalid secret is a misconfiguration, not a failed captcha
+		for _, code := range body.ErrorCodes {
+			if code == "invalid-input-secret" || code == "missing-input-secret" {
+				return Result{}, errors.Errorf("siteverify rejected secret, %s", strings.Join(body.ErrorCodes, ","))
+			}
+		}
+	}
+
+	res := Result{Success: body.Success, Score: 1}
+	if body.Score != nil {
+		res.Score = *body.Score
+		if v.Provider == HCaptcha {
+			res.Score = 1 - res.Score
+		}
+	}
+	return res, nil
+}
+
+// String representation of SiteVerify
+func (v *SiteVerify) String() string {
+	return v.Provider
+}
@@ -0,0 +1,60 @@
+package captcha
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSiteVerify_Verify(t *testing.T) {
+	var resp string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "secret", r.PostForm.Get("secret"))
+		assert.Equal(t, "token", r.PostForm.Get("response"))
+		assert.Equal(t, "1.2.3.4", r.PostForm.Get("remoteip"))
+		if resp == "" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, _ = w.Write([]byte(resp))
+	}))
+	defer ts.Close()
+
+	v := &SiteVerify{Provider: ReCaptcha, Secret: "secret", API: ts.URL}
+	assert.Equal(t, "recaptcha", v.String())
+
+	tbl := []struct {
+		provider string
+		resp     string
+		res      Result
+		err      string
+	}{
+		{provider: Turnstile, resp: `{"success": true}`, res: Result{Success: true, Score: 1}},
+		{provider: ReCaptcha, resp: `{"success": true, "score": 0.7}`, res: Result{Success: true, Score: 0.7}},
+		{provider: HCaptcha, resp: `{"success": true, "score": 0.2}`, res: Result{Success: true, Score: 0.8}},
+		{provider: HCaptcha, resp: `{"success": false, "error-codes": ["invalid-input-response"]}`, res: Result{Score: 1}},
+		{provider: Turnstile, resp: `{"success": false, "error-codes": ["invalid-input-secret"]}`,
+			err: "siteverify rejected secret, invalid-input-secret"},
+		{provider: Turnstile, resp: `bad`, err: "can't decode siteverify response: invalid character 'b' looking for beginning of value"},
+		{provider: Turnstile, err: "siteverify request to " + ts.URL + " failed, status 502 Bad Gateway"},
+	}
+	for _, tt := range tbl {
+		resp, v.Provider = tt.resp, tt.provider
+		res, err := v.Verify(context.Background(), "token", "1.2.3.4")
+		if tt.err != "" {
+			assert.EqualError(t, err, tt.err, tt.resp)
+			continue
+		}
+		require.NoError(t, err, tt.resp)
+		assert.InDelta(t, tt.res.Score, res.Score, 0.001, tt.resp)
+		assert.Equal(t, tt.res.Success, res.Success, tt.resp)
+	}
+
+	_, err := (&SiteVerify{Provider: "bad"}).Verify(context.Background(), "token", "")
+	assert.EqualError(t, err, `unknown captcha provider "bad"`)
+}
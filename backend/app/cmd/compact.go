@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// CompactCommand set of flags and command for bolt storage compaction.
+// Works with bolt files directly, server should be stopped before running it.
+type CompactCommand struct {
+	Sites    []string      `short:"s" long:"site" env:"SITE" default:"remark" description:"site name(s)" env-delim:","`
+	BoltPath string        `long:"path" env:"STORE_BOLT_PATH" default:"./var" description:"parent dir for bolt files"`
+	Timeout  time.Duration `long:"timeout" default:"5s" description:"bolt file lock timeout"`
+	CommonOpts
+}
+
+// Execute runs compaction with CompactCommand parameters, entry point for "compact" command
+func (cc *CompactCommand) Execute(_ []string) error {
+	log.Printf("[INFO] compact bolt storage for sites %v", cc.Sites)
+	for _, site := range cc.Sites {
+		fileName := fmt.Sprintf("%s/%s.db", cc.BoltPath, site)
+		report, err := engine.CompactBoltDB(fileName, bolt.Options{Timeout: cc.Timeout})
+		if err != nil {
+			return errors.Wrapf(err, "can't compact site %s", site)
+		}
+		log.Printf("[INFO] site %s compacted, %d -> %d bytes", site, report.SizeBefore, report.SizeAfter)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/umputun/go-flags"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+func TestCompact_Execute(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compact")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	b, err := engine.NewBoltDB(bolt.Options{}, engine.BoltSite{FileName: dir + "/remark.db", SiteID: "remark"})
+	require.NoError(t, err)
+	_, err = b.Create(store.Comment{ID: "c1", Text: "text", Locator: store.Locator{SiteID: "remark", URL: "u1"}, User: store.User{ID: "user1"}})
+	require.NoError(t, err)
+	require.NoError(t, b.Close())
+
+	cmd := CompactCommand{}
+	cmd.SetCommon(CommonOpts{RemarkURL: "http://127.0.0.1", SharedSecret: "123456"})
+	p := flags.NewParser(&cmd, flags.Default)
+	_, err = p.ParseArgs([]string{"--site=remark", "--path=" + dir})
+	require.NoError(t, err)
+	require.NoError(t, cmd.Execute(nil))
+
+	b, err = engine.NewBoltDB(bolt.Options{}, engine.BoltSite{FileName: dir + "/remark.db", SiteID: "remark"})
+	require.NoError(t, err)
+	defer b.Close()
+	c, err := b.Get(engine.GetRequest{Locator: store.Locator{SiteID: "remark", URL: "u1"}, CommentID: "c1"})
+	require.NoError(t, err)
+	assert.Equal(t, "text", c.Text)
+
+	cmd = CompactCommand{}
+	p = flags.NewParser(&cmd, flags.Default)
+	_, err = p.ParseArgs([]string{"--site=bad", "--path=" + dir})
+	require.NoError(t, err)
+	assert.Error(t, cmd.Execute(nil))
+}
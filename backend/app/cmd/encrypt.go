@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// EncryptionGroup defines options group for encryption of comments and emails at rest
+type EncryptionGroup struct {
+	Key    string `long:"key" env:"KEY" description:"base64 of 32 bytes key encrypting comments and emails at rest"`
+	KeyCmd string `long:"key_cmd" env:"KEY_CMD" description:"command printing base64 of the key, i.e. decrypting it with kms"`
+}
+
+// EncryptCommand set of flags and command for encryption of existing bolt storage.
+// Works with bolt files directly, server should be stopped before running it.
+type EncryptCommand struct {
+	Sites      []string        `short:"s" long:"site" env:"SITE" default:"remark" description:"site name(s)" env-delim:","`
+	BoltPath   string          `long:"path" env:"STORE_BOLT_PATH" default:"./var" description:"parent dir for bolt files"`
+	Timeout    time.Duration   `long:"timeout" default:"5s" description:"bolt file lock timeout"`
+	Encryption EncryptionGroup `group:"encryption" namespace:"encryption" env-namespace:"STORE_ENCRYPTION"`
+	CommonOpts
+}
+
+// Execute encrypts comments and emails stored before encryption enabled, entry point for "encrypt" command
+func (ec *EncryptCommand) Execute(_ []string) error {
+	log.Printf("[INFO] encrypt bolt storage for sites %v", ec.Sites)
+	key, err := ec.Encryption.key()
+	if err != nil {
+		return err
+	}
+	resetEnv("STORE_ENCRYPTION_KEY")
+	if key == nil {
+		return errors.New("encryption key is required")
+	}
+
+	for _, site := range ec.Sites {
+		b, err := engine.NewBoltDB(bolt.Options{Timeout: ec.Timeout},
+			engine.BoltSite{FileName: fmt.Sprintf("%s/%s.db", ec.BoltPath, site), SiteID: site})
+		if err != nil {
+			return errors.Wrapf(err, "can't open site %s", site)
+		}
+		enc, err := engine.NewEncrypted(b, key)
+		if err != nil {
+			_ = b.Close()
+			return err
+		}
+		report, err := enc.EncryptSite(site)
+		if e := b.Close(); e != nil {
+			log.Printf("[WARN] can't close site %s, %v", site, e)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "can't encrypt site %s", site)
+		}
+		log.Printf("[INFO] site %s encrypted, comments=%d, emails=%d", site, report.Comments, report.Emails)
+	}
+	return nil
+}
+
+// key returns encryption key set directly or printed by key command, nil if encryption not enabled
+func (g EncryptionGroup) key() ([]byte, error) {
+	encoded := g.Key
+	if g.KeyCmd != "" {
+		stdout := bytes.Buffer{}
+		cmd := exec.Command("sh", "-c", g.KeyCmd) //nolint:gosec // command set by admin
+		cmd.Stdout = &stdout
+		if err := cmd.Run(); err != nil {
+			return nil, errors.Wrap(err, "encryption key command failed")
+		}
+		encoded = stdout.String()
+	}
+	encoded = strings.TrimSpace(encoded)
+	if encoded == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't decode encryption key")
+	}
+	if len(key) != 32 {
+		return nil, errors.Errorf("invalid encryption key size %d, 32 bytes expected", len(key))
+	}
+	return key, nil
+}
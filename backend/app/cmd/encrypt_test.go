@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/umputun/go-flags"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+var testEncryptionKey = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+
+func TestEncrypt_Execute(t *testing.T) {
+	dir, err := ioutil.TempDir("", "encrypt")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	locator := store.Locator{SiteID: "remark", URL: "u1"}
+	b, err := engine.NewBoltDB(bolt.Options{}, engine.BoltSite{FileName: dir + "/remark.db", SiteID: "remark"})
+	require.NoError(t, err)
+	_, err = b.Create(store.Comment{ID: "c1", Text: "text", Locator: locator, User: store.User{ID: "user1"}})
+	require.NoError(t, err)
+	require.NoError(t, b.Close())
+
+	cmd := EncryptCommand{}
+	cmd.SetCommon(CommonOpts{RemarkURL: "http://127.0.0.1", SharedSecret: "123456"})
+	p := flags.NewParser(&cmd, flags.Default)
+	_, err = p.ParseArgs([]string{"--site=remark", "--path=" + dir})
+	require.NoError(t, err)
+	assert.EqualError(t, cmd.Execute(nil), "encryption key is required")
+
+	_, err = p.ParseArgs([]string{"--site=remark", "--path=" + dir, "--encryption.key=" + testEncryptionKey})
+	require.NoError(t, err)
+	require.NoError(t, cmd.Execute(nil))
+
+	b, err = engine.NewBoltDB(bolt.Options{}, engine.BoltSite{FileName: dir + "/remark.db", SiteID: "remark"})
+	require.NoError(t, err)
+	defer b.Close()
+	c, err := b.Get(engine.GetRequest{Locator: locator, CommentID: "c1"})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(c.Text, "enc:"), c.Text)
+
+	key, err := cmd.Encryption.key()
+	require.NoError(t, err)
+	enc, err := engine.NewEncrypted(b, key)
+	require.NoError(t, err)
+	c, err = enc.Get(engine.GetRequest{Locator: locator, CommentID: "c1"})
+	require.NoError(t, err)
+	assert.Equal(t, "text", c.Text)
+}
+
+func TestEncryptionGroup_key(t *testing.T) {
+	key, err := EncryptionGroup{}.key()
+	require.NoError(t, err)
+	assert.Nil(t, key, "encryption disabled")
+
+	key, err = EncryptionGroup{Key: testEncryptionKey}.key()
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789abcdef0123456789abcdef", string(key))
+
+	key, err = EncryptionGroup{KeyCmd: "echo " + testEncryptionKey}.key()
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789abcdef0123456789abcdef", string(key))
+
+	_, err = EncryptionGroup{KeyCmd: "exit 1"}.key()
+	assert.Error(t, err)
+	_, err = EncryptionGroup{Key: "bad key"}.key()
+	assert.Error(t, err)
+	_, err = EncryptionGroup{Key: base64.StdEncoding.EncodeToString([]byte("short"))}.key()
+	assert.EqualError(t, err, "invalid encryption key size 5, 32 bytes expected")
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// IntegrityCommand set of flags and command for storage integrity check
+type IntegrityCommand struct {
+	Site        string        `short:"s" long:"site" env:"SITE" default:"remark" description:"site name"`
+	Repair      bool          `long:"repair" description:"repair found problems"`
+	Timeout     time.Duration `long:"timeout" default:"15m" description:"integrity check timeout"`
+	AdminPasswd string        `long:"admin-passwd" env:"ADMIN_PASSWD" required:"true" description:"admin basic auth password"`
+	CommonOpts
+}
+
+// Execute runs integrity check with IntegrityCommand parameters, entry point for "integrity" command
+// It prints every found problem and fails if any of them left unrepaired.
+func (ic *IntegrityCommand) Execute(_ []string) error {
+	log.Printf("[INFO] integrity check for site %s, repair=%v", ic.Site, ic.Repair)
+	resetEnv("SECRET", "ADMIN_PASSWD")
+
+	method := http.MethodGet
+	if ic.Repair {
+		method = http.MethodPost
+	}
+
+	client := http.Client{}
+	ctx, cancel := context.WithTimeout(context.Background(), ic.Timeout)
+	defer cancel()
+	integrityURL := fmt.Sprintf("%s/api/v1/admin/integrity?site=%s", ic.RemarkURL, ic.Site)
+	req, err := http.NewRequest(method, integrityURL, nil)
+	if err != nil {
+		return errors.Wrapf(err, "can't make integrity request for %s", integrityURL)
+	}
+	req.SetBasicAuth("admin", ic.AdminPasswd)
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "request failed for %s", integrityURL)
+	}
+	defer func() {
+		if err = resp.Body.Close(); err != nil {
+			log.Printf("[WARN] failed to close response, %s", err)
+		}
+	}()
+
+	if resp.StatusCode >= 300 {
+		return responseError(resp)
+	}
+
+	report := engine.IntegrityReport{}
+	if err = json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return errors.Wrap(err, "can't decode integrity report")
+	}
+
+	for _, issue := range report.Issues {
+		log.Printf("[WARN] %s, url=%s, id=%s, %s, repaired=%v", issue.Kind, issue.URL, issue.CommentID, issue.Details, issue.Repaired)
+	}
+	log.Printf("[INFO] integrity check completed, posts=%d, comments=%d, issues=%d, repaired=%d",
+		report.Posts, report.Comments, len(report.Issues), report.Repaired)
+
+	if unrepaired := len(report.Issues) - report.Repaired; unrepaired > 0 {
+		return errors.Errorf("%d integrity problems found", unrepaired)
+	}
+	return nil
+}
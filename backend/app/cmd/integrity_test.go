@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/umputun/go-flags"
+)
+
+func TestIntegrity_Execute(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/admin/integrity", r.URL.Path)
+		assert.Equal(t, "remark", r.URL.Query().Get("site"))
+		user, passwd, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "admin", user)
+		assert.Equal(t, "secret", passwd)
+		if r.Method == http.MethodPost {
+			fmt.Fprint(w, `{"site_id":"remark","posts":1,"comments":2,"repaired":1,`+
+				`"issues":[{"kind":"dangling_parent","url":"u1","comment_id":"c1","details":"parent p1 not found","repaired":true}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"site_id":"remark","posts":1,"comments":2,"repaired":0,`+
+			`"issues":[{"kind":"dangling_parent","url":"u1","comment_id":"c1","details":"parent p1 not found","repaired":false}]}`)
+	}))
+	defer ts.Close()
+
+	cmd := IntegrityCommand{}
+	cmd.SetCommon(CommonOpts{RemarkURL: ts.URL, SharedSecret: "123456"})
+	p := flags.NewParser(&cmd, flags.Default)
+	_, err := p.ParseArgs([]string{"--site=remark", "--admin-passwd=secret"})
+	require.NoError(t, err)
+	err = cmd.Execute(nil)
+	assert.EqualError(t, err, "1 integrity problems found")
+
+	cmd = IntegrityCommand{}
+	cmd.SetCommon(CommonOpts{RemarkURL: ts.URL, SharedSecret: "123456"})
+	p = flags.NewParser(&cmd, flags.Default)
+	_, err = p.ParseArgs([]string{"--site=remark", "--admin-passwd=secret", "--repair"})
+	require.NoError(t, err)
+	assert.NoError(t, cmd.Execute(nil))
+}
+
+func TestIntegrity_ExecuteFailedStatus(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+		fmt.Fprint(w, "some error")
+	}))
+	defer ts.Close()
+
+	cmd := IntegrityCommand{}
+	cmd.SetCommon(CommonOpts{RemarkURL: ts.URL, SharedSecret: "123456"})
+	p := flags.NewParser(&cmd, flags.Default)
+	_, err := p.ParseArgs([]string{"--site=remark", "--admin-passwd=secret"})
+	require.NoError(t, err)
+	err = cmd.Execute(nil)
+	assert.EqualError(t, err, `error response "500 Internal Server Error", some error`)
+}
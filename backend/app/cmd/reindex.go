@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+)
+
+// ReindexCommand set of flags and command for rebuild of full-text search index.
+// Index owned by running server, so the command only asks the server to rebuild it.
+type ReindexCommand struct {
+	Site        string        `short:"s" long:"site" env:"SITE" default:"remark" description:"site name"`
+	AdminPasswd string        `long:"admin-passwd" env:"ADMIN_PASSWD" required:"true" description:"admin basic auth password"`
+	Timeout     time.Duration `long:"timeout" default:"15m" description:"reindex timeout"`
+	CommonOpts
+}
+
+// Execute runs search index rebuild with ReindexCommand parameters, entry point for "reindex" command
+func (rc *ReindexCommand) Execute(_ []string) error {
+	log.Printf("[INFO] start reindex, site %s", rc.Site)
+	resetEnv("SECRET", "ADMIN_PASSWD")
+
+	client := http.Client{}
+	ctx, cancel := context.WithTimeout(context.Background(), rc.Timeout)
+	defer cancel()
+	reindexURL := fmt.Sprintf("%s/api/v1/admin/search/rebuild?site=%s", rc.RemarkURL, rc.Site)
+	req, err := http.NewRequest(http.MethodPost, reindexURL, nil)
+	if err != nil {
+		return errors.Wrapf(err, "can't make reindex request for %s", reindexURL)
+	}
+	req.SetBasicAuth("admin", rc.AdminPasswd)
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "request failed for %s", reindexURL)
+	}
+	defer func() {
+		if err = resp.Body.Close(); err != nil {
+			log.Printf("[WARN] failed to close response, %s", err)
+		}
+	}()
+	if resp.StatusCode >= 300 {
+		return responseError(resp)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "can't get response")
+	}
+
+	log.Printf("[INFO] completed, status=%d, %s", resp.StatusCode, string(body))
+	return nil
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/umputun/go-flags"
+)
+
+func TestReindex_Execute(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/admin/search/rebuild", r.URL.Path)
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "remark", r.URL.Query().Get("site"))
+		user, passwd, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "admin", user)
+		assert.Equal(t, "secret", passwd)
+		_, _ = w.Write([]byte(`{"indexed":10,"site":"remark"}`))
+	}))
+	defer ts.Close()
+
+	cmd := ReindexCommand{}
+	cmd.SetCommon(CommonOpts{RemarkURL: ts.URL, SharedSecret: "123456"})
+	p := flags.NewParser(&cmd, flags.Default)
+	_, err := p.ParseArgs([]string{"--site=remark", "--admin-passwd=secret"})
+	require.NoError(t, err)
+	assert.NoError(t, cmd.Execute(nil))
+}
+
+func TestReindex_ExecuteFailed(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"search disabled"}`))
+	}))
+	defer ts.Close()
+
+	cmd := ReindexCommand{}
+	cmd.SetCommon(CommonOpts{RemarkURL: ts.URL, SharedSecret: "123456"})
+	p := flags.NewParser(&cmd, flags.Default)
+	_, err := p.ParseArgs([]string{"--site=remark", "--admin-passwd=secret"})
+	require.NoError(t, err)
+	assert.Error(t, cmd.Execute(nil))
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/rest/api"
+)
+
+// RenotifyCommand set of flags and command for re-sending notifications about comments of the period,
+// i.e. after email server outage. Notifications sent by running server, the command starts the job and reports
+// its progress till completed.
+type RenotifyCommand struct {
+	Site        string        `short:"s" long:"site" env:"SITE" default:"remark" description:"site name"`
+	From        string        `long:"from" required:"true" description:"start of the period, RFC3339"`
+	To          string        `long:"to" description:"end of the period, RFC3339, now if not set"`
+	Rate        int           `long:"rate" default:"60" description:"notifications per minute"`
+	Force       bool          `long:"force" description:"send comments sent by previous re-notifications again"`
+	Poll        time.Duration `long:"poll" default:"5s" description:"progress check interval"`
+	Timeout     time.Duration `long:"timeout" default:"24h" description:"re-notification timeout, job stopped on timeout"`
+	AdminPasswd string        `long:"admin-passwd" env:"ADMIN_PASSWD" required:"true" description:"admin basic auth password"`
+	CommonOpts
+}
+
+// Execute runs re-notification with RenotifyCommand parameters, entry point for "renotify" command.
+// It fails if notifications about some comments not sent.
+func (rc *RenotifyCommand) Execute(_ []string) error {
+	log.Printf("[INFO] start re-notification, site %s, from %s to %s, rate %d/min", rc.Site, rc.From, rc.To, rc.Rate)
+	resetEnv("SECRET", "ADMIN_PASSWD")
+
+	ctx, cancel := context.WithTimeout(context.Background(), rc.Timeout)
+	defer cancel()
+	query := url.Values{"site": {rc.Site}, "from": {rc.From}, "rate": {fmt.Sprintf("%d", rc.Rate)}}
+	if rc.To != "" {
+		query.Set("to", rc.To)
+	}
+	if rc.Force {
+		query.Set("force", "1")
+	}
+	started := struct {
+		Comments int `json:"comments"`
+		Skipped  int `json:"skipped"`
+	}{}
+	if err := rc.request(ctx, http.MethodPost, query, &started); err != nil {
+		return errors.Wrap(err, "can't start re-notification")
+	}
+	log.Printf("[INFO] re-notification started, %d comments, %d skipped", started.Comments, started.Skipped)
+
+	ticker := time.NewTicker(rc.Poll)
+	defer ticker.Stop()
+	status := api.RenotifyStatus{}
+	for {
+		select {
+		case <-ctx.Done():
+			if err := rc.request(context.Background(), http.MethodDelete, url.Values{"site": {rc.Site}}, nil); err != nil {
+				log.Printf("[WARN] can't stop re-notification, %v", err)
+			}
+			return errors.Errorf("re-notification timed out, %d of %d comments sent", status.Sent, status.Total)
+		case <-ticker.C:
+		}
+		if err := rc.request(ctx, http.MethodGet, url.Values{"site": {rc.Site}}, &status); err != nil {
+			log.Printf("[WARN] can't get progress of re-notification, %v", err)
+			continue
+		}
+		log.Printf("[INFO] progress, %d of %d comments sent, %d skipped, %d failed, %d retries", status.Sent,
+			status.Total, status.Skipped, len(status.Failed), status.Retries)
+		if !status.Running {
+			break
+		}
+	}
+
+	for _, f := range status.Failed {
+		log.Printf("[WARN] failed comment %s, %s", f.CommentID, f.Error)
+	}
+	if status.Canceled {
+		return errors.New("re-notification stopped")
+	}
+	if len(status.Failed) > 0 {
+		return errors.Errorf("notifications about %d comments not sent", len(status.Failed))
+	}
+	log.Printf("[INFO] completed, %d comments sent, %d skipped", status.Sent, status.Skipped)
+	return nil
+}
+
+// request calls renotify api with method and decodes response to res if set
+func (rc *RenotifyCommand) request(ctx context.Context, method string, query url.Values, res interface{}) error {
+	renotifyURL := fmt.Sprintf("%s/api/v1/admin/renotify?%s", rc.RemarkURL, query.Encode())
+	req, err := http.NewRequest(method, renotifyURL, nil)
+	if err != nil {
+		return errors.Wrapf(err, "can't make renotify request for %s", renotifyURL)
+	}
+	req.SetBasicAuth("admin", rc.AdminPasswd)
+
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "request failed for %s", renotifyURL)
+	}
+	defer func() {
+		if err = resp.Body.Close(); err != nil {
+			log.Printf("[WARN] failed to close response, %s", err)
+		}
+	}()
+	if resp.StatusCode >= 300 {
+		return responseError(resp)
+	}
+	if res == nil {
+		return nil
+	}
+	return errors.Wrap(json.NewDecoder(resp.Body).Decode(res), "can't decode response")
+}
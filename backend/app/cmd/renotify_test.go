@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/umputun/go-flags"
+)
+
+func TestRenotify_Execute(t *testing.T) {
+	var polls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/admin/renotify", r.URL.Path)
+		assert.Equal(t, "remark", r.URL.Query().Get("site"))
+		user, passwd, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "admin", user)
+		assert.Equal(t, "secret", passwd)
+		switch r.Method {
+		case "POST":
+			assert.Equal(t, "2020-06-01T00:00:00Z", r.URL.Query().Get("from"))
+			assert.Equal(t, "120", r.URL.Query().Get("rate"))
+			assert.Equal(t, "1", r.URL.Query().Get("force"))
+			_, _ = w.Write([]byte(`{"comments":3,"rate":120,"site":"remark","skipped":0}`))
+		case "GET":
+			if atomic.AddInt32(&polls, 1) < 3 {
+				_, _ = w.Write([]byte(`{"site":"remark","running":true,"total":3,"sent":1,"failed":[]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"site":"remark","running":false,"total":3,"sent":3,"failed":[]}`))
+		default:
+			t.Errorf("unexpected method %s", r.Method)
+		}
+	}))
+	defer ts.Close()
+
+	cmd := RenotifyCommand{}
+	cmd.SetCommon(CommonOpts{RemarkURL: ts.URL, SharedSecret: "123456"})
+	p := flags.NewParser(&cmd, flags.Default)
+	_, err := p.ParseArgs([]string{"--site=remark", "--admin-passwd=secret", "--from=2020-06-01T00:00:00Z",
+		"--rate=120", "--force", "--poll=10ms"})
+	require.NoError(t, err)
+	assert.NoError(t, cmd.Execute(nil))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&polls))
+}
+
+func TestRenotify_ExecuteFailedComments(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			_, _ = w.Write([]byte(`{"comments":2,"rate":60,"site":"remark","skipped":0}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"site":"remark","running":false,"total":2,"sent":1,"failed":[{"id":"c1","error":"bad"}]}`))
+	}))
+	defer ts.Close()
+
+	cmd := RenotifyCommand{}
+	cmd.SetCommon(CommonOpts{RemarkURL: ts.URL, SharedSecret: "123456"})
+	p := flags.NewParser(&cmd, flags.Default)
+	_, err := p.ParseArgs([]string{"--site=remark", "--admin-passwd=secret", "--from=2020-06-01T00:00:00Z", "--poll=10ms"})
+	require.NoError(t, err)
+	assert.EqualError(t, cmd.Execute(nil), "notifications about 1 comments not sent")
+}
+
+func TestRenotify_ExecuteFailed(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte(`{"error":"re-notification for remark is in progress"}`))
+	}))
+	defer ts.Close()
+
+	cmd := RenotifyCommand{}
+	cmd.SetCommon(CommonOpts{RemarkURL: ts.URL, SharedSecret: "123456"})
+	p := flags.NewParser(&cmd, flags.Default)
+	_, err := p.ParseArgs([]string{"--site=remark", "--admin-passwd=secret", "--from=2020-06-01T00:00:00Z"})
+	require.NoError(t, err)
+	assert.Error(t, cmd.Execute(nil))
+}
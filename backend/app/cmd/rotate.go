@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+)
+
+// RotateJWTCommand set of flags and command for rotation of the key signing JWT.
+// Keys owned by running server, so the command only asks the server to rotate them.
+type RotateJWTCommand struct {
+	Site        string        `short:"s" long:"site" env:"SITE" default:"remark" description:"site name"`
+	Grace       time.Duration `long:"grace" description:"previous keys accepted for grace period, server default if not set"`
+	AdminPasswd string        `long:"admin-passwd" env:"ADMIN_PASSWD" required:"true" description:"admin basic auth password"`
+	Timeout     time.Duration `long:"timeout" default:"1m" description:"rotation timeout"`
+	CommonOpts
+}
+
+// Execute runs rotation of jwt key with RotateJWTCommand parameters, entry point for "rotate-jwt" command
+func (rc *RotateJWTCommand) Execute(_ []string) error {
+	log.Printf("[INFO] rotate jwt key")
+	resetEnv("SECRET", "ADMIN_PASSWD")
+
+	client := http.Client{}
+	ctx, cancel := context.WithTimeout(context.Background(), rc.Timeout)
+	defer cancel()
+	rotateURL := fmt.Sprintf("%s/api/v1/admin/jwt/rotate?site=%s", rc.RemarkURL, rc.Site)
+	if rc.Grace > 0 {
+		rotateURL += "&grace=" + rc.Grace.String()
+	}
+	req, err := http.NewRequest(http.MethodPost, rotateURL, nil)
+	if err != nil {
+		return errors.Wrapf(err, "can't make rotate request for %s", rotateURL)
+	}
+	req.SetBasicAuth("admin", rc.AdminPasswd)
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "request failed for %s", rotateURL)
+	}
+	defer func() {
+		if err = resp.Body.Close(); err != nil {
+			log.Printf("[WARN] failed to close response, %s", err)
+		}
+	}()
+	if resp.StatusCode >= 300 {
+		return responseError(resp)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "can't get response")
+	}
+
+	log.Printf("[INFO] completed, status=%d, %s", resp.StatusCode, string(body))
+	return nil
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/umputun/go-flags"
+)
+
+func TestRotateJWT_Execute(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/admin/jwt/rotate", r.URL.Path)
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "remark", r.URL.Query().Get("site"))
+		assert.Equal(t, "1h0m0s", r.URL.Query().Get("grace"))
+		user, passwd, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "admin", user)
+		assert.Equal(t, "secret", passwd)
+		_, _ = w.Write([]byte(`{"grace":"1h0m0s","id":"1a2b3c4d"}`))
+	}))
+	defer ts.Close()
+
+	cmd := RotateJWTCommand{}
+	cmd.SetCommon(CommonOpts{RemarkURL: ts.URL, SharedSecret: "123456"})
+	p := flags.NewParser(&cmd, flags.Default)
+	_, err := p.ParseArgs([]string{"--site=remark", "--admin-passwd=secret", "--grace=1h"})
+	require.NoError(t, err)
+	assert.NoError(t, cmd.Execute(nil))
+}
+
+func TestRotateJWT_ExecuteFailed(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error":"jwt keys rotation disabled"}`))
+	}))
+	defer ts.Close()
+
+	cmd := RotateJWTCommand{}
+	cmd.SetCommon(CommonOpts{RemarkURL: ts.URL, SharedSecret: "123456"})
+	p := flags.NewParser(&cmd, flags.Default)
+	_, err := p.ParseArgs([]string{"--site=remark", "--admin-passwd=secret"})
+	require.NoError(t, err)
+	assert.Error(t, cmd.Execute(nil))
+}
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/migrator"
+)
+
+// StaticCommand set of flags and command for rendering of backup to static html pages.
+// Works with backup file directly, without running server, so discussions stay browsable after its shutdown.
+type StaticCommand struct {
+	InputFile     string        `short:"f" long:"file" required:"true" description:"backup file, plain or gzipped"`
+	Site          string        `short:"s" long:"site" env:"SITE" default:"remark" description:"site name"`
+	Location      string        `short:"p" long:"path" default:"./var/static" description:"directory for html pages"`
+	Avatars       string        `long:"avatars" default:"link" choice:"link" choice:"inline" choice:"none" description:"avatars referenced, inlined or skipped"` //nolint
+	Template      string        `long:"template" default:"archive.html.tmpl" description:"template of post page"`
+	IndexTemplate string        `long:"index-template" default:"static_index.html.tmpl" description:"template of index page"`
+	Timeout       time.Duration `long:"timeout" default:"10s" description:"timeout of avatar loading"`
+	CommonOpts
+}
+
+// Execute renders static pages with StaticCommand parameters, entry point for "static" command
+func (sc *StaticCommand) Execute(_ []string) error {
+	log.Printf("[INFO] render %s to static pages in %s, site %s", sc.InputFile, sc.Location, sc.Site)
+	fh, err := os.Open(filepath.Clean(sc.InputFile))
+	if err != nil {
+		return errors.Wrapf(err, "can't open backup file %s", sc.InputFile)
+	}
+	defer func() {
+		if e := fh.Close(); e != nil {
+			log.Printf("[WARN] failed to close file %s, %s", fh.Name(), e)
+		}
+	}()
+
+	site := migrator.StaticSite{Location: sc.Location, TemplatePath: sc.Template, IndexTemplatePath: sc.IndexTemplate,
+		Avatars: migrator.AvatarMode(sc.Avatars), Client: http.Client{Timeout: sc.Timeout}}
+	res, err := site.Render(fh, sc.Site)
+	if err != nil {
+		return errors.Wrapf(err, "can't render %s", sc.InputFile)
+	}
+	log.Printf("[INFO] completed, %d posts with %d comments, index %s", res.Posts, res.Comments, res.IndexFile)
+	return nil
+}
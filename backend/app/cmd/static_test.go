@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/umputun/go-flags"
+)
+
+func TestStatic_Execute(t *testing.T) {
+	dir, err := ioutil.TempDir("", "static")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	backup := `{"version":1,"users":[],"posts":[]}
+{"id":"c1","pid":"","text":"<p>first comment</p>","user":{"name":"dev","id":"dev","picture":"","admin":false},"locator":{"site":"remark","url":"https://remark42.com/demo/"},"score":0,"vote":0,"time":"2020-05-01T10:00:00Z","title":"Demo"}
+{"id":"c2","pid":"c1","text":"<p>reply</p>","user":{"name":"other","id":"other","picture":"","admin":false},"locator":{"site":"remark","url":"https://remark42.com/demo/"},"score":0,"vote":0,"time":"2020-05-01T11:00:00Z"}
+`
+	require.NoError(t, ioutil.WriteFile(dir+"/backup.json", []byte(backup), 0600))
+
+	cmd := StaticCommand{}
+	cmd.SetCommon(CommonOpts{RemarkURL: "http://127.0.0.1", SharedSecret: "123456"})
+	p := flags.NewParser(&cmd, flags.Default)
+	_, err = p.ParseArgs([]string{"--site=remark", "--file=" + dir + "/backup.json", "--path=" + dir + "/static",
+		"--template=../../templates/archive.html.tmpl", "--index-template=../../templates/static_index.html.tmpl"})
+	require.NoError(t, err)
+	require.NoError(t, cmd.Execute(nil))
+
+	index, err := ioutil.ReadFile(dir + "/static/index.html")
+	require.NoError(t, err)
+	assert.Contains(t, string(index), ">Demo</a>")
+	assert.Contains(t, string(index), "2 comments")
+
+	cmd.InputFile = dir + "/bad.json"
+	assert.Error(t, cmd.Execute(nil), "no backup file")
+}
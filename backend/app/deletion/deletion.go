@@ -0,0 +1,130 @@
+// Package deletion implements self-service deletion of user accounts. Deletion confirmed by the user scheduled
+// after the grace period, user can cancel it till then. Scheduled deletions executed in background by Run.
+package deletion
+
+import (
+	"context"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+)
+
+// Request is a confirmed deletion of user's account on the site
+type Request struct {
+	SiteID    string    `json:"site"`
+	UserID    string    `json:"user_id"`
+	Picture   string    `json:"picture,omitempty"` // avatar url, removed with the account
+	Requested time.Time `json:"requested"`
+	Scheduled time.Time `json:"scheduled"` // deletion executed after this time
+}
+
+// Store defines interface to keep scheduled deletions
+type Store interface {
+	Get(siteID, userID string) (req Request, found bool, err error)
+	Set(req Request) error
+	Delete(siteID, userID string) error
+	List() ([]Request, error) // requests of all sites
+}
+
+// Params of the service
+type Params struct {
+	Grace    time.Duration       // period between confirmation and deletion, 7 days by default
+	Interval time.Duration       // interval of scheduled deletions check, 1 minute by default
+	Delete   func(Request) error // removes the account, kept scheduled and retried on error
+}
+
+// Service schedules and executes deletions
+type Service struct {
+	Params
+	store Store
+	now   func() time.Time
+}
+
+// NewService makes deletion service
+func NewService(st Store, params Params) *Service {
+	if params.Grace <= 0 {
+		params.Grace = 7 * 24 * time.Hour
+	}
+	if params.Interval <= 0 {
+		params.Interval = time.Minute
+	}
+	return &Service{Params: params, store: st, now: time.Now}
+}
+
+// Schedule deletion of the account after the grace period. Already scheduled deletion kept as is.
+func (s *Service) Schedule(req Request) (Request, error) {
+	if req.SiteID == "" || req.UserID == "" {
+		return Request{}, errors.New("site and user are required")
+	}
+	cur, found, err := s.store.Get(req.SiteID, req.UserID)
+	if err != nil {
+		return Request{}, err
+	}
+	if found {
+		return cur, nil
+	}
+	req.Requested = s.now()
+	req.Scheduled = req.Requested.Add(s.Grace)
+	if err = s.store.Set(req); err != nil {
+		return Request{}, err
+	}
+	log.Printf("[INFO] audit: deletion of %s on %s scheduled at %s", req.UserID, req.SiteID, req.Scheduled.Format(time.RFC3339))
+	return req, nil
+}
+
+// Status returns scheduled deletion of the account, found false if not scheduled
+func (s *Service) Status(siteID, userID string) (Request, bool, error) {
+	return s.store.Get(siteID, userID)
+}
+
+// Cancel scheduled deletion of the account, returns false if nothing scheduled
+func (s *Service) Cancel(siteID, userID string) (bool, error) {
+	_, found, err := s.store.Get(siteID, userID)
+	if err != nil || !found {
+		return false, err
+	}
+	if err = s.store.Delete(siteID, userID); err != nil {
+		return false, err
+	}
+	log.Printf("[INFO] audit: deletion of %s on %s canceled", userID, siteID)
+	return true, nil
+}
+
+// Run executes scheduled deletions periodically, blocking
+func (s *Service) Run(ctx context.Context) {
+	log.Printf("[INFO] account deletions with grace period %v", s.Grace)
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		s.execute()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// execute deletions scheduled before now
+func (s *Service) execute() {
+	reqs, err := s.store.List()
+	if err != nil {
+		log.Printf("[WARN] can't list scheduled deletions, %v", err)
+		return
+	}
+	for _, req := range reqs {
+		if s.now().Before(req.Scheduled) {
+			continue
+		}
+		if err = s.Delete(req); err != nil {
+			log.Printf("[WARN] can't delete %s on %s, %v", req.UserID, req.SiteID, err)
+			continue
+		}
+		if err = s.store.Delete(req.SiteID, req.UserID); err != nil {
+			log.Printf("[WARN] can't remove executed deletion of %s on %s, %v", req.UserID, req.SiteID, err)
+			continue
+		}
+		log.Printf("[INFO] audit: account %s on %s deleted by user's request", req.UserID, req.SiteID)
+	}
+}
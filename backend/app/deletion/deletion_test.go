@@ -0,0 +1,76 @@
+package deletion
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+func TestService_ScheduleAndExecute(t *testing.T) {
+	st := prepStore(t)
+
+	deleted := []string{}
+	fail := true
+	svc := NewService(st, Params{Grace: time.Hour, Delete: func(req Request) error {
+		if req.UserID == "user2" && fail {
+			return errors.New("failed")
+		}
+		deleted = append(deleted, req.SiteID+"/"+req.UserID)
+		return nil
+	}})
+	ts := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	svc.now = func() time.Time { return ts }
+
+	_, err := svc.Schedule(Request{SiteID: "site1"})
+	assert.Error(t, err)
+	req, err := svc.Schedule(Request{SiteID: "site1", UserID: "user1", Picture: "http://example.com/pic.png"})
+	require.NoError(t, err)
+	assert.Equal(t, Request{SiteID: "site1", UserID: "user1", Picture: "http://example.com/pic.png", Requested: ts,
+		Scheduled: ts.Add(time.Hour)}, req)
+	ts = ts.Add(time.Minute)
+	req2, err := svc.Schedule(Request{SiteID: "site1", UserID: "user1"})
+	require.NoError(t, err)
+	assert.Equal(t, req, req2, "scheduled already")
+	_, err = svc.Schedule(Request{SiteID: "site1", UserID: "user2"})
+	require.NoError(t, err)
+	_, err = svc.Schedule(Request{SiteID: "site2", UserID: "user1"})
+	require.NoError(t, err)
+
+	canceled, err := svc.Cancel("site2", "user1")
+	require.NoError(t, err)
+	assert.True(t, canceled)
+	canceled, err = svc.Cancel("site2", "user1")
+	require.NoError(t, err)
+	assert.False(t, canceled)
+
+	svc.execute()
+	assert.Equal(t, 0, len(deleted), "grace period")
+
+	ts = ts.Add(time.Hour)
+	svc.execute()
+	assert.Equal(t, []string{"site1/user1"}, deleted)
+	_, found, err := svc.Status("site1", "user1")
+	require.NoError(t, err)
+	assert.False(t, found, "executed")
+	_, found, err = svc.Status("site1", "user2")
+	require.NoError(t, err)
+	assert.True(t, found, "kept on error")
+
+	fail = false
+	svc.execute()
+	assert.Equal(t, []string{"site1/user1", "site1/user2"}, deleted)
+	reqs, err := st.List()
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(reqs))
+}
+
+func prepStore(t *testing.T) *EngineStore {
+	eng, err := engine.NewMemory("", "site1", "site2")
+	require.NoError(t, err)
+	return NewEngineStore(eng, func() []string { return []string{"site1", "site2"} })
+}
@@ -0,0 +1,55 @@
+package deletion
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// EngineStore implements Store with records of store engine, deletions kept along with comments of their site.
+// Deletions executed for all sites, so listed in all sites.
+type EngineStore struct {
+	records engine.Records // keyed by userID
+	sites   func() []string
+}
+
+// NewEngineStore makes store of scheduled deletions kept by eng for sites
+func NewEngineStore(eng engine.Interface, sites func() []string) *EngineStore {
+	return &EngineStore{records: engine.Records{Engine: eng, Kind: engine.Deletions}, sites: sites}
+}
+
+// Get scheduled deletion of the user
+func (e *EngineStore) Get(siteID, userID string) (req Request, found bool, err error) {
+	found, err = e.records.Get(siteID, userID, &req)
+	return req, found, err
+}
+
+// Set scheduled deletion of the user
+func (e *EngineStore) Set(req Request) error {
+	return errors.Wrapf(e.records.Set(req.SiteID, req.UserID, req), "can't put deletion of %s", req.UserID)
+}
+
+// Delete scheduled deletion of the user, missing one ignored
+func (e *EngineStore) Delete(siteID, userID string) error {
+	_, err := e.records.Delete(siteID, userID)
+	return errors.Wrapf(err, "can't delete deletion of %s", userID)
+}
+
+// List scheduled deletions of all sites
+func (e *EngineStore) List() (res []Request, err error) {
+	res = []Request{}
+	for _, siteID := range e.sites() {
+		err = e.records.List(siteID, "", func(_ string, unmarshal func(v interface{}) error) error {
+			req := Request{}
+			if err := unmarshal(&req); err != nil {
+				return err
+			}
+			res = append(res, req)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
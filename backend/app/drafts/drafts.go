@@ -0,0 +1,137 @@
+// Package drafts keeps in-progress comments of users server-side, one per post, so a comment started on one device
+// can be finished on another. Draft removed when the comment posted, drafts not updated for TTL removed by Run.
+package drafts
+
+import (
+	"context"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+)
+
+// Draft is an in-progress comment of the user to the post
+type Draft struct {
+	SiteID   string    `json:"site"`
+	UserID   string    `json:"user_id"`
+	URL      string    `json:"url"`
+	ParentID string    `json:"pid,omitempty"` // comment the draft replies to
+	Text     string    `json:"text"`
+	Updated  time.Time `json:"updated"`
+}
+
+// Store defines interface to keep drafts
+type Store interface {
+	Get(siteID, userID, url string) (d Draft, found bool, err error)
+	Set(d Draft) error
+	Delete(siteID, userID, url string) error // missing draft ignored
+	List(siteID string) ([]Draft, error)     // drafts of the site
+}
+
+// Params of the service
+type Params struct {
+	TTL      time.Duration   // drafts not updated for TTL removed, 30 days by default
+	MaxSize  int             // max size of draft text, 8K by default
+	Interval time.Duration   // interval of expired drafts cleanup, 1 hour by default
+	Sites    func() []string // sites of expired drafts cleanup
+}
+
+// Service saves, loads and expires drafts
+type Service struct {
+	Params
+	store Store
+	now   func() time.Time
+}
+
+// NewService makes drafts service
+func NewService(st Store, params Params) *Service {
+	if params.TTL <= 0 {
+		params.TTL = 30 * 24 * time.Hour
+	}
+	if params.MaxSize <= 0 {
+		params.MaxSize = 8 * 1024
+	}
+	if params.Interval <= 0 {
+		params.Interval = time.Hour
+	}
+	return &Service{Params: params, store: st, now: time.Now}
+}
+
+// Save the draft, replaces previous draft of the user to the same post. Returns saved draft with update time set.
+func (s *Service) Save(d Draft) (Draft, error) {
+	if d.SiteID == "" || d.UserID == "" || d.URL == "" {
+		return Draft{}, errors.New("site, user and url are required")
+	}
+	if len(d.Text) > s.MaxSize {
+		return Draft{}, errors.Errorf("draft is too long, %d > %d", len(d.Text), s.MaxSize)
+	}
+	d.Updated = s.now()
+	if err := s.store.Set(d); err != nil {
+		return Draft{}, err
+	}
+	return d, nil
+}
+
+// Get the draft of the user to the post, found false if not saved or expired
+func (s *Service) Get(siteID, userID, url string) (Draft, bool, error) {
+	d, found, err := s.store.Get(siteID, userID, url)
+	if err != nil || !found {
+		return Draft{}, false, err
+	}
+	if s.expired(d) {
+		return Draft{}, false, nil
+	}
+	return d, true, nil
+}
+
+// Delete the draft of the user to the post, i.e. on posting the comment
+func (s *Service) Delete(siteID, userID, url string) error {
+	return s.store.Delete(siteID, userID, url)
+}
+
+// Run removes expired drafts periodically, blocking
+func (s *Service) Run(ctx context.Context) {
+	log.Printf("[INFO] drafts with ttl %v", s.TTL)
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		s.cleanup()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// cleanup removes drafts not updated for TTL
+func (s *Service) cleanup() {
+	if s.Sites == nil {
+		return
+	}
+	count := 0
+	for _, siteID := range s.Sites() {
+		list, err := s.store.List(siteID)
+		if err != nil {
+			log.Printf("[WARN] can't list drafts of %s, %v", siteID, err)
+			continue
+		}
+		for _, d := range list {
+			if !s.expired(d) {
+				continue
+			}
+			if err = s.store.Delete(d.SiteID, d.UserID, d.URL); err != nil {
+				log.Printf("[WARN] can't remove expired draft of %s on %s, %v", d.UserID, d.SiteID, err)
+				continue
+			}
+			count++
+		}
+	}
+	if count > 0 {
+		log.Printf("[DEBUG] removed %d expired drafts", count)
+	}
+}
+
+func (s *Service) expired(d Draft) bool {
+	return s.now().Sub(d.Updated) > s.TTL
+}
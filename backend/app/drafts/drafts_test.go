@@ -0,0 +1,65 @@
+package drafts
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+func TestService_SaveAndExpire(t *testing.T) {
+	eng, err := engine.NewMemory("", "site1", "site2")
+	require.NoError(t, err)
+	st := NewEngineStore(eng)
+
+	svc := NewService(st, Params{TTL: time.Hour, MaxSize: 10, Sites: func() []string { return []string{"site1", "site2"} }})
+	ts := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	svc.now = func() time.Time { return ts }
+
+	_, err = svc.Save(Draft{SiteID: "site1", UserID: "user1"})
+	assert.EqualError(t, err, "site, user and url are required")
+	_, err = svc.Save(Draft{SiteID: "site1", UserID: "user1", URL: "https://example.com/1", Text: strings.Repeat("x", 11)})
+	assert.EqualError(t, err, "draft is too long, 11 > 10")
+
+	d, err := svc.Save(Draft{SiteID: "site1", UserID: "user1", URL: "https://example.com/1", Text: "draft", ParentID: "p1"})
+	require.NoError(t, err)
+	assert.Equal(t, Draft{SiteID: "site1", UserID: "user1", URL: "https://example.com/1", Text: "draft", ParentID: "p1",
+		Updated: ts}, d)
+	_, err = svc.Save(Draft{SiteID: "site1", UserID: "user2", URL: "https://example.com/1", Text: "other"})
+	require.NoError(t, err)
+	_, err = svc.Save(Draft{SiteID: "site2", UserID: "user1", URL: "https://example.com/1", Text: "other site"})
+	require.NoError(t, err)
+
+	ts = ts.Add(30 * time.Minute)
+	d, err = svc.Save(Draft{SiteID: "site1", UserID: "user1", URL: "https://example.com/1", Text: "draft 2"})
+	require.NoError(t, err)
+	res, found, err := svc.Get("site1", "user1", "https://example.com/1")
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, d, res, "replaced")
+	_, found, err = svc.Get("site1", "user1", "https://example.com/2")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	ts = ts.Add(45 * time.Minute)
+	_, found, err = svc.Get("site1", "user2", "https://example.com/1")
+	require.NoError(t, err)
+	assert.False(t, found, "expired")
+	svc.cleanup()
+	list, err := st.List("site1")
+	require.NoError(t, err)
+	assert.Equal(t, []Draft{d}, list, "expired removed")
+	list, err = st.List("site2")
+	require.NoError(t, err)
+	assert.Equal(t, []Draft{}, list, "expired removed on all sites")
+
+	require.NoError(t, svc.Delete("site1", "user1", "https://example.com/1"))
+	_, found, err = svc.Get("site1", "user1", "https://example.com/1")
+	require.NoError(t, err)
+	assert.False(t, found, "deleted")
+	assert.NoError(t, svc.Delete("site1", "user1", "https://example.com/1"), "missing ignored")
+}
@@ -0,0 +1,50 @@
+package drafts
+
+import (
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// EngineStore implements Store with records of store engine, drafts kept along with comments of the site
+type EngineStore struct {
+	records engine.Records // keyed by userID!!url
+}
+
+// NewEngineStore makes store of drafts kept by eng
+func NewEngineStore(eng engine.Interface) *EngineStore {
+	return &EngineStore{records: engine.Records{Engine: eng, Kind: engine.Drafts}}
+}
+
+// Get draft of the user to the post
+func (e *EngineStore) Get(siteID, userID, url string) (d Draft, found bool, err error) {
+	found, err = e.records.Get(siteID, draftKey(userID, url), &d)
+	return d, found, err
+}
+
+// Set draft of the user to the post
+func (e *EngineStore) Set(d Draft) error {
+	return e.records.Set(d.SiteID, draftKey(d.UserID, d.URL), d)
+}
+
+// Delete draft of the user to the post, missing one ignored
+func (e *EngineStore) Delete(siteID, userID, url string) error {
+	_, err := e.records.Delete(siteID, draftKey(userID, url))
+	return err
+}
+
+// List all drafts of the site
+func (e *EngineStore) List(siteID string) (res []Draft, err error) {
+	res = []Draft{}
+	err = e.records.List(siteID, "", func(_ string, unmarshal func(v interface{}) error) error {
+		d := Draft{}
+		if err := unmarshal(&d); err != nil {
+			return err
+		}
+		res = append(res, d)
+		return nil
+	})
+	return res, err
+}
+
+func draftKey(userID, url string) string {
+	return userID + "!!" + url
+}
@@ -0,0 +1,195 @@
+// Package fingerprint keeps salted hashes of ip and user-agent of commenters, recorded on comment creation and
+// stored separately from comments. Raw values never stored. Hashes used by admins to find comments made from the
+// same address or browser, i.e. by a blocked user under a new account, and to block new comments with these hashes.
+package fingerprint
+
+import (
+	"context"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// Record is a fingerprint of a single comment
+type Record struct {
+	SiteID    string    `json:"site"`
+	URL       string    `json:"url"`
+	CommentID string    `json:"comment_id"`
+	UserID    string    `json:"user_id"`
+	IP        string    `json:"ip,omitempty"`    // hashed ip of the author
+	Agent     string    `json:"agent,omitempty"` // hashed user-agent of the author
+	Timestamp time.Time `json:"time"`
+}
+
+// Block of comments with the hash
+type Block struct {
+	Hash      string     `json:"hash"`
+	Reason    string     `json:"reason,omitempty"`
+	Timestamp time.Time  `json:"time"`
+	Until     *time.Time `json:"until,omitempty"` // permanent block if not set
+}
+
+func (b Block) active(now time.Time) bool {
+	return b.Until == nil || b.Until.After(now)
+}
+
+// Store defines interface to keep fingerprints and blocks
+type Store interface {
+	Add(rec Record) error
+	Get(siteID, commentID string) (Record, error)
+	Find(siteID, hash string) ([]Record, error) // records with ip or agent hash, ordered by time
+	Cleanup(before time.Time) (int, error)      // removes records made before the time
+	SetBlock(siteID string, block Block) error
+	RemoveBlock(siteID, hash string) error
+	Blocks(siteID string) ([]Block, error)
+}
+
+// Params of service
+type Params struct {
+	Salt string        // salt of hashes
+	TTL  time.Duration // records kept for ttl, forever if not set
+}
+
+// Service records fingerprints of comments and checks blocked ones
+type Service struct {
+	Params
+	store Store
+}
+
+// NewService makes fingerprints service with the store
+func NewService(st Store, params Params) *Service {
+	return &Service{Params: params, store: st}
+}
+
+// Hashes returns salted hashes of ip and user-agent, empty values not hashed
+func (s *Service) Hashes(ip, agent string) (ipHash, agentHash string) {
+	if ip != "" {
+		ipHash = store.HashValue(ip, s.Salt)
+	}
+	if agent != "" {
+		agentHash = store.HashValue(agent, s.Salt)
+	}
+	return ipHash, agentHash
+}
+
+// Record fingerprint of the created comment. Does nothing for nil service.
+func (s *Service) Record(comment store.Comment, ip, agent string) {
+	if s == nil {
+		return
+	}
+	rec := Record{SiteID: comment.Locator.SiteID, URL: comment.Locator.URL, CommentID: comment.ID,
+		UserID: comment.User.ID, Timestamp: time.Now()}
+	rec.IP, rec.Agent = s.Hashes(ip, agent)
+	if err := s.store.Add(rec); err != nil {
+		log.Printf("[WARN] can't record fingerprint of %s, %v", comment.ID, err)
+	}
+}
+
+// Blocked checks if hash of ip or user-agent blocked on the site. Always false for nil service.
+func (s *Service) Blocked(siteID, ip, agent string) bool {
+	if s == nil {
+		return false
+	}
+	blocks, err := s.store.Blocks(siteID)
+	if err != nil {
+		log.Printf("[WARN] can't get blocked fingerprints of %s, %v", siteID, err)
+		return false
+	}
+	ipHash, agentHash := s.Hashes(ip, agent)
+	now := time.Now()
+	for _, b := range blocks {
+		if b.active(now) && (b.Hash == ipHash || b.Hash == agentHash) {
+			return true
+		}
+	}
+	return false
+}
+
+// Get fingerprint of the comment
+func (s *Service) Get(siteID, commentID string) (Record, error) {
+	return s.store.Get(siteID, commentID)
+}
+
+// Related returns records of other comments with the same ip or user-agent hash as the record
+func (s *Service) Related(rec Record) ([]Record, error) {
+	res := []Record{}
+	seen := map[string]bool{rec.CommentID: true}
+	for _, hash := range []string{rec.IP, rec.Agent} {
+		if hash == "" {
+			continue
+		}
+		found, err := s.store.Find(rec.SiteID, hash)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range found {
+			if !seen[r.CommentID] {
+				seen[r.CommentID] = true
+				res = append(res, r)
+			}
+		}
+	}
+	return res, nil
+}
+
+// Find records with ip or user-agent hash
+func (s *Service) Find(siteID, hash string) ([]Record, error) {
+	return s.store.Find(siteID, hash)
+}
+
+// SetBlock blocks new comments with the hash for ttl, permanently with zero ttl
+func (s *Service) SetBlock(siteID, hash, reason string, ttl time.Duration) error {
+	block := Block{Hash: hash, Reason: reason, Timestamp: time.Now()}
+	if ttl > 0 {
+		until := block.Timestamp.Add(ttl)
+		block.Until = &until
+	}
+	return s.store.SetBlock(siteID, block)
+}
+
+// RemoveBlock unblocks the hash
+func (s *Service) RemoveBlock(siteID, hash string) error {
+	return s.store.RemoveBlock(siteID, hash)
+}
+
+// Blocks returns active blocks of the site
+func (s *Service) Blocks(siteID string) ([]Block, error) {
+	blocks, err := s.store.Blocks(siteID)
+	if err != nil {
+		return nil, err
+	}
+	res := []Block{}
+	now := time.Now()
+	for _, b := range blocks {
+		if b.active(now) {
+			res = append(res, b)
+		}
+	}
+	return res, nil
+}
+
+// Run removes records older than ttl periodically till context canceled, does nothing without ttl
+func (s *Service) Run(ctx context.Context, interval time.Duration) {
+	if s.TTL <= 0 {
+		return
+	}
+	log.Printf("[INFO] fingerprints kept for %v", s.TTL)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		count, err := s.store.Cleanup(time.Now().Add(-s.TTL))
+		if err != nil {
+			log.Printf("[WARN] can't cleanup fingerprints, %v", err)
+		}
+		if count > 0 {
+			log.Printf("[DEBUG] removed %d expired fingerprints", count)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
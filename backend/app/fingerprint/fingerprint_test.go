@@ -0,0 +1,125 @@
+package fingerprint
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+func TestService_RecordAndRelated(t *testing.T) {
+	st := prepStore(t)
+	svc := NewService(st, Params{Salt: "salt"})
+
+	locator := store.Locator{SiteID: "site1", URL: "https://example.com/1"}
+	svc.Record(store.Comment{ID: "c1", Locator: locator, User: store.User{ID: "user1"}}, "10.0.0.1", "firefox")
+	svc.Record(store.Comment{ID: "c2", Locator: locator, User: store.User{ID: "user2"}}, "10.0.0.1", "chrome")
+	svc.Record(store.Comment{ID: "c3", Locator: locator, User: store.User{ID: "user3"}}, "10.0.0.2", "firefox")
+	svc.Record(store.Comment{ID: "c4", Locator: locator, User: store.User{ID: "user4"}}, "10.0.0.3", "safari")
+	svc.Record(store.Comment{ID: "c5", Locator: store.Locator{SiteID: "site2", URL: "https://example.com/1"}}, "10.0.0.1", "firefox")
+
+	rec, err := svc.Get("site1", "c1")
+	require.NoError(t, err)
+	assert.Equal(t, "user1", rec.UserID)
+	assert.Equal(t, store.HashValue("10.0.0.1", "salt"), rec.IP)
+	assert.Equal(t, store.HashValue("firefox", "salt"), rec.Agent)
+	assert.NotContains(t, rec.IP+rec.Agent, "10.0.0.1", "raw values not kept")
+
+	related, err := svc.Related(rec)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(related))
+	assert.Equal(t, "c2", related[0].CommentID, "same ip")
+	assert.Equal(t, "c3", related[1].CommentID, "same agent")
+
+	found, err := svc.Find("site1", rec.IP)
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(found))
+
+	_, err = svc.Get("site1", "c5")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestService_Blocked(t *testing.T) {
+	st := prepStore(t)
+	svc := NewService(st, Params{Salt: "salt"})
+
+	ipHash, agentHash := svc.Hashes("10.0.0.1", "firefox")
+	assert.False(t, svc.Blocked("site1", "10.0.0.1", "chrome"))
+
+	require.NoError(t, svc.SetBlock("site1", ipHash, "ban evasion", 0))
+	assert.True(t, svc.Blocked("site1", "10.0.0.1", "chrome"))
+	assert.False(t, svc.Blocked("site1", "10.0.0.2", "firefox"))
+	assert.False(t, svc.Blocked("site2", "10.0.0.1", "chrome"), "other site")
+
+	require.NoError(t, svc.SetBlock("site1", agentHash, "", time.Hour))
+	assert.True(t, svc.Blocked("site1", "10.0.0.2", "firefox"))
+	blocks, err := svc.Blocks("site1")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(blocks))
+
+	require.NoError(t, svc.SetBlock("site1", agentHash, "", time.Nanosecond))
+	time.Sleep(time.Millisecond)
+	assert.False(t, svc.Blocked("site1", "10.0.0.2", "firefox"), "expired")
+	blocks, err = svc.Blocks("site1")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(blocks))
+	assert.Equal(t, "ban evasion", blocks[0].Reason)
+
+	require.NoError(t, svc.RemoveBlock("site1", ipHash))
+	assert.False(t, svc.Blocked("site1", "10.0.0.1", "chrome"))
+}
+
+func TestService_Run(t *testing.T) {
+	st := prepStore(t)
+	svc := NewService(st, Params{Salt: "salt", TTL: time.Hour})
+
+	require.NoError(t, st.Add(Record{SiteID: "site1", CommentID: "old", IP: "ip1", Timestamp: time.Now().Add(-2 * time.Hour)}))
+	svc.Record(store.Comment{ID: "c1", Locator: store.Locator{SiteID: "site1"}}, "10.0.0.1", "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	svc.Run(ctx, 10*time.Millisecond)
+	_, err := svc.Get("site1", "old")
+	assert.Equal(t, ErrNotFound, err)
+	found, err := svc.Find("site1", "ip1")
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(found), "index cleaned")
+	_, err = svc.Get("site1", "c1")
+	assert.NoError(t, err)
+}
+
+func TestService_Nil(t *testing.T) {
+	var svc *Service
+	svc.Record(store.Comment{ID: "c1"}, "10.0.0.1", "firefox")
+	assert.False(t, svc.Blocked("site1", "10.0.0.1", "firefox"))
+}
+
+func TestEngineStore_Replace(t *testing.T) {
+	st := prepStore(t)
+
+	ts := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	require.NoError(t, st.Add(Record{SiteID: "site1", CommentID: "c1", IP: "ip1", Agent: "a1", Timestamp: ts}))
+	require.NoError(t, st.Add(Record{SiteID: "site1", CommentID: "c1", IP: "ip2", Timestamp: ts.Add(time.Second)}))
+
+	found, err := st.Find("site1", "ip1")
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(found), "previous index removed")
+	found, err = st.Find("site1", "ip2")
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(found))
+
+	n, err := st.Cleanup(ts.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
+
+func prepStore(t *testing.T) *EngineStore {
+	eng, err := engine.NewMemory("", "site1", "site2")
+	require.NoError(t, err)
+	return NewEngineStore(eng, func() []string { return []string{"site1", "site2"} })
+}
@@ -0,0 +1,158 @@
+package fingerprint
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// keyTimeFormat is sortable fixed-width format of time in keys
+const keyTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+// ErrNotFound returned for comments without recorded fingerprint
+var ErrNotFound = errors.New("fingerprint not found")
+
+// EngineStore implements Store with records of store engine, fingerprints kept along with comments of their site.
+// Records cleaned up in all sites.
+type EngineStore struct {
+	records engine.Records // keyed by commentID
+	hashes  engine.Records // index keyed by hash!!time!!commentID
+	blocks  engine.Records // keyed by hash
+	sites   func() []string
+}
+
+// NewEngineStore makes store of fingerprints kept by eng for sites
+func NewEngineStore(eng engine.Interface, sites func() []string) *EngineStore {
+	return &EngineStore{
+		records: engine.Records{Engine: eng, Kind: engine.Fingerprints},
+		hashes:  engine.Records{Engine: eng, Kind: engine.FingerprintHashes},
+		blocks:  engine.Records{Engine: eng, Kind: engine.FingerprintBlocks},
+		sites:   sites,
+	}
+}
+
+// Add record of the comment, replaces the previous one
+func (e *EngineStore) Add(rec Record) error {
+	prev := Record{}
+	found, err := e.records.Get(rec.SiteID, rec.CommentID, &prev)
+	if err != nil {
+		return errors.Wrapf(err, "can't get fingerprint of %s", rec.CommentID)
+	}
+	if found {
+		if err = e.delete(prev); err != nil {
+			return err
+		}
+	}
+	if err = e.records.Set(rec.SiteID, rec.CommentID, rec); err != nil {
+		return errors.Wrapf(err, "can't put fingerprint of %s", rec.CommentID)
+	}
+	for _, k := range hashKeys(rec) {
+		if err = e.hashes.Set(rec.SiteID, k, rec.CommentID); err != nil {
+			return errors.Wrapf(err, "can't index fingerprint of %s", rec.CommentID)
+		}
+	}
+	return nil
+}
+
+// Get record of the comment, ErrNotFound if not recorded
+func (e *EngineStore) Get(siteID, commentID string) (res Record, err error) {
+	found, err := e.records.Get(siteID, commentID, &res)
+	if err != nil {
+		return Record{}, err
+	}
+	if !found {
+		return Record{}, ErrNotFound
+	}
+	return res, nil
+}
+
+// Find records with ip or agent hash, ordered by time
+func (e *EngineStore) Find(siteID, hash string) ([]Record, error) {
+	res := []Record{}
+	err := e.hashes.List(siteID, hash+"!!", func(_ string, unmarshal func(v interface{}) error) error {
+		var commentID string
+		if err := unmarshal(&commentID); err != nil {
+			return err
+		}
+		rec := Record{}
+		found, err := e.records.Get(siteID, commentID, &rec)
+		if err != nil || !found {
+			return err
+		}
+		res = append(res, rec)
+		return nil
+	})
+	return res, errors.Wrap(err, "can't find fingerprints")
+}
+
+// Cleanup removes records of all sites made before the time, returns number of removed records
+func (e *EngineStore) Cleanup(before time.Time) (count int, err error) {
+	for _, siteID := range e.sites() {
+		expired := []Record{}
+		err = e.records.List(siteID, "", func(key string, unmarshal func(v interface{}) error) error {
+			rec := Record{}
+			if unmarshal(&rec) != nil || rec.Timestamp.Before(before) {
+				rec.SiteID, rec.CommentID = siteID, key
+				expired = append(expired, rec) // broken records removed too
+			}
+			return nil
+		})
+		if err != nil {
+			return count, errors.Wrap(err, "can't cleanup fingerprints")
+		}
+		for _, rec := range expired {
+			if err = e.delete(rec); err != nil {
+				return count, errors.Wrap(err, "can't cleanup fingerprints")
+			}
+			count++
+		}
+	}
+	return count, nil
+}
+
+// SetBlock adds or replaces block of the hash
+func (e *EngineStore) SetBlock(siteID string, block Block) error {
+	return errors.Wrapf(e.blocks.Set(siteID, block.Hash, block), "can't put block of %s", block.Hash)
+}
+
+// RemoveBlock of the hash, missing block ignored
+func (e *EngineStore) RemoveBlock(siteID, hash string) error {
+	_, err := e.blocks.Delete(siteID, hash)
+	return err
+}
+
+// Blocks of the site, expired included
+func (e *EngineStore) Blocks(siteID string) ([]Block, error) {
+	res := []Block{}
+	err := e.blocks.List(siteID, "", func(_ string, unmarshal func(v interface{}) error) error {
+		block := Block{}
+		if err := unmarshal(&block); err != nil {
+			return err
+		}
+		res = append(res, block)
+		return nil
+	})
+	return res, errors.Wrap(err, "can't list blocks")
+}
+
+// delete removes record and its index entries
+func (e *EngineStore) delete(rec Record) error {
+	for _, k := range hashKeys(rec) {
+		if _, err := e.hashes.Delete(rec.SiteID, k); err != nil {
+			return errors.Wrapf(err, "can't delete index of %s", rec.CommentID)
+		}
+	}
+	_, err := e.records.Delete(rec.SiteID, rec.CommentID)
+	return errors.Wrapf(err, "can't delete fingerprint of %s", rec.CommentID)
+}
+
+func hashKeys(rec Record) (res []string) {
+	for _, hash := range []string{rec.IP, rec.Agent} {
+		if hash != "" {
+			res = append(res, hash+"!!"+rec.Timestamp.UTC().Format(keyTimeFormat)+"!!"+rec.CommentID)
+		}
+	}
+	return res
+}
@@ -0,0 +1,201 @@
+// Package gateway implements inbound SMTP listener creating comments from emails of whitelisted senders.
+// Each post exposed by a dedicated recipient address, email body becomes comment text. Made for air-gapped
+// and intranet setups where the web widget isn't usable; listener has no authentication and should be
+// limited to trusted networks.
+package gateway
+
+import (
+	"context"
+	"crypto/sha1" //nolint:gosec // used for user id, the same way as email auth provider does
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-pkgz/auth/token"
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// DataService defines subset of service.DataStore used to check parent of replies
+type DataService interface {
+	Get(locator store.Locator, commentID string, user store.User) (store.Comment, error)
+}
+
+// Creator creates comments going through the same checks as comments of web widget, implemented by rest api
+type Creator interface {
+	CreateComment(ctx context.Context, comment store.Comment) (store.Comment, error)
+}
+
+// Server accepts emails over SMTP and creates comments on posts mapped to recipient addresses
+type Server struct {
+	Address  string            // listening address, like :2525
+	Domain   string            // host name in greeting
+	SiteID   string            // site of all mapped posts
+	Posts    map[string]string // recipient address to post url
+	Senders  []string          // whitelisted sender addresses
+	Networks []*net.IPNet      // allowed client networks, all if empty
+	MaxSize  int               // max size of message in bytes
+	Timeout  time.Duration     // read and write timeout of connection
+
+	Creator Creator
+
+	lock     sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+}
+
+// user id prefix of commenters, the same as used by email auth provider to keep single identity
+const userPrefix = "email_"
+
+// ParseNetworks parses list of CIDRs or single ips
+func ParseNetworks(nets []string) ([]*net.IPNet, error) {
+	res := make([]*net.IPNet, 0, len(nets))
+	for _, n := range nets {
+		if !strings.Contains(n, "/") {
+			if strings.Contains(n, ":") {
+				n += "/128"
+			} else {
+				n += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(n)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid network %q", n)
+		}
+		res = append(res, ipNet)
+	}
+	return res, nil
+}
+
+// Run listens and serves connections until context cancellation or Shutdown call
+func (s *Server) Run(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.Address)
+	if err != nil {
+		return errors.Wrapf(err, "can't listen on %s", s.Address)
+	}
+	s.lock.Lock()
+	s.listener = listener
+	s.conns = map[net.Conn]struct{}{}
+	s.lock.Unlock()
+	log.Printf("[INFO] email gateway listens on %s for %d posts of %s", listener.Addr(), len(s.Posts), s.SiteID)
+
+	go func() {
+		<-ctx.Done()
+		s.Shutdown()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			s.wg.Wait()
+			return nil // listener closed
+		}
+		s.lock.Lock()
+		s.conns[conn] = struct{}{}
+		s.lock.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serve(conn)
+			s.lock.Lock()
+			delete(s.conns, conn)
+			s.lock.Unlock()
+		}()
+	}
+}
+
+// Addr returns address of running listener, nil if not started
+func (s *Server) Addr() net.Addr {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Shutdown stops listener and closes active connections
+func (s *Server) Shutdown() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.listener == nil {
+		return
+	}
+	if err := s.listener.Close(); err != nil {
+		log.Printf("[DEBUG] email gateway listener close, %v", err)
+	}
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+}
+
+// isAllowedClient checks remote address against allowed networks
+func (s *Server) isAllowedClient(addr net.Addr) bool {
+	if len(s.Networks) == 0 {
+		return true
+	}
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range s.Networks {
+		if n.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// isAllowedSender checks sender address against whitelist
+func (s *Server) isAllowedSender(address string) bool {
+	for _, sender := range s.Senders {
+		if strings.EqualFold(sender, address) {
+			return true
+		}
+	}
+	return false
+}
+
+// postURL returns url of the post mapped to recipient address
+func (s *Server) postURL(address string) (string, bool) {
+	for rcpt, url := range s.Posts {
+		if strings.EqualFold(rcpt, address) {
+			return url, true
+		}
+	}
+	return "", false
+}
+
+// createComment makes comment from message on the post
+func (s *Server) createComment(postURL string, msg message, ip string) (store.Comment, error) {
+	address := strings.ToLower(msg.sender)
+	user := store.User{
+		ID:   userPrefix + token.HashID(sha1.New(), address), //nolint:gosec // not used for security
+		Name: msg.name,
+		IP:   ip,
+	}
+	comment := store.Comment{Locator: store.Locator{SiteID: s.SiteID, URL: postURL}, Text: msg.text, User: user}
+	res, err := s.Creator.CreateComment(context.Background(), comment)
+	if err != nil {
+		return store.Comment{}, err
+	}
+	log.Printf("[INFO] comment %s created from email of %s on %s", res.ID, address, postURL)
+	return res, nil
+}
+
+// greeting returns domain for server replies
+func (s *Server) greeting() string {
+	if s.Domain != "" {
+		return s.Domain
+	}
+	return fmt.Sprintf("remark42-%s", s.SiteID)
+}
@@ -0,0 +1,145 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/engine"
+	"github.com/umputun/remark42/backend/app/store/service"
+)
+
+func TestServer_Comment(t *testing.T) {
+	srv, dataStore, teardown := startServer(t)
+	defer teardown()
+	addr := srv.Addr().String()
+
+	msg := "From: John Doe <john@example.com>\r\nSubject: re\r\n\r\ncomment **from** email\r\n"
+	err := smtp.SendMail(addr, nil, "John@Example.com", []string{"post1@comments.example.com"}, []byte(msg))
+	require.NoError(t, err)
+
+	comments, err := dataStore.Find(store.Locator{SiteID: "remark", URL: "https://example.com/post1"}, "time", store.User{})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(comments))
+	assert.Equal(t, "<p>comment <strong>from</strong> email</p>\n", comments[0].Text)
+	assert.Equal(t, "comment **from** email", comments[0].Orig)
+	assert.Equal(t, "John Doe", comments[0].User.Name)
+	assert.Equal(t, "email_5224cb6fdd5bbe463af1db8ee499e858fcb79f81", comments[0].User.ID)
+
+	err = smtp.SendMail(addr, nil, "bad@example.com", []string{"post1@comments.example.com"}, []byte(msg))
+	requireReply(t, err, 550, "5.7.1 sender not allowed")
+
+	err = smtp.SendMail(addr, nil, "john@example.com", []string{"post2@comments.example.com"}, []byte(msg))
+	requireReply(t, err, 550, "5.1.1 unknown recipient")
+
+	err = smtp.SendMail(addr, nil, "john@example.com", []string{"post1@comments.example.com"},
+		[]byte("From: john@example.com\r\nContent-Type: text/html\r\n\r\n<p>html</p>\r\n"))
+	requireReply(t, err, 554, "5.6.0 unsupported content type text/html")
+
+	err = dataStore.SetReadOnly(store.Locator{SiteID: "remark", URL: "https://example.com/post1"}, true)
+	require.NoError(t, err)
+	err = smtp.SendMail(addr, nil, "john@example.com", []string{"post1@comments.example.com"}, []byte(msg))
+	requireReply(t, err, 554, "5.7.0 old post, read-only")
+}
+
+func TestServer_MaxSize(t *testing.T) {
+	srv, _, teardown := startServer(t)
+	defer teardown()
+
+	msg := "From: john@example.com\r\n\r\n" + string(make([]byte, 200)) + "\r\n"
+	err := smtp.SendMail(srv.Addr().String(), nil, "john@example.com", []string{"post1@comments.example.com"}, []byte(msg))
+	requireReply(t, err, 552, "5.3.4 message too big")
+}
+
+func TestServer_Networks(t *testing.T) {
+	nets, err := ParseNetworks([]string{"10.0.0.0/8", "192.168.1.1"})
+	require.NoError(t, err)
+	srv, _, teardown := startServer(t, nets...)
+	defer teardown()
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	buf := make([]byte, 100)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "554 5.7.1 client not allowed\r\n", string(buf[:n]))
+
+	_, err = ParseNetworks([]string{"bad"})
+	assert.EqualError(t, err, `invalid network "bad/32": invalid CIDR address: bad/32`)
+}
+
+func requireReply(t *testing.T, err error, code int, msg string) {
+	require.Error(t, err)
+	tpErr, ok := err.(*textproto.Error)
+	require.True(t, ok, err.Error())
+	assert.Equal(t, code, tpErr.Code)
+	assert.Equal(t, msg, tpErr.Msg)
+}
+
+func startServer(t *testing.T, nets ...*net.IPNet) (srv *Server, dataStore *service.DataStore, teardown func()) {
+	dbFile := os.TempDir() + "/remark-gateway-test.db"
+	_ = os.Remove(dbFile)
+	b, err := engine.NewBoltDB(bolt.Options{}, engine.BoltSite{FileName: dbFile, SiteID: "remark"})
+	require.NoError(t, err)
+	dataStore = &service.DataStore{Engine: b, AdminStore: admin.NewStaticStore("12345", nil, []string{}, ""),
+		MaxCommentSize: 100, MaxVotes: -1}
+
+	srv = &Server{
+		Address:  "127.0.0.1:0",
+		SiteID:   "remark",
+		Posts:    map[string]string{"Post1@comments.example.com": "https://example.com/post1"},
+		Senders:  []string{"john@example.com"},
+		Networks: nets,
+		MaxSize:  100,
+		Timeout:  time.Second,
+		Creator:  dataCreator{dataStore},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		assert.NoError(t, srv.Run(ctx))
+		close(done)
+	}()
+	require.Eventually(t, func() bool { return srv.Addr() != nil }, time.Second, 10*time.Millisecond)
+
+	teardown = func() {
+		cancel()
+		<-done
+		require.NoError(t, dataStore.Close())
+		_ = os.Remove(dbFile)
+	}
+	return srv, dataStore, teardown
+}
+
+// dataCreator saves comments to data store with basic checks, stands for shared creation path of rest api
+type dataCreator struct {
+	dataStore *service.DataStore
+}
+
+func (c dataCreator) CreateComment(_ context.Context, comment store.Comment) (store.Comment, error) {
+	comment.Orig = comment.Text
+	if err := c.dataStore.ValidateComment(&comment); err != nil {
+		return store.Comment{}, err
+	}
+	comment = store.NewCommentFormatter().Format(comment)
+	if c.dataStore.IsReadOnly(comment.Locator) {
+		return store.Comment{}, errors.New("old post, read-only")
+	}
+	id, err := c.dataStore.Create(comment)
+	if err != nil {
+		return store.Comment{}, err
+	}
+	return c.dataStore.Get(comment.Locator, id, comment.User)
+}
@@ -0,0 +1,141 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/url"
+	"strings"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+)
+
+// Inbound is an email received by inbound webhook of mail provider
+type Inbound struct {
+	Recipients []string // envelope or To addresses, one of them expected to be reply address
+	Sender     string   // sender address
+	Raw        []byte   // raw message, if provided by webhook
+	Text       string   // plain text body, used if Raw is missing
+}
+
+// maxInboundMemory limits memory used to parse multipart form of inbound webhook
+const maxInboundMemory = 1 << 20
+
+// ParseInbound extracts email from inbound webhook body of the given provider.
+// Supported providers are "ses" (SNS notification with message content), "mailgun" (route forwarding
+// parsed message as a form) and "generic", the last one expects raw message with From and To headers.
+// Returns nil Inbound without an error for messages which are not emails, like SNS subscription confirmation.
+func ParseInbound(provider, contentType string, body []byte) (*Inbound, error) {
+	switch provider {
+	case "ses":
+		return parseSESInbound(body)
+	case "mailgun":
+		return parseMailgunInbound(contentType, body)
+	case "generic", "":
+		msg, err := mail.ReadMessage(bytes.NewReader(body))
+		if err != nil {
+			return nil, errors.Wrap(err, "can't parse message")
+		}
+		from, err := mail.ParseAddress(msg.Header.Get("From"))
+		if err != nil {
+			return nil, errors.Wrap(err, "can't parse sender")
+		}
+		return &Inbound{Recipients: addresses(msg.Header.Get("To")), Sender: from.Address, Raw: body}, nil
+	}
+	return nil, errors.Errorf("unsupported inbound provider %q", provider)
+}
+
+// parseSESInbound handles Amazon SES receipt notification delivered by SNS
+func parseSESInbound(body []byte) (*Inbound, error) {
+	snsMsg := struct {
+		Type         string `json:"Type"`
+		Message      string `json:"Message"`
+		SubscribeURL string `json:"SubscribeURL"`
+	}{}
+	if err := json.Unmarshal(body, &snsMsg); err != nil {
+		return nil, errors.Wrap(err, "can't unmarshal sns message")
+	}
+
+	if snsMsg.Type == "SubscriptionConfirmation" {
+		// not confirmed automatically in order to prevent requests to arbitrary urls
+		log.Printf("[INFO] sns subscription confirmation requested, visit %s to confirm", snsMsg.SubscribeURL)
+		return nil, nil
+	}
+
+	sesMsg := struct {
+		NotificationType string `json:"notificationType"`
+		Mail             struct {
+			Source      string   `json:"source"`
+			Destination []string `json:"destination"`
+		} `json:"mail"`
+		Receipt struct {
+			Action struct {
+				Encoding string `json:"encoding"`
+			} `json:"action"`
+		} `json:"receipt"`
+		Content string `json:"content"`
+	}{}
+	if err := json.Unmarshal([]byte(snsMsg.Message), &sesMsg); err != nil {
+		return nil, errors.Wrap(err, "can't unmarshal ses notification")
+	}
+	if sesMsg.NotificationType != "Received" {
+		return nil, nil
+	}
+	if sesMsg.Content == "" {
+		return nil, errors.New("no content in ses notification, sns action with content is required")
+	}
+
+	raw := []byte(sesMsg.Content)
+	if sesMsg.Receipt.Action.Encoding == "BASE64" {
+		decoded, err := base64.StdEncoding.DecodeString(sesMsg.Content)
+		if err != nil {
+			return nil, errors.Wrap(err, "can't decode ses content")
+		}
+		raw = decoded
+	}
+	return &Inbound{Recipients: sesMsg.Mail.Destination, Sender: sesMsg.Mail.Source, Raw: raw}, nil
+}
+
+// parseMailgunInbound handles form posted by Mailgun route with forward action
+func parseMailgunInbound(contentType string, body []byte) (*Inbound, error) {
+	var values url.Values
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err == nil && mediaType == "multipart/form-data" {
+		form, e := multipart.NewReader(bytes.NewReader(body), params["boundary"]).ReadForm(maxInboundMemory)
+		if e != nil {
+			return nil, errors.Wrap(e, "can't parse mailgun form")
+		}
+		defer func() { _ = form.RemoveAll() }()
+		values = form.Value
+	} else if values, err = url.ParseQuery(string(body)); err != nil {
+		return nil, errors.Wrap(err, "can't parse mailgun form")
+	}
+
+	res := Inbound{Recipients: addresses(values.Get("recipient")), Sender: values.Get("sender"),
+		Text: values.Get("body-plain")}
+	if res.Sender == "" || len(res.Recipients) == 0 {
+		return nil, errors.New("no sender or recipient in mailgun form")
+	}
+	return &res, nil
+}
+
+// addresses parses list of addresses, ignoring display names and invalid entries
+func addresses(list string) (res []string) {
+	parsed, err := mail.ParseAddressList(list)
+	if err != nil {
+		for _, addr := range strings.Split(list, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				res = append(res, addr)
+			}
+		}
+		return res
+	}
+	for _, addr := range parsed {
+		res = append(res, addr.Address)
+	}
+	return res
+}
@@ -0,0 +1,65 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"mime/multipart"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseInbound(t *testing.T) {
+	raw := "From: User <user@example.com>\r\nTo: reply+123@example.com\r\nSubject: re\r\n\r\nreply\r\n"
+
+	in, err := ParseInbound("generic", "message/rfc822", []byte(raw))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"reply+123@example.com"}, in.Recipients)
+	assert.Equal(t, "user@example.com", in.Sender)
+	assert.Equal(t, raw, string(in.Raw))
+
+	sesMsg, err := json.Marshal(map[string]interface{}{
+		"notificationType": "Received",
+		"mail":             map[string]interface{}{"source": "user@example.com", "destination": []string{"reply+123@example.com"}},
+		"receipt":          map[string]interface{}{"action": map[string]string{"type": "SNS", "encoding": "BASE64"}},
+		"content":          base64.StdEncoding.EncodeToString([]byte(raw)),
+	})
+	require.NoError(t, err)
+	snsMsg, err := json.Marshal(map[string]string{"Type": "Notification", "Message": string(sesMsg)})
+	require.NoError(t, err)
+	in, err = ParseInbound("ses", "text/plain", snsMsg)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"reply+123@example.com"}, in.Recipients)
+	assert.Equal(t, "user@example.com", in.Sender)
+	assert.Equal(t, raw, string(in.Raw))
+
+	in, err = ParseInbound("ses", "text/plain", []byte(`{"Type":"SubscriptionConfirmation","SubscribeURL":"https://example.com"}`))
+	require.NoError(t, err)
+	assert.Nil(t, in, "subscription confirmation ignored")
+
+	in, err = ParseInbound("mailgun", "application/x-www-form-urlencoded",
+		[]byte("recipient=reply%2B123%40example.com&sender=user%40example.com&body-plain=reply+text"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"reply+123@example.com"}, in.Recipients)
+	assert.Equal(t, "user@example.com", in.Sender)
+	assert.Equal(t, "reply text", in.Text)
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	require.NoError(t, mw.WriteField("recipient", "reply+123@example.com"))
+	require.NoError(t, mw.WriteField("sender", "user@example.com"))
+	require.NoError(t, mw.WriteField("body-plain", "multipart reply"))
+	require.NoError(t, mw.Close())
+	in, err = ParseInbound("mailgun", mw.FormDataContentType(), body.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, "multipart reply", in.Text)
+
+	_, err = ParseInbound("mailgun", "application/x-www-form-urlencoded", []byte("body-plain=text"))
+	assert.EqualError(t, err, "no sender or recipient in mailgun form")
+	_, err = ParseInbound("generic", "", []byte("bad"))
+	assert.Error(t, err)
+	_, err = ParseInbound("unknown", "", []byte(raw))
+	assert.EqualError(t, err, `unsupported inbound provider "unknown"`)
+}
@@ -0,0 +1,125 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// message is a comment extracted from email
+type message struct {
+	sender string // envelope sender address
+	name   string // display name from From header, local part of sender address if missing
+	text   string // plain text body without quoted reply and signature
+}
+
+// maxPartsDepth limits nesting of multipart messages
+const maxPartsDepth = 5
+
+// parseMessage extracts commenter name and plain text body from raw message
+func parseMessage(data []byte, sender string) (message, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return message{}, errors.Wrap(err, "can't parse message")
+	}
+
+	res := message{sender: sender}
+	if from, e := mail.ParseAddress(msg.Header.Get("From")); e == nil && strings.TrimSpace(from.Name) != "" {
+		res.name = strings.TrimSpace(from.Name)
+	}
+	if res.name == "" {
+		res.name = strings.Split(sender, "@")[0]
+	}
+
+	body, err := plainText(msg.Header, msg.Body, 0)
+	if err != nil {
+		return message{}, err
+	}
+	if res.text = cleanText(body); res.text == "" {
+		return message{}, errors.New("empty comment")
+	}
+	return res, nil
+}
+
+// header is a common part of mail.Header and multipart part header
+type header interface {
+	Get(key string) string
+}
+
+// plainText returns decoded text/plain content, the first text/plain part for multipart messages
+func plainText(h header, body io.Reader, depth int) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain" // default content type by RFC 2045
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		if depth >= maxPartsDepth {
+			return "", errors.New("too deep multipart message")
+		}
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, e := mr.NextPart()
+			if e == io.EOF {
+				return "", errors.New("no text/plain part")
+			}
+			if e != nil {
+				return "", errors.Wrap(e, "can't read multipart message")
+			}
+			text, e := plainText(part.Header, part, depth+1)
+			if e == nil {
+				return text, nil
+			}
+		}
+	}
+
+	if mediaType != "text/plain" {
+		return "", errors.Errorf("unsupported content type %s", mediaType)
+	}
+	if charset := strings.ToLower(params["charset"]); charset != "" && charset != "utf-8" && charset != "us-ascii" {
+		return "", errors.Errorf("unsupported charset %s", charset)
+	}
+
+	switch strings.ToLower(h.Get("Content-Transfer-Encoding")) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return "", errors.Wrap(err, "can't read message body")
+	}
+	return string(data), nil
+}
+
+// cleanText drops signature and quoted text of reply, with "On ... wrote:" line before the quote
+func cleanText(text string) string {
+	lines := []string{}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "--" {
+			break // signature separator, "-- " with trailing space dropped
+		}
+		if strings.HasPrefix(line, ">") {
+			n := len(lines)
+			for n > 0 && lines[n-1] == "" {
+				n--
+			}
+			if n > 0 && strings.HasSuffix(lines[n-1], "wrote:") {
+				lines = lines[:n-1]
+			}
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,56 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMessage(t *testing.T) {
+	tbl := []struct {
+		name string
+		data string
+		res  message
+		err  string
+	}{
+		{name: "plain",
+			data: "From: John Doe <john@example.com>\r\nSubject: hi\r\n\r\nsome comment\r\nline 2\r\n",
+			res:  message{sender: "john@example.com", name: "John Doe", text: "some comment\nline 2"}},
+		{name: "no display name",
+			data: "From: john@example.com\r\n\r\nsome comment\r\n",
+			res:  message{sender: "john@example.com", name: "john", text: "some comment"}},
+		{name: "quoted-printable",
+			data: "From: john@example.com\r\nContent-Type: text/plain; charset=utf-8\r\n" +
+				"Content-Transfer-Encoding: quoted-printable\r\n\r\nprivet =D0=BC=D0=B8=D1=80\r\n",
+			res: message{sender: "john@example.com", name: "john", text: "privet мир"}},
+		{name: "multipart alternative",
+			data: "From: john@example.com\r\nContent-Type: multipart/alternative; boundary=xyz\r\n\r\n" +
+				"--xyz\r\nContent-Type: text/html\r\n\r\n<p>html</p>\r\n" +
+				"--xyz\r\nContent-Type: text/plain\r\nContent-Transfer-Encoding: base64\r\n\r\ndGV4dCBwYXJ0\r\n--xyz--\r\n",
+			res: message{sender: "john@example.com", name: "john", text: "text part"}},
+		{name: "reply with quote and signature",
+			data: "From: john@example.com\r\n\r\nmy reply\r\n\r\nOn Mon, Jan 1 Jane wrote:\r\n\r\n> original\r\n> text\r\n\r\n-- \r\nJohn\r\n",
+			res:  message{sender: "john@example.com", name: "john", text: "my reply"}},
+		{name: "html only", data: "From: john@example.com\r\nContent-Type: text/html\r\n\r\n<p>html</p>\r\n",
+			err: "unsupported content type text/html"},
+		{name: "bad charset", data: "From: john@example.com\r\nContent-Type: text/plain; charset=koi8-r\r\n\r\ntext\r\n",
+			err: "unsupported charset koi8-r"},
+		{name: "empty", data: "From: john@example.com\r\n\r\n> quote only\r\n", err: "empty comment"},
+		{name: "no headers", data: "bad", err: "can't parse message"},
+	}
+
+	for _, tt := range tbl {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := parseMessage([]byte(tt.data), "john@example.com")
+			if tt.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.res, res)
+		})
+	}
+}
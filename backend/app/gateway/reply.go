@@ -0,0 +1,138 @@
+package gateway
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// Ticket issued with reply address of notification, reply to the address posted under the comment
+// on behalf of the recipient of notification
+type Ticket struct {
+	Token     string        `json:"token"`
+	Locator   store.Locator `json:"locator"`
+	CommentID string        `json:"comment_id"` // parent of the reply
+	User      store.User    `json:"user"`       // recipient of notification, author of the reply
+	Email     string        `json:"email"`      // address of recipient, replies accepted from it only
+	Expires   time.Time     `json:"expires"`
+}
+
+// TicketStore defines interface to keep issued tickets
+type TicketStore interface {
+	Add(ticket Ticket) error
+	Get(token string) (ticket Ticket, found bool, err error)
+	Cleanup(now time.Time) (int, error) // removes expired tickets, returns number of removed
+}
+
+// Replies issues reply addresses for notifications and creates comments from replies sent to them.
+// Address made with plus-tag, like reply+token@domain, so single mailbox of the domain receives all replies.
+type Replies struct {
+	Domain string        // domain of reply addresses, routed to inbound webhook
+	TTL    time.Duration // lifetime of reply address
+	Store  TicketStore
+
+	DataService DataService
+	Creator     Creator
+}
+
+// reply addresses are like reply+token@domain
+const replyPrefix = "reply+"
+
+// Address issues reply address of notification about the comment sent to the recipient
+func (r *Replies) Address(recipient store.User, email string, comment store.Comment) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "can't make reply token")
+	}
+	ticket := Ticket{
+		Token:     hex.EncodeToString(b),
+		Locator:   comment.Locator,
+		CommentID: comment.ID,
+		User:      store.User{ID: recipient.ID, Name: recipient.Name, Picture: recipient.Picture},
+		Email:     strings.ToLower(email),
+		Expires:   time.Now().Add(r.TTL),
+	}
+	if err := r.Store.Add(ticket); err != nil {
+		return "", errors.Wrapf(err, "can't save reply ticket for %s", comment.ID)
+	}
+	return replyPrefix + ticket.Token + "@" + r.Domain, nil
+}
+
+// Process creates reply from inbound email, sent by recipient of notification to the reply address.
+// Text of the email cleaned from quoted text and signature.
+func (r *Replies) Process(in Inbound) (store.Comment, error) {
+	var ticket Ticket
+	err := errors.New("no recipients")
+	for _, addr := range in.Recipients {
+		if ticket, err = r.ticket(addr); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return store.Comment{}, err
+	}
+	if !strings.EqualFold(strings.TrimSpace(in.Sender), ticket.Email) {
+		return store.Comment{}, errors.Errorf("sender %s doesn't match recipient of notification", in.Sender)
+	}
+
+	text := cleanText(in.Text)
+	if in.Raw != nil {
+		msg, e := parseMessage(in.Raw, in.Sender)
+		if e != nil {
+			return store.Comment{}, e
+		}
+		text = msg.text
+	}
+	if text == "" {
+		return store.Comment{}, errors.New("empty comment")
+	}
+
+	parent, err := r.DataService.Get(ticket.Locator, ticket.CommentID, ticket.User)
+	if err != nil {
+		return store.Comment{}, errors.Wrapf(err, "can't get comment %s", ticket.CommentID)
+	}
+	if parent.Deleted {
+		return store.Comment{}, errors.Errorf("comment %s deleted", ticket.CommentID)
+	}
+
+	res, err := r.Creator.CreateComment(context.Background(), store.Comment{Locator: ticket.Locator, ParentID: ticket.CommentID, Text: text, User: ticket.User})
+	if err != nil {
+		return store.Comment{}, err
+	}
+	log.Printf("[INFO] reply %s to %s created from email of %s", res.ID, ticket.CommentID, ticket.User.ID)
+	return res, nil
+}
+
+// Cleanup removes expired tickets
+func (r *Replies) Cleanup() {
+	n, err := r.Store.Cleanup(time.Now())
+	if err != nil {
+		log.Printf("[WARN] can't remove expired reply tickets, %v", err)
+		return
+	}
+	log.Printf("[DEBUG] %d expired reply tickets removed", n)
+}
+
+// ticket returns not expired ticket of reply address
+func (r *Replies) ticket(address string) (Ticket, error) {
+	address = strings.ToLower(strings.TrimSpace(address))
+	elems := strings.SplitN(address, "@", 2)
+	if len(elems) != 2 || !strings.HasPrefix(elems[0], replyPrefix) || !strings.EqualFold(elems[1], r.Domain) {
+		return Ticket{}, errors.Errorf("%s is not a reply address", address)
+	}
+	ticket, found, err := r.Store.Get(strings.TrimPrefix(elems[0], replyPrefix))
+	if err != nil {
+		return Ticket{}, errors.Wrap(err, "can't get reply ticket")
+	}
+	if !found || ticket.Expires.Before(time.Now()) {
+		return Ticket{}, errors.Errorf("reply address %s unknown or expired", address)
+	}
+	return ticket, nil
+}
@@ -0,0 +1,61 @@
+package gateway
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// EngineTickets implements TicketStore with records of store engine, tickets kept along with comments of their site.
+// Reply address doesn't include site, so ticket looked up by token in all sites.
+type EngineTickets struct {
+	records engine.Records // keyed by token
+	sites   func() []string
+}
+
+// NewEngineTickets makes store of reply tickets kept by eng for sites
+func NewEngineTickets(eng engine.Interface, sites func() []string) *EngineTickets {
+	return &EngineTickets{records: engine.Records{Engine: eng, Kind: engine.ReplyTickets}, sites: sites}
+}
+
+// Add ticket
+func (e *EngineTickets) Add(ticket Ticket) error {
+	err := e.records.Set(ticket.Locator.SiteID, ticket.Token, ticket)
+	return errors.Wrapf(err, "can't put ticket of %s", ticket.CommentID)
+}
+
+// Get ticket by token
+func (e *EngineTickets) Get(token string) (ticket Ticket, found bool, err error) {
+	for _, siteID := range e.sites() {
+		if found, err = e.records.Get(siteID, token, &ticket); err != nil || found {
+			return ticket, found, err
+		}
+	}
+	return Ticket{}, false, nil
+}
+
+// Cleanup removes tickets of all sites expired before now
+func (e *EngineTickets) Cleanup(now time.Time) (count int, err error) {
+	for _, siteID := range e.sites() {
+		expired := []string{}
+		err = e.records.List(siteID, "", func(key string, unmarshal func(v interface{}) error) error {
+			ticket := Ticket{}
+			if unmarshal(&ticket) != nil || ticket.Expires.Before(now) {
+				expired = append(expired, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return count, err
+		}
+		for _, key := range expired {
+			if _, err = e.records.Delete(siteID, key); err != nil {
+				return count, errors.Wrapf(err, "can't delete ticket %s", key)
+			}
+			count++
+		}
+	}
+	return count, nil
+}
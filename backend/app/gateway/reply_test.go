@@ -0,0 +1,101 @@
+package gateway
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/engine"
+	"github.com/umputun/remark42/backend/app/store/service"
+)
+
+func TestReplies_Process(t *testing.T) {
+	r, dataStore := prepReplies(t)
+	locator := store.Locator{SiteID: "remark", URL: "https://example.com/post1"}
+	recipient := store.User{ID: "user1", Name: "User One", Picture: "https://example.com/pic.png"}
+
+	parentID, err := dataStore.Create(store.Comment{Locator: locator, Text: "parent", User: recipient})
+	require.NoError(t, err)
+	parent, err := dataStore.Get(locator, parentID, store.User{})
+	require.NoError(t, err)
+
+	addr, err := r.Address(recipient, "User1@Example.com", parent)
+	require.NoError(t, err)
+	assert.Regexp(t, `^reply\+[0-9a-f]{32}@replies\.example\.com$`, addr)
+
+	_, err = r.Process(Inbound{Recipients: []string{addr}, Sender: "other@example.com", Text: "reply"})
+	assert.EqualError(t, err, "sender other@example.com doesn't match recipient of notification")
+
+	_, err = r.Process(Inbound{Recipients: []string{"reply+bad@replies.example.com"}, Sender: "user1@example.com",
+		Text: "reply"})
+	assert.EqualError(t, err, "reply address reply+bad@replies.example.com unknown or expired")
+
+	_, err = r.Process(Inbound{Recipients: []string{"post@example.com"}, Sender: "user1@example.com", Text: "reply"})
+	assert.EqualError(t, err, "post@example.com is not a reply address")
+
+	_, err = r.Process(Inbound{Recipients: []string{addr}, Sender: "user1@example.com", Text: "> quoted only"})
+	assert.EqualError(t, err, "empty comment")
+
+	res, err := r.Process(Inbound{Recipients: []string{"other@example.com", addr}, Sender: "user1@example.com",
+		Text: "reply **text**\n\nOn Mon, Jan 1, 2021 remark42 wrote:\n> parent"})
+	require.NoError(t, err)
+	assert.Equal(t, parentID, res.ParentID)
+	assert.Equal(t, "user1", res.User.ID)
+
+	comments, err := dataStore.Find(locator, "time", store.User{})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(comments))
+	assert.Equal(t, "<p>reply <strong>text</strong></p>\n", comments[1].Text)
+	assert.Equal(t, "User One", comments[1].User.Name)
+
+	msg := "From: User <user1@example.com>\r\nSubject: re\r\n\r\nraw reply\r\n"
+	res, err = r.Process(Inbound{Recipients: []string{addr}, Sender: "user1@example.com", Raw: []byte(msg)})
+	require.NoError(t, err)
+	assert.Equal(t, "<p>raw reply</p>\n", res.Text)
+
+	require.NoError(t, dataStore.Delete(locator, parentID, store.SoftDelete))
+	_, err = r.Process(Inbound{Recipients: []string{addr}, Sender: "user1@example.com", Text: "reply"})
+	assert.EqualError(t, err, "comment "+parentID+" deleted")
+}
+
+func TestReplies_Cleanup(t *testing.T) {
+	r, _ := prepReplies(t)
+	locator := store.Locator{SiteID: "remark", URL: "https://example.com/post1"}
+	require.NoError(t, r.Store.Add(Ticket{Token: "expired", Locator: locator, Expires: time.Now().Add(-time.Minute)}))
+	require.NoError(t, r.Store.Add(Ticket{Token: "active", Locator: locator, Expires: time.Now().Add(time.Minute)}))
+
+	r.Cleanup()
+	_, found, err := r.Store.Get("expired")
+	require.NoError(t, err)
+	assert.False(t, found)
+	_, found, err = r.Store.Get("active")
+	require.NoError(t, err)
+	assert.True(t, found)
+
+	_, err = r.ticket("reply+expired@replies.example.com")
+	assert.Error(t, err)
+}
+
+func prepReplies(t *testing.T) (*Replies, *service.DataStore) {
+	dbFile := os.TempDir() + "/remark-replies-test.db"
+	_ = os.Remove(dbFile)
+	b, err := engine.NewBoltDB(bolt.Options{}, engine.BoltSite{FileName: dbFile, SiteID: "remark"})
+	require.NoError(t, err)
+	dataStore := &service.DataStore{Engine: b, AdminStore: admin.NewStaticStore("12345", nil, []string{}, ""),
+		MaxCommentSize: 1000, MaxVotes: -1}
+
+	tickets := NewEngineTickets(b, func() []string { return []string{"remark"} })
+	r := &Replies{Domain: "replies.example.com", TTL: time.Hour, Store: tickets,
+		DataService: dataStore, Creator: dataCreator{dataStore}}
+	t.Cleanup(func() {
+		assert.NoError(t, dataStore.Close())
+		_ = os.Remove(dbFile)
+	})
+	return r, dataStore
+}
@@ -0,0 +1,193 @@
+package gateway
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+)
+
+// session keeps envelope of the message accepted by connection
+type session struct {
+	sender string
+	posts  []string
+}
+
+// serve runs minimal SMTP dialog, enough to accept messages from mail servers and clients.
+// Sender checked on MAIL, recipient on RCPT and comments created on DATA, one per recipient.
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	ip := ""
+	if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		ip = tcpAddr.IP.String()
+	}
+
+	if !s.isAllowedClient(conn.RemoteAddr()) {
+		log.Printf("[WARN] email gateway rejected client %s", conn.RemoteAddr())
+		_ = tp.PrintfLine("554 5.7.1 client not allowed")
+		return
+	}
+
+	s.setDeadline(conn)
+	if err := tp.PrintfLine("220 %s ESMTP remark42 gateway", s.greeting()); err != nil {
+		return
+	}
+
+	sess := session{}
+	for {
+		s.setDeadline(conn)
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			cmd, arg = line[:i], strings.TrimSpace(line[i+1:])
+		}
+
+		var reply string
+		switch strings.ToUpper(cmd) {
+		case "HELO":
+			sess = session{}
+			reply = "250 " + s.greeting()
+		case "EHLO":
+			sess = session{}
+			reply = "250-" + s.greeting() + "\r\n250-8BITMIME\r\n250 SIZE " + strconv.Itoa(s.maxSize())
+		case "MAIL":
+			reply = s.mailCmd(&sess, arg)
+		case "RCPT":
+			reply = s.rcptCmd(&sess, arg)
+		case "DATA":
+			if len(sess.posts) == 0 {
+				reply = "503 5.5.1 need RCPT command"
+				break
+			}
+			if err = tp.PrintfLine("354 end data with <CR><LF>.<CR><LF>"); err != nil {
+				return
+			}
+			reply = s.dataCmd(tp, sess, ip)
+			sess = session{}
+		case "RSET":
+			sess = session{}
+			reply = "250 2.0.0 ok"
+		case "NOOP":
+			reply = "250 2.0.0 ok"
+		case "VRFY":
+			reply = "252 2.5.0 cannot verify"
+		case "QUIT":
+			_ = tp.PrintfLine("221 2.0.0 bye")
+			return
+		default:
+			reply = "502 5.5.2 command not implemented"
+		}
+		if err = tp.PrintfLine("%s", reply); err != nil {
+			return
+		}
+	}
+}
+
+// mailCmd checks sender of MAIL FROM:<address>
+func (s *Server) mailCmd(sess *session, arg string) string {
+	if sess.sender != "" {
+		return "503 5.5.1 sender already specified"
+	}
+	address, ok := envelopeAddress(arg, "FROM:")
+	if !ok {
+		return "501 5.5.4 syntax: MAIL FROM:<address>"
+	}
+	if !s.isAllowedSender(address) {
+		log.Printf("[WARN] email gateway rejected sender %q", address)
+		return "550 5.7.1 sender not allowed"
+	}
+	sess.sender = address
+	return "250 2.1.0 ok"
+}
+
+// rcptCmd checks recipient of RCPT TO:<address> is mapped to the post
+func (s *Server) rcptCmd(sess *session, arg string) string {
+	if sess.sender == "" {
+		return "503 5.5.1 need MAIL command"
+	}
+	address, ok := envelopeAddress(arg, "TO:")
+	if !ok {
+		return "501 5.5.4 syntax: RCPT TO:<address>"
+	}
+	url, ok := s.postURL(address)
+	if !ok {
+		return "550 5.1.1 unknown recipient"
+	}
+	sess.posts = append(sess.posts, url)
+	return "250 2.1.5 ok"
+}
+
+// dataCmd reads message and creates comments on all posts of the envelope
+func (s *Server) dataCmd(tp *textproto.Conn, sess session, ip string) string {
+	maxSize := s.maxSize()
+	dr := tp.DotReader()
+	data, err := ioutil.ReadAll(io.LimitReader(dr, int64(maxSize)+1))
+	if err != nil {
+		return "451 4.3.0 failed to read message"
+	}
+	if len(data) > maxSize {
+		_, _ = io.Copy(ioutil.Discard, dr) // skip the rest of message
+		return "552 5.3.4 message too big"
+	}
+
+	msg, err := parseMessage(data, sess.sender)
+	if err != nil {
+		log.Printf("[WARN] email gateway rejected message from %s, %v", sess.sender, err)
+		return "554 5.6.0 " + replyText(err)
+	}
+	for _, url := range sess.posts {
+		if _, err = s.createComment(url, msg, ip); err != nil {
+			log.Printf("[WARN] email gateway failed to create comment from %s on %s, %v", sess.sender, url, err)
+			return "554 5.7.0 " + replyText(err)
+		}
+	}
+	return "250 2.0.0 comment accepted"
+}
+
+// envelopeAddress extracts address from FROM:<address> or TO:<address> argument, parameters after address ignored
+func envelopeAddress(arg, prefix string) (string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+	arg = strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(arg, "<") || !strings.Contains(arg, ">") {
+		return "", false
+	}
+	arg = arg[1:strings.Index(arg, ">")]
+	if arg == "" {
+		return "", false
+	}
+	addr, err := mail.ParseAddress(arg)
+	if err != nil {
+		return "", false
+	}
+	return addr.Address, true
+}
+
+func (s *Server) setDeadline(conn net.Conn) {
+	if s.Timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(s.Timeout))
+	}
+}
+
+func (s *Server) maxSize() int {
+	if s.MaxSize > 0 {
+		return s.MaxSize
+	}
+	return 1024 * 1024
+}
+
+// replyText makes single-line reply from error
+func replyText(err error) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(err.Error())
+}
@@ -0,0 +1,137 @@
+// Package health checks availability of dependencies of the server for readiness probe. Checks run concurrently
+// with timeout, results of expensive checks cached for TTL. Failed optional checks reported, but don't make the
+// server not ready, i.e. SMTP outage shouldn't stop traffic to comments.
+package health
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Check is a named check of a dependency
+type Check struct {
+	Name     string
+	Func     func(ctx context.Context) error
+	TTL      time.Duration // result cached for TTL if set, for expensive checks
+	Optional bool          // failure doesn't make the server not ready
+}
+
+// Status of a dependency
+type Status struct {
+	Status    string    `json:"status"` // ok or failed
+	Error     string    `json:"error,omitempty"`
+	Optional  bool      `json:"optional,omitempty"`
+	Cached    bool      `json:"cached,omitempty"`
+	Latency   string    `json:"latency"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Report of readiness with status per dependency
+type Report struct {
+	Ready  bool              `json:"ready"`
+	Status string            `json:"status"` // ok, degraded with failed optional checks or failed
+	Checks map[string]Status `json:"checks"`
+}
+
+// enum of statuses
+const (
+	StatusOK       = "ok"
+	StatusDegraded = "degraded"
+	StatusFailed   = "failed"
+)
+
+// Service runs checks of dependencies
+type Service struct {
+	checks  []Check
+	timeout time.Duration
+
+	lock   sync.Mutex
+	cached map[string]Status
+}
+
+// NewService makes service with timeout of each check and checks of dependencies
+func NewService(timeout time.Duration, checks ...Check) *Service {
+	return &Service{checks: checks, timeout: timeout, cached: map[string]Status{}}
+}
+
+// Names of checked dependencies, sorted
+func (s *Service) Names() []string {
+	res := make([]string, 0, len(s.checks))
+	for _, c := range s.checks {
+		res = append(res, c.Name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Ready runs all checks concurrently and returns report, ready if all required checks passed
+func (s *Service) Ready(ctx context.Context) Report {
+	res := Report{Ready: true, Status: StatusOK, Checks: make(map[string]Status, len(s.checks))}
+	statuses := make([]Status, len(s.checks))
+	var wg sync.WaitGroup
+	for i, c := range s.checks {
+		wg.Add(1)
+		go func(i int, c Check) {
+			defer wg.Done()
+			statuses[i] = s.status(ctx, c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	for i, c := range s.checks {
+		st := statuses[i]
+		res.Checks[c.Name] = st
+		if st.Status == StatusOK {
+			continue
+		}
+		if c.Optional {
+			if res.Ready {
+				res.Status = StatusDegraded
+			}
+			continue
+		}
+		res.Ready, res.Status = false, StatusFailed
+	}
+	return res
+}
+
+// status of the check, cached result returned if not expired
+func (s *Service) status(ctx context.Context, c Check) Status {
+	if c.TTL > 0 {
+		s.lock.Lock()
+		st, ok := s.cached[c.Name]
+		s.lock.Unlock()
+		if ok && time.Since(st.CheckedAt) < c.TTL {
+			st.Cached = true
+			return st
+		}
+	}
+
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+	st := Status{Status: StatusOK, Optional: c.Optional, CheckedAt: time.Now()}
+	errCh := make(chan error, 1)
+	go func() { errCh <- c.Func(ctx) }()
+	var err error
+	select { // check ignoring context can't block the probe
+	case err = <-errCh:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	st.Latency = time.Since(st.CheckedAt).Round(time.Millisecond).String()
+	if err != nil {
+		st.Status, st.Error = StatusFailed, err.Error()
+	}
+
+	if c.TTL > 0 {
+		s.lock.Lock()
+		s.cached[c.Name] = st
+		s.lock.Unlock()
+	}
+	return st
+}
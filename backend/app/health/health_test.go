@@ -0,0 +1,57 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_Ready(t *testing.T) {
+	var smtpCalls int32
+	smtpErr := errors.New("connection refused")
+	s := NewService(100*time.Millisecond,
+		Check{Name: "store", Func: func(context.Context) error { return nil }},
+		Check{Name: "smtp", Optional: true, TTL: time.Minute, Func: func(context.Context) error {
+			atomic.AddInt32(&smtpCalls, 1)
+			return smtpErr
+		}},
+	)
+	assert.Equal(t, []string{"smtp", "store"}, s.Names())
+
+	rep := s.Ready(context.Background())
+	assert.True(t, rep.Ready)
+	assert.Equal(t, StatusDegraded, rep.Status, "optional check failed")
+	require.Equal(t, 2, len(rep.Checks))
+	assert.Equal(t, StatusOK, rep.Checks["store"].Status)
+	assert.Equal(t, Status{Status: StatusFailed, Error: "connection refused", Optional: true,
+		Latency: rep.Checks["smtp"].Latency, CheckedAt: rep.Checks["smtp"].CheckedAt}, rep.Checks["smtp"])
+
+	rep = s.Ready(context.Background())
+	assert.True(t, rep.Checks["smtp"].Cached)
+	assert.False(t, rep.Checks["store"].Cached)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&smtpCalls), "cached result used")
+}
+
+func TestService_ReadyFailed(t *testing.T) {
+	s := NewService(50*time.Millisecond,
+		Check{Name: "store", Func: func(context.Context) error { return errors.New("db closed") }},
+		Check{Name: "cache", Func: func(context.Context) error { time.Sleep(time.Second); return nil }},
+		Check{Name: "avatars", Func: func(ctx context.Context) error { return nil }},
+	)
+	st := time.Now()
+	rep := s.Ready(context.Background())
+	assert.True(t, time.Since(st) < 500*time.Millisecond, "check not blocking")
+	assert.False(t, rep.Ready)
+	assert.Equal(t, StatusFailed, rep.Status)
+	assert.Equal(t, "db closed", rep.Checks["store"].Error)
+	assert.Equal(t, "context deadline exceeded", rep.Checks["cache"].Error)
+	assert.Equal(t, StatusOK, rep.Checks["avatars"].Status)
+
+	rep = NewService(time.Second).Ready(context.Background())
+	assert.Equal(t, Report{Ready: true, Status: StatusOK, Checks: map[string]Status{}}, rep, "no checks")
+}
@@ -0,0 +1,179 @@
+// Package identity links accounts of the same person made with different auth providers. The user logged in with
+// one provider requests a link code, logs in with another provider and confirms the code. Comments, votes and details
+// of the confirmed identity merged to the canonical one, and later logins of the linked identity mapped to the
+// canonical user id. Admins can link and unlink identities manually.
+package identity
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+)
+
+// Request to link identity, made by the canonical user and confirmed by another identity with the code
+type Request struct {
+	SiteID  string    `json:"site"`
+	UserID  string    `json:"user_id"` // canonical user id
+	Code    string    `json:"code"`
+	Expires time.Time `json:"expires"`
+}
+
+// Link of identity to canonical user
+type Link struct {
+	SiteID    string    `json:"site"`
+	UserID    string    `json:"user_id"`   // canonical user id
+	LinkedID  string    `json:"linked_id"` // id of linked identity, mapped to UserID on login
+	Timestamp time.Time `json:"time"`
+}
+
+// Store defines interface to keep links and pending requests
+type Store interface {
+	Link(siteID, linkedID string) (*Link, error) // returns nil for identity not linked
+	SetLink(link Link) error
+	DeleteLink(siteID, linkedID string) error
+	Links(siteID, userID string) ([]Link, error)   // identities linked to the canonical user
+	Request(siteID, code string) (*Request, error) // returns nil for unknown code
+	SetRequest(req Request) error
+	DeleteRequest(siteID, code string) error
+	DeleteExpired(siteID string, now time.Time) error // removes requests of the site expired before now
+}
+
+// MergeFunc moves comments, votes and details of fromID to toID on the site
+type MergeFunc func(siteID, fromID, toID string) error
+
+// Service links identities, nil service maps each user to itself
+type Service struct {
+	store Store
+	merge MergeFunc
+	ttl   time.Duration
+	now   func() time.Time
+
+	lock sync.Mutex // serializes changes of links
+}
+
+// NewService makes identity service, merge called on link to move data of linked identity,
+// ttl of link request is 15 minutes by default
+func NewService(st Store, merge MergeFunc, ttl time.Duration) *Service {
+	if ttl <= 0 {
+		ttl = 15 * time.Minute
+	}
+	return &Service{store: st, merge: merge, ttl: ttl, now: time.Now}
+}
+
+// Canonical returns id of canonical user for the identity, the same id for identity not linked. Errors logged.
+func (s *Service) Canonical(siteID, userID string) string {
+	if s == nil || userID == "" {
+		return userID
+	}
+	link, err := s.store.Link(siteID, userID)
+	if err != nil {
+		log.Printf("[WARN] can't get link of %s on %s, %v", userID, siteID, err)
+		return userID
+	}
+	if link == nil {
+		return userID
+	}
+	return link.UserID
+}
+
+// Request makes one-time code to link another identity to the user
+func (s *Service) Request(siteID, userID string) (Request, error) {
+	if siteID == "" || userID == "" {
+		return Request{}, errors.New("site and user are required")
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return Request{}, errors.Wrap(err, "can't make link code")
+	}
+	now := s.now()
+	if err := s.store.DeleteExpired(siteID, now); err != nil {
+		log.Printf("[WARN] can't delete expired link requests, %v", err)
+	}
+	req := Request{SiteID: siteID, UserID: s.Canonical(siteID, userID), Code: hex.EncodeToString(b),
+		Expires: now.Add(s.ttl)}
+	if err := s.store.SetRequest(req); err != nil {
+		return Request{}, errors.Wrapf(err, "can't save link request of %s", userID)
+	}
+	log.Printf("[INFO] link of identity requested by %s on %s", req.UserID, siteID)
+	return req, nil
+}
+
+// Confirm links identity of the user to the canonical user requested the code. The code used once.
+func (s *Service) Confirm(siteID, userID, code string) (Link, error) {
+	req, err := s.store.Request(siteID, code)
+	if err != nil {
+		return Link{}, errors.Wrap(err, "can't get link request")
+	}
+	if req == nil || req.SiteID != siteID || s.now().After(req.Expires) {
+		return Link{}, errors.New("invalid or expired link code")
+	}
+	if err = s.store.DeleteRequest(siteID, code); err != nil {
+		return Link{}, errors.Wrap(err, "can't delete link request")
+	}
+	return s.Link(siteID, userID, req.UserID)
+}
+
+// Link merges identity fromID to canonical user of toID and maps later logins of fromID to it.
+// Identities already linked to fromID re-linked to the canonical user.
+func (s *Service) Link(siteID, fromID, toID string) (Link, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	toID = s.Canonical(siteID, toID)
+	if fromID == "" || toID == "" || fromID == toID {
+		return Link{}, errors.Errorf("can't link %q to %q", fromID, toID)
+	}
+	if canonical := s.Canonical(siteID, fromID); canonical != fromID {
+		return Link{}, errors.Errorf("identity %s already linked to %s", fromID, canonical)
+	}
+	if err := s.merge(siteID, fromID, toID); err != nil {
+		return Link{}, errors.Wrapf(err, "can't merge %s to %s", fromID, toID)
+	}
+
+	linked, err := s.store.Links(siteID, fromID)
+	if err != nil {
+		return Link{}, errors.Wrapf(err, "can't get links of %s", fromID)
+	}
+	now := s.now()
+	for _, l := range linked {
+		if err = s.store.SetLink(Link{SiteID: siteID, UserID: toID, LinkedID: l.LinkedID, Timestamp: now}); err != nil {
+			return Link{}, errors.Wrapf(err, "can't re-link %s", l.LinkedID)
+		}
+	}
+	link := Link{SiteID: siteID, UserID: toID, LinkedID: fromID, Timestamp: now}
+	if err = s.store.SetLink(link); err != nil {
+		return Link{}, errors.Wrapf(err, "can't link %s", fromID)
+	}
+	log.Printf("[INFO] identity %s of %s linked to %s, %d identities re-linked", fromID, siteID, toID, len(linked))
+	return link, nil
+}
+
+// Unlink identity, later logins of it made with own id. Merged data stays with canonical user.
+func (s *Service) Unlink(siteID, linkedID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	link, err := s.store.Link(siteID, linkedID)
+	if err != nil {
+		return errors.Wrapf(err, "can't get link of %s", linkedID)
+	}
+	if link == nil {
+		return errors.Errorf("identity %s not linked", linkedID)
+	}
+	if err = s.store.DeleteLink(siteID, linkedID); err != nil {
+		return errors.Wrapf(err, "can't unlink %s", linkedID)
+	}
+	log.Printf("[INFO] identity %s of %s unlinked from %s", linkedID, siteID, link.UserID)
+	return nil
+}
+
+// Links returns identities linked to the canonical user of userID
+func (s *Service) Links(siteID, userID string) (canonical string, links []Link, err error) {
+	canonical = s.Canonical(siteID, userID)
+	if links, err = s.store.Links(siteID, canonical); err != nil {
+		return "", nil, errors.Wrapf(err, "can't get links of %s", canonical)
+	}
+	return canonical, links, nil
+}
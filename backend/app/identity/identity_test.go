@@ -0,0 +1,126 @@
+package identity
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+func TestService_RequestAndConfirm(t *testing.T) {
+	st := prepStore(t)
+
+	var merged []string
+	svc := NewService(st, func(siteID, fromID, toID string) error {
+		merged = append(merged, siteID+":"+fromID+"->"+toID)
+		return nil
+	}, time.Minute)
+	ts := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	svc.now = func() time.Time { return ts }
+
+	_, err := svc.Request("site1", "")
+	assert.EqualError(t, err, "site and user are required")
+
+	req, err := svc.Request("site1", "github_1")
+	require.NoError(t, err)
+	assert.Equal(t, 16, len(req.Code))
+	assert.Equal(t, "github_1", req.UserID)
+	assert.Equal(t, ts.Add(time.Minute), req.Expires)
+
+	_, err = svc.Confirm("site2", "google_1", req.Code)
+	assert.EqualError(t, err, "invalid or expired link code", "other site")
+	_, err = svc.Confirm("site1", "google_1", "bad")
+	assert.EqualError(t, err, "invalid or expired link code")
+
+	link, err := svc.Confirm("site1", "google_1", req.Code)
+	require.NoError(t, err)
+	assert.Equal(t, Link{SiteID: "site1", UserID: "github_1", LinkedID: "google_1", Timestamp: ts}, link)
+	assert.Equal(t, []string{"site1:google_1->github_1"}, merged)
+	_, err = svc.Confirm("site1", "google_1", req.Code)
+	assert.EqualError(t, err, "invalid or expired link code", "code used once")
+
+	assert.Equal(t, "github_1", svc.Canonical("site1", "google_1"))
+	assert.Equal(t, "github_1", svc.Canonical("site1", "github_1"))
+	assert.Equal(t, "google_1", svc.Canonical("site2", "google_1"), "not linked on other site")
+
+	req, err = svc.Request("site1", "github_1")
+	require.NoError(t, err)
+	ts = ts.Add(2 * time.Minute)
+	_, err = svc.Confirm("site1", "twitter_1", req.Code)
+	assert.EqualError(t, err, "invalid or expired link code", "expired")
+
+	req, err = svc.Request("site1", "github_1")
+	require.NoError(t, err)
+	_, err = svc.Confirm("site1", "github_1", req.Code)
+	assert.EqualError(t, err, `can't link "github_1" to "github_1"`, "self link")
+
+	var nilService *Service
+	assert.Equal(t, "google_1", nilService.Canonical("site1", "google_1"))
+}
+
+func TestService_LinkAndUnlink(t *testing.T) {
+	st := prepStore(t)
+
+	fail := false
+	svc := NewService(st, func(_, fromID, _ string) error {
+		if fail {
+			return errors.New("failed")
+		}
+		return nil
+	}, 0)
+
+	_, err := svc.Link("site1", "google_1", "github_1")
+	require.NoError(t, err)
+	_, err = svc.Link("site1", "twitter_1", "google_1")
+	require.NoError(t, err)
+	assert.Equal(t, "github_1", svc.Canonical("site1", "twitter_1"), "linked to canonical user")
+
+	_, err = svc.Link("site1", "google_1", "email_1")
+	assert.EqualError(t, err, "identity google_1 already linked to github_1")
+
+	_, err = svc.Link("site1", "github_1", "email_1")
+	require.NoError(t, err, "canonical user linked to another one with its identities")
+	canonical, links, err := svc.Links("site1", "twitter_1")
+	require.NoError(t, err)
+	assert.Equal(t, "email_1", canonical)
+	ids := []string{}
+	for _, l := range links {
+		ids = append(ids, l.LinkedID)
+	}
+	assert.ElementsMatch(t, []string{"github_1", "google_1", "twitter_1"}, ids)
+
+	fail = true
+	_, err = svc.Link("site1", "yandex_1", "email_1")
+	assert.EqualError(t, err, "can't merge yandex_1 to email_1: failed")
+	assert.Equal(t, "yandex_1", svc.Canonical("site1", "yandex_1"), "not linked on failed merge")
+
+	require.NoError(t, svc.Unlink("site1", "google_1"))
+	assert.Equal(t, "google_1", svc.Canonical("site1", "google_1"))
+	assert.EqualError(t, svc.Unlink("site1", "google_1"), "identity google_1 not linked")
+}
+
+func TestEngineStore_DeleteExpired(t *testing.T) {
+	st := prepStore(t)
+
+	ts := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	require.NoError(t, st.SetRequest(Request{SiteID: "site1", UserID: "u1", Code: "c1", Expires: ts}))
+	require.NoError(t, st.SetRequest(Request{SiteID: "site1", UserID: "u2", Code: "c2", Expires: ts.Add(time.Hour)}))
+	require.NoError(t, st.DeleteExpired("site1", ts.Add(time.Minute)))
+	req, err := st.Request("site1", "c1")
+	require.NoError(t, err)
+	assert.Nil(t, req)
+	req, err = st.Request("site1", "c2")
+	require.NoError(t, err)
+	require.NotNil(t, req)
+	assert.Equal(t, "u2", req.UserID)
+}
+
+func prepStore(t *testing.T) *EngineStore {
+	eng, err := engine.NewMemory("", "site1", "site2")
+	require.NoError(t, err)
+	return NewEngineStore(eng)
+}
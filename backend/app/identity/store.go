@@ -0,0 +1,98 @@
+package identity
+
+import (
+	"time"
+
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// EngineStore implements Store with records of store engine, links kept along with comments of the site
+type EngineStore struct {
+	links    engine.Records // keyed by linkedID
+	requests engine.Records // keyed by code
+}
+
+// NewEngineStore makes store of links kept by eng
+func NewEngineStore(eng engine.Interface) *EngineStore {
+	return &EngineStore{links: engine.Records{Engine: eng, Kind: engine.Links},
+		requests: engine.Records{Engine: eng, Kind: engine.LinkRequests}}
+}
+
+// Link of the identity, nil if not linked
+func (e *EngineStore) Link(siteID, linkedID string) (*Link, error) {
+	res := Link{}
+	found, err := e.links.Get(siteID, linkedID, &res)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// SetLink of the identity, replacing previous one
+func (e *EngineStore) SetLink(link Link) error {
+	return e.links.Set(link.SiteID, link.LinkedID, link)
+}
+
+// DeleteLink of the identity, missing one ignored
+func (e *EngineStore) DeleteLink(siteID, linkedID string) error {
+	_, err := e.links.Delete(siteID, linkedID)
+	return err
+}
+
+// Links of identities to the canonical user on the site
+func (e *EngineStore) Links(siteID, userID string) (res []Link, err error) {
+	res = []Link{}
+	err = e.links.List(siteID, "", func(_ string, unmarshal func(v interface{}) error) error {
+		link := Link{}
+		if err := unmarshal(&link); err != nil {
+			return err
+		}
+		if link.UserID == userID {
+			res = append(res, link)
+		}
+		return nil
+	})
+	return res, err
+}
+
+// Request to link by code, nil if not found
+func (e *EngineStore) Request(siteID, code string) (*Request, error) {
+	res := Request{}
+	found, err := e.requests.Get(siteID, code, &res)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// SetRequest saves request to link
+func (e *EngineStore) SetRequest(req Request) error {
+	return e.requests.Set(req.SiteID, req.Code, req)
+}
+
+// DeleteRequest by code, missing one ignored
+func (e *EngineStore) DeleteRequest(siteID, code string) error {
+	_, err := e.requests.Delete(siteID, code)
+	return err
+}
+
+// DeleteExpired removes requests of the site expired before now
+func (e *EngineStore) DeleteExpired(siteID string, now time.Time) error {
+	var expired []string
+	err := e.requests.List(siteID, "", func(code string, unmarshal func(v interface{}) error) error {
+		req := Request{}
+		if err := unmarshal(&req); err != nil || req.Expires.Before(now) {
+			expired = append(expired, code)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, code := range expired {
+		if _, err = e.requests.Delete(siteID, code); err != nil {
+			return err
+		}
+	}
+	return nil
+}
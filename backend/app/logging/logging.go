@@ -0,0 +1,150 @@
+// Package logging switches lgr output to JSON lines and carries request scoped fields, request id, site and
+// user, in context. Fields added to messages logged with Printf only in JSON mode, text output stays unchanged.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+)
+
+// Fields of the request added to log messages, fields of operation set for messages about it only
+type Fields struct {
+	RequestID string `json:"request_id,omitempty"`
+	Site      string `json:"site,omitempty"`
+	User      string `json:"user,omitempty"`        // id of user, hashed by auth providers
+	Route     string `json:"route,omitempty"`       // route pattern of api call
+	URL       string `json:"url,omitempty"`         // url of post
+	Duration  int64  `json:"duration_ms,omitempty"` // duration of operation, in milliseconds
+	Size      int    `json:"size,omitempty"`        // size of response, in bytes
+}
+
+// RequestIDHeader keeps request id passed by proxy, returned in response
+const RequestIDHeader = "X-Request-ID"
+
+const (
+	partsSep  = "\x1f" // separates time, level and message in lines formatted by lgr
+	fieldsSep = "\x1e" // separates encoded fields appended to message
+	format    = `{{.DT.Format "2006-01-02T15:04:05.000Z07:00"}}` + partsSep + `{{.Level}}` + partsSep + `{{.Message}}`
+)
+
+var jsonMode int32
+
+type ctxKey struct{}
+
+// Setup sets global lgr to write JSON lines to stdout, errors written to stderr as well
+func Setup(dbg bool, stdout, stderr io.Writer) {
+	atomic.StoreInt32(&jsonMode, 1)
+	opts := []log.Option{log.Format(format), log.Out(NewWriter(stdout)), log.Err(NewWriter(stderr))}
+	if dbg {
+		opts = append(opts, log.Debug)
+	}
+	log.Setup(opts...)
+}
+
+// WithFields returns context keeping fields. User can be set later with SetUser on the returned context
+func WithFields(ctx context.Context, f Fields) context.Context {
+	return context.WithValue(ctx, ctxKey{}, &f)
+}
+
+// FromContext returns fields kept in context, empty if not set
+func FromContext(ctx context.Context) Fields {
+	if f, ok := ctx.Value(ctxKey{}).(*Fields); ok {
+		return *f
+	}
+	return Fields{}
+}
+
+// SetUser sets user of fields kept in context, ignored if context has no fields.
+// Called by handlers after authentication, with the context made by Middleware.
+func SetUser(ctx context.Context, user string) {
+	if f, ok := ctx.Value(ctxKey{}).(*Fields); ok {
+		f.User = user
+	}
+}
+
+// Printf logs message with fields of the context, level taken from the message prefix as lgr does
+func Printf(ctx context.Context, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if atomic.LoadInt32(&jsonMode) == 1 {
+		if f := FromContext(ctx); f != (Fields{}) {
+			if data, err := json.Marshal(f); err == nil {
+				msg += fieldsSep + string(data)
+			}
+		}
+	}
+	log.Print(msg)
+}
+
+// Middleware keeps fields of the request in context. Request id taken from X-Request-ID header or generated,
+// set in the header of request for the request logger and returned in response. Site taken from query.
+func Middleware(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if id == "" || len(id) > 64 {
+			id = newID()
+			r.Header.Set(RequestIDHeader, id)
+		}
+		w.Header().Set(RequestIDHeader, id)
+		ctx := WithFields(r.Context(), Fields{RequestID: id, Site: r.URL.Query().Get("site")})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+	return http.HandlerFunc(fn)
+}
+
+func newID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// record is a JSON line of log message
+type record struct {
+	TS    string `json:"ts"`
+	Level string `json:"level"`
+	Msg   string `json:"msg"`
+	Fields
+}
+
+type writer struct {
+	w io.Writer
+}
+
+// NewWriter makes writer converting lines formatted by lgr with Setup's format to JSON lines.
+// Lines in other formats written as messages with info level.
+func NewWriter(w io.Writer) io.Writer {
+	return &writer{w: w}
+}
+
+// Write converts single line written by lgr, multiline messages kept in msg
+func (l *writer) Write(p []byte) (int, error) {
+	line := strings.TrimSuffix(string(p), "\n")
+	rec := record{TS: time.Now().Format("2006-01-02T15:04:05.000Z07:00"), Level: "info", Msg: line}
+	if parts := strings.SplitN(line, partsSep, 3); len(parts) == 3 {
+		rec.TS, rec.Level, rec.Msg = parts[0], strings.ToLower(strings.TrimSpace(parts[1])), parts[2]
+	}
+	if i := strings.LastIndex(rec.Msg, fieldsSep); i >= 0 {
+		if err := json.Unmarshal([]byte(rec.Msg[i+len(fieldsSep):]), &rec.Fields); err == nil {
+			rec.Msg = rec.Msg[:i]
+		}
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = l.w.Write(append(data, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
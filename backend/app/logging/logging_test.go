@@ -0,0 +1,104 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetup(t *testing.T) {
+	defer func() {
+		atomic.StoreInt32(&jsonMode, 0)
+		log.Setup(log.Msec, log.LevelBraces)
+	}()
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	Setup(false, stdout, stderr)
+
+	ctx := WithFields(context.Background(), Fields{RequestID: "123", Site: "remark"})
+	SetUser(ctx, "github_abc")
+	Printf(ctx, "[WARN] can't send to %s", "email")
+	log.Printf("[DEBUG] not logged")
+	Printf(context.Background(), "[ERROR] failed\nwith details")
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	require.Equal(t, 2, len(lines), stdout.String())
+
+	rec := map[string]string{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &rec))
+	assert.NotEmpty(t, rec["ts"])
+	delete(rec, "ts")
+	assert.Equal(t, map[string]string{"level": "warn", "msg": "can't send to email", "request_id": "123",
+		"site": "remark", "user": "github_abc"}, rec)
+
+	rec = map[string]string{}
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &rec))
+	assert.Equal(t, "error", rec["level"])
+	assert.Equal(t, "failed\nwith details", rec["msg"])
+	assert.NotContains(t, rec, "request_id")
+	assert.Equal(t, lines[1]+"\n", stderr.String(), "errors written to stderr too")
+}
+
+func TestPrintf_TextMode(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log.Setup(log.Out(buf), log.Err(buf))
+	defer log.Setup(log.Msec, log.LevelBraces)
+
+	Printf(WithFields(context.Background(), Fields{RequestID: "123"}), "[INFO] message %d", 1)
+	assert.True(t, strings.HasSuffix(buf.String(), "INFO  message 1\n"), buf.String())
+}
+
+func TestWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	n, err := w.Write([]byte("some text\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 10, n)
+	rec := map[string]string{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &rec))
+	assert.Equal(t, "info", rec["level"])
+	assert.Equal(t, "some text", rec["msg"])
+
+	buf.Reset()
+	_, err = w.Write([]byte("2021-01-02T10:11:12.000Z" + partsSep + "INFO " + partsSep + "msg" + fieldsSep + "not json\n"))
+	require.NoError(t, err)
+	assert.Equal(t, `{"ts":"2021-01-02T10:11:12.000Z","level":"info","msg":"msg\u001enot json"}`+"\n", buf.String())
+}
+
+func TestFromContext(t *testing.T) {
+	assert.Equal(t, Fields{}, FromContext(context.Background()))
+	SetUser(context.Background(), "user") // no fields, ignored
+	ctx := WithFields(context.Background(), Fields{Site: "remark"})
+	SetUser(ctx, "user")
+	assert.Equal(t, Fields{Site: "remark", User: "user"}, FromContext(ctx))
+}
+
+func TestMiddleware(t *testing.T) {
+	var fields Fields
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fields = FromContext(r.Context())
+		assert.Equal(t, fields.RequestID, r.Header.Get(RequestIDHeader), "set for request logger")
+	}))
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/find?site=remark&url=blah", nil)
+	req.Header.Set(RequestIDHeader, "req-1")
+	h.ServeHTTP(rr, req)
+	assert.Equal(t, Fields{RequestID: "req-1", Site: "remark"}, fields)
+	assert.Equal(t, "req-1", rr.Header().Get(RequestIDHeader))
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/config", nil))
+	assert.Len(t, fields.RequestID, 24, "generated")
+	assert.Equal(t, fields.RequestID, rr.Header().Get(RequestIDHeader))
+	assert.Equal(t, "", fields.Site)
+}
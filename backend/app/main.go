@@ -11,22 +11,31 @@ import (
 	"github.com/umputun/go-flags"
 
 	"github.com/umputun/remark42/backend/app/cmd"
+	"github.com/umputun/remark42/backend/app/logging"
 )
 
 // Opts with all cli commands and flags
 type Opts struct {
-	ServerCmd  cmd.ServerCommand  `command:"server"`
-	ImportCmd  cmd.ImportCommand  `command:"import"`
-	BackupCmd  cmd.BackupCommand  `command:"backup"`
-	RestoreCmd cmd.RestoreCommand `command:"restore"`
-	AvatarCmd  cmd.AvatarCommand  `command:"avatar"`
-	CleanupCmd cmd.CleanupCommand `command:"cleanup"`
-	RemapCmd   cmd.RemapCommand   `command:"remap"`
+	ServerCmd    cmd.ServerCommand    `command:"server"`
+	ImportCmd    cmd.ImportCommand    `command:"import"`
+	BackupCmd    cmd.BackupCommand    `command:"backup"`
+	RestoreCmd   cmd.RestoreCommand   `command:"restore"`
+	AvatarCmd    cmd.AvatarCommand    `command:"avatar"`
+	CleanupCmd   cmd.CleanupCommand   `command:"cleanup"`
+	RemapCmd     cmd.RemapCommand     `command:"remap"`
+	IntegrityCmd cmd.IntegrityCommand `command:"integrity"`
+	CompactCmd   cmd.CompactCommand   `command:"compact"`
+	ReindexCmd   cmd.ReindexCommand   `command:"reindex"`
+	EncryptCmd   cmd.EncryptCommand   `command:"encrypt"`
+	RotateJWTCmd cmd.RotateJWTCommand `command:"rotate-jwt"`
+	StaticCmd    cmd.StaticCommand    `command:"static"`
+	RenotifyCmd  cmd.RenotifyCommand  `command:"renotify"`
 
 	RemarkURL    string `long:"url" env:"REMARK_URL" required:"true" description:"url to remark"`
 	SharedSecret string `long:"secret" env:"SECRET" required:"true" description:"shared secret key used to sign JWT, should be a random, long, hard-to-guess string"`
 
-	Dbg bool `long:"dbg" env:"DEBUG" description:"debug mode"`
+	Dbg     bool `long:"dbg" env:"DEBUG" description:"debug mode"`
+	LogJSON bool `long:"log-json" env:"LOG_JSON" description:"log json lines with request id, site and user"`
 }
 
 var revision = "unknown"
@@ -37,7 +46,7 @@ func main() {
 	var opts Opts
 	p := flags.NewParser(&opts, flags.Default)
 	p.CommandHandler = func(command flags.Commander, args []string) error {
-		setupLog(opts.Dbg)
+		setupLog(opts.Dbg, opts.LogJSON)
 		// commands implements CommonOptionsCommander to allow passing set of extra options defined for all commands
 		c := command.(cmd.CommonOptionsCommander)
 		c.SetCommon(cmd.CommonOpts{
@@ -68,7 +77,11 @@ func main() {
 	}
 }
 
-func setupLog(dbg bool) {
+func setupLog(dbg, jsonLines bool) {
+	if jsonLines {
+		logging.Setup(dbg, os.Stdout, os.Stderr)
+		return
+	}
 	if dbg {
 		log.Setup(log.Debug, log.CallerFile, log.CallerFunc, log.Msec, log.LevelBraces)
 		return
@@ -89,10 +89,11 @@ func waitForHTTPServerStart(port int) {
 }
 
 func TestMain(m *testing.M) {
-	// both ignores are for leaks which are detected locally
+	// first two ignores are for leaks which are detected locally, bleve starts global analysis workers on init
 	goleak.VerifyTestMain(
 		m,
 		goleak.IgnoreTopFunction("github.com/umputun/remark42/backend/app.init.0.func1"),
 		goleak.IgnoreTopFunction("net/http.(*Server).Shutdown"),
+		goleak.IgnoreTopFunction("github.com/blevesearch/bleve/index.AnalysisWorker"),
 	)
 }
@@ -0,0 +1,158 @@
+// Package metrics collects prometheus metrics of comments, votes, cache, notifications and http requests,
+// exported with Handler. All methods are safe to call on nil Metrics, so collection is optional for callers.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	cache "github.com/go-pkgz/lcw"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "remark42"
+
+// Metrics keeps collectors in own registry
+type Metrics struct {
+	registry *prometheus.Registry
+	comments *prometheus.CounterVec
+	votes    *prometheus.CounterVec
+	notify   *prometheus.CounterVec
+	email    *prometheus.HistogramVec
+	breakers *prometheus.GaugeVec
+	http     *prometheus.HistogramVec
+}
+
+// New makes Metrics with registered collectors, including go runtime and process ones
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		comments: prometheus.NewCounterVec(prometheus.CounterOpts{Namespace: namespace, Name: "comments_total",
+			Help: "Number of created and deleted comments"}, []string{"site", "action"}),
+		votes: prometheus.NewCounterVec(prometheus.CounterOpts{Namespace: namespace, Name: "votes_total",
+			Help: "Number of votes for comments"}, []string{"site", "direction"}),
+		notify: prometheus.NewCounterVec(prometheus.CounterOpts{Namespace: namespace, Name: "notifications_total",
+			Help: "Number of sent notifications by destination and status"}, []string{"destination", "status"}),
+		email: prometheus.NewHistogramVec(prometheus.HistogramOpts{Namespace: namespace, Name: "email_send_duration_seconds",
+			Help: "Duration of sending email with smtp server or api", Buckets: prometheus.DefBuckets}, []string{"status"}),
+		breakers: prometheus.NewGaugeVec(prometheus.GaugeOpts{Namespace: namespace, Name: "notification_circuit_open",
+			Help: "Circuit breaker of notification destination open, 1 if sending skipped"}, []string{"destination"}),
+		http: prometheus.NewHistogramVec(prometheus.HistogramOpts{Namespace: namespace, Name: "http_request_duration_seconds",
+			Help: "Duration of http requests by route", Buckets: prometheus.DefBuckets}, []string{"route", "method", "code"}),
+	}
+	m.registry.MustRegister(m.comments, m.votes, m.notify, m.email, m.breakers, m.http,
+		prometheus.NewGoCollector(), prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+	return m
+}
+
+// Handler returns http handler exporting metrics in prometheus format
+func (m *Metrics) Handler() http.Handler {
+	if m == nil {
+		return http.NotFoundHandler()
+	}
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// CommentCreated counts new comment of the site
+func (m *Metrics) CommentCreated(siteID string) {
+	if m == nil {
+		return
+	}
+	m.comments.WithLabelValues(siteID, "create").Inc()
+}
+
+// CommentDeleted counts deleted comment of the site
+func (m *Metrics) CommentDeleted(siteID string) {
+	if m == nil {
+		return
+	}
+	m.comments.WithLabelValues(siteID, "delete").Inc()
+}
+
+// Voted counts vote for comment of the site
+func (m *Metrics) Voted(siteID string, up bool) {
+	if m == nil {
+		return
+	}
+	direction := "down"
+	if up {
+		direction = "up"
+	}
+	m.votes.WithLabelValues(siteID, direction).Inc()
+}
+
+// NotificationSent counts notification sent to destination, like email or telegram, failed if err is not nil
+func (m *Metrics) NotificationSent(destination string, err error) {
+	if m == nil {
+		return
+	}
+	m.notify.WithLabelValues(destination, status(err)).Inc()
+}
+
+// EmailSent observes duration of email sending
+func (m *Metrics) EmailSent(duration time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.email.WithLabelValues(status(err)).Observe(duration.Seconds())
+}
+
+// BreakerChanged sets state of circuit breaker of notification destination
+func (m *Metrics) BreakerChanged(destination string, open bool) {
+	if m == nil {
+		return
+	}
+	val := 0.0
+	if open {
+		val = 1
+	}
+	m.breakers.WithLabelValues(destination).Set(val)
+}
+
+// WatchCache exports hits, misses and number of keys of the cache
+func (m *Metrics) WatchCache(stat func() cache.CacheStat) {
+	if m == nil {
+		return
+	}
+	m.registry.MustRegister(
+		prometheus.NewCounterFunc(prometheus.CounterOpts{Namespace: namespace, Name: "cache_hits_total",
+			Help: "Number of cache hits"}, func() float64 { return float64(stat().Hits) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{Namespace: namespace, Name: "cache_misses_total",
+			Help: "Number of cache misses"}, func() float64 { return float64(stat().Misses) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{Namespace: namespace, Name: "cache_keys",
+			Help: "Number of keys in cache"}, func() float64 { return float64(stat().Keys) }),
+	)
+}
+
+// Middleware observes duration of http requests by route pattern, not by url to keep number of series limited
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	if m == nil {
+		return next
+	}
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		st := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+		route := "not_found"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		code := ww.Status()
+		if code == 0 {
+			code = http.StatusOK
+		}
+		m.http.WithLabelValues(route, r.Method, strconv.Itoa(code)).Observe(time.Since(st).Seconds())
+	}
+	return http.HandlerFunc(fn)
+}
+
+func status(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	cache "github.com/go-pkgz/lcw"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	m := New()
+	m.CommentCreated("site1")
+	m.CommentCreated("site1")
+	m.CommentDeleted("site1")
+	m.Voted("site1", true)
+	m.Voted("site2", false)
+	m.NotificationSent("email", nil)
+	m.NotificationSent("telegram", errors.New("failed"))
+	m.EmailSent(time.Millisecond*100, nil)
+	m.BreakerChanged("telegram", true)
+	m.BreakerChanged("email", true)
+	m.BreakerChanged("email", false)
+	m.WatchCache(func() cache.CacheStat { return cache.CacheStat{Hits: 10, Misses: 5, Keys: 3} })
+
+	router := chi.NewRouter()
+	router.Use(m.Middleware)
+	router.Get("/api/v1/find/{id}", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) })
+	router.Handle("/metrics", m.Handler())
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/v1/find/123")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusTeapot, resp.StatusCode)
+
+	resp, err = http.Get(ts.URL + "/metrics")
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	res := string(body)
+
+	for _, line := range []string{
+		`remark42_comments_total{action="create",site="site1"} 2`,
+		`remark42_comments_total{action="delete",site="site1"} 1`,
+		`remark42_votes_total{direction="up",site="site1"} 1`,
+		`remark42_votes_total{direction="down",site="site2"} 1`,
+		`remark42_notifications_total{destination="email",status="success"} 1`,
+		`remark42_notifications_total{destination="telegram",status="failure"} 1`,
+		`remark42_email_send_duration_seconds_count{status="success"} 1`,
+		`remark42_notification_circuit_open{destination="telegram"} 1`,
+		`remark42_notification_circuit_open{destination="email"} 0`,
+		`remark42_cache_hits_total 10`,
+		`remark42_cache_misses_total 5`,
+		`remark42_cache_keys 3`,
+		`remark42_http_request_duration_seconds_count{code="418",method="GET",route="/api/v1/find/{id}"} 1`,
+		`go_goroutines`,
+	} {
+		assert.Contains(t, res, line)
+	}
+}
+
+func TestMetrics_Nil(t *testing.T) {
+	var m *Metrics
+	m.CommentCreated("site1")
+	m.CommentDeleted("site1")
+	m.Voted("site1", true)
+	m.NotificationSent("email", nil)
+	m.EmailSent(time.Second, nil)
+	m.BreakerChanged("email", true)
+	m.WatchCache(func() cache.CacheStat { return cache.CacheStat{} })
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	assert.NotNil(t, m.Middleware(h))
+	rr := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
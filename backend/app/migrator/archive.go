@@ -0,0 +1,215 @@
+package migrator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/templates"
+)
+
+const archiveVersion = 1
+const defaultArchiveTemplatePath = "archive.html.tmpl"
+
+// ArchiveStore defines minimal interface needed to freeze and archive posts
+type ArchiveStore interface {
+	Find(locator store.Locator, sort string, user store.User) ([]store.Comment, error)
+	SetReadOnly(locator store.Locator, status bool) error
+	DeletePost(locator store.Locator) error
+}
+
+// Archiver freezes post, i.e. makes it read-only, and saves the whole thread to static json and html files.
+// Optionally removes archived post from the store, for pruning of huge old threads.
+type Archiver struct {
+	DataStore    ArchiveStore
+	Location     string // directory for archive files
+	TemplatePath string // path to html template, archive.html.tmpl by default
+}
+
+// ArchiveResult describes archived post
+type ArchiveResult struct {
+	Locator  store.Locator `json:"locator"`
+	Comments int           `json:"comments"`
+	JSONFile string        `json:"json_file"`
+	HTMLFile string        `json:"html_file"`
+	Removed  bool          `json:"removed"`
+}
+
+// PostArchive is a content of json archive
+type PostArchive struct {
+	Version    int             `json:"version"`
+	Locator    store.Locator   `json:"locator"`
+	Title      string          `json:"title,omitempty"`
+	ArchivedAt time.Time       `json:"archived_at"`
+	Comments   []store.Comment `json:"comments"`
+}
+
+// archiveNode is a comment with replies, used for html rendering
+type archiveNode struct {
+	Comment store.Comment
+	Replies []archiveNode
+}
+
+// Archive freezes the post and writes its comments to json and html files. If remove set, post deleted from the store
+// after successful archiving. Archive files rewritten on repeated calls.
+func (a *Archiver) Archive(locator store.Locator, remove bool) (ArchiveResult, error) {
+	res := ArchiveResult{Locator: locator}
+	if locator.SiteID == "" || locator.URL == "" {
+		return res, errors.New("site and url are required")
+	}
+
+	// freeze first to prevent new comments during archiving
+	if err := a.DataStore.SetReadOnly(locator, true); err != nil {
+		return res, errors.Wrapf(err, "can't freeze %s", locator.URL)
+	}
+
+	comments, err := a.DataStore.Find(locator, "time", adminUser)
+	if err != nil {
+		return res, errors.Wrapf(err, "can't get comments for %s", locator.URL)
+	}
+	if len(comments) == 0 {
+		return res, errors.Errorf("no comments for %s", locator.URL)
+	}
+
+	arch := PostArchive{Version: archiveVersion, Locator: locator, ArchivedAt: time.Now(), Comments: comments}
+	for i := range arch.Comments {
+		arch.Comments[i].User.IP = "" // archive made for viewing, ip not needed
+		if arch.Title == "" {
+			arch.Title = arch.Comments[i].PostTitle
+		}
+	}
+	res.Comments = len(comments)
+
+	if err = os.MkdirAll(a.Location, 0700); err != nil {
+		return res, errors.Wrapf(err, "can't make archive location %s", a.Location)
+	}
+	res.JSONFile, res.HTMLFile = a.files(locator)
+
+	data, err := json.MarshalIndent(arch, "", "  ")
+	if err != nil {
+		return res, errors.Wrap(err, "can't marshal archive")
+	}
+	if err = ioutil.WriteFile(res.JSONFile, data, 0600); err != nil {
+		return res, errors.Wrapf(err, "can't write %s", res.JSONFile)
+	}
+
+	page, err := a.renderHTML(arch)
+	if err != nil {
+		return res, err
+	}
+	if err = ioutil.WriteFile(res.HTMLFile, page, 0600); err != nil {
+		return res, errors.Wrapf(err, "can't write %s", res.HTMLFile)
+	}
+	log.Printf("[INFO] archived %d comments of %s to %s", res.Comments, locator.URL, res.JSONFile)
+
+	if remove {
+		if err = a.DataStore.DeletePost(locator); err != nil {
+			return res, errors.Wrapf(err, "can't remove archived %s", locator.URL)
+		}
+		res.Removed = true
+		log.Printf("[INFO] removed archived post %s", locator.URL)
+	}
+	return res, nil
+}
+
+// Read returns json or html content of previously archived post
+func (a *Archiver) Read(locator store.Locator, asHTML bool) ([]byte, error) {
+	jsonFile, htmlFile := a.files(locator)
+	file := jsonFile
+	if asHTML {
+		file = htmlFile
+	}
+	data, err := ioutil.ReadFile(file) // nolint
+	if err != nil {
+		return nil, errors.Wrapf(err, "no archive for %s", locator.URL)
+	}
+	return data, nil
+}
+
+// files returns paths to json and html archive files of the post
+func (a *Archiver) files(locator store.Locator) (jsonFile, htmlFile string) {
+	name := fmt.Sprintf("archive-%s-%s", locator.SiteID, store.EncodeID(locator.URL))
+	return path.Join(a.Location, name+".json"), path.Join(a.Location, name+".html")
+}
+
+// renderHTML makes static html page with comments tree, avatars referenced by their urls
+func (a *Archiver) renderHTML(arch PostArchive) ([]byte, error) {
+	return renderArchive(a.TemplatePath, archivePage{PostArchive: arch}, linkAvatar)
+}
+
+// archivePage is data of archive template
+type archivePage struct {
+	PostArchive
+	Tree  []archiveNode
+	Index string // optional, link to the list of archived posts
+}
+
+// renderArchive makes static html page with comments tree, avatar func returns src of user's picture, empty to skip it
+func renderArchive(tmplPath string, page archivePage, avatar func(picture string) template.URL) ([]byte, error) {
+	if tmplPath == "" {
+		tmplPath = defaultArchiveTemplatePath
+	}
+	tmplFile, err := templates.NewFS().ReadFile(tmplPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't read archive template")
+	}
+	tmpl, err := template.New("archive").Funcs(template.FuncMap{
+		"safeHTML": func(s string) template.HTML { return template.HTML(s) }, // nolint:gosec // comment text sanitized on save
+		"avatar":   avatar,
+	}).Parse(string(tmplFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "can't parse archive template")
+	}
+
+	page.Tree = makeArchiveTree(page.Comments)
+	buf := bytes.Buffer{}
+	if err = tmpl.Execute(&buf, page); err != nil {
+		return nil, errors.Wrap(err, "can't render archive")
+	}
+	return buf.Bytes(), nil
+}
+
+// linkAvatar returns http(s) url of the picture as is, other urls skipped
+func linkAvatar(picture string) template.URL {
+	if u, err := url.Parse(picture); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	return template.URL(picture) // nolint:gosec // only http(s) urls allowed
+}
+
+// makeArchiveTree builds comments tree from time-ordered list. Replies to unknown parents shown as top-level.
+func makeArchiveTree(comments []store.Comment) []archiveNode {
+	known := map[string]bool{}
+	children := map[string][]store.Comment{}
+	for _, c := range comments {
+		known[c.ID] = true
+	}
+	var top []store.Comment
+	for _, c := range comments {
+		if c.ParentID == "" || !known[c.ParentID] {
+			top = append(top, c)
+			continue
+		}
+		children[c.ParentID] = append(children[c.ParentID], c)
+	}
+
+	var build func(cc []store.Comment) []archiveNode
+	build = func(cc []store.Comment) []archiveNode {
+		res := make([]archiveNode, 0, len(cc))
+		for _, c := range cc {
+			res = append(res, archiveNode{Comment: c, Replies: build(children[c.ID])})
+		}
+		return res
+	}
+	return build(top)
+}
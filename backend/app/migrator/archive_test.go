@@ -0,0 +1,93 @@
+package migrator
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestArchiver_Archive(t *testing.T) {
+	b, teardown := prep(t) // write 2 comments
+	defer teardown()
+
+	loc, err := ioutil.TempDir("", "archive")
+	require.NoError(t, err)
+	defer os.RemoveAll(loc)
+
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	reply := store.Comment{ID: "reply-1", ParentID: "efbc17f177ee1a1c0ee6e1e025749966ec071adc", Text: "<p>reply <b>text</b></p>",
+		Timestamp: time.Date(2017, 12, 20, 15, 19, 22, 0, time.Local), Locator: locator, PostTitle: "Post title",
+		User: store.User{ID: "user2", Name: "other user", IP: "127.0.0.1"}}
+	_, err = b.Create(reply)
+	require.NoError(t, err)
+
+	a := Archiver{DataStore: b, Location: loc + "/sub", TemplatePath: "../../templates/archive.html.tmpl"}
+	res, err := a.Archive(locator, false)
+	require.NoError(t, err)
+	assert.Equal(t, 2, res.Comments)
+	assert.False(t, res.Removed)
+	assert.True(t, strings.HasPrefix(res.JSONFile, loc+"/sub/archive-radio-t-"), res.JSONFile)
+	assert.True(t, b.IsReadOnly(locator), "post frozen")
+
+	data, err := a.Read(locator, false)
+	require.NoError(t, err)
+	arch := PostArchive{}
+	require.NoError(t, json.Unmarshal(data, &arch))
+	assert.Equal(t, 1, arch.Version)
+	assert.Equal(t, "Post title", arch.Title)
+	require.Equal(t, 2, len(arch.Comments))
+	assert.Equal(t, "reply-1", arch.Comments[1].ID)
+	assert.Equal(t, "", arch.Comments[1].User.IP, "ip removed")
+
+	page, err := a.Read(locator, true)
+	require.NoError(t, err)
+	assert.Contains(t, string(page), "<title>Post title - archived comments</title>")
+	assert.Contains(t, string(page), `<div class="replies">`)
+	assert.Contains(t, string(page), "<div><p>reply <b>text</b></p></div>", "comment html kept")
+	assert.Contains(t, string(page), `<a href="http://radio-t.com" rel="nofollow">link</a>`)
+
+	comments, err := b.Find(locator, "time", adminUser)
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(comments), "comments kept in store")
+
+	// archive again with removal
+	res, err = a.Archive(locator, true)
+	require.NoError(t, err)
+	assert.True(t, res.Removed)
+	_, err = b.Find(locator, "time", adminUser)
+	assert.Error(t, err, "post removed from store")
+	posts, err := b.List("radio-t", 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(posts), "other post kept")
+	_, err = a.Read(locator, true)
+	assert.NoError(t, err, "archive still available")
+
+	_, err = a.Archive(locator, false)
+	assert.Error(t, err, "nothing to archive")
+	_, err = a.Archive(store.Locator{SiteID: "radio-t"}, false)
+	assert.EqualError(t, err, "site and url are required")
+	_, err = a.Read(store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}, false)
+	assert.Error(t, err)
+}
+
+func Test_makeArchiveTree(t *testing.T) {
+	comments := []store.Comment{{ID: "1"}, {ID: "2", ParentID: "1"}, {ID: "3"}, {ID: "4", ParentID: "2"},
+		{ID: "5", ParentID: "1"}, {ID: "6", ParentID: "unknown"}}
+	tree := makeArchiveTree(comments)
+	require.Equal(t, 3, len(tree))
+	assert.Equal(t, "1", tree[0].Comment.ID)
+	require.Equal(t, 2, len(tree[0].Replies))
+	assert.Equal(t, "2", tree[0].Replies[0].Comment.ID)
+	assert.Equal(t, "4", tree[0].Replies[0].Replies[0].Comment.ID)
+	assert.Equal(t, "5", tree[0].Replies[1].Comment.ID)
+	assert.Equal(t, "3", tree[1].Comment.ID)
+	assert.Equal(t, "6", tree[2].Comment.ID, "orphan shown as top-level")
+}
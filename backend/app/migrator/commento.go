@@ -0,0 +1,118 @@
+package migrator
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// Commento implements Importer from Commento json export
+type Commento struct {
+	DataStore Store
+}
+
+type commentoExport struct {
+	Version    int                 `json:"version"`
+	Comments   []commentoComment   `json:"comments"`
+	Commenters []commentoCommenter `json:"commenters"`
+}
+
+type commentoComment struct {
+	CommentHex   string    `json:"commentHex"`
+	Domain       string    `json:"domain"`
+	Path         string    `json:"path"`
+	CommenterHex string    `json:"commenterHex"`
+	Markdown     string    `json:"markdown"`
+	ParentHex    string    `json:"parentHex"`
+	State        string    `json:"state"`
+	CreationDate time.Time `json:"creationDate"`
+	Deleted      bool      `json:"deleted"`
+}
+
+type commentoCommenter struct {
+	CommenterHex string `json:"commenterHex"`
+	Name         string `json:"name"`
+	Photo        string `json:"photo"`
+}
+
+// Import comments from Commento and save to store
+func (c *Commento) Import(r io.Reader, siteID string) (size int, err error) {
+	comments, err := c.convert(r, siteID)
+	if err != nil {
+		return 0, err
+	}
+	if e := c.DataStore.DeleteAll(siteID); e != nil {
+		return 0, e
+	}
+	return saveComments(c.DataStore, comments, siteID)
+}
+
+func (c *Commento) convert(r io.Reader, siteID string) ([]store.Comment, error) {
+	exp := commentoExport{}
+	if err := json.NewDecoder(r).Decode(&exp); err != nil {
+		return nil, errors.Wrap(err, "can't decode commento export")
+	}
+
+	users := map[string]commentoCommenter{}
+	for _, u := range exp.Commenters {
+		users[u.CommenterHex] = u
+	}
+
+	commentFormatter := store.NewCommentFormatter()
+	res := make([]store.Comment, 0, len(exp.Comments))
+	skipped := 0
+	for _, cc := range exp.Comments {
+		if cc.Deleted || cc.State != "approved" {
+			skipped++
+			continue
+		}
+		comment := store.Comment{
+			ID:        cc.CommentHex,
+			Locator:   store.Locator{SiteID: siteID, URL: "https://" + cc.Domain + cc.Path},
+			User:      store.User{ID: "anonymous", Name: "Anonymous"},
+			Text:      cc.Markdown,
+			Timestamp: cc.CreationDate,
+			Imported:  true,
+		}
+		if cc.ParentHex != "root" {
+			comment.ParentID = cc.ParentHex
+		}
+		if u, ok := users[cc.CommenterHex]; ok && cc.CommenterHex != "anonymous" {
+			comment.User = store.User{ID: "commento_" + store.EncodeID(u.CommenterHex), Name: u.Name}
+			if strings.HasPrefix(u.Photo, "http") {
+				comment.User.Picture = u.Photo
+			}
+		}
+		res = append(res, commentFormatter.Format(comment))
+	}
+	log.Printf("[INFO] converted %d commento comments, skipped %d", len(res), skipped)
+	return res, nil
+}
+
+// saveComments creates converted comments in the store, fails if no comment saved
+func saveComments(dataStore Store, comments []store.Comment, siteID string) (size int, err error) {
+	failed, passed := 0, 0
+	for _, c := range comments {
+		if _, e := dataStore.Create(c); e != nil {
+			log.Printf("[DEBUG] can't save comment %s, %v", c.ID, e)
+			failed++
+			continue
+		}
+		passed++
+	}
+
+	if failed > 0 {
+		err = errors.Errorf("failed to save %d comments", failed)
+		if passed == 0 {
+			err = errors.New("import failed")
+		}
+	}
+	log.Printf("[DEBUG] imported %d comments to site %s", passed, siteID)
+	return passed, err
+}
@@ -0,0 +1,83 @@
+package migrator
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/engine"
+	"github.com/umputun/remark42/backend/app/store/service"
+)
+
+func TestCommento_Import(t *testing.T) {
+	siteID := "testCommento"
+	defer func() { _ = os.Remove("/tmp/remark-test.db") }()
+	b, err := engine.NewBoltDB(bolt.Options{}, engine.BoltSite{FileName: "/tmp/remark-test.db", SiteID: siteID})
+	require.NoError(t, err, "create store")
+
+	dataStore := service.DataStore{Engine: b, AdminStore: admin.NewStaticStore("12345", nil, []string{}, "")}
+	defer dataStore.Close()
+	c := Commento{DataStore: &dataStore}
+	size, err := c.Import(strings.NewReader(jsonTestCommento), siteID)
+	require.NoError(t, err)
+	assert.Equal(t, 3, size)
+
+	comments, err := dataStore.Find(store.Locator{SiteID: siteID, URL: "https://example.com/post1/"}, "time", adminUser)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(comments))
+	assert.Equal(t, "", comments[0].ParentID)
+	assert.Equal(t, comments[0].ID, comments[1].ParentID, "reply")
+
+	_, err = c.Import(strings.NewReader("bad json"), siteID)
+	assert.Error(t, err)
+}
+
+func TestCommento_Convert(t *testing.T) {
+	c := Commento{}
+	comments, err := c.convert(strings.NewReader(jsonTestCommento), "testCommento")
+	require.NoError(t, err)
+	require.Equal(t, 3, len(comments), "unapproved and deleted skipped")
+
+	assert.Equal(t, store.Comment{
+		ID:        "c1",
+		Locator:   store.Locator{SiteID: "testCommento", URL: "https://example.com/post1/"},
+		User:      store.User{ID: "commento_" + store.EncodeID("u1"), Name: "John Doe", Picture: "https://example.com/john.png"},
+		Text:      "<p>first <strong>comment</strong></p>\n",
+		Timestamp: time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC),
+		Imported:  true,
+	}, comments[0])
+	assert.Equal(t, "c1", comments[1].ParentID)
+	assert.Equal(t, store.User{ID: "anonymous", Name: "Anonymous"}, comments[1].User)
+	assert.Equal(t, "https://example.com/post2", comments[2].Locator.URL)
+	assert.Equal(t, "", comments[2].User.Picture, "undefined photo skipped")
+}
+
+const jsonTestCommento = `{
+  "version": 1,
+  "comments": [
+    {"commentHex": "c1", "domain": "example.com", "path": "/post1/", "commenterHex": "u1", "markdown": "first **comment**",
+      "html": "<p>first <b>comment</b></p>", "parentHex": "root", "score": 1, "state": "approved",
+      "creationDate": "2020-05-01T10:00:00Z", "direction": 0, "deleted": false},
+    {"commentHex": "c2", "domain": "example.com", "path": "/post1/", "commenterHex": "anonymous", "markdown": "reply",
+      "parentHex": "c1", "state": "approved", "creationDate": "2020-05-01T11:00:00Z", "deleted": false},
+    {"commentHex": "c3", "domain": "example.com", "path": "/post2", "commenterHex": "u2", "markdown": "another post",
+      "parentHex": "root", "state": "approved", "creationDate": "2020-05-02T10:00:00Z", "deleted": false},
+    {"commentHex": "c4", "domain": "example.com", "path": "/post2", "commenterHex": "u2", "markdown": "spam",
+      "parentHex": "root", "state": "unapproved", "creationDate": "2020-05-02T11:00:00Z", "deleted": false},
+    {"commentHex": "c5", "domain": "example.com", "path": "/post2", "commenterHex": "u2", "markdown": "[deleted]",
+      "parentHex": "root", "state": "approved", "creationDate": "2020-05-02T12:00:00Z", "deleted": true}
+  ],
+  "commenters": [
+    {"commenterHex": "u1", "email": "john@example.com", "name": "John Doe", "link": "undefined",
+      "photo": "https://example.com/john.png", "provider": "commento", "joinDate": "2020-04-01T10:00:00Z"},
+    {"commenterHex": "u2", "email": "jane@example.com", "name": "Jane", "link": "undefined",
+      "photo": "undefined", "provider": "google", "joinDate": "2020-04-01T10:00:00Z"}
+  ]
+}`
@@ -2,21 +2,37 @@ package migrator
 
 import (
 	"encoding/xml"
+	"fmt"
 	"io"
+	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
+	"github.com/PuerkitoBio/goquery"
 	log "github.com/go-pkgz/lgr"
 	"github.com/pkg/errors"
 
 	"github.com/umputun/remark42/backend/app/store"
 )
 
-// Disqus implements Importer from disqus xml
+// Disqus implements Importer from disqus xml. Likes and dislikes of comments imported as synthetic votes.
+// With ImageService set, media attachments hosted by disqus downloaded to the image store and urls rewritten.
 type Disqus struct {
-	DataStore Store
+	DataStore    Store
+	ImageService ImageSaver   // optional, media attachments kept as links to disqus if nil
+	ImageAPI     string       // image api url prefix, i.e. https://remark.example.com/api/v1/picture/
+	HTTPClient   *http.Client // optional, client with default timeout used if nil
 }
 
+// ImageSaver defines interface to save downloaded images, returns id of the saved image
+type ImageSaver interface {
+	Save(userID string, r io.Reader) (id string, err error)
+}
+
+// disqusMediaHosts serve media attachments of disqus comments
+var disqusMediaHosts = []string{"uploads.disquscdn.com", "media.disquscdn.com"}
+
 type disqusThread struct {
 	UID         string    `xml:"id,attr"`
 	Forum       string    `xml:"forum"`
@@ -45,6 +61,8 @@ type disqusComment struct {
 	Pid            uid       `xml:"parent"`
 	IsSpam         bool      `xml:"isSpam"`
 	Deleted        bool      `xml:"isDeleted"`
+	Likes          int       `xml:"likes"`
+	Dislikes       int       `xml:"dislikes"`
 }
 
 type uid struct {
@@ -92,6 +110,7 @@ func (d *Disqus) convert(r io.Reader, siteID string) (ch chan store.Comment) {
 		commentsCount, spamComments      int
 		failedThreads, failedPosts       int
 		deletedComments, skippedComments int
+		importedMedia, failedMedia       int
 	}{}
 
 	go func() {
@@ -161,6 +180,13 @@ func (d *Disqus) convert(r io.Reader, siteID string) (ch chan store.Comment) {
 					if c.ID == "" { // no comment.UID
 						c.ID = comment.ID
 					}
+					c.Votes, c.Score = d.votes(comment.Likes, comment.Dislikes)
+					if d.ImageService != nil {
+						var imported, failed int
+						c.Text, imported, failed = d.importMedia(c.Text, c.User.ID)
+						stats.importedMedia += imported
+						stats.failedMedia += failed
+					}
 					commentsCh <- c
 					stats.commentsCount++
 					if stats.commentsCount%1000 == 0 {
@@ -182,3 +208,105 @@ func (*Disqus) cleanText(text string) string {
 	text = strings.Replace(text, "\t", "", -1)
 	return text
 }
+
+// votes makes synthetic votes for likes and dislikes, disqus doesn't export voters
+func (*Disqus) votes(likes, dislikes int) (votes map[string]bool, score int) {
+	if likes <= 0 && dislikes <= 0 {
+		return nil, 0
+	}
+	votes = make(map[string]bool)
+	for i := 0; i < likes; i++ {
+		votes[fmt.Sprintf("disqus_like_%d", i)] = true
+	}
+	for i := 0; i < dislikes; i++ {
+		votes[fmt.Sprintf("disqus_dislike_%d", i)] = false
+	}
+	return votes, likes - dislikes
+}
+
+// importMedia downloads images hosted by disqus to the image store and rewrites urls of them in the text.
+// Links to media replaced by images, as disqus renders them embedded. Failed media left as is.
+func (d *Disqus) importMedia(text, userID string) (res string, imported, failed int) {
+	if !strings.Contains(text, "disquscdn.com") {
+		return text, 0, 0
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(text))
+	if err != nil {
+		log.Printf("[WARN] can't parse comment text for media, %v", err)
+		return text, 0, 0
+	}
+
+	saved := map[string]string{} // media url:image url, the same media can be referenced few times
+	save := func(mediaURL string) (string, bool) {
+		if u, ok := saved[mediaURL]; ok {
+			return u, true
+		}
+		if !isDisqusMedia(mediaURL) {
+			return "", false
+		}
+		id, e := d.saveMedia(mediaURL, userID)
+		if e != nil {
+			log.Printf("[WARN] can't import disqus media %s, %v", mediaURL, e)
+			failed++
+			return "", false
+		}
+		imported++
+		saved[mediaURL] = d.ImageAPI + id
+		return saved[mediaURL], true
+	}
+
+	doc.Find("img").Each(func(_ int, sl *goquery.Selection) {
+		if src, ok := sl.Attr("src"); ok {
+			if imgURL, ok := save(src); ok {
+				sl.SetAttr("src", imgURL)
+			}
+		}
+	})
+	doc.Find("a").Each(func(_ int, sl *goquery.Selection) {
+		if href, ok := sl.Attr("href"); ok && sl.Find("img").Length() == 0 {
+			if imgURL, ok := save(href); ok {
+				sl.ReplaceWithHtml(fmt.Sprintf(`<img src="%s"/>`, imgURL))
+			}
+		}
+	})
+
+	if imported == 0 {
+		return text, imported, failed
+	}
+	if res, err = doc.Find("body").Html(); err != nil {
+		log.Printf("[WARN] can't render comment text with media, %v", err)
+		return text, imported, failed
+	}
+	return res, imported, failed
+}
+
+// saveMedia downloads media and saves it to the image store
+func (d *Disqus) saveMedia(mediaURL, userID string) (id string, err error) {
+	client := d.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Get(mediaURL)
+	if err != nil {
+		return "", errors.Wrap(err, "can't download media")
+	}
+	defer resp.Body.Close() //nolint
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("can't download media, status %d", resp.StatusCode)
+	}
+	return d.ImageService.Save(userID, resp.Body)
+}
+
+// isDisqusMedia checks if url points to media hosted by disqus
+func isDisqusMedia(mediaURL string) bool {
+	u, err := url.Parse(mediaURL)
+	if err != nil {
+		return false
+	}
+	for _, h := range disqusMediaHosts {
+		if u.Hostname() == h {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,12 @@
 package migrator
 
 import (
+	"bytes"
+	goimage "image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"strings"
 	"testing"
@@ -13,6 +19,7 @@ import (
 	"github.com/umputun/remark42/backend/app/store"
 	"github.com/umputun/remark42/backend/app/store/admin"
 	"github.com/umputun/remark42/backend/app/store/engine"
+	"github.com/umputun/remark42/backend/app/store/image"
 	"github.com/umputun/remark42/backend/app/store/service"
 )
 
@@ -116,6 +123,76 @@ func TestDisqus_ImportDeletedPost(t *testing.T) {
 	assert.True(t, c.Imported)
 }
 
+func TestDisqus_ImportVotesAndMedia(t *testing.T) {
+	defer os.Remove("/tmp/remark-test.db")
+	defer os.RemoveAll("/tmp/remark-test-images")
+	b, err := engine.NewBoltDB(bolt.Options{}, engine.BoltSite{FileName: "/tmp/remark-test.db", SiteID: "test"})
+	require.NoError(t, err, "create store")
+	imageService := image.NewService(&image.FileSystem{
+		Staging:  "/tmp/remark-test-images/staging",
+		Location: "/tmp/remark-test-images/location",
+	}, image.ServiceParams{ImageAPI: "https://remark.example.com/api/v1/picture/",
+		ProxyAPI: "https://remark.example.com/api/v1/img", MaxSize: 10000})
+	dataStore := service.DataStore{Engine: b, AdminStore: admin.NewStaticStore("12345", nil, []string{}, ""),
+		ImageService: imageService}
+	defer dataStore.Close()
+
+	img := bytes.Buffer{}
+	require.NoError(t, png.Encode(&img, goimage.NewGray(goimage.Rect(0, 0, 10, 10))))
+	requested := []string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/images/lost.png" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(img.Bytes())
+	}))
+	defer ts.Close()
+	tsURL, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		requested = append(requested, r.URL.Host+r.URL.Path)
+		r.URL.Scheme, r.URL.Host = tsURL.Scheme, tsURL.Host // all requests go to test server
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+
+	d := Disqus{DataStore: &dataStore, ImageService: imageService, ImageAPI: imageService.ImageAPI, HTTPClient: client}
+	fh, err := os.Open("testdata/disqus-media.xml")
+	require.NoError(t, err)
+	size, err := d.Import(fh, "test")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, size)
+	assert.Equal(t, []string{"uploads.disquscdn.com/images/pic1.png", "uploads.disquscdn.com/images/pic2.png",
+		"media.disquscdn.com/images/lost.png"}, requested, "other links not requested")
+
+	locator := store.Locator{SiteID: "test", URL: "https://radio-t.com/p/2011/03/05/podcast-229/"}
+	comments, err := dataStore.Dump(locator) // keeps votes, hidden by find
+	require.NoError(t, err)
+	require.Equal(t, 2, len(comments))
+
+	c := comments[0]
+	assert.Equal(t, "299619020", c.ID)
+	assert.Equal(t, 2, c.Score)
+	assert.Equal(t, 4, len(c.Votes))
+	assert.True(t, c.Votes["disqus_like_0"])
+	assert.False(t, c.Votes["disqus_dislike_0"])
+	ids := imageService.ExtractPictures(c.Text)
+	require.Equal(t, 2, len(ids), c.Text)
+	assert.True(t, strings.HasPrefix(ids[0], "disqus_328c8b68974aef73785f6b38c3d3fedfdf941434/"))
+	assert.Equal(t, `<p>Look at this <img src="https://remark.example.com/api/v1/picture/`+ids[0]+`"/></p>`+
+		`<p><img src="https://remark.example.com/api/v1/picture/`+ids[1]+`"/> and `+
+		`<a href="https://radio-t.com" rel="nofollow">link</a></p>`, c.Text)
+	for _, id := range ids {
+		_, err = imageService.Load(id)
+		assert.NoError(t, err, "image %s committed", id)
+	}
+
+	c = comments[1]
+	assert.Equal(t, 0, c.Score)
+	assert.Empty(t, c.Votes)
+	assert.Contains(t, c.Text, `<a href="https://media.disquscdn.com/images/lost.png"`, "failed media kept as link")
+}
+
 func TestDisqus_Convert(t *testing.T) {
 	d := Disqus{}
 	fh, err := os.Open("testdata/disqus.xml")
@@ -145,3 +222,7 @@ func TestDisqus_Convert(t *testing.T) {
 	exp0.Timestamp, _ = time.Parse("2006-01-02T15:04:05Z", "2011-08-31T15:16:29Z")
 	assert.Equal(t, exp0, res[0])
 }
+
+type roundTripFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
@@ -0,0 +1,277 @@
+package migrator
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+	"github.com/rs/xid"
+)
+
+// ExportJobStatus is a state of export job
+type ExportJobStatus string
+
+// enum of all export job statuses
+const (
+	ExportRunning   ExportJobStatus = "running"
+	ExportCompleted ExportJobStatus = "completed"
+	ExportFailed    ExportJobStatus = "failed"
+)
+
+// ExportJob describes background export of the site to gz file
+type ExportJob struct {
+	ID        string          `json:"id"`
+	SiteID    string          `json:"site"`
+	Status    ExportJobStatus `json:"status"`
+	Error     string          `json:"error,omitempty"`
+	Size      int64           `json:"size"` // size of generated file, grows while running
+	Comments  int             `json:"comments"`
+	Created   time.Time       `json:"created"`
+	Completed time.Time       `json:"completed"`
+}
+
+// ExportJobs runs exports in background to files kept for TTL, so big exports downloaded separately with
+// resume of interrupted downloads. Job state persisted next to the file and survives restart, jobs interrupted
+// by restart marked as failed.
+type ExportJobs struct {
+	Exporter Exporter
+	Location string        // directory for export files and job states
+	TTL      time.Duration // time to keep completed and failed jobs
+
+	lock      sync.Mutex // guards job states
+	startLock sync.Mutex // serializes starts to keep one running job per site
+}
+
+// ErrExportJobNotFound returned for unknown or expired job
+var ErrExportJobNotFound = errors.New("export job not found")
+
+// NewExportJobs makes ExportJobs in location and marks jobs interrupted by restart as failed
+func NewExportJobs(exporter Exporter, location string, ttl time.Duration) (*ExportJobs, error) {
+	if err := os.MkdirAll(location, 0700); err != nil {
+		return nil, errors.Wrapf(err, "can't make export jobs location %s", location)
+	}
+	res := &ExportJobs{Exporter: exporter, Location: location, TTL: ttl}
+	jobs, err := res.List("")
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range jobs {
+		if job.Status != ExportRunning {
+			continue
+		}
+		job.Status, job.Error = ExportFailed, "interrupted by restart"
+		_ = os.Remove(res.filePath(job.ID) + ".tmp")
+		if err = res.save(job); err != nil {
+			return nil, err
+		}
+		log.Printf("[INFO] export job %s for %s interrupted by restart", job.ID, job.SiteID)
+	}
+	return res, nil
+}
+
+// Start runs export of the site in background, rejected if another export of the site is running
+func (e *ExportJobs) Start(siteID string) (ExportJob, error) {
+	e.startLock.Lock()
+	defer e.startLock.Unlock()
+	e.Cleanup()
+	jobs, err := e.List(siteID)
+	if err != nil {
+		return ExportJob{}, err
+	}
+	for _, j := range jobs {
+		if j.Status == ExportRunning {
+			return ExportJob{}, errors.Errorf("export job %s for %s is running", j.ID, siteID)
+		}
+	}
+
+	job := ExportJob{ID: xid.New().String(), SiteID: siteID, Status: ExportRunning, Created: time.Now()}
+	if err = e.save(job); err != nil {
+		return ExportJob{}, err
+	}
+	go e.run(job)
+	return job, nil
+}
+
+// Get returns job by id
+func (e *ExportJobs) Get(id string) (ExportJob, error) {
+	if _, err := xid.FromString(id); err != nil {
+		return ExportJob{}, ErrExportJobNotFound
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	data, err := ioutil.ReadFile(e.statePath(id))
+	if os.IsNotExist(err) {
+		return ExportJob{}, ErrExportJobNotFound
+	}
+	if err != nil {
+		return ExportJob{}, errors.Wrapf(err, "can't read export job %s", id)
+	}
+	job := ExportJob{}
+	if err = json.Unmarshal(data, &job); err != nil {
+		return ExportJob{}, errors.Wrapf(err, "can't unmarshal export job %s", id)
+	}
+	return job, nil
+}
+
+// Open returns file of completed job, caller should close it
+func (e *ExportJobs) Open(id string) (*os.File, ExportJob, error) {
+	job, err := e.Get(id)
+	if err != nil {
+		return nil, ExportJob{}, err
+	}
+	if job.Status != ExportCompleted {
+		return nil, job, errors.Errorf("export job %s is %s", id, job.Status)
+	}
+	fh, err := os.Open(e.filePath(id)) //nolint:gosec // id validated by Get
+	if err != nil {
+		return nil, job, errors.Wrapf(err, "can't open export file of %s", id)
+	}
+	return fh, job, nil
+}
+
+// List returns jobs of the site sorted by creation time, all jobs for empty siteID
+func (e *ExportJobs) List(siteID string) ([]ExportJob, error) {
+	files, err := filepath.Glob(path.Join(e.Location, "*"+stateSuffix))
+	if err != nil {
+		return nil, errors.Wrap(err, "can't list export jobs")
+	}
+	res := []ExportJob{}
+	for _, f := range files { // Glob returns sorted names and xid ids sorted by creation time
+		job, err := e.Get(strings.TrimSuffix(filepath.Base(f), stateSuffix))
+		if err != nil {
+			continue
+		}
+		if siteID == "" || job.SiteID == siteID {
+			res = append(res, job)
+		}
+	}
+	return res, nil
+}
+
+// Cleanup removes files and states of finished jobs older than TTL
+func (e *ExportJobs) Cleanup() {
+	jobs, err := e.List("")
+	if err != nil {
+		log.Printf("[WARN] can't cleanup export jobs, %v", err)
+		return
+	}
+	for _, job := range jobs {
+		if job.Status == ExportRunning || e.TTL <= 0 || time.Since(job.Created) < e.TTL {
+			continue
+		}
+		e.lock.Lock()
+		_ = os.Remove(e.filePath(job.ID))
+		if err := os.Remove(e.statePath(job.ID)); err != nil {
+			log.Printf("[WARN] can't remove export job %s, %v", job.ID, err)
+		}
+		e.lock.Unlock()
+		log.Printf("[DEBUG] expired export job %s for %s removed", job.ID, job.SiteID)
+	}
+}
+
+// run generates export to temporary file, renamed on completion
+func (e *ExportJobs) run(job ExportJob) {
+	log.Printf("[INFO] export job %s for %s started", job.ID, job.SiteID)
+	count, size, err := e.export(&job)
+	job.Size = size
+	job.Completed = time.Now()
+	if err != nil {
+		_ = os.Remove(e.filePath(job.ID) + ".tmp")
+		job.Status, job.Error = ExportFailed, err.Error()
+		log.Printf("[WARN] export job %s for %s failed, %v", job.ID, job.SiteID, err)
+	} else {
+		job.Status, job.Comments = ExportCompleted, count
+		log.Printf("[INFO] export job %s for %s completed, %d comments, %d bytes", job.ID, job.SiteID, count, size)
+	}
+	if err = e.save(job); err != nil {
+		log.Printf("[WARN] %v", err)
+	}
+}
+
+// sizeInterval is a period of size updates of running job
+const sizeInterval = 5 * time.Second
+
+// export writes gzipped export of the site, size of running job updated every sizeInterval to show progress
+func (e *ExportJobs) export(job *ExportJob) (count int, size int64, err error) {
+	tmpFile := e.filePath(job.ID) + ".tmp"
+	fh, err := os.Create(tmpFile) //nolint:gosec // id generated by us
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "can't create export file %s", tmpFile)
+	}
+	lastUpdate := time.Now()
+	cw := &countingWriter{w: fh, onWrite: func(n int64) {
+		if time.Since(lastUpdate) < sizeInterval {
+			return
+		}
+		lastUpdate, job.Size = time.Now(), n
+		if saveErr := e.save(*job); saveErr != nil {
+			log.Printf("[WARN] %v", saveErr)
+		}
+	}}
+	gz := gzip.NewWriter(cw)
+	if count, err = e.Exporter.Export(gz, job.SiteID); err != nil {
+		_ = fh.Close()
+		return 0, 0, errors.Wrapf(err, "export failed for %s", job.SiteID)
+	}
+	if err = gz.Close(); err != nil {
+		_ = fh.Close()
+		return 0, 0, errors.Wrap(err, "can't close gzip writer")
+	}
+	if err = fh.Close(); err != nil {
+		return 0, 0, errors.Wrapf(err, "can't close export file %s", tmpFile)
+	}
+	if err = os.Rename(tmpFile, e.filePath(job.ID)); err != nil {
+		return 0, 0, errors.Wrapf(err, "can't rename export file %s", tmpFile)
+	}
+	return count, cw.n, nil
+}
+
+// save writes job state atomically
+func (e *ExportJobs) save(job ExportJob) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	data, err := json.Marshal(job)
+	if err != nil {
+		return errors.Wrapf(err, "can't marshal export job %s", job.ID)
+	}
+	stateFile := e.statePath(job.ID)
+	if err = ioutil.WriteFile(stateFile+".tmp", data, 0600); err != nil { //nolint:gocritic //octalLiteral is OK as FileMode
+		return errors.Wrapf(err, "can't write export job %s", job.ID)
+	}
+	return errors.Wrapf(os.Rename(stateFile+".tmp", stateFile), "can't save export job %s", job.ID)
+}
+
+const stateSuffix = ".job.json"
+
+// filePath returns path of export file
+func (e *ExportJobs) filePath(id string) string {
+	return path.Join(e.Location, id+".json.gz")
+}
+
+// statePath returns path of job state file
+func (e *ExportJobs) statePath(id string) string {
+	return path.Join(e.Location, id+stateSuffix)
+}
+
+// countingWriter counts written bytes and reports the total on each write
+type countingWriter struct {
+	w       io.Writer
+	n       int64
+	onWrite func(n int64)
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.onWrite(c.n)
+	return n, err
+}
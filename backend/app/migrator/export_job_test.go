@@ -0,0 +1,134 @@
+package migrator
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockJobExporter struct {
+	data  string
+	err   error
+	block chan struct{}
+}
+
+func (m mockJobExporter) Export(w io.Writer, _ string) (int, error) {
+	if m.block != nil {
+		<-m.block
+	}
+	if m.err != nil {
+		return 0, m.err
+	}
+	_, err := io.WriteString(w, m.data)
+	return 3, err
+}
+
+func TestExportJobs_Start(t *testing.T) {
+	dir, err := ioutil.TempDir("", "export_jobs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	block := make(chan struct{})
+	jobs, err := NewExportJobs(mockJobExporter{data: "some export data", block: block}, dir, time.Hour)
+	require.NoError(t, err)
+
+	job, err := jobs.Start("site1")
+	require.NoError(t, err)
+	assert.Equal(t, ExportRunning, job.Status)
+	assert.Equal(t, "site1", job.SiteID)
+
+	_, err = jobs.Start("site1")
+	assert.EqualError(t, err, "export job "+job.ID+" for site1 is running")
+
+	_, _, err = jobs.Open(job.ID)
+	assert.EqualError(t, err, "export job "+job.ID+" is running")
+
+	close(block)
+	require.Eventually(t, func() bool {
+		j, e := jobs.Get(job.ID)
+		return e == nil && j.Status == ExportCompleted
+	}, time.Second, 10*time.Millisecond)
+
+	fh, res, err := jobs.Open(job.ID)
+	require.NoError(t, err)
+	defer fh.Close()
+	assert.Equal(t, 3, res.Comments)
+	st, err := fh.Stat()
+	require.NoError(t, err)
+	assert.Equal(t, st.Size(), res.Size)
+	gz, err := gzip.NewReader(fh)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, "some export data", string(data))
+
+	list, err := jobs.List("site1")
+	require.NoError(t, err)
+	assert.Equal(t, []ExportJob{res}, list)
+	list, err = jobs.List("site2")
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(list))
+
+	_, err = jobs.Get("../../etc/passwd")
+	assert.Equal(t, ErrExportJobNotFound, err)
+	_, err = jobs.Get("c2ce2dehp4f1g3lk0tl0")
+	assert.Equal(t, ErrExportJobNotFound, err)
+}
+
+func TestExportJobs_Failed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "export_jobs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	jobs, err := NewExportJobs(mockJobExporter{err: errors.New("some error")}, dir, time.Hour)
+	require.NoError(t, err)
+	job, err := jobs.Start("site1")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		j, e := jobs.Get(job.ID)
+		return e == nil && j.Status == ExportFailed
+	}, time.Second, 10*time.Millisecond)
+
+	job, err = jobs.Get(job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "export failed for site1: some error", job.Error)
+	_, err = os.Stat(jobs.filePath(job.ID) + ".tmp")
+	assert.True(t, os.IsNotExist(err), "temp file removed")
+}
+
+func TestExportJobs_RestartAndCleanup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "export_jobs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	jobs, err := NewExportJobs(mockJobExporter{}, dir, time.Hour)
+	require.NoError(t, err)
+	running := ExportJob{ID: "c2ce2dehp4f1g3lk0tl0", SiteID: "site1", Status: ExportRunning, Created: time.Now()}
+	require.NoError(t, jobs.save(running))
+	expired := ExportJob{ID: "c2ce2dehp4f1g3lk0tlg", SiteID: "site1", Status: ExportCompleted,
+		Created: time.Now().Add(-2 * time.Hour)}
+	require.NoError(t, jobs.save(expired))
+	require.NoError(t, ioutil.WriteFile(jobs.filePath(expired.ID), []byte("data"), 0600))
+
+	jobs, err = NewExportJobs(mockJobExporter{}, dir, time.Hour)
+	require.NoError(t, err)
+	job, err := jobs.Get(running.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ExportFailed, job.Status)
+	assert.Equal(t, "interrupted by restart", job.Error)
+
+	jobs.Cleanup()
+	_, err = jobs.Get(expired.ID)
+	assert.Equal(t, ErrExportJobNotFound, err)
+	_, err = os.Stat(jobs.filePath(expired.ID))
+	assert.True(t, os.IsNotExist(err), "expired file removed")
+	_, err = jobs.Get(running.ID)
+	assert.NoError(t, err, "recent job kept")
+}
@@ -0,0 +1,294 @@
+package migrator
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+const issoModeAccepted = 1
+
+// Isso implements Importer from Isso sqlite dump, made with `sqlite3 comments.db .dump`.
+// Isso keeps uri of the post without domain, posts imported with uri as url and can be mapped
+// to full urls with remap.
+type Isso struct {
+	DataStore Store
+}
+
+// issoRow is a row of dump insert statement, values by column name
+type issoRow map[string]interface{}
+
+// Import comments from Isso and save to store
+func (s *Isso) Import(r io.Reader, siteID string) (size int, err error) {
+	comments, err := s.convert(r, siteID)
+	if err != nil {
+		return 0, err
+	}
+	if e := s.DataStore.DeleteAll(siteID); e != nil {
+		return 0, e
+	}
+	return saveComments(s.DataStore, comments, siteID)
+}
+
+func (s *Isso) convert(r io.Reader, siteID string) ([]store.Comment, error) {
+	tables, err := s.parseDump(r)
+	if err != nil {
+		return nil, err
+	}
+
+	threads := map[int64]string{}
+	for _, t := range tables["threads"] {
+		threads[t.int("id")] = t.str("uri")
+	}
+
+	commentFormatter := store.NewCommentFormatter()
+	res := make([]store.Comment, 0, len(tables["comments"]))
+	skipped := 0
+	for _, c := range tables["comments"] {
+		uri, ok := threads[c.int("tid")]
+		if !ok || c.int("mode") != issoModeAccepted {
+			skipped++
+			continue
+		}
+		created := c.float("created")
+		sec, frac := math.Modf(created)
+		comment := store.Comment{
+			ID:      strconv.FormatInt(c.int("id"), 10),
+			Locator: store.Locator{SiteID: siteID, URL: uri},
+			User: store.User{
+				ID:   "isso_" + store.EncodeID(c.str("author")+c.str("email")),
+				Name: c.str("author"),
+				IP:   c.str("remote_addr"),
+			},
+			Text:      c.str("text"),
+			Timestamp: time.Unix(int64(sec), int64(frac*1e9)).UTC().Truncate(time.Millisecond),
+			Imported:  true,
+		}
+		if comment.User.Name == "" {
+			comment.User.Name = "Anonymous"
+		}
+		if pid := c.int("parent"); pid > 0 {
+			comment.ParentID = strconv.FormatInt(pid, 10)
+		}
+		res = append(res, commentFormatter.Format(comment))
+	}
+	log.Printf("[INFO] converted %d isso comments in %d threads, skipped %d", len(res), len(threads), skipped)
+	return res, nil
+}
+
+// parseDump reads CREATE TABLE and INSERT statements of threads and comments tables
+// and returns rows of each table. Other statements ignored.
+func (s *Isso) parseDump(r io.Reader) (map[string][]issoRow, error) {
+	columns := map[string][]string{}
+	res := map[string][]issoRow{}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	scanner.Split(splitSQLStatements)
+	for scanner.Scan() {
+		stmt := strings.TrimSpace(scanner.Text())
+		upper := strings.ToUpper(stmt)
+		switch {
+		case strings.HasPrefix(upper, "CREATE TABLE"):
+			table, cols := parseCreateTable(stmt)
+			columns[table] = cols
+		case strings.HasPrefix(upper, "INSERT INTO"):
+			table, values, err := parseInsert(stmt)
+			if err != nil {
+				return nil, err
+			}
+			cols, ok := columns[table]
+			if !ok || (table != "threads" && table != "comments") {
+				continue
+			}
+			row := issoRow{}
+			for i, v := range values {
+				if i < len(cols) {
+					row[cols[i]] = v
+				}
+			}
+			res[table] = append(res[table], row)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "can't read isso dump")
+	}
+	if _, ok := columns["comments"]; !ok {
+		return nil, errors.New("no comments table in isso dump")
+	}
+	return res, nil
+}
+
+// splitSQLStatements is a bufio.SplitFunc splitting sql by ";" outside of quotes
+func splitSQLStatements(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	inQuote := byte(0)
+	for i := 0; i < len(data); i++ {
+		switch ch := data[i]; {
+		case inQuote != 0 && ch == inQuote:
+			inQuote = 0 // escaped quote '' handled as closing and opening again
+		case inQuote != 0:
+		case ch == '\'' || ch == '"':
+			inQuote = ch
+		case ch == ';':
+			return i + 1, data[:i], nil
+		}
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// parseCreateTable returns table name and column names
+func parseCreateTable(stmt string) (table string, columns []string) {
+	open, end := strings.Index(stmt, "("), strings.LastIndex(stmt, ")")
+	if open < 0 || end < open {
+		return "", nil
+	}
+	fields := strings.Fields(stmt[:open])
+	table = unquoteName(fields[len(fields)-1])
+	for _, def := range splitTopLevel(stmt[open+1 : end]) {
+		f := strings.Fields(def)
+		if len(f) == 0 {
+			continue
+		}
+		switch strings.ToUpper(f[0]) {
+		case "PRIMARY", "FOREIGN", "UNIQUE", "CHECK", "CONSTRAINT":
+			continue
+		}
+		columns = append(columns, unquoteName(f[0]))
+	}
+	return table, columns
+}
+
+// parseInsert returns table name and values of INSERT INTO table VALUES(...) statement
+func parseInsert(stmt string) (table string, values []interface{}, err error) {
+	open, end := strings.Index(stmt, "("), strings.LastIndex(stmt, ")")
+	if open < 0 || end < open {
+		return "", nil, errors.Errorf("invalid insert statement %.50q", stmt)
+	}
+	fields := strings.Fields(stmt[:open])
+	if len(fields) < 3 {
+		return "", nil, errors.Errorf("invalid insert statement %.50q", stmt)
+	}
+	table = unquoteName(fields[2])
+	for _, v := range splitTopLevel(stmt[open+1 : end]) {
+		val, e := parseSQLValue(strings.TrimSpace(v))
+		if e != nil {
+			return "", nil, errors.Wrapf(e, "can't parse value of %s", table)
+		}
+		values = append(values, val)
+	}
+	return table, values, nil
+}
+
+// parseSQLValue converts sql literal to string, int64, float64 or nil. Supports replace() and char()
+// functions used by sqlite to dump strings with newlines.
+func parseSQLValue(v string) (interface{}, error) {
+	upper := strings.ToUpper(v)
+	switch {
+	case upper == "NULL":
+		return nil, nil
+	case strings.HasPrefix(v, "'") && strings.HasSuffix(v, "'") && len(v) >= 2:
+		return strings.ReplaceAll(v[1:len(v)-1], "''", "'"), nil
+	case strings.HasPrefix(upper, "X'"):
+		return nil, nil // blobs, like voters, not used
+	case strings.HasPrefix(upper, "REPLACE(") && strings.HasSuffix(v, ")"):
+		args := splitTopLevel(v[len("replace(") : len(v)-1])
+		if len(args) != 3 {
+			return nil, errors.Errorf("invalid replace %.50q", v)
+		}
+		var strs [3]string
+		for i, a := range args {
+			val, err := parseSQLValue(strings.TrimSpace(a))
+			if err != nil {
+				return nil, err
+			}
+			str, ok := val.(string)
+			if !ok {
+				return nil, errors.Errorf("invalid replace argument %.50q", a)
+			}
+			strs[i] = str
+		}
+		return strings.ReplaceAll(strs[0], strs[1], strs[2]), nil
+	case strings.HasPrefix(upper, "CHAR(") && strings.HasSuffix(v, ")"):
+		var sb strings.Builder
+		for _, a := range strings.Split(v[len("char("):len(v)-1], ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(a))
+			if err != nil {
+				return nil, errors.Errorf("invalid char %.50q", v)
+			}
+			sb.WriteRune(rune(n))
+		}
+		return sb.String(), nil
+	}
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(v, 64); err == nil {
+		return f, nil
+	}
+	return nil, errors.Errorf("unsupported value %.50q", v)
+}
+
+// splitTopLevel splits by commas outside of quotes and parentheses
+func splitTopLevel(s string) (res []string) {
+	depth, start := 0, 0
+	inQuote := byte(0)
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; {
+		case inQuote != 0 && ch == inQuote:
+			inQuote = 0
+		case inQuote != 0:
+		case ch == '\'' || ch == '"':
+			inQuote = ch
+		case ch == '(':
+			depth++
+		case ch == ')':
+			depth--
+		case ch == ',' && depth == 0:
+			res = append(res, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(res, s[start:])
+}
+
+func unquoteName(name string) string {
+	return strings.Trim(name, "\"`[]")
+}
+
+func (r issoRow) str(key string) string {
+	if v, ok := r[key].(string); ok {
+		return v
+	}
+	return ""
+}
+
+func (r issoRow) int(key string) int64 {
+	switch v := r[key].(type) {
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	}
+	return 0
+}
+
+func (r issoRow) float(key string) float64 {
+	switch v := r[key].(type) {
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
@@ -0,0 +1,114 @@
+package migrator
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/engine"
+	"github.com/umputun/remark42/backend/app/store/service"
+)
+
+func TestIsso_Import(t *testing.T) {
+	siteID := "testIsso"
+	defer func() { _ = os.Remove("/tmp/remark-test.db") }()
+	b, err := engine.NewBoltDB(bolt.Options{}, engine.BoltSite{FileName: "/tmp/remark-test.db", SiteID: siteID})
+	require.NoError(t, err, "create store")
+
+	dataStore := service.DataStore{Engine: b, AdminStore: admin.NewStaticStore("12345", nil, []string{}, "")}
+	defer dataStore.Close()
+	isso := Isso{DataStore: &dataStore}
+	size, err := isso.Import(strings.NewReader(sqlTestIsso), siteID)
+	require.NoError(t, err)
+	assert.Equal(t, 3, size)
+
+	posts, err := dataStore.List(siteID, 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(posts))
+
+	comments, err := dataStore.Find(store.Locator{SiteID: siteID, URL: "/blog/first/"}, "time", adminUser)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(comments))
+	assert.Equal(t, "1", comments[0].ID)
+	assert.Equal(t, "1", comments[1].ParentID)
+
+	_, err = isso.Import(strings.NewReader("CREATE TABLE other (id INTEGER);"), siteID)
+	assert.EqualError(t, err, "no comments table in isso dump")
+}
+
+func TestIsso_Convert(t *testing.T) {
+	isso := Isso{}
+	comments, err := isso.convert(strings.NewReader(sqlTestIsso), "testIsso")
+	require.NoError(t, err)
+	require.Equal(t, 3, len(comments), "comments in moderation and deleted skipped")
+
+	assert.Equal(t, store.Comment{
+		ID:      "1",
+		Locator: store.Locator{SiteID: "testIsso", URL: "/blog/first/"},
+		User: store.User{ID: "isso_" + store.EncodeID("Johnjohn@example.com"), Name: "John",
+			IP: "127.0.0.0"},
+		Text:      "<p>It’s the <em>first</em> one<br/>\nwith; two lines</p>\n",
+		Timestamp: time.Date(2020, 5, 1, 10, 0, 0, 500000000, time.UTC),
+		Imported:  true,
+	}, comments[0])
+
+	assert.Equal(t, "1", comments[1].ParentID)
+	assert.Equal(t, "Anonymous", comments[1].User.Name)
+	assert.Equal(t, "<p>line1<br/>\nline2</p>\n", comments[1].Text, "replace with char(10) decoded")
+	assert.Equal(t, "/blog/second/", comments[2].Locator.URL)
+}
+
+func TestIsso_parseSQLValue(t *testing.T) {
+	tbl := []struct {
+		inp string
+		res interface{}
+		err bool
+	}{
+		{"NULL", nil, false},
+		{"12", int64(12), false},
+		{"1588327200.5", 1588327200.5, false},
+		{"'it''s'", "it's", false},
+		{"X'0a0b'", nil, false},
+		{"char(104,105)", "hi", false},
+		{`replace('a\nb','\n',char(10))`, "a\nb", false},
+		{"replace('a', 'b')", nil, true},
+		{"char(x)", nil, true},
+		{"unknown", nil, true},
+	}
+	for i, tt := range tbl {
+		res, err := parseSQLValue(tt.inp)
+		if tt.err {
+			assert.Error(t, err, "case #%d", i)
+			continue
+		}
+		require.NoError(t, err, "case #%d", i)
+		assert.Equal(t, tt.res, res, "case #%d", i)
+	}
+}
+
+const sqlTestIsso = `PRAGMA foreign_keys=OFF;
+BEGIN TRANSACTION;
+CREATE TABLE preferences (key VARCHAR PRIMARY KEY, value VARCHAR);
+INSERT INTO preferences VALUES('session-key','abc');
+CREATE TABLE comments (tid REFERENCES threads(id), id INTEGER PRIMARY KEY, parent INTEGER,
+  created FLOAT NOT NULL, modified FLOAT, mode INTEGER, remote_addr VARCHAR, text VARCHAR, author VARCHAR,
+  email VARCHAR, website VARCHAR, likes INTEGER DEFAULT 0, dislikes INTEGER DEFAULT 0, voters BLOB NOT NULL,
+  notification INTEGER DEFAULT 0);
+INSERT INTO comments VALUES(1,1,NULL,1588327200.5,NULL,1,'127.0.0.0','It''s the *first* one
+with; two lines','John','john@example.com',NULL,0,0,X'00',0);
+INSERT INTO comments VALUES(1,2,1,1588330800.0,NULL,1,'127.0.0.0',replace('line1\nline2','\n',char(10)),NULL,NULL,NULL,0,0,X'00',0);
+INSERT INTO comments VALUES(1,3,NULL,1588334400.0,NULL,2,'127.0.0.0','in moderation','Bob',NULL,NULL,0,0,X'00',0);
+INSERT INTO comments VALUES(2,4,NULL,1588338000.0,NULL,4,'127.0.0.0','deleted','Bob',NULL,NULL,0,0,X'00',0);
+INSERT INTO comments VALUES(2,5,NULL,1588341600.0,NULL,1,'127.0.0.0','second post','Bob',NULL,NULL,0,0,X'00',0);
+CREATE TABLE threads (id INTEGER PRIMARY KEY, uri VARCHAR(256) UNIQUE, title VARCHAR(256));
+INSERT INTO threads VALUES(1,'/blog/first/','First');
+INSERT INTO "threads" VALUES(2,'/blog/second/','Second (post)');
+COMMIT;
+`
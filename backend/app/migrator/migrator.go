@@ -4,6 +4,9 @@
 package migrator
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"io"
 	"os"
 
@@ -24,6 +27,11 @@ type Exporter interface {
 	Export(w io.Writer, siteID string) (int, error)
 }
 
+// VersionedExporter defines interface to export comments in the given version of format, i.e. for older importers
+type VersionedExporter interface {
+	ExportVersion(w io.Writer, siteID string, version int) (int, error)
+}
+
 // Mapper defines interface to convert data in import procedure
 type Mapper interface {
 	URL(url string) string
@@ -38,6 +46,7 @@ type MapperMaker func(reader io.Reader) (Mapper, error)
 type Store interface {
 	Create(comment store.Comment) (commentID string, err error)
 	Find(locator store.Locator, sort string, user store.User) ([]store.Comment, error)
+	Dump(locator store.Locator) ([]store.Comment, error)
 	List(siteID string, limit int, skip int) ([]store.PostInfo, error)
 	DeleteAll(siteID string) error
 	Metas(siteID string) (umetas []service.UserMetaData, pmetas []service.PostMetaData, err error)
@@ -64,6 +73,10 @@ func ImportComments(p ImportParams) (int, error) {
 		importer = &Disqus{DataStore: p.DataStore}
 	case "wordpress":
 		importer = &WordPress{DataStore: p.DataStore}
+	case "commento":
+		importer = &Commento{DataStore: p.DataStore}
+	case "isso":
+		importer = &Isso{DataStore: p.DataStore}
 	case "native":
 		importer = &Native{DataStore: p.DataStore}
 	default:
@@ -81,5 +94,27 @@ func ImportComments(p ImportParams) (int, error) {
 		}
 	}()
 
-	return importer.Import(fh, p.SiteID)
+	reader, err := Uncompressed(fh)
+	if err != nil {
+		return 0, errors.Wrapf(err, "can't read import file %s", p.InputFile)
+	}
+	return importer.Import(reader, p.SiteID)
+}
+
+// Uncompressed returns reader of gzipped data unpacked, detected by gzip header.
+// Data without the header returned as is, so import accepts both plain and gzipped exports.
+func Uncompressed(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err != nil && err != io.EOF {
+		return nil, errors.Wrap(err, "can't read data")
+	}
+	if !bytes.Equal(header, []byte{0x1f, 0x8b}) {
+		return br, nil
+	}
+	gz, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't make gz reader")
+	}
+	return gz, nil
 }
@@ -1,7 +1,7 @@
 package migrator
 
 import (
-	"bytes"
+	"bufio"
 	"context"
 	"encoding/json"
 	"io"
@@ -15,12 +15,19 @@ import (
 	"github.com/umputun/remark42/backend/app/store/service"
 )
 
-const nativeVersion = 1
+// versions of native format. Version 2 keeps votes, voted ips, reactions of users and revisions of comments,
+// shadow-bans of users. Version 1 made without them, for importers of older remark42 versions.
+const (
+	nativeVersion   = 2
+	nativeVersionV1 = 1
+)
+
 const defaultConcurrent = 8
+const exportBufferSize = 64 * 1024
 
-// Native implements exporter and importer for internal store format
-// {"version": 1, comments:[{...}\n,{}], meta: {meta}}
-// each comments starts from the new line
+// Native implements exporter and importer for internal store format, newline-delimited json
+// with meta {"version": 2, "users": [...], "posts": [...]} in the first line and one comment per line after it.
+// Import accepts gzipped data and all versions of the format, see Uncompressed.
 type Native struct {
 	DataStore  Store
 	Concurrent int
@@ -32,11 +39,21 @@ type meta struct {
 	Posts   []service.PostMetaData `json:"posts"`
 }
 
-// Export all comments to writer as json strings. Each comment is one string, separated by "\n"
-// The final file is a valid json
+// Export all comments to writer as json strings in the latest version of format, see ExportVersion
 func (n *Native) Export(w io.Writer, siteID string) (size int, err error) {
+	return n.ExportVersion(w, siteID, nativeVersion)
+}
+
+// ExportVersion exports all comments to writer as json strings in the given version of format.
+// Each comment is one string, separated by "\n". Comments written post by post through the buffer,
+// so memory used doesn't depend on number of comments of the site.
+func (n *Native) ExportVersion(w io.Writer, siteID string, version int) (size int, err error) {
+	if !IsExportVersion(version) {
+		return 0, errors.Errorf("unsupported export version %d", version)
+	}
+	bw := bufio.NewWriterSize(w, exportBufferSize)
 
-	if err = n.exportMeta(siteID, w); err != nil {
+	if err = n.exportMeta(siteID, version, bw); err != nil {
 		return 0, errors.Wrapf(err, "failed to export meta for site %s", siteID)
 	}
 
@@ -46,40 +63,49 @@ func (n *Native) Export(w io.Writer, siteID string) (size int, err error) {
 	}
 
 	log.Printf("[DEBUG] exporting %d topics", len(topics))
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
 	commentsCount := 0
 	for i := len(topics) - 1; i >= 0; i-- { // topics from List sorted in opposite direction
 		topic := topics[i]
-		comments, e := n.DataStore.Find(store.Locator{SiteID: siteID, URL: topic.URL}, "time", adminUser)
+		locator := store.Locator{SiteID: siteID, URL: topic.URL}
+		var comments []store.Comment
+		var e error
+		if version == nativeVersionV1 {
+			comments, e = n.DataStore.Find(locator, "time", adminUser) // votes and other hidden fields dropped
+		} else {
+			comments, e = n.DataStore.Dump(locator)
+		}
 		if e != nil {
 			return commentsCount, e
 		}
 
 		for _, comment := range comments {
-
-			buf := &bytes.Buffer{}
-			enc := json.NewEncoder(buf)
-			enc.SetEscapeHTML(false)
-
 			if err = enc.Encode(comment); err != nil {
-				return commentsCount, errors.Wrapf(err, "can't marshal %v", comments)
-			}
-			if _, err = w.Write(buf.Bytes()); err != nil {
-				return commentsCount, errors.Wrap(err, "can't write comment data")
+				return commentsCount, errors.Wrapf(err, "can't write comment %s", comment.ID)
 			}
 			commentsCount++
 		}
 	}
+	if err = bw.Flush(); err != nil {
+		return commentsCount, errors.Wrap(err, "can't write comment data")
+	}
 	log.Printf("[DEBUG] exported %d comments", commentsCount)
 	return commentsCount, nil
 }
 
 // exportMeta appends user and post metas to exported stream
-func (n *Native) exportMeta(siteID string, w io.Writer) (err error) {
-	m := meta{Version: nativeVersion}
+func (n *Native) exportMeta(siteID string, version int, w io.Writer) (err error) {
+	m := meta{Version: version}
 	m.Users, m.Posts, err = n.DataStore.Metas(siteID)
 	if err != nil {
 		return errors.Wrap(err, "can't get meta")
 	}
+	if version == nativeVersionV1 {
+		for i := range m.Users {
+			m.Users[i].Shadowed = false
+		}
+	}
 
 	if err = json.NewEncoder(w).Encode(m); err != nil {
 		return errors.Wrap(err, "can't encode meta")
@@ -128,15 +154,19 @@ func WithMapper(reader io.Reader, mapper Mapper) io.Reader {
 	return r
 }
 
-// Import comments from json strings produced by Remark.Export
+// Import comments from json strings produced by Remark.Export, plain or gzipped.
+// Comments decoded and saved one by one, the whole data never loaded to memory.
 func (n *Native) Import(reader io.Reader, siteID string) (size int, err error) {
+	if reader, err = Uncompressed(reader); err != nil {
+		return 0, errors.Wrapf(err, "failed to import site %s", siteID)
+	}
 	m := meta{}
 	dec := json.NewDecoder(reader)
 	if err = dec.Decode(&m); err != nil {
 		return 0, errors.Wrapf(err, "failed to import meta for site %s", siteID)
 	}
 
-	if m.Version != nativeVersion && m.Version != 0 { // this version allows back compatibility with 0 version
+	if !supportedVersion(m.Version) {
 		return 0, errors.Errorf("unexpected import file version %d", m.Version)
 	}
 
@@ -194,3 +224,13 @@ func (n *Native) Import(reader io.Reader, siteID string) (size int, err error) {
 
 	return int(comments), err
 }
+
+// IsExportVersion checks if comments can be exported in the version of native format
+func IsExportVersion(version int) bool {
+	return version == nativeVersion || version == nativeVersionV1
+}
+
+// supportedVersion checks if version of native format can be imported, 0 is the version of the oldest backups
+func supportedVersion(version int) bool {
+	return version >= 0 && version <= nativeVersion
+}
@@ -2,8 +2,11 @@ package migrator
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math/rand"
 	"os"
 	"strings"
@@ -67,6 +70,61 @@ func TestNative_Export(t *testing.T) {
 	assert.Equal(t, "some text, <a href=\"http://radio-t.com\" rel=\"nofollow\">link</a>", comments[0].Text)
 }
 
+func TestNative_ExportImportVotes(t *testing.T) {
+	b, teardown := prep(t) // write 2 comments
+	defer teardown()
+	b.MaxVotes = service.UnlimitedVotes
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	_, err := b.Vote(service.VoteReq{Locator: locator, CommentID: "efbc17f177ee1a1c0ee6e1e025749966ec071adc",
+		UserID: "user2", UserIP: "127.0.0.1", Val: true})
+	require.NoError(t, err)
+	require.NoError(t, b.SetShadowBan("radio-t", "user2", true))
+	_, err = b.SetUserProfile("radio-t", store.User{ID: "user1"}, store.Profile{DisplayName: "User One"})
+	require.NoError(t, err)
+	r := Native{DataStore: b}
+
+	buf := &bytes.Buffer{}
+	size, err := r.Export(buf, "radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, 2, size)
+	backup := buf.String()
+	assert.Contains(t, backup, `"version":2`)
+	assert.Contains(t, backup, `"votes":{"user2":true}`)
+	assert.Contains(t, backup, `"shadowed":true`)
+
+	bufV1 := &bytes.Buffer{}
+	size, err = r.ExportVersion(bufV1, "radio-t", 1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, size)
+	assert.Contains(t, bufV1.String(), `"version":1`)
+	assert.NotContains(t, bufV1.String(), `"votes"`, "votes hidden in version 1")
+	assert.NotContains(t, bufV1.String(), `"shadowed"`)
+	_, err = r.ExportVersion(bufV1, "radio-t", 3)
+	assert.EqualError(t, err, "unsupported export version 3")
+
+	require.NoError(t, b.DeleteAll("radio-t"))
+	require.NoError(t, b.SetShadowBan("radio-t", "user2", false))
+	require.NoError(t, b.DeleteUserDetail("radio-t", "user1", engine.UserProfile))
+	size, err = r.Import(strings.NewReader(backup), "radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, 2, size)
+
+	comments, err := b.Dump(locator)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(comments))
+	assert.Equal(t, 1, comments[0].Score)
+	assert.Equal(t, map[string]bool{"user2": true}, comments[0].Votes, "votes restored")
+	assert.Equal(t, 1, len(comments[0].VotedIPs))
+	assert.True(t, b.IsShadowBanned("radio-t", "user2"))
+	profile, err := b.GetUserProfile("radio-t", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, "User One", profile.DisplayName)
+
+	size, err = r.Import(strings.NewReader(bufV1.String()), "radio-t")
+	require.NoError(t, err, "version 1 accepted")
+	assert.Equal(t, 2, size)
+}
+
 func TestNative_Import(t *testing.T) {
 	b, teardown := prep(t) // write 2 comments
 	defer teardown()
@@ -101,6 +159,53 @@ func TestNative_Import(t *testing.T) {
 	assert.Equal(t, false, b.IsVerified("radio-t", "user2"))
 }
 
+func TestNative_ImportGzipped(t *testing.T) {
+	b, teardown := prep(t) // write 2 comments
+	defer teardown()
+	r := Native{DataStore: b}
+
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	size, err := r.Export(gz, "radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, 2, size)
+	require.NoError(t, gz.Close())
+
+	require.NoError(t, b.DeleteAll("radio-t"))
+	size, err = r.Import(buf, "radio-t")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, size)
+
+	comments, err := b.Last("radio-t", 10, time.Time{}, store.User{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(comments))
+
+	_, err = r.Import(bytes.NewReader([]byte{0x1f, 0x8b, 1}), "radio-t")
+	assert.Error(t, err, "broken gzip")
+}
+
+func TestUncompressed(t *testing.T) {
+	buf := bytes.Buffer{}
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write([]byte("some data"))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	for _, inp := range []io.Reader{&buf, strings.NewReader("some data")} {
+		r, err := Uncompressed(inp)
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, "some data", string(data))
+	}
+
+	r, err := Uncompressed(strings.NewReader(""))
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Empty(t, data)
+}
+
 func TestNative_ImportWithMapper(t *testing.T) {
 	b, teardown := prep(t) // write 2 comments
 	defer teardown()
@@ -144,14 +249,14 @@ func TestNative_ImportWrongVersion(t *testing.T) {
 	b, teardown := prep(t) // write 2 comments
 	defer teardown()
 
-	inp := `{"version":2,"users":[{"id":"user1","blocked":{"status":false,"until":"0001-01-01T00:00:00Z"},"verified":true},{"id":"user2","blocked":{"status":true,"until":"2018-12-23T02:55:22.472041-06:00"},"verified":false}],"posts":[{"url":"https://radio-t.com","read_only":true}]}
+	inp := `{"version":3,"users":[{"id":"user1","blocked":{"status":false,"until":"0001-01-01T00:00:00Z"},"verified":true},{"id":"user2","blocked":{"status":true,"until":"2018-12-23T02:55:22.472041-06:00"},"verified":false}],"posts":[{"url":"https://radio-t.com","read_only":true}]}
 	{"id":"efbc17f177ee1a1c0ee6e1e025749966ec071adc","pid":"","text":"some text, <a href=\"http://radio-t.com\" rel=\"nofollow\">link</a>","user":{"name":"user name","id":"user1","picture":"","ip":"293ec5b0cf154855258824ec7fac5dc63d176915","admin":false},"locator":{"site":"radio-t","url":"https://radio-t.com"},"score":0,"votes":{},"time":"2017-12-20T15:18:22-06:00"}
 	{"id":"f863bd79-fec6-4a75-b308-61fe5dd02aa1","pid":"1234","text":"some text2","user":{"name":"user name","id":"user2","picture":"","ip":"293ec5b0cf154855258824ec7fac5dc63d176915","admin":false},"locator":{"site":"radio-t","url":"https://radio-t.com/2"},"score":0,"votes":{},"time":"2017-12-20T15:18:23-06:00"}`
 
 	b.AdminStore = admin.NewStaticStore("12345", nil, []string{}, "")
 	r := Native{DataStore: b}
 	size, err := r.Import(strings.NewReader(inp), "radio-t")
-	assert.EqualError(t, err, "unexpected import file version 2")
+	assert.EqualError(t, err, "unexpected import file version 3")
 	assert.Equal(t, 0, size)
 
 }
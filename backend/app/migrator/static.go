@@ -0,0 +1,224 @@
+package migrator
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/templates"
+)
+
+const defaultStaticIndexTemplatePath = "static_index.html.tmpl"
+
+// AvatarMode defines how avatars of users rendered to static pages
+type AvatarMode string
+
+// AvatarMode enum
+const (
+	AvatarsLink   AvatarMode = "link"   // referenced by their urls
+	AvatarsInline AvatarMode = "inline" // loaded and embedded to pages as data urls, referenced on errors
+	AvatarsNone   AvatarMode = "none"   // not shown
+)
+
+const maxInlineAvatarSize = 512 * 1024
+
+// StaticSite renders comments from native backup to standalone html pages, one page per post and index page
+// with the list of posts. Pages don't need remark42 backend, so discussions of retired site stay browsable.
+// Pending comments skipped, deleted ones shown as deleted.
+type StaticSite struct {
+	Location          string      // directory for html files
+	TemplatePath      string      // path to post template, archive.html.tmpl by default
+	IndexTemplatePath string      // path to index template, static_index.html.tmpl by default
+	Avatars           AvatarMode  // AvatarsLink by default
+	Client            http.Client // loads inlined avatars
+
+	avatars map[string]template.URL // inlined avatars by url
+}
+
+// StaticResult describes rendered site
+type StaticResult struct {
+	Posts     int    `json:"posts"`
+	Comments  int    `json:"comments"`
+	IndexFile string `json:"index_file"`
+}
+
+// staticPost is a post listed on index page
+type staticPost struct {
+	URL      string
+	Title    string
+	File     string
+	Comments int
+	LastTS   time.Time
+}
+
+// Render reads native backup of the site, plain or gzipped, and writes html pages to Location.
+// Pages named by encoded url of the post and rewritten on repeated calls.
+func (s *StaticSite) Render(reader io.Reader, siteID string) (StaticResult, error) {
+	res := StaticResult{}
+	reader, err := Uncompressed(reader)
+	if err != nil {
+		return res, errors.Wrap(err, "can't read backup")
+	}
+	dec := json.NewDecoder(reader)
+	m := meta{}
+	if err = dec.Decode(&m); err != nil {
+		return res, errors.Wrap(err, "can't decode backup meta")
+	}
+	if !supportedVersion(m.Version) {
+		return res, errors.Errorf("unexpected backup version %d", m.Version)
+	}
+
+	posts := map[string][]store.Comment{}
+	for {
+		c := store.Comment{}
+		if err = dec.Decode(&c); err == io.EOF {
+			break
+		}
+		if err != nil {
+			return res, errors.Wrap(err, "can't decode comment")
+		}
+		if c.Pending || (siteID != "" && c.Locator.SiteID != siteID) {
+			continue
+		}
+		c.User.IP = "" // pages made for viewing, ip not needed
+		posts[c.Locator.URL] = append(posts[c.Locator.URL], c)
+	}
+
+	if err = os.MkdirAll(path.Join(s.Location, "posts"), 0700); err != nil {
+		return res, errors.Wrapf(err, "can't make static location %s", s.Location)
+	}
+
+	now := time.Now()
+	index := make([]staticPost, 0, len(posts))
+	for url, comments := range posts {
+		sort.SliceStable(comments, func(i, j int) bool { return comments[i].Timestamp.Before(comments[j].Timestamp) })
+		arch := PostArchive{Version: archiveVersion, Locator: comments[0].Locator, ArchivedAt: now, Comments: comments}
+		for _, c := range comments {
+			if c.PostTitle != "" {
+				arch.Title = c.PostTitle
+				break
+			}
+		}
+		page, e := renderArchive(s.TemplatePath, archivePage{PostArchive: arch, Index: "../index.html"}, s.avatar)
+		if e != nil {
+			return res, e
+		}
+		file := path.Join("posts", store.EncodeID(url)+".html")
+		if e = ioutil.WriteFile(path.Join(s.Location, file), page, 0600); e != nil {
+			return res, errors.Wrapf(e, "can't write %s", file)
+		}
+		index = append(index, staticPost{URL: url, Title: arch.Title, File: file, Comments: len(comments),
+			LastTS: comments[len(comments)-1].Timestamp})
+		res.Comments += len(comments)
+	}
+	sort.Slice(index, func(i, j int) bool { return index[i].LastTS.After(index[j].LastTS) })
+	res.Posts = len(index)
+
+	res.IndexFile = path.Join(s.Location, "index.html")
+	page, err := s.renderIndex(siteID, now, index)
+	if err != nil {
+		return res, err
+	}
+	if err = ioutil.WriteFile(res.IndexFile, page, 0600); err != nil {
+		return res, errors.Wrapf(err, "can't write %s", res.IndexFile)
+	}
+	log.Printf("[INFO] rendered %d comments of %d posts to %s", res.Comments, res.Posts, s.Location)
+	return res, nil
+}
+
+func (s *StaticSite) renderIndex(siteID string, ts time.Time, posts []staticPost) ([]byte, error) {
+	tmplPath := s.IndexTemplatePath
+	if tmplPath == "" {
+		tmplPath = defaultStaticIndexTemplatePath
+	}
+	tmplFile, err := templates.NewFS().ReadFile(tmplPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't read index template")
+	}
+	tmpl, err := template.New("index").Parse(string(tmplFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "can't parse index template")
+	}
+	data := struct {
+		SiteID     string
+		ArchivedAt time.Time
+		Posts      []staticPost
+	}{SiteID: siteID, ArchivedAt: ts, Posts: posts}
+	buf := bytes.Buffer{}
+	if err = tmpl.Execute(&buf, data); err != nil {
+		return nil, errors.Wrap(err, "can't render index")
+	}
+	return buf.Bytes(), nil
+}
+
+// avatar returns src of user's picture by Avatars mode
+func (s *StaticSite) avatar(picture string) template.URL {
+	switch s.Avatars {
+	case AvatarsNone:
+		return ""
+	case AvatarsInline:
+		return s.inlineAvatar(picture)
+	default:
+		return linkAvatar(picture)
+	}
+}
+
+// inlineAvatar loads picture and returns it as data url, cached by url. Returns link to picture on errors.
+func (s *StaticSite) inlineAvatar(picture string) template.URL {
+	link := linkAvatar(picture)
+	if link == "" {
+		return ""
+	}
+	if s.avatars == nil {
+		s.avatars = map[string]template.URL{}
+	}
+	if res, ok := s.avatars[picture]; ok {
+		return res
+	}
+	res, err := s.loadAvatar(picture)
+	if err != nil {
+		log.Printf("[WARN] can't inline avatar %s, %v", picture, err)
+		res = link
+	}
+	s.avatars[picture] = res
+	return res
+}
+
+func (s *StaticSite) loadAvatar(picture string) (template.URL, error) {
+	resp, err := s.Client.Get(picture)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close() // nolint
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("status %d", resp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxInlineAvatarSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > maxInlineAvatarSize {
+		return "", errors.New("avatar is too large")
+	}
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !strings.HasPrefix(contentType, "image/") {
+		if contentType = http.DetectContentType(data); !strings.HasPrefix(contentType, "image/") {
+			return "", errors.Errorf("not an image, %s", contentType)
+		}
+	}
+	return template.URL("data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(data)), nil // nolint:gosec // image only
+}
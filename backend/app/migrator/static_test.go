@@ -0,0 +1,99 @@
+package migrator
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestStaticSite_Render(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n0000")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/avatar/u1.image":
+			_, _ = w.Write(png)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	loc, err := ioutil.TempDir("", "static")
+	require.NoError(t, err)
+	defer os.RemoveAll(loc)
+
+	post1 := store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/p1"}
+	post2 := store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/p2"}
+	ts0 := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	comments := []store.Comment{
+		{ID: "c1", Text: "<p>first</p>", Locator: post1, PostTitle: "Post 1", Timestamp: ts0,
+			User: store.User{ID: "u1", Name: "user one", IP: "10.1.2.3", Picture: ts.URL + "/avatar/u1.image"}},
+		{ID: "c2", ParentID: "c1", Text: "<p>reply</p>", Locator: post1, Timestamp: ts0.Add(time.Hour),
+			User: store.User{ID: "u2", Name: "user two", Picture: ts.URL + "/avatar/u2.image"}},
+		{ID: "c3", Text: "<p>held</p>", Locator: post1, Pending: true, Timestamp: ts0.Add(2 * time.Hour),
+			User: store.User{ID: "u2", Name: "user two"}},
+		{ID: "c4", Text: "<p>other post</p>", Locator: post2, Timestamp: ts0.Add(3 * time.Hour),
+			User: store.User{ID: "u3", Name: "user three", Picture: "javascript:alert(1)"}},
+		{ID: "c5", Text: "<p>other site</p>", Locator: store.Locator{SiteID: "other", URL: post1.URL}, Timestamp: ts0,
+			User: store.User{ID: "u3", Name: "user three"}},
+	}
+	buf := bytes.Buffer{}
+	gz := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(gz)
+	require.NoError(t, enc.Encode(meta{Version: 1}))
+	for _, c := range comments {
+		require.NoError(t, enc.Encode(c))
+	}
+	require.NoError(t, gz.Close())
+
+	s := StaticSite{Location: loc, TemplatePath: "../../templates/archive.html.tmpl",
+		IndexTemplatePath: "../../templates/static_index.html.tmpl", Avatars: AvatarsInline}
+	res, err := s.Render(bytes.NewReader(buf.Bytes()), "radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, StaticResult{Posts: 2, Comments: 3, IndexFile: path.Join(loc, "index.html")}, res)
+
+	index, err := ioutil.ReadFile(res.IndexFile)
+	require.NoError(t, err)
+	p1, p2 := "posts/"+store.EncodeID(post1.URL)+".html", "posts/"+store.EncodeID(post2.URL)+".html"
+	assert.Contains(t, string(index), `<a href="`+p1+`">Post 1</a>`)
+	assert.Contains(t, string(index), `<a href="`+p2+`">https://radio-t.com/p2</a>`)
+	assert.True(t, strings.Index(string(index), p2) < strings.Index(string(index), p1), "recent post first")
+
+	page, err := ioutil.ReadFile(path.Join(loc, p1))
+	require.NoError(t, err)
+	assert.Contains(t, string(page), "<title>Post 1 - archived comments</title>")
+	assert.Contains(t, string(page), `<a href="../index.html">All discussions</a>`)
+	assert.Contains(t, string(page), "<div><p>reply</p></div>")
+	assert.NotContains(t, string(page), "held", "pending comment skipped")
+	assert.NotContains(t, string(page), "10.1.2.3")
+	assert.Contains(t, string(page), `<img class="avatar" src="data:image/png;base64,iVBORw0KGgowMDAw" alt="" />`, "inlined")
+	assert.Contains(t, string(page), `<img class="avatar" src="`+ts.URL+`/avatar/u2.image" alt="" />`, "referenced on errors")
+
+	page, err = ioutil.ReadFile(path.Join(loc, p2))
+	require.NoError(t, err)
+	assert.NotContains(t, string(page), "javascript:")
+	assert.NotContains(t, string(page), `class="avatar"`)
+
+	s.Avatars = AvatarsNone
+	_, err = s.Render(bytes.NewReader(buf.Bytes()), "radio-t")
+	require.NoError(t, err)
+	page, err = ioutil.ReadFile(path.Join(loc, p1))
+	require.NoError(t, err)
+	assert.NotContains(t, string(page), `class="avatar"`)
+
+	_, err = s.Render(strings.NewReader(`{"version": 5}`), "radio-t")
+	assert.EqualError(t, err, "unexpected backup version 5")
+}
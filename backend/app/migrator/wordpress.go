@@ -1,6 +1,7 @@
 package migrator
 
 import (
+	"bufio"
 	"encoding/xml"
 	"html"
 	"io"
@@ -14,11 +15,24 @@ import (
 
 const wpTimeLayout = "2006-01-02 15:04:05"
 
-// WordPress implements Importer from WP xml
+// WordPress implements Importer from WP xml and Exporter to WXR, format of WordPress export
 type WordPress struct {
 	DataStore Store
 }
 
+// WPAuthor is WordPress author of exported comments, UserID 0 for guest comments
+type WPAuthor struct {
+	Name   string `json:"name"`
+	Email  string `json:"email,omitempty"`
+	URL    string `json:"url,omitempty"`
+	UserID int    `json:"user_id,omitempty"`
+}
+
+// WPExporter defines interface to export comments to WordPress with remark42 users mapped to authors by user id
+type WPExporter interface {
+	ExportAuthors(w io.Writer, siteID string, authors map[string]WPAuthor) (int, error)
+}
+
 type wpItem struct {
 	Link     string      `xml:"link"`
 	Comments []wpComment `xml:"comment"`
@@ -35,6 +49,48 @@ type wpComment struct {
 	PID         string `xml:"comment_parent"`
 }
 
+type wxrItem struct {
+	XMLName       xml.Name     `xml:"item"`
+	Title         string       `xml:"title"`
+	Link          string       `xml:"link"`
+	PostType      string       `xml:"wp:post_type"`
+	Status        string       `xml:"wp:status"`
+	CommentStatus string       `xml:"wp:comment_status"`
+	Comments      []wxrComment `xml:"wp:comment"`
+}
+
+type wxrComment struct {
+	ID          int       `xml:"wp:comment_id"`
+	Author      wxrCDATA  `xml:"wp:comment_author"`
+	AuthorEmail string    `xml:"wp:comment_author_email"`
+	AuthorURL   string    `xml:"wp:comment_author_url"`
+	Date        string    `xml:"wp:comment_date"`
+	DateGMT     string    `xml:"wp:comment_date_gmt"`
+	Content     wxrCDATA  `xml:"wp:comment_content"`
+	Approved    string    `xml:"wp:comment_approved"`
+	Parent      int       `xml:"wp:comment_parent"`
+	UserID      int       `xml:"wp:comment_user_id"`
+	Meta        []wxrMeta `xml:"wp:commentmeta"`
+}
+
+type wxrMeta struct {
+	Key   string   `xml:"wp:meta_key"`
+	Value wxrCDATA `xml:"wp:meta_value"`
+}
+
+type wxrCDATA struct {
+	Text string `xml:",cdata"`
+}
+
+const (
+	wxrHeader = xml.Header + `<rss version="2.0" xmlns:excerpt="http://wordpress.org/export/1.2/excerpt/" ` +
+		`xmlns:content="http://purl.org/rss/1.0/modules/content/" xmlns:wfw="http://wellformedweb.org/CommentAPI/" ` +
+		`xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:wp="http://wordpress.org/export/1.2/">
+<channel>
+<title>`
+	wxrFooter = "\n</channel>\n</rss>\n"
+)
+
 type wpTime struct {
 	time time.Time
 }
@@ -156,3 +212,98 @@ func (w *WordPress) convert(r io.Reader, siteID string) chan store.Comment {
 	}()
 	return commentsCh
 }
+
+// Export all comments of the site to WXR, authors named as remark42 users
+func (w *WordPress) Export(wr io.Writer, siteID string) (int, error) {
+	return w.ExportAuthors(wr, siteID, nil)
+}
+
+// ExportAuthors exports all comments of the site to WXR, item per post with comments threaded by parent.
+// Comments of users in authors map exported as given WordPress authors, other ones as guests with remark42 names.
+// WordPress needs numeric ids, comments numbered in order of creation and original ids kept in comment meta.
+// Pending comments exported not approved and deleted ones as trash, to keep replies to them in threads.
+func (w *WordPress) ExportAuthors(wr io.Writer, siteID string, authors map[string]WPAuthor) (size int, err error) {
+	bw := bufio.NewWriterSize(wr, exportBufferSize)
+	if _, err = io.WriteString(bw, wxrHeader); err != nil {
+		return 0, errors.Wrap(err, "can't write wxr header")
+	}
+	if err = xml.EscapeText(bw, []byte(siteID)); err != nil {
+		return 0, errors.Wrap(err, "can't write wxr header")
+	}
+	if _, err = io.WriteString(bw, "</title>\n<wp:wxr_version>1.2</wp:wxr_version>\n"); err != nil {
+		return 0, errors.Wrap(err, "can't write wxr header")
+	}
+
+	topics, err := w.DataStore.List(siteID, 0, 0)
+	if err != nil {
+		return 0, err
+	}
+	log.Printf("[DEBUG] exporting %d topics to wordpress", len(topics))
+
+	enc := xml.NewEncoder(bw)
+	enc.Indent("", "  ")
+	ids := map[string]int{}                 // remark42 id:wordpress id
+	for i := len(topics) - 1; i >= 0; i-- { // topics from List sorted in opposite direction
+		topic := topics[i]
+		comments, e := w.DataStore.Dump(store.Locator{SiteID: siteID, URL: topic.URL})
+		if e != nil {
+			return size, e
+		}
+		item := wxrItem{Title: topic.URL, Link: topic.URL, PostType: "post", Status: "publish", CommentStatus: "open"}
+		if topic.ReadOnly {
+			item.CommentStatus = "closed"
+		}
+		for _, c := range comments { // sorted by time, parents go before replies
+			if c.PostTitle != "" {
+				item.Title = c.PostTitle
+			}
+			size++
+			ids[c.ID] = size
+			item.Comments = append(item.Comments, w.wxrComment(c, size, ids[c.ParentID], authors))
+		}
+		if err = enc.Encode(item); err != nil {
+			return size, errors.Wrapf(err, "can't write comments of %s", topic.URL)
+		}
+	}
+
+	if _, err = io.WriteString(bw, wxrFooter); err != nil {
+		return size, errors.Wrap(err, "can't write wxr footer")
+	}
+	if err = bw.Flush(); err != nil {
+		return size, errors.Wrap(err, "can't write comment data")
+	}
+	log.Printf("[DEBUG] exported %d comments to wordpress", size)
+	return size, nil
+}
+
+// wxrComment makes WXR comment with given ids, unknown parent exported as 0, i.e. top-level comment
+func (w *WordPress) wxrComment(c store.Comment, id, parentID int, authors map[string]WPAuthor) wxrComment {
+	author, ok := authors[c.User.ID]
+	if !ok {
+		author = WPAuthor{Name: c.User.Name}
+	}
+	ts := c.Timestamp.UTC().Format(wpTimeLayout)
+	res := wxrComment{
+		ID:          id,
+		Author:      wxrCDATA{author.Name},
+		AuthorEmail: author.Email,
+		AuthorURL:   author.URL,
+		Date:        ts,
+		DateGMT:     ts,
+		Content:     wxrCDATA{c.Text},
+		Approved:    "1",
+		Parent:      parentID,
+		UserID:      author.UserID,
+		Meta: []wxrMeta{
+			{Key: "remark42_id", Value: wxrCDATA{c.ID}},
+			{Key: "remark42_user_id", Value: wxrCDATA{c.User.ID}},
+		},
+	}
+	switch {
+	case c.Deleted:
+		res.Approved = "trash"
+	case c.Pending:
+		res.Approved = "0"
+	}
+	return res
+}
@@ -1,6 +1,7 @@
 package migrator
 
 import (
+	"bytes"
 	"os"
 	"strings"
 	"testing"
@@ -56,6 +57,79 @@ func TestWordPress_Import(t *testing.T) {
 	assert.Equal(t, 3, count)
 }
 
+func TestWordPress_Export(t *testing.T) {
+	defer func() {
+		_ = os.Remove("/tmp/remark-test.db")
+		_ = os.Remove("/tmp/remark-test-wp.db")
+	}()
+	b, err := engine.NewBoltDB(bolt.Options{}, engine.BoltSite{FileName: "/tmp/remark-test.db", SiteID: "radio-t"},
+		engine.BoltSite{FileName: "/tmp/remark-test-wp.db", SiteID: "wp"})
+	require.NoError(t, err, "create store")
+	dataStore := service.DataStore{Engine: b, AdminStore: admin.NewStaticStore("12345", nil, []string{}, "")}
+	defer dataStore.Close()
+
+	ts := time.Date(2021, 3, 5, 10, 11, 12, 0, time.UTC)
+	locator := store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/p/1/"}
+	comments := []store.Comment{
+		{ID: "c1", Locator: locator, Text: "<p>first & <b>bold</b></p>", PostTitle: "Podcast 1", Timestamp: ts,
+			User: store.User{ID: "github_1", Name: "user one"}},
+		{ID: "c2", ParentID: "c1", Locator: locator, Text: "<p>reply</p>", Timestamp: ts.Add(time.Minute),
+			User: store.User{ID: "github_2", Name: "user two"}},
+		{ID: "c3", ParentID: "c2", Locator: locator, Text: "<p>to be deleted</p>", Timestamp: ts.Add(2 * time.Minute),
+			User: store.User{ID: "github_1", Name: "user one"}},
+		{ID: "c4", ParentID: "c3", Locator: locator, Text: "<p>pending</p>", Timestamp: ts.Add(3 * time.Minute),
+			User: store.User{ID: "github_2", Name: "user two"}, Pending: true},
+		{ID: "c5", Locator: store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/p/2/"}, Text: "<p>another</p>",
+			Timestamp: ts.Add(time.Hour), User: store.User{ID: "github_2", Name: "user two"}},
+	}
+	for _, c := range comments {
+		_, err = dataStore.Create(c)
+		require.NoError(t, err)
+	}
+	require.NoError(t, dataStore.Delete(locator, "c3", store.SoftDelete))
+
+	wp := WordPress{DataStore: &dataStore}
+	buf := bytes.Buffer{}
+	size, err := wp.ExportAuthors(&buf, "radio-t", map[string]WPAuthor{
+		"github_1": {Name: "Admin", Email: "admin@example.com", URL: "https://example.com", UserID: 1}})
+	require.NoError(t, err)
+	assert.Equal(t, 5, size)
+	out := buf.String()
+	assert.True(t, strings.HasPrefix(out, `<?xml version="1.0" encoding="UTF-8"?>`))
+	assert.Contains(t, out, "<title>radio-t</title>")
+	assert.Contains(t, out, `<title>Podcast 1</title>`)
+	assert.Contains(t, out, `<wp:comment_content><![CDATA[<p>first &amp; <b>bold</b></p>]]></wp:comment_content>`)
+	assert.Contains(t, out, `<wp:comment_author><![CDATA[Admin]]></wp:comment_author>
+    <wp:comment_author_email>admin@example.com</wp:comment_author_email>
+    <wp:comment_author_url>https://example.com</wp:comment_author_url>
+    <wp:comment_date>2021-03-05 10:11:12</wp:comment_date>
+    <wp:comment_date_gmt>2021-03-05 10:11:12</wp:comment_date_gmt>`)
+	assert.Contains(t, out, `<wp:comment_approved>trash</wp:comment_approved>`)
+	assert.Contains(t, out, `<wp:comment_approved>0</wp:comment_approved>
+    <wp:comment_parent>3</wp:comment_parent>`)
+	assert.Contains(t, out, `<wp:meta_key>remark42_id</wp:meta_key>
+      <wp:meta_value><![CDATA[c2]]></wp:meta_value>`)
+	assert.True(t, strings.HasSuffix(out, "</channel>\n</rss>\n"))
+
+	// exported comments imported back, deleted and pending ones skipped by import
+	size, err = wp.Import(&buf, "wp")
+	require.NoError(t, err)
+	assert.Equal(t, 3, size)
+	res, err := dataStore.Find(store.Locator{SiteID: "wp", URL: "https://radio-t.com/p/1/"}, "time", adminUser)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(res))
+	assert.Equal(t, "1", res[0].ID)
+	assert.Equal(t, "Admin", res[0].User.Name)
+	assert.Contains(t, res[0].Text, "<p>first &amp; <b>bold</b></p>")
+	assert.Equal(t, ts, res[0].Timestamp)
+	assert.Equal(t, "2", res[1].ID)
+	assert.Equal(t, "1", res[1].ParentID)
+	assert.Equal(t, "user two", res[1].User.Name)
+
+	_, err = wp.Export(&bytes.Buffer{}, "bad")
+	assert.Error(t, err)
+}
+
 func TestWordPress_Convert(t *testing.T) {
 	wp := WordPress{}
 	ch := wp.convert(strings.NewReader(xmlTestWP), "testWP")
@@ -0,0 +1,163 @@
+package moderation
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/antonmedv/expr"
+	"github.com/antonmedv/expr/vm"
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/reputation"
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// Expression is a rule written in expr language (https://github.com/antonmedv/expr), evaluated against new comment.
+// Expression should return bool, i.e. `links > 2 && user_comments < 3`. Variables available in expressions:
+//   - text, html, length, links: original text, rendered text, number of characters in text and number of links
+//   - site, url, reply: site id, post url and true for reply to another comment
+//   - user_id, user_name, anonymous: author of comment, anonymous is true for anonymous user
+//   - user_age, user_comments, user_score, user_negative: days since the first comment of the author on the site,
+//     number of author's comments, their total score and number of comments with negative score. Counted by
+//     the last comments of the author, zero for new user
+//   - karma: reputation of the author, see reputation.Karma. Zero if reputation disabled
+//   - hour, weekday: hour (0-23) and day of week (0 is Sunday) the comment posted, in server's time zone
+type Expression struct {
+	Name   string `json:"name,omitempty"` // reported as reason of action, expression itself used if empty
+	Expr   string `json:"expr"`
+	Action Action `json:"action"` // Approve, Hold, Reject or Notify
+}
+
+// History provides the last comments of user, optional, used by expressions with user_* variables
+type History interface {
+	User(siteID, userID string, limit, skip int, user store.User) ([]store.Comment, error)
+}
+
+// Reputation provides karma of user, optional, used by expressions with karma variable
+type Reputation interface {
+	Karma(siteID, userID string) reputation.Karma
+}
+
+const historyLimit = 1000 // max number of user's comments counted in user_* variables
+
+type compiledExpr struct {
+	Expression
+	program *vm.Program
+}
+
+// userStats keeps user_* variables of expressions
+type userStats struct {
+	age      float64
+	comments int
+	score    int
+	negative int
+	karma    int
+}
+
+// compileExpressions validates expressions and compiles them with the set of variables
+func compileExpressions(exprs []Expression) ([]compiledExpr, error) {
+	res := make([]compiledExpr, 0, len(exprs))
+	for _, e := range exprs {
+		switch e.Action {
+		case Approve, Hold, Reject, Notify:
+		default:
+			return nil, errors.Errorf("unknown action %q of expression %q", e.Action, e.Expr)
+		}
+		if strings.TrimSpace(e.Expr) == "" {
+			return nil, errors.New("empty expression")
+		}
+		program, err := expr.Compile(e.Expr, expr.Env(exprEnv(store.Comment{}, "", 0, userStats{})), expr.AsBool())
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid expression %q", e.Expr)
+		}
+		res = append(res, compiledExpr{Expression: e, program: program})
+	}
+	return res, nil
+}
+
+// matchExpressions returns action and reason of the first expression matched by comment, Pass if none matched.
+// Failed expressions logged and skipped. History of user requested once, only if site has expressions.
+func (c *compiledRules) matchExpressions(comment store.Comment, text string, links int, history History,
+	rep Reputation) (Action, string) {
+	if len(c.expressions) == 0 {
+		return Pass, ""
+	}
+	st := stats(history, comment)
+	if rep != nil && comment.User.ID != "" {
+		st.karma = rep.Karma(comment.Locator.SiteID, comment.User.ID).Karma
+	}
+	env := exprEnv(comment, text, links, st)
+	for _, e := range c.expressions {
+		res, err := expr.Run(e.program, env)
+		if err != nil {
+			log.Printf("[WARN] moderation expression %q failed, %v", e.Expr, err)
+			continue
+		}
+		if matched, ok := res.(bool); ok && matched {
+			reason := e.Name
+			if reason == "" {
+				reason = e.Expr
+			}
+			return e.Action, "rule " + reason
+		}
+	}
+	return Pass, ""
+}
+
+// exprEnv makes variables of expressions for the comment
+func exprEnv(comment store.Comment, text string, links int, st userStats) map[string]interface{} {
+	ts := comment.Timestamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	return map[string]interface{}{
+		"text":          text,
+		"html":          comment.Text,
+		"length":        utf8.RuneCountInString(text),
+		"links":         links,
+		"site":          comment.Locator.SiteID,
+		"url":           comment.Locator.URL,
+		"reply":         comment.ParentID != "",
+		"user_id":       comment.User.ID,
+		"user_name":     comment.User.Name,
+		"anonymous":     strings.HasPrefix(comment.User.ID, "anonymous_"),
+		"user_age":      st.age,
+		"user_comments": st.comments,
+		"user_score":    st.score,
+		"user_negative": st.negative,
+		"karma":         st.karma,
+		"hour":          ts.Hour(),
+		"weekday":       int(ts.Weekday()),
+	}
+}
+
+// stats counts user_* variables by the last comments of the author, zero values if history not set or failed
+func stats(history History, comment store.Comment) (res userStats) {
+	if history == nil || comment.User.ID == "" {
+		return res
+	}
+	comments, err := history.User(comment.Locator.SiteID, comment.User.ID, historyLimit, 0, store.User{})
+	if err != nil {
+		return res // no comments of new user reported as error by some engines
+	}
+	first := time.Now()
+	for _, c := range comments {
+		if c.ID == comment.ID || c.Deleted {
+			continue
+		}
+		res.comments++
+		res.score += c.Score
+		if c.Score < 0 {
+			res.negative++
+		}
+		if c.Timestamp.Before(first) {
+			first = c.Timestamp
+		}
+	}
+	if res.comments > 0 {
+		res.age = time.Since(first).Hours() / 24
+	}
+	return res
+}
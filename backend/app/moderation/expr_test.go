@@ -0,0 +1,141 @@
+package moderation
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/reputation"
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestFilter_CheckExpressions(t *testing.T) {
+	history := &mockHistory{comments: map[string][]store.Comment{
+		"old": {
+			{ID: "o1", Score: 5, Timestamp: time.Now().AddDate(0, 0, -40)},
+			{ID: "o2", Score: -1, Timestamp: time.Now().AddDate(0, 0, -10)},
+			{ID: "o3", Score: 10, Deleted: true},
+		},
+		"troll": {{ID: "t1", Score: -3}, {ID: "t2", Score: -2}, {ID: "t3", Score: 1}},
+	}}
+	f := NewFilter(prepStore(t))
+	f.History = history
+
+	rules, err := f.SetRules("site1", Rules{Words: []string{"casino"}, Action: Reject, Expressions: []Expression{
+		{Name: "trusted", Expr: `user_age > 30 && user_score >= 4`, Action: Approve},
+		{Name: "trolls", Expr: `user_negative >= 2`, Action: Reject},
+		{Expr: `links > 1 && user_comments < 3`, Action: Hold},
+		{Name: "night", Expr: `hour >= 1 && hour < 5`, Action: Notify},
+		{Name: "short reply", Expr: `reply && length < 3`, Action: Hold},
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, 5, len(rules.Expressions))
+
+	day := time.Date(2021, 5, 10, 12, 0, 0, 0, time.Local)
+	night := time.Date(2021, 5, 10, 3, 0, 0, 0, time.Local)
+	twoLinks := `<p><a href="http://a">a</a> <a href="http://b">b</a></p>`
+	tbl := []struct {
+		comment store.Comment
+		action  Action
+		reason  string
+	}{
+		{store.Comment{Orig: "casino", User: store.User{ID: "old"}, Timestamp: day}, Approve, "rule trusted"},
+		{store.Comment{Orig: "hello", User: store.User{ID: "troll"}, Timestamp: day}, Reject, "rule trolls"},
+		{store.Comment{Orig: "links", Text: twoLinks, User: store.User{ID: "new"}, Timestamp: day}, Hold,
+			"rule links > 1 && user_comments < 3"},
+		{store.Comment{Orig: "hello", User: store.User{ID: "new"}, Timestamp: night}, Notify, "rule night"},
+		{store.Comment{Orig: "casino", User: store.User{ID: "new"}, Timestamp: night}, Reject, "blocked word casino"},
+		{store.Comment{Orig: "ok", ParentID: "p1", User: store.User{ID: "new"}, Timestamp: day}, Hold, "rule short reply"},
+		{store.Comment{Orig: "hello", User: store.User{ID: "new"}, Timestamp: day}, Pass, ""},
+	}
+	for i, tt := range tbl {
+		tt.comment.Locator = store.Locator{SiteID: "site1", URL: "u"}
+		action, reason := f.Check(tt.comment)
+		assert.Equal(t, tt.action, action, "case #%d", i)
+		assert.Equal(t, tt.reason, reason, "case #%d", i)
+	}
+
+	history.err = errors.New("no comments")
+	action, _ := f.Check(store.Comment{Orig: "hello", User: store.User{ID: "troll"}, Timestamp: day,
+		Locator: store.Locator{SiteID: "site1"}})
+	assert.Equal(t, Pass, action, "history error ignored")
+
+	f.History = nil
+	action, _ = f.Check(store.Comment{Orig: "a", ParentID: "p1", Timestamp: day, Locator: store.Locator{SiteID: "site1"}})
+	assert.Equal(t, Hold, action, "works without history")
+}
+
+func TestFilter_CheckKarma(t *testing.T) {
+	history := &mockHistory{comments: map[string][]store.Comment{
+		"good": {{ID: "g1", Score: 8}, {ID: "g2", Score: 4}},
+		"bad":  {{ID: "b1", Score: 1}, {ID: "b2", Deleted: true}, {ID: "b3", Deleted: true}},
+	}}
+	f := NewFilter(prepStore(t))
+	f.Reputation = reputation.NewService(history, reputation.DefaultWeights, 0)
+	_, err := f.SetRules("site1", Rules{Expressions: []Expression{
+		{Name: "high karma", Expr: `karma >= 10`, Action: Approve},
+		{Name: "low karma", Expr: `karma < 0`, Action: Hold},
+	}})
+	require.NoError(t, err)
+
+	tbl := []struct {
+		user   string
+		action Action
+	}{{"good", Approve}, {"bad", Hold}, {"new", Pass}}
+	for i, tt := range tbl {
+		action, _ := f.Check(store.Comment{Orig: "hello", User: store.User{ID: tt.user},
+			Locator: store.Locator{SiteID: "site1", URL: "u"}})
+		assert.Equal(t, tt.action, action, "case #%d", i)
+	}
+
+	f.Reputation = nil
+	action, _ := f.Check(store.Comment{Orig: "hello", User: store.User{ID: "bad"}, Locator: store.Locator{SiteID: "site1"}})
+	assert.Equal(t, Pass, action, "zero karma without reputation")
+}
+
+func TestFilter_SetRulesInvalidExpressions(t *testing.T) {
+	f := NewFilter(prepStore(t))
+	tbl := []struct {
+		expr Expression
+		err  string
+	}{
+		{Expression{Expr: "links > 1", Action: "blah"}, `unknown action "blah" of expression "links > 1"`},
+		{Expression{Expr: " ", Action: Hold}, "empty expression"},
+		{Expression{Expr: "links + 1", Action: Hold}, `invalid expression "links + 1"`},
+		{Expression{Expr: "unknown > 1", Action: Hold}, `invalid expression "unknown > 1"`},
+		{Expression{Expr: "links >", Action: Hold}, `invalid expression "links >"`},
+	}
+	for i, tt := range tbl {
+		_, err := f.SetRules("site1", Rules{Expressions: []Expression{tt.expr}})
+		require.Error(t, err, "case #%d", i)
+		assert.Contains(t, err.Error(), tt.err, "case #%d", i)
+	}
+}
+
+func TestStats(t *testing.T) {
+	h := &mockHistory{comments: map[string][]store.Comment{"u1": {
+		{ID: "c1", Score: 2, Timestamp: time.Now().Add(-48 * time.Hour)},
+		{ID: "c2", Score: -1, Timestamp: time.Now().Add(-24 * time.Hour)},
+		{ID: "c3", Score: 7, Timestamp: time.Now()}, // the comment itself, edited
+	}}}
+	st := stats(h, store.Comment{ID: "c3", User: store.User{ID: "u1"}})
+	assert.Equal(t, 2, st.comments)
+	assert.Equal(t, 1, st.score)
+	assert.Equal(t, 1, st.negative)
+	assert.InDelta(t, 2, st.age, 0.01)
+
+	assert.Equal(t, userStats{}, stats(h, store.Comment{User: store.User{ID: "u2"}}))
+	assert.Equal(t, userStats{}, stats(nil, store.Comment{User: store.User{ID: "u1"}}))
+}
+
+type mockHistory struct {
+	comments map[string][]store.Comment
+	err      error
+}
+
+func (m *mockHistory) User(_, userID string, _, _ int, _ store.User) ([]store.Comment, error) {
+	return m.comments[userID], m.err
+}
@@ -0,0 +1,226 @@
+// Package moderation checks new comments against admin-managed per-site blocklists of words, regular expressions
+// and number of links, comments matched by the lists rejected or held for review. Site-specific policies
+// can be set with expressions, see Expression. Rules kept in Store and applied immediately after change, without restart.
+package moderation
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// Action made with comment matched by rules
+type Action string
+
+// enum of all actions
+const (
+	Pass    Action = ""        // comment not matched
+	Hold    Action = "pending" // comment held for review
+	Reject  Action = "reject"  // comment rejected
+	Approve Action = "approve" // comment published, blocklists not checked. Used by expressions only
+	Notify  Action = "notify"  // comment published, admins alerted. Used by expressions only
+)
+
+// Rules is a set of blocklists for a site
+type Rules struct {
+	Words    []string `json:"words"`     // blocked words, case-insensitive
+	Patterns []string `json:"patterns"`  // blocked regular expressions, matched against original text
+	MaxLinks int      `json:"max_links"` // max number of links in comment, 0 for no limit
+	Action   Action   `json:"action"`    // action on matched comment, Hold by default
+
+	Expressions []Expression `json:"expressions,omitempty"` // checked in order before blocklists, the first matched applied
+}
+
+// Store defines interface to keep rules per site
+type Store interface {
+	Get(siteID string) (Rules, error) // returns empty rules for unknown site
+	Set(siteID string, rules Rules) error
+}
+
+// Filter checks comments with rules loaded from store. Compiled rules cached per site and replaced on update.
+type Filter struct {
+	History    History    // optional, provides user_* variables of expressions
+	Reputation Reputation // optional, provides karma variable of expressions
+	store      Store
+
+	lock     sync.RWMutex
+	compiled map[string]*compiledRules
+}
+
+type compiledRules struct {
+	Rules
+	words       map[string]bool
+	patterns    []*regexp.Regexp
+	expressions []compiledExpr
+}
+
+var linkRe = regexp.MustCompile(`(?i)<a\s`)
+
+// NewFilter makes filter for rules from the store
+func NewFilter(st Store) *Filter {
+	return &Filter{store: st, compiled: map[string]*compiledRules{}}
+}
+
+// Rules returns rules of the site
+func (f *Filter) Rules(siteID string) (Rules, error) {
+	return f.store.Get(siteID)
+}
+
+// SetRules validates and saves rules of the site, new rules used for all comments checked after the call.
+// Returns normalized rules, i.e. with lower-cased words and default action.
+func (f *Filter) SetRules(siteID string, rules Rules) (Rules, error) {
+	c, err := compile(rules)
+	if err != nil {
+		return Rules{}, err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if err = f.store.Set(siteID, c.Rules); err != nil {
+		return Rules{}, errors.Wrapf(err, "can't save moderation rules of %s", siteID)
+	}
+	f.compiled[siteID] = c
+	log.Printf("[INFO] moderation rules of %s updated, %d words, %d patterns, max links %d, action %s, %d expressions",
+		siteID, len(c.Words), len(c.Patterns), c.MaxLinks, c.Action, len(c.Expressions))
+	return c.Rules, nil
+}
+
+// Check comment against rules of its site, returns action and human-readable reason for matched comment.
+// Comments with Approve and Notify actions should be published. Errors of store logged and comment passed.
+func (f *Filter) Check(comment store.Comment) (action Action, reason string) {
+	rules, err := f.rules(comment.Locator.SiteID)
+	if err != nil {
+		log.Printf("[WARN] can't get moderation rules of %s, %v", comment.Locator.SiteID, err)
+		return Pass, ""
+	}
+
+	if action, reason = rules.match(comment, f.History, f.Reputation); action == Pass {
+		return Pass, ""
+	}
+	log.Printf("[INFO] comment of %s on %s matched moderation rules, %s, action %s",
+		comment.User.ID, comment.Locator.URL, reason, action)
+	return action, reason
+}
+
+// Flush compiled rules of the site, for rules changed bypassing the filter, i.e. by replication
+func (f *Filter) Flush(siteID string) {
+	f.lock.Lock()
+	delete(f.compiled, siteID)
+	f.lock.Unlock()
+}
+
+// rules returns compiled rules of the site, loaded from store on the first call
+func (f *Filter) rules(siteID string) (*compiledRules, error) {
+	f.lock.RLock()
+	c, ok := f.compiled[siteID]
+	f.lock.RUnlock()
+	if ok {
+		return c, nil
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if c, ok = f.compiled[siteID]; ok {
+		return c, nil
+	}
+	rules, err := f.store.Get(siteID)
+	if err != nil {
+		return nil, err
+	}
+	if c, err = compile(rules); err != nil {
+		return nil, err
+	}
+	f.compiled[siteID] = c
+	return c, nil
+}
+
+// compile validates rules and makes set of words and compiled patterns
+func compile(rules Rules) (*compiledRules, error) {
+	switch rules.Action {
+	case Pass:
+		rules.Action = Hold
+	case Hold, Reject:
+	default:
+		return nil, errors.Errorf("unknown moderation action %q", rules.Action)
+	}
+	if rules.MaxLinks < 0 {
+		return nil, errors.Errorf("invalid max links %d", rules.MaxLinks)
+	}
+
+	res := compiledRules{words: map[string]bool{}}
+	words := []string{}
+	for _, w := range rules.Words {
+		w = strings.ToLower(strings.TrimSpace(w))
+		if w == "" || res.words[w] {
+			continue
+		}
+		res.words[w] = true
+		words = append(words, w)
+	}
+	for _, p := range rules.Patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid pattern %q", p)
+		}
+		res.patterns = append(res.patterns, re)
+	}
+	if rules.Patterns == nil {
+		rules.Patterns = []string{}
+	}
+	var err error
+	if res.expressions, err = compileExpressions(rules.Expressions); err != nil {
+		return nil, err
+	}
+	rules.Words = words
+	res.Rules = rules
+	return &res, nil
+}
+
+// match returns action and reason if comment matched by rules, Pass otherwise. Expressions checked first,
+// Notify action of expression kept unless blocklists matched. Links counted in rendered text,
+// words and patterns matched against original text.
+func (c *compiledRules) match(comment store.Comment, history History, rep Reputation) (Action, string) {
+	text := comment.Orig
+	if text == "" {
+		text = html.UnescapeString(bluemonday.StrictPolicy().Sanitize(comment.Text))
+	}
+	links := len(linkRe.FindAllStringIndex(comment.Text, -1))
+
+	exprAction, exprReason := c.matchExpressions(comment, text, links, history, rep)
+	if exprAction != Pass && exprAction != Notify {
+		return exprAction, exprReason
+	}
+	if reason := c.matchLists(text, links); reason != "" {
+		return c.Action, reason
+	}
+	return exprAction, exprReason
+}
+
+// matchLists returns reason if comment matched by blocklists, empty string otherwise
+func (c *compiledRules) matchLists(text string, links int) string {
+	if c.MaxLinks > 0 && links > c.MaxLinks {
+		return "too many links"
+	}
+
+	if len(c.words) > 0 {
+		notWord := func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsNumber(r) }
+		for _, w := range strings.FieldsFunc(strings.ToLower(text), notWord) {
+			if c.words[w] {
+				return "blocked word " + w
+			}
+		}
+	}
+	for _, re := range c.patterns {
+		if re.MatchString(text) {
+			return "blocked pattern " + re.String()
+		}
+	}
+	return ""
+}
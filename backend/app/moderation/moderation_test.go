@@ -0,0 +1,113 @@
+package moderation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+func TestFilter_Check(t *testing.T) {
+	f := NewFilter(prepStore(t))
+	comment := func(site, text string) store.Comment {
+		return store.Comment{Orig: text, Text: "<p>" + text + "</p>", Locator: store.Locator{SiteID: site, URL: "u"}}
+	}
+
+	action, reason := f.Check(comment("site1", "blah buy Viagra"))
+	assert.Equal(t, Pass, action, "no rules")
+	assert.Equal(t, "", reason)
+
+	rules, err := f.SetRules("site1", Rules{Words: []string{" Viagra", "viagra", "casino", ""},
+		Patterns: []string{`(?i)free\s+money`}})
+	require.NoError(t, err)
+	assert.Equal(t, Rules{Words: []string{"viagra", "casino"}, Patterns: []string{`(?i)free\s+money`}, Action: Hold}, rules)
+
+	tbl := []struct {
+		site, text string
+		action     Action
+		reason     string
+	}{
+		{"site1", "blah buy Viagra!", Hold, "blocked word viagra"},
+		{"site1", "blah buy viagras", Pass, ""},
+		{"site1", "get FREE  money now", Hold, `blocked pattern (?i)free\s+money`},
+		{"site1", "casinos nearby", Pass, ""},
+		{"site2", "blah buy viagra", Pass, ""},
+	}
+	for i, tt := range tbl {
+		action, reason = f.Check(comment(tt.site, tt.text))
+		assert.Equal(t, tt.action, action, "case #%d", i)
+		assert.Equal(t, tt.reason, reason, "case #%d", i)
+	}
+
+	_, err = f.SetRules("site2", Rules{MaxLinks: 1, Action: Reject})
+	require.NoError(t, err)
+	c := store.Comment{Text: `<p><a href="http://a">a</a> and <A href="http://b">b</a></p>`, Locator: store.Locator{SiteID: "site2"}}
+	action, reason = f.Check(c)
+	assert.Equal(t, Reject, action)
+	assert.Equal(t, "too many links", reason)
+	c.Text = `<p><a href="http://a">a</a> casino</p>`
+	action, _ = f.Check(c)
+	assert.Equal(t, Pass, action)
+
+	_, err = f.SetRules("site1", Rules{Patterns: []string{"[a-"}})
+	assert.Error(t, err, "invalid pattern")
+	_, err = f.SetRules("site1", Rules{Action: "blah"})
+	assert.Error(t, err, "invalid action")
+	_, err = f.SetRules("site1", Rules{MaxLinks: -1})
+	assert.Error(t, err, "invalid max links")
+	action, _ = f.Check(comment("site1", "viagra"))
+	assert.Equal(t, Hold, action, "rules not changed on error")
+}
+
+func TestFilter_LoadFromStore(t *testing.T) {
+	st := prepStore(t)
+	require.NoError(t, st.Set("site1", Rules{Words: []string{"Spam"}, Action: Reject}))
+	f := NewFilter(st)
+	action, reason := f.Check(store.Comment{Orig: "some spam", Locator: store.Locator{SiteID: "site1"}})
+	assert.Equal(t, Reject, action)
+	assert.Equal(t, "blocked word spam", reason)
+
+	rules, err := f.Rules("site1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Spam"}, rules.Words)
+
+	require.NoError(t, st.Set("site1", Rules{Words: []string{"ham"}, Action: Reject}), "changed bypassing the filter")
+	action, _ = f.Check(store.Comment{Orig: "some spam", Locator: store.Locator{SiteID: "site1"}})
+	assert.Equal(t, Reject, action, "compiled rules cached")
+	f.Flush("site1")
+	action, _ = f.Check(store.Comment{Orig: "some spam", Locator: store.Locator{SiteID: "site1"}})
+	assert.Equal(t, Pass, action)
+
+	action, _ = f.Check(store.Comment{Orig: "some spam", Locator: store.Locator{SiteID: "site3"}})
+	assert.Equal(t, Pass, action, "store error ignored")
+	_, err = f.SetRules("site3", Rules{})
+	assert.EqualError(t, err, `can't save moderation rules of site3: site "site3" not found`)
+}
+
+func TestEngineStore(t *testing.T) {
+	st := prepStore(t)
+
+	rules, err := st.Get("site1")
+	require.NoError(t, err)
+	assert.Equal(t, Rules{Words: []string{}, Patterns: []string{}}, rules)
+
+	require.NoError(t, st.Set("site1", Rules{Words: []string{"w1"}, Patterns: []string{"p1"}, MaxLinks: 2, Action: Reject}))
+	require.NoError(t, st.Set("site2", Rules{Words: []string{"w2"}, Action: Hold}))
+	assert.Error(t, st.Set("", Rules{}))
+
+	rules, err = st.Get("site1")
+	require.NoError(t, err)
+	assert.Equal(t, Rules{Words: []string{"w1"}, Patterns: []string{"p1"}, MaxLinks: 2, Action: Reject}, rules)
+	rules, err = st.Get("site2")
+	require.NoError(t, err)
+	assert.Equal(t, Rules{Words: []string{"w2"}, Patterns: []string{}, Action: Hold}, rules)
+}
+
+func prepStore(t *testing.T) *EngineStore {
+	eng, err := engine.NewMemory("", "site1", "site2")
+	require.NoError(t, err)
+	return NewEngineStore(eng)
+}
@@ -0,0 +1,42 @@
+package moderation
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+const rulesKey = "rules" // single record of the site
+
+// EngineStore implements Store with records of store engine, rules kept along with comments of the site
+type EngineStore struct {
+	records engine.Records
+}
+
+// NewEngineStore makes store of moderation rules kept by eng
+func NewEngineStore(eng engine.Interface) *EngineStore {
+	return &EngineStore{records: engine.Records{Engine: eng, Kind: engine.ModerationRules}}
+}
+
+// Get rules of the site, empty rules returned for site without rules
+func (e *EngineStore) Get(siteID string) (Rules, error) {
+	res := Rules{Words: []string{}, Patterns: []string{}}
+	if _, err := e.records.Get(siteID, rulesKey, &res); err != nil {
+		return res, err
+	}
+	if res.Words == nil {
+		res.Words = []string{}
+	}
+	if res.Patterns == nil {
+		res.Patterns = []string{}
+	}
+	return res, nil
+}
+
+// Set rules of the site, replacing previous ones
+func (e *EngineStore) Set(siteID string, rules Rules) error {
+	if siteID == "" {
+		return errors.New("site id required for moderation rules")
+	}
+	return e.records.Set(siteID, rulesKey, rules)
+}
@@ -0,0 +1,185 @@
+package notify
+
+import (
+	"strings"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+)
+
+// AdminEvents defines which new comments admin notified about
+type AdminEvents string
+
+// enum of all admin events
+const (
+	AdminEventsAll     AdminEvents = "all"     // every new comment, including held for moderation
+	AdminEventsPending AdminEvents = "pending" // comments held for moderation only
+	AdminEventsFlagged AdminEvents = "flagged" // comments with watched keywords only
+	AdminEventsNone    AdminEvents = "none"
+)
+
+// enum of admin destinations
+const (
+	AdminDestEmail    = "email"
+	AdminDestTelegram = "telegram"
+)
+
+// AdminPrefs defines notification preferences of the admin. Empty fields inherited from default preferences.
+type AdminPrefs struct {
+	Events       AdminEvents `json:"events,omitempty"`
+	Destinations []string    `json:"destinations,omitempty"`  // email and telegram
+	TelegramChat string      `json:"telegram_chat,omitempty"` // personal chat of the admin, required for telegram
+}
+
+// AdminPrefsStore defines interface to keep notification preferences of admins by their emails
+type AdminPrefsStore interface {
+	Get(siteID, email string) (AdminPrefs, error) // empty prefs if not set
+	Set(siteID, email string, prefs AdminPrefs) error
+}
+
+// match checks if admin notified about the request
+func (e AdminEvents) match(req Request) bool {
+	switch e {
+	case AdminEventsAll:
+		return true
+	case AdminEventsPending:
+		return req.Comment.Pending
+	case AdminEventsFlagged:
+		return len(req.Keywords) > 0
+	}
+	return false
+}
+
+// inherit returns prefs with empty events and destinations set from defaults
+func (p AdminPrefs) inherit(defaults AdminPrefs) AdminPrefs {
+	if p.Events == "" {
+		p.Events = defaults.Events
+	}
+	if len(p.Destinations) == 0 {
+		p.Destinations = defaults.Destinations
+	}
+	return p
+}
+
+// validate checks events and destinations of prefs
+func (p AdminPrefs) validate() error {
+	switch p.Events {
+	case "", AdminEventsAll, AdminEventsPending, AdminEventsFlagged, AdminEventsNone:
+	default:
+		return errors.Errorf("unknown admin events %q", p.Events)
+	}
+	for _, d := range p.Destinations {
+		switch d {
+		case AdminDestEmail:
+		case AdminDestTelegram:
+			if p.TelegramChat == "" {
+				return errors.New("telegram chat required for telegram destination")
+			}
+		default:
+			return errors.Errorf("unknown admin destination %q", d)
+		}
+	}
+	return nil
+}
+
+func (p AdminPrefs) has(dest string) bool {
+	for _, d := range p.Destinations {
+		if d == dest {
+			return true
+		}
+	}
+	return false
+}
+
+// SetAdmins sets emails of admins notified about new comments by their own preferences kept in the store,
+// defaults used for admins without preferences. Shared admin destinations, like telegram channel and slack,
+// notified by default events. Store is optional, without it all admins notified by defaults.
+// Should be called before submitting any requests.
+func (s *Service) SetAdmins(emails []string, defaults AdminPrefs, prefs AdminPrefsStore) {
+	if defaults.Events == "" {
+		defaults.Events = AdminEventsAll
+	}
+	s.admins = emails
+	s.adminDefaults = defaults
+	s.adminPrefs = prefs
+}
+
+// AdminPrefs returns own notification preferences of each admin, empty for admins using defaults
+func (s *Service) AdminPrefs(siteID string) (map[string]AdminPrefs, error) {
+	res := map[string]AdminPrefs{}
+	for _, email := range s.admins {
+		if s.adminPrefs == nil {
+			res[email] = AdminPrefs{}
+			continue
+		}
+		p, err := s.adminPrefs.Get(siteID, email)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't get notification preferences of %s", email)
+		}
+		res[email] = p
+	}
+	return res, nil
+}
+
+// AdminDefaults returns default notification preferences of admins
+func (s *Service) AdminDefaults() AdminPrefs {
+	return s.adminDefaults
+}
+
+// SetAdminPrefs sets notification preferences of the admin, empty prefs reset admin to defaults
+func (s *Service) SetAdminPrefs(siteID, email string, prefs AdminPrefs) error {
+	if s.adminPrefs == nil {
+		return errors.New("admin notification preferences disabled")
+	}
+	if !contains(email, s.admins) {
+		return errors.Errorf("%s is not an admin email", email)
+	}
+	if err := prefs.validate(); err != nil {
+		return err
+	}
+	return s.adminPrefs.Set(siteID, email, prefs)
+}
+
+// routeAdmins sets admins notified about the request by their preferences. Comment approved after being held
+// not sent to admins again, they were notified about it on hold.
+func (s *Service) routeAdmins(req *Request) {
+	if req.Approved {
+		req.skipShared = true
+		return
+	}
+	if s.admins == nil {
+		return
+	}
+	req.skipShared = !s.adminDefaults.Events.match(*req)
+	chats := map[string]bool{}
+	for _, email := range s.admins {
+		own := AdminPrefs{}
+		if s.adminPrefs != nil {
+			p, err := s.adminPrefs.Get(req.Comment.Locator.SiteID, email)
+			if err != nil {
+				log.Printf("[WARN] can't get notification preferences of %s, %v", email, err)
+			}
+			own = p
+		}
+		p := own.inherit(s.adminDefaults)
+		if !p.Events.match(*req) {
+			continue
+		}
+		if p.has(AdminDestEmail) {
+			req.AdminEmails = append(req.AdminEmails, email)
+		}
+		if p.has(AdminDestTelegram) && p.TelegramChat != "" && !chats[p.TelegramChat] {
+			chats[p.TelegramChat] = true
+			req.AdminChats = append(req.AdminChats, p.TelegramChat)
+		}
+	}
+}
+
+func contains(s string, list []string) bool {
+	for _, v := range list {
+		if strings.EqualFold(s, v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package notify
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// EngineAdminPrefs implements AdminPrefsStore with records of store engine, keyed by email of the admin
+type EngineAdminPrefs struct {
+	records engine.Records
+}
+
+// NewEngineAdminPrefs makes store of notification preferences of admins kept by eng
+func NewEngineAdminPrefs(eng engine.Interface) *EngineAdminPrefs {
+	return &EngineAdminPrefs{records: engine.Records{Engine: eng, Kind: engine.AdminPrefs}}
+}
+
+// Get returns preferences of the admin, empty if not set
+func (e *EngineAdminPrefs) Get(siteID, email string) (AdminPrefs, error) {
+	res := AdminPrefs{}
+	_, err := e.records.Get(siteID, normalizeEmail(email), &res)
+	return res, err
+}
+
+// Set stores preferences of the admin, empty preferences removed
+func (e *EngineAdminPrefs) Set(siteID, email string, prefs AdminPrefs) error {
+	if siteID == "" || email == "" {
+		return errors.Errorf("site and email required for admin prefs, %q, %q", siteID, email)
+	}
+	if prefs.Events == "" && len(prefs.Destinations) == 0 && prefs.TelegramChat == "" {
+		_, err := e.records.Delete(siteID, normalizeEmail(email))
+		return err
+	}
+	return e.records.Set(siteID, normalizeEmail(email), prefs)
+}
@@ -0,0 +1,31 @@
+package notify
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngineAdminPrefs(t *testing.T) {
+	b := NewEngineAdminPrefs(prepEngine(t))
+
+	p, err := b.Get("site1", "admin@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, AdminPrefs{}, p, "not set")
+
+	prefs := AdminPrefs{Events: AdminEventsPending, Destinations: []string{"telegram"}, TelegramChat: "123"}
+	require.NoError(t, b.Set("site1", "Admin@example.com", prefs))
+	p, err = b.Get("site1", "admin@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, prefs, p)
+	p, err = b.Get("site2", "admin@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, AdminPrefs{}, p, "other site")
+
+	require.NoError(t, b.Set("site1", "admin@example.com", AdminPrefs{}), "reset to defaults")
+	p, err = b.Get("site1", "admin@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, AdminPrefs{}, p)
+	assert.Error(t, b.Set("", "admin@example.com", prefs))
+}
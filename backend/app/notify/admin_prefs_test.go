@@ -0,0 +1,119 @@
+package notify
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestService_RouteAdmins(t *testing.T) {
+	prefs := &mockAdminPrefs{data: map[string]AdminPrefs{
+		"site!!pending@example.com": {Events: AdminEventsPending},
+		"site!!flagged@example.com": {Events: AdminEventsFlagged, Destinations: []string{"email", "telegram"}, TelegramChat: "111"},
+		"site!!tg@example.com":      {Destinations: []string{"telegram"}, TelegramChat: "222"},
+		"site!!none@example.com":    {Events: AdminEventsNone},
+	}}
+	s := NewService(nil, 1)
+	s.SetAdmins([]string{"all@example.com", "pending@example.com", "flagged@example.com", "tg@example.com", "none@example.com"},
+		AdminPrefs{Destinations: []string{"email"}}, prefs)
+	s.WatchKeywords(func(string) []string { return []string{"bitcoin"} })
+	locator := store.Locator{SiteID: "site"}
+
+	tbl := []struct {
+		name   string
+		req    Request
+		emails []string
+		chats  []string
+		skip   bool
+	}{
+		{name: "new comment", req: Request{Comment: store.Comment{Text: "hi", Locator: locator}},
+			emails: []string{"all@example.com"}, chats: []string{"222"}},
+		{name: "pending", req: Request{Comment: store.Comment{Text: "hi", Locator: locator, Pending: true}},
+			emails: []string{"all@example.com", "pending@example.com"}, chats: []string{"222"}},
+		{name: "flagged", req: Request{Comment: store.Comment{Text: "buy bitcoin", Locator: locator}},
+			emails: []string{"all@example.com", "flagged@example.com"}, chats: []string{"111", "222"}},
+		{name: "approved", req: Request{Comment: store.Comment{Text: "hi", Locator: locator}, Approved: true}, skip: true},
+		{name: "other site uses defaults", req: Request{Comment: store.Comment{Text: "hi", Locator: store.Locator{SiteID: "other"}}},
+			emails: []string{"all@example.com", "pending@example.com", "flagged@example.com", "tg@example.com", "none@example.com"}},
+	}
+	for _, tt := range tbl {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			req, ok := s.prepare(tt.req)
+			require.True(t, ok)
+			assert.Equal(t, tt.emails, req.AdminEmails)
+			assert.Equal(t, tt.chats, req.AdminChats)
+			assert.Equal(t, tt.skip, req.skipShared)
+		})
+	}
+
+	s.SetAdmins(nil, AdminPrefs{Events: AdminEventsPending}, nil)
+	req, _ := s.prepare(Request{Comment: store.Comment{Text: "hi"}})
+	assert.False(t, req.skipShared, "no admins routed, shared destinations get everything")
+}
+
+func TestService_PendingNotifiesAdminsOnly(t *testing.T) {
+	dataStore := &mockStore{data: map[string]store.Comment{}, emailData: map[string]string{"u1": "u1@example.com"}}
+	dataStore.data["p1"] = store.Comment{ID: "p1", User: store.User{ID: "u1"}}
+	s := NewService(dataStore, 1)
+	s.SetAdmins([]string{"admin@example.com"}, AdminPrefs{Events: AdminEventsPending, Destinations: []string{"email"}}, nil)
+
+	req, _ := s.prepare(Request{Comment: store.Comment{ID: "c1", ParentID: "p1", User: store.User{ID: "u2"}, Pending: true}})
+	assert.Empty(t, req.Emails, "users notified on approval")
+	assert.Equal(t, []string{"admin@example.com"}, req.AdminEmails)
+	assert.False(t, req.skipShared)
+
+	req, _ = s.prepare(Request{Comment: store.Comment{ID: "c1", ParentID: "p1", User: store.User{ID: "u2"}}, Approved: true})
+	assert.Equal(t, []string{"u1@example.com"}, req.Emails)
+	assert.Empty(t, req.AdminEmails, "admins notified on hold")
+	assert.True(t, req.skipShared)
+
+	req, _ = s.prepare(Request{Comment: store.Comment{ID: "c2", User: store.User{ID: "u2"}}})
+	assert.Empty(t, req.AdminEmails, "not pending")
+	assert.True(t, req.skipShared, "default events don't match")
+}
+
+func TestService_SetAdminPrefs(t *testing.T) {
+	s := NewService(nil, 1)
+	s.SetAdmins([]string{"admin@example.com"}, AdminPrefs{}, nil)
+	assert.EqualError(t, s.SetAdminPrefs("site", "admin@example.com", AdminPrefs{}), "admin notification preferences disabled")
+	assert.Equal(t, AdminPrefs{Events: AdminEventsAll}, s.AdminDefaults())
+
+	prefs := &mockAdminPrefs{data: map[string]AdminPrefs{}}
+	s.SetAdmins([]string{"admin@example.com"}, AdminPrefs{}, prefs)
+	assert.EqualError(t, s.SetAdminPrefs("site", "user@example.com", AdminPrefs{}), "user@example.com is not an admin email")
+	assert.EqualError(t, s.SetAdminPrefs("site", "admin@example.com", AdminPrefs{Events: "some"}), `unknown admin events "some"`)
+	assert.EqualError(t, s.SetAdminPrefs("site", "admin@example.com", AdminPrefs{Destinations: []string{"slack"}}),
+		`unknown admin destination "slack"`)
+	assert.EqualError(t, s.SetAdminPrefs("site", "admin@example.com", AdminPrefs{Destinations: []string{"telegram"}}),
+		"telegram chat required for telegram destination")
+
+	require.NoError(t, s.SetAdminPrefs("site", "Admin@example.com", AdminPrefs{Events: AdminEventsFlagged}))
+	res, err := s.AdminPrefs("site")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]AdminPrefs{"admin@example.com": {Events: AdminEventsFlagged}}, res)
+}
+
+func TestEmail_AdminRecipients(t *testing.T) {
+	e := Email{EmailParams: EmailParams{AdminEmails: []string{"shared@example.com"}}}
+	assert.Equal(t, []string{"shared@example.com", "admin@example.com"},
+		e.adminRecipients(Request{AdminEmails: []string{"admin@example.com", "shared@example.com"}}))
+	assert.Equal(t, []string{"admin@example.com"},
+		e.adminRecipients(Request{AdminEmails: []string{"admin@example.com"}, skipShared: true}))
+}
+
+type mockAdminPrefs struct {
+	data map[string]AdminPrefs
+}
+
+func (m *mockAdminPrefs) Get(siteID, email string) (AdminPrefs, error) {
+	return m.data[siteID+"!!"+normalizeEmail(email)], nil
+}
+
+func (m *mockAdminPrefs) Set(siteID, email string, prefs AdminPrefs) error {
+	m.data[siteID+"!!"+normalizeEmail(email)] = prefs
+	return nil
+}
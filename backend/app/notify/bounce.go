@@ -0,0 +1,152 @@
+package notify
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+)
+
+// BounceKind defines type of delivery problem reported by email provider
+type BounceKind string
+
+// enum of all bounce kinds recorded. Soft (temporary) bounces are not recorded.
+const (
+	BounceHard      BounceKind = "hard"
+	BounceComplaint BounceKind = "complaint"
+)
+
+// Bounce is a record about undeliverable address or spam complaint
+type Bounce struct {
+	SiteID    string     `json:"site"`
+	Email     string     `json:"email"`
+	Kind      BounceKind `json:"kind"`
+	Reason    string     `json:"reason,omitempty"`
+	Provider  string     `json:"provider,omitempty"`
+	Count     int        `json:"count"`
+	Timestamp time.Time  `json:"time"`
+}
+
+// BounceStore defines interface to keep bounced addresses. Addresses from this store are not used for sending.
+type BounceStore interface {
+	Add(b Bounce) (Bounce, error)
+	IsBounced(siteID, email string) bool
+	List(siteID string) ([]Bounce, error)
+	Delete(siteID, email string) error
+}
+
+// ParseBounces extracts hard bounces and complaints from webhook body of the given provider.
+// Supported providers are "ses" (SNS notification), "mailgun" (webhook event) and "generic",
+// the last one expects {"email": "addr", "kind": "hard|complaint", "reason": "text"}.
+// Soft bounces and non-bounce events ignored, i.e. result can be empty without an error.
+func ParseBounces(provider string, body []byte) ([]Bounce, error) {
+	switch provider {
+	case "ses":
+		return parseSESBounces(body)
+	case "mailgun":
+		return parseMailgunBounces(body)
+	case "generic", "":
+		b := Bounce{}
+		if err := json.Unmarshal(body, &b); err != nil {
+			return nil, errors.Wrap(err, "can't unmarshal bounce")
+		}
+		if b.Email == "" || (b.Kind != BounceHard && b.Kind != BounceComplaint) {
+			return nil, errors.Errorf("invalid bounce %+v", b)
+		}
+		return []Bounce{{Email: b.Email, Kind: b.Kind, Reason: b.Reason, Provider: "generic"}}, nil
+	}
+	return nil, errors.Errorf("unsupported bounce provider %q", provider)
+}
+
+// parseSESBounces handles Amazon SES notification delivered by SNS
+func parseSESBounces(body []byte) ([]Bounce, error) {
+	snsMsg := struct {
+		Type         string `json:"Type"`
+		Message      string `json:"Message"`
+		SubscribeURL string `json:"SubscribeURL"`
+	}{}
+	if err := json.Unmarshal(body, &snsMsg); err != nil {
+		return nil, errors.Wrap(err, "can't unmarshal sns message")
+	}
+
+	if snsMsg.Type == "SubscriptionConfirmation" {
+		// not confirmed automatically in order to prevent requests to arbitrary urls
+		log.Printf("[INFO] sns subscription confirmation requested, visit %s to confirm", snsMsg.SubscribeURL)
+		return nil, nil
+	}
+
+	sesMsg := struct {
+		NotificationType string `json:"notificationType"`
+		Bounce           struct {
+			BounceType        string `json:"bounceType"`
+			BouncedRecipients []struct {
+				EmailAddress   string `json:"emailAddress"`
+				DiagnosticCode string `json:"diagnosticCode"`
+			} `json:"bouncedRecipients"`
+		} `json:"bounce"`
+		Complaint struct {
+			ComplaintFeedbackType string `json:"complaintFeedbackType"`
+			ComplainedRecipients  []struct {
+				EmailAddress string `json:"emailAddress"`
+			} `json:"complainedRecipients"`
+		} `json:"complaint"`
+	}{}
+	if err := json.Unmarshal([]byte(snsMsg.Message), &sesMsg); err != nil {
+		return nil, errors.Wrap(err, "can't unmarshal ses notification")
+	}
+
+	res := []Bounce{}
+	switch sesMsg.NotificationType {
+	case "Bounce":
+		if sesMsg.Bounce.BounceType != "Permanent" {
+			return res, nil
+		}
+		for _, r := range sesMsg.Bounce.BouncedRecipients {
+			res = append(res, Bounce{Email: r.EmailAddress, Kind: BounceHard, Reason: r.DiagnosticCode, Provider: "ses"})
+		}
+	case "Complaint":
+		for _, r := range sesMsg.Complaint.ComplainedRecipients {
+			res = append(res, Bounce{Email: r.EmailAddress, Kind: BounceComplaint,
+				Reason: sesMsg.Complaint.ComplaintFeedbackType, Provider: "ses"})
+		}
+	}
+	return res, nil
+}
+
+// parseMailgunBounces handles Mailgun webhook event
+func parseMailgunBounces(body []byte) ([]Bounce, error) {
+	mgMsg := struct {
+		EventData struct {
+			Event          string `json:"event"`
+			Severity       string `json:"severity"`
+			Recipient      string `json:"recipient"`
+			DeliveryStatus struct {
+				Description string `json:"description"`
+				Message     string `json:"message"`
+			} `json:"delivery-status"`
+		} `json:"event-data"`
+	}{}
+	if err := json.Unmarshal(body, &mgMsg); err != nil {
+		return nil, errors.Wrap(err, "can't unmarshal mailgun event")
+	}
+
+	ev := mgMsg.EventData
+	switch {
+	case ev.Event == "failed" && ev.Severity == "permanent":
+		reason := ev.DeliveryStatus.Description
+		if reason == "" {
+			reason = ev.DeliveryStatus.Message
+		}
+		return []Bounce{{Email: ev.Recipient, Kind: BounceHard, Reason: reason, Provider: "mailgun"}}, nil
+	case ev.Event == "complained":
+		return []Bounce{{Email: ev.Recipient, Kind: BounceComplaint, Provider: "mailgun"}}, nil
+	}
+	return []Bounce{}, nil
+}
+
+// normalizeEmail makes email comparable regardless of case and surrounding spaces
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,69 @@
+package notify
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// EngineBounces implements BounceStore with records of store engine, keyed by email
+type EngineBounces struct {
+	records engine.Records
+}
+
+// NewEngineBounces makes store of bounced addresses kept by eng
+func NewEngineBounces(eng engine.Interface) *EngineBounces {
+	return &EngineBounces{records: engine.Records{Engine: eng, Kind: engine.Bounces}}
+}
+
+// Add records bounce. Repeated bounce for the same address increments count and updates kind and reason.
+func (e *EngineBounces) Add(bounce Bounce) (Bounce, error) {
+	bounce.Email = normalizeEmail(bounce.Email)
+	if bounce.SiteID == "" || bounce.Email == "" {
+		return Bounce{}, errors.Errorf("site and email required for bounce %+v", bounce)
+	}
+	if bounce.Timestamp.IsZero() {
+		bounce.Timestamp = time.Now()
+	}
+
+	prev := Bounce{}
+	if _, err := e.records.Get(bounce.SiteID, bounce.Email, &prev); err != nil {
+		return Bounce{}, errors.Wrapf(err, "can't get bounce for %s", bounce.Email)
+	}
+	bounce.Count = prev.Count + 1
+	return bounce, e.records.Set(bounce.SiteID, bounce.Email, bounce)
+}
+
+// IsBounced checks if email has a bounce record for the site
+func (e *EngineBounces) IsBounced(siteID, email string) bool {
+	found, err := e.records.Get(siteID, normalizeEmail(email), &Bounce{})
+	return err == nil && found
+}
+
+// List returns all bounce records for the site
+func (e *EngineBounces) List(siteID string) ([]Bounce, error) {
+	res := []Bounce{}
+	err := e.records.List(siteID, "", func(_ string, unmarshal func(v interface{}) error) error {
+		bounce := Bounce{}
+		if err := unmarshal(&bounce); err != nil {
+			return err
+		}
+		res = append(res, bounce)
+		return nil
+	})
+	return res, err
+}
+
+// Delete removes bounce record, i.e. allows sending to this email again
+func (e *EngineBounces) Delete(siteID, email string) error {
+	found, err := e.records.Delete(siteID, normalizeEmail(email))
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errors.Errorf("no bounce for %s!!%s", siteID, normalizeEmail(email))
+	}
+	return nil
+}
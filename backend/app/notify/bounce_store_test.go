@@ -0,0 +1,47 @@
+package notify
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngineBounces(t *testing.T) {
+	b := NewEngineBounces(prepEngine(t))
+
+	assert.False(t, b.IsBounced("site1", "a@example.com"))
+
+	ts := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	res, err := b.Add(Bounce{SiteID: "site1", Email: " A@Example.com", Kind: BounceHard, Reason: "550", Timestamp: ts})
+	require.NoError(t, err)
+	assert.Equal(t, Bounce{SiteID: "site1", Email: "a@example.com", Kind: BounceHard, Reason: "550", Count: 1, Timestamp: ts}, res)
+
+	res, err = b.Add(Bounce{SiteID: "site1", Email: "a@example.com", Kind: BounceComplaint})
+	require.NoError(t, err)
+	assert.Equal(t, 2, res.Count)
+	assert.Equal(t, BounceComplaint, res.Kind)
+	assert.False(t, res.Timestamp.IsZero())
+
+	_, err = b.Add(Bounce{SiteID: "site2", Email: "b@example.com", Kind: BounceHard})
+	require.NoError(t, err)
+	_, err = b.Add(Bounce{SiteID: "site1", Kind: BounceHard})
+	assert.Error(t, err, "email required")
+
+	assert.True(t, b.IsBounced("site1", "a@EXAMPLE.com"))
+	assert.False(t, b.IsBounced("site2", "a@example.com"))
+
+	list, err := b.List("site1")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(list))
+	assert.Equal(t, "a@example.com", list[0].Email)
+
+	require.NoError(t, b.Delete("site1", "a@example.com"))
+	assert.False(t, b.IsBounced("site1", "a@example.com"))
+	assert.Error(t, b.Delete("site1", "a@example.com"))
+
+	list, err = b.List("site1")
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(list))
+}
@@ -0,0 +1,84 @@
+package notify
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBounces_SES(t *testing.T) {
+	tbl := []struct {
+		name string
+		body string
+		res  []Bounce
+		err  string
+	}{
+		{name: "permanent bounce",
+			body: `{"Type":"Notification","Message":"{\"notificationType\":\"Bounce\",\"bounce\":{\"bounceType\":\"Permanent\",` +
+				`\"bouncedRecipients\":[{\"emailAddress\":\"a@example.com\",\"diagnosticCode\":\"550 no such user\"}]}}"}`,
+			res: []Bounce{{Email: "a@example.com", Kind: BounceHard, Reason: "550 no such user", Provider: "ses"}}},
+		{name: "transient bounce",
+			body: `{"Type":"Notification","Message":"{\"notificationType\":\"Bounce\",\"bounce\":{\"bounceType\":\"Transient\",` +
+				`\"bouncedRecipients\":[{\"emailAddress\":\"a@example.com\"}]}}"}`,
+			res: []Bounce{}},
+		{name: "complaint",
+			body: `{"Type":"Notification","Message":"{\"notificationType\":\"Complaint\",\"complaint\":{\"complaintFeedbackType\":\"abuse\",` +
+				`\"complainedRecipients\":[{\"emailAddress\":\"a@example.com\"},{\"emailAddress\":\"b@example.com\"}]}}"}`,
+			res: []Bounce{{Email: "a@example.com", Kind: BounceComplaint, Reason: "abuse", Provider: "ses"},
+				{Email: "b@example.com", Kind: BounceComplaint, Reason: "abuse", Provider: "ses"}}},
+		{name: "subscription confirmation",
+			body: `{"Type":"SubscriptionConfirmation","SubscribeURL":"https://sns.example.com/confirm"}`},
+		{name: "bad sns", body: `{bad`, err: "can't unmarshal sns message: invalid character 'b' looking for beginning of object key string"},
+		{name: "bad ses", body: `{"Type":"Notification","Message":"bad"}`,
+			err: "can't unmarshal ses notification: invalid character 'b' looking for beginning of value"},
+	}
+
+	for _, tt := range tbl {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := ParseBounces("ses", []byte(tt.body))
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.res, res)
+		})
+	}
+}
+
+func TestParseBounces_Mailgun(t *testing.T) {
+	res, err := ParseBounces("mailgun", []byte(`{"signature":{},"event-data":{"event":"failed","severity":"permanent",`+
+		`"recipient":"a@example.com","delivery-status":{"description":"mailbox not found"}}}`))
+	require.NoError(t, err)
+	assert.Equal(t, []Bounce{{Email: "a@example.com", Kind: BounceHard, Reason: "mailbox not found", Provider: "mailgun"}}, res)
+
+	res, err = ParseBounces("mailgun", []byte(`{"event-data":{"event":"failed","severity":"temporary","recipient":"a@example.com"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, []Bounce{}, res)
+
+	res, err = ParseBounces("mailgun", []byte(`{"event-data":{"event":"complained","recipient":"a@example.com"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, []Bounce{{Email: "a@example.com", Kind: BounceComplaint, Provider: "mailgun"}}, res)
+
+	_, err = ParseBounces("mailgun", []byte(`bad`))
+	assert.Error(t, err)
+}
+
+func TestParseBounces_Generic(t *testing.T) {
+	res, err := ParseBounces("generic", []byte(`{"email":"a@example.com","kind":"hard","reason":"bad mailbox"}`))
+	require.NoError(t, err)
+	assert.Equal(t, []Bounce{{Email: "a@example.com", Kind: BounceHard, Reason: "bad mailbox", Provider: "generic"}}, res)
+
+	res, err = ParseBounces("", []byte(`{"email":"a@example.com","kind":"complaint"}`))
+	require.NoError(t, err)
+	assert.Equal(t, []Bounce{{Email: "a@example.com", Kind: BounceComplaint, Provider: "generic"}}, res)
+
+	_, err = ParseBounces("generic", []byte(`{"email":"a@example.com","kind":"soft"}`))
+	assert.Error(t, err)
+	_, err = ParseBounces("generic", []byte(`bad`))
+	assert.Error(t, err)
+	_, err = ParseBounces("unknown", []byte(`{}`))
+	assert.EqualError(t, err, `unsupported bounce provider "unknown"`)
+}
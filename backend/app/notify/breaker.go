@@ -0,0 +1,130 @@
+package notify
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+)
+
+// BreakerParams defines circuit breaker of failing destinations
+type BreakerParams struct {
+	Failures int           // consecutive failures opening the circuit, breaker disabled if 0
+	Cooldown time.Duration // time sending skipped once the circuit opened, one trial sent after it
+}
+
+// ErrCircuitOpen returned for notifications skipped while destination failing
+var ErrCircuitOpen = errors.New("circuit open, destination failing")
+
+// breaker skips sending to destination after repeated failures. Once cooldown passed, single trial message
+// let through (half-open state), its success closes the circuit and failure opens it for another cooldown.
+type breaker struct {
+	name    string // destination name reported to log and metrics
+	params  BreakerParams
+	metrics Metrics
+	now     func() time.Time
+
+	mu       sync.Mutex
+	failures int
+	open     bool
+	openedAt time.Time
+	trial    bool // trial message in flight, half-open state
+}
+
+// breakerUser implemented by destinations checking breaker by themselves, i.e. sending later than called
+type breakerUser interface {
+	setBreaker(b *breaker)
+}
+
+// SetBreaker sets circuit breaker for each destination, passed to destinations sending later than called.
+// Should be called before submitting any requests.
+func (s *Service) SetBreaker(params BreakerParams) {
+	if params.Failures <= 0 {
+		return
+	}
+	s.breakers = map[Destination]*breaker{}
+	for _, d := range s.destinations {
+		b := &breaker{name: destinationName(d), params: params, metrics: s.metrics, now: time.Now}
+		if bu, ok := d.(breakerUser); ok {
+			bu.setBreaker(b)
+			continue
+		}
+		s.breakers[d] = b
+	}
+}
+
+// sendWithBreaker sends with fn unless circuit of the destination open
+func (s *Service) sendWithBreaker(d Destination, fn func() error) error {
+	return s.breakers[d].do(fn)
+}
+
+// do calls fn if circuit closed or for the trial after cooldown, reports the result. Nil breaker calls fn.
+func (b *breaker) do(fn func() error) error {
+	if b == nil {
+		return fn()
+	}
+	if !b.allow() {
+		return ErrCircuitOpen
+	}
+	err := fn()
+	b.report(err)
+	return err
+}
+
+// allow checks if message can be sent, marks trial message in half-open state
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.open {
+		return true
+	}
+	if b.trial || b.now().Sub(b.openedAt) < b.params.Cooldown {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+// report counts result of sending, opens circuit on too many failures and closes it on success
+func (b *breaker) report(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+	if err == nil {
+		b.failures = 0
+		if b.open {
+			b.open = false
+			log.Printf("[INFO] circuit of %s closed, destination recovered", b.name)
+			b.changed()
+		}
+		return
+	}
+
+	b.failures++
+	if b.open { // failed trial
+		b.openedAt = b.now()
+		log.Printf("[WARN] circuit of %s still open, trial failed, %v", b.name, err)
+		return
+	}
+	if b.failures >= b.params.Failures {
+		b.open, b.openedAt = true, b.now()
+		log.Printf("[WARN] circuit of %s opened after %d failures, sending skipped for %v, %v", b.name, b.failures,
+			b.params.Cooldown, err)
+		b.changed()
+	}
+}
+
+// setMetrics sets collector of circuit state changes
+func (b *breaker) setMetrics(m Metrics) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.metrics = m
+}
+
+// changed reports state of the circuit to metrics, called under lock
+func (b *breaker) changed() {
+	if b.metrics != nil {
+		b.metrics.BreakerChanged(b.name, b.open)
+	}
+}
@@ -0,0 +1,107 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	m := &mockMetrics{}
+	b := &breaker{name: "email", params: BreakerParams{Failures: 2, Cooldown: time.Minute}, metrics: m,
+		now: func() time.Time { return now }}
+	calls := 0
+	fail := func() error { calls++; return errors.New("failed") }
+	ok := func() error { calls++; return nil }
+
+	assert.EqualError(t, b.do(fail), "failed")
+	assert.NoError(t, b.do(ok), "failures reset")
+	assert.Error(t, b.do(fail))
+	assert.EqualError(t, b.do(fail), "failed")
+	assert.Equal(t, 4, calls)
+	assert.True(t, m.breakers["email"], "opened")
+
+	assert.Equal(t, ErrCircuitOpen, b.do(ok))
+	assert.Equal(t, 4, calls, "skipped")
+
+	now = now.Add(time.Minute)
+	assert.True(t, b.allow(), "trial after cooldown")
+	assert.False(t, b.allow(), "single trial")
+	b.report(errors.New("failed"))
+	assert.Equal(t, ErrCircuitOpen, b.do(ok), "opened again by failed trial")
+
+	now = now.Add(time.Minute)
+	assert.NoError(t, b.do(ok))
+	assert.Equal(t, 5, calls)
+	assert.False(t, m.breakers["email"], "closed")
+	assert.NoError(t, b.do(ok))
+
+	var nb *breaker
+	assert.EqualError(t, nb.do(fail), "failed", "nil breaker calls fn")
+}
+
+func TestService_Breaker(t *testing.T) {
+	d1, d2 := &failingDest{}, &failingDest{}
+	th := NewThrottled(d2, ThrottleParams{PerMinute: 100})
+	defer th.Close()
+	s := NewService(nil, 10, d1, th, &MockDest{id: 1})
+	s.SetBreaker(BreakerParams{Failures: 2, Cooldown: time.Hour})
+	m := &mockMetrics{}
+	s.SetMetrics(m)
+
+	for i := 0; i < 4; i++ {
+		s.Submit(Request{Comment: store.Comment{ID: "c1"}})
+	}
+	s.SubmitVerification(VerificationRequest{SiteID: "remark", User: "u1", Email: "u1@example.com"})
+	time.Sleep(200 * time.Millisecond)
+	s.Close()
+	assert.Equal(t, 2, d1.count(), "skipped after 2 failures")
+	assert.Equal(t, 2, d2.count(), "throttled skipped on sending")
+	assert.True(t, m.breakers["failing"])
+	assert.Equal(t, 8, m.get("failing"), "skipped counted as failed")
+	assert.Equal(t, 4, m.get("mock"), "not affected")
+}
+
+func TestService_BreakerDisabled(t *testing.T) {
+	d := &failingDest{}
+	s := NewService(nil, 10, d)
+	s.SetBreaker(BreakerParams{})
+	for i := 0; i < 3; i++ {
+		s.Submit(Request{Comment: store.Comment{ID: "c1"}})
+	}
+	time.Sleep(100 * time.Millisecond)
+	s.Close()
+	assert.Equal(t, 3, d.count())
+}
+
+// failingDest fails to send anything and counts attempts
+type failingDest struct {
+	lock  sync.Mutex
+	calls int
+}
+
+func (f *failingDest) Send(context.Context, Request) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.calls++
+	return errors.New("failed")
+}
+
+func (f *failingDest) SendVerification(context.Context, VerificationRequest) error {
+	return f.Send(context.Background(), Request{})
+}
+
+func (f *failingDest) String() string { return "failing destination" }
+
+func (f *failingDest) count() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.calls
+}
@@ -0,0 +1,86 @@
+package notify
+
+import (
+	"sync/atomic"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+)
+
+// pendingRequest is notification held for coalescing window
+type pendingRequest struct {
+	req   Request
+	timer *time.Timer
+}
+
+// SetCoalesce sets window notifications about new comments held for. Notifications about the same comment
+// submitted within the window, like edits of just posted reply, replaced by the latest one, sent once
+// the window passed. Should be called before submitting any requests.
+func (s *Service) SetCoalesce(window time.Duration) {
+	s.coalesceWindow = window
+	s.pending = map[string]*pendingRequest{}
+}
+
+// coalesce holds notification for coalescing window or replaces the one held for the same comment.
+// Edited comment updates held notification only, deletion by admin drops it. Moderation notifications
+// never held. Returns false if not held and should be sent now.
+func (s *Service) coalesce(req Request) bool {
+	if s.coalesceWindow <= 0 || atomic.LoadUint32(&s.closed) != 0 {
+		return false
+	}
+	key := req.Comment.Locator.SiteID + "!!" + req.Comment.ID
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	if req.Moderation != "" {
+		if p, ok := s.pending[key]; ok && req.Moderation == ModerationDeleted {
+			p.timer.Stop()
+			delete(s.pending, key)
+			log.Printf("[DEBUG] notification for comment %s dropped, deleted by admin", req.Comment.ID)
+		}
+		return false
+	}
+	if p, ok := s.pending[key]; ok {
+		if req.Edited {
+			p.req.Comment = req.Comment
+		} else {
+			p.req = req
+		}
+		log.Printf("[DEBUG] notification for comment %s coalesced", req.Comment.ID)
+		return true
+	}
+	if req.Edited {
+		return true // notification already sent, edits not notified
+	}
+	s.pending[key] = &pendingRequest{req: req, timer: time.AfterFunc(s.coalesceWindow, func() { s.release(key) })}
+	return true
+}
+
+// release sends held notification once coalescing window passed, dropped if the comment deleted meanwhile
+func (s *Service) release(key string) {
+	s.pendingMu.Lock()
+	p, ok := s.pending[key]
+	delete(s.pending, key)
+	s.pendingMu.Unlock()
+	if !ok || p.req.Comment.Deleted {
+		return
+	}
+	if err := s.push(p.req); err == ErrQueueFull {
+		log.Printf("[WARN] can't send notification to queue, %+v", p.req.Comment)
+	}
+}
+
+// flushPending sends all held notifications right away, called on close under the lock
+func (s *Service) flushPending() {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	for key, p := range s.pending {
+		p.timer.Stop()
+		delete(s.pending, key)
+		if p.req.Comment.Deleted {
+			continue
+		}
+		if err := s.enqueue(p.req); err == ErrQueueFull {
+			log.Printf("[WARN] can't send notification to queue, %+v", p.req.Comment)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package notify
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestService_Coalesce(t *testing.T) {
+	d := &MockDest{id: 1}
+	s := NewService(nil, 10, d)
+	s.SetCoalesce(100 * time.Millisecond)
+	loc := store.Locator{SiteID: "remark", URL: "http://example.com/post"}
+
+	s.Submit(Request{Comment: store.Comment{ID: "c1", Locator: loc, Text: "v1"}})
+	s.Submit(Request{Comment: store.Comment{ID: "c2", Locator: loc, Text: "other"}})
+	s.Submit(Request{Comment: store.Comment{ID: "c1", Locator: loc, Text: "v2"}, Edited: true})
+	s.Submit(Request{Comment: store.Comment{ID: "c1", Locator: loc, Text: "v3"}, Edited: true})
+	s.Submit(Request{Comment: store.Comment{ID: "c3", Locator: loc, Text: "edited, not held"}, Edited: true})
+	s.Submit(Request{Comment: store.Comment{ID: "c4", Locator: loc, Text: "deleted"}})
+	s.Submit(Request{Comment: store.Comment{ID: "c4", Locator: loc, Deleted: true}, Edited: true})
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 0, len(d.Get()), "held for the window")
+
+	require.Eventually(t, func() bool { return len(d.Get()) == 2 }, time.Second, 10*time.Millisecond)
+	texts := map[string]string{}
+	for _, r := range d.Get() {
+		texts[r.Comment.ID] = r.Comment.Text
+	}
+	assert.Equal(t, map[string]string{"c1": "v3", "c2": "other"}, texts, "latest version sent once")
+
+	s.Submit(Request{Comment: store.Comment{ID: "c1", Locator: loc, Text: "v4"}, Edited: true})
+	s.Submit(Request{Comment: store.Comment{ID: "c1", Locator: loc}, Moderation: ModerationDeleted})
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, 2, len(d.Get()), "edit after the window not notified, moderation not held")
+
+	s.Submit(Request{Comment: store.Comment{ID: "c6", Locator: loc, Text: "deleted by admin"}})
+	s.Submit(Request{Comment: store.Comment{ID: "c6", Locator: loc, Text: "deleted by admin"}, Moderation: ModerationDeleted})
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, 2, len(d.Get()), "held notification dropped on deletion by admin")
+
+	s.Submit(Request{Comment: store.Comment{ID: "c5", Locator: loc, Text: "on close"}})
+	s.Close()
+	require.Equal(t, 3, len(d.Get()), "held notification sent on close")
+	assert.Equal(t, "c5", d.Get()[2].Comment.ID)
+}
+
+func TestService_CoalesceDisabled(t *testing.T) {
+	d := &MockDest{id: 1}
+	s := NewService(nil, 10, d)
+	loc := store.Locator{SiteID: "remark", URL: "http://example.com/post"}
+	s.Submit(Request{Comment: store.Comment{ID: "c1", Locator: loc, Text: "v1"}})
+	s.Submit(Request{Comment: store.Comment{ID: "c1", Locator: loc, Text: "v2"}, Edited: true})
+	s.Submit(Request{Comment: store.Comment{ID: "c1", Locator: loc, Text: "v3"}})
+	s.Close()
+	require.Equal(t, 2, len(d.Get()), "edit not notified")
+	assert.Equal(t, "v1", d.Get()[0].Comment.Text)
+	assert.Equal(t, "v3", d.Get()[1].Comment.Text)
+}
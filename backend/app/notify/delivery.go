@@ -0,0 +1,19 @@
+package notify
+
+// DeliveryLog defines interface to keep record of comment notifications sent by email. Recipients
+// already notified about the comment are skipped, so notifications can be sent again safely, i.e. after outage.
+type DeliveryLog interface {
+	Add(siteID, commentID, email string) error
+	IsDelivered(siteID, commentID, email string) bool
+}
+
+// deliveryLogger implemented by destinations keeping delivery log, the only ones getting re-sent notifications
+type deliveryLogger interface {
+	logsDeliveries() bool
+}
+
+// logsDeliveries checks if destination keeps delivery log
+func logsDeliveries(d Destination) bool {
+	dl, ok := d.(deliveryLogger)
+	return ok && dl.logsDeliveries()
+}
@@ -0,0 +1,39 @@
+package notify
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// EngineDeliveries implements DeliveryLog with records of store engine. Records are keyed by commentID!!email,
+// with time of delivery as a value.
+type EngineDeliveries struct {
+	records engine.Records
+}
+
+// NewEngineDeliveries makes delivery log kept by eng
+func NewEngineDeliveries(eng engine.Interface) *EngineDeliveries {
+	return &EngineDeliveries{records: engine.Records{Engine: eng, Kind: engine.Deliveries}}
+}
+
+// Add records delivery of the comment notification to the email
+func (e *EngineDeliveries) Add(siteID, commentID, email string) error {
+	if siteID == "" || commentID == "" || email == "" {
+		return errors.Errorf("site, comment and email required for delivery, %q %q %q", siteID, commentID, email)
+	}
+	return e.records.Set(siteID, deliveryKey(commentID, email), time.Now().UTC().Format(time.RFC3339))
+}
+
+// IsDelivered checks if notification about the comment was sent to the email
+func (e *EngineDeliveries) IsDelivered(siteID, commentID, email string) bool {
+	var ts string
+	found, err := e.records.Get(siteID, deliveryKey(commentID, email), &ts)
+	return err == nil && found
+}
+
+func deliveryKey(commentID, email string) string {
+	return commentID + "!!" + normalizeEmail(email)
+}
@@ -0,0 +1,22 @@
+package notify
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngineDeliveries(t *testing.T) {
+	d := NewEngineDeliveries(prepEngine(t))
+
+	assert.False(t, d.IsDelivered("site1", "c1", "a@example.com"))
+	require.NoError(t, d.Add("site1", "c1", " A@Example.com"))
+	assert.True(t, d.IsDelivered("site1", "c1", "a@example.com"))
+	assert.False(t, d.IsDelivered("site1", "c2", "a@example.com"))
+	assert.False(t, d.IsDelivered("site2", "c1", "a@example.com"))
+	assert.Error(t, d.Add("site1", "", "a@example.com"))
+	assert.Error(t, d.Add("site1", "c1", ""))
+	assert.True(t, d.IsDelivered("site1", "c1", "A@example.com"))
+	assert.False(t, d.IsDelivered("bad", "c1", "a@example.com"), "unknown site")
+}
@@ -3,14 +3,25 @@ package notify
 import (
 	"bytes"
 	"context"
+	"crypto/sha1" //nolint:gosec // used for message ids only
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"html/template"
 	"io"
+	"io/ioutil"
 	"mime"
 	"mime/quotedprintable"
 	"net"
+	"net/mail"
 	"net/smtp"
-	"text/template"
+	"net/textproto"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	texttemplate "text/template"
 	"time"
 
 	log "github.com/go-pkgz/lgr"
@@ -18,7 +29,10 @@ import (
 	"github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
 
+	"github.com/umputun/remark42/backend/app/logging"
+	"github.com/umputun/remark42/backend/app/store"
 	"github.com/umputun/remark42/backend/app/templates"
+	"github.com/umputun/remark42/backend/app/tracing"
 )
 
 // EmailParams contain settings for email notifications
@@ -27,11 +41,32 @@ type EmailParams struct {
 	AdminEmails              []string // administrator emails to send copy of comment notification to
 	MsgTemplatePath          string   // path to request message template
 	VerificationSubject      string   // verification message sub
+	SubjectTemplate          string   // optional, text/template of notification subject with subjectTmplData, built-in subject if empty
+	ActionMarkup             bool     // add schema.org ViewAction markup, shown by Gmail as "View comment" button
 	VerificationTemplatePath string   // path to verification template
 	SubscribeURL             string   // full subscribe handler URL
 	UnsubscribeURL           string   // full unsubscribe handler URL
-
-	TokenGenFn func(userID, email, site string) (string, error) // Unsubscribe token generation function
+	OneClickUnsubscribeURL   string   // full one-click (RFC 8058) unsubscribe handler URL, used in List-Unsubscribe header if set
+	VoteURL                  string   // full email vote handler URL, vote links not added if empty
+	ModerationURL            string   // full email moderation handler URL, moderation links for admins not added if empty
+	ReloadTemplates          bool     // re-read templates changed on disk before use, the last good template kept on errors
+	CodeStyle                string   // optional, chroma style inlined to highlighted code of comments, as emails have no css of the site
+
+	// optional, additional headers of each message, like X-Entity-Ref-ID, Reply-To, Return-Path or X-Mailer.
+	// Reply-To overridden by reply address of reply notification.
+	Headers map[string]string
+
+	TokenGenFn     func(userID, email, site string) (string, error)              // Unsubscribe token generation function
+	VoteTokenGenFn func(userID, site, postURL, commentID string) (string, error) // Vote token generation function
+	Bounces        BounceStore                                                   // optional, emails with recorded bounces are skipped
+	Deliveries     DeliveryLog                                                   // optional, recipients already notified about the comment are skipped
+	Sender         EmailSender                                                   // optional, api-based sender used instead of SMTP
+
+	// optional, makes Reply-To address of reply notification, reply sent to it posted as a comment
+	ReplyAddressFn func(recipient store.User, email string, comment store.Comment) (string, error)
+
+	// optional, makes short-lived token of moderation link for the admin, action is approve, delete or block
+	ModerationTokenGenFn func(email, site, postURL, commentID, action string) (string, error)
 }
 
 // SMTPParams contain settings for smtp server connection
@@ -42,6 +77,11 @@ type SMTPParams struct {
 	Username string        // user name
 	Password string        // password
 	TimeOut  time.Duration // TCP connection timeout
+	Helo     string        // optional, hostname sent in HELO/EHLO (or LHLO), "localhost" by default
+	Socket   string        // optional, path to unix socket of local MTA used instead of host and port
+	LMTP     bool          // deliver with LMTP instead of SMTP, i.e. to co-located MTA
+
+	Fallback []SMTPParams // optional, servers tried in order if sending with the primary one failed
 }
 
 // Email implements notify.Destination for email
@@ -50,8 +90,16 @@ type Email struct {
 	SMTPParams
 
 	smtp       smtpClientCreator
+	smtpMu     sync.RWMutex       // guards SMTPParams switched at runtime
 	msgTmpl    *template.Template // parsed request message template
 	verifyTmpl *template.Template // parsed verification message template
+	metrics    Metrics
+
+	tmplMu        sync.Mutex // guards templates in reload mode
+	msgTmplMod    time.Time  // modification time of the loaded message template file, reload mode only
+	verifyTmplMod time.Time  // modification time of the loaded verification template file, reload mode only
+
+	subjectTmpl *texttemplate.Template // parsed subject template, optional
 }
 
 // default email client implementation
@@ -82,18 +130,38 @@ type emailMessage struct {
 type msgTmplData struct {
 	UserName          string
 	UserPicture       string
-	CommentText       string
+	CommentText       template.HTML // sanitized on comment creation, rendered as is
 	CommentLink       string
 	CommentDate       time.Time
 	ParentUserName    string
 	ParentUserPicture string
-	ParentCommentText string
+	ParentCommentText template.HTML // sanitized on comment creation, rendered as is
 	ParentCommentLink string
 	ParentCommentDate time.Time
 	PostTitle         string
 	Email             string
 	UnsubscribeLink   string
+	VoteLink          string
+	ApproveLink       string // moderation links for admin, approve set for comment held for moderation only
+	DeleteLink        string
+	BlockLink         string
 	ForAdmin          bool
+	Following         bool // sent to the follower of the comment author
+	Keywords          []string
+	Moderation        string
+	Reason            string
+}
+
+// subjectTmplData store data for notification subject template execution
+type subjectTmplData struct {
+	PostTitle  string
+	UserName   string // author of the comment
+	ForAdmin   bool
+	Following  bool     // sent to the follower of the comment author
+	Pending    bool     // comment awaits moderation
+	Keywords   []string // watched keywords found in the comment, admin alert
+	Moderation string   // moderation decision about the comment, sent to its author
+	Default    string   // built-in subject
 }
 
 // verifyTmplData store data for verification message template execution
@@ -103,28 +171,46 @@ type verifyTmplData struct {
 	Email        string
 	Site         string
 	SubscribeURL string
+	DeletionURL  string
+	NewEmail     string
 }
 
 const (
 	defaultVerificationSubject           = "Email verification"
+	deletionSubject                      = "Account deletion"
+	emailChangeSubject                   = "Email address changed"
 	defaultEmailTimeout                  = 10 * time.Second
 	defaultEmailTemplatePath             = "email_reply.html.tmpl"
 	defaultEmailVerificationTemplatePath = "email_confirmation_subscription.html.tmpl"
 )
 
+// moderationSubjects defines email subject for each moderation event
+var moderationSubjects = map[ModerationEvent]string{
+	ModerationApproved: "Your comment was approved",
+	ModerationRejected: "Your comment was rejected",
+	ModerationDeleted:  "Your comment was removed",
+}
+
 // NewEmail makes new Email object, returns error in case of e.MsgTemplate or e.VerificationTemplate parsing error
 func NewEmail(emailParams EmailParams, smtpParams SMTPParams) (*Email, error) {
 	// set up Email emailParams
 	res := Email{EmailParams: emailParams}
 	res.smtp = &emailClient{}
-	res.SMTPParams = smtpParams
-	if res.TimeOut <= 0 {
-		res.TimeOut = defaultEmailTimeout
-	}
+	res.SMTPParams = smtpParams.withDefaults()
 
 	if res.VerificationSubject == "" {
 		res.VerificationSubject = defaultVerificationSubject
 	}
+	if err := validateHeaders(res.Headers); err != nil {
+		return nil, err
+	}
+	if res.SubjectTemplate != "" {
+		tmpl, err := texttemplate.New("subject").Funcs(texttemplate.FuncMap(emailFuncs())).Parse(res.SubjectTemplate)
+		if err != nil {
+			return nil, errors.Wrap(err, "can't parse subject template")
+		}
+		res.subjectTmpl = tmpl
+	}
 
 	// initialize templates
 	err := res.setTemplates()
@@ -132,12 +218,67 @@ func NewEmail(emailParams EmailParams, smtpParams SMTPParams) (*Email, error) {
 		return nil, errors.Wrap(err, "can't set templates")
 	}
 
-	log.Printf("[DEBUG] Create new email notifier for server %s with user %s, timeout=%s",
-		res.Host, res.Username, res.TimeOut)
+	if res.Sender != nil {
+		log.Printf("[DEBUG] Create new email notifier with %s", res.Sender)
+		return &res, nil
+	}
+	log.Printf("[DEBUG] Create new email notifier for server %s with user %s, timeout=%s, %d fallback servers",
+		res.Host, res.Username, res.TimeOut, len(res.Fallback))
 
 	return &res, nil
 }
 
+// withDefaults returns params with default timeout set, fallback servers inherit timeout and have no own fallbacks
+func (p SMTPParams) withDefaults() SMTPParams {
+	if p.TimeOut <= 0 {
+		p.TimeOut = defaultEmailTimeout
+	}
+	fallback := p.Fallback
+	p.Fallback = nil
+	for _, f := range fallback {
+		f.Fallback = nil
+		if f.TimeOut <= 0 {
+			f.TimeOut = p.TimeOut
+		}
+		p.Fallback = append(p.Fallback, f)
+	}
+	return p
+}
+
+// SMTP returns current params of SMTP servers
+func (e *Email) SMTP() SMTPParams {
+	e.smtpMu.RLock()
+	defer e.smtpMu.RUnlock()
+	return e.SMTPParams
+}
+
+// TestSMTP checks connection and auth with the primary server of params, dry-run of SetSMTP. Nothing sent.
+func (e *Email) TestSMTP(params SMTPParams) error {
+	if e.Sender != nil {
+		return errors.Errorf("email sent with %s, smtp not used", e.Sender)
+	}
+	params = params.withDefaults()
+	client, err := e.smtp.Create(params)
+	if err != nil {
+		return errors.Wrapf(err, "can't connect to smtp server %s:%d", params.Host, params.Port)
+	}
+	if err = client.Quit(); err != nil {
+		_ = client.Close()
+		return errors.Wrapf(err, "can't quit smtp server %s:%d", params.Host, params.Port)
+	}
+	return nil
+}
+
+// SetSMTP switches SMTP servers, messages being sent finished with previous ones. Thread safe.
+func (e *Email) SetSMTP(params SMTPParams) {
+	params = params.withDefaults()
+	e.smtpMu.Lock()
+	e.SMTPParams = params
+	e.smtpMu.Unlock()
+	log.Printf("[INFO] email notifications switched to smtp server %s:%d with user %s, %d fallback servers",
+		params.Host, params.Port, params.Username, len(params.Fallback))
+}
+
 func (e *Email) setTemplates() error {
 	var err error
 	var msgTmplFile, verifyTmplFile []byte
@@ -151,23 +292,41 @@ func (e *Email) setTemplates() error {
 		e.MsgTemplatePath = defaultEmailTemplatePath
 	}
 
-	if msgTmplFile, err = fs.ReadFile(e.MsgTemplatePath); err != nil {
+	// custom templates read from disk, embedded ones used only for default paths
+	readFile := func(path, defaultPath string) ([]byte, error) {
+		if path != defaultPath {
+			return ioutil.ReadFile(path) //nolint:gosec // path of the template set by admin
+		}
+		return fs.ReadFile(path)
+	}
+
+	if msgTmplFile, err = readFile(e.MsgTemplatePath, defaultEmailTemplatePath); err != nil {
 		return errors.Wrapf(err, "can't read message template")
 	}
-	if verifyTmplFile, err = fs.ReadFile(e.VerificationTemplatePath); err != nil {
+	if verifyTmplFile, err = readFile(e.VerificationTemplatePath, defaultEmailVerificationTemplatePath); err != nil {
 		return errors.Wrapf(err, "can't read verification template")
 	}
-	if e.msgTmpl, err = template.New("msgTmpl").Parse(string(msgTmplFile)); err != nil {
+	if e.msgTmpl, err = template.New("msgTmpl").Funcs(emailFuncs()).Parse(string(msgTmplFile)); err != nil {
 		return errors.Wrapf(err, "can't parse message template")
 	}
-	if e.verifyTmpl, err = template.New("verifyTmpl").Parse(string(verifyTmplFile)); err != nil {
+	if e.verifyTmpl, err = template.New("verifyTmpl").Funcs(emailFuncs()).Parse(string(verifyTmplFile)); err != nil {
 		return errors.Wrapf(err, "can't parse verification template")
 	}
 
+	if e.ReloadTemplates {
+		if fi, e1 := os.Stat(e.MsgTemplatePath); e1 == nil {
+			e.msgTmplMod = fi.ModTime()
+		}
+		if fi, e1 := os.Stat(e.VerificationTemplatePath); e1 == nil {
+			e.verifyTmplMod = fi.ModTime()
+		}
+		log.Printf("[INFO] email templates %s and %s reloaded on change", e.MsgTemplatePath, e.VerificationTemplatePath)
+	}
+
 	return nil
 }
 
-// Send email about comment reply to Request.Emails and Email.AdminEmails
+// Send email about comment reply to Request.Emails, Request.AdminEmails and Email.AdminEmails
 // if they're set.
 // Thread safe
 func (e *Email) Send(ctx context.Context, req Request) error {
@@ -179,30 +338,121 @@ func (e *Email) Send(ctx context.Context, req Request) error {
 
 	result := new(multierror.Error)
 
+	// moderation events sent to the comment author only
+	if req.Moderation != "" {
+		for _, email := range req.Emails {
+			if e.isBounced(req.Comment.Locator.SiteID, email) {
+				continue
+			}
+			err := e.buildAndSendMessage(ctx, req, email, false)
+			result = multierror.Append(errors.Wrapf(err, "problem sending moderation email notification to %q", email))
+		}
+		return result.ErrorOrNil()
+	}
+
 	for _, email := range req.Emails {
-		err := e.buildAndSendMessage(ctx, req, email, false)
+		err := e.sendNotification(ctx, req, email, false)
 		result = multierror.Append(errors.Wrapf(err, "problem sending user email notification to %q", email))
 	}
 
-	for _, email := range e.AdminEmails {
-		err := e.buildAndSendMessage(ctx, req, email, true)
+	for _, f := range req.Followers {
+		followerReq := req
+		followerReq.follower = &Follower{UserID: f.UserID, Email: f.Email}
+		err := e.sendNotification(ctx, followerReq, f.Email, false)
+		result = multierror.Append(errors.Wrapf(err, "problem sending follower email notification to %q", f.Email))
+	}
+
+	for _, email := range e.adminRecipients(req) {
+		err := e.sendNotification(ctx, req, email, true)
 		result = multierror.Append(errors.Wrapf(err, "problem sending admin email notification to %q", email))
 	}
 
 	return result.ErrorOrNil()
 }
 
+// sendNotification sends comment notification to the email, except bounced ones and already notified according
+// to delivery log. Successful delivery recorded to the log.
+func (e *Email) sendNotification(ctx context.Context, req Request, email string, forAdmin bool) error {
+	siteID := req.Comment.Locator.SiteID
+	if e.isBounced(siteID, email) {
+		return nil
+	}
+	if e.Deliveries != nil && e.Deliveries.IsDelivered(siteID, req.Comment.ID, email) {
+		log.Printf("[DEBUG] skip sending to %s, already notified about comment %s", email, req.Comment.ID)
+		return nil
+	}
+	if err := e.buildAndSendMessage(ctx, req, email, forAdmin); err != nil {
+		return err
+	}
+	if e.Deliveries != nil {
+		if err := e.Deliveries.Add(siteID, req.Comment.ID, email); err != nil {
+			log.Printf("[WARN] can't record delivery of comment %s to %s, %v", req.Comment.ID, email, err)
+		}
+	}
+	return nil
+}
+
+// logsDeliveries implements deliveryLogger, email with delivery log gets re-sent notifications
+func (e *Email) logsDeliveries() bool {
+	return e.Deliveries != nil
+}
+
+// setMetrics implements metricsReporter to report duration of sending
+func (e *Email) setMetrics(m Metrics) {
+	e.metrics = m
+}
+
+// recipients returns all emails getting notification about the comment, used by Throttled
+func (e *Email) recipients(req Request) []string {
+	if req.Moderation != "" {
+		return append([]string{}, req.Emails...)
+	}
+	res := append([]string{}, req.Emails...)
+	for _, f := range req.Followers {
+		res = append(res, f.Email)
+	}
+	return append(res, e.adminRecipients(req)...)
+}
+
+// adminRecipients returns emails of admins notified about the request, shared admin emails skipped
+// if request doesn't match default admin events
+func (e *Email) adminRecipients(req Request) []string {
+	res := []string{}
+	if !req.skipShared {
+		res = append(res, e.AdminEmails...)
+	}
+	for _, email := range req.AdminEmails {
+		if !contains(email, res) {
+			res = append(res, email)
+		}
+	}
+	return res
+}
+
+// isBounced checks if email has bounce record and must not be used for sending
+func (e *Email) isBounced(siteID, email string) bool {
+	if e.Bounces == nil || !e.Bounces.IsBounced(siteID, email) {
+		return false
+	}
+	log.Printf("[DEBUG] skip sending to bounced email %s, site %s", email, siteID)
+	return true
+}
+
 func (e *Email) buildAndSendMessage(ctx context.Context, req Request, email string, forAdmin bool) error {
-	log.Printf("[DEBUG] send notification via %s, comment id %s", e, req.Comment.ID)
+	logging.Printf(ctx, "[DEBUG] send notification via %s, comment id %s", e, req.Comment.ID)
 	msg, err := e.buildMessageFromRequest(req, email, forAdmin)
 	if err != nil {
 		return err
 	}
 
+	attempts := 0
 	return repeater.NewDefault(5, time.Millisecond*250).Do(
 		ctx,
 		func() error {
-			return e.sendMessage(emailMessage{from: e.From, to: email, message: msg})
+			if attempts++; attempts > 1 {
+				countRetry(ctx)
+			}
+			return e.send(ctx, emailMessage{from: e.From, to: email, message: msg})
 		})
 }
 
@@ -219,91 +469,324 @@ func (e *Email) SendVerification(ctx context.Context, req VerificationRequest) e
 	default:
 	}
 
+	if e.isBounced(req.SiteID, req.Email) {
+		return errors.Errorf("verification for %s not sent, email %q bounced", req.User, req.Email)
+	}
+
 	log.Printf("[DEBUG] send verification via %s, user %s", e, req.User)
-	msg, err := e.buildVerificationMessage(req.User, req.Email, req.Token, req.SiteID)
+	msg, err := e.buildVerificationMessage(req)
 	if err != nil {
 		return err
 	}
 
+	attempts := 0
 	return repeater.NewDefault(5, time.Millisecond*250).Do(
 		ctx,
 		func() error {
-			return e.sendMessage(emailMessage{from: e.From, to: req.Email, message: msg})
+			if attempts++; attempts > 1 {
+				countRetry(ctx)
+			}
+			return e.send(ctx, emailMessage{from: e.From, to: req.Email, message: msg})
 		})
 }
 
 // buildVerificationMessage generates verification email message based on given input
-func (e *Email) buildVerificationMessage(user, email, token, site string) (string, error) {
+func (e *Email) buildVerificationMessage(req VerificationRequest) (string, error) {
 	subject := e.VerificationSubject
+	if req.DeletionURL != "" {
+		subject = deletionSubject
+	}
+	if req.NewEmail != "" {
+		subject = emailChangeSubject
+	}
 	msg := bytes.Buffer{}
-	err := e.verifyTmpl.Execute(&msg, verifyTmplData{
-		User:         user,
-		Token:        token,
-		Email:        email,
-		Site:         site,
+	_, verifyTmpl := e.templates()
+	err := verifyTmpl.Execute(&msg, verifyTmplData{
+		User:         req.User,
+		Token:        req.Token,
+		Email:        req.Email,
+		Site:         req.SiteID,
 		SubscribeURL: e.SubscribeURL,
+		DeletionURL:  req.DeletionURL,
+		NewEmail:     req.NewEmail,
 	})
 	if err != nil {
 		return "", errors.Wrapf(err, "error executing template to build verification message")
 	}
-	return e.buildMessage(subject, msg.String(), email, "text/html", "")
+	return e.buildMessage(subject, msg.String(), req.Email, "text/html", msgHeaders{})
 }
 
 // buildMessageFromRequest generates email message based on Request using e.MsgTemplate
 func (e *Email) buildMessageFromRequest(req Request, email string, forAdmin bool) (string, error) {
 	subject := "New reply to your comment"
+	if req.follower != nil {
+		subject = fmt.Sprintf("New comment from %s", req.Comment.User.Name)
+	}
 	if forAdmin {
 		subject = "New comment to your site"
+		if req.Comment.Pending {
+			subject = "New comment to your site awaits moderation"
+		}
+		if len(req.Keywords) > 0 {
+			subject = fmt.Sprintf("Keywords alert (%s): new comment to your site", strings.Join(req.Keywords, ", "))
+		}
+	}
+	if req.Moderation != "" {
+		subject = moderationSubjects[req.Moderation]
 	}
 	if req.Comment.PostTitle != "" {
 		subject += fmt.Sprintf(" for %q", req.Comment.PostTitle)
 	}
+	subject = e.customSubject(subject, subjectTmplData{PostTitle: req.Comment.PostTitle, UserName: req.Comment.User.Name,
+		ForAdmin: forAdmin, Following: req.follower != nil, Pending: req.Comment.Pending, Keywords: req.Keywords,
+		Moderation: string(req.Moderation)})
 
-	token, err := e.TokenGenFn(req.parent.User.ID, email, req.Comment.Locator.SiteID)
-	if err != nil {
-		return "", errors.Wrapf(err, "error creating token for unsubscribe link")
+	recipientID := req.parent.User.ID // recipient of reply is the author of parent comment
+	if req.follower != nil {
+		recipientID = req.follower.UserID
 	}
-	unsubscribeLink := e.UnsubscribeURL + "?site=" + req.Comment.Locator.SiteID + "&tkn=" + token
-	if forAdmin {
-		unsubscribeLink = ""
+
+	unsubscribeLink, headerLink := "", "" // link in the message and in List-Unsubscribe header
+	if !forAdmin && req.Moderation == "" {
+		token, err := e.TokenGenFn(recipientID, email, req.Comment.Locator.SiteID)
+		if err != nil {
+			return "", errors.Wrapf(err, "error creating token for unsubscribe link")
+		}
+		unsubscribeLink = e.UnsubscribeURL + "?site=" + req.Comment.Locator.SiteID + "&tkn=" + token
+		headerLink = unsubscribeLink
+		if e.OneClickUnsubscribeURL != "" {
+			headerLink = e.OneClickUnsubscribeURL + "?site=" + req.Comment.Locator.SiteID + "&tkn=" + token
+		}
+	}
+
+	// upvote link for the recipient of the reply
+	voteLink := ""
+	if !forAdmin && req.Moderation == "" && e.VoteURL != "" && e.VoteTokenGenFn != nil {
+		token, err := e.VoteTokenGenFn(recipientID, req.Comment.Locator.SiteID, req.Comment.Locator.URL, req.Comment.ID)
+		if err != nil {
+			return "", errors.Wrapf(err, "error creating token for vote link")
+		}
+		voteLink = e.VoteURL + "?tkn=" + token
+	}
+
+	// moderation links for the admin, approve link for comment held for moderation only
+	modLinks := map[string]string{}
+	if forAdmin && req.Moderation == "" && !req.Comment.Deleted && e.ModerationURL != "" && e.ModerationTokenGenFn != nil {
+		for _, action := range []string{"approve", "delete", "block"} {
+			if action == "approve" && !req.Comment.Pending {
+				continue
+			}
+			token, err := e.ModerationTokenGenFn(email, req.Comment.Locator.SiteID, req.Comment.Locator.URL, req.Comment.ID, action)
+			if err != nil {
+				return "", errors.Wrapf(err, "error creating token for %s link", action)
+			}
+			modLinks[action] = e.ModerationURL + "?tkn=" + token
+		}
+	}
+
+	// reply-to address for replies by email, only for notifications about replies to the recipient
+	replyTo := ""
+	if !forAdmin && req.Moderation == "" && req.follower == nil && e.ReplyAddressFn != nil {
+		addr, err := e.ReplyAddressFn(req.parent.User, email, req.Comment)
+		if err != nil {
+			log.Printf("[WARN] can't make reply address for %s, %v", req.Comment.ID, err)
+		}
+		replyTo = addr
 	}
 
-	commentURLPrefix := req.Comment.Locator.URL + uiNav
 	msg := bytes.Buffer{}
 	tmplData := msgTmplData{
 		UserName:        req.Comment.User.Name,
 		UserPicture:     req.Comment.User.Picture,
-		CommentText:     req.Comment.Text,
-		CommentLink:     commentURLPrefix + req.Comment.ID,
+		CommentText:     template.HTML(store.InlineCodeStyle(req.Comment.Text, e.CodeStyle)), //nolint:gosec // comment text sanitized by store
+		CommentLink:     req.CommentLink(req.Comment.ID),
 		CommentDate:     req.Comment.Timestamp,
 		PostTitle:       req.Comment.PostTitle,
 		Email:           email,
 		UnsubscribeLink: unsubscribeLink,
+		VoteLink:        voteLink,
+		ApproveLink:     modLinks["approve"],
+		DeleteLink:      modLinks["delete"],
+		BlockLink:       modLinks["block"],
 		ForAdmin:        forAdmin,
+		Following:       req.follower != nil,
+		Moderation:      string(req.Moderation),
+		Reason:          req.Reason,
+	}
+	if forAdmin {
+		tmplData.Keywords = req.Keywords
 	}
 	// in case of message to admin, parent message might be empty
 	if req.Comment.ParentID != "" {
 		tmplData.ParentUserName = req.parent.User.Name
 		tmplData.ParentUserPicture = req.parent.User.Picture
-		tmplData.ParentCommentText = req.parent.Text
-		tmplData.ParentCommentLink = commentURLPrefix + req.parent.ID
+		tmplData.ParentCommentText = template.HTML(store.InlineCodeStyle(req.parent.Text, e.CodeStyle)) //nolint:gosec // comment text sanitized by store
+		tmplData.ParentCommentLink = req.CommentLink(req.parent.ID)
 		tmplData.ParentCommentDate = req.parent.Timestamp
 	}
-	err = e.msgTmpl.Execute(&msg, tmplData)
+	msgTmpl, _ := e.templates()
+	err := msgTmpl.Execute(&msg, tmplData)
 	if err != nil {
 		return "", errors.Wrapf(err, "error executing template to build comment reply message")
 	}
-	return e.buildMessage(subject, msg.String(), email, "text/html", unsubscribeLink)
+	body := msg.String()
+	if e.ActionMarkup && (req.Moderation == "" || req.Moderation == ModerationApproved) {
+		body = addActionMarkup(body, tmplData.CommentLink)
+	}
+	headers := msgHeaders{unsubscribeLink: headerLink, replyTo: replyTo}
+	headers.messageID, headers.inReplyTo = e.threadIDs(req, email, forAdmin)
+	return e.buildMessage(subject, body, email, "text/html", headers)
+}
+
+// addActionMarkup adds JSON-LD markup of view action with the comment link to html body, before closing body tag
+// if any. Body returned as is for empty link. See https://developers.google.com/gmail/markup/reference/go-to-action
+func addActionMarkup(body, link string) string {
+	if link == "" {
+		return body
+	}
+	type action struct {
+		Type string `json:"@type"`
+		URL  string `json:"url"`
+		Name string `json:"name"`
+	}
+	markup := struct {
+		Context         string `json:"@context"`
+		Type            string `json:"@type"`
+		Description     string `json:"description"`
+		PotentialAction action `json:"potentialAction"`
+	}{Context: "http://schema.org", Type: "EmailMessage", Description: "View comment",
+		PotentialAction: action{Type: "ViewAction", URL: link, Name: "View comment"}}
+	data, err := json.Marshal(markup) // html characters escaped, can't close the script
+	if err != nil {
+		log.Printf("[WARN] can't make action markup, %v", err)
+		return body
+	}
+	script := `<script type="application/ld+json">` + string(data) + "</script>\n"
+	if i := strings.LastIndex(strings.ToLower(body), "</body>"); i >= 0 {
+		return body[:i] + script + body[i:]
+	}
+	return body + script
+}
+
+// threadIDs makes Message-ID of the notification and Message-ID of notification about the parent comment,
+// replied to. Ids are stable for the recipient and kind of notification, so replies threaded by mail clients.
+func (e *Email) threadIDs(req Request, email string, forAdmin bool) (messageID, inReplyTo string) {
+	kind := ""
+	switch {
+	case req.Moderation != "":
+		kind = "." + string(req.Moderation)
+	case forAdmin:
+		kind = ".admin"
+	}
+	domain := "remark42"
+	if addr, err := mail.ParseAddress(e.From); err == nil && strings.Contains(addr.Address, "@") {
+		domain = addr.Address[strings.LastIndex(addr.Address, "@")+1:]
+	}
+	recipient := sha1.Sum([]byte(req.Comment.Locator.SiteID + "!!" + strings.ToLower(email))) //nolint:gosec // not a security hash
+	makeID := func(commentID string) string {
+		return fmt.Sprintf("<%s.%x%s@%s>", messageIDRe.ReplaceAllString(commentID, "-"), recipient[:6], kind, domain)
+	}
+	messageID = makeID(req.Comment.ID)
+	if req.Comment.ParentID != "" && req.Moderation == "" {
+		inReplyTo = makeID(req.Comment.ParentID)
+	}
+	return messageID, inReplyTo
+}
+
+// messageIDRe matches characters of comment id not allowed in Message-ID
+var messageIDRe = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// msgHeaders defines optional per-message headers
+type msgHeaders struct {
+	unsubscribeLink string // link in List-Unsubscribe header
+	replyTo         string // Reply-To address, overrides one of EmailParams.Headers
+	messageID       string // Message-ID, with angle brackets
+	inReplyTo       string // Message-ID of the message replied to, set to In-Reply-To and References
+}
+
+// reservedHeaders are set by Email and can't be set with EmailParams.Headers
+var reservedHeaders = map[string]bool{"From": true, "To": true, "Subject": true, "Date": true, "Message-Id": true,
+	"In-Reply-To": true, "References": true, "Content-Type": true, "Content-Transfer-Encoding": true,
+	"Mime-Version": true, "List-Unsubscribe": true, "List-Unsubscribe-Post": true}
+
+// validateHeaders checks custom headers are valid and not reserved, as header injection is possible otherwise
+func validateHeaders(headers map[string]string) error {
+	for h, v := range headers {
+		if h == "" || strings.IndexFunc(h, func(r rune) bool { return r <= ' ' || r > '~' || r == ':' }) >= 0 {
+			return errors.Errorf("invalid email header name %q", h)
+		}
+		if reservedHeaders[textproto.CanonicalMIMEHeaderKey(h)] {
+			return errors.Errorf("email header %s can't be customized", h)
+		}
+		if strings.ContainsAny(v, "\r\n") {
+			return errors.Errorf("invalid value of email header %s", h)
+		}
+	}
+	return nil
+}
+
+// customSubject makes subject with subject template, the default one returned if template not set, failed or empty
+func (e *Email) customSubject(subject string, data subjectTmplData) string {
+	if e.subjectTmpl == nil {
+		return subject
+	}
+	data.Default = subject
+	buf := bytes.Buffer{}
+	if err := e.subjectTmpl.Execute(&buf, data); err != nil {
+		log.Printf("[WARN] can't execute subject template, default subject used, %v", err)
+		return subject
+	}
+	res := strings.Join(strings.Fields(buf.String()), " ") // single line, as template may span lines
+	if res == "" {
+		return subject
+	}
+	return res
+}
+
+// templates returns message and verification templates, re-read from disk if changed in reload mode
+func (e *Email) templates() (msgTmpl, verifyTmpl *template.Template) {
+	e.tmplMu.Lock()
+	defer e.tmplMu.Unlock()
+	if e.ReloadTemplates {
+		e.msgTmpl = reloadTemplate("msgTmpl", e.MsgTemplatePath, e.msgTmpl, &e.msgTmplMod)
+		e.verifyTmpl = reloadTemplate("verifyTmpl", e.VerificationTemplatePath, e.verifyTmpl, &e.verifyTmplMod)
+	}
+	return e.msgTmpl, e.verifyTmpl
+}
+
+// reloadTemplate parses template file if its modification time differs from modTime, the current template
+// returned for missing, unchanged or broken file. Broken file is not re-read till changed again.
+func reloadTemplate(name, path string, current *template.Template, modTime *time.Time) *template.Template {
+	fi, err := os.Stat(path)
+	if err != nil || fi.ModTime().Equal(*modTime) {
+		return current
+	}
+	*modTime = fi.ModTime()
+	data, err := ioutil.ReadFile(path) //nolint:gosec // path of the template set by admin
+	if err != nil {
+		log.Printf("[WARN] can't read email template %s, the last good one kept, %v", path, err)
+		return current
+	}
+	tmpl, err := template.New(name).Funcs(emailFuncs()).Parse(string(data))
+	if err != nil {
+		log.Printf("[WARN] can't parse email template %s, the last good one kept, %v", path, err)
+		return current
+	}
+	log.Printf("[INFO] email template %s reloaded", path)
+	return tmpl
 }
 
 // buildMessage generates email message to send using net/smtp.Data()
-func (e *Email) buildMessage(subject, body, to, contentType, unsubscribeLink string) (message string, err error) {
+func (e *Email) buildMessage(subject, body, to, contentType string, headers msgHeaders) (message string, err error) {
 	addHeader := func(msg, h, v string) string {
 		msg += fmt.Sprintf("%s: %s\n", h, v)
 		return msg
 	}
 	message = addHeader(message, "From", e.From)
 	message = addHeader(message, "To", to)
+	if headers.replyTo != "" {
+		message = addHeader(message, "Reply-To", headers.replyTo)
+	}
 	message = addHeader(message, "Subject", mime.BEncoding.Encode("utf-8", subject))
 	message = addHeader(message, "Content-Transfer-Encoding", "quoted-printable")
 
@@ -312,13 +795,31 @@ func (e *Email) buildMessage(subject, body, to, contentType, unsubscribeLink str
 		message = addHeader(message, "Content-Type", contentType+`; charset="UTF-8"`)
 	}
 
-	if unsubscribeLink != "" {
+	if headers.unsubscribeLink != "" {
 		// https://support.google.com/mail/answer/81126 -> "Include option to unsubscribe"
 		message = addHeader(message, "List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
-		message = addHeader(message, "List-Unsubscribe", "<"+unsubscribeLink+">")
+		message = addHeader(message, "List-Unsubscribe", "<"+headers.unsubscribeLink+">")
 	}
 
 	message = addHeader(message, "Date", time.Now().Format(time.RFC1123Z))
+	if headers.messageID != "" {
+		message = addHeader(message, "Message-ID", headers.messageID)
+	}
+	if headers.inReplyTo != "" {
+		message = addHeader(message, "In-Reply-To", headers.inReplyTo)
+		message = addHeader(message, "References", headers.inReplyTo)
+	}
+	custom := make([]string, 0, len(e.Headers))
+	for h := range e.Headers {
+		if headers.replyTo != "" && strings.EqualFold(h, "Reply-To") {
+			continue
+		}
+		custom = append(custom, h)
+	}
+	sort.Strings(custom)
+	for _, h := range custom {
+		message = addHeader(message, h, mime.QEncoding.Encode("utf-8", e.Headers[h]))
+	}
 
 	buff := &bytes.Buffer{}
 	qp := quotedprintable.NewWriter(buff)
@@ -334,20 +835,57 @@ func (e *Email) buildMessage(subject, body, to, contentType, unsubscribeLink str
 	return message, nil
 }
 
-// sendMessage sends messages to server in a new connection, closing the connection after finishing.
-// Thread safe.
+// send delivers message with Sender if set, with SMTP otherwise. Duration of sending reported to metrics.
+func (e *Email) send(ctx context.Context, m emailMessage) (err error) {
+	if e.metrics != nil {
+		st := time.Now()
+		defer func() { e.metrics.EmailSent(time.Since(st), err) }()
+	}
+	return tracing.Span(ctx, "email.send", func(ctx context.Context) error {
+		if e.Sender != nil {
+			return e.Sender.Send(ctx, m.from, m.to, m.message)
+		}
+		return e.sendMessage(m)
+	})
+}
+
+// sendMessage sends message with the primary SMTP server, failing over to the next of fallback servers
+// on connection or sending error. Server handled the message logged. Thread safe.
 func (e *Email) sendMessage(m emailMessage) error {
 	if e.smtp == nil {
 		return errors.New("sendMessage called without client set")
 	}
-	client, err := e.smtp.Create(e.SMTPParams)
+	params := e.SMTP()
+	servers := append([]SMTPParams{params}, params.Fallback...)
+	result := new(multierror.Error)
+	for i, params := range servers {
+		err := e.sendMessageWith(params, m)
+		if err == nil {
+			log.Printf("[INFO] email to %s sent with smtp server %s:%d", m.to, params.Host, params.Port)
+			return nil
+		}
+		if len(servers) == 1 {
+			return err
+		}
+		if i < len(servers)-1 {
+			log.Printf("[WARN] can't send email to %s with smtp server %s:%d, failing over to %s:%d, %v",
+				m.to, params.Host, params.Port, servers[i+1].Host, servers[i+1].Port, err)
+		}
+		result = multierror.Append(result, errors.Wrapf(err, "smtp server %s:%d", params.Host, params.Port))
+	}
+	return result.ErrorOrNil()
+}
+
+// sendMessageWith sends message to the server in a new connection, closing the connection after finishing.
+func (e *Email) sendMessageWith(params SMTPParams, m emailMessage) error {
+	client, err := e.smtp.Create(params)
 	if err != nil {
 		return errors.Wrap(err, "failed to make smtp Create")
 	}
 
 	defer func() {
 		if err = client.Quit(); err != nil {
-			log.Printf("[WARN] failed to send quit command to %s:%d, %v", e.Host, e.Port, err)
+			log.Printf("[WARN] failed to send quit command to %s:%d, %v", params.Host, params.Port, err)
 			if err = client.Close(); err != nil {
 				log.Printf("[WARN] can't close smtp connection, %v", err)
 			}
@@ -382,13 +920,31 @@ func (e *Email) sendMessage(m emailMessage) error {
 
 // String representation of Email object
 func (e *Email) String() string {
-	return fmt.Sprintf("email: from %q with username '%s' at server %s:%d", e.From, e.Username, e.Host, e.Port)
+	if e.Sender != nil {
+		return fmt.Sprintf("email: from %q with %s", e.From, e.Sender)
+	}
+	p := e.SMTP()
+	if p.Socket != "" {
+		return fmt.Sprintf("email: from %q with socket %s", e.From, p.Socket)
+	}
+	if len(p.Fallback) > 0 {
+		return fmt.Sprintf("email: from %q with username '%s' at server %s:%d and %d fallback servers",
+			e.From, p.Username, p.Host, p.Port, len(p.Fallback))
+	}
+	return fmt.Sprintf("email: from %q with username '%s' at server %s:%d", e.From, p.Username, p.Host, p.Port)
 }
 
 // Create establish SMTP connection with server using credentials in smtpClientWithCreator.SMTPParams
-// and returns pointer to it. Thread safe.
+// and returns pointer to it. Connection made to unix socket if it is set, LMTP used instead of SMTP if enabled.
+// Thread safe.
 func (s *emailClient) Create(params SMTPParams) (smtpClient, error) {
-	authenticate := func(c *smtp.Client) error {
+	// hello sent before auth, otherwise net/smtp greets server with EHLO localhost
+	setup := func(c *smtp.Client) error {
+		if params.Helo != "" {
+			if err := c.Hello(params.Helo); err != nil {
+				return errors.Wrapf(err, "helo %q rejected", params.Helo)
+			}
+		}
 		if params.Username == "" || params.Password == "" {
 			return nil
 		}
@@ -400,7 +956,13 @@ func (s *emailClient) Create(params SMTPParams) (smtpClient, error) {
 	}
 
 	var c *smtp.Client
-	srvAddress := fmt.Sprintf("%s:%d", params.Host, params.Port)
+	network, srvAddress, srvName := "tcp", fmt.Sprintf("%s:%d", params.Host, params.Port), params.Host
+	if params.Socket != "" {
+		network, srvAddress, srvName = "unix", params.Socket, "localhost"
+	}
+	if params.TLS && (params.LMTP || params.Socket != "") {
+		return nil, errors.New("tls can't be used with lmtp or unix socket")
+	}
 	if params.TLS {
 		tlsConf := &tls.Config{
 			InsecureSkipVerify: false,
@@ -414,18 +976,26 @@ func (s *emailClient) Create(params SMTPParams) (smtpClient, error) {
 		if c, err = smtp.NewClient(conn, params.Host); err != nil {
 			return nil, errors.Wrapf(err, "failed to make smtp client for %s", srvAddress)
 		}
-		return c, authenticate(c)
+		return c, setup(c)
 	}
 
-	conn, err := net.DialTimeout("tcp", srvAddress, params.TimeOut)
+	conn, err := net.DialTimeout(network, srvAddress, params.TimeOut)
 	if err != nil {
 		return nil, errors.Wrapf(err, "timeout connecting to %s", srvAddress)
 	}
 
-	c, err = smtp.NewClient(conn, params.Host)
+	if params.LMTP {
+		lc, err := newLMTPClient(conn, params.Helo)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to make lmtp client for %s", srvAddress)
+		}
+		return lc, nil
+	}
+
+	c, err = smtp.NewClient(conn, srvName)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to dial")
 	}
 
-	return c, authenticate(c)
+	return c, setup(c)
 }
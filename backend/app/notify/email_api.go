@@ -0,0 +1,293 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+)
+
+// EmailSender defines interface for delivery of complete email message (headers and body) built by Email.
+// Used to send emails with http API of email providers instead of SMTP.
+type EmailSender interface {
+	Send(ctx context.Context, from, to, message string) error
+	fmt.Stringer
+}
+
+// SendGridSender sends emails with SendGrid v3 API
+type SendGridSender struct {
+	APIKey   string
+	Endpoint string // optional, https://api.sendgrid.com/v3/mail/send by default
+	Client   http.Client
+}
+
+// MailgunSender sends emails with Mailgun messages.mime API
+type MailgunSender struct {
+	APIKey string
+	Domain string
+	API    string // optional, https://api.mailgun.net by default, https://api.eu.mailgun.net for EU region
+	Client http.Client
+}
+
+// SESSender sends emails with Amazon SES v2 API
+type SESSender struct {
+	Region    string
+	AccessKey string
+	SecretKey string
+	Endpoint  string // optional, https://email.{region}.amazonaws.com by default
+	Client    http.Client
+}
+
+// Send email with SendGrid. Message parsed back to subject, body and headers as SendGrid doesn't accept raw messages.
+func (s *SendGridSender) Send(ctx context.Context, from, to, message string) error {
+	msg, err := parseEmailMessage(message)
+	if err != nil {
+		return err
+	}
+
+	type address struct {
+		Email string `json:"email"`
+	}
+	type content struct {
+		Type  string `json:"type"`
+		Value string `json:"value"`
+	}
+	req := struct {
+		Personalizations []struct {
+			To []address `json:"to"`
+		} `json:"personalizations"`
+		From    address           `json:"from"`
+		ReplyTo *address          `json:"reply_to,omitempty"`
+		Subject string            `json:"subject"`
+		Content []content         `json:"content"`
+		Headers map[string]string `json:"headers,omitempty"`
+	}{
+		From:    address{Email: from},
+		Subject: msg.subject,
+		Content: []content{{Type: msg.contentType, Value: msg.body}},
+		Headers: msg.headers,
+	}
+	if msg.replyTo != "" { // sendgrid doesn't allow Reply-To in headers
+		req.ReplyTo = &address{Email: msg.replyTo}
+	}
+	req.Personalizations = append(req.Personalizations, struct {
+		To []address `json:"to"`
+	}{To: []address{{Email: to}}})
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return errors.Wrap(err, "can't marshal sendgrid request")
+	}
+
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = "https://api.sendgrid.com/v3/mail/send"
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "can't make sendgrid request")
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+s.APIKey)
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.Client.Do(httpReq)
+	if err != nil {
+		return errors.Wrap(err, "sendgrid request failed")
+	}
+	defer resp.Body.Close() // nolint
+	if err = checkAPIResponse("sendgrid", resp); err != nil {
+		return err
+	}
+	log.Printf("[DEBUG] email to %s accepted by sendgrid, id %s", to, resp.Header.Get("X-Message-Id"))
+	return nil
+}
+
+// String representation of SendGridSender
+func (s *SendGridSender) String() string {
+	return "sendgrid api"
+}
+
+// Send email with Mailgun, message sent as-is
+func (m *MailgunSender) Send(ctx context.Context, _, to, message string) error {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	if err := mw.WriteField("to", to); err != nil {
+		return errors.Wrap(err, "can't write mailgun to field")
+	}
+	fw, err := mw.CreateFormFile("message", "message.mime")
+	if err != nil {
+		return errors.Wrap(err, "can't make mailgun message field")
+	}
+	if _, err = io.WriteString(fw, message); err != nil {
+		return errors.Wrap(err, "can't write mailgun message field")
+	}
+	if err = mw.Close(); err != nil {
+		return errors.Wrap(err, "can't close mailgun request body")
+	}
+
+	api := m.API
+	if api == "" {
+		api = "https://api.mailgun.net"
+	}
+	endpoint := strings.TrimSuffix(api, "/") + "/v3/" + m.Domain + "/messages.mime"
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, body)
+	if err != nil {
+		return errors.Wrap(err, "can't make mailgun request")
+	}
+	httpReq.SetBasicAuth("api", m.APIKey)
+	httpReq.Header.Set("Content-Type", mw.FormDataContentType())
+
+	resp, err := m.Client.Do(httpReq)
+	if err != nil {
+		return errors.Wrap(err, "mailgun request failed")
+	}
+	defer resp.Body.Close() // nolint
+	if err = checkAPIResponse("mailgun", resp); err != nil {
+		return err
+	}
+	res := struct {
+		ID string `json:"id"`
+	}{}
+	if err = json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		log.Printf("[WARN] can't decode mailgun response, %v", err)
+	}
+	log.Printf("[DEBUG] email to %s accepted by mailgun, id %s", to, res.ID)
+	return nil
+}
+
+// String representation of MailgunSender
+func (m *MailgunSender) String() string {
+	return fmt.Sprintf("mailgun api for %s", m.Domain)
+}
+
+// Send email with SES, message sent as-is
+func (s *SESSender) Send(ctx context.Context, from, to, message string) error {
+	req := struct {
+		FromEmailAddress string `json:"FromEmailAddress"`
+		Destination      struct {
+			ToAddresses []string `json:"ToAddresses"`
+		} `json:"Destination"`
+		Content struct {
+			Raw struct {
+				Data string `json:"Data"`
+			} `json:"Raw"`
+		} `json:"Content"`
+	}{FromEmailAddress: from}
+	req.Destination.ToAddresses = []string{to}
+	req.Content.Raw.Data = base64.StdEncoding.EncodeToString([]byte(message))
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return errors.Wrap(err, "can't marshal ses request")
+	}
+
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://email.%s.amazonaws.com", s.Region)
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/") + "/v2/email/outbound-emails"
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "can't make ses request")
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	signer := v4.NewSigner(credentials.NewStaticCredentials(s.AccessKey, s.SecretKey, ""))
+	if _, err = signer.Sign(httpReq, bytes.NewReader(body), "ses", s.Region, time.Now()); err != nil {
+		return errors.Wrap(err, "can't sign ses request")
+	}
+
+	resp, err := s.Client.Do(httpReq)
+	if err != nil {
+		return errors.Wrap(err, "ses request failed")
+	}
+	defer resp.Body.Close() // nolint
+	if err = checkAPIResponse("ses", resp); err != nil {
+		return err
+	}
+	res := struct {
+		MessageID string `json:"MessageId"`
+	}{}
+	if err = json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		log.Printf("[WARN] can't decode ses response, %v", err)
+	}
+	log.Printf("[DEBUG] email to %s accepted by ses, id %s", to, res.MessageID)
+	return nil
+}
+
+// String representation of SESSender
+func (s *SESSender) String() string {
+	return fmt.Sprintf("ses api in %s", s.Region)
+}
+
+// checkAPIResponse returns error with provider's response for non-2xx status
+func checkAPIResponse(provider string, resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return errors.Errorf("%s rejected email with status %d, %s", provider, resp.StatusCode, strings.TrimSpace(string(body)))
+}
+
+// parsedEmailMessage keeps parts of the message built by Email.buildMessage
+type parsedEmailMessage struct {
+	subject     string
+	contentType string
+	body        string
+	replyTo     string
+	headers     map[string]string
+}
+
+// parseEmailMessage splits message made by Email.buildMessage back to subject, decoded body and extra headers
+func parseEmailMessage(message string) (parsedEmailMessage, error) {
+	msg, err := mail.ReadMessage(strings.NewReader(message))
+	if err != nil {
+		return parsedEmailMessage{}, errors.Wrap(err, "can't parse email message")
+	}
+
+	res := parsedEmailMessage{contentType: "text/plain", replyTo: msg.Header.Get("Reply-To"), headers: map[string]string{}}
+	dec := mime.WordDecoder{}
+	if res.subject, err = dec.DecodeHeader(msg.Header.Get("Subject")); err != nil {
+		return parsedEmailMessage{}, errors.Wrap(err, "can't decode subject")
+	}
+	if ct := msg.Header.Get("Content-Type"); ct != "" {
+		if mediaType, _, e := mime.ParseMediaType(ct); e == nil {
+			res.contentType = mediaType
+		}
+	}
+
+	var body io.Reader = msg.Body
+	if strings.EqualFold(msg.Header.Get("Content-Transfer-Encoding"), "quoted-printable") {
+		body = quotedprintable.NewReader(msg.Body)
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return parsedEmailMessage{}, errors.Wrap(err, "can't read email body")
+	}
+	res.body = string(data)
+
+	for h := range msg.Header {
+		if !parsedHeaders[h] {
+			res.headers[h] = msg.Header.Get(h)
+		}
+	}
+	return res, nil
+}
+
+// parsedHeaders are headers of the message parsed to separate fields or set by api, not passed as extra headers
+var parsedHeaders = map[string]bool{"From": true, "To": true, "Reply-To": true, "Subject": true, "Date": true,
+	"Content-Type": true, "Content-Transfer-Encoding": true, "Mime-Version": true}
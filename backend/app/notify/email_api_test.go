@@ -0,0 +1,171 @@
+package notify
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendGridSender_Send(t *testing.T) {
+	var req struct {
+		Personalizations []struct {
+			To []struct {
+				Email string `json:"email"`
+			} `json:"to"`
+		} `json:"personalizations"`
+		From struct {
+			Email string `json:"email"`
+		} `json:"from"`
+		ReplyTo *struct {
+			Email string `json:"email"`
+		} `json:"reply_to"`
+		Subject string `json:"subject"`
+		Content []struct {
+			Type  string `json:"type"`
+			Value string `json:"value"`
+		} `json:"content"`
+		Headers map[string]string `json:"headers"`
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer key123", r.Header.Get("Authorization"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		if req.Subject == "fail" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"errors":[{"message":"bad request"}]}`))
+			return
+		}
+		w.Header().Set("X-Message-Id", "msg-1")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	e := Email{EmailParams: EmailParams{From: "from@example.com", Headers: map[string]string{"X-Mailer": "remark42"}}}
+	msg, err := e.buildMessage("Привет 🦄", "<b>some long text with = and ü</b>", "to@example.com", "text/html",
+		msgHeaders{unsubscribeLink: "https://example.com/unsubscribe", replyTo: "reply+123@example.com",
+			messageID: "<1.abc@example.com>"})
+	require.NoError(t, err)
+
+	s := SendGridSender{APIKey: "key123", Endpoint: ts.URL}
+	require.NoError(t, s.Send(context.Background(), "from@example.com", "to@example.com", msg))
+	assert.Equal(t, "from@example.com", req.From.Email)
+	assert.Equal(t, "to@example.com", req.Personalizations[0].To[0].Email)
+	assert.Equal(t, "Привет 🦄", req.Subject)
+	assert.Equal(t, "text/html", req.Content[0].Type)
+	assert.Equal(t, "<b>some long text with = and ü</b>", req.Content[0].Value)
+	assert.Equal(t, map[string]string{"List-Unsubscribe": "<https://example.com/unsubscribe>",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click", "Message-Id": "<1.abc@example.com>",
+		"X-Mailer": "remark42"}, req.Headers)
+	require.NotNil(t, req.ReplyTo)
+	assert.Equal(t, "reply+123@example.com", req.ReplyTo.Email)
+
+	msg, err = e.buildMessage("fail", "body", "to@example.com", "", msgHeaders{})
+	require.NoError(t, err)
+	err = s.Send(context.Background(), "from@example.com", "to@example.com", msg)
+	assert.EqualError(t, err, `sendgrid rejected email with status 400, {"errors":[{"message":"bad request"}]}`)
+	assert.Equal(t, "text/plain", req.Content[0].Type)
+
+	assert.Error(t, s.Send(context.Background(), "from@example.com", "to@example.com", "bad message"))
+	assert.Equal(t, "sendgrid api", s.String())
+}
+
+func TestMailgunSender_Send(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v3/mg.example.com/messages.mime", r.URL.Path)
+		user, passwd, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "api", user)
+		assert.Equal(t, "key123", passwd)
+		require.NoError(t, r.ParseMultipartForm(1024*1024))
+		assert.Equal(t, "to@example.com", r.FormValue("to"))
+		f, _, err := r.FormFile("message")
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(f)
+		require.NoError(t, err)
+		if !strings.Contains(string(data), "Subject: test") {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte("Forbidden"))
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":"<123@mg.example.com>","message":"Queued. Thank you."}`))
+	}))
+	defer ts.Close()
+
+	m := MailgunSender{APIKey: "key123", Domain: "mg.example.com", API: ts.URL + "/"}
+	require.NoError(t, m.Send(context.Background(), "from@example.com", "to@example.com", "Subject: test\n\nbody"))
+	err := m.Send(context.Background(), "from@example.com", "to@example.com", "Subject: other\n\nbody")
+	assert.EqualError(t, err, "mailgun rejected email with status 401, Forbidden")
+	assert.Equal(t, "mailgun api for mg.example.com", m.String())
+}
+
+func TestSESSender_Send(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v2/email/outbound-emails", r.URL.Path)
+		assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"),
+			"AWS4-HMAC-SHA256 Credential=access123/"), r.Header.Get("Authorization"))
+		assert.Contains(t, r.Header.Get("Authorization"), "/eu-west-1/ses/aws4_request")
+		assert.NotEmpty(t, r.Header.Get("X-Amz-Date"))
+		req := struct {
+			FromEmailAddress string
+			Destination      struct{ ToAddresses []string }
+			Content          struct{ Raw struct{ Data string } }
+		}{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "from@example.com", req.FromEmailAddress)
+		assert.Equal(t, []string{"to@example.com"}, req.Destination.ToAddresses)
+		data, err := base64.StdEncoding.DecodeString(req.Content.Raw.Data)
+		require.NoError(t, err)
+		if string(data) != "Subject: test\n\nbody" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"message":"Illegal address"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"MessageId":"id-1"}`))
+	}))
+	defer ts.Close()
+
+	s := SESSender{Region: "eu-west-1", AccessKey: "access123", SecretKey: "secret", Endpoint: ts.URL}
+	require.NoError(t, s.Send(context.Background(), "from@example.com", "to@example.com", "Subject: test\n\nbody"))
+	err := s.Send(context.Background(), "from@example.com", "to@example.com", "Subject: bad\n\nbody")
+	assert.EqualError(t, err, `ses rejected email with status 400, {"message":"Illegal address"}`)
+	assert.Equal(t, "ses api in eu-west-1", s.String())
+}
+
+func TestEmail_SendWithSender(t *testing.T) {
+	sender := &fakeSender{}
+	email, err := NewEmail(EmailParams{
+		From:                     "from@example.com",
+		VerificationTemplatePath: "testdata/verification.html.tmpl",
+		MsgTemplatePath:          "testdata/msg.html.tmpl",
+		TokenGenFn:               TokenGenFn,
+		Sender:                   sender,
+	}, SMTPParams{})
+	require.NoError(t, err)
+	email.smtp = &fakeTestSMTP{fail: map[string]bool{"create": true}}
+	assert.Equal(t, `email: from "from@example.com" with fake sender`, email.String())
+
+	req := VerificationRequest{SiteID: "remark42", User: "u1", Email: "u1@example.com", Token: "tkn"}
+	require.NoError(t, email.SendVerification(context.Background(), req))
+	assert.Equal(t, []string{"u1@example.com"}, sender.to)
+	assert.Contains(t, sender.messages[0], "Subject: Email verification\n")
+}
+
+type fakeSender struct {
+	to       []string
+	messages []string
+}
+
+func (f *fakeSender) Send(_ context.Context, _, to, message string) error {
+	f.to = append(f.to, to)
+	f.messages = append(f.messages, message)
+	return nil
+}
+
+func (f *fakeSender) String() string { return "fake sender" }
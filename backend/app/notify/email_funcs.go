@@ -0,0 +1,159 @@
+package notify
+
+import (
+	"html/template"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// emailLocale defines names of months and weekdays of the language, full month names are in the form used with day
+type emailLocale struct {
+	months, shortMonths     [12]string
+	weekdays, shortWeekdays [7]string // from Sunday
+}
+
+// emailLocales are languages supported by formatDate of email templates
+var emailLocales = map[string]emailLocale{
+	"de": {
+		months: [12]string{"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli", "August", "September", "Oktober",
+			"November", "Dezember"},
+		shortMonths:   [12]string{"Jan", "Feb", "Mär", "Apr", "Mai", "Jun", "Jul", "Aug", "Sep", "Okt", "Nov", "Dez"},
+		weekdays:      [7]string{"Sonntag", "Montag", "Dienstag", "Mittwoch", "Donnerstag", "Freitag", "Samstag"},
+		shortWeekdays: [7]string{"So", "Mo", "Di", "Mi", "Do", "Fr", "Sa"},
+	},
+	"es": {
+		months: [12]string{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre",
+			"octubre", "noviembre", "diciembre"},
+		shortMonths:   [12]string{"ene", "feb", "mar", "abr", "may", "jun", "jul", "ago", "sept", "oct", "nov", "dic"},
+		weekdays:      [7]string{"domingo", "lunes", "martes", "miércoles", "jueves", "viernes", "sábado"},
+		shortWeekdays: [7]string{"dom", "lun", "mar", "mié", "jue", "vie", "sáb"},
+	},
+	"fr": {
+		months: [12]string{"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre",
+			"octobre", "novembre", "décembre"},
+		shortMonths:   [12]string{"janv.", "févr.", "mars", "avr.", "mai", "juin", "juil.", "août", "sept.", "oct.", "nov.", "déc."},
+		weekdays:      [7]string{"dimanche", "lundi", "mardi", "mercredi", "jeudi", "vendredi", "samedi"},
+		shortWeekdays: [7]string{"dim.", "lun.", "mar.", "mer.", "jeu.", "ven.", "sam."},
+	},
+	"it": {
+		months: [12]string{"gennaio", "febbraio", "marzo", "aprile", "maggio", "giugno", "luglio", "agosto", "settembre",
+			"ottobre", "novembre", "dicembre"},
+		shortMonths:   [12]string{"gen", "feb", "mar", "apr", "mag", "giu", "lug", "ago", "set", "ott", "nov", "dic"},
+		weekdays:      [7]string{"domenica", "lunedì", "martedì", "mercoledì", "giovedì", "venerdì", "sabato"},
+		shortWeekdays: [7]string{"dom", "lun", "mar", "mer", "gio", "ven", "sab"},
+	},
+	"pt": {
+		months: [12]string{"janeiro", "fevereiro", "março", "abril", "maio", "junho", "julho", "agosto", "setembro",
+			"outubro", "novembro", "dezembro"},
+		shortMonths:   [12]string{"jan", "fev", "mar", "abr", "mai", "jun", "jul", "ago", "set", "out", "nov", "dez"},
+		weekdays:      [7]string{"domingo", "segunda-feira", "terça-feira", "quarta-feira", "quinta-feira", "sexta-feira", "sábado"},
+		shortWeekdays: [7]string{"dom", "seg", "ter", "qua", "qui", "sex", "sáb"},
+	},
+	"ru": {
+		months: [12]string{"января", "февраля", "марта", "апреля", "мая", "июня", "июля", "августа", "сентября",
+			"октября", "ноября", "декабря"},
+		shortMonths:   [12]string{"янв", "фев", "мар", "апр", "мая", "июн", "июл", "авг", "сен", "окт", "ноя", "дек"},
+		weekdays:      [7]string{"воскресенье", "понедельник", "вторник", "среда", "четверг", "пятница", "суббота"},
+		shortWeekdays: [7]string{"вс", "пн", "вт", "ср", "чт", "пт", "сб"},
+	},
+}
+
+// emailFuncs returns helper functions of message, verification and subject templates. Value is the last argument
+// of the function, so it can be piped, i.e. {{.CommentDate | inZone "Europe/Berlin" | formatDate "2. January 2006, 15:04" "de"}}
+func emailFuncs() template.FuncMap {
+	return template.FuncMap{
+		"inZone":     inZone,
+		"formatDate": formatDate,
+		"truncate":   truncate,
+		"plural":     plural,
+		"urlEscape":  url.QueryEscape,
+		"pathEscape": url.PathEscape,
+	}
+}
+
+// inZone returns time in IANA timezone, like "Europe/Berlin"
+func inZone(name string, t time.Time) (time.Time, error) {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return t, errors.Wrapf(err, "unknown timezone %q", name)
+	}
+	return t.In(loc), nil
+}
+
+// formatDate formats time with go layout, names of months and weekdays in the layout translated to the locale.
+// English names used for "en" or empty locale.
+func formatDate(layout, locale string, t time.Time) (string, error) {
+	if locale == "" || locale == "en" {
+		return t.Format(layout), nil
+	}
+	names, ok := emailLocales[strings.ToLower(locale)]
+	if !ok {
+		return "", errors.Errorf("unsupported date locale %q", locale)
+	}
+
+	// layout split on names, the rest of layout formatted by time.Format. Longer names checked first,
+	// as "Jan" and "Mon" are prefixes of "January" and "Monday"
+	tokens := []struct {
+		layout string
+		value  string
+	}{
+		{"January", names.months[t.Month()-1]},
+		{"Monday", names.weekdays[t.Weekday()]},
+		{"Jan", names.shortMonths[t.Month()-1]},
+		{"Mon", names.shortWeekdays[t.Weekday()]},
+	}
+	res := strings.Builder{}
+	for layout != "" {
+		pos, token := len(layout), -1
+		for i, tk := range tokens {
+			if p := strings.Index(layout, tk.layout); p >= 0 && p < pos {
+				pos, token = p, i
+			}
+		}
+		res.WriteString(t.Format(layout[:pos]))
+		if token < 0 {
+			break
+		}
+		res.WriteString(tokens[token].value)
+		layout = layout[pos+len(tokens[token].layout):]
+	}
+	return res.String(), nil
+}
+
+// truncate cuts string to max runes, with ellipsis added if cut
+func truncate(max int, s string) string {
+	if max <= 0 || utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	return string([]rune(s)[:max]) + "…"
+}
+
+// plural picks form of the word for the number. With two forms the first one is for 1, like "comment" and "comments".
+// With three forms the rule of slavic languages used, forms for 1, 2-4 and 5 (21, 22 and 25), like
+// "комментарий", "комментария" and "комментариев".
+func plural(n int, forms ...string) (string, error) {
+	if n < 0 {
+		n = -n
+	}
+	switch len(forms) {
+	case 2:
+		if n == 1 {
+			return forms[0], nil
+		}
+		return forms[1], nil
+	case 3:
+		switch {
+		case n%10 == 1 && n%100 != 11:
+			return forms[0], nil
+		case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+			return forms[1], nil
+		default:
+			return forms[2], nil
+		}
+	}
+	return "", errors.Errorf("plural needs 2 or 3 forms, got %d", len(forms))
+}
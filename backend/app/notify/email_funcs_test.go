@@ -0,0 +1,80 @@
+package notify
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmailFuncs_FormatDate(t *testing.T) {
+	ts := time.Date(2020, 5, 4, 14, 5, 0, 0, time.UTC) // Monday
+
+	tbl := []struct {
+		layout, locale, res string
+	}{
+		{"02.01.2006 at 15:04", "", "04.05.2020 at 14:05"},
+		{"Monday, 2 January 2006", "en", "Monday, 4 May 2020"},
+		{"Monday, 2. January 2006, 15:04", "de", "Montag, 4. Mai 2020, 14:05"},
+		{"Mon 2 Jan 2006", "DE", "Mo 4 Mai 2020"},
+		{"2 January 2006 в 15:04", "ru", "4 мая 2020 в 14:05"},
+		{"Mon, Jan 2", "fr", "lun., mai 4"},
+		{"Monday Monday", "es", "lunes lunes"},
+		{"January", "pt", "maio"},
+		{"Jan2", "it", "mag4"},
+	}
+	for i, tt := range tbl {
+		res, err := formatDate(tt.layout, tt.locale, ts)
+		require.NoError(t, err, "case #%d", i)
+		assert.Equal(t, tt.res, res, "case #%d", i)
+	}
+
+	_, err := formatDate("2006", "xx", ts)
+	assert.EqualError(t, err, `unsupported date locale "xx"`)
+}
+
+func TestEmailFuncs_InZone(t *testing.T) {
+	ts := time.Date(2020, 5, 4, 14, 5, 0, 0, time.UTC)
+	res, err := inZone("Europe/Berlin", ts)
+	require.NoError(t, err)
+	assert.Equal(t, "16:05", res.Format("15:04"))
+	assert.True(t, ts.Equal(res))
+
+	_, err = inZone("Mars/Olympus", ts)
+	assert.Error(t, err)
+}
+
+func TestEmailFuncs_Truncate(t *testing.T) {
+	assert.Equal(t, "short", truncate(10, "short"))
+	assert.Equal(t, "Длинн…", truncate(5, "Длинный заголовок"))
+	assert.Equal(t, "as is", truncate(0, "as is"))
+}
+
+func TestEmailFuncs_Plural(t *testing.T) {
+	tbl := []struct {
+		n     int
+		forms []string
+		res   string
+	}{
+		{1, []string{"comment", "comments"}, "comment"},
+		{0, []string{"comment", "comments"}, "comments"},
+		{5, []string{"comment", "comments"}, "comments"},
+		{1, []string{"ответ", "ответа", "ответов"}, "ответ"},
+		{3, []string{"ответ", "ответа", "ответов"}, "ответа"},
+		{5, []string{"ответ", "ответа", "ответов"}, "ответов"},
+		{11, []string{"ответ", "ответа", "ответов"}, "ответов"},
+		{12, []string{"ответ", "ответа", "ответов"}, "ответов"},
+		{21, []string{"ответ", "ответа", "ответов"}, "ответ"},
+		{22, []string{"ответ", "ответа", "ответов"}, "ответа"},
+		{-2, []string{"ответ", "ответа", "ответов"}, "ответа"},
+	}
+	for i, tt := range tbl {
+		res, err := plural(tt.n, tt.forms...)
+		require.NoError(t, err, "case #%d", i)
+		assert.Equal(t, tt.res, res, "case #%d", i)
+	}
+
+	_, err := plural(1, "one")
+	assert.Error(t, err)
+}
@@ -0,0 +1,130 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// PreviewKinds lists kinds of email messages rendered by preview
+var PreviewKinds = []string{"reply", "follow", "admin", "moderation", "verification", "deletion"}
+
+// ErrNoEmail returned by preview and test sending if email destination is not set
+var ErrNoEmail = errors.New("email notifications disabled")
+
+// emailPreviewer implemented by destinations able to render email templates and send test emails
+type emailPreviewer interface {
+	Preview(kind string) (subject, body string, err error)
+	SendTest(ctx context.Context, to, kind string) error
+}
+
+// PreviewEmail renders email message of the given kind with sample data, returns subject and html body
+func (s *Service) PreviewEmail(kind string) (subject, body string, err error) {
+	for _, d := range s.destinations {
+		if p, ok := d.(emailPreviewer); ok {
+			return p.Preview(kind)
+		}
+	}
+	return "", "", ErrNoEmail
+}
+
+// SendTestEmail sends email message of the given kind with sample data to the address with current email settings
+func (s *Service) SendTestEmail(ctx context.Context, to, kind string) error {
+	for _, d := range s.destinations {
+		if p, ok := d.(emailPreviewer); ok {
+			return p.SendTest(ctx, to, kind)
+		}
+	}
+	return ErrNoEmail
+}
+
+// Preview renders template of the given kind with sample data
+func (e *Email) Preview(kind string) (subject, body string, err error) {
+	msg := bytes.Buffer{}
+	msgTmpl, verifyTmpl := e.templates()
+	switch kind {
+	case "verification", "deletion":
+		data := verifyTmplData{User: "Sample User", Token: "sample-token", Email: "user@example.com", Site: "remark",
+			SubscribeURL: e.SubscribeURL}
+		subject = e.VerificationSubject
+		if kind == "deletion" {
+			data.DeletionURL, subject = "https://example.com/account/delete.html?tkn=sample-token", deletionSubject
+		}
+		err = verifyTmpl.Execute(&msg, data)
+	case "reply", "follow", "admin", "moderation":
+		var data msgTmplData
+		subject, data = sampleMsgTmplData(kind)
+		err = msgTmpl.Execute(&msg, data)
+	default:
+		return "", "", errors.Errorf("unknown preview kind %q", kind)
+	}
+	if err != nil {
+		return "", "", errors.Wrapf(err, "can't render %s template", kind)
+	}
+	return subject, msg.String(), nil
+}
+
+// SendTest sends rendered preview of the given kind to the address, bounces and delivery log ignored
+func (e *Email) SendTest(ctx context.Context, to, kind string) error {
+	subject, body, err := e.Preview(kind)
+	if err != nil {
+		return err
+	}
+	msg, err := e.buildMessage("[test] "+subject, body, to, "text/html", msgHeaders{})
+	if err != nil {
+		return errors.Wrap(err, "can't build test message")
+	}
+	return e.send(ctx, emailMessage{from: e.From, to: to, message: msg})
+}
+
+// Preview implements emailPreviewer for wrapped destination
+func (t *Throttled) Preview(kind string) (subject, body string, err error) {
+	if p, ok := t.dest.(emailPreviewer); ok {
+		return p.Preview(kind)
+	}
+	return "", "", ErrNoEmail
+}
+
+// SendTest implements emailPreviewer for wrapped destination, test messages are not throttled
+func (t *Throttled) SendTest(ctx context.Context, to, kind string) error {
+	if p, ok := t.dest.(emailPreviewer); ok {
+		return p.SendTest(ctx, to, kind)
+	}
+	return ErrNoEmail
+}
+
+// sampleMsgTmplData makes subject and template data of comment notification for preview
+func sampleMsgTmplData(kind string) (subject string, data msgTmplData) {
+	ts := time.Date(2021, 1, 2, 15, 4, 5, 0, time.UTC)
+	data = msgTmplData{
+		UserName:          "Sample User",
+		UserPicture:       "https://remark42.com/favicon.png",
+		CommentText:       template.HTML("<p>Sample reply with <strong>formatting</strong> and a <a href=\"https://example.com\">link</a>.</p>"),
+		CommentLink:       "https://example.com/post#remark42__comment-2",
+		CommentDate:       ts,
+		ParentUserName:    "Parent User",
+		ParentUserPicture: "https://remark42.com/favicon.png",
+		ParentCommentText: template.HTML("<p>Sample parent comment.</p>"),
+		ParentCommentLink: "https://example.com/post#remark42__comment-1",
+		ParentCommentDate: ts.Add(-time.Hour),
+		PostTitle:         "Sample Post",
+		Email:             "user@example.com",
+		UnsubscribeLink:   "https://example.com/email/unsubscribe.html?site=remark&tkn=sample-token",
+		VoteLink:          "https://example.com/email/vote.html?tkn=sample-token",
+	}
+	switch kind {
+	case "follow":
+		data.Following = true
+		return "New comment from Sample User for \"Sample Post\"", data
+	case "admin":
+		data.ForAdmin, data.UnsubscribeLink, data.VoteLink = true, "", ""
+		return "New comment to your site for \"Sample Post\"", data
+	case "moderation":
+		data.Moderation, data.Reason, data.UnsubscribeLink, data.VoteLink = string(ModerationRejected), "sample reason", "", ""
+		return moderationSubjects[ModerationRejected] + " for \"Sample Post\"", data
+	}
+	return "New reply to your comment for \"Sample Post\"", data
+}
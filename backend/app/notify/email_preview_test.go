@@ -0,0 +1,79 @@
+package notify
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmail_Preview(t *testing.T) {
+	email, err := NewEmail(EmailParams{From: "from@example.org", MsgTemplatePath: "testdata/msg.html.tmpl",
+		VerificationTemplatePath: "testdata/verification.html.tmpl"}, SMTPParams{})
+	require.NoError(t, err)
+
+	for _, kind := range PreviewKinds {
+		subject, body, err := email.Preview(kind)
+		require.NoError(t, err, kind)
+		assert.NotEmpty(t, subject, kind)
+		assert.NotEmpty(t, body, kind)
+	}
+
+	subject, body, err := email.Preview("reply")
+	require.NoError(t, err)
+	assert.Equal(t, `New reply to your comment for "Sample Post"`, subject)
+	assert.Contains(t, body, "Sample User")
+	assert.Contains(t, body, "Parent User")
+
+	subject, body, err = email.Preview("deletion")
+	require.NoError(t, err)
+	assert.Equal(t, "Account deletion", subject)
+	assert.Contains(t, body, "https://example.com/account/delete.html?tkn=sample-token")
+
+	_, _, err = email.Preview("bad")
+	assert.EqualError(t, err, `unknown preview kind "bad"`)
+}
+
+func TestEmail_SendTest(t *testing.T) {
+	email, err := NewEmail(EmailParams{From: "from@example.org", MsgTemplatePath: "testdata/msg.html.tmpl",
+		VerificationTemplatePath: "testdata/verification.html.tmpl"}, SMTPParams{})
+	require.NoError(t, err)
+	fakeSMTP := fakeTestSMTP{}
+	email.smtp = &fakeSMTP
+
+	require.NoError(t, email.SendTest(context.Background(), "test@example.org", "verification"))
+	assert.Equal(t, "from@example.org", fakeSMTP.readMail())
+	assert.Equal(t, "test@example.org", fakeSMTP.readRcpt())
+	assert.Contains(t, fakeSMTP.buff.String(), "Subject: [test] Email verification")
+	assert.Contains(t, fakeSMTP.buff.String(), "To: test@example.org")
+
+	assert.Error(t, email.SendTest(context.Background(), "test@example.org", "bad"))
+
+	fakeSMTP.fail = map[string]bool{"rcpt": true}
+	assert.Error(t, email.SendTest(context.Background(), "bad@example.org", "reply"))
+}
+
+func TestService_PreviewEmail(t *testing.T) {
+	s := NewService(nil, 1)
+	_, _, err := s.PreviewEmail("reply")
+	assert.Equal(t, ErrNoEmail, err)
+	assert.Equal(t, ErrNoEmail, s.SendTestEmail(context.Background(), "test@example.org", "reply"))
+	s.Close()
+
+	email, err := NewEmail(EmailParams{From: "from@example.org", MsgTemplatePath: "testdata/msg.html.tmpl",
+		VerificationTemplatePath: "testdata/verification.html.tmpl"}, SMTPParams{})
+	require.NoError(t, err)
+	fakeSMTP := fakeTestSMTP{}
+	email.smtp = &fakeSMTP
+	throttled := NewThrottled(email, ThrottleParams{PerMinute: 1})
+	s = NewService(nil, 1, &MockDest{}, throttled)
+	defer s.Close()
+
+	subject, _, err := s.PreviewEmail("admin")
+	require.NoError(t, err)
+	assert.Equal(t, `New comment to your site for "Sample Post"`, subject)
+
+	require.NoError(t, s.SendTestEmail(context.Background(), "test@example.org", "reply"))
+	assert.Equal(t, "test@example.org", fakeSMTP.readRcpt(), "sent directly, not throttled")
+}
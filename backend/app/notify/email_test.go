@@ -4,11 +4,18 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
+	"html/template"
 	"io"
+	"io/ioutil"
+	"mime/quotedprintable"
 	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
-	"text/template"
+	texttemplate "text/template"
 	"time"
 
 	"github.com/stretchr/testify/assert"
@@ -248,6 +255,486 @@ Content-Transfer-Encoding: quoted-printable
 MIME-version: 1.0
 Content-Type: text/html; charset="UTF-8"
 Date: `)
+
+	// admin alert for watched keywords, not shown to users
+	req.Keywords = []string{"acme", "law suit"}
+	res, err = email.buildMessageFromRequest(req, email.AdminEmails[0], true)
+	assert.NoError(t, err)
+	assert.Contains(t, res, `Subject: Keywords alert (acme, law suit): new comment to your site for "test_title"`)
+	assert.Contains(t, res, "Watched keywords: acme, law suit")
+	res, err = email.buildMessageFromRequest(req, req.Emails[0], false)
+	assert.NoError(t, err)
+	assert.Contains(t, res, "Subject: New reply to your comment")
+	assert.NotContains(t, res, "Watched keywords")
+}
+
+func TestEmail_VoteLink(t *testing.T) {
+	email, err := NewEmail(EmailParams{
+		From:                     "from@example.org",
+		VerificationTemplatePath: "testdata/verification.html.tmpl",
+		MsgTemplatePath:          "testdata/msg.html.tmpl",
+		TokenGenFn:               TokenGenFn,
+		VoteURL:                  "https://remark42.com/email/vote.html",
+		VoteTokenGenFn: func(userID, site, postURL, commentID string) (string, error) {
+			if userID == "error" {
+				return "", errors.New("token generation error")
+			}
+			return fmt.Sprintf("%s-%s-%s", userID, site, commentID), nil
+		},
+	}, SMTPParams{})
+	require.NoError(t, err)
+	email.AdminEmails = []string{"admin@example.org"}
+	req := Request{
+		Comment: store.Comment{ID: "999", User: store.User{ID: "1", Name: "test_user"}, ParentID: "1",
+			Locator: store.Locator{SiteID: "remark", URL: "https://example.com/post"}},
+		parent: store.Comment{ID: "1", User: store.User{ID: "user2", Name: "parent_user"}},
+		Emails: []string{"test@example.org"},
+	}
+
+	res, err := email.buildMessageFromRequest(req, req.Emails[0], false)
+	require.NoError(t, err)
+	assert.Contains(t, res, "Vote link: https://remark42.com/email/vote.html?tkn=3Duser2-remark-999",
+		"upvote link for the recipient of the reply")
+
+	res, err = email.buildMessageFromRequest(req, email.AdminEmails[0], true)
+	require.NoError(t, err)
+	assert.NotContains(t, res, "Vote link", "no vote link for admin")
+
+	req.Moderation = ModerationApproved
+	res, err = email.buildMessageFromRequest(req, req.Emails[0], false)
+	require.NoError(t, err)
+	assert.NotContains(t, res, "Vote link", "no vote link in moderation notification")
+
+	req.Moderation = ""
+	req.parent.User.ID = "error"
+	_, err = email.buildMessageFromRequest(req, req.Emails[0], false)
+	assert.EqualError(t, err, "error creating token for unsubscribe link: token generation error")
+	email.TokenGenFn = func(string, string, string) (string, error) { return "token", nil }
+	_, err = email.buildMessageFromRequest(req, req.Emails[0], false)
+	assert.EqualError(t, err, "error creating token for vote link: token generation error")
+}
+
+func TestEmail_ModerationLinks(t *testing.T) {
+	email, err := NewEmail(EmailParams{
+		From:                     "from@example.org",
+		VerificationTemplatePath: "testdata/verification.html.tmpl",
+		MsgTemplatePath:          "testdata/msg.html.tmpl",
+		TokenGenFn:               TokenGenFn,
+		ModerationURL:            "https://remark42.com/email/moderate.html",
+		ModerationTokenGenFn: func(email, site, postURL, commentID, action string) (string, error) {
+			if email == "error@example.org" {
+				return "", errors.New("token generation error")
+			}
+			return fmt.Sprintf("%s-%s", commentID, action[:1]), nil
+		},
+	}, SMTPParams{})
+	require.NoError(t, err)
+	req := Request{
+		Comment: store.Comment{ID: "999", User: store.User{ID: "1", Name: "test_user"}, Pending: true,
+			Locator: store.Locator{SiteID: "remark", URL: "https://example.com/post"}},
+		Emails: []string{"test@example.org"},
+	}
+
+	res, err := email.buildMessageFromRequest(req, "admin@example.org", true)
+	require.NoError(t, err)
+	assert.Contains(t, res, "Approve link: https://remark42.com/email/moderate.html?tkn=3D999-a")
+	assert.Contains(t, res, "Delete link: https://remark42.com/email/moderate.html?tkn=3D999-d")
+	assert.Contains(t, res, "Block link: https://remark42.com/email/moderate.html?tkn=3D999-b")
+
+	req.Comment.Pending = false
+	res, err = email.buildMessageFromRequest(req, "admin@example.org", true)
+	require.NoError(t, err)
+	assert.NotContains(t, res, "Approve link", "approve for pending comment only")
+	assert.Contains(t, res, "Delete link")
+
+	res, err = email.buildMessageFromRequest(req, req.Emails[0], false)
+	require.NoError(t, err)
+	assert.NotContains(t, res, "Delete link", "no moderation links for users")
+
+	_, err = email.buildMessageFromRequest(req, "error@example.org", true)
+	assert.EqualError(t, err, "error creating token for delete link: token generation error")
+}
+
+func TestEmail_CodeStyle(t *testing.T) {
+	email, err := NewEmail(EmailParams{
+		From:                     "from@example.org",
+		VerificationTemplatePath: "testdata/verification.html.tmpl",
+		MsgTemplatePath:          "testdata/msg.html.tmpl",
+		TokenGenFn:               TokenGenFn,
+		CodeStyle:                "github",
+	}, SMTPParams{})
+	require.NoError(t, err)
+	code := store.NewCommentFormatter().FormatText("```go\nfunc main() {}\n```")
+	req := Request{
+		Comment: store.Comment{ID: "999", User: store.User{ID: "1", Name: "test_user"}, ParentID: "1", Text: code,
+			Locator: store.Locator{SiteID: "remark", URL: "https://example.com/post"}},
+		parent: store.Comment{ID: "1", User: store.User{ID: "user2", Name: "parent_user"}, Text: code},
+		Emails: []string{"test@example.org"},
+	}
+
+	res, err := email.buildMessageFromRequest(req, req.Emails[0], false)
+	require.NoError(t, err)
+	decoded, err := ioutil.ReadAll(quotedprintable.NewReader(strings.NewReader(res)))
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(decoded), `<span style="color: #000000; font-weight: bold">func</span>`),
+		"styles inlined to comment and parent")
+	assert.NotContains(t, string(decoded), `class="kd"`)
+}
+
+func TestEmail_Follower(t *testing.T) {
+	email, err := NewEmail(EmailParams{
+		From:                     "from@example.org",
+		VerificationTemplatePath: "testdata/verification.html.tmpl",
+		MsgTemplatePath:          "testdata/msg.html.tmpl",
+		TokenGenFn:               TokenGenFn,
+		VoteURL:                  "https://remark42.com/email/vote.html",
+		VoteTokenGenFn: func(userID, site, postURL, commentID string) (string, error) {
+			return fmt.Sprintf("%s-%s-%s", userID, site, commentID), nil
+		},
+	}, SMTPParams{})
+	require.NoError(t, err)
+	req := Request{
+		Comment: store.Comment{ID: "999", User: store.User{ID: "1", Name: "test_user"},
+			Locator: store.Locator{SiteID: "remark", URL: "https://example.com/post"}},
+		follower: &Follower{UserID: "follower1", Email: "follower@example.org"},
+	}
+
+	res, err := email.buildMessageFromRequest(req, req.follower.Email, false)
+	require.NoError(t, err)
+	assert.Contains(t, res, "Subject: New comment from test_user")
+	assert.Contains(t, res, "To: follower@example.org")
+	assert.Contains(t, res, "New comment from test_user you follow")
+	assert.Contains(t, res, "Vote link: https://remark42.com/email/vote.html?tkn=3Dfollower1-remark-999",
+		"upvote link for the follower")
+
+	req.follower.UserID = "error"
+	_, err = email.buildMessageFromRequest(req, req.follower.Email, false)
+	assert.EqualError(t, err, "error creating token for unsubscribe link: token generation error")
+}
+
+func TestEmail_ReloadTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	msgFile, verifyFile := filepath.Join(dir, "msg.tmpl"), filepath.Join(dir, "verify.tmpl")
+	require.NoError(t, ioutil.WriteFile(msgFile, []byte("message v1 {{.UserName}}"), 0600)) //nolint:gocritic //octalLiteral is OK as FileMode
+	require.NoError(t, ioutil.WriteFile(verifyFile, []byte("verify v1 {{.User}}"), 0600))   //nolint:gocritic //octalLiteral is OK as FileMode
+
+	email, err := NewEmail(EmailParams{From: "from@example.org", MsgTemplatePath: msgFile,
+		VerificationTemplatePath: verifyFile, ReloadTemplates: true}, SMTPParams{})
+	require.NoError(t, err)
+	req := VerificationRequest{User: "user1", Email: "user1@example.org"}
+	msg, err := email.buildVerificationMessage(req)
+	require.NoError(t, err)
+	assert.Contains(t, msg, "verify v1 user1")
+
+	// modification time changed explicitly as file systems may keep it the same for quick writes
+	touch := func(file, content string, shift time.Duration) {
+		require.NoError(t, ioutil.WriteFile(file, []byte(content), 0600)) //nolint:gocritic //octalLiteral is OK as FileMode
+		require.NoError(t, os.Chtimes(file, time.Now().Add(shift), time.Now().Add(shift)))
+	}
+	touch(verifyFile, "verify v2 {{.User}}", time.Minute)
+	msg, err = email.buildVerificationMessage(req)
+	require.NoError(t, err)
+	assert.Contains(t, msg, "verify v2 user1", "changed template reloaded")
+
+	touch(verifyFile, "verify v3 {{.User", 2*time.Minute)
+	msg, err = email.buildVerificationMessage(req)
+	require.NoError(t, err)
+	assert.Contains(t, msg, "verify v2 user1", "last good template kept on parse error")
+
+	require.NoError(t, os.Remove(msgFile))
+	subject, body, err := email.Preview("reply")
+	require.NoError(t, err)
+	assert.NotEmpty(t, subject)
+	assert.Equal(t, "message v1 Sample User", body, "last good template kept for removed file")
+
+	touch(msgFile, "message v2 {{.UserName}}", 3*time.Minute)
+	_, body, err = email.Preview("reply")
+	require.NoError(t, err)
+	assert.Equal(t, "message v2 Sample User", body)
+
+	email.ReloadTemplates = false
+	touch(msgFile, "message v3 {{.UserName}}", 4*time.Minute)
+	_, body, err = email.Preview("reply")
+	require.NoError(t, err)
+	assert.Equal(t, "message v2 Sample User", body, "not reloaded without reload mode")
+}
+
+func TestEmail_TemplateFuncs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	msgFile, verifyFile := filepath.Join(dir, "msg.tmpl"), filepath.Join(dir, "verify.tmpl")
+	msgTmpl := `{{.CommentDate | inZone "Europe/Berlin" | formatDate "2. January 2006, 15:04" "de"}}|` +
+		`{{truncate 4 .PostTitle}}|{{len .Keywords}} {{plural (len .Keywords) "keyword" "keywords"}}|{{urlEscape .Email}}`
+	require.NoError(t, ioutil.WriteFile(msgFile, []byte(msgTmpl), 0600))                                        //nolint:gocritic //octalLiteral is OK as FileMode
+	require.NoError(t, ioutil.WriteFile(verifyFile, []byte(`{{truncate 4 .User}} {{pathEscape .Site}}`), 0600)) //nolint:gocritic //octalLiteral is OK as FileMode
+
+	email, err := NewEmail(EmailParams{From: "from@example.org", MsgTemplatePath: msgFile, VerificationTemplatePath: verifyFile,
+		SubjectTemplate: `{{truncate 3 .UserName}}`}, SMTPParams{})
+	require.NoError(t, err)
+	req := Request{
+		Comment: store.Comment{ID: "999", User: store.User{ID: "1", Name: "test_user"}, PostTitle: "Long title",
+			Timestamp: time.Date(2020, 5, 4, 14, 5, 0, 0, time.UTC), Locator: store.Locator{SiteID: "remark", URL: "https://example.com/post"}},
+		Keywords: []string{"spam"},
+	}
+	res, err := email.buildMessageFromRequest(req, "admin+1@example.org", true)
+	require.NoError(t, err)
+	msg, err := parseEmailMessage(res)
+	require.NoError(t, err)
+	assert.Equal(t, "4. Mai 2020, 16:05|Long…|1 keyword|admin%2B1%40example.org", msg.body)
+	assert.Equal(t, "tes…", msg.subject)
+
+	res, err = email.buildVerificationMessage(VerificationRequest{SiteID: "my site", User: "test_user", Email: "user@example.org"})
+	require.NoError(t, err)
+	msg, err = parseEmailMessage(res)
+	require.NoError(t, err)
+	assert.Equal(t, "test… my%20site", msg.body)
+
+	require.NoError(t, ioutil.WriteFile(msgFile, []byte(`{{formatDate "2006" "xx" .CommentDate}}`), 0600)) //nolint:gocritic //octalLiteral is OK as FileMode
+	email, err = NewEmail(EmailParams{From: "from@example.org", MsgTemplatePath: msgFile, VerificationTemplatePath: verifyFile},
+		SMTPParams{})
+	require.NoError(t, err)
+	_, err = email.buildMessageFromRequest(req, "admin@example.org", true)
+	assert.Error(t, err, "unsupported locale")
+}
+
+func TestEmail_OneClickUnsubscribe(t *testing.T) {
+	email, err := NewEmail(EmailParams{
+		From:                     "from@example.org",
+		VerificationTemplatePath: "testdata/verification.html.tmpl",
+		MsgTemplatePath:          "testdata/msg.html.tmpl",
+		TokenGenFn:               TokenGenFn,
+		UnsubscribeURL:           "https://remark42.com/email/unsubscribe.html",
+		OneClickUnsubscribeURL:   "https://remark42.com/email/unsubscribe",
+	}, SMTPParams{})
+	require.NoError(t, err)
+	req := Request{
+		Comment: store.Comment{ID: "999", ParentID: "1", User: store.User{ID: "1", Name: "test_user"},
+			Locator: store.Locator{SiteID: "remark"}},
+		parent: store.Comment{ID: "1", User: store.User{ID: "999", Name: "parent_user"}},
+	}
+
+	res, err := email.buildMessageFromRequest(req, "test@example.org", false)
+	require.NoError(t, err)
+	assert.Contains(t, res, "List-Unsubscribe: <https://remark42.com/email/unsubscribe?site=remark&tkn=token>\n",
+		"one-click link in header")
+	assert.Contains(t, res, "Unsubscribe link: https://remark42.com/email/unsubscribe.html?site=3Dremark", "page link in body")
+}
+
+func TestEmail_ReplyTo(t *testing.T) {
+	email, err := NewEmail(EmailParams{
+		From:                     "from@example.org",
+		VerificationTemplatePath: "testdata/verification.html.tmpl",
+		MsgTemplatePath:          "testdata/msg.html.tmpl",
+		TokenGenFn:               TokenGenFn,
+		ReplyAddressFn: func(recipient store.User, email string, comment store.Comment) (string, error) {
+			if comment.ID == "error" {
+				return "", errors.New("reply address error")
+			}
+			return fmt.Sprintf("reply+%s-%s-%s@example.org", recipient.ID, email, comment.ID), nil
+		},
+	}, SMTPParams{})
+	require.NoError(t, err)
+	req := Request{
+		Comment: store.Comment{ID: "999", ParentID: "1", User: store.User{ID: "1", Name: "test_user"},
+			Locator: store.Locator{SiteID: "remark", URL: "https://example.com/post"}},
+		parent: store.Comment{ID: "1", User: store.User{ID: "parent_user"}},
+	}
+
+	res, err := email.buildMessageFromRequest(req, "parent@example.org", false)
+	require.NoError(t, err)
+	assert.Contains(t, res, "Reply-To: reply+parent_user-parent@example.org-999@example.org")
+
+	res, err = email.buildMessageFromRequest(req, "admin@example.org", true)
+	require.NoError(t, err)
+	assert.NotContains(t, res, "\nReply-To:", "no reply address for admin")
+
+	req.follower = &Follower{UserID: "follower1", Email: "follower@example.org"}
+	res, err = email.buildMessageFromRequest(req, req.follower.Email, false)
+	require.NoError(t, err)
+	assert.NotContains(t, res, "\nReply-To:", "no reply address for follower")
+
+	req.follower = nil
+	req.Comment.ID = "error"
+	res, err = email.buildMessageFromRequest(req, "parent@example.org", false)
+	require.NoError(t, err, "notification sent without reply address")
+	assert.NotContains(t, res, "\nReply-To:")
+}
+
+func TestEmail_Headers(t *testing.T) {
+	email, err := NewEmail(EmailParams{
+		From:                     "Remark42 <notify@example.org>",
+		VerificationTemplatePath: "testdata/verification.html.tmpl",
+		MsgTemplatePath:          "testdata/msg.html.tmpl",
+		TokenGenFn:               TokenGenFn,
+		Headers: map[string]string{"X-Mailer": "remark42", "X-Entity-Ref-ID": "ref", "Reply-To": "noreply@example.org",
+			"Return-Path": "<bounce@example.org>"},
+	}, SMTPParams{})
+	require.NoError(t, err)
+	req := Request{
+		Comment: store.Comment{ID: "999", ParentID: "1", User: store.User{ID: "1", Name: "test_user"},
+			Locator: store.Locator{SiteID: "remark", URL: "https://example.com/post"}},
+		parent: store.Comment{ID: "1", User: store.User{ID: "parent_user"}},
+	}
+
+	res, err := email.buildMessageFromRequest(req, "parent@example.org", false)
+	require.NoError(t, err)
+	assert.Contains(t, res, "\nReply-To: noreply@example.org\nReturn-Path: <bounce@example.org>\n"+
+		"X-Entity-Ref-ID: ref\nX-Mailer: remark42\n", "custom headers sorted")
+	assert.Contains(t, res, "\nMessage-ID: <999.7816b4079f42@example.org>\n")
+	assert.Contains(t, res, "\nIn-Reply-To: <1.7816b4079f42@example.org>\nReferences: <1.7816b4079f42@example.org>\n")
+
+	// notification about the reply threaded with notification about the parent sent to the same recipient
+	req.Comment, req.parent = store.Comment{ID: "1000", ParentID: "999", Locator: req.Comment.Locator}, req.Comment
+	res, err = email.buildMessageFromRequest(req, "Parent@example.org", false)
+	require.NoError(t, err)
+	assert.Contains(t, res, "\nMessage-ID: <1000.7816b4079f42@example.org>\nIn-Reply-To: <999.7816b4079f42@example.org>\n")
+
+	res, err = email.buildMessageFromRequest(req, "admin@example.org", true)
+	require.NoError(t, err)
+	assert.Contains(t, res, ".admin@example.org>\n", "admin notification id differs")
+
+	req.Moderation, req.Comment.ID = ModerationApproved, "1000 /x"
+	res, err = email.buildMessageFromRequest(req, "parent@example.org", false)
+	require.NoError(t, err)
+	assert.Contains(t, res, "\nMessage-ID: <1000--x.7816b4079f42.approved@example.org>\n")
+	assert.NotContains(t, res, "In-Reply-To:", "moderation not threaded")
+
+	email.ReplyAddressFn = func(store.User, string, store.Comment) (string, error) { return "reply@example.org", nil }
+	req.Moderation = ""
+	res, err = email.buildMessageFromRequest(req, "parent@example.org", false)
+	require.NoError(t, err)
+	assert.Contains(t, res, "\nReply-To: reply@example.org\n", "reply address overrides custom header")
+	assert.NotContains(t, res, "noreply@example.org")
+
+	res, err = email.buildVerificationMessage(VerificationRequest{SiteID: "remark", User: "u1", Email: "u1@example.org"})
+	require.NoError(t, err)
+	assert.Contains(t, res, "\nX-Mailer: remark42\n")
+	assert.NotContains(t, res, "Message-ID:")
+
+	for _, headers := range []map[string]string{{"Subject": "x"}, {"message-id": "x"}, {"X-Bad Name": "x"},
+		{"X-Bad:": "x"}, {"X-Value": "x\r\nBcc: a@example.org"}, {"": "x"}} {
+		_, err = NewEmail(EmailParams{Headers: headers}, SMTPParams{})
+		assert.Error(t, err, "%v rejected", headers)
+	}
+}
+
+func TestEmail_SubjectTemplate(t *testing.T) {
+	email, err := NewEmail(EmailParams{
+		From:                     "from@example.org",
+		VerificationTemplatePath: "testdata/verification.html.tmpl",
+		MsgTemplatePath:          "testdata/msg.html.tmpl",
+		TokenGenFn:               TokenGenFn,
+		SubjectTemplate: `{{if .ForAdmin}}Новый комментарий{{if .Pending}} ждёт модерации{{end}}
+			{{else if .Moderation}}{{.Default}}{{else}}Ответ от {{.UserName}}{{end}}{{with .PostTitle}} к «{{.}}»{{end}}`,
+	}, SMTPParams{})
+	require.NoError(t, err)
+	req := Request{
+		Comment: store.Comment{ID: "999", ParentID: "1", User: store.User{ID: "1", Name: "test_user"}, PostTitle: "Пост",
+			Locator: store.Locator{SiteID: "remark", URL: "https://example.com/post"}},
+		parent: store.Comment{ID: "1", User: store.User{ID: "parent_user"}},
+	}
+	subject := func(res string) string {
+		msg, e := parseEmailMessage(res)
+		require.NoError(t, e)
+		return msg.subject
+	}
+
+	res, err := email.buildMessageFromRequest(req, "parent@example.org", false)
+	require.NoError(t, err)
+	assert.Equal(t, "Ответ от test_user к «Пост»", subject(res))
+
+	req.Comment.Pending = true
+	res, err = email.buildMessageFromRequest(req, "admin@example.org", true)
+	require.NoError(t, err)
+	assert.Equal(t, "Новый комментарий ждёт модерации к «Пост»", subject(res), "spaces of multiline template collapsed")
+
+	req.Moderation = ModerationApproved
+	res, err = email.buildMessageFromRequest(req, "parent@example.org", false)
+	require.NoError(t, err)
+	assert.Equal(t, `Your comment was approved for "Пост" к «Пост»`, subject(res), "default subject available")
+
+	_, err = NewEmail(EmailParams{SubjectTemplate: "{{.Bad"}, SMTPParams{})
+	assert.EqualError(t, err, `can't parse subject template: template: subject:1: unclosed action`)
+	email.subjectTmpl = texttemplate.Must(texttemplate.New("subject").Parse("{{.Unknown}}"))
+	res, err = email.buildMessageFromRequest(req, "parent@example.org", false)
+	require.NoError(t, err)
+	assert.Equal(t, `Your comment was approved for "Пост"`, subject(res), "default subject on template error")
+}
+
+func TestEmail_ActionMarkup(t *testing.T) {
+	email, err := NewEmail(EmailParams{From: "from@example.org", TokenGenFn: TokenGenFn, ActionMarkup: true,
+		MsgTemplatePath: "testdata/msg.html.tmpl", VerificationTemplatePath: "testdata/verification.html.tmpl"}, SMTPParams{})
+	require.NoError(t, err)
+	req := Request{
+		Comment: store.Comment{ID: "999", ParentID: "1", User: store.User{ID: "1", Name: "test_user"},
+			Locator: store.Locator{SiteID: "remark", URL: "https://example.com/post?a=1&b=</script>"}},
+		parent: store.Comment{ID: "1", User: store.User{ID: "parent_user"}},
+	}
+	res, err := email.buildMessageFromRequest(req, "parent@example.org", false)
+	require.NoError(t, err)
+	msg, err := parseEmailMessage(res)
+	require.NoError(t, err)
+	assert.Contains(t, msg.body, `<script type="application/ld+json">{"@context":"http://schema.org","@type":"EmailMessage",`+
+		`"description":"View comment","potentialAction":{"@type":"ViewAction",`+
+		`"url":"https://example.com/post?a=1\u0026b=\u003c/script\u003e#remark42__comment-999","name":"View comment"}}</script>`, "link escaped")
+
+	req.Moderation = ModerationDeleted
+	res, err = email.buildMessageFromRequest(req, "parent@example.org", false)
+	require.NoError(t, err)
+	assert.NotContains(t, res, "ViewAction", "no action for deleted comment")
+
+	email.ActionMarkup = false
+	req.Moderation = ""
+	res, err = email.buildMessageFromRequest(req, "parent@example.org", false)
+	require.NoError(t, err)
+	assert.NotContains(t, res, "ViewAction")
+
+	assert.Equal(t, "<p>text</p>", addActionMarkup("<p>text</p>", ""))
+	assert.Contains(t, addActionMarkup("<p>text</p>", "https://example.com"), "<p>text</p><script ")
+	assert.Contains(t, addActionMarkup("<body><p>text</p></BODY>", "https://example.com"), "}}</script>\n</BODY>",
+		"added before closing body")
+}
+
+func TestEmail_SendModeration(t *testing.T) {
+	email, err := NewEmail(EmailParams{
+		From:                     "from@example.org",
+		VerificationTemplatePath: "testdata/verification.html.tmpl",
+		MsgTemplatePath:          "testdata/msg.html.tmpl",
+		AdminEmails:              []string{"admin@example.org"},
+		UnsubscribeURL:           "https://remark42.com/api/v1/email/unsubscribe",
+	}, SMTPParams{})
+	require.NoError(t, err)
+	fakeSMTP := fakeTestSMTP{}
+	email.smtp = &fakeSMTP
+	email.TokenGenFn = TokenGenFn
+
+	req := Request{
+		Comment:    store.Comment{ID: "999", User: store.User{ID: "1", Name: "test_user"}, PostTitle: "test_title"},
+		Emails:     []string{"test@example.org"},
+		Moderation: ModerationDeleted,
+		Reason:     "off-topic",
+	}
+	assert.NoError(t, email.Send(context.TODO(), req))
+	assert.Equal(t, 1, fakeSMTP.readQuitCount(), "sent to the author only, no admin copy")
+	assert.Equal(t, "test@example.org", fakeSMTP.readRcpt())
+	assert.Equal(t, []string{"test@example.org"}, email.recipients(req))
+
+	res, err := email.buildMessageFromRequest(req, req.Emails[0], false)
+	require.NoError(t, err)
+	assert.Contains(t, res, `Subject: Your comment was removed for "test_title"`)
+	assert.Contains(t, res, "Your comment to =C2=ABtest_title=C2=BB was deleted")
+	assert.Contains(t, res, "Reason: off-topic")
+	assert.NotContains(t, res, "List-Unsubscribe")
+	assert.Contains(t, res, "test@example.org=20\r\n", "no parent user in footer")
+
+	req.Moderation, req.Reason = ModerationApproved, ""
+	res, err = email.buildMessageFromRequest(req, req.Emails[0], false)
+	require.NoError(t, err)
+	assert.Contains(t, res, `Subject: Your comment was approved for "test_title"`)
+	assert.NotContains(t, res, "Reason:")
 }
 
 func TestEmail_SendWithUnicodeInSubject(t *testing.T) {
@@ -282,6 +769,63 @@ List-Unsubscribe: <https://remark42.com/api/v1/email/unsubscribe?site=&tkn=token
 Date: `)
 }
 
+func TestEmail_EscapeUserFields(t *testing.T) {
+	email, err := NewEmail(EmailParams{
+		From:                     "from@example.org",
+		VerificationTemplatePath: "../../templates/email_confirmation_subscription.html.tmpl",
+		MsgTemplatePath:          "../../templates/email_reply.html.tmpl",
+		SubscribeURL:             "https://example.com/subscribe.html?token=",
+	}, SMTPParams{})
+	require.NoError(t, err)
+	email.TokenGenFn = TokenGenFn
+
+	// decode quoted-printable body of the message
+	body := func(msg string) string {
+		parts := strings.SplitN(msg, "\n\n", 2)
+		require.Len(t, parts, 2)
+		res, err := ioutil.ReadAll(quotedprintable.NewReader(strings.NewReader(parts[1])))
+		require.NoError(t, err)
+		return string(res)
+	}
+
+	req := Request{
+		Comment: store.Comment{ID: "999", ParentID: "1", Text: `<p>reply with <a href="https://example.com">link</a></p>`,
+			PostTitle: `title</div><style>body{display:none}</style>`,
+			User: store.User{ID: "1", Name: `<script>alert("name")</script>`,
+				Picture: `javascript:alert(1)`}},
+		parent: store.Comment{ID: "1", Text: "<p>parent</p>",
+			User: store.User{ID: "2", Name: `"><img src=x onerror=alert(1)>`, Picture: `" onload="alert(1)`}},
+		Emails: []string{"test@example.org"},
+	}
+	res, err := email.buildMessageFromRequest(req, req.Emails[0], false)
+	require.NoError(t, err)
+	html := body(res)
+	assert.Contains(t, html, `<p>reply with <a href="https://example.com">link</a></p>`, "sanitized comment kept as is")
+	assert.Contains(t, html, "<p>parent</p>")
+	assert.NotContains(t, html, "<script>")
+	assert.Contains(t, html, "&lt;script&gt;alert(&#34;name&#34;)&lt;/script&gt;")
+	assert.NotContains(t, html, "<style>")
+	assert.Contains(t, html, "title&lt;/div&gt;&lt;style&gt;")
+	assert.NotContains(t, html, "<img src=x")
+	assert.Contains(t, html, "&#34;&gt;&lt;img src=x onerror=alert(1)&gt;")
+	assert.NotContains(t, html, `src="javascript:`, "unsafe url filtered")
+	assert.NotContains(t, html, `" onload="`)
+
+	req.Moderation, req.Reason = ModerationRejected, `<b>bold</b> reason`
+	res, err = email.buildMessageFromRequest(req, req.Emails[0], false)
+	require.NoError(t, err)
+	html = body(res)
+	assert.Contains(t, html, "Reason: &lt;b&gt;bold&lt;/b&gt; reason")
+
+	fakeSMTP := fakeTestSMTP{}
+	email.smtp = &fakeSMTP
+	require.NoError(t, email.SendVerification(context.TODO(),
+		VerificationRequest{SiteID: "remark", User: `<a href="https://evil.example.com">user</a>`, Email: "test@example.org", Token: "tkn"}))
+	html = body(fakeSMTP.buff.String())
+	assert.NotContains(t, html, `<a href="https://evil.example.com">`)
+	assert.Contains(t, html, "&lt;a href=&#34;https://evil.example.com&#34;&gt;user&lt;/a&gt;")
+}
+
 func TestEmail_SendVerification(t *testing.T) {
 	email, err := NewEmail(EmailParams{
 		From:                     "from@example.org",
@@ -317,7 +861,7 @@ func TestEmail_SendVerification(t *testing.T) {
 	assert.EqualError(t, email.SendVerification(ctx, req), "sending message to \"test_username\" aborted due to canceled context")
 
 	// test buildVerificationMessage separately for message text
-	res, err := email.buildVerificationMessage(req.User, req.Email, req.Token, req.SiteID)
+	res, err := email.buildVerificationMessage(req)
 	assert.NoError(t, err)
 	assert.Contains(t, res, `From: from@example.org
 To: test@example.org
@@ -329,7 +873,7 @@ Date: `)
 	assert.Contains(t, res, `secret_`)
 	assert.NotContains(t, res, `https://example.org/`)
 	email.SubscribeURL = "https://example.org/subscribe.html?token="
-	res, err = email.buildVerificationMessage(req.User, req.Email, req.Token, req.SiteID)
+	res, err = email.buildVerificationMessage(req)
 	assert.NoError(t, err)
 	assert.Contains(t, res, `From: from@example.org
 To: test@example.org
@@ -341,6 +885,49 @@ Date: `)
 	assert.Contains(t, res, `https://example.org/subscribe.html?token=3Dsecret_`)
 }
 
+func TestEmail_SendDeletionConfirmation(t *testing.T) {
+	email, err := NewEmail(EmailParams{
+		From:                     "from@example.org",
+		VerificationTemplatePath: "testdata/verification.html.tmpl",
+		MsgTemplatePath:          "testdata/msg.html.tmpl",
+		SubscribeURL:             "https://example.org/subscribe.html?token=",
+	}, SMTPParams{})
+	require.NoError(t, err)
+	req := VerificationRequest{SiteID: "remark", User: "test_username", Email: "test@example.org", Token: "secret_",
+		DeletionURL: "https://example.org/api/v1/account/delete/confirm"}
+	res, err := email.buildVerificationMessage(req)
+	require.NoError(t, err)
+	assert.Contains(t, res, "Subject: Account deletion\n")
+	assert.Contains(t, res, "Confirm url: https://example.org/api/v1/account/delete/confirm")
+	assert.NotContains(t, res, "subscribe.html")
+}
+
+func TestEmail_SendEmailChange(t *testing.T) {
+	email, err := NewEmail(EmailParams{
+		From:                     "from@example.org",
+		VerificationTemplatePath: "testdata/verification.html.tmpl",
+		MsgTemplatePath:          "testdata/msg.html.tmpl",
+		SubscribeURL:             "https://example.org/subscribe.html?token=",
+	}, SMTPParams{})
+	require.NoError(t, err)
+	req := VerificationRequest{SiteID: "remark", User: "test_username", Email: "old@example.org", NewEmail: "new@example.org"}
+	res, err := email.buildVerificationMessage(req)
+	require.NoError(t, err)
+	assert.Contains(t, res, "Subject: Email address changed\n")
+	assert.Contains(t, res, "To: old@example.org\n")
+	assert.Contains(t, res, "Email of test_username on site remark changed to new@example.org")
+	assert.NotContains(t, res, "Token:")
+
+	// default template
+	email, err = NewEmail(EmailParams{From: "from@example.org", MsgTemplatePath: "testdata/msg.html.tmpl",
+		VerificationTemplatePath: "../../templates/email_confirmation_subscription.html.tmpl"}, SMTPParams{})
+	require.NoError(t, err)
+	res, err = email.buildVerificationMessage(req)
+	require.NoError(t, err)
+	assert.Contains(t, res, "changed to <b>new@example.org</b>")
+	assert.NotContains(t, res, "TOKEN")
+}
+
 func Test_emailClient_Create(t *testing.T) {
 	creator := emailClient{}
 	client, err := creator.Create(SMTPParams{})
@@ -445,3 +1032,164 @@ type nopCloser struct {
 func (nopCloser) Close() error {
 	return nil
 }
+
+func TestEmail_SendBounced(t *testing.T) {
+	email, err := NewEmail(EmailParams{
+		From:                     "from@example.org",
+		VerificationTemplatePath: "testdata/verification.html.tmpl",
+		MsgTemplatePath:          "testdata/msg.html.tmpl",
+		AdminEmails:              []string{"admin@example.org"},
+		TokenGenFn:               TokenGenFn,
+	}, SMTPParams{})
+	require.NoError(t, err)
+	fakeSMTP := fakeTestSMTP{}
+	email.smtp = &fakeSMTP
+	email.Bounces = &fakeBounces{bounced: map[string]bool{"site!!test@example.org": true}}
+
+	req := Request{
+		Comment: store.Comment{ID: "999", User: store.User{ID: "1", Name: "test_user"}, Locator: store.Locator{SiteID: "site"}},
+		Emails:  []string{"test@example.org"},
+	}
+	assert.NoError(t, email.Send(context.TODO(), req))
+	assert.Equal(t, 1, fakeSMTP.readQuitCount(), "sent to admin only")
+	assert.Equal(t, "admin@example.org", fakeSMTP.readRcpt())
+
+	err = email.SendVerification(context.TODO(), VerificationRequest{SiteID: "site", User: "u1", Email: "test@example.org", Token: "t"})
+	assert.EqualError(t, err, `verification for u1 not sent, email "test@example.org" bounced`)
+	assert.Equal(t, 1, fakeSMTP.readQuitCount(), "nothing sent")
+}
+
+type fakeBounces struct {
+	BounceStore
+	bounced map[string]bool
+}
+
+func (f *fakeBounces) IsBounced(siteID, email string) bool { return f.bounced[siteID+"!!"+email] }
+
+func TestEmail_SendDeliveryLog(t *testing.T) {
+	email, err := NewEmail(EmailParams{
+		From:                     "from@example.org",
+		VerificationTemplatePath: "testdata/verification.html.tmpl",
+		MsgTemplatePath:          "testdata/msg.html.tmpl",
+		AdminEmails:              []string{"admin@example.org"},
+		TokenGenFn:               TokenGenFn,
+	}, SMTPParams{})
+	require.NoError(t, err)
+	assert.False(t, logsDeliveries(email))
+	fakeSMTP := fakeTestSMTP{}
+	email.smtp = &fakeSMTP
+	deliveries := &fakeDeliveries{delivered: map[string]bool{"site!!999!!admin@example.org": true}}
+	email.Deliveries = deliveries
+	assert.True(t, logsDeliveries(email))
+
+	req := Request{
+		Comment: store.Comment{ID: "999", User: store.User{ID: "1", Name: "test_user"}, Locator: store.Locator{SiteID: "site"}},
+		Emails:  []string{"test@example.org"},
+	}
+	assert.NoError(t, email.Send(context.TODO(), req))
+	assert.Equal(t, 1, fakeSMTP.readQuitCount(), "sent to user only, admin notified already")
+	assert.Equal(t, "test@example.org", fakeSMTP.readRcpt())
+	assert.True(t, deliveries.delivered["site!!999!!test@example.org"], "delivery recorded")
+
+	assert.NoError(t, email.Send(context.TODO(), req))
+	assert.Equal(t, 1, fakeSMTP.readQuitCount(), "nothing sent again")
+}
+
+type fakeDeliveries struct {
+	DeliveryLog
+	delivered map[string]bool
+}
+
+func (f *fakeDeliveries) Add(siteID, commentID, email string) error {
+	f.delivered[siteID+"!!"+commentID+"!!"+email] = true
+	return nil
+}
+
+func (f *fakeDeliveries) IsDelivered(siteID, commentID, email string) bool {
+	return f.delivered[siteID+"!!"+commentID+"!!"+email]
+}
+
+func TestEmail_SendMessageFailover(t *testing.T) {
+	email, err := NewEmail(EmailParams{From: "from@example.org", VerificationTemplatePath: "testdata/verification.html.tmpl",
+		MsgTemplatePath: "testdata/msg.html.tmpl"}, SMTPParams{Host: "primary", Port: 25,
+		Fallback: []SMTPParams{{Host: "backup1", Port: 587}, {Host: "backup2", Port: 2525, TimeOut: time.Second}}})
+	require.NoError(t, err)
+	assert.Equal(t, defaultEmailTimeout, email.Fallback[0].TimeOut, "timeout of primary server used by default")
+	assert.Equal(t, time.Second, email.Fallback[1].TimeOut)
+	assert.Equal(t, `email: from "from@example.org" with username '' at server primary:25 and 2 fallback servers`,
+		email.String())
+
+	fakeSMTP := &fakeFailoverSMTP{fail: map[string]bool{"primary": true}}
+	email.smtp = fakeSMTP
+	require.NoError(t, email.sendMessage(emailMessage{from: "from@example.org", to: "to@example.org", message: "msg"}))
+	assert.Equal(t, []string{"primary", "backup1"}, fakeSMTP.used, "failed over to the first fallback")
+	assert.Equal(t, "to@example.org", fakeSMTP.clients["backup1"].readRcpt())
+
+	fakeSMTP = &fakeFailoverSMTP{fail: map[string]bool{"primary": true, "backup1": true}}
+	email.smtp = fakeSMTP
+	require.NoError(t, email.sendMessage(emailMessage{from: "from@example.org", to: "to@example.org", message: "msg"}))
+	assert.Equal(t, []string{"primary", "backup1", "backup2"}, fakeSMTP.used)
+
+	fakeSMTP = &fakeFailoverSMTP{fail: map[string]bool{"primary": true, "backup1": true, "backup2": true}}
+	email.smtp = fakeSMTP
+	err = email.sendMessage(emailMessage{from: "from@example.org", to: "to@example.org", message: "msg"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "3 errors occurred")
+	assert.Contains(t, err.Error(), "smtp server backup2:2525: failed to make smtp Create: failed to create client")
+
+	// send failure, not only connection one, causes failover
+	fakeSMTP = &fakeFailoverSMTP{fail: map[string]bool{}, rcptFail: map[string]bool{"primary": true}}
+	email.smtp = fakeSMTP
+	require.NoError(t, email.sendMessage(emailMessage{from: "from@example.org", to: "to@example.org", message: "msg"}))
+	assert.Equal(t, []string{"primary", "backup1"}, fakeSMTP.used)
+	assert.Equal(t, "msg", fakeSMTP.clients["backup1"].buff.String())
+}
+
+// fakeFailoverSMTP makes fakeTestSMTP client per server, failing to connect to servers from fail
+// and to accept recipient by servers from rcptFail
+type fakeFailoverSMTP struct {
+	fail, rcptFail map[string]bool
+	used           []string
+	clients        map[string]*fakeTestSMTP
+}
+
+func (f *fakeFailoverSMTP) Create(params SMTPParams) (smtpClient, error) {
+	f.used = append(f.used, params.Host)
+	if f.fail[params.Host] {
+		return nil, errors.New("failed to create client")
+	}
+	if f.clients == nil {
+		f.clients = map[string]*fakeTestSMTP{}
+	}
+	f.clients[params.Host] = &fakeTestSMTP{fail: map[string]bool{"rcpt": f.rcptFail[params.Host]}}
+	return f.clients[params.Host], nil
+}
+
+func TestEmail_SetSMTP(t *testing.T) {
+	email, err := NewEmail(EmailParams{From: "from@example.org", VerificationTemplatePath: "testdata/verification.html.tmpl",
+		MsgTemplatePath: "testdata/msg.html.tmpl"}, SMTPParams{Host: "primary", Port: 25,
+		Fallback: []SMTPParams{{Host: "backup1", Port: 587}}})
+	require.NoError(t, err)
+
+	fakeSMTP := &fakeFailoverSMTP{fail: map[string]bool{"bad": true}}
+	email.smtp = fakeSMTP
+	err = email.TestSMTP(SMTPParams{Host: "bad", Port: 25})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "can't connect to smtp server bad:25")
+	require.NoError(t, email.TestSMTP(SMTPParams{Host: "new", Port: 587}))
+	assert.Equal(t, 1, fakeSMTP.clients["new"].quitCount, "connection closed after check")
+	assert.Equal(t, "primary", email.SMTP().Host, "not switched by check")
+
+	email.SetSMTP(SMTPParams{Host: "new", Port: 587, Fallback: []SMTPParams{{Host: "backup2", Port: 2525}}})
+	assert.Equal(t, "new", email.SMTP().Host)
+	assert.Equal(t, defaultEmailTimeout, email.SMTP().TimeOut)
+	assert.Equal(t, defaultEmailTimeout, email.SMTP().Fallback[0].TimeOut)
+
+	fakeSMTP = &fakeFailoverSMTP{fail: map[string]bool{"new": true}}
+	email.smtp = fakeSMTP
+	require.NoError(t, email.sendMessage(emailMessage{from: "from@example.org", to: "to@example.org", message: "msg"}))
+	assert.Equal(t, []string{"new", "backup2"}, fakeSMTP.used, "switched servers used")
+
+	email.Sender = &fakeSender{}
+	assert.Error(t, email.TestSMTP(SMTPParams{Host: "new", Port: 587}), "smtp not used with sender")
+}
@@ -0,0 +1,57 @@
+package notify
+
+import (
+	"github.com/pkg/errors"
+)
+
+// FollowStore defines interface to keep users following comment authors. Followers of the author
+// notified about new comments of the author on the site.
+type FollowStore interface {
+	Follow(siteID, userID, authorID string) error
+	Unfollow(siteID, userID, authorID string) error
+	Following(siteID, userID string) ([]string, error)   // ids of authors followed by the user
+	Followers(siteID, authorID string) ([]string, error) // ids of users following the author
+}
+
+// Follower is a user following the comment author
+type Follower struct {
+	UserID string
+	Email  string
+}
+
+// MergeFollows moves follows of fromID to toID on the site, both authors followed by fromID and followers of it.
+// Follows between fromID and toID dropped, as user can't follow himself.
+func MergeFollows(st FollowStore, siteID, fromID, toID string) error {
+	following, err := st.Following(siteID, fromID)
+	if err != nil {
+		return errors.Wrapf(err, "can't get authors followed by %s", fromID)
+	}
+	for _, authorID := range following {
+		if err = st.Unfollow(siteID, fromID, authorID); err != nil {
+			return err
+		}
+		if authorID == toID {
+			continue
+		}
+		if err = st.Follow(siteID, toID, authorID); err != nil {
+			return err
+		}
+	}
+
+	followers, err := st.Followers(siteID, fromID)
+	if err != nil {
+		return errors.Wrapf(err, "can't get followers of %s", fromID)
+	}
+	for _, userID := range followers {
+		if err = st.Unfollow(siteID, userID, fromID); err != nil {
+			return err
+		}
+		if userID == toID {
+			continue
+		}
+		if err = st.Follow(siteID, userID, toID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
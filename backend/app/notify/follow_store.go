@@ -0,0 +1,80 @@
+package notify
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// EngineFollows implements FollowStore with records of store engine, follows kept along with comments of the site.
+// Each follow kept twice, by author and by follower, to list both followers of the author and authors followed by the user.
+type EngineFollows struct {
+	followers engine.Records // keyed by authorID!!userID
+	following engine.Records // keyed by userID!!authorID
+}
+
+// NewEngineFollows makes store of followers of comment authors kept by eng
+func NewEngineFollows(eng engine.Interface) *EngineFollows {
+	return &EngineFollows{
+		followers: engine.Records{Engine: eng, Kind: engine.Followers},
+		following: engine.Records{Engine: eng, Kind: engine.Following},
+	}
+}
+
+// Follow makes user a follower of the author, following again is not an error
+func (e *EngineFollows) Follow(siteID, userID, authorID string) error {
+	if siteID == "" || userID == "" || authorID == "" {
+		return errors.Errorf("site, user and author required to follow, %q, %q, %q", siteID, userID, authorID)
+	}
+	if userID == authorID {
+		return errors.Errorf("user %s can not follow himself", userID)
+	}
+	follow := struct {
+		Timestamp time.Time `json:"time"`
+	}{Timestamp: time.Now()}
+	if err := e.followers.Set(siteID, followKey(authorID, userID), follow); err != nil {
+		return errors.Wrapf(err, "can't add follower %s of %s", userID, authorID)
+	}
+	return errors.Wrapf(e.following.Set(siteID, followKey(userID, authorID), follow),
+		"can't add %s followed by %s", authorID, userID)
+}
+
+// Unfollow removes user from followers of the author
+func (e *EngineFollows) Unfollow(siteID, userID, authorID string) error {
+	found, err := e.followers.Delete(siteID, followKey(authorID, userID))
+	if err != nil {
+		return errors.Wrapf(err, "can't remove follower %s of %s", userID, authorID)
+	}
+	if !found {
+		return errors.Errorf("user %s doesn't follow %s", userID, authorID)
+	}
+	_, err = e.following.Delete(siteID, followKey(userID, authorID))
+	return errors.Wrapf(err, "can't remove %s followed by %s", authorID, userID)
+}
+
+// Following returns ids of authors followed by the user
+func (e *EngineFollows) Following(siteID, userID string) ([]string, error) {
+	return e.list(e.following, siteID, userID)
+}
+
+// Followers returns ids of users following the author
+func (e *EngineFollows) Followers(siteID, authorID string) ([]string, error) {
+	return e.list(e.followers, siteID, authorID)
+}
+
+// list returns last parts of keys with id!! prefix
+func (e *EngineFollows) list(records engine.Records, siteID, id string) ([]string, error) {
+	res := []string{}
+	prefix := followKey(id, "")
+	err := records.List(siteID, prefix, func(key string, _ func(v interface{}) error) error {
+		res = append(res, key[len(prefix):])
+		return nil
+	})
+	return res, errors.Wrapf(err, "can't list %s of %s", records.Kind, id)
+}
+
+func followKey(id, otherID string) string {
+	return id + "!!" + otherID
+}
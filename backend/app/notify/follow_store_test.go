@@ -0,0 +1,79 @@
+package notify
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+func TestEngineFollows(t *testing.T) {
+	b := NewEngineFollows(prepEngine(t))
+
+	require.NoError(t, b.Follow("site1", "u1", "author1"))
+	require.NoError(t, b.Follow("site1", "u1", "author1"), "follow again")
+	require.NoError(t, b.Follow("site1", "u2", "author1"))
+	require.NoError(t, b.Follow("site1", "u1", "author2"))
+	require.NoError(t, b.Follow("site2", "u3", "author1"))
+	assert.EqualError(t, b.Follow("site1", "u1", "u1"), "user u1 can not follow himself")
+	assert.Error(t, b.Follow("site1", "u1", ""))
+
+	followers, err := b.Followers("site1", "author1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"u1", "u2"}, followers)
+	following, err := b.Following("site1", "u1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"author1", "author2"}, following)
+	followers, err = b.Followers("site2", "author1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"u3"}, followers)
+
+	require.NoError(t, b.Unfollow("site1", "u1", "author1"))
+	assert.EqualError(t, b.Unfollow("site1", "u1", "author1"), "user u1 doesn't follow author1")
+	followers, err = b.Followers("site1", "author1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"u2"}, followers)
+	following, err = b.Following("site1", "u1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"author2"}, following)
+
+	following, err = b.Following("site1", "nobody")
+	require.NoError(t, err)
+	assert.Equal(t, []string{}, following)
+}
+
+func TestMergeFollows(t *testing.T) {
+	b := NewEngineFollows(prepEngine(t))
+
+	require.NoError(t, b.Follow("site1", "old", "author1"))
+	require.NoError(t, b.Follow("site1", "old", "new"))
+	require.NoError(t, b.Follow("site1", "new", "author2"))
+	require.NoError(t, b.Follow("site1", "u1", "old"))
+	require.NoError(t, b.Follow("site1", "new", "old"))
+	require.NoError(t, b.Follow("site2", "u2", "old"))
+
+	require.NoError(t, MergeFollows(b, "site1", "old", "new"))
+	following, err := b.Following("site1", "new")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"author1", "author2"}, following, "follow of itself dropped")
+	followers, err := b.Followers("site1", "new")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"u1"}, followers)
+	following, err = b.Following("site1", "old")
+	require.NoError(t, err)
+	assert.Empty(t, following)
+	followers, err = b.Followers("site1", "old")
+	require.NoError(t, err)
+	assert.Empty(t, followers)
+	followers, err = b.Followers("site2", "old")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"u2"}, followers, "other site not changed")
+}
+
+func prepEngine(t *testing.T) engine.Interface {
+	eng, err := engine.NewMemory("", "remark", "site1", "site2", "site3")
+	require.NoError(t, err)
+	return eng
+}
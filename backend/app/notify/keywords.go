@@ -0,0 +1,66 @@
+package notify
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// KeywordMatcher finds watched keywords and phrases in comment text. Matching is case-insensitive
+// and respects word boundaries, i.e. keyword "law" doesn't match "lawyer".
+type KeywordMatcher struct {
+	keywords []string
+}
+
+// NewKeywordMatcher makes matcher for given keywords, empty keywords ignored
+func NewKeywordMatcher(keywords []string) *KeywordMatcher {
+	res := KeywordMatcher{}
+	seen := map[string]bool{}
+	for _, k := range keywords {
+		k = strings.ToLower(strings.Join(strings.Fields(k), " "))
+		if k == "" || seen[k] {
+			continue
+		}
+		seen[k] = true
+		res.keywords = append(res.keywords, k)
+	}
+	return &res
+}
+
+// Match returns all keywords found in the text, in order of watchlist
+func (m *KeywordMatcher) Match(text string) (res []string) {
+	if m == nil || len(m.keywords) == 0 {
+		return nil
+	}
+	text = strings.ToLower(strings.Join(strings.Fields(text), " "))
+	for _, k := range m.keywords {
+		if containsWord(text, k) {
+			res = append(res, k)
+		}
+	}
+	return res
+}
+
+// containsWord checks if text has word surrounded by non-letters or text boundaries
+func containsWord(text, word string) bool {
+	for start := 0; start < len(text); {
+		pos := strings.Index(text[start:], word)
+		if pos < 0 {
+			return false
+		}
+		pos += start
+		end := pos + len(word)
+		before, _ := utf8.DecodeLastRuneInString(text[:pos])
+		after, _ := utf8.DecodeRuneInString(text[end:])
+		if (pos == 0 || !isWordRune(before)) && (end == len(text) || !isWordRune(after)) {
+			return true
+		}
+		_, size := utf8.DecodeRuneInString(text[pos:])
+		start = pos + size
+	}
+	return false
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
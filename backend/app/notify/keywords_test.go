@@ -0,0 +1,33 @@
+package notify
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeywordMatcher_Match(t *testing.T) {
+	m := NewKeywordMatcher([]string{"Acme", "law suit", "", "acme", "Ремарк", "c++"})
+	assert.Equal(t, []string{"acme", "law suit", "ремарк", "c++"}, m.keywords, "normalized and deduplicated")
+
+	tbl := []struct {
+		text string
+		res  []string
+	}{
+		{"", nil},
+		{"nothing interesting here", nil},
+		{"I like ACME products", []string{"acme"}},
+		{"acme, acmeish and acme's", []string{"acme"}},
+		{"acmeish only", nil},
+		{"going to file a Law\n  Suit against", []string{"law suit"}},
+		{"lawsuit", nil},
+		{"про ремарк42 и Ремарк!", []string{"ремарк"}},
+		{"(acme) and c++", []string{"acme", "c++"}},
+	}
+	for _, tt := range tbl {
+		assert.Equal(t, tt.res, m.Match(tt.text), tt.text)
+	}
+
+	var nilMatcher *KeywordMatcher
+	assert.Nil(t, nilMatcher.Match("acme"))
+}
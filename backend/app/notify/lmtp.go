@@ -0,0 +1,119 @@
+package notify
+
+import (
+	"io"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// lmtpClient implements smtpClient for delivery to co-located MTA with LMTP (RFC 2033).
+// net/smtp can't be used for it as it greets server with EHLO instead of LHLO.
+type lmtpClient struct {
+	text  *textproto.Conn
+	rcpts int // recipients of the current transaction, LMTP server replies for each one after DATA
+}
+
+// newLMTPClient reads server greeting and introduces itself with LHLO, connection closed on error
+func newLMTPClient(conn net.Conn, host string) (*lmtpClient, error) {
+	if host == "" {
+		host = "localhost"
+	}
+	c := &lmtpClient{text: textproto.NewConn(conn)}
+	if _, _, err := c.text.ReadResponse(220); err != nil {
+		_ = c.text.Close()
+		return nil, errors.Wrap(err, "bad lmtp greeting")
+	}
+	if err := c.cmd(250, "LHLO %s", host); err != nil {
+		_ = c.text.Close()
+		return nil, errors.Wrap(err, "lhlo rejected")
+	}
+	return c, nil
+}
+
+// Mail starts transaction with the sender address
+func (c *lmtpClient) Mail(from string) error {
+	if err := validateLine(from); err != nil {
+		return err
+	}
+	c.rcpts = 0
+	return c.cmd(250, "MAIL FROM:<%s>", from)
+}
+
+// Rcpt adds recipient to the transaction
+func (c *lmtpClient) Rcpt(to string) error {
+	if err := validateLine(to); err != nil {
+		return err
+	}
+	if err := c.cmd(25, "RCPT TO:<%s>", to); err != nil {
+		return err
+	}
+	c.rcpts++
+	return nil
+}
+
+// Auth is not supported, local delivery doesn't need credentials
+func (c *lmtpClient) Auth(smtp.Auth) error {
+	return errors.New("auth not supported with lmtp")
+}
+
+// Data returns writer for message body, closing the writer checks delivery status of each recipient
+func (c *lmtpClient) Data() (io.WriteCloser, error) {
+	if err := c.cmd(354, "DATA"); err != nil {
+		return nil, err
+	}
+	return &lmtpDataWriter{WriteCloser: c.text.DotWriter(), client: c}, nil
+}
+
+// Quit sends QUIT command and closes connection
+func (c *lmtpClient) Quit() error {
+	if err := c.cmd(221, "QUIT"); err != nil {
+		return err
+	}
+	return c.text.Close()
+}
+
+// Close closes connection without QUIT
+func (c *lmtpClient) Close() error {
+	return c.text.Close()
+}
+
+func (c *lmtpClient) cmd(expectCode int, format string, args ...interface{}) error {
+	id, err := c.text.Cmd(format, args...)
+	if err != nil {
+		return err
+	}
+	c.text.StartResponse(id)
+	defer c.text.EndResponse(id)
+	_, _, err = c.text.ReadResponse(expectCode)
+	return err
+}
+
+type lmtpDataWriter struct {
+	io.WriteCloser
+	client *lmtpClient
+}
+
+// Close ends message body and reads per-recipient replies
+func (w *lmtpDataWriter) Close() error {
+	if err := w.WriteCloser.Close(); err != nil {
+		return err
+	}
+	for i := 0; i < w.client.rcpts; i++ {
+		if _, _, err := w.client.text.ReadResponse(250); err != nil {
+			return errors.Wrap(err, "message not delivered")
+		}
+	}
+	return nil
+}
+
+// validateLine checks address for line breaks, the same way net/smtp does
+func validateLine(line string) error {
+	if strings.ContainsAny(line, "\n\r") {
+		return errors.New("a line must not contain CR or LF")
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package notify
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmailClient_CreateLMTP(t *testing.T) {
+	srv := newFakeMTA(t, true)
+	defer srv.close()
+
+	creator := emailClient{}
+	client, err := creator.Create(SMTPParams{Socket: srv.socket, LMTP: true, Helo: "mail.example.com", TimeOut: time.Second})
+	require.NoError(t, err)
+	require.NoError(t, client.Mail("from@example.com"))
+	require.NoError(t, client.Rcpt("to@example.com"))
+	w, err := client.Data()
+	require.NoError(t, err)
+	_, err = w.Write([]byte("Subject: test\r\n\r\nbody\r\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	assert.Error(t, client.Auth(nil))
+	require.NoError(t, client.Quit())
+
+	assert.Equal(t, []string{"LHLO mail.example.com", "MAIL FROM:<from@example.com>", "RCPT TO:<to@example.com>",
+		"DATA", "Subject: test", "", "body", ".", "QUIT"}, srv.received())
+
+	assert.EqualError(t, (&lmtpClient{}).Mail("from@example.com\r\nRCPT TO:<x@example.com>"), "a line must not contain CR or LF")
+}
+
+func TestEmailClient_CreateHelo(t *testing.T) {
+	srv := newFakeMTA(t, false)
+	defer srv.close()
+
+	creator := emailClient{}
+	client, err := creator.Create(SMTPParams{Socket: srv.socket, Helo: "mail.example.com", TimeOut: time.Second})
+	require.NoError(t, err)
+	require.NoError(t, client.Mail("from@example.com"))
+	require.NoError(t, client.Quit())
+	assert.Equal(t, []string{"EHLO mail.example.com", "MAIL FROM:<from@example.com> BODY=8BITMIME", "QUIT"}, srv.received())
+
+	_, err = creator.Create(SMTPParams{Socket: srv.socket, TLS: true})
+	assert.EqualError(t, err, "tls can't be used with lmtp or unix socket")
+	_, err = creator.Create(SMTPParams{Socket: srv.socket + ".bad", LMTP: true})
+	assert.Error(t, err)
+}
+
+// fakeMTA serves single SMTP or LMTP connection on unix socket and records received lines
+type fakeMTA struct {
+	socket string
+	dir    string
+	ln     net.Listener
+	wg     sync.WaitGroup
+
+	lock  sync.Mutex
+	lines []string
+}
+
+func newFakeMTA(t *testing.T, lmtp bool) *fakeMTA {
+	dir, err := ioutil.TempDir("", "mta")
+	require.NoError(t, err)
+	res := &fakeMTA{dir: dir, socket: filepath.Join(dir, "mta.sock")}
+	res.ln, err = net.Listen("unix", res.socket)
+	require.NoError(t, err)
+
+	res.wg.Add(1)
+	go func() {
+		defer res.wg.Done()
+		conn, err := res.ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reply := func(s string) { _, _ = conn.Write([]byte(s + "\r\n")) }
+		reply("220 localhost ready")
+		scanner := bufio.NewScanner(conn)
+		inData := false
+		for scanner.Scan() {
+			line := scanner.Text()
+			res.lock.Lock()
+			res.lines = append(res.lines, line)
+			res.lock.Unlock()
+			switch {
+			case inData && line == ".":
+				inData = false
+				reply("250 2.0.0 delivered")
+			case inData:
+			case strings.HasPrefix(line, "LHLO") && lmtp, strings.HasPrefix(line, "EHLO") && !lmtp:
+				reply("250-localhost")
+				reply("250 8BITMIME")
+			case strings.HasPrefix(line, "DATA"):
+				inData = true
+				reply("354 go ahead")
+			case strings.HasPrefix(line, "QUIT"):
+				reply("221 bye")
+				return
+			case strings.HasPrefix(line, "MAIL"), strings.HasPrefix(line, "RCPT"):
+				reply("250 ok")
+			default:
+				reply("502 unknown command")
+			}
+		}
+	}()
+	return res
+}
+
+func (f *fakeMTA) received() []string {
+	f.wg.Wait()
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.lines
+}
+
+func (f *fakeMTA) close() {
+	_ = f.ln.Close()
+	f.wg.Wait()
+	_ = os.RemoveAll(f.dir)
+}
@@ -0,0 +1,55 @@
+package notify
+
+import (
+	"strings"
+	"time"
+)
+
+// Metrics defines interface to collect metrics of sent notifications
+type Metrics interface {
+	NotificationSent(destination string, err error)
+	EmailSent(duration time.Duration, err error)
+	BreakerChanged(destination string, open bool)
+}
+
+// metricsReporter implemented by destinations reporting metrics by themselves, i.e. sending later than called
+type metricsReporter interface {
+	setMetrics(m Metrics)
+}
+
+// SetMetrics sets collector of notification metrics, passed to destinations reporting own metrics.
+// Should be called before submitting any requests.
+func (s *Service) SetMetrics(m Metrics) {
+	s.metrics = m
+	for _, b := range s.breakers {
+		b.setMetrics(m)
+	}
+	for _, d := range s.destinations {
+		if mr, ok := d.(metricsReporter); ok {
+			mr.setMetrics(m)
+		}
+	}
+}
+
+// reportSent counts notification sent by destination, skipped for destinations reporting by themselves
+func (s *Service) reportSent(d Destination, err error) {
+	if s.metrics == nil {
+		return
+	}
+	if _, ok := d.(*Throttled); ok {
+		return // throttled destination reports on actual sending
+	}
+	s.metrics.NotificationSent(destinationName(d), err)
+}
+
+// destinationName returns short name of destination, like email or telegram
+func destinationName(d Destination) string {
+	if t, ok := d.(*Throttled); ok {
+		return destinationName(t.dest)
+	}
+	name := strings.Fields(d.String())
+	if len(name) == 0 {
+		return "unknown"
+	}
+	return strings.TrimSuffix(name[0], ":")
+}
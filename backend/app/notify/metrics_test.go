@@ -0,0 +1,83 @@
+package notify
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+type mockMetrics struct {
+	lock     sync.Mutex
+	sent     map[string]int
+	emails   int
+	breakers map[string]bool // last state of circuit by destination
+}
+
+func (m *mockMetrics) NotificationSent(destination string, _ error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.sent == nil {
+		m.sent = map[string]int{}
+	}
+	m.sent[destination]++
+}
+
+func (m *mockMetrics) EmailSent(time.Duration, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.emails++
+}
+
+func (m *mockMetrics) BreakerChanged(destination string, open bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.breakers == nil {
+		m.breakers = map[string]bool{}
+	}
+	m.breakers[destination] = open
+}
+
+func (m *mockMetrics) get(destination string) int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.sent[destination]
+}
+
+func TestService_Metrics(t *testing.T) {
+	d1 := &MockDest{id: 1}
+	th := NewThrottled(&MockDest{id: 2}, ThrottleParams{PerMinute: 100})
+	defer th.Close()
+	s := NewService(nil, 10, d1, th)
+	m := &mockMetrics{}
+	s.SetMetrics(m)
+
+	s.Submit(Request{Comment: store.Comment{ID: "c1"}})
+	s.Submit(Request{Comment: store.Comment{ID: "c2"}})
+	time.Sleep(100 * time.Millisecond)
+	s.Close()
+	assert.Equal(t, 4, m.get("mock"), "sent by both destinations, throttled reported on sending")
+}
+
+func TestEmail_Metrics(t *testing.T) {
+	email, err := NewEmail(EmailParams{From: "from@example.org", VerificationTemplatePath: "testdata/verification.html.tmpl",
+		MsgTemplatePath: "testdata/msg.html.tmpl"}, SMTPParams{})
+	require.NoError(t, err)
+	email.smtp = &fakeTestSMTP{}
+	m := &mockMetrics{}
+	email.setMetrics(m)
+	require.NoError(t, email.SendVerification(context.Background(),
+		VerificationRequest{SiteID: "remark", User: "u1", Email: "u1@example.com", Token: "some"}))
+	assert.Equal(t, 1, m.emails)
+}
+
+func TestDestinationName(t *testing.T) {
+	assert.Equal(t, "mock", destinationName(&MockDest{id: 1}))
+	assert.Equal(t, "mock", destinationName(NewThrottled(&MockDest{id: 1}, ThrottleParams{})))
+	assert.Equal(t, "telegram", destinationName(&Telegram{TelegramParams: TelegramParams{AdminChannelID: "chan"}}))
+}
@@ -3,13 +3,18 @@ package notify
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	log "github.com/go-pkgz/lgr"
+	"go.opentelemetry.io/otel/trace"
 
+	"github.com/umputun/remark42/backend/app/logging"
 	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/tracing"
 )
 
 // Service delivers notifications to multiple destinations
@@ -18,10 +23,33 @@ type Service struct {
 	destinations      []Destination
 	queue             chan Request
 	verificationQueue chan VerificationRequest
+	keywords          func(siteID string) []string
+	filter            func(req Request) bool
+	follows           FollowStore
+	usersEnabled      func(siteID string) bool
+	metrics           Metrics
+	admins            []string // emails of admins notified by their preferences
+	adminDefaults     AdminPrefs
+	adminPrefs        AdminPrefsStore
+	links             store.Links // canonical links of virtual locators
+	statuses          StatusStore // optional, keeps send statuses
+	breakers          map[Destination]*breaker
+	coalesceWindow    time.Duration // time notification about new comment held, replaced by later ones
+	pendingMu         sync.Mutex
+	pending           map[string]*pendingRequest // notifications held for coalescing, by site and comment id
 
-	closed uint32 // non-zero means closed. uses uint instead of bool for atomic
-	ctx    context.Context
-	cancel context.CancelFunc
+	workers      sync.WaitGroup
+	drainTimeout time.Duration // max time to send queued notifications on close, no limit if 0
+	lock         sync.RWMutex  // guards closing of queues
+	closed       uint32        // non-zero means closed. uses uint instead of bool for atomic
+	ctx          context.Context
+	cancel       context.CancelFunc
+}
+
+// DispatchParams defines workers sending queued notifications
+type DispatchParams struct {
+	Workers      int           // number of workers sending notifications concurrently, single worker if 0
+	DrainTimeout time.Duration // max time to send queued notifications on close, sending canceled after it
 }
 
 // Destination defines interface for a given destination service, like telegram, email and so on
@@ -42,18 +70,52 @@ type Request struct {
 	Comment     store.Comment
 	parent      store.Comment
 	Emails      []string
+	Keywords    []string          // watched keywords found in the comment, admin alert
+	Alert       string            // optional, reason of admin alert set by caller, i.e. matched moderation rule
+	Moderation  ModerationEvent   // moderation decision about the comment, sent to the comment author only
+	Reason      string            // optional reason of moderation decision
+	Followers   []Follower        // users following the comment author, not in Emails
+	follower    *Follower         // set for the copy of request sent to the follower
+	resend      bool              // sent again with Resend, only to destinations keeping delivery log
+	prepared    bool              // recipients already set, by Resend
+	Trace       trace.SpanContext // optional, span of the caller, spans of sending added to its trace
+	Log         logging.Fields    // optional, log fields of the caller's request, added to messages about sending
+	Approved    bool              // comment approved after being held for moderation, admins notified on hold
+	Edited      bool              // comment edited, updates notification about the comment held for coalescing only
+	AdminEmails []string          // admins notified by email according to their preferences
+	AdminChats  []string          // telegram chats of admins notified according to their preferences
+	skipShared  bool              // shared admin destinations not notified, request doesn't match default events
+	links       store.Links       // canonical links of virtual locators, set by service
+}
+
+// CommentLink returns canonical link to the comment of the request's post, empty if unknown for virtual locator
+func (r Request) CommentLink(commentID string) string {
+	return r.links.Comment(r.Comment.Locator, commentID)
 }
 
+// ModerationEvent defines moderation decision made about the comment
+type ModerationEvent string
+
+// enum of all moderation events
+const (
+	ModerationApproved ModerationEvent = "approved"
+	ModerationRejected ModerationEvent = "rejected"
+	ModerationDeleted  ModerationEvent = "deleted"
+)
+
 // VerificationRequest notification for user
 type VerificationRequest struct {
 	SiteID string
 	User   string
 	Email  string // if set, send email only
 	Token  string
+	// DeletionURL set for confirmation of account deletion instead of email verification
+	DeletionURL string
+	// NewEmail set for notice to the previous address about change of email instead of email verification
+	NewEmail string
 }
 
 const defaultQueueSize = 100
-const uiNav = "#remark42__comment-"
 
 // NewService makes notification service routing comments to all destinations.
 func NewService(dataService Store, size int, destinations ...Destination) *Service {
@@ -70,28 +132,213 @@ func NewService(dataService Store, size int, destinations ...Destination) *Servi
 		cancel:            cancel,
 	}
 	if len(destinations) > 0 {
+		res.workers.Add(1)
 		go res.do()
 	}
 	log.Printf("[INFO] create notifier service, queue size=%d, destinations=%d", size, len(destinations))
 	return &res
 }
 
-// Submit Request to internal channel if not busy, drop if can't send
+// WatchKeywords sets function returning keywords of the site triggering admin alerts, i.e. set by runtime settings.
+// Should be called before submitting any requests.
+func (s *Service) WatchKeywords(fn func(siteID string) []string) {
+	s.keywords = fn
+}
+
+// SetFilter sets function deciding if notification for a new comment should be sent, false drops notification.
+// Moderation events are not filtered. Should be called before submitting any requests.
+func (s *Service) SetFilter(filter func(req Request) bool) {
+	s.filter = filter
+}
+
+// SetFollowStore sets store of users following comment authors, followers get notifications about new comments
+// of the author. Should be called before submitting any requests.
+func (s *Service) SetFollowStore(follows FollowStore) {
+	s.follows = follows
+}
+
+// SetUsersEnabled sets function checking if users of the site get notifications, i.e. set by runtime settings.
+// Admin notifications are not affected. Should be called before submitting any requests.
+func (s *Service) SetUsersEnabled(fn func(siteID string) bool) {
+	s.usersEnabled = fn
+}
+
+// SetLinks sets templates of canonical links to threads of virtual locators, used in notifications instead of url.
+// Should be called before submitting any requests.
+func (s *Service) SetLinks(links store.Links) {
+	s.links = links
+}
+
+// SetDispatch starts additional workers sending queued notifications and sets drain timeout of Close.
+// Should be called once, before submitting any requests.
+func (s *Service) SetDispatch(params DispatchParams) {
+	if len(s.destinations) == 0 {
+		return
+	}
+	for i := 1; i < params.Workers; i++ {
+		s.workers.Add(1)
+		go s.do()
+	}
+	s.drainTimeout = params.DrainTimeout
+	log.Printf("[INFO] notifier workers=%d, drain timeout=%v", params.Workers, params.DrainTimeout)
+}
+
+// Submit Request to internal channel if not busy, drop if can't send. Recipients of the notification
+// resolved by workers, so the caller is never blocked by stores or destinations.
 func (s *Service) Submit(req Request) {
-	if len(s.destinations) == 0 || atomic.LoadUint32(&s.closed) != 0 {
+	if len(s.destinations) == 0 {
+		return
+	}
+	req.links = s.links
+	if s.coalesce(req) || req.Edited {
 		return
 	}
+	if err := s.push(req); err == ErrQueueFull {
+		logging.Printf(logging.WithFields(context.Background(), req.Log), "[WARN] can't send notification to queue, %+v", req.Comment)
+	}
+}
+
+// ErrQueueFull returned by Resend if notification can't be queued now and should be retried later
+var ErrQueueFull = errors.New("notifications queue is full")
+
+// Resend submits notification about the comment again, i.e. after outage of email server. Request sent only
+// to destinations keeping delivery log, recipients already notified skipped.
+func (s *Service) Resend(req Request) error {
+	if !s.CanResend() {
+		return errors.New("no notification destinations with delivery log")
+	}
+	if atomic.LoadUint32(&s.closed) != 0 {
+		return errServiceClosed
+	}
+	req.resend, req.links = true, s.links
+	req, ok := s.prepare(req)
+	if !ok {
+		return nil // dropped by filter, nothing to send
+	}
+	req.prepared = true
+	return s.push(req)
+}
+
+var errServiceClosed = errors.New("notification service closed")
+
+// push adds request to the queue, fails if the queue is full or the service closed
+func (s *Service) push(req Request) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if atomic.LoadUint32(&s.closed) != 0 {
+		return errServiceClosed
+	}
+	return s.enqueue(req)
+}
+
+// enqueue adds request to the queue, caller holds the lock
+func (s *Service) enqueue(req Request) error {
+	select {
+	case s.queue <- req:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Telegram returns telegram destination of admin notifications, nil if not set. Safe to call on nil Service
+func (s *Service) Telegram() *Telegram {
+	if s == nil {
+		return nil
+	}
+	for _, d := range s.destinations {
+		if t, ok := unwrap(d).(*Telegram); ok {
+			return t
+		}
+	}
+	return nil
+}
+
+// Email returns email destination, nil if not set. Safe to call on nil Service
+func (s *Service) Email() *Email {
+	if s == nil {
+		return nil
+	}
+	for _, d := range s.destinations {
+		if e, ok := unwrap(d).(*Email); ok {
+			return e
+		}
+	}
+	return nil
+}
+
+// unwrap returns destination wrapped by throttling, the destination itself if not throttled
+func unwrap(d Destination) Destination {
+	if t, ok := d.(*Throttled); ok {
+		return t.dest
+	}
+	return d
+}
+
+// CanResend checks if any destination keeps delivery log, i.e. notifications can be re-sent
+func (s *Service) CanResend() bool {
+	for _, d := range s.destinations {
+		if logsDeliveries(d) {
+			return true
+		}
+	}
+	return false
+}
+
+// prepare sets recipients of the new comment notification, false if notification dropped by filter
+func (s *Service) prepare(req Request) (Request, bool) {
+	text := req.Comment.Orig
+	if text == "" {
+		text = req.Comment.Text
+	}
+	req.Keywords = nil // set by service only
+	if s.keywords != nil {
+		req.Keywords = NewKeywordMatcher(s.keywords(req.Comment.Locator.SiteID)).Match(text)
+	}
+	if len(req.Keywords) > 0 {
+		log.Printf("[INFO] comment %s matched watched keywords %v", req.Comment.ID, req.Keywords)
+	}
+	if req.Alert != "" {
+		req.Keywords = append(req.Keywords, req.Alert) // reported by destinations the same way as keywords
+	}
 	if s.dataService != nil && req.Comment.ParentID != "" {
 		if p, err := s.dataService.Get(req.Comment.Locator, req.Comment.ParentID, store.User{}); err == nil {
 			req.parent = p
 			req.Emails = deduplicateStrings(s.getNotificationEmails(req, p))
 		}
 	}
-	select {
-	case s.queue <- req:
-	default:
-		log.Printf("[WARN] can't send notification to queue, %+v", req.Comment)
+	req.Followers = s.getFollowers(req)
+	// users notified about held comment on approval
+	if req.Comment.Pending || !s.isUsersEnabled(req.Comment.Locator.SiteID) {
+		req.Emails, req.Followers = nil, nil
+	}
+	s.routeAdmins(&req)
+	if s.filter != nil && !s.filter(req) {
+		log.Printf("[DEBUG] notification for comment %s dropped by filter", req.Comment.ID)
+		return req, false
+	}
+	return req, true
+}
+
+// prepareModeration sets the comment author as recipient of moderation event, false if author's email is unknown
+func (s *Service) prepareModeration(req Request) (Request, bool) {
+	if s.dataService == nil || !s.isUsersEnabled(req.Comment.Locator.SiteID) {
+		return req, false
+	}
+	email, err := s.dataService.GetUserEmail(req.Comment.Locator.SiteID, req.Comment.User.ID)
+	if err != nil {
+		log.Printf("[WARN] can't read email for %s, %v", req.Comment.User.ID, err)
 	}
+	if email == "" {
+		return req, false
+	}
+	req.Emails = []string{email}
+	return req, true
+}
+
+// isUsersEnabled checks if users of the site get notifications
+func (s *Service) isUsersEnabled(siteID string) bool {
+	return s.usersEnabled == nil || s.usersEnabled(siteID)
 }
 
 // getNotificationEmails returns list of emails for notifications for provided comment.
@@ -115,9 +362,42 @@ func (s *Service) getNotificationEmails(req Request, notifyComment store.Comment
 	return result
 }
 
+// getFollowers returns followers of the comment author with known emails, except those already notified about reply
+func (s *Service) getFollowers(req Request) (result []Follower) {
+	if s.follows == nil || s.dataService == nil {
+		return nil
+	}
+	ids, err := s.follows.Followers(req.Comment.Locator.SiteID, req.Comment.User.ID)
+	if err != nil {
+		log.Printf("[WARN] can't get followers of %s, %v", req.Comment.User.ID, err)
+		return nil
+	}
+	notified := map[string]bool{}
+	for _, email := range req.Emails {
+		notified[email] = true
+	}
+	for _, id := range ids {
+		email, err := s.dataService.GetUserEmail(req.Comment.Locator.SiteID, id)
+		if err != nil {
+			log.Printf("[WARN] can't read email for %s, %v", id, err)
+		}
+		if email == "" || notified[email] {
+			continue
+		}
+		notified[email] = true
+		result = append(result, Follower{UserID: id, Email: email})
+	}
+	return result
+}
+
 // SubmitVerification to internal channel if not busy, drop if can't send
 func (s *Service) SubmitVerification(req VerificationRequest) {
-	if len(s.destinations) == 0 || atomic.LoadUint32(&s.closed) != 0 {
+	if len(s.destinations) == 0 {
+		return
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if atomic.LoadUint32(&s.closed) != 0 {
 		return
 	}
 	select {
@@ -127,55 +407,145 @@ func (s *Service) SubmitVerification(req VerificationRequest) {
 	}
 }
 
-// Close queue channel and wait for completion
+// Close stops accepting requests and waits for queued notifications sent, up to drain timeout.
+// Messages queued by throttled destinations sent within the same timeout.
 func (s *Service) Close() {
+	s.lock.Lock()
+	if atomic.LoadUint32(&s.closed) != 0 {
+		s.lock.Unlock()
+		return
+	}
+	s.flushPending() // held notifications sent before closing
+	atomic.StoreUint32(&s.closed, 1)
+	s.lock.Unlock()
+
+	st := time.Now()
 	if s.queue != nil {
-		log.Print("[DEBUG] close notifier")
+		log.Printf("[DEBUG] close notifier, %d queued", len(s.queue)+len(s.verificationQueue))
 		close(s.queue)
 		close(s.verificationQueue)
+		s.drain()
+	}
+	for _, dest := range s.destinations {
+		if t, ok := dest.(*Throttled); ok && s.drainTimeout > 0 {
+			t.closeWithin(s.drainTimeout - time.Since(st)) // the rest of drain timeout, messages dropped if it's over
+			continue
+		}
+		if c, ok := dest.(interface{ Close() }); ok {
+			c.Close()
+		}
+	}
+	if s.cancel != nil {
 		s.cancel()
-		<-s.ctx.Done()
 	}
-	atomic.StoreUint32(&s.closed, 1)
 }
 
+// drain waits for workers sending queued notifications, cancels sending after drain timeout
+func (s *Service) drain() {
+	done := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(done)
+	}()
+	if s.drainTimeout <= 0 {
+		<-done
+		return
+	}
+	select {
+	case <-done:
+	case <-time.After(s.drainTimeout):
+		log.Printf("[WARN] queued notifications not sent in %v, canceled", s.drainTimeout)
+		s.cancel()
+		<-done
+	}
+}
+
+// do sends queued notifications and verifications until both queues closed and drained
 func (s *Service) do() {
-	defer log.Print("[WARN] terminated notifier")
-	var wg sync.WaitGroup
-	for {
+	defer s.workers.Done()
+	queue, verifications := s.queue, s.verificationQueue
+	for queue != nil || verifications != nil {
 		select {
-		case c, ok := <-s.queue:
+		case c, ok := <-queue:
 			if !ok {
-				return
+				queue = nil // nil channel never selected
+				continue
 			}
-			wg.Add(len(s.destinations))
-			for _, dest := range s.destinations {
-				go func(d Destination) {
-					if err := d.Send(s.ctx, c); err != nil {
-						log.Printf("[WARN] failed to send to %s, %s", d, err)
-					}
-					wg.Done()
-				}(dest)
-			}
-			wg.Wait()
-		case v, ok := <-s.verificationQueue:
+			s.dispatch(c)
+		case v, ok := <-verifications:
 			if !ok {
-				return
-			}
-			wg.Add(len(s.destinations))
-			for _, dest := range s.destinations {
-				go func(d Destination) {
-					if err := d.SendVerification(s.ctx, v); err != nil {
-						log.Printf("[WARN] failed to send to %s, %s", d, err)
-					}
-					wg.Done()
-				}(dest)
+				verifications = nil
+				continue
 			}
-			wg.Wait()
-		case <-s.ctx.Done():
-			return
+			s.dispatchVerification(v)
+		}
+	}
+}
+
+// dispatch resolves recipients of the request and sends it to all destinations, skipped once sending canceled
+func (s *Service) dispatch(c Request) {
+	if s.ctx.Err() != nil {
+		return
+	}
+	ok := true
+	switch {
+	case c.Moderation != "":
+		c, ok = s.prepareModeration(c)
+	case !c.prepared:
+		c, ok = s.prepare(c)
+	}
+	if !ok {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, dest := range s.destinations {
+		if c.resend && !logsDeliveries(dest) {
+			continue
 		}
+		wg.Add(1)
+		go func(d Destination) {
+			ctx, st := withSendStats(logging.WithFields(s.ctx, c.Log))
+			err := s.sendWithBreaker(d, func() error { return c.send(ctx, d) })
+			if err != nil {
+				logging.Printf(ctx, "[WARN] failed to send to %s, %s", d, err)
+			}
+			s.reportSent(d, err)
+			s.recordSent(d, &c, nil, st, err)
+			wg.Done()
+		}(dest)
+	}
+	wg.Wait()
+}
+
+// dispatchVerification sends verification to all destinations, skipped once sending canceled
+func (s *Service) dispatchVerification(v VerificationRequest) {
+	if s.ctx.Err() != nil {
+		return
+	}
+	var wg sync.WaitGroup
+	wg.Add(len(s.destinations))
+	for _, dest := range s.destinations {
+		go func(d Destination) {
+			ctx, st := withSendStats(s.ctx)
+			err := s.sendWithBreaker(d, func() error { return d.SendVerification(ctx, v) })
+			if err != nil {
+				log.Printf("[WARN] failed to send to %s, %s", d, err)
+			}
+			s.recordSent(d, nil, &v, st, err)
+			wg.Done()
+		}(dest)
+	}
+	wg.Wait()
+}
+
+// send request to destination in span added to the trace of request
+func (r Request) send(ctx context.Context, d Destination) error {
+	ctx = trace.ContextWithSpanContext(ctx, r.Trace)
+	if _, ok := d.(*Throttled); ok {
+		return d.Send(ctx, r) // throttled destination traces actual sending
 	}
+	return tracing.Span(ctx, "notify."+destinationName(d), func(ctx context.Context) error { return d.Send(ctx, r) })
 }
 
 // NopService is do-nothing notifier, without destinations
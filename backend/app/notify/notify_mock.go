@@ -16,6 +16,8 @@ type MockDest struct {
 	id               int
 	closed           bool
 	lock             sync.Mutex
+
+	LogDeliveries bool // mock destination keeping delivery log, gets re-sent notifications
 }
 
 // Send mock
@@ -66,4 +68,6 @@ func (m *MockDest) GetVerify() []VerificationRequest {
 	return res
 }
 
+func (m *MockDest) logsDeliveries() bool { return m.LogDeliveries }
+
 func (m *MockDest) String() string { return fmt.Sprintf("mock id=%d, closed=%v", m.id, m.closed) }
@@ -1,16 +1,23 @@
 package notify
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math/rand"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 
+	"github.com/umputun/remark42/backend/app/logging"
 	"github.com/umputun/remark42/backend/app/store"
 )
 
@@ -45,6 +52,160 @@ func TestService_WithDestinations(t *testing.T) {
 	assert.Equal(t, "102", d1.Get()[2].Comment.ID)
 }
 
+func TestService_Links(t *testing.T) {
+	d := &MockDest{id: 1}
+	s := NewService(nil, 1, d)
+	s.SetLinks(store.Links{"app": "https://app.example.com/threads/{key}?comment={id}"})
+
+	s.Submit(Request{Comment: store.Comment{ID: "100", Locator: store.Locator{SiteID: "app", URL: "virtual:thread-1"}}})
+	time.Sleep(time.Millisecond * 50)
+	s.Submit(Request{Comment: store.Comment{ID: "101", Locator: store.Locator{SiteID: "app", URL: "https://example.com/post"}}})
+	time.Sleep(time.Millisecond * 50)
+	s.Close()
+
+	require.Equal(t, 2, len(d.Get()))
+	assert.Equal(t, "https://app.example.com/threads/thread-1?comment=100", d.Get()[0].CommentLink("100"))
+	assert.Equal(t, "https://example.com/post#remark42__comment-101", d.Get()[1].CommentLink("101"))
+}
+
+func TestService_WatchKeywords(t *testing.T) {
+	d := &MockDest{id: 1}
+	s := NewService(nil, 1, d)
+	s.WatchKeywords(func(siteID string) []string {
+		if siteID == "other" {
+			return nil
+		}
+		return []string{"acme", "law suit"}
+	})
+
+	s.Submit(Request{Comment: store.Comment{ID: "100", Orig: "about ACME and law  suit", Text: "<p>about ACME</p>"}})
+	time.Sleep(time.Millisecond * 50)
+	s.Submit(Request{Comment: store.Comment{ID: "101", Text: "<p>acme</p>"}})
+	time.Sleep(time.Millisecond * 50)
+	s.Submit(Request{Comment: store.Comment{ID: "102", Orig: "other text"}, Keywords: []string{"acme"}})
+	time.Sleep(time.Millisecond * 50)
+	s.Submit(Request{Comment: store.Comment{ID: "103", Orig: "acme again"}, Alert: "rule new user"})
+	time.Sleep(time.Millisecond * 50)
+	s.Submit(Request{Comment: store.Comment{ID: "104", Orig: "acme", Locator: store.Locator{SiteID: "other"}}})
+	time.Sleep(time.Millisecond * 50)
+	s.Close()
+
+	require.Equal(t, 5, len(d.Get()))
+	assert.Equal(t, []string{"acme", "law suit"}, d.Get()[0].Keywords)
+	assert.Equal(t, []string{"acme"}, d.Get()[1].Keywords, "text used if orig not set")
+	assert.Nil(t, d.Get()[2].Keywords, "set by service only")
+	assert.Equal(t, []string{"acme", "rule new user"}, d.Get()[3].Keywords, "alert reported with keywords")
+	assert.Nil(t, d.Get()[4].Keywords, "keywords of other site")
+}
+
+func TestService_Filter(t *testing.T) {
+	d := &MockDest{id: 1}
+	s := NewService(nil, 1, d)
+	s.SetFilter(func(req Request) bool { return req.Comment.User.ID != "spammer" })
+
+	s.Submit(Request{Comment: store.Comment{ID: "100", User: store.User{ID: "u1"}}})
+	time.Sleep(time.Millisecond * 50)
+	s.Submit(Request{Comment: store.Comment{ID: "101", User: store.User{ID: "spammer"}}})
+	time.Sleep(time.Millisecond * 50)
+	s.Close()
+
+	require.Equal(t, 1, len(d.Get()))
+	assert.Equal(t, "100", d.Get()[0].Comment.ID)
+}
+
+func TestService_Moderation(t *testing.T) {
+	dest := &MockDest{id: 1}
+	dataStore := &mockStore{data: map[string]store.Comment{}, emailData: map[string]string{"u1": "u1@example.com"}}
+	dataStore.data["p1"] = store.Comment{ID: "p1", User: store.User{ID: "u2"}}
+
+	s := NewService(dataStore, 1, dest)
+	s.WatchKeywords(func(string) []string { return []string{"acme"} })
+	s.Submit(Request{Comment: store.Comment{ID: "c1", ParentID: "p1", Orig: "acme", User: store.User{ID: "u1"}},
+		Moderation: ModerationDeleted, Reason: "spam"})
+	time.Sleep(time.Millisecond * 50)
+	s.Submit(Request{Comment: store.Comment{ID: "c2", User: store.User{ID: "u2"}}, Moderation: ModerationApproved})
+	time.Sleep(time.Millisecond * 50)
+	s.Close()
+
+	destRes := dest.Get()
+	require.Equal(t, 1, len(destRes), "author without email not notified")
+	assert.Equal(t, "c1", destRes[0].Comment.ID)
+	assert.Equal(t, ModerationDeleted, destRes[0].Moderation)
+	assert.Equal(t, "spam", destRes[0].Reason)
+	assert.Equal(t, []string{"u1@example.com"}, destRes[0].Emails, "sent to the author only")
+	assert.Empty(t, destRes[0].parent, "parent not loaded")
+	assert.Nil(t, destRes[0].Keywords, "keywords not matched")
+}
+
+func TestService_Followers(t *testing.T) {
+	dest := &MockDest{id: 1}
+	dataStore := &mockStore{data: map[string]store.Comment{},
+		emailData: map[string]string{"u1": "u1@example.com", "u2": "u2@example.com", "u3": "u3@example.com"}}
+	dataStore.data["p1"] = store.Comment{ID: "p1", User: store.User{ID: "u2"}}
+	follows := &mockFollows{followers: map[string][]string{"author": {"u1", "u2", "u4", "author"}}}
+
+	s := NewService(dataStore, 1, dest)
+	s.SetFollowStore(follows)
+	s.Submit(Request{Comment: store.Comment{ID: "c1", ParentID: "p1", User: store.User{ID: "author"}}})
+	time.Sleep(time.Millisecond * 50)
+	s.Submit(Request{Comment: store.Comment{ID: "c2", User: store.User{ID: "other"}}})
+	time.Sleep(time.Millisecond * 50)
+	s.Close()
+
+	destRes := dest.Get()
+	require.Equal(t, 2, len(destRes))
+	assert.Equal(t, []string{"u2@example.com"}, destRes[0].Emails)
+	assert.Equal(t, []Follower{{UserID: "u1", Email: "u1@example.com"}}, destRes[0].Followers,
+		"parent author notified about reply only, follower without email skipped")
+	assert.Empty(t, destRes[1].Followers, "no followers")
+}
+
+func TestService_UsersEnabled(t *testing.T) {
+	dest := &MockDest{id: 1}
+	dataStore := &mockStore{data: map[string]store.Comment{}, emailData: map[string]string{"u1": "u1@example.com"}}
+	dataStore.data["p1"] = store.Comment{ID: "p1", User: store.User{ID: "u1"}}
+
+	s := NewService(dataStore, 1, dest)
+	s.SetUsersEnabled(func(siteID string) bool { return siteID != "off" })
+	s.Submit(Request{Comment: store.Comment{ID: "c1", ParentID: "p1", User: store.User{ID: "u2"}, Locator: store.Locator{SiteID: "off"}}})
+	time.Sleep(time.Millisecond * 50)
+	s.Submit(Request{Comment: store.Comment{ID: "c2", User: store.User{ID: "u1"}, Locator: store.Locator{SiteID: "off"}},
+		Moderation: ModerationDeleted})
+	time.Sleep(time.Millisecond * 50)
+	s.Submit(Request{Comment: store.Comment{ID: "c3", ParentID: "p1", User: store.User{ID: "u2"}, Locator: store.Locator{SiteID: "on"}}})
+	time.Sleep(time.Millisecond * 50)
+	s.Close()
+
+	destRes := dest.Get()
+	require.Equal(t, 2, len(destRes), "moderation notification of disabled site dropped")
+	assert.Equal(t, "c1", destRes[0].Comment.ID)
+	assert.Empty(t, destRes[0].Emails, "users not notified, admins are")
+	assert.Equal(t, "c3", destRes[1].Comment.ID)
+	assert.Equal(t, []string{"u1@example.com"}, destRes[1].Emails)
+}
+
+func TestService_Resend(t *testing.T) {
+	d1, d2 := &MockDest{id: 1}, &MockDest{id: 2, LogDeliveries: true}
+	s := NewService(nil, 1, d1)
+	assert.False(t, s.CanResend())
+	assert.EqualError(t, s.Resend(Request{Comment: store.Comment{ID: "100"}}), "no notification destinations with delivery log")
+	s.Close()
+
+	s = NewService(nil, 1, d1, d2)
+	assert.True(t, s.CanResend())
+	s.Submit(Request{Comment: store.Comment{ID: "100"}})
+	time.Sleep(time.Millisecond * 50)
+	assert.NoError(t, s.Resend(Request{Comment: store.Comment{ID: "101"}}))
+	time.Sleep(time.Millisecond * 50)
+	s.Close()
+	assert.EqualError(t, s.Resend(Request{Comment: store.Comment{ID: "102"}}), "notification service closed")
+
+	require.Equal(t, 1, len(d1.Get()), "destination without delivery log skipped on resend")
+	assert.Equal(t, "100", d1.Get()[0].Comment.ID)
+	require.Equal(t, 2, len(d2.Get()))
+	assert.Equal(t, "101", d2.Get()[1].Comment.ID)
+}
+
 func TestService_WithDrops(t *testing.T) {
 	d1, d2 := &MockDest{id: 1}, &MockDest{id: 2}
 	s := NewService(nil, 1, d1, d2)
@@ -94,6 +255,7 @@ func TestService_Many(t *testing.T) {
 	d1, d2 := &MockDest{id: 1}, &MockDest{id: 2}
 	s := NewService(nil, 5, d1, d2)
 	assert.NotNil(t, s)
+	s.SetDispatch(DispatchParams{DrainTimeout: time.Millisecond}) // queued notifications canceled on close
 
 	for i := 0; i < 10; i++ {
 		s.Submit(Request{Comment: store.Comment{ID: fmt.Sprintf("%d", 100+i)}})
@@ -276,6 +438,106 @@ func TestService_Nop(t *testing.T) {
 	assert.Equal(t, uint32(1), atomic.LoadUint32(&s.closed))
 }
 
+func TestService_Drain(t *testing.T) {
+	d := &MockDest{id: 1}
+	s := NewService(nil, 20, d)
+	for i := 0; i < 10; i++ {
+		s.Submit(Request{Comment: store.Comment{ID: fmt.Sprintf("%d", 100+i)}})
+	}
+	s.SubmitVerification(VerificationRequest{User: "u1"})
+	s.Close()
+	assert.Equal(t, 10, len(d.Get()), "all queued notifications sent on close")
+	assert.Equal(t, 1, len(d.GetVerify()))
+	assert.False(t, d.closed, "sending not canceled")
+
+	s.Submit(Request{Comment: store.Comment{ID: "200"}})
+	s.SubmitVerification(VerificationRequest{User: "u2"})
+	s.Close()
+	assert.Equal(t, 10, len(d.Get()), "not accepted after close")
+}
+
+func TestService_DrainThrottled(t *testing.T) {
+	d := &MockDest{id: 1}
+	th := NewThrottled(d, ThrottleParams{PerMinute: 2})
+	s := NewService(nil, 20, th)
+	s.SetDispatch(DispatchParams{DrainTimeout: time.Second})
+	for i := 0; i < 6; i++ {
+		s.Submit(Request{Comment: store.Comment{ID: fmt.Sprintf("%d", 100+i)}})
+	}
+	s.SubmitVerification(VerificationRequest{User: "u1"})
+	s.Close()
+	assert.Equal(t, 0, th.Queued())
+	assert.Equal(t, 6, len(d.Get()), "messages held by throttle sent on close")
+	assert.Equal(t, 1, len(d.GetVerify()))
+	assert.False(t, d.closed, "sending not canceled")
+
+	// drain timeout covers throttled queues
+	d = &MockDest{id: 2}
+	th = NewThrottled(d, ThrottleParams{PerMinute: 1, FlushTimeout: time.Minute})
+	s = NewService(nil, 50, th)
+	s.SetDispatch(DispatchParams{DrainTimeout: 55 * time.Millisecond})
+	for i := 0; i < 30; i++ {
+		s.Submit(Request{Comment: store.Comment{ID: fmt.Sprintf("%d", 100+i)}})
+	}
+	st := time.Now()
+	s.Close()
+	assert.True(t, time.Since(st) < 500*time.Millisecond, "close bounded by drain timeout, %v", time.Since(st))
+	assert.True(t, len(d.Get()) > 1 && len(d.Get()) < 30, "sent %d", len(d.Get()))
+	assert.Equal(t, 0, th.Queued())
+}
+
+func TestService_Workers(t *testing.T) {
+	d := &slowDest{delay: 100 * time.Millisecond}
+	s := NewService(nil, 10, d)
+	s.SetDispatch(DispatchParams{Workers: 4, DrainTimeout: time.Second})
+	st := time.Now()
+	for i := 0; i < 4; i++ {
+		s.Submit(Request{Comment: store.Comment{ID: fmt.Sprintf("%d", 100+i)}})
+	}
+	s.Close()
+	assert.Equal(t, int32(4), atomic.LoadInt32(&d.sent))
+	assert.True(t, time.Since(st) < 300*time.Millisecond, "sent concurrently, %v", time.Since(st))
+}
+
+func TestService_SubmitNotBlocked(t *testing.T) {
+	ds := slowStore{mockStore: mockStore{data: map[string]store.Comment{
+		"p1": {ID: "p1", User: store.User{ID: "u1"}}}}, delay: 200 * time.Millisecond}
+	d := &MockDest{id: 1}
+	s := NewService(ds, 10, d)
+	st := time.Now()
+	s.Submit(Request{Comment: store.Comment{ID: "c1", ParentID: "p1"}})
+	assert.True(t, time.Since(st) < 100*time.Millisecond, "recipients resolved by worker")
+	s.Close()
+	require.Equal(t, 1, len(d.Get()))
+	assert.Equal(t, "p1", d.Get()[0].parent.ID)
+}
+
+// slowDest counts notifications sent with delay
+type slowDest struct {
+	delay time.Duration
+	sent  int32
+}
+
+func (d *slowDest) Send(context.Context, Request) error {
+	time.Sleep(d.delay)
+	atomic.AddInt32(&d.sent, 1)
+	return nil
+}
+
+func (d *slowDest) SendVerification(context.Context, VerificationRequest) error { return nil }
+func (d *slowDest) String() string                                              { return "slow destination" }
+
+// slowStore reads comments with delay
+type slowStore struct {
+	mockStore
+	delay time.Duration
+}
+
+func (m slowStore) Get(locator store.Locator, id string, user store.User) (store.Comment, error) {
+	time.Sleep(m.delay)
+	return m.mockStore.Get(locator, id, user)
+}
+
 type mockStore struct {
 	data      map[string]store.Comment
 	emailData map[string]string
@@ -296,3 +558,60 @@ func (m mockStore) GetUserEmail(_, userID string) (string, error) {
 	}
 	return email, nil
 }
+
+type mockFollows struct {
+	followers map[string][]string
+}
+
+func (m *mockFollows) Follow(_, _, _ string) error   { return nil }
+func (m *mockFollows) Unfollow(_, _, _ string) error { return nil }
+func (m *mockFollows) Following(_, _ string) ([]string, error) {
+	return nil, nil
+}
+func (m *mockFollows) Followers(_, authorID string) ([]string, error) {
+	return m.followers[authorID], nil
+}
+func TestService_LogFields(t *testing.T) {
+	dest := &logFieldsDest{}
+	s := NewService(nil, 10, dest)
+	fields := logging.Fields{RequestID: "123", Site: "remark42", User: "github_abc"}
+	s.Submit(Request{Comment: store.Comment{ID: "c1"}, Log: fields})
+	s.Close()
+	assert.Equal(t, []logging.Fields{fields}, dest.fields)
+}
+
+// logFieldsDest keeps log fields of contexts it got
+type logFieldsDest struct {
+	sync.Mutex
+	fields []logging.Fields
+}
+
+func (d *logFieldsDest) Send(ctx context.Context, _ Request) error {
+	d.Lock()
+	defer d.Unlock()
+	d.fields = append(d.fields, logging.FromContext(ctx))
+	return nil
+}
+func (d *logFieldsDest) SendVerification(context.Context, VerificationRequest) error { return nil }
+func (d *logFieldsDest) String() string                                              { return "log fields" }
+
+func TestService_Trace(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "POST /api/v1/comment")
+	parent.End()
+
+	th := NewThrottled(&MockDest{id: 2}, ThrottleParams{PerMinute: 100})
+	defer th.Close()
+	s := NewService(nil, 10, &MockDest{id: 1}, th)
+	s.Submit(Request{Comment: store.Comment{ID: "c1"}, Trace: trace.SpanContextFromContext(ctx)})
+	require.Eventually(t, func() bool { return len(exporter.GetSpans()) == 3 }, time.Second, 10*time.Millisecond)
+	s.Close()
+
+	for _, span := range exporter.GetSpans()[1:] {
+		assert.Equal(t, "notify.mock", span.Name, "sent by both destinations, throttled traced on sending")
+		assert.Equal(t, parent.SpanContext().TraceID(), span.SpanContext.TraceID())
+		assert.Equal(t, parent.SpanContext().SpanID(), span.Parent.SpanID())
+	}
+}
@@ -2,6 +2,7 @@ package notify
 
 import (
 	"context"
+	"strings"
 
 	log "github.com/go-pkgz/lgr"
 	"github.com/pkg/errors"
@@ -38,6 +39,12 @@ func NewSlack(token, channelName string, opts ...slack.Option) (*Slack, error) {
 
 // Send to Slack channel
 func (t *Slack) Send(ctx context.Context, req Request) error {
+	if req.Moderation != "" {
+		return nil // moderation events are for comment authors only
+	}
+	if req.skipShared {
+		return nil // doesn't match default admin events
+	}
 
 	log.Printf("[DEBUG] send slack notification, comment id %s", req.Comment.ID)
 
@@ -46,16 +53,21 @@ func (t *Slack) Send(ctx context.Context, req Request) error {
 		user += " → " + req.parent.User.Name
 	}
 
+	text := "New comment from " + user
+	if len(req.Keywords) > 0 {
+		text = "Keywords alert (" + strings.Join(req.Keywords, ", ") + "). " + text
+	}
+
 	title := "↦ original comment"
 	if req.Comment.PostTitle != "" {
 		title = "↦ " + req.Comment.PostTitle
 	}
 
 	_, _, err := t.client.PostMessageContext(ctx, t.channelID,
-		slack.MsgOptionText("New comment from "+user, false),
+		slack.MsgOptionText(text, false),
 		slack.MsgOptionAttachments(
 			slack.Attachment{
-				TitleLink: req.Comment.Locator.URL + uiNav + req.Comment.ID,
+				TitleLink: req.CommentLink(req.Comment.ID),
 				Title:     title,
 				Text:      req.Comment.Orig,
 			},
@@ -56,6 +56,8 @@ func TestSlack_Send(t *testing.T) {
 	c.PostTitle = "[test title]"
 	err = tb.Send(context.TODO(), Request{Comment: c, parent: cp})
 	assert.NoError(t, err)
+	err = tb.Send(context.TODO(), Request{Comment: c, parent: cp, Keywords: []string{"acme"}})
+	assert.NoError(t, err)
 
 	tb, err = ts.newClient("general")
 	assert.NoError(t, err)
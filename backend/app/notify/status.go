@@ -0,0 +1,146 @@
+package notify
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+)
+
+// SendStatus is outcome of sending notification by a destination
+type SendStatus struct {
+	SiteID      string        `json:"site"`
+	CommentID   string        `json:"comment_id,omitempty"`
+	Kind        string        `json:"kind"` // reply, moderation or verification
+	Destination string        `json:"destination"`
+	Recipients  []string      `json:"recipients,omitempty"` // emails notified by the destination
+	Time        time.Time     `json:"time"`
+	Duration    time.Duration `json:"duration"`
+	Retries     int           `json:"retries"` // failed attempts retried by the destination
+	Success     bool          `json:"success"`
+	Error       string        `json:"error,omitempty"` // final error, set if not succeeded
+}
+
+// enum of kinds of sent notifications
+const (
+	StatusReply        = "reply"
+	StatusModeration   = "moderation"
+	StatusVerification = "verification"
+)
+
+// StatusFilter selects send statuses of the site
+type StatusFilter struct {
+	Email     string // statuses with the email in recipients, all if empty
+	CommentID string // statuses of notifications about the comment, all if empty
+	Limit     int    // max number of statuses, defaultStatusLimit if 0
+}
+
+// StatusStore defines interface to keep send statuses of notifications
+type StatusStore interface {
+	Add(status SendStatus) error
+	List(siteID string, filter StatusFilter) ([]SendStatus, error) // newest first
+}
+
+const defaultStatusLimit = 100
+
+// statusReporter implemented by destinations recording send statuses by themselves, i.e. sending later than called
+type statusReporter interface {
+	setStatusStore(st StatusStore)
+}
+
+// SetStatusStore sets store of send statuses, passed to destinations recording own statuses.
+// Should be called before submitting any requests.
+func (s *Service) SetStatusStore(st StatusStore) {
+	s.statuses = st
+	for _, d := range s.destinations {
+		if sr, ok := d.(statusReporter); ok {
+			sr.setStatusStore(st)
+		}
+	}
+}
+
+// TracksStatus checks if send statuses of notifications recorded
+func (s *Service) TracksStatus() bool {
+	return s.statuses != nil
+}
+
+// Statuses returns recent send statuses of notifications of the site, newest first
+func (s *Service) Statuses(siteID string, filter StatusFilter) ([]SendStatus, error) {
+	if s.statuses == nil {
+		return []SendStatus{}, nil
+	}
+	return s.statuses.List(siteID, filter)
+}
+
+// recordSent records send status of request or verification, skipped for destinations recording by themselves
+// unless message rejected by them
+func (s *Service) recordSent(d Destination, req *Request, verification *VerificationRequest, st *sendStats, err error) {
+	if _, ok := d.(*Throttled); ok && err == nil {
+		return // throttled destination records actual sending
+	}
+	recordStatus(s.statuses, d, req, verification, st, err)
+}
+
+// recordStatus adds send status to the store. Statuses of verifications recorded for destinations sending
+// separate messages to recipients only, like email, as others don't send verifications.
+func recordStatus(store StatusStore, d Destination, req *Request, verification *VerificationRequest, st *sendStats, err error) {
+	if store == nil {
+		return
+	}
+	status := SendStatus{Destination: destinationName(d), Time: st.start, Duration: time.Since(st.start),
+		Retries: int(atomic.LoadInt32(&st.retries)), Success: err == nil}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	lister, isLister := unwrapDestination(d).(recipientsLister)
+	switch {
+	case verification != nil:
+		if !isLister || verification.Email == "" {
+			return
+		}
+		status.SiteID, status.Kind, status.Recipients = verification.SiteID, StatusVerification, []string{verification.Email}
+	default:
+		status.SiteID, status.CommentID, status.Kind = req.Comment.Locator.SiteID, req.Comment.ID, StatusReply
+		if req.Moderation != "" {
+			status.Kind = StatusModeration
+		}
+		if isLister {
+			if status.Recipients = lister.recipients(*req); len(status.Recipients) == 0 {
+				return // nothing sent
+			}
+		}
+	}
+	if e := store.Add(status); e != nil {
+		log.Printf("[WARN] can't record send status of %s to %s, %v", status.Kind, status.Destination, e)
+	}
+}
+
+// unwrapDestination returns destination wrapped by Throttled
+func unwrapDestination(d Destination) Destination {
+	if t, ok := d.(*Throttled); ok {
+		return t.dest
+	}
+	return d
+}
+
+// sendStats collects stats of sending passed with context to destinations
+type sendStats struct {
+	start   time.Time
+	retries int32
+}
+
+type sendStatsKey struct{}
+
+// withSendStats returns context collecting stats of sending started now
+func withSendStats(ctx context.Context) (context.Context, *sendStats) {
+	st := &sendStats{start: time.Now()}
+	return context.WithValue(ctx, sendStatsKey{}, st), st
+}
+
+// countRetry counts retry of failed attempt to send by destination, if stats collected by the context
+func countRetry(ctx context.Context) {
+	if st, ok := ctx.Value(sendStatsKey{}).(*sendStats); ok {
+		atomic.AddInt32(&st.retries, 1)
+	}
+}
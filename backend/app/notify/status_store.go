@@ -0,0 +1,99 @@
+package notify
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/xid"
+
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+const (
+	statusTimeFormat  = "20060102150405.000000000" // fixed width, keys of the site sorted by time
+	maxExpiredRemoval = 100                        // max number of expired statuses removed on each Add
+)
+
+// EngineStatuses implements StatusStore with records of store engine. Records are keyed by time!!xid,
+// statuses older than keep duration removed on adding new ones.
+type EngineStatuses struct {
+	records engine.Records
+	keep    time.Duration
+}
+
+// NewEngineStatuses makes store of send statuses kept by eng for keep duration, forever if 0
+func NewEngineStatuses(eng engine.Interface, keep time.Duration) *EngineStatuses {
+	return &EngineStatuses{records: engine.Records{Engine: eng, Kind: engine.SendStatuses}, keep: keep}
+}
+
+// Add records send status and removes expired statuses of the site
+func (e *EngineStatuses) Add(status SendStatus) error {
+	if status.SiteID == "" {
+		return errors.New("site required for send status")
+	}
+	key := status.Time.UTC().Format(statusTimeFormat) + "!!" + xid.New().String()
+	if err := e.records.Set(status.SiteID, key, status); err != nil {
+		return err
+	}
+	if e.keep <= 0 {
+		return nil
+	}
+
+	expired := time.Now().Add(-e.keep).UTC().Format(statusTimeFormat)
+	keys := []string{}
+	err := e.records.List(status.SiteID, "", func(k string, _ func(v interface{}) error) error {
+		if k < expired && len(keys) < maxExpiredRemoval {
+			keys = append(keys, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if _, err = e.records.Delete(status.SiteID, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// List returns statuses of the site matching filter, newest first
+func (e *EngineStatuses) List(siteID string, filter StatusFilter) ([]SendStatus, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = defaultStatusLimit
+	}
+	all := []SendStatus{}
+	err := e.records.List(siteID, "", func(_ string, unmarshal func(v interface{}) error) error {
+		status := SendStatus{}
+		if err := unmarshal(&status); err != nil {
+			return err
+		}
+		if filter.CommentID != "" && status.CommentID != filter.CommentID {
+			return nil
+		}
+		if filter.Email != "" && !containsEmail(status.Recipients, filter.Email) {
+			return nil
+		}
+		all = append(all, status)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	res := []SendStatus{}
+	for i := len(all) - 1; i >= 0 && len(res) < filter.Limit; i-- {
+		res = append(res, all[i])
+	}
+	return res, nil
+}
+
+// containsEmail checks if normalized email is in the list
+func containsEmail(emails []string, email string) bool {
+	for _, e := range emails {
+		if normalizeEmail(e) == normalizeEmail(email) {
+			return true
+		}
+	}
+	return false
+}
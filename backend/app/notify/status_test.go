@@ -0,0 +1,119 @@
+package notify
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestService_Statuses(t *testing.T) {
+	statuses := NewEngineStatuses(prepEngine(t), 0)
+
+	email, err := NewEmail(EmailParams{From: "from@example.org", VerificationTemplatePath: "testdata/verification.html.tmpl",
+		MsgTemplatePath: "testdata/msg.html.tmpl"}, SMTPParams{})
+	require.NoError(t, err)
+	email.smtp, email.TokenGenFn = &fakeTestSMTP{}, TokenGenFn
+	th := NewThrottled(&MockDest{id: 1}, ThrottleParams{PerMinute: 100})
+	defer th.Close()
+	s := NewService(nil, 10, email, th)
+	assert.False(t, s.TracksStatus())
+	res, err := s.Statuses("remark", StatusFilter{})
+	require.NoError(t, err)
+	assert.Empty(t, res, "not tracked")
+	s.SetStatusStore(statuses)
+	assert.True(t, s.TracksStatus())
+
+	s.Submit(Request{Comment: store.Comment{ID: "c1", Locator: store.Locator{SiteID: "remark", URL: "http://example.com"}},
+		Emails: []string{"u1@example.com"}})
+	s.SubmitVerification(VerificationRequest{SiteID: "remark", User: "u2", Email: "u2@example.com", Token: "tkn"})
+	time.Sleep(100 * time.Millisecond)
+	s.Close()
+
+	res, err = s.Statuses("remark", StatusFilter{})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(res), "reply by email and mock, verification by email only")
+	byKind := map[string]SendStatus{}
+	for _, st := range res {
+		byKind[st.Kind+" "+st.Destination] = st
+	}
+	reply := byKind["reply email"]
+	assert.Equal(t, "c1", reply.CommentID)
+	assert.Equal(t, []string{"u1@example.com"}, reply.Recipients)
+	assert.True(t, reply.Success)
+	assert.Equal(t, 0, reply.Retries)
+	assert.False(t, reply.Time.IsZero())
+	assert.True(t, byKind["reply mock"].Success, "recorded by throttled on sending")
+	assert.Equal(t, []string{"u2@example.com"}, byKind["verification email"].Recipients)
+
+	res, err = s.Statuses("remark", StatusFilter{Email: "U2@example.com"})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, StatusVerification, res[0].Kind)
+}
+
+func TestService_StatusesFailed(t *testing.T) {
+	statuses := NewEngineStatuses(prepEngine(t), 0)
+
+	email, err := NewEmail(EmailParams{From: "from@example.org", VerificationTemplatePath: "testdata/verification.html.tmpl",
+		MsgTemplatePath: "testdata/msg.html.tmpl"}, SMTPParams{})
+	require.NoError(t, err)
+	email.smtp = &fakeTestSMTP{fail: map[string]bool{"rcpt": true}}
+	s := NewService(nil, 10, email)
+	s.SetStatusStore(statuses)
+
+	s.SubmitVerification(VerificationRequest{SiteID: "remark", User: "u1", Email: "u1@example.com", Token: "tkn"})
+	assert.Eventually(t, func() bool {
+		res, e := s.Statuses("remark", StatusFilter{Email: "u1@example.com"})
+		return e == nil && len(res) == 1
+	}, 5*time.Second, 50*time.Millisecond)
+	s.Close()
+
+	res, err := s.Statuses("remark", StatusFilter{})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, StatusVerification, res[0].Kind)
+	assert.False(t, res[0].Success)
+	assert.Equal(t, 4, res[0].Retries, "5 attempts")
+	assert.Contains(t, res[0].Error, "failed to verify receiver")
+	assert.True(t, res[0].Duration > 0)
+}
+
+func TestEngineStatuses(t *testing.T) {
+	statuses := NewEngineStatuses(prepEngine(t), time.Hour)
+
+	now := time.Now()
+	require.NoError(t, statuses.Add(SendStatus{SiteID: "site1", CommentID: "old", Time: now.Add(-2 * time.Hour)}))
+	require.NoError(t, statuses.Add(SendStatus{SiteID: "site1", CommentID: "c1", Recipients: []string{"a@example.com"},
+		Time: now.Add(-time.Minute), Success: true}))
+	require.NoError(t, statuses.Add(SendStatus{SiteID: "site1", CommentID: "c2", Time: now, Error: "failed"}))
+	require.NoError(t, statuses.Add(SendStatus{SiteID: "site2", CommentID: "c3", Time: now}))
+	assert.Error(t, statuses.Add(SendStatus{}))
+
+	ids := func(res []SendStatus) (ids []string) {
+		for _, st := range res {
+			ids = append(ids, st.CommentID)
+		}
+		return ids
+	}
+	res, err := statuses.List("site1", StatusFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c2", "c1"}, ids(res), "newest first, expired removed")
+	assert.Equal(t, "failed", res[0].Error)
+	res, err = statuses.List("site1", StatusFilter{Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c2"}, ids(res))
+	res, err = statuses.List("site1", StatusFilter{Email: "A@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c1"}, ids(res))
+	res, err = statuses.List("site2", StatusFilter{CommentID: "c3"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c3"}, ids(res))
+	res, err = statuses.List("site3", StatusFilter{})
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+}
@@ -9,11 +9,15 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/go-pkgz/lcw"
 	log "github.com/go-pkgz/lgr"
 	"github.com/go-pkgz/repeater"
 	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/logging"
 )
 
 // TelegramParams contain settings for telegram notifications
@@ -22,12 +26,19 @@ type TelegramParams struct {
 	Token          string        // token for telegram bot API interactions
 	Timeout        time.Duration // http client timeout
 
+	Moderation    bool     // approve, delete and block buttons added to admin notifications, handled by webhook
+	WebhookURL    string   // url of webhook receiving pressed buttons, registered on start if moderation enabled
+	WebhookSecret string   // secret token sent by telegram with webhook requests, required for moderation
+	Moderators    []string // ids of telegram users allowed to moderate, besides admins with personal telegram chats
+
 	apiPrefix string // changed only in tests
 }
 
 // Telegram implements notify.Destination for telegram
 type Telegram struct {
 	TelegramParams
+	actions lcw.LoadingCache // targets of moderation buttons by keys sent in callback data
+	tokenMu sync.RWMutex     // guards Token switched at runtime
 }
 
 const telegramTimeOut = 5000 * time.Millisecond
@@ -36,7 +47,7 @@ const telegramAPIPrefix = "https://api.telegram.org/bot"
 // NewTelegram makes telegram bot for notifications
 func NewTelegram(params TelegramParams) (*Telegram, error) {
 	res := Telegram{TelegramParams: params}
-	if _, err := strconv.ParseInt(res.AdminChannelID, 10, 64); err != nil {
+	if _, err := strconv.ParseInt(res.AdminChannelID, 10, 64); err != nil && res.AdminChannelID != "" {
 		res.AdminChannelID = "@" + res.AdminChannelID // if channelID not a number enforce @ prefix
 	}
 
@@ -52,67 +63,123 @@ func NewTelegram(params TelegramParams) (*Telegram, error) {
 	defer cancel()
 
 	err := repeater.NewDefault(5, time.Millisecond*250).Do(ctx, func() error {
-		client := http.Client{Timeout: res.Timeout}
-		resp, err := client.Get(fmt.Sprintf("%s%s/getMe", res.apiPrefix, res.Token))
-		if err != nil {
-			return errors.Wrap(err, "can't initialize telegram notifications")
-		}
-		defer func() {
-			if err = resp.Body.Close(); err != nil {
-				log.Printf("[WARN] can't close request body, %s", err)
-			}
-		}()
-
-		if resp.StatusCode != http.StatusOK {
-			return errors.Errorf("unexpected telegram status code %d", resp.StatusCode)
-		}
+		return res.checkToken(ctx, res.Token)
+	})
+	if err != nil || !res.Moderation {
+		return &res, err
+	}
+	return &res, res.setupModeration()
+}
 
-		tgResp := struct {
-			OK     bool `json:"ok"`
-			Result struct {
-				FirstName string `json:"first_name"`
-				ID        uint64 `json:"id"`
-				IsBot     bool   `json:"is_bot"`
-				UserName  string `json:"username"`
-			}
-		}{}
-
-		if err = json.NewDecoder(resp.Body).Decode(&tgResp); err != nil {
-			return errors.Wrap(err, "can't decode response")
+// checkToken calls getMe of bot api with the token, fails if the token doesn't belong to a bot
+func (t *Telegram) checkToken(ctx context.Context, token string) error {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s%s/getMe", t.apiPrefix, token), http.NoBody)
+	if err != nil {
+		return errors.Wrap(err, "can't initialize telegram notifications")
+	}
+	client := http.Client{Timeout: t.Timeout}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "can't initialize telegram notifications")
+	}
+	defer func() {
+		if err = resp.Body.Close(); err != nil {
+			log.Printf("[WARN] can't close request body, %s", err)
 		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected telegram status code %d", resp.StatusCode)
+	}
 
-		if !tgResp.OK || !tgResp.Result.IsBot {
-			return errors.Errorf("unexpected telegram response %+v", tgResp)
+	tgResp := struct {
+		OK     bool `json:"ok"`
+		Result struct {
+			FirstName string `json:"first_name"`
+			ID        uint64 `json:"id"`
+			IsBot     bool   `json:"is_bot"`
+			UserName  string `json:"username"`
 		}
-		return nil
-	})
+	}{}
+
+	if err = json.NewDecoder(resp.Body).Decode(&tgResp); err != nil {
+		return errors.Wrap(err, "can't decode response")
+	}
 
-	return &res, err
+	if !tgResp.OK || !tgResp.Result.IsBot {
+		return errors.Errorf("unexpected telegram response %+v", tgResp)
+	}
+	return nil
+}
+
+// token returns current token of bot api
+func (t *Telegram) token() string {
+	t.tokenMu.RLock()
+	defer t.tokenMu.RUnlock()
+	return t.Token
+}
+
+// TestToken checks the token with bot api, dry-run of SetToken. Nothing sent.
+func (t *Telegram) TestToken(ctx context.Context, token string) error {
+	if token == "" {
+		return errors.New("empty telegram token")
+	}
+	return t.checkToken(ctx, token)
+}
+
+// SetToken switches bot api token, webhook re-registered for the new bot if moderation enabled. Thread safe.
+func (t *Telegram) SetToken(ctx context.Context, token string) error {
+	if err := t.TestToken(ctx, token); err != nil {
+		return err
+	}
+	t.tokenMu.Lock()
+	t.Token = token
+	t.tokenMu.Unlock()
+	log.Printf("[INFO] telegram notifications switched to new bot token")
+	if !t.Moderation || t.actions == nil {
+		return nil
+	}
+	return errors.Wrap(t.setWebhook(ctx), "can't set telegram webhook for new token")
 }
 
 // Send to telegram recipients
 func (t *Telegram) Send(ctx context.Context, req Request) error {
 	var err error
 
-	if t.AdminChannelID != "" {
-		err = t.sendAdminNotification(ctx, req)
+	if req.Moderation != "" {
+		return nil
+	}
+
+	buttons := t.moderationButtons(req)
+	if t.AdminChannelID != "" && !req.skipShared {
+		err = t.sendAdminNotification(ctx, req, t.AdminChannelID, buttons)
 		if err != nil {
 			return errors.Wrapf(err, "problem sending admin telegram notification")
 		}
 	}
 
+	// personal chats of admins
+	for _, chatID := range req.AdminChats {
+		if chatID == t.AdminChannelID && !req.skipShared {
+			continue
+		}
+		if err = t.sendAdminNotification(ctx, req, chatID, buttons); err != nil {
+			return errors.Wrapf(err, "problem sending admin telegram notification to %s", chatID)
+		}
+	}
+
 	return nil
 }
 
-func (t *Telegram) sendAdminNotification(ctx context.Context, req Request) error {
-	log.Printf("[DEBUG] send admin telegram notification to %s, comment id %s", t.AdminChannelID, req.Comment.ID)
+func (t *Telegram) sendAdminNotification(ctx context.Context, req Request, chatID string, buttons []telegramButton) error {
+	logging.Printf(ctx, "[DEBUG] send admin telegram notification to %s, comment id %s", chatID, req.Comment.ID)
 
-	msg, err := buildTelegramMessage(req)
+	msg, err := buildTelegramMessage(req, buttons...)
 	if err != nil {
 		return errors.Wrap(err, "failed to make telegram message body")
 	}
 
-	err = t.sendMessage(ctx, msg, t.AdminChannelID)
+	err = t.sendMessage(ctx, msg, chatID)
 	if err != nil {
 		return errors.Wrapf(err, "failed to send admin notification about %s", req.Comment.ID)
 	}
@@ -121,7 +188,7 @@ func (t *Telegram) sendAdminNotification(ctx context.Context, req Request) error
 
 func (t *Telegram) sendMessage(ctx context.Context, b []byte, chatID string) error {
 	u := fmt.Sprintf("%s%s/sendMessage?chat_id=%s&parse_mode=Markdown&disable_web_page_preview=true",
-		t.apiPrefix, t.Token, chatID)
+		t.apiPrefix, t.token(), chatID)
 	r, err := http.NewRequest("POST", u, bytes.NewReader(b))
 	if err != nil {
 		return errors.Wrap(err, "failed to make telegram request")
@@ -154,22 +221,37 @@ func (t *Telegram) sendMessage(ctx context.Context, b []byte, chatID string) err
 	return nil
 }
 
-func buildTelegramMessage(req Request) ([]byte, error) {
+// buildTelegramMessage makes body of admin notification, buttons added as inline keyboard in a single row
+func buildTelegramMessage(req Request, buttons ...telegramButton) ([]byte, error) {
 	from := req.Comment.User.Name
 	if req.Comment.ParentID != "" {
 		from += " → " + req.parent.User.Name
 	}
 	from = "*" + from + "*"
-	link := fmt.Sprintf("↦ [original comment](%s)", req.Comment.Locator.URL+uiNav+req.Comment.ID)
+	if len(req.Keywords) > 0 {
+		from = fmt.Sprintf("⚠️ *keywords alert:* %s\n\n%s", strings.Join(req.Keywords, ", "), from)
+	}
+	if req.Comment.Pending {
+		from = "⏸ *awaits moderation*\n\n" + from
+	}
+	commentLink := req.CommentLink(req.Comment.ID)
+	link := fmt.Sprintf("↦ [original comment](%s)", commentLink)
 	if req.Comment.PostTitle != "" {
-		link = fmt.Sprintf("↦ [%s](%s)", escapeTitle(req.Comment.PostTitle), req.Comment.Locator.URL+uiNav+req.Comment.ID)
+		link = fmt.Sprintf("↦ [%s](%s)", escapeTitle(req.Comment.PostTitle), commentLink)
 	}
 
 	msg := fmt.Sprintf("%s\n\n%s\n\n%s", from, req.Comment.Orig, link)
+	if commentLink == "" { // virtual locator without canonical link
+		msg = fmt.Sprintf("%s\n\n%s", from, req.Comment.Orig)
+	}
 	msg = html.UnescapeString(msg)
 	body := struct {
-		Text string `json:"text"`
+		Text        string          `json:"text"`
+		ReplyMarkup *telegramMarkup `json:"reply_markup,omitempty"`
 	}{Text: msg}
+	if len(buttons) > 0 {
+		body.ReplyMarkup = &telegramMarkup{InlineKeyboard: [][]telegramButton{buttons}}
+	}
 	b, err := json.Marshal(body)
 	if err != nil {
 		return nil, err
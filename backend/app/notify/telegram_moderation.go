@@ -0,0 +1,233 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-pkgz/lcw"
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// enum of moderation actions of telegram buttons
+const (
+	TelegramApprove = "approve"
+	TelegramDelete  = "delete"
+	TelegramBlock   = "block"
+)
+
+// TelegramSecretHeader keeps secret token of webhook, set by telegram
+const TelegramSecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+const (
+	telegramActionsTTL = 7 * 24 * time.Hour // buttons of older notifications expire
+	telegramMaxActions = 10000
+)
+
+// ErrTelegramNoCallback returned by ParseCallback for webhook updates other than pressed buttons
+var ErrTelegramNoCallback = errors.New("not a callback query")
+
+// ErrTelegramExpired returned by ParseCallback for buttons of expired notifications, action can be answered
+var ErrTelegramExpired = errors.New("moderation action expired")
+
+// TelegramAction is a moderation action requested by telegram user with button of admin notification
+type TelegramAction struct {
+	Action    string        // approve, delete or block
+	Locator   store.Locator // locator of the comment
+	CommentID string
+	AuthorID  string // author of the comment, blocked by block action
+	UserID    string // telegram id of user pressed the button
+	UserName  string // telegram username, can be empty
+
+	callbackID string
+}
+
+// telegramTarget is the comment of admin notification, kept for buttons
+type telegramTarget struct {
+	Locator   store.Locator
+	CommentID string
+	AuthorID  string
+}
+
+type telegramButton struct {
+	Text string `json:"text"`
+	Data string `json:"callback_data"`
+}
+
+type telegramMarkup struct {
+	InlineKeyboard [][]telegramButton `json:"inline_keyboard"`
+}
+
+// setupModeration makes cache of buttons' targets and registers webhook receiving pressed buttons
+func (t *Telegram) setupModeration() error {
+	if t.WebhookSecret == "" {
+		return errors.New("webhook secret required for telegram moderation")
+	}
+	var err error
+	if t.actions, err = lcw.NewExpirableCache(lcw.TTL(telegramActionsTTL), lcw.MaxKeys(telegramMaxActions)); err != nil {
+		return errors.Wrap(err, "can't make cache of telegram moderation actions")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), t.Timeout)
+	defer cancel()
+	if err = t.setWebhook(ctx); err != nil {
+		return errors.Wrap(err, "can't set telegram webhook")
+	}
+	log.Printf("[INFO] telegram moderation enabled, webhook %s, moderators %v", t.WebhookURL, t.Moderators)
+	return nil
+}
+
+// setWebhook registers webhook receiving pressed buttons of admin notifications
+func (t *Telegram) setWebhook(ctx context.Context) error {
+	req := struct {
+		URL            string   `json:"url"`
+		SecretToken    string   `json:"secret_token"`
+		AllowedUpdates []string `json:"allowed_updates"`
+	}{URL: t.WebhookURL, SecretToken: t.WebhookSecret, AllowedUpdates: []string{"callback_query"}}
+	return t.callAPI(ctx, "setWebhook", req)
+}
+
+// moderationButtons makes buttons of admin notification, approve added for comment held for moderation.
+// Target of buttons kept by the random key passed in callback data, limited by telegram to 64 bytes.
+func (t *Telegram) moderationButtons(req Request) []telegramButton {
+	if !t.Moderation || t.actions == nil || req.Comment.ID == "" || req.Comment.Deleted {
+		return nil
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("[WARN] can't make key of telegram buttons, %v", err)
+		return nil
+	}
+	key := hex.EncodeToString(b)
+	target := telegramTarget{Locator: req.Comment.Locator, CommentID: req.Comment.ID, AuthorID: req.Comment.User.ID}
+	_, _ = t.actions.Get(key, func() (interface{}, error) { return target, nil })
+
+	res := []telegramButton{}
+	if req.Comment.Pending {
+		res = append(res, telegramButton{Text: "✅ approve", Data: TelegramApprove + ":" + key})
+	}
+	return append(res, telegramButton{Text: "🗑 delete", Data: TelegramDelete + ":" + key},
+		telegramButton{Text: "⛔️ block", Data: TelegramBlock + ":" + key})
+}
+
+// CheckSecret checks secret token of webhook request
+func (t *Telegram) CheckSecret(r *http.Request) bool {
+	return t.WebhookSecret != "" &&
+		subtle.ConstantTimeCompare([]byte(r.Header.Get(TelegramSecretHeader)), []byte(t.WebhookSecret)) == 1
+}
+
+// IsModerator checks if telegram user is one of moderators set in params
+func (t *Telegram) IsModerator(userID string) bool {
+	for _, id := range t.Moderators {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseCallback parses webhook update with pressed button and returns requested action.
+// Action returned with ErrTelegramExpired too, to be answered.
+func (t *Telegram) ParseCallback(body []byte) (TelegramAction, error) {
+	update := struct {
+		CallbackQuery *struct {
+			ID   string `json:"id"`
+			Data string `json:"data"`
+			From struct {
+				ID       int64  `json:"id"`
+				UserName string `json:"username"`
+			} `json:"from"`
+		} `json:"callback_query"`
+	}{}
+	if err := json.Unmarshal(body, &update); err != nil {
+		return TelegramAction{}, errors.Wrap(err, "can't decode telegram update")
+	}
+	cb := update.CallbackQuery
+	if cb == nil {
+		return TelegramAction{}, ErrTelegramNoCallback
+	}
+	res := TelegramAction{UserID: strconv.FormatInt(cb.From.ID, 10), UserName: cb.From.UserName, callbackID: cb.ID}
+	elems := strings.SplitN(cb.Data, ":", 2)
+	if len(elems) != 2 {
+		return res, errors.Errorf("bad callback data %q", cb.Data)
+	}
+	switch elems[0] {
+	case TelegramApprove, TelegramDelete, TelegramBlock:
+		res.Action = elems[0]
+	default:
+		return res, errors.Errorf("unknown moderation action %q", elems[0])
+	}
+	if t.actions == nil {
+		return res, ErrTelegramExpired
+	}
+	v, ok := t.actions.Peek(elems[1])
+	target, isTarget := v.(telegramTarget)
+	if !ok || !isTarget {
+		return res, ErrTelegramExpired
+	}
+	res.Locator, res.CommentID, res.AuthorID = target.Locator, target.CommentID, target.AuthorID
+	return res, nil
+}
+
+// Answer shows result of the action to telegram user pressed the button
+func (t *Telegram) Answer(ctx context.Context, action TelegramAction, text string) error {
+	req := struct {
+		ID   string `json:"callback_query_id"`
+		Text string `json:"text"`
+	}{ID: action.callbackID, Text: text}
+	return t.callAPI(ctx, "answerCallbackQuery", req)
+}
+
+// Close stops cleanup of buttons' targets, called by Service on close
+func (t *Telegram) Close() {
+	if t.actions == nil {
+		return
+	}
+	if err := t.actions.Close(); err != nil {
+		log.Printf("[WARN] can't close telegram moderation actions cache, %v", err)
+	}
+}
+
+// callAPI calls method of telegram bot api with json request, fails on response not ok
+func (t *Telegram) callAPI(ctx context.Context, method string, req interface{}) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return errors.Wrapf(err, "can't marshal %s request", method)
+	}
+	r, err := http.NewRequest("POST", fmt.Sprintf("%s%s/%s", t.apiPrefix, t.token(), method), bytes.NewReader(b))
+	if err != nil {
+		return errors.Wrapf(err, "failed to make %s request", method)
+	}
+	r.Header.Set("Content-Type", "application/json; charset=utf-8")
+	client := http.Client{Timeout: t.Timeout}
+	resp, err := client.Do(r.WithContext(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "failed to get %s response", method)
+	}
+	defer func() {
+		if err = resp.Body.Close(); err != nil {
+			log.Printf("[WARN] can't close request body, %s", err)
+		}
+	}()
+	tgResp := struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}{}
+	if err = json.NewDecoder(resp.Body).Decode(&tgResp); err != nil {
+		return errors.Wrapf(err, "can't decode %s response, status %d", method, resp.StatusCode)
+	}
+	if !tgResp.OK {
+		return errors.Errorf("%s failed with status %d, %s", method, resp.StatusCode, tgResp.Description)
+	}
+	return nil
+}
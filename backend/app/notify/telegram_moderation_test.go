@@ -0,0 +1,176 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestTelegram_Moderation(t *testing.T) {
+	ts, calls := mockTelegramModerationServer(t)
+	defer ts.Close()
+
+	_, err := NewTelegram(TelegramParams{Token: "good-token", Moderation: true, apiPrefix: ts.URL + "/"})
+	assert.EqualError(t, err, "webhook secret required for telegram moderation")
+
+	tb, err := NewTelegram(TelegramParams{AdminChannelID: "remark_test", Token: "good-token", Moderation: true,
+		WebhookURL: "https://remark42.example.com/api/v1/telegram/webhook", WebhookSecret: "secret",
+		Moderators: []string{"123"}, apiPrefix: ts.URL + "/"})
+	require.NoError(t, err)
+	defer tb.Close()
+	require.Equal(t, 1, len(calls("setWebhook")))
+	assert.Equal(t, map[string]interface{}{"url": "https://remark42.example.com/api/v1/telegram/webhook",
+		"secret_token": "secret", "allowed_updates": []interface{}{"callback_query"}}, calls("setWebhook")[0])
+
+	c := store.Comment{ID: "c1", Orig: "some text", Pending: true, User: store.User{ID: "user1", Name: "from"},
+		Locator: store.Locator{SiteID: "remark", URL: "https://example.com/post"}}
+	require.NoError(t, tb.Send(context.Background(), Request{Comment: c}))
+	c.Pending = false
+	require.NoError(t, tb.Send(context.Background(), Request{Comment: c}))
+
+	msgs := calls("sendMessage")
+	require.Equal(t, 2, len(msgs))
+	buttons := func(msg map[string]interface{}) (res []string) {
+		rows := msg["reply_markup"].(map[string]interface{})["inline_keyboard"].([]interface{})
+		for _, b := range rows[0].([]interface{}) {
+			res = append(res, b.(map[string]interface{})["callback_data"].(string))
+		}
+		return res
+	}
+	pending := buttons(msgs[0])
+	require.Equal(t, 3, len(pending), "approve added for pending comment")
+	assert.True(t, strings.HasPrefix(pending[0], "approve:"))
+	assert.Equal(t, 2, len(buttons(msgs[1])))
+	for _, b := range pending {
+		assert.True(t, len(b) <= 64, "callback data limited by telegram")
+	}
+
+	update := func(data string) []byte {
+		return []byte(`{"update_id":1,"callback_query":{"id":"cb1","data":"` + data +
+			`","from":{"id":123,"username":"admin"}}}`)
+	}
+	action, err := tb.ParseCallback(update(pending[2]))
+	require.NoError(t, err)
+	assert.Equal(t, TelegramAction{Action: TelegramBlock, Locator: c.Locator, CommentID: "c1", AuthorID: "user1",
+		UserID: "123", UserName: "admin", callbackID: "cb1"}, action)
+	assert.True(t, tb.IsModerator(action.UserID))
+	assert.False(t, tb.IsModerator("456"))
+
+	require.NoError(t, tb.Answer(context.Background(), action, "blocked"))
+	assert.Equal(t, []map[string]interface{}{{"callback_query_id": "cb1", "text": "blocked"}}, calls("answerCallbackQuery"))
+
+	action, err = tb.ParseCallback(update("delete:unknown"))
+	assert.Equal(t, ErrTelegramExpired, err)
+	assert.Equal(t, TelegramDelete, action.Action)
+	assert.Equal(t, "cb1", action.callbackID, "expired action can be answered")
+
+	_, err = tb.ParseCallback(update("blah:" + strings.TrimPrefix(pending[2], "block:")))
+	assert.EqualError(t, err, `unknown moderation action "blah"`)
+	_, err = tb.ParseCallback(update("blah"))
+	assert.EqualError(t, err, `bad callback data "blah"`)
+	_, err = tb.ParseCallback([]byte(`{"update_id":1,"message":{"text":"hi"}}`))
+	assert.Equal(t, ErrTelegramNoCallback, err)
+	_, err = tb.ParseCallback([]byte(`{bad`))
+	assert.Error(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook", nil)
+	assert.False(t, tb.CheckSecret(req))
+	req.Header.Set(TelegramSecretHeader, "bad")
+	assert.False(t, tb.CheckSecret(req))
+	req.Header.Set(TelegramSecretHeader, "secret")
+	assert.True(t, tb.CheckSecret(req))
+}
+
+func TestTelegram_ModerationDisabled(t *testing.T) {
+	ts, calls := mockTelegramModerationServer(t)
+	defer ts.Close()
+
+	tb, err := NewTelegram(TelegramParams{AdminChannelID: "remark_test", Token: "good-token", apiPrefix: ts.URL + "/"})
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(calls("setWebhook")))
+
+	c := store.Comment{ID: "c1", Orig: "some text", Pending: true, Locator: store.Locator{SiteID: "remark", URL: "u"}}
+	require.NoError(t, tb.Send(context.Background(), Request{Comment: c}))
+	require.Equal(t, 1, len(calls("sendMessage")))
+	assert.NotContains(t, calls("sendMessage")[0], "reply_markup")
+
+	_, err = tb.ParseCallback([]byte(`{"callback_query":{"id":"cb1","data":"delete:1234","from":{"id":123}}}`))
+	assert.Equal(t, ErrTelegramExpired, err)
+	tb.Close()
+
+	svc := NewService(nil, 1, tb)
+	defer svc.Close()
+	assert.Equal(t, tb, svc.Telegram())
+	assert.Nil(t, NewService(nil, 1, &MockDest{}).Telegram())
+	var nilService *Service
+	assert.Nil(t, nilService.Telegram())
+
+	throttled := NewThrottled(tb, ThrottleParams{PerMinute: 10})
+	svc = NewService(nil, 1, throttled)
+	defer svc.Close()
+	assert.Equal(t, tb, svc.Telegram(), "throttled destination unwrapped")
+	assert.Nil(t, svc.Email())
+	assert.Nil(t, nilService.Email())
+}
+
+func TestTelegram_SetToken(t *testing.T) {
+	ts := mockTelegramServer()
+	defer ts.Close()
+
+	tb, err := NewTelegram(TelegramParams{AdminChannelID: "remark_test", Token: "good-token", apiPrefix: ts.URL + "/"})
+	require.NoError(t, err)
+	assert.EqualError(t, tb.TestToken(context.Background(), ""), "empty telegram token")
+	assert.EqualError(t, tb.TestToken(context.Background(), "404"), "unexpected telegram status code 404")
+	assert.EqualError(t, tb.SetToken(context.Background(), "404"), "unexpected telegram status code 404")
+	assert.Equal(t, "good-token", tb.token(), "not switched to bad token")
+
+	// moderation webhook registered for the new bot
+	mts, calls := mockTelegramModerationServer(t)
+	defer mts.Close()
+	tb, err = NewTelegram(TelegramParams{Token: "old-token", Moderation: true, WebhookURL: "https://example.com/webhook",
+		WebhookSecret: "secret", apiPrefix: mts.URL + "/"})
+	require.NoError(t, err)
+	defer tb.Close()
+	require.NoError(t, tb.TestToken(context.Background(), "new-token"))
+	assert.Equal(t, 1, len(calls("setWebhook")), "not registered by check")
+	require.NoError(t, tb.SetToken(context.Background(), "new-token"))
+	assert.Equal(t, "new-token", tb.token())
+	assert.Equal(t, 2, len(calls("setWebhook")))
+}
+
+// mockTelegramModerationServer records json requests of bot api methods
+func mockTelegramModerationServer(t *testing.T) (ts *httptest.Server, calls func(method string) []map[string]interface{}) {
+	var lock sync.Mutex
+	requests := map[string][]map[string]interface{}{}
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		if method == "getMe" {
+			_, _ = w.Write([]byte(`{"ok": true, "result": {"id": 707381019, "is_bot": true}}`))
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		req := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal(body, &req))
+		lock.Lock()
+		requests[method] = append(requests[method], req)
+		lock.Unlock()
+		_, _ = w.Write([]byte(`{"ok": true}`))
+	}))
+	calls = func(method string) []map[string]interface{} {
+		lock.Lock()
+		defer lock.Unlock()
+		return requests[method]
+	}
+	return ts, calls
+}
@@ -136,6 +136,35 @@ func TestTelegram_Send(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestTelegram_SendAdminChats(t *testing.T) {
+	var chats []string
+	router := chi.NewRouter()
+	router.Get("/good-token/getMe", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"ok": true, "result": {"first_name": "comments_test", "is_bot": true}}`))
+	})
+	router.Post("/good-token/sendMessage", func(w http.ResponseWriter, r *http.Request) {
+		chats = append(chats, r.URL.Query().Get("chat_id"))
+		_, _ = w.Write([]byte(`{"ok": true}`))
+	})
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	tb, err := NewTelegram(TelegramParams{AdminChannelID: "remark_test", Token: "good-token", apiPrefix: ts.URL + "/"})
+	require.NoError(t, err)
+	c := store.Comment{Text: "some text", ID: "999", Pending: true}
+
+	require.NoError(t, tb.Send(context.TODO(), Request{Comment: c, AdminChats: []string{"111", "@remark_test"}}))
+	assert.Equal(t, []string{"@remark_test", "111"}, chats, "channel not notified twice")
+
+	chats = nil
+	require.NoError(t, tb.Send(context.TODO(), Request{Comment: c, AdminChats: []string{"111"}, skipShared: true}))
+	assert.Equal(t, []string{"111"}, chats, "channel skipped")
+
+	tb, err = NewTelegram(TelegramParams{Token: "good-token", apiPrefix: ts.URL + "/"})
+	require.NoError(t, err)
+	assert.Equal(t, "", tb.AdminChannelID, "no channel, admin chats only")
+}
+
 func TestTelegram_SendVerification(t *testing.T) {
 	ts := mockTelegramServer()
 	defer ts.Close()
@@ -195,6 +224,29 @@ func mockTelegramServer() *httptest.Server {
 	return httptest.NewServer(router)
 }
 
+func Test_buildTelegramMessage(t *testing.T) {
+	c := store.Comment{Orig: "some text", ID: "999", Locator: store.Locator{URL: "https://example.com/post"}}
+	c.User.Name = "from"
+	msg, err := buildTelegramMessage(Request{Comment: c})
+	require.NoError(t, err)
+	assert.Equal(t, `{"text":"*from*\n\nsome text\n\n↦ [original comment](https://example.com/post#remark42__comment-999)"}`,
+		string(msg))
+
+	msg, err = buildTelegramMessage(Request{Comment: c, Keywords: []string{"acme", "law suit"}})
+	require.NoError(t, err)
+	assert.Equal(t, `{"text":"⚠️ *keywords alert:* acme, law suit\n\n*from*\n\nsome text\n\n↦ [original comment](https://example.com/post#remark42__comment-999)"}`,
+		string(msg))
+
+	c.Locator = store.Locator{SiteID: "app", URL: "virtual:screen-1"}
+	msg, err = buildTelegramMessage(Request{Comment: c})
+	require.NoError(t, err)
+	assert.Equal(t, `{"text":"*from*\n\nsome text"}`, string(msg), "no link of virtual locator")
+	msg, err = buildTelegramMessage(Request{Comment: c, links: store.Links{"app": "https://app.example.com/{key}"}})
+	require.NoError(t, err)
+	assert.Equal(t, `{"text":"*from*\n\nsome text\n\n↦ [original comment](https://app.example.com/screen-1#remark42__comment-999)"}`,
+		string(msg))
+}
+
 func Test_escapeTitle(t *testing.T) {
 	tbl := []struct {
 		inp string
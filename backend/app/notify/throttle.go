@@ -0,0 +1,338 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+)
+
+// ThrottleParams defines limits of outgoing messages for a destination
+type ThrottleParams struct {
+	PerMinute int           // max messages sent per minute, 0 for unlimited
+	Cooldown  time.Duration // min interval between comment notifications to the same recipient, 0 for none
+	QueueSize int           // max number of delayed messages, defaultThrottleQueueSize if 0
+
+	FlushTimeout time.Duration // max time to send queued messages on close, defaultThrottleFlushTimeout if 0
+}
+
+// Throttled wraps destination and limits rate of outgoing messages. Messages over the limits
+// are queued and sent later, dropped only if queue is full. Queued messages sent on close bypassing the limits.
+type Throttled struct {
+	dest   Destination
+	params ThrottleParams
+
+	mu       sync.Mutex
+	queue    []throttledMsg
+	sent     []time.Time          // send times within the last minute, one per message
+	lastSent map[string]time.Time // last send time per recipient
+	wake     chan struct{}
+	closed   bool
+
+	ctx        context.Context // context of sending, canceled after flush on close
+	cancel     context.CancelFunc
+	loopCancel context.CancelFunc // stops sending loop, message in flight sent anyway
+	done       chan struct{}
+	now        func() time.Time
+
+	metrics  Metrics
+	statuses StatusStore
+	breaker  *breaker
+}
+
+// throttledMsg is either comment notification or verification request waiting for sending
+type throttledMsg struct {
+	req          *Request
+	verification *VerificationRequest
+	recipients   []string
+}
+
+// recipientsLister implemented by destinations sending separate messages to multiple recipients
+type recipientsLister interface {
+	recipients(req Request) []string
+}
+
+const (
+	defaultThrottleQueueSize    = 1000
+	defaultThrottleFlushTimeout = 10 * time.Second
+)
+
+// NewThrottled makes destination wrapper with limits and starts sending loop, Close should be called to stop it
+func NewThrottled(dest Destination, params ThrottleParams) *Throttled {
+	if params.QueueSize <= 0 {
+		params.QueueSize = defaultThrottleQueueSize
+	}
+	if params.FlushTimeout <= 0 {
+		params.FlushTimeout = defaultThrottleFlushTimeout
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	loopCtx, loopCancel := context.WithCancel(ctx)
+	res := Throttled{
+		dest:       dest,
+		params:     params,
+		lastSent:   map[string]time.Time{},
+		wake:       make(chan struct{}, 1),
+		ctx:        ctx,
+		cancel:     cancel,
+		loopCancel: loopCancel,
+		done:       make(chan struct{}),
+		now:        time.Now,
+	}
+	go res.do(loopCtx)
+	log.Printf("[INFO] throttle %s, per minute=%d, cooldown=%v, queue size=%d",
+		dest, params.PerMinute, params.Cooldown, params.QueueSize)
+	return &res
+}
+
+// Send queues comment notification, actual sending done asynchronously within the limits. Returns error
+// only if the queue is full. Failures of actual sending, after retries done by the wrapped destination, can't
+// be returned to the caller gone by then, so they are logged, counted in metrics and recorded in status store.
+// Recipient cooldown applied to destinations sending separate messages to recipients only, like email.
+func (t *Throttled) Send(_ context.Context, req Request) error {
+	var recipients []string
+	if l, ok := t.dest.(recipientsLister); ok {
+		recipients = l.recipients(req)
+	}
+	return t.push(throttledMsg{req: &req, recipients: recipients})
+}
+
+// SendVerification queues verification request. Such requests limited by rate only, recipient cooldown not applied.
+func (t *Throttled) SendVerification(_ context.Context, req VerificationRequest) error {
+	return t.push(throttledMsg{verification: &req})
+}
+
+// logsDeliveries implements deliveryLogger for wrapped destination
+func (t *Throttled) logsDeliveries() bool {
+	return logsDeliveries(t.dest)
+}
+
+// setMetrics implements metricsReporter, sent messages reported by Throttled and passed to wrapped destination
+func (t *Throttled) setMetrics(m Metrics) {
+	t.metrics = m
+	if t.breaker != nil {
+		t.breaker.setMetrics(m)
+	}
+	if mr, ok := t.dest.(metricsReporter); ok {
+		mr.setMetrics(m)
+	}
+}
+
+// setStatusStore implements statusReporter, statuses of messages recorded on actual sending
+func (t *Throttled) setStatusStore(st StatusStore) {
+	t.statuses = st
+}
+
+// setBreaker implements breakerUser, circuit checked on actual sending
+func (t *Throttled) setBreaker(b *breaker) {
+	t.breaker = b
+}
+
+// String representation of Throttled
+func (t *Throttled) String() string {
+	return fmt.Sprintf("throttled %s", t.dest)
+}
+
+// Close stops sending loop and sends queued messages bypassing the limits, up to FlushTimeout.
+// Messages not sent by then dropped.
+func (t *Throttled) Close() {
+	t.closeWithin(t.params.FlushTimeout)
+}
+
+// closeWithin stops accepting messages and sends queued ones bypassing the limits. Sending, including
+// the message in flight, canceled after timeout.
+func (t *Throttled) closeWithin(timeout time.Duration) {
+	t.mu.Lock()
+	t.closed = true
+	t.mu.Unlock()
+
+	timer := time.AfterFunc(timeout, t.cancel)
+	defer timer.Stop()
+	t.loopCancel()
+	<-t.done
+
+	t.mu.Lock()
+	queue := t.queue
+	t.queue = nil
+	t.mu.Unlock()
+	for i, m := range queue {
+		if t.ctx.Err() != nil {
+			log.Printf("[WARN] %d throttled messages to %s not sent in %v, dropped", len(queue)-i, t.dest, timeout)
+			break
+		}
+		t.send(m)
+	}
+	t.cancel()
+}
+
+// Queued returns number of messages waiting for sending
+func (t *Throttled) Queued() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.queue)
+}
+
+func (t *Throttled) push(m throttledMsg) error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return fmt.Errorf("throttle of %s closed", t.dest)
+	}
+	if len(t.queue) >= t.params.QueueSize {
+		t.mu.Unlock()
+		return fmt.Errorf("throttle queue of %s is full, %d messages", t.dest, len(t.queue))
+	}
+	t.queue = append(t.queue, m)
+	t.mu.Unlock()
+
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// do sends queued messages as soon as limits allow, till ctx canceled
+func (t *Throttled) do(ctx context.Context) {
+	defer close(t.done)
+	for ctx.Err() == nil {
+		m, wait, ok := t.next()
+		if ok {
+			t.send(m)
+			continue
+		}
+
+		var timer *time.Timer
+		var timerCh <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			timerCh = timer.C
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.wake:
+		case <-timerCh:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// next pops the first message allowed to send now. Returns time to wait if nothing allowed,
+// zero wait means queue is empty.
+func (t *Throttled) next() (m throttledMsg, wait time.Duration, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.queue) == 0 {
+		return throttledMsg{}, 0, false
+	}
+
+	now := t.now()
+	for len(t.sent) > 0 && now.Sub(t.sent[0]) >= time.Minute {
+		t.sent = t.sent[1:]
+	}
+
+	if t.params.PerMinute > 0 && len(t.sent) >= t.params.PerMinute {
+		return throttledMsg{}, t.rateWait(1, now), false
+	}
+
+	for i, msg := range t.queue {
+		cost := len(msg.recipients)
+		if cost == 0 {
+			cost = 1
+		}
+		// message with more recipients than allowed per minute sent alone, otherwise it blocks the queue forever
+		if t.params.PerMinute > 0 && len(t.sent) > 0 && len(t.sent)+cost > t.params.PerMinute {
+			if rateWait := t.rateWait(cost, now); wait == 0 || rateWait < wait {
+				wait = rateWait
+			}
+			continue
+		}
+
+		if cooldown := t.cooldownWait(msg, now); cooldown > 0 {
+			if wait == 0 || cooldown < wait {
+				wait = cooldown
+			}
+			continue
+		}
+
+		t.queue = append(t.queue[:i], t.queue[i+1:]...)
+		for j := 0; j < cost; j++ {
+			t.sent = append(t.sent, now)
+		}
+		if t.params.Cooldown > 0 {
+			for _, r := range msg.recipients {
+				t.lastSent[r] = now
+			}
+			t.cleanup(now)
+		}
+		return msg, 0, true
+	}
+
+	if wait <= 0 {
+		wait = time.Millisecond
+	}
+	return throttledMsg{}, wait, false
+}
+
+// rateWait returns time left till the message with given cost fits into per-minute limit, called under lock
+func (t *Throttled) rateWait(cost int, now time.Time) time.Duration {
+	idx := len(t.sent) + cost - t.params.PerMinute - 1
+	if idx >= len(t.sent) {
+		idx = len(t.sent) - 1
+	}
+	return t.sent[idx].Add(time.Minute).Sub(now)
+}
+
+// cooldownWait returns time left till all recipients of the message can get it.
+// Verifications and keyword alerts are not delayed by cooldown.
+func (t *Throttled) cooldownWait(m throttledMsg, now time.Time) (wait time.Duration) {
+	if t.params.Cooldown <= 0 || m.verification != nil || len(m.req.Keywords) > 0 {
+		return 0
+	}
+	for _, r := range m.recipients {
+		last, ok := t.lastSent[r]
+		if !ok {
+			continue
+		}
+		if w := last.Add(t.params.Cooldown).Sub(now); w > wait {
+			wait = w
+		}
+	}
+	return wait
+}
+
+// cleanup removes expired recipients from lastSent, called under lock
+func (t *Throttled) cleanup(now time.Time) {
+	if len(t.lastSent) < t.params.QueueSize {
+		return
+	}
+	for r, last := range t.lastSent {
+		if now.Sub(last) >= t.params.Cooldown {
+			delete(t.lastSent, r)
+		}
+	}
+}
+
+func (t *Throttled) send(m throttledMsg) {
+	ctx, st := withSendStats(t.ctx)
+	if m.verification != nil {
+		err := t.breaker.do(func() error { return t.dest.SendVerification(ctx, *m.verification) })
+		if err != nil {
+			log.Printf("[WARN] failed to send verification to %s, %s", t.dest, err)
+		}
+		recordStatus(t.statuses, t.dest, nil, m.verification, st, err)
+		return
+	}
+	err := t.breaker.do(func() error { return m.req.send(ctx, t.dest) })
+	if err != nil {
+		log.Printf("[WARN] failed to send to %s, %s", t.dest, err)
+	}
+	recordStatus(t.statuses, t.dest, m.req, nil, st, err)
+	if t.metrics != nil {
+		t.metrics.NotificationSent(destinationName(t.dest), err)
+	}
+}
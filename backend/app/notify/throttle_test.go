@@ -0,0 +1,193 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestThrottled_Cooldown(t *testing.T) {
+	dest := &listerDest{MockDest: MockDest{id: 1}}
+	th := NewThrottled(dest, ThrottleParams{Cooldown: 300 * time.Millisecond})
+	assert.Equal(t, "throttled mock id=1, closed=false", th.String())
+
+	user := store.User{ID: "u1"}
+	for _, id := range []string{"c1", "c2", "c3"} {
+		require.NoError(t, th.Send(context.Background(), Request{Comment: store.Comment{ID: id, User: user}}))
+	}
+	require.NoError(t, th.SendVerification(context.Background(), VerificationRequest{User: "u1"}))
+	require.NoError(t, th.Send(context.Background(), Request{Comment: store.Comment{ID: "c4", User: user}, Keywords: []string{"kw"}}))
+
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, 2, len(dest.Get()), "second message delayed by cooldown, alert sent")
+	assert.Equal(t, "c4", dest.Get()[1].Comment.ID)
+	assert.Equal(t, 1, len(dest.GetVerify()), "verification not delayed by cooldown")
+	assert.Equal(t, 2, th.Queued())
+
+	time.Sleep(350 * time.Millisecond)
+	require.Equal(t, 3, len(dest.Get()))
+	assert.Equal(t, "c2", dest.Get()[2].Comment.ID)
+
+	th.Close()
+	assert.Equal(t, 0, th.Queued())
+	require.Equal(t, 4, len(dest.Get()), "queued message sent on close")
+	assert.Equal(t, "c3", dest.Get()[3].Comment.ID)
+}
+
+func TestThrottled_Close(t *testing.T) {
+	dest := &MockDest{id: 1}
+	th := NewThrottled(dest, ThrottleParams{PerMinute: 1})
+	for _, id := range []string{"c1", "c2", "c3", "c4", "c5"} {
+		require.NoError(t, th.Send(context.Background(), Request{Comment: store.Comment{ID: id}}))
+	}
+	require.NoError(t, th.SendVerification(context.Background(), VerificationRequest{User: "u1"}))
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, 1, len(dest.Get()), "rate limit reached")
+	assert.Equal(t, 5, th.Queued())
+
+	th.Close()
+	assert.Equal(t, 0, th.Queued())
+	res := dest.Get()
+	require.Equal(t, 5, len(res), "all queued messages sent on close, limits bypassed")
+	for i, id := range []string{"c1", "c2", "c3", "c4", "c5"} {
+		assert.Equal(t, id, res[i].Comment.ID)
+	}
+	assert.Equal(t, 1, len(dest.GetVerify()))
+	assert.EqualError(t, th.Send(context.Background(), Request{Comment: store.Comment{ID: "c6"}}),
+		"throttle of mock id=1, closed=false closed")
+
+	// flush limited by timeout, the rest dropped
+	dest = &MockDest{id: 2}
+	th = NewThrottled(dest, ThrottleParams{PerMinute: 1, FlushTimeout: 55 * time.Millisecond})
+	for i := 0; i < 20; i++ {
+		require.NoError(t, th.Send(context.Background(), Request{Comment: store.Comment{ID: fmt.Sprintf("c%d", i)}}))
+	}
+	time.Sleep(50 * time.Millisecond)
+	st := time.Now()
+	th.Close()
+	assert.True(t, time.Since(st) < 500*time.Millisecond, "close bounded by flush timeout")
+	assert.True(t, len(dest.Get()) > 1 && len(dest.Get()) < 20, "sent %d", len(dest.Get()))
+}
+
+func TestThrottled_CooldownNotLister(t *testing.T) {
+	dest := &MockDest{id: 1}
+	th := NewThrottled(dest, ThrottleParams{Cooldown: time.Minute})
+	defer th.Close()
+	for _, id := range []string{"c1", "c2", "c3"} {
+		require.NoError(t, th.Send(context.Background(), Request{Comment: store.Comment{ID: id, User: store.User{ID: "u1"}}}))
+	}
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 3, len(dest.Get()), "destination without recipients, like telegram channel, not cooled down")
+	assert.Equal(t, 0, th.Queued())
+}
+
+func TestThrottled_SendFailed(t *testing.T) {
+	statuses := NewEngineStatuses(prepEngine(t), 0)
+	dest := &failingDest{}
+	th := NewThrottled(dest, ThrottleParams{PerMinute: 100})
+	defer th.Close()
+	th.setStatusStore(statuses)
+
+	req := Request{Comment: store.Comment{ID: "c1", Locator: store.Locator{SiteID: "remark", URL: "http://example.com"}}}
+	assert.NoError(t, th.Send(context.Background(), req), "queued, failure of sending not returned")
+	require.Eventually(t, func() bool { return dest.count() == 1 }, time.Second, 10*time.Millisecond)
+	var res []SendStatus
+	require.Eventually(t, func() bool {
+		var err error
+		res, err = statuses.List("remark", StatusFilter{})
+		return err == nil && len(res) == 1
+	}, time.Second, 10*time.Millisecond, "failure recorded in status store")
+	assert.False(t, res[0].Success)
+	assert.Equal(t, "failed", res[0].Error)
+	assert.Equal(t, "c1", res[0].CommentID)
+}
+
+func TestThrottled_next(t *testing.T) {
+	now := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	th := &Throttled{dest: &MockDest{}, params: ThrottleParams{PerMinute: 3, Cooldown: 10 * time.Second, QueueSize: 10},
+		lastSent: map[string]time.Time{}, now: func() time.Time { return now }}
+
+	_, wait, ok := th.next()
+	assert.False(t, ok)
+	assert.Equal(t, time.Duration(0), wait, "empty queue")
+
+	for _, m := range []throttledMsg{
+		{req: &Request{Comment: store.Comment{ID: "c1"}}, recipients: []string{"a@example.com", "b@example.com"}},
+		{req: &Request{Comment: store.Comment{ID: "c2"}}, recipients: []string{"a@example.com"}},
+		{req: &Request{Comment: store.Comment{ID: "c3"}}, recipients: []string{"c@example.com"}},
+		{req: &Request{Comment: store.Comment{ID: "c4"}}, recipients: []string{"c@example.com", "d@example.com",
+			"e@example.com", "f@example.com"}},
+	} {
+		require.NoError(t, th.push(m))
+	}
+
+	m, _, ok := th.next()
+	require.True(t, ok)
+	assert.Equal(t, "c1", m.req.Comment.ID)
+
+	m, _, ok = th.next()
+	require.True(t, ok)
+	assert.Equal(t, "c3", m.req.Comment.ID, "c2 skipped as a@example.com cooling down")
+
+	_, wait, ok = th.next()
+	assert.False(t, ok)
+	assert.Equal(t, time.Minute, wait, "3 messages sent, rate limit reached")
+
+	now = now.Add(time.Minute)
+	m, _, ok = th.next()
+	require.True(t, ok, "c2 allowed after cooldown and rate limit window")
+	assert.Equal(t, "c2", m.req.Comment.ID)
+
+	now = now.Add(20 * time.Second)
+	_, wait, ok = th.next()
+	assert.False(t, ok, "c4 with 4 recipients waits for the empty window")
+	assert.Equal(t, 40*time.Second, wait)
+
+	now = now.Add(40 * time.Second)
+	m, _, ok = th.next()
+	require.True(t, ok)
+	assert.Equal(t, "c4", m.req.Comment.ID)
+	assert.Equal(t, 4, len(th.sent))
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, th.push(throttledMsg{verification: &VerificationRequest{User: "u1"}}))
+	}
+	assert.EqualError(t, th.push(throttledMsg{verification: &VerificationRequest{User: "u1"}}),
+		"throttle queue of mock id=0, closed=false is full, 10 messages")
+}
+
+func TestThrottled_WithService(t *testing.T) {
+	dest := &MockDest{id: 1}
+	th := NewThrottled(dest, ThrottleParams{PerMinute: 2})
+	s := NewService(nil, 1, th)
+	for _, id := range []string{"c1", "c2", "c3", "c4"} {
+		s.Submit(Request{Comment: store.Comment{ID: id}})
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 2, len(dest.Get()), "2 messages sent, others queued and not dropped")
+	assert.Equal(t, 2, th.Queued())
+
+	s.Close()
+	assert.Equal(t, 0, th.Queued(), "throttled destination closed with service")
+}
+
+func TestEmail_recipients(t *testing.T) {
+	e := Email{EmailParams: EmailParams{AdminEmails: []string{"admin@example.com"}}}
+	req := Request{Emails: []string{"u1@example.com"}}
+	assert.Equal(t, []string{"u1@example.com", "admin@example.com"}, e.recipients(req))
+	assert.Equal(t, []string{"u1@example.com"}, req.Emails)
+}
+
+// listerDest is mock destination sending separate messages to authors of comments, like email
+type listerDest struct {
+	MockDest
+}
+
+func (l *listerDest) recipients(req Request) []string { return []string{req.Comment.User.ID} }
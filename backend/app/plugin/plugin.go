@@ -0,0 +1,188 @@
+// Package plugin provides server-side extension points. Plugins subscribe to hooks and get events
+// on comment create, edit and delete, user blocking and authentication and notification dispatch.
+// Before-hooks (create, edit, deleting, user block, auth and notify filter) are synchronous and allow plugin to change
+// comment text or reject the action, other hooks are informational. Plugins usually run as sidecar services called over json-rpc, see RPC,
+// or as sandboxed scripts, see Script.
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	log "github.com/go-pkgz/lgr"
+
+	"github.com/umputun/remark42/backend/app/notify"
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// Hook defines the point where plugins called
+type Hook string
+
+// enum of all hooks
+const (
+	HookCommentCreate   Hook = "comment.create"   // before comment saved, plugin may change text or reject comment
+	HookCommentCreated  Hook = "comment.created"  // after comment saved
+	HookCommentEdit     Hook = "comment.edit"     // before comment edit applied, plugin may change text or reject edit
+	HookCommentDeleting Hook = "comment.deleting" // before comment deleted by user or admin, plugin may reject deletion
+	HookCommentDelete   Hook = "comment.delete"   // after comment deleted by user or admin
+	HookUserBlock       Hook = "user.block"       // before user blocked by admin, plugin may reject blocking
+	HookAuth            Hook = "auth"             // on login and token refresh, plugin may reject (block) user
+	HookNotify          Hook = "notify"           // on notification dispatch for new comment
+	HookNotifyFilter    Hook = "notify.filter"    // before notification for new comment sent, plugin may reject (drop) it
+)
+
+// Event passed to plugin
+type Event struct {
+	Hook    Hook           `json:"hook"`
+	SiteID  string         `json:"site"`
+	Comment *store.Comment `json:"comment,omitempty"`
+	User    *store.User    `json:"user,omitempty"`
+	Reason  string         `json:"reason,omitempty"` // reason of deletion or blocking given by admin
+}
+
+// Result returned by plugin, used by before-hooks only
+type Result struct {
+	Reject  bool   `json:"reject,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+	Text    string `json:"text,omitempty"` // replacement of comment's html text, empty to keep as is
+	Orig    string `json:"orig,omitempty"` // replacement of comment's original (markdown) text
+	Changed bool   `json:"changed,omitempty"`
+}
+
+// Plugin handles events of hooks it subscribed to
+type Plugin interface {
+	Hooks() []Hook
+	Handle(ev Event) (Result, error)
+	fmt.Stringer
+}
+
+// RejectedError returned by before-hooks when plugin rejects the action
+type RejectedError struct {
+	Plugin string
+	Reason string
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("rejected by plugin %s: %s", e.Plugin, e.Reason)
+}
+
+// Service dispatches events to plugins. Nil Service is valid and does nothing.
+type Service struct {
+	plugins []Plugin
+	wg      sync.WaitGroup
+}
+
+// NewService makes Service for given plugins
+func NewService(plugins ...Plugin) *Service {
+	for _, p := range plugins {
+		log.Printf("[INFO] plugin %s, hooks %v", p, p.Hooks())
+	}
+	return &Service{plugins: plugins}
+}
+
+// Has checks if any plugin subscribed to the hook
+func (s *Service) Has(hook Hook) bool {
+	return len(s.subscribed(hook)) > 0
+}
+
+// Before calls plugins subscribed to ev.Hook one by one, each plugin gets comment changed by previous ones.
+// Returns possibly changed event or *RejectedError if any plugin rejected it. Failed plugin calls only logged,
+// so broken plugin doesn't block commenting.
+func (s *Service) Before(ev Event) (Event, error) {
+	for _, p := range s.subscribed(ev.Hook) {
+		res, err := p.Handle(ev)
+		if err != nil {
+			log.Printf("[WARN] plugin %s failed on %s, %v", p, ev.Hook, err)
+			continue
+		}
+		if res.Reject {
+			return ev, &RejectedError{Plugin: p.String(), Reason: res.Reason}
+		}
+		if res.Changed && ev.Comment != nil {
+			c := *ev.Comment
+			c.Text, c.Orig = res.Text, res.Orig
+			ev.Comment = &c
+		}
+	}
+	return ev, nil
+}
+
+// After sends informational event to subscribed plugins in background
+func (s *Service) After(ev Event) {
+	plugins := s.subscribed(ev.Hook)
+	if len(plugins) == 0 {
+		return
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.dispatch(plugins, ev)
+	}()
+}
+
+// Send implements notify.Destination and passes new comment to plugins subscribed to notify hook
+func (s *Service) Send(_ context.Context, req notify.Request) error {
+	if req.Moderation != "" || req.Comment.ID == "" || req.Comment.Pending {
+		return nil // plugins notified about held comment on approval
+	}
+	comment := req.Comment
+	s.dispatch(s.subscribed(HookNotify), Event{Hook: HookNotify, SiteID: comment.Locator.SiteID, Comment: &comment})
+	return nil
+}
+
+// AllowNotify passes new comment notification to plugins subscribed to notify filter hook,
+// returns false if any plugin rejected it. Used as notify.Service filter.
+func (s *Service) AllowNotify(req notify.Request) bool {
+	comment := req.Comment
+	if _, err := s.Before(Event{Hook: HookNotifyFilter, SiteID: comment.Locator.SiteID, Comment: &comment}); err != nil {
+		log.Printf("[DEBUG] notification for %s dropped, %v", comment.ID, err)
+		return false
+	}
+	return true
+}
+
+// SendVerification implements notify.Destination, verification messages not passed to plugins
+func (s *Service) SendVerification(_ context.Context, _ notify.VerificationRequest) error {
+	return nil
+}
+
+// String implements notify.Destination
+func (s *Service) String() string {
+	if s == nil {
+		return "plugins: none"
+	}
+	return fmt.Sprintf("plugins: %d", len(s.plugins))
+}
+
+// Close waits for completion of background calls
+func (s *Service) Close() {
+	if s == nil {
+		return
+	}
+	s.wg.Wait()
+}
+
+func (s *Service) dispatch(plugins []Plugin, ev Event) {
+	for _, p := range plugins {
+		if _, err := p.Handle(ev); err != nil {
+			log.Printf("[WARN] plugin %s failed on %s, %v", p, ev.Hook, err)
+		}
+	}
+}
+
+// subscribed returns plugins handling the hook
+func (s *Service) subscribed(hook Hook) (res []Plugin) {
+	if s == nil {
+		return nil
+	}
+	for _, p := range s.plugins {
+		for _, h := range p.Hooks() {
+			if h == hook {
+				res = append(res, p)
+				break
+			}
+		}
+	}
+	return res
+}
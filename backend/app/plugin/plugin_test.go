@@ -0,0 +1,129 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/notify"
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestService_Before(t *testing.T) {
+	upper := &mockPlugin{name: "upper", hooks: []Hook{HookCommentCreate, HookCommentEdit}, fn: func(ev Event) (Result, error) {
+		return Result{Changed: true, Text: strings.ToUpper(ev.Comment.Text), Orig: ev.Comment.Orig}, nil
+	}}
+	broken := &mockPlugin{name: "broken", hooks: []Hook{HookCommentCreate}, fn: func(ev Event) (Result, error) {
+		return Result{}, errors.New("failed")
+	}}
+	suffix := &mockPlugin{name: "suffix", hooks: []Hook{HookCommentCreate}, fn: func(ev Event) (Result, error) {
+		return Result{Changed: true, Text: ev.Comment.Text + "!", Orig: ev.Comment.Orig + "!"}, nil
+	}}
+	svc := NewService(upper, broken, suffix)
+	assert.True(t, svc.Has(HookCommentCreate))
+	assert.False(t, svc.Has(HookAuth))
+
+	comment := store.Comment{ID: "c1", Text: "<p>text</p>", Orig: "text"}
+	ev, err := svc.Before(Event{Hook: HookCommentCreate, SiteID: "site", Comment: &comment})
+	require.NoError(t, err, "broken plugin ignored")
+	assert.Equal(t, "<P>TEXT</P>!", ev.Comment.Text)
+	assert.Equal(t, "text!", ev.Comment.Orig)
+	assert.Equal(t, "<p>text</p>", comment.Text, "original comment not changed")
+	assert.Equal(t, 1, len(broken.Events()))
+
+	ev, err = svc.Before(Event{Hook: HookCommentEdit, SiteID: "site", Comment: &comment})
+	require.NoError(t, err)
+	assert.Equal(t, "<P>TEXT</P>", ev.Comment.Text)
+	assert.Equal(t, 1, len(suffix.Events()), "suffix not subscribed to edit")
+
+	// nothing subscribed
+	ev, err = svc.Before(Event{Hook: HookAuth, User: &store.User{ID: "u1"}})
+	require.NoError(t, err)
+	assert.Equal(t, "u1", ev.User.ID)
+}
+
+func TestService_BeforeRejected(t *testing.T) {
+	reject := &mockPlugin{name: "spam", hooks: []Hook{HookCommentCreate}, fn: func(ev Event) (Result, error) {
+		return Result{Reject: true, Reason: "spam detected"}, nil
+	}}
+	next := &mockPlugin{name: "next", hooks: []Hook{HookCommentCreate}}
+	svc := NewService(reject, next)
+
+	_, err := svc.Before(Event{Hook: HookCommentCreate, Comment: &store.Comment{ID: "c1"}})
+	require.Error(t, err)
+	assert.EqualError(t, err, "rejected by plugin spam: spam detected")
+	rejErr, ok := err.(*RejectedError)
+	require.True(t, ok)
+	assert.Equal(t, "spam", rejErr.Plugin)
+	assert.Equal(t, 0, len(next.Events()), "next plugin not called after rejection")
+}
+
+func TestService_After(t *testing.T) {
+	p := &mockPlugin{name: "p1", hooks: []Hook{HookCommentDelete, HookNotify}, fn: func(ev Event) (Result, error) {
+		time.Sleep(10 * time.Millisecond)
+		return Result{Reject: true}, nil
+	}}
+	svc := NewService(p)
+	svc.After(Event{Hook: HookCommentDelete, Comment: &store.Comment{ID: "c1"}})
+	svc.After(Event{Hook: HookCommentCreate, Comment: &store.Comment{ID: "c2"}})
+	svc.Close()
+	require.Equal(t, 1, len(p.Events()))
+	assert.Equal(t, "c1", p.Events()[0].Comment.ID)
+
+	var dest notify.Destination = svc
+	assert.Equal(t, "plugins: 1", dest.String())
+	require.NoError(t, dest.Send(context.Background(), notify.Request{Comment: store.Comment{ID: "c3",
+		Locator: store.Locator{SiteID: "site"}}}))
+	require.NoError(t, dest.Send(context.Background(), notify.Request{Comment: store.Comment{ID: "c4"},
+		Moderation: notify.ModerationDeleted}))
+	require.NoError(t, dest.SendVerification(context.Background(), notify.VerificationRequest{User: "u1"}))
+	require.Equal(t, 2, len(p.Events()), "only new comment passed")
+	assert.Equal(t, Event{Hook: HookNotify, SiteID: "site", Comment: &store.Comment{ID: "c3",
+		Locator: store.Locator{SiteID: "site"}}}, p.Events()[1])
+}
+
+func TestService_Nil(t *testing.T) {
+	var svc *Service
+	assert.False(t, svc.Has(HookCommentCreate))
+	comment := store.Comment{ID: "c1", Text: "text"}
+	ev, err := svc.Before(Event{Hook: HookCommentCreate, Comment: &comment})
+	require.NoError(t, err)
+	assert.Equal(t, "text", ev.Comment.Text)
+	svc.After(Event{Hook: HookCommentDelete, Comment: &comment})
+	svc.Close()
+	assert.Equal(t, "plugins: none", svc.String())
+}
+
+type mockPlugin struct {
+	name   string
+	hooks  []Hook
+	fn     func(ev Event) (Result, error)
+	lock   sync.Mutex
+	events []Event
+}
+
+func (m *mockPlugin) Hooks() []Hook { return m.hooks }
+
+func (m *mockPlugin) Handle(ev Event) (Result, error) {
+	m.lock.Lock()
+	m.events = append(m.events, ev)
+	m.lock.Unlock()
+	if m.fn == nil {
+		return Result{}, nil
+	}
+	return m.fn(ev)
+}
+
+func (m *mockPlugin) String() string { return m.name }
+
+func (m *mockPlugin) Events() []Event {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return append([]Event{}, m.events...)
+}
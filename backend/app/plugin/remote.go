@@ -0,0 +1,56 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-pkgz/jrpc"
+	"github.com/pkg/errors"
+)
+
+// RPC implements Plugin for sidecar service and delegates all calls to remote json-rpc server.
+// Server should handle two methods:
+//   - "plugin.hooks" with no params, returns list of hooks plugin subscribed to
+//   - "plugin.handle" with Event param, returns Result
+type RPC struct {
+	jrpc.Client
+	hooks []Hook
+}
+
+// NewRPC makes remote plugin and requests list of hooks it subscribed to
+func NewRPC(client jrpc.Client) (*RPC, error) {
+	res := RPC{Client: client}
+	resp, err := res.Call("plugin.hooks")
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't get hooks of plugin %s", client.API)
+	}
+	if resp.Result == nil {
+		return nil, errors.Errorf("no hooks returned by plugin %s", client.API)
+	}
+	if err = json.Unmarshal(*resp.Result, &res.hooks); err != nil {
+		return nil, errors.Wrapf(err, "can't decode hooks of plugin %s", client.API)
+	}
+	return &res, nil
+}
+
+// Hooks returns list of hooks plugin subscribed to
+func (r *RPC) Hooks() []Hook {
+	return r.hooks
+}
+
+// Handle sends event to plugin
+func (r *RPC) Handle(ev Event) (res Result, err error) {
+	resp, err := r.Call("plugin.handle", ev)
+	if err != nil {
+		return Result{}, err
+	}
+	if resp.Result == nil {
+		return Result{}, nil
+	}
+	err = json.Unmarshal(*resp.Result, &res)
+	return res, err
+}
+
+func (r *RPC) String() string {
+	return fmt.Sprintf("rpc %s", r.API)
+}
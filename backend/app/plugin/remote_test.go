@@ -0,0 +1,58 @@
+package plugin
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-pkgz/jrpc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestRPC_HooksAndHandle(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		switch {
+		case strings.Contains(string(body), `"method":"plugin.hooks"`):
+			_, _ = w.Write([]byte(`{"result":["comment.create","auth"],"id":1}`))
+		case strings.Contains(string(body), `"method":"plugin.handle"`):
+			assert.Equal(t, `{"method":"plugin.handle","params":{"hook":"comment.create","site":"site",`+
+				`"comment":{"id":"c1","pid":"","text":"msg","user":{"name":"","id":"","picture":"","admin":false},`+
+				`"locator":{"url":""},"score":0,"vote":0,"time":"0001-01-01T00:00:00Z"}},"id":2}`, string(body))
+			_, _ = w.Write([]byte(`{"result":{"reject":true,"reason":"spam"},"id":2}`))
+		}
+	}))
+	defer ts.Close()
+
+	p, err := NewRPC(jrpc.Client{API: ts.URL, Client: http.Client{}})
+	require.NoError(t, err)
+	assert.Equal(t, []Hook{HookCommentCreate, HookAuth}, p.Hooks())
+	assert.Equal(t, "rpc "+ts.URL, p.String())
+
+	res, err := p.Handle(Event{Hook: HookCommentCreate, SiteID: "site", Comment: &store.Comment{ID: "c1", Text: "msg"}})
+	require.NoError(t, err)
+	assert.Equal(t, Result{Reject: true, Reason: "spam"}, res)
+}
+
+func TestRPC_Errors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"error":"failed"}`))
+	}))
+	defer ts.Close()
+
+	_, err := NewRPC(jrpc.Client{API: ts.URL, Client: http.Client{}})
+	assert.EqualError(t, err, "can't get hooks of plugin "+ts.URL+": failed")
+
+	p := RPC{Client: jrpc.Client{API: ts.URL, Client: http.Client{}}}
+	_, err = p.Handle(Event{Hook: HookAuth})
+	assert.EqualError(t, err, "failed")
+
+	_, err = NewRPC(jrpc.Client{API: "http://127.0.0.1:1", Client: http.Client{}})
+	assert.Error(t, err)
+}
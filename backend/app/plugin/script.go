@@ -0,0 +1,278 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+	"go.starlark.net/starlark"
+)
+
+// scriptMaxSteps limits number of computation steps of a single script call
+const scriptMaxSteps = 1000000
+
+// scriptFuncs maps hooks to names of script functions handling them, in order of hooks
+var scriptFuncs = []struct {
+	hook Hook
+	name string
+}{
+	{HookCommentCreate, "on_comment_create"},
+	{HookCommentCreated, "on_comment_created"},
+	{HookCommentEdit, "on_comment_edit"},
+	{HookCommentDeleting, "on_comment_deleting"},
+	{HookCommentDelete, "on_comment_delete"},
+	{HookUserBlock, "on_user_block"},
+	{HookAuth, "on_auth"},
+	{HookNotify, "on_notify"},
+	{HookNotifyFilter, "on_notify_filter"},
+}
+
+// Script implements Plugin with starlark (python dialect) script. Script subscribes to hooks by defining functions
+// on_comment_create, on_comment_created, on_comment_edit, on_comment_deleting, on_comment_delete, on_user_block,
+// on_auth, on_notify and on_notify_filter. Each function gets event as a dict with the same fields as json event
+// of RPC plugin. Functions of before-hooks may return
+// dict with "reject", "reason", "text" and "orig" keys, or None to accept the event as is.
+// Scripts are sandboxed: no access to files, network or environment, global state frozen after load,
+// each call limited by timeout and number of computation steps.
+type Script struct {
+	name    string
+	funcs   map[Hook]*starlark.Function
+	hooks   []Hook
+	timeout time.Duration
+}
+
+// NewScript loads script from src. Name used in logs and errors.
+func NewScript(name string, src []byte, timeout time.Duration) (*Script, error) {
+	res := Script{name: name, funcs: map[Hook]*starlark.Function{}, timeout: timeout}
+	if res.timeout <= 0 {
+		res.timeout = time.Second
+	}
+
+	thread, cancel := res.thread()
+	defer cancel()
+	globals, err := starlark.ExecFile(thread, name, src, scriptBuiltins)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't load script %s", name)
+	}
+	globals.Freeze()
+
+	for _, f := range scriptFuncs {
+		v, ok := globals[f.name]
+		if !ok {
+			continue
+		}
+		fn, ok := v.(*starlark.Function)
+		if !ok || fn.NumParams() != 1 {
+			return nil, errors.Errorf("%s in script %s should be a function with one parameter", f.name, name)
+		}
+		res.funcs[f.hook] = fn
+		res.hooks = append(res.hooks, f.hook)
+	}
+	return &res, nil
+}
+
+// LoadScripts loads all *.star scripts from the directory, in order of file names
+func LoadScripts(dir string, timeout time.Duration) ([]*Script, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.star"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't list scripts in %s", dir)
+	}
+	res := make([]*Script, 0, len(files))
+	for _, file := range files {
+		src, err := ioutil.ReadFile(file) // nolint
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't read script %s", file)
+		}
+		script, err := NewScript(filepath.Base(file), src, timeout)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, script)
+	}
+	return res, nil
+}
+
+// Hooks returns list of hooks script has functions for
+func (s *Script) Hooks() []Hook {
+	return s.hooks
+}
+
+// Handle calls script function for the event's hook
+func (s *Script) Handle(ev Event) (Result, error) {
+	fn, ok := s.funcs[ev.Hook]
+	if !ok {
+		return Result{}, nil
+	}
+	arg, err := toStarlark(ev)
+	if err != nil {
+		return Result{}, errors.Wrap(err, "can't convert event")
+	}
+
+	thread, cancel := s.thread()
+	defer cancel()
+	v, err := starlark.Call(thread, fn, starlark.Tuple{arg}, nil)
+	if err != nil {
+		return Result{}, err
+	}
+	return s.result(v, ev)
+}
+
+func (s *Script) String() string {
+	return fmt.Sprintf("script %s", s.name)
+}
+
+// thread makes starlark thread with steps limit and timeout, cancel func should be called after use
+func (s *Script) thread() (thread *starlark.Thread, cancel func()) {
+	thread = &starlark.Thread{
+		Name:  s.name,
+		Print: func(_ *starlark.Thread, msg string) { log.Printf("[INFO] script %s: %s", s.name, msg) },
+	}
+	thread.SetMaxExecutionSteps(scriptMaxSteps)
+	timer := time.AfterFunc(s.timeout, func() { thread.Cancel(fmt.Sprintf("timeout %v", s.timeout)) })
+	return thread, func() { timer.Stop() }
+}
+
+// result converts value returned by script to Result. Text and orig not returned by script kept as is.
+func (s *Script) result(v starlark.Value, ev Event) (res Result, err error) {
+	if v == starlark.None {
+		return Result{}, nil
+	}
+	dict, ok := v.(*starlark.Dict)
+	if !ok {
+		return Result{}, errors.Errorf("unexpected result type %s", v.Type())
+	}
+	val, err := fromStarlark(dict)
+	if err != nil {
+		return Result{}, errors.Wrap(err, "can't convert result")
+	}
+	data, err := json.Marshal(val)
+	if err != nil {
+		return Result{}, errors.Wrap(err, "can't marshal result")
+	}
+	if err = json.Unmarshal(data, &res); err != nil {
+		return Result{}, errors.Wrap(err, "can't decode result")
+	}
+
+	m := val.(map[string]interface{})
+	_, hasText := m["text"]
+	_, hasOrig := m["orig"]
+	if (hasText || hasOrig) && ev.Comment != nil {
+		res.Changed = true
+		if !hasText {
+			res.Text = ev.Comment.Text
+		}
+		if !hasOrig {
+			res.Orig = ev.Comment.Orig
+		}
+	}
+	return res, nil
+}
+
+// scriptBuiltins are functions available to scripts in addition to starlark's universe
+var scriptBuiltins = starlark.StringDict{
+	"re_match": starlark.NewBuiltin("re_match", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple,
+		kwargs []starlark.Tuple) (starlark.Value, error) {
+		var pattern, text string
+		if err := starlark.UnpackArgs(b.Name(), args, kwargs, "pattern", &pattern, "text", &text); err != nil {
+			return nil, err
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return starlark.Bool(re.MatchString(text)), nil
+	}),
+}
+
+// toStarlark converts value to starlark with json representation of the value
+func toStarlark(v interface{}) (starlark.Value, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var decoded interface{}
+	if err = json.Unmarshal(data, &decoded); err != nil {
+		return nil, err
+	}
+	return jsonToStarlark(decoded), nil
+}
+
+func jsonToStarlark(v interface{}) starlark.Value {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		dict := starlark.NewDict(len(val))
+		for k, e := range val {
+			_ = dict.SetKey(starlark.String(k), jsonToStarlark(e))
+		}
+		return dict
+	case []interface{}:
+		list := make([]starlark.Value, 0, len(val))
+		for _, e := range val {
+			list = append(list, jsonToStarlark(e))
+		}
+		return starlark.NewList(list)
+	case string:
+		return starlark.String(val)
+	case bool:
+		return starlark.Bool(val)
+	case float64:
+		if val == float64(int64(val)) {
+			return starlark.MakeInt64(int64(val))
+		}
+		return starlark.Float(val)
+	}
+	return starlark.None
+}
+
+// fromStarlark converts starlark value to go value with json-compatible types
+func fromStarlark(v starlark.Value) (interface{}, error) {
+	switch val := v.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(val), nil
+	case starlark.String:
+		return string(val), nil
+	case starlark.Int:
+		i, ok := val.Int64()
+		if !ok {
+			return nil, errors.Errorf("int %s out of range", val)
+		}
+		return i, nil
+	case starlark.Float:
+		return float64(val), nil
+	case *starlark.List, starlark.Tuple:
+		res := []interface{}{}
+		iter := starlark.Iterate(val)
+		defer iter.Done()
+		var e starlark.Value
+		for iter.Next(&e) {
+			ge, err := fromStarlark(e)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, ge)
+		}
+		return res, nil
+	case *starlark.Dict:
+		res := map[string]interface{}{}
+		for _, item := range val.Items() {
+			k, ok := item[0].(starlark.String)
+			if !ok {
+				return nil, errors.Errorf("non-string dict key %s", item[0])
+			}
+			ge, err := fromStarlark(item[1])
+			if err != nil {
+				return nil, err
+			}
+			res[string(k)] = ge
+		}
+		return res, nil
+	}
+	return nil, errors.Errorf("unsupported type %s", v.Type())
+}
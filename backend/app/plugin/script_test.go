@@ -0,0 +1,163 @@
+package plugin
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/notify"
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestScript_Handle(t *testing.T) {
+	src := `
+banned = ["casino", "viagra"]
+
+def on_comment_create(ev):
+    for w in banned:
+        if w in ev["comment"]["orig"].lower():
+            return {"reject": True, "reason": "banned word " + w}
+    if re_match("(?i)^\\s*first!*\\s*$", ev["comment"]["orig"]):
+        return {"reject": True, "reason": "low effort"}
+    if ev["comment"]["locator"]["url"].startswith("https://example.com/shop"):
+        return {"text": ev["comment"]["text"] + "<p><i>sponsored</i></p>"}
+    return None
+
+def on_user_block(ev):
+    if ev["user"]["id"].startswith("admin_") or ev.get("reason", "") == "":
+        return {"reject": True, "reason": "not allowed"}
+
+def on_notify_filter(ev):
+    if ev["comment"]["user"]["id"] == "bot":
+        return {"reject": True}
+`
+	s, err := NewScript("rules.star", []byte(src), time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, []Hook{HookCommentCreate, HookUserBlock, HookNotifyFilter}, s.Hooks())
+	assert.Equal(t, "script rules.star", s.String())
+
+	comment := func(orig, url string) *store.Comment {
+		return &store.Comment{ID: "c1", Orig: orig, Text: "<p>" + orig + "</p>", Locator: store.Locator{SiteID: "site", URL: url}}
+	}
+
+	res, err := s.Handle(Event{Hook: HookCommentCreate, SiteID: "site", Comment: comment("Cheap CASINO", "https://example.com/1")})
+	require.NoError(t, err)
+	assert.Equal(t, Result{Reject: true, Reason: "banned word casino"}, res)
+
+	res, err = s.Handle(Event{Hook: HookCommentCreate, SiteID: "site", Comment: comment(" First!!", "https://example.com/1")})
+	require.NoError(t, err)
+	assert.Equal(t, Result{Reject: true, Reason: "low effort"}, res)
+
+	res, err = s.Handle(Event{Hook: HookCommentCreate, SiteID: "site", Comment: comment("nice", "https://example.com/shop/1")})
+	require.NoError(t, err)
+	assert.Equal(t, Result{Changed: true, Text: "<p>nice</p><p><i>sponsored</i></p>", Orig: "nice"}, res, "orig kept")
+
+	res, err = s.Handle(Event{Hook: HookCommentCreate, SiteID: "site", Comment: comment("nice", "https://example.com/1")})
+	require.NoError(t, err)
+	assert.Equal(t, Result{}, res)
+
+	res, err = s.Handle(Event{Hook: HookUserBlock, SiteID: "site", User: &store.User{ID: "admin_1"}, Reason: "spam"})
+	require.NoError(t, err)
+	assert.Equal(t, Result{Reject: true, Reason: "not allowed"}, res)
+	res, err = s.Handle(Event{Hook: HookUserBlock, SiteID: "site", User: &store.User{ID: "u1"}})
+	require.NoError(t, err)
+	assert.Equal(t, Result{Reject: true, Reason: "not allowed"}, res, "no reason")
+	res, err = s.Handle(Event{Hook: HookUserBlock, SiteID: "site", User: &store.User{ID: "u1"}, Reason: "spam"})
+	require.NoError(t, err)
+	assert.Equal(t, Result{}, res)
+
+	res, err = s.Handle(Event{Hook: HookAuth, SiteID: "site", User: &store.User{ID: "u1"}})
+	require.NoError(t, err)
+	assert.Equal(t, Result{}, res, "no function for hook")
+
+	// notification filter via plugin service
+	svc := NewService(s)
+	assert.False(t, svc.AllowNotify(notify.Request{Comment: store.Comment{ID: "c1", User: store.User{ID: "bot"}}}))
+	assert.True(t, svc.AllowNotify(notify.Request{Comment: store.Comment{ID: "c2", User: store.User{ID: "u1"}}}))
+}
+
+func TestScript_Limits(t *testing.T) {
+	src := `
+def on_comment_create(ev):
+    n = 0
+    for i in range(100000000):
+        n += i
+    return None
+
+def on_comment_edit(ev):
+    ev["comment"]["text"] = "changed" # event is a copy, mutation allowed
+    return {"reject": "bad type"}
+
+def on_auth(ev):
+    return "wrong"
+`
+	s, err := NewScript("limits.star", []byte(src), 50*time.Millisecond)
+	require.NoError(t, err)
+
+	st := time.Now()
+	_, err = s.Handle(Event{Hook: HookCommentCreate, Comment: &store.Comment{ID: "c1"}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cancelled")
+	assert.True(t, time.Since(st) < time.Second)
+
+	_, err = s.Handle(Event{Hook: HookCommentEdit, Comment: &store.Comment{ID: "c1"}})
+	assert.Error(t, err, "reject is not bool")
+
+	_, err = s.Handle(Event{Hook: HookAuth, User: &store.User{ID: "u1"}})
+	assert.EqualError(t, err, "unexpected result type string")
+
+	// steps limit
+	s, err = NewScript("steps.star", []byte(src), time.Minute)
+	require.NoError(t, err)
+	_, err = s.Handle(Event{Hook: HookCommentCreate, Comment: &store.Comment{ID: "c1"}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "too many steps")
+}
+
+func TestScript_Sandbox(t *testing.T) {
+	_, err := NewScript("load.star", []byte(`load("os.star", "open")`), time.Second)
+	assert.Error(t, err, "load not supported")
+
+	s, err := NewScript("state.star", []byte(`
+counter = {"n": 0}
+def on_comment_create(ev):
+    counter["n"] += 1
+`), time.Second)
+	require.NoError(t, err)
+	_, err = s.Handle(Event{Hook: HookCommentCreate, Comment: &store.Comment{ID: "c1"}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "frozen")
+
+	_, err = NewScript("bad.star", []byte(`on_auth = 1`), time.Second)
+	assert.EqualError(t, err, "on_auth in script bad.star should be a function with one parameter")
+	_, err = NewScript("bad.star", []byte(`def on_auth(): pass`), time.Second)
+	assert.Error(t, err)
+	_, err = NewScript("bad.star", []byte(`def on_auth(ev) pass`), time.Second)
+	assert.Error(t, err)
+}
+
+func TestLoadScripts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scripts")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "b.star"), []byte("def on_auth(ev):\n    pass\n"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a.star"), []byte("def on_notify(ev):\n    pass\n"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "readme.txt"), []byte("not a script"), 0600))
+
+	scripts, err := LoadScripts(dir, time.Second)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(scripts))
+	assert.Equal(t, "script a.star", scripts[0].String())
+	assert.Equal(t, []Hook{HookNotify}, scripts[0].Hooks())
+	assert.Equal(t, []Hook{HookAuth}, scripts[1].Hooks())
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "c.star"), []byte("def on_auth(ev)"), 0600))
+	_, err = LoadScripts(dir, time.Second)
+	assert.Error(t, err)
+}
@@ -0,0 +1,51 @@
+// Package publicnet makes http client for urls set by users, like sources of webmentions and keys of remote actors.
+// Connections to loopback and private networks refused, so such urls can't reach internal services.
+package publicnet
+
+import (
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// NewClient makes http client refusing connections to loopback and private networks, redirects checked as well
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, Control: func(_, address string, _ syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if ip := net.ParseIP(host); ip == nil || IsPrivate(ip) {
+			return errors.Errorf("connection to %s refused, not a public address", host)
+		}
+		return nil
+	}}
+	transport := &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: timeout, Proxy: http.ProxyFromEnvironment}
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+var privateNets = func() []*net.IPNet {
+	res := []*net.IPNet{}
+	for _, n := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "169.254.0.0/16",
+		"fc00::/7", "fe80::/10"} {
+		_, ipNet, _ := net.ParseCIDR(n)
+		res = append(res, ipNet)
+	}
+	return res
+}()
+
+// IsPrivate checks if ip is loopback, unspecified, multicast or belongs to private network
+func IsPrivate(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsMulticast() {
+		return true
+	}
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
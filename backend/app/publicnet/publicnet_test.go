@@ -0,0 +1,26 @@
+package publicnet
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewClient(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	_, err := NewClient(time.Second).Get(ts.URL)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not a public address")
+}
+
+func TestIsPrivate(t *testing.T) {
+	assert.True(t, IsPrivate([]byte{192, 168, 1, 1}))
+	assert.True(t, IsPrivate([]byte{127, 0, 0, 1}))
+	assert.True(t, IsPrivate([]byte{169, 254, 169, 254}))
+	assert.False(t, IsPrivate([]byte{8, 8, 8, 8}))
+}
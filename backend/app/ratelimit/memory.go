@@ -0,0 +1,56 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+const maxMemoryKeys = 10000 // expired keys removed when store grows above
+
+// MemoryStore implements Store in memory of a single node
+type MemoryStore struct {
+	lock sync.Mutex
+	tat  map[string]time.Time // theoretical arrival time of the next event per key
+	now  func() time.Time
+}
+
+// NewMemoryStore makes in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{tat: map[string]time.Time{}, now: time.Now}
+}
+
+// Take consumes one event of the key allowed each interval with burst of events at once
+func (m *MemoryStore) Take(key string, interval time.Duration, burst int) (allowed bool, retryAfter time.Duration, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	now := m.now()
+	if len(m.tat) > maxMemoryKeys {
+		for k, v := range m.tat {
+			if v.Before(now) {
+				delete(m.tat, k)
+			}
+		}
+	}
+	tat, ok := m.tat[key]
+	if !ok || tat.Before(now) {
+		tat = now
+	}
+	next := tat.Add(interval)
+	if wait := next.Sub(now) - time.Duration(burst)*interval; wait > 0 {
+		return false, wait, nil
+	}
+	m.tat[key] = next
+	return true, 0, nil
+}
+
+// Peek returns number of events of the key allowed right now and time until the whole burst restored
+func (m *MemoryStore) Peek(key string, interval time.Duration, burst int) (remaining int, reset time.Duration, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	now := m.now()
+	tat, ok := m.tat[key]
+	if !ok || tat.Before(now) {
+		return burst, 0, nil
+	}
+	return remainingEvents(tat.Sub(now), interval, burst), tat.Sub(now), nil
+}
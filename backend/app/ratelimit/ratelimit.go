@@ -0,0 +1,124 @@
+// Package ratelimit limits rate of comments per user and per ip. Limits applied with generic cell rate algorithm,
+// each key allows burst of comments at once and one more comment each 1/rate of minute after that.
+// Store keeps theoretical arrival time of the next comment per key, in memory or in the shared cache.
+package ratelimit
+
+import (
+	"math"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+)
+
+// Store defines interface of rate limit state, shared by all nodes for distributed setup
+type Store interface {
+	// Take consumes one event of the key allowed each interval with burst of events at once.
+	// Returns false and time to wait for the next allowed event if limit reached.
+	Take(key string, interval time.Duration, burst int) (allowed bool, retryAfter time.Duration, err error)
+	// Peek returns number of events of the key allowed right now and time until the whole burst restored,
+	// without consuming any.
+	Peek(key string, interval time.Duration, burst int) (remaining int, reset time.Duration, err error)
+}
+
+// Limit defines rate per minute with burst, 0 rate disables the limit
+type Limit struct {
+	Rate  int // events per minute
+	Burst int // events allowed at once, 1 if not set
+}
+
+// Params of Limiter
+type Params struct {
+	User Limit // limit of comments per user
+	IP   Limit // limit of comments per ip
+}
+
+// Status of the limit of a key
+type Status struct {
+	Rate      int `json:"rate"`      // events per minute, 0 for unlimited
+	Burst     int `json:"burst"`     // events allowed at once
+	Remaining int `json:"remaining"` // events allowed right now, -1 for unlimited
+	Reset     int `json:"reset"`     // seconds until the whole burst restored, rounded up
+}
+
+// Limiter checks rate limits of comments
+type Limiter struct {
+	Params
+	store Store
+}
+
+// NewLimiter makes Limiter with the store
+func NewLimiter(st Store, params Params) *Limiter {
+	log.Printf("[INFO] comments rate limit, user %+v, ip %+v", params.User, params.IP)
+	return &Limiter{Params: params, store: st}
+}
+
+// Allow checks and consumes limits of the user and ip on the site, returns false with time to wait if any limit reached.
+// Errors of the store only logged and comment allowed. Safe to call on nil Limiter.
+func (l *Limiter) Allow(siteID, userID, ip string) (allowed bool, retryAfter time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	if userID != "" {
+		if ok, wait := l.take(l.User, "user!!"+siteID+"!!"+userID); !ok {
+			return false, wait
+		}
+	}
+	if ip != "" {
+		if ok, wait := l.take(l.IP, "ip!!"+siteID+"!!"+ip); !ok {
+			return false, wait
+		}
+	}
+	return true, 0
+}
+
+// Status returns state of limits of the user and ip on the site, without consuming them.
+// Errors of the store only logged and the limit reported as not used. Safe to call on nil Limiter.
+func (l *Limiter) Status(siteID, userID, ip string) (user, ipStatus Status) {
+	if l == nil {
+		return Status{Remaining: -1}, Status{Remaining: -1}
+	}
+	return l.peek(l.User, "user!!"+siteID+"!!"+userID), l.peek(l.IP, "ip!!"+siteID+"!!"+ip)
+}
+
+func (l *Limiter) take(limit Limit, key string) (allowed bool, retryAfter time.Duration) {
+	if limit.Rate <= 0 {
+		return true, 0
+	}
+	burst := limit.Burst
+	if burst < 1 {
+		burst = 1
+	}
+	allowed, retryAfter, err := l.store.Take(key, time.Minute/time.Duration(limit.Rate), burst)
+	if err != nil {
+		log.Printf("[WARN] can't check rate limit of %s, %v", key, err)
+		return true, 0
+	}
+	return allowed, retryAfter
+}
+
+func (l *Limiter) peek(limit Limit, key string) Status {
+	if limit.Rate <= 0 {
+		return Status{Remaining: -1}
+	}
+	burst := limit.Burst
+	if burst < 1 {
+		burst = 1
+	}
+	res := Status{Rate: limit.Rate, Burst: burst, Remaining: burst}
+	remaining, reset, err := l.store.Peek(key, time.Minute/time.Duration(limit.Rate), burst)
+	if err != nil {
+		log.Printf("[WARN] can't get rate limit of %s, %v", key, err)
+		return res
+	}
+	res.Remaining, res.Reset = remaining, int(math.Ceil(reset.Seconds()))
+	return res
+}
+
+// remainingEvents returns number of events allowed with theoretical arrival time of the next event ahead by the delay
+func remainingEvents(ahead, interval time.Duration, burst int) int {
+	res := int((time.Duration(burst)*interval - ahead) / interval)
+	if res < 0 {
+		return 0
+	}
+	return res
+}
@@ -0,0 +1,113 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStore_Take(t *testing.T) {
+	st := NewMemoryStore()
+	ts := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	st.now = func() time.Time { return ts }
+
+	for i := 0; i < 3; i++ {
+		allowed, _, err := st.Take("k1", 10*time.Second, 3)
+		assert.NoError(t, err)
+		assert.True(t, allowed, "burst %d", i)
+	}
+	allowed, wait, err := st.Take("k1", 10*time.Second, 3)
+	assert.NoError(t, err)
+	assert.False(t, allowed, "burst exceeded")
+	assert.Equal(t, 10*time.Second, wait)
+
+	allowed, _, err = st.Take("k2", 10*time.Second, 3)
+	assert.NoError(t, err)
+	assert.True(t, allowed, "other key")
+
+	remaining, reset, err := st.Peek("k1", 10*time.Second, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, remaining)
+	assert.Equal(t, 30*time.Second, reset)
+	remaining, reset, err = st.Peek("k2", 10*time.Second, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, remaining)
+	assert.Equal(t, 10*time.Second, reset)
+	remaining, reset, err = st.Peek("k3", 10*time.Second, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, remaining, "not used")
+	assert.Equal(t, time.Duration(0), reset)
+
+	ts = ts.Add(4 * time.Second)
+	allowed, wait, err = st.Take("k1", 10*time.Second, 3)
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+	assert.Equal(t, 6*time.Second, wait)
+
+	ts = ts.Add(6 * time.Second)
+	allowed, _, err = st.Take("k1", 10*time.Second, 3)
+	assert.NoError(t, err)
+	assert.True(t, allowed, "one more after interval")
+	allowed, _, err = st.Take("k1", 10*time.Second, 3)
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+
+	ts = ts.Add(time.Minute)
+	for i := 0; i < 3; i++ {
+		allowed, _, err = st.Take("k1", 10*time.Second, 3)
+		assert.NoError(t, err)
+		assert.True(t, allowed, "burst restored %d", i)
+	}
+}
+
+func TestLimiter_Allow(t *testing.T) {
+	var l *Limiter
+	allowed, _ := l.Allow("site", "user1", "127.0.0.1")
+	assert.True(t, allowed, "nil limiter allows all")
+
+	l = NewLimiter(NewMemoryStore(), Params{User: Limit{Rate: 1, Burst: 2}, IP: Limit{Rate: 1, Burst: 3}})
+	for i := 0; i < 2; i++ {
+		allowed, _ = l.Allow("site", "user1", "127.0.0.1")
+		assert.True(t, allowed)
+	}
+	allowed, wait := l.Allow("site", "user1", "127.0.0.1")
+	assert.False(t, allowed, "user limit")
+	assert.True(t, wait > 59*time.Second && wait <= time.Minute, wait)
+
+	allowed, _ = l.Allow("site2", "user1", "127.0.0.1")
+	assert.True(t, allowed, "limits per site")
+	allowed, _ = l.Allow("site", "user2", "127.0.0.1")
+	assert.True(t, allowed, "third comment from ip")
+	allowed, _ = l.Allow("site", "user3", "127.0.0.1")
+	assert.False(t, allowed, "ip limit")
+	allowed, _ = l.Allow("site", "user3", "127.0.0.2")
+	assert.True(t, allowed)
+
+	user, ip := l.Status("site", "user1", "127.0.0.1")
+	assert.Equal(t, Status{Rate: 1, Burst: 2, Remaining: 0, Reset: 120}, user)
+	assert.Equal(t, Status{Rate: 1, Burst: 3, Remaining: 0, Reset: 180}, ip)
+	user, ip = l.Status("site", "user4", "127.0.0.3")
+	assert.Equal(t, Status{Rate: 1, Burst: 2, Remaining: 2}, user)
+	assert.Equal(t, Status{Rate: 1, Burst: 3, Remaining: 3}, ip)
+	user, _ = (*Limiter)(nil).Status("site", "user1", "127.0.0.1")
+	assert.Equal(t, Status{Remaining: -1}, user, "nil limiter unlimited")
+
+	l = NewLimiter(failingStore{}, Params{User: Limit{Rate: 1}})
+	allowed, _ = l.Allow("site", "user1", "127.0.0.1")
+	assert.True(t, allowed, "allowed on store error")
+	user, ip = l.Status("site", "user1", "127.0.0.1")
+	assert.Equal(t, Status{Rate: 1, Burst: 1, Remaining: 1}, user, "not used on store error")
+	assert.Equal(t, Status{Remaining: -1}, ip, "no ip limit")
+}
+
+type failingStore struct{}
+
+func (failingStore) Take(string, time.Duration, int) (bool, time.Duration, error) {
+	return false, 0, errors.New("failed")
+}
+
+func (failingStore) Peek(string, time.Duration, int) (int, time.Duration, error) {
+	return 0, 0, errors.New("failed")
+}
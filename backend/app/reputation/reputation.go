@@ -0,0 +1,147 @@
+// Package reputation computes karma of users from their history on the site. Karma combines total score of votes
+// received by user's comments with numbers of approved (published) and rejected (deleted) comments, each component
+// multiplied by configurable weight. Results cached for a while, so karma shown with comments and used by moderation
+// rules doesn't cost a history scan per request.
+package reputation
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// Weights of karma components, karma = Votes*score + Approved*approved - Rejected*rejected
+type Weights struct {
+	Votes    float64 `json:"votes"`
+	Approved float64 `json:"approved"`
+	Rejected float64 `json:"rejected"`
+}
+
+// DefaultWeights used when no weights set, rejected comment costs as much as five upvotes
+var DefaultWeights = Weights{Votes: 1, Approved: 0.5, Rejected: 5}
+
+// Karma of the user on the site with components it made of
+type Karma struct {
+	UserID   string  `json:"user_id"`
+	Karma    int     `json:"karma"`
+	Score    int     `json:"score"`    // total score of votes received by published comments
+	Approved int     `json:"approved"` // published comments
+	Rejected int     `json:"rejected"` // deleted comments
+	Ratio    float64 `json:"ratio"`    // approved/(approved+rejected), 1 for user without comments
+}
+
+// DataService defines subset of data store used to get history of users
+type DataService interface {
+	User(siteID, userID string, limit, skip int, user store.User) ([]store.Comment, error)
+}
+
+const historyLimit = 1000 // max number of user's comments counted in karma
+
+// Service computes karma of users, nil service reports zero karma
+type Service struct {
+	data    DataService
+	weights Weights
+	ttl     time.Duration
+	now     func() time.Time
+
+	lock  sync.Mutex
+	cache map[string]cached // keyed by site and user id
+}
+
+type cached struct {
+	Karma
+	at time.Time
+}
+
+// NewService makes reputation service with weights of components, results cached for ttl, 0 disables caching
+func NewService(data DataService, weights Weights, ttl time.Duration) *Service {
+	return &Service{data: data, weights: weights, ttl: ttl, now: time.Now, cache: map[string]cached{}}
+}
+
+// Weights returns weights of karma components
+func (s *Service) Weights() Weights {
+	return s.weights
+}
+
+// Karma returns karma of the user on the site, user without history gets zero karma. Errors of data store logged.
+func (s *Service) Karma(siteID, userID string) Karma {
+	if s == nil || userID == "" {
+		return Karma{UserID: userID, Ratio: 1}
+	}
+	key := siteID + "!!" + userID
+	now := s.now()
+	s.lock.Lock()
+	if c, ok := s.cache[key]; ok && now.Sub(c.at) < s.ttl {
+		s.lock.Unlock()
+		return c.Karma
+	}
+	s.lock.Unlock()
+
+	res := s.compute(siteID, userID)
+	if s.ttl > 0 {
+		s.lock.Lock()
+		s.cache[key] = cached{Karma: res, at: now}
+		s.cleanup(now)
+		s.lock.Unlock()
+	}
+	return res
+}
+
+// Apply sets karma of authors to comments, karma of each author computed once
+func (s *Service) Apply(comments []store.Comment) []store.Comment {
+	if s == nil {
+		return comments
+	}
+	karma := map[string]int{}
+	for i, c := range comments {
+		k, ok := karma[c.User.ID]
+		if !ok {
+			k = s.Karma(c.Locator.SiteID, c.User.ID).Karma
+			karma[c.User.ID] = k
+		}
+		comments[i].User.Karma = k
+	}
+	return comments
+}
+
+// compute karma by the last comments of the user
+func (s *Service) compute(siteID, userID string) Karma {
+	res := Karma{UserID: userID, Ratio: 1}
+	comments, err := s.data.User(siteID, userID, historyLimit, 0, store.User{Admin: true})
+	if err != nil { // engine reports user without comments as error
+		log.Printf("[DEBUG] no comments of %s on %s for karma, %v", userID, siteID, err)
+		return res
+	}
+	for _, c := range comments {
+		switch {
+		case c.Deleted:
+			res.Rejected++
+		case c.Pending:
+		default:
+			res.Approved++
+			res.Score += c.Score
+		}
+	}
+	if total := res.Approved + res.Rejected; total > 0 {
+		res.Ratio = math.Round(float64(res.Approved)/float64(total)*100) / 100
+	}
+	res.Karma = int(math.Round(s.weights.Votes*float64(res.Score) + s.weights.Approved*float64(res.Approved) -
+		s.weights.Rejected*float64(res.Rejected)))
+	return res
+}
+
+// cleanup removes expired records, called under the lock
+func (s *Service) cleanup(now time.Time) {
+	if len(s.cache) < 10000 {
+		return
+	}
+	for k, c := range s.cache {
+		if now.Sub(c.at) >= s.ttl {
+			delete(s.cache, k)
+		}
+	}
+}
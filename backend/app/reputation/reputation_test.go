@@ -0,0 +1,88 @@
+package reputation
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestService_Karma(t *testing.T) {
+	data := &mockData{comments: map[string][]store.Comment{
+		"u1": {
+			{ID: "c1", Score: 5},
+			{ID: "c2", Score: -1},
+			{ID: "c3", Score: 10, Deleted: true},
+			{ID: "c4", Score: 3, Pending: true},
+		},
+	}}
+	s := NewService(data, DefaultWeights, time.Minute)
+
+	k := s.Karma("site1", "u1")
+	assert.Equal(t, Karma{UserID: "u1", Karma: 0, Score: 4, Approved: 2, Rejected: 1, Ratio: 0.67}, k,
+		"4 + 0.5*2 - 5*1")
+
+	s = NewService(data, Weights{Votes: 2, Approved: 1}, time.Minute)
+	assert.Equal(t, 10, s.Karma("site1", "u1").Karma, "2*4 + 1*2")
+
+	assert.Equal(t, Karma{UserID: "u2", Ratio: 1}, s.Karma("site1", "u2"), "user without comments")
+	data.err = errors.New("no comments")
+	assert.Equal(t, Karma{UserID: "u3", Ratio: 1}, s.Karma("site1", "u3"), "error of data store")
+
+	var nilService *Service
+	assert.Equal(t, Karma{UserID: "u1", Ratio: 1}, nilService.Karma("site1", "u1"))
+}
+
+func TestService_KarmaCached(t *testing.T) {
+	data := &mockData{comments: map[string][]store.Comment{"u1": {{ID: "c1", Score: 5}}}}
+	now := time.Date(2021, 5, 10, 12, 0, 0, 0, time.UTC)
+	s := NewService(data, Weights{Votes: 1}, time.Minute)
+	s.now = func() time.Time { return now }
+
+	assert.Equal(t, 5, s.Karma("site1", "u1").Karma)
+	data.comments["u1"] = append(data.comments["u1"], store.Comment{ID: "c2", Score: 3})
+	assert.Equal(t, 5, s.Karma("site1", "u1").Karma, "cached")
+	assert.Equal(t, 1, data.calls)
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, 8, s.Karma("site1", "u1").Karma, "expired")
+	assert.Equal(t, 2, data.calls)
+
+	s = NewService(data, Weights{Votes: 1}, 0)
+	s.Karma("site1", "u1")
+	s.Karma("site1", "u1")
+	assert.Equal(t, 4, data.calls, "caching disabled")
+}
+
+func TestService_Apply(t *testing.T) {
+	data := &mockData{comments: map[string][]store.Comment{
+		"u1": {{ID: "c1", Score: 5}},
+		"u2": {{ID: "c2", Score: -2}},
+	}}
+	s := NewService(data, Weights{Votes: 1}, 0)
+	comments := []store.Comment{
+		{ID: "c1", User: store.User{ID: "u1"}}, {ID: "c2", User: store.User{ID: "u2"}}, {ID: "c3", User: store.User{ID: "u1"}},
+	}
+	res := s.Apply(comments)
+	assert.Equal(t, 5, res[0].User.Karma)
+	assert.Equal(t, -2, res[1].User.Karma)
+	assert.Equal(t, 5, res[2].User.Karma)
+	assert.Equal(t, 2, data.calls, "karma of author computed once")
+
+	var nilService *Service
+	assert.Equal(t, comments, nilService.Apply(comments))
+}
+
+type mockData struct {
+	comments map[string][]store.Comment
+	err      error
+	calls    int
+}
+
+func (m *mockData) User(_, userID string, _, _ int, _ store.User) ([]store.Comment, error) {
+	m.calls++
+	return m.comments[userID], m.err
+}
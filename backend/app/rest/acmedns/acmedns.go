@@ -0,0 +1,315 @@
+// Package acmedns obtains and renews TLS certificate from ACME server (Let's Encrypt) with dns-01 challenge.
+// Used for hosts not reachable by ACME server over http, i.e. behind firewall or running on non-default ports.
+// Certificate and account key stored in the same cache autocert uses, renewed in background before expiration.
+package acmedns
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Provider sets and removes TXT records of dns-01 challenge
+type Provider interface {
+	Present(ctx context.Context, fqdn, value string) error
+	CleanUp(ctx context.Context, fqdn, value string) error
+}
+
+// Manager keeps certificate of domains obtained with dns-01 challenge, implements tls.Config.GetCertificate
+type Manager struct {
+	Domains      []string       // first one is the common name of certificate
+	Email        string         // contact of ACME account
+	DirectoryURL string         // directory of ACME server, Let's Encrypt if empty
+	Cache        autocert.Cache // keeps account key and certificate
+	Provider     Provider
+	RenewBefore  time.Duration // renew certificate expiring within, 30 days if not set
+	Propagation  time.Duration // wait after TXT records set, before ACME server asked to check them
+
+	lock sync.RWMutex
+	cert *tls.Certificate
+}
+
+const accountKeyName = "acme_account+key" // the same as autocert uses, account shared with http-01 challenge
+
+const checkInterval = 12 * time.Hour
+
+// GetCertificate returns certificate of the domains, for use in tls.Config
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+	if name != "" && !m.covers(name) {
+		return nil, errors.Errorf("host %q not configured", name)
+	}
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if m.cert == nil {
+		return nil, errors.New("certificate not obtained yet")
+	}
+	return m.cert, nil
+}
+
+// Run loads certificate from the cache, obtains new one if missing or expiring,
+// and checks it periodically till ctx canceled. Returns error if the first attempt failed.
+func (m *Manager) Run(ctx context.Context) error {
+	if err := m.Renew(ctx); err != nil {
+		return err
+	}
+	go func() {
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := m.Renew(ctx); err != nil {
+					log.Printf("[WARN] can't renew certificate of %s, %v", strings.Join(m.Domains, ", "), err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// Renew loads certificate from the cache if not loaded and obtains new one if it is missing or expires soon
+func (m *Manager) Renew(ctx context.Context) error {
+	m.lock.RLock()
+	cert := m.cert
+	m.lock.RUnlock()
+
+	if cert == nil {
+		c, err := m.load(ctx)
+		if err != nil && err != autocert.ErrCacheMiss {
+			log.Printf("[WARN] can't load cached certificate, %v", err)
+		}
+		cert = c
+	}
+	if cert != nil && time.Until(cert.Leaf.NotAfter) > m.renewBefore() {
+		m.set(cert)
+		return nil
+	}
+
+	log.Printf("[INFO] obtain certificate of %s with dns-01 challenge", strings.Join(m.Domains, ", "))
+	cert, err := m.obtain(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "can't obtain certificate of %s", strings.Join(m.Domains, ", "))
+	}
+	m.set(cert)
+	log.Printf("[INFO] certificate of %s obtained, expires %s", strings.Join(m.Domains, ", "), cert.Leaf.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+func (m *Manager) set(cert *tls.Certificate) {
+	m.lock.Lock()
+	m.cert = cert
+	m.lock.Unlock()
+}
+
+// obtain makes new order, completes dns-01 challenges of its authorizations and stores issued certificate
+func (m *Manager) obtain(ctx context.Context) (*tls.Certificate, error) {
+	client, err := m.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(m.Domains...))
+	if err != nil {
+		return nil, errors.Wrap(err, "can't make order")
+	}
+	for _, u := range order.AuthzURLs {
+		if err = m.authorize(ctx, client, u); err != nil {
+			return nil, err
+		}
+	}
+	if order, err = client.WaitOrder(ctx, order.URI); err != nil {
+		return nil, errors.Wrap(err, "order not ready")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't make certificate key")
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader,
+		&x509.CertificateRequest{Subject: pkix.Name{CommonName: m.Domains[0]}, DNSNames: m.Domains}, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't make certificate request")
+	}
+	der, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't finalize order")
+	}
+
+	data, err := encodeCert(key, der)
+	if err != nil {
+		return nil, err
+	}
+	if err = m.Cache.Put(ctx, m.certName(), data); err != nil {
+		log.Printf("[WARN] can't cache certificate, %v", err)
+	}
+	return parseCert(data)
+}
+
+// authorize completes dns-01 challenge of pending authorization, TXT record removed after the check
+func (m *Manager) authorize(ctx context.Context, client *acme.Client, authzURL string) error {
+	authz, err := client.GetAuthorization(ctx, authzURL)
+	if err != nil {
+		return errors.Wrap(err, "can't get authorization")
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+	var chal *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == "dns-01" {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return errors.Errorf("no dns-01 challenge for %s", authz.Identifier.Value)
+	}
+
+	value, err := client.DNS01ChallengeRecord(chal.Token)
+	if err != nil {
+		return errors.Wrap(err, "can't make challenge record")
+	}
+	fqdn := "_acme-challenge." + strings.TrimPrefix(authz.Identifier.Value, "*.") + "."
+	if err = m.Provider.Present(ctx, fqdn, value); err != nil {
+		return errors.Wrapf(err, "can't set TXT record %s", fqdn)
+	}
+	defer func() {
+		if e := m.Provider.CleanUp(ctx, fqdn, value); e != nil {
+			log.Printf("[WARN] can't remove TXT record %s, %v", fqdn, e)
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(m.Propagation):
+	}
+	if _, err = client.Accept(ctx, chal); err != nil {
+		return errors.Wrapf(err, "can't accept challenge of %s", authz.Identifier.Value)
+	}
+	if _, err = client.WaitAuthorization(ctx, authz.URI); err != nil {
+		return errors.Wrapf(err, "authorization of %s failed", authz.Identifier.Value)
+	}
+	return nil
+}
+
+// client makes ACME client with the cached account key, registers account with new key
+func (m *Manager) client(ctx context.Context) (*acme.Client, error) {
+	var key crypto.Signer
+	data, err := m.Cache.Get(ctx, accountKeyName)
+	switch {
+	case err == nil:
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("invalid account key")
+		}
+		if key, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+			return nil, errors.Wrap(err, "can't parse account key")
+		}
+	case err == autocert.ErrCacheMiss:
+		ecKey, e := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if e != nil {
+			return nil, errors.Wrap(e, "can't make account key")
+		}
+		der, e := x509.MarshalECPrivateKey(ecKey)
+		if e != nil {
+			return nil, errors.Wrap(e, "can't encode account key")
+		}
+		if e = m.Cache.Put(ctx, accountKeyName, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})); e != nil {
+			return nil, errors.Wrap(e, "can't cache account key")
+		}
+		key = ecKey
+	default:
+		return nil, errors.Wrap(err, "can't load account key")
+	}
+
+	client := &acme.Client{Key: key, DirectoryURL: m.DirectoryURL, UserAgent: "remark42"}
+	acct := &acme.Account{}
+	if m.Email != "" {
+		acct.Contact = []string{"mailto:" + m.Email}
+	}
+	if _, err = client.Register(ctx, acct, acme.AcceptTOS); err != nil && err != acme.ErrAccountAlreadyExists {
+		return nil, errors.Wrap(err, "can't register account")
+	}
+	return client, nil
+}
+
+// load returns cached certificate
+func (m *Manager) load(ctx context.Context) (*tls.Certificate, error) {
+	data, err := m.Cache.Get(ctx, m.certName())
+	if err != nil {
+		return nil, err
+	}
+	return parseCert(data)
+}
+
+// certName is the cache key of certificate, doesn't clash with names of autocert
+func (m *Manager) certName() string {
+	return m.Domains[0] + "+dns01"
+}
+
+func (m *Manager) covers(name string) bool {
+	for _, d := range m.Domains {
+		d = strings.ToLower(d)
+		if d == name || (strings.HasPrefix(d, "*.") && strings.Count(name, ".") == strings.Count(d, ".") &&
+			strings.HasSuffix(name, d[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Manager) renewBefore() time.Duration {
+	if m.RenewBefore > 0 {
+		return m.RenewBefore
+	}
+	return 30 * 24 * time.Hour
+}
+
+// encodeCert makes PEM of private key followed by certificate chain, in format of autocert
+func encodeCert(key *ecdsa.PrivateKey, der [][]byte) ([]byte, error) {
+	var buf bytes.Buffer
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't encode certificate key")
+	}
+	if err = pem.Encode(&buf, &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}); err != nil {
+		return nil, errors.Wrap(err, "can't encode certificate key")
+	}
+	for _, b := range der {
+		if err = pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: b}); err != nil {
+			return nil, errors.Wrap(err, "can't encode certificate")
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// parseCert makes tls certificate from PEM of private key and certificate chain
+func parseCert(data []byte) (*tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid certificate")
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, errors.Wrap(err, "invalid certificate")
+	}
+	return &cert, nil
+}
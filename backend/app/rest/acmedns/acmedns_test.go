@@ -0,0 +1,244 @@
+package acmedns
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestManager_Renew(t *testing.T) {
+	provider := &mockProvider{records: map[string]string{}}
+	srv := newFakeACME(t, provider)
+	defer srv.Close()
+
+	cache := autocert.DirCache(t.TempDir())
+	m := &Manager{Domains: []string{"example.com"}, Email: "admin@example.com", DirectoryURL: srv.URL + "/dir",
+		Cache: cache, Provider: provider}
+
+	_, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+	assert.EqualError(t, err, "certificate not obtained yet")
+
+	require.NoError(t, m.Renew(context.Background()))
+	assert.Equal(t, 1, srv.orders)
+	assert.Equal(t, []string{"present _acme-challenge.example.com.", "cleanup _acme-challenge.example.com."}, provider.calls)
+	assert.Empty(t, provider.records, "TXT record removed")
+
+	cert, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "Example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"example.com"}, cert.Leaf.DNSNames)
+	_, err = m.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.com"})
+	assert.EqualError(t, err, `host "other.com" not configured`)
+
+	_, err = cache.Get(context.Background(), "acme_account+key")
+	assert.NoError(t, err, "account key cached")
+	_, err = cache.Get(context.Background(), "example.com+dns01")
+	assert.NoError(t, err, "certificate cached")
+
+	m2 := &Manager{Domains: []string{"example.com"}, DirectoryURL: srv.URL + "/dir", Cache: cache, Provider: provider}
+	require.NoError(t, m2.Renew(context.Background()))
+	assert.Equal(t, 1, srv.orders, "loaded from cache")
+	cert2, err := m2.GetCertificate(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	assert.Equal(t, cert.Certificate, cert2.Certificate)
+
+	m2.RenewBefore = 100 * 24 * time.Hour // certificate expires in 90 days
+	require.NoError(t, m2.Renew(context.Background()))
+	assert.Equal(t, 2, srv.orders, "renewed")
+	cert3, err := m2.GetCertificate(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	assert.NotEqual(t, cert.Certificate, cert3.Certificate)
+
+	provider.err = assert.AnError
+	err = m2.Renew(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "can't set TXT record _acme-challenge.example.com.")
+	cert4, err := m2.GetCertificate(&tls.ClientHelloInfo{})
+	require.NoError(t, err)
+	assert.Equal(t, cert3.Certificate, cert4.Certificate, "previous certificate kept")
+}
+
+func TestManager_covers(t *testing.T) {
+	m := Manager{Domains: []string{"remark42.example.com", "*.example.org"}}
+	assert.True(t, m.covers("remark42.example.com"))
+	assert.True(t, m.covers("comments.example.org"))
+	assert.False(t, m.covers("example.org"))
+	assert.False(t, m.covers("a.b.example.org"))
+	assert.False(t, m.covers("example.com"))
+}
+
+type mockProvider struct {
+	lock    sync.Mutex
+	records map[string]string
+	calls   []string
+	err     error
+}
+
+func (p *mockProvider) Present(_ context.Context, fqdn, value string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	p.calls = append(p.calls, "present "+fqdn)
+	p.records[fqdn] = value
+	return nil
+}
+
+func (p *mockProvider) CleanUp(_ context.Context, fqdn, _ string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.calls = append(p.calls, "cleanup "+fqdn)
+	delete(p.records, fqdn)
+	return nil
+}
+
+func (p *mockProvider) record(fqdn string) string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.records[fqdn]
+}
+
+// fakeACME is a minimal RFC 8555 server issuing certificates after dns-01 challenge, signatures not checked
+type fakeACME struct {
+	*httptest.Server
+	t        *testing.T
+	provider *mockProvider
+	caKey    *ecdsa.PrivateKey
+	caCert   *x509.Certificate
+
+	lock       sync.Mutex
+	orders     int
+	authzValid bool
+	certDER    []byte
+}
+
+func newFakeACME(t *testing.T, provider *mockProvider) *fakeACME {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "fake ca"}, IsCA: true,
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour * 24 * 365),
+		KeyUsage: x509.KeyUsageCertSign, BasicConstraintsValid: true}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	res := &fakeACME{t: t, provider: provider, caKey: caKey, caCert: caCert}
+	res.Server = httptest.NewServer(http.HandlerFunc(res.handle))
+	return res
+}
+
+func (f *fakeACME) handle(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	u := f.URL
+	w.Header().Set("Replay-Nonce", base64.RawURLEncoding.EncodeToString([]byte(time.Now().String())))
+	w.Header().Set("Content-Type", "application/json")
+
+	order := func(status string) map[string]interface{} {
+		res := map[string]interface{}{"status": status, "identifiers": []map[string]string{{"type": "dns", "value": "example.com"}},
+			"authorizations": []string{u + "/authz/1"}, "finalize": u + "/finalize/1"}
+		if status == "valid" {
+			res["certificate"] = u + "/cert/1"
+		}
+		return res
+	}
+	send := func(status int, v interface{}) {
+		w.WriteHeader(status)
+		require.NoError(f.t, json.NewEncoder(w).Encode(v))
+	}
+
+	switch r.URL.Path {
+	case "/dir":
+		send(http.StatusOK, map[string]string{"newNonce": u + "/nonce", "newAccount": u + "/account", "newOrder": u + "/order"})
+	case "/nonce":
+		w.WriteHeader(http.StatusOK)
+	case "/account":
+		w.Header().Set("Location", u+"/acct/1")
+		send(http.StatusCreated, map[string]string{"status": "valid"})
+	case "/order":
+		f.orders++
+		f.authzValid, f.certDER = false, nil
+		w.Header().Set("Location", u+"/order/1")
+		send(http.StatusCreated, order("pending"))
+	case "/order/1":
+		w.Header().Set("Location", u+"/order/1")
+		switch {
+		case f.certDER != nil:
+			send(http.StatusOK, order("valid"))
+		case f.authzValid:
+			send(http.StatusOK, order("ready"))
+		default:
+			send(http.StatusOK, order("pending"))
+		}
+	case "/authz/1":
+		status := "pending"
+		if f.authzValid {
+			status = "valid"
+		}
+		send(http.StatusOK, map[string]interface{}{"status": status, "identifier": map[string]string{"type": "dns", "value": "example.com"},
+			"challenges": []map[string]string{{"type": "http-01", "url": u + "/chal/2", "token": "tok2", "status": "pending"},
+				{"type": "dns-01", "url": u + "/chal/1", "token": "tok1", "status": status}}})
+	case "/chal/1":
+		if f.provider.record("_acme-challenge.example.com.") == "" {
+			send(http.StatusBadRequest, map[string]string{"type": "urn:ietf:params:acme:error:incorrectResponse", "detail": "no TXT record"})
+			return
+		}
+		f.authzValid = true
+		send(http.StatusOK, map[string]string{"type": "dns-01", "url": u + "/chal/1", "token": "tok1", "status": "valid"})
+	case "/finalize/1":
+		var req struct {
+			CSR string `json:"csr"`
+		}
+		require.NoError(f.t, json.Unmarshal(f.payload(r), &req))
+		csrDER, err := base64.RawURLEncoding.DecodeString(req.CSR)
+		require.NoError(f.t, err)
+		csr, err := x509.ParseCertificateRequest(csrDER)
+		require.NoError(f.t, err)
+		tmpl := &x509.Certificate{SerialNumber: big.NewInt(time.Now().UnixNano()), Subject: csr.Subject, DNSNames: csr.DNSNames,
+			NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(90 * 24 * time.Hour),
+			KeyUsage: x509.KeyUsageDigitalSignature, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}
+		f.certDER, err = x509.CreateCertificate(rand.Reader, tmpl, f.caCert, csr.PublicKey, f.caKey)
+		require.NoError(f.t, err)
+		w.Header().Set("Location", u+"/order/1")
+		send(http.StatusOK, order("valid"))
+	case "/cert/1":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.WriteHeader(http.StatusOK)
+		_ = pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: f.certDER})
+		_ = pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: f.caCert.Raw})
+	default:
+		send(http.StatusNotFound, map[string]string{"type": "urn:ietf:params:acme:error:malformed", "detail": "not found"})
+	}
+}
+
+// payload decodes payload of JWS request
+func (f *fakeACME) payload(r *http.Request) []byte {
+	body, err := ioutil.ReadAll(r.Body)
+	require.NoError(f.t, err)
+	var jws struct {
+		Payload string `json:"payload"`
+	}
+	require.NoError(f.t, json.Unmarshal(body, &jws))
+	res, err := base64.RawURLEncoding.DecodeString(jws.Payload)
+	require.NoError(f.t, err)
+	return res
+}
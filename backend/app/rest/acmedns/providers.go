@@ -0,0 +1,158 @@
+package acmedns
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Exec provider runs script with "present" or "cleanup" command, fqdn and value of TXT record as arguments,
+// i.e. "/srv/dns.sh present _acme-challenge.example.com. value"
+type Exec struct {
+	Script  string
+	Timeout time.Duration
+}
+
+// Present runs script to set TXT record
+func (e *Exec) Present(ctx context.Context, fqdn, value string) error {
+	return e.run(ctx, "present", fqdn, value)
+}
+
+// CleanUp runs script to remove TXT record
+func (e *Exec) CleanUp(ctx context.Context, fqdn, value string) error {
+	return e.run(ctx, "cleanup", fqdn, value)
+}
+
+func (e *Exec) run(ctx context.Context, command, fqdn, value string) error {
+	if e.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.Timeout)
+		defer cancel()
+	}
+	out, err := exec.CommandContext(ctx, e.Script, command, fqdn, value).CombinedOutput() // nolint:gosec // script set by admin
+	if err != nil {
+		return errors.Wrapf(err, "%s %s failed: %s", e.Script, command, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Cloudflare provider sets TXT records with Cloudflare API, token should have Zone.DNS edit permission
+type Cloudflare struct {
+	Token   string
+	BaseURL string // api url, https://api.cloudflare.com/client/v4 if empty
+	Client  *http.Client
+}
+
+type cloudflareResponse struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+	Result json.RawMessage `json:"result"`
+}
+
+// Present creates TXT record in the zone of fqdn
+func (c *Cloudflare) Present(ctx context.Context, fqdn, value string) error {
+	zoneID, err := c.zone(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+	rec := struct {
+		Type    string `json:"type"`
+		Name    string `json:"name"`
+		Content string `json:"content"`
+		TTL     int    `json:"ttl"`
+	}{Type: "TXT", Name: strings.TrimSuffix(fqdn, "."), Content: value, TTL: 120}
+	return c.call(ctx, http.MethodPost, "/zones/"+zoneID+"/dns_records", rec, nil)
+}
+
+// CleanUp removes TXT records with the value from the zone of fqdn
+func (c *Cloudflare) CleanUp(ctx context.Context, fqdn, value string) error {
+	zoneID, err := c.zone(ctx, fqdn)
+	if err != nil {
+		return err
+	}
+	var records []struct {
+		ID string `json:"id"`
+	}
+	q := url.Values{"type": {"TXT"}, "name": {strings.TrimSuffix(fqdn, ".")}, "content": {value}}
+	if err = c.call(ctx, http.MethodGet, "/zones/"+zoneID+"/dns_records?"+q.Encode(), nil, &records); err != nil {
+		return err
+	}
+	for _, r := range records {
+		if err = c.call(ctx, http.MethodDelete, "/zones/"+zoneID+"/dns_records/"+r.ID, nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// zone returns id of the closest zone of fqdn, looked up from the longest name to the shortest
+func (c *Cloudflare) zone(ctx context.Context, fqdn string) (string, error) {
+	labels := strings.Split(strings.TrimSuffix(fqdn, "."), ".")
+	for i := 1; i < len(labels)-1; i++ {
+		var zones []struct {
+			ID string `json:"id"`
+		}
+		name := strings.Join(labels[i:], ".")
+		if err := c.call(ctx, http.MethodGet, "/zones?"+url.Values{"name": {name}}.Encode(), nil, &zones); err != nil {
+			return "", err
+		}
+		if len(zones) > 0 {
+			return zones[0].ID, nil
+		}
+	}
+	return "", errors.Errorf("no zone of %s", fqdn)
+}
+
+func (c *Cloudflare) call(ctx context.Context, method, path string, body, result interface{}) error {
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			return errors.Wrap(err, "can't encode request")
+		}
+	}
+	baseURL := c.BaseURL
+	if baseURL == "" {
+		baseURL = "https://api.cloudflare.com/client/v4"
+	}
+	req, err := http.NewRequestWithContext(ctx, method, baseURL+path, &reqBody)
+	if err != nil {
+		return errors.Wrap(err, "can't make request")
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := c.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "cloudflare %s %s failed", method, path)
+	}
+	defer resp.Body.Close() // nolint
+
+	var res cloudflareResponse
+	if err = json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return errors.Wrapf(err, "can't decode response of cloudflare, status %d", resp.StatusCode)
+	}
+	if !res.Success {
+		msgs := make([]string, 0, len(res.Errors))
+		for _, e := range res.Errors {
+			msgs = append(msgs, e.Message)
+		}
+		return errors.Errorf("cloudflare %s %s failed, status %d: %s", method, path, resp.StatusCode, strings.Join(msgs, "; "))
+	}
+	if result != nil {
+		return errors.Wrap(json.Unmarshal(res.Result, result), "can't decode result")
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package acmedns
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExec(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out.txt")
+	script := filepath.Join(dir, "dns.sh")
+	require.NoError(t, ioutil.WriteFile(script, []byte("#!/bin/sh\necho \"$1 $2 $3\" >> "+out+"\n"), 0700)) //nolint:gosec
+
+	e := &Exec{Script: script, Timeout: time.Second}
+	require.NoError(t, e.Present(context.Background(), "_acme-challenge.example.com.", "val"))
+	require.NoError(t, e.CleanUp(context.Background(), "_acme-challenge.example.com.", "val"))
+	data, err := ioutil.ReadFile(out) // nolint
+	require.NoError(t, err)
+	assert.Equal(t, "present _acme-challenge.example.com. val\ncleanup _acme-challenge.example.com. val\n", string(data))
+
+	require.NoError(t, ioutil.WriteFile(script, []byte("#!/bin/sh\necho 'bad token'\nexit 1\n"), 0700)) //nolint:gosec
+	err = e.Present(context.Background(), "_acme-challenge.example.com.", "val")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "present failed: bad token")
+
+	e = &Exec{Script: filepath.Join(dir, "missing.sh")}
+	assert.Error(t, e.Present(context.Background(), "_acme-challenge.example.com.", "val"))
+}
+
+func TestCloudflare(t *testing.T) {
+	var lock sync.Mutex
+	records := map[string]map[string]string{} // id -> record
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"success":false,"errors":[{"message":"Invalid API Token"}]}`))
+			return
+		}
+		send := func(result interface{}) {
+			require.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "result": result}))
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/zones":
+			if r.URL.Query().Get("name") == "example.com" {
+				send([]map[string]string{{"id": "zone1"}})
+				return
+			}
+			send([]map[string]string{})
+		case r.Method == http.MethodPost && r.URL.Path == "/zones/zone1/dns_records":
+			rec := map[string]interface{}{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&rec))
+			assert.Equal(t, "TXT", rec["type"])
+			records["rec1"] = map[string]string{"name": rec["name"].(string), "content": rec["content"].(string)}
+			send(map[string]string{"id": "rec1"})
+		case r.Method == http.MethodGet && r.URL.Path == "/zones/zone1/dns_records":
+			res := []map[string]string{}
+			for id, rec := range records {
+				if rec["name"] == r.URL.Query().Get("name") && rec["content"] == r.URL.Query().Get("content") {
+					res = append(res, map[string]string{"id": id})
+				}
+			}
+			send(res)
+		case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/zones/zone1/dns_records/"):
+			delete(records, strings.TrimPrefix(r.URL.Path, "/zones/zone1/dns_records/"))
+			send(map[string]string{})
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+	}))
+	defer ts.Close()
+
+	c := &Cloudflare{Token: "secret", BaseURL: ts.URL}
+	require.NoError(t, c.Present(context.Background(), "_acme-challenge.remark42.example.com.", "val"))
+	assert.Equal(t, map[string]map[string]string{"rec1": {"name": "_acme-challenge.remark42.example.com", "content": "val"}}, records)
+	require.NoError(t, c.CleanUp(context.Background(), "_acme-challenge.remark42.example.com.", "val"))
+	assert.Empty(t, records)
+
+	err := c.Present(context.Background(), "_acme-challenge.example.org.", "val")
+	assert.EqualError(t, err, "no zone of _acme-challenge.example.org.")
+
+	c.Token = "bad"
+	err = c.Present(context.Background(), "_acme-challenge.example.com.", "val")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "status 403: Invalid API Token")
+}
@@ -1,9 +1,14 @@
 package api
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"path"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -13,21 +18,81 @@ import (
 	log "github.com/go-pkgz/lgr"
 	R "github.com/go-pkgz/rest"
 
+	"github.com/umputun/remark42/backend/app/audit"
+	"github.com/umputun/remark42/backend/app/fingerprint"
+	"github.com/umputun/remark42/backend/app/identity"
+	"github.com/umputun/remark42/backend/app/metrics"
+	"github.com/umputun/remark42/backend/app/migrator"
+	"github.com/umputun/remark42/backend/app/moderation"
+	"github.com/umputun/remark42/backend/app/notify"
+	"github.com/umputun/remark42/backend/app/plugin"
 	"github.com/umputun/remark42/backend/app/rest"
+	"github.com/umputun/remark42/backend/app/rest/apitokens"
+	"github.com/umputun/remark42/backend/app/rest/jwtkeys"
+	"github.com/umputun/remark42/backend/app/rest/proxy"
+	"github.com/umputun/remark42/backend/app/rest/sessions"
+	"github.com/umputun/remark42/backend/app/retention"
+	"github.com/umputun/remark42/backend/app/roles"
+	"github.com/umputun/remark42/backend/app/schedule"
+	"github.com/umputun/remark42/backend/app/settings"
+	"github.com/umputun/remark42/backend/app/sites"
+	"github.com/umputun/remark42/backend/app/slowlog"
+	"github.com/umputun/remark42/backend/app/spam"
 	"github.com/umputun/remark42/backend/app/store"
 	"github.com/umputun/remark42/backend/app/store/engine"
+	"github.com/umputun/remark42/backend/app/store/replication"
+	"github.com/umputun/remark42/backend/app/store/service"
+	"github.com/umputun/remark42/backend/app/templates"
+	"github.com/umputun/remark42/backend/app/trust"
+	"github.com/umputun/remark42/backend/app/verified"
+	"github.com/umputun/remark42/backend/app/votefraud"
+	"github.com/umputun/remark42/backend/app/warmup"
 )
 
 // admin provides router for all requests available for admin users only
 type admin struct {
-	dataService   adminStore
-	cache         LoadingCache
-	authenticator *auth.Service
-	readOnlyAge   int
-	migrator      *Migrator
+	dataService      adminStore
+	cache            LoadingCache
+	authenticator    *auth.Service
+	settings         *settings.Service
+	migrator         *Migrator
+	bounceStore      notify.BounceStore
+	notifyService    *notify.Service
+	archiver         *migrator.Archiver
+	plugins          *plugin.Service
+	spamService      *spam.Service
+	moderationFilter *moderation.Filter
+	maintenance      *Maintenance
+	renotifier       *renotifier
+	metrics          *metrics.Metrics
+	voteFraud        *votefraud.Detector
+	fingerprints     *fingerprint.Service
+	jwtKeys          *jwtkeys.Keyring
+	sessions         *sessions.Service
+	warmup           *warmup.Service
+	audit            *audit.Service
+	schedule         *schedule.Service
+	verified         *verified.Service
+	trust            *trust.Service
+	identity         *identity.Service
+	apiTokens        *apitokens.Service
+	slowLog          *slowlog.Recorder
+	retention        *retention.Service
+	siteKey          func(siteID string) (string, error) // signs badges of posts
+	remarkURL        string
+	roles            *roles.Service
+	imageProxy       *proxy.Image
+	sites            *sites.Service
+	telegram         TelegramBot
+	templates        templates.FileReader
+	links            store.Links
+
+	replicationPrimary *replication.Primary
+	replicationStandby *replication.Standby
 }
 
 type adminStore interface {
+	Get(locator store.Locator, commentID string, user store.User) (store.Comment, error)
 	Delete(locator store.Locator, commentID string, mode store.DeleteMode) error
 	DeleteUser(siteID string, userID string, mode store.DeleteMode) error
 	DeleteUserDetail(siteID string, userID string, detail engine.UserDetail) error
@@ -35,26 +100,76 @@ type adminStore interface {
 	IsBlocked(siteID string, userID string) bool
 	SetBlock(siteID string, userID string, status bool, ttl time.Duration) error
 	BlockedUsers(siteID string) ([]store.BlockedUser, error)
+	SetShadowBan(siteID, userID string, status bool) error
+	ShadowBannedUsers(siteID string) ([]string, error)
 	Info(locator store.Locator, readonlyAge int) (store.PostInfo, error)
 	SetTitle(locator store.Locator, commentID string) (comment store.Comment, err error)
 	SetVerified(siteID string, userID string, status bool) error
 	SetReadOnly(locator store.Locator, status bool) error
+	SetSlowMode(locator store.Locator, status bool) error
+	Interval(locator store.Locator) int
+	SetInterval(locator store.Locator, minutes int) error
+	Intervals(siteID string) ([]service.PostInterval, error)
+	SetPending(locator store.Locator, commentID string, status bool) error
+	PendingComments(siteID string) ([]store.Comment, error)
+	ReportedComments(siteID string) ([]store.Comment, error)
+	DismissReports(locator store.Locator, commentID string) error
+	DeletedComments(siteID string) ([]service.TrashedComment, error)
+	Undelete(locator store.Locator, commentID string) (store.Comment, error)
+	Consents(siteID string) ([]engine.UserDetailEntry, error)
 	SetPin(locator store.Locator, commentID string, status bool) error
+	SetLabel(locator store.Locator, commentID, label string, status bool) (store.Comment, error)
+	Labeled(locator store.Locator, label string) ([]store.Comment, error)
+	Integrity(req engine.IntegrityRequest) (engine.IntegrityReport, error)
+	SentimentTrends(locator store.Locator, since time.Time) (service.SentimentTrends, error)
+	RebuildSearchIndex(siteID string) (int, error)
+	Reattribute(siteID, fromID, toID string, dryRun bool) (service.ReattributeResult, error)
+	RemapURLs(siteID string, mapURL func(url string) string, dryRun bool) (service.RemapResult, error)
+	BackupSite(siteID string, w io.Writer) error
+	RestoreSite(siteID string, r io.Reader) error
+	DetachSite(siteID string) (string, error)
+	Created(siteID string, from, to time.Time) ([]store.Comment, error)
+	Matched(siteID string, filter service.CommentFilter, limit int) ([]store.Comment, error)
+	ExportComments(siteID string, filter service.ExportFilter, fn func(store.Comment) error) error
+	ExportUsers(siteID string, filter service.ExportFilter) ([]service.UserSummary, error)
+	FindAsOf(locator store.Locator, sortMethod string, asOf time.Time) ([]store.Comment, error)
+	FindByExternalID(siteID, externalID string, user store.User) (store.Comment, error)
+	VoidVote(locator store.Locator, commentID, userID string) (store.Comment, bool, error)
 }
 
-// DELETE /comment/{id}?site=siteID&url=post-url - removes comment
+// DELETE /comment/{id}?site=siteID&url=post-url&reason=text - removes comment, author notified with optional reason
 func (a *admin) deleteCommentCtrl(w http.ResponseWriter, r *http.Request) {
 
 	id := chi.URLParam(r, "id")
 	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
 	log.Printf("[INFO] delete comment %s", id)
 
+	// comment read before deletion to keep its text for the author's notification
+	comment, getErr := a.dataService.Get(locator, id, store.User{})
+	if getErr == nil && !comment.Deleted {
+		user := rest.MustGetUserInfo(r)
+		ev := plugin.Event{Hook: plugin.HookCommentDeleting, SiteID: locator.SiteID, Comment: &comment, User: &user,
+			Reason: r.URL.Query().Get("reason")}
+		if _, e := a.plugins.Before(ev); e != nil {
+			rest.SendErrorJSON(w, r, http.StatusForbidden, e, "rejected by plugin", rest.ErrActionRejected)
+			return
+		}
+	}
+
 	err := a.dataService.Delete(locator, id, store.SoftDelete)
 	if err != nil {
 		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't delete comment", rest.ErrInternal)
 		return
 	}
 	a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.SiteID, locator.URL, lastCommentsScope))
+	if getErr == nil && !comment.Deleted {
+		a.metrics.CommentDeleted(locator.SiteID)
+		a.notifyModeration(comment, notify.ModerationDeleted, r.URL.Query().Get("reason"))
+		user := rest.MustGetUserInfo(r)
+		a.plugins.After(plugin.Event{Hook: plugin.HookCommentDelete, SiteID: locator.SiteID, Comment: &comment, User: &user})
+		a.record(r, audit.Entry{SiteID: locator.SiteID, Action: audit.ActionDelete, Target: id, URL: locator.URL,
+			Reason: r.URL.Query().Get("reason")})
+	}
 	render.Status(r, http.StatusOK)
 	render.JSON(w, r, R.JSON{"id": id, "locator": locator})
 }
@@ -70,6 +185,7 @@ func (a *admin) deleteUserCtrl(w http.ResponseWriter, r *http.Request) {
 		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't delete user", rest.ErrInternal)
 		return
 	}
+	a.record(r, audit.Entry{SiteID: siteID, Action: audit.ActionDeleteUser, Target: userID})
 	a.cache.Flush(cache.Flusher(siteID).Scopes(userID, siteID, lastCommentsScope))
 	render.Status(r, http.StatusOK)
 	render.JSON(w, r, R.JSON{"user_id": userID, "site_id": siteID})
@@ -135,7 +251,7 @@ func (a *admin) deleteMeRequestCtrl(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, R.JSON{"user_id": claims.User.ID, "site_id": claims.Audience})
 }
 
-// PUT /user/{userid}?site=side-id&block=1&ttl=7d - block or unblock user
+// PUT /user/{userid}?site=side-id&block=1&ttl=7d&reason=text - block or unblock user, reason recorded to audit log
 func (a *admin) setBlockCtrl(w http.ResponseWriter, r *http.Request) {
 	userID := chi.URLParam(r, "userid")
 	siteID := r.URL.Query().Get("site")
@@ -148,6 +264,14 @@ func (a *admin) setBlockCtrl(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if blockStatus {
+		ev := plugin.Event{Hook: plugin.HookUserBlock, SiteID: siteID, User: &store.User{ID: userID}, Reason: r.URL.Query().Get("reason")}
+		if _, err := a.plugins.Before(ev); err != nil {
+			rest.SendErrorJSON(w, r, http.StatusForbidden, err, "rejected by plugin", rest.ErrActionRejected)
+			return
+		}
+	}
+
 	if err := a.dataService.SetBlock(siteID, userID, blockStatus, ttl); err != nil {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set blocking status", rest.ErrActionRejected)
 		return
@@ -159,6 +283,14 @@ func (a *admin) setBlockCtrl(w http.ResponseWriter, r *http.Request) {
 			log.Printf("[WARN] can't delete comments for blocked user %s on site %s, %v", userID, siteID, err)
 		}
 	}
+	entry := audit.Entry{SiteID: siteID, Action: audit.ActionUnblock, Target: userID}
+	if blockStatus {
+		entry.Action, entry.Reason = audit.ActionBlock, r.URL.Query().Get("reason")
+		if ttl > 0 {
+			entry.Reason = strings.TrimSpace(fmt.Sprintf("%s (for %s)", entry.Reason, ttl))
+		}
+	}
+	a.record(r, entry)
 	a.cache.Flush(cache.Flusher(siteID).Scopes(userID, siteID, lastCommentsScope))
 	render.JSON(w, r, R.JSON{"user_id": userID, "site_id": siteID, "block": blockStatus})
 }
@@ -174,19 +306,60 @@ func (a *admin) blockedUsersCtrl(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, users)
 }
 
+// PUT /shadowban/{userid}?site=siteID&shadowban=1&reason=text - shadow-ban or lift shadow-ban of the user.
+// Comments of shadow-banned user accepted as usual but shown to the user only.
+func (a *admin) setShadowBanCtrl(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userid")
+	siteID := r.URL.Query().Get("site")
+	shadowStatus := r.URL.Query().Get("shadowban") == "1"
+
+	if err := a.dataService.SetShadowBan(siteID, userID, shadowStatus); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set shadow-ban status", rest.ErrActionRejected)
+		return
+	}
+	entry := audit.Entry{SiteID: siteID, Action: audit.ActionUnshadowBan, Target: userID}
+	if shadowStatus {
+		entry.Action, entry.Reason = audit.ActionShadowBan, r.URL.Query().Get("reason")
+	}
+	a.record(r, entry)
+	a.cache.Flush(cache.Flusher(siteID).Scopes(userID, siteID, lastCommentsScope))
+	render.JSON(w, r, R.JSON{"user_id": userID, "site_id": siteID, "shadowban": shadowStatus})
+}
+
+// GET /shadowbanned?site=siteID - list shadow-banned users
+func (a *admin) shadowBannedUsersCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID := r.URL.Query().Get("site")
+	ids, err := a.dataService.ShadowBannedUsers(siteID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get shadow-banned users", rest.ErrSiteNotFound)
+		return
+	}
+	users := make([]store.User, 0, len(ids))
+	for _, id := range ids {
+		user := store.User{ID: id}
+		// get user name from the last comment of the user
+		if comments, e := a.dataService.User(siteID, id, 1, 0, store.User{Admin: true}); e == nil && len(comments) > 0 {
+			user = comments[0].User
+		}
+		users = append(users, user)
+	}
+	render.JSON(w, r, users)
+}
+
 // PUT /readonly?site=siteID&url=post-url&ro=1 - set or reset read-only status for the post
 func (a *admin) setReadOnlyCtrl(w http.ResponseWriter, r *http.Request) {
 	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
 	roStatus := r.URL.Query().Get("ro") == "1"
 
+	readOnlyAge := a.settings.ReadOnlyAge(locator.SiteID)
 	isRoByAge := func(info store.PostInfo) bool {
-		return a.readOnlyAge > 0 && !info.FirstTS.IsZero() &&
-			info.FirstTS.AddDate(0, 0, a.readOnlyAge).Before(time.Now())
+		return readOnlyAge > 0 && !info.FirstTS.IsZero() &&
+			info.FirstTS.AddDate(0, 0, readOnlyAge).Before(time.Now())
 	}
 
 	// don't allow to reset ro for posts turned to ro by ReadOnlyAge
 	if !roStatus {
-		if info, e := a.dataService.Info(locator, a.readOnlyAge); e == nil && isRoByAge(info) {
+		if info, e := a.dataService.Info(locator, readOnlyAge); e == nil && isRoByAge(info) {
 			rest.SendErrorJSON(w, r, http.StatusForbidden, errors.New("rejected"),
 				"read-only due the age", rest.ErrActionRejected)
 			return
@@ -201,6 +374,133 @@ func (a *admin) setReadOnlyCtrl(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, R.JSON{"locator": locator, "read-only": roStatus})
 }
 
+// PUT /slowmode?site=siteID&url=post-url&slow=1 - set or reset slow mode for the post
+func (a *admin) setSlowModeCtrl(w http.ResponseWriter, r *http.Request) {
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	slowStatus := r.URL.Query().Get("slow") == "1"
+
+	if err := a.dataService.SetSlowMode(locator, slowStatus); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set slow mode status", rest.ErrPostNotFound)
+		return
+	}
+	a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.URL, locator.SiteID, lastCommentsScope))
+	render.JSON(w, r, R.JSON{"locator": locator, "slow-mode": slowStatus})
+}
+
+// GET /schedule?site=siteID&url=post-url - get schedule of the post, or schedules of all posts of the site without url
+func (a *admin) getScheduleCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.schedule == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("scheduling disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	if locator.URL == "" {
+		schedules, err := a.schedule.List(locator.SiteID)
+		if err != nil {
+			rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get schedules", rest.ErrInternal)
+			return
+		}
+		render.JSON(w, r, schedules)
+		return
+	}
+	sch, found, err := a.schedule.Get(locator.SiteID, locator.URL)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get schedule", rest.ErrInternal)
+		return
+	}
+	if !found {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("post not scheduled"), "not found", rest.ErrPostNotFound)
+		return
+	}
+	render.JSON(w, r, sch)
+}
+
+// PUT /schedule?site=siteID&url=post-url - set schedule of the post, body is {"open_at": "RFC3339", "close_after": days}.
+// Comments open at open_at and close after close_after days, counted from now if open_at not set.
+func (a *admin) setScheduleCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.schedule == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("scheduling disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	req := struct {
+		OpenAt     time.Time `json:"open_at"`
+		CloseAfter int       `json:"close_after"`
+	}{}
+	if err := render.DecodeJSON(http.MaxBytesReader(w, r.Body, hardBodyLimit), &req); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't decode schedule", rest.ErrDecode)
+		return
+	}
+	sch, err := a.schedule.Set(locator.SiteID, locator.URL, req.OpenAt, req.CloseAfter)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set schedule", rest.ErrActionRejected)
+		return
+	}
+	log.Printf("[INFO] schedule of %s set to open at %v, close at %v", locator.URL, sch.OpenAt, sch.CloseAt)
+	a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.URL))
+	render.JSON(w, r, sch)
+}
+
+// DELETE /schedule?site=siteID&url=post-url - delete schedule of the post, comments open as usual
+func (a *admin) deleteScheduleCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.schedule == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("scheduling disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	if err := a.schedule.Delete(locator.SiteID, locator.URL); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't delete schedule", rest.ErrInternal)
+		return
+	}
+	a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.URL))
+	render.JSON(w, r, R.JSON{"locator": locator, "deleted": true})
+}
+
+// GET /interval?site=siteID&url=post-url - get interval between comments of each user to the post,
+// or intervals of all posts of the site with interval set without url
+func (a *admin) getIntervalCtrl(w http.ResponseWriter, r *http.Request) {
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	if locator.URL == "" {
+		intervals, err := a.dataService.Intervals(locator.SiteID)
+		if err != nil {
+			rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get intervals", rest.ErrInternal)
+			return
+		}
+		render.JSON(w, r, intervals)
+		return
+	}
+	render.JSON(w, r, service.PostInterval{URL: locator.URL, Minutes: a.dataService.Interval(locator)})
+}
+
+// PUT /interval?site=siteID&url=post-url&minutes=10 - set interval in minutes between comments of each user to the post,
+// 0 removes the limit
+func (a *admin) setIntervalCtrl(w http.ResponseWriter, r *http.Request) {
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	minutes, err := strconv.Atoi(r.URL.Query().Get("minutes"))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't parse minutes", rest.ErrDecode)
+		return
+	}
+	if err = a.dataService.SetInterval(locator, minutes); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set interval", rest.ErrActionRejected)
+		return
+	}
+	log.Printf("[INFO] interval of %s set to %dm", locator.URL, minutes)
+	a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.URL))
+	render.JSON(w, r, R.JSON{"locator": locator, "interval": minutes})
+}
+
+// GET /external?site=siteID&id=external-id - get comment by external id set by integration on creation
+func (a *admin) externalCommentCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID, externalID := r.URL.Query().Get("site"), r.URL.Query().Get("id")
+	comment, err := a.dataService.FindByExternalID(siteID, externalID, rest.MustGetUserInfo(r))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, err, "can't find comment by external id", rest.ErrCommentNotFound)
+		return
+	}
+	render.JSON(w, r, comment)
+}
+
 // PUT /title/{id}?site=siteID&url=post-url - set comment PostTitle to page's title
 func (a *admin) setTitleCtrl(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
@@ -228,6 +528,11 @@ func (a *admin) setVerifyCtrl(w http.ResponseWriter, r *http.Request) {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set verify status", rest.ErrActionRejected)
 		return
 	}
+	if verifyStatus {
+		a.record(r, audit.Entry{SiteID: siteID, Action: audit.ActionVerify, Target: userID})
+	} else {
+		a.record(r, audit.Entry{SiteID: siteID, Action: audit.ActionUnverify, Target: userID})
+	}
 	a.cache.Flush(cache.Flusher(siteID).Scopes(siteID, userID))
 	render.JSON(w, r, R.JSON{"user": userID, "verified": verifyStatus})
 }
@@ -243,6 +548,1586 @@ func (a *admin) setPinCtrl(w http.ResponseWriter, r *http.Request) {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set pin status", rest.ErrActionRejected)
 		return
 	}
+	if pinStatus {
+		a.record(r, audit.Entry{SiteID: locator.SiteID, Action: audit.ActionPin, Target: commentID, URL: locator.URL})
+	} else {
+		a.record(r, audit.Entry{SiteID: locator.SiteID, Action: audit.ActionUnpin, Target: commentID, URL: locator.URL})
+	}
 	a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.URL))
 	render.JSON(w, r, R.JSON{"id": commentID, "locator": locator, "pin": pinStatus})
 }
+
+// PUT /label/{id}?site=siteID&url=post-url&label=question - add label to the comment
+// DELETE /label/{id}?site=siteID&url=post-url&label=question - remove label from the comment
+func (a *admin) setLabelCtrl(w http.ResponseWriter, r *http.Request) {
+	commentID := chi.URLParam(r, "id")
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	status := r.Method != http.MethodDelete
+
+	comment, err := a.dataService.SetLabel(locator, commentID, r.URL.Query().Get("label"), status)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set label", rest.ErrActionRejected)
+		return
+	}
+	a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.URL, lastCommentsScope, comment.User.ID))
+	labels := comment.Labels
+	if labels == nil {
+		labels = []string{}
+	}
+	render.JSON(w, r, R.JSON{"id": commentID, "locator": locator, "labels": labels})
+}
+
+// GET /labeled?site=siteID&label=question&url=post-url&export=1 - comments with the label, sorted by time.
+// url is optional, all posts of the site used if not set. With export=1 returned as json file.
+func (a *admin) labeledCommentsCtrl(w http.ResponseWriter, r *http.Request) {
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	label := r.URL.Query().Get("label")
+	comments, err := a.dataService.Labeled(locator, label)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get labeled comments", rest.ErrActionRejected)
+		return
+	}
+	if r.URL.Query().Get("export") == "1" {
+		exportFile := fmt.Sprintf("%s-%s-%s.json", locator.SiteID, strings.ToLower(strings.TrimSpace(label)), time.Now().Format("20060102"))
+		w.Header().Set("Content-Disposition", "attachment;filename="+exportFile)
+	}
+	render.JSON(w, r, comments)
+}
+
+// GET /find?site=siteID&url=post-url&as_of=RFC3339&sort=fld&format=tree - comments of the post as they were
+// at as_of time, with later edits rolled back and later comments skipped. Used to review disputes about edited comments.
+func (a *admin) findAsOfCtrl(w http.ResponseWriter, r *http.Request) {
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	asOf, err := time.Parse(time.RFC3339, r.URL.Query().Get("as_of"))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't parse as_of time", rest.ErrDecode)
+		return
+	}
+	sort := r.URL.Query().Get("sort")
+	if strings.HasPrefix(sort, " ") { // restore + replaced by " "
+		sort = "+" + sort[1:]
+	}
+
+	comments, err := a.dataService.FindAsOf(locator, sort, asOf)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't find comments", rest.ErrCommentNotFound)
+		return
+	}
+	if r.URL.Query().Get("format") == "tree" {
+		tree := service.MakeTree(comments, sort, a.settings.ReadOnlyAge(locator.SiteID))
+		if tree.Nodes == nil { // eliminate json nil serialization
+			tree.Nodes = []*service.Node{}
+		}
+		render.JSON(w, r, tree)
+		return
+	}
+	withInfo := commentsWithInfo{Comments: comments}
+	if info, e := a.dataService.Info(locator, a.settings.ReadOnlyAge(locator.SiteID)); e == nil {
+		withInfo.Info = info
+	}
+	render.JSON(w, r, withInfo)
+}
+
+// PUT /spam/{id}?site=siteID&url=post-url&spam=1 - mark comment as spam or not. Spam deleted, and pending
+// comment marked as not spam approved. The decision sent to spam checker as a feedback.
+func (a *admin) setSpamCtrl(w http.ResponseWriter, r *http.Request) {
+	commentID := chi.URLParam(r, "id")
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	spamStatus := r.URL.Query().Get("spam") == "1"
+
+	comment, err := a.dataService.Get(locator, commentID, store.User{})
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get comment", rest.ErrCommentNotFound)
+		return
+	}
+	if a.spamService != nil {
+		if e := a.spamService.Feedback(comment, spamStatus); e != nil {
+			log.Printf("[WARN] can't send spam feedback for %s, %v", commentID, e)
+		}
+	}
+
+	if spamStatus {
+		err = a.dataService.Delete(locator, commentID, store.SoftDelete)
+	} else {
+		err = a.dataService.SetPending(locator, commentID, false)
+	}
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't set spam status", rest.ErrInternal)
+		return
+	}
+	if spamStatus {
+		a.metrics.CommentDeleted(locator.SiteID)
+		a.record(r, audit.Entry{SiteID: locator.SiteID, Action: audit.ActionSpam, Target: commentID, URL: locator.URL})
+	} else if comment.Pending {
+		a.record(r, audit.Entry{SiteID: locator.SiteID, Action: audit.ActionApprove, Target: commentID, URL: locator.URL})
+		a.countApproved(comment)
+	}
+	a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.SiteID, locator.URL, lastCommentsScope, comment.User.ID))
+
+	if spamStatus && !comment.Deleted {
+		a.notifyModeration(comment, notify.ModerationRejected, "spam")
+	}
+	if !spamStatus && comment.Pending && a.notifyService != nil { // user notifications of pending comment held till approval
+		comment.Pending = false
+		a.notifyService.Submit(notify.Request{Comment: comment, Approved: true})
+		a.notifyModeration(comment, notify.ModerationApproved, "")
+	}
+	render.JSON(w, r, R.JSON{"id": commentID, "locator": locator, "spam": spamStatus})
+}
+
+// GET /pending?site=siteID - get comments held for moderation, the most recent first
+func (a *admin) pendingCommentsCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID := r.URL.Query().Get("site")
+	comments, err := a.dataService.PendingComments(siteID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get pending comments", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, comments)
+}
+
+// GET /reports?site=siteID - get comments reported by users, the most reported first
+func (a *admin) reportedCommentsCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID := r.URL.Query().Get("site")
+	comments, err := a.dataService.ReportedComments(siteID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get reported comments", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, comments)
+}
+
+// DELETE /reports/{id}?site=siteID&url=post-url - dismiss reports of the comment, pending status kept as is
+func (a *admin) dismissReportsCtrl(w http.ResponseWriter, r *http.Request) {
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	id := chi.URLParam(r, "id")
+	if err := a.dataService.DismissReports(locator, id); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't dismiss reports", rest.ErrCommentNotFound)
+		return
+	}
+	a.record(r, audit.Entry{SiteID: locator.SiteID, Action: audit.ActionDismiss, Target: id, URL: locator.URL})
+	render.JSON(w, r, R.JSON{"id": id, "locator": locator})
+}
+
+// GET /deleted?site=siteID - get soft-deleted comments kept in trash, recently deleted first
+func (a *admin) deletedCommentsCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID := r.URL.Query().Get("site")
+	comments, err := a.dataService.DeletedComments(siteID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get deleted comments", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, comments)
+}
+
+// PUT /undelete/{id}?site=siteID&url=post-url - restore soft-deleted comment from trash
+func (a *admin) undeleteCommentCtrl(w http.ResponseWriter, r *http.Request) {
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	id := chi.URLParam(r, "id")
+	comment, err := a.dataService.Undelete(locator, id)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't undelete comment", rest.ErrCommentNotFound)
+		return
+	}
+	a.record(r, audit.Entry{SiteID: locator.SiteID, Action: audit.ActionUndelete, Target: id, URL: locator.URL})
+	a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.SiteID, locator.URL, lastCommentsScope))
+	render.JSON(w, r, comment)
+}
+
+const (
+	defaultAuditLimit = 100  // number of audit entries returned if limit not set
+	maxAuditLimit     = 1000 // max number of audit entries returned at once
+)
+
+// GET /audit?site=siteID&actor=userID&action=delete&target=id&from=RFC3339&to=RFC3339&limit=100 - get moderation
+// actions, the most recent first. All filters are optional, limited to 100 entries by default.
+func (a *admin) auditCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.audit == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("audit log disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	filter, err := auditFilter(r, defaultAuditLimit)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "bad audit filter", rest.ErrDecode)
+		return
+	}
+	entries, err := a.audit.List(r.URL.Query().Get("site"), filter)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get audit log", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, entries)
+}
+
+// GET /audit/export?site=siteID&actor=userID&action=delete&target=id&from=RFC3339&to=RFC3339 - export moderation
+// actions as json lines, the most recent first. The same filters as /audit, unlimited unless limit set.
+func (a *admin) auditExportCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.audit == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("audit log disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	filter, err := auditFilter(r, 0)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "bad audit filter", rest.ErrDecode)
+		return
+	}
+	siteID := r.URL.Query().Get("site")
+	w.Header().Set("Content-Type", "application/x-ndjson; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s-audit-%s.jsonl\"", siteID, time.Now().Format("20060102")))
+	enc := json.NewEncoder(w)
+	if err := a.audit.Export(siteID, filter, func(e audit.Entry) error { return enc.Encode(e) }); err != nil {
+		log.Printf("[WARN] can't export audit log of %s, %v", siteID, err)
+	}
+}
+
+// record adds audit entry of the action made by the current admin
+func (a *admin) record(r *http.Request, entry audit.Entry) {
+	if user, err := rest.GetUserInfo(r); err == nil {
+		entry.Actor, entry.ActorName = user.ID, user.Name
+	}
+	a.audit.Record(entry)
+}
+
+// auditFilter makes filter of audit entries from query params
+func auditFilter(r *http.Request, limit int) (filter audit.Filter, err error) {
+	q := r.URL.Query()
+	filter = audit.Filter{Actor: q.Get("actor"), Action: audit.Action(q.Get("action")), Target: q.Get("target"), Limit: limit}
+	if v := q.Get("from"); v != "" {
+		if filter.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, fmt.Errorf("bad from time %q: %w", v, err)
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if filter.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, fmt.Errorf("bad to time %q: %w", v, err)
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit <= 0 {
+			return filter, fmt.Errorf("bad limit %q", v)
+		}
+		if filter.Limit > maxAuditLimit {
+			filter.Limit = maxAuditLimit
+		}
+	}
+	return filter, nil
+}
+
+// GET /consents?site=siteID - get consents to legal terms of all users, with version and time of consent
+func (a *admin) consentsCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID := r.URL.Query().Get("site")
+	consents, err := a.dataService.Consents(siteID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get consents", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, consents)
+}
+
+// GET /moderation?site=siteID - get moderation rules of the site
+func (a *admin) getModerationCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.moderationFilter == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("moderation filter disabled"), "can't get moderation rules", rest.ErrActionRejected)
+		return
+	}
+	rules, err := a.moderationFilter.Rules(r.URL.Query().Get("site"))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get moderation rules", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, rules)
+}
+
+// PUT /moderation?site=siteID - set moderation rules of the site, applied to new comments immediately.
+// body is {"words": ["w1"], "patterns": ["regex"], "max_links": 5, "action": "pending|reject"}
+func (a *admin) setModerationCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.moderationFilter == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("moderation filter disabled"), "can't set moderation rules", rest.ErrActionRejected)
+		return
+	}
+	rules := moderation.Rules{}
+	if err := render.DecodeJSON(http.MaxBytesReader(w, r.Body, hardBodyLimit), &rules); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't bind moderation rules", rest.ErrDecode)
+		return
+	}
+	siteID := r.URL.Query().Get("site")
+	rules, err := a.moderationFilter.SetRules(siteID, rules)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set moderation rules", rest.ErrActionRejected)
+		return
+	}
+	render.JSON(w, r, rules)
+}
+
+// GET /verified/rules?site=siteID - get rules of the site granting verified flag to users
+func (a *admin) getVerifiedRulesCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.verified == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("verification rules disabled"), "can't get verification rules", rest.ErrActionRejected)
+		return
+	}
+	rules, err := a.verified.Rules(r.URL.Query().Get("site"))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get verification rules", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, rules)
+}
+
+// PUT /verified/rules?site=siteID - set rules of the site, applied on the next login or email confirmation of users.
+// body is {"domains": ["example.com"], "confirmed_email": true}
+func (a *admin) setVerifiedRulesCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.verified == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("verification rules disabled"), "can't set verification rules", rest.ErrActionRejected)
+		return
+	}
+	rules := verified.Rules{}
+	if err := render.DecodeJSON(http.MaxBytesReader(w, r.Body, hardBodyLimit), &rules); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't bind verification rules", rest.ErrDecode)
+		return
+	}
+	rules, err := a.verified.SetRules(r.URL.Query().Get("site"), rules)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set verification rules", rest.ErrActionRejected)
+		return
+	}
+	render.JSON(w, r, rules)
+}
+
+// POST /verified/evaluate?site=siteID - check existing users with confirmed emails against rules of the site,
+// returns ids of users granted verified flag
+func (a *admin) evaluateVerifiedCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.verified == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("verification rules disabled"), "can't evaluate verification rules", rest.ErrActionRejected)
+		return
+	}
+	siteID := r.URL.Query().Get("site")
+	granted, err := a.verified.Reevaluate(siteID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't evaluate verification rules", rest.ErrInternal)
+		return
+	}
+	for _, userID := range granted {
+		a.record(r, audit.Entry{SiteID: siteID, Action: audit.ActionVerify, Target: userID})
+	}
+	render.JSON(w, r, R.JSON{"site": siteID, "verified": granted, "count": len(granted)})
+}
+
+// GET /trust/rules?site=siteID - get pre-moderation rules of the site, defaults for site without own rules
+func (a *admin) getTrustRulesCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.trust == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("pre-moderation disabled"), "can't get trust rules", rest.ErrActionRejected)
+		return
+	}
+	rules, err := a.trust.Rules(r.URL.Query().Get("site"))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get trust rules", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, rules)
+}
+
+// PUT /trust/rules?site=siteID - set pre-moderation rules of the site, body is {"threshold": 3}.
+// Threshold is the number of approved comments to trust the user, 0 disables pre-moderation of the site.
+func (a *admin) setTrustRulesCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.trust == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("pre-moderation disabled"), "can't set trust rules", rest.ErrActionRejected)
+		return
+	}
+	rules := trust.Rules{}
+	if err := render.DecodeJSON(http.MaxBytesReader(w, r.Body, hardBodyLimit), &rules); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't bind trust rules", rest.ErrDecode)
+		return
+	}
+	rules, err := a.trust.SetRules(r.URL.Query().Get("site"), rules)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set trust rules", rest.ErrActionRejected)
+		return
+	}
+	render.JSON(w, r, rules)
+}
+
+// GET /trust/user/{userid}?site=siteID - get trust status of the user
+func (a *admin) getTrustCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.trust == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("pre-moderation disabled"), "can't get trust", rest.ErrActionRejected)
+		return
+	}
+	status, err := a.trust.Status(r.URL.Query().Get("site"), chi.URLParam(r, "userid"))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get trust", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, status)
+}
+
+// PUT /trust/user/{userid}?site=siteID&level=trusted - set trust level of the user, "trusted", "untrusted" or "auto".
+// Trusted users never pre-moderated, untrusted always, auto trusts the user after threshold of approved comments.
+func (a *admin) setTrustCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.trust == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("pre-moderation disabled"), "can't set trust", rest.ErrActionRejected)
+		return
+	}
+	siteID, userID := r.URL.Query().Get("site"), chi.URLParam(r, "userid")
+	level := trust.Level(r.URL.Query().Get("level"))
+	status, err := a.trust.SetLevel(siteID, userID, level)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set trust", rest.ErrActionRejected)
+		return
+	}
+	a.record(r, audit.Entry{SiteID: siteID, Action: audit.ActionTrust, Target: userID, Reason: string(level)})
+	render.JSON(w, r, status)
+}
+
+// GET /trust/queue?site=siteID - get pending comments of users not trusted yet with trust status of the authors,
+// the most recent first
+func (a *admin) trustQueueCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.trust == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("pre-moderation disabled"), "can't get trust queue", rest.ErrActionRejected)
+		return
+	}
+	siteID := r.URL.Query().Get("site")
+	comments, err := a.dataService.PendingComments(siteID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get pending comments", rest.ErrInternal)
+		return
+	}
+	type queued struct {
+		Comment store.Comment `json:"comment"`
+		Trust   trust.Status  `json:"trust"`
+	}
+	res := []queued{}
+	statuses := map[string]trust.Status{}
+	for _, c := range comments {
+		status, ok := statuses[c.User.ID]
+		if !ok {
+			if status, err = a.trust.Status(siteID, c.User.ID); err != nil {
+				rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get trust", rest.ErrInternal)
+				return
+			}
+			statuses[c.User.ID] = status
+		}
+		if !status.Trusted {
+			res = append(res, queued{Comment: c, Trust: status})
+		}
+	}
+	render.JSON(w, r, res)
+}
+
+// countApproved counts approved comment of the user, the user trusted after threshold of them
+func (a *admin) countApproved(c store.Comment) {
+	if a.trust == nil || c.User.Admin {
+		return
+	}
+	a.trust.Approved(c.Locator.SiteID, c.User.ID)
+}
+
+// notifyModeration informs author of the comment about moderation decision. Removal of pending comment
+// reported as rejection, as it was never published.
+func (a *admin) notifyModeration(c store.Comment, event notify.ModerationEvent, reason string) {
+	if a.notifyService == nil {
+		return
+	}
+	if event == notify.ModerationDeleted && c.Pending {
+		event = notify.ModerationRejected
+	}
+	a.notifyService.Submit(notify.Request{Comment: c, Moderation: event, Reason: reason})
+}
+
+// GET /roles?site=siteID - get roles of admins on the site, admins set on start listed as static owners
+func (a *admin) rolesCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.roles == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("roles disabled"), "can't get roles", rest.ErrActionRejected)
+		return
+	}
+	list, err := a.roles.List(r.URL.Query().Get("site"))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get roles", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, list)
+}
+
+// PUT /roles/{userid}?site=siteID&role=moderator - assign role (owner, moderator or viewer) to the user
+// DELETE /roles/{userid}?site=siteID - remove role of the user
+func (a *admin) setRoleCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.roles == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("roles disabled"), "can't set role", rest.ErrActionRejected)
+		return
+	}
+	userID := chi.URLParam(r, "userid")
+	siteID := r.URL.Query().Get("site")
+	role := roles.Role(r.URL.Query().Get("role"))
+	if r.Method == http.MethodDelete {
+		role = roles.None
+	} else if !role.Valid() {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("invalid role"), "can't set role", rest.ErrActionRejected)
+		return
+	}
+	if err := a.roles.Assign(siteID, userID, role); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set role", rest.ErrActionRejected)
+		return
+	}
+	render.JSON(w, r, R.JSON{"user": userID, "role": role})
+}
+
+// DELETE /img-cache?site=siteID&url=image-url - removes remote image from the cache of image proxy, all images if url not set.
+// The cache is shared by all sites.
+func (a *admin) purgeImageCacheCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.imageProxy == nil || a.imageProxy.Cache == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("image proxy cache disabled"), "image proxy cache disabled",
+			rest.ErrActionRejected)
+		return
+	}
+	imgURL := r.URL.Query().Get("url")
+	count, err := a.imageProxy.Purge(imgURL)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't purge image proxy cache", rest.ErrInternal)
+		return
+	}
+	log.Printf("[INFO] purged %d images of %q from image proxy cache", count, imgURL)
+	render.JSON(w, r, R.JSON{"url": imgURL, "purged": count})
+}
+
+// GET /settings?site=siteID - get effective settings of the site with overrides and defaults
+func (a *admin) getSettingsCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID := r.URL.Query().Get("site")
+	overrides, err := a.settings.Overrides(siteID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get settings", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, R.JSON{"settings": a.settings.Get(siteID), "overrides": overrides, "defaults": a.settings.Defaults()})
+}
+
+// PUT /settings?site=siteID - set settings of the site, applied immediately. Fields not set use defaults.
+// body is {"readonly_age": 30, "max_comment_size": 4096, "email_notifications": false}
+func (a *admin) setSettingsCtrl(w http.ResponseWriter, r *http.Request) {
+	overrides := settings.Overrides{}
+	if err := render.DecodeJSON(http.MaxBytesReader(w, r.Body, hardBodyLimit), &overrides); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't bind settings", rest.ErrDecode)
+		return
+	}
+	siteID := r.URL.Query().Get("site")
+	values, err := a.settings.Set(siteID, overrides)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set settings", rest.ErrActionRejected)
+		return
+	}
+	a.cache.Flush(cache.Flusher(siteID).Scopes(siteID))
+	render.JSON(w, r, R.JSON{"settings": values, "overrides": overrides, "defaults": a.settings.Defaults()})
+}
+
+// GET /maintenance - get status of maintenance (read-only) mode
+func (a *admin) getMaintenanceCtrl(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, a.maintenance.Status())
+}
+
+// PUT /maintenance?enabled=1&message=text - switch maintenance mode on or off. In maintenance mode all writes
+// except admin's rejected with 503, message returned with rejected requests.
+func (a *admin) setMaintenanceCtrl(w http.ResponseWriter, r *http.Request) {
+	enabled := r.URL.Query().Get("enabled") == "1" || r.URL.Query().Get("enabled") == "true"
+	render.JSON(w, r, a.maintenance.Set(enabled, r.URL.Query().Get("message")))
+}
+
+// GET /replication - get status of replication, the log of primary or progress of standby
+func (a *admin) replicationCtrl(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case a.replicationStandby != nil:
+		render.JSON(w, r, a.replicationStandby.Status())
+	case a.replicationPrimary != nil:
+		render.JSON(w, r, a.replicationPrimary.Status())
+	default:
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("replication disabled"), "not found", rest.ErrActionRejected)
+	}
+}
+
+// POST /replication/promote?force=1 - promote standby to primary. Standby stops following the primary,
+// accepts changes and leaves maintenance mode. Old primary should be stopped before and resynced as standby later.
+// Rejected while standby has local data not replicated from primary, force=1 promotes keeping it as is.
+func (a *admin) promoteStandbyCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.replicationStandby == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("not a standby node"), "not found", rest.ErrActionRejected)
+		return
+	}
+	if err := a.replicationStandby.Promote(r.URL.Query().Get("force") == "1"); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusConflict, err, "can't promote standby", rest.ErrActionRejected)
+		return
+	}
+	a.maintenance.Set(false, "")
+	render.JSON(w, r, a.replicationStandby.Status())
+}
+
+// GET /integrity?site=siteID - check storage integrity, report problems without changing anything
+// POST /integrity?site=siteID - check storage integrity and repair found problems
+func (a *admin) integrityCtrl(w http.ResponseWriter, r *http.Request) {
+	req := engine.IntegrityRequest{SiteID: r.URL.Query().Get("site"), Repair: r.Method == http.MethodPost}
+	log.Printf("[INFO] integrity check for site %s, repair=%v", req.SiteID, req.Repair)
+
+	report, err := a.dataService.Integrity(req)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't check integrity", rest.ErrInternal)
+		return
+	}
+	if report.Repaired > 0 {
+		a.cache.Flush(cache.Flusher(req.SiteID))
+	}
+	render.JSON(w, r, report)
+}
+
+// POST /archive?site=siteID&url=post-url&remove=1 - freeze post and save its comments to static json and html archive.
+// With remove=1 archived post deleted from the store.
+func (a *admin) archiveCtrl(w http.ResponseWriter, r *http.Request) {
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	remove := r.URL.Query().Get("remove") == "1" || r.URL.Query().Get("remove") == "true"
+	log.Printf("[INFO] archive %+v, remove=%v", locator, remove)
+
+	if a.archiver == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("archive disabled"), "can't archive post", rest.ErrActionRejected)
+		return
+	}
+	res, err := a.archiver.Archive(locator, remove)
+	a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.SiteID, locator.URL, lastCommentsScope))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't archive post", rest.ErrActionRejected)
+		return
+	}
+	render.JSON(w, r, res)
+}
+
+// POST /search/rebuild?site=siteID - drop full-text search index of the site and index all comments again
+func (a *admin) rebuildSearchCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID := r.URL.Query().Get("site")
+	log.Printf("[INFO] rebuild search index for %s", siteID)
+
+	count, err := a.dataService.RebuildSearchIndex(siteID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't rebuild search index", rest.ErrActionRejected)
+		return
+	}
+	render.JSON(w, r, R.JSON{"site": siteID, "indexed": count})
+}
+
+// PUT /reattribute?site=siteID&from=userID&to=userID&dry=1 - move all comments, votes and user details
+// from one user to another. With dry=1 nothing changed, response lists what would be re-attributed.
+func (a *admin) reattributeCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID, fromID, toID := r.URL.Query().Get("site"), r.URL.Query().Get("from"), r.URL.Query().Get("to")
+	dryRun := r.URL.Query().Get("dry") == "1" || r.URL.Query().Get("dry") == "true"
+	log.Printf("[INFO] re-attribute comments of %s from %s to %s, dry-run=%v", siteID, fromID, toID, dryRun)
+
+	res, err := a.dataService.Reattribute(siteID, fromID, toID, dryRun)
+	if !dryRun {
+		a.cache.Flush(cache.Flusher(siteID)) // votes changed in many posts
+	}
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't re-attribute comments", rest.ErrActionRejected)
+		return
+	}
+	render.JSON(w, r, res)
+}
+
+// GET /identity?site=siteID&user=userID - get canonical id of the user and identities linked to it
+func (a *admin) getIdentityCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.identity == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("linking of identities disabled"), "can't get identity", rest.ErrActionRejected)
+		return
+	}
+	canonical, links, err := a.identity.Links(r.URL.Query().Get("site"), r.URL.Query().Get("user"))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get identity", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, R.JSON{"user": canonical, "linked": links})
+}
+
+// PUT /identity/link?site=siteID&from=userID&to=userID - merge identity "from" to the canonical user of "to" without
+// confirmation, for users who can't log in with one of the providers anymore. Later logins of "from" mapped to "to".
+func (a *admin) linkIdentityCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.identity == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("linking of identities disabled"), "can't link identity", rest.ErrActionRejected)
+		return
+	}
+	siteID, fromID, toID := r.URL.Query().Get("site"), r.URL.Query().Get("from"), r.URL.Query().Get("to")
+	link, err := a.identity.Link(siteID, fromID, toID)
+	a.cache.Flush(cache.Flusher(siteID)) // merge can fail half-way, comments and votes of many posts changed
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't link identity", rest.ErrActionRejected)
+		return
+	}
+	a.record(r, audit.Entry{SiteID: siteID, Action: audit.ActionLink, Target: fromID, Reason: link.UserID})
+	render.JSON(w, r, link)
+}
+
+// DELETE /identity/link?site=siteID&user=userID - remove link of the identity, later logins made with own id.
+// Merged comments stay with the canonical user.
+func (a *admin) unlinkIdentityCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.identity == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("linking of identities disabled"), "can't unlink identity", rest.ErrActionRejected)
+		return
+	}
+	siteID, userID := r.URL.Query().Get("site"), r.URL.Query().Get("user")
+	if err := a.identity.Unlink(siteID, userID); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't unlink identity", rest.ErrActionRejected)
+		return
+	}
+	a.record(r, audit.Entry{SiteID: siteID, Action: audit.ActionUnlink, Target: userID})
+	render.JSON(w, r, R.JSON{"user": userID, "linked": false})
+}
+
+// GET /tokens?site=siteID - list api tokens of the site, without token values
+func (a *admin) apiTokensCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.apiTokens == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("api tokens disabled"), "can't get api tokens", rest.ErrActionRejected)
+		return
+	}
+	list, err := a.apiTokens.List(r.URL.Query().Get("site"))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get api tokens", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, list)
+}
+
+// POST /tokens?site=siteID - create api token, body is {"name": "ci", "scope": "read|moderate|full"}.
+// Responds with the token and its value, the value can't be recovered later. Tokens can't create other tokens.
+func (a *admin) createAPITokenCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.apiTokens == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("api tokens disabled"), "can't create api token", rest.ErrActionRejected)
+		return
+	}
+	user := rest.MustGetUserInfo(r)
+	if strings.HasPrefix(user.ID, apitokens.UserPrefix) {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, errors.New("api token user"), "can't create api token with api token",
+			rest.ErrActionRejected)
+		return
+	}
+	req := struct {
+		Name  string          `json:"name"`
+		Scope apitokens.Scope `json:"scope"`
+	}{}
+	if err := render.DecodeJSON(http.MaxBytesReader(w, r.Body, hardBodyLimit), &req); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't bind api token request", rest.ErrDecode)
+		return
+	}
+	siteID := r.URL.Query().Get("site")
+	t, value, err := a.apiTokens.Create(siteID, req.Name, req.Scope, user.ID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't create api token", rest.ErrActionRejected)
+		return
+	}
+	a.record(r, audit.Entry{SiteID: siteID, Action: audit.ActionCreateToken, Target: t.ID, Reason: string(t.Scope)})
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, R.JSON{"token": t, "value": value})
+}
+
+// DELETE /tokens/{id}?site=siteID - revoke api token, later requests with it rejected
+func (a *admin) revokeAPITokenCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.apiTokens == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("api tokens disabled"), "can't revoke api token", rest.ErrActionRejected)
+		return
+	}
+	if strings.HasPrefix(rest.MustGetUserInfo(r).ID, apitokens.UserPrefix) {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, errors.New("api token user"), "can't revoke api token with api token",
+			rest.ErrActionRejected)
+		return
+	}
+	siteID, id := r.URL.Query().Get("site"), chi.URLParam(r, "id")
+	if err := a.apiTokens.Revoke(siteID, id); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't revoke api token", rest.ErrActionRejected)
+		return
+	}
+	a.record(r, audit.Entry{SiteID: siteID, Action: audit.ActionRevokeToken, Target: id})
+	render.JSON(w, r, R.JSON{"id": id, "revoked": true})
+}
+
+// POST /remap/urls?site=siteID&dry=1 - move comments of posts to new urls, body has the same rules as /remap.
+// Unlike /remap runs synchronously in a single transaction and responds with moved posts and number of comments.
+// With dry=1 nothing changed, response lists posts to be moved.
+func (a *admin) remapURLsCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID := r.URL.Query().Get("site")
+	dryRun := r.URL.Query().Get("dry") == "1" || r.URL.Query().Get("dry") == "true"
+
+	mapper, err := migrator.NewURLMapper(r.Body)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "remap failed, bad given rules", rest.ErrDecode)
+		return
+	}
+	log.Printf("[INFO] remap urls of %s, dry-run=%v", siteID, dryRun)
+
+	res, err := a.dataService.RemapURLs(siteID, mapper.URL, dryRun)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't remap urls", rest.ErrActionRejected)
+		return
+	}
+	if !dryRun && len(res.Posts) > 0 {
+		a.cache.Flush(cache.Flusher(siteID).Scopes(siteID))
+	}
+	render.JSON(w, r, res)
+}
+
+// GET /sentiment?site=siteID&url=post-url&days=30 - sentiment of comments aggregated per post and per day.
+// url is optional, all posts of the site used if not set. days defines period, 30 by default.
+func (a *admin) sentimentCtrl(w http.ResponseWriter, r *http.Request) {
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	days := 30
+	if v := r.URL.Query().Get("days"); v != "" {
+		d, err := strconv.Atoi(v)
+		if err != nil || d <= 0 {
+			rest.SendErrorJSON(w, r, http.StatusBadRequest, fmt.Errorf("invalid days %q", v), "can't get sentiment", rest.ErrDecode)
+			return
+		}
+		days = d
+	}
+	since := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -days+1)
+
+	key := cache.NewKey(locator.SiteID).ID(URLKey(r)).Scopes(locator.SiteID, postsScope)
+	data, err := a.cache.Get(key, func() ([]byte, error) {
+		trends, e := a.dataService.SentimentTrends(locator, since)
+		if e != nil {
+			return nil, e
+		}
+		return encodeJSONWithHTML(trends)
+	})
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get sentiment", rest.ErrActionRejected)
+		return
+	}
+
+	if err = R.RenderJSONFromBytes(w, r, data); err != nil {
+		log.Printf("[WARN] can't render sentiment for %+v", locator)
+	}
+}
+
+// GET /bounces?site=siteID - list of bounced emails with stats
+func (a *admin) bouncesCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.bounceStore == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("bounce processing disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	siteID := r.URL.Query().Get("site")
+	bounces, err := a.bounceStore.List(siteID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get bounces", rest.ErrInternal)
+		return
+	}
+	stats := map[notify.BounceKind]int{notify.BounceHard: 0, notify.BounceComplaint: 0}
+	for _, b := range bounces {
+		stats[b.Kind]++
+	}
+	render.JSON(w, r, R.JSON{"total": len(bounces), "hard": stats[notify.BounceHard],
+		"complaint": stats[notify.BounceComplaint], "bounces": bounces})
+}
+
+// POST /renotify?site=siteID&from=RFC3339&to=RFC3339&rate=60&force=1 - sends notifications about comments created
+// within the period again, i.e. after email server outage. Notifications sent in background with rate per minute,
+// slowed down while notifications queue is full. Recipients already notified per delivery log skipped, as well as
+// comments sent by previous jobs unless force set. "to" defaults to now and "rate" to 60. Progress by GET /renotify.
+func (a *admin) renotifyCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID := r.URL.Query().Get("site")
+	if a.notifyService == nil || !a.notifyService.CanResend() {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("no notification destinations with delivery log"),
+			"re-notification not available", rest.ErrActionRejected)
+		return
+	}
+
+	from, err := time.Parse(time.RFC3339, r.URL.Query().Get("from"))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't parse from time", rest.ErrDecode)
+		return
+	}
+	to := time.Now()
+	if v := r.URL.Query().Get("to"); v != "" {
+		if to, err = time.Parse(time.RFC3339, v); err != nil {
+			rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't parse to time", rest.ErrDecode)
+			return
+		}
+	}
+	if !from.Before(to) {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, fmt.Errorf("from %s is not before to %s", from, to),
+			"invalid period", rest.ErrActionRejected)
+		return
+	}
+	rate := 60
+	if v := r.URL.Query().Get("rate"); v != "" {
+		if rate, err = strconv.Atoi(v); err != nil || rate <= 0 || rate > 6000 {
+			rest.SendErrorJSON(w, r, http.StatusBadRequest, fmt.Errorf("invalid rate %q", v),
+				"rate should be 1-6000 per minute", rest.ErrDecode)
+			return
+		}
+	}
+
+	comments, err := a.dataService.Created(siteID, from, to)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get comments", rest.ErrInternal)
+		return
+	}
+	force := r.URL.Query().Get("force") == "1" || r.URL.Query().Get("force") == "true"
+	status, err := a.renotifier.start(a.notifyService, siteID, comments, rate, force)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusConflict, err, "re-notification in progress", rest.ErrActionRejected)
+		return
+	}
+	log.Printf("[INFO] re-notification for %s started, %d comments from %s to %s, %d skipped, rate %d/min", siteID,
+		len(comments), from.Format(time.RFC3339), to.Format(time.RFC3339), status.Skipped, rate)
+	render.JSON(w, r, R.JSON{"site": siteID, "comments": len(comments), "skipped": status.Skipped, "rate": rate})
+}
+
+// GET /renotify?site=siteID - get progress of the latest re-notification of the site, with failed comments
+func (a *admin) renotifyStatusCtrl(w http.ResponseWriter, r *http.Request) {
+	status, ok := a.renotifier.status(r.URL.Query().Get("site"))
+	if !ok {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("no re-notifications"), "not found", rest.ErrActionRejected)
+		return
+	}
+	render.JSON(w, r, status)
+}
+
+// DELETE /renotify?site=siteID - stop running re-notification of the site, comments sent already not sent again
+func (a *admin) stopRenotifyCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID := r.URL.Query().Get("site")
+	if !a.renotifier.stop(siteID) {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("no running re-notification"), "not found",
+			rest.ErrActionRejected)
+		return
+	}
+	log.Printf("[INFO] re-notification for %s stopped", siteID)
+	render.JSON(w, r, R.JSON{"site": siteID, "stopped": true})
+}
+
+// DELETE /bounce?site=siteID&email=address - remove bounce record, allows to send to the address again
+func (a *admin) deleteBounceCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.bounceStore == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("bounce processing disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	siteID, email := r.URL.Query().Get("site"), r.URL.Query().Get("email")
+	if err := a.bounceStore.Delete(siteID, email); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't delete bounce", rest.ErrActionRejected)
+		return
+	}
+	render.JSON(w, r, R.JSON{"email": email, "site_id": siteID, "deleted": true})
+}
+
+// GET /notify/admins?site=siteID - notification preferences of each admin and defaults inherited by them
+func (a *admin) adminNotifyPrefsCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.notifyService == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("notifications disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	prefs, err := a.notifyService.AdminPrefs(r.URL.Query().Get("site"))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get admin notification preferences", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, R.JSON{"defaults": a.notifyService.AdminDefaults(), "admins": prefs})
+}
+
+// GET /notify/status?site=siteID&email=address&comment=id&limit=100 - recent send statuses of notifications,
+// newest first. Optional email and comment select notifications sent to the address or about the comment.
+func (a *admin) notifyStatusCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.notifyService == nil || !a.notifyService.TracksStatus() {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("notification status disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	filter := notify.StatusFilter{Email: r.URL.Query().Get("email"), CommentID: r.URL.Query().Get("comment")}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "bad limit", rest.ErrDecode)
+			return
+		}
+		filter.Limit = limit
+	}
+	statuses, err := a.notifyService.Statuses(r.URL.Query().Get("site"), filter)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get notification statuses", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, statuses)
+}
+
+// PUT /notify/admin?site=siteID&email=address - set notification preferences of the admin, empty fields inherited
+// from defaults, i.e. {"events":"pending","destinations":["telegram"],"telegram_chat":"12345"}
+func (a *admin) setAdminNotifyPrefsCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.notifyService == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("notifications disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	prefs := notify.AdminPrefs{}
+	if err := render.DecodeJSON(http.MaxBytesReader(w, r.Body, hardBodyLimit), &prefs); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't bind admin notification preferences", rest.ErrDecode)
+		return
+	}
+	siteID, email := r.URL.Query().Get("site"), r.URL.Query().Get("email")
+	if err := a.notifyService.SetAdminPrefs(siteID, email, prefs); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set admin notification preferences", rest.ErrActionRejected)
+		return
+	}
+	render.JSON(w, r, R.JSON{"email": email, "site_id": siteID, "prefs": prefs})
+}
+
+// GET /email/preview?kind=reply - html of email message of the given kind rendered with sample data, subject in X-Email-Subject
+// header. Kinds are reply, follow, admin, moderation, verification and deletion.
+func (a *admin) emailPreviewCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.notifyService == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("notifications disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	kind := r.URL.Query().Get("kind")
+	if kind == "" {
+		kind = "reply"
+	}
+	subject, body, err := a.notifyService.PreviewEmail(kind)
+	if err != nil {
+		a.sendEmailPreviewError(w, r, err, "can't render email preview")
+		return
+	}
+	w.Header().Set("X-Email-Subject", subject)
+	render.HTML(w, r, body)
+}
+
+// POST /email/test?address=user@example.com&kind=reply - send email of the given kind with sample data to the address
+// using current email settings, sending error returned as is to validate settings
+func (a *admin) emailTestCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.notifyService == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("notifications disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	address, kind := r.URL.Query().Get("address"), r.URL.Query().Get("kind")
+	if address == "" {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("missing address"), "address is required", rest.ErrActionRejected)
+		return
+	}
+	if kind == "" {
+		kind = "reply"
+	}
+	if err := a.notifyService.SendTestEmail(r.Context(), address, kind); err != nil {
+		a.sendEmailPreviewError(w, r, err, "can't send test email")
+		return
+	}
+	log.Printf("[INFO] test email %s sent to %s", kind, address)
+	render.JSON(w, r, R.JSON{"address": address, "kind": kind, "sent": true})
+}
+
+// sendEmailPreviewError responds with not found if email notifications disabled and with bad request for other errors
+func (a *admin) sendEmailPreviewError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	if errors.Is(err, notify.ErrNoEmail) {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, err, "not found", rest.ErrActionRejected)
+		return
+	}
+	rest.SendErrorJSON(w, r, http.StatusBadRequest, err, msg, rest.ErrActionRejected)
+}
+
+// GET /notify/config - current settings of smtp server of email notifications and telegram bot, secrets not shown
+func (a *admin) notifyConfigCtrl(w http.ResponseWriter, r *http.Request) {
+	email, tg := a.notifyService.Email(), a.notifyService.Telegram()
+	if email == nil && tg == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("email and telegram notifications disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	res := R.JSON{}
+	if email != nil {
+		res["smtp"] = smtpConfig(email.SMTP())
+	}
+	if tg != nil {
+		res["telegram"] = R.JSON{"channel": tg.AdminChannelID, "moderation": tg.Moderation}
+	}
+	render.JSON(w, r, res)
+}
+
+// PUT /notify/config/smtp?dry=1 - switch email notifications to smtp server, i.e. {"host":"smtp.example.com","port":587,
+// "tls":false,"username":"user","password":"secret","timeout":"10s"}. Connection and auth checked before the switch,
+// the current password kept if not set. With dry=1 only checked. Fallback servers kept as is.
+func (a *admin) setNotifySMTPCtrl(w http.ResponseWriter, r *http.Request) {
+	email := a.notifyService.Email()
+	if email == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("email notifications disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	req := struct {
+		Host     string `json:"host"`
+		Port     int    `json:"port"`
+		TLS      bool   `json:"tls"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Helo     string `json:"helo"`
+		TimeOut  string `json:"timeout"`
+	}{}
+	if err := render.DecodeJSON(http.MaxBytesReader(w, r.Body, hardBodyLimit), &req); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't bind smtp settings", rest.ErrDecode)
+		return
+	}
+	if req.Host == "" || req.Port <= 0 {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("missing host or port"), "host and port are required", rest.ErrActionRejected)
+		return
+	}
+	params := email.SMTP()
+	params.Host, params.Port, params.TLS, params.Username, params.Helo = req.Host, req.Port, req.TLS, req.Username, req.Helo
+	params.Socket, params.LMTP = "", false
+	if req.Password != "" {
+		params.Password = req.Password
+	}
+	if req.TimeOut != "" {
+		timeout, err := time.ParseDuration(req.TimeOut)
+		if err != nil {
+			rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "bad timeout", rest.ErrDecode)
+			return
+		}
+		params.TimeOut = timeout
+	}
+	if err := email.TestSMTP(params); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't connect to smtp server", rest.ErrActionRejected)
+		return
+	}
+	dryRun := r.URL.Query().Get("dry") == "1" || r.URL.Query().Get("dry") == "true"
+	if !dryRun {
+		email.SetSMTP(params)
+	}
+	render.JSON(w, r, R.JSON{"smtp": smtpConfig(email.SMTP()), "checked": true, "switched": !dryRun})
+}
+
+// PUT /notify/config/telegram?dry=1 - switch telegram notifications to bot with the token, i.e. {"token":"123:abc"}.
+// Token checked with bot api before the switch, webhook of moderation buttons registered for the new bot.
+// With dry=1 only checked.
+func (a *admin) setNotifyTelegramCtrl(w http.ResponseWriter, r *http.Request) {
+	tg := a.notifyService.Telegram()
+	if tg == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("telegram notifications disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	req := struct {
+		Token string `json:"token"`
+	}{}
+	if err := render.DecodeJSON(http.MaxBytesReader(w, r.Body, hardBodyLimit), &req); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't bind telegram settings", rest.ErrDecode)
+		return
+	}
+	dryRun := r.URL.Query().Get("dry") == "1" || r.URL.Query().Get("dry") == "true"
+	check := tg.SetToken
+	if dryRun {
+		check = tg.TestToken
+	}
+	if err := check(r.Context(), req.Token); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't use telegram token", rest.ErrActionRejected)
+		return
+	}
+	render.JSON(w, r, R.JSON{"telegram": R.JSON{"channel": tg.AdminChannelID, "moderation": tg.Moderation},
+		"checked": true, "switched": !dryRun})
+}
+
+// smtpConfig makes response with smtp settings, password not shown
+func smtpConfig(params notify.SMTPParams) R.JSON {
+	return R.JSON{"host": params.Host, "port": params.Port, "tls": params.TLS, "username": params.Username,
+		"password_set": params.Password != "", "helo": params.Helo, "timeout": params.TimeOut.String(),
+		"socket": params.Socket, "lmtp": params.LMTP, "fallback": len(params.Fallback)}
+}
+
+// GET /votes/fraud?site=siteID - suspicious voting patterns found by the last analysis of recent votes
+func (a *admin) voteFraudCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.voteFraud == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("vote fraud detection disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	render.JSON(w, r, a.voteFraud.Findings(r.URL.Query().Get("site")))
+}
+
+// GET /fingerprint/{id}?site=siteID - get hashes of ip and user-agent of the comment author,
+// with other comments made with the same ip or user-agent
+func (a *admin) fingerprintCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.fingerprints == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("fingerprints disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	rec, err := a.fingerprints.Get(r.URL.Query().Get("site"), chi.URLParam(r, "id"))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, err, "can't get fingerprint", rest.ErrCommentNotFound)
+		return
+	}
+	related, err := a.fingerprints.Related(rec)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get related comments", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, R.JSON{"fingerprint": rec, "related": related})
+}
+
+// GET /fingerprint?site=siteID&hash=hash - get comments made with ip or user-agent hash
+func (a *admin) findFingerprintCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.fingerprints == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("fingerprints disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	hash := r.URL.Query().Get("hash")
+	if hash == "" {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("empty hash"), "hash required", rest.ErrDecode)
+		return
+	}
+	records, err := a.fingerprints.Find(r.URL.Query().Get("site"), hash)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't find fingerprints", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, records)
+}
+
+// GET /fingerprint/block?site=siteID - list active blocks of ip and user-agent hashes
+func (a *admin) fingerprintBlocksCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.fingerprints == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("fingerprints disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	blocks, err := a.fingerprints.Blocks(r.URL.Query().Get("site"))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get blocks", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, blocks)
+}
+
+// PUT /fingerprint/block/{hash}?site=siteID&ttl=7d&reason=text - block new comments with ip or user-agent hash
+// DELETE /fingerprint/block/{hash}?site=siteID - unblock the hash
+func (a *admin) setFingerprintBlockCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.fingerprints == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("fingerprints disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	siteID, hash := r.URL.Query().Get("site"), chi.URLParam(r, "hash")
+	entry := audit.Entry{SiteID: siteID, Action: audit.ActionUnblockHash, Target: hash}
+	if r.Method == http.MethodDelete {
+		if err := a.fingerprints.RemoveBlock(siteID, hash); err != nil {
+			rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't unblock hash", rest.ErrInternal)
+			return
+		}
+		a.record(r, entry)
+		render.JSON(w, r, R.JSON{"hash": hash, "site_id": siteID, "block": false})
+		return
+	}
+
+	ttl := time.Duration(0) // unlimited duration by default
+	if ttlParam := r.URL.Query().Get("ttl"); ttlParam != "" {
+		if d, err := time.ParseDuration(ttlParam); err == nil {
+			ttl = d
+		}
+	}
+	entry.Action, entry.Reason = audit.ActionBlockHash, r.URL.Query().Get("reason")
+	if err := a.fingerprints.SetBlock(siteID, hash, entry.Reason, ttl); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't block hash", rest.ErrInternal)
+		return
+	}
+	if ttl > 0 {
+		entry.Reason = strings.TrimSpace(fmt.Sprintf("%s (for %s)", entry.Reason, ttl))
+	}
+	a.record(r, entry)
+	render.JSON(w, r, R.JSON{"hash": hash, "site_id": siteID, "block": true})
+}
+
+// GET /jwt/keys - list current and accepted rotated keys signing JWT, without secrets
+func (a *admin) jwtKeysCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.jwtKeys == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("jwt keys rotation disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	render.JSON(w, r, a.jwtKeys.Keys())
+}
+
+// POST /jwt/rotate?site=siteID&grace=24h - make new key signing JWT, previous keys accepted for grace period.
+// Keys shared by all sites.
+func (a *admin) rotateJWTCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.jwtKeys == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("jwt keys rotation disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	grace := a.jwtKeys.Grace
+	if graceParam := r.URL.Query().Get("grace"); graceParam != "" {
+		d, err := time.ParseDuration(graceParam)
+		if err != nil || d < 0 {
+			rest.SendErrorJSON(w, r, http.StatusBadRequest, fmt.Errorf("invalid grace %q", graceParam), "bad grace period", rest.ErrDecode)
+			return
+		}
+		grace = d
+	}
+	key, err := a.jwtKeys.Rotate(grace)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't rotate jwt key", rest.ErrInternal)
+		return
+	}
+	a.record(r, audit.Entry{SiteID: r.URL.Query().Get("site"), Action: audit.ActionRotateJWT, Target: key.ID,
+		Reason: fmt.Sprintf("grace %s", grace)})
+	render.JSON(w, r, R.JSON{"id": key.ID, "grace": grace.String()})
+}
+
+// GET /warmup?site=siteID - status of cache warmup of the site
+func (a *admin) warmupCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.warmup == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("warmup disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	status, ok := a.warmup.Status(r.URL.Query().Get("site"))
+	if !ok {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("no sitemap"), "site has no sitemap", rest.ErrActionRejected)
+		return
+	}
+	render.JSON(w, r, status)
+}
+
+// GET /slow?site=siteID&kind=store|response&limit=20 - get the slowest store operations or the largest responses
+// of the site among the latest kept in memory, store operations by default
+func (a *admin) slowOpsCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.slowLog == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("slow log disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	kind := slowlog.Kind(r.URL.Query().Get("kind"))
+	if kind == "" {
+		kind = slowlog.KindStore
+	}
+	if kind != slowlog.KindStore && kind != slowlog.KindResponse {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, fmt.Errorf("bad kind %q", kind), "bad kind", rest.ErrDecode)
+		return
+	}
+	limit := 20
+	if v := r.URL.Query().Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l <= 0 {
+			rest.SendErrorJSON(w, r, http.StatusBadRequest, fmt.Errorf("bad limit %q", v), "bad limit", rest.ErrDecode)
+			return
+		}
+		limit = l
+	}
+	render.JSON(w, r, a.slowLog.Top(kind, r.URL.Query().Get("site"), limit))
+}
+
+// GET /retention?site=siteID - report comments expired by retention policy of the site, nothing changed
+func (a *admin) retentionCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.retention == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("retention disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	report, err := a.retention.Report(r.URL.Query().Get("site"))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't report expired comments", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, report)
+}
+
+// POST /warmup?site=siteID - start cache warmup of the site, rejected if warmup in progress
+func (a *admin) startWarmupCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.warmup == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("warmup disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	siteID := r.URL.Query().Get("site")
+	if err := a.warmup.Trigger(siteID); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusConflict, err, "can't start warmup", rest.ErrActionRejected)
+		return
+	}
+	status, _ := a.warmup.Status(siteID)
+	render.Status(r, http.StatusAccepted)
+	render.JSON(w, r, status)
+}
+
+// DELETE /sessions/{userid}?site=siteID - revoke all sessions of the user, the user logged out on next request
+func (a *admin) revokeSessionsCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.sessions == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("sessions disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	siteID, userID := r.URL.Query().Get("site"), chi.URLParam(r, "userid")
+	count, err := a.sessions.RevokeUser(siteID, userID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't revoke sessions", rest.ErrInternal)
+		return
+	}
+	a.record(r, audit.Entry{SiteID: siteID, Action: audit.ActionLogout, Target: userID,
+		Reason: fmt.Sprintf("%d sessions", count)})
+	render.JSON(w, r, R.JSON{"user_id": userID, "revoked": count})
+}
+
+// POST /votes/fraud/{id}?site=siteID - void all votes of the finding, votes removed and scores reverted
+// DELETE /votes/fraud/{id}?site=siteID - dismiss the finding, votes kept as is
+// Votes of resolved finding not flagged again.
+func (a *admin) resolveVoteFraudCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.voteFraud == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("vote fraud detection disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	siteID, id := r.URL.Query().Get("site"), chi.URLParam(r, "id")
+	finding, ok := a.voteFraud.Finding(siteID, id)
+	if !ok {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, fmt.Errorf("no finding %s", id), "not found", rest.ErrActionRejected)
+		return
+	}
+
+	voided := 0
+	if r.Method == http.MethodPost {
+		log.Printf("[INFO] void %d votes of %s finding %s for %s", len(finding.Votes), finding.Kind, id, siteID)
+		for _, v := range finding.Votes {
+			locator := store.Locator{SiteID: siteID, URL: v.URL}
+			comment, changed, err := a.dataService.VoidVote(locator, v.CommentID, v.UserID)
+			if err != nil {
+				log.Printf("[WARN] can't void vote of %s for %s, %v", v.UserID, v.CommentID, err)
+				continue
+			}
+			if changed {
+				voided++
+				a.cache.Flush(cache.Flusher(siteID).Scopes(locator.URL, comment.User.ID))
+			}
+		}
+	}
+
+	if err := a.voteFraud.Resolve(siteID, id); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't resolve finding", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, R.JSON{"id": id, "voided": voided})
+}
+
+// GET /sites - list static and provisioned sites, basic auth admin only
+func (a *admin) sitesCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.sites == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("provisioning disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	render.JSON(w, r, a.sites.List())
+}
+
+// POST /sites - provision site, body is {"id":"blog", "admins":["github_123"], "admin_email":"me@example.com"}.
+// Secret generated if not set, returned in response only.
+func (a *admin) createSiteCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.sites == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("provisioning disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	site := sites.Site{}
+	if err := render.DecodeJSON(http.MaxBytesReader(w, r.Body, hardBodyLimit), &site); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't bind site", rest.ErrDecode)
+		return
+	}
+	res, err := a.sites.Create(site)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't create site", rest.ErrActionRejected)
+		return
+	}
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, res)
+}
+
+// PUT /sites/{id} - update provisioned site, body is {"admins":[...], "admin_email":"...", "disabled":true},
+// missing fields kept as is
+func (a *admin) updateSiteCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.sites == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("provisioning disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	upd := sites.Update{}
+	if err := render.DecodeJSON(http.MaxBytesReader(w, r.Body, hardBodyLimit), &upd); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't bind site", rest.ErrDecode)
+		return
+	}
+	siteID := chi.URLParam(r, "id")
+	res, err := a.sites.Update(siteID, upd)
+	if err != nil {
+		a.sendSiteError(w, r, err, "can't update site")
+		return
+	}
+	a.cache.Flush(cache.Flusher(siteID).Scopes(siteID))
+	res.Secret = ""
+	render.JSON(w, r, res)
+}
+
+// POST /sites/{id}/secret - replace secret of provisioned site, returns site with the new secret
+func (a *admin) rotateSiteSecretCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.sites == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("provisioning disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	res, err := a.sites.RotateSecret(chi.URLParam(r, "id"))
+	if err != nil {
+		a.sendSiteError(w, r, err, "can't rotate secret")
+		return
+	}
+	render.JSON(w, r, res)
+}
+
+// DELETE /sites/{id} - delete provisioned site with all its comments
+func (a *admin) deleteSiteCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.sites == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("provisioning disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	siteID := chi.URLParam(r, "id")
+	if err := a.sites.Delete(siteID); err != nil {
+		a.sendSiteError(w, r, err, "can't delete site")
+		return
+	}
+	a.cache.Flush(cache.Flusher(siteID).Scopes(siteID))
+	render.JSON(w, r, R.JSON{"id": siteID, "deleted": true})
+}
+
+func (a *admin) sendSiteError(w http.ResponseWriter, r *http.Request, err error, details string) {
+	switch err {
+	case sites.ErrNotFound:
+		rest.SendErrorJSON(w, r, http.StatusNotFound, err, details, rest.ErrSiteNotFound)
+	case sites.ErrStatic:
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, details, rest.ErrActionRejected)
+	default:
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, details, rest.ErrInternal)
+	}
+}
+
+// GET /storage/backup?site=siteID - download copy of the site's storage as is, i.e. bolt file.
+// Supported by bolt store only, basic auth admin only.
+func (a *admin) storageBackupCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID := r.URL.Query().Get("site")
+	aw := &attachmentWriter{ResponseWriter: w, fileName: fmt.Sprintf("%s-%s.db", siteID, time.Now().Format("20060102"))}
+	if err := a.dataService.BackupSite(siteID, aw); err != nil {
+		if !aw.started {
+			rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't backup site storage", rest.ErrActionRejected)
+			return
+		}
+		log.Printf("[WARN] backup of site %s storage interrupted, %v", siteID, err)
+	}
+}
+
+// POST /storage/restore?site=siteID - replace storage of the site with copy made by backup, body is the copy.
+// Current storage kept if the copy is broken.
+func (a *admin) storageRestoreCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID := r.URL.Query().Get("site")
+	log.Printf("[INFO] audit: restore storage of site %s", siteID)
+	if err := a.dataService.RestoreSite(siteID, r.Body); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't restore site storage", rest.ErrActionRejected)
+		return
+	}
+	a.cache.Flush(cache.Flusher(siteID))
+	render.JSON(w, r, R.JSON{"site": siteID, "restored": true})
+}
+
+// POST /storage/detach?site=siteID - stop serving the site keeping its storage, i.e. to move it to another instance.
+// Returns location of the storage, bolt file or postgres schema. The site served again after restart.
+func (a *admin) storageDetachCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID := r.URL.Query().Get("site")
+	location, err := a.dataService.DetachSite(siteID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't detach site", rest.ErrActionRejected)
+		return
+	}
+	log.Printf("[INFO] audit: site %s detached, storage %s", siteID, location)
+	a.cache.Flush(cache.Flusher(siteID))
+	render.JSON(w, r, R.JSON{"site": siteID, "location": location})
+}
+
+// attachmentWriter sets headers of attachment on the first write, so errors before any data can be sent as json
+type attachmentWriter struct {
+	http.ResponseWriter
+	fileName string
+	started  bool
+}
+
+func (aw *attachmentWriter) Write(p []byte) (int, error) {
+	if !aw.started {
+		aw.started = true
+		aw.Header().Set("Content-Type", "application/octet-stream")
+		aw.Header().Set("Content-Disposition", "attachment;filename="+aw.fileName)
+	}
+	return aw.ResponseWriter.Write(p)
+}
@@ -3,11 +3,17 @@ package api
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/textproto"
 	"os"
 	"strings"
 	"testing"
@@ -19,9 +25,35 @@ import (
 	R "github.com/go-pkgz/rest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
-
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark42/backend/app/audit"
+	"github.com/umputun/remark42/backend/app/fingerprint"
+	"github.com/umputun/remark42/backend/app/migrator"
+	"github.com/umputun/remark42/backend/app/moderation"
+	"github.com/umputun/remark42/backend/app/notify"
+	"github.com/umputun/remark42/backend/app/rest"
+	"github.com/umputun/remark42/backend/app/rest/apitokens"
+	"github.com/umputun/remark42/backend/app/rest/jwtkeys"
+	"github.com/umputun/remark42/backend/app/rest/proxy"
+	"github.com/umputun/remark42/backend/app/rest/saml"
+	"github.com/umputun/remark42/backend/app/retention"
+	"github.com/umputun/remark42/backend/app/roles"
+	"github.com/umputun/remark42/backend/app/schedule"
+	"github.com/umputun/remark42/backend/app/settings"
+	"github.com/umputun/remark42/backend/app/sites"
+	"github.com/umputun/remark42/backend/app/slowlog"
+	"github.com/umputun/remark42/backend/app/spam"
 	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+	"github.com/umputun/remark42/backend/app/store/replication"
+	"github.com/umputun/remark42/backend/app/store/search"
 	"github.com/umputun/remark42/backend/app/store/service"
+	"github.com/umputun/remark42/backend/app/totp"
+	"github.com/umputun/remark42/backend/app/trust"
+	"github.com/umputun/remark42/backend/app/verified"
+	"github.com/umputun/remark42/backend/app/votefraud"
+	"github.com/umputun/remark42/backend/app/warmup"
 )
 
 func TestAdmin_Delete(t *testing.T) {
@@ -107,6 +139,198 @@ func TestAdmin_Delete(t *testing.T) {
 		{URL: "https://radio-t.com/blah2", Count: 0}}, j)
 }
 
+func TestAdmin_DeleteWithNotification(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	mockDestination := &notify.MockDest{}
+	srv.adminRest.notifyService = notify.NewService(srv.DataService, 1, mockDestination)
+	defer srv.adminRest.notifyService.Close()
+
+	c1 := store.Comment{Text: "test test #1", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}}
+	id1 := addComment(t, c1, ts)
+	id2 := addComment(t, c1, ts)
+	time.Sleep(50 * time.Millisecond)
+	_, err := srv.DataService.SetUserEmail("remark42", "dev", "dev@example.com")
+	require.NoError(t, err)
+	initial := len(mockDestination.Get())
+
+	req, err := http.NewRequest(http.MethodDelete,
+		fmt.Sprintf("%s/api/v1/admin/comment/%s?site=remark42&url=https://radio-t.com/blah&reason=off-topic", ts.URL, id1), nil)
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	time.Sleep(50 * time.Millisecond)
+
+	notifications := mockDestination.Get()
+	require.Equal(t, initial+1, len(notifications))
+	n := notifications[initial]
+	assert.Equal(t, notify.ModerationDeleted, n.Moderation)
+	assert.Equal(t, "off-topic", n.Reason)
+	assert.Equal(t, id1, n.Comment.ID)
+	assert.Equal(t, "test test #1", n.Comment.Orig, "comment content kept")
+	assert.Equal(t, []string{"dev@example.com"}, n.Emails)
+
+	// delete again, no notification for already deleted comment
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// no email, no notification
+	require.NoError(t, srv.DataService.DeleteUserDetail("remark42", "dev", engine.UserEmail))
+	req, err = http.NewRequest(http.MethodDelete,
+		fmt.Sprintf("%s/api/v1/admin/comment/%s?site=remark42&url=https://radio-t.com/blah", ts.URL, id2), nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, initial+1, len(mockDestination.Get()))
+}
+
+func TestAdmin_ModerationNotification(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	mockDestination := &notify.MockDest{}
+	srv.adminRest.notifyService = notify.NewService(srv.DataService, 10, mockDestination)
+	defer srv.adminRest.notifyService.Close()
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}
+	ids := make([]string, 3)
+	for i := range ids {
+		ids[i] = addComment(t, store.Comment{Text: fmt.Sprintf("pending #%d", i), Locator: locator}, ts)
+		require.NoError(t, srv.DataService.SetPending(locator, ids[i], true))
+	}
+	_, err := srv.DataService.SetUserEmail("remark42", "dev", "dev@example.com")
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+	initial := len(mockDestination.Get())
+
+	send := func(method, path string) {
+		req, e := http.NewRequest(method, ts.URL+path, nil)
+		require.NoError(t, e)
+		resp, e := sendReq(t, req, adminUmputunToken)
+		require.NoError(t, e)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	send(http.MethodPut, "/api/v1/admin/spam/"+ids[0]+"?site=remark42&url=https://radio-t.com/blah&spam=0")
+	send(http.MethodDelete, "/api/v1/admin/comment/"+ids[1]+"?site=remark42&url=https://radio-t.com/blah&reason=off-topic")
+	send(http.MethodPut, "/api/v1/admin/spam/"+ids[2]+"?site=remark42&url=https://radio-t.com/blah&spam=1")
+	time.Sleep(50 * time.Millisecond)
+
+	var moderation []notify.Request
+	for _, n := range mockDestination.Get()[initial:] {
+		if n.Moderation != "" {
+			moderation = append(moderation, n)
+		}
+	}
+	require.Len(t, moderation, 3)
+	assert.Equal(t, notify.ModerationApproved, moderation[0].Moderation)
+	assert.Equal(t, ids[0], moderation[0].Comment.ID)
+	assert.False(t, moderation[0].Comment.Pending)
+	assert.Equal(t, notify.ModerationRejected, moderation[1].Moderation, "pending comment removed is rejected")
+	assert.Equal(t, "off-topic", moderation[1].Reason)
+	assert.Equal(t, ids[1], moderation[1].Comment.ID)
+	assert.Equal(t, notify.ModerationRejected, moderation[2].Moderation)
+	assert.Equal(t, "spam", moderation[2].Reason)
+	assert.Equal(t, ids[2], moderation[2].Comment.ID)
+	for _, n := range moderation {
+		assert.Equal(t, []string{"dev@example.com"}, n.Emails, "sent to the author")
+	}
+}
+
+func TestAdmin_Archive(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	c1 := store.Comment{Text: "test test #1", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}}
+	addComment(t, c1, ts)
+	addComment(t, c1, ts)
+
+	// archive disabled
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/admin/archive?site=remark42&url=https://radio-t.com/blah", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	loc, err := ioutil.TempDir("", "archive")
+	require.NoError(t, err)
+	defer os.RemoveAll(loc)
+	archiver := &migrator.Archiver{DataStore: srv.DataService, Location: loc, TemplatePath: "../../../templates/archive.html.tmpl"}
+	srv.adminRest.archiver, srv.pubRest.archiver = archiver, archiver
+
+	req, err = http.NewRequest(http.MethodPost, ts.URL+"/api/v1/admin/archive?site=remark42&url=https://radio-t.com/blah&remove=1", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	res := migrator.ArchiveResult{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 2, res.Comments)
+	assert.True(t, res.Removed)
+
+	body, code := get(t, ts.URL+"/api/v1/count?site=remark42&url=https://radio-t.com/blah")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `{"count":0,"locator":{"site":"remark42","url":"https://radio-t.com/blah"}}`+"\n", body, "post removed")
+
+	body, code = get(t, ts.URL+"/api/v1/archive?site=remark42&url=https://radio-t.com/blah")
+	assert.Equal(t, http.StatusOK, code)
+	arch := migrator.PostArchive{}
+	require.NoError(t, json.Unmarshal([]byte(body), &arch))
+	assert.Equal(t, 2, len(arch.Comments))
+
+	body, code = get(t, ts.URL+"/api/v1/archive?site=remark42&url=https://radio-t.com/blah&format=html")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, "test test #1")
+
+	_, code = get(t, ts.URL+"/api/v1/archive?site=remark42&url=https://radio-t.com/blah2")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestAdmin_RebuildSearch(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	c1 := store.Comment{Text: "test test #1", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}}
+	addComment(t, c1, ts)
+	addComment(t, c1, ts)
+
+	// search disabled
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/admin/search/rebuild?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	srv.DataService.SearchService, err = search.NewService([]string{"remark42"}, search.Params{})
+	require.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, ts.URL+"/api/v1/admin/search/rebuild?site=remark42", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `{"indexed":2,"site":"remark42"}`+"\n", string(body))
+
+	res, code := get(t, ts.URL+"/api/v1/search?site=remark42&query=test")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, res, `"total":2`)
+}
+
 func TestAdmin_Title(t *testing.T) {
 	ts, srv, teardown := startupT(t)
 	defer teardown()
@@ -250,6 +474,128 @@ func TestAdmin_Pin(t *testing.T) {
 	assert.False(t, cr.Pin)
 }
 
+func TestAdmin_Label(t *testing.T) {
+	ts, _, teardown := startupT(t)
+	defer teardown()
+
+	c1 := store.Comment{Text: "test test #1",
+		Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}}
+	c2 := store.Comment{Text: "test test #2",
+		Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah2"}}
+
+	id1 := addComment(t, c1, ts)
+	id2 := addComment(t, c2, ts)
+
+	label := func(method, id, url, label string) (string, int) {
+		req, err := http.NewRequest(method,
+			fmt.Sprintf("%s/api/v1/admin/label/%s?site=remark42&url=%s&label=%s", ts.URL, id, url, label), nil)
+		require.NoError(t, err)
+		requireAdminOnly(t, req)
+		req.SetBasicAuth("admin", "password")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return string(body), resp.StatusCode
+	}
+
+	body, code := label(http.MethodPut, id1, "https://radio-t.com/blah", "question")
+	assert.Equal(t, 200, code)
+	assert.Contains(t, body, `"labels":["question"]`)
+	_, code = label(http.MethodPut, id1, "https://radio-t.com/blah", "bug-report")
+	assert.Equal(t, 200, code)
+	_, code = label(http.MethodPut, id2, "https://radio-t.com/blah2", "question")
+	assert.Equal(t, 200, code)
+	_, code = label(http.MethodPut, id2, "https://radio-t.com/blah2", "bad%20label")
+	assert.Equal(t, 400, code)
+
+	body, code = get(t, fmt.Sprintf("%s/api/v1/id/%s?site=remark42&url=https://radio-t.com/blah", ts.URL, id1))
+	assert.Equal(t, 200, code)
+	cr := store.Comment{}
+	require.NoError(t, json.Unmarshal([]byte(body), &cr))
+	assert.Equal(t, []string{"bug-report", "question"}, cr.Labels)
+
+	labeled := func(query string) ([]store.Comment, *http.Response) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/labeled?site=remark42&"+query, nil)
+		require.NoError(t, err)
+		req.SetBasicAuth("admin", "password")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		comments := []store.Comment{}
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&comments))
+		}
+		return comments, resp
+	}
+
+	comments, resp := labeled("label=question")
+	assert.Equal(t, 200, resp.StatusCode)
+	require.Equal(t, 2, len(comments))
+	assert.Equal(t, id1, comments[0].ID)
+	assert.Equal(t, id2, comments[1].ID)
+	assert.Equal(t, "", resp.Header.Get("Content-Disposition"))
+
+	comments, resp = labeled("label=question&url=https://radio-t.com/blah2&export=1")
+	assert.Equal(t, 200, resp.StatusCode)
+	require.Equal(t, 1, len(comments))
+	assert.Equal(t, id2, comments[0].ID)
+	assert.Contains(t, resp.Header.Get("Content-Disposition"), "attachment;filename=remark42-question-")
+
+	_, resp = labeled("label=")
+	assert.Equal(t, 400, resp.StatusCode)
+
+	body, code = label(http.MethodDelete, id1, "https://radio-t.com/blah", "question")
+	assert.Equal(t, 200, code)
+	assert.Contains(t, body, `"labels":["bug-report"]`)
+	comments, _ = labeled("label=question")
+	require.Equal(t, 1, len(comments))
+	assert.Equal(t, id2, comments[0].ID)
+}
+
+func TestAdmin_FindAsOf(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.DataService.MaxRevisions = 10
+
+	c1 := store.Comment{Text: "test test #1", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}}
+	id := addComment(t, c1, ts)
+	asOf := time.Now().Truncate(time.Second).Add(time.Second) // as_of passed with seconds precision
+	time.Sleep(time.Until(asOf) + 10*time.Millisecond)
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/comment/"+id+"?site=remark42&url=https://radio-t.com/blah1",
+		strings.NewReader(`{"text":"updated text", "summary":"my edit"}`))
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, devToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	addComment(t, c1, ts)
+
+	url := ts.URL + "/api/v1/admin/find?site=remark42&url=https://radio-t.com/blah1&as_of=" + asOf.Format(time.RFC3339)
+	req, err = http.NewRequest(http.MethodGet, url, nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	body, code := getWithAdminAuth(t, url)
+	require.Equal(t, http.StatusOK, code, body)
+	res := commentsWithInfo{}
+	require.NoError(t, json.Unmarshal([]byte(body), &res))
+	require.Equal(t, 1, len(res.Comments), "later comment skipped")
+	assert.Equal(t, id, res.Comments[0].ID)
+	assert.Equal(t, "test test #1", res.Comments[0].Orig)
+	assert.Nil(t, res.Comments[0].Edit)
+	assert.Nil(t, res.Comments[0].Revisions)
+
+	body, code = getWithAdminAuth(t, url+"&format=tree")
+	require.Equal(t, http.StatusOK, code, body)
+	tree := service.Tree{}
+	require.NoError(t, json.Unmarshal([]byte(body), &tree))
+	require.Equal(t, 1, len(tree.Nodes))
+	assert.Equal(t, "test test #1", tree.Nodes[0].Comment.Orig)
+
+	body, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/find?site=remark42&url=https://radio-t.com/blah1")
+	assert.Equal(t, http.StatusBadRequest, code, body)
+}
 func TestAdmin_Block(t *testing.T) {
 	ts, srv, teardown := startupT(t)
 	defer teardown()
@@ -425,6 +771,112 @@ func TestAdmin_BlockedList(t *testing.T) {
 	assert.Equal(t, 1, len(users), "one user left blocked")
 }
 
+func TestAdmin_ShadowBan(t *testing.T) {
+	ts, _, teardown := startupT(t)
+	defer teardown()
+
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/shadowban/dev?site=remark42&shadowban=1", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	res, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	addComment(t, store.Comment{Text: "troll comment", Locator: store.Locator{SiteID: "remark42",
+		URL: "https://radio-t.com/blah"}}, ts)
+
+	body, code := get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah&sort=+time")
+	assert.Equal(t, http.StatusOK, code)
+	comments := commentsWithInfo{}
+	require.NoError(t, json.Unmarshal([]byte(body), &comments))
+	assert.Equal(t, 0, len(comments.Comments), "hidden for anonymous")
+
+	req, err = http.NewRequest(http.MethodGet, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah&sort=+time", nil)
+	require.NoError(t, err)
+	res, err = sendReq(t, req, devToken)
+	require.NoError(t, err)
+	comments = commentsWithInfo{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&comments))
+	require.NoError(t, res.Body.Close())
+	assert.Equal(t, 1, len(comments.Comments), "visible for author")
+
+	req, err = http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/shadowbanned?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	res, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	users := []store.User{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&users))
+	require.NoError(t, res.Body.Close())
+	require.Equal(t, 1, len(users))
+	assert.Equal(t, "dev", users[0].ID)
+	assert.Equal(t, "developer one", users[0].Name)
+
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/shadowban/dev?site=remark42&shadowban=0", nil)
+	require.NoError(t, err)
+	res, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	body, code = get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah&sort=+time")
+	assert.Equal(t, http.StatusOK, code)
+	comments = commentsWithInfo{}
+	require.NoError(t, json.Unmarshal([]byte(body), &comments))
+	assert.Equal(t, 1, len(comments.Comments), "visible for all after shadow-ban lifted")
+}
+
+func TestAdmin_Reports(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}
+	id1, err := srv.DataService.Create(store.Comment{Text: "test test #1", User: store.User{ID: "user1"}, Locator: locator})
+	require.NoError(t, err)
+	id2, err := srv.DataService.Create(store.Comment{Text: "test test #2", User: store.User{ID: "user1"}, Locator: locator})
+	require.NoError(t, err)
+	for _, u := range []string{"user2", "user3"} {
+		_, err = srv.DataService.Report(service.ReportReq{Locator: locator, CommentID: id2, UserID: u, Reason: "spam"})
+		require.NoError(t, err)
+	}
+	_, err = srv.DataService.Report(service.ReportReq{Locator: locator, CommentID: id1, UserID: "user2", Reason: "rude"})
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/reports?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	res, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	comments := []store.Comment{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&comments))
+	require.NoError(t, res.Body.Close())
+	require.Equal(t, 2, len(comments))
+	assert.Equal(t, id2, comments[0].ID, "the most reported first")
+	assert.Equal(t, 2, len(comments[0].Reports))
+	assert.Equal(t, "rude", comments[1].Reports[0].Reason)
+
+	req, err = http.NewRequest(http.MethodDelete, ts.URL+"/api/v1/admin/reports/"+id2+"?site=remark42&url=https://radio-t.com/blah", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	res, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	req, err = http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/reports?site=remark42", nil)
+	require.NoError(t, err)
+	res, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	comments = []store.Comment{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&comments))
+	require.NoError(t, res.Body.Close())
+	require.Equal(t, 1, len(comments), "reports of the comment dismissed")
+	assert.Equal(t, id1, comments[0].ID)
+}
+
 func TestAdmin_ReadOnly(t *testing.T) {
 	ts, srv, teardown := startupT(t)
 	defer teardown()
@@ -496,6 +948,60 @@ func TestAdmin_ReadOnly(t *testing.T) {
 	assert.Equal(t, http.StatusCreated, resp.StatusCode)
 }
 
+func TestAdmin_SlowMode(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.DataService.SlowModeDelay = time.Minute
+
+	c1 := store.Comment{Text: "test test #1", Locator: store.Locator{SiteID: "remark42",
+		URL: "https://radio-t.com/blah"}, User: store.User{Name: "user1 name", ID: "user1"}}
+	_, err := srv.DataService.Create(c1)
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodPut,
+		fmt.Sprintf("%s/api/v1/admin/slowmode?site=remark42&url=https://radio-t.com/blah&slow=1", ts.URL), nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, `{"locator":{"site":"remark42","url":"https://radio-t.com/blah"},"slow-mode":true}`+"\n", string(body))
+	assert.True(t, srv.DataService.IsSlowMode(store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}))
+
+	res, code := get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah&format=plain")
+	assert.Equal(t, 200, code)
+	comments := commentsWithInfo{}
+	require.NoError(t, json.Unmarshal([]byte(res), &comments))
+	assert.Equal(t, 0, len(comments.Comments), "new comment hidden for anonymous")
+	assert.True(t, comments.Info.SlowMode)
+
+	req, err = http.NewRequest(http.MethodGet, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah&format=plain", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&comments))
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, 1, len(comments.Comments), "new comment visible for admin")
+
+	req, err = http.NewRequest(http.MethodPut,
+		fmt.Sprintf("%s/api/v1/admin/slowmode?site=remark42&url=https://radio-t.com/blah&slow=0", ts.URL), nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, 200, resp.StatusCode)
+
+	res, code = get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah&format=tree")
+	assert.Equal(t, 200, code)
+	tree := service.Tree{}
+	require.NoError(t, json.Unmarshal([]byte(res), &tree))
+	assert.Equal(t, 1, len(tree.Nodes), "visible after slow mode reset")
+	assert.False(t, tree.Info.SlowMode)
+}
+
 func TestAdmin_ReadOnlyNoComments(t *testing.T) {
 	ts, srv, teardown := startupT(t)
 	defer teardown()
@@ -635,7 +1141,18 @@ func TestAdmin_ExportStream(t *testing.T) {
 	assert.Equal(t, 200, code)
 	assert.Equal(t, 3, strings.Count(body, "\n"))
 	assert.Equal(t, 2, strings.Count(body, "\"text\""))
+	assert.True(t, strings.HasPrefix(body, `{"version":2,`), "the latest version by default")
 	t.Logf("%s", body)
+
+	body, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/export?site=remark42&mode=stream&version=1")
+	assert.Equal(t, 200, code)
+	assert.True(t, strings.HasPrefix(body, `{"version":1,`), body)
+	assert.Equal(t, 2, strings.Count(body, "\"text\""))
+
+	for _, v := range []string{"3", "0", "blah"} {
+		body, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/export?site=remark42&mode=file&version="+v)
+		assert.Equal(t, http.StatusBadRequest, code, "version %s, %s", v, body)
+	}
 }
 
 func TestAdmin_ExportFile(t *testing.T) {
@@ -669,16 +1186,60 @@ func TestAdmin_ExportFile(t *testing.T) {
 	t.Logf("%s", string(ungzBody))
 }
 
-func TestAdmin_DeleteMeRequest(t *testing.T) {
-	ts, srv, teardown := startupT(t)
+func TestAdmin_ExportWordPress(t *testing.T) {
+	ts, _, teardown := startupT(t)
 	defer teardown()
 
-	c1 := store.Comment{Text: "test test #1", Locator: store.Locator{SiteID: "remark42",
-		URL: "https://radio-t.com/blah"}, User: store.User{Name: "user1 name", ID: "user1"}}
-	c2 := store.Comment{Text: "test test #2", ParentID: "p1", Locator: store.Locator{SiteID: "remark42",
-		URL: "https://radio-t.com/blah"}, User: store.User{Name: "user2", ID: "user2"}}
+	c1 := store.Comment{Text: "test test #1",
+		Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}}
+	addComment(t, c1, ts)
 
-	_, err := srv.DataService.Create(c1)
+	req, err := http.NewRequest("POST", ts.URL+"/api/v1/admin/export/wordpress?site=remark42&mode=file",
+		strings.NewReader(`{"dev": {"name": "Dev Author", "email": "dev@example.com", "user_id": 7}}`))
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/gzip", resp.Header.Get("Content-Type"))
+	assert.Contains(t, resp.Header.Get("Content-Disposition"), ".xml.gz")
+	ungzReader, err := gzip.NewReader(resp.Body)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(ungzReader)
+	require.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+	assert.Contains(t, string(body), "<link>https://radio-t.com/blah1</link>")
+	assert.Contains(t, string(body), "<wp:comment_author><![CDATA[Dev Author]]></wp:comment_author>")
+	assert.Contains(t, string(body), "<wp:comment_user_id>7</wp:comment_user_id>")
+
+	req, err = http.NewRequest("POST", ts.URL+"/api/v1/admin/export/wordpress?site=remark42", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	body, err = ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), "<wp:comment_author><![CDATA[developer one]]></wp:comment_author>", "no authors mapped")
+
+	req, err = http.NewRequest("POST", ts.URL+"/api/v1/admin/export/wordpress?site=remark42", strings.NewReader("bad"))
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestAdmin_DeleteMeRequest(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	c1 := store.Comment{Text: "test test #1", Locator: store.Locator{SiteID: "remark42",
+		URL: "https://radio-t.com/blah"}, User: store.User{Name: "user1 name", ID: "user1"}}
+	c2 := store.Comment{Text: "test test #2", ParentID: "p1", Locator: store.Locator{SiteID: "remark42",
+		URL: "https://radio-t.com/blah"}, User: store.User{Name: "user2", ID: "user2"}}
+
+	_, err := srv.DataService.Create(c1)
 	assert.NoError(t, err)
 	_, err = srv.DataService.Create(c2)
 	assert.NoError(t, err)
@@ -849,3 +1410,2499 @@ func TestAdmin_GetUserInfo(t *testing.T) {
 	_, code = getWithAdminAuth(t, fmt.Sprintf("%s/api/v1/admin/user/userX?site=remark42&url=https://radio-t.com/blah", ts.URL))
 	assert.Equal(t, 400, code, "no info about user")
 }
+
+func TestAdmin_Integrity(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	c1 := store.Comment{Text: "test test #1", Locator: store.Locator{SiteID: "remark42",
+		URL: "https://radio-t.com/blah"}, User: store.User{Name: "user1 name", ID: "user1"}}
+	c2 := store.Comment{Text: "test test #2", ParentID: "p1", Locator: store.Locator{SiteID: "remark42",
+		URL: "https://radio-t.com/blah"}, User: store.User{Name: "user2 name", ID: "user2"}}
+	_, err := srv.DataService.Create(c1)
+	require.NoError(t, err)
+	id2, err := srv.DataService.Create(c2)
+	require.NoError(t, err)
+
+	// check only
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/integrity?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	res, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	report := engine.IntegrityReport{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&report))
+	require.NoError(t, res.Body.Close())
+	assert.Equal(t, 2, report.Comments)
+	require.Equal(t, 1, len(report.Issues))
+	assert.Equal(t, engine.IssueDanglingParent, report.Issues[0].Kind)
+	assert.Equal(t, id2, report.Issues[0].CommentID)
+	assert.Equal(t, 0, report.Repaired)
+
+	// check and repair
+	req, err = http.NewRequest(http.MethodPost, ts.URL+"/api/v1/admin/integrity?site=remark42", nil)
+	require.NoError(t, err)
+	res, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	report = engine.IntegrityReport{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&report))
+	require.NoError(t, res.Body.Close())
+	assert.Equal(t, 1, report.Repaired)
+
+	c, err := srv.DataService.Get(c2.Locator, id2, store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, "", c.ParentID)
+
+	// unknown site
+	req, err = http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/integrity?site=bad", nil)
+	require.NoError(t, err)
+	res, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	assert.NotEqual(t, http.StatusOK, res.StatusCode)
+}
+
+func TestAdmin_EmailPreview(t *testing.T) {
+	_, srv, teardown := startupT(t)
+	defer teardown()
+
+	sender := &mockEmailSender{}
+	email, err := notify.NewEmail(notify.EmailParams{From: "from@example.com", Sender: sender,
+		MsgTemplatePath:          "../../../templates/email_reply.html.tmpl",
+		VerificationTemplatePath: "../../../templates/email_confirmation_subscription.html.tmpl"}, notify.SMTPParams{})
+	require.NoError(t, err)
+	srv.NotifyService = notify.NewService(nil, 1, email)
+	defer srv.NotifyService.Close()
+	ts := httptest.NewServer(srv.routes())
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/email/preview?kind=follow", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	assert.Equal(t, `New comment from Sample User for "Sample Post"`, resp.Header.Get("X-Email-Subject"))
+	assert.Contains(t, string(body), "Sample User")
+
+	badBody, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/email/preview?kind=bad")
+	assert.Equal(t, http.StatusBadRequest, code, badBody)
+
+	req, err = http.NewRequest(http.MethodPost, ts.URL+"/api/v1/admin/email/test?address=test@example.com&kind=verification", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "test@example.com", sender.to)
+	assert.Contains(t, sender.message, "Subject: [test] Email verification")
+
+	sender.err = errors.New("auth failed")
+	req, err = http.NewRequest(http.MethodPost, ts.URL+"/api/v1/admin/email/test?address=test@example.com", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	body, err = ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Contains(t, string(body), "auth failed")
+
+	srv.NotifyService = notify.NewService(nil, 1)
+	defer srv.NotifyService.Close()
+	ts2 := httptest.NewServer(srv.routes())
+	defer ts2.Close()
+	badBody, code = getWithAdminAuth(t, ts2.URL+"/api/v1/admin/email/preview")
+	assert.Equal(t, http.StatusNotFound, code, badBody)
+}
+
+func TestAdmin_NotifyConfig(t *testing.T) {
+	_, srv, teardown := startupT(t)
+	defer teardown()
+
+	srv.NotifyService = notify.NewService(nil, 1)
+	ts := httptest.NewServer(srv.routes())
+	body, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/notify/config")
+	assert.Equal(t, http.StatusNotFound, code, body)
+	ts.Close()
+	srv.NotifyService.Close()
+
+	email, err := notify.NewEmail(notify.EmailParams{From: "from@example.com",
+		MsgTemplatePath:          "../../../templates/email_reply.html.tmpl",
+		VerificationTemplatePath: "../../../templates/email_confirmation_subscription.html.tmpl"},
+		notify.SMTPParams{Host: "127.0.0.1", Port: 25, Username: "user", Password: "secret"})
+	require.NoError(t, err)
+	srv.NotifyService = notify.NewService(nil, 1, email)
+	defer srv.NotifyService.Close()
+	ts = httptest.NewServer(srv.routes())
+	defer ts.Close()
+
+	send := func(method, url, body string) (string, int) {
+		req, e := http.NewRequest(method, ts.URL+url, strings.NewReader(body))
+		require.NoError(t, e)
+		req.SetBasicAuth("admin", "password")
+		resp, e := http.DefaultClient.Do(req)
+		require.NoError(t, e)
+		defer resp.Body.Close()
+		b, e := ioutil.ReadAll(resp.Body)
+		require.NoError(t, e)
+		return string(b), resp.StatusCode
+	}
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/notify/config", nil)
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "basic auth admin only")
+
+	body, code = send(http.MethodGet, "/api/v1/admin/notify/config", "")
+	require.Equal(t, http.StatusOK, code, body)
+	assert.NotContains(t, body, "secret")
+	cfg := struct {
+		SMTP struct {
+			Host        string `json:"host"`
+			Port        int    `json:"port"`
+			PasswordSet bool   `json:"password_set"`
+		} `json:"smtp"`
+		Telegram interface{} `json:"telegram"`
+	}{}
+	require.NoError(t, json.Unmarshal([]byte(body), &cfg))
+	assert.Equal(t, "127.0.0.1", cfg.SMTP.Host)
+	assert.True(t, cfg.SMTP.PasswordSet)
+	assert.Nil(t, cfg.Telegram)
+
+	body, code = send(http.MethodPut, "/api/v1/admin/notify/config/smtp", `{"host":"127.0.0.1"}`)
+	assert.Equal(t, http.StatusBadRequest, code, body)
+	body, code = send(http.MethodPut, "/api/v1/admin/notify/config/smtp", `{"host":"127.0.0.1","port":1,"timeout":"bad"}`)
+	assert.Equal(t, http.StatusBadRequest, code, body)
+	body, code = send(http.MethodPut, "/api/v1/admin/notify/config/telegram", `{"token":"123"}`)
+	assert.Equal(t, http.StatusNotFound, code, body)
+
+	// closed port, connection check failed
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedPort := l.Addr().(*net.TCPAddr).Port
+	require.NoError(t, l.Close())
+	body, code = send(http.MethodPut, "/api/v1/admin/notify/config/smtp",
+		fmt.Sprintf(`{"host":"127.0.0.1","port":%d,"timeout":"1s"}`, closedPort))
+	assert.Equal(t, http.StatusBadRequest, code, body)
+	assert.Contains(t, body, "can't connect to smtp server")
+	assert.Equal(t, 25, email.SMTP().Port, "not switched")
+
+	port := fakeSMTPServer(t)
+	body, code = send(http.MethodPut, "/api/v1/admin/notify/config/smtp?dry=1",
+		fmt.Sprintf(`{"host":"127.0.0.1","port":%d,"timeout":"1s"}`, port))
+	require.Equal(t, http.StatusOK, code, body)
+	assert.Contains(t, body, `"switched":false`)
+	assert.Equal(t, 25, email.SMTP().Port, "not switched on dry run")
+
+	body, code = send(http.MethodPut, "/api/v1/admin/notify/config/smtp",
+		fmt.Sprintf(`{"host":"127.0.0.1","port":%d,"username":"new","timeout":"1s"}`, port))
+	require.Equal(t, http.StatusOK, code, body)
+	assert.Contains(t, body, `"switched":true`)
+	assert.Equal(t, port, email.SMTP().Port)
+	assert.Equal(t, "new", email.SMTP().Username)
+	assert.Equal(t, "secret", email.SMTP().Password, "password kept")
+	assert.Equal(t, time.Second, email.SMTP().TimeOut)
+}
+
+// fakeSMTPServer accepts smtp connections, greets and accepts any command and auth. Returns port.
+func fakeSMTPServer(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, e := l.Accept()
+			if e != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				tp := textproto.NewConn(conn)
+				_ = tp.PrintfLine("220 localhost ESMTP fake")
+				for {
+					line, e := tp.ReadLine()
+					if e != nil {
+						return
+					}
+					switch cmd := strings.ToUpper(line); {
+					case strings.HasPrefix(cmd, "QUIT"):
+						_ = tp.PrintfLine("221 bye")
+						return
+					case strings.HasPrefix(cmd, "AUTH"):
+						_ = tp.PrintfLine("235 authenticated")
+					default:
+						_ = tp.PrintfLine("250 ok")
+					}
+				}
+			}(conn)
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+type mockEmailSender struct {
+	to, message string
+	err         error
+}
+
+func (m *mockEmailSender) Send(_ context.Context, _, to, message string) error {
+	m.to, m.message = to, message
+	return m.err
+}
+
+func (m *mockEmailSender) String() string { return "mock sender" }
+
+func TestAdmin_NotifyStatus(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	_, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/notify/status?site=remark42")
+	assert.Equal(t, http.StatusNotFound, code, "not tracked")
+
+	statuses := notify.NewEngineStatuses(srv.DataService.Engine, 0)
+	require.NoError(t, statuses.Add(notify.SendStatus{SiteID: "remark42", CommentID: "c1", Kind: notify.StatusReply,
+		Destination: "email", Recipients: []string{"user@example.com"}, Time: time.Now(), Error: "failed"}))
+	require.NoError(t, statuses.Add(notify.SendStatus{SiteID: "remark42", CommentID: "c2", Kind: notify.StatusReply,
+		Destination: "email", Recipients: []string{"other@example.com"}, Time: time.Now(), Success: true}))
+	notifyService := notify.NewService(nil, 1)
+	notifyService.SetStatusStore(statuses)
+	srv.NotifyService = notifyService
+	ts2 := httptest.NewServer(srv.routes())
+	defer ts2.Close()
+
+	req, err := http.NewRequest(http.MethodGet, ts2.URL+"/api/v1/admin/notify/status?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+
+	body, code := getWithAdminAuth(t, ts2.URL+"/api/v1/admin/notify/status?site=remark42&email=user@example.com")
+	require.Equal(t, http.StatusOK, code, body)
+	res := []notify.SendStatus{}
+	require.NoError(t, json.Unmarshal([]byte(body), &res))
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, "c1", res[0].CommentID)
+	assert.Equal(t, "failed", res[0].Error)
+
+	body, code = getWithAdminAuth(t, ts2.URL+"/api/v1/admin/notify/status?site=remark42&limit=1")
+	require.Equal(t, http.StatusOK, code, body)
+	require.NoError(t, json.Unmarshal([]byte(body), &res))
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, "c2", res[0].CommentID, "newest first")
+
+	_, code = getWithAdminAuth(t, ts2.URL+"/api/v1/admin/notify/status?site=remark42&limit=bad")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestAdmin_NotifyPrefs(t *testing.T) {
+	_, srv, teardown := startupT(t)
+	defer teardown()
+
+	prefsStore := notify.NewEngineAdminPrefs(srv.DataService.Engine)
+	notifyService := notify.NewService(nil, 1)
+	notifyService.SetAdmins([]string{"admin@example.com", "other@example.com"},
+		notify.AdminPrefs{Destinations: []string{"email"}}, prefsStore)
+	srv.NotifyService = notifyService
+	ts := httptest.NewServer(srv.routes())
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/notify/admin?site=remark42&email=admin@example.com",
+		strings.NewReader(`{"events":"pending","destinations":["telegram"],"telegram_chat":"12345"}`))
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	res, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/notify/admin?site=remark42&email=user@example.com",
+		strings.NewReader(`{"events":"pending"}`))
+	require.NoError(t, err)
+	res, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode, "not an admin")
+
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/notify/admin?site=remark42&email=other@example.com",
+		strings.NewReader(`{"events":"some"}`))
+	require.NoError(t, err)
+	res, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode, "bad events")
+
+	body, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/notify/admins?site=remark42")
+	require.Equal(t, http.StatusOK, code)
+	prefs := struct {
+		Defaults notify.AdminPrefs            `json:"defaults"`
+		Admins   map[string]notify.AdminPrefs `json:"admins"`
+	}{}
+	require.NoError(t, json.Unmarshal([]byte(body), &prefs))
+	assert.Equal(t, notify.AdminPrefs{Events: notify.AdminEventsAll, Destinations: []string{"email"}}, prefs.Defaults)
+	assert.Equal(t, map[string]notify.AdminPrefs{
+		"admin@example.com": {Events: notify.AdminEventsPending, Destinations: []string{"telegram"}, TelegramChat: "12345"},
+		"other@example.com": {},
+	}, prefs.Admins)
+}
+
+func TestAdmin_Bounces(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	// bounce processing disabled by default
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/bounces?site=remark42", nil)
+	require.NoError(t, err)
+	res, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	bounceStore := notify.NewEngineBounces(srv.DataService.Engine)
+	srv.BounceStore = bounceStore
+	ts2 := httptest.NewServer(srv.routes())
+	defer ts2.Close()
+
+	for _, b := range []notify.Bounce{{SiteID: "remark42", Email: "a@example.com", Kind: notify.BounceHard},
+		{SiteID: "remark42", Email: "b@example.com", Kind: notify.BounceComplaint},
+		{SiteID: "remark42", Email: "c@example.com", Kind: notify.BounceHard}} {
+		_, err = bounceStore.Add(b)
+		require.NoError(t, err)
+	}
+
+	req, err = http.NewRequest(http.MethodGet, ts2.URL+"/api/v1/admin/bounces?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	res, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	stats := struct {
+		Total     int             `json:"total"`
+		Hard      int             `json:"hard"`
+		Complaint int             `json:"complaint"`
+		Bounces   []notify.Bounce `json:"bounces"`
+	}{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&stats))
+	require.NoError(t, res.Body.Close())
+	assert.Equal(t, 3, stats.Total)
+	assert.Equal(t, 2, stats.Hard)
+	assert.Equal(t, 1, stats.Complaint)
+	assert.Equal(t, 3, len(stats.Bounces))
+
+	req, err = http.NewRequest(http.MethodDelete, ts2.URL+"/api/v1/admin/bounce?site=remark42&email=a@example.com", nil)
+	require.NoError(t, err)
+	res, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.False(t, bounceStore.IsBounced("remark42", "a@example.com"))
+
+	res, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode, "already deleted")
+}
+
+func TestAdmin_Renotify(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	from := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	renotifyURL := ts.URL + "/api/v1/admin/renotify?site=remark42&from=" + from
+	req, err := http.NewRequest(http.MethodPost, renotifyURL, nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	res, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode, "no notify service")
+
+	mockDestination := &notify.MockDest{LogDeliveries: true}
+	srv.adminRest.notifyService = notify.NewService(srv.DataService, 1, mockDestination)
+	defer srv.adminRest.notifyService.Close()
+
+	c1 := store.Comment{Text: "test test #1", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}}
+	addComment(t, c1, ts)
+	addComment(t, c1, ts)
+
+	for _, q := range []string{"from=bad", "from=" + from + "&to=bad", "from=" + from + "&rate=0", "from=" + from + "&rate=x",
+		"from=" + from + "&to=" + time.Now().Add(-2*time.Hour).Format(time.RFC3339)} {
+		req, err = http.NewRequest(http.MethodPost, ts.URL+"/api/v1/admin/renotify?site=remark42&"+q, nil)
+		require.NoError(t, err)
+		res, err = sendReq(t, req, adminUmputunToken)
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode, q)
+	}
+
+	req, err = http.NewRequest(http.MethodPost, renotifyURL+"&rate=600", nil)
+	require.NoError(t, err)
+	res, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	body, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	assert.Equal(t, `{"comments":2,"rate":600,"site":"remark42","skipped":0}`+"\n", string(body))
+
+	req, err = http.NewRequest(http.MethodPost, renotifyURL, nil)
+	require.NoError(t, err)
+	res, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	assert.Equal(t, http.StatusConflict, res.StatusCode, "job for the site in progress")
+
+	assert.Eventually(t, func() bool { return !srv.adminRest.renotifier.isBusy("remark42") }, time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool { return len(mockDestination.Get()) == 2 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "test test #1", mockDestination.Get()[0].Comment.Orig)
+
+}
+
+func TestAdmin_RenotifyProgress(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	_, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/renotify?site=remark42")
+	assert.Equal(t, http.StatusNotFound, code, "nothing started")
+
+	mockDestination := &notify.MockDest{LogDeliveries: true}
+	notifyService := notify.NewService(srv.DataService, 1, mockDestination)
+	defer notifyService.Close()
+	c1 := store.Comment{ID: "c1", Text: "test test #1", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}}
+	c2 := store.Comment{ID: "c2", Text: "test test #2", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}}
+
+	status, err := srv.adminRest.renotifier.start(notifyService, "remark42", []store.Comment{c1, c2, c1}, 6000, false)
+	require.NoError(t, err)
+	assert.Equal(t, 3, status.Total)
+	assert.Equal(t, 1, status.Skipped, "duplicate skipped")
+	assert.Eventually(t, func() bool { return !srv.adminRest.renotifier.isBusy("remark42") }, time.Second, 10*time.Millisecond)
+
+	body, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/renotify?site=remark42")
+	require.Equal(t, http.StatusOK, code, body)
+	status = RenotifyStatus{}
+	require.NoError(t, json.Unmarshal([]byte(body), &status))
+	assert.False(t, status.Running)
+	assert.Equal(t, 2, status.Sent)
+	assert.Equal(t, []RenotifyFailure{}, status.Failed)
+	assert.NotNil(t, status.Finished)
+	assert.Eventually(t, func() bool { return len(mockDestination.Get()) == 2 }, time.Second, 10*time.Millisecond)
+
+	status, err = srv.adminRest.renotifier.start(notifyService, "remark42", []store.Comment{c1, c2}, 6000, false)
+	require.NoError(t, err)
+	assert.Equal(t, 2, status.Skipped, "sent by previous job")
+	assert.Eventually(t, func() bool { return !srv.adminRest.renotifier.isBusy("remark42") }, time.Second, 10*time.Millisecond)
+
+	status, err = srv.adminRest.renotifier.start(notifyService, "remark42", []store.Comment{c1, c2}, 1, true)
+	require.NoError(t, err)
+	assert.Equal(t, 0, status.Skipped, "forced")
+	req, err := http.NewRequest(http.MethodDelete, ts.URL+"/api/v1/admin/renotify?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	res, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	assert.Equal(t, http.StatusOK, res.StatusCode, "running job stopped")
+	assert.Eventually(t, func() bool { return !srv.adminRest.renotifier.isBusy("remark42") }, time.Second, 10*time.Millisecond)
+	status, ok := srv.adminRest.renotifier.status("remark42")
+	require.True(t, ok)
+	assert.True(t, status.Canceled)
+	assert.Equal(t, 0, status.Sent)
+
+	res, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, res.Body.Close())
+	assert.Equal(t, http.StatusNotFound, res.StatusCode, "nothing running")
+}
+
+func TestAdmin_Sentiment(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/sentiment?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	res, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode, "disabled")
+	require.NoError(t, res.Body.Close())
+
+	srv.DataService.Sentiment = service.NewSentimentAnalyzer(nil)
+	for _, text := range []string{"great post, thanks", "awful and wrong", "love it"} {
+		c := store.Comment{Text: text, Orig: text, Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"},
+			User: store.User{Name: "user1 name", ID: "user1"}}
+		_, err = srv.DataService.Create(c)
+		require.NoError(t, err)
+	}
+
+	req, err = http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/sentiment?site=remark42&days=7", nil)
+	require.NoError(t, err)
+	res, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	trends := service.SentimentTrends{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&trends))
+	require.NoError(t, res.Body.Close())
+	assert.Equal(t, 3, trends.Total.Comments)
+	assert.Equal(t, 2, trends.Total.Positive)
+	assert.Equal(t, 1, trends.Total.Negative)
+	require.Equal(t, 1, len(trends.Posts))
+	assert.Equal(t, "https://radio-t.com/blah", trends.Posts[0].URL)
+	require.Equal(t, 1, len(trends.Days))
+	assert.Equal(t, time.Now().UTC().Format("2006-01-02"), trends.Days[0].Day)
+	assert.Equal(t, time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -6), trends.Since.UTC())
+
+	req, err = http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/sentiment?site=remark42&days=bad", nil)
+	require.NoError(t, err)
+	res, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	require.NoError(t, res.Body.Close())
+}
+
+func TestAdmin_Spam(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	spamTS, feedback := spamServer(t)
+	defer spamTS.Close()
+	srv.adminRest.spamService = spam.NewService(&spam.Remote{API: spamTS.URL}, spam.Params{})
+	defer srv.adminRest.spamService.Close()
+
+	loc := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}
+	id1, err := srv.DataService.Create(store.Comment{Text: "pending #1", Locator: loc, Pending: true,
+		User: store.User{Name: "user1 name", ID: "user1"}})
+	require.NoError(t, err)
+	id2, err := srv.DataService.Create(store.Comment{Text: "pending #2", Locator: loc, Pending: true,
+		User: store.User{Name: "user1 name", ID: "user1"}})
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/pending?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	pending := []store.Comment{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&pending))
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, 2, len(pending))
+
+	req, err = http.NewRequest(http.MethodPut,
+		fmt.Sprintf("%s/api/v1/admin/spam/%s?site=remark42&url=https://radio-t.com/blah&spam=0", ts.URL, id1), nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `{"id":"`+id1+`","locator":{"site":"remark42","url":"https://radio-t.com/blah"},"spam":false}`+"\n", string(body))
+
+	req, err = http.NewRequest(http.MethodPut,
+		fmt.Sprintf("%s/api/v1/admin/spam/%s?site=remark42&url=https://radio-t.com/blah&spam=1", ts.URL, id2), nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"/ham " + id1, "/spam " + id2}, *feedback)
+
+	res, code := get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah&format=plain")
+	assert.Equal(t, http.StatusOK, code)
+	comments := commentsWithInfo{}
+	require.NoError(t, json.Unmarshal([]byte(res), &comments))
+	require.Equal(t, 1, len(comments.Comments))
+	assert.Equal(t, id1, comments.Comments[0].ID, "approved comment visible")
+	assert.False(t, comments.Comments[0].Pending)
+	c2, err := srv.DataService.Get(loc, id2, store.User{})
+	require.NoError(t, err)
+	assert.True(t, c2.Deleted, "spam deleted")
+
+	req, err = http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/pending?site=remark42", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&pending))
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, 0, len(pending))
+
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/spam/bad?site=remark42&url=https://radio-t.com/blah&spam=1", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestAdmin_Consents(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.DataService.ConsentVersions = map[string]string{"remark42": "v1"}
+	_, err := srv.DataService.SetUserConsent("remark42", "user1", "v1")
+	require.NoError(t, err)
+	_, err = srv.DataService.SetUserEmail("remark42", "user2", "user2@example.com")
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/consents?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	consents := []engine.UserDetailEntry{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&consents))
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, 1, len(consents))
+	assert.Equal(t, "user1", consents[0].UserID)
+	assert.Equal(t, "v1", consents[0].Consent)
+	assert.NotNil(t, consents[0].ConsentTime)
+}
+
+func TestAdmin_Reattribute(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}
+	id1, err := srv.DataService.Create(store.Comment{Text: "test test #1", Locator: locator, User: store.User{ID: "github_user1", Name: "user1"}})
+	require.NoError(t, err)
+	_, err = srv.DataService.Create(store.Comment{Text: "test test #2", Locator: locator, User: store.User{ID: "google_user2", Name: "user2"}})
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/reattribute?site=remark42&from=github_user1&to=google_user2&dry=1", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	res := service.ReattributeResult{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{id1}, res.Comments)
+	assert.True(t, res.DryRun)
+	c, err := srv.DataService.Get(locator, id1, store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, "github_user1", c.User.ID)
+
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/reattribute?site=remark42&from=github_user1&to=google_user2", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	c, err = srv.DataService.Get(locator, id1, store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, store.User{ID: "google_user2", Name: "user2"}, c.User)
+
+	body, code := get(t, ts.URL+"/api/v1/comments?site=remark42&user=google_user2")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, id1)
+
+	// nothing left to move
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/reattribute?site=remark42&from=github_user1&to=google_user2", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestAdmin_RemapURLs(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://remark42.com/demo/"}
+	id1, err := srv.DataService.Create(store.Comment{Text: "first comment", Locator: locator, User: store.User{ID: "u1"}})
+	require.NoError(t, err)
+	_, err = srv.DataService.Create(store.Comment{Text: "second comment", Locator: locator, User: store.User{ID: "u2"}})
+	require.NoError(t, err)
+	require.NoError(t, srv.DataService.SetReadOnly(locator, true))
+	body, code := get(t, ts.URL+"/api/v1/find?site=remark42&url=https://remark42.com/demo/")
+	require.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, id1, "cached before remap")
+
+	resp, err := post(t, ts.URL+"/api/v1/admin/remap/urls?site=remark42&dry=1", "https://remark42.com/* https://www.remark42.com/*")
+	require.NoError(t, err)
+	res := service.RemapResult{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, service.RemapResult{Posts: []service.RemappedPost{{From: "https://remark42.com/demo/",
+		To: "https://www.remark42.com/demo/", Comments: 2}}, Comments: 2, DryRun: true}, res)
+
+	resp, err = post(t, ts.URL+"/api/v1/admin/remap/urls?site=remark42", "https://remark42.com/* https://www.remark42.com/*")
+	require.NoError(t, err)
+	res = service.RemapResult{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 2, res.Comments)
+	assert.False(t, res.DryRun)
+
+	body, code = get(t, ts.URL+"/api/v1/find?site=remark42&url=https://www.remark42.com/demo/")
+	require.Equal(t, http.StatusOK, code)
+	comments := commentsWithInfo{}
+	require.NoError(t, json.Unmarshal([]byte(body), &comments))
+	assert.Equal(t, 2, comments.Info.Count)
+	assert.True(t, comments.Info.ReadOnly)
+	body, code = get(t, ts.URL+"/api/v1/find?site=remark42&url=https://remark42.com/demo/")
+	require.Equal(t, http.StatusOK, code)
+	assert.NotContains(t, body, id1, "cache flushed")
+
+	resp, err = post(t, ts.URL+"/api/v1/admin/remap/urls?site=remark42", "bad-rules")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/admin/remap/urls?site=remark42", strings.NewReader("a b"))
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+}
+
+func TestAdmin_Settings(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/settings?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	res, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/settings?site=remark42")
+	require.Equal(t, http.StatusOK, code, res)
+	resp := struct {
+		Settings  settings.Values    `json:"settings"`
+		Overrides settings.Overrides `json:"overrides"`
+		Defaults  settings.Values    `json:"defaults"`
+	}{}
+	require.NoError(t, json.Unmarshal([]byte(res), &resp))
+	assert.Equal(t, 10, resp.Settings.ReadOnlyAge)
+	assert.Equal(t, resp.Defaults, resp.Settings)
+
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/settings?site=remark42", strings.NewReader(`{"readonly_age": 0}`))
+	require.NoError(t, err)
+	r, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, r.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, r.StatusCode, "runtime settings disabled")
+
+	srv.Settings = settings.NewService(settings.NewEngineStore(srv.DataService.Engine),
+		settings.Values{ReadOnlyAge: 10, MaxCommentSize: 2000, LowScore: -5, CriticalScore: -10})
+	ts2 := httptest.NewServer(srv.routes())
+	defer ts2.Close()
+
+	req, err = http.NewRequest(http.MethodPut, ts2.URL+"/api/v1/admin/settings?site=remark42",
+		strings.NewReader(`{"readonly_age": 0, "max_comment_size": 100, "low_score": -2, "keywords": ["acme"]}`))
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	r, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, r.StatusCode)
+	require.NoError(t, json.NewDecoder(r.Body).Decode(&resp))
+	require.NoError(t, r.Body.Close())
+	assert.Equal(t, settings.Values{ReadOnlyAge: 0, MaxCommentSize: 100, LowScore: -2, CriticalScore: -10,
+		Keywords: []string{"acme"}}, resp.Settings)
+	assert.Equal(t, settings.Values{ReadOnlyAge: 10, MaxCommentSize: 2000, LowScore: -5, CriticalScore: -10}, resp.Defaults)
+	require.NotNil(t, resp.Overrides.MaxCommentSize)
+	assert.Equal(t, 100, *resp.Overrides.MaxCommentSize)
+	assert.Nil(t, resp.Overrides.EmailNotifications)
+
+	res, code = get(t, ts2.URL+"/api/v1/config?site=remark42")
+	require.Equal(t, http.StatusOK, code)
+	cnf := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal([]byte(res), &cnf))
+	assert.Equal(t, 100.0, cnf["max_comment_size"])
+	assert.Equal(t, 0.0, cnf["readonly_age"])
+	assert.Equal(t, -2.0, cnf["low_score"])
+	assert.Equal(t, -10.0, cnf["critical_score"])
+	assert.NotContains(t, cnf, "keywords", "not public")
+
+	for _, body := range []string{`{"max_comment_size": 0}`, `{"email_notifications": true}`, `{"critical_score": 0}`, `{"keywords": [""]}`, `{bad`} {
+		req, err = http.NewRequest(http.MethodPut, ts2.URL+"/api/v1/admin/settings?site=remark42", strings.NewReader(body))
+		require.NoError(t, err)
+		r, err = sendReq(t, req, adminUmputunToken)
+		require.NoError(t, err)
+		require.NoError(t, r.Body.Close())
+		assert.Equal(t, http.StatusBadRequest, r.StatusCode, body)
+	}
+}
+
+func TestAdmin_Moderation(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/moderation?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "filter disabled")
+
+	filter := moderationFilter(srv)
+	srv.adminRest.moderationFilter = filter
+
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/moderation?site=remark42",
+		strings.NewReader(`{"words": ["Casino", "casino"], "patterns": ["free\\s+money"], "max_links": 3, "action": "reject"}`))
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	rules := moderation.Rules{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&rules))
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	expected := moderation.Rules{Words: []string{"casino"}, Patterns: []string{`free\s+money`}, MaxLinks: 3, Action: moderation.Reject}
+	assert.Equal(t, expected, rules)
+
+	res, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/moderation?site=remark42")
+	assert.Equal(t, http.StatusOK, code)
+	rules = moderation.Rules{}
+	require.NoError(t, json.Unmarshal([]byte(res), &rules))
+	assert.Equal(t, expected, rules)
+
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/moderation?site=remark42",
+		strings.NewReader(`{"patterns": ["[a-"]}`))
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "invalid pattern")
+
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/moderation?site=remark42", strings.NewReader(`{bad`))
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "invalid json")
+}
+
+func TestAdmin_Verified(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/verified/rules?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "rules disabled")
+
+	svc := verified.NewService(verified.NewEngineStore(srv.DataService.Engine), srv.DataService)
+	srv.adminRest.verified = svc
+
+	_, err = srv.DataService.SetUserEmail("remark42", "user1", "user1@dev.example.com")
+	require.NoError(t, err)
+	_, err = srv.DataService.SetUserEmail("remark42", "user2", "user2@gmail.com")
+	require.NoError(t, err)
+
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/verified/rules?site=remark42",
+		strings.NewReader(`{"domains": ["Example.com"]}`))
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	rules := verified.Rules{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&rules))
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, verified.Rules{Domains: []string{"example.com"}}, rules)
+
+	res, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/verified/rules?site=remark42")
+	assert.Equal(t, http.StatusOK, code)
+	rules = verified.Rules{}
+	require.NoError(t, json.Unmarshal([]byte(res), &rules))
+	assert.Equal(t, []string{"example.com"}, rules.Domains)
+
+	req, err = http.NewRequest(http.MethodPost, ts.URL+"/api/v1/admin/verified/evaluate?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	result := struct {
+		Verified []string `json:"verified"`
+		Count    int      `json:"count"`
+	}{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"user1"}, result.Verified)
+	assert.Equal(t, 1, result.Count)
+	assert.True(t, srv.DataService.IsVerified("remark42", "user1"))
+	assert.False(t, srv.DataService.IsVerified("remark42", "user2"))
+
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/verified/rules?site=remark42",
+		strings.NewReader(`{"domains": ["bad domain"]}`))
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "invalid domain")
+
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/verified/rules?site=remark42", strings.NewReader(`{bad`))
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "invalid json")
+}
+
+func TestAdmin_BulkModeration(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	loc1 := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}
+	loc2 := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah2"}
+	ids := []string{}
+	for i, c := range []store.Comment{
+		{Text: "spam #1", Locator: loc1, User: store.User{ID: "spammer", Name: "spammer"}},
+		{Text: "spam #2", Locator: loc2, User: store.User{ID: "spammer", Name: "spammer"}},
+		{Text: "pending", Locator: loc1, User: store.User{ID: "user1", Name: "user1"}, Pending: true},
+		{Text: "good", Locator: loc2, User: store.User{ID: "user1", Name: "user1"}},
+	} {
+		c.Timestamp = time.Date(2021, 5, 1, 10, i, 0, 0, time.UTC)
+		id, err := srv.DataService.Create(c)
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+
+	send := func(body string) (res bulkResult, code int) {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/admin/bulk?site=remark42", strings.NewReader(body))
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, adminUmputunToken)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		}
+		return res, resp.StatusCode
+	}
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/admin/bulk?site=remark42", strings.NewReader(`{}`))
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+
+	_, code := send(`{"action": "ban", "filter": {"user": "spammer"}}`)
+	assert.Equal(t, http.StatusBadRequest, code, "unknown action")
+	_, code = send(`{"action": "delete"}`)
+	assert.Equal(t, http.StatusBadRequest, code, "no comments or filter")
+	_, code = send(`{"action": "delete", "filter": {"user": "spammer"}, "comments": [{"id": "` + ids[0] + `", "url": "https://radio-t.com/blah1"}]}`)
+	assert.Equal(t, http.StatusBadRequest, code, "both comments and filter")
+
+	res, code := send(`{"action": "spam", "filter": {"user": "spammer"}, "dry_run": true}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, bulkResult{Action: bulkSpam, Matched: 2, Failed: []bulkFailure{}, DryRun: true}, res)
+
+	time.Sleep(time.Second) // admin routes limited to 10 req/s
+	res, code = send(`{"action": "spam", "filter": {"user": "spammer", "from": "2021-05-01T10:00:00Z"}}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, bulkResult{Action: bulkSpam, Matched: 2, Done: 2, Failed: []bulkFailure{}}, res)
+	for i, loc := range []store.Locator{loc1, loc2} {
+		c, e := srv.DataService.Get(loc, ids[i], store.User{})
+		require.NoError(t, e)
+		assert.True(t, c.Deleted, "spam deleted")
+	}
+
+	res, code = send(`{"action": "approve", "comments": [{"id": "` + ids[2] + `", "url": "https://radio-t.com/blah1"},
+		{"id": "` + ids[3] + `", "url": "https://radio-t.com/blah2"}, {"id": "bad", "url": "https://radio-t.com/blah2"}]}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 2, res.Matched)
+	assert.Equal(t, 1, res.Done)
+	assert.Equal(t, 1, res.Skipped, "not pending")
+	require.Equal(t, 1, len(res.Failed))
+	assert.Equal(t, "bad", res.Failed[0].ID)
+	c, err := srv.DataService.Get(loc1, ids[2], store.User{})
+	require.NoError(t, err)
+	assert.False(t, c.Pending, "approved")
+
+	res, code = send(`{"action": "delete", "reason": "off-topic", "filter": {"url": "https://radio-t.com/blah2"}}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, bulkResult{Action: bulkDelete, Matched: 1, Done: 1, Failed: []bulkFailure{}}, res, "deleted one skipped")
+	c, err = srv.DataService.Get(loc2, ids[3], store.User{})
+	require.NoError(t, err)
+	assert.True(t, c.Deleted)
+}
+
+func TestAdmin_PurgeImageCache(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	req, err := http.NewRequest(http.MethodDelete, ts.URL+"/api/v1/admin/img-cache?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "cache disabled")
+
+	tmpFile, err := ioutil.TempFile("", "img-proxy")
+	require.NoError(t, err)
+	require.NoError(t, tmpFile.Close())
+	defer os.Remove(tmpFile.Name())
+	cache, err := proxy.NewBoltCache(tmpFile.Name(), bolt.Options{})
+	require.NoError(t, err)
+	defer cache.Close()
+	srv.adminRest.imageProxy = &proxy.Image{Cache: cache}
+	expires := time.Now().Add(time.Hour)
+	require.NoError(t, cache.Put("https://example.com/1.png", "", proxy.CacheEntry{Data: []byte("img1"), Expires: expires}))
+	require.NoError(t, cache.Put("https://example.com/1.png", "webp", proxy.CacheEntry{Data: []byte("webp1"), Expires: expires}))
+	require.NoError(t, cache.Put("https://example.com/2.png", "", proxy.CacheEntry{Data: []byte("img2"), Expires: expires}))
+
+	req, err = http.NewRequest(http.MethodDelete, ts.URL+"/api/v1/admin/img-cache?site=remark42&url=https://example.com/1.png", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `{"purged":2,"url":"https://example.com/1.png"}`+"\n", string(body))
+
+	req, err = http.NewRequest(http.MethodDelete, ts.URL+"/api/v1/admin/img-cache?site=remark42", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	body, err = ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, `{"purged":1,"url":""}`+"\n", string(body), "all images")
+}
+
+func TestAdmin_Roles(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/roles?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "roles disabled")
+
+	svc := roles.NewService(roles.NewEngineStore(srv.DataService.Engine), srv.DataService.AdminStore.Admins)
+	srv.Roles, srv.adminRest.roles, srv.privRest.roles = svc, svc, svc
+
+	send := func(method, url, tkn string) int {
+		req, e := http.NewRequest(method, ts.URL+url, nil)
+		require.NoError(t, e)
+		resp, e := sendReq(t, req, tkn)
+		require.NoError(t, e)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusForbidden, send(http.MethodGet, "/api/v1/admin/roles?site=remark42", adminUmputunToken),
+		"admin flag of token ignored without role")
+	require.NoError(t, svc.Assign("remark42", "github_ef0f706a7", roles.Owner))
+
+	assert.Equal(t, http.StatusOK, send(http.MethodPut, "/api/v1/admin/roles/dev?site=remark42&role=viewer", adminUmputunToken))
+	assert.Equal(t, http.StatusOK, send(http.MethodGet, "/api/v1/admin/pending?site=remark42", devToken), "viewer reads")
+	assert.Equal(t, http.StatusForbidden, send(http.MethodPut, "/api/v1/admin/readonly?site=remark42&url=https://radio-t.com/blah&ro=1", devToken),
+		"viewer can't moderate")
+	assert.Equal(t, http.StatusForbidden, send(http.MethodGet, "/api/v1/admin/roles?site=remark42", devToken))
+
+	time.Sleep(time.Second) // admin routes limited to 10 req/s
+	assert.Equal(t, http.StatusOK, send(http.MethodPut, "/api/v1/admin/roles/dev?site=remark42&role=moderator", adminUmputunToken))
+	assert.Equal(t, http.StatusOK, send(http.MethodPut, "/api/v1/admin/readonly?site=remark42&url=https://radio-t.com/blah&ro=1", devToken),
+		"moderator moderates")
+	assert.Equal(t, http.StatusForbidden, send(http.MethodPut, "/api/v1/admin/settings?site=remark42", devToken), "moderator can't manage")
+	assert.Equal(t, http.StatusForbidden, send(http.MethodGet, "/api/v1/admin/export?site=remark42&mode=stream", devToken),
+		"moderator can't export")
+
+	body, code := getWithDevAuth(t, ts.URL+"/api/v1/user?site=remark42")
+	assert.Equal(t, http.StatusOK, code)
+	user := store.User{}
+	require.NoError(t, json.Unmarshal([]byte(body), &user))
+	assert.Equal(t, "moderator", user.Role)
+
+	res, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/roles?site=remark42")
+	assert.Equal(t, http.StatusOK, code)
+	list := []roles.Assignment{}
+	require.NoError(t, json.Unmarshal([]byte(res), &list))
+	assert.Equal(t, []roles.Assignment{{UserID: "a1", Role: roles.Owner, Static: true}, {UserID: "a2", Role: roles.Owner, Static: true},
+		{UserID: "dev", Role: roles.Moderator}, {UserID: "github_ef0f706a7", Role: roles.Owner}}, list)
+
+	time.Sleep(time.Second)
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodPut, "/api/v1/admin/roles/dev?site=remark42&role=bad", adminUmputunToken))
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodPut, "/api/v1/admin/roles/a1?site=remark42&role=viewer", adminUmputunToken),
+		"static owner")
+	assert.Equal(t, http.StatusOK, send(http.MethodDelete, "/api/v1/admin/roles/dev?site=remark42", adminUmputunToken))
+	assert.Equal(t, http.StatusForbidden, send(http.MethodGet, "/api/v1/admin/pending?site=remark42", devToken), "role removed")
+}
+
+func TestAdmin_AccessPendingTwoFactor(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	svc := roles.NewService(roles.NewEngineStore(srv.DataService.Engine), srv.DataService.AdminStore.Admins)
+	srv.Roles, srv.adminRest.roles, srv.privRest.roles = svc, svc, svc
+	require.NoError(t, svc.Assign("remark42", "github_admin", roles.Owner))
+
+	send := func(admin bool, attr string) int {
+		claims := token.Claims{
+			User: &token.User{ID: "github_admin", Name: "admin"},
+			StandardClaims: jwt.StandardClaims{Audience: "remark42", Issuer: "remark42",
+				ExpiresAt: time.Now().Add(10 * time.Minute).Unix(), NotBefore: time.Now().Add(-1 * time.Minute).Unix()},
+		}
+		claims.User.SetAdmin(admin)
+		if attr != "" {
+			claims.User.SetBoolAttr(attr, true)
+		}
+		tkn, e := srv.Authenticator.TokenService().Token(claims)
+		require.NoError(t, e)
+		req, e := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/pending?site=remark42", nil)
+		require.NoError(t, e)
+		resp, e := sendReq(t, req, tkn)
+		require.NoError(t, e)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, send(true, ""), "owner")
+	assert.Equal(t, http.StatusForbidden, send(false, totp.PendingFlag), "owner pending two-factor auth")
+	assert.Equal(t, http.StatusForbidden, send(true, totp.PendingFlag), "pending flag wins over admin flag")
+
+	require.NoError(t, svc.Assign("remark42", "github_admin", roles.None))
+	assert.Equal(t, http.StatusOK, send(true, saml.AdminFlag), "admin granted by identity provider")
+	assert.Equal(t, http.StatusForbidden, send(false, saml.AdminFlag), "identity provider admin without admin rights in token")
+}
+
+func TestAdmin_Maintenance(t *testing.T) {
+	ts, _, teardown := startupT(t)
+	defer teardown()
+
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/maintenance?enabled=1&message=migration", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	st := MaintenanceStatus{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&st))
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, st.Enabled)
+	assert.Equal(t, "migration", st.Message)
+
+	body, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/maintenance")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"enabled":true`)
+	body, code = get(t, ts.URL+"/api/v1/config?site=remark42")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"maintenance":true`)
+
+	req, err = http.NewRequest(http.MethodPost, ts.URL+"/api/v1/comment",
+		strings.NewReader(`{"text": "test 123", "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`))
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, devToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode, "writes rejected")
+
+	_, code = get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah1")
+	assert.Equal(t, http.StatusOK, code, "reads allowed")
+
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/maintenance?enabled=0", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	req, err = http.NewRequest(http.MethodPost, ts.URL+"/api/v1/comment",
+		strings.NewReader(`{"text": "test 123", "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`))
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, devToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+}
+
+func TestAdmin_Replication(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/replication", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	_, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/replication")
+	assert.Equal(t, http.StatusNotFound, code, "disabled")
+
+	dir, err := ioutil.TempDir("", "replication")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	oplog, err := replication.NewBoltLog(dir+"/replication.db", bolt.Options{})
+	require.NoError(t, err)
+	standby, err := replication.NewStandby(srv.DataService.Engine, oplog, []string{"remark42"},
+		replication.StandbyParams{Primary: "https://primary.example.com/api/v1/replication", Secret: "secret",
+			Unreplicated: []string{dir + "/replication.db"}})
+	require.NoError(t, err)
+	srv.adminRest.replicationStandby = standby
+	srv.Maintenance.Set(true, "standby")
+
+	body, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/replication")
+	assert.Equal(t, http.StatusOK, code)
+	st := replication.Status{}
+	require.NoError(t, json.Unmarshal([]byte(body), &st))
+	assert.Equal(t, "standby", st.Role)
+	assert.Equal(t, "https://primary.example.com/api/v1/replication", st.Primary)
+	assert.False(t, st.Promoted)
+	assert.Equal(t, []string{dir + "/replication.db"}, st.Unreplicated)
+
+	req, err = http.NewRequest(http.MethodPost, ts.URL+"/api/v1/admin/replication/promote", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "unreplicated data")
+	assert.False(t, standby.Promoted())
+
+	req, err = http.NewRequest(http.MethodPost, ts.URL+"/api/v1/admin/replication/promote?force=1", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&st))
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, st.Promoted)
+	assert.True(t, standby.Promoted())
+	assert.False(t, srv.Maintenance.Status().Enabled, "writes allowed after promotion")
+	require.NoError(t, oplog.Close())
+}
+
+func TestAdmin_VoteFraud(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/votes/fraud?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "detection disabled")
+
+	detector := votefraud.NewDetector(votefraud.NewEngineStore(srv.DataService.Engine, func() []string { return []string{"remark42"} }),
+		votefraud.Params{Secret: "secret", SubnetVoters: 3})
+	srv.privRest.voteFraud, srv.adminRest.voteFraud = detector, detector
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}
+	id := addComment(t, store.Comment{Text: "test test #1", Locator: locator}, ts)
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/vote/"+id+"?site=remark42&url=https://radio-t.com/blah&vote=1", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, devToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	for _, u := range []string{"user2", "user3"} { // more voters from the same ip
+		c, e := srv.DataService.Vote(service.VoteReq{Locator: locator, CommentID: id, UserID: u, UserIP: "127.0.0.1", Val: true})
+		require.NoError(t, e)
+		detector.Record(c, u, "127.0.0.1", true)
+	}
+	require.NoError(t, detector.Analyze())
+
+	res, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/votes/fraud?site=remark42")
+	require.Equal(t, http.StatusOK, code, res)
+	findings := []votefraud.Finding{}
+	require.NoError(t, json.Unmarshal([]byte(res), &findings))
+	require.Equal(t, 2, len(findings), "ip and subnet")
+	assert.Equal(t, votefraud.KindIP, findings[0].Kind)
+	assert.Equal(t, []string{"dev", "user2", "user3"}, findings[0].Users)
+
+	req, err = http.NewRequest(http.MethodPost, ts.URL+"/api/v1/admin/votes/fraud/"+findings[0].ID+"?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	assert.Equal(t, `{"id":"`+findings[0].ID+`","voided":3}`+"\n", string(body))
+	c, err := srv.DataService.Get(locator, id, store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, c.Score, "votes voided")
+	assert.Equal(t, 0, len(c.Votes))
+
+	req, err = http.NewRequest(http.MethodDelete, ts.URL+"/api/v1/admin/votes/fraud/"+findings[1].ID+"?site=remark42", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "dismissed")
+
+	res, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/votes/fraud?site=remark42")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "[]\n", res)
+
+	req, err = http.NewRequest(http.MethodDelete, ts.URL+"/api/v1/admin/votes/fraud/"+findings[1].ID+"?site=remark42", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "already resolved")
+}
+
+func TestAdmin_Audit(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/audit?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "audit disabled")
+
+	auditService := audit.NewService(audit.NewEngineStore(srv.DataService.Engine))
+	srv.privRest.audit, srv.adminRest.audit = auditService, auditService
+
+	id := addComment(t, store.Comment{Text: "test test #1", Locator: store.Locator{SiteID: "remark42",
+		URL: "https://radio-t.com/blah"}}, ts)
+	for _, u := range []string{
+		"/api/v1/admin/pin/" + id + "?site=remark42&url=https://radio-t.com/blah&pin=1",
+		"/api/v1/admin/user/user1?site=remark42&block=1&ttl=1h&reason=rude",
+		"/api/v1/admin/verify/dev?site=remark42&verified=1",
+	} {
+		req, err = http.NewRequest(http.MethodPut, ts.URL+u, nil)
+		require.NoError(t, err)
+		resp, err = sendReq(t, req, adminUmputunToken)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusOK, resp.StatusCode, u)
+	}
+	req, err = http.NewRequest(http.MethodDelete, ts.URL+"/api/v1/admin/comment/"+id+"?site=remark42&url=https://radio-t.com/blah&reason=spam", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	res, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/audit?site=remark42")
+	require.Equal(t, http.StatusOK, code, res)
+	entries := []audit.Entry{}
+	require.NoError(t, json.Unmarshal([]byte(res), &entries))
+	require.Equal(t, 4, len(entries))
+	assert.Equal(t, audit.ActionDelete, entries[0].Action, "the most recent first")
+	assert.Equal(t, id, entries[0].Target)
+	assert.Equal(t, "https://radio-t.com/blah", entries[0].URL)
+	assert.Equal(t, "spam", entries[0].Reason)
+	assert.Equal(t, "github_ef0f706a7", entries[0].Actor)
+	assert.Equal(t, audit.ActionVerify, entries[1].Action)
+	assert.Equal(t, audit.ActionBlock, entries[2].Action)
+	assert.Equal(t, "rude (for 1h0m0s)", entries[2].Reason)
+	assert.Equal(t, audit.ActionPin, entries[3].Action)
+
+	res, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/audit?site=remark42&action=block&actor=github_ef0f706a7")
+	require.Equal(t, http.StatusOK, code, res)
+	entries = []audit.Entry{}
+	require.NoError(t, json.Unmarshal([]byte(res), &entries))
+	require.Equal(t, 1, len(entries))
+	assert.Equal(t, "user1", entries[0].Target)
+
+	res, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/audit?site=remark42&limit=2&to="+
+		time.Now().Add(time.Hour).Format(time.RFC3339))
+	require.Equal(t, http.StatusOK, code, res)
+	entries = []audit.Entry{}
+	require.NoError(t, json.Unmarshal([]byte(res), &entries))
+	assert.Equal(t, 2, len(entries))
+
+	_, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/audit?site=remark42&from=yesterday")
+	assert.Equal(t, http.StatusBadRequest, code)
+	_, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/audit?site=remark42&limit=-1")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	req, err = http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/audit/export?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Disposition"), "remark42-audit-")
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	require.Equal(t, 4, len(lines))
+	entry := audit.Entry{}
+	require.NoError(t, json.Unmarshal([]byte(lines[3]), &entry))
+	assert.Equal(t, audit.ActionPin, entry.Action)
+}
+
+func TestAdmin_Schedule(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/schedule?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "scheduling disabled")
+
+	scheduleService := schedule.NewService(schedule.NewEngineStore(srv.DataService.Engine))
+	srv.pubRest.schedule, srv.privRest.schedule, srv.adminRest.schedule = scheduleService, scheduleService, scheduleService
+
+	openAt := time.Now().Add(time.Hour).Truncate(time.Second).UTC()
+	body := fmt.Sprintf(`{"open_at": %q, "close_after": 3}`, openAt.Format(time.RFC3339))
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/schedule?site=remark42&url=https://radio-t.com/blah",
+		strings.NewReader(body))
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	sch := schedule.Schedule{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&sch))
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, openAt.AddDate(0, 0, 3), sch.CloseAt.UTC())
+
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/schedule?site=remark42&url=https://radio-t.com/blah",
+		strings.NewReader(`{"close_after": -1}`))
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	c := store.Comment{Text: "test test #1", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}}
+	b, err := json.Marshal(c)
+	require.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, ts.URL+"/api/v1/comment", bytes.NewBuffer(b))
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, devToken)
+	require.NoError(t, err)
+	errResp := struct {
+		Code  int
+		Error string
+	}{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&errResp))
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Equal(t, rest.ErrNotOpened, errResp.Code)
+	assert.Equal(t, "comments open at "+openAt.Format(time.RFC3339), errResp.Error)
+
+	res, code := get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah&format=tree")
+	require.Equal(t, http.StatusOK, code)
+	tree := service.Tree{}
+	require.NoError(t, json.Unmarshal([]byte(res), &tree))
+	assert.True(t, tree.Info.ReadOnly, "not opened post is read-only")
+	require.NotNil(t, tree.Info.OpenAt)
+	assert.Equal(t, openAt, tree.Info.OpenAt.UTC())
+
+	res, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/schedule?site=remark42")
+	require.Equal(t, http.StatusOK, code)
+	schedules := []schedule.Schedule{}
+	require.NoError(t, json.Unmarshal([]byte(res), &schedules))
+	require.Equal(t, 1, len(schedules))
+	assert.Equal(t, 3, schedules[0].CloseAfter)
+
+	// closed post
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/schedule?site=remark42&url=https://radio-t.com/blah",
+		strings.NewReader(fmt.Sprintf(`{"open_at": %q, "close_after": 1}`, time.Now().AddDate(0, 0, -2).Format(time.RFC3339))))
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	req, err = http.NewRequest(http.MethodPost, ts.URL+"/api/v1/comment", bytes.NewBuffer(b))
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, devToken)
+	require.NoError(t, err)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&errResp))
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Equal(t, rest.ErrReadOnly, errResp.Code)
+
+	req, err = http.NewRequest(http.MethodDelete, ts.URL+"/api/v1/admin/schedule?site=remark42&url=https://radio-t.com/blah", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	_, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/schedule?site=remark42&url=https://radio-t.com/blah")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	id := addComment(t, c, ts)
+	assert.NotEmpty(t, id, "open after schedule deleted")
+}
+
+func TestAdmin_Interval(t *testing.T) {
+	ts, _, teardown := startupT(t)
+	defer teardown()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/interval?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+
+	setInterval := func(minutes string) int {
+		req, e := http.NewRequest(http.MethodPut,
+			ts.URL+"/api/v1/admin/interval?site=remark42&url=https://radio-t.com/blah&minutes="+minutes, nil)
+		require.NoError(t, e)
+		resp, e := sendReq(t, req, adminUmputunToken)
+		require.NoError(t, e)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode
+	}
+	errResp := struct {
+		Code  int
+		Error string
+	}{}
+	postComment := func(c store.Comment, tkn string) *http.Response {
+		b, e := json.Marshal(c)
+		require.NoError(t, e)
+		req, e := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/comment", bytes.NewBuffer(b))
+		require.NoError(t, e)
+		resp, e := sendReq(t, req, tkn)
+		require.NoError(t, e)
+		errResp.Code, errResp.Error = 0, ""
+		if resp.StatusCode != http.StatusCreated {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&errResp))
+		}
+		require.NoError(t, resp.Body.Close())
+		return resp
+	}
+
+	assert.Equal(t, http.StatusBadRequest, setInterval("-1"))
+	assert.Equal(t, http.StatusBadRequest, setInterval("bad"))
+	require.Equal(t, http.StatusOK, setInterval("10"))
+
+	c := store.Comment{Text: "test test #1", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}}
+	addComment(t, c, ts)
+	resp := postComment(c, devToken)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, rest.ErrCommentInterval, errResp.Code)
+	assert.Contains(t, errResp.Error, "one comment per 10 minutes")
+	assert.NotEmpty(t, resp.Header.Get("Retry-After"))
+	resp = postComment(c, adminUmputunToken)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "admin not limited")
+	c2 := store.Comment{Text: "test test #2", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah2"}}
+	addComment(t, c2, ts) // other posts not limited
+
+	res, code := get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah")
+	require.Equal(t, http.StatusOK, code)
+	withInfo := commentsWithInfo{}
+	require.NoError(t, json.Unmarshal([]byte(res), &withInfo))
+	assert.Equal(t, 10, withInfo.Info.Interval)
+
+	res, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/interval?site=remark42")
+	require.Equal(t, http.StatusOK, code)
+	intervals := []service.PostInterval{}
+	require.NoError(t, json.Unmarshal([]byte(res), &intervals))
+	assert.Equal(t, []service.PostInterval{{URL: "https://radio-t.com/blah", Minutes: 10}}, intervals)
+
+	require.Equal(t, http.StatusOK, setInterval("0"))
+	res, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/interval?site=remark42&url=https://radio-t.com/blah")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `{"url":"https://radio-t.com/blah","interval":0}`+"\n", res)
+	addComment(t, c, ts)
+}
+
+func TestAdmin_Undelete(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	srv.DataService.Trash = service.NewEngineTrash(srv.DataService.Engine, func() []string { return []string{"remark42"} })
+	srv.DataService.TrashRetention = time.Hour
+
+	id := addComment(t, store.Comment{Text: "test test #1", Locator: store.Locator{SiteID: "remark42",
+		URL: "https://radio-t.com/blah"}}, ts)
+	req, err := http.NewRequest(http.MethodDelete, ts.URL+"/api/v1/admin/comment/"+id+"?site=remark42&url=https://radio-t.com/blah", nil)
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	req, err = http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/deleted?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	deleted := []service.TrashedComment{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&deleted))
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, 1, len(deleted))
+	assert.Equal(t, id, deleted[0].Comment.ID)
+	assert.Equal(t, "<p>test test #1</p>\n", deleted[0].Comment.Text)
+
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/undelete/"+id+"?site=remark42&url=https://radio-t.com/blah", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	c, err := srv.DataService.Get(store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}, id, store.User{})
+	require.NoError(t, err)
+	assert.False(t, c.Deleted)
+	assert.Equal(t, "<p>test test #1</p>\n", c.Text)
+
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "not in trash anymore")
+}
+
+func TestAdmin_Fingerprint(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/fingerprint/block?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "fingerprints disabled")
+
+	svc := fingerprint.NewService(fingerprint.NewEngineStore(srv.DataService.Engine, func() []string { return []string{"remark42"} }),
+		fingerprint.Params{Salt: "salt"})
+	srv.privRest.fingerprints, srv.adminRest.fingerprints = svc, svc
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}
+	id1 := addComment(t, store.Comment{Text: "test test #1", Locator: locator}, ts)
+	id2 := addComment(t, store.Comment{Text: "test test #2", Locator: locator}, ts)
+
+	res, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/fingerprint/"+id1+"?site=remark42")
+	require.Equal(t, http.StatusOK, code, res)
+	result := struct {
+		Fingerprint fingerprint.Record   `json:"fingerprint"`
+		Related     []fingerprint.Record `json:"related"`
+	}{}
+	require.NoError(t, json.Unmarshal([]byte(res), &result))
+	assert.Equal(t, id1, result.Fingerprint.CommentID)
+	assert.Equal(t, store.HashValue("127.0.0.1", "salt"), result.Fingerprint.IP)
+	assert.NotEmpty(t, result.Fingerprint.Agent)
+	require.Equal(t, 1, len(result.Related))
+	assert.Equal(t, id2, result.Related[0].CommentID)
+
+	res, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/fingerprint?site=remark42&hash="+result.Fingerprint.IP)
+	require.Equal(t, http.StatusOK, code, res)
+	records := []fingerprint.Record{}
+	require.NoError(t, json.Unmarshal([]byte(res), &records))
+	assert.Equal(t, 2, len(records))
+	_, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/fingerprint?site=remark42")
+	assert.Equal(t, http.StatusBadRequest, code, "no hash")
+	_, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/fingerprint/unknown?site=remark42")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/fingerprint/block/"+result.Fingerprint.IP+
+		"?site=remark42&reason=evasion", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	b, err := json.Marshal(store.Comment{Text: "test test #3", Locator: locator})
+	require.NoError(t, err)
+	req, err = http.NewRequest(http.MethodPost, ts.URL+"/api/v1/comment", bytes.NewBuffer(b))
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, devToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "blocked by ip hash")
+
+	res, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/fingerprint/block?site=remark42")
+	require.Equal(t, http.StatusOK, code, res)
+	blocks := []fingerprint.Block{}
+	require.NoError(t, json.Unmarshal([]byte(res), &blocks))
+	require.Equal(t, 1, len(blocks))
+	assert.Equal(t, "evasion", blocks[0].Reason)
+
+	req, err = http.NewRequest(http.MethodDelete, ts.URL+"/api/v1/admin/fingerprint/block/"+result.Fingerprint.IP+"?site=remark42", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	addComment(t, store.Comment{Text: "test test #3", Locator: locator}, ts)
+}
+
+func TestAdmin_JWTKeys(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/admin/jwt/rotate?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "rotation disabled")
+
+	tmpFile, err := ioutil.TempFile("", "jwt_keys")
+	require.NoError(t, err)
+	require.NoError(t, tmpFile.Close())
+	defer os.Remove(tmpFile.Name())
+	keys, err := jwtkeys.NewKeyring(tmpFile.Name(), bolt.Options{}, func() (string, error) { return "secret", nil }, time.Hour)
+	require.NoError(t, err)
+	defer keys.Close()
+	srv.adminRest.jwtKeys = keys
+
+	req, err = http.NewRequest(http.MethodPost, ts.URL+"/api/v1/admin/jwt/rotate?site=remark42&grace=bad", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	req, err = http.NewRequest(http.MethodPost, ts.URL+"/api/v1/admin/jwt/rotate?site=remark42&grace=10m", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	rotated := struct {
+		ID    string `json:"id"`
+		Grace string `json:"grace"`
+	}{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&rotated))
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "10m0s", rotated.Grace)
+
+	res, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/jwt/keys?site=remark42")
+	require.Equal(t, http.StatusOK, code, res)
+	list := []jwtkeys.Key{}
+	require.NoError(t, json.Unmarshal([]byte(res), &list))
+	require.Equal(t, 2, len(list))
+	assert.Equal(t, rotated.ID, list[0].ID)
+	assert.Empty(t, list[0].Secret, "secret not exposed")
+	assert.Equal(t, jwtkeys.BaseKeyID, list[1].ID)
+	require.NotNil(t, list[1].Expires)
+	assert.True(t, list[1].Expires.Before(time.Now().Add(11*time.Minute)))
+}
+
+func TestAdmin_Warmup(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	_, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/warmup?site=remark42")
+	assert.Equal(t, http.StatusNotFound, code, "warmup disabled")
+
+	sitemap := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<urlset><url><loc>https://radio-t.com/blah1</loc></url><url><loc>https://radio-t.com/blah2</loc></url></urlset>`))
+	}))
+	defer sitemap.Close()
+	svc := warmup.NewService(warmup.Params{Sitemaps: map[string]string{"remark42": sitemap.URL}})
+	svc.SetHandler(srv.warmupRoutes())
+	srv.adminRest.warmup = svc
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/admin/warmup?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "not started")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go svc.Run(ctx)
+	status := warmup.Status{}
+	assert.Eventually(t, func() bool {
+		res, c := getWithAdminAuth(t, ts.URL+"/api/v1/admin/warmup?site=remark42")
+		require.Equal(t, http.StatusOK, c, res)
+		require.NoError(t, json.Unmarshal([]byte(res), &status))
+		return !status.Finished.IsZero()
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 2, status.URLs)
+	assert.Equal(t, 2, status.Warmed)
+
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+	_, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/warmup?site=other")
+	assert.Equal(t, http.StatusNotFound, code, "no sitemap")
+}
+
+func TestAdmin_Sites(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	_, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/sites")
+	assert.Equal(t, http.StatusNotFound, code, "provisioning disabled")
+
+	tmpFile, err := ioutil.TempFile("", "sites")
+	require.NoError(t, err)
+	require.NoError(t, tmpFile.Close())
+	defer os.Remove(tmpFile.Name())
+	st, err := sites.NewBoltStore(tmpFile.Name(), bolt.Options{})
+	require.NoError(t, err)
+	svc, err := sites.NewService(st, srv.DataService.AdminStore, srv.DataService.Engine.(engine.SiteManager), []string{"remark42"})
+	require.NoError(t, err)
+	defer svc.Close()
+	srv.adminRest.sites, srv.DataService.AdminStore = svc, svc
+
+	send := func(method, url, body string) (string, int) {
+		req, e := http.NewRequest(method, ts.URL+url, strings.NewReader(body))
+		require.NoError(t, e)
+		req.SetBasicAuth("admin", "password")
+		resp, e := http.DefaultClient.Do(req)
+		require.NoError(t, e)
+		defer resp.Body.Close()
+		b, e := ioutil.ReadAll(resp.Body)
+		require.NoError(t, e)
+		return string(b), resp.StatusCode
+	}
+
+	body, code := send(http.MethodPost, "/api/v1/admin/sites", `{"id":"blog","admins":["github_1"],"admin_email":"blog@example.com"}`)
+	require.Equal(t, http.StatusCreated, code, body)
+	site := sites.Site{}
+	require.NoError(t, json.Unmarshal([]byte(body), &site))
+	assert.Equal(t, "blog", site.ID)
+	assert.NotEmpty(t, site.Secret)
+
+	_, err = srv.DataService.Create(store.Comment{Text: "hello", Locator: store.Locator{SiteID: "blog", URL: "https://example.com/1"},
+		User: store.User{ID: "user1", Name: "user1"}})
+	require.NoError(t, err, "storage of the site made")
+
+	_, code = send(http.MethodPost, "/api/v1/admin/sites", `{"id":"remark42"}`)
+	assert.Equal(t, http.StatusBadRequest, code, "static site exists")
+
+	body, code = send(http.MethodGet, "/api/v1/admin/sites", "")
+	require.Equal(t, http.StatusOK, code)
+	list := []sites.Site{}
+	require.NoError(t, json.Unmarshal([]byte(body), &list))
+	require.Len(t, list, 2)
+	assert.Equal(t, "remark42", list[0].ID)
+	assert.True(t, list[0].Static)
+	assert.Equal(t, "", list[1].Secret)
+
+	body, code = send(http.MethodPut, "/api/v1/admin/sites/blog", `{"disabled":true}`)
+	require.Equal(t, http.StatusOK, code, body)
+	enabled, err := svc.Enabled("blog")
+	require.NoError(t, err)
+	assert.False(t, enabled)
+
+	body, code = send(http.MethodPost, "/api/v1/admin/sites/blog/secret", "")
+	require.Equal(t, http.StatusOK, code, body)
+	rotated := sites.Site{}
+	require.NoError(t, json.Unmarshal([]byte(body), &rotated))
+	assert.NotEqual(t, site.Secret, rotated.Secret)
+
+	_, code = send(http.MethodDelete, "/api/v1/admin/sites/remark42", "")
+	assert.Equal(t, http.StatusBadRequest, code, "static site can't be deleted")
+	_, code = send(http.MethodDelete, "/api/v1/admin/sites/blog", "")
+	assert.Equal(t, http.StatusOK, code)
+	_, code = send(http.MethodDelete, "/api/v1/admin/sites/blog", "")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	time.Sleep(time.Second) // admin routes limited to 10 req/s
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/sites", nil)
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "admin of the site rejected")
+}
+
+func TestAdmin_Storage(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	_, err := srv.DataService.Create(store.Comment{Text: "test test #1", Locator: store.Locator{SiteID: "remark42",
+		URL: "https://radio-t.com/blah"}, User: store.User{Name: "user1 name", ID: "user1"}})
+	require.NoError(t, err)
+
+	send := func(method, url string, body io.Reader) (*http.Response, []byte) {
+		req, e := http.NewRequest(method, ts.URL+url, body)
+		require.NoError(t, e)
+		req.SetBasicAuth("admin", "password")
+		resp, e := http.DefaultClient.Do(req)
+		require.NoError(t, e)
+		defer resp.Body.Close()
+		b, e := ioutil.ReadAll(resp.Body)
+		require.NoError(t, e)
+		return resp, b
+	}
+
+	resp, backup := send(http.MethodGet, "/api/v1/admin/storage/backup?site=remark42", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Disposition"), "attachment;filename=remark42-")
+	assert.True(t, len(backup) > 0)
+
+	resp, _ = send(http.MethodGet, "/api/v1/admin/storage/backup?site=unknown", nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, "", resp.Header.Get("Content-Disposition"))
+
+	_, err = srv.DataService.Create(store.Comment{Text: "test test #2", Locator: store.Locator{SiteID: "remark42",
+		URL: "https://radio-t.com/blah"}, User: store.User{Name: "user1 name", ID: "user1"}})
+	require.NoError(t, err)
+	resp, body := send(http.MethodPost, "/api/v1/admin/storage/restore?site=remark42", bytes.NewReader(backup))
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	count, err := srv.DataService.Count(store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count, "restored")
+
+	resp, body = send(http.MethodPost, "/api/v1/admin/storage/detach?site=remark42", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	res := R.JSON{}
+	require.NoError(t, json.Unmarshal(body, &res))
+	assert.Equal(t, "remark42", res["site"])
+	assert.NotEmpty(t, res["location"])
+	_, err = srv.DataService.Count(store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"})
+	assert.Error(t, err, "site detached")
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/storage/backup?site=remark42", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "admin of the site rejected")
+}
+
+func TestAdmin_TelegramWebhook(t *testing.T) {
+	_, srv, teardown := startupT(t)
+	defer teardown()
+
+	prefsStore := notify.NewEngineAdminPrefs(srv.DataService.Engine)
+	notifyService := notify.NewService(nil, 1)
+	notifyService.SetAdmins([]string{"admin@example.com"}, notify.AdminPrefs{}, prefsStore)
+	require.NoError(t, notifyService.SetAdminPrefs("remark42", "admin@example.com",
+		notify.AdminPrefs{Destinations: []string{"telegram"}, TelegramChat: "777"}))
+	srv.NotifyService = notifyService
+
+	ts := httptest.NewServer(srv.routes())
+	_, code := postTelegramUpdate(t, ts, "secret")
+	assert.Equal(t, http.StatusNotFound, code, "telegram moderation disabled")
+	ts.Close()
+
+	bot := &mockTelegramBot{moderators: []string{"123"}}
+	srv.Telegram = bot
+	ts = httptest.NewServer(srv.routes())
+	defer ts.Close()
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}
+	id1, err := srv.DataService.Create(store.Comment{Text: "held", Locator: locator, Pending: true,
+		User: store.User{ID: "user1", Name: "user1"}})
+	require.NoError(t, err)
+	id2, err := srv.DataService.Create(store.Comment{Text: "spam", Locator: locator, User: store.User{ID: "user2", Name: "user2"}})
+	require.NoError(t, err)
+
+	_, code = postTelegramUpdate(t, ts, "bad")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	tbl := []struct {
+		action notify.TelegramAction
+		err    error
+		result string
+	}{
+		{notify.TelegramAction{Action: notify.TelegramApprove, Locator: locator, CommentID: id1, AuthorID: "user1", UserID: "123"},
+			nil, "approved"},
+		{notify.TelegramAction{Action: notify.TelegramApprove, Locator: locator, CommentID: id1, AuthorID: "user1", UserID: "777"},
+			nil, "already approved"},
+		{notify.TelegramAction{Action: notify.TelegramDelete, Locator: locator, CommentID: id2, AuthorID: "user2", UserID: "456"},
+			nil, "not allowed"},
+		{notify.TelegramAction{Action: notify.TelegramDelete, Locator: locator, CommentID: "bad", AuthorID: "user2", UserID: "777"},
+			nil, "comment not found"},
+		{notify.TelegramAction{Action: notify.TelegramDelete, Locator: locator, CommentID: id2, AuthorID: "user2", UserID: "777"},
+			nil, "deleted"},
+		{notify.TelegramAction{Action: notify.TelegramBlock, Locator: locator, CommentID: id1, AuthorID: "user1", UserID: "123"},
+			nil, "blocked"},
+		{notify.TelegramAction{Action: notify.TelegramBlock, Locator: locator, CommentID: id1, AuthorID: "user1", UserID: "123"},
+			nil, "already blocked"},
+		{notify.TelegramAction{Action: notify.TelegramDelete, UserID: "123"}, notify.ErrTelegramExpired, "buttons expired, use admin ui"},
+	}
+	for i, tt := range tbl {
+		bot.action, bot.err = tt.action, tt.err
+		body, code := postTelegramUpdate(t, ts, "secret")
+		require.Equal(t, http.StatusOK, code, "case #%d", i)
+		assert.Contains(t, body, `"result":"`+tt.result+`"`, "case #%d", i)
+		assert.Equal(t, tt.result, bot.answers[len(bot.answers)-1], "case #%d, answered", i)
+	}
+
+	c, err := srv.DataService.Get(locator, id1, store.User{})
+	require.NoError(t, err)
+	assert.False(t, c.Pending, "approved")
+	assert.True(t, c.Deleted, "deleted with comments of blocked user")
+	assert.True(t, srv.DataService.IsBlocked("remark42", "user1"))
+	c, err = srv.DataService.Get(locator, id2, store.User{})
+	require.NoError(t, err)
+	assert.True(t, c.Deleted)
+	assert.False(t, srv.DataService.IsBlocked("remark42", "user2"))
+
+	bot.err = notify.ErrTelegramNoCallback
+	answers := len(bot.answers)
+	body, code := postTelegramUpdate(t, ts, "secret")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"status":"ignored"`)
+	assert.Equal(t, answers, len(bot.answers), "nothing to answer")
+}
+
+func postTelegramUpdate(t *testing.T, ts *httptest.Server, secret string) (body string, code int) {
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/telegram/webhook", strings.NewReader(`{"update_id":1}`))
+	require.NoError(t, err)
+	req.Header.Set(notify.TelegramSecretHeader, secret)
+	resp, err := sendReq(t, req, "")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(b), resp.StatusCode
+}
+
+type mockTelegramBot struct {
+	moderators []string
+	action     notify.TelegramAction
+	err        error
+	answers    []string
+}
+
+func (m *mockTelegramBot) CheckSecret(r *http.Request) bool {
+	return r.Header.Get(notify.TelegramSecretHeader) == "secret"
+}
+
+func (m *mockTelegramBot) ParseCallback(_ []byte) (notify.TelegramAction, error) {
+	return m.action, m.err
+}
+
+func (m *mockTelegramBot) Answer(_ context.Context, _ notify.TelegramAction, text string) error {
+	m.answers = append(m.answers, text)
+	return nil
+}
+
+func (m *mockTelegramBot) IsModerator(userID string) bool {
+	for _, id := range m.moderators {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAdmin_EmailModerate(t *testing.T) {
+	_, srv, teardown := startupT(t)
+	defer teardown()
+	notifyService := notify.NewService(nil, 1)
+	notifyService.SetAdmins([]string{"admin@example.com"}, notify.AdminPrefs{}, nil)
+	srv.NotifyService = notifyService
+	ts := httptest.NewServer(srv.routes())
+	defer ts.Close()
+	srv.adminRest.templates = dirFS("../../../templates")
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}
+	id1, err := srv.DataService.Create(store.Comment{Text: "held comment", Locator: locator, Pending: true,
+		User: store.User{ID: "user1", Name: "user one"}})
+	require.NoError(t, err)
+	id2, err := srv.DataService.Create(store.Comment{Text: "another comment", Locator: locator,
+		User: store.User{ID: "user1", Name: "user one"}})
+	require.NoError(t, err)
+
+	modToken := func(handshakeID string, ttl time.Duration) string {
+		claims := token.Claims{
+			Handshake: &token.Handshake{ID: handshakeID, From: locator.URL},
+			StandardClaims: jwt.StandardClaims{
+				Audience:  "remark42",
+				ExpiresAt: time.Now().Add(ttl).Unix(),
+				NotBefore: time.Now().Add(-1 * time.Minute).Unix(),
+				Issuer:    "remark42",
+			},
+		}
+		tkn, e := srv.Authenticator.TokenService().Token(claims)
+		require.NoError(t, e)
+		return tkn
+	}
+	postForm := func(tkn string) (string, int) {
+		resp, e := http.Post(ts.URL+"/email/moderate.html?tkn="+tkn, "application/x-www-form-urlencoded", nil)
+		require.NoError(t, e)
+		defer resp.Body.Close()
+		b, e := ioutil.ReadAll(resp.Body)
+		require.NoError(t, e)
+		return string(b), resp.StatusCode
+	}
+
+	body, code := get(t, ts.URL+"/email/moderate.html")
+	assert.Equal(t, http.StatusBadRequest, code, body)
+	body, code = get(t, ts.URL+"/email/moderate.html?tkn=jwt")
+	assert.Equal(t, http.StatusForbidden, code, body)
+	body, code = get(t, ts.URL+"/email/moderate.html?tkn="+modToken("moderate::approve::"+id1+"::admin@example.com", -time.Hour))
+	assert.Equal(t, http.StatusForbidden, code, "expired, %s", body)
+	body, code = get(t, ts.URL+"/email/moderate.html?tkn="+modToken("vote::user2::"+id1, time.Hour))
+	assert.Equal(t, http.StatusBadRequest, code, "vote token rejected, %s", body)
+	body, code = get(t, ts.URL+"/email/moderate.html?tkn="+modToken("moderate::spam::"+id1+"::admin@example.com", time.Hour))
+	assert.Equal(t, http.StatusBadRequest, code, body)
+	body, code = get(t, ts.URL+"/email/moderate.html?tkn="+modToken("moderate::approve::"+id1+"::user@example.com", time.Hour))
+	assert.Equal(t, http.StatusForbidden, code, "not an admin, %s", body)
+	body, code = get(t, ts.URL+"/email/moderate.html?tkn="+modToken("moderate::approve::bad-id::admin@example.com", time.Hour))
+	assert.Equal(t, http.StatusBadRequest, code, body)
+
+	tkn := modToken("moderate::approve::"+id1+"::ADMIN@example.com", time.Hour)
+	body, code = get(t, ts.URL+"/email/moderate.html?tkn="+tkn)
+	require.Equal(t, http.StatusOK, code, body)
+	assert.Contains(t, body, "Approve comment of user one?")
+	assert.Contains(t, body, "held comment")
+	assert.Contains(t, body, `<form method="post"`)
+	c, err := srv.DataService.Get(locator, id1, store.User{})
+	require.NoError(t, err)
+	assert.True(t, c.Pending, "not applied without confirmation")
+
+	body, code = postForm(tkn)
+	require.Equal(t, http.StatusOK, code, body)
+	assert.Contains(t, body, "Comment of user one: approved")
+	c, err = srv.DataService.Get(locator, id1, store.User{})
+	require.NoError(t, err)
+	assert.False(t, c.Pending)
+	body, _ = postForm(tkn)
+	assert.Contains(t, body, "Comment of user one: already approved")
+
+	body, code = postForm(modToken("moderate::delete::"+id2+"::admin@example.com", time.Hour))
+	require.Equal(t, http.StatusOK, code, body)
+	assert.Contains(t, body, "Comment of user one: deleted")
+
+	body, code = postForm(modToken("moderate::block::"+id1+"::admin@example.com", time.Hour))
+	require.Equal(t, http.StatusOK, code, body)
+	assert.Contains(t, body, "User user one: blocked")
+	assert.True(t, srv.DataService.IsBlocked("remark42", "user1"))
+}
+
+func TestAdmin_Trust(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/trust/rules?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "pre-moderation disabled")
+
+	svc := trust.NewService(trust.NewEngineStore(srv.DataService.Engine), srv.DataService, trust.Rules{Threshold: 2})
+	srv.adminRest.trust, srv.privRest.trust = svc, svc
+
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/trust/rules?site=remark42", strings.NewReader(`{"threshold": 1}`))
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	rules := trust.Rules{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&rules))
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, trust.Rules{Threshold: 1}, rules)
+
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/trust/rules?site=remark42", strings.NewReader(`{"threshold": -1}`))
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}
+	id1 := addComment(t, store.Comment{Text: "first comment", Locator: locator}, ts)
+	c, err := srv.DataService.Get(locator, id1, store.User{})
+	require.NoError(t, err)
+	assert.True(t, c.Pending, "comment of new user held")
+
+	res, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/trust/queue?site=remark42")
+	require.Equal(t, http.StatusOK, code, res)
+	queue := []struct {
+		Comment store.Comment `json:"comment"`
+		Trust   trust.Status  `json:"trust"`
+	}{}
+	require.NoError(t, json.Unmarshal([]byte(res), &queue))
+	require.Equal(t, 1, len(queue))
+	assert.Equal(t, id1, queue[0].Comment.ID)
+	assert.Equal(t, trust.Status{UserID: "dev", Level: trust.LevelAuto, Threshold: 1}, queue[0].Trust)
+
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/spam/"+id1+"?site=remark42&url=https://radio-t.com/blah&spam=0", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	res, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/trust/user/dev?site=remark42")
+	require.Equal(t, http.StatusOK, code, res)
+	status := trust.Status{}
+	require.NoError(t, json.Unmarshal([]byte(res), &status))
+	assert.Equal(t, trust.Status{UserID: "dev", Level: trust.LevelAuto, Approved: 1, Threshold: 1, Trusted: true}, status)
+
+	id2 := addComment(t, store.Comment{Text: "second comment", Locator: locator}, ts)
+	c, err = srv.DataService.Get(locator, id2, store.User{})
+	require.NoError(t, err)
+	assert.False(t, c.Pending, "comment of trusted user published")
+
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/trust/user/dev?site=remark42&level=untrusted", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	status = trust.Status{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.False(t, status.Trusted)
+
+	id3 := addComment(t, store.Comment{Text: "third comment", Locator: locator}, ts)
+	c, err = srv.DataService.Get(locator, id3, store.User{})
+	require.NoError(t, err)
+	assert.True(t, c.Pending, "comment of distrusted user held")
+
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/trust/user/dev?site=remark42&level=blah", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestAdmin_APITokens(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	send := func(method, uri, body string, headers map[string]string) (string, int) {
+		req, err := http.NewRequest(method, ts.URL+uri, strings.NewReader(body))
+		require.NoError(t, err)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := sendReq(t, req, "")
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return string(b), resp.StatusCode
+	}
+	adminAuth := map[string]string{"X-JWT": adminUmputunToken}
+
+	body, code := send(http.MethodGet, "/api/v1/admin/tokens?site=remark42", "", adminAuth)
+	assert.Equal(t, http.StatusBadRequest, code, "disabled, %s", body)
+
+	svc := apitokens.NewService(apitokens.NewEngineStore(srv.DataService.Engine, func() []string { return []string{"remark42"} }))
+	srv.APITokens, srv.adminRest.apiTokens = svc, svc
+
+	create := func(name string, scope apitokens.Scope) (apitokens.Token, map[string]string) {
+		body, code := send(http.MethodPost, "/api/v1/admin/tokens?site=remark42",
+			fmt.Sprintf(`{"name": %q, "scope": %q}`, name, scope), adminAuth)
+		require.Equal(t, http.StatusCreated, code, body)
+		res := struct {
+			Token apitokens.Token `json:"token"`
+			Value string          `json:"value"`
+		}{}
+		require.NoError(t, json.Unmarshal([]byte(body), &res))
+		return res.Token, map[string]string{apitokens.Header: res.Value}
+	}
+	readTkn, readAuth := create("bot", apitokens.Read)
+	assert.Equal(t, apitokens.Read, readTkn.Scope)
+	assert.Equal(t, "github_ef0f706a7", readTkn.CreatedBy)
+	_, modAuth := create("moderation bot", apitokens.Moderate)
+	_, fullAuth := create("ssg", apitokens.Full)
+
+	body, code = send(http.MethodPost, "/api/v1/admin/tokens?site=remark42", `{"name": "bad", "scope": "all"}`, adminAuth)
+	assert.Equal(t, http.StatusBadRequest, code, body)
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}
+	id := addComment(t, store.Comment{Text: "test test #1", Locator: locator}, ts)
+	newComment := `{"text": "from token", "locator": {"url": "https://radio-t.com/blah", "site": "remark42"}}`
+
+	// read scope
+	body, code = send(http.MethodGet, "/api/v1/user?site=remark42", "", readAuth)
+	require.Equal(t, http.StatusOK, code, body)
+	user := store.User{}
+	require.NoError(t, json.Unmarshal([]byte(body), &user))
+	assert.Equal(t, store.User{ID: "token_" + readTkn.ID, Name: "bot", Admin: true, SiteID: "remark42"}, user)
+	body, code = send(http.MethodGet, "/api/v1/admin/blocked?site=remark42", "", readAuth)
+	assert.Equal(t, http.StatusOK, code, body)
+	_, code = send(http.MethodPut, "/api/v1/admin/pin/"+id+"?site=remark42&url=https://radio-t.com/blah&pin=1", "", readAuth)
+	assert.Equal(t, http.StatusForbidden, code)
+	_, code = send(http.MethodPost, "/api/v1/comment", newComment, readAuth)
+	assert.Equal(t, http.StatusForbidden, code)
+	_, code = send(http.MethodGet, "/api/v1/user?site=other", "", readAuth)
+	assert.Equal(t, http.StatusForbidden, code, "token of other site")
+
+	// moderate scope
+	_, code = send(http.MethodPut, "/api/v1/admin/pin/"+id+"?site=remark42&url=https://radio-t.com/blah&pin=1", "", modAuth)
+	assert.Equal(t, http.StatusOK, code)
+	_, code = send(http.MethodPost, "/api/v1/comment", newComment, modAuth)
+	assert.Equal(t, http.StatusForbidden, code)
+	_, code = send(http.MethodGet, "/api/v1/admin/tokens?site=remark42", "", modAuth)
+	assert.Equal(t, http.StatusForbidden, code, "manage rights required")
+
+	// full scope
+	body, code = send(http.MethodPost, "/api/v1/comment", newComment, fullAuth)
+	assert.Equal(t, http.StatusCreated, code, body)
+	body, code = send(http.MethodGet, "/api/v1/admin/tokens?site=remark42", "", fullAuth)
+	require.Equal(t, http.StatusOK, code, body)
+	list := []apitokens.Token{}
+	require.NoError(t, json.Unmarshal([]byte(body), &list))
+	assert.Equal(t, 3, len(list))
+	_, code = send(http.MethodPost, "/api/v1/admin/tokens?site=remark42", `{"name": "more", "scope": "full"}`, fullAuth)
+	assert.Equal(t, http.StatusForbidden, code, "token can't create tokens")
+
+	_, code = send(http.MethodGet, "/api/v1/user?site=remark42", "", map[string]string{apitokens.Header: readTkn.ID + ".bad"})
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	body, code = send(http.MethodDelete, "/api/v1/admin/tokens/"+readTkn.ID+"?site=remark42", "", adminAuth)
+	require.Equal(t, http.StatusOK, code, body)
+	_, code = send(http.MethodGet, "/api/v1/user?site=remark42", "", readAuth)
+	assert.Equal(t, http.StatusUnauthorized, code, "revoked")
+	_, code = send(http.MethodDelete, "/api/v1/admin/tokens/"+readTkn.ID+"?site=remark42", "", adminAuth)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestAdmin_ExportCommentsAndUsers(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/export/comments?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}
+	id1 := addComment(t, store.Comment{Text: "first comment", Locator: locator}, ts)
+	id2 := addComment(t, store.Comment{Text: "second, with comma", Locator: locator}, ts)
+	id3 := addComment(t, store.Comment{Text: "other post", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/a"}}, ts)
+	require.NoError(t, srv.DataService.SetPending(locator, id2, true))
+
+	body, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/export/comments?site=remark42")
+	require.Equal(t, http.StatusOK, code, body)
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	require.Equal(t, 3, len(lines), body)
+	row := exportComment{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &row))
+	assert.Equal(t, id3, row.ID, "ordered by post url")
+	assert.Equal(t, "dev", row.UserID)
+	assert.Equal(t, service.StatusPublished, row.Status)
+	assert.Equal(t, "<p>other post</p>\n", row.Text)
+
+	readCSV := func(uri string) [][]string {
+		body, code := getWithAdminAuth(t, ts.URL+uri)
+		require.Equal(t, http.StatusOK, code, body)
+		records, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+		require.NoError(t, err)
+		require.True(t, len(records) > 0, "header always written")
+		assert.Equal(t, exportCommentColumns, records[0])
+		return records[1:]
+	}
+	records := readCSV("/api/v1/admin/export/comments?site=remark42&format=csv&skip=1&limit=1")
+	require.Equal(t, 1, len(records))
+	assert.Equal(t, []string{id1, "", "https://radio-t.com/blah", "", "dev", "developer one"}, records[0][:6])
+	assert.Equal(t, []string{"published", "0", "0", "<p>first comment</p>\n"}, records[0][7:])
+
+	records = readCSV("/api/v1/admin/export/comments?site=remark42&format=csv&status=pending")
+	require.Equal(t, 1, len(records))
+	assert.Equal(t, id2, records[0][0])
+	assert.Equal(t, "<p>second, with comma</p>\n", records[0][10])
+
+	records = readCSV("/api/v1/admin/export/comments?site=remark42&format=csv&user=nobody")
+	assert.Equal(t, 0, len(records))
+
+	body, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/export/users?site=remark42")
+	require.Equal(t, http.StatusOK, code, body)
+	user := service.UserSummary{}
+	require.NoError(t, json.Unmarshal([]byte(body), &user))
+	assert.Equal(t, "dev", user.ID)
+	assert.Equal(t, 3, user.Comments)
+	assert.Equal(t, 1, user.Pending)
+
+	body, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/export/users?site=remark42&format=csv&url=https://radio-t.com/a")
+	require.Equal(t, http.StatusOK, code, body)
+	lines = strings.Split(strings.TrimSpace(body), "\n")
+	require.Equal(t, 2, len(lines), body)
+	assert.Equal(t, strings.Join(exportUserColumns, ","), lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], "dev,developer one,1,0,0,0,0,"), lines[1])
+
+	body, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/export/comments?site=remark42&status=bad")
+	assert.Equal(t, http.StatusBadRequest, code, body)
+}
+
+func TestAdmin_exportParams(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/export/comments?site=remark42&user=u1&url=https://radio-t.com/blah&status=deleted"+
+		"&from=2021-05-01T10:00:00Z&to=2021-05-02T10:00:00Z&skip=10&limit=5&format=csv", nil)
+	filter, page, format, err := exportParams(r)
+	require.NoError(t, err)
+	assert.Equal(t, service.ExportFilter{UserID: "u1", URL: "https://radio-t.com/blah", Status: service.StatusDeleted,
+		From: time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC), To: time.Date(2021, 5, 2, 10, 0, 0, 0, time.UTC)}, filter)
+	assert.Equal(t, exportPage{skip: 10, limit: 5}, page)
+	assert.Equal(t, "csv", format)
+
+	_, page, format, err = exportParams(httptest.NewRequest(http.MethodGet, "/export/comments?site=remark42", nil))
+	require.NoError(t, err)
+	assert.Equal(t, exportPage{}, page, "unlimited")
+	assert.Equal(t, "jsonl", format)
+
+	for _, q := range []string{"status=bad", "format=xml", "from=yesterday", "to=1", "limit=0", "skip=-1"} {
+		_, _, _, err = exportParams(httptest.NewRequest(http.MethodGet, "/export/comments?site=remark42&"+q, nil))
+		assert.Error(t, err, q)
+	}
+}
+
+func TestAdmin_SlowOps(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	_, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/slow?site=remark42")
+	assert.Equal(t, http.StatusNotFound, code, "slow log disabled")
+
+	rec := slowlog.New(slowlog.Params{StoreThreshold: time.Millisecond})
+	srv.adminRest.slowLog = rec
+	rec.Store("Find", store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}, 10*time.Millisecond)
+	rec.Store("Info", store.Locator{SiteID: "remark42"}, 20*time.Millisecond)
+	rec.Store("Find", store.Locator{SiteID: "other"}, 30*time.Millisecond)
+
+	body, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/slow?site=remark42")
+	require.Equal(t, http.StatusOK, code, body)
+	ops := []slowlog.Op{}
+	require.NoError(t, json.Unmarshal([]byte(body), &ops))
+	require.Equal(t, 2, len(ops), "operations of other sites skipped")
+	assert.Equal(t, "Info", ops[0].Name)
+	assert.Equal(t, int64(20), ops[0].Duration)
+	assert.Equal(t, "https://radio-t.com/blah1", ops[1].URL)
+
+	body, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/slow?site=remark42&limit=1")
+	require.Equal(t, http.StatusOK, code, body)
+	require.NoError(t, json.Unmarshal([]byte(body), &ops))
+	assert.Equal(t, 1, len(ops))
+
+	body, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/slow?site=remark42&kind=response")
+	require.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, "[]\n", body)
+
+	_, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/slow?site=remark42&kind=bad")
+	assert.Equal(t, http.StatusBadRequest, code)
+	_, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/slow?site=remark42&limit=-1")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestAdmin_Retention(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	_, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/retention?site=remark42")
+	assert.Equal(t, http.StatusNotFound, code, "retention disabled")
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}
+	_, err := srv.DataService.Engine.Create(store.Comment{ID: "old-1", Text: "old comment", Locator: locator,
+		Timestamp: time.Now().AddDate(-1, 0, 0), User: store.User{ID: "user1", Name: "user1"}})
+	require.NoError(t, err)
+	_, err = srv.DataService.Engine.Create(store.Comment{ID: "new-1", Text: "new comment", Locator: locator,
+		Timestamp: time.Now(), User: store.User{ID: "user1", Name: "user1"}})
+	require.NoError(t, err)
+	srv.adminRest.retention = retention.NewService(srv.DataService, retention.Params{
+		Policy: func(string) (int, string) { return 30, "delete" }})
+
+	body, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/retention?site=remark42")
+	require.Equal(t, http.StatusOK, code, body)
+	report := retention.Report{}
+	require.NoError(t, json.Unmarshal([]byte(body), &report))
+	assert.Equal(t, 30, report.Days)
+	assert.Equal(t, retention.Delete, report.Action)
+	assert.True(t, report.DryRun)
+	require.Equal(t, 1, len(report.Comments))
+	assert.Equal(t, "old-1", report.Comments[0].ID)
+
+	c, err := srv.DataService.Get(locator, "old-1", store.User{})
+	require.NoError(t, err)
+	assert.False(t, c.Deleted, "nothing changed")
+
+	_, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/retention?site=bad")
+	assert.Equal(t, http.StatusInternalServerError, code)
+}
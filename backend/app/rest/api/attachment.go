@@ -0,0 +1,105 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	log "github.com/go-pkgz/lgr"
+	R "github.com/go-pkgz/rest"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/rest"
+)
+
+// POST /file - save attachment with form request, file in "file" field. Returns id and url of the file,
+// url ends with the original name of the file, used as name of downloaded file.
+func (s *private) saveFileCtrl(w http.ResponseWriter, r *http.Request) {
+	if !s.attachments.Enabled() {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("attachments disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	user := rest.MustGetUserInfo(r)
+
+	if err := r.ParseMultipartForm(5 * 1024 * 1024); err != nil { // 5M max memory, if bigger will make a file
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't parse multipart form", rest.ErrDecode)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get file from the request", rest.ErrDecode)
+		return
+	}
+	defer func() { _ = file.Close() }()
+
+	name := attachmentName(header.Filename, "")
+	id, err := s.attachments.Save(r.Context(), user.ID, name, file)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't save file", rest.ErrActionRejected)
+		return
+	}
+	render.JSON(w, r, R.JSON{"id": id, "name": name, "url": s.remarkURL + "/api/v1/file/" + id + "/" + url.PathEscape(name)})
+}
+
+// GET /file/{user}/{id}/{name} - download attachment as file with the name. Extension of the file
+// enforced, so it is always saved with the type it was uploaded with.
+func (s *public) loadFileCtrl(w http.ResponseWriter, r *http.Request) {
+	if !s.attachments.Enabled() {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("attachments disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	id := chi.URLParam(r, "user") + "/" + chi.URLParam(r, "id")
+	data, err := s.attachments.Load(id)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, err, "can't get file "+id, rest.ErrAssetNotFound)
+		return
+	}
+
+	// enforce client-side caching, files never change
+	etag := `"` + id + `"`
+	w.Header().Set("Etag", etag)
+	w.Header().Set("Cache-Control", "max-age=604800") // 7 days
+	if match := r.Header.Get("If-None-Match"); match != "" && strings.Contains(match, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// always downloaded, never rendered by browser in context of the site
+	w.Header().Set("Content-Type", s.attachments.ContentType(id, data))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment",
+		map[string]string{"filename": attachmentName(chi.URLParam(r, "name"), path.Ext(id))}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	if _, err = io.Copy(w, bytes.NewReader(data)); err != nil {
+		log.Printf("[WARN] can't send response to %s, %s", r.RemoteAddr, err)
+	}
+}
+
+// attachmentName cleans name of the file, with path and control characters removed. Extension added
+// if set and differs from extension of the name, "file" used for empty name.
+func attachmentName(name, ext string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/")) // windows path sent by some browsers
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' {
+			return -1
+		}
+		return r
+	}, name))
+	if name == "" || name == "." || name == ".." || name == "/" {
+		name = "file"
+	}
+	if ext != "" && !strings.EqualFold(path.Ext(name), ext) {
+		name += ext
+	}
+	return name
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store/attachment"
+	"github.com/umputun/remark42/backend/app/store/image"
+)
+
+func TestRest_Attachments(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	upload := func(name, content string) (code int, res map[string]string) {
+		bodyBuf := &bytes.Buffer{}
+		bodyWriter := multipart.NewWriter(bodyBuf)
+		fileWriter, err := bodyWriter.CreateFormFile("file", name)
+		require.NoError(t, err)
+		_, err = fileWriter.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, bodyWriter.Close())
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/file", bodyBuf)
+		require.NoError(t, err)
+		req.Header.Add("Content-Type", bodyWriter.FormDataContentType())
+		req.Header.Add("X-JWT", devToken)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		res = map[string]string{}
+		_ = json.NewDecoder(resp.Body).Decode(&res)
+		return resp.StatusCode, res
+	}
+
+	code, _ := upload("crash.log", "panic: something")
+	assert.Equal(t, http.StatusNotFound, code, "disabled")
+	resp, err := http.Get(ts.URL + "/api/v1/file/dev/abc.log/crash.log")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "disabled")
+
+	dir, err := ioutil.TempDir("", "attachments")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	files := attachment.NewService(&image.FileSystem{Location: dir + "/files", Staging: dir + "/staging"},
+		attachment.Params{Extensions: []string{".log", ".patch"}, MaxSize: 100, API: srv.RemarkURL + "/api/v1/file/",
+			EditDuration: 100 * time.Millisecond})
+	defer files.Close(context.Background())
+	srv.Attachments, srv.pubRest.attachments, srv.privRest.attachments = files, files, files
+	srv.DataService.AttachmentService = files
+
+	code, res := upload(`C:\logs\crash "1".log`, "panic: something")
+	require.Equal(t, http.StatusOK, code, res)
+	assert.Equal(t, "crash 1.log", res["name"])
+	assert.Equal(t, srv.RemarkURL+"/api/v1/file/"+res["id"]+"/crash%201.log", res["url"])
+	id := res["id"]
+
+	code, res = upload("script.js", "alert(1)")
+	assert.Equal(t, http.StatusBadRequest, code, res)
+	code, res = upload("big.log", string(make([]byte, 101)))
+	assert.Equal(t, http.StatusBadRequest, code, res)
+
+	resp, err = http.Get(ts.URL + "/api/v1/file/" + id + "/crash.html")
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "panic: something", string(body))
+	assert.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, `attachment; filename=crash.html.log`, resp.Header.Get("Content-Disposition"), "extension enforced")
+	assert.Equal(t, "nosniff", resp.Header.Get("X-Content-Type-Options"))
+	assert.Equal(t, "sandbox", resp.Header.Get("Content-Security-Policy"))
+
+	resp, err = http.Get(ts.URL + "/api/v1/file/dev/unknown.log/crash.log")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	postComment := func(text string) (code int, body string) {
+		resp, e := post(t, ts.URL+"/api/v1/comment",
+			fmt.Sprintf(`{"text": %q, "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`, text))
+		require.NoError(t, e)
+		b, e := ioutil.ReadAll(resp.Body)
+		require.NoError(t, e)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode, string(b)
+	}
+	code, msg := postComment(fmt.Sprintf("[log](%s/api/v1/file/dev/unknown.log/crash.log)", srv.RemarkURL))
+	assert.Equal(t, http.StatusBadRequest, code, "link to unknown file rejected, %s", msg)
+	code, msg = postComment(fmt.Sprintf("see [crash.log](%s/api/v1/file/%s/crash.log)", srv.RemarkURL, id))
+	require.Equal(t, http.StatusCreated, code, msg)
+	_, err = os.Stat(dir + "/files/" + id)
+	assert.Error(t, err, "not committed during edit duration")
+	assert.Eventually(t, func() bool {
+		_, err = os.Stat(dir + "/files/" + id)
+		return err == nil
+	}, 3*time.Second, 50*time.Millisecond, "committed")
+
+	resp, err = http.Get(ts.URL + "/api/v1/config?site=remark42")
+	require.NoError(t, err)
+	cnf := struct {
+		Attachments       []string `json:"attachments"`
+		MaxAttachmentSize int      `json:"max_attachment_size"`
+	}{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&cnf))
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, []string{".log", ".patch"}, cnf.Attachments)
+	assert.Equal(t, 100, cnf.MaxAttachmentSize)
+}
+
+func TestRest_attachmentName(t *testing.T) {
+	tbl := []struct {
+		name, ext, res string
+	}{
+		{"crash.log", "", "crash.log"},
+		{"crash.log", ".log", "crash.log"},
+		{"crash.LOG", ".log", "crash.LOG"},
+		{"crash.html", ".log", "crash.html.log"},
+		{"../../etc/passwd", "", "passwd"},
+		{`C:\Users\me\fix.patch`, ".patch", "fix.patch"},
+		{"bad\r\n\"name\".txt", "", "badname.txt"},
+		{"", ".log", "file.log"},
+		{"..", "", "file"},
+		{"отчёт.txt", "", "отчёт.txt"},
+	}
+	for i, tt := range tbl {
+		assert.Equal(t, tt.res, attachmentName(tt.name, tt.ext), "case #%d", i)
+	}
+}
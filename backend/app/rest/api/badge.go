@@ -0,0 +1,152 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha1" // nolint
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-chi/render"
+	cache "github.com/go-pkgz/lcw"
+	log "github.com/go-pkgz/lgr"
+	R "github.com/go-pkgz/rest"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/rest"
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+const (
+	badgeLabel      = "comments" // default label of badge
+	badgeColor      = "4c9ed9"   // default color of count
+	maxBadgeLabel   = 32         // max length of label in runes
+	badgeCharWidth  = 7          // approximate width of a character of badge font in pixels
+	badgeTextMargin = 10         // horizontal margin of each part of badge in pixels
+)
+
+var badgeColorRe = regexp.MustCompile(`^[0-9a-fA-F]{3}([0-9a-fA-F]{3})?$`)
+
+// badgeSignature signs site and url of the post with the site's key, so badges made for known posts only
+func badgeSignature(key, siteID, url string) string {
+	return store.HashValue(siteID+"::"+url, key)
+}
+
+// GET /badge?site=siteID&url=post-url&sig=signature&format=svg|json&label=comments&color=4c9ed9 - get badge with
+// number of comments of the post, svg image by default. Signature made by GET /admin/badge. Response has strong etag
+// and answered with 304 if matched, cached internally till the next comment created or deleted on the site.
+func (s *public) badgeCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID, postURL, format := r.URL.Query().Get("site"), r.URL.Query().Get("url"), r.URL.Query().Get("format")
+	if postURL == "" || (format != "" && format != "svg" && format != "json") {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("bad badge request"), "post url and format svg or json required",
+			rest.ErrDecode)
+		return
+	}
+	label, color := r.URL.Query().Get("label"), r.URL.Query().Get("color")
+	if label == "" {
+		label = badgeLabel
+	}
+	if color == "" {
+		color = badgeColor
+	}
+	if utf8.RuneCountInString(label) > maxBadgeLabel || !badgeColorRe.MatchString(color) {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.Errorf("bad label %q or color %q", label, color),
+			"bad label or color", rest.ErrDecode)
+		return
+	}
+	key, err := s.siteKey(siteID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get key of "+siteID, rest.ErrSiteNotFound)
+		return
+	}
+	if !hmac.Equal([]byte(r.URL.Query().Get("sig")), []byte(badgeSignature(key, siteID, postURL))) {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, errors.New("bad signature"), "badge not signed", rest.ErrNoAccess)
+		return
+	}
+
+	cacheKey := cache.NewKey(siteID).ID(URLKey(r)).Scopes(siteID, postsScope)
+	data, err := s.cache.Get(cacheKey, func() ([]byte, error) {
+		counts, e := s.dataService.Counts(siteID, []string{postURL})
+		if e != nil {
+			return nil, e
+		}
+		count := 0
+		if len(counts) > 0 {
+			count = counts[0].Count
+		}
+		if format == "json" {
+			return encodeJSONWithHTML(store.PostInfo{URL: postURL, Count: count})
+		}
+		return badgeSVG(label, fmt.Sprintf("%d", count), color), nil
+	})
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get count for "+siteID, rest.ErrSiteNotFound)
+		return
+	}
+
+	// embedded on pages of other origins, as image or fetched by script
+	if w.Header().Get("Access-Control-Allow-Origin") == "" {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	}
+	w.Header().Set("Cross-Origin-Resource-Policy", "cross-origin")
+	etag := fmt.Sprintf(`"%x"`, sha1.Sum(data)) // nolint
+	w.Header().Set("Etag", etag)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d, must-revalidate", int(countsMaxAge.Seconds())))
+	if match := r.Header.Get("If-None-Match"); match != "" && strings.Contains(match, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if format == "json" {
+		if err = R.RenderJSONFromBytes(w, r, data); err != nil {
+			log.Printf("[WARN] can't render badge of %s", postURL)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "image/svg+xml; charset=utf-8")
+	if _, err = w.Write(data); err != nil {
+		log.Printf("[WARN] can't render badge of %s", postURL)
+	}
+}
+
+// GET /badge?site=siteID&url=post-url - get signed urls of svg and json badges of the post
+func (a *admin) badgeCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID, postURL := r.URL.Query().Get("site"), r.URL.Query().Get("url")
+	if postURL == "" {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("missing url"), "post url required", rest.ErrDecode)
+		return
+	}
+	key, err := a.siteKey(siteID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get key of "+siteID, rest.ErrSiteNotFound)
+		return
+	}
+	sig := badgeSignature(key, siteID, postURL)
+	badgeURL := fmt.Sprintf("%s/api/v1/badge?site=%s&url=%s&sig=%s", a.remarkURL, url.QueryEscape(siteID),
+		url.QueryEscape(postURL), sig)
+	render.JSON(w, r, R.JSON{"sig": sig, "svg": badgeURL, "json": badgeURL + "&format=json"})
+}
+
+// badgeSVG makes flat badge with label on the left and value on colored background on the right
+func badgeSVG(label, value, color string) []byte {
+	labelWidth := utf8.RuneCountInString(label)*badgeCharWidth + 2*badgeTextMargin
+	valueWidth := utf8.RuneCountInString(value)*badgeCharWidth + 2*badgeTextMargin
+	width := labelWidth + valueWidth
+	label = html.EscapeString(label)
+
+	b := strings.Builder{}
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="20" role="img" aria-label="%s: %s">`,
+		width, label, value)
+	fmt.Fprintf(&b, `<title>%s: %s</title>`, label, value)
+	fmt.Fprintf(&b, `<rect width="%d" height="20" rx="3" fill="#555"/>`, width)
+	fmt.Fprintf(&b, `<rect x="%d" width="%d" height="20" rx="3" fill="#%s"/>`, labelWidth, valueWidth, color)
+	fmt.Fprintf(&b, `<rect x="%d" width="4" height="20" fill="#%s"/>`, labelWidth, color)
+	b.WriteString(`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">`)
+	fmt.Fprintf(&b, `<text x="%d" y="14">%s</text>`, labelWidth/2, label)
+	fmt.Fprintf(&b, `<text x="%d" y="14">%s</text>`, labelWidth+valueWidth/2, value)
+	b.WriteString(`</g></svg>`)
+	return []byte(b.String())
+}
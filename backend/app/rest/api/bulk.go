@@ -0,0 +1,251 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/render"
+	cache "github.com/go-pkgz/lcw"
+	log "github.com/go-pkgz/lgr"
+
+	"github.com/umputun/remark42/backend/app/audit"
+	"github.com/umputun/remark42/backend/app/notify"
+	"github.com/umputun/remark42/backend/app/plugin"
+	"github.com/umputun/remark42/backend/app/rest"
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/service"
+)
+
+const maxBulkComments = 1000 // max number of comments moderated in one call
+
+// bulkAction is a moderation action applied to many comments at once
+type bulkAction string
+
+const (
+	bulkApprove bulkAction = "approve" // pending comment approved
+	bulkDelete  bulkAction = "delete"  // comment deleted, author notified with reason
+	bulkSpam    bulkAction = "spam"    // comment deleted as spam, reported to spam checker
+)
+
+// bulkRequest selects comments by list of ids or by filter, one of them required
+type bulkRequest struct {
+	Action   bulkAction `json:"action"`
+	Reason   string     `json:"reason"`
+	DryRun   bool       `json:"dry_run"`
+	Comments []struct {
+		ID  string `json:"id"`
+		URL string `json:"url"`
+	} `json:"comments"`
+	Filter struct {
+		UserID  string    `json:"user"`
+		URL     string    `json:"url"`
+		From    time.Time `json:"from"`
+		To      time.Time `json:"to"`
+		Pending bool      `json:"pending"`
+	} `json:"filter"`
+}
+
+// bulkFailure is a comment failed to moderate
+type bulkFailure struct {
+	ID    string `json:"id"`
+	URL   string `json:"url"`
+	Error string `json:"error"`
+}
+
+// bulkResult of moderation, skipped comments didn't need the action, i.e. approve of not pending comment
+type bulkResult struct {
+	Action  bulkAction    `json:"action"`
+	Matched int           `json:"matched"`
+	Done    int           `json:"done"`
+	Skipped int           `json:"skipped"`
+	Failed  []bulkFailure `json:"failed"`
+	More    bool          `json:"more,omitempty"` // filter matched more than processed, call again for the rest
+	DryRun  bool          `json:"dry_run,omitempty"`
+}
+
+// POST /bulk?site=siteID - apply moderation action to comments, body is {"action": "approve|delete|spam", "reason": "text",
+// "comments": [{"id": "c1", "url": "post-url"}]} or {"action": "delete", "filter": {"user": "u1", "url": "post-url",
+// "from": RFC3339, "to": RFC3339, "pending": true}}. Up to 1000 comments per call, failures reported per comment.
+// With "dry_run" comments matched but not moderated.
+func (a *admin) bulkModerationCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID := r.URL.Query().Get("site")
+	req := bulkRequest{}
+	if err := render.DecodeJSON(http.MaxBytesReader(w, r.Body, hardBodyLimit), &req); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't bind bulk request", rest.ErrDecode)
+		return
+	}
+	if req.Action != bulkApprove && req.Action != bulkDelete && req.Action != bulkSpam {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, fmt.Errorf("unknown action %q", req.Action),
+			"action should be approve, delete or spam", rest.ErrActionRejected)
+		return
+	}
+	filter := service.CommentFilter{UserID: req.Filter.UserID, URL: req.Filter.URL, From: req.Filter.From,
+		To: req.Filter.To, Pending: req.Filter.Pending}
+	hasFilter := filter != service.CommentFilter{}
+	if len(req.Comments) == 0 == !hasFilter {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("comments or filter required"),
+			"either comments or filter should be set", rest.ErrActionRejected)
+		return
+	}
+	if len(req.Comments) > maxBulkComments {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, fmt.Errorf("too many comments, %d > %d", len(req.Comments), maxBulkComments),
+			"too many comments", rest.ErrActionRejected)
+		return
+	}
+
+	res := bulkResult{Action: req.Action, Failed: []bulkFailure{}, DryRun: req.DryRun}
+	comments := []store.Comment{}
+	if hasFilter {
+		matched, err := a.dataService.Matched(siteID, filter, maxBulkComments+1)
+		if err != nil {
+			rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get comments", rest.ErrInternal)
+			return
+		}
+		if len(matched) > maxBulkComments {
+			matched, res.More = matched[:maxBulkComments], true
+		}
+		comments = matched
+	}
+	for _, ref := range req.Comments {
+		c, err := a.dataService.Get(store.Locator{SiteID: siteID, URL: ref.URL}, ref.ID, store.User{})
+		if err != nil {
+			res.Failed = append(res.Failed, bulkFailure{ID: ref.ID, URL: ref.URL, Error: err.Error()})
+			continue
+		}
+		comments = append(comments, c)
+	}
+	res.Matched = len(comments)
+	if req.DryRun {
+		render.JSON(w, r, res)
+		return
+	}
+
+	scopes := []string{siteID, lastCommentsScope}
+	for _, c := range comments {
+		c.Locator.SiteID = siteID
+		done, err := a.moderate(r, req.Action, c, req.Reason)
+		if err != nil {
+			res.Failed = append(res.Failed, bulkFailure{ID: c.ID, URL: c.Locator.URL, Error: err.Error()})
+			continue
+		}
+		if !done {
+			res.Skipped++
+			continue
+		}
+		res.Done++
+		scopes = append(scopes, c.Locator.URL, c.User.ID)
+	}
+	if res.Done > 0 {
+		a.cache.Flush(cache.Flusher(siteID).Scopes(scopes...))
+	}
+	log.Printf("[INFO] bulk %s on %s, matched %d, done %d, skipped %d, failed %d", req.Action, siteID, res.Matched,
+		res.Done, res.Skipped, len(res.Failed))
+	render.JSON(w, r, res)
+}
+
+// moderate applies the action to the comment the same way as single comment endpoints do, with notifications,
+// spam feedback and audit. Returns false if the comment doesn't need the action. Cache not flushed.
+func (a *admin) moderate(r *http.Request, action bulkAction, c store.Comment, reason string) (bool, error) {
+	switch action {
+	case bulkApprove:
+		if !c.Pending || c.Deleted {
+			return false, nil
+		}
+		if a.spamService != nil {
+			if e := a.spamService.Feedback(c, false); e != nil {
+				log.Printf("[WARN] can't send spam feedback for %s, %v", c.ID, e)
+			}
+		}
+		if err := a.dataService.SetPending(c.Locator, c.ID, false); err != nil {
+			return false, err
+		}
+		a.record(r, audit.Entry{SiteID: c.Locator.SiteID, Action: audit.ActionApprove, Target: c.ID, URL: c.Locator.URL})
+		a.countApproved(c)
+		if a.notifyService != nil {
+			c.Pending = false
+			a.notifyService.Submit(notify.Request{Comment: c, Approved: true})
+		}
+		a.notifyModeration(c, notify.ModerationApproved, reason)
+	case bulkDelete:
+		if c.Deleted {
+			return false, nil
+		}
+		user := rest.MustGetUserInfo(r)
+		ev := plugin.Event{Hook: plugin.HookCommentDeleting, SiteID: c.Locator.SiteID, Comment: &c, User: &user, Reason: reason}
+		if _, err := a.plugins.Before(ev); err != nil {
+			return false, err
+		}
+		if err := a.dataService.Delete(c.Locator, c.ID, store.SoftDelete); err != nil {
+			return false, err
+		}
+		a.metrics.CommentDeleted(c.Locator.SiteID)
+		a.notifyModeration(c, notify.ModerationDeleted, reason)
+		a.plugins.After(plugin.Event{Hook: plugin.HookCommentDelete, SiteID: c.Locator.SiteID, Comment: &c, User: &user})
+		a.record(r, audit.Entry{SiteID: c.Locator.SiteID, Action: audit.ActionDelete, Target: c.ID, URL: c.Locator.URL,
+			Reason: reason})
+	case bulkSpam:
+		if c.Deleted {
+			return false, nil
+		}
+		if a.spamService != nil {
+			if e := a.spamService.Feedback(c, true); e != nil {
+				log.Printf("[WARN] can't send spam feedback for %s, %v", c.ID, e)
+			}
+		}
+		if err := a.dataService.Delete(c.Locator, c.ID, store.SoftDelete); err != nil {
+			return false, err
+		}
+		a.metrics.CommentDeleted(c.Locator.SiteID)
+		a.notifyModeration(c, notify.ModerationRejected, "spam")
+		a.record(r, audit.Entry{SiteID: c.Locator.SiteID, Action: audit.ActionSpam, Target: c.ID, URL: c.Locator.URL})
+	}
+	return true, nil
+}
+
+// quickModerate applies action of telegram button or email link, approve, delete or block, the same way admin
+// endpoints do, and returns text of the result. Author of the comment blocked permanently.
+func (a *admin) quickModerate(r *http.Request, action string, locator store.Locator, commentID, authorID string) string {
+	switch action {
+	case "approve", "delete":
+		comment, err := a.dataService.Get(locator, commentID, store.User{})
+		if err != nil {
+			log.Printf("[WARN] can't get comment %s, %v", commentID, err)
+			return "comment not found"
+		}
+		bulk, result := bulkApprove, "approved"
+		if action == "delete" {
+			bulk, result = bulkDelete, "deleted"
+		}
+		done, err := a.moderate(r, bulk, comment, "")
+		if err != nil {
+			log.Printf("[WARN] can't %s comment %s, %v", action, commentID, err)
+			return "failed, " + err.Error()
+		}
+		if !done {
+			return "already " + result
+		}
+		a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.SiteID, locator.URL, lastCommentsScope, comment.User.ID))
+		return result
+	case "block":
+		if a.dataService.IsBlocked(locator.SiteID, authorID) {
+			return "already blocked"
+		}
+		user := store.User{ID: authorID}
+		if _, err := a.plugins.Before(plugin.Event{Hook: plugin.HookUserBlock, SiteID: locator.SiteID, User: &user}); err != nil {
+			return "failed, " + err.Error()
+		}
+		if err := a.dataService.SetBlock(locator.SiteID, authorID, true, 0); err != nil {
+			log.Printf("[WARN] can't block user %s, %v", authorID, err)
+			return "failed, " + err.Error()
+		}
+		if err := a.dataService.DeleteUser(locator.SiteID, authorID, store.SoftDelete); err != nil {
+			log.Printf("[WARN] can't delete comments for blocked user %s on site %s, %v", authorID, locator.SiteID, err)
+		}
+		a.record(r, audit.Entry{SiteID: locator.SiteID, Action: audit.ActionBlock, Target: authorID})
+		a.cache.Flush(cache.Flusher(locator.SiteID).Scopes(authorID, locator.SiteID, lastCommentsScope))
+		return "blocked"
+	}
+	return "unknown action"
+}
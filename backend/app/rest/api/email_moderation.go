@@ -0,0 +1,123 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha1" //nolint:gosec // used for user id only, the same way auth providers do
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/render"
+	"github.com/go-pkgz/auth/token"
+	log "github.com/go-pkgz/lgr"
+
+	"github.com/umputun/remark42/backend/app/rest"
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// GET/POST /email/moderate.html?tkn=jwt - approve, delete or block from the link in admin notification email.
+// Short-lived token issued for the admin email, the comment and the action. GET shows the comment with confirmation
+// form, as mail scanners follow links in emails, the action applied on POST of the form.
+func (a *admin) emailModerateCtrl(w http.ResponseWriter, r *http.Request) {
+	tkn := r.URL.Query().Get("tkn")
+	if tkn == "" {
+		rest.SendErrorHTML(w, r, http.StatusBadRequest,
+			errors.New("missing parameter"), "token parameter is required", rest.ErrInternal, a.templates)
+		return
+	}
+
+	modClaims, err := a.authenticator.TokenService().Parse(tkn)
+	if err != nil {
+		rest.SendErrorHTML(w, r, http.StatusForbidden, err, "failed to verify moderation token", rest.ErrInternal, a.templates)
+		return
+	}
+	if a.authenticator.TokenService().IsExpired(modClaims) {
+		rest.SendErrorHTML(w, r, http.StatusForbidden,
+			errors.New("expired"), "failed to verify moderation token", rest.ErrInternal, a.templates)
+		return
+	}
+
+	// handshake id is moderate::action::commentID::email, post url in handshake's from
+	elems := []string{}
+	if modClaims.Handshake != nil {
+		elems = strings.Split(modClaims.Handshake.ID, "::")
+	}
+	if len(elems) != 4 || elems[0] != "moderate" || modClaims.Handshake.From == "" {
+		rest.SendErrorHTML(w, r, http.StatusBadRequest,
+			errors.New("bad moderation token"), "invalid handshake token", rest.ErrInternal, a.templates)
+		return
+	}
+	action, commentID, email := elems[1], elems[2], elems[3]
+	locator := store.Locator{SiteID: modClaims.Audience, URL: modClaims.Handshake.From}
+	switch action {
+	case "approve", "delete", "block":
+	default:
+		rest.SendErrorHTML(w, r, http.StatusBadRequest,
+			errors.New("bad moderation token"), "invalid moderation action", rest.ErrInternal, a.templates)
+		return
+	}
+
+	if !a.isAdminEmail(locator.SiteID, email) { // token of removed admin rejected
+		rest.SendErrorHTML(w, r, http.StatusForbidden, errors.New("rejected"), "not an admin email",
+			rest.ErrNoAccess, a.templates)
+		return
+	}
+
+	comment, err := a.dataService.Get(locator, commentID, store.User{})
+	if err != nil {
+		rest.SendErrorHTML(w, r, http.StatusBadRequest, err, "can't get comment", rest.ErrCommentNotFound, a.templates)
+		return
+	}
+
+	result := ""
+	if r.Method == http.MethodPost {
+		moderator := store.User{ID: "email_" + token.HashID(sha1.New(), email), Name: email, Admin: true}
+		log.Printf("[INFO] email %s of comment %s by %s", action, commentID, email)
+		result = a.quickModerate(rest.SetUserInfo(r, moderator), action, locator, commentID, comment.User.ID)
+	}
+
+	tmplFile, err := a.templates.ReadFile("email_moderate.html.tmpl")
+	if err != nil {
+		rest.SendErrorHTML(w, r, http.StatusInternalServerError, err, "can't read moderation template", rest.ErrInternal, a.templates)
+		return
+	}
+	tmpl, err := template.New("moderate").Parse(string(tmplFile))
+	if err != nil {
+		rest.SendErrorHTML(w, r, http.StatusInternalServerError, err, "can't parse moderation template", rest.ErrInternal, a.templates)
+		return
+	}
+	msg := bytes.Buffer{}
+	tmplData := struct {
+		Action      string
+		UserName    string
+		CommentText template.HTML
+		CommentLink string
+		Token       string
+		Result      string
+	}{Action: action, UserName: comment.User.Name, CommentText: template.HTML(comment.Text), //nolint:gosec // sanitized by store
+		CommentLink: a.links.Comment(locator, commentID), Token: tkn, Result: result}
+	if err = tmpl.Execute(&msg, tmplData); err != nil {
+		rest.SendErrorHTML(w, r, http.StatusInternalServerError, err, "can't execute moderation template", rest.ErrInternal, a.templates)
+		return
+	}
+	render.HTML(w, r, msg.String())
+}
+
+// isAdminEmail checks if email is one of admin emails notified about new comments
+func (a *admin) isAdminEmail(siteID, email string) bool {
+	if a.notifyService == nil {
+		return false
+	}
+	prefs, err := a.notifyService.AdminPrefs(siteID)
+	if err != nil {
+		log.Printf("[WARN] can't get notification preferences of admins, %v", err)
+		return false
+	}
+	for e := range prefs {
+		if strings.EqualFold(e, email) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,208 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+
+	"github.com/umputun/remark42/backend/app/rest"
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/service"
+)
+
+// errPageEnd stops export after the last row of the page
+var errPageEnd = errors.New("end of page")
+
+// exportComment is a row of comments export, the same fields in csv and json lines
+type exportComment struct {
+	ID       string                `json:"id"`
+	ParentID string                `json:"pid"`
+	URL      string                `json:"url"`
+	Title    string                `json:"title"`
+	UserID   string                `json:"user_id"`
+	UserName string                `json:"user_name"`
+	Time     time.Time             `json:"time"`
+	Status   service.CommentStatus `json:"status"`
+	Score    int                   `json:"score"`
+	Reports  int                   `json:"reports"`
+	Text     string                `json:"text"`
+}
+
+var exportCommentColumns = []string{"id", "pid", "url", "title", "user_id", "user_name", "time", "status", "score",
+	"reports", "text"}
+
+var exportUserColumns = []string{"id", "name", "comments", "pending", "deleted", "reported", "score", "first", "last",
+	"verified", "blocked"}
+
+// GET /export/comments?site=siteID&format=csv|jsonl&url=post-url&user=userID&status=published|pending|deleted|reported
+// &from=RFC3339&to=RFC3339&skip=0&limit=1000 - stream comments matched by filters as csv or json lines (default),
+// ordered by post url and time. Deleted comments included unless status set. Paged with skip and limit,
+// page shorter than the limit is the last one.
+func (a *admin) exportCommentsCtrl(w http.ResponseWriter, r *http.Request) {
+	filter, page, format, err := exportParams(r)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "bad export params", rest.ErrDecode)
+		return
+	}
+	siteID := r.URL.Query().Get("site")
+	out := newExportWriter(w, siteID, "comments", format, exportCommentColumns)
+	err = a.dataService.ExportComments(siteID, filter, func(c store.Comment) error {
+		if ok, e := page.take(); !ok {
+			return e
+		}
+		row := exportComment{ID: c.ID, ParentID: c.ParentID, URL: c.Locator.URL, Title: c.PostTitle, UserID: c.User.ID,
+			UserName: c.User.Name, Time: c.Timestamp, Status: service.StatusOf(c), Score: c.Score, Reports: len(c.Reports),
+			Text: c.Text}
+		return out.write(row, []string{row.ID, row.ParentID, row.URL, row.Title, row.UserID, row.UserName,
+			row.Time.Format(time.RFC3339), string(row.Status), strconv.Itoa(row.Score), strconv.Itoa(row.Reports), row.Text})
+	})
+	out.finish(r, err)
+}
+
+// GET /export/users?site=siteID&format=csv|jsonl&url=post-url&user=userID&status=published|pending|deleted|reported
+// &from=RFC3339&to=RFC3339&skip=0&limit=1000 - stream users with comments matched by filters as csv or json lines
+// (default), with numbers of their comments, sorted by user id. Paged with skip and limit.
+func (a *admin) exportUsersCtrl(w http.ResponseWriter, r *http.Request) {
+	filter, page, format, err := exportParams(r)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "bad export params", rest.ErrDecode)
+		return
+	}
+	siteID := r.URL.Query().Get("site")
+	users, err := a.dataService.ExportUsers(siteID, filter)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't export users", rest.ErrInternal)
+		return
+	}
+	out := newExportWriter(w, siteID, "users", format, exportUserColumns)
+	for _, u := range users {
+		ok, e := page.take()
+		if e != nil {
+			break
+		}
+		if !ok {
+			continue
+		}
+		err = out.write(u, []string{u.ID, u.Name, strconv.Itoa(u.Comments), strconv.Itoa(u.Pending),
+			strconv.Itoa(u.Deleted), strconv.Itoa(u.Reported), strconv.Itoa(u.Score), u.First.Format(time.RFC3339),
+			u.Last.Format(time.RFC3339), strconv.FormatBool(u.Verified), strconv.FormatBool(u.Blocked)})
+		if err != nil {
+			break
+		}
+	}
+	out.finish(r, err)
+}
+
+// exportParams makes filter, page and format of export from query params
+func exportParams(r *http.Request) (filter service.ExportFilter, page exportPage, format string, err error) {
+	q := r.URL.Query()
+	filter = service.ExportFilter{UserID: q.Get("user"), URL: q.Get("url"), Status: service.CommentStatus(q.Get("status"))}
+	if filter.Status != "" && !filter.Status.Valid() {
+		return filter, page, "", fmt.Errorf("bad status %q", filter.Status)
+	}
+	if v := q.Get("from"); v != "" {
+		if filter.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, page, "", fmt.Errorf("bad from time %q: %w", v, err)
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if filter.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, page, "", fmt.Errorf("bad to time %q: %w", v, err)
+		}
+	}
+	if v := q.Get("skip"); v != "" {
+		if page.skip, err = strconv.Atoi(v); err != nil || page.skip < 0 {
+			return filter, page, "", fmt.Errorf("bad skip %q", v)
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if page.limit, err = strconv.Atoi(v); err != nil || page.limit <= 0 {
+			return filter, page, "", fmt.Errorf("bad limit %q", v)
+		}
+	}
+	format = q.Get("format")
+	if format == "" {
+		format = "jsonl"
+	}
+	if format != "jsonl" && format != "csv" {
+		return filter, page, "", fmt.Errorf("bad format %q", format)
+	}
+	return filter, page, format, nil
+}
+
+// exportPage selects rows of export by skip and limit, unlimited if limit is 0
+type exportPage struct {
+	skip, limit int
+	seen        int
+}
+
+// take checks if the next row is on the page, errPageEnd returned for the row after the page
+func (p *exportPage) take() (bool, error) {
+	p.seen++
+	if p.seen <= p.skip {
+		return false, nil
+	}
+	if p.limit > 0 && p.seen > p.skip+p.limit {
+		return false, errPageEnd
+	}
+	return true, nil
+}
+
+// exportWriter writes rows of export as csv with header or as json lines
+type exportWriter struct {
+	w       http.ResponseWriter
+	csv     *csv.Writer
+	enc     *json.Encoder
+	columns []string
+	rows    int
+}
+
+func newExportWriter(w http.ResponseWriter, siteID, kind, format string, columns []string) *exportWriter {
+	name := fmt.Sprintf("%s-%s-%s.%s", siteID, kind, time.Now().Format("20060102"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		return &exportWriter{w: w, csv: csv.NewWriter(w), columns: columns}
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson; charset=utf-8")
+	return &exportWriter{w: w, enc: json.NewEncoder(w)}
+}
+
+// write the row, v used for json lines and values for csv
+func (e *exportWriter) write(v interface{}, values []string) error {
+	e.rows++
+	if e.enc != nil {
+		return e.enc.Encode(v)
+	}
+	if e.rows == 1 {
+		if err := e.csv.Write(e.columns); err != nil {
+			return err
+		}
+	}
+	return e.csv.Write(values) // buffered writer of csv writes through when full, so rows streamed
+}
+
+// finish the export, error reported as response if nothing written yet, logged otherwise
+func (e *exportWriter) finish(r *http.Request, err error) {
+	if err != nil && !errors.Is(err, errPageEnd) {
+		if e.rows == 0 {
+			e.w.Header().Del("Content-Disposition")
+			rest.SendErrorJSON(e.w, r, http.StatusInternalServerError, err, "can't export", rest.ErrInternal)
+			return
+		}
+		log.Printf("[WARN] export of %s interrupted after %d rows, %v", r.URL.Path, e.rows, err)
+	}
+	if e.csv == nil {
+		return
+	}
+	if e.rows == 0 {
+		_ = e.csv.Write(e.columns) // header only for empty export
+	}
+	e.csv.Flush()
+}
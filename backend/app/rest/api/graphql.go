@@ -0,0 +1,431 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/render"
+	log "github.com/go-pkgz/lgr"
+	"github.com/graphql-go/graphql"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/rest"
+	"github.com/umputun/remark42/backend/app/settings"
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/service"
+)
+
+// graphQL provides read-only GraphQL api for comments, trees, users, votes and counts.
+// Lists paginated with opaque cursors, "first" items returned after "after" cursor.
+type graphQL struct {
+	dataService pubStore
+	settings    *settings.Service
+	schema      graphql.Schema
+}
+
+type gqlUserKey struct{}
+
+// page of connection, with cursor-based pagination
+type gqlConnection struct {
+	Edges      []gqlEdge   `json:"edges"`
+	PageInfo   gqlPageInfo `json:"pageInfo"`
+	TotalCount int         `json:"totalCount"`
+}
+
+type gqlEdge struct {
+	Cursor string      `json:"cursor"`
+	Node   interface{} `json:"node"`
+}
+
+type gqlPageInfo struct {
+	HasNextPage bool   `json:"hasNextPage"`
+	EndCursor   string `json:"endCursor"`
+}
+
+const (
+	gqlDefaultPage = 20
+	gqlMaxPage     = 100
+)
+
+// newGraphQL makes GraphQL api with schema for data service
+func newGraphQL(dataService pubStore, siteSettings *settings.Service) (*graphQL, error) {
+	res := &graphQL{dataService: dataService, settings: siteSettings}
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{Query: res.queryType()})
+	if err != nil {
+		return nil, errors.Wrap(err, "can't make graphql schema")
+	}
+	res.schema = schema
+	return res, nil
+}
+
+// GET /graphql?query=...&variables={json} or POST /graphql with {"query": "...", "variables": {}, "operationName": ""}
+func (g *graphQL) handler(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		Query         string                 `json:"query"`
+		Variables     map[string]interface{} `json:"variables"`
+		OperationName string                 `json:"operationName"`
+	}{}
+
+	switch r.Method {
+	case http.MethodPost:
+		if err := render.DecodeJSON(http.MaxBytesReader(w, r.Body, hardBodyLimit), &req); err != nil {
+			rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't bind graphql request", rest.ErrDecode)
+			return
+		}
+	default:
+		req.Query, req.OperationName = r.URL.Query().Get("query"), r.URL.Query().Get("operationName")
+		if vars := r.URL.Query().Get("variables"); vars != "" {
+			if err := render.DecodeJSON(strings.NewReader(vars), &req.Variables); err != nil {
+				rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't bind graphql variables", rest.ErrDecode)
+				return
+			}
+		}
+	}
+
+	ctx := context.WithValue(r.Context(), gqlUserKey{}, rest.GetUserOrEmpty(r))
+	res := graphql.Do(graphql.Params{Schema: g.schema, RequestString: req.Query, VariableValues: req.Variables,
+		OperationName: req.OperationName, Context: ctx})
+	if res.HasErrors() {
+		log.Printf("[DEBUG] graphql errors %v", res.Errors)
+	}
+	render.JSON(w, r, res)
+}
+
+func (g *graphQL) queryType() *graphql.Object {
+	userType := graphql.NewObject(graphql.ObjectConfig{Name: "User", Fields: graphql.Fields{
+		"id":       &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"name":     &graphql.Field{Type: graphql.String},
+		"picture":  &graphql.Field{Type: graphql.String},
+		"admin":    &graphql.Field{Type: graphql.Boolean},
+		"block":    &graphql.Field{Type: graphql.Boolean},
+		"verified": &graphql.Field{Type: graphql.Boolean},
+	}})
+
+	locatorType := graphql.NewObject(graphql.ObjectConfig{Name: "Locator", Fields: graphql.Fields{
+		"site": &graphql.Field{Type: graphql.String},
+		"url":  &graphql.Field{Type: graphql.String},
+	}})
+
+	editType := graphql.NewObject(graphql.ObjectConfig{Name: "Edit", Fields: graphql.Fields{
+		"time":    &graphql.Field{Type: graphql.DateTime},
+		"summary": &graphql.Field{Type: graphql.String},
+	}})
+
+	commentType := graphql.NewObject(graphql.ObjectConfig{Name: "Comment", Fields: graphql.Fields{
+		"id":          &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		"pid":         &graphql.Field{Type: graphql.String},
+		"text":        &graphql.Field{Type: graphql.String},
+		"orig":        &graphql.Field{Type: graphql.String},
+		"user":        &graphql.Field{Type: userType},
+		"locator":     &graphql.Field{Type: locatorType},
+		"score":       &graphql.Field{Type: graphql.Int},
+		"vote":        &graphql.Field{Type: graphql.Int, Description: "vote of the current user, -1/1/0"},
+		"controversy": &graphql.Field{Type: graphql.Float},
+		"quality":     &graphql.Field{Type: graphql.Float},
+		"best":        &graphql.Field{Type: graphql.Float},
+		"time":        &graphql.Field{Type: graphql.DateTime},
+		"edit":        &graphql.Field{Type: editType},
+		"pin":         &graphql.Field{Type: graphql.Boolean},
+		"delete":      &graphql.Field{Type: graphql.Boolean},
+		"pending":     &graphql.Field{Type: graphql.Boolean},
+		"title":       &graphql.Field{Type: graphql.String},
+	}})
+
+	nodeType := graphql.NewObject(graphql.ObjectConfig{Name: "Node", Fields: graphql.Fields{
+		"comment": &graphql.Field{Type: commentType},
+	}})
+	nodeType.AddFieldConfig("replies", &graphql.Field{Type: graphql.NewList(nodeType)})
+
+	postInfoType := graphql.NewObject(graphql.ObjectConfig{Name: "PostInfo", Fields: graphql.Fields{
+		"url":        &graphql.Field{Type: graphql.String},
+		"count":      &graphql.Field{Type: graphql.Int},
+		"read_only":  &graphql.Field{Type: graphql.Boolean},
+		"slow_mode":  &graphql.Field{Type: graphql.Boolean},
+		"first_time": &graphql.Field{Type: graphql.DateTime},
+		"last_time":  &graphql.Field{Type: graphql.DateTime},
+	}})
+
+	pageInfoType := graphql.NewObject(graphql.ObjectConfig{Name: "PageInfo", Fields: graphql.Fields{
+		"hasNextPage": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+		"endCursor":   &graphql.Field{Type: graphql.String},
+	}})
+
+	connection := func(name string, node graphql.Output) *graphql.Object {
+		edgeType := graphql.NewObject(graphql.ObjectConfig{Name: name + "Edge", Fields: graphql.Fields{
+			"cursor": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"node":   &graphql.Field{Type: node},
+		}})
+		return graphql.NewObject(graphql.ObjectConfig{Name: name + "Connection", Fields: graphql.Fields{
+			"edges":      &graphql.Field{Type: graphql.NewList(edgeType)},
+			"pageInfo":   &graphql.Field{Type: graphql.NewNonNull(pageInfoType)},
+			"totalCount": &graphql.Field{Type: graphql.Int},
+		}})
+	}
+
+	site := &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)}
+	url := &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)}
+	first := &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: gqlDefaultPage}
+	after := &graphql.ArgumentConfig{Type: graphql.String}
+	sort := &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: "", Description: "+/-time, +/-score, +/-controversy, +/-quality, +/-best"}
+
+	return graphql.NewObject(graphql.ObjectConfig{Name: "Query", Fields: graphql.Fields{
+		"comments": &graphql.Field{
+			Type:        connection("Comment", commentType),
+			Description: "plain list of post's comments",
+			Args:        graphql.FieldConfigArgument{"site": site, "url": url, "sort": sort, "first": first, "after": after},
+			Resolve:     g.resolveComments,
+		},
+		"tree": &graphql.Field{
+			Type:        connection("Node", nodeType),
+			Description: "top-level comments of the post with nested replies",
+			Args:        graphql.FieldConfigArgument{"site": site, "url": url, "sort": sort, "first": first, "after": after},
+			Resolve:     g.resolveTree,
+		},
+		"comment": &graphql.Field{
+			Type: commentType,
+			Args: graphql.FieldConfigArgument{"site": site, "url": &graphql.ArgumentConfig{Type: graphql.String},
+				"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)}},
+			Resolve: g.resolveComment,
+		},
+		"userComments": &graphql.Field{
+			Type: connection("UserComment", commentType),
+			Args: graphql.FieldConfigArgument{"site": site, "user": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				"first": first, "after": after},
+			Resolve: g.resolveUserComments,
+		},
+		"user": &graphql.Field{
+			Type:        userType,
+			Description: "user with the latest name and picture, null for user without comments",
+			Args:        graphql.FieldConfigArgument{"site": site, "id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)}},
+			Resolve:     g.resolveUser,
+		},
+		"lastComments": &graphql.Field{
+			Type:    graphql.NewList(commentType),
+			Args:    graphql.FieldConfigArgument{"site": site, "first": first},
+			Resolve: g.resolveLastComments,
+		},
+		"count": &graphql.Field{
+			Type:    graphql.Int,
+			Args:    graphql.FieldConfigArgument{"site": site, "url": url},
+			Resolve: g.resolveCount,
+		},
+		"counts": &graphql.Field{
+			Type: graphql.NewList(postInfoType),
+			Args: graphql.FieldConfigArgument{"site": site,
+				"urls": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String)))}},
+			Resolve: g.resolveCounts,
+		},
+		"info": &graphql.Field{
+			Type:    postInfoType,
+			Args:    graphql.FieldConfigArgument{"site": site, "url": url},
+			Resolve: g.resolveInfo,
+		},
+		"posts": &graphql.Field{
+			Type:        connection("Post", postInfoType),
+			Description: "posts of the site, the most recently commented first",
+			Args:        graphql.FieldConfigArgument{"site": site, "first": first, "after": after},
+			Resolve:     g.resolvePosts,
+		},
+	}})
+}
+
+func (g *graphQL) resolveComments(p graphql.ResolveParams) (interface{}, error) {
+	comments, err := g.findComments(p)
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]interface{}, len(comments))
+	for i := range comments {
+		nodes[i] = comments[i]
+	}
+	return gqlPage(p, nodes)
+}
+
+func (g *graphQL) resolveTree(p graphql.ResolveParams) (interface{}, error) {
+	comments, err := g.findComments(p)
+	if err != nil {
+		return nil, err
+	}
+	tree := service.MakeTree(comments, p.Args["sort"].(string), g.settings.ReadOnlyAge(p.Args["site"].(string)))
+	tree.Flatten(g.settings.MaxReplyDepth(p.Args["site"].(string)))
+	nodes := make([]interface{}, len(tree.Nodes))
+	for i, n := range tree.Nodes {
+		nodes[i] = n
+	}
+	return gqlPage(p, nodes)
+}
+
+func (g *graphQL) resolveComment(p graphql.ResolveParams) (interface{}, error) {
+	locator := store.Locator{SiteID: p.Args["site"].(string), URL: gqlString(p, "url")}
+	user := gqlUser(p)
+	c, err := g.dataService.Get(locator, p.Args["id"].(string), user)
+	if err != nil {
+		return nil, err
+	}
+	if c.Pending && !user.Admin && c.User.ID != user.ID {
+		return nil, nil // pending comment hidden from others
+	}
+	return c, nil
+}
+
+func (g *graphQL) resolveUserComments(p graphql.ResolveParams) (interface{}, error) {
+	siteID, userID := p.Args["site"].(string), p.Args["user"].(string)
+	offset, limit, err := gqlRange(p)
+	if err != nil {
+		return nil, err
+	}
+	count, err := g.dataService.UserCount(siteID, userID)
+	if err != nil {
+		return nil, err
+	}
+	user := gqlUser(p)
+	comments, err := g.dataService.User(siteID, userID, limit, offset, user)
+	if err != nil {
+		return nil, err
+	}
+	res := gqlConnection{Edges: []gqlEdge{}, TotalCount: count}
+	for i, c := range comments {
+		if c.Pending && !user.Admin && c.User.ID != user.ID {
+			continue // pending comment hidden from others
+		}
+		res.Edges = append(res.Edges, gqlEdge{Cursor: gqlCursor(offset + i), Node: c})
+	}
+	res.PageInfo.HasNextPage = offset+len(comments) < count
+	if len(res.Edges) > 0 {
+		res.PageInfo.EndCursor = res.Edges[len(res.Edges)-1].Cursor
+	}
+	return res, nil
+}
+
+func (g *graphQL) resolveUser(p graphql.ResolveParams) (interface{}, error) {
+	comments, err := g.dataService.User(p.Args["site"].(string), p.Args["id"].(string), 1, 0, gqlUser(p))
+	if err != nil || len(comments) == 0 {
+		return nil, nil
+	}
+	return comments[0].User, nil
+}
+
+func (g *graphQL) resolveLastComments(p graphql.ResolveParams) (interface{}, error) {
+	_, limit, err := gqlRange(p)
+	if err != nil {
+		return nil, err
+	}
+	comments, err := g.dataService.Last(p.Args["site"].(string), limit, time.Time{}, gqlUser(p))
+	if err != nil {
+		return nil, err
+	}
+	return filterComments(comments, func(c store.Comment) bool { return !c.Deleted }), nil
+}
+
+func (g *graphQL) resolveCount(p graphql.ResolveParams) (interface{}, error) {
+	return g.dataService.Count(store.Locator{SiteID: p.Args["site"].(string), URL: p.Args["url"].(string)})
+}
+
+func (g *graphQL) resolveCounts(p graphql.ResolveParams) (interface{}, error) {
+	urls := []string{}
+	for _, u := range p.Args["urls"].([]interface{}) {
+		urls = append(urls, u.(string))
+	}
+	if len(urls) > gqlMaxPage {
+		return nil, errors.Errorf("too many urls, max %d", gqlMaxPage)
+	}
+	return g.dataService.Counts(p.Args["site"].(string), urls)
+}
+
+func (g *graphQL) resolveInfo(p graphql.ResolveParams) (interface{}, error) {
+	locator := store.Locator{SiteID: p.Args["site"].(string), URL: p.Args["url"].(string)}
+	info, err := g.dataService.Info(locator, g.settings.ReadOnlyAge(locator.SiteID))
+	if err != nil {
+		return nil, err
+	}
+	info.SlowMode = g.dataService.IsSlowMode(locator)
+	return info, nil
+}
+
+func (g *graphQL) resolvePosts(p graphql.ResolveParams) (interface{}, error) {
+	offset, limit, err := gqlRange(p)
+	if err != nil {
+		return nil, err
+	}
+	posts, err := g.dataService.List(p.Args["site"].(string), limit+1, offset) // one extra to detect the next page
+	if err != nil {
+		return nil, err
+	}
+	res := gqlConnection{Edges: []gqlEdge{}, TotalCount: -1}
+	for i := range posts {
+		if i == limit {
+			res.PageInfo.HasNextPage = true
+			break
+		}
+		res.Edges = append(res.Edges, gqlEdge{Cursor: gqlCursor(offset + i), Node: posts[i]})
+	}
+	if len(res.Edges) > 0 {
+		res.PageInfo.EndCursor = res.Edges[len(res.Edges)-1].Cursor
+	}
+	if !res.PageInfo.HasNextPage {
+		res.TotalCount = offset + len(res.Edges)
+	}
+	return res, nil
+}
+
+// findComments returns all visible comments of the post
+func (g *graphQL) findComments(p graphql.ResolveParams) ([]store.Comment, error) {
+	locator := store.Locator{SiteID: p.Args["site"].(string), URL: p.Args["url"].(string)}
+	return g.dataService.FindSince(locator, p.Args["sort"].(string), gqlUser(p), time.Time{})
+}
+
+// gqlPage makes connection with page of nodes defined by "first" and "after" arguments
+func gqlPage(p graphql.ResolveParams, nodes []interface{}) (gqlConnection, error) {
+	offset, limit, err := gqlRange(p)
+	if err != nil {
+		return gqlConnection{}, err
+	}
+	res := gqlConnection{Edges: []gqlEdge{}, TotalCount: len(nodes)}
+	for i := offset; i < len(nodes) && i < offset+limit; i++ {
+		res.Edges = append(res.Edges, gqlEdge{Cursor: gqlCursor(i), Node: nodes[i]})
+	}
+	res.PageInfo.HasNextPage = offset+limit < len(nodes)
+	if len(res.Edges) > 0 {
+		res.PageInfo.EndCursor = res.Edges[len(res.Edges)-1].Cursor
+	}
+	return res, nil
+}
+
+// gqlRange returns offset from "after" cursor and limit from "first" argument
+func gqlRange(p graphql.ResolveParams) (offset, limit int, err error) {
+	limit, _ = p.Args["first"].(int)
+	if limit <= 0 || limit > gqlMaxPage {
+		return 0, 0, errors.Errorf("first should be in 1..%d range", gqlMaxPage)
+	}
+	cursor := gqlString(p, "after")
+	if cursor == "" {
+		return 0, limit, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(data), "cursor:") {
+		return 0, 0, errors.Errorf("invalid cursor %q", cursor)
+	}
+	idx, err := strconv.Atoi(strings.TrimPrefix(string(data), "cursor:"))
+	if err != nil || idx < 0 {
+		return 0, 0, errors.Errorf("invalid cursor %q", cursor)
+	}
+	return idx + 1, limit, nil
+}
+
+func gqlCursor(idx int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("cursor:" + strconv.Itoa(idx)))
+}
+
+func gqlString(p graphql.ResolveParams, name string) string {
+	s, _ := p.Args[name].(string)
+	return s
+}
+
+func gqlUser(p graphql.ResolveParams) store.User {
+	user, _ := p.Context.Value(gqlUserKey{}).(store.User)
+	return user
+}
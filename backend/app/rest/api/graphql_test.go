@@ -0,0 +1,131 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestGraphQL(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}
+	ids := []string{}
+	for i, c := range []store.Comment{
+		{Text: "top 1", User: store.User{ID: "user1", Name: "user one"}},
+		{Text: "top 2", User: store.User{ID: "user2", Name: "user two"}},
+		{Text: "reply 1", User: store.User{ID: "user2", Name: "user two"}},
+		{Text: "top 3", User: store.User{ID: "user1", Name: "user one"}},
+	} {
+		c.Locator = locator
+		if i == 2 {
+			c.ParentID = ids[0]
+		}
+		id, err := srv.DataService.Create(c)
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+
+	query := func(q string, vars map[string]interface{}) (data map[string]interface{}, errs []interface{}) {
+		body, err := json.Marshal(map[string]interface{}{"query": q, "variables": vars})
+		require.NoError(t, err)
+		resp, err := http.Post(ts.URL+"/api/v1/graphql", "application/json", strings.NewReader(string(body)))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		res := struct {
+			Data   map[string]interface{} `json:"data"`
+			Errors []interface{}          `json:"errors"`
+		}{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		return res.Data, res.Errors
+	}
+
+	// plain comments, two pages
+	q := `query($after: String) {comments(site: "remark42", url: "https://radio-t.com/blah1", sort: "time", first: 3, after: $after) {
+		totalCount pageInfo {hasNextPage endCursor} edges {node {id text user {id name}}}}}`
+	data, errs := query(q, nil)
+	require.Empty(t, errs)
+	conn := data["comments"].(map[string]interface{})
+	assert.Equal(t, 4.0, conn["totalCount"])
+	edges := conn["edges"].([]interface{})
+	require.Equal(t, 3, len(edges))
+	node := edges[0].(map[string]interface{})["node"].(map[string]interface{})
+	assert.Equal(t, ids[0], node["id"])
+	assert.Equal(t, "top 1", node["text"])
+	assert.Equal(t, map[string]interface{}{"id": "user1", "name": "user one"}, node["user"])
+	pageInfo := conn["pageInfo"].(map[string]interface{})
+	assert.Equal(t, true, pageInfo["hasNextPage"])
+
+	data, errs = query(q, map[string]interface{}{"after": pageInfo["endCursor"]})
+	require.Empty(t, errs)
+	conn = data["comments"].(map[string]interface{})
+	edges = conn["edges"].([]interface{})
+	require.Equal(t, 1, len(edges))
+	assert.Equal(t, ids[3], edges[0].(map[string]interface{})["node"].(map[string]interface{})["id"])
+	assert.Equal(t, false, conn["pageInfo"].(map[string]interface{})["hasNextPage"])
+
+	// tree with replies
+	data, errs = query(`{tree(site: "remark42", url: "https://radio-t.com/blah1", sort: "time") {
+		totalCount edges {node {comment {id} replies {comment {id text}}}}}}`, nil)
+	require.Empty(t, errs)
+	conn = data["tree"].(map[string]interface{})
+	assert.Equal(t, 3.0, conn["totalCount"])
+	node = conn["edges"].([]interface{})[0].(map[string]interface{})["node"].(map[string]interface{})
+	assert.Equal(t, ids[0], node["comment"].(map[string]interface{})["id"])
+	replies := node["replies"].([]interface{})
+	require.Equal(t, 1, len(replies))
+	assert.Equal(t, ids[2], replies[0].(map[string]interface{})["comment"].(map[string]interface{})["id"])
+
+	// single comment, user, counts and posts in one request
+	data, errs = query(`query($id: String!) {
+		comment(site: "remark42", url: "https://radio-t.com/blah1", id: $id) {id score vote}
+		user(site: "remark42", id: "user2") {id name}
+		userComments(site: "remark42", user: "user1", first: 1) {totalCount edges {node {text}} pageInfo {hasNextPage}}
+		count(site: "remark42", url: "https://radio-t.com/blah1")
+		counts(site: "remark42", urls: ["https://radio-t.com/blah1", "https://radio-t.com/blah2"]) {url count}
+		info(site: "remark42", url: "https://radio-t.com/blah1") {count read_only}
+		posts(site: "remark42") {totalCount edges {node {url count}}}
+		lastComments(site: "remark42", first: 2) {id}
+	}`, map[string]interface{}{"id": ids[1]})
+	require.Empty(t, errs)
+	assert.Equal(t, map[string]interface{}{"id": ids[1], "score": 0.0, "vote": 0.0}, data["comment"])
+	assert.Equal(t, map[string]interface{}{"id": "user2", "name": "user two"}, data["user"])
+	userComments := data["userComments"].(map[string]interface{})
+	assert.Equal(t, 2.0, userComments["totalCount"])
+	assert.Equal(t, "top 3", userComments["edges"].([]interface{})[0].(map[string]interface{})["node"].(map[string]interface{})["text"])
+	assert.Equal(t, true, userComments["pageInfo"].(map[string]interface{})["hasNextPage"])
+	assert.Equal(t, 4.0, data["count"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"url": "https://radio-t.com/blah1", "count": 4.0},
+		map[string]interface{}{"url": "https://radio-t.com/blah2", "count": 0.0}}, data["counts"])
+	assert.Equal(t, map[string]interface{}{"count": 4.0, "read_only": false}, data["info"])
+	assert.Equal(t, 1.0, data["posts"].(map[string]interface{})["totalCount"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"id": ids[3]}, map[string]interface{}{"id": ids[2]}}, data["lastComments"])
+
+	// errors
+	_, errs = query(`{comments(site: "remark42", url: "https://radio-t.com/blah1", first: 1000) {totalCount}}`, nil)
+	assert.Equal(t, 1, len(errs), "too large page")
+	_, errs = query(`{comments(site: "remark42", url: "https://radio-t.com/blah1", after: "bad") {totalCount}}`, nil)
+	assert.Equal(t, 1, len(errs), "invalid cursor")
+	_, errs = query(`{blah}`, nil)
+	assert.Equal(t, 1, len(errs), "unknown field")
+
+	// get request
+	body, code := get(t, ts.URL+"/api/v1/graphql?query="+url.QueryEscape(`{count(site: "remark42", url: "https://radio-t.com/blah1")}`))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `{"data":{"count":4}}`+"\n", body)
+	_, code = get(t, ts.URL+"/api/v1/graphql?query=blah&variables=bad")
+	assert.Equal(t, http.StatusBadRequest, code)
+	resp, err := http.Post(ts.URL+"/api/v1/graphql", "application/json", strings.NewReader("bad"))
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/didip/tollbooth/v6/libstring"
+
+	"github.com/umputun/remark42/backend/app/ratelimit"
+)
+
+// requestLimiter throttles requests per ip and path to max requests per second with burst of max requests,
+// the same way as tollbooth limiter does, and reports state of the limit of the caller
+type requestLimiter struct {
+	max   float64
+	store *ratelimit.MemoryStore
+}
+
+// requestLimit describes state of the request limit of the caller
+type requestLimit struct {
+	Limit     float64 `json:"limit"`     // requests per second
+	Remaining int     `json:"remaining"` // requests allowed right now
+	Reset     float64 `json:"reset"`     // seconds until the whole burst restored
+}
+
+var requestLimitIPLookups = []string{"X-Forwarded-For", "X-Real-IP", "RemoteAddr"}
+
+func newRequestLimiter(max float64) *requestLimiter {
+	return &requestLimiter{max: max, store: ratelimit.NewMemoryStore()}
+}
+
+// Handler rejects requests above the limit with 429
+func (l *requestLimiter) Handler(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("X-Rate-Limit-Limit", fmt.Sprintf("%.2f", l.max))
+		w.Header().Add("X-Rate-Limit-Duration", "1")
+		if allowed, retryAfter, _ := l.store.Take(l.key(r, r.URL.Path), l.interval(), l.burst()); !allowed {
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Ceil(retryAfter.Seconds()))))
+			w.Header().Add("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte("You have reached maximum request limit."))
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// Status returns state of the limit of the caller's requests to the path
+func (l *requestLimiter) Status(r *http.Request, path string) requestLimit {
+	remaining, reset, _ := l.store.Peek(l.key(r, path), l.interval(), l.burst()) // memory store never fails
+	return requestLimit{Limit: l.max, Remaining: remaining, Reset: reset.Seconds()}
+}
+
+func (l *requestLimiter) key(r *http.Request, path string) string {
+	return libstring.RemoteIP(requestLimitIPLookups, 0, r) + "|" + path
+}
+
+func (l *requestLimiter) interval() time.Duration {
+	return time.Duration(float64(time.Second) / l.max)
+}
+
+func (l *requestLimiter) burst() int {
+	return int(math.Max(1, l.max))
+}
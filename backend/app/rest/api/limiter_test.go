@@ -0,0 +1,38 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestLimiter(t *testing.T) {
+	lmt := newRequestLimiter(2)
+	h := lmt.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }))
+	send := func(path, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = ip + ":1234"
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/user/limits", nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+
+	assert.Equal(t, requestLimit{Limit: 2, Remaining: 2}, lmt.Status(req, "/api/v1/find"))
+	assert.Equal(t, http.StatusOK, send("/api/v1/find", "127.0.0.1").Code)
+	assert.Equal(t, 1, lmt.Status(req, "/api/v1/find").Remaining)
+	assert.Equal(t, http.StatusOK, send("/api/v1/find", "127.0.0.1").Code, "burst of 2")
+	rr := send("/api/v1/find", "127.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "1", rr.Header().Get("Retry-After"))
+	assert.Equal(t, "2.00", rr.Header().Get("X-Rate-Limit-Limit"))
+	st := lmt.Status(req, "/api/v1/find")
+	assert.Equal(t, 0, st.Remaining)
+	assert.True(t, st.Reset > 0.9 && st.Reset <= 1, st.Reset)
+
+	assert.Equal(t, http.StatusOK, send("/api/v1/config", "127.0.0.1").Code, "other path")
+	assert.Equal(t, http.StatusOK, send("/api/v1/find", "127.0.0.2").Code, "other ip")
+}
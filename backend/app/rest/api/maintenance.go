@@ -0,0 +1,101 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/rest"
+)
+
+// Maintenance is a runtime switch of read-only mode, used during migrations and backups.
+// In maintenance mode reads served as usual and writes rejected with 503, except admin calls and
+// read-only POST calls like preview.
+type Maintenance struct {
+	RetryAfter time.Duration // sent as Retry-After header with rejected requests
+
+	lock   sync.RWMutex
+	status MaintenanceStatus
+}
+
+// MaintenanceStatus describes current state of maintenance mode
+type MaintenanceStatus struct {
+	Enabled bool      `json:"enabled"`
+	Message string    `json:"message,omitempty"`
+	Since   time.Time `json:"since,omitempty"`
+}
+
+// maintenanceAllowed lists write calls served in maintenance mode, admin's and the ones not changing anything
+var maintenanceAllowed = []string{"/api/v1/admin/", "/api/v1/preview", "/api/v1/counts", "/api/v1/graphql", "/auth/"}
+
+// NewMaintenance makes maintenance switch with initial status
+func NewMaintenance(enabled bool, message string, retryAfter time.Duration) *Maintenance {
+	res := &Maintenance{RetryAfter: retryAfter}
+	res.Set(enabled, message)
+	return res
+}
+
+// Set maintenance mode on or off, message returned with rejected requests
+func (m *Maintenance) Set(enabled bool, message string) MaintenanceStatus {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if !enabled {
+		if m.status.Enabled {
+			log.Printf("[INFO] maintenance mode disabled")
+		}
+		m.status = MaintenanceStatus{}
+		return m.status
+	}
+	if !m.status.Enabled {
+		m.status.Since = time.Now()
+		log.Printf("[INFO] maintenance mode enabled, %q", message)
+	}
+	m.status.Enabled, m.status.Message = true, message
+	return m.status
+}
+
+// Status returns current state of maintenance mode
+func (m *Maintenance) Status() MaintenanceStatus {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.status
+}
+
+// Middleware rejects writes in maintenance mode with 503 and Retry-After header
+func (m *Maintenance) Middleware(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		status := m.Status()
+		if !status.Enabled || !isWrite(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for _, prefix := range maintenanceAllowed {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		if m.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(m.RetryAfter.Seconds())))
+		}
+		details := status.Message
+		if details == "" {
+			details = "service in maintenance mode, try again later"
+		}
+		rest.SendErrorJSON(w, r, http.StatusServiceUnavailable, errors.New("maintenance mode"), details, rest.ErrMaintenance)
+	}
+	return http.HandlerFunc(fn)
+}
+
+func isWrite(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
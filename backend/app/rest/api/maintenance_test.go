@@ -0,0 +1,66 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaintenance_Middleware(t *testing.T) {
+	m := NewMaintenance(false, "", time.Minute)
+	h := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }))
+
+	tbl := []struct {
+		method, path string
+		code         int
+	}{
+		{http.MethodGet, "/api/v1/find", http.StatusOK},
+		{http.MethodOptions, "/api/v1/comment", http.StatusOK},
+		{http.MethodPost, "/api/v1/comment", http.StatusServiceUnavailable},
+		{http.MethodPut, "/api/v1/vote/123", http.StatusServiceUnavailable},
+		{http.MethodDelete, "/api/v1/email", http.StatusServiceUnavailable},
+		{http.MethodPost, "/api/v1/preview", http.StatusOK},
+		{http.MethodPost, "/api/v1/counts", http.StatusOK},
+		{http.MethodPut, "/api/v1/admin/maintenance", http.StatusOK},
+		{http.MethodPost, "/auth/email/login", http.StatusOK},
+	}
+
+	for i, tt := range tbl {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.path, nil))
+		assert.Equal(t, http.StatusOK, rr.Code, "case #%d, disabled", i)
+	}
+
+	st := m.Set(true, "backup in progress")
+	assert.True(t, st.Enabled)
+	assert.Equal(t, "backup in progress", st.Message)
+	assert.WithinDuration(t, time.Now(), st.Since, time.Second)
+	assert.Equal(t, st, m.Status())
+	assert.Equal(t, st.Since, m.Set(true, "other").Since, "enabling again keeps time")
+
+	for i, tt := range tbl {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.path, nil))
+		assert.Equal(t, tt.code, rr.Code, "case #%d", i)
+		if tt.code == http.StatusServiceUnavailable {
+			assert.Equal(t, "60", rr.Header().Get("Retry-After"))
+			assert.Equal(t, `{"code":22,"details":"other","error":"maintenance mode"}`+"\n", rr.Body.String())
+		}
+	}
+
+	assert.Equal(t, MaintenanceStatus{}, m.Set(false, "blah"))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/comment", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	m = NewMaintenance(true, "", 0)
+	rr = httptest.NewRecorder()
+	m.Middleware(h).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/comment", nil))
+	require.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(t, "", rr.Header().Get("Retry-After"))
+	assert.Contains(t, rr.Body.String(), "service in maintenance mode, try again later")
+}
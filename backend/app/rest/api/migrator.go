@@ -3,14 +3,17 @@ package api
 import (
 	"compress/gzip"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
 	cache "github.com/go-pkgz/lcw"
 	log "github.com/go-pkgz/lgr"
@@ -27,9 +30,13 @@ type Migrator struct {
 	NativeImporter    migrator.Importer
 	DisqusImporter    migrator.Importer
 	WordPressImporter migrator.Importer
+	CommentoImporter  migrator.Importer
+	IssoImporter      migrator.Importer
 	NativeExporter    migrator.Exporter
+	WordPressExporter migrator.WPExporter
 	URLMapperMaker    migrator.MapperMaker
 	KeyStore          KeyStore
+	ExportJobs        *migrator.ExportJobs // optional, enables background exports with resumable download
 
 	busy map[string]bool
 	lock sync.Mutex
@@ -40,8 +47,8 @@ type KeyStore interface {
 	Key(siteID string) (key string, err error)
 }
 
-// POST /import?secret=key&site=site-id&provider=disqus|remark|wordpress
-// imports comments from post body.
+// POST /import?secret=key&site=site-id&provider=disqus|remark|wordpress|commento|isso
+// imports comments from post body, plain or gzipped.
 func (m *Migrator) importCtrl(w http.ResponseWriter, r *http.Request) {
 
 	siteID := r.URL.Query().Get("site")
@@ -64,7 +71,7 @@ func (m *Migrator) importCtrl(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, R.JSON{"status": "import request accepted"})
 }
 
-// POST /import/form?secret=key&site=site-id&provider=disqus|remark|wordpress
+// POST /import/form?secret=key&site=site-id&provider=disqus|remark|wordpress|commento|isso
 // imports comments from form body.
 func (m *Migrator) importFormCtrl(w http.ResponseWriter, r *http.Request) {
 	siteID := r.URL.Query().Get("site")
@@ -128,15 +135,56 @@ func (m *Migrator) waitCtrl(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, R.JSON{"status": "completed", "site_id": siteID})
 }
 
-// GET /export?site=site-id&secret=12345&?mode=file|stream
-// exports all comments for siteID as gz file
+// GET /export?site=site-id&secret=12345&?mode=file|stream&version=1
+// exports all comments for siteID as gz file or newline-delimited json stream. Optional version of the format
+// makes export readable by older versions of remark42, the latest version used by default.
 func (m *Migrator) exportCtrl(w http.ResponseWriter, r *http.Request) {
 
 	siteID := r.URL.Query().Get("site")
 
+	export := m.NativeExporter.Export
+	if v := r.URL.Query().Get("version"); v != "" {
+		version, err := strconv.Atoi(v)
+		vExporter, ok := m.NativeExporter.(migrator.VersionedExporter)
+		if err != nil || !ok || !migrator.IsExportVersion(version) {
+			rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("bad version"), "can't export version "+v, rest.ErrDecode)
+			return
+		}
+		export = func(wr io.Writer, siteID string) (int, error) { return vExporter.ExportVersion(wr, siteID, version) }
+	}
+
+	m.writeExport(w, r, "json", func(wr io.Writer) (int, error) { return export(wr, siteID) })
+}
+
+// POST /export/wordpress?site=site-id&secret=12345&?mode=file|stream
+// exports all comments for siteID to WordPress WXR as gz file or xml stream. Optional body maps ids of users
+// to WordPress authors, i.e. {"github_123": {"name": "admin", "email": "admin@example.com", "user_id": 1}}
+func (m *Migrator) exportWordPressCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID := r.URL.Query().Get("site")
+
+	authors := map[string]migrator.WPAuthor{}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1024*1024))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't read authors", rest.ErrDecode)
+		return
+	}
+	if len(body) > 0 {
+		if err = json.Unmarshal(body, &authors); err != nil {
+			rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't decode authors", rest.ErrDecode)
+			return
+		}
+	}
+
+	m.writeExport(w, r, "xml", func(wr io.Writer) (int, error) {
+		return m.WordPressExporter.ExportAuthors(wr, siteID, authors)
+	})
+}
+
+// writeExport writes export to response, gzipped file named by site, date and ext with mode=file
+func (m *Migrator) writeExport(w http.ResponseWriter, r *http.Request, ext string, export func(w io.Writer) (int, error)) {
 	var writer io.Writer = w
 	if r.URL.Query().Get("mode") == "file" {
-		exportFile := fmt.Sprintf("%s-%s.json.gz", siteID, time.Now().Format("20060102"))
+		exportFile := fmt.Sprintf("%s-%s.%s.gz", r.URL.Query().Get("site"), time.Now().Format("20060102"), ext)
 		w.Header().Set("Content-Type", "application/gzip")
 		w.Header().Set("Content-Disposition", "attachment;filename="+exportFile)
 		w.WriteHeader(http.StatusOK)
@@ -149,12 +197,80 @@ func (m *Migrator) exportCtrl(w http.ResponseWriter, r *http.Request) {
 		writer = gzWriter
 	}
 
-	if _, err := m.NativeExporter.Export(writer, siteID); err != nil {
+	if _, err := export(writer); err != nil {
 		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "export failed", rest.ErrInternal)
 		return
 	}
 }
 
+// POST /export/job?site=site-id
+// starts background export of the site to file, downloaded with /export/job/{id}/file when completed
+func (m *Migrator) startExportJobCtrl(w http.ResponseWriter, r *http.Request) {
+	if m.ExportJobs == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("export jobs disabled"), "can't start export", rest.ErrActionRejected)
+		return
+	}
+	job, err := m.ExportJobs.Start(r.URL.Query().Get("site"))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusConflict, err, "can't start export", rest.ErrActionRejected)
+		return
+	}
+	render.Status(r, http.StatusAccepted)
+	render.JSON(w, r, job)
+}
+
+// GET /export/job/{id}?site=site-id
+// returns state of export job
+func (m *Migrator) exportJobCtrl(w http.ResponseWriter, r *http.Request) {
+	job, ok := m.siteExportJob(w, r)
+	if !ok {
+		return
+	}
+	render.JSON(w, r, job)
+}
+
+// GET /export/job/{id}/file?site=site-id
+// returns gz file of completed export job, supports range requests to resume interrupted downloads
+func (m *Migrator) exportJobFileCtrl(w http.ResponseWriter, r *http.Request) {
+	job, ok := m.siteExportJob(w, r)
+	if !ok {
+		return
+	}
+	fh, job, err := m.ExportJobs.Open(job.ID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusConflict, err, "export is not completed", rest.ErrActionRejected)
+		return
+	}
+	defer func() { _ = fh.Close() }()
+
+	exportFile := fmt.Sprintf("%s-%s.json.gz", job.SiteID, job.Created.Format("20060102"))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", "attachment;filename="+exportFile)
+	w.Header().Set("ETag", `"`+job.ID+`"`) // file never changes, id is enough for If-Range of resumed download
+	http.ServeContent(w, r, exportFile, job.Completed, fh)
+}
+
+// siteExportJob returns job by id from url, jobs of other sites are not found
+func (m *Migrator) siteExportJob(w http.ResponseWriter, r *http.Request) (migrator.ExportJob, bool) {
+	if m.ExportJobs == nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("export jobs disabled"), "can't get export", rest.ErrActionRejected)
+		return migrator.ExportJob{}, false
+	}
+	job, err := m.ExportJobs.Get(chi.URLParam(r, "id"))
+	if err == nil && job.SiteID != r.URL.Query().Get("site") {
+		err = migrator.ErrExportJobNotFound
+	}
+	if err == migrator.ErrExportJobNotFound {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, err, "can't get export", rest.ErrAssetNotFound)
+		return migrator.ExportJob{}, false
+	}
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get export", rest.ErrInternal)
+		return migrator.ExportJob{}, false
+	}
+	return job, true
+}
+
 // POST /remap?site=site-id
 // remap urls in comments based on given rules (oldUrl newUrl)
 func (m *Migrator) remapCtrl(w http.ResponseWriter, r *http.Request) {
@@ -228,6 +344,10 @@ func (m *Migrator) runImport(siteID, provider, tmpfile string) {
 		importer = m.DisqusImporter
 	case "wordpress":
 		importer = m.WordPressImporter
+	case "commento":
+		importer = m.CommentoImporter
+	case "isso":
+		importer = m.IssoImporter
 	default:
 		importer = m.NativeImporter
 	}
@@ -239,7 +359,14 @@ func (m *Migrator) runImport(siteID, provider, tmpfile string) {
 		return
 	}
 
-	size, err := importer.Import(fh, siteID)
+	defer fh.Close() // nolint
+
+	reader, err := migrator.Uncompressed(fh) // gzipped exports and backups imported as is
+	if err != nil {
+		log.Printf("[WARN] import failed, %v", err)
+		return
+	}
+	size, err := importer.Import(reader, siteID)
 	if err != nil {
 		log.Printf("[WARN] import failed, %v", err)
 		return
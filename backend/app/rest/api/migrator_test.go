@@ -10,6 +10,7 @@ import (
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
 	"time"
@@ -17,6 +18,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/umputun/remark42/backend/app/migrator"
 	"github.com/umputun/remark42/backend/app/store"
 	"github.com/umputun/remark42/backend/app/store/service"
 )
@@ -51,6 +53,34 @@ func TestMigrator_Import(t *testing.T) {
 	waitForMigrationCompletion(t, ts)
 }
 
+func TestMigrator_ImportGzipped(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	buf := bytes.Buffer{}
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write([]byte(`{"version":1}
+{"id":"2aa0478c-df1b-46b1-b561-03d507cf482c","pid":"","text":"<p>test test #1</p>","user":{"name":"developer one","id":"dev"},"locator":{"site":"remark42","url":"https://radio-t.com/blah1"},"score":0,"time":"2018-04-30T01:37:00.849053725-05:00"}
+`))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	client := &http.Client{Timeout: 1 * time.Second}
+	req, err := http.NewRequest("POST", ts.URL+"/api/v1/admin/import?site=remark42&provider=native", &buf)
+	require.NoError(t, err)
+	req.SetBasicAuth("admin", "password")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	waitForMigrationCompletion(t, ts)
+
+	comments, err := srv.DataService.Find(store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}, "time", store.User{})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(comments))
+	assert.Equal(t, "2aa0478c-df1b-46b1-b561-03d507cf482c", comments[0].ID)
+}
+
 func TestMigrator_ImportForm(t *testing.T) {
 	ts, _, teardown := startupT(t)
 	defer teardown()
@@ -269,6 +299,82 @@ func TestMigrator_Export(t *testing.T) {
 	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
 }
 
+func TestMigrator_ExportJob(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	req, err := http.NewRequest("POST", ts.URL+"/api/v1/admin/export/job?site=remark42", nil)
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "disabled by default")
+
+	dir, err := ioutil.TempDir("", "export_jobs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	srv.Migrator.ExportJobs, err = migrator.NewExportJobs(srv.Migrator.NativeExporter, dir, time.Hour)
+	require.NoError(t, err)
+
+	c := store.Comment{Text: "some comment", Timestamp: time.Now(),
+		Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}, User: store.User{ID: "u1"}}
+	_, err = srv.DataService.Create(c)
+	require.NoError(t, err)
+
+	req, err = http.NewRequest("POST", ts.URL+"/api/v1/admin/export/job?site=remark42", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	job := migrator.ExportJob{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&job))
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, "remark42", job.SiteID)
+
+	require.Eventually(t, func() bool {
+		body, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/export/job/"+job.ID+"?site=remark42")
+		require.Equal(t, http.StatusOK, code, body)
+		require.NoError(t, json.Unmarshal([]byte(body), &job))
+		return job.Status == migrator.ExportCompleted
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, job.Comments)
+
+	_, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/export/job/"+job.ID+"?site=other")
+	assert.Equal(t, http.StatusNotFound, code, "job of another site")
+	_, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/export/job/bad?site=remark42")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// full download
+	body, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/export/job/"+job.ID+"/file?site=remark42")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, job.Size, int64(len(body)))
+	gzReader, err := gzip.NewReader(strings.NewReader(body))
+	require.NoError(t, err)
+	export, err := ioutil.ReadAll(gzReader)
+	require.NoError(t, err)
+	assert.Contains(t, string(export), "some comment")
+
+	// resumed download
+	req, err = http.NewRequest("GET", ts.URL+"/api/v1/admin/export/job/"+job.ID+"/file?site=remark42", nil)
+	require.NoError(t, err)
+	req.Header.Set("Range", "bytes=10-")
+	req.Header.Set("If-Range", `"`+job.ID+`"`)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	part, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, body[10:], string(part))
+
+	// changed file, If-Range doesn't match
+	req.Header.Set("If-Range", `"other"`)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
 func TestMigrator_Remap(t *testing.T) {
 	ts, srv, teardown := startupT(t)
 	defer teardown()
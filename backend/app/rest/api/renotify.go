@@ -0,0 +1,184 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+
+	"github.com/umputun/remark42/backend/app/notify"
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+const (
+	renotifyMaxBackoff  = time.Minute // max delay of the next attempt while notifications queue is full
+	renotifyMaxFailures = 10          // job stopped after this number of failures in a row
+)
+
+// RenotifyStatus is progress of re-notification job of the site
+type RenotifyStatus struct {
+	Site     string            `json:"site"`
+	Running  bool              `json:"running"`
+	Canceled bool              `json:"canceled,omitempty"`
+	Rate     int               `json:"rate"`    // notifications per minute
+	Total    int               `json:"total"`   // comments of the job
+	Sent     int               `json:"sent"`    // comments submitted to notify service
+	Skipped  int               `json:"skipped"` // comments sent by previous jobs, or listed twice
+	Retries  int               `json:"retries"` // attempts delayed by full notifications queue
+	Failed   []RenotifyFailure `json:"failed"`
+	Started  time.Time         `json:"started"`
+	Finished *time.Time        `json:"finished,omitempty"`
+}
+
+// RenotifyFailure is a comment failed to re-notify about
+type RenotifyFailure struct {
+	CommentID string `json:"id"`
+	Error     string `json:"error"`
+}
+
+// renotifier re-sends notifications about comments in background at limited rate, one job per site.
+// Delay between attempts grows while notifications queue is full, comments sent by previous jobs skipped.
+type renotifier struct {
+	lock sync.Mutex
+	jobs map[string]*renotifyJob    // the latest job by site
+	sent map[string]map[string]bool // ids of comments sent by site, for suppression of duplicates
+}
+
+type renotifyJob struct {
+	status RenotifyStatus
+	cancel context.CancelFunc
+}
+
+// start re-sending notifications about comments with rate per minute, fails if job for the site is running already.
+// Comments sent by previous jobs skipped unless force set, recipients already notified skipped by notify service anyway.
+func (n *renotifier) start(notifyService *notify.Service, siteID string, comments []store.Comment, rate int,
+	force bool) (RenotifyStatus, error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.jobs == nil {
+		n.jobs, n.sent = map[string]*renotifyJob{}, map[string]map[string]bool{}
+	}
+	if job, ok := n.jobs[siteID]; ok && job.status.Running {
+		return RenotifyStatus{}, fmt.Errorf("re-notification for %s is in progress", siteID)
+	}
+	if n.sent[siteID] == nil {
+		n.sent[siteID] = map[string]bool{}
+	}
+
+	queued, seen := []store.Comment{}, map[string]bool{}
+	for _, c := range comments {
+		if seen[c.ID] || (!force && n.sent[siteID][c.ID]) {
+			continue
+		}
+		seen[c.ID] = true
+		queued = append(queued, c)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &renotifyJob{cancel: cancel, status: RenotifyStatus{Site: siteID, Running: true, Rate: rate,
+		Total: len(comments), Skipped: len(comments) - len(queued), Failed: []RenotifyFailure{}, Started: time.Now()}}
+	n.jobs[siteID] = job
+	res := job.status
+
+	go func() {
+		defer cancel()
+		n.run(ctx, notifyService, siteID, queued, time.Minute/time.Duration(rate))
+		n.lock.Lock()
+		finished := time.Now()
+		job.status.Running, job.status.Finished = false, &finished
+		st := job.status
+		n.lock.Unlock()
+		log.Printf("[INFO] re-notification for %s completed, %d of %d comments sent, %d skipped, %d failed", siteID,
+			st.Sent, st.Total, st.Skipped, len(st.Failed))
+	}()
+	return res, nil
+}
+
+// status returns progress of the latest job of the site, false if no jobs started
+func (n *renotifier) status(siteID string) (RenotifyStatus, bool) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	job, ok := n.jobs[siteID]
+	if !ok {
+		return RenotifyStatus{}, false
+	}
+	res := job.status
+	res.Failed = append([]RenotifyFailure{}, job.status.Failed...)
+	return res, true
+}
+
+// stop running job of the site, returns false if nothing running
+func (n *renotifier) stop(siteID string) bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	job, ok := n.jobs[siteID]
+	if !ok || !job.status.Running {
+		return false
+	}
+	job.status.Canceled = true
+	job.cancel()
+	return true
+}
+
+// isBusy checks if job for the site is running
+func (n *renotifier) isBusy(siteID string) bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	job, ok := n.jobs[siteID]
+	return ok && job.status.Running
+}
+
+// run sends one comment per interval. The same comment retried on full queue, with delay doubled up to
+// renotifyMaxBackoff. Failed comments recorded, job stopped after renotifyMaxFailures failures in a row.
+func (n *renotifier) run(ctx context.Context, notifyService *notify.Service, siteID string, comments []store.Comment,
+	interval time.Duration) {
+	wait, failures := interval, 0
+	for i := 0; i < len(comments); {
+		select {
+		case <-ctx.Done():
+			log.Printf("[INFO] re-notification for %s canceled", siteID)
+			return
+		case <-time.After(wait):
+		}
+
+		err := notifyService.Resend(notify.Request{Comment: comments[i]})
+		if errors.Is(err, notify.ErrQueueFull) {
+			n.update(siteID, func(st *RenotifyStatus) { st.Retries++ })
+			if wait *= 2; wait > renotifyMaxBackoff {
+				wait = renotifyMaxBackoff
+			}
+			continue
+		}
+		wait = interval
+		if err != nil {
+			log.Printf("[WARN] re-notification failed for comment %s, %v", comments[i].ID, err)
+			n.update(siteID, func(st *RenotifyStatus) {
+				st.Failed = append(st.Failed, RenotifyFailure{CommentID: comments[i].ID, Error: err.Error()})
+			})
+			if failures++; failures >= renotifyMaxFailures {
+				log.Printf("[WARN] re-notification for %s stopped after %d failures in a row", siteID, failures)
+				return
+			}
+			i++
+			continue
+		}
+		failures = 0
+		n.update(siteID, func(st *RenotifyStatus) {
+			st.Sent++
+			n.sent[siteID][comments[i].ID] = true
+		})
+		i++
+	}
+}
+
+// update status of the running job of the site
+func (n *renotifier) update(siteID string, fn func(st *RenotifyStatus)) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if job, ok := n.jobs[siteID]; ok {
+		fn(&job.status)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -17,20 +18,65 @@ import (
 	"github.com/go-chi/cors"
 	"github.com/go-chi/render"
 	"github.com/go-pkgz/auth"
+	"github.com/go-pkgz/auth/token"
 	"github.com/go-pkgz/lcw"
 	log "github.com/go-pkgz/lgr"
 	R "github.com/go-pkgz/rest"
 	"github.com/go-pkgz/rest/logger"
 	"github.com/pkg/errors"
+	"github.com/quic-go/quic-go/http3"
 	"github.com/rakyll/statik/fs"
-
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/umputun/remark42/backend/app/activitypub"
+	"github.com/umputun/remark42/backend/app/audit"
+	"github.com/umputun/remark42/backend/app/bookmarks"
+	"github.com/umputun/remark42/backend/app/captcha"
+	"github.com/umputun/remark42/backend/app/deletion"
+	"github.com/umputun/remark42/backend/app/drafts"
+	"github.com/umputun/remark42/backend/app/fingerprint"
+	"github.com/umputun/remark42/backend/app/gateway"
+	"github.com/umputun/remark42/backend/app/health"
+	"github.com/umputun/remark42/backend/app/identity"
+	"github.com/umputun/remark42/backend/app/logging"
+	"github.com/umputun/remark42/backend/app/metrics"
+	"github.com/umputun/remark42/backend/app/migrator"
+	"github.com/umputun/remark42/backend/app/moderation"
 	"github.com/umputun/remark42/backend/app/notify"
+	"github.com/umputun/remark42/backend/app/plugin"
+	"github.com/umputun/remark42/backend/app/ratelimit"
+	"github.com/umputun/remark42/backend/app/reputation"
 	"github.com/umputun/remark42/backend/app/rest"
+	"github.com/umputun/remark42/backend/app/rest/apitokens"
+	"github.com/umputun/remark42/backend/app/rest/compress"
+	"github.com/umputun/remark42/backend/app/rest/jwtkeys"
 	"github.com/umputun/remark42/backend/app/rest/proxy"
+	"github.com/umputun/remark42/backend/app/rest/saml"
+	"github.com/umputun/remark42/backend/app/rest/sessions"
+	"github.com/umputun/remark42/backend/app/retention"
+	"github.com/umputun/remark42/backend/app/roles"
+	"github.com/umputun/remark42/backend/app/schedule"
+	"github.com/umputun/remark42/backend/app/settings"
+	"github.com/umputun/remark42/backend/app/sites"
+	"github.com/umputun/remark42/backend/app/slowlog"
+	"github.com/umputun/remark42/backend/app/spam"
 	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/attachment"
+	"github.com/umputun/remark42/backend/app/store/events"
 	"github.com/umputun/remark42/backend/app/store/image"
+	"github.com/umputun/remark42/backend/app/store/replication"
 	"github.com/umputun/remark42/backend/app/store/service"
 	"github.com/umputun/remark42/backend/app/templates"
+	"github.com/umputun/remark42/backend/app/totp"
+	"github.com/umputun/remark42/backend/app/toxicity"
+	"github.com/umputun/remark42/backend/app/tracing"
+	"github.com/umputun/remark42/backend/app/translate"
+	"github.com/umputun/remark42/backend/app/trust"
+	"github.com/umputun/remark42/backend/app/verified"
+	"github.com/umputun/remark42/backend/app/votefraud"
+	"github.com/umputun/remark42/backend/app/warmup"
+	"github.com/umputun/remark42/backend/app/webmention"
 )
 
 // Rest is a rest access server
@@ -45,12 +91,61 @@ type Rest struct {
 	Migrator         *Migrator
 	NotifyService    *notify.Service
 	ImageService     *image.Service
+	Attachments      *attachment.Service // optional, enables non-image files attached to comments
+	BounceStore      notify.BounceStore
+	FollowStore      notify.FollowStore // optional, enables following of comment authors
+	Archiver         *migrator.Archiver
+	Plugins          *plugin.Service
+	SpamService      *spam.Service        // optional, checks new comments for spam
+	Toxicity         *toxicity.Service    // optional, scores new comments for toxicity in background
+	ModerationFilter *moderation.Filter   // optional, checks new comments with admin-managed blocklists
+	Events           *events.Bus          // optional, enables stream of live updates of posts
+	Maintenance      *Maintenance         // optional, read-only mode switch, disabled if not set
+	Settings         *settings.Service    // optional, per-site settings changed at runtime, defaults used if not set
+	Captcha          *captcha.Service     // optional, verifies captcha of anonymous users on sites with captcha enabled
+	VoteFraud        *votefraud.Detector  // optional, records votes and flags suspicious voting patterns
+	Fingerprints     *fingerprint.Service // optional, keeps hashes of ip and user-agent of commenters
+	JWTKeys          *jwtkeys.Keyring     // optional, rotated secrets signing JWT, shared secret used if not set
+	Sessions         *sessions.Service    // optional, server-side sessions of users with refresh tokens and revocation
+	Warmup           *warmup.Service      // optional, warms cache with posts from sitemaps of sites
+	TwoFactor        *totp.Service        // optional, two-factor auth of admins
+	Links            store.Links          // templates of canonical links to threads of virtual locators, site:template
+	AccountDeletion  *deletion.Service    // optional, self-service deletion of user accounts
+	RateLimiter      *ratelimit.Limiter   // optional, limits rate of new comments per user and ip
+	Schedule         *schedule.Service    // optional, per-post windows of commenting
+	Audit            *audit.Service       // optional, append-only log of moderation actions
+	Replies          *gateway.Replies     // optional, replies to notification emails posted as comments
+	ActivityPub      *activitypub.Service // optional, actors of posts federated with fediverse
+	Webmention       *webmention.Service  // optional, webmentions received as comments and sent for links of comments
+	Verified         *verified.Service    // optional, grants verified flag to users matched by per-site rules
+	Roles            *roles.Service       // optional, per-site roles of admins, all admins are owners if not set
+	Drafts           *drafts.Service      // optional, in-progress comments of users saved server-side
+	Bookmarks        *bookmarks.Service   // optional, comments saved by users for later
+	Identity         *identity.Service    // optional, links of user's accounts made with different auth providers
+	APITokens        *apitokens.Service   // optional, long-lived tokens of services calling api with X-API-Token header
+	SlowLog          *slowlog.Recorder    // optional, logs slow store operations and large responses
+	Retention        *retention.Service   // optional, anonymizes or deletes comments older than retention age of the site
+	Translator       *translate.Service   // optional, translates comments on request of readers of sites with translation enabled
+	Metrics          *metrics.Metrics     // optional, prometheus metrics exported on /metrics
+	Sites            *sites.Service       // optional, sites provisioned at runtime
+	Compression      *compress.Middleware // optional, compresses json responses with brotli, zstd or gzip
+	Telegram         TelegramBot          // optional, moderation with buttons of telegram admin notifications
+	Trust            *trust.Service       // optional, comments of new users held for pre-moderation till trusted
+	Reputation       *reputation.Service  // optional, karma of users shown with comments
+	Health           *health.Service      // optional, checks of dependencies for readiness probe, ready if not set
+	Tracing          bool                 // starts span of each request, tracer provider set by tracing.Setup
+	CachePeers       http.Handler         // handler for requests from other nodes, set for peers cache only
+
+	ReplicationPrimary *replication.Primary // optional, serves operation log and snapshots to standby nodes
+	ReplicationStandby *replication.Standby // optional, follows the primary, changes rejected till promotion
 
 	AnonVote        bool
 	WebRoot         string
 	RemarkURL       string
 	ReadOnlyAge     int
 	SharedSecret    string
+	BounceSecret    string
+	ReplySecret     string
 	ScoreThresholds struct {
 		Low      int
 		Critical int
@@ -58,16 +153,23 @@ type Rest struct {
 	UpdateLimiter      float64
 	EmailNotifications bool
 	EmojiEnabled       bool
+	CodeStyle          string // chroma style of code.css
 	SimpleView         bool
 	ProxyCORS          bool
 	SendJWTHeader      bool
+	HistoryPublic      bool     // allow everyone to see edit history of comments, admins only otherwise
+	PrivacyURL         string   // link to privacy policy, shown with consent checkbox
+	TermsURL           string   // link to terms of service, shown with consent checkbox
 	AllowedAncestors   []string // sets Content-Security-Policy "frame-ancestors ..."
+	FeedMaxItems       int      // max number of items in rss and atom feeds
 
 	SSLConfig   SSLConfig
 	httpsServer *http.Server
 	httpServer  *http.Server
+	http3Server *http3.Server
 	lock        sync.Mutex
 
+	settings  *settings.Service
 	pubRest   public
 	privRest  private
 	adminRest admin
@@ -81,10 +183,28 @@ type LoadingCache interface {
 	Close() error
 }
 
+// cachedGet gets data from cache in span, load called on cache miss in the child span
+func cachedGet(ctx context.Context, c LoadingCache, key lcw.Key, load func(ctx context.Context) ([]byte, error)) (data []byte, err error) {
+	err = tracing.Span(ctx, "cache.get", func(ctx context.Context) (e error) {
+		hit := true
+		data, e = c.Get(key, func() ([]byte, error) {
+			hit = false
+			return load(ctx)
+		})
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("cache.hit", hit))
+		return e
+	})
+	return data, err
+}
+
 const hardBodyLimit = 1024 * 64 // limit size of body
 
 const lastCommentsScope = "last"
 
+const postsScope = "posts" // list and counts of posts, changed by new comments
+
+const readLimit = 10.0 // requests per second per ip for public and protected read routes
+
 type commentsWithInfo struct {
 	Comments []store.Comment `json:"comments"`
 	Info     store.PostInfo  `json:"info,omitempty"`
@@ -117,6 +237,9 @@ func (s *Rest) Run(address string, port int) {
 
 		s.httpServer = s.makeHTTPServer(address, port, s.httpToHTTPSRouter())
 		s.httpServer.ErrorLog = log.ToStdLogger(log.Default(), "WARN")
+		if s.SSLConfig.HTTP3 {
+			s.http3Server = s.makeHTTP3Server(s.httpsServer)
+		}
 		s.lock.Unlock()
 
 		go func() {
@@ -125,6 +248,14 @@ func (s *Rest) Run(address string, port int) {
 			log.Printf("[WARN] http redirect server terminated, %s", err)
 		}()
 
+		if s.http3Server != nil {
+			go func() {
+				log.Printf("[INFO] activate http/3 server on udp %s:%d", address, s.SSLConfig.Port)
+				err := s.http3Server.ListenAndServeTLS(s.SSLConfig.Cert, s.SSLConfig.Key)
+				log.Printf("[WARN] http/3 server terminated, %s", err)
+			}()
+		}
+
 		err := s.httpsServer.ListenAndServeTLS(s.SSLConfig.Cert, s.SSLConfig.Key)
 		log.Printf("[WARN] https server terminated, %s", err)
 	case Auto:
@@ -135,8 +266,18 @@ func (s *Rest) Run(address string, port int) {
 		s.httpsServer = s.makeHTTPSAutocertServer(address, s.SSLConfig.Port, s.routes(), m)
 		s.httpsServer.ErrorLog = log.ToStdLogger(log.Default(), "WARN")
 
-		s.httpServer = s.makeHTTPServer(address, port, s.httpChallengeRouter(m))
+		var httpRouter http.Handler
+		if s.SSLConfig.Certificates != nil { // certificates obtained with dns-01 challenge, http server only redirects
+			s.httpsServer.TLSConfig.GetCertificate = s.SSLConfig.Certificates
+			httpRouter = s.httpToHTTPSRouter()
+		} else {
+			httpRouter = s.httpChallengeRouter(m)
+		}
+		s.httpServer = s.makeHTTPServer(address, port, httpRouter)
 		s.httpServer.ErrorLog = log.ToStdLogger(log.Default(), "WARN")
+		if s.SSLConfig.HTTP3 {
+			s.http3Server = s.makeHTTP3Server(s.httpsServer)
+		}
 
 		s.lock.Unlock()
 
@@ -147,6 +288,14 @@ func (s *Rest) Run(address string, port int) {
 			log.Printf("[WARN] http challenge server terminated, %s", err)
 		}()
 
+		if s.http3Server != nil {
+			go func() {
+				log.Printf("[INFO] activate http/3 server on udp %s:%d", address, s.SSLConfig.Port)
+				err := s.http3Server.ListenAndServe()
+				log.Printf("[WARN] http/3 server terminated, %s", err)
+			}()
+		}
+
 		err := s.httpsServer.ListenAndServeTLS("", "")
 		log.Printf("[WARN] https server terminated, %s", err)
 	}
@@ -172,9 +321,28 @@ func (s *Rest) Shutdown() {
 		}
 		log.Print("[DEBUG] shutdown https server completed")
 	}
+
+	if s.http3Server != nil {
+		log.Print("[WARN] shutdown http/3 server")
+		if err := s.http3Server.Close(); err != nil {
+			log.Printf("[DEBUG] http/3 shutdown error, %s", err)
+		}
+	}
 	s.lock.Unlock()
 }
 
+// CreateComment creates comment made outside of web widget, like email or mention by other site, going through
+// the same checks as comments of web widget except of consent to legal terms and captcha. Available once server runs.
+func (s *Rest) CreateComment(ctx context.Context, comment store.Comment) (store.Comment, error) {
+	s.lock.Lock()
+	priv := s.privRest
+	s.lock.Unlock()
+	if priv.dataService == nil {
+		return store.Comment{}, errors.New("rest server not started")
+	}
+	return priv.createComment(ctx, newComment{Comment: comment})
+}
+
 func (s *Rest) makeHTTPServer(address string, port int, router http.Handler) *http.Server {
 	return &http.Server{
 		Addr:              fmt.Sprintf("%s:%d", address, port),
@@ -187,10 +355,37 @@ func (s *Rest) makeHTTPServer(address string, port int, router http.Handler) *ht
 
 func (s *Rest) routes() chi.Router {
 	router := chi.NewRouter()
-	router.Use(middleware.Throttle(1000), middleware.RealIP, R.Recoverer(log.Default()))
+	router.Use(middleware.Throttle(1000), middleware.RealIP, R.Recoverer(log.Default()), logging.Middleware)
 	router.Use(R.AppInfo("remark42", "umputun", s.Version), R.Ping)
+	if s.Metrics != nil {
+		router.Use(s.Metrics.Middleware)
+	}
+	if s.Tracing {
+		router.Use(tracing.Middleware)
+	}
+	if s.Compression != nil {
+		router.Use(s.Compression.Handler)
+	}
+	if s.SlowLog != nil {
+		router.Use(s.SlowLog.Middleware) // after compression to see size of response made by api
+	}
 
+	if s.Maintenance == nil {
+		s.Maintenance = NewMaintenance(false, "", 0)
+	}
+	s.settings = s.Settings
+	if s.settings == nil { // defaults only, can't be changed at runtime
+		defaults := settings.Values{ReadOnlyAge: s.ReadOnlyAge, EmailNotifications: s.EmailNotifications,
+			LowScore: s.ScoreThresholds.Low, CriticalScore: s.ScoreThresholds.Critical}
+		if s.DataService != nil {
+			defaults.MaxCommentSize = s.DataService.MaxCommentSize
+		}
+		s.settings = settings.NewService(nil, defaults)
+	}
 	s.pubRest, s.privRest, s.adminRest, s.rssRest = s.controllerGroups() // assign controllers for groups
+	if s.Warmup != nil {
+		s.Warmup.SetHandler(s.warmupRoutes())
+	}
 
 	if s.ProxyCORS {
 		log.Printf("[WARN] internal CORS disabled")
@@ -210,15 +405,32 @@ func (s *Rest) routes() chi.Router {
 		log.Printf("[INFO] allowed from %+v only", s.AllowedAncestors)
 		router.Use(frameAncestors(s.AllowedAncestors))
 	}
+	router.Use(s.Maintenance.Middleware)
+	if s.JWTKeys != nil {
+		router.Use(s.JWTKeys.Middleware(s.Authenticator.TokenService())) // re-signs tokens signed with rotated keys
+	}
+
+	if s.Metrics != nil {
+		router.Handle("/metrics", s.Metrics.Handler())
+	}
+	router.With(middleware.NoCache).Get("/health", s.healthCtrl)
+	router.With(middleware.NoCache).Get("/ready", s.readyCtrl)
 
 	ipFn := func(ip string) string { return store.HashValue(ip, s.SharedSecret)[:12] } // logger uses it for anonymization
-	logInfoWithBody := logger.New(logger.Log(log.Default()), logger.WithBody, logger.IPfn(ipFn), logger.Prefix("[INFO]")).Handler
+	reqLogger := logger.New(logger.Log(log.Default()), logger.WithBody, logger.IPfn(ipFn), logger.Prefix("[INFO]")).Handler
+	logInfoWithBody := func(next http.Handler) http.Handler { return reqLogger(logUser(next)) }
 
 	authHandler, avatarHandler := s.Authenticator.Handlers()
 
 	router.Group(func(r chi.Router) {
 		r.Use(middleware.Timeout(5 * time.Second))
 		r.Use(logInfoWithBody, tollbooth_chi.LimitHandler(tollbooth.NewLimiter(10, nil)), middleware.NoCache)
+		r.Use(s.anonCaptcha)
+		if p, err := s.Authenticator.Provider(saml.Name); err == nil {
+			if sp, ok := p.Provider.(*saml.Provider); ok {
+				r.Get("/auth/"+saml.Name+"/metadata", sp.MetadataHandler) // registered in identity provider
+			}
+		}
 		r.Mount("/auth", authHandler)
 	})
 
@@ -226,9 +438,13 @@ func (s *Rest) routes() chi.Router {
 		r.Use(middleware.Timeout(5 * time.Second))
 		r.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(100, nil)))
 		r.Mount("/avatar", avatarHandler)
+		if s.ActivityPub != nil {
+			r.Get("/.well-known/webfinger", s.ActivityPub.WebFinger)
+		}
 	})
 
 	authMiddleware := s.Authenticator.Middleware()
+	authOnly, authTrace := s.apiTokenAuth(authMiddleware.Auth), s.apiTokenAuth(authMiddleware.Trace)
 
 	// api routes
 	router.Route("/api/v1", func(rapi chi.Router) {
@@ -239,14 +455,53 @@ func (s *Rest) routes() chi.Router {
 			rava.Mount("/avatar", avatarHandler)
 		})
 
+		if s.CachePeers != nil {
+			rapi.Group(func(rpeers chi.Router) {
+				rpeers.Use(middleware.Timeout(5 * time.Second))
+				rpeers.Mount("/cache", s.CachePeers)
+			})
+		}
+
+		if s.ActivityPub != nil {
+			rapi.Group(func(rap chi.Router) {
+				rap.Use(middleware.Timeout(30 * time.Second))
+				rap.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(readLimit, nil)))
+				rap.Use(authTrace, middleware.NoCache, logInfoWithBody)
+				rap.Mount("/ap", s.ActivityPub)
+			})
+		}
+
+		if s.Webmention != nil {
+			rapi.Group(func(rwm chi.Router) {
+				rwm.Use(middleware.Timeout(5 * time.Second))
+				rwm.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(10, nil)))
+				rwm.Use(middleware.NoCache, logInfoWithBody)
+				rwm.Mount("/webmention", s.Webmention)
+			})
+		}
+
+		if s.Translator != nil {
+			rapi.Group(func(rtr chi.Router) {
+				rtr.Use(middleware.Timeout(30 * time.Second))
+				rtr.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(10, nil))) // calls of paid translation api limited
+				rtr.Use(authTrace, middleware.NoCache, logInfoWithBody, virtualKey)
+				rtr.Get("/translate/{id}", s.pubRest.translateCtrl)
+			})
+		}
+
+		if s.ReplicationPrimary != nil { // no timeout, snapshots of large sites streamed for a while
+			rapi.Mount("/replication", s.ReplicationPrimary)
+		}
+
 		// open routes
 		rapi.Group(func(ropen chi.Router) {
 			ropen.Use(middleware.Timeout(30 * time.Second))
-			ropen.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(10, nil)))
-			ropen.Use(authMiddleware.Trace, middleware.NoCache, logInfoWithBody)
+			ropen.Use(s.privRest.readLimiter.Handler)
+			ropen.Use(authTrace, middleware.NoCache, logInfoWithBody, virtualKey, markdownQuery)
 			ropen.Get("/config", s.configCtrl)
-			ropen.Get("/find", s.pubRest.findCommentsCtrl)
+			ropen.Get("/replies/{id}", s.pubRest.repliesCtrl)
 			ropen.Get("/id/{id}", s.pubRest.commentByIDCtrl)
+			ropen.Get("/comment/{id}/history", s.pubRest.historyCtrl)
 			ropen.Get("/comments", s.pubRest.findUserCommentsCtrl)
 			ropen.Get("/last/{limit}", s.pubRest.lastCommentsCtrl)
 			ropen.Get("/count", s.pubRest.countCtrl)
@@ -254,83 +509,273 @@ func (s *Rest) routes() chi.Router {
 			ropen.Get("/list", s.pubRest.listCtrl)
 			ropen.Post("/preview", s.pubRest.previewCommentCtrl)
 			ropen.Get("/info", s.pubRest.infoCtrl)
+			ropen.Get("/archive", s.pubRest.archiveCtrl)
+			ropen.Get("/search", s.pubRest.searchCtrl)
+			ropen.Post("/similar", s.pubRest.similarCtrl)
+			if s.Reputation != nil {
+				ropen.Get("/karma", s.pubRest.karmaCtrl)
+			}
+			if gql, err := newGraphQL(s.DataService, s.settings); err == nil {
+				ropen.Get("/graphql", gql.handler)
+				ropen.Post("/graphql", gql.handler)
+			} else {
+				log.Printf("[WARN] graphql api disabled, %v", err)
+			}
 			ropen.Get("/img", s.ImageProxy.Handler)
+			ropen.Post("/email/bounce", s.privRest.emailBounceCtrl)
+			ropen.Post("/email/reply", s.privRest.emailReplyCtrl)
+			ropen.Post("/telegram/webhook", s.adminRest.telegramWebhookCtrl)
+			ropen.Post("/session/refresh", s.privRest.refreshSessionCtrl)
 
-			ropen.Route("/rss", func(rrss chi.Router) {
+			ropen.Route("/{format:rss|atom}", func(rrss chi.Router) {
 				rrss.Get("/post", s.rssRest.postCommentsCtrl)
 				rrss.Get("/site", s.rssRest.siteCommentsCtrl)
 				rrss.Get("/reply", s.rssRest.repliesCtrl)
+				rrss.Get("/user", s.rssRest.userCommentsCtrl)
 			})
 
 		})
 
+		// live updates stream, long-living connections not limited by timeout
+		rapi.Group(func(rstream chi.Router) {
+			rstream.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(readLimit, nil)))
+			rstream.Use(authTrace, middleware.NoCache, virtualKey)
+			rstream.Get("/stream", s.pubRest.streamCtrl)
+		})
+
 		// open routes, cached
 		rapi.Group(func(ropen chi.Router) {
 			ropen.Use(middleware.Timeout(30 * time.Second))
-			ropen.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(10, nil)))
-			ropen.Use(authMiddleware.Trace, logInfoWithBody)
+			ropen.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(readLimit, nil)))
+			ropen.Use(authTrace, logInfoWithBody)
 			ropen.Get("/picture/{user}/{id}", s.pubRest.loadPictureCtrl)
+			ropen.Get("/file/{user}/{id}/{name}", s.pubRest.loadFileCtrl)
+			ropen.Get("/counts", s.pubRest.countBatchCtrl)
+			ropen.Get("/badge", s.pubRest.badgeCtrl)
+			ropen.Get("/code.css", s.codeCSSCtrl)
+			ropen.With(virtualKey, markdownQuery).Get("/find", s.pubRest.findCommentsCtrl) // revalidated with etag and last-modified
 		})
 
 		// protected routes, require auth
 		rapi.Group(func(rauth chi.Router) {
 			rauth.Use(middleware.Timeout(30 * time.Second))
-			rauth.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(10, nil)))
-			rauth.Use(authMiddleware.Auth, matchSiteID, middleware.NoCache, logInfoWithBody)
+			rauth.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(readLimit, nil)))
+			rauth.Use(authOnly, matchSiteID, middleware.NoCache, logInfoWithBody, virtualKey)
 			rauth.Get("/user", s.privRest.userInfoCtrl)
 			rauth.Get("/userdata", s.privRest.userAllDataCtrl)
+			rauth.Get("/user/limits", s.privRest.userLimitsCtrl)
 		})
 
-		// admin routes, require auth and admin users only
+		// admin routes, require auth and admin role on the site, management of the site allowed to owners only
 		rapi.Route("/admin", func(radmin chi.Router) {
 			radmin.Use(middleware.Timeout(30 * time.Second))
 			radmin.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(10, nil)))
-			radmin.Use(authMiddleware.Auth, authMiddleware.AdminOnly, matchSiteID)
-			radmin.Use(middleware.NoCache, logInfoWithBody)
+			radmin.Use(authOnly, s.adminAccess(roles.View), matchSiteID)
+			radmin.Use(middleware.NoCache, logInfoWithBody, virtualKey)
 
 			radmin.Delete("/comment/{id}", s.adminRest.deleteCommentCtrl)
 			radmin.Put("/user/{userid}", s.adminRest.setBlockCtrl)
 			radmin.Delete("/user/{userid}", s.adminRest.deleteUserCtrl)
 			radmin.Get("/user/{userid}", s.adminRest.getUserInfoCtrl)
-			radmin.Get("/deleteme", s.adminRest.deleteMeRequestCtrl)
+			radmin.Delete("/sessions/{userid}", s.adminRest.revokeSessionsCtrl)
+			radmin.With(s.adminAccess(roles.Moderate)).Get("/deleteme", s.adminRest.deleteMeRequestCtrl) // deletes user on GET
 			radmin.Put("/verify/{userid}", s.adminRest.setVerifyCtrl)
 			radmin.Put("/pin/{id}", s.adminRest.setPinCtrl)
+			radmin.Put("/label/{id}", s.adminRest.setLabelCtrl)
+			radmin.Delete("/label/{id}", s.adminRest.setLabelCtrl)
+			radmin.Get("/labeled", s.adminRest.labeledCommentsCtrl)
+			radmin.Get("/find", s.adminRest.findAsOfCtrl)
 			radmin.Get("/blocked", s.adminRest.blockedUsersCtrl)
+			radmin.Put("/shadowban/{userid}", s.adminRest.setShadowBanCtrl)
+			radmin.Get("/shadowbanned", s.adminRest.shadowBannedUsersCtrl)
 			radmin.Put("/readonly", s.adminRest.setReadOnlyCtrl)
+			radmin.Put("/slowmode", s.adminRest.setSlowModeCtrl)
+			radmin.Get("/schedule", s.adminRest.getScheduleCtrl)
+			radmin.Put("/schedule", s.adminRest.setScheduleCtrl)
+			radmin.Delete("/schedule", s.adminRest.deleteScheduleCtrl)
+			radmin.Get("/interval", s.adminRest.getIntervalCtrl)
+			radmin.Put("/interval", s.adminRest.setIntervalCtrl)
+			radmin.Put("/spam/{id}", s.adminRest.setSpamCtrl)
+			radmin.Post("/bulk", s.adminRest.bulkModerationCtrl)
+			radmin.Get("/pending", s.adminRest.pendingCommentsCtrl)
+			radmin.Get("/reports", s.adminRest.reportedCommentsCtrl)
+			radmin.Delete("/reports/{id}", s.adminRest.dismissReportsCtrl)
+			radmin.Get("/deleted", s.adminRest.deletedCommentsCtrl)
+			radmin.Put("/undelete/{id}", s.adminRest.undeleteCommentCtrl)
+			radmin.Get("/audit", s.adminRest.auditCtrl)
+			radmin.Get("/audit/export", s.adminRest.auditExportCtrl)
+			radmin.Get("/external", s.adminRest.externalCommentCtrl)
+			radmin.Get("/consents", s.adminRest.consentsCtrl)
+			radmin.Get("/moderation", s.adminRest.getModerationCtrl)
+			radmin.Get("/verified/rules", s.adminRest.getVerifiedRulesCtrl)
+			radmin.Get("/trust/rules", s.adminRest.getTrustRulesCtrl)
+			radmin.Get("/trust/queue", s.adminRest.trustQueueCtrl)
+			radmin.Get("/trust/user/{userid}", s.adminRest.getTrustCtrl)
+			radmin.Put("/trust/user/{userid}", s.adminRest.setTrustCtrl)
+			radmin.Get("/identity", s.adminRest.getIdentityCtrl)
+			radmin.Get("/settings", s.adminRest.getSettingsCtrl)
 			radmin.Put("/title/{id}", s.adminRest.setTitleCtrl)
+			radmin.Get("/integrity", s.adminRest.integrityCtrl)
+			radmin.Get("/bounces", s.adminRest.bouncesCtrl)
+			radmin.Get("/sentiment", s.adminRest.sentimentCtrl)
+			radmin.Get("/maintenance", s.adminRest.getMaintenanceCtrl)
+			radmin.Get("/replication", s.adminRest.replicationCtrl)
+			radmin.Get("/slow", s.adminRest.slowOpsCtrl)
+			radmin.Get("/retention", s.adminRest.retentionCtrl)
+			radmin.Get("/badge", s.adminRest.badgeCtrl)
+			radmin.Get("/renotify", s.adminRest.renotifyStatusCtrl)
+			radmin.Delete("/bounce", s.adminRest.deleteBounceCtrl)
+			radmin.Get("/notify/admins", s.adminRest.adminNotifyPrefsCtrl)
+			radmin.Get("/notify/status", s.adminRest.notifyStatusCtrl)
+			radmin.Get("/email/preview", s.adminRest.emailPreviewCtrl)
+			radmin.Get("/votes/fraud", s.adminRest.voteFraudCtrl)
+			radmin.Post("/votes/fraud/{id}", s.adminRest.resolveVoteFraudCtrl)
+			radmin.Delete("/votes/fraud/{id}", s.adminRest.resolveVoteFraudCtrl)
+			radmin.Get("/warmup", s.adminRest.warmupCtrl)
+			radmin.Get("/fingerprint", s.adminRest.findFingerprintCtrl)
+			radmin.Get("/fingerprint/{id}", s.adminRest.fingerprintCtrl)
+			radmin.Get("/fingerprint/block", s.adminRest.fingerprintBlocksCtrl)
+			radmin.Put("/fingerprint/block/{hash}", s.adminRest.setFingerprintBlockCtrl)
+			radmin.Delete("/fingerprint/block/{hash}", s.adminRest.setFingerprintBlockCtrl)
+
+			// provisioning of sites, basic auth admin only
+			radmin.Route("/sites", func(rsites chi.Router) {
+				rsites.Use(basicAdminOnly)
+				rsites.Get("/", s.adminRest.sitesCtrl)
+				rsites.Post("/", s.adminRest.createSiteCtrl)
+				rsites.Put("/{id}", s.adminRest.updateSiteCtrl)
+				rsites.Delete("/{id}", s.adminRest.deleteSiteCtrl)
+				rsites.Post("/{id}/secret", s.adminRest.rotateSiteSecretCtrl)
+			})
 
-			// migrator
-			radmin.Get("/export", s.adminRest.migrator.exportCtrl)
-			radmin.Post("/import", s.adminRest.migrator.importCtrl)
-			radmin.Post("/import/form", s.adminRest.migrator.importFormCtrl)
-			radmin.Post("/remap", s.adminRest.migrator.remapCtrl)
-			radmin.Get("/wait", s.adminRest.migrator.waitCtrl)
+			// settings of notification destinations switched at runtime, basic auth admin only
+			radmin.Route("/notify/config", func(rnotify chi.Router) {
+				rnotify.Use(basicAdminOnly)
+				rnotify.Get("/", s.adminRest.notifyConfigCtrl)
+				rnotify.Put("/smtp", s.adminRest.setNotifySMTPCtrl)
+				rnotify.Put("/telegram", s.adminRest.setNotifyTelegramCtrl)
+			})
+
+			// management of the site, owners only
+			radmin.Group(func(rmanage chi.Router) {
+				rmanage.Use(s.adminAccess(roles.Manage))
+				rmanage.Put("/moderation", s.adminRest.setModerationCtrl)
+				rmanage.Put("/verified/rules", s.adminRest.setVerifiedRulesCtrl)
+				rmanage.Post("/verified/evaluate", s.adminRest.evaluateVerifiedCtrl)
+				rmanage.Put("/trust/rules", s.adminRest.setTrustRulesCtrl)
+				rmanage.Put("/settings", s.adminRest.setSettingsCtrl)
+				rmanage.Post("/integrity", s.adminRest.integrityCtrl)
+				rmanage.Post("/renotify", s.adminRest.renotifyCtrl)
+				rmanage.Delete("/renotify", s.adminRest.stopRenotifyCtrl)
+				rmanage.Post("/archive", s.adminRest.archiveCtrl)
+				rmanage.Post("/search/rebuild", s.adminRest.rebuildSearchCtrl)
+				rmanage.Put("/reattribute", s.adminRest.reattributeCtrl)
+				rmanage.Put("/identity/link", s.adminRest.linkIdentityCtrl)
+				rmanage.Delete("/identity/link", s.adminRest.unlinkIdentityCtrl)
+				rmanage.Get("/tokens", s.adminRest.apiTokensCtrl)
+				rmanage.Post("/tokens", s.adminRest.createAPITokenCtrl)
+				rmanage.Delete("/tokens/{id}", s.adminRest.revokeAPITokenCtrl)
+				rmanage.Put("/maintenance", s.adminRest.setMaintenanceCtrl)
+				rmanage.Post("/replication/promote", s.adminRest.promoteStandbyCtrl)
+				rmanage.Put("/notify/admin", s.adminRest.setAdminNotifyPrefsCtrl)
+				rmanage.Post("/email/test", s.adminRest.emailTestCtrl)
+				rmanage.Get("/roles", s.adminRest.rolesCtrl)
+				rmanage.Put("/roles/{userid}", s.adminRest.setRoleCtrl)
+				rmanage.Delete("/roles/{userid}", s.adminRest.setRoleCtrl)
+				rmanage.Delete("/img-cache", s.adminRest.purgeImageCacheCtrl)
+				rmanage.Post("/warmup", s.adminRest.startWarmupCtrl)
+				rmanage.Get("/jwt/keys", s.adminRest.jwtKeysCtrl)
+				rmanage.Post("/jwt/rotate", s.adminRest.rotateJWTCtrl)
+
+				// migrator
+				rmanage.Get("/export", s.adminRest.migrator.exportCtrl)
+				rmanage.Post("/export/wordpress", s.adminRest.migrator.exportWordPressCtrl)
+				rmanage.Post("/export/job", s.adminRest.migrator.startExportJobCtrl)
+				rmanage.Get("/export/job/{id}", s.adminRest.migrator.exportJobCtrl)
+				rmanage.Post("/import", s.adminRest.migrator.importCtrl)
+				rmanage.Post("/import/form", s.adminRest.migrator.importFormCtrl)
+				rmanage.Post("/remap", s.adminRest.migrator.remapCtrl)
+				rmanage.Post("/remap/urls", s.adminRest.remapURLsCtrl)
+				rmanage.Get("/wait", s.adminRest.migrator.waitCtrl)
+			})
+		})
+
+		// admin download of export files, no timeout for big files and no NoCache as it drops If-Range of resumed download
+		rapi.Group(func(rdown chi.Router) {
+			rdown.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(10, nil)))
+			rdown.Use(authOnly, s.adminAccess(roles.Manage), matchSiteID, logInfoWithBody)
+			rdown.Get("/admin/export/job/{id}/file", s.adminRest.migrator.exportJobFileCtrl)
+			rdown.Get("/admin/export/comments", s.adminRest.exportCommentsCtrl)
+			rdown.Get("/admin/export/users", s.adminRest.exportUsersCtrl)
+		})
+
+		// storage of a single site copied as is, basic auth admin only, no timeout and no body logging for big files
+		rapi.Group(func(rstorage chi.Router) {
+			rstorage.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(10, nil)))
+			rstorage.Use(authOnly, basicAdminOnly, middleware.NoCache)
+			rstorage.Use(logger.New(logger.Log(log.Default()), logger.Prefix("[INFO]"), logger.IPfn(ipFn)).Handler)
+			rstorage.Get("/admin/storage/backup", s.adminRest.storageBackupCtrl)
+			rstorage.Post("/admin/storage/restore", s.adminRest.storageRestoreCtrl)
+			rstorage.Post("/admin/storage/detach", s.adminRest.storageDetachCtrl)
 		})
 
 		// protected routes, throttled to 10/s by default, controlled by external UpdateLimiter param
 		rapi.Group(func(rauth chi.Router) {
 			rauth.Use(middleware.Timeout(10 * time.Second))
-			rauth.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(s.updateLimiter(), nil)))
-			rauth.Use(authMiddleware.Auth, matchSiteID)
-			rauth.Use(middleware.NoCache, logInfoWithBody)
+			rauth.Use(s.privRest.updateLimiter.Handler)
+			rauth.Use(authOnly, matchSiteID)
+			rauth.Use(middleware.NoCache, logInfoWithBody, virtualKey)
 
 			rauth.Put("/comment/{id}", s.privRest.updateCommentCtrl)
 			rauth.Post("/comment", s.privRest.createCommentCtrl)
 			rauth.Put("/vote/{id}", s.privRest.voteCtrl)
+			rauth.Put("/react/{id}", s.privRest.reactCtrl)
+			rauth.Delete("/react/{id}", s.privRest.reactCtrl)
+			rauth.With(rejectAnonUser).Put("/report/{id}", s.privRest.reportCtrl)
+			rauth.With(rejectAnonUser).Get("/profile", s.privRest.getProfileCtrl)
+			rauth.With(rejectAnonUser).Put("/profile", s.privRest.setProfileCtrl)
+			rauth.Get("/consent", s.privRest.getConsentCtrl)
+			rauth.Post("/consent", s.privRest.setConsentCtrl)
 			rauth.With(rejectAnonUser).Post("/deleteme", s.privRest.deleteMeCtrl)
 			rauth.With(rejectAnonUser).Get("/email", s.privRest.getEmailCtrl)
 			rauth.With(rejectAnonUser).Post("/email/subscribe", s.privRest.sendEmailConfirmationCtrl)
+			rauth.With(rejectAnonUser).Post("/email/change", s.privRest.changeEmailCtrl)
 			rauth.With(rejectAnonUser).Post("/email/confirm", s.privRest.setConfirmedEmailCtrl)
 			rauth.With(rejectAnonUser).Delete("/email", s.privRest.deleteEmailCtrl)
+			rauth.With(rejectAnonUser).Get("/follow", s.privRest.followingCtrl)
+			rauth.With(rejectAnonUser).Put("/follow/{user}", s.privRest.followCtrl)
+			rauth.With(rejectAnonUser).Delete("/follow/{user}", s.privRest.followCtrl)
+			rauth.With(rejectAnonUser).Get("/totp", s.privRest.twoFactorStatusCtrl)
+			rauth.With(rejectAnonUser).Post("/totp/enroll", s.privRest.enrollTwoFactorCtrl)
+			rauth.With(rejectAnonUser).Post("/totp/confirm", s.privRest.confirmTwoFactorCtrl)
+			rauth.With(rejectAnonUser).Post("/totp/verify", s.privRest.verifyTwoFactorCtrl)
+			rauth.With(rejectAnonUser).Delete("/totp", s.privRest.disableTwoFactorCtrl)
+			rauth.With(rejectAnonUser).Post("/account/delete", s.privRest.requestAccountDeletionCtrl)
+			rauth.With(rejectAnonUser).Get("/account/delete", s.privRest.accountDeletionStatusCtrl)
+			rauth.With(rejectAnonUser).Delete("/account/delete", s.privRest.cancelAccountDeletionCtrl)
+			rauth.With(rejectAnonUser).Get("/draft", s.privRest.getDraftCtrl)
+			rauth.With(rejectAnonUser).Put("/draft", s.privRest.saveDraftCtrl)
+			rauth.With(rejectAnonUser).Delete("/draft", s.privRest.deleteDraftCtrl)
+			rauth.With(rejectAnonUser).Get("/bookmarks", s.privRest.bookmarksCtrl)
+			rauth.With(rejectAnonUser).Get("/bookmarks/post", s.privRest.postBookmarksCtrl)
+			rauth.With(rejectAnonUser).Put("/bookmark/{id}", s.privRest.addBookmarkCtrl)
+			rauth.With(rejectAnonUser).Delete("/bookmark/{id}", s.privRest.deleteBookmarkCtrl)
+			rauth.With(rejectAnonUser).Get("/identity", s.privRest.identityCtrl)
+			rauth.With(rejectAnonUser).Post("/identity/link", s.privRest.requestLinkCtrl)
+			rauth.With(rejectAnonUser).Post("/identity/link/confirm", s.privRest.confirmLinkCtrl)
+			rauth.Post("/session/refresh-token", s.privRest.issueRefreshTokenCtrl)
+			rauth.Get("/sessions", s.privRest.sessionsCtrl)
+			rauth.Delete("/sessions/{id}", s.privRest.revokeSessionCtrl)
 		})
 
 		// protected routes, anonymous rejected
 		rapi.Group(func(rauth chi.Router) {
 			rauth.Use(middleware.Timeout(10 * time.Second))
 			rauth.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(s.updateLimiter(), nil)))
-			rauth.Use(authMiddleware.Auth, rejectAnonUser, matchSiteID)
+			rauth.Use(authOnly, rejectAnonUser, matchSiteID)
 			rauth.Use(logger.New(logger.Log(log.Default()), logger.Prefix("[DEBUG]"), logger.IPfn(ipFn)).Handler)
 			rauth.Post("/picture", s.privRest.savePictureCtrl)
+			rauth.Post("/file", s.privRest.saveFileCtrl)
 		})
 
 	})
@@ -343,6 +788,14 @@ func (s *Rest) routes() chi.Router {
 		rroot.Get("/robots.txt", s.pubRest.robotsCtrl)
 		rroot.Get("/email/unsubscribe.html", s.privRest.emailUnsubscribeCtrl)
 		rroot.Post("/email/unsubscribe.html", s.privRest.emailUnsubscribeCtrl)
+		rroot.Get("/email/unsubscribe", s.privRest.emailOneClickUnsubscribeCtrl)
+		rroot.Post("/email/unsubscribe", s.privRest.emailOneClickUnsubscribeCtrl)
+		rroot.Get("/email/vote.html", s.privRest.emailVoteCtrl)
+		rroot.Post("/email/vote.html", s.privRest.emailVoteCtrl)
+		rroot.Get("/email/moderate.html", s.adminRest.emailModerateCtrl)
+		rroot.Post("/email/moderate.html", s.adminRest.emailModerateCtrl)
+		rroot.Get("/account/delete.html", s.privRest.confirmAccountDeletionCtrl)
+		rroot.Post("/account/delete.html", s.privRest.confirmAccountDeletionCtrl)
 	})
 
 	// file server for static content from /web
@@ -350,41 +803,123 @@ func (s *Rest) routes() chi.Router {
 	return router
 }
 
+// warmupRoutes returns handler of warmup requests, public routes without rate limits and logging
+func (s *Rest) warmupRoutes() http.Handler {
+	router := chi.NewRouter()
+	router.Get("/api/v1/find", s.pubRest.findCommentsCtrl)
+	router.Get("/api/v1/counts", s.pubRest.countBatchCtrl)
+	return router
+}
+
 func (s *Rest) controllerGroups() (public, private, admin, rss) {
 
+	// key of the site signing badges of posts
+	siteKey := func(siteID string) (string, error) { return s.DataService.AdminStore.Key(siteID) }
+
 	pubGrp := public{
 		dataService:      s.DataService,
 		cache:            s.Cache,
 		imageService:     s.ImageService,
+		attachments:      s.Attachments,
 		commentFormatter: s.CommentFormatter,
-		readOnlyAge:      s.ReadOnlyAge,
+		settings:         s.settings,
 		webRoot:          s.WebRoot,
+		archiver:         s.Archiver,
+		historyPublic:    s.HistoryPublic,
+		events:           s.Events,
+		schedule:         s.Schedule,
+		reputation:       s.Reputation,
+		translator:       s.Translator,
+		siteKey:          siteKey,
 	}
 
 	privGrp := private{
 		dataService:      s.DataService,
 		cache:            s.Cache,
 		imageService:     s.ImageService,
+		attachments:      s.Attachments,
 		commentFormatter: s.CommentFormatter,
-		readOnlyAge:      s.ReadOnlyAge,
+		settings:         s.settings,
 		authenticator:    s.Authenticator,
 		notifyService:    s.NotifyService,
 		remarkURL:        s.RemarkURL,
 		anonVote:         s.AnonVote,
 		templates:        templates.NewFS(),
+		bounceStore:      s.BounceStore,
+		bounceSecret:     s.BounceSecret,
+		replies:          s.Replies,
+		replySecret:      s.ReplySecret,
+		followStore:      s.FollowStore,
+		readLimiter:      newRequestLimiter(readLimit),
+		updateLimiter:    newRequestLimiter(s.updateLimiter()),
+		plugins:          s.Plugins,
+		spamService:      s.SpamService,
+		toxicity:         s.Toxicity,
+		moderationFilter: s.ModerationFilter,
+		captcha:          s.Captcha,
+		voteFraud:        s.VoteFraud,
+		fingerprints:     s.Fingerprints,
+		sessions:         s.Sessions,
+		twoFactor:        s.TwoFactor,
+		accountDeletion:  s.AccountDeletion,
+		rateLimiter:      s.RateLimiter,
+		audit:            s.Audit,
+		schedule:         s.Schedule,
+		verified:         s.Verified,
+		trust:            s.Trust,
+		roles:            s.Roles,
+		drafts:           s.Drafts,
+		bookmarks:        s.Bookmarks,
+		identity:         s.Identity,
+		links:            s.Links,
+		metrics:          s.Metrics,
 	}
 
 	admGrp := admin{
-		dataService:   s.DataService,
-		migrator:      s.Migrator,
-		cache:         s.Cache,
-		authenticator: s.Authenticator,
-		readOnlyAge:   s.ReadOnlyAge,
+		dataService:        s.DataService,
+		migrator:           s.Migrator,
+		cache:              s.Cache,
+		authenticator:      s.Authenticator,
+		settings:           s.settings,
+		bounceStore:        s.BounceStore,
+		notifyService:      s.NotifyService,
+		archiver:           s.Archiver,
+		plugins:            s.Plugins,
+		spamService:        s.SpamService,
+		moderationFilter:   s.ModerationFilter,
+		maintenance:        s.Maintenance,
+		renotifier:         &renotifier{},
+		voteFraud:          s.VoteFraud,
+		fingerprints:       s.Fingerprints,
+		jwtKeys:            s.JWTKeys,
+		sessions:           s.Sessions,
+		warmup:             s.Warmup,
+		audit:              s.Audit,
+		schedule:           s.Schedule,
+		verified:           s.Verified,
+		trust:              s.Trust,
+		identity:           s.Identity,
+		apiTokens:          s.APITokens,
+		slowLog:            s.SlowLog,
+		retention:          s.Retention,
+		siteKey:            siteKey,
+		remarkURL:          s.RemarkURL,
+		roles:              s.Roles,
+		imageProxy:         s.ImageProxy,
+		sites:              s.Sites,
+		telegram:           s.Telegram,
+		templates:          templates.NewFS(),
+		links:              s.Links,
+		metrics:            s.Metrics,
+		replicationPrimary: s.ReplicationPrimary,
+		replicationStandby: s.ReplicationStandby,
 	}
 
 	rssGrp := rss{
 		dataService: s.DataService,
 		cache:       s.Cache,
+		maxItems:    s.FeedMaxItems,
+		links:       s.Links,
 	}
 
 	return pubGrp, privGrp, admGrp, rssGrp
@@ -399,18 +934,51 @@ func (s *Rest) updateLimiter() float64 {
 	return lmt
 }
 
+// GET /code.css - css for code highlighted in comments, frontends don't need own highlighting
+func (s *Rest) codeCSSCtrl(w http.ResponseWriter, r *http.Request) {
+	css, err := store.CodeCSS(s.CodeStyle)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't make code css", rest.ErrInternal)
+		return
+	}
+	w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	if _, err = w.Write(css); err != nil {
+		log.Printf("[WARN] can't write code css, %v", err)
+	}
+}
+
+// GET /health - liveness probe, returns ok while the server is up, dependencies not checked
+func (s *Rest) healthCtrl(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, R.JSON{"status": "ok", "version": s.Version})
+}
+
+// GET /ready - readiness probe with status of each dependency, 503 if any required dependency failed
+func (s *Rest) readyCtrl(w http.ResponseWriter, r *http.Request) {
+	rep := health.Report{Ready: true, Status: health.StatusOK, Checks: map[string]health.Status{}}
+	if s.Health != nil {
+		rep = s.Health.Ready(r.Context())
+	}
+	if !rep.Ready {
+		render.Status(r, http.StatusServiceUnavailable)
+	}
+	render.JSON(w, r, rep)
+}
+
 // GET /config?site=siteID - returns configuration
 func (s *Rest) configCtrl(w http.ResponseWriter, r *http.Request) {
 	siteID := r.URL.Query().Get("site")
 
 	admins, _ := s.DataService.AdminStore.Admins(siteID)
 	emails, _ := s.DataService.AdminStore.Email(siteID)
+	siteSettings := s.settings.Get(siteID)
 
 	cnf := struct {
 		Version            string   `json:"version"`
 		EditDuration       int      `json:"edit_duration"`
 		AdminEdit          bool     `json:"admin_edit"`
 		MaxCommentSize     int      `json:"max_comment_size"`
+		MaxReplyDepth      int      `json:"max_reply_depth"`
 		Admins             []string `json:"admins"`
 		AdminEmail         string   `json:"admin_email"`
 		Auth               []string `json:"auth_providers"`
@@ -420,27 +988,56 @@ func (s *Rest) configCtrl(w http.ResponseWriter, r *http.Request) {
 		PositiveScore      bool     `json:"positive_score"`
 		ReadOnlyAge        int      `json:"readonly_age"`
 		MaxImageSize       int      `json:"max_image_size"`
+		Attachments        []string `json:"attachments"` // allowed extensions of attached files
+		MaxAttachmentSize  int      `json:"max_attachment_size,omitempty"`
 		EmailNotifications bool     `json:"email_notifications"`
 		EmojiEnabled       bool     `json:"emoji_enabled"`
+		MathEnabled        bool     `json:"math_enabled"`
 		SimpleView         bool     `json:"simple_view"`
 		SendJWTHeader      bool     `json:"send_jwt_header"`
+		ConsentVersion     string   `json:"consent_version,omitempty"`
+		PrivacyURL         string   `json:"privacy_url,omitempty"`
+		TermsURL           string   `json:"terms_url,omitempty"`
+		HistoryPublic      bool     `json:"history_public"`
+		Maintenance        bool     `json:"maintenance"`
+		LiveUpdates        bool     `json:"live_updates"`
+		Reactions          []string `json:"reactions"`
+		Follow             bool     `json:"follow"`
+		Translation        bool     `json:"translation"`
+		Captcha            string   `json:"captcha,omitempty"`
+		CaptchaSiteKey     string   `json:"captcha_site_key,omitempty"`
 	}{
 		Version:            s.Version,
 		EditDuration:       int(s.DataService.EditDuration.Seconds()),
 		AdminEdit:          s.DataService.AdminEdits,
-		MaxCommentSize:     s.DataService.MaxCommentSize,
+		MaxCommentSize:     siteSettings.MaxCommentSize,
+		MaxReplyDepth:      siteSettings.MaxReplyDepth,
 		Admins:             admins,
 		AdminEmail:         emails,
-		LowScore:           s.ScoreThresholds.Low,
-		CriticalScore:      s.ScoreThresholds.Critical,
+		LowScore:           siteSettings.LowScore,
+		CriticalScore:      siteSettings.CriticalScore,
 		PositiveScore:      s.DataService.PositiveScore,
-		ReadOnlyAge:        s.ReadOnlyAge,
+		ReadOnlyAge:        siteSettings.ReadOnlyAge,
 		MaxImageSize:       s.ImageService.MaxSize,
-		EmailNotifications: s.EmailNotifications,
+		EmailNotifications: siteSettings.EmailNotifications,
 		EmojiEnabled:       s.EmojiEnabled,
+		MathEnabled:        siteSettings.Math,
 		AnonVote:           s.AnonVote,
 		SimpleView:         s.SimpleView,
 		SendJWTHeader:      s.SendJWTHeader,
+		ConsentVersion:     s.DataService.ConsentVersion(siteID),
+		PrivacyURL:         s.PrivacyURL,
+		TermsURL:           s.TermsURL,
+		HistoryPublic:      s.HistoryPublic,
+		Maintenance:        s.Maintenance.Status().Enabled,
+		LiveUpdates:        s.Events != nil,
+		Reactions:          s.DataService.Reactions,
+		Follow:             s.FollowStore != nil,
+		Translation:        s.Translator != nil && siteSettings.Translation,
+	}
+
+	if s.Captcha.Enabled(siteID) {
+		cnf.Captcha, cnf.CaptchaSiteKey = s.Captcha.Provider(), s.Captcha.SiteKey
 	}
 
 	cnf.Auth = []string{}
@@ -451,10 +1048,46 @@ func (s *Rest) configCtrl(w http.ResponseWriter, r *http.Request) {
 	if cnf.Admins == nil { // prevent json serialization to nil
 		cnf.Admins = []string{}
 	}
+	if cnf.Reactions == nil {
+		cnf.Reactions = []string{}
+	}
+	cnf.Attachments = []string{}
+	if s.Attachments.Enabled() {
+		cnf.Attachments, cnf.MaxAttachmentSize = s.Attachments.Extensions, s.Attachments.MaxSize
+	}
 	render.Status(r, http.StatusOK)
 	render.JSON(w, r, cnf)
 }
 
+// anonCaptcha rejects anonymous logins without valid captcha on sites with captcha enabled.
+// Token passed in X-Captcha-Token header or captcha query param, site in aud param of the login.
+func (s *Rest) anonCaptcha(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/anonymous/login") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		siteID := r.URL.Query().Get("aud")
+		if siteID == "" {
+			siteID = r.URL.Query().Get("site")
+		}
+		if err := s.Captcha.Check(siteID, captchaToken(r), strings.Split(r.RemoteAddr, ":")[0]); err != nil {
+			rest.SendErrorJSON(w, r, http.StatusForbidden, err, "captcha rejected", rest.ErrCaptcha)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// captchaToken returns captcha token of the request from header or query
+func captchaToken(r *http.Request) string {
+	if t := r.Header.Get("X-Captcha-Token"); t != "" {
+		return t
+	}
+	return r.URL.Query().Get("captcha")
+}
+
 // serves static files from /web or embedded by statik
 func addFileServer(r chi.Router, path string, root http.FileSystem, version string) {
 
@@ -555,6 +1188,70 @@ func rejectAnonUser(next http.Handler) http.Handler {
 	return http.HandlerFunc(fn)
 }
 
+// logUser sets user of the request to its log fields, applied after auth middleware
+func logUser(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if user, err := rest.GetUserInfo(r); err == nil {
+			logging.SetUser(r.Context(), user.ID)
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// markdownQuery is a middleware setting markdown param for requests with Accept: text/markdown, the query is
+// a part of cache keys, so comments with and without original markdown cached separately
+func markdownQuery(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+		if q := r.URL.Query(); q.Get("markdown") == "" && strings.Contains(r.Header.Get("Accept"), "text/markdown") {
+			q.Set("markdown", "true")
+			r.URL.RawQuery = q.Encode()
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// withMarkdown clears original markdown of comments, unless requested with markdown=true
+func withMarkdown(r *http.Request, comments []store.Comment) []store.Comment {
+	if keep, _ := strconv.ParseBool(r.URL.Query().Get("markdown")); keep {
+		return comments
+	}
+	for i := range comments {
+		comments[i].Raw = ""
+	}
+	return comments
+}
+
+// virtualKey is a middleware setting url param of virtual locator from key param, i.e. key=product/1 to
+// url=virtual:product/1, so apps without real urls of threads use the key instead of url everywhere
+func virtualKey(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		key := q.Get("key")
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if q.Get("url") != "" {
+			rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("both key and url set"),
+				"can't use key with url", rest.ErrDecode)
+			return
+		}
+		locator, err := store.VirtualLocator(q.Get("site"), key)
+		if err != nil {
+			rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "invalid key", rest.ErrDecode)
+			return
+		}
+		q.Del("key")
+		q.Set("url", locator.URL)
+		r.URL.RawQuery = q.Encode()
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
 // matchSiteID is a middleware rejecting users with mismatch between site param and and User.SiteID
 func matchSiteID(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
@@ -580,6 +1277,108 @@ func matchSiteID(next http.Handler) http.Handler {
 	return http.HandlerFunc(fn)
 }
 
+// basicAdminOnly is a middleware allowing requests of basic auth admin only, admins of sites rejected
+func basicAdminOnly(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		user, err := rest.GetUserInfo(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if user.Name != "admin" || user.ID != "admin" {
+			http.Error(w, "Access denied", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// adminAccess is a middleware allowing request by role of the user on the site. Read-only requests need
+// the permission, others at least roles.Moderate. Without roles all admins are owners.
+// Tokens pending two-factor auth rejected, admin granted by identity provider counted only if kept in the token.
+func (s *Rest) adminAccess(perm roles.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			user, err := rest.GetUserInfo(r)
+			if err != nil {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			required := perm
+			if r.Method != http.MethodGet && r.Method != http.MethodHead && required < roles.Moderate {
+				required = roles.Moderate
+			}
+			tu, tuErr := token.GetUserInfo(r)
+			if tuErr == nil && tu.BoolAttr(totp.PendingFlag) {
+				http.Error(w, "Access denied, two-factor verification required", http.StatusForbidden)
+				return
+			}
+			role := s.userRole(user)
+			if tuErr == nil && tu.BoolAttr(saml.AdminFlag) && user.Admin {
+				role = roles.Owner // admin granted by identity provider and kept in the token
+			}
+			if tuErr == nil && tu.StrAttr(apiTokenScopeAttr) != "" {
+				role = apitokens.Scope(tu.StrAttr(apiTokenScopeAttr)).Role()
+			}
+			if !role.Can(required) {
+				http.Error(w, "Access denied", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// apiTokenScopeAttr is an attribute of users made from api tokens, keeps scope of the token
+const apiTokenScopeAttr = "api_token_scope"
+
+// apiTokenAuth is a middleware authenticating requests with api token header, passing others to auth middleware.
+// User of the token is an admin of its site with role by scope. Read scope allows read-only requests only,
+// changes outside of admin api need full scope.
+func (s *Rest) apiTokenAuth(auth func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		authNext := auth(next)
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			value := r.Header.Get(apitokens.Header)
+			if value == "" || s.APITokens == nil {
+				authNext.ServeHTTP(w, r)
+				return
+			}
+			t, err := s.APITokens.Check(value)
+			if err != nil {
+				log.Printf("[WARN] api token rejected, %v", err)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			readOnly := r.Method == http.MethodGet || r.Method == http.MethodHead
+			adminAPI := strings.HasPrefix(r.URL.Path, "/api/v1/admin/")
+			if (t.Scope == apitokens.Read && !readOnly) || (t.Scope != apitokens.Full && !readOnly && !adminAPI) {
+				http.Error(w, "Access denied", http.StatusForbidden)
+				return
+			}
+			user := token.User{ID: apitokens.UserPrefix + t.ID, Name: t.Name, Audience: t.SiteID}
+			user.SetAdmin(true)
+			user.SetStrAttr(apiTokenScopeAttr, string(t.Scope))
+			next.ServeHTTP(w, token.SetUserInfo(r, user))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// userRole returns role of the user on the site of the token, basic auth admin is the owner.
+// Roles checked on each request, so changes applied immediately, without token refresh.
+func (s *Rest) userRole(user store.User) roles.Role {
+	if s.Roles == nil || (user.Name == "admin" && user.ID == "admin") {
+		if user.Admin {
+			return roles.Owner
+		}
+		return roles.None
+	}
+	return s.Roles.Role(user.SiteID, user.ID)
+}
+
 // cacheControl is a middleware setting cache expiration. Using url+version as etag
 func cacheControl(expiration time.Duration, version string) func(http.Handler) http.Handler {
 
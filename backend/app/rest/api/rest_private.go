@@ -3,12 +3,18 @@ package api
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
 	"io"
+	"io/ioutil"
+	"math"
 	"net/http"
+	"net/mail"
+	"strconv"
 	"strings"
 	"time"
 
@@ -21,42 +27,111 @@ import (
 	log "github.com/go-pkgz/lgr"
 	R "github.com/go-pkgz/rest"
 	"github.com/hashicorp/go-multierror"
-
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/umputun/remark42/backend/app/audit"
+	"github.com/umputun/remark42/backend/app/bookmarks"
+	"github.com/umputun/remark42/backend/app/captcha"
+	"github.com/umputun/remark42/backend/app/deletion"
+	"github.com/umputun/remark42/backend/app/drafts"
+	"github.com/umputun/remark42/backend/app/fingerprint"
+	"github.com/umputun/remark42/backend/app/gateway"
+	"github.com/umputun/remark42/backend/app/identity"
+	"github.com/umputun/remark42/backend/app/logging"
+	"github.com/umputun/remark42/backend/app/metrics"
+	"github.com/umputun/remark42/backend/app/moderation"
 	"github.com/umputun/remark42/backend/app/notify"
+	"github.com/umputun/remark42/backend/app/plugin"
+	"github.com/umputun/remark42/backend/app/ratelimit"
 	"github.com/umputun/remark42/backend/app/rest"
+	"github.com/umputun/remark42/backend/app/rest/sessions"
+	"github.com/umputun/remark42/backend/app/roles"
+	"github.com/umputun/remark42/backend/app/schedule"
+	"github.com/umputun/remark42/backend/app/settings"
+	"github.com/umputun/remark42/backend/app/spam"
 	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/attachment"
 	"github.com/umputun/remark42/backend/app/store/engine"
 	"github.com/umputun/remark42/backend/app/store/image"
 	"github.com/umputun/remark42/backend/app/store/service"
 	"github.com/umputun/remark42/backend/app/templates"
+	"github.com/umputun/remark42/backend/app/totp"
+	"github.com/umputun/remark42/backend/app/toxicity"
+	"github.com/umputun/remark42/backend/app/tracing"
+	"github.com/umputun/remark42/backend/app/trust"
+	"github.com/umputun/remark42/backend/app/verified"
+	"github.com/umputun/remark42/backend/app/votefraud"
 )
 
 type private struct {
 	dataService      privStore
 	cache            LoadingCache
-	readOnlyAge      int
+	settings         *settings.Service
 	commentFormatter *store.CommentFormatter
 	imageService     *image.Service
+	attachments      *attachment.Service
 	notifyService    *notify.Service
 	authenticator    *auth.Service
 	remarkURL        string
 	anonVote         bool
 	templates        templates.FileReader
+	bounceStore      notify.BounceStore
+	bounceSecret     string
+	replies          *gateway.Replies
+	replySecret      string
+	followStore      notify.FollowStore
+	readLimiter      *requestLimiter
+	updateLimiter    *requestLimiter
+	plugins          *plugin.Service
+	spamService      *spam.Service
+	toxicity         *toxicity.Service
+	moderationFilter *moderation.Filter
+	captcha          *captcha.Service
+	voteFraud        *votefraud.Detector
+	fingerprints     *fingerprint.Service
+	sessions         *sessions.Service
+	twoFactor        *totp.Service
+	accountDeletion  *deletion.Service
+	rateLimiter      *ratelimit.Limiter
+	audit            *audit.Service
+	schedule         *schedule.Service
+	verified         *verified.Service
+	trust            *trust.Service
+	roles            *roles.Service
+	drafts           *drafts.Service
+	bookmarks        *bookmarks.Service
+	identity         *identity.Service
+	links            store.Links
+	metrics          *metrics.Metrics
 }
 
 type privStore interface {
-	Create(comment store.Comment) (commentID string, err error)
+	CreateContext(ctx context.Context, comment store.Comment) (commentID string, err error)
 	EditComment(locator store.Locator, commentID string, req service.EditRequest) (comment store.Comment, err error)
-	Vote(req service.VoteReq) (comment store.Comment, err error)
+	VoteContext(ctx context.Context, req service.VoteReq) (comment store.Comment, err error)
+	React(req service.ReactReq) (comment store.Comment, err error)
 	Get(locator store.Locator, commentID string, user store.User) (store.Comment, error)
 	User(siteID, userID string, limit, skip int, user store.User) ([]store.Comment, error)
+	NextComment(locator store.Locator, user store.User) time.Time
+	Interval(locator store.Locator) int
 	GetUserEmail(siteID string, userID string) (string, error)
 	SetUserEmail(siteID string, userID string, value string) (string, error)
 	DeleteUserDetail(siteID string, userID string, detail engine.UserDetail) error
+	UnsubscribeEmail(siteID, email string) ([]string, error)
+	ConsentVersion(siteID string) string
+	HasConsent(siteID, userID string) (bool, error)
+	GetUserConsent(siteID, userID string) (engine.UserDetailEntry, error)
+	SetUserConsent(siteID, userID, version string) (engine.UserDetailEntry, error)
+	GetUserProfile(siteID, userID string) (store.Profile, error)
+	SetUserProfile(siteID string, user store.User, profile store.Profile) (store.Profile, error)
+	UserLimits(siteID, userID string, recent int) (service.UserLimits, error)
+	UserData(siteID, userID string) (service.UserData, error)
 	ValidateComment(c *store.Comment) error
 	IsVerified(siteID string, userID string) bool
 	IsReadOnly(locator store.Locator) bool
 	IsBlocked(siteID string, userID string) bool
+	IsShadowBanned(siteID, userID string) bool
+	Report(req service.ReportReq) (comment store.Comment, err error)
 	Info(locator store.Locator, readonlyAge int) (store.PostInfo, error)
 }
 
@@ -77,64 +152,220 @@ func (s *private) createCommentCtrl(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	externalID := comment.ExternalID
 	comment.PrepareUntrusted() // clean all fields user not supposed to set
+	if user.Admin {
+		comment.ExternalID = externalID // set by integrations to correlate comment with their own records
+	}
 	comment.User = user
 	comment.User.IP = strings.Split(r.RemoteAddr, ":")[0]
 
+	finalComment, err := s.createComment(r.Context(), newComment{Comment: comment, UserAgent: r.UserAgent(),
+		Referrer: r.Referer(), Captcha: captchaToken(r), Widget: true})
+	if err != nil {
+		rj := &rejection{}
+		if !errors.As(err, &rj) {
+			rj = &rejection{err: err, status: http.StatusInternalServerError, details: "can't save comment", code: rest.ErrInternal}
+		}
+		if rj.retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(rj.retryAfter.Seconds()))))
+		}
+		rest.SendErrorJSON(w, r, rj.status, rj.err, rj.details, rj.code)
+		return
+	}
+
+	if s.drafts != nil {
+		if e := s.drafts.Delete(comment.Locator.SiteID, comment.User.ID, comment.Locator.URL); e != nil {
+			log.Printf("[WARN] can't delete draft of %s, %v", comment.User.ID, e)
+		}
+	}
+
+	log.Printf("[DEBUG] created commend %+v", finalComment)
+
+	finalComment = withMarkdown(r, []store.Comment{finalComment})[0]
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, &finalComment)
+}
+
+// newComment is a comment to create with details of the request made it
+type newComment struct {
+	Comment   store.Comment
+	UserAgent string
+	Referrer  string
+	Captcha   string // token of captcha, checked for anonymous users
+	Widget    bool   // made with web widget, consent to legal terms and captcha required
+}
+
+// rejection of new comment with details of error response
+type rejection struct {
+	err        error
+	status     int
+	details    string
+	code       int
+	retryAfter time.Duration // set for comments rejected by rate or slow mode limits
+}
+
+func (r *rejection) Error() string { return r.details }
+
+// createComment validates, formats and saves new comment, with checks of blocked users, read-only posts, post flags,
+// rate limits, plugins, moderation filter, trust of the user and spam. Single path of comments of web widget and
+// comments made outside of it, like emails and mentions by other sites. Returns *rejection for rejected comment.
+func (s *private) createComment(ctx context.Context, req newComment) (store.Comment, error) {
+	comment, user := req.Comment, req.Comment.User
+
 	comment.Orig = comment.Text // original comment text, prior to md render
 	if err := s.dataService.ValidateComment(&comment); err != nil {
-		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "invalid comment", rest.ErrCommentValidation)
-		return
+		return store.Comment{}, &rejection{err: err, status: http.StatusBadRequest, details: "invalid comment",
+			code: rest.ErrCommentValidation}
 	}
 	comment = s.commentFormatter.Format(comment)
 
 	// check if images are valid
 	for _, id := range s.imageService.ExtractPictures(comment.Text) {
-		_, err := s.imageService.Load(id)
-		if err != nil {
-			rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't load picture from the comment", rest.ErrImgNotFound)
-			return
+		if _, err := s.imageService.Load(id); err != nil {
+			return store.Comment{}, &rejection{err: err, status: http.StatusBadRequest,
+				details: "can't load picture from the comment", code: rest.ErrImgNotFound}
+		}
+	}
+	for _, id := range s.attachments.Extract(comment.Text) {
+		if _, err := s.attachments.Load(id); err != nil {
+			return store.Comment{}, &rejection{err: err, status: http.StatusBadRequest,
+				details: "can't load file from the comment", code: rest.ErrAssetNotFound}
 		}
 	}
 
-	// check if user blocked
-	if s.dataService.IsBlocked(comment.Locator.SiteID, comment.User.ID) {
-		rest.SendErrorJSON(w, r, http.StatusForbidden, errors.New("rejected"), "user blocked", rest.ErrUserBlocked)
-		return
+	// check if user blocked, directly or by fingerprint of the new account
+	if s.dataService.IsBlocked(comment.Locator.SiteID, comment.User.ID) ||
+		(!user.Admin && s.fingerprints.Blocked(comment.Locator.SiteID, comment.User.IP, req.UserAgent)) {
+		return store.Comment{}, &rejection{err: errors.New("rejected"), status: http.StatusForbidden, details: "user blocked",
+			code: rest.ErrUserBlocked}
+	}
+
+	if state, openAt := s.schedule.Check(comment.Locator.SiteID, comment.Locator.URL); state == schedule.NotOpened {
+		return store.Comment{}, &rejection{err: fmt.Errorf("comments open at %s", openAt.Format(time.RFC3339)),
+			status: http.StatusForbidden, details: "post not opened for comments", code: rest.ErrNotOpened}
 	}
 
 	if s.isReadOnly(comment.Locator) {
-		rest.SendErrorJSON(w, r, http.StatusForbidden, errors.New("rejected"), "old post, read-only", rest.ErrReadOnly)
-		return
+		return store.Comment{}, &rejection{err: errors.New("rejected"), status: http.StatusForbidden,
+			details: "old post, read-only", code: rest.ErrReadOnly}
 	}
 
-	id, err := s.dataService.Create(comment)
-	if err == service.ErrRestrictedWordsFound {
-		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "invalid comment", rest.ErrCommentRestrictWords)
-		return
+	if !user.Admin {
+		if err := s.checkInterval(comment.Locator, user); err != nil {
+			return store.Comment{}, err
+		}
 	}
-	if err != nil {
-		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't save comment", rest.ErrInternal)
-		return
+
+	if !user.Admin && req.Widget {
+		consent, err := s.dataService.HasConsent(comment.Locator.SiteID, user.ID)
+		if err != nil {
+			return store.Comment{}, &rejection{err: err, status: http.StatusInternalServerError, details: "can't check consent",
+				code: rest.ErrInternal}
+		}
+		if !consent {
+			return store.Comment{}, &rejection{err: errors.New("rejected"), status: http.StatusForbidden,
+				details: "consent to legal terms required", code: rest.ErrConsentRequired}
+		}
 	}
 
-	// dataService modifies comment
-	finalComment, err := s.dataService.Get(comment.Locator, id, rest.GetUserOrEmpty(r))
+	if strings.HasPrefix(user.ID, "anonymous_") && req.Widget {
+		if err := s.captcha.Check(comment.Locator.SiteID, req.Captcha, comment.User.IP); err != nil {
+			return store.Comment{}, &rejection{err: err, status: http.StatusForbidden, details: "captcha rejected",
+				code: rest.ErrCaptcha}
+		}
+	}
+
+	if !user.Admin {
+		if allowed, retryAfter := s.rateLimiter.Allow(comment.Locator.SiteID, user.ID, comment.User.IP); !allowed {
+			return store.Comment{}, &rejection{err: errors.New("rate limit exceeded"), status: http.StatusTooManyRequests,
+				details: "too many comments, try again later", code: rest.ErrRateLimited, retryAfter: retryAfter}
+		}
+	}
+
+	ev, err := s.plugins.Before(plugin.Event{Hook: plugin.HookCommentCreate, SiteID: comment.Locator.SiteID,
+		Comment: &comment, User: &user})
 	if err != nil {
-		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't load created comment", rest.ErrInternal)
-		return
+		return store.Comment{}, &rejection{err: err, status: http.StatusForbidden, details: "rejected by plugin",
+			code: rest.ErrCommentRejected}
+	}
+	comment = *ev.Comment
+
+	alert := "" // reason of admin alert set by moderation rules
+	action, reason := s.checkModeration(comment)
+	switch action {
+	case moderation.Reject:
+		return store.Comment{}, &rejection{err: errors.New(reason), status: http.StatusForbidden,
+			details: "rejected by moderation filter", code: rest.ErrCommentRejected}
+	case moderation.Hold:
+		comment.Pending = true
+	case moderation.Notify:
+		alert = reason
+	}
+	if action != moderation.Approve && s.isUntrusted(comment) {
+		comment.Pending = true
 	}
-	s.cache.Flush(cache.Flusher(comment.Locator.SiteID).
-		Scopes(comment.Locator.URL, lastCommentsScope, comment.User.ID, comment.Locator.SiteID))
 
-	if s.notifyService != nil {
-		s.notifyService.Submit(notify.Request{Comment: finalComment})
+	var spamReq spam.Request
+	var isSpam bool
+	_ = tracing.Span(ctx, "spam.check", func(context.Context) error {
+		spamReq, isSpam = s.checkSpam(&comment, req.UserAgent, req.Referrer)
+		return nil
+	})
+	if isSpam {
+		return store.Comment{}, &rejection{err: errors.New("rejected"), status: http.StatusForbidden,
+			details: "rejected as spam", code: rest.ErrCommentRejected}
 	}
 
-	log.Printf("[DEBUG] created commend %+v", finalComment)
+	var id string
+	err = tracing.Span(ctx, "store.create", func(ctx context.Context) (err error) {
+		id, err = s.dataService.CreateContext(ctx, comment)
+		return err
+	})
+	if err == service.ErrRestrictedWordsFound {
+		return store.Comment{}, &rejection{err: err, status: http.StatusBadRequest, details: "invalid comment",
+			code: rest.ErrCommentRestrictWords}
+	}
+	if err == service.ErrExternalIDExists {
+		return store.Comment{}, &rejection{err: err, status: http.StatusConflict, details: "duplicate external id",
+			code: rest.ErrCommentValidation}
+	}
+	if err != nil {
+		return store.Comment{}, &rejection{err: err, status: http.StatusInternalServerError, details: "can't save comment",
+			code: rest.ErrInternal}
+	}
 
-	render.Status(r, http.StatusCreated)
-	render.JSON(w, r, &finalComment)
+	// dataService modifies comment
+	finalComment, err := s.dataService.Get(comment.Locator, id, user)
+	if err != nil {
+		return store.Comment{}, &rejection{err: err, status: http.StatusInternalServerError,
+			details: "can't load created comment", code: rest.ErrInternal}
+	}
+	_ = tracing.Span(ctx, "cache.flush", func(context.Context) error {
+		s.cache.Flush(cache.Flusher(comment.Locator.SiteID).
+			Scopes(comment.Locator.URL, lastCommentsScope, comment.User.ID, postsScope))
+		return nil
+	})
+	s.metrics.CommentCreated(comment.Locator.SiteID)
+	s.fingerprints.Record(finalComment, comment.User.IP, req.UserAgent)
+	created := finalComment
+	s.plugins.After(plugin.Event{Hook: plugin.HookCommentCreated, SiteID: comment.Locator.SiteID, Comment: &created, User: &user})
+
+	if s.spamService != nil {
+		s.spamService.Keep(id, spamReq)
+	}
+	// pending comment notified to admins only, users notified on approval.
+	// comment of shadow-banned user not notified, nobody else sees it
+	spanCtx, logFields := trace.SpanContextFromContext(ctx), logging.FromContext(ctx)
+	notifyComment := func(c store.Comment) {
+		if s.notifyService != nil && !s.dataService.IsShadowBanned(c.Locator.SiteID, c.User.ID) {
+			s.notifyService.Submit(notify.Request{Comment: c, Trace: spanCtx, Log: logFields, Alert: alert})
+		}
+	}
+	if !s.scoreToxicity(finalComment, notifyComment) {
+		notifyComment(finalComment)
+	}
+	return finalComment, nil
 }
 
 // PUT /comment/{id}?site=siteID&url=post-url - update comment
@@ -171,11 +402,38 @@ func (s *private) updateCommentCtrl(w http.ResponseWriter, r *http.Request) {
 	}
 
 	editReq := service.EditRequest{
-		Text:    s.commentFormatter.FormatText(edit.Text),
+		Text:    s.commentFormatter.FormatSiteText(locator.SiteID, edit.Text),
 		Orig:    edit.Text,
 		Summary: edit.Summary,
 		Delete:  edit.Delete,
 		Admin:   user.Admin,
+		UserID:  user.ID,
+	}
+
+	if edit.Delete {
+		ev := plugin.Event{Hook: plugin.HookCommentDeleting, SiteID: locator.SiteID, Comment: &currComment, User: &user}
+		if _, e := s.plugins.Before(ev); e != nil {
+			rest.SendErrorJSON(w, r, http.StatusForbidden, e, "rejected by plugin", rest.ErrCommentRejected)
+			return
+		}
+	}
+
+	if !edit.Delete {
+		edited := currComment
+		edited.Text, edited.Orig = editReq.Text, editReq.Orig
+		ev, e := s.plugins.Before(plugin.Event{Hook: plugin.HookCommentEdit, SiteID: locator.SiteID, Comment: &edited, User: &user})
+		if e != nil {
+			rest.SendErrorJSON(w, r, http.StatusForbidden, e, "rejected by plugin", rest.ErrCommentRejected)
+			return
+		}
+		editReq.Text, editReq.Orig = ev.Comment.Text, ev.Comment.Orig
+
+		// edited comment can't be held for review, any match rejects the edit
+		edited.Text, edited.Orig = editReq.Text, editReq.Orig
+		if action, reason := s.checkModeration(edited); action == moderation.Hold || action == moderation.Reject {
+			rest.SendErrorJSON(w, r, http.StatusForbidden, errors.New(reason), "rejected by moderation filter", rest.ErrCommentRejected)
+			return
+		}
 	}
 
 	res, err := s.dataService.EditComment(locator, id, editReq)
@@ -191,6 +449,23 @@ func (s *private) updateCommentCtrl(w http.ResponseWriter, r *http.Request) {
 	}
 
 	s.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.SiteID, locator.URL, lastCommentsScope, user.ID))
+	if user.Admin { // admins not restricted by edit duration, so their edits recorded
+		action := audit.ActionEdit
+		if edit.Delete {
+			action = audit.ActionDelete
+		}
+		s.audit.Record(audit.Entry{SiteID: locator.SiteID, Actor: user.ID, ActorName: user.Name, Action: action,
+			Target: id, URL: locator.URL, Reason: edit.Summary})
+	}
+	if edit.Delete {
+		s.metrics.CommentDeleted(locator.SiteID)
+		s.plugins.After(plugin.Event{Hook: plugin.HookCommentDelete, SiteID: locator.SiteID, Comment: &currComment, User: &user})
+	}
+	// edit of just posted comment updates its notification held for coalescing, dropped if deleted
+	if s.notifyService != nil {
+		s.notifyService.Submit(notify.Request{Comment: res, Edited: true})
+	}
+	res = withMarkdown(r, []store.Comment{res})[0]
 	render.JSON(w, r, res)
 }
 
@@ -207,6 +482,9 @@ func (s *private) userInfoCtrl(w http.ResponseWriter, r *http.Request) {
 		if len(email) > 0 {
 			user.EmailSubscription = true
 		}
+		if s.roles != nil {
+			user.Role = string(s.roles.Role(siteID, user.ID))
+		}
 	}
 
 	render.JSON(w, r, user)
@@ -243,189 +521,700 @@ func (s *private) voteCtrl(w http.ResponseWriter, r *http.Request) {
 		UserIP:    strings.Split(r.RemoteAddr, ":")[0],
 		Val:       vote,
 	}
-	comment, err := s.dataService.Vote(req)
+	var comment store.Comment
+	err := tracing.Span(r.Context(), "store.vote", func(ctx context.Context) (err error) {
+		comment, err = s.dataService.VoteContext(ctx, req)
+		return err
+	})
 	if err != nil {
 		code := parseError(err, rest.ErrVoteRejected)
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't vote for comment", code)
 		return
 	}
 	s.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.URL, comment.User.ID))
+	s.metrics.Voted(locator.SiteID, vote)
+	s.voteFraud.Record(comment, user.ID, req.UserIP, vote)
 	render.JSON(w, r, R.JSON{"id": comment.ID, "score": comment.Score})
 }
 
-// getEmailCtrl gets email address for authenticated user.
-// GET /email?site=siteID
-func (s *private) getEmailCtrl(w http.ResponseWriter, r *http.Request) {
+// PUT /react/{id}?site=siteID&url=post-url&reaction=heart - adds reaction of the user to the comment
+// DELETE /react/{id}?site=siteID&url=post-url&reaction=heart - removes reaction of the user
+func (s *private) reactCtrl(w http.ResponseWriter, r *http.Request) {
 	user := rest.MustGetUserInfo(r)
-	siteID := r.URL.Query().Get("site")
-	address, err := s.dataService.GetUserEmail(siteID, user.ID)
-	if err != nil {
-		log.Printf("[WARN] can't read email for %s, %v", user.ID, err)
+	if !s.anonVote && strings.HasPrefix(user.ID, "anonymous_") {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
 	}
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	id := chi.URLParam(r, "id")
+	reaction := r.URL.Query().Get("reaction")
+	log.Printf("[DEBUG] reaction %s for comment %s", reaction, id)
 
-	render.JSON(w, r, R.JSON{"user": user, "address": address})
-}
+	if s.isReadOnly(locator) {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, errors.New("rejected"), "old post, read-only", rest.ErrReadOnly)
+		return
+	}
 
-// sendEmailConfirmationCtrl gets address and siteID from query, makes confirmation token and sends it to user.
-// GET /email/subscribe?site=siteID&address=someone@example.com
-func (s *private) sendEmailConfirmationCtrl(w http.ResponseWriter, r *http.Request) {
-	user := rest.MustGetUserInfo(r)
-	address := r.URL.Query().Get("address")
-	siteID := r.URL.Query().Get("site")
-	if address == "" {
-		rest.SendErrorJSON(w, r, http.StatusBadRequest,
-			errors.New("missing parameter"), "address parameter is required", rest.ErrInternal)
+	if s.dataService.IsBlocked(locator.SiteID, user.ID) {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, errors.New("rejected"), "user blocked", rest.ErrUserBlocked)
 		return
 	}
-	existingAddress, err := s.dataService.GetUserEmail(siteID, user.ID)
-	if err != nil {
-		log.Printf("[WARN] can't read email for %s, %v", user.ID, err)
+
+	req := service.ReactReq{
+		Locator:   locator,
+		CommentID: id,
+		UserID:    user.ID,
+		Reaction:  reaction,
+		Remove:    r.Method == http.MethodDelete,
 	}
-	if address == existingAddress {
-		rest.SendErrorJSON(w, r, http.StatusConflict,
-			errors.New("already verified"), "email address is already verified for this user", rest.ErrInternal)
+	comment, err := s.dataService.React(req)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't react to comment", rest.ErrReactionRejected)
 		return
 	}
-	claims := token.Claims{
-		Handshake: &token.Handshake{ID: user.ID + "::" + address},
-		StandardClaims: jwt.StandardClaims{
-			Audience:  r.URL.Query().Get("site"),
-			ExpiresAt: time.Now().Add(30 * time.Minute).Unix(),
-			NotBefore: time.Now().Add(-1 * time.Minute).Unix(),
-			Issuer:    "remark42",
-		},
+	s.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.URL, comment.User.ID))
+	reactions := comment.Reactions
+	if reactions == nil {
+		reactions = map[string]int{}
 	}
+	render.JSON(w, r, R.JSON{"id": comment.ID, "reactions": reactions, "reacted": comment.Reacted})
+}
 
-	tkn, err := s.authenticator.TokenService().Token(claims)
-	if err != nil {
-		rest.SendErrorJSON(w, r, http.StatusForbidden, err, "failed to make verification token", rest.ErrInternal)
+// PUT /report/{id}?site=siteID&url=post-url - reports the comment to moderators, body is {"reason": "spam"}
+func (s *private) reportCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	id := chi.URLParam(r, "id")
+
+	body := struct {
+		Reason string `json:"reason"`
+	}{}
+	if err := render.DecodeJSON(http.MaxBytesReader(w, r.Body, hardBodyLimit), &body); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't bind report", rest.ErrDecode)
 		return
 	}
 
-	s.notifyService.SubmitVerification(
-		notify.VerificationRequest{
-			SiteID: siteID,
-			User:   user.Name,
-			Email:  address,
-			Token:  tkn,
-		},
-	)
+	if s.dataService.IsBlocked(locator.SiteID, user.ID) {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, errors.New("rejected"), "user blocked", rest.ErrUserBlocked)
+		return
+	}
 
-	render.JSON(w, r, R.JSON{"user": user, "address": address})
+	comment, err := s.dataService.Report(service.ReportReq{Locator: locator, CommentID: id, UserID: user.ID, Reason: body.Reason})
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't report comment", rest.ErrActionRejected)
+		return
+	}
+	log.Printf("[INFO] comment %s reported by %s", id, user.ID)
+	if comment.Pending { // moved to pending by reports
+		s.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.URL, lastCommentsScope, comment.User.ID))
+	}
+	render.JSON(w, r, R.JSON{"id": comment.ID, "reported": true})
 }
 
-// setConfirmedEmailCtrl uses provided token parameter (generated by sendEmailConfirmationCtrl) to set email and add it to user token
-// PUT /email/confirm?site=siteID&tkn=jwt
-func (s *private) setConfirmedEmailCtrl(w http.ResponseWriter, r *http.Request) {
-	tkn := r.URL.Query().Get("tkn")
-	if tkn == "" {
-		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("missing parameter"), "token parameter is required", rest.ErrInternal)
+// PUT /follow/{user}?site=siteID - follow comments of the user
+// DELETE /follow/{user}?site=siteID - stop following the user
+func (s *private) followCtrl(w http.ResponseWriter, r *http.Request) {
+	if s.followStore == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("following disabled"), "not found", rest.ErrActionRejected)
 		return
 	}
 	user := rest.MustGetUserInfo(r)
-	siteID := r.URL.Query().Get("site")
+	siteID, authorID := r.URL.Query().Get("site"), chi.URLParam(r, "user")
 
-	confClaims, err := s.authenticator.TokenService().Parse(tkn)
-	if err != nil {
-		rest.SendErrorJSON(w, r, http.StatusForbidden, err, "failed to verify confirmation token", rest.ErrInternal)
+	if r.Method == http.MethodDelete {
+		if err := s.followStore.Unfollow(siteID, user.ID, authorID); err != nil {
+			rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't unfollow user", rest.ErrActionRejected)
+			return
+		}
+		log.Printf("[DEBUG] user %s stopped following %s on %s", user.ID, authorID, siteID)
+		render.JSON(w, r, R.JSON{"user": authorID, "following": false})
 		return
 	}
 
-	if s.authenticator.TokenService().IsExpired(confClaims) {
-		rest.SendErrorJSON(w, r, http.StatusForbidden, errors.New("expired"), "failed to verify confirmation token", rest.ErrInternal)
+	if comments, err := s.dataService.User(siteID, authorID, 1, 0, store.User{}); err != nil || len(comments) == 0 {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, fmt.Errorf("no comments of %s", authorID),
+			"can't follow user", rest.ErrActionRejected)
 		return
 	}
+	if err := s.followStore.Follow(siteID, user.ID, authorID); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't follow user", rest.ErrActionRejected)
+		return
+	}
+	log.Printf("[DEBUG] user %s follows %s on %s", user.ID, authorID, siteID)
+	render.JSON(w, r, R.JSON{"user": authorID, "following": true})
+}
 
-	elems := strings.Split(confClaims.Handshake.ID, "::")
-	if len(elems) != 2 || elems[0] != user.ID {
-		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New(confClaims.Handshake.ID), "invalid handshake token", rest.ErrInternal)
+// GET /follow?site=siteID - list of users followed by the current user
+func (s *private) followingCtrl(w http.ResponseWriter, r *http.Request) {
+	if s.followStore == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("following disabled"), "not found", rest.ErrActionRejected)
 		return
 	}
-	address := elems[1]
+	user := rest.MustGetUserInfo(r)
+	following, err := s.followStore.Following(r.URL.Query().Get("site"), user.ID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get followed users", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, R.JSON{"following": following})
+}
 
-	log.Printf("[DEBUG] set email for user %s", user.ID)
+// GET /totp?site=siteID - two-factor auth status of the current user.
+// Pending means admin rights granted after code verified with POST /totp/verify.
+func (s *private) twoFactorStatusCtrl(w http.ResponseWriter, r *http.Request) {
+	claims, ok := s.twoFactorClaims(w, r)
+	if !ok {
+		return
+	}
+	siteID := r.URL.Query().Get("site")
+	render.JSON(w, r, R.JSON{"enrolled": s.twoFactor.Enrolled(siteID, claims.User.ID),
+		"enforced": s.twoFactor.Enforced(siteID), "pending": claims.User.BoolAttr(totp.PendingFlag),
+		"verified": claims.User.BoolAttr(totp.VerifiedFlag)})
+}
 
-	val, err := s.dataService.SetUserEmail(siteID, user.ID, address)
+// POST /totp/enroll?site=siteID - makes secret of authenticator app for admin, returns secret, otpauth url and qr code.
+// Enrollment confirmed with POST /totp/confirm.
+func (s *private) enrollTwoFactorCtrl(w http.ResponseWriter, r *http.Request) {
+	claims, ok := s.twoFactorClaims(w, r)
+	if !ok {
+		return
+	}
+	secret, err := s.twoFactor.Enroll(r.URL.Query().Get("site"), claims.User.ID, claims.User.Name)
 	if err != nil {
-		code := parseError(err, rest.ErrInternal)
-		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set email for user", code)
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't enroll two-factor auth", rest.ErrActionRejected)
 		return
 	}
+	render.JSON(w, r, secret)
+}
 
-	// update User.Email from the token
-	claims, _, err := s.authenticator.TokenService().Get(r)
+// POST /totp/confirm?site=siteID - confirms enrollment with the first code, body is {"code": "123456"}.
+// Returns backup codes, shown only once. Session marked verified.
+func (s *private) confirmTwoFactorCtrl(w http.ResponseWriter, r *http.Request) {
+	claims, ok := s.twoFactorClaims(w, r)
+	if !ok {
+		return
+	}
+	code, ok := s.twoFactorCode(w, r)
+	if !ok {
+		return
+	}
+	backupCodes, err := s.twoFactor.Confirm(r.URL.Query().Get("site"), claims.User.ID, code)
 	if err != nil {
-		rest.SendErrorJSON(w, r, http.StatusForbidden, err, "failed to verify confirmation token", rest.ErrInternal)
+		rest.SendErrorJSON(w, r, http.StatusForbidden, err, "can't confirm two-factor auth", rest.ErrActionRejected)
 		return
 	}
-	claims.User.Email = address
-	if _, err = s.authenticator.TokenService().Set(w, claims); err != nil {
-		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "failed to set token", rest.ErrInternal)
+	if !s.setTwoFactorVerified(w, r, claims) {
 		return
 	}
-	render.JSON(w, r, R.JSON{"updated": true, "address": val})
+	render.JSON(w, r, R.JSON{"enrolled": true, "backup_codes": backupCodes})
 }
 
-// POST/GET /email/unsubscribe.html?site=siteID&tkn=jwt - unsubscribe the user in token from email notifications
-func (s *private) emailUnsubscribeCtrl(w http.ResponseWriter, r *http.Request) {
-	tkn := r.URL.Query().Get("tkn")
-	if tkn == "" {
-		rest.SendErrorHTML(w, r, http.StatusBadRequest,
-			errors.New("missing parameter"), "token parameter is required", rest.ErrInternal, s.templates)
+// POST /totp/verify?site=siteID - verifies code of authenticator app or backup code, body is {"code": "123456"}.
+// Admin rights of the session granted after verification.
+func (s *private) verifyTwoFactorCtrl(w http.ResponseWriter, r *http.Request) {
+	claims, ok := s.twoFactorClaims(w, r)
+	if !ok {
 		return
 	}
-	siteID := r.URL.Query().Get("site")
-
-	confClaims, err := s.authenticator.TokenService().Parse(tkn)
-	if err != nil {
-		rest.SendErrorHTML(w, r, http.StatusForbidden, err, "failed to verify confirmation token", rest.ErrInternal, s.templates)
+	code, ok := s.twoFactorCode(w, r)
+	if !ok {
 		return
 	}
-
-	if s.authenticator.TokenService().IsExpired(confClaims) {
-		rest.SendErrorHTML(w, r, http.StatusForbidden,
-			errors.New("expired"), "failed to verify confirmation token", rest.ErrInternal, s.templates)
+	if err := s.twoFactor.Verify(r.URL.Query().Get("site"), claims.User.ID, code); err != nil {
+		rest.SendErrorJSON(w, r, twoFactorErrStatus(err), err, "can't verify two-factor auth", rest.ErrActionRejected)
 		return
 	}
-
-	elems := strings.Split(confClaims.Handshake.ID, "::")
-	if len(elems) != 2 {
-		rest.SendErrorHTML(w, r, http.StatusBadRequest,
-			errors.New(confClaims.Handshake.ID), "invalid handshake token", rest.ErrInternal, s.templates)
+	if !s.setTwoFactorVerified(w, r, claims) {
 		return
 	}
-	userID := elems[0]
-	address := elems[1]
+	render.JSON(w, r, R.JSON{"verified": true})
+}
 
-	existingAddress, err := s.dataService.GetUserEmail(siteID, userID)
-	if err != nil {
-		log.Printf("[WARN] can't read email for %s, %v", userID, err)
+// DELETE /totp?site=siteID - disables two-factor auth of verified admin, body is {"code": "123456"}.
+// Rejected on sites enforcing two-factor auth.
+func (s *private) disableTwoFactorCtrl(w http.ResponseWriter, r *http.Request) {
+	claims, ok := s.twoFactorClaims(w, r)
+	if !ok {
+		return
 	}
-	if existingAddress == "" {
-		rest.SendErrorHTML(w, r, http.StatusConflict,
-			errors.New("user is not subscribed"), "user does not have active email subscription", rest.ErrInternal, s.templates)
+	siteID := r.URL.Query().Get("site")
+	if !claims.User.IsAdmin() || s.twoFactor.Enforced(siteID) {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, errors.New("can't disable two-factor auth"),
+			"two-factor auth required", rest.ErrActionRejected)
 		return
 	}
-	if address != existingAddress {
-		rest.SendErrorHTML(w, r, http.StatusBadRequest,
-			errors.New("wrong email unsubscription"), "email address in request does not match known for this user",
-			rest.ErrInternal, s.templates)
+	code, ok := s.twoFactorCode(w, r)
+	if !ok {
 		return
 	}
-
-	log.Printf("[DEBUG] unsubscribe user %s", userID)
-
-	if err = s.dataService.DeleteUserDetail(siteID, userID, engine.UserEmail); err != nil {
-		code := parseError(err, rest.ErrInternal)
-		rest.SendErrorHTML(w, r, http.StatusBadRequest, err, "can't delete email for user", code, s.templates)
+	if err := s.twoFactor.Disable(siteID, claims.User.ID, code); err != nil {
+		rest.SendErrorJSON(w, r, twoFactorErrStatus(err), err, "can't disable two-factor auth", rest.ErrActionRejected)
 		return
 	}
-	// clean User.Email from the token, if user has the token
+	render.JSON(w, r, R.JSON{"enrolled": false})
+}
+
+// twoFactorClaims returns claims of admin or admin pending two-factor auth, sends error otherwise
+func (s *private) twoFactorClaims(w http.ResponseWriter, r *http.Request) (token.Claims, bool) {
+	if s.twoFactor == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("two-factor auth disabled"), "not found", rest.ErrActionRejected)
+		return token.Claims{}, false
+	}
 	claims, _, err := s.authenticator.TokenService().Get(r)
-	if err != nil {
-		log.Printf("[DEBUG] unsubscribed user doesn't have valid JWT token to update %s, %v", userID, err)
+	if err != nil || claims.User == nil {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, errors.New("no token"), "two-factor auth requires token",
+			rest.ErrActionRejected)
+		return token.Claims{}, false
 	}
-	if claims.User != nil && claims.User.Email != "" {
+	if !claims.User.IsAdmin() && !claims.User.BoolAttr(totp.PendingFlag) {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, errors.New("not an admin"), "two-factor auth available to admins only",
+			rest.ErrActionRejected)
+		return token.Claims{}, false
+	}
+	return claims, true
+}
+
+// twoFactorErrStatus returns http status of failed verification, locked one retried later
+func twoFactorErrStatus(err error) int {
+	if err == totp.ErrLocked {
+		return http.StatusTooManyRequests
+	}
+	return http.StatusForbidden
+}
+
+// twoFactorCode returns code from request body
+func (s *private) twoFactorCode(w http.ResponseWriter, r *http.Request) (string, bool) {
+	req := struct {
+		Code string `json:"code"`
+	}{}
+	if err := render.DecodeJSON(http.MaxBytesReader(w, r.Body, hardBodyLimit), &req); err != nil || req.Code == "" {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("no code"), "code required", rest.ErrDecode)
+		return "", false
+	}
+	return strings.TrimSpace(req.Code), true
+}
+
+// setTwoFactorVerified marks session verified and updates token, admin rights granted by ClaimsUpd on token update
+func (s *private) setTwoFactorVerified(w http.ResponseWriter, r *http.Request, claims token.Claims) bool {
+	claims.User.SetBoolAttr(totp.VerifiedFlag, true)
+	if _, err := s.authenticator.TokenService().Set(w, claims); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "failed to set token", rest.ErrInternal)
+		return false
+	}
+	log.Printf("[INFO] two-factor auth of %s verified for %s", claims.User.ID, r.URL.Query().Get("site"))
+	return true
+}
+
+// getEmailCtrl gets email address for authenticated user.
+// GET /email?site=siteID
+func (s *private) getEmailCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	siteID := r.URL.Query().Get("site")
+	address, err := s.dataService.GetUserEmail(siteID, user.ID)
+	if err != nil {
+		log.Printf("[WARN] can't read email for %s, %v", user.ID, err)
+	}
+
+	render.JSON(w, r, R.JSON{"user": user, "address": address})
+}
+
+// GET /profile?site=siteID - returns profile and notification email of the current user
+func (s *private) getProfileCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	siteID := r.URL.Query().Get("site")
+	profile, err := s.dataService.GetUserProfile(siteID, user.ID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get profile", rest.ErrInternal)
+		return
+	}
+	address, err := s.dataService.GetUserEmail(siteID, user.ID)
+	if err != nil {
+		log.Printf("[WARN] can't read email for %s, %v", user.ID, err)
+	}
+	render.JSON(w, r, R.JSON{"user": user, "profile": profile, "email": address})
+}
+
+// PUT /profile?site=siteID - sets profile of the current user, body is
+// {"display_name": "name", "website": "https://example.com", "bio": "text", "email": "user@example.com"}.
+// Email is optional, changed address confirmed over email the same way as with /email/subscribe, empty one removed.
+func (s *private) setProfileCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	siteID := r.URL.Query().Get("site")
+	req := struct {
+		store.Profile
+		Email *string `json:"email"`
+	}{}
+	if err := render.DecodeJSON(http.MaxBytesReader(w, r.Body, hardBodyLimit), &req); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't bind profile", rest.ErrDecode)
+		return
+	}
+	if s.dataService.IsBlocked(siteID, user.ID) {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, errors.New("rejected"), "user blocked", rest.ErrUserBlocked)
+		return
+	}
+
+	address, err := s.dataService.GetUserEmail(siteID, user.ID)
+	if err != nil {
+		log.Printf("[WARN] can't read email for %s, %v", user.ID, err)
+	}
+	newAddress := req.Email != nil && strings.TrimSpace(*req.Email) != "" && strings.TrimSpace(*req.Email) != address
+	if newAddress {
+		if _, err = mail.ParseAddress(strings.TrimSpace(*req.Email)); err != nil {
+			rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "invalid email address", rest.ErrActionRejected)
+			return
+		}
+	}
+
+	profile, err := s.dataService.SetUserProfile(siteID, user, req.Profile)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "invalid profile", rest.ErrActionRejected)
+		return
+	}
+	s.cache.Flush(cache.Flusher(siteID).Scopes(user.ID, siteID, lastCommentsScope))
+
+	res := R.JSON{"profile": profile, "email": address}
+	switch {
+	case newAddress:
+		if err = s.sendEmailConfirmation(siteID, user, strings.TrimSpace(*req.Email)); err != nil {
+			rest.SendErrorJSON(w, r, http.StatusForbidden, err, "failed to make verification token", rest.ErrInternal)
+			return
+		}
+		res["email_confirmation"] = true
+	case req.Email != nil && strings.TrimSpace(*req.Email) == "" && address != "":
+		if err = s.dataService.DeleteUserDetail(siteID, user.ID, engine.UserEmail); err != nil {
+			rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't delete email for user", rest.ErrInternal)
+			return
+		}
+		log.Printf("[INFO] audit: email of %s removed on %s with profile update", user.ID, siteID)
+		res["email"] = ""
+	}
+	render.JSON(w, r, res)
+}
+
+// GET /consent?site=siteID - returns required version of legal terms and user's consent
+func (s *private) getConsentCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	siteID := r.URL.Query().Get("site")
+	consent, err := s.dataService.GetUserConsent(siteID, user.ID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get consent", rest.ErrInternal)
+		return
+	}
+	version := s.dataService.ConsentVersion(siteID)
+	render.JSON(w, r, R.JSON{"version": version, "consent": consent.Consent, "time": consent.ConsentTime,
+		"accepted": version == "" || consent.Consent == version})
+}
+
+// POST /consent?site=siteID&version=v1 - records user's consent with the current version of legal terms
+func (s *private) setConsentCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	siteID := r.URL.Query().Get("site")
+	consent, err := s.dataService.SetUserConsent(siteID, user.ID, r.URL.Query().Get("version"))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set consent", rest.ErrActionRejected)
+		return
+	}
+	log.Printf("[INFO] user %s accepted legal terms %s of %s", user.ID, consent.Consent, siteID)
+	render.JSON(w, r, R.JSON{"version": consent.Consent, "consent": consent.Consent, "time": consent.ConsentTime,
+		"accepted": true})
+}
+
+// sendEmailConfirmationCtrl gets address and siteID from query, makes confirmation token and sends it to user.
+// GET /email/subscribe?site=siteID&address=someone@example.com
+func (s *private) sendEmailConfirmationCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	address := r.URL.Query().Get("address")
+	siteID := r.URL.Query().Get("site")
+	if address == "" {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest,
+			errors.New("missing parameter"), "address parameter is required", rest.ErrInternal)
+		return
+	}
+	existingAddress, err := s.dataService.GetUserEmail(siteID, user.ID)
+	if err != nil {
+		log.Printf("[WARN] can't read email for %s, %v", user.ID, err)
+	}
+	if address == existingAddress {
+		rest.SendErrorJSON(w, r, http.StatusConflict,
+			errors.New("already verified"), "email address is already verified for this user", rest.ErrInternal)
+		return
+	}
+	if err = s.sendEmailConfirmation(siteID, user, address); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, err, "failed to make verification token", rest.ErrInternal)
+		return
+	}
+
+	render.JSON(w, r, R.JSON{"user": user, "address": address})
+}
+
+// changeEmailCtrl sends confirmation token to the new address of the user subscribed to email notifications.
+// Subscription switched to the new address once confirmed with /email/confirm, previous address notified about the change.
+// POST /email/change?site=siteID&address=someone@example.com
+func (s *private) changeEmailCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	address := strings.TrimSpace(r.URL.Query().Get("address"))
+	siteID := r.URL.Query().Get("site")
+	if _, err := mail.ParseAddress(address); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "invalid email address", rest.ErrActionRejected)
+		return
+	}
+	existingAddress, err := s.dataService.GetUserEmail(siteID, user.ID)
+	if err != nil {
+		log.Printf("[WARN] can't read email for %s, %v", user.ID, err)
+	}
+	if existingAddress == "" {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("no email"),
+			"user not subscribed to email notifications", rest.ErrActionRejected)
+		return
+	}
+	if address == existingAddress {
+		rest.SendErrorJSON(w, r, http.StatusConflict,
+			errors.New("already verified"), "email address is already verified for this user", rest.ErrInternal)
+		return
+	}
+	if err = s.sendEmailConfirmation(siteID, user, address); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, err, "failed to make verification token", rest.ErrInternal)
+		return
+	}
+	log.Printf("[INFO] change of email requested by %s on %s", user.ID, siteID)
+	render.JSON(w, r, R.JSON{"user": user, "address": address, "email_confirmation": true})
+}
+
+// sendEmailConfirmation makes confirmation token for the address and sends it to user
+func (s *private) sendEmailConfirmation(siteID string, user store.User, address string) error {
+	claims := token.Claims{
+		Handshake: &token.Handshake{ID: user.ID + "::" + address},
+		StandardClaims: jwt.StandardClaims{
+			Audience:  siteID,
+			ExpiresAt: time.Now().Add(30 * time.Minute).Unix(),
+			NotBefore: time.Now().Add(-1 * time.Minute).Unix(),
+			Issuer:    "remark42",
+		},
+	}
+
+	tkn, err := s.authenticator.TokenService().Token(claims)
+	if err != nil {
+		return err
+	}
+
+	s.notifyService.SubmitVerification(
+		notify.VerificationRequest{
+			SiteID: siteID,
+			User:   user.Name,
+			Email:  address,
+			Token:  tkn,
+		},
+	)
+	return nil
+}
+
+// setConfirmedEmailCtrl uses provided token parameter (generated by sendEmailConfirmationCtrl) to set email and add it to user token
+// PUT /email/confirm?site=siteID&tkn=jwt
+func (s *private) setConfirmedEmailCtrl(w http.ResponseWriter, r *http.Request) {
+	tkn := r.URL.Query().Get("tkn")
+	if tkn == "" {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("missing parameter"), "token parameter is required", rest.ErrInternal)
+		return
+	}
+	user := rest.MustGetUserInfo(r)
+	siteID := r.URL.Query().Get("site")
+
+	confClaims, err := s.authenticator.TokenService().Parse(tkn)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, err, "failed to verify confirmation token", rest.ErrInternal)
+		return
+	}
+
+	if s.authenticator.TokenService().IsExpired(confClaims) {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, errors.New("expired"), "failed to verify confirmation token", rest.ErrInternal)
+		return
+	}
+
+	elems := strings.Split(confClaims.Handshake.ID, "::")
+	if len(elems) != 2 || elems[0] != user.ID {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New(confClaims.Handshake.ID), "invalid handshake token", rest.ErrInternal)
+		return
+	}
+	address := elems[1]
+
+	log.Printf("[DEBUG] set email for user %s", user.ID)
+
+	prevAddress, err := s.dataService.GetUserEmail(siteID, user.ID)
+	if err != nil {
+		log.Printf("[WARN] can't read email for %s, %v", user.ID, err)
+	}
+	val, err := s.dataService.SetUserEmail(siteID, user.ID, address)
+	if err != nil {
+		code := parseError(err, rest.ErrInternal)
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't set email for user", code)
+		return
+	}
+	if prevAddress != "" && prevAddress != val {
+		log.Printf("[INFO] audit: email of %s changed on %s", user.ID, siteID)
+		s.notifyService.SubmitVerification(notify.VerificationRequest{SiteID: siteID, User: user.Name, Email: prevAddress,
+			NewEmail: val})
+	}
+
+	// update User.Email from the token
+	claims, _, err := s.authenticator.TokenService().Get(r)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, err, "failed to verify confirmation token", rest.ErrInternal)
+		return
+	}
+	if s.verified != nil {
+		s.verified.Evaluate(siteID, user.ID, "", address)
+	}
+
+	claims.User.Email = address
+	if _, err = s.authenticator.TokenService().Set(w, claims); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "failed to set token", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, R.JSON{"updated": true, "address": val})
+}
+
+// GET/POST /email/vote.html?tkn=jwt - upvote comment from the link in reply notification email.
+// Token issued for the recipient of the notification and the comment, vote applied once.
+func (s *private) emailVoteCtrl(w http.ResponseWriter, r *http.Request) {
+	tkn := r.URL.Query().Get("tkn")
+	if tkn == "" {
+		rest.SendErrorHTML(w, r, http.StatusBadRequest,
+			errors.New("missing parameter"), "token parameter is required", rest.ErrInternal, s.templates)
+		return
+	}
+
+	voteClaims, err := s.authenticator.TokenService().Parse(tkn)
+	if err != nil {
+		rest.SendErrorHTML(w, r, http.StatusForbidden, err, "failed to verify vote token", rest.ErrInternal, s.templates)
+		return
+	}
+	if s.authenticator.TokenService().IsExpired(voteClaims) {
+		rest.SendErrorHTML(w, r, http.StatusForbidden,
+			errors.New("expired"), "failed to verify vote token", rest.ErrInternal, s.templates)
+		return
+	}
+
+	// handshake id is vote::userID::commentID, post url in handshake's from
+	elems := []string{}
+	if voteClaims.Handshake != nil {
+		elems = strings.Split(voteClaims.Handshake.ID, "::")
+	}
+	if len(elems) != 3 || elems[0] != "vote" || voteClaims.Handshake.From == "" {
+		rest.SendErrorHTML(w, r, http.StatusBadRequest,
+			errors.New("bad vote token"), "invalid handshake token", rest.ErrInternal, s.templates)
+		return
+	}
+	userID, commentID := elems[1], elems[2]
+	locator := store.Locator{SiteID: voteClaims.Audience, URL: voteClaims.Handshake.From}
+
+	if !s.anonVote && strings.HasPrefix(userID, "anonymous_") {
+		rest.SendErrorHTML(w, r, http.StatusForbidden, errors.New("rejected"), "anonymous votes not allowed",
+			rest.ErrVoteRejected, s.templates)
+		return
+	}
+	if s.isReadOnly(locator) {
+		rest.SendErrorHTML(w, r, http.StatusForbidden, errors.New("rejected"), "old post, read-only",
+			rest.ErrReadOnly, s.templates)
+		return
+	}
+	if s.dataService.IsBlocked(locator.SiteID, userID) {
+		rest.SendErrorHTML(w, r, http.StatusForbidden, errors.New("rejected"), "user blocked",
+			rest.ErrUserBlocked, s.templates)
+		return
+	}
+
+	comment, err := s.dataService.Get(locator, commentID, store.User{ID: userID})
+	if err != nil {
+		rest.SendErrorHTML(w, r, http.StatusBadRequest, err, "can't get comment", rest.ErrCommentNotFound, s.templates)
+		return
+	}
+
+	voted := comment.Vote != 0 // vote applied once, repeated clicks and votes made on the site not changed
+	if !voted {
+		req := service.VoteReq{Locator: locator, CommentID: commentID, UserID: userID,
+			UserIP: strings.Split(r.RemoteAddr, ":")[0], Val: true}
+		if comment, err = s.dataService.VoteContext(r.Context(), req); err != nil {
+			code := parseError(err, rest.ErrVoteRejected)
+			rest.SendErrorHTML(w, r, http.StatusBadRequest, err, "can't vote for comment", code, s.templates)
+			return
+		}
+		s.cache.Flush(cache.Flusher(locator.SiteID).Scopes(locator.URL, comment.User.ID))
+		s.metrics.Voted(locator.SiteID, true)
+		log.Printf("[DEBUG] user %s voted for comment %s from email", userID, commentID)
+	}
+
+	tmplFile, err := s.templates.ReadFile("email_vote.html.tmpl")
+	if err != nil {
+		rest.SendErrorHTML(w, r, http.StatusInternalServerError, err, "can't read vote template", rest.ErrInternal, s.templates)
+		return
+	}
+	tmpl, err := template.New("vote").Parse(string(tmplFile))
+	if err != nil {
+		rest.SendErrorHTML(w, r, http.StatusInternalServerError, err, "can't parse vote template", rest.ErrInternal, s.templates)
+		return
+	}
+	msg := bytes.Buffer{}
+	tmplData := struct {
+		UserName     string
+		CommentLink  string
+		AlreadyVoted bool
+	}{UserName: comment.User.Name, CommentLink: s.links.Comment(locator, commentID), AlreadyVoted: voted}
+	if err = tmpl.Execute(&msg, tmplData); err != nil {
+		rest.SendErrorHTML(w, r, http.StatusInternalServerError, err, "can't execute vote template", rest.ErrInternal, s.templates)
+		return
+	}
+	render.HTML(w, r, msg.String())
+}
+
+// POST/GET /email/unsubscribe.html?site=siteID&tkn=jwt - unsubscribe the user in token from email notifications
+func (s *private) emailUnsubscribeCtrl(w http.ResponseWriter, r *http.Request) {
+	tkn := r.URL.Query().Get("tkn")
+	if tkn == "" {
+		rest.SendErrorHTML(w, r, http.StatusBadRequest,
+			errors.New("missing parameter"), "token parameter is required", rest.ErrInternal, s.templates)
+		return
+	}
+	siteID := r.URL.Query().Get("site")
+
+	userID, address, status, err := s.parseUnsubscribeToken(tkn)
+	if err != nil {
+		msg := "failed to verify confirmation token"
+		if status == http.StatusBadRequest {
+			msg = "invalid handshake token"
+		}
+		rest.SendErrorHTML(w, r, status, err, msg, rest.ErrInternal, s.templates)
+		return
+	}
+
+	existingAddress, err := s.dataService.GetUserEmail(siteID, userID)
+	if err != nil {
+		log.Printf("[WARN] can't read email for %s, %v", userID, err)
+	}
+	if existingAddress == "" {
+		rest.SendErrorHTML(w, r, http.StatusConflict,
+			errors.New("user is not subscribed"), "user does not have active email subscription", rest.ErrInternal, s.templates)
+		return
+	}
+	if address != existingAddress {
+		rest.SendErrorHTML(w, r, http.StatusBadRequest,
+			errors.New("wrong email unsubscription"), "email address in request does not match known for this user",
+			rest.ErrInternal, s.templates)
+		return
+	}
+
+	log.Printf("[DEBUG] unsubscribe user %s", userID)
+
+	if err = s.dataService.DeleteUserDetail(siteID, userID, engine.UserEmail); err != nil {
+		code := parseError(err, rest.ErrInternal)
+		rest.SendErrorHTML(w, r, http.StatusBadRequest, err, "can't delete email for user", code, s.templates)
+		return
+	}
+	// clean User.Email from the token, if user has the token
+	claims, _, err := s.authenticator.TokenService().Get(r)
+	if err != nil {
+		log.Printf("[DEBUG] unsubscribed user doesn't have valid JWT token to update %s, %v", userID, err)
+	}
+	if claims.User != nil && claims.User.Email != "" {
 		claims.User.Email = ""
 		if _, err = s.authenticator.TokenService().Set(w, claims); err != nil {
 			rest.SendErrorHTML(w, r, http.StatusInternalServerError, err, "failed to set token", rest.ErrInternal, s.templates)
@@ -453,6 +1242,149 @@ func (s *private) emailUnsubscribeCtrl(w http.ResponseWriter, r *http.Request) {
 	render.HTML(w, r, msg.String())
 }
 
+// POST /email/unsubscribe?site=siteID&tkn=jwt - one-click unsubscribe by RFC 8058, posted by mail clients with
+// List-Unsubscribe=One-Click body. Responds without confirmation page, GET redirected to the page for users.
+func (s *private) emailOneClickUnsubscribeCtrl(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		// link scanners and clients without one-click support get the regular page, nothing changed on GET
+		http.Redirect(w, r, s.remarkURL+"/email/unsubscribe.html?"+r.URL.RawQuery, http.StatusFound)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, hardBodyLimit)
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("List-Unsubscribe") != "One-Click" {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("missing List-Unsubscribe=One-Click"),
+			"not a one-click unsubscribe request", rest.ErrDecode)
+		return
+	}
+	siteID := r.URL.Query().Get("site")
+
+	userID, address, status, err := s.parseUnsubscribeToken(r.URL.Query().Get("tkn"))
+	if err != nil {
+		rest.SendErrorJSON(w, r, status, err, "failed to verify unsubscribe token", rest.ErrNoAccess)
+		return
+	}
+
+	existingAddress, err := s.dataService.GetUserEmail(siteID, userID)
+	if err != nil {
+		log.Printf("[WARN] can't read email for %s, %v", userID, err)
+	}
+	// repeated request or subscription changed to another address, the address in token is not subscribed already
+	if existingAddress == "" || existingAddress != address {
+		render.JSON(w, r, R.JSON{"unsubscribed": true})
+		return
+	}
+
+	if err = s.dataService.DeleteUserDetail(siteID, userID, engine.UserEmail); err != nil {
+		code := parseError(err, rest.ErrInternal)
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't delete email for user", code)
+		return
+	}
+	log.Printf("[INFO] user %s unsubscribed with one-click", userID)
+	render.JSON(w, r, R.JSON{"unsubscribed": true})
+}
+
+// parseUnsubscribeToken verifies unsubscribe token and returns user and address from it, with http status for errors
+func (s *private) parseUnsubscribeToken(tkn string) (userID, address string, status int, err error) {
+	if tkn == "" {
+		return "", "", http.StatusBadRequest, errors.New("missing token")
+	}
+	confClaims, err := s.authenticator.TokenService().Parse(tkn)
+	if err != nil {
+		return "", "", http.StatusForbidden, err
+	}
+	if s.authenticator.TokenService().IsExpired(confClaims) {
+		return "", "", http.StatusForbidden, errors.New("expired")
+	}
+	if confClaims.Handshake == nil {
+		return "", "", http.StatusBadRequest, errors.New("no handshake in token")
+	}
+	elems := strings.Split(confClaims.Handshake.ID, "::")
+	if len(elems) != 2 {
+		return "", "", http.StatusBadRequest, errors.New(confClaims.Handshake.ID)
+	}
+	return elems[0], elems[1], http.StatusOK, nil
+}
+
+// POST /email/bounce?site=siteID&provider=[ses|mailgun|generic] - webhook for bounce and complaint events from email provider.
+// Requires basic auth with bounce secret as a password. Records bounces and unsubscribes all users with bounced address.
+func (s *private) emailBounceCtrl(w http.ResponseWriter, r *http.Request) {
+	if s.bounceStore == nil || s.bounceSecret == "" {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("bounce processing disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	if _, passwd, ok := r.BasicAuth(); !ok || subtle.ConstantTimeCompare([]byte(passwd), []byte(s.bounceSecret)) != 1 {
+		rest.SendErrorJSON(w, r, http.StatusUnauthorized, errors.New("unauthorized"), "invalid bounce secret", rest.ErrNoAccess)
+		return
+	}
+
+	siteID := r.URL.Query().Get("site")
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, hardBodyLimit))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't read bounce", rest.ErrDecode)
+		return
+	}
+	bounces, err := notify.ParseBounces(r.URL.Query().Get("provider"), body)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't parse bounce", rest.ErrDecode)
+		return
+	}
+
+	unsubscribed := []string{}
+	for _, b := range bounces {
+		b.SiteID = siteID
+		if b, err = s.bounceStore.Add(b); err != nil {
+			rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't save bounce", rest.ErrInternal)
+			return
+		}
+		log.Printf("[INFO] %s bounce for %s, site %s, count %d, %s", b.Kind, b.Email, siteID, b.Count, b.Reason)
+		users, e := s.dataService.UnsubscribeEmail(siteID, b.Email)
+		if e != nil {
+			rest.SendErrorJSON(w, r, http.StatusInternalServerError, e, "can't unsubscribe bounced email", rest.ErrInternal)
+			return
+		}
+		unsubscribed = append(unsubscribed, users...)
+	}
+	render.JSON(w, r, R.JSON{"bounces": len(bounces), "unsubscribed": unsubscribed})
+}
+
+// POST /email/reply?provider=[ses|mailgun|generic] - webhook for inbound emails sent to reply addresses of notifications.
+// Requires basic auth with reply secret as a password. Creates reply to the comment of notification.
+func (s *private) emailReplyCtrl(w http.ResponseWriter, r *http.Request) {
+	if s.replies == nil || s.replySecret == "" {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("replies by email disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	if _, passwd, ok := r.BasicAuth(); !ok || subtle.ConstantTimeCompare([]byte(passwd), []byte(s.replySecret)) != 1 {
+		rest.SendErrorJSON(w, r, http.StatusUnauthorized, errors.New("unauthorized"), "invalid reply secret", rest.ErrNoAccess)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, hardBodyLimit))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't read email", rest.ErrDecode)
+		return
+	}
+	in, err := gateway.ParseInbound(r.URL.Query().Get("provider"), r.Header.Get("Content-Type"), body)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't parse email", rest.ErrDecode)
+		return
+	}
+	if in == nil { // not an email, like sns subscription confirmation
+		render.JSON(w, r, R.JSON{"created": false})
+		return
+	}
+
+	comment, err := s.replies.Process(*in)
+	if err != nil {
+		// rejected replies are not retried by providers with 2xx response
+		log.Printf("[WARN] reply from %s rejected, %v", in.Sender, err)
+		render.JSON(w, r, R.JSON{"created": false, "error": err.Error()})
+		return
+	}
+	render.JSON(w, r, R.JSON{"created": true, "id": comment.ID})
+}
+
 // DELETE /email?site=siteID - removes user's email
 func (s *private) deleteEmailCtrl(w http.ResponseWriter, r *http.Request) {
 	user := rest.MustGetUserInfo(r)
@@ -480,7 +1412,62 @@ func (s *private) deleteEmailCtrl(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, R.JSON{"deleted": true})
 }
 
-// GET /userdata?site=siteID - exports all data about the user as a json with user info and list of all comments
+// GET /user/limits?site=siteID&url=post-url - returns rate limits, posting quotas and edit windows of the latest comments.
+// url is optional and used to report read-only status of the post.
+func (s *private) userLimitsCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.MustGetUserInfo(r)
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+
+	limits, err := s.dataService.UserLimits(locator.SiteID, user.ID, 10)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get user limits", rest.ErrInternal)
+		return
+	}
+
+	anonymous := strings.HasPrefix(user.ID, "anonymous_")
+	res := struct {
+		service.UserLimits
+		RateLimit struct {
+			Read   requestLimit `json:"read"`   // finding comments
+			Update requestLimit `json:"update"` // posting comments
+		} `json:"rate_limit"`
+		Posting struct {
+			User        ratelimit.Status `json:"user"`                   // comments of the user
+			IP          ratelimit.Status `json:"ip"`                     // comments from the ip
+			Interval    int              `json:"interval"`               // minutes between comments to the post, 0 for no limit
+			NextComment *time.Time       `json:"next_comment,omitempty"` // next comment to the post allowed at
+		} `json:"posting"`
+		MaxImageSize  int  `json:"max_image_size"`
+		CanPostImages bool `json:"can_post_images"`
+		CanSubscribe  bool `json:"can_subscribe"`
+		CanVote       bool `json:"can_vote"`
+		ReadOnly      bool `json:"read_only"`
+	}{
+		UserLimits:    limits,
+		MaxImageSize:  s.imageService.MaxSize,
+		CanPostImages: !anonymous && !limits.Blocked,
+		CanSubscribe:  !anonymous && !limits.Blocked,
+		CanVote:       !limits.Blocked && (!anonymous || s.anonVote),
+	}
+	res.RateLimit.Read = s.readLimiter.Status(r, "/api/v1/find")
+	res.RateLimit.Update = s.updateLimiter.Status(r, "/api/v1/comment")
+	res.Posting.User, res.Posting.IP = ratelimit.Status{Remaining: -1}, ratelimit.Status{Remaining: -1} // admins not limited
+	if !user.Admin {
+		res.Posting.User, res.Posting.IP = s.rateLimiter.Status(locator.SiteID, user.ID, strings.Split(r.RemoteAddr, ":")[0])
+	}
+	if locator.URL != "" {
+		res.ReadOnly = s.isReadOnly(locator)
+		res.Posting.Interval = s.dataService.Interval(locator)
+		if next := s.dataService.NextComment(locator, user); !next.IsZero() && !user.Admin {
+			res.Posting.NextComment = &next
+		}
+	}
+
+	render.JSON(w, r, res)
+}
+
+// GET /userdata?site=siteID - exports all data about the user as a json with user info, list of all comments,
+// stored personal details and votes
 func (s *private) userAllDataCtrl(w http.ResponseWriter, r *http.Request) {
 	siteID := r.URL.Query().Get("site")
 	user := rest.MustGetUserInfo(r)
@@ -529,6 +1516,25 @@ func (s *private) userAllDataCtrl(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// stored personal data and votes of the user
+	data, err := s.dataService.UserData(siteID, user.ID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get user data", rest.ErrInternal)
+		return
+	}
+	detailsB, err := json.Marshal(data.Details)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't marshal user details", rest.ErrInternal)
+		return
+	}
+	votesB, err := json.Marshal(data.Votes)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't marshal user votes", rest.ErrInternal)
+		return
+	}
+	merr = multierror.Append(merr, write([]byte(`, "details":`)), write(detailsB))
+	merr = multierror.Append(merr, write([]byte(`, "votes":`)), write(votesB))
+
 	merr = multierror.Append(merr, write([]byte(`}`)))
 	if merr.(*multierror.Error).ErrorOrNil() != nil {
 		rest.SendErrorJSON(w, r, http.StatusInternalServerError, merr, "can't write user info", rest.ErrInternal)
@@ -569,6 +1575,429 @@ func (s *private) deleteMeCtrl(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, R.JSON{"site": siteID, "user_id": user.ID, "token": tokenStr, "link": link})
 }
 
+// POST /account/delete?site=siteID - requests deletion of the account, sends confirmation link to the verified email.
+// Deletion scheduled after confirmation and executed after the grace period.
+func (s *private) requestAccountDeletionCtrl(w http.ResponseWriter, r *http.Request) {
+	if s.accountDeletion == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("disabled"), "account deletion disabled", rest.ErrActionRejected)
+		return
+	}
+	user := rest.MustGetUserInfo(r)
+	siteID := r.URL.Query().Get("site")
+
+	address, err := s.dataService.GetUserEmail(siteID, user.ID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't read email", rest.ErrInternal)
+		return
+	}
+	if address == "" {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("no email"),
+			"verified email is required to confirm deletion", rest.ErrActionRejected)
+		return
+	}
+
+	// handshake id is delete::userID, user's picture in handshake's from to remove the avatar with the account
+	claims := token.Claims{
+		Handshake: &token.Handshake{ID: "delete::" + user.ID, From: user.Picture},
+		StandardClaims: jwt.StandardClaims{
+			Audience:  siteID,
+			ExpiresAt: time.Now().Add(24 * time.Hour).Unix(),
+			NotBefore: time.Now().Add(-1 * time.Minute).Unix(),
+			Issuer:    "remark42",
+		},
+	}
+	tkn, err := s.authenticator.TokenService().Token(claims)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't make token", rest.ErrInternal)
+		return
+	}
+
+	s.notifyService.SubmitVerification(notify.VerificationRequest{
+		SiteID:      siteID,
+		User:        user.Name,
+		Email:       address,
+		Token:       tkn,
+		DeletionURL: fmt.Sprintf("%s/account/delete.html?tkn=%s", s.remarkURL, tkn),
+	})
+	render.JSON(w, r, R.JSON{"site": siteID, "user_id": user.ID, "address": address})
+}
+
+// GET/POST /account/delete.html?tkn=jwt - confirms deletion of the account from the link in confirmation email
+func (s *private) confirmAccountDeletionCtrl(w http.ResponseWriter, r *http.Request) {
+	if s.accountDeletion == nil {
+		rest.SendErrorHTML(w, r, http.StatusNotFound, errors.New("disabled"), "account deletion disabled",
+			rest.ErrActionRejected, s.templates)
+		return
+	}
+	tkn := r.URL.Query().Get("tkn")
+	if tkn == "" {
+		rest.SendErrorHTML(w, r, http.StatusBadRequest,
+			errors.New("missing parameter"), "token parameter is required", rest.ErrInternal, s.templates)
+		return
+	}
+
+	confClaims, err := s.authenticator.TokenService().Parse(tkn)
+	if err != nil {
+		rest.SendErrorHTML(w, r, http.StatusForbidden, err, "failed to verify confirmation token", rest.ErrInternal, s.templates)
+		return
+	}
+	if s.authenticator.TokenService().IsExpired(confClaims) {
+		rest.SendErrorHTML(w, r, http.StatusForbidden,
+			errors.New("expired"), "failed to verify confirmation token", rest.ErrInternal, s.templates)
+		return
+	}
+
+	elems := []string{}
+	if confClaims.Handshake != nil {
+		elems = strings.Split(confClaims.Handshake.ID, "::")
+	}
+	if len(elems) != 2 || elems[0] != "delete" || elems[1] == "" {
+		rest.SendErrorHTML(w, r, http.StatusBadRequest,
+			errors.New("bad deletion token"), "invalid handshake token", rest.ErrInternal, s.templates)
+		return
+	}
+
+	req, err := s.accountDeletion.Schedule(deletion.Request{SiteID: confClaims.Audience, UserID: elems[1],
+		Picture: confClaims.Handshake.From})
+	if err != nil {
+		rest.SendErrorHTML(w, r, http.StatusInternalServerError, err, "can't schedule deletion", rest.ErrInternal, s.templates)
+		return
+	}
+
+	tmplFile, err := s.templates.ReadFile("account_delete.html.tmpl")
+	if err != nil {
+		rest.SendErrorHTML(w, r, http.StatusInternalServerError, err, "can't read deletion template", rest.ErrInternal, s.templates)
+		return
+	}
+	tmpl, err := template.New("delete").Parse(string(tmplFile))
+	if err != nil {
+		rest.SendErrorHTML(w, r, http.StatusInternalServerError, err, "can't parse deletion template", rest.ErrInternal, s.templates)
+		return
+	}
+	msg := bytes.Buffer{}
+	tmplData := struct {
+		Site      string
+		Scheduled string
+	}{Site: req.SiteID, Scheduled: req.Scheduled.Format("January 2, 2006 15:04 MST")}
+	if err = tmpl.Execute(&msg, tmplData); err != nil {
+		rest.SendErrorHTML(w, r, http.StatusInternalServerError, err, "can't execute deletion template", rest.ErrInternal, s.templates)
+		return
+	}
+	render.HTML(w, r, msg.String())
+}
+
+// GET /account/delete?site=siteID - returns scheduled deletion of the account, 404 if not scheduled
+func (s *private) accountDeletionStatusCtrl(w http.ResponseWriter, r *http.Request) {
+	if s.accountDeletion == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("disabled"), "account deletion disabled", rest.ErrActionRejected)
+		return
+	}
+	user := rest.MustGetUserInfo(r)
+	req, found, err := s.accountDeletion.Status(r.URL.Query().Get("site"), user.ID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get scheduled deletion", rest.ErrInternal)
+		return
+	}
+	if !found {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("not scheduled"), "deletion not scheduled", rest.ErrActionRejected)
+		return
+	}
+	render.JSON(w, r, req)
+}
+
+// DELETE /account/delete?site=siteID - cancels scheduled deletion of the account
+func (s *private) cancelAccountDeletionCtrl(w http.ResponseWriter, r *http.Request) {
+	if s.accountDeletion == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("disabled"), "account deletion disabled", rest.ErrActionRejected)
+		return
+	}
+	user := rest.MustGetUserInfo(r)
+	canceled, err := s.accountDeletion.Cancel(r.URL.Query().Get("site"), user.ID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't cancel deletion", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, R.JSON{"canceled": canceled})
+}
+
+// GET /draft?site=siteID&url=post-url - returns draft of the current user to the post, 404 if not saved or expired
+func (s *private) getDraftCtrl(w http.ResponseWriter, r *http.Request) {
+	if s.drafts == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("disabled"), "drafts disabled", rest.ErrActionRejected)
+		return
+	}
+	user := rest.MustGetUserInfo(r)
+	d, found, err := s.drafts.Get(r.URL.Query().Get("site"), user.ID, r.URL.Query().Get("url"))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get draft", rest.ErrInternal)
+		return
+	}
+	if !found {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("not found"), "no draft", rest.ErrActionRejected)
+		return
+	}
+	render.JSON(w, r, d)
+}
+
+// PUT /draft?site=siteID&url=post-url - saves draft of the current user to the post, body is {"text": "...", "pid": "parent-id"}.
+// Replaces previous draft to the same post.
+func (s *private) saveDraftCtrl(w http.ResponseWriter, r *http.Request) {
+	if s.drafts == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("disabled"), "drafts disabled", rest.ErrActionRejected)
+		return
+	}
+	req := struct {
+		Text     string `json:"text"`
+		ParentID string `json:"pid"`
+	}{}
+	if err := render.DecodeJSON(http.MaxBytesReader(w, r.Body, hardBodyLimit), &req); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't bind draft", rest.ErrDecode)
+		return
+	}
+	user := rest.MustGetUserInfo(r)
+	d, err := s.drafts.Save(drafts.Draft{SiteID: r.URL.Query().Get("site"), UserID: user.ID, URL: r.URL.Query().Get("url"),
+		ParentID: req.ParentID, Text: req.Text})
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't save draft", rest.ErrActionRejected)
+		return
+	}
+	render.JSON(w, r, d)
+}
+
+// DELETE /draft?site=siteID&url=post-url - deletes draft of the current user to the post
+func (s *private) deleteDraftCtrl(w http.ResponseWriter, r *http.Request) {
+	if s.drafts == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("disabled"), "drafts disabled", rest.ErrActionRejected)
+		return
+	}
+	user := rest.MustGetUserInfo(r)
+	if err := s.drafts.Delete(r.URL.Query().Get("site"), user.ID, r.URL.Query().Get("url")); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't delete draft", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, R.JSON{"deleted": true})
+}
+
+// GET /bookmarks?site=siteID - returns bookmarks of the current user with bookmarked comments, the most recent first.
+// Bookmarks of deleted comments skipped.
+func (s *private) bookmarksCtrl(w http.ResponseWriter, r *http.Request) {
+	if s.bookmarks == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("disabled"), "bookmarks disabled", rest.ErrActionRejected)
+		return
+	}
+	user := rest.MustGetUserInfo(r)
+	list, err := s.bookmarks.List(r.URL.Query().Get("site"), user.ID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get bookmarks", rest.ErrInternal)
+		return
+	}
+	type bookmark struct {
+		bookmarks.Bookmark
+		Comment store.Comment `json:"comment"`
+	}
+	res := []bookmark{}
+	for _, b := range list {
+		c, e := s.dataService.Get(store.Locator{SiteID: b.SiteID, URL: b.URL}, b.CommentID, user)
+		if e != nil || c.Deleted {
+			continue
+		}
+		res = append(res, bookmark{Bookmark: b, Comment: c})
+	}
+	render.JSON(w, r, R.JSON{"bookmarks": res, "count": len(res)})
+}
+
+// GET /bookmarks/post?site=siteID&url=post-url - returns numbers of bookmarks by comment id on the post
+// and ids of comments bookmarked by the current user
+func (s *private) postBookmarksCtrl(w http.ResponseWriter, r *http.Request) {
+	if s.bookmarks == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("disabled"), "bookmarks disabled", rest.ErrActionRejected)
+		return
+	}
+	user := rest.MustGetUserInfo(r)
+	counts, saved, err := s.bookmarks.Post(r.URL.Query().Get("site"), user.ID, r.URL.Query().Get("url"))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get bookmarks of post", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, R.JSON{"counts": counts, "saved": saved})
+}
+
+// PUT /bookmark/{id}?site=siteID&url=post-url - bookmarks the comment for the current user
+func (s *private) addBookmarkCtrl(w http.ResponseWriter, r *http.Request) {
+	if s.bookmarks == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("disabled"), "bookmarks disabled", rest.ErrActionRejected)
+		return
+	}
+	user := rest.MustGetUserInfo(r)
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	id := chi.URLParam(r, "id")
+	c, err := s.dataService.Get(locator, id, user)
+	if err != nil || c.Deleted {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, fmt.Errorf("no comment %s", id), "can't bookmark comment",
+			rest.ErrCommentNotFound)
+		return
+	}
+	if _, err = s.bookmarks.Add(locator.SiteID, user.ID, locator.URL, id); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't bookmark comment", rest.ErrActionRejected)
+		return
+	}
+	counts, _, err := s.bookmarks.Post(locator.SiteID, user.ID, locator.URL)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get bookmarks of post", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, R.JSON{"id": id, "bookmarked": true, "count": counts[id]})
+}
+
+// DELETE /bookmark/{id}?site=siteID - removes bookmark of the comment for the current user
+func (s *private) deleteBookmarkCtrl(w http.ResponseWriter, r *http.Request) {
+	if s.bookmarks == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("disabled"), "bookmarks disabled", rest.ErrActionRejected)
+		return
+	}
+	user := rest.MustGetUserInfo(r)
+	id := chi.URLParam(r, "id")
+	if err := s.bookmarks.Delete(r.URL.Query().Get("site"), user.ID, id); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't delete bookmark", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, R.JSON{"id": id, "bookmarked": false})
+}
+
+// GET /identity?site=siteID - returns canonical id of the current user and identities linked to it
+func (s *private) identityCtrl(w http.ResponseWriter, r *http.Request) {
+	if s.identity == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("disabled"), "linking of identities disabled", rest.ErrActionRejected)
+		return
+	}
+	user := rest.MustGetUserInfo(r)
+	canonical, links, err := s.identity.Links(r.URL.Query().Get("site"), user.ID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't get linked identities", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, R.JSON{"user": canonical, "linked": links})
+}
+
+// POST /identity/link?site=siteID - makes one-time code to link another identity to the current user.
+// The code confirmed by the user logged in with another auth provider.
+func (s *private) requestLinkCtrl(w http.ResponseWriter, r *http.Request) {
+	if s.identity == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("disabled"), "linking of identities disabled", rest.ErrActionRejected)
+		return
+	}
+	user := rest.MustGetUserInfo(r)
+	req, err := s.identity.Request(r.URL.Query().Get("site"), user.ID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't request link of identity", rest.ErrActionRejected)
+		return
+	}
+	render.JSON(w, r, R.JSON{"code": req.Code, "expires": req.Expires})
+}
+
+// POST /identity/link/confirm?site=siteID&code=code - links the current identity to the user requested the code.
+// Comments, votes and details of the current identity merged to that user, later logins mapped to it.
+func (s *private) confirmLinkCtrl(w http.ResponseWriter, r *http.Request) {
+	if s.identity == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("disabled"), "linking of identities disabled", rest.ErrActionRejected)
+		return
+	}
+	user := rest.MustGetUserInfo(r)
+	siteID := r.URL.Query().Get("site")
+	link, err := s.identity.Confirm(siteID, user.ID, r.URL.Query().Get("code"))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't link identity", rest.ErrActionRejected)
+		return
+	}
+	s.cache.Flush(cache.Flusher(siteID)) // comments and votes of many posts changed
+	render.JSON(w, r, R.JSON{"user": link.UserID, "linked": link.LinkedID})
+}
+
+// POST /session/refresh-token?site=siteID - makes refresh token of the current session, replacing previous one.
+// Returns the token and expiration of the session, the token exchanged for new JWT with POST /session/refresh.
+func (s *private) issueRefreshTokenCtrl(w http.ResponseWriter, r *http.Request) {
+	if s.sessions == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("sessions disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	claims, _, err := s.authenticator.TokenService().Get(r)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, errors.New("no token"), "refresh token requires token", rest.ErrActionRejected)
+		return
+	}
+	refreshToken, expires, err := s.sessions.Issue(claims)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, err, "can't issue refresh token", rest.ErrActionRejected)
+		return
+	}
+	render.JSON(w, r, R.JSON{"refresh_token": refreshToken, "expires": expires})
+}
+
+// POST /session/refresh - exchanges refresh token for new JWT and new refresh token, body is {"refresh_token": "..."}.
+// JWT set as cookie, or as X-JWT header if send-jwt-header enabled. Refresh token can be used only once.
+func (s *private) refreshSessionCtrl(w http.ResponseWriter, r *http.Request) {
+	if s.sessions == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("sessions disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	req := struct {
+		RefreshToken string `json:"refresh_token"`
+	}{}
+	if err := render.DecodeJSON(http.MaxBytesReader(w, r.Body, hardBodyLimit), &req); err != nil || req.RefreshToken == "" {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("no refresh token"), "refresh token required", rest.ErrDecode)
+		return
+	}
+	sess, refreshToken, err := s.sessions.Refresh(req.RefreshToken)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusUnauthorized, err, "can't refresh session", rest.ErrActionRejected)
+		return
+	}
+	claims := token.Claims{User: sess.User, StandardClaims: jwt.StandardClaims{Id: sess.ID, Audience: sess.SiteID}}
+	if claims, err = s.authenticator.TokenService().Set(w, claims); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "failed to set token", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, R.JSON{"user": claims.User, "refresh_token": refreshToken, "expires": sess.Expires})
+}
+
+// GET /sessions?site=siteID - returns active sessions of the current user and id of the current one
+func (s *private) sessionsCtrl(w http.ResponseWriter, r *http.Request) {
+	if s.sessions == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("sessions disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	user := rest.MustGetUserInfo(r)
+	list, err := s.sessions.List(r.URL.Query().Get("site"), user.ID)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't list sessions", rest.ErrInternal)
+		return
+	}
+	current := ""
+	if claims, _, e := s.authenticator.TokenService().Get(r); e == nil {
+		current = claims.Id
+	}
+	render.JSON(w, r, R.JSON{"sessions": list, "current": current})
+}
+
+// DELETE /sessions/{id}?site=siteID - revokes session of the current user, tokens of the session rejected
+func (s *private) revokeSessionCtrl(w http.ResponseWriter, r *http.Request) {
+	if s.sessions == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("sessions disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	user := rest.MustGetUserInfo(r)
+	err := s.sessions.Revoke(r.URL.Query().Get("site"), user.ID, chi.URLParam(r, "id"))
+	if err == sessions.ErrNotFound {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, err, "no session", rest.ErrActionRejected)
+		return
+	}
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't revoke session", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, R.JSON{"revoked": true})
+}
+
 // POST /image - save image with form request
 func (s *private) savePictureCtrl(w http.ResponseWriter, r *http.Request) {
 	user := rest.MustGetUserInfo(r)
@@ -594,10 +2023,77 @@ func (s *private) savePictureCtrl(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, R.JSON{"id": id})
 }
 
+// checkSpam checks comment of non-admin user with spam service and marks suspected spam as pending.
+// Returns true if comment should be rejected, i.e. for blatant spam or for any spam with spam.Params.Reject.
+func (s *private) checkSpam(comment *store.Comment, userAgent, referrer string) (req spam.Request, reject bool) {
+	if s.spamService == nil || comment.User.Admin {
+		return spam.Request{}, false
+	}
+	req = spam.Request{Comment: *comment, UserIP: comment.User.IP, UserAgent: userAgent, Referrer: referrer}
+	verdict := s.spamService.Check(req)
+	if verdict == spam.Blatant || verdict == spam.Spam && s.spamService.Reject {
+		return req, true
+	}
+	if verdict == spam.Spam {
+		comment.Pending = true
+	}
+	return req, false
+}
+
+// scoreToxicity queues comment of non-admin user for toxicity scoring, cached comments flushed once it scored.
+// On sites holding toxic comments notification delayed till the comment scored, returns true in this case.
+func (s *private) scoreToxicity(comment store.Comment, notifyComment func(store.Comment)) (delayed bool) {
+	if comment.User.Admin {
+		return false
+	}
+	policy, _ := s.toxicity.Policy(comment.Locator.SiteID)
+	hold := policy == toxicity.Hold
+	queued := s.toxicity.Submit(comment, func(scored store.Comment) {
+		s.cache.Flush(cache.Flusher(comment.Locator.SiteID).
+			Scopes(comment.Locator.URL, lastCommentsScope, comment.User.ID, postsScope))
+		if hold {
+			comment.Pending = scored.Pending
+			notifyComment(comment)
+		}
+	})
+	return queued && hold
+}
+
+// checkModeration checks comment of non-admin user with moderation filter
+func (s *private) checkModeration(comment store.Comment) (moderation.Action, string) {
+	if s.moderationFilter == nil || comment.User.Admin {
+		return moderation.Pass, ""
+	}
+	return s.moderationFilter.Check(comment)
+}
+
+// checkInterval rejects new comment of the user to the post with interval if the user commented it
+// less than interval ago. Returns *rejection if comment rejected, nil otherwise.
+func (s *private) checkInterval(locator store.Locator, user store.User) error {
+	next := s.dataService.NextComment(locator, user)
+	if next.IsZero() {
+		return nil
+	}
+	return &rejection{err: fmt.Errorf("one comment per %d minutes, next comment allowed at %s", s.dataService.Interval(locator),
+		next.Format(time.RFC3339)), status: http.StatusTooManyRequests, details: "post commented recently, try again later",
+		code: rest.ErrCommentInterval, retryAfter: time.Until(next)}
+}
+
+// isUntrusted checks if comment of non-admin user should be held for pre-moderation, as the user is not trusted yet
+func (s *private) isUntrusted(comment store.Comment) bool {
+	if s.trust == nil || comment.User.Admin || comment.Pending {
+		return false
+	}
+	return s.trust.Hold(comment.Locator.SiteID, comment.User.ID)
+}
+
 func (s *private) isReadOnly(locator store.Locator) bool {
-	if s.readOnlyAge > 0 {
+	if state, _ := s.schedule.Check(locator.SiteID, locator.URL); state != schedule.Open {
+		return true // closed by schedule, or not opened yet
+	}
+	if readOnlyAge := s.settings.ReadOnlyAge(locator.SiteID); readOnlyAge > 0 {
 		// check RO by age
-		if info, e := s.dataService.Info(locator, s.readOnlyAge); e == nil && info.ReadOnly {
+		if info, e := s.dataService.Info(locator, readOnlyAge); e == nil && info.ReadOnly {
 			return true
 		}
 	}
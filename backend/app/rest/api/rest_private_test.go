@@ -4,14 +4,22 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec // sha1 is the default algorithm of authenticator apps
+	"encoding/base32"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"mime/multipart"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path"
 	"strings"
 	"testing"
 	"time"
@@ -23,10 +31,27 @@ import (
 	R "github.com/go-pkgz/rest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
-
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark42/backend/app/bookmarks"
+	"github.com/umputun/remark42/backend/app/captcha"
+	"github.com/umputun/remark42/backend/app/deletion"
+	"github.com/umputun/remark42/backend/app/drafts"
+	"github.com/umputun/remark42/backend/app/gateway"
+	"github.com/umputun/remark42/backend/app/identity"
+	"github.com/umputun/remark42/backend/app/moderation"
 	"github.com/umputun/remark42/backend/app/notify"
+	"github.com/umputun/remark42/backend/app/plugin"
+	"github.com/umputun/remark42/backend/app/ratelimit"
+	"github.com/umputun/remark42/backend/app/rest"
+	"github.com/umputun/remark42/backend/app/rest/sessions"
+	"github.com/umputun/remark42/backend/app/spam"
 	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
 	"github.com/umputun/remark42/backend/app/store/image"
+	"github.com/umputun/remark42/backend/app/store/service"
+	"github.com/umputun/remark42/backend/app/totp"
+	"github.com/umputun/remark42/backend/app/toxicity"
 )
 
 // gopher png for test, from https://golang.org/src/image/png/example_test.go
@@ -55,6 +80,588 @@ func TestRest_Create(t *testing.T) {
 	assert.True(t, len(c["id"].(string)) > 8)
 }
 
+func TestRest_CreateWithExternalID(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.DataService.ExternalIDs = service.NewEngineExternalIDs(srv.DataService.Engine)
+
+	body := `{"text": "from crm", "external_id": "ticket-42", "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`
+	resp, err := post(t, ts.URL+"/api/v1/comment", body)
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusCreated, resp.StatusCode, string(b))
+	created := store.Comment{}
+	require.NoError(t, json.Unmarshal(b, &created))
+	assert.Equal(t, "ticket-42", created.ExternalID)
+
+	resp, err = post(t, ts.URL+"/api/v1/comment", body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "duplicate external id")
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/admin/external?site=remark42&id=ticket-42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	res, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/external?site=remark42&id=ticket-42")
+	require.Equal(t, http.StatusOK, code, res)
+	found := store.Comment{}
+	require.NoError(t, json.Unmarshal([]byte(res), &found))
+	assert.Equal(t, created.ID, found.ID)
+
+	_, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/external?site=remark42&id=ticket-1")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// external id of non-admin ignored
+	id := addComment(t, store.Comment{Text: "user comment", ExternalID: "ticket-43",
+		Locator: store.Locator{URL: "https://radio-t.com/blah1", SiteID: "remark42"}}, ts)
+	_, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/external?site=remark42&id=ticket-43")
+	assert.Equal(t, http.StatusNotFound, code, "comment %s created without external id", id)
+}
+
+func TestRest_CreateWithSpamCheck(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	spamTS, _ := spamServer(t)
+	defer spamTS.Close()
+	srv.privRest.spamService = spam.NewService(&spam.Remote{API: spamTS.URL}, spam.Params{})
+	defer srv.privRest.spamService.Close()
+
+	create := func(text string) (code int, c store.Comment) {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/comment",
+			strings.NewReader(`{"text": "`+text+`", "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`))
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, devToken)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusCreated {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&c))
+		}
+		return resp.StatusCode, c
+	}
+
+	code, c := create("good comment")
+	require.Equal(t, http.StatusCreated, code)
+	assert.False(t, c.Pending)
+
+	code, c = create("buy spam")
+	require.Equal(t, http.StatusCreated, code)
+	assert.True(t, c.Pending, "suspected spam held for moderation")
+
+	code, _ = create("blatant spam")
+	assert.Equal(t, http.StatusForbidden, code, "blatant spam rejected")
+
+	res, code := get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah1&format=plain")
+	require.Equal(t, http.StatusOK, code)
+	comments := commentsWithInfo{}
+	require.NoError(t, json.Unmarshal([]byte(res), &comments))
+	require.Equal(t, 1, len(comments.Comments), "pending comment hidden for anonymous")
+	assert.Equal(t, "good comment", comments.Comments[0].Orig)
+
+	res, code = getWithDevAuth(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah1&format=plain")
+	require.Equal(t, http.StatusOK, code)
+	require.NoError(t, json.Unmarshal([]byte(res), &comments))
+	assert.Equal(t, 2, len(comments.Comments), "pending comment visible for its author")
+
+	srv.privRest.spamService.Reject = true
+	code, _ = create("more spam")
+	assert.Equal(t, http.StatusForbidden, code, "suspected spam rejected")
+}
+
+func TestRest_CreateWithToxicity(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.privRest.toxicity = toxicity.NewService(&toxicity.Stub{Words: []string{"idiot"}}, srv.DataService,
+		toxicity.Params{Policy: func(string) (string, float64) { return "hold", 0.5 }})
+
+	good := addComment(t, store.Comment{Text: "nice post", Locator: store.Locator{URL: "https://radio-t.com/blah1", SiteID: "remark42"}}, ts)
+	toxic := addComment(t, store.Comment{Text: "you idiot", Locator: store.Locator{URL: "https://radio-t.com/blah1", SiteID: "remark42"}}, ts)
+	srv.privRest.toxicity.Close() // waits for queued comments
+
+	res, code := get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah1&format=plain")
+	require.Equal(t, http.StatusOK, code)
+	comments := commentsWithInfo{}
+	require.NoError(t, json.Unmarshal([]byte(res), &comments))
+	require.Equal(t, 1, len(comments.Comments), "toxic comment held")
+	assert.Equal(t, good, comments.Comments[0].ID)
+	assert.Equal(t, 0.0, comments.Comments[0].Toxicity, "score hidden from users")
+
+	c, err := srv.DataService.Get(store.Locator{URL: "https://radio-t.com/blah1", SiteID: "remark42"}, toxic, store.User{Admin: true})
+	require.NoError(t, err)
+	assert.True(t, c.Pending)
+	assert.Equal(t, 1.0, c.Toxicity)
+}
+
+func TestRest_Profile(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	profile := func(method, body string) (code int, res R.JSON) {
+		req, err := http.NewRequest(method, ts.URL+"/api/v1/profile?site=remark42", strings.NewReader(body))
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, devToken)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		return resp.StatusCode, res
+	}
+
+	code, res := profile(http.MethodGet, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]interface{}{}, res["profile"])
+	assert.Equal(t, "", res["email"])
+
+	code, res = profile(http.MethodPut, `{"display_name": "Dev One", "website": "javascript:alert(1)"}`)
+	assert.Equal(t, http.StatusBadRequest, code, "invalid website")
+	assert.Equal(t, float64(rest.ErrActionRejected), res["code"])
+	code, _ = profile(http.MethodPut, `{"display_name": "Dev One", "email": "bad address"}`)
+	assert.Equal(t, http.StatusBadRequest, code, "invalid email")
+
+	id := addComment(t, store.Comment{Text: "test 123", Locator: store.Locator{URL: "https://radio-t.com/blah1", SiteID: "remark42"}}, ts)
+	code, res = profile(http.MethodPut,
+		`{"display_name": "Dev One", "website": "https://example.com", "bio": "about <b>me</b>", "email": "dev@example.com"}`)
+	require.Equal(t, http.StatusOK, code, res)
+	assert.Equal(t, map[string]interface{}{"display_name": "Dev One", "website": "https://example.com", "bio": "about me"},
+		res["profile"])
+	assert.Equal(t, true, res["email_confirmation"], "new address confirmed over email")
+
+	body, code := get(t, ts.URL+"/api/v1/id/"+id+"?site=remark42&url=https://radio-t.com/blah1")
+	require.Equal(t, http.StatusOK, code)
+	comment := store.Comment{}
+	require.NoError(t, json.Unmarshal([]byte(body), &comment))
+	assert.Equal(t, "Dev One", comment.User.Name, "display name in comment")
+	assert.Equal(t, "https://example.com", comment.User.Website)
+	assert.Equal(t, "about me", comment.User.Bio)
+
+	_, err := srv.DataService.SetUserEmail("remark42", "dev", "dev@example.com")
+	require.NoError(t, err)
+	code, res = profile(http.MethodGet, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "dev@example.com", res["email"])
+	code, res = profile(http.MethodPut, `{"email": ""}`)
+	require.Equal(t, http.StatusOK, code, res)
+	assert.Equal(t, map[string]interface{}{}, res["profile"], "profile removed")
+	assert.Equal(t, "", res["email"], "email removed")
+	address, err := srv.DataService.GetUserEmail("remark42", "dev")
+	require.NoError(t, err)
+	assert.Equal(t, "", address)
+}
+
+func TestRest_Consent(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.DataService.ConsentVersions = map[string]string{"remark42": "v1"}
+
+	create := func() int {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/comment",
+			strings.NewReader(`{"text": "test 123", "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`))
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, devToken)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode
+	}
+	consent := func(method, query string) (code int, res R.JSON) {
+		req, err := http.NewRequest(method, ts.URL+"/api/v1/consent?site=remark42"+query, nil)
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, devToken)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		return resp.StatusCode, res
+	}
+
+	assert.Equal(t, http.StatusForbidden, create(), "consent required")
+	resp, err := post(t, ts.URL+"/api/v1/comment",
+		`{"text": "test 123", "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "admin comments without consent")
+
+	code, res := consent(http.MethodGet, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, R.JSON{"version": "v1", "consent": "", "time": nil, "accepted": false}, res)
+
+	code, res = consent(http.MethodPost, "&version=v0")
+	assert.Equal(t, http.StatusBadRequest, code, "outdated version rejected")
+	assert.Equal(t, float64(rest.ErrActionRejected), res["code"])
+
+	code, res = consent(http.MethodPost, "&version=v1")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "v1", res["consent"])
+	assert.NotNil(t, res["time"])
+
+	code, res = consent(http.MethodGet, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, res["accepted"])
+	assert.Equal(t, http.StatusCreated, create(), "created after consent")
+
+	srv.DataService.ConsentVersions["remark42"] = "v2"
+	assert.Equal(t, http.StatusForbidden, create(), "consent to updated terms required")
+	res2, code2 := get(t, ts.URL+"/api/v1/config?site=remark42")
+	assert.Equal(t, http.StatusOK, code2)
+	assert.Contains(t, res2, `"consent_version":"v2"`)
+}
+
+func TestRest_CreateWithModeration(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	filter := moderationFilter(srv)
+	srv.privRest.moderationFilter = filter
+	_, err := filter.SetRules("remark42", moderation.Rules{Words: []string{"casino"}, MaxLinks: 1})
+	require.NoError(t, err)
+
+	create := func(text string) (code int, c store.Comment) {
+		req, e := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/comment",
+			strings.NewReader(`{"text": "`+text+`", "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`))
+		require.NoError(t, e)
+		resp, e := sendReq(t, req, devToken)
+		require.NoError(t, e)
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusCreated {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&c))
+		}
+		return resp.StatusCode, c
+	}
+
+	code, c := create("good comment")
+	require.Equal(t, http.StatusCreated, code)
+	assert.False(t, c.Pending)
+
+	code, c = create("best Casino in town")
+	require.Equal(t, http.StatusCreated, code)
+	assert.True(t, c.Pending, "held for review")
+
+	_, err = filter.SetRules("remark42", moderation.Rules{Words: []string{"casino"}, MaxLinks: 1, Action: moderation.Reject})
+	require.NoError(t, err)
+	code, _ = create("best casino in town")
+	assert.Equal(t, http.StatusForbidden, code, "rejected after rules update")
+	code, _ = create("http://example.com/1 and http://example.com/2")
+	assert.Equal(t, http.StatusForbidden, code, "too many links")
+
+	// edit rejected for matched text
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/comment/"+c.ID+"?site=remark42&url=https://radio-t.com/blah1",
+		strings.NewReader(`{"text":"good casino"}`))
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, devToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// admin not checked
+	resp, err = post(t, ts.URL+"/api/v1/comment",
+		`{"text": "casino", "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+}
+
+func TestRest_CreateWithModerationExpressions(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	filter := moderationFilter(srv)
+	filter.History = srv.DataService
+	srv.privRest.moderationFilter = filter
+	mockDestination := &notify.MockDest{}
+	srv.privRest.notifyService = notify.NewService(srv.DataService, 1, mockDestination)
+	defer srv.privRest.notifyService.Close()
+	_, err := filter.SetRules("remark42", moderation.Rules{Words: []string{"casino"}, Action: moderation.Reject,
+		Expressions: []moderation.Expression{
+			{Name: "trusted", Expr: `user_comments >= 2`, Action: moderation.Approve},
+			{Name: "shouting", Expr: `text matches "^[A-Z ]+$"`, Action: moderation.Hold},
+			{Name: "question", Expr: `text endsWith "?"`, Action: moderation.Notify},
+		}})
+	require.NoError(t, err)
+
+	create := func(text string) (code int, c store.Comment) {
+		req, e := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/comment",
+			strings.NewReader(`{"text": "`+text+`", "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`))
+		require.NoError(t, e)
+		resp, e := sendReq(t, req, devToken)
+		require.NoError(t, e)
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusCreated {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&c))
+		}
+		return resp.StatusCode, c
+	}
+
+	code, c := create("WHAT A POST")
+	require.Equal(t, http.StatusCreated, code)
+	assert.True(t, c.Pending, "held by expression")
+
+	code, _ = create("casino")
+	assert.Equal(t, http.StatusForbidden, code, "blocklist checked after expressions")
+
+	code, c = create("is it ok?")
+	require.Equal(t, http.StatusCreated, code)
+	assert.False(t, c.Pending)
+	alerted := func() []string {
+		for _, r := range mockDestination.Get() {
+			if r.Comment.ID == c.ID {
+				return r.Keywords
+			}
+		}
+		return nil
+	}
+	require.Eventually(t, func() bool { return alerted() != nil }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"rule question"}, alerted(), "admins alerted")
+
+	code, c = create("CASINO")
+	require.Equal(t, http.StatusCreated, code, "approved for user with two comments")
+	assert.False(t, c.Pending)
+}
+
+// moderationFilter makes moderation filter with rules kept by store engine of the server
+func moderationFilter(srv *Rest) *moderation.Filter {
+	return moderation.NewFilter(moderation.NewEngineStore(srv.DataService.Engine))
+}
+
+// spamServer makes remote spam api detecting "spam" and "blatant" words, feedback calls collected
+func spamServer(t *testing.T) (ts *httptest.Server, feedback *[]string) {
+	feedback = &[]string{}
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := spam.RemoteRequest{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		if r.URL.Path != "/check" {
+			*feedback = append(*feedback, r.URL.Path+" "+req.CommentID)
+			return
+		}
+		render.JSON(w, r, R.JSON{"spam": strings.Contains(req.Text, "spam"), "blatant": strings.Contains(req.Text, "blatant")})
+	}))
+	return ts, feedback
+}
+
+func TestRest_CreateAnonymousWithCaptcha(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	captchaTS := captchaServer(t)
+	defer captchaTS.Close()
+	srv.privRest.captcha = captcha.NewService(&captcha.SiteVerify{Provider: captcha.Turnstile, Secret: "secret", API: captchaTS.URL},
+		captcha.Params{SiteKey: "key"})
+
+	claims := token.Claims{
+		User: &token.User{ID: "anonymous_user1", Name: "anon"},
+		StandardClaims: jwt.StandardClaims{Audience: "remark42", Issuer: "remark42",
+			ExpiresAt: time.Now().Add(10 * time.Minute).Unix(), NotBefore: time.Now().Add(-1 * time.Minute).Unix()},
+	}
+	anonToken, err := srv.Authenticator.TokenService().Token(claims)
+	require.NoError(t, err)
+
+	create := func(tkn, captchaToken string) int {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/comment",
+			strings.NewReader(`{"text": "test 123", "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`))
+		require.NoError(t, err)
+		if captchaToken != "" {
+			req.Header.Set("X-Captcha-Token", captchaToken)
+		}
+		resp, err := sendReq(t, req, tkn)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusForbidden, create(anonToken, ""), "captcha required")
+	assert.Equal(t, http.StatusForbidden, create(anonToken, "bad"), "captcha failed")
+	assert.Equal(t, http.StatusCreated, create(anonToken, "good"))
+	assert.Equal(t, http.StatusCreated, create(devToken, ""), "not required from other users")
+}
+
+// captchaServer makes siteverify api accepting "good" token
+func captchaServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		render.JSON(w, r, R.JSON{"success": r.PostForm.Get("response") == "good"})
+	}))
+}
+
+func TestRest_CreateWithRateLimit(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.privRest.rateLimiter = ratelimit.NewLimiter(ratelimit.NewMemoryStore(),
+		ratelimit.Params{User: ratelimit.Limit{Rate: 1, Burst: 2}})
+
+	create := func(tkn string) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/comment",
+			strings.NewReader(`{"text": "test 123", "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`))
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, tkn)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp
+	}
+	assert.Equal(t, http.StatusCreated, create(devToken).StatusCode)
+	assert.Equal(t, http.StatusCreated, create(devToken).StatusCode, "burst of 2")
+	resp := create(devToken)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "60", resp.Header.Get("Retry-After"))
+	assert.Equal(t, http.StatusCreated, create(adminUmputunToken).StatusCode, "admins not limited")
+}
+
+func TestRest_CreateCommentExternal(t *testing.T) {
+	_, srv, teardown := startupT(t)
+	defer teardown()
+	filter := moderationFilter(srv)
+	srv.privRest.moderationFilter = filter
+	_, err := filter.SetRules("remark42", moderation.Rules{Words: []string{"casino"}})
+	require.NoError(t, err)
+	srv.privRest.rateLimiter = ratelimit.NewLimiter(ratelimit.NewMemoryStore(),
+		ratelimit.Params{User: ratelimit.Limit{Rate: 1, Burst: 3}})
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}
+	create := func(userID, text string) (store.Comment, error) {
+		return srv.CreateComment(context.Background(), store.Comment{Locator: locator, Text: text,
+			User: store.User{ID: userID, Name: "John"}})
+	}
+
+	c, err := create("email_123", "from **email**")
+	require.NoError(t, err)
+	assert.Equal(t, "<p>from <strong>email</strong></p>\n", c.Text)
+	assert.Equal(t, "from **email**", c.Orig)
+	assert.False(t, c.Pending)
+
+	c, err = create("email_123", "best casino in town")
+	require.NoError(t, err)
+	assert.True(t, c.Pending, "held by moderation filter")
+
+	_, err = create("email_123", "third")
+	require.NoError(t, err)
+	_, err = create("email_123", "fourth")
+	rj := &rejection{}
+	require.True(t, errors.As(err, &rj))
+	assert.Equal(t, http.StatusTooManyRequests, rj.status, "rate limited")
+
+	require.NoError(t, srv.DataService.SetBlock("remark42", "email_456", true, time.Hour))
+	_, err = create("email_456", "blocked")
+	assert.EqualError(t, err, "user blocked")
+
+	require.NoError(t, srv.DataService.SetReadOnly(locator, true))
+	_, err = create("email_789", "read-only")
+	assert.EqualError(t, err, "old post, read-only")
+}
+
+func TestRest_CreateWithPlugin(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	p := &mockPlugin{hooks: []plugin.Hook{plugin.HookCommentCreate, plugin.HookCommentEdit, plugin.HookCommentDelete}}
+	srv.privRest.plugins = plugin.NewService(p)
+
+	// plugin changes text
+	p.res = plugin.Result{Changed: true, Text: "<p>changed by plugin</p>", Orig: "changed by plugin"}
+	resp, err := post(t, ts.URL+"/api/v1/comment",
+		`{"text": "test 123", "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`)
+	require.NoError(t, err)
+	c := store.Comment{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&c))
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "<p>changed by plugin</p>", c.Text)
+	assert.Equal(t, "changed by plugin", c.Orig)
+	require.Equal(t, 1, len(p.events))
+	assert.Equal(t, plugin.HookCommentCreate, p.events[0].Hook)
+	assert.Equal(t, "<p>test 123</p>\n", p.events[0].Comment.Text)
+	assert.Equal(t, "admin", p.events[0].User.ID)
+
+	// plugin rejects edit
+	p.res = plugin.Result{Reject: true, Reason: "not allowed"}
+	client := http.Client{}
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/comment/"+c.ID+"?site=remark42&url=https://radio-t.com/blah1",
+		strings.NewReader(`{"text":"updated text"}`))
+	require.NoError(t, err)
+	req.SetBasicAuth("admin", "password")
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Contains(t, string(body), "rejected by plugin")
+	assert.Equal(t, "<p>updated text</p>\n", p.events[1].Comment.Text)
+
+	// plugin rejects create
+	resp, err = post(t, ts.URL+"/api/v1/comment",
+		`{"text": "test 456", "locator":{"url": "https://radio-t.com/blah1", "site": "remark42"}}`)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// delete by user reported to plugin
+	req, err = http.NewRequest(http.MethodPut, ts.URL+"/api/v1/comment/"+c.ID+"?site=remark42&url=https://radio-t.com/blah1",
+		strings.NewReader(`{"delete":true}`))
+	require.NoError(t, err)
+	req.SetBasicAuth("admin", "password")
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	srv.privRest.plugins.Close()
+	require.Equal(t, 4, len(p.events))
+	assert.Equal(t, plugin.HookCommentDelete, p.events[3].Hook)
+	assert.Equal(t, c.ID, p.events[3].Comment.ID)
+}
+
+func TestRest_PluginLifecycleHooks(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	p := &mockPlugin{hooks: []plugin.Hook{plugin.HookCommentCreated, plugin.HookCommentDeleting, plugin.HookUserBlock}}
+	srv.privRest.plugins = plugin.NewService(p)
+	srv.adminRest.plugins = srv.privRest.plugins
+
+	id := addComment(t, store.Comment{Text: "test 123", Locator: store.Locator{URL: "https://radio-t.com/blah1", SiteID: "remark42"}}, ts)
+	srv.privRest.plugins.Close() // created event sent in background
+	require.Equal(t, 1, len(p.events))
+	assert.Equal(t, plugin.HookCommentCreated, p.events[0].Hook)
+	assert.Equal(t, id, p.events[0].Comment.ID)
+	assert.Equal(t, "dev", p.events[0].User.ID)
+
+	send := func(method, url, body string) (code int, respBody string) {
+		req, err := http.NewRequest(method, ts.URL+url, strings.NewReader(body))
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, adminUmputunToken)
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode, string(b)
+	}
+
+	// deletion by admin rejected
+	p.res = plugin.Result{Reject: true, Reason: "keep it"}
+	code, body := send(http.MethodDelete, "/api/v1/admin/comment/"+id+"?site=remark42&url=https://radio-t.com/blah1&reason=off+topic", "")
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Contains(t, body, "rejected by plugin mock: keep it")
+	require.Equal(t, 2, len(p.events))
+	assert.Equal(t, plugin.HookCommentDeleting, p.events[1].Hook)
+	assert.Equal(t, id, p.events[1].Comment.ID)
+	assert.Equal(t, "off topic", p.events[1].Reason)
+	c, err := srv.DataService.Get(store.Locator{URL: "https://radio-t.com/blah1", SiteID: "remark42"}, id, store.User{})
+	require.NoError(t, err)
+	assert.False(t, c.Deleted)
+
+	// blocking rejected, unblocking not passed to plugins
+	code, _ = send(http.MethodPut, "/api/v1/admin/user/dev?site=remark42&block=1&reason=spammer", "")
+	assert.Equal(t, http.StatusForbidden, code)
+	require.Equal(t, 3, len(p.events))
+	assert.Equal(t, plugin.HookUserBlock, p.events[2].Hook)
+	assert.Equal(t, "dev", p.events[2].User.ID)
+	assert.Equal(t, "spammer", p.events[2].Reason)
+	assert.False(t, srv.DataService.IsBlocked("remark42", "dev"))
+	code, _ = send(http.MethodPut, "/api/v1/admin/user/dev?site=remark42&block=0", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 3, len(p.events))
+
+	// deletion allowed
+	p.res = plugin.Result{}
+	code, _ = send(http.MethodDelete, "/api/v1/admin/comment/"+id+"?site=remark42&url=https://radio-t.com/blah1", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 4, len(p.events))
+	c, err = srv.DataService.Get(store.Locator{URL: "https://radio-t.com/blah1", SiteID: "remark42"}, id, store.User{})
+	require.NoError(t, err)
+	assert.True(t, c.Deleted)
+}
+
 func TestRest_CreateOldPost(t *testing.T) {
 	ts, srv, teardown := startupT(t)
 	defer teardown()
@@ -282,6 +889,24 @@ func TestRest_Update(t *testing.T) {
 	assert.Equal(t, c2, c3, "same as response from update")
 }
 
+func TestRest_UpdateNotificationsDisabled(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.privRest.notifyService = nil
+
+	c1 := store.Comment{Text: "test test #1", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}}
+	id := addComment(t, c1, ts)
+
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/comment/"+id+"?site=remark42&url=https://radio-t.com/blah1",
+		strings.NewReader(`{"text":"updated text"}`))
+	require.NoError(t, err)
+	req.Header.Add("X-JWT", devToken)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
 func TestRest_UpdateDelete(t *testing.T) {
 	ts, _, teardown := startupT(t)
 	defer teardown()
@@ -425,6 +1050,142 @@ func TestRest_UpdateWithRestrictedWords(t *testing.T) {
 	assert.Equal(t, "invalid comment", c["details"])
 }
 
+func TestRest_Report(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.DataService.ReportThreshold = 1
+
+	id, err := srv.DataService.Create(store.Comment{Text: "test test #1", User: store.User{ID: "user1", Name: "user1"},
+		Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}})
+	require.NoError(t, err)
+
+	report := func(body string) (string, int) {
+		req, err := http.NewRequest(http.MethodPut,
+			fmt.Sprintf("%s/api/v1/report/%s?site=remark42&url=https://radio-t.com/blah", ts.URL, id), strings.NewReader(body))
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, devToken)
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return string(b), resp.StatusCode
+	}
+
+	_, code := report(`bad json`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	_, code = report(`{"reason": ""}`)
+	assert.Equal(t, http.StatusBadRequest, code, "reason required")
+	body, code := report(`{"reason": "spam"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, fmt.Sprintf(`{"id":%q,"reported":true}`+"\n", id), body)
+	_, code = report(`{"reason": "spam"}`)
+	assert.Equal(t, http.StatusBadRequest, code, "reported already")
+
+	body, code = get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah&sort=+time")
+	assert.Equal(t, http.StatusOK, code)
+	comments := commentsWithInfo{}
+	require.NoError(t, json.Unmarshal([]byte(body), &comments))
+	assert.Equal(t, 0, len(comments.Comments), "moved to pending")
+}
+
+func TestRest_React(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.DataService.Reactions = []string{"like", "heart"}
+
+	c1 := store.Comment{Text: "test test #1", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}}
+	id1 := addComment(t, c1, ts)
+
+	react := func(method, reaction string) (string, int) {
+		req, err := http.NewRequest(method,
+			fmt.Sprintf("%s/api/v1/react/%s?site=remark42&url=https://radio-t.com/blah&reaction=%s", ts.URL, id1, reaction), nil)
+		require.NoError(t, err)
+		req.Header.Add("X-JWT", devToken)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return string(body), resp.StatusCode
+	}
+
+	body, code := react(http.MethodPut, "heart")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, fmt.Sprintf(`{"id":%q,"reacted":["heart"],"reactions":{"heart":1}}`+"\n", id1), body)
+	_, code = react(http.MethodPut, "heart")
+	assert.Equal(t, 400, code, "second reaction rejected")
+	body, code = react(http.MethodPut, "unknown")
+	assert.Equal(t, 400, code)
+	assert.Contains(t, body, fmt.Sprintf(`"code":%d`, rest.ErrReactionRejected))
+	_, code = react(http.MethodPut, "like")
+	assert.Equal(t, 200, code)
+
+	body, code = getWithDevAuth(t, fmt.Sprintf("%s/api/v1/id/%s?site=remark42&url=https://radio-t.com/blah", ts.URL, id1))
+	assert.Equal(t, 200, code)
+	cr := store.Comment{}
+	require.NoError(t, json.Unmarshal([]byte(body), &cr))
+	assert.Equal(t, map[string]int{"heart": 1, "like": 1}, cr.Reactions)
+	assert.Equal(t, []string{"heart", "like"}, cr.Reacted)
+	assert.Nil(t, cr.Reactors, "hidden")
+
+	body, code = react(http.MethodDelete, "heart")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, fmt.Sprintf(`{"id":%q,"reacted":["like"],"reactions":{"like":1}}`+"\n", id1), body)
+	_, code = react(http.MethodDelete, "heart")
+	assert.Equal(t, 400, code, "no reaction to remove")
+
+	body, code = get(t, ts.URL+"/api/v1/config?site=remark42")
+	assert.Equal(t, 200, code)
+	assert.Contains(t, body, `"reactions":["like","heart"]`)
+}
+
+func TestRest_Follow(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	follow := func(method, user string) (string, int) {
+		req, err := http.NewRequest(method, fmt.Sprintf("%s/api/v1/follow/%s?site=remark42", ts.URL, user), nil)
+		require.NoError(t, err)
+		req.Header.Add("X-JWT", devToken)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return string(body), resp.StatusCode
+	}
+
+	_, code := follow(http.MethodPut, "user1")
+	assert.Equal(t, 404, code, "following disabled")
+
+	srv.privRest.followStore = notify.NewEngineFollows(srv.DataService.Engine)
+
+	_, code = follow(http.MethodPut, "user1")
+	assert.Equal(t, 400, code, "no comments of user1")
+
+	_, err := srv.DataService.Create(store.Comment{Text: "test test #1", User: store.User{ID: "user1", Name: "user one"},
+		Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}})
+	require.NoError(t, err)
+
+	body, code := follow(http.MethodPut, "user1")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, `{"following":true,"user":"user1"}`+"\n", body)
+
+	body, code = getWithDevAuth(t, ts.URL+"/api/v1/follow?site=remark42")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, `{"following":["user1"]}`+"\n", body)
+
+	body, code = follow(http.MethodDelete, "user1")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, `{"following":false,"user":"user1"}`+"\n", body)
+	_, code = follow(http.MethodDelete, "user1")
+	assert.Equal(t, 400, code, "not followed")
+
+	body, code = getWithDevAuth(t, ts.URL+"/api/v1/follow?site=remark42")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, `{"following":[]}`+"\n", body)
+}
+
 func TestRest_Vote(t *testing.T) {
 	ts, _, teardown := startupT(t)
 	defer teardown()
@@ -573,6 +1334,66 @@ func (fs *MockFS) ReadFile(path string) ([]byte, error) {
 	return []byte(fmt.Sprintf("template %s", path)), nil
 }
 
+// dirFS reads templates from the directory
+type dirFS string
+
+func (d dirFS) ReadFile(path string) ([]byte, error) {
+	return ioutil.ReadFile(string(d) + "/" + path)
+}
+
+func TestRest_EmailVote(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.privRest.templates = dirFS("../../../templates")
+
+	c := store.Comment{Text: "test test #1", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah"}}
+	id := addComment(t, c, ts)
+
+	voteToken := func(handshakeID, postURL string) string {
+		claims := token.Claims{
+			Handshake: &token.Handshake{ID: handshakeID, From: postURL},
+			StandardClaims: jwt.StandardClaims{
+				Audience:  "remark42",
+				ExpiresAt: time.Now().Add(10 * time.Minute).Unix(),
+				NotBefore: time.Now().Add(-1 * time.Minute).Unix(),
+				Issuer:    "remark42",
+			},
+		}
+		tkn, err := srv.Authenticator.TokenService().Token(claims)
+		require.NoError(t, err)
+		return tkn
+	}
+	score := func() int {
+		body, code := get(t, fmt.Sprintf("%s/api/v1/id/%s?site=remark42&url=https://radio-t.com/blah", ts.URL, id))
+		require.Equal(t, http.StatusOK, code)
+		cr := store.Comment{}
+		require.NoError(t, json.Unmarshal([]byte(body), &cr))
+		return cr.Score
+	}
+
+	body, code := get(t, ts.URL+"/email/vote.html")
+	assert.Equal(t, http.StatusBadRequest, code, body)
+	body, code = get(t, ts.URL+"/email/vote.html?tkn=jwt")
+	assert.Equal(t, http.StatusForbidden, code, body)
+	body, code = get(t, ts.URL+"/email/vote.html?tkn="+voteToken("user2::good@example.com", ""))
+	assert.Equal(t, http.StatusBadRequest, code, "unsubscribe token rejected, %s", body)
+	body, code = get(t, ts.URL+"/email/vote.html?tkn="+voteToken("vote::user2::bad-id", "https://radio-t.com/blah"))
+	assert.Equal(t, http.StatusBadRequest, code, body)
+	assert.Equal(t, 0, score())
+
+	tkn := voteToken("vote::user2::"+id, "https://radio-t.com/blah")
+	body, code = get(t, ts.URL+"/email/vote.html?tkn="+tkn)
+	assert.Equal(t, http.StatusOK, code, body)
+	assert.Contains(t, body, "Your vote for the comment of developer one is counted")
+	assert.Contains(t, body, `href="https://radio-t.com/blah#remark42__comment-`+id+`"`)
+	assert.Equal(t, 1, score())
+
+	body, code = get(t, ts.URL+"/email/vote.html?tkn="+tkn)
+	assert.Equal(t, http.StatusOK, code, body)
+	assert.Contains(t, body, "You already voted for the comment of developer one")
+	assert.Equal(t, 1, score(), "vote applied once")
+}
+
 func TestRest_Email(t *testing.T) {
 	ts, srv, teardown := startupT(t)
 	defer teardown()
@@ -645,6 +1466,108 @@ func TestRest_Email(t *testing.T) {
 	}
 }
 
+func TestRest_EmailOneClickUnsubscribe(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	claims := token.Claims{
+		Handshake: &token.Handshake{ID: "dev::good@example.com"},
+		StandardClaims: jwt.StandardClaims{
+			Audience:  "remark42",
+			ExpiresAt: time.Now().Add(10 * time.Minute).Unix(),
+			NotBefore: time.Now().Add(-1 * time.Minute).Unix(),
+			Issuer:    "remark42",
+		},
+	}
+	tkn, err := srv.Authenticator.TokenService().Token(claims)
+	require.NoError(t, err)
+	_, err = srv.DataService.SetUserEmail("remark42", "dev", "good@example.com")
+	require.NoError(t, err)
+
+	oneClick := func(query, body string) *http.Response {
+		resp, e := http.Post(ts.URL+"/email/unsubscribe?"+query, "application/x-www-form-urlencoded", strings.NewReader(body))
+		require.NoError(t, e)
+		require.NoError(t, resp.Body.Close())
+		return resp
+	}
+
+	resp := oneClick("site=remark42&tkn="+tkn, "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "no one-click body")
+	resp = oneClick("site=remark42&tkn=bad", "List-Unsubscribe=One-Click")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp = oneClick("site=remark42", "List-Unsubscribe=One-Click")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "no token")
+
+	client := http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	getResp, err := client.Get(ts.URL + "/email/unsubscribe?site=remark42&tkn=" + tkn)
+	require.NoError(t, err)
+	require.NoError(t, getResp.Body.Close())
+	assert.Equal(t, http.StatusFound, getResp.StatusCode)
+	assert.Equal(t, srv.RemarkURL+"/email/unsubscribe.html?site=remark42&tkn="+tkn, getResp.Header.Get("Location"))
+	email, err := srv.DataService.GetUserEmail("remark42", "dev")
+	require.NoError(t, err)
+	assert.Equal(t, "good@example.com", email, "not unsubscribed on GET")
+
+	resp = oneClick("site=remark42&tkn="+tkn, "List-Unsubscribe=One-Click")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	email, err = srv.DataService.GetUserEmail("remark42", "dev")
+	require.NoError(t, err)
+	assert.Empty(t, email)
+
+	resp = oneClick("site=remark42&tkn="+tkn, "List-Unsubscribe=One-Click")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "repeated request")
+}
+
+func TestRest_EmailChange(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	mockDestination := &notify.MockDest{}
+	srv.privRest.notifyService = notify.NewService(srv.DataService, 1, mockDestination)
+	defer srv.privRest.notifyService.Close()
+
+	post := func(url string) (int, string) {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+url, nil)
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, devToken)
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode, string(body)
+	}
+
+	code, _ := post("/api/v1/email/change?site=remark42&address=new@example.com")
+	assert.Equal(t, http.StatusBadRequest, code, "not subscribed")
+
+	_, err := srv.DataService.SetUserEmail("remark42", "dev", "old@example.com")
+	require.NoError(t, err)
+	code, _ = post("/api/v1/email/change?site=remark42&address=bad")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = post("/api/v1/email/change?site=remark42&address=old@example.com")
+	assert.Equal(t, http.StatusConflict, code)
+
+	code, body := post("/api/v1/email/change?site=remark42&address=new@example.com")
+	require.Equal(t, http.StatusOK, code, body)
+	assert.Contains(t, body, `"email_confirmation":true`)
+	time.Sleep(time.Millisecond * 30)
+	require.Equal(t, 1, len(mockDestination.GetVerify()))
+	assert.Equal(t, "new@example.com", mockDestination.GetVerify()[0].Email)
+	address, err := srv.DataService.GetUserEmail("remark42", "dev")
+	require.NoError(t, err)
+	assert.Equal(t, "old@example.com", address, "not switched before confirmation")
+
+	code, body = post("/api/v1/email/confirm?site=remark42&tkn=" + mockDestination.GetVerify()[0].Token)
+	require.Equal(t, http.StatusOK, code, body)
+	address, err = srv.DataService.GetUserEmail("remark42", "dev")
+	require.NoError(t, err)
+	assert.Equal(t, "new@example.com", address)
+	time.Sleep(time.Millisecond * 30)
+	require.Equal(t, 2, len(mockDestination.GetVerify()))
+	assert.Equal(t, notify.VerificationRequest{SiteID: "remark42", User: "developer one", Email: "old@example.com",
+		NewEmail: "new@example.com"}, mockDestination.GetVerify()[1], "previous address notified")
+}
+
 func TestRest_EmailNotification(t *testing.T) {
 	ts, srv, teardown := startupT(t)
 	defer teardown()
@@ -790,6 +1713,77 @@ func TestRest_EmailNotification(t *testing.T) {
 	assert.Empty(t, mockDestination.Get()[3].Emails)
 }
 
+func TestRest_UserLimits(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	srv.privRest.rateLimiter = ratelimit.NewLimiter(ratelimit.NewMemoryStore(),
+		ratelimit.Params{User: ratelimit.Limit{Rate: 1, Burst: 2}})
+	require.NoError(t, srv.DataService.SetInterval(store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}, 10))
+
+	c1 := store.Comment{Text: "test test #1", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}}
+	id1 := addComment(t, c1, ts)
+
+	body, code := getWithDevAuth(t, ts.URL+"/api/v1/user/limits?site=remark42&url=https://radio-t.com/blah1")
+	require.Equal(t, http.StatusOK, code, body)
+	type requestLimit struct {
+		Limit     float64
+		Remaining int
+		Reset     float64
+	}
+	res := struct {
+		service.UserLimits
+		RateLimit struct {
+			Read   requestLimit `json:"read"`
+			Update requestLimit `json:"update"`
+		} `json:"rate_limit"`
+		Posting struct {
+			User        ratelimit.Status `json:"user"`
+			IP          ratelimit.Status `json:"ip"`
+			Interval    int              `json:"interval"`
+			NextComment *time.Time       `json:"next_comment"`
+		} `json:"posting"`
+		MaxImageSize  int  `json:"max_image_size"`
+		CanPostImages bool `json:"can_post_images"`
+		CanSubscribe  bool `json:"can_subscribe"`
+		CanVote       bool `json:"can_vote"`
+		ReadOnly      bool `json:"read_only"`
+	}{}
+	require.NoError(t, json.Unmarshal([]byte(body), &res), body)
+	assert.Equal(t, requestLimit{Limit: 10, Remaining: 10}, res.RateLimit.Read, "no requests to find comments")
+	assert.Equal(t, 10.0, res.RateLimit.Update.Limit)
+	assert.Equal(t, 9, res.RateLimit.Update.Remaining, "one comment posted")
+	assert.True(t, res.RateLimit.Update.Reset > 0 && res.RateLimit.Update.Reset <= 0.1, res.RateLimit.Update.Reset)
+	assert.Equal(t, ratelimit.Status{Rate: 1, Burst: 2, Remaining: 1, Reset: 60}, res.Posting.User)
+	assert.Equal(t, ratelimit.Status{Remaining: -1}, res.Posting.IP, "no ip limit")
+	assert.Equal(t, 10, res.Posting.Interval)
+	require.NotNil(t, res.Posting.NextComment)
+	assert.True(t, res.Posting.NextComment.After(time.Now().Add(9*time.Minute)), res.Posting.NextComment)
+	assert.Equal(t, srv.DataService.MaxCommentSize, res.MaxCommentSize)
+	assert.Equal(t, int(srv.DataService.EditDuration.Seconds()), res.EditDuration)
+	assert.True(t, res.CanPostImages && res.CanSubscribe && res.CanVote)
+	assert.False(t, res.Blocked)
+	assert.False(t, res.ReadOnly)
+	require.Equal(t, 1, len(res.Edits))
+	assert.Equal(t, id1, res.Edits[0].ID)
+	assert.True(t, res.Edits[0].Editable)
+	require.NotNil(t, res.Edits[0].Until)
+
+	require.NoError(t, srv.DataService.SetBlock("remark42", "dev", true, time.Hour))
+	require.NoError(t, srv.DataService.SetReadOnly(store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}, true))
+	body, code = getWithDevAuth(t, ts.URL+"/api/v1/user/limits?site=remark42&url=https://radio-t.com/blah1")
+	require.Equal(t, http.StatusOK, code, body)
+	require.NoError(t, json.Unmarshal([]byte(body), &res), body)
+	assert.True(t, res.Blocked)
+	assert.True(t, res.ReadOnly)
+	assert.False(t, res.CanPostImages || res.CanSubscribe || res.CanVote)
+
+	resp, err := http.Get(ts.URL + "/api/v1/user/limits?site=remark42")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
 func TestRest_UserAllData(t *testing.T) {
 	ts, srv, teardown := startupT(t)
 	defer teardown()
@@ -829,8 +1823,10 @@ func TestRest_UserAllData(t *testing.T) {
 	assert.Equal(t, 3, strings.Count(strUungzBody, `"text":`), "3 comments inside")
 
 	parsed := struct {
-		Info     store.User      `json:"info"`
-		Comments []store.Comment `json:"comments"`
+		Info     store.User             `json:"info"`
+		Comments []store.Comment        `json:"comments"`
+		Details  engine.UserDetailEntry `json:"details"`
+		Votes    []service.UserVote     `json:"votes"`
 	}{}
 
 	err = json.Unmarshal(ungzBody, &parsed)
@@ -838,6 +1834,8 @@ func TestRest_UserAllData(t *testing.T) {
 	assert.Equal(t, store.User{Name: "developer one", ID: "dev",
 		Picture: "http://example.com/pic.png", IP: "127.0.0.1", SiteID: "remark42"}, parsed.Info)
 	assert.Equal(t, 3, len(parsed.Comments))
+	assert.Equal(t, engine.UserDetailEntry{UserID: "dev"}, parsed.Details)
+	assert.Equal(t, []service.UserVote{}, parsed.Votes)
 
 	req, err = http.NewRequest("GET", ts.URL+"/api/v1/userdata?site=remark42", nil)
 	require.NoError(t, err)
@@ -916,6 +1914,85 @@ func TestRest_DeleteMe(t *testing.T) {
 	assert.NoError(t, resp.Body.Close())
 }
 
+func TestRest_AccountDeletion(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.privRest.templates = dirFS("../../../templates")
+	mockDestination := &notify.MockDest{}
+	srv.privRest.notifyService = notify.NewService(srv.DataService, 1, mockDestination)
+	defer srv.privRest.notifyService.Close()
+
+	send := func(method string) (string, int) {
+		req, err := http.NewRequest(method, ts.URL+"/api/v1/account/delete?site=remark42", nil)
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, devToken)
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return string(body), resp.StatusCode
+	}
+
+	body, code := send(http.MethodPost)
+	assert.Equal(t, http.StatusNotFound, code, "disabled, %s", body)
+
+	st := deletion.NewEngineStore(srv.DataService.Engine, func() []string { return []string{"remark42"} })
+	srv.privRest.accountDeletion = deletion.NewService(st, deletion.Params{Grace: time.Hour,
+		Delete: func(deletion.Request) error { return nil }})
+
+	body, code = send(http.MethodPost)
+	assert.Equal(t, http.StatusBadRequest, code, "no verified email, %s", body)
+
+	_, err := srv.DataService.SetUserEmail("remark42", "dev", "good@example.com")
+	require.NoError(t, err)
+	body, code = send(http.MethodPost)
+	require.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, `{"address":"good@example.com","site":"remark42","user_id":"dev"}`+"\n", body)
+	time.Sleep(time.Millisecond * 30)
+	require.Equal(t, 1, len(mockDestination.GetVerify()))
+	verify := mockDestination.GetVerify()[0]
+	assert.Equal(t, "good@example.com", verify.Email)
+	assert.Equal(t, "https://demo.remark42.com/account/delete.html?tkn="+verify.Token, verify.DeletionURL)
+
+	body, code = send(http.MethodGet)
+	assert.Equal(t, http.StatusNotFound, code, "not confirmed yet, %s", body)
+
+	body, code = get(t, ts.URL+"/account/delete.html")
+	assert.Equal(t, http.StatusBadRequest, code, body)
+	body, code = get(t, ts.URL+"/account/delete.html?tkn=jwt")
+	assert.Equal(t, http.StatusForbidden, code, body)
+	claims := token.Claims{
+		Handshake: &token.Handshake{ID: "vote::dev::id"},
+		StandardClaims: jwt.StandardClaims{
+			Audience:  "remark42",
+			ExpiresAt: time.Now().Add(10 * time.Minute).Unix(),
+			Issuer:    "remark42",
+		},
+	}
+	voteTkn, err := srv.Authenticator.TokenService().Token(claims)
+	require.NoError(t, err)
+	body, code = get(t, ts.URL+"/account/delete.html?tkn="+voteTkn)
+	assert.Equal(t, http.StatusBadRequest, code, "other token rejected, %s", body)
+
+	body, code = get(t, ts.URL+"/account/delete.html?tkn="+verify.Token)
+	require.Equal(t, http.StatusOK, code, body)
+	assert.Contains(t, body, "Deletion of your account on site <b>remark42</b> is scheduled")
+
+	body, code = send(http.MethodGet)
+	require.Equal(t, http.StatusOK, code, body)
+	req := deletion.Request{}
+	require.NoError(t, json.Unmarshal([]byte(body), &req))
+	assert.Equal(t, "dev", req.UserID)
+	assert.Equal(t, "http://example.com/pic.png", req.Picture)
+	assert.Equal(t, time.Hour, req.Scheduled.Sub(req.Requested))
+
+	body, code = send(http.MethodDelete)
+	require.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, `{"canceled":true}`+"\n", body)
+	body, code = send(http.MethodGet)
+	assert.Equal(t, http.StatusNotFound, code, "canceled, %s", body)
+}
+
 func TestRest_SavePictureCtrl(t *testing.T) {
 	ts, _, teardown := startupT(t)
 	defer teardown()
@@ -1068,3 +2145,544 @@ func TestRest_CreateWithPictures(t *testing.T) {
 		assert.NoError(t, err, "picture %d moved from staging and available in permanent location", i)
 	}
 }
+
+func TestRest_EmailBounce(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	// bounce processing disabled by default
+	resp, err := post(t, ts.URL+"/api/v1/email/bounce?site=remark42", `{"email":"a@example.com","kind":"hard"}`)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	bounceStore := notify.NewEngineBounces(srv.DataService.Engine)
+	srv.BounceStore, srv.BounceSecret = bounceStore, "bounce-secret"
+	ts2 := httptest.NewServer(srv.routes())
+	defer ts2.Close()
+
+	_, err = srv.DataService.SetUserEmail("remark42", "user1", "a@example.com")
+	require.NoError(t, err)
+	_, err = srv.DataService.SetUserEmail("remark42", "user2", "b@example.com")
+	require.NoError(t, err)
+
+	sendBounce := func(provider, passwd, body string) *http.Response {
+		req, e := http.NewRequest(http.MethodPost, ts2.URL+"/api/v1/email/bounce?site=remark42&provider="+provider, strings.NewReader(body))
+		require.NoError(t, e)
+		req.SetBasicAuth("bounce", passwd)
+		resp, e := http.DefaultClient.Do(req)
+		require.NoError(t, e)
+		return resp
+	}
+
+	resp = sendBounce("generic", "wrong", `{"email":"a@example.com","kind":"hard"}`)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = sendBounce("generic", "bounce-secret", `{"email":"a@example.com","kind":"soft"}`)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = sendBounce("mailgun", "bounce-secret",
+		`{"event-data":{"event":"failed","severity":"permanent","recipient":"A@example.com","delivery-status":{"description":"no mailbox"}}}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	res := struct {
+		Bounces      int      `json:"bounces"`
+		Unsubscribed []string `json:"unsubscribed"`
+	}{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, 1, res.Bounces)
+	assert.Equal(t, []string{"user1"}, res.Unsubscribed)
+
+	email, err := srv.DataService.GetUserEmail("remark42", "user1")
+	require.NoError(t, err)
+	assert.Empty(t, email, "bounced email unsubscribed")
+	email, err = srv.DataService.GetUserEmail("remark42", "user2")
+	require.NoError(t, err)
+	assert.Equal(t, "b@example.com", email)
+	assert.True(t, bounceStore.IsBounced("remark42", "a@example.com"))
+}
+
+func TestRest_EmailReply(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	// replies by email disabled by default
+	resp, err := post(t, ts.URL+"/api/v1/email/reply", "")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	tickets := gateway.NewEngineTickets(srv.DataService.Engine, func() []string { return []string{"remark42"} })
+	replies := &gateway.Replies{Domain: "example.com", TTL: time.Hour, Store: tickets,
+		DataService: srv.DataService, Creator: srv}
+	srv.Replies, srv.ReplySecret = replies, "reply-secret"
+	ts2 := httptest.NewServer(srv.routes())
+	defer ts2.Close()
+
+	parent := store.Comment{Text: "parent", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"},
+		User: store.User{ID: "user1", Name: "user one"}}
+	parent.ID, err = srv.DataService.Create(parent)
+	require.NoError(t, err)
+	addr, err := replies.Address(parent.User, "a@example.com", parent)
+	require.NoError(t, err)
+
+	sendReply := func(passwd, body string) *http.Response {
+		req, e := http.NewRequest(http.MethodPost, ts2.URL+"/api/v1/email/reply?provider=mailgun", strings.NewReader(body))
+		require.NoError(t, e)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("reply", passwd)
+		resp, e := http.DefaultClient.Do(req)
+		require.NoError(t, e)
+		return resp
+	}
+
+	form := url.Values{"recipient": {addr}, "sender": {"a@example.com"}, "body-plain": {"reply by email"}}
+	resp = sendReply("wrong", form.Encode())
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = sendReply("reply-secret", "body-plain=no+recipient")
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	res := struct {
+		Created bool   `json:"created"`
+		ID      string `json:"id"`
+		Error   string `json:"error"`
+	}{}
+	wrongSender := url.Values{"recipient": {addr}, "sender": {"b@example.com"}, "body-plain": {"reply by email"}}
+	resp = sendReply("reply-secret", wrongSender.Encode())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+	require.NoError(t, resp.Body.Close())
+	assert.False(t, res.Created)
+	assert.Equal(t, "sender b@example.com doesn't match recipient of notification", res.Error)
+
+	resp = sendReply("reply-secret", form.Encode())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+	require.NoError(t, resp.Body.Close())
+	assert.True(t, res.Created)
+
+	reply, err := srv.DataService.Get(parent.Locator, res.ID, store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, parent.ID, reply.ParentID)
+	assert.Equal(t, "user1", reply.User.ID)
+	assert.Equal(t, "<p>reply by email</p>\n", reply.Text)
+}
+
+type mockPlugin struct {
+	hooks  []plugin.Hook
+	res    plugin.Result
+	events []plugin.Event
+}
+
+func (m *mockPlugin) Hooks() []plugin.Hook { return m.hooks }
+
+func (m *mockPlugin) Handle(ev plugin.Event) (plugin.Result, error) {
+	if ev.Comment != nil { // keep comment as it was passed to plugin
+		c := *ev.Comment
+		ev.Comment = &c
+	}
+	m.events = append(m.events, ev)
+	return m.res, nil
+}
+
+func (m *mockPlugin) String() string { return "mock" }
+
+func TestRest_TwoFactor(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	dir, err := ioutil.TempDir("", "totp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	call := func(method, path, body, tkn string) (R.JSON, *http.Response) {
+		req, e := http.NewRequest(method, ts.URL+"/api/v1/totp"+path+"?site=remark42", strings.NewReader(body))
+		require.NoError(t, e)
+		resp, e := sendReq(t, req, tkn)
+		require.NoError(t, e)
+		defer resp.Body.Close()
+		res := R.JSON{}
+		_ = json.NewDecoder(resp.Body).Decode(&res)
+		return res, resp
+	}
+	_, resp := call(http.MethodGet, "", "", devToken)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "disabled")
+
+	srv.privRest.twoFactor = totp.NewService(totp.NewEngineStore(srv.DataService.Engine), totp.Params{})
+
+	makeToken := func(admin, pending bool) string {
+		claims := token.Claims{
+			User: &token.User{ID: "github_admin", Name: "admin"},
+			StandardClaims: jwt.StandardClaims{Audience: "remark42", Issuer: "remark42",
+				ExpiresAt: time.Now().Add(10 * time.Minute).Unix(), NotBefore: time.Now().Add(-1 * time.Minute).Unix()},
+		}
+		claims.User.SetAdmin(admin)
+		claims.User.SetBoolAttr(totp.PendingFlag, pending)
+		tkn, e := srv.Authenticator.TokenService().Token(claims)
+		require.NoError(t, e)
+		return tkn
+	}
+	adminToken, pendingToken := makeToken(true, false), makeToken(false, true)
+
+	_, resp = call(http.MethodPost, "/enroll", "", devToken)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "not an admin")
+
+	res, resp := call(http.MethodPost, "/enroll", "", adminToken)
+	require.Equal(t, http.StatusOK, resp.StatusCode, res)
+	secret := res["secret"].(string)
+	assert.True(t, strings.HasPrefix(res["qr"].(string), "data:image/png;base64,"))
+
+	res, resp = call(http.MethodPost, "/confirm", `{"code": "000000"}`, adminToken)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, res)
+	_, resp = call(http.MethodPost, "/confirm", `{}`, adminToken)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	res, resp = call(http.MethodPost, "/confirm", `{"code": "`+totpCode(t, secret, time.Now())+`"}`, adminToken)
+	require.Equal(t, http.StatusOK, resp.StatusCode, res)
+	backupCodes := res["backup_codes"].([]interface{})
+	assert.Equal(t, 10, len(backupCodes))
+	assert.True(t, tokenAttr(t, srv, resp, totp.VerifiedFlag), "session verified")
+
+	res, resp = call(http.MethodGet, "", "", pendingToken)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, R.JSON{"enrolled": true, "enforced": false, "pending": true, "verified": false}, res)
+
+	_, resp = call(http.MethodPost, "/verify", `{"code": "bad"}`, pendingToken)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	res, resp = call(http.MethodPost, "/verify", `{"code": "`+backupCodes[0].(string)+`"}`, pendingToken)
+	require.Equal(t, http.StatusOK, resp.StatusCode, res)
+	assert.True(t, tokenAttr(t, srv, resp, totp.VerifiedFlag), "session verified")
+
+	_, resp = call(http.MethodDelete, "", `{"code": "`+backupCodes[1].(string)+`"}`, pendingToken)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "pending admin can't disable")
+	srv.privRest.twoFactor.Sites = func(string) bool { return true }
+	_, resp = call(http.MethodDelete, "", `{"code": "`+backupCodes[1].(string)+`"}`, adminToken)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "enforced for site")
+	srv.privRest.twoFactor.Sites = nil
+	res, resp = call(http.MethodDelete, "", `{"code": "`+backupCodes[1].(string)+`"}`, adminToken)
+	require.Equal(t, http.StatusOK, resp.StatusCode, res)
+	assert.False(t, srv.privRest.twoFactor.Enrolled("remark42", "github_admin"))
+
+	// verification locked after too many invalid codes in a row
+	res, resp = call(http.MethodPost, "/enroll", "", adminToken)
+	require.Equal(t, http.StatusOK, resp.StatusCode, res)
+	res, resp = call(http.MethodPost, "/confirm", `{"code": "`+totpCode(t, res["secret"].(string), time.Now())+`"}`, adminToken)
+	require.Equal(t, http.StatusOK, resp.StatusCode, res)
+	backupCodes = res["backup_codes"].([]interface{})
+	for i := 0; i < 5; i++ {
+		_, resp = call(http.MethodPost, "/verify", `{"code": "bad"}`, pendingToken)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	}
+	_, resp = call(http.MethodPost, "/verify", `{"code": "`+backupCodes[0].(string)+`"}`, pendingToken)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode, "valid code rejected while locked")
+}
+
+// totpCode makes code of authenticator app for the secret, RFC 6238
+func totpCode(t *testing.T, secret string, ts time.Time) string {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	require.NoError(t, err)
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(ts.Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	_, _ = mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:offset+4])&0x7fffffff)%1000000)
+}
+
+// tokenAttr returns bool attribute of the user from JWT cookie set by response
+func tokenAttr(t *testing.T, srv *Rest, resp *http.Response, attr string) bool {
+	for _, c := range resp.Cookies() {
+		if c.Name == "JWT" {
+			claims, err := srv.Authenticator.TokenService().Parse(c.Value)
+			require.NoError(t, err)
+			return claims.User.BoolAttr(attr)
+		}
+	}
+	t.Fatal("no JWT cookie")
+	return false
+}
+
+func TestRest_Drafts(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	send := func(method, body string) (string, int) {
+		req, err := http.NewRequest(method, ts.URL+"/api/v1/draft?site=remark42&url=https://radio-t.com/blah1", strings.NewReader(body))
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, devToken)
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return string(b), resp.StatusCode
+	}
+
+	body, code := send(http.MethodGet, "")
+	assert.Equal(t, http.StatusNotFound, code, "disabled, %s", body)
+
+	srv.privRest.drafts = drafts.NewService(drafts.NewEngineStore(srv.DataService.Engine), drafts.Params{MaxSize: 100})
+
+	body, code = send(http.MethodGet, "")
+	assert.Equal(t, http.StatusNotFound, code, "not saved, %s", body)
+	body, code = send(http.MethodPut, `{"text": "`+strings.Repeat("x", 101)+`"}`)
+	assert.Equal(t, http.StatusBadRequest, code, "too long, %s", body)
+	body, code = send(http.MethodPut, `{"text": "in progress", "pid": "p1"}`)
+	require.Equal(t, http.StatusOK, code, body)
+
+	body, code = send(http.MethodGet, "")
+	require.Equal(t, http.StatusOK, code, body)
+	d := drafts.Draft{}
+	require.NoError(t, json.Unmarshal([]byte(body), &d))
+	assert.Equal(t, "in progress", d.Text)
+	assert.Equal(t, "p1", d.ParentID)
+	assert.Equal(t, "dev", d.UserID)
+
+	_, code = send(http.MethodDelete, "")
+	require.Equal(t, http.StatusOK, code)
+	_, code = send(http.MethodGet, "")
+	assert.Equal(t, http.StatusNotFound, code, "deleted")
+
+	_, code = send(http.MethodPut, `{"text": "in progress"}`)
+	require.Equal(t, http.StatusOK, code)
+	addComment(t, store.Comment{Text: "posted", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}}, ts)
+	_, code = send(http.MethodGet, "")
+	assert.Equal(t, http.StatusNotFound, code, "deleted on posting")
+}
+
+func TestRest_Bookmarks(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	send := func(method, uri string) (string, int) {
+		req, err := http.NewRequest(method, ts.URL+uri, http.NoBody)
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, devToken)
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return string(b), resp.StatusCode
+	}
+	loc := "site=remark42&url=https://radio-t.com/blah1"
+
+	body, code := send(http.MethodGet, "/api/v1/bookmarks?site=remark42")
+	assert.Equal(t, http.StatusNotFound, code, "disabled, %s", body)
+
+	srv.privRest.bookmarks = bookmarks.NewService(bookmarks.NewEngineStore(srv.DataService.Engine), 10)
+
+	id1 := addComment(t, store.Comment{Text: "useful answer", Locator: store.Locator{SiteID: "remark42",
+		URL: "https://radio-t.com/blah1"}}, ts)
+	id2 := addComment(t, store.Comment{Text: "another one", Locator: store.Locator{SiteID: "remark42",
+		URL: "https://radio-t.com/blah1"}}, ts)
+
+	body, code = send(http.MethodPut, "/api/v1/bookmark/unknown?"+loc)
+	assert.Equal(t, http.StatusBadRequest, code, "no comment, %s", body)
+
+	body, code = send(http.MethodPut, "/api/v1/bookmark/"+id1+"?"+loc)
+	require.Equal(t, http.StatusOK, code, body)
+	assert.JSONEq(t, `{"id":"`+id1+`","bookmarked":true,"count":1}`, body)
+	_, code = send(http.MethodPut, "/api/v1/bookmark/"+id2+"?"+loc)
+	require.Equal(t, http.StatusOK, code)
+
+	body, code = send(http.MethodGet, "/api/v1/bookmarks?site=remark42")
+	require.Equal(t, http.StatusOK, code, body)
+	res := struct {
+		Bookmarks []struct {
+			ID      string        `json:"id"`
+			URL     string        `json:"url"`
+			Comment store.Comment `json:"comment"`
+		} `json:"bookmarks"`
+		Count int `json:"count"`
+	}{}
+	require.NoError(t, json.Unmarshal([]byte(body), &res))
+	require.Equal(t, 2, res.Count)
+	assert.Equal(t, "https://radio-t.com/blah1", res.Bookmarks[1].URL)
+	assert.Equal(t, "<p>useful answer</p>\n", res.Bookmarks[1].Comment.Text)
+
+	body, code = send(http.MethodGet, "/api/v1/bookmarks/post?"+loc)
+	require.Equal(t, http.StatusOK, code, body)
+	state := struct {
+		Counts map[string]int `json:"counts"`
+		Saved  []string       `json:"saved"`
+	}{}
+	require.NoError(t, json.Unmarshal([]byte(body), &state))
+	assert.Equal(t, map[string]int{id1: 1, id2: 1}, state.Counts)
+	assert.ElementsMatch(t, []string{id1, id2}, state.Saved)
+
+	body, code = send(http.MethodDelete, "/api/v1/bookmark/"+id1+"?site=remark42")
+	require.Equal(t, http.StatusOK, code, body)
+	assert.JSONEq(t, `{"id":"`+id1+`","bookmarked":false}`, body)
+	body, code = send(http.MethodGet, "/api/v1/bookmarks/post?"+loc)
+	require.Equal(t, http.StatusOK, code, body)
+	state.Counts = nil
+	require.NoError(t, json.Unmarshal([]byte(body), &state))
+	assert.Equal(t, map[string]int{id2: 1}, state.Counts)
+	assert.Equal(t, []string{id2}, state.Saved)
+}
+
+func TestRest_Identity(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	send := func(method, uri, tkn string) (string, int) {
+		req, err := http.NewRequest(method, ts.URL+uri, http.NoBody)
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, tkn)
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return string(b), resp.StatusCode
+	}
+
+	body, code := send(http.MethodPost, "/api/v1/identity/link?site=remark42", devToken)
+	assert.Equal(t, http.StatusNotFound, code, "disabled, %s", body)
+
+	svc := identity.NewService(identity.NewEngineStore(srv.DataService.Engine), func(siteID, fromID, toID string) error {
+		_, e := srv.DataService.Reattribute(siteID, fromID, toID, false)
+		return e
+	}, time.Minute)
+	srv.privRest.identity, srv.adminRest.identity = svc, svc
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}
+	id, err := srv.DataService.Create(store.Comment{Text: "from github", Locator: locator,
+		User: store.User{ID: "github_ef0f706a7", Name: "Umputun"}})
+	require.NoError(t, err)
+
+	body, code = send(http.MethodPost, "/api/v1/identity/link?site=remark42", devToken)
+	require.Equal(t, http.StatusOK, code, body)
+	req := struct {
+		Code string `json:"code"`
+	}{}
+	require.NoError(t, json.Unmarshal([]byte(body), &req))
+	assert.Equal(t, 16, len(req.Code))
+
+	body, code = send(http.MethodPost, "/api/v1/identity/link/confirm?site=remark42&code=bad", adminUmputunToken)
+	assert.Equal(t, http.StatusBadRequest, code, body)
+	body, code = send(http.MethodPost, "/api/v1/identity/link/confirm?site=remark42&code="+req.Code, adminUmputunToken)
+	require.Equal(t, http.StatusOK, code, body)
+	assert.JSONEq(t, `{"user":"dev","linked":"github_ef0f706a7"}`, body)
+
+	c, err := srv.DataService.Get(locator, id, store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, "dev", c.User.ID, "comment merged to canonical user")
+	assert.Equal(t, "dev", svc.Canonical("remark42", "github_ef0f706a7"))
+
+	body, code = send(http.MethodGet, "/api/v1/identity?site=remark42", devToken)
+	require.Equal(t, http.StatusOK, code, body)
+	assert.Contains(t, body, `"user":"dev"`)
+	assert.Contains(t, body, `"linked_id":"github_ef0f706a7"`)
+
+	body, code = send(http.MethodGet, "/api/v1/admin/identity?site=remark42&user=github_ef0f706a7", adminUmputunToken)
+	require.Equal(t, http.StatusOK, code, body)
+	assert.Contains(t, body, `"user":"dev"`)
+
+	body, code = send(http.MethodDelete, "/api/v1/admin/identity/link?site=remark42&user=github_ef0f706a7", adminUmputunToken)
+	require.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, "github_ef0f706a7", svc.Canonical("remark42", "github_ef0f706a7"))
+	body, code = send(http.MethodDelete, "/api/v1/admin/identity/link?site=remark42&user=github_ef0f706a7", adminUmputunToken)
+	assert.Equal(t, http.StatusBadRequest, code, body)
+
+	body, code = send(http.MethodPut, "/api/v1/admin/identity/link?site=remark42&from=dev&to=google_1", adminUmputunToken)
+	require.Equal(t, http.StatusOK, code, body)
+	assert.Contains(t, body, `"user_id":"google_1"`)
+	c, err = srv.DataService.Get(locator, id, store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, "google_1", c.User.ID, "comment merged by admin")
+}
+
+func TestRest_Sessions(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	send := func(method, uri, body, tkn string) (*http.Response, string) {
+		req, err := http.NewRequest(method, ts.URL+uri, strings.NewReader(body))
+		require.NoError(t, err)
+		resp, err := sendReq(t, req, tkn)
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp, string(b)
+	}
+
+	resp, body := send(http.MethodGet, "/api/v1/sessions?site=remark42", "", devToken)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "disabled, %s", body)
+
+	dir, err := ioutil.TempDir("", "sessions")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	svc, err := sessions.NewService(path.Join(dir, "sessions.db"), bolt.Options{}, sessions.Params{TTL: time.Hour})
+	require.NoError(t, err)
+	defer svc.Close()
+	srv.privRest.sessions, srv.adminRest.sessions = svc, svc
+
+	claims, err := srv.Authenticator.TokenService().Parse(devToken)
+	require.NoError(t, err)
+	require.True(t, svc.Validate(claims), "session started by validator of auth")
+	other := claims
+	other.Id = "other id"
+	require.True(t, svc.Validate(other))
+
+	resp, body = send(http.MethodPost, "/api/v1/session/refresh-token?site=remark42", "", devToken)
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	issued := struct {
+		RefreshToken string    `json:"refresh_token"`
+		Expires      time.Time `json:"expires"`
+	}{}
+	require.NoError(t, json.Unmarshal([]byte(body), &issued))
+	assert.NotEmpty(t, issued.RefreshToken)
+	assert.True(t, issued.Expires.After(time.Now()))
+
+	resp, body = send(http.MethodPost, "/api/v1/session/refresh", `{"refresh_token": "`+issued.RefreshToken+`"}`, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	refreshed := issued
+	require.NoError(t, json.Unmarshal([]byte(body), &refreshed))
+	assert.NotEqual(t, issued.RefreshToken, refreshed.RefreshToken, "rotated")
+	var jwtCookie *http.Cookie
+	for _, c := range resp.Cookies() {
+		if c.Name == "JWT" {
+			jwtCookie = c
+		}
+	}
+	require.NotNil(t, jwtCookie)
+	newClaims, err := srv.Authenticator.TokenService().Parse(jwtCookie.Value)
+	require.NoError(t, err)
+	assert.Equal(t, "dev", newClaims.User.ID)
+	assert.Equal(t, claims.Id, newClaims.Id, "the same session")
+
+	resp, body = send(http.MethodPost, "/api/v1/session/refresh", `{"refresh_token": "`+issued.RefreshToken+`"}`, "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "used token, %s", body)
+	resp, _ = send(http.MethodPost, "/api/v1/session/refresh", `{}`, "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, body = send(http.MethodGet, "/api/v1/sessions?site=remark42", "", devToken)
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	list := struct {
+		Sessions []sessions.Session `json:"sessions"`
+		Current  string             `json:"current"`
+	}{}
+	require.NoError(t, json.Unmarshal([]byte(body), &list))
+	assert.Equal(t, 2, len(list.Sessions))
+	assert.Equal(t, claims.Id, list.Current)
+
+	resp, _ = send(http.MethodDelete, "/api/v1/sessions/unknown?site=remark42", "", devToken)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, body = send(http.MethodDelete, "/api/v1/sessions/other%20id?site=remark42", "", devToken)
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	assert.False(t, svc.Validate(other))
+
+	req, err := http.NewRequest(http.MethodDelete, ts.URL+"/api/v1/admin/sessions/dev?site=remark42", nil)
+	require.NoError(t, err)
+	requireAdminOnly(t, req)
+	resp, body = send(http.MethodDelete, "/api/v1/admin/sessions/dev?site=remark42", "", adminUmputunToken)
+	require.Equal(t, http.StatusOK, resp.StatusCode, body)
+	assert.Contains(t, body, `"revoked":1`)
+	assert.False(t, svc.Validate(claims))
+	resp, body = send(http.MethodPost, "/api/v1/session/refresh", `{"refresh_token": "`+refreshed.RefreshToken+`"}`, "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "revoked, %s", body)
+}
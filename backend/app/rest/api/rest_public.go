@@ -2,12 +2,16 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha1" // nolint
 	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -19,19 +23,36 @@ import (
 	R "github.com/go-pkgz/rest"
 	"github.com/pkg/errors"
 
+	"github.com/umputun/remark42/backend/app/migrator"
+	"github.com/umputun/remark42/backend/app/reputation"
 	"github.com/umputun/remark42/backend/app/rest"
+	"github.com/umputun/remark42/backend/app/schedule"
+	"github.com/umputun/remark42/backend/app/settings"
 	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/attachment"
+	"github.com/umputun/remark42/backend/app/store/events"
 	"github.com/umputun/remark42/backend/app/store/image"
+	"github.com/umputun/remark42/backend/app/store/search"
 	"github.com/umputun/remark42/backend/app/store/service"
+	"github.com/umputun/remark42/backend/app/tracing"
+	"github.com/umputun/remark42/backend/app/translate"
 )
 
 type public struct {
 	dataService      pubStore
 	cache            LoadingCache
-	readOnlyAge      int
+	settings         *settings.Service
 	commentFormatter *store.CommentFormatter
 	imageService     *image.Service
+	attachments      *attachment.Service
 	webRoot          string
+	archiver         *migrator.Archiver
+	historyPublic    bool
+	events           *events.Bus
+	schedule         *schedule.Service
+	reputation       *reputation.Service
+	translator       *translate.Service
+	siteKey          func(siteID string) (string, error) // signs badges of posts
 }
 
 type pubStore interface {
@@ -47,11 +68,21 @@ type pubStore interface {
 
 	ValidateComment(c *store.Comment) error
 	IsReadOnly(locator store.Locator) bool
+	IsSlowMode(locator store.Locator) bool
+	Interval(locator store.Locator) int
 	Counts(siteID string, postIDs []string) ([]store.PostInfo, error)
+	Search(req search.Request, user store.User) (service.SearchResult, error)
+	Similar(req search.SimilarRequest, user store.User) (service.SearchResult, error)
+	History(locator store.Locator, commentID string) ([]service.HistoryEntry, error)
+	Visible(comment store.Comment, user store.User) bool
+	PersonalViewers(locator store.Locator) ([]string, error)
 }
 
-// GET /find?site=siteID&url=post-url&format=[tree|plain]&sort=[+/-time|+/-score|+/-controversy]&view=[user|all]&since=unix_ts_msec
-// find comments for given post. Returns in tree or plain formats, sorted
+// GET /find?site=siteID&url=post-url&format=[tree|plain]&sort=[+/-time|+/-score|+/-controversy|+/-quality|+/-best]&view=[user|all]&since=unix_ts_msec
+// find comments for given post. Returns in tree or plain formats, sorted.
+// Original markdown of comments returned in raw field with markdown=true or Accept: text/markdown, for all comment endpoints.
+// Tree can be paginated with limit=N top-level comments per page, cursor from the previous page and replies=M
+// replies on each level, cut replies loaded with GET /replies/{id}.
 func (s *public) findCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
 	sort := r.URL.Query().Get("sort")
@@ -69,47 +100,170 @@ func (s *public) findCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 	if format == "tree" {
 		since = time.Time{} // since doesn't make sense for tree
 	}
+	page, err := s.parsePageRequest(r)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't parse page", rest.ErrDecode)
+		return
+	}
 
 	log.Printf("[DEBUG] get comments for %+v, sort %s, format %s, since %v", locator, sort, format, since)
 
-	key := cache.NewKey(locator.SiteID).ID(URLKeyWithUser(r)).Scopes(locator.SiteID, locator.URL)
-	data, err := s.cache.Get(key, func() ([]byte, error) {
-		comments, e := s.dataService.FindSince(locator, sort, rest.GetUserOrEmpty(r), since)
+	slowMode := s.dataService.IsSlowMode(locator)
+	scheduleState, scheduleAt := s.schedule.Check(locator.SiteID, locator.URL)
+	interval := s.dataService.Interval(locator)
+	findComments := func(ctx context.Context) ([]byte, error) {
+		var comments []store.Comment
+		e := tracing.Span(ctx, "store.find", func(context.Context) (err error) {
+			comments, err = s.dataService.FindSince(locator, sort, rest.GetUserOrEmpty(r), since)
+			return err
+		})
 		if e != nil {
 			comments = []store.Comment{} // error should clear comments and continue for post info
 		}
-		comments = s.applyView(comments, view)
+		comments = s.reputation.Apply(withMarkdown(r, s.applyView(comments, view)))
 		var b []byte
 		switch format {
 		case "tree":
-			tree := service.MakeTree(comments, sort, s.readOnlyAge)
+			tree := service.MakeTree(comments, sort, s.settings.ReadOnlyAge(locator.SiteID))
 			if tree.Nodes == nil { // eliminate json nil serialization
 				tree.Nodes = []*service.Node{}
 			}
+			tree.Flatten(s.settings.MaxReplyDepth(locator.SiteID)) // replies made before the limit set
+			if e = tree.Page(page); e != nil {
+				return nil, e
+			}
 			if s.dataService.IsReadOnly(locator) {
 				tree.Info.ReadOnly = true
 			}
+			tree.Info.SlowMode = slowMode
+			setSchedule(&tree.Info, scheduleState, scheduleAt)
+			tree.Info.Interval = interval
 			b, e = encodeJSONWithHTML(tree)
 		default:
 			withInfo := commentsWithInfo{Comments: comments}
-			if info, ee := s.dataService.Info(locator, s.readOnlyAge); ee == nil {
+			if info, ee := s.dataService.Info(locator, s.settings.ReadOnlyAge(locator.SiteID)); ee == nil {
 				withInfo.Info = info
 			}
+			withInfo.Info.SlowMode = slowMode
+			setSchedule(&withInfo.Info, scheduleState, scheduleAt)
+			withInfo.Info.Interval = interval
 			b, e = encodeJSONWithHTML(withInfo)
 		}
 		return b, e
-	})
+	}
+
+	var data []byte
+	var modified time.Time
+	if slowMode || !scheduleAt.IsZero() { // visibility of comments in slow mode and schedule change with time, can't be cached
+		data, err = findComments(r.Context())
+	} else {
+		modified = s.lastModified(locator) // loaded before comments, change in between can't be missed
+		key := cache.NewKey(locator.SiteID).ID(s.commentsKey(r, locator)).Scopes(locator.SiteID, locator.URL)
+		data, err = cachedGet(r.Context(), s.cache, key, findComments)
+	}
 
 	if err != nil {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't find comments", rest.ErrCommentNotFound)
 		return
 	}
 
+	if notModified(w, r, data, modified) {
+		return
+	}
 	if err = R.RenderJSONFromBytes(w, r, data); err != nil {
 		log.Printf("[WARN] can't render comments for post %+v", locator)
 	}
 }
 
+// lastModified returns time of the last activity on the post, kept in the cache along with its comments
+// and reset with them by any change of the post. Last-Modified has a precision of seconds, so zero time returned
+// till the end of the second the activity happened in, as well as if the time can't be loaded.
+func (s *public) lastModified(locator store.Locator) time.Time {
+	key := cache.NewKey(locator.SiteID).ID("modified!!"+locator.SiteID+"!!"+locator.URL).Scopes(locator.SiteID, locator.URL)
+	data, err := s.cache.Get(key, func() ([]byte, error) {
+		return []byte(strconv.FormatInt(time.Now().UnixNano(), 10)), nil
+	})
+	if err != nil {
+		return time.Time{}
+	}
+	ts, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	modified := time.Unix(0, ts).UTC()
+	if !modified.Before(time.Now().Truncate(time.Second)) {
+		return time.Time{} // other changes possible within the same second
+	}
+	return modified.Truncate(time.Second)
+}
+
+// notModified sets Etag of data and Last-Modified headers and responds with 304 if the client has the same
+// version already. If-None-Match takes precedence over If-Modified-Since, the latter ignored for zero modified time.
+func notModified(w http.ResponseWriter, r *http.Request, data []byte, modified time.Time) bool {
+	etag := fmt.Sprintf(`"%x"`, sha1.Sum(data)) // nolint
+	w.Header().Set("Etag", etag)
+	w.Header().Set("Cache-Control", "no-cache") // polling clients should revalidate every time
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+	}
+
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		if strings.Contains(match, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+		return false
+	}
+	if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modified.IsZero() && !modified.After(since) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// GET /replies/{id}?site=siteID&url=post-url&limit=N&cursor=reply-id&replies=M - replies to the comment as a tree,
+// sorted by time. Used to load replies cut from paginated tree, cursor is the id of the last loaded reply.
+func (s *public) repliesCtrl(w http.ResponseWriter, r *http.Request) {
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	id := chi.URLParam(r, "id")
+	page, err := s.parsePageRequest(r)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't parse page", rest.ErrDecode)
+		return
+	}
+
+	findReplies := func() ([]byte, error) {
+		comments, e := s.dataService.FindSince(locator, "time", rest.GetUserOrEmpty(r), time.Time{})
+		if e != nil {
+			return nil, e
+		}
+		comments = withMarkdown(r, comments)
+		tree := service.MakeTree(comments, "time", s.settings.ReadOnlyAge(locator.SiteID))
+		tree.Flatten(s.settings.MaxReplyDepth(locator.SiteID))
+		branch, e := tree.Branch(id, page)
+		if e != nil {
+			return nil, e
+		}
+		return encodeJSONWithHTML(branch)
+	}
+
+	var data []byte
+	if s.dataService.IsSlowMode(locator) {
+		data, err = findReplies()
+	} else {
+		key := cache.NewKey(locator.SiteID).ID(s.commentsKey(r, locator)).Scopes(locator.SiteID, locator.URL)
+		data, err = s.cache.Get(key, findReplies)
+	}
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't find replies", rest.ErrCommentNotFound)
+		return
+	}
+
+	if err = R.RenderJSONFromBytes(w, r, data); err != nil {
+		log.Printf("[WARN] can't render replies of %s for post %+v", id, locator)
+	}
+}
+
 // POST /preview, body is a comment, returns rendered html
 func (s *public) previewCommentCtrl(w http.ResponseWriter, r *http.Request) {
 	comment := store.Comment{}
@@ -141,6 +295,12 @@ func (s *public) previewCommentCtrl(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	for _, id := range s.attachments.Extract(comment.Text) {
+		if err = s.attachments.ResetCleanupTimer(id); err != nil {
+			rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't renew staged file cleanup timer", rest.ErrAssetNotFound)
+			return
+		}
+	}
 
 	render.HTML(w, r, comment.Text)
 }
@@ -151,7 +311,7 @@ func (s *public) infoCtrl(w http.ResponseWriter, r *http.Request) {
 
 	key := cache.NewKey(locator.SiteID).ID(URLKey(r)).Scopes(locator.SiteID, locator.URL)
 	data, err := s.cache.Get(key, func() ([]byte, error) {
-		info, e := s.dataService.Info(locator, s.readOnlyAge)
+		info, e := s.dataService.Info(locator, s.settings.ReadOnlyAge(locator.SiteID))
 		if e != nil {
 			return nil, e
 		}
@@ -168,6 +328,101 @@ func (s *public) infoCtrl(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GET /archive?site=siteID&url=post-url&format=[json|html] - static archive of frozen post, json by default
+func (s *public) archiveCtrl(w http.ResponseWriter, r *http.Request) {
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	asHTML := r.URL.Query().Get("format") == "html"
+
+	if s.archiver == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("archive disabled"), "can't get archive", rest.ErrPostNotFound)
+		return
+	}
+	data, err := s.archiver.Read(locator, asHTML)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, err, "can't get archive", rest.ErrPostNotFound)
+		return
+	}
+
+	if asHTML {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	}
+	if _, err = w.Write(data); err != nil {
+		log.Printf("[WARN] can't render archive for post %+v", locator)
+	}
+}
+
+// GET /search?site=siteID&query=text&label=question&sort=[+/-time]&limit=20&skip=0 - full-text search of site's comments.
+// Query uses bleve query string syntax, results sorted by relevance if sort not set, each comment returned with
+// fragments of text containing matched terms highlighted with <mark>. Optional label limits results to comments
+// with the label, query can be empty in this case. Results not cached.
+func (s *public) searchCtrl(w http.ResponseWriter, r *http.Request) {
+	req := search.Request{SiteID: r.URL.Query().Get("site"), Query: strings.TrimSpace(r.URL.Query().Get("query")),
+		Label: strings.ToLower(strings.TrimSpace(r.URL.Query().Get("label"))), SortBy: r.URL.Query().Get("sort")}
+	if strings.HasPrefix(req.SortBy, " ") { // restore + replaced by " "
+		req.SortBy = "+" + req.SortBy[1:]
+	}
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil {
+		req.Limit = v
+	}
+	if v, err := strconv.Atoi(r.URL.Query().Get("skip")); err == nil {
+		req.Skip = v
+	}
+	if req.Query == "" && req.Label == "" {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("empty query"), "can't search", rest.ErrDecode)
+		return
+	}
+
+	res, err := s.dataService.Search(req, rest.GetUserOrEmpty(r))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't search", rest.ErrActionRejected)
+		return
+	}
+	data, err := encodeJSONWithHTML(res)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't encode search result", rest.ErrInternal)
+		return
+	}
+	if err = R.RenderJSONFromBytes(w, r, data); err != nil {
+		log.Printf("[WARN] can't render search result for site %s", req.SiteID)
+	}
+}
+
+// POST /similar?site=siteID&url=post-url&limit=N - existing comments of the post similar to the draft text
+// from the body, i.e. {"text": "how to ..."}. Lets frontends suggest existing answers before posting duplicates.
+func (s *public) similarCtrl(w http.ResponseWriter, r *http.Request) {
+	draft := struct {
+		Text string `json:"text"`
+	}{}
+	if err := render.DecodeJSON(http.MaxBytesReader(w, r.Body, hardBodyLimit), &draft); err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't bind draft", rest.ErrDecode)
+		return
+	}
+	req := search.SimilarRequest{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url"), Text: draft.Text}
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil {
+		req.Limit = v
+	}
+	if req.URL == "" || strings.TrimSpace(req.Text) == "" {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("empty url or text"), "can't find similar", rest.ErrDecode)
+		return
+	}
+
+	res, err := s.dataService.Similar(req, rest.GetUserOrEmpty(r))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't find similar", rest.ErrActionRejected)
+		return
+	}
+	data, err := encodeJSONWithHTML(res)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, err, "can't encode similar comments", rest.ErrInternal)
+		return
+	}
+	if err = R.RenderJSONFromBytes(w, r, data); err != nil {
+		log.Printf("[WARN] can't render similar comments for %s", req.URL)
+	}
+}
+
 // GET /last/{limit}?site=siteID&since=unix_ts_msec - last comments for the siteID, across all posts, sorted by time, optionally
 // limited with "since" param
 func (s *public) lastCommentsCtrl(w http.ResponseWriter, r *http.Request) {
@@ -193,7 +448,7 @@ func (s *public) lastCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 		}
 		// filter deleted from last comments view. Blocked marked as deleted and will sneak in without
 		filterDeleted := filterComments(comments, func(c store.Comment) bool { return !c.Deleted })
-		return encodeJSONWithHTML(filterDeleted)
+		return encodeJSONWithHTML(withMarkdown(r, filterDeleted))
 	})
 
 	if err != nil {
@@ -220,6 +475,7 @@ func (s *public) commentByIDCtrl(w http.ResponseWriter, r *http.Request) {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get comment by id", rest.ErrCommentNotFound)
 		return
 	}
+	comment = withMarkdown(r, []store.Comment{comment})[0]
 	render.Status(r, http.StatusOK)
 
 	if err = R.RenderJSONWithHTML(w, r, comment); err != nil {
@@ -227,6 +483,67 @@ func (s *public) commentByIDCtrl(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GET /translate/{id}?site=siteID&url=post-url&lang=de - html text of the comment translated to the language,
+// for sites with translation enabled. Translations cached per comment and language.
+func (s *public) translateCtrl(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	lang := r.URL.Query().Get("lang")
+	if !s.settings.Translation(locator.SiteID) {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("disabled"), "translation not enabled for the site", rest.ErrActionRejected)
+		return
+	}
+
+	user := rest.GetUserOrEmpty(r)
+	comment, err := s.dataService.Get(locator, id, user)
+	if err != nil || comment.Deleted || !s.dataService.Visible(comment, user) {
+		if err == nil {
+			err = errors.New("not visible")
+		}
+		rest.SendErrorJSON(w, r, http.StatusNotFound, err, "can't get comment by id", rest.ErrCommentNotFound)
+		return
+	}
+
+	text, err := s.translator.Translate(comment, lang)
+	if err == translate.ErrLanguage {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't translate comment to "+lang, rest.ErrDecode)
+		return
+	}
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadGateway, err, "can't translate comment", rest.ErrInternal)
+		return
+	}
+	render.JSON(w, r, R.JSON{"id": comment.ID, "lang": strings.ToLower(lang), "text": text})
+}
+
+// GET /comment/{id}/history?site=siteID&url=post-url - edits of the comment with replaced texts, editors and diffs,
+// the oldest first. Available for admins only, unless public history enabled.
+func (s *public) historyCtrl(w http.ResponseWriter, r *http.Request) {
+	user := rest.GetUserOrEmpty(r)
+	if !s.historyPublic && !user.Admin {
+		rest.SendErrorJSON(w, r, http.StatusForbidden, errors.New("rejected"), "edit history available for admins only", rest.ErrNoAccess)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	comment, err := s.dataService.Get(locator, id, user)
+	if err == nil && comment.Pending && !user.Admin && comment.User.ID != user.ID {
+		err = errors.Errorf("comment %s is pending", id) // pending comment hidden from others
+	}
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get comment by id", rest.ErrCommentNotFound)
+		return
+	}
+
+	history, err := s.dataService.History(locator, id)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get edit history", rest.ErrCommentNotFound)
+		return
+	}
+	render.JSON(w, r, R.JSON{"id": id, "history": history})
+}
+
 // GET /comments?site=siteID&user=id - returns comments for given userID
 func (s *public) findUserCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 
@@ -256,7 +573,7 @@ func (s *public) findUserCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 		if e != nil {
 			return nil, e
 		}
-		resp.Comments, resp.Count = comments, count
+		resp.Comments, resp.Count = withMarkdown(r, comments), count
 		return encodeJSONWithHTML(resp)
 	})
 
@@ -281,6 +598,16 @@ func (s *public) countCtrl(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, R.JSON{"count": count, "locator": locator})
 }
 
+// GET /karma?site=siteID&user=userID - get karma of the user with its components
+func (s *public) karmaCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID, userID := r.URL.Query().Get("site"), r.URL.Query().Get("user")
+	if userID == "" {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("missing user"), "user id required", rest.ErrDecode)
+		return
+	}
+	render.JSON(w, r, s.reputation.Karma(siteID, userID))
+}
+
 // POST /counts?site=siteID - get number of comments for posts from post body
 func (s *public) countMultiCtrl(w http.ResponseWriter, r *http.Request) {
 	const countBodyLimit int64 = 1024 * 128 // count request can be big for some site because it lists all urls
@@ -296,7 +623,7 @@ func (s *public) countMultiCtrl(w http.ResponseWriter, r *http.Request) {
 	h := sha1.Sum([]byte(k)) // nolint
 	sha := base64.URLEncoding.EncodeToString(h[:])
 
-	key := cache.NewKey(siteID).ID(sha).Scopes(siteID)
+	key := cache.NewKey(siteID).ID(sha).Scopes(siteID, postsScope)
 	data, err := s.cache.Get(key, func() ([]byte, error) {
 		counts, e := s.dataService.Counts(siteID, posts)
 		if e != nil {
@@ -315,6 +642,72 @@ func (s *public) countMultiCtrl(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+const (
+	maxCountsBatch = 100              // max number of posts in one GET /counts request
+	countsMaxAge   = 30 * time.Second // browsers and proxies revalidate counts with etag after this
+)
+
+// GET /counts?site=siteID&url=post-url1&url=post-url2 - get number of comments for up to 100 posts, sorted by url.
+// Posts without comments have zero count. Response has strong etag of the content and answered with 304 if matched,
+// cached internally till the next comment created or deleted on the site.
+func (s *public) countBatchCtrl(w http.ResponseWriter, r *http.Request) {
+	siteID := r.URL.Query().Get("site")
+	urls := uniqueSorted(r.URL.Query()["url"])
+	if len(urls) == 0 || len(urls) > maxCountsBatch {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.Errorf("%d urls requested", len(urls)),
+			fmt.Sprintf("1-%d post urls required", maxCountsBatch), rest.ErrDecode)
+		return
+	}
+
+	h := sha1.Sum([]byte(strings.Join(urls, "\n"))) // nolint
+	key := cache.NewKey(siteID).ID("counts-"+base64.URLEncoding.EncodeToString(h[:])).Scopes(siteID, postsScope)
+	data, err := s.cache.Get(key, func() ([]byte, error) {
+		counts, e := s.dataService.Counts(siteID, urls)
+		if e != nil {
+			return nil, e
+		}
+		found := make(map[string]int, len(counts))
+		for _, c := range counts {
+			found[c.URL] = c.Count
+		}
+		res := make([]store.PostInfo, 0, len(urls))
+		for _, u := range urls {
+			res = append(res, store.PostInfo{URL: u, Count: found[u]})
+		}
+		return encodeJSONWithHTML(res)
+	})
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get counts for "+siteID, rest.ErrSiteNotFound)
+		return
+	}
+
+	etag := fmt.Sprintf(`"%x"`, sha1.Sum(data)) // nolint
+	w.Header().Set("Etag", etag)
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d, must-revalidate", int(countsMaxAge.Seconds())))
+	if match := r.Header.Get("If-None-Match"); match != "" && strings.Contains(match, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if err = R.RenderJSONFromBytes(w, r, data); err != nil {
+		log.Printf("[WARN] can't render comments counters site %s", siteID)
+	}
+}
+
+// uniqueSorted returns sorted list of non-empty values without duplicates
+func uniqueSorted(values []string) []string {
+	seen := map[string]bool{}
+	res := make([]string, 0, len(values))
+	for _, v := range values {
+		if v == "" || seen[v] {
+			continue
+		}
+		seen[v] = true
+		res = append(res, v)
+	}
+	sort.Strings(res)
+	return res
+}
+
 // GET /list?site=siteID&limit=50&skip=10 - list posts with comments
 func (s *public) listCtrl(w http.ResponseWriter, r *http.Request) {
 
@@ -328,7 +721,7 @@ func (s *public) listCtrl(w http.ResponseWriter, r *http.Request) {
 		skip = v
 	}
 
-	key := cache.NewKey(siteID).ID(URLKey(r)).Scopes(siteID)
+	key := cache.NewKey(siteID).ID(URLKey(r)).Scopes(siteID, postsScope)
 	data, err := s.cache.Get(key, func() ([]byte, error) {
 		posts, e := s.dataService.List(siteID, limit, skip)
 		if e != nil {
@@ -347,16 +740,25 @@ func (s *public) listCtrl(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// GET /picture/{user}/{id} - get picture
+// GET /picture/{user}/{id}?thumb=1 - get picture or its thumbnail, redirects to presigned url if supported by store.
+// Picture itself returned for thumb=1 if it has no thumbnail.
 func (s *public) loadPictureCtrl(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "user") + "/" + chi.URLParam(r, "id")
-	img, err := s.imageService.Load(id)
+	load, variantID := s.imageService.Load, id
+	if r.URL.Query().Get("thumb") == "1" {
+		load, variantID = s.imageService.LoadThumbnail, image.ThumbnailID(id)
+	}
+	if u, err := s.imageService.PresignedURL(variantID); err == nil && u != "" { // direct link to object storage
+		http.Redirect(w, r, u, http.StatusFound)
+		return
+	}
+	img, err := load(id)
 	if err != nil {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get image "+id, rest.ErrAssetNotFound)
 		return
 	}
 	// enforce client-side caching
-	etag := `"` + id + `"`
+	etag := `"` + variantID + `"`
 	w.Header().Set("Etag", etag)
 	w.Header().Set("Cache-Control", "max-age=604800") // 7 days
 	if match := r.Header.Get("If-None-Match"); match != "" {
@@ -394,6 +796,62 @@ func (s *public) robotsCtrl(w http.ResponseWriter, r *http.Request) {
 	render.PlainText(w, r, "User-agent: *\nDisallow: /auth/\nDisallow: /api/\n"+strings.Join(allowed, "\n")+"\n")
 }
 
+// commentsKey makes cache key of rendered comments of the post, shared by all viewers with the same role,
+// i.e. by all guests and users without own votes, reactions or pending comments on the post.
+// Such personal viewers get their own key, as before. Query params sorted to make the same key for any order.
+func (s *public) commentsKey(r *http.Request, locator store.Locator) string {
+	role := "user"
+	user, err := rest.GetUserInfo(r)
+	if err == nil {
+		if s.isPersonalViewer(locator, user.ID) {
+			return URLKeyWithUser(r)
+		}
+		if user.Admin {
+			role = "admin"
+		}
+	}
+	return "role!!" + role + "!!" + r.URL.Path + "?" + r.URL.Query().Encode()
+}
+
+// isPersonalViewer checks if the user sees the post differently from other users with the same role.
+// List of such users cached along with comments of the post and invalidated with them.
+func (s *public) isPersonalViewer(locator store.Locator, userID string) bool {
+	key := cache.NewKey(locator.SiteID).ID("viewers!!"+locator.URL).Scopes(locator.SiteID, locator.URL)
+	data, err := s.cache.Get(key, func() ([]byte, error) {
+		viewers, e := s.dataService.PersonalViewers(locator)
+		if e != nil {
+			viewers = []string{} // unknown post has no comments, error returned by find
+		}
+		return json.Marshal(viewers)
+	})
+	viewers := []string{}
+	if err == nil {
+		err = json.Unmarshal(data, &viewers)
+	}
+	if err != nil {
+		log.Printf("[WARN] can't get personal viewers of %+v, %v", locator, err)
+		return true // safe default, own key for the user
+	}
+	for _, v := range viewers {
+		if v == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// setSchedule sets scheduled open or close time to info, not opened and closed posts are read-only
+func setSchedule(info *store.PostInfo, state schedule.State, at time.Time) {
+	switch {
+	case state == schedule.NotOpened:
+		info.ReadOnly, info.OpenAt = true, &at
+	case state == schedule.Closed:
+		info.ReadOnly, info.CloseAt = true, &at
+	case !at.IsZero():
+		info.CloseAt = &at
+	}
+}
+
 func (s *public) applyView(comments []store.Comment, view string) []store.Comment {
 	if strings.EqualFold(view, "user") {
 		projection := make([]store.Comment, 0, len(comments))
@@ -413,6 +871,26 @@ func (s *public) applyView(comments []store.Comment, view string) []store.Commen
 	return comments
 }
 
+// parsePageRequest gets limit, cursor and replies parameters of tree page, all optional
+func (s *public) parsePageRequest(r *http.Request) (service.PageRequest, error) {
+	res := service.PageRequest{Cursor: r.URL.Query().Get("cursor")}
+	for _, p := range []struct {
+		name string
+		val  *int
+	}{{"limit", &res.Limit}, {"replies", &res.Replies}} {
+		v := r.URL.Query().Get(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return res, errors.Errorf("invalid %s %q", p.name, v)
+		}
+		*p.val = n
+	}
+	return res, nil
+}
+
 func (s *public) parseSince(r *http.Request) (time.Time, error) {
 	sinceTS := time.Time{}
 	if since := r.URL.Query().Get("since"); since != "" {
@@ -1,10 +1,14 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
 	"time"
@@ -14,8 +18,13 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/umputun/remark42/backend/app/reputation"
+	"github.com/umputun/remark42/backend/app/settings"
 	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/image"
+	"github.com/umputun/remark42/backend/app/store/search"
 	"github.com/umputun/remark42/backend/app/store/service"
+	"github.com/umputun/remark42/backend/app/translate"
 )
 
 func TestRest_Ping(t *testing.T) {
@@ -198,6 +207,150 @@ func TestRest_Find(t *testing.T) {
 	assert.False(t, tree.Info.ReadOnly, "post is fresh")
 }
 
+func TestRest_FindTreePage(t *testing.T) {
+	ts, _, teardown := startupT(t)
+	defer teardown()
+
+	locator := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}
+	id1 := addComment(t, store.Comment{Text: "top #1", Locator: locator}, ts)
+	id2 := addComment(t, store.Comment{Text: "top #2", Locator: locator}, ts)
+	id3 := addComment(t, store.Comment{Text: "top #3", Locator: locator}, ts)
+	r1 := addComment(t, store.Comment{Text: "reply #1", ParentID: id1, Locator: locator}, ts)
+	r2 := addComment(t, store.Comment{Text: "reply #2", ParentID: id1, Locator: locator}, ts)
+	r3 := addComment(t, store.Comment{Text: "reply #3", ParentID: id1, Locator: locator}, ts)
+
+	res, code := get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah1&format=tree&sort=+time&limit=2&replies=1")
+	assert.Equal(t, 200, code)
+	tree := service.Tree{}
+	require.NoError(t, json.Unmarshal([]byte(res), &tree))
+	require.Equal(t, 2, len(tree.Nodes))
+	assert.Equal(t, id1, tree.Nodes[0].Comment.ID)
+	assert.Equal(t, id2, tree.Nodes[1].Comment.ID)
+	assert.Equal(t, id2, tree.Cursor)
+	assert.Equal(t, 1, tree.More)
+	require.Equal(t, 1, len(tree.Nodes[0].Replies))
+	assert.Equal(t, r1, tree.Nodes[0].Replies[0].Comment.ID)
+	assert.Equal(t, 2, tree.Nodes[0].More)
+	assert.Equal(t, 6, tree.Info.Count, "count of all comments")
+
+	res, code = get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah1&format=tree&sort=+time&limit=2&cursor="+tree.Cursor)
+	assert.Equal(t, 200, code)
+	tree = service.Tree{}
+	require.NoError(t, json.Unmarshal([]byte(res), &tree))
+	require.Equal(t, 1, len(tree.Nodes))
+	assert.Equal(t, id3, tree.Nodes[0].Comment.ID)
+	assert.Equal(t, "", tree.Cursor)
+
+	res, code = get(t, ts.URL+"/api/v1/replies/"+id1+"?site=remark42&url=https://radio-t.com/blah1&cursor="+r1)
+	assert.Equal(t, 200, code)
+	tree = service.Tree{}
+	require.NoError(t, json.Unmarshal([]byte(res), &tree))
+	require.Equal(t, 2, len(tree.Nodes), "the rest of replies")
+	assert.Equal(t, r2, tree.Nodes[0].Comment.ID)
+	assert.Equal(t, r3, tree.Nodes[1].Comment.ID)
+
+	_, code = get(t, ts.URL+"/api/v1/replies/bad?site=remark42&url=https://radio-t.com/blah1")
+	assert.Equal(t, 400, code)
+	_, code = get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah1&format=tree&cursor=bad")
+	assert.Equal(t, 400, code)
+	_, code = get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah1&format=tree&limit=-1")
+	assert.Equal(t, 400, code)
+}
+
+func TestRest_Translate(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	c := store.Comment{Text: "<p>hello</p>", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"},
+		User: store.User{ID: "u1"}}
+	id, err := srv.DataService.Create(c)
+	require.NoError(t, err)
+	loc := "site=remark42&url=https://radio-t.com/blah1"
+
+	_, code := get(t, ts.URL+"/api/v1/translate/"+id+"?"+loc+"&lang=de")
+	assert.Equal(t, http.StatusNotFound, code, "no translator")
+
+	srv.Translator = translate.NewService(&mockTranslator{}, translate.Params{})
+	defer srv.Translator.Close()
+	ts2 := httptest.NewServer(srv.routes())
+	defer ts2.Close()
+	res, code := get(t, ts2.URL+"/api/v1/translate/"+id+"?"+loc+"&lang=de")
+	assert.Equal(t, http.StatusNotFound, code, "not enabled for the site")
+	assert.Contains(t, res, "translation not enabled for the site")
+
+	srv.Settings = settings.NewService(nil, settings.Values{Translation: true})
+	ts3 := httptest.NewServer(srv.routes())
+	defer ts3.Close()
+	res, code = get(t, ts3.URL+"/api/v1/config?site=remark42")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, res, `"translation":true`)
+
+	res, code = get(t, ts3.URL+"/api/v1/translate/"+id+"?"+loc+"&lang=DE")
+	require.Equal(t, http.StatusOK, code, res)
+	tr := struct{ ID, Lang, Text string }{}
+	require.NoError(t, json.Unmarshal([]byte(res), &tr))
+	assert.Equal(t, id, tr.ID)
+	assert.Equal(t, "de", tr.Lang)
+	assert.Equal(t, "<p>[de] hello</p>", tr.Text)
+
+	_, code = get(t, ts3.URL+"/api/v1/translate/"+id+"?"+loc+"&lang=bad_lang")
+	assert.Equal(t, http.StatusBadRequest, code)
+	_, code = get(t, ts3.URL+"/api/v1/translate/unknown?"+loc+"&lang=de")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	c.Text = "fail"
+	failID, err := srv.DataService.Create(c)
+	require.NoError(t, err)
+	_, code = get(t, ts3.URL+"/api/v1/translate/"+failID+"?"+loc+"&lang=de")
+	assert.Equal(t, http.StatusBadGateway, code)
+}
+
+func TestRest_Karma(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	loc := store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}
+	_, err := srv.DataService.Create(store.Comment{Text: "c1", Locator: loc, Score: 4, User: store.User{ID: "u1"}})
+	require.NoError(t, err)
+	_, err = srv.DataService.Create(store.Comment{Text: "c2", Locator: loc, Score: 2, User: store.User{ID: "u1"}})
+	require.NoError(t, err)
+
+	_, code := get(t, ts.URL+"/api/v1/karma?site=remark42&user=u1")
+	assert.Equal(t, http.StatusNotFound, code, "reputation disabled")
+
+	srv.Reputation = reputation.NewService(srv.DataService, reputation.Weights{Votes: 1, Approved: 1}, 0)
+	ts2 := httptest.NewServer(srv.routes())
+	defer ts2.Close()
+
+	res, code := get(t, ts2.URL+"/api/v1/karma?site=remark42&user=u1")
+	require.Equal(t, http.StatusOK, code, res)
+	k := reputation.Karma{}
+	require.NoError(t, json.Unmarshal([]byte(res), &k))
+	assert.Equal(t, reputation.Karma{UserID: "u1", Karma: 8, Score: 6, Approved: 2, Ratio: 1}, k)
+
+	_, code = get(t, ts2.URL+"/api/v1/karma?site=remark42")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	res, code = get(t, ts2.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah1")
+	require.Equal(t, http.StatusOK, code, res)
+	comments := commentsWithInfo{}
+	require.NoError(t, json.Unmarshal([]byte(res), &comments))
+	require.Equal(t, 2, len(comments.Comments))
+	assert.Equal(t, 8, comments.Comments[0].User.Karma)
+	assert.Equal(t, 8, comments.Comments[1].User.Karma)
+}
+
+type mockTranslator struct{}
+
+func (m *mockTranslator) Translate(_ context.Context, html, lang string) (string, error) {
+	if strings.Contains(html, "fail") {
+		return "", errors.New("failed")
+	}
+	return strings.Replace(html, "<p>", "<p>["+lang+"] ", 1), nil
+}
+
+func (m *mockTranslator) String() string { return "mock" }
+
 func TestRest_FindAge(t *testing.T) {
 	ts, srv, teardown := startupT(t)
 	defer teardown()
@@ -271,6 +424,128 @@ func TestRest_FindReadOnly(t *testing.T) {
 	assert.False(t, tree.Info.ReadOnly, "post is writable")
 }
 
+func TestRest_FindConditional(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	lru, err := cache.NewLruCache(cache.MaxKeys(100))
+	require.NoError(t, err)
+	srv.pubRest.cache = cache.NewScache(lru)
+	srv.privRest.cache = srv.pubRest.cache
+
+	id := addComment(t, store.Comment{Text: "test test #1", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}}, ts)
+
+	send := func(format string, headers map[string]string) *http.Response {
+		req, e := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah1&format="+format, nil)
+		require.NoError(t, e)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, e := http.DefaultClient.Do(req)
+		require.NoError(t, e)
+		require.NoError(t, resp.Body.Close())
+		return resp
+	}
+
+	resp := send("tree", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	etag := resp.Header.Get("Etag")
+	assert.NotEmpty(t, etag)
+	assert.Equal(t, "no-cache", resp.Header.Get("Cache-Control"))
+	assert.Empty(t, resp.Header.Get("Last-Modified"), "not set within the second of the last activity")
+
+	time.Sleep(time.Second)
+	resp = send("tree", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, etag, resp.Header.Get("Etag"))
+	modified := resp.Header.Get("Last-Modified")
+	require.NotEmpty(t, modified)
+
+	assert.Equal(t, http.StatusNotModified, send("tree", map[string]string{"If-None-Match": etag}).StatusCode)
+	assert.Equal(t, http.StatusNotModified, send("tree", map[string]string{"If-Modified-Since": modified}).StatusCode)
+	assert.Equal(t, http.StatusOK, send("tree", map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": modified}).StatusCode,
+		"If-Modified-Since ignored with If-None-Match")
+	assert.Equal(t, http.StatusOK, send("tree", map[string]string{"If-Modified-Since": "Mon, 02 Jan 2006 15:04:05 GMT"}).StatusCode)
+	resp = send("plain", map[string]string{"If-Modified-Since": modified})
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode, "same activity of the post for other format")
+	assert.NotEqual(t, etag, resp.Header.Get("Etag"))
+
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/vote/"+id+"?site=remark42&url=https://radio-t.com/blah1&vote=1", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = send("tree", map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "changed by vote")
+	assert.NotEqual(t, etag, resp.Header.Get("Etag"))
+	assert.Equal(t, http.StatusOK, send("tree", map[string]string{"If-Modified-Since": modified}).StatusCode, "changed by vote")
+}
+
+func TestRest_FindSharedByRole(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	lru, err := cache.NewLruCache(cache.MaxKeys(100))
+	require.NoError(t, err)
+	srv.pubRest.cache = cache.NewScache(lru)
+	srv.privRest.cache = srv.pubRest.cache
+
+	id := addComment(t, store.Comment{Text: "test test #1", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}}, ts)
+	hasKey := func(prefix string) bool {
+		for _, k := range lru.Keys() {
+			if strings.HasPrefix(k, "remark42@@"+prefix) {
+				return true
+			}
+		}
+		return false
+	}
+
+	_, code := get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah1&format=tree")
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, hasKey("role!!user!!/api/v1/find?"), "guest")
+	assert.True(t, hasKey("role!!user!!/api/v1/find?format=tree&site=remark42&url="), "sorted params")
+	keys := len(lru.Keys())
+	_, code = getWithDevAuth(t, ts.URL+"/api/v1/find?format=tree&url=https://radio-t.com/blah1&site=remark42")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, keys+1, len(lru.Keys()), "user without own votes shares response, personal viewers added")
+	assert.False(t, hasKey("dev!!/api/v1/find?"))
+
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/vote/"+id+"?site=remark42&url=https://radio-t.com/blah1&vote=1", nil)
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.False(t, hasKey("role!!user!!/api/v1/find?"), "flushed by vote")
+
+	req, err = http.NewRequest(http.MethodGet, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah1&format=tree", nil)
+	require.NoError(t, err)
+	resp, err = sendReq(t, req, adminUmputunToken)
+	require.NoError(t, err)
+	tree := service.Tree{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&tree))
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, 1, len(tree.Nodes))
+	assert.Equal(t, 1, tree.Nodes[0].Comment.Vote, "own vote of the admin")
+	assert.True(t, hasKey("admin!!github_ef0f706a7!!/api/v1/find?"), "voter gets own key")
+	assert.False(t, hasKey("role!!admin!!/api/v1/find?"))
+
+	res, code := getWithDevAuth(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah1&format=tree")
+	assert.Equal(t, http.StatusOK, code)
+	tree = service.Tree{}
+	require.NoError(t, json.Unmarshal([]byte(res), &tree))
+	assert.Equal(t, 0, tree.Nodes[0].Comment.Vote)
+	assert.Equal(t, 1, tree.Nodes[0].Comment.Score)
+	assert.True(t, hasKey("role!!user!!/api/v1/find?"))
+
+	// new comment invalidates its post only
+	_, code = get(t, ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah2")
+	assert.Equal(t, http.StatusOK, code)
+	addComment(t, store.Comment{Text: "test test #2", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}}, ts)
+	assert.True(t, hasKey("role!!user!!/api/v1/find?site=remark42&url=https%3A%2F%2Fradio-t.com%2Fblah2"), "other post kept")
+	assert.False(t, hasKey("role!!user!!/api/v1/find?format=tree"))
+}
+
 func TestRest_FindUserView(t *testing.T) {
 	ts, srv, teardown := startupT(t)
 	defer teardown()
@@ -319,6 +594,48 @@ func TestRest_FindUserView(t *testing.T) {
 	assert.Equal(t, id2, comments.Comments[0].ID)
 }
 
+func TestRest_FindMarkdown(t *testing.T) {
+	ts, _, teardown := startupT(t)
+	defer teardown()
+
+	text := "**bold** and 1 < 2 <script>alert(1)</script>"
+	id := addComment(t, store.Comment{Text: text, Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}}, ts)
+
+	find := func(query, accept string) store.Comment {
+		req, err := http.NewRequest("GET", ts.URL+"/api/v1/find?site=remark42&url=https://radio-t.com/blah1"+query, nil)
+		require.NoError(t, err)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		resp, err := sendReq(t, req, "")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Contains(t, resp.Header.Values("Vary"), "Accept")
+		res := commentsWithInfo{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		require.Equal(t, 1, len(res.Comments))
+		return res.Comments[0]
+	}
+
+	c := find("", "")
+	assert.Equal(t, "", c.Raw, "not returned by default")
+	assert.NotEqual(t, text, c.Orig, "orig sanitized")
+	assert.Equal(t, text, find("&markdown=true", "").Raw)
+	assert.Equal(t, text, find("", "text/markdown, application/json").Raw, "requested with accept header")
+	assert.Equal(t, "", find("", "").Raw, "cached separately")
+
+	body, code := get(t, ts.URL+"/api/v1/id/"+id+"?site=remark42&url=https://radio-t.com/blah1&markdown=true")
+	require.Equal(t, http.StatusOK, code)
+	c = store.Comment{}
+	require.NoError(t, json.Unmarshal([]byte(body), &c))
+	assert.Equal(t, text, c.Raw)
+
+	body, code = get(t, ts.URL+"/api/v1/last/10?site=remark42")
+	require.Equal(t, http.StatusOK, code)
+	assert.NotContains(t, body, `"raw"`)
+}
+
 func TestRest_Last(t *testing.T) {
 	ts, srv, teardown := startupT(t)
 	defer teardown()
@@ -511,6 +828,121 @@ func TestRest_Counts(t *testing.T) {
 	assert.NoError(t, resp.Body.Close())
 }
 
+func TestRest_CountsBatch(t *testing.T) {
+	ts, _, teardown := startupT(t)
+	defer teardown()
+
+	c1 := store.Comment{Text: "test test #1", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}}
+	c2 := store.Comment{Text: "test test #2", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah2"}}
+	addComment(t, c1, ts)
+	addComment(t, c1, ts)
+	addComment(t, c2, ts)
+
+	send := func(query, etag string) (res []store.PostInfo, resp *http.Response) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/counts?site=remark42"+query, nil)
+		require.NoError(t, err)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		resp, err = http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		}
+		return res, resp
+	}
+
+	query := "&url=https://radio-t.com/blah2&url=https://radio-t.com/blah1&url=https://radio-t.com/blah1&url=https://radio-t.com/blah3"
+	res, resp := send(query, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []store.PostInfo{{URL: "https://radio-t.com/blah1", Count: 2}, {URL: "https://radio-t.com/blah2", Count: 1},
+		{URL: "https://radio-t.com/blah3", Count: 0}}, res, "sorted, without duplicates, zero for unknown post")
+	etag := resp.Header.Get("Etag")
+	assert.NotEmpty(t, etag)
+	assert.False(t, strings.HasPrefix(etag, "W/"), "strong etag")
+	assert.Equal(t, "max-age=30, must-revalidate", resp.Header.Get("Cache-Control"))
+
+	_, resp = send("&url=https://radio-t.com/blah3&url=https://radio-t.com/blah1&url=https://radio-t.com/blah2", etag)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode, "same posts in other order")
+
+	addComment(t, c2, ts)
+	res, resp = send(query, etag)
+	require.Equal(t, http.StatusOK, resp.StatusCode, "changed by new comment")
+	assert.Equal(t, 2, res[1].Count)
+	assert.NotEqual(t, etag, resp.Header.Get("Etag"))
+
+	_, resp = send("", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "no urls")
+	_, resp = send(strings.Repeat("&url=https://radio-t.com/blah1", 101), "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "duplicates not counted")
+	many := ""
+	for i := 0; i < 101; i++ {
+		many += fmt.Sprintf("&url=https://radio-t.com/p%d", i)
+	}
+	_, resp = send(many, "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "too many urls")
+}
+
+func TestRest_Badge(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	c1 := store.Comment{Text: "test test #1", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}}
+	addComment(t, c1, ts)
+	addComment(t, c1, ts)
+
+	body, code := getWithAdminAuth(t, ts.URL+"/api/v1/admin/badge?site=remark42&url=https://radio-t.com/blah1")
+	require.Equal(t, http.StatusOK, code, body)
+	urls := map[string]string{}
+	require.NoError(t, json.Unmarshal([]byte(body), &urls))
+	sig := badgeSignature("123456", "remark42", "https://radio-t.com/blah1")
+	assert.Equal(t, sig, urls["sig"])
+	assert.Equal(t, srv.RemarkURL+"/api/v1/badge?site=remark42&url=https%3A%2F%2Fradio-t.com%2Fblah1&sig="+sig, urls["svg"])
+	assert.Equal(t, urls["svg"]+"&format=json", urls["json"])
+
+	badge := ts.URL + strings.TrimPrefix(urls["svg"], srv.RemarkURL)
+	resp, err := http.Get(badge)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(data))
+	assert.Equal(t, "image/svg+xml; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "*", resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "public, max-age=30, must-revalidate", resp.Header.Get("Cache-Control"))
+	assert.Contains(t, string(data), "<title>comments: 2</title>")
+
+	req, err := http.NewRequest(http.MethodGet, badge, nil)
+	require.NoError(t, err)
+	req.Header.Set("If-None-Match", resp.Header.Get("Etag"))
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	body, code = get(t, badge+"&label=<replies>&color=f00")
+	require.Equal(t, http.StatusOK, code, body)
+	assert.Contains(t, body, "<title>&lt;replies&gt;: 2</title>")
+	assert.Contains(t, body, `fill="#f00"`)
+
+	addComment(t, c1, ts)
+	body, code = get(t, ts.URL+strings.TrimPrefix(urls["json"], srv.RemarkURL))
+	require.Equal(t, http.StatusOK, code, body)
+	info := store.PostInfo{}
+	require.NoError(t, json.Unmarshal([]byte(body), &info))
+	assert.Equal(t, store.PostInfo{URL: "https://radio-t.com/blah1", Count: 3}, info, "cache reset by new comment")
+
+	body, code = get(t, ts.URL+"/api/v1/badge?site=remark42&url=https://radio-t.com/blah2&sig="+sig)
+	assert.Equal(t, http.StatusForbidden, code, body)
+	_, code = get(t, badge+"&color=red")
+	assert.Equal(t, http.StatusBadRequest, code)
+	_, code = get(t, badge+"&format=png")
+	assert.Equal(t, http.StatusBadRequest, code)
+	_, code = getWithAdminAuth(t, ts.URL+"/api/v1/admin/badge?site=remark42")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
 func TestRest_List(t *testing.T) {
 	ts, _, teardown := startupT(t)
 	defer teardown()
@@ -540,6 +972,148 @@ func TestRest_List(t *testing.T) {
 	assert.Equal(t, 400, code)
 }
 
+func TestRest_History(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.DataService.MaxRevisions = 10
+
+	c1 := store.Comment{Text: "test test #1", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}}
+	id := addComment(t, c1, ts)
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/comment/"+id+"?site=remark42&url=https://radio-t.com/blah1",
+		strings.NewReader(`{"text":"updated text", "summary":"my edit"}`))
+	require.NoError(t, err)
+	resp, err := sendReq(t, req, devToken)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	url := ts.URL + "/api/v1/comment/" + id + "/history?site=remark42&url=https://radio-t.com/blah1"
+	_, code := get(t, url)
+	assert.Equal(t, http.StatusForbidden, code, "admins only")
+	_, code = getWithDevAuth(t, url)
+	assert.Equal(t, http.StatusForbidden, code, "admins only")
+
+	body, code := getWithAdminAuth(t, url)
+	require.Equal(t, http.StatusOK, code, body)
+	res := struct {
+		ID      string                 `json:"id"`
+		History []service.HistoryEntry `json:"history"`
+	}{}
+	require.NoError(t, json.Unmarshal([]byte(body), &res))
+	assert.Equal(t, id, res.ID)
+	require.Equal(t, 1, len(res.History))
+	assert.Equal(t, "test test #1", res.History[0].Orig)
+	assert.Equal(t, "dev", res.History[0].Editor)
+	assert.Equal(t, "my edit", res.History[0].Summary)
+	assert.Equal(t, "--- before\n+++ after\n@@ -1 +1 @@\n-test test #1\n+updated text\n", res.History[0].Diff)
+
+	body, code = get(t, ts.URL+"/api/v1/id/"+id+"?site=remark42&url=https://radio-t.com/blah1")
+	require.Equal(t, http.StatusOK, code)
+	assert.NotContains(t, body, "revisions")
+
+	srv.pubRest.historyPublic = true
+	_, code = get(t, url)
+	assert.Equal(t, http.StatusOK, code, "public history")
+	_, code = get(t, ts.URL+"/api/v1/comment/bad/history?site=remark42&url=https://radio-t.com/blah1")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestRest_Search(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	_, code := get(t, ts.URL+"/api/v1/search?site=remark42&query=test")
+	assert.Equal(t, http.StatusBadRequest, code, "search disabled")
+
+	var err error
+	srv.DataService.SearchService, err = search.NewService([]string{"remark42"}, search.Params{})
+	require.NoError(t, err)
+
+	c1 := store.Comment{Text: "first message", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}}
+	c2 := store.Comment{Text: "second message", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah2"}}
+	id1 := addComment(t, c1, ts)
+	id2 := addComment(t, c2, ts)
+
+	body, code := get(t, ts.URL+"/api/v1/search?site=remark42&query=message&sort=-time")
+	assert.Equal(t, http.StatusOK, code)
+	res := service.SearchResult{}
+	require.NoError(t, json.Unmarshal([]byte(body), &res))
+	assert.Equal(t, uint64(2), res.Total)
+	require.Equal(t, 2, len(res.Comments))
+	assert.Equal(t, id2, res.Comments[0].ID)
+	assert.Equal(t, "https://radio-t.com/blah2", res.Comments[0].Locator.URL)
+	assert.Equal(t, []string{"second <mark>message</mark>"}, res.Comments[0].Highlights)
+	assert.Equal(t, id1, res.Comments[1].ID)
+
+	body, code = get(t, ts.URL+"/api/v1/search?site=remark42&query=message&sort=+time&limit=1&skip=1")
+	assert.Equal(t, http.StatusOK, code)
+	res = service.SearchResult{}
+	require.NoError(t, json.Unmarshal([]byte(body), &res))
+	assert.Equal(t, uint64(2), res.Total)
+	require.Equal(t, 1, len(res.Comments))
+	assert.Equal(t, id2, res.Comments[0].ID)
+
+	_, err = srv.DataService.SetLabel(store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}, id1, "question", true)
+	require.NoError(t, err)
+	body, code = get(t, ts.URL+"/api/v1/search?site=remark42&label=Question")
+	assert.Equal(t, http.StatusOK, code)
+	res = service.SearchResult{}
+	require.NoError(t, json.Unmarshal([]byte(body), &res))
+	require.Equal(t, 1, len(res.Comments), "filtered by label without query")
+	assert.Equal(t, id1, res.Comments[0].ID)
+	assert.Equal(t, []string{"question"}, res.Comments[0].Labels)
+
+	_, code = get(t, ts.URL+"/api/v1/search?site=remark42&query=")
+	assert.Equal(t, http.StatusBadRequest, code)
+	_, code = get(t, ts.URL+"/api/v1/search?site=remark42&query=text:")
+	assert.Equal(t, http.StatusBadRequest, code)
+	_, code = get(t, ts.URL+"/api/v1/search?site=bad&query=message")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestRest_Similar(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	resp, err := post(t, ts.URL+"/api/v1/similar?site=remark42&url=https://radio-t.com/blah1", `{"text": "first question"}`)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "search disabled")
+
+	srv.DataService.SearchService, err = search.NewService([]string{"remark42"}, search.Params{})
+	require.NoError(t, err)
+
+	c1 := store.Comment{Text: "first question about go", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}}
+	c2 := store.Comment{Text: "nothing similar", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}}
+	c3 := store.Comment{Text: "question about go", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah2"}}
+	id1 := addComment(t, c1, ts)
+	addComment(t, c2, ts)
+	addComment(t, c3, ts)
+
+	resp, err = post(t, ts.URL+"/api/v1/similar?site=remark42&url=https://radio-t.com/blah1", `{"text": "A *question* about Go"}`)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	res := service.SearchResult{}
+	require.NoError(t, json.Unmarshal(body, &res))
+	require.Equal(t, 1, len(res.Comments), "only comments of the post")
+	assert.Equal(t, id1, res.Comments[0].ID)
+	assert.Contains(t, res.Comments[0].Highlights[0], "<mark>question</mark>")
+
+	for _, b := range []string{`{"text": " "}`, `bad`} {
+		resp, err = post(t, ts.URL+"/api/v1/similar?site=remark42&url=https://radio-t.com/blah1", b)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, b)
+	}
+	resp, err = post(t, ts.URL+"/api/v1/similar?site=remark42", `{"text": "question"}`)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "no url")
+}
+
 func TestRest_ListWithSkipAndLimit(t *testing.T) {
 	ts, _, teardown := startupT(t)
 	defer teardown()
@@ -597,7 +1171,7 @@ func TestRest_Info(t *testing.T) {
 	ts, srv, teardown := startupT(t)
 	defer teardown()
 
-	srv.pubRest.readOnlyAge = 10000000 // make sure we don't hit read-only
+	srv.pubRest.settings = settings.NewService(nil, settings.Values{ReadOnlyAge: 10000000}) // make sure we don't hit read-only
 
 	user := store.User{ID: "user1", Name: "user name 1"}
 	c1 := store.Comment{User: user, Text: "test test #1", Locator: store.Locator{SiteID: "remark42",
@@ -641,3 +1215,70 @@ func TestRest_Robots(t *testing.T) {
 		"Allow: /api/v1/list\nAllow: /api/v1/config\nAllow: /api/v1/user\nAllow: /api/v1/img\n"+
 		"Allow: /api/v1/avatar\nAllow: /api/v1/picture\n", body)
 }
+
+func TestRest_LoadPicturePresigned(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	imgStore := &presignedImageStore{}
+	img, err := ioutil.ReadAll(gopherPNG())
+	require.NoError(t, err)
+	imgStore.On("Load", "dev/pic2").Return(img, nil)
+	srv.pubRest.imageService = image.NewService(imgStore, image.ServiceParams{})
+
+	client := http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(ts.URL + "/api/v1/picture/dev/pic1")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.Equal(t, "https://s3.example.com/bucket/images/dev/pic1?X-Amz-Signature=123", resp.Header.Get("Location"))
+
+	resp, err = client.Get(ts.URL + "/api/v1/picture/dev/pic2")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "loaded if no presigned url")
+	assert.Equal(t, "image/png", resp.Header.Get("Content-Type"))
+}
+
+func TestRest_LoadPictureThumbnail(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	tmp, err := ioutil.TempDir("", "remark42_thumb")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmp)
+
+	imgSvc := image.NewService(&image.FileSystem{Location: tmp + "/images", Staging: tmp + "/staging"},
+		image.ServiceParams{MaxSize: 2000, ThumbWidth: 16, ThumbHeight: 16})
+	srv.pubRest.imageService = imgSvc
+	id, err := imgSvc.Save("dev", gopherPNG())
+	require.NoError(t, err)
+
+	resp, err := http.Get(ts.URL + "/api/v1/picture/" + id + "?thumb=1")
+	require.NoError(t, err)
+	thumb, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `"`+id+`-thumb"`, resp.Header.Get("Etag"))
+
+	body, code := get(t, ts.URL+"/api/v1/picture/"+id)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 1462, len(body))
+	assert.True(t, len(thumb) < len(body), "thumbnail is smaller, %d", len(thumb))
+
+	imgSvc.ThumbWidth, imgSvc.ThumbHeight = 0, 0
+	body, code = get(t, ts.URL+"/api/v1/picture/"+id+"?thumb=1")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 1462, len(body), "picture itself if thumbnails disabled")
+}
+
+type presignedImageStore struct {
+	image.MockStore
+}
+
+func (s *presignedImageStore) PresignedURL(id string) (string, error) {
+	if id == "dev/pic1" {
+		return "https://s3.example.com/bucket/images/dev/pic1?X-Amz-Signature=123", nil
+	}
+	return "", nil
+}
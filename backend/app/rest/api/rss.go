@@ -3,8 +3,10 @@ package api
 import (
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/go-chi/chi/v5"
 	cache "github.com/go-pkgz/lcw"
 	log "github.com/go-pkgz/lgr"
 	"github.com/gorilla/feeds"
@@ -17,25 +19,25 @@ import (
 type rss struct {
 	dataService rssStore
 	cache       LoadingCache
+	maxItems    int         // max number of items in feed, maxRssItems if 0
+	links       store.Links // canonical links of virtual locators
 }
 
 type rssStore interface {
 	Find(locator store.Locator, sort string, user store.User) ([]store.Comment, error)
 	Last(siteID string, limit int, since time.Time, user store.User) ([]store.Comment, error)
 	Get(locator store.Locator, commentID string, user store.User) (store.Comment, error)
+	User(siteID, userID string, limit, skip int, user store.User) ([]store.Comment, error)
 	UserReplies(siteID, userID string, limit int, duration time.Duration) ([]store.Comment, string, error)
 }
 
 const maxRssItems = 20
 const maxReplyDuration = 31 * 24 * time.Hour
 
-// ui uses links like <post-url>#remark42__comment-<comment-id>
-const uiNav = "#remark42__comment-"
-
-// GET /rss/post?site=siteID&url=post-url
+// GET /{rss|atom}/post?site=siteID&url=post-url&limit=N
 func (s *rss) postCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
-	log.Printf("[DEBUG] get rss for post %+v", locator)
+	log.Printf("[DEBUG] get %s for post %+v", feedFormat(r), locator)
 
 	key := cache.NewKey(locator.SiteID).ID(URLKey(r)).Scopes(locator.SiteID, locator.URL)
 	data, err := s.cache.Get(key, func() ([]byte, error) {
@@ -43,7 +45,7 @@ func (s *rss) postCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 		if e != nil {
 			return nil, e
 		}
-		feed, e := s.toRssFeed(locator.URL, comments, "post comments for "+r.URL.Query().Get("url"))
+		feed, e := s.toFeed(r, s.links.Post(locator), comments, "post comments for "+r.URL.Query().Get("url"))
 		if e != nil {
 			return nil, e
 		}
@@ -54,28 +56,22 @@ func (s *rss) postCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't find comments", rest.ErrPostNotFound)
 		return
 	}
-
-	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
-	w.WriteHeader(http.StatusOK)
-
-	if _, err = w.Write(data); err != nil {
-		log.Printf("[WARN] failed to send response to %s, %s", r.RemoteAddr, err)
-	}
+	s.send(w, r, data)
 }
 
-// GET /rss/site?site=siteID
+// GET /{rss|atom}/site?site=siteID&limit=N
 func (s *rss) siteCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 	siteID := r.URL.Query().Get("site")
-	log.Printf("[DEBUG] get rss for site %s", siteID)
+	log.Printf("[DEBUG] get %s for site %s", feedFormat(r), siteID)
 
 	key := cache.NewKey(siteID).ID(URLKey(r)).Scopes(siteID, lastCommentsScope)
 	data, err := s.cache.Get(key, func() ([]byte, error) {
-		comments, e := s.dataService.Last(siteID, maxRssItems, time.Time{}, rest.GetUserOrEmpty(r))
+		comments, e := s.dataService.Last(siteID, s.limit(r), time.Time{}, rest.GetUserOrEmpty(r))
 		if e != nil {
 			return nil, e
 		}
 
-		feed, e := s.toRssFeed(r.URL.Query().Get("site"), comments, "site comment for "+siteID)
+		feed, e := s.toFeed(r, r.URL.Query().Get("site"), comments, "site comment for "+siteID)
 		if e != nil {
 			return nil, e
 		}
@@ -86,29 +82,24 @@ func (s *rss) siteCommentsCtrl(w http.ResponseWriter, r *http.Request) {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get last comments", rest.ErrSiteNotFound)
 		return
 	}
-
-	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
-	w.WriteHeader(http.StatusOK)
-	if _, err = w.Write(data); err != nil {
-		log.Printf("[WARN] failed to send response to %s, %s", r.RemoteAddr, err)
-	}
+	s.send(w, r, data)
 }
 
-// GET /rss/reply?user=userID&site=siteID
+// GET /{rss|atom}/reply?user=userID&site=siteID&limit=N
 func (s *rss) repliesCtrl(w http.ResponseWriter, r *http.Request) {
 	userID := r.URL.Query().Get("user")
 	siteID := r.URL.Query().Get("site")
-	log.Printf("[DEBUG] get rss replies to user %s for site %s", userID, siteID)
+	log.Printf("[DEBUG] get %s replies to user %s for site %s", feedFormat(r), userID, siteID)
 
 	key := cache.NewKey(siteID).ID(URLKey(r)).Scopes(siteID, lastCommentsScope)
 	data, err := s.cache.Get(key, func() (res []byte, e error) {
 
-		replies, userName, e := s.dataService.UserReplies(siteID, userID, maxRssItems, maxReplyDuration)
+		replies, userName, e := s.dataService.UserReplies(siteID, userID, s.limit(r), maxReplyDuration)
 		if e != nil {
 			return nil, errors.Wrap(e, "can't get last comments")
 		}
 
-		feed, e := s.toRssFeed(siteID, replies, "replies to "+userName)
+		feed, e := s.toFeed(r, siteID, replies, "replies to "+userName)
 		if e != nil {
 			return nil, e
 		}
@@ -119,15 +110,76 @@ func (s *rss) repliesCtrl(w http.ResponseWriter, r *http.Request) {
 		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get replies", rest.ErrSiteNotFound)
 		return
 	}
+	s.send(w, r, data)
+}
 
-	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+// GET /{rss|atom}/user?user=userID&site=siteID&limit=N
+func (s *rss) userCommentsCtrl(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("user")
+	siteID := r.URL.Query().Get("site")
+	log.Printf("[DEBUG] get %s for comments of user %s for site %s", feedFormat(r), userID, siteID)
+
+	key := cache.NewKey(siteID).ID(URLKey(r)).Scopes(userID, siteID, lastCommentsScope)
+	data, err := s.cache.Get(key, func() ([]byte, error) {
+		comments, e := s.dataService.User(siteID, userID, s.limit(r), 0, rest.GetUserOrEmpty(r))
+		if e != nil {
+			return nil, errors.Wrap(e, "can't get user comments")
+		}
+		comments = filterComments(comments, func(c store.Comment) bool { return !c.Deleted })
+		userName := userID
+		if len(comments) > 0 {
+			userName = comments[0].User.Name
+		}
+
+		feed, e := s.toFeed(r, siteID, comments, "comments of "+userName)
+		if e != nil {
+			return nil, e
+		}
+		return []byte(feed), e
+	})
+
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't get user comments", rest.ErrSiteNotFound)
+		return
+	}
+	s.send(w, r, data)
+}
+
+// send feed with content type of requested format
+func (s *rss) send(w http.ResponseWriter, r *http.Request, data []byte) {
+	contentType := "application/xml; charset=utf-8"
+	if feedFormat(r) == "atom" {
+		contentType = "application/atom+xml; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
 	w.WriteHeader(http.StatusOK)
-	if _, err = w.Write(data); err != nil {
+	if _, err := w.Write(data); err != nil {
 		log.Printf("[WARN] failed to send response to %s, %s", r.RemoteAddr, err)
 	}
 }
 
-func (s *rss) toRssFeed(url string, comments []store.Comment, description string) (string, error) {
+// limit returns number of feed items requested with limit query param, capped by max items
+func (s *rss) limit(r *http.Request) int {
+	maxItems := s.maxItems
+	if maxItems <= 0 {
+		maxItems = maxRssItems
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > maxItems {
+		return maxItems
+	}
+	return limit
+}
+
+// feedFormat returns requested feed format, rss or atom
+func feedFormat(r *http.Request) string {
+	if chi.URLParam(r, "format") == "atom" {
+		return "atom"
+	}
+	return "rss"
+}
+
+func (s *rss) toFeed(r *http.Request, url string, comments []store.Comment, description string) (string, error) {
 
 	if description == "" {
 		description = "comment updates"
@@ -144,11 +196,15 @@ func (s *rss) toRssFeed(url string, comments []store.Comment, description string
 		Created:     lastCommentTS,
 	}
 
+	limit := s.limit(r)
 	feed.Items = []*feeds.Item{}
-	for i, c := range comments {
+	for _, c := range comments {
+		if len(feed.Items) >= limit {
+			break
+		}
 		f := feeds.Item{
 			Title:       c.User.Name,
-			Link:        &feeds.Link{Href: c.Locator.URL + uiNav + c.ID},
+			Link:        &feeds.Link{Href: s.links.Comment(c.Locator, c.ID)},
 			Description: c.Text,
 			Created:     c.Timestamp,
 			Author:      &feeds.Author{Name: c.User.Name},
@@ -169,9 +225,9 @@ func (s *rss) toRssFeed(url string, comments []store.Comment, description string
 		}
 
 		feed.Items = append(feed.Items, &f)
-		if i > maxRssItems {
-			break
-		}
+	}
+	if feedFormat(r) == "atom" {
+		return feed.ToAtom()
 	}
 	return feed.ToRss()
 }
@@ -2,6 +2,8 @@ package api
 
 import (
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"regexp"
 	"strings"
 	"testing"
@@ -269,6 +271,88 @@ func TestServer_RssReplies(t *testing.T) {
 	assert.Equal(t, 400, code)
 }
 
+func TestServer_RssUser(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	for i := 1; i <= 3; i++ {
+		c := store.Comment{
+			ID:      fmt.Sprintf("comment-%d", i),
+			Text:    fmt.Sprintf("comment %d", i),
+			Locator: store.Locator{URL: "https://radio-t.com/blah1", SiteID: "remark42"},
+			User:    store.User{ID: "user1", Name: "user one"},
+		}
+		_, err := srv.DataService.Create(c)
+		require.NoError(t, err)
+	}
+	_, err := srv.DataService.Create(store.Comment{ID: "comment-4", Text: "other user",
+		Locator: store.Locator{URL: "https://radio-t.com/blah1", SiteID: "remark42"}, User: store.User{ID: "user2", Name: "user2"}})
+	require.NoError(t, err)
+
+	res, code := get(t, ts.URL+"/api/v1/rss/user?user=user1&site=remark42")
+	assert.Equal(t, 200, code)
+	assert.Contains(t, res, "<description>comments of user one</description>")
+	assert.Equal(t, 3, strings.Count(res, "<item>"))
+	assert.NotContains(t, res, "other user")
+
+	res, code = get(t, ts.URL+"/api/v1/rss/user?user=user1&site=remark42&limit=2")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, 2, strings.Count(res, "<item>"))
+	assert.Contains(t, res, "<guid>comment-3</guid>", "the latest comments first")
+
+	_, code = get(t, ts.URL+"/api/v1/rss/user?user=user1&site=remark42-bad")
+	assert.Equal(t, 400, code)
+}
+
+func TestServer_Atom(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	c1 := store.Comment{
+		ID:      "comment-1",
+		Text:    "test 123",
+		Locator: store.Locator{URL: "https://radio-t.com/blah1", SiteID: "remark42"},
+		User:    store.User{ID: "u1", Name: "developer one"},
+	}
+	_, err := srv.DataService.Create(c1)
+	require.NoError(t, err)
+
+	for _, feed := range []string{"post?url=https://radio-t.com/blah1&", "site?", "user?user=u1&"} {
+		resp, err := http.Get(ts.URL + "/api/v1/atom/" + feed + "site=remark42")
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusOK, resp.StatusCode, feed)
+		assert.Equal(t, "application/atom+xml; charset=utf-8", resp.Header.Get("Content-Type"))
+		assert.Contains(t, string(body), `<feed xmlns="http://www.w3.org/2005/Atom">`, feed)
+		assert.Contains(t, string(body), "https://radio-t.com/blah1#remark42__comment-comment-1", feed)
+	}
+
+	_, code := get(t, ts.URL+"/api/v1/xml/site?site=remark42")
+	assert.Equal(t, 404, code, "unknown feed format")
+}
+
+func TestServer_RssMaxItems(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+	srv.rssRest.maxItems = 2
+
+	for i := 1; i <= 4; i++ {
+		_, err := srv.DataService.Create(store.Comment{ID: fmt.Sprintf("comment-%d", i), Text: "text",
+			Locator: store.Locator{URL: "https://radio-t.com/blah1", SiteID: "remark42"}, User: store.User{ID: "u1", Name: "u1"}})
+		require.NoError(t, err)
+	}
+	for _, limit := range []string{"", "&limit=10", "&limit=bad"} {
+		res, code := get(t, ts.URL+"/api/v1/rss/post?site=remark42&url=https://radio-t.com/blah1"+limit)
+		assert.Equal(t, 200, code)
+		assert.Equal(t, 2, strings.Count(res, "<item>"), "limited by max items, %q", limit)
+	}
+	res, code := get(t, ts.URL+"/api/v1/rss/site?site=remark42&limit=1")
+	assert.Equal(t, 200, code)
+	assert.Equal(t, 1, strings.Count(res, "<item>"))
+}
+
 func waitOnSecChange() {
 	for {
 		if time.Now().Nanosecond() < 100000000 {
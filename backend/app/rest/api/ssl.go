@@ -9,6 +9,8 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	log "github.com/go-pkgz/lgr"
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/crypto/acme"
 	"golang.org/x/crypto/acme/autocert"
 
 	R "github.com/go-pkgz/rest"
@@ -36,6 +38,10 @@ type SSLConfig struct {
 	Port         int
 	ACMELocation string
 	ACMEEmail    string
+
+	ACMEDirectory string                                               // directory url of acme server, Let's Encrypt if empty
+	Certificates  func(*tls.ClientHelloInfo) (*tls.Certificate, error) // optional, certificates of auto mode obtained with dns-01 challenge
+	HTTP3         bool                                                 // serve http/3 (quic) on udp port of https server
 }
 
 // httpToHTTPSRouter creates new router which does redirect from http to https server
@@ -80,6 +86,7 @@ func (s *Rest) makeAutocertManager() *autocert.Manager {
 		Cache:      autocert.DirCache(s.SSLConfig.ACMELocation),
 		HostPolicy: autocert.HostWhitelist(s.getRemarkHost()),
 		Email:      s.SSLConfig.ACMEEmail,
+		Client:     &acme.Client{DirectoryURL: s.SSLConfig.ACMEDirectory},
 	}
 }
 
@@ -99,6 +106,18 @@ func (s *Rest) makeHTTPSServer(address string, port int, router http.Handler) *h
 	return server
 }
 
+// makeHTTP3Server makes http/3 server on udp port of the https server with the same handler and tls config.
+// Handler of the https server altered to advertise http/3 with Alt-Svc header, once http/3 server listens.
+func (s *Rest) makeHTTP3Server(httpsServer *http.Server) *http3.Server {
+	server := &http3.Server{Addr: httpsServer.Addr, Handler: httpsServer.Handler, TLSConfig: httpsServer.TLSConfig}
+	next := httpsServer.Handler
+	httpsServer.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = server.SetQuicHeaders(w.Header()) // fails till http/3 server listens
+		next.ServeHTTP(w, r)
+	})
+	return server
+}
+
 // getRemarkHost returns hostname for remark server.
 // For example for remarkURL https://remark.com:443 it should return remark.com
 func (s *Rest) getRemarkHost() string {
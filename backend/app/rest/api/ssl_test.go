@@ -49,6 +49,7 @@ func TestSSL_ACME_HTTPChallengeRouter(t *testing.T) {
 
 	m := rest.makeAutocertManager()
 	defer os.RemoveAll(rest.SSLConfig.ACMELocation)
+	assert.Equal(t, "", m.Client.DirectoryURL, "let's encrypt by default")
 
 	ts := httptest.NewServer(rest.httpChallengeRouter(m))
 	defer ts.Close()
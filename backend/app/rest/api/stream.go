@@ -0,0 +1,89 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/rest"
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/events"
+)
+
+const streamHeartbeat = 25 * time.Second // comment line sent to keep idle connections open through proxies
+
+// GET /stream?site=siteID&url=post-url - server-sent events with live updates of the post. Events "create", "update"
+// and "delete" have the comment in data, only id and locator for deleted one. Comments hidden from the user,
+// i.e. pending or delayed by slow mode, skipped, and sent as deleted if they were hidden after update.
+func (s *public) streamCtrl(w http.ResponseWriter, r *http.Request) {
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	if s.events == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("disabled"), "live updates stream disabled", rest.ErrActionRejected)
+		return
+	}
+	if locator.SiteID == "" || locator.URL == "" {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, errors.New("missing parameter"), "site and url parameters are required",
+			rest.ErrPostNotFound)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		rest.SendErrorJSON(w, r, http.StatusInternalServerError, errors.New("no flusher"), "streaming not supported", rest.ErrInternal)
+		return
+	}
+
+	evCh, unsubscribe, err := s.events.Subscribe(locator)
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusServiceUnavailable, err, "can't subscribe to updates", rest.ErrActionRejected)
+		return
+	}
+	defer unsubscribe()
+	log.Printf("[DEBUG] stream updates of %+v, %d subscribers", locator, s.events.Subscribers())
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // disable buffering by nginx
+	w.WriteHeader(http.StatusOK)
+	if _, err = fmt.Fprintf(w, "retry: %d\n\n", (5 * time.Second).Milliseconds()); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	user := rest.GetUserOrEmpty(r)
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err = fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case ev, ok := <-evCh:
+			if !ok {
+				return // bus closed
+			}
+			if ev.Kind != events.Deleted && !s.dataService.Visible(ev.Comment, user) {
+				if ev.Kind == events.Created {
+					continue
+				}
+				ev = events.Event{Kind: events.Deleted,
+					Comment: store.Comment{ID: ev.Comment.ID, Locator: ev.Comment.Locator, Deleted: true}}
+			}
+			data, e := encodeJSONWithHTML(ev.Comment)
+			if e != nil {
+				log.Printf("[WARN] can't encode %s event of comment %s, %v", ev.Kind, ev.Comment.ID, e)
+				continue
+			}
+			if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Kind, bytes.TrimSpace(data)); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/events"
+)
+
+func TestRest_Stream(t *testing.T) {
+	ts, srv, teardown := startupT(t)
+	defer teardown()
+
+	body, code := get(t, ts.URL+"/api/v1/stream?site=remark42&url=https://radio-t.com/blah1")
+	assert.Equal(t, http.StatusNotFound, code, "disabled by default, %s", body)
+
+	bus := events.NewBus(1)
+	defer bus.Close()
+	srv.DataService.Events = bus
+	srv.pubRest.events = bus
+
+	body, code = get(t, ts.URL+"/api/v1/stream?site=remark42")
+	assert.Equal(t, http.StatusBadRequest, code, body)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/api/v1/stream?site=remark42&url=https://radio-t.com/blah1", nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	body, code = get(t, ts.URL+"/api/v1/stream?site=remark42&url=https://radio-t.com/blah1")
+	assert.Equal(t, http.StatusServiceUnavailable, code, "max subscribers reached, %s", body)
+
+	// readEvent returns next event name and data, skipping empty and comment lines
+	rd := bufio.NewReader(resp.Body)
+	readEvent := func() (name, data string) {
+		for data == "" {
+			line, e := rd.ReadString('\n')
+			require.NoError(t, e)
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+		return name, data
+	}
+
+	c := store.Comment{Text: "test 123", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah1"}}
+	id := addComment(t, c, ts)
+	addComment(t, store.Comment{Text: "other post", Locator: store.Locator{SiteID: "remark42", URL: "https://radio-t.com/blah2"}}, ts)
+	name, data := readEvent()
+	assert.Equal(t, "create", name)
+	comment := store.Comment{}
+	require.NoError(t, json.Unmarshal([]byte(data), &comment))
+	assert.Equal(t, id, comment.ID)
+	assert.Equal(t, "<p>test 123</p>\n", comment.Text)
+	assert.Equal(t, "", comment.User.IP)
+
+	// held comment sent as deleted for anonymous reader
+	require.NoError(t, srv.DataService.SetPending(c.Locator, id, true))
+	name, data = readEvent()
+	assert.Equal(t, "delete", name)
+	comment = store.Comment{}
+	require.NoError(t, json.Unmarshal([]byte(data), &comment))
+	assert.Equal(t, id, comment.ID)
+	assert.True(t, comment.Deleted)
+	assert.Equal(t, "", comment.Text)
+
+	// new pending comment not sent
+	_, err = srv.DataService.Create(store.Comment{Text: "pending", Locator: c.Locator, Pending: true, User: store.User{ID: "user2"}})
+	require.NoError(t, err)
+	require.NoError(t, srv.DataService.SetPending(c.Locator, id, false))
+	name, data = readEvent()
+	assert.Equal(t, "update", name, "approved comment updated")
+	assert.Contains(t, data, id)
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/go-chi/render"
+	log "github.com/go-pkgz/lgr"
+	R "github.com/go-pkgz/rest"
+
+	"github.com/umputun/remark42/backend/app/notify"
+	"github.com/umputun/remark42/backend/app/rest"
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// TelegramBot defines interface of telegram bot receiving moderation buttons pressed in admin notifications
+type TelegramBot interface {
+	CheckSecret(r *http.Request) bool
+	ParseCallback(body []byte) (notify.TelegramAction, error)
+	Answer(ctx context.Context, action notify.TelegramAction, text string) error
+	IsModerator(userID string) bool
+}
+
+// POST /telegram/webhook - webhook of telegram bot with buttons pressed in admin notifications, approve, delete
+// or block. Telegram user allowed to moderate if set as personal telegram chat in notification preferences of admin
+// or listed in moderators. Result shown to the user by telegram, the webhook responds with 200 unless not authorized.
+func (a *admin) telegramWebhookCtrl(w http.ResponseWriter, r *http.Request) {
+	if a.telegram == nil {
+		rest.SendErrorJSON(w, r, http.StatusNotFound, errors.New("telegram moderation disabled"), "not found", rest.ErrActionRejected)
+		return
+	}
+	if !a.telegram.CheckSecret(r) {
+		rest.SendErrorJSON(w, r, http.StatusUnauthorized, errors.New("unauthorized"), "invalid telegram secret", rest.ErrNoAccess)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, hardBodyLimit))
+	if err != nil {
+		rest.SendErrorJSON(w, r, http.StatusBadRequest, err, "can't read telegram update", rest.ErrDecode)
+		return
+	}
+
+	action, err := a.telegram.ParseCallback(body)
+	switch {
+	case errors.Is(err, notify.ErrTelegramNoCallback):
+		render.JSON(w, r, R.JSON{"status": "ignored"})
+		return
+	case errors.Is(err, notify.ErrTelegramExpired):
+		a.answerTelegram(w, r, action, "buttons expired, use admin ui")
+		return
+	case err != nil:
+		log.Printf("[WARN] bad telegram callback, %v", err)
+		render.JSON(w, r, R.JSON{"status": "ignored"})
+		return
+	}
+
+	moderator, ok := a.telegramModerator(action)
+	if !ok {
+		log.Printf("[WARN] telegram user %s (%s) not allowed to moderate %s", action.UserID, action.UserName,
+			action.Locator.SiteID)
+		a.answerTelegram(w, r, action, "not allowed")
+		return
+	}
+	log.Printf("[INFO] telegram %s of comment %s by %s", action.Action, action.CommentID, moderator.Name)
+	result := a.quickModerate(rest.SetUserInfo(r, moderator), action.Action, action.Locator, action.CommentID, action.AuthorID)
+	a.answerTelegram(w, r, action, result)
+}
+
+// telegramModerator returns user acting on behalf of telegram user, false if the telegram user is not an admin
+// with personal chat or one of moderators
+func (a *admin) telegramModerator(action notify.TelegramAction) (store.User, bool) {
+	user := store.User{ID: "telegram_" + action.UserID, Name: action.UserName, Admin: true}
+	if user.Name == "" {
+		user.Name = user.ID
+	}
+	if a.telegram.IsModerator(action.UserID) {
+		return user, true
+	}
+	if a.notifyService == nil {
+		return user, false
+	}
+	prefs, err := a.notifyService.AdminPrefs(action.Locator.SiteID)
+	if err != nil {
+		log.Printf("[WARN] can't get notification preferences of admins, %v", err)
+		return user, false
+	}
+	for email, p := range prefs {
+		if p.TelegramChat != "" && p.TelegramChat == action.UserID {
+			user.Name = email
+			return user, true
+		}
+	}
+	return user, false
+}
+
+// answerTelegram shows text to telegram user pressed the button
+func (a *admin) answerTelegram(w http.ResponseWriter, r *http.Request, action notify.TelegramAction, text string) {
+	if err := a.telegram.Answer(r.Context(), action, text); err != nil {
+		log.Printf("[WARN] can't answer telegram callback, %v", err)
+	}
+	render.JSON(w, r, R.JSON{"action": action.Action, "result": text})
+}
@@ -0,0 +1,190 @@
+// Package apitokens keeps long-lived tokens of services calling rest api, like CI jobs, static site generators and
+// moderation bots. Each token belongs to a site and has a scope, read-only, moderation or full access.
+// Only hash of the token stored, the token itself returned once, on creation.
+package apitokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/roles"
+)
+
+// Header of requests with api token
+const Header = "X-API-Token"
+
+// UserPrefix of ids of users made from tokens, i.e. token_1a2b3c4d5e6f7a8b
+const UserPrefix = "token_"
+
+// ErrInvalid returned for unknown, malformed and revoked tokens
+var ErrInvalid = errors.New("invalid api token")
+
+const idAttempts = 5 // attempts to make unused id of new token
+
+// Scope of access granted by token
+type Scope string
+
+// enum of all scopes
+const (
+	Read     Scope = "read"     // read-only access, including admin data
+	Moderate Scope = "moderate" // read access and moderation actions of admin api
+	Full     Scope = "full"     // everything, like comments posted and settings changed
+)
+
+// Valid checks if the scope is one of known scopes
+func (s Scope) Valid() bool {
+	return s == Read || s == Moderate || s == Full
+}
+
+// Role of the token user on admin api
+func (s Scope) Role() roles.Role {
+	switch s {
+	case Read:
+		return roles.Viewer
+	case Moderate:
+		return roles.Moderator
+	case Full:
+		return roles.Owner
+	}
+	return roles.None
+}
+
+// Token of a service, identified by id, the secret part kept as hash only
+type Token struct {
+	ID        string    `json:"id"`
+	SiteID    string    `json:"site"`
+	Name      string    `json:"name"`
+	Scope     Scope     `json:"scope"`
+	CreatedBy string    `json:"created_by"`
+	Created   time.Time `json:"created"`
+	LastUsed  time.Time `json:"last_used,omitempty"`
+	Hash      string    `json:"hash,omitempty"` // sha256 of secret, cleared in tokens returned by Service
+}
+
+// Store defines interface to keep tokens
+type Store interface {
+	Get(id string) (*Token, error)       // returns nil for unknown token
+	List(siteID string) ([]Token, error) // tokens of the site
+	Set(t Token) error
+	Add(t Token) (added bool, err error) // sets token if its id not used yet, added false otherwise
+	Delete(siteID, id string) error      // missing token ignored
+}
+
+// Service creates, checks and revokes tokens
+type Service struct {
+	store Store
+	now   func() time.Time
+}
+
+// NewService makes service of api tokens
+func NewService(st Store) *Service {
+	return &Service{store: st, now: time.Now}
+}
+
+// Create token of the site, the token value returned once and can't be recovered later
+func (s *Service) Create(siteID, name string, scope Scope, createdBy string) (t Token, value string, err error) {
+	if siteID == "" || strings.TrimSpace(name) == "" {
+		return Token{}, "", errors.New("site and name are required")
+	}
+	if !scope.Valid() {
+		return Token{}, "", errors.Errorf("invalid scope %q", scope)
+	}
+	secret, err := randomHex(20)
+	if err != nil {
+		return Token{}, "", err
+	}
+	t = Token{SiteID: siteID, Name: strings.TrimSpace(name), Scope: scope, CreatedBy: createdBy,
+		Created: s.now(), Hash: hash(secret)}
+	for i := 0; i < idAttempts && t.ID == ""; i++ {
+		if t.ID, err = randomHex(8); err != nil {
+			return Token{}, "", err
+		}
+		added, e := s.store.Add(t)
+		if e != nil {
+			return Token{}, "", errors.Wrapf(e, "can't save api token of %s", siteID)
+		}
+		if !added {
+			t.ID = "" // id used by another token, never replaced
+		}
+	}
+	if t.ID == "" {
+		return Token{}, "", errors.Errorf("can't make unique id of api token of %s", siteID)
+	}
+	log.Printf("[INFO] api token %s %q with %s scope created on %s by %s", t.ID, t.Name, t.Scope, siteID, createdBy)
+	t.Hash = ""
+	return t, t.ID + "." + secret, nil
+}
+
+// List tokens of the site, the most recent first
+func (s *Service) List(siteID string) ([]Token, error) {
+	list, err := s.store.List(siteID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't get api tokens of %s", siteID)
+	}
+	for i := range list {
+		list[i].Hash = ""
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Created.After(list[j].Created) })
+	return list, nil
+}
+
+// Revoke token of the site, later requests with it rejected
+func (s *Service) Revoke(siteID, id string) error {
+	t, err := s.store.Get(id)
+	if err != nil {
+		return errors.Wrapf(err, "can't get api token %s", id)
+	}
+	if t == nil || t.SiteID != siteID {
+		return errors.Errorf("api token %s not found", id)
+	}
+	if err = s.store.Delete(siteID, id); err != nil {
+		return errors.Wrapf(err, "can't revoke api token %s", id)
+	}
+	log.Printf("[INFO] api token %s %q revoked on %s", t.ID, t.Name, siteID)
+	return nil
+}
+
+// Check token value and return the token, ErrInvalid for unknown or malformed one.
+// Time of the last use updated once a minute at most, errors of update logged.
+func (s *Service) Check(value string) (Token, error) {
+	elems := strings.SplitN(value, ".", 2)
+	if len(elems) != 2 || elems[0] == "" || elems[1] == "" {
+		return Token{}, ErrInvalid
+	}
+	t, err := s.store.Get(elems[0])
+	if err != nil {
+		return Token{}, errors.Wrap(err, "can't get api token")
+	}
+	if t == nil || subtle.ConstantTimeCompare([]byte(t.Hash), []byte(hash(elems[1]))) != 1 {
+		return Token{}, ErrInvalid
+	}
+	if now := s.now(); now.Sub(t.LastUsed) > time.Minute {
+		t.LastUsed = now
+		if e := s.store.Set(*t); e != nil {
+			log.Printf("[WARN] can't update last use of api token %s, %v", t.ID, e)
+		}
+	}
+	t.Hash = ""
+	return *t, nil
+}
+
+func randomHex(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "can't make api token")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hash(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,122 @@
+package apitokens
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/roles"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+func TestService_CreateAndCheck(t *testing.T) {
+	svc := NewService(prepStore(t))
+	ts := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	svc.now = func() time.Time { return ts }
+
+	_, _, err := svc.Create("site1", " ", Read, "admin")
+	assert.EqualError(t, err, "site and name are required")
+	_, _, err = svc.Create("site1", "ci", Scope("bad"), "admin")
+	assert.EqualError(t, err, `invalid scope "bad"`)
+
+	tkn, value, err := svc.Create("site1", "ci", Moderate, "admin")
+	require.NoError(t, err)
+	assert.Equal(t, 16, len(tkn.ID))
+	assert.Equal(t, "", tkn.Hash)
+	assert.True(t, strings.HasPrefix(value, tkn.ID+"."))
+	assert.Equal(t, Token{ID: tkn.ID, SiteID: "site1", Name: "ci", Scope: Moderate, CreatedBy: "admin", Created: ts}, tkn)
+
+	ts = ts.Add(time.Hour)
+	checked, err := svc.Check(value)
+	require.NoError(t, err)
+	assert.Equal(t, tkn.ID, checked.ID)
+	assert.Equal(t, ts, checked.LastUsed)
+	assert.Equal(t, "", checked.Hash)
+
+	for _, v := range []string{"", tkn.ID, tkn.ID + ".", tkn.ID + ".bad", "bad." + strings.Split(value, ".")[1]} {
+		_, err = svc.Check(v)
+		assert.Equal(t, ErrInvalid, err, v)
+	}
+
+	ts = ts.Add(time.Minute)
+	_, _, err = svc.Create("site1", "bot", Read, "admin")
+	require.NoError(t, err)
+	_, _, err = svc.Create("site2", "ssg", Full, "admin")
+	require.NoError(t, err)
+	list, err := svc.List("site1")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(list))
+	assert.Equal(t, "bot", list[0].Name, "the most recent first")
+	assert.Equal(t, "ci", list[1].Name)
+	assert.Equal(t, "", list[1].Hash)
+
+	assert.EqualError(t, svc.Revoke("site2", tkn.ID), "api token "+tkn.ID+" not found", "token of other site")
+	require.NoError(t, svc.Revoke("site1", tkn.ID))
+	_, err = svc.Check(value)
+	assert.Equal(t, ErrInvalid, err, "revoked")
+	assert.EqualError(t, svc.Revoke("site1", tkn.ID), "api token "+tkn.ID+" not found")
+}
+
+func TestService_CreateUniqueID(t *testing.T) {
+	st := &collidingStore{EngineStore: prepStore(t), collisions: 2}
+	svc := NewService(st)
+	tkn, _, err := svc.Create("site1", "ci", Read, "admin")
+	require.NoError(t, err)
+	assert.Equal(t, 3, st.attempts, "ids of two attempts used already")
+	list, err := svc.List("site1")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(list))
+	assert.Equal(t, tkn.ID, list[0].ID)
+
+	st.collisions, st.attempts = idAttempts, 0
+	_, _, err = svc.Create("site1", "bot", Read, "admin")
+	assert.EqualError(t, err, "can't make unique id of api token of site1")
+	assert.Equal(t, idAttempts, st.attempts)
+}
+
+func TestEngineStore_Add(t *testing.T) {
+	st := prepStore(t)
+	added, err := st.Add(Token{ID: "id1", SiteID: "site1", Name: "ci"})
+	require.NoError(t, err)
+	assert.True(t, added)
+	added, err = st.Add(Token{ID: "id1", SiteID: "site1", Name: "bot"})
+	require.NoError(t, err)
+	assert.False(t, added, "id used on the site")
+	added, err = st.Add(Token{ID: "id1", SiteID: "site2", Name: "bot"})
+	require.NoError(t, err)
+	assert.False(t, added, "id used on another site")
+	tkn, err := st.Get("id1")
+	require.NoError(t, err)
+	require.NotNil(t, tkn)
+	assert.Equal(t, "ci", tkn.Name, "not replaced")
+}
+
+func TestScope_Role(t *testing.T) {
+	assert.Equal(t, roles.Viewer, Read.Role())
+	assert.Equal(t, roles.Moderator, Moderate.Role())
+	assert.Equal(t, roles.Owner, Full.Role())
+	assert.Equal(t, roles.None, Scope("bad").Role())
+	assert.False(t, Scope("").Valid())
+}
+
+func prepStore(t *testing.T) *EngineStore {
+	eng, err := engine.NewMemory("", "site1", "site2")
+	require.NoError(t, err)
+	return NewEngineStore(eng, func() []string { return []string{"site1", "site2"} })
+}
+
+// collidingStore reports the first collisions ids as used
+type collidingStore struct {
+	*EngineStore
+	collisions, attempts int
+}
+
+func (c *collidingStore) Add(t Token) (bool, error) {
+	if c.attempts++; c.attempts <= c.collisions {
+		return false, nil
+	}
+	return c.EngineStore.Add(t)
+}
@@ -0,0 +1,66 @@
+package apitokens
+
+import (
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// EngineStore implements Store with records of store engine, tokens kept along with comments of their site.
+// Token value doesn't include site, so token looked up by id in all sites.
+type EngineStore struct {
+	records engine.Records // keyed by id
+	sites   func() []string
+}
+
+// NewEngineStore makes store of api tokens kept by eng for sites
+func NewEngineStore(eng engine.Interface, sites func() []string) *EngineStore {
+	return &EngineStore{records: engine.Records{Engine: eng, Kind: engine.APITokens}, sites: sites}
+}
+
+// Get token by id, nil if not found in any site
+func (e *EngineStore) Get(id string) (*Token, error) {
+	for _, siteID := range e.sites() {
+		t := Token{}
+		found, err := e.records.Get(siteID, id, &t)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			return &t, nil
+		}
+	}
+	return nil, nil
+}
+
+// List tokens of the site
+func (e *EngineStore) List(siteID string) (res []Token, err error) {
+	res = []Token{}
+	err = e.records.List(siteID, "", func(_ string, unmarshal func(v interface{}) error) error {
+		t := Token{}
+		if err := unmarshal(&t); err != nil {
+			return err
+		}
+		res = append(res, t)
+		return nil
+	})
+	return res, err
+}
+
+// Set token, replacing previous one with the same id
+func (e *EngineStore) Set(t Token) error {
+	return e.records.Set(t.SiteID, t.ID, t)
+}
+
+// Add token if its id not used by any site yet, added false otherwise
+func (e *EngineStore) Add(t Token) (added bool, err error) {
+	existing, err := e.Get(t.ID)
+	if err != nil || existing != nil {
+		return false, err
+	}
+	return e.records.Add(t.SiteID, t.ID, t)
+}
+
+// Delete token of the site by id, missing one ignored
+func (e *EngineStore) Delete(siteID, id string) error {
+	_, err := e.records.Delete(siteID, id)
+	return err
+}
@@ -0,0 +1,253 @@
+// Package compress implements middleware compressing json responses with brotli, zstd or gzip,
+// negotiated with Accept-Encoding of the request. Responses smaller than the min size sent as is.
+package compress
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	log "github.com/go-pkgz/lgr"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// supported encodings in order of preference of the server, used if client accepts several with the same weight
+const (
+	Brotli = "br"
+	Zstd   = "zstd"
+	Gzip   = "gzip"
+)
+
+// Params of compression, level 0 disables the encoding
+type Params struct {
+	BrotliLevel int // 1-11
+	ZstdLevel   int // 1-22, mapped to the closest level of the encoder
+	GzipLevel   int // 1-9
+	MinSize     int // min size of response body to compress
+}
+
+// Middleware compresses json responses with the best encoding accepted by client
+type Middleware struct {
+	minSize   int
+	encodings []string              // enabled encodings, in order of preference
+	pools     map[string]*sync.Pool // reusable encoders, per encoding
+}
+
+// encoder is a compressing writer reset to the next response
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// New makes compression middleware, at least one encoding should be enabled
+func New(params Params) (*Middleware, error) {
+	if params.BrotliLevel < 0 || params.BrotliLevel > brotli.BestCompression {
+		return nil, errors.Errorf("invalid brotli level %d", params.BrotliLevel)
+	}
+	if params.ZstdLevel < 0 || params.ZstdLevel > 22 {
+		return nil, errors.Errorf("invalid zstd level %d", params.ZstdLevel)
+	}
+	if params.GzipLevel < 0 || params.GzipLevel > gzip.BestCompression {
+		return nil, errors.Errorf("invalid gzip level %d", params.GzipLevel)
+	}
+
+	res := Middleware{minSize: params.MinSize, pools: map[string]*sync.Pool{}}
+	if params.BrotliLevel > 0 {
+		res.add(Brotli, func() encoder { return brotli.NewWriterLevel(nil, params.BrotliLevel) })
+	}
+	if params.ZstdLevel > 0 {
+		level := zstd.EncoderLevelFromZstd(params.ZstdLevel)
+		res.add(Zstd, func() encoder {
+			enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(level), zstd.WithEncoderConcurrency(1))
+			if err != nil { // can't happen with valid options
+				panic(err)
+			}
+			return enc
+		})
+	}
+	if params.GzipLevel > 0 {
+		res.add(Gzip, func() encoder {
+			enc, _ := gzip.NewWriterLevel(nil, params.GzipLevel) // level checked already
+			return enc
+		})
+	}
+	if len(res.encodings) == 0 {
+		return nil, errors.New("no encodings enabled")
+	}
+	log.Printf("[INFO] compression of responses with %s, min size %d", strings.Join(res.encodings, ", "), res.minSize)
+	return &res, nil
+}
+
+func (m *Middleware) add(encoding string, fn func() encoder) {
+	m.encodings = append(m.encodings, encoding)
+	m.pools[encoding] = &sync.Pool{New: func() interface{} { return fn() }}
+}
+
+// Handler compresses json responses of the next handler if client accepts one of enabled encodings
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := m.negotiate(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &writer{ResponseWriter: w, m: m, encoding: encoding}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// negotiate returns enabled encoding with the highest weight in Accept-Encoding, empty if none accepted
+func (m *Middleware) negotiate(accept string) string {
+	weights := map[string]float64{}
+	for _, part := range strings.Split(accept, ",") {
+		name, q := part, 1.0
+		if i := strings.Index(part, ";"); i >= 0 {
+			name = part[:i]
+			if v := strings.TrimSpace(part[i+1:]); strings.HasPrefix(v, "q=") {
+				w, err := strconv.ParseFloat(v[2:], 64)
+				if err != nil {
+					continue
+				}
+				q = w
+			}
+		}
+		weights[strings.ToLower(strings.TrimSpace(name))] = q
+	}
+
+	res, best := "", 0.0
+	for _, enc := range m.encodings {
+		q, ok := weights[enc]
+		if !ok {
+			q = weights["*"] // not mentioned encodings accepted with the weight of wildcard
+		}
+		if q > best {
+			res, best = enc, q
+		}
+	}
+	return res
+}
+
+// writer buffers the response till the min size reached and compresses it after that.
+// Responses of other types, statuses and already encoded ones passed as is.
+type writer struct {
+	http.ResponseWriter
+	m        *Middleware
+	encoding string
+
+	status      int
+	wroteHeader bool
+	passThrough bool
+	buf         []byte
+	enc         encoder
+}
+
+// WriteHeader delays sending of the header of compressible response till the body size known
+func (w *writer) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader, w.status = true, status
+	if !w.compressible() {
+		w.passThrough = true
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+// Write buffers the body till the min size reached, then starts compression
+func (w *writer) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.passThrough {
+		return w.ResponseWriter.Write(p)
+	}
+	if w.enc != nil {
+		return w.enc.Write(p)
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) < w.m.minSize {
+		return len(p), nil
+	}
+	if err := w.start(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush compresses buffered body regardless of its size and flushes it to client
+func (w *writer) Flush() {
+	if w.wroteHeader && !w.passThrough && w.enc == nil {
+		if err := w.start(); err != nil {
+			return
+		}
+	}
+	if w.enc != nil {
+		if err := w.enc.Flush(); err != nil {
+			return
+		}
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// start sends the header of compressed response and the buffered body
+func (w *writer) start() error {
+	h := w.Header()
+	h.Set("Content-Encoding", w.encoding)
+	h.Del("Content-Length")
+	if etag := h.Get("Etag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("Etag", "W/"+etag) // compressed body isn't byte-to-byte equal to the one etag made for
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+
+	w.enc = w.m.pools[w.encoding].Get().(encoder)
+	w.enc.Reset(w.ResponseWriter)
+	buf := w.buf
+	w.buf = nil
+	_, err := w.enc.Write(buf)
+	return err
+}
+
+// close completes the response, small buffered body sent as is
+func (w *writer) close() {
+	if w.enc != nil {
+		if err := w.enc.Close(); err != nil {
+			log.Printf("[WARN] can't complete %s response, %v", w.encoding, err)
+		}
+		w.enc.Reset(nil)
+		w.m.pools[w.encoding].Put(w.enc)
+		return
+	}
+	if !w.wroteHeader || w.passThrough {
+		return
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buf) > 0 {
+		if _, err := w.ResponseWriter.Write(w.buf); err != nil {
+			log.Printf("[WARN] can't write response, %v", err)
+		}
+	}
+}
+
+// compressible checks if the response is json with body, not encoded already
+func (w *writer) compressible() bool {
+	if w.status < http.StatusOK || w.status == http.StatusNoContent || w.status == http.StatusNotModified {
+		return false
+	}
+	if w.Header().Get("Content-Encoding") != "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
@@ -0,0 +1,157 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware_Handler(t *testing.T) {
+	m, err := New(Params{BrotliLevel: 5, ZstdLevel: 3, GzipLevel: 5, MinSize: 100})
+	require.NoError(t, err)
+
+	body := `{"text":"` + strings.Repeat("some comment ", 100) + `"}`
+	h := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/small":
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			_, _ = w.Write([]byte(`{"ok":true}`))
+		case "/html":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte(body))
+		case "/error":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(body))
+		default:
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.Header().Set("Etag", `"123"`)
+			_, _ = w.Write([]byte(body[:50])) // written in parts, compression starts after min size
+			_, _ = w.Write([]byte(body[50:]))
+		}
+	}))
+
+	send := func(path, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if accept != "" {
+			req.Header.Set("Accept-Encoding", accept)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	decoders := map[string]func([]byte) ([]byte, error){
+		"br": func(b []byte) ([]byte, error) { return ioutil.ReadAll(brotli.NewReader(bytes.NewReader(b))) },
+		"zstd": func(b []byte) ([]byte, error) {
+			d, e := zstd.NewReader(bytes.NewReader(b))
+			if e != nil {
+				return nil, e
+			}
+			defer d.Close()
+			return ioutil.ReadAll(d)
+		},
+		"gzip": func(b []byte) ([]byte, error) {
+			r, e := gzip.NewReader(bytes.NewReader(b))
+			if e != nil {
+				return nil, e
+			}
+			return ioutil.ReadAll(r)
+		},
+	}
+
+	tbl := []struct {
+		accept, encoding string
+	}{
+		{"gzip, deflate, br", "br"},
+		{"gzip, zstd", "zstd"},
+		{"gzip", "gzip"},
+		{"br;q=0.5, gzip;q=0.8", "gzip"},
+		{"br;q=0, *", "zstd"},
+		{"deflate", ""},
+		{"", ""},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.accept, func(t *testing.T) {
+			rr := send("/", tt.accept)
+			assert.Equal(t, http.StatusOK, rr.Code)
+			assert.Equal(t, "Accept-Encoding", rr.Header().Get("Vary"))
+			assert.Equal(t, tt.encoding, rr.Header().Get("Content-Encoding"))
+			if tt.encoding == "" {
+				assert.Equal(t, body, rr.Body.String())
+				assert.Equal(t, `"123"`, rr.Header().Get("Etag"))
+				return
+			}
+			assert.Equal(t, `W/"123"`, rr.Header().Get("Etag"), "etag of compressed response is weak")
+			assert.Less(t, rr.Body.Len(), len(body))
+			res, e := decoders[tt.encoding](rr.Body.Bytes())
+			require.NoError(t, e)
+			assert.Equal(t, body, string(res))
+		})
+	}
+
+	rr := send("/small", "br")
+	assert.Equal(t, "", rr.Header().Get("Content-Encoding"), "smaller than min size")
+	assert.Equal(t, `{"ok":true}`, rr.Body.String())
+
+	rr = send("/html", "br")
+	assert.Equal(t, "", rr.Header().Get("Content-Encoding"), "not json")
+	assert.Equal(t, body, rr.Body.String())
+
+	rr = send("/error", "br")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "br", rr.Header().Get("Content-Encoding"), "error responses compressed too")
+
+	for i := 0; i < 3; i++ { // encoders reused
+		rr = send("/", "zstd")
+		res, e := decoders["zstd"](rr.Body.Bytes())
+		require.NoError(t, e)
+		assert.Equal(t, body, string(res))
+	}
+}
+
+func TestMiddleware_HandlerFlush(t *testing.T) {
+	m, err := New(Params{GzipLevel: 5, MinSize: 1000})
+	require.NoError(t, err)
+	h := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"part":1}`))
+		w.(http.Flusher).Flush()
+		_, _ = w.Write([]byte(`{"part":2}`))
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	assert.True(t, rr.Flushed)
+	assert.Equal(t, "gzip", rr.Header().Get("Content-Encoding"), "flushed before min size reached")
+	r, err := gzip.NewReader(rr.Body)
+	require.NoError(t, err)
+	res, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, `{"part":1}{"part":2}`, string(res))
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(Params{})
+	assert.EqualError(t, err, "no encodings enabled")
+	_, err = New(Params{BrotliLevel: 12})
+	assert.EqualError(t, err, "invalid brotli level 12")
+	_, err = New(Params{ZstdLevel: 23})
+	assert.EqualError(t, err, "invalid zstd level 23")
+	_, err = New(Params{GzipLevel: -1})
+	assert.EqualError(t, err, "invalid gzip level -1")
+
+	m, err := New(Params{ZstdLevel: 1, GzipLevel: 9})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"zstd", "gzip"}, m.encodings)
+}
@@ -11,9 +11,9 @@ import (
 	"strings"
 
 	"github.com/go-chi/render"
-	log "github.com/go-pkgz/lgr"
 	"github.com/go-pkgz/rest"
 
+	"github.com/umputun/remark42/backend/app/logging"
 	"github.com/umputun/remark42/backend/app/templates"
 )
 
@@ -40,6 +40,13 @@ const (
 	ErrAssetNotFound        = 18 // requested file not found
 	ErrCommentRestrictWords = 19 // restricted words in a comment
 	ErrImgNotFound          = 20 // posted image not found in the storage
+	ErrConsentRequired      = 21 // user should accept the current version of legal terms
+	ErrMaintenance          = 22 // service in maintenance mode, writes rejected
+	ErrReactionRejected     = 23 // reaction rejected, unknown or already set
+	ErrCaptcha              = 24 // captcha required or failed
+	ErrRateLimited          = 25 // too many comments, retry after delay
+	ErrNotOpened            = 26 // comments of the post not opened yet
+	ErrCommentInterval      = 27 // user commented the post with interval recently, retry after delay
 )
 
 // errTmplData store data for error message
@@ -66,7 +73,7 @@ func SendErrorHTML(w http.ResponseWriter, r *http.Request, httpStatusCode int, e
 	}
 	tmplstr := MustRead("error_response.html.tmpl")
 	tmpl := template.Must(template.New("error").Parse(tmplstr))
-	log.Printf("[WARN] %s", errDetailsMsg(r, httpStatusCode, err, details, errCode))
+	logging.Printf(r.Context(), "[WARN] %s", errDetailsMsg(r, httpStatusCode, err, details, errCode))
 	render.Status(r, httpStatusCode)
 	msg := bytes.Buffer{}
 	MustExecute(tmpl, &msg, errTmplData{
@@ -78,7 +85,7 @@ func SendErrorHTML(w http.ResponseWriter, r *http.Request, httpStatusCode int, e
 
 // SendErrorJSON makes {error: blah, details: blah} json body and responds with error code
 func SendErrorJSON(w http.ResponseWriter, r *http.Request, httpStatusCode int, err error, details string, errCode int) {
-	log.Printf("[WARN] %s", errDetailsMsg(r, httpStatusCode, err, details, errCode))
+	logging.Printf(r.Context(), "[WARN] %s", errDetailsMsg(r, httpStatusCode, err, details, errCode))
 	render.Status(r, httpStatusCode)
 	render.JSON(w, r, rest.JSON{"error": err.Error(), "details": details, "code": errCode})
 }
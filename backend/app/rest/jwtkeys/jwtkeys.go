@@ -0,0 +1,311 @@
+// Package jwtkeys keeps multiple secrets signing JWT tokens, to rotate the secret without logging out all users.
+// New tokens signed with the current key only, tokens signed with rotated keys accepted till the key expires
+// and re-signed with the current key on the first request. The configured shared secret is the first key.
+package jwtkeys
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-pkgz/auth/token"
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// BaseKeyID is id of the configured shared secret, current key till the first rotation
+const BaseKeyID = "secret"
+
+const keysBktName = "keys" // keyed by id
+
+// Key is a secret signing JWT tokens
+type Key struct {
+	ID      string     `json:"id"`
+	Secret  string     `json:"secret,omitempty"` // not stored for the base key
+	Created time.Time  `json:"created"`
+	Expires *time.Time `json:"expires,omitempty"` // set for rotated key, tokens signed with it accepted till the time
+}
+
+// Keyring keeps current and rotated keys in bolt db, implements token.Secret returning the current one
+type Keyring struct {
+	Grace time.Duration // rotated keys accepted for grace period by default
+
+	base func() (string, error) // configured shared secret
+	db   *bolt.DB
+	lock sync.RWMutex
+	keys []Key // current first, then rotated ones, newest first
+}
+
+// NewKeyring makes keyring with persistent keys. base returns the configured shared secret used as the first key.
+func NewKeyring(fileName string, options bolt.Options, base func() (string, error), grace time.Duration) (*Keyring, error) {
+	db, err := bolt.Open(fileName, 0600, &options) //nolint:gocritic //octalLiteral is OK as FileMode
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to make boltdb for %s", fileName)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, e := tx.CreateBucketIfNotExists([]byte(keysBktName))
+		return errors.Wrapf(e, "failed to create top level bucket %s", keysBktName)
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to initialize boltdb db %q buckets", fileName)
+	}
+	res := Keyring{Grace: grace, base: base, db: db}
+	if err = res.load(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Get returns secret of the current key, implements token.Secret. Secret is the same for all sites.
+func (k *Keyring) Get(string) (string, error) {
+	k.lock.RLock()
+	current := k.keys[0]
+	k.lock.RUnlock()
+	return k.secret(current)
+}
+
+// Keys returns current and accepted rotated keys without secrets, current first
+func (k *Keyring) Keys() []Key {
+	res := []Key{}
+	for _, key := range k.accepted() {
+		key.Secret = ""
+		res = append(res, key)
+	}
+	return res
+}
+
+// Rotate makes new current key. Previous keys accepted for grace period, or till they expire if it happens sooner.
+// Zero grace retires previous keys immediately, logging out users with tokens signed with them.
+func (k *Keyring) Rotate(grace time.Duration) (Key, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return Key{}, errors.Wrap(err, "can't make secret")
+	}
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return Key{}, errors.Wrap(err, "can't make key id")
+	}
+	now := time.Now()
+	key := Key{ID: hex.EncodeToString(id), Secret: base64.StdEncoding.EncodeToString(secret), Created: now}
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	expires := now.Add(grace)
+	keys, expired := []Key{key}, []Key{}
+	for _, prev := range k.keys {
+		if prev.Expires != nil && !prev.Expires.After(now) {
+			expired = append(expired, prev)
+			continue
+		}
+		if prev.Expires == nil || prev.Expires.After(expires) {
+			prev.Expires = &expires
+		}
+		keys = append(keys, prev)
+	}
+	err := k.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(keysBktName))
+		for _, key := range expired {
+			if e := bkt.Delete([]byte(key.ID)); e != nil {
+				return errors.Wrapf(e, "can't delete expired key %s", key.ID)
+			}
+		}
+		for _, key := range keys {
+			if key.ID == BaseKeyID {
+				key.Secret = "" // shared secret not stored
+			}
+			data, e := json.Marshal(key)
+			if e != nil {
+				return errors.Wrapf(e, "can't marshal key %s", key.ID)
+			}
+			if e = bkt.Put([]byte(key.ID), data); e != nil {
+				return errors.Wrapf(e, "can't put key %s", key.ID)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return Key{}, err
+	}
+	k.keys = keys
+	log.Printf("[INFO] jwt key rotated, new key %s, previous keys accepted for %v", key.ID, grace)
+	key.Secret = ""
+	return key, nil
+}
+
+// Middleware re-signs token signed with rotated key by the current one, replacing it in the request and response.
+// Should be used before auth middleware, tokens signed with unknown or expired keys passed as is, as well as
+// expired tokens. Expiration of re-signed token capped by expiration of the rotated key and token duration.
+func (k *Keyring) Middleware(tokens *token.Service) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			tkn, src := requestToken(tokens, r)
+			if tkn == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			claims, key, ok := k.parseRotated(tokens, tkn)
+			if !ok || tokens.IsExpired(claims) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			// re-signed token can't outlive the rotated key nor the usual token duration, whatever set in claims
+			expires := time.Now().Add(tokens.TokenDuration)
+			if key.Expires != nil && key.Expires.Before(expires) {
+				expires = *key.Expires
+			}
+			if claims.ExpiresAt > expires.Unix() {
+				claims.ExpiresAt = expires.Unix()
+			}
+			resigned, err := tokens.Token(claims)
+			if err != nil {
+				log.Printf("[WARN] can't re-sign token signed with key %s, %v", key.ID, err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			if src == srcCookie || tokens.SendJWTHeader {
+				if _, err = tokens.Set(w, claims); err != nil {
+					log.Printf("[WARN] can't set re-signed token, %v", err)
+				}
+			}
+			log.Printf("[DEBUG] token signed with rotated key %s re-signed", key.ID)
+			next.ServeHTTP(w, replaceToken(tokens, r, src, resigned))
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// Close bolt store
+func (k *Keyring) Close() error {
+	return errors.Wrap(k.db.Close(), "failed to close jwt keys store")
+}
+
+// parseRotated checks if token signed with rotated key, returns parsed claims and the key.
+// Token signed with the current key or not valid with any key is not ok. Expiration of claims not checked.
+func (k *Keyring) parseRotated(tokens *token.Service, tkn string) (claims token.Claims, key Key, ok bool) {
+	keys := k.accepted()
+	for i, key := range keys {
+		secret, err := k.secret(key)
+		if err != nil {
+			continue
+		}
+		svc := *tokens
+		svc.SecretReader = token.SecretFunc(func(string) (string, error) { return secret, nil })
+		if claims, err = svc.Parse(tkn); err == nil {
+			return claims, key, i > 0
+		}
+	}
+	return token.Claims{}, Key{}, false
+}
+
+// accepted returns current key and rotated keys not expired yet
+func (k *Keyring) accepted() []Key {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	res := []Key{}
+	now := time.Now()
+	for _, key := range k.keys {
+		if key.Expires == nil || key.Expires.After(now) {
+			res = append(res, key)
+		}
+	}
+	return res
+}
+
+func (k *Keyring) secret(key Key) (string, error) {
+	if key.ID == BaseKeyID {
+		return k.base()
+	}
+	return key.Secret, nil
+}
+
+// load keys from the store, expired keys removed. Base key used as the current one if nothing stored.
+func (k *Keyring) load() error {
+	now := time.Now()
+	keys := []Key{}
+	err := k.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(keysBktName))
+		expired := [][]byte{}
+		e := bkt.ForEach(func(id, v []byte) error {
+			key := Key{}
+			if err := json.Unmarshal(v, &key); err != nil {
+				return errors.Wrapf(err, "can't unmarshal key %s", id)
+			}
+			if key.Expires != nil && !key.Expires.After(now) {
+				expired = append(expired, append([]byte{}, id...))
+				return nil
+			}
+			keys = append(keys, key)
+			return nil
+		})
+		if e != nil {
+			return e
+		}
+		for _, id := range expired {
+			if e = bkt.Delete(id); e != nil {
+				return errors.Wrapf(e, "can't delete expired key %s", id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "can't load jwt keys")
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Created.After(keys[j].Created) })
+	if len(keys) == 0 {
+		keys = []Key{{ID: BaseKeyID}} // nothing rotated yet
+	}
+	k.keys = keys
+	return nil
+}
+
+type tokenSource int
+
+const (
+	srcQuery tokenSource = iota
+	srcHeader
+	srcCookie
+)
+
+// requestToken gets token the same way token.Service does, from query, header or cookie
+func requestToken(tokens *token.Service, r *http.Request) (string, tokenSource) {
+	if tkn := r.URL.Query().Get(tokens.JWTQuery); tkn != "" {
+		return tkn, srcQuery
+	}
+	if tkn := r.Header.Get(tokens.JWTHeaderKey); tkn != "" {
+		return tkn, srcHeader
+	}
+	if c, err := r.Cookie(tokens.JWTCookieName); err == nil {
+		return c.Value, srcCookie
+	}
+	return "", srcCookie
+}
+
+// replaceToken returns copy of the request with token replaced in the source
+func replaceToken(tokens *token.Service, r *http.Request, src tokenSource, tkn string) *http.Request {
+	res := r.Clone(r.Context())
+	switch src {
+	case srcQuery:
+		q := res.URL.Query()
+		q.Set(tokens.JWTQuery, tkn)
+		res.URL.RawQuery = q.Encode()
+	case srcHeader:
+		res.Header.Set(tokens.JWTHeaderKey, tkn)
+	case srcCookie:
+		cookies := res.Cookies()
+		res.Header.Del("Cookie")
+		for _, c := range cookies {
+			if c.Name == tokens.JWTCookieName {
+				c.Value = tkn
+			}
+			res.AddCookie(c)
+		}
+	}
+	return res
+}
@@ -0,0 +1,211 @@
+package jwtkeys
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-pkgz/auth/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestKeyring_Rotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwtkeys")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	base := func() (string, error) { return "shared secret", nil }
+
+	k, err := NewKeyring(path.Join(dir, "keys.db"), bolt.Options{}, base, time.Hour)
+	require.NoError(t, err)
+	secret, err := k.Get("site")
+	require.NoError(t, err)
+	assert.Equal(t, "shared secret", secret, "configured secret used till rotation")
+	keys := k.Keys()
+	require.Equal(t, 1, len(keys))
+	assert.Equal(t, BaseKeyID, keys[0].ID)
+	assert.Nil(t, keys[0].Expires)
+
+	key, err := k.Rotate(time.Hour)
+	require.NoError(t, err)
+	assert.Empty(t, key.Secret, "secret not returned")
+	secret, err = k.Get("site")
+	require.NoError(t, err)
+	assert.NotEqual(t, "shared secret", secret)
+	keys = k.Keys()
+	require.Equal(t, 2, len(keys))
+	assert.Equal(t, key.ID, keys[0].ID)
+	assert.Equal(t, BaseKeyID, keys[1].ID)
+	require.NotNil(t, keys[1].Expires)
+	assert.True(t, keys[1].Expires.After(time.Now().Add(59*time.Minute)))
+	require.NoError(t, k.Close())
+
+	k, err = NewKeyring(path.Join(dir, "keys.db"), bolt.Options{}, base, time.Hour)
+	require.NoError(t, err)
+	defer k.Close()
+	reloaded, err := k.Get("site")
+	require.NoError(t, err)
+	assert.Equal(t, secret, reloaded, "current key kept")
+	reloadedKeys := k.Keys()
+	require.Equal(t, 2, len(reloadedKeys))
+	assert.Equal(t, keys[0].ID, reloadedKeys[0].ID)
+	assert.True(t, keys[1].Expires.Equal(*reloadedKeys[1].Expires))
+	err = k.db.View(func(tx *bolt.Tx) error {
+		assert.NotContains(t, string(tx.Bucket([]byte(keysBktName)).Get([]byte(BaseKeyID))), "shared secret")
+		return nil
+	})
+	require.NoError(t, err)
+
+	_, err = k.Rotate(time.Minute)
+	require.NoError(t, err)
+	keys = k.Keys()
+	require.Equal(t, 3, len(keys))
+	assert.True(t, keys[2].Expires.Before(time.Now().Add(2*time.Minute)), "grace shortened")
+
+	_, err = k.Rotate(0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(k.Keys()), "previous keys retired")
+}
+
+func TestKeyring_Middleware(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwtkeys")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	k, err := NewKeyring(path.Join(dir, "keys.db"), bolt.Options{},
+		func() (string, error) { return "shared secret", nil }, time.Hour)
+	require.NoError(t, err)
+	defer k.Close()
+
+	tokens := token.NewService(token.Opts{SecretReader: k, TokenDuration: time.Minute, CookieDuration: time.Hour})
+	claims := token.Claims{User: &token.User{ID: "user1", Name: "user"},
+		StandardClaims: jwt.StandardClaims{Id: "xsrf1", Audience: "site", ExpiresAt: time.Now().Add(time.Minute).Unix()}}
+	oldToken, err := tokens.Token(claims)
+	require.NoError(t, err)
+
+	var got token.Claims
+	var gotErr error
+	ts := httptest.NewServer(k.Middleware(tokens)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _, gotErr = tokens.Get(r)
+	})))
+	defer ts.Close()
+
+	send := func(req *http.Request) *http.Response {
+		resp, e := http.DefaultClient.Do(req)
+		require.NoError(t, e)
+		require.NoError(t, resp.Body.Close())
+		return resp
+	}
+
+	// signed with the current key, passed as is
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set("X-JWT", oldToken)
+	resp := send(req)
+	require.NoError(t, gotErr)
+	assert.Equal(t, "user1", got.User.ID)
+	assert.Empty(t, resp.Header.Get("Set-Cookie"))
+
+	_, err = k.Rotate(time.Hour)
+	require.NoError(t, err)
+	_, err = tokens.Parse(oldToken)
+	require.Error(t, err, "old token not valid with the current key")
+
+	// header token re-signed
+	resp = send(req)
+	require.NoError(t, gotErr)
+	assert.Equal(t, "user1", got.User.ID)
+	assert.Empty(t, resp.Header.Get("Set-Cookie"), "header token not set as cookie")
+
+	// query token re-signed
+	req, err = http.NewRequest(http.MethodGet, ts.URL+"?token="+oldToken, nil)
+	require.NoError(t, err)
+	send(req)
+	require.NoError(t, gotErr)
+	assert.Equal(t, "user1", got.User.ID)
+
+	// cookie token re-signed and set
+	req, err = http.NewRequest(http.MethodGet, ts.URL, nil)
+	require.NoError(t, err)
+	req.AddCookie(&http.Cookie{Name: "JWT", Value: oldToken})
+	req.AddCookie(&http.Cookie{Name: "other", Value: "val"})
+	req.Header.Set("X-XSRF-TOKEN", "xsrf1")
+	resp = send(req)
+	require.NoError(t, gotErr)
+	assert.Equal(t, "user1", got.User.ID)
+	var jwtCookie *http.Cookie
+	for _, c := range resp.Cookies() {
+		if c.Name == "JWT" {
+			jwtCookie = c
+		}
+	}
+	require.NotNil(t, jwtCookie)
+	newClaims, err := tokens.Parse(jwtCookie.Value)
+	require.NoError(t, err)
+	assert.Equal(t, "user1", newClaims.User.ID)
+	assert.Equal(t, "xsrf1", newClaims.Id, "xsrf kept")
+
+	// retired key
+	_, err = k.Rotate(0)
+	require.NoError(t, err)
+	req, err = http.NewRequest(http.MethodGet, ts.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set("X-JWT", oldToken)
+	send(req)
+	assert.Error(t, gotErr)
+
+	// no token
+	req, err = http.NewRequest(http.MethodGet, ts.URL, nil)
+	require.NoError(t, err)
+	send(req)
+	assert.Error(t, gotErr)
+}
+
+func TestKeyring_MiddlewareExpiration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwtkeys")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	k, err := NewKeyring(path.Join(dir, "keys.db"), bolt.Options{},
+		func() (string, error) { return "shared secret", nil }, time.Hour)
+	require.NoError(t, err)
+	defer k.Close()
+
+	tokens := token.NewService(token.Opts{SecretReader: k, TokenDuration: time.Hour, CookieDuration: time.Hour})
+	user := &token.User{ID: "user1", Name: "user"}
+	longToken, err := tokens.Token(token.Claims{User: user,
+		StandardClaims: jwt.StandardClaims{Audience: "site", ExpiresAt: time.Now().Add(365 * 24 * time.Hour).Unix()}})
+	require.NoError(t, err)
+	expiredToken, err := tokens.Token(token.Claims{User: user,
+		StandardClaims: jwt.StandardClaims{Audience: "site", ExpiresAt: time.Now().Add(-time.Minute).Unix()}})
+	require.NoError(t, err)
+	_, err = k.Rotate(time.Minute)
+	require.NoError(t, err)
+
+	var got token.Claims
+	var gotErr error
+	ts := httptest.NewServer(k.Middleware(tokens)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _, gotErr = tokens.Get(r)
+	})))
+	defer ts.Close()
+	send := func(tkn string) {
+		req, e := http.NewRequest(http.MethodGet, ts.URL, nil)
+		require.NoError(t, e)
+		req.Header.Set("X-JWT", tkn)
+		resp, e := http.DefaultClient.Do(req)
+		require.NoError(t, e)
+		require.NoError(t, resp.Body.Close())
+	}
+
+	send(longToken)
+	require.NoError(t, gotErr)
+	assert.Equal(t, "user1", got.User.ID)
+	assert.True(t, got.ExpiresAt <= time.Now().Add(time.Minute).Unix(), "capped by expiration of rotated key")
+
+	send(expiredToken)
+	assert.Error(t, gotErr, "expired token not re-signed")
+}
@@ -0,0 +1,102 @@
+// Package oidc makes generic OpenID Connect auth provider from metadata of the issuer, so any self-hosted
+// identity provider, like Keycloak, Authentik or Azure AD, can be used without provider-specific code.
+package oidc
+
+import (
+	"context"
+	"crypto/sha1" //nolint:gosec // used for user id hashing only, like other providers
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-pkgz/auth/provider"
+	"github.com/go-pkgz/auth/token"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+// Params of OpenID Connect provider
+type Params struct {
+	Name        string   // name of provider, used as prefix of user ids
+	Issuer      string   // issuer url, metadata discovered from issuer/.well-known/openid-configuration
+	Scopes      []string // requested scopes, openid added if missing
+	NameClaim   string   // claim with user name, preferred_username used if it's empty
+	AvatarClaim string   // claim with avatar url
+	EmailClaim  string   // claim with user email
+}
+
+// metadata is a part of provider metadata used to make auth provider
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+// Discover loads metadata of the issuer and returns options of custom auth provider using authorization,
+// token and userinfo endpoints of the issuer, with user mapped from userinfo claims
+func Discover(ctx context.Context, client *http.Client, p Params) (provider.CustomHandlerOpt, error) {
+	issuer := strings.TrimSuffix(p.Issuer, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return provider.CustomHandlerOpt{}, errors.Wrapf(err, "can't make discovery request for %s", p.Issuer)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return provider.CustomHandlerOpt{}, errors.Wrapf(err, "can't get metadata of %s", p.Issuer)
+	}
+	defer resp.Body.Close() // nolint
+	if resp.StatusCode != http.StatusOK {
+		return provider.CustomHandlerOpt{}, errors.Errorf("can't get metadata of %s, status %s", p.Issuer, resp.Status)
+	}
+
+	md := metadata{}
+	if err = json.NewDecoder(resp.Body).Decode(&md); err != nil {
+		return provider.CustomHandlerOpt{}, errors.Wrapf(err, "can't decode metadata of %s", p.Issuer)
+	}
+	if strings.TrimSuffix(md.Issuer, "/") != issuer {
+		return provider.CustomHandlerOpt{}, errors.Errorf("issuer %q in metadata doesn't match %q", md.Issuer, p.Issuer)
+	}
+	if md.AuthorizationEndpoint == "" || md.TokenEndpoint == "" || md.UserinfoEndpoint == "" {
+		return provider.CustomHandlerOpt{}, errors.Errorf("metadata of %s has no authorization, token or userinfo endpoint", p.Issuer)
+	}
+
+	scopes := p.Scopes
+	if !contains("openid", scopes) {
+		scopes = append([]string{"openid"}, scopes...)
+	}
+	return provider.CustomHandlerOpt{
+		Endpoint:  oauth2.Endpoint{AuthURL: md.AuthorizationEndpoint, TokenURL: md.TokenEndpoint},
+		InfoURL:   md.UserinfoEndpoint,
+		Scopes:    scopes,
+		MapUserFn: p.mapUser,
+	}, nil
+}
+
+// mapUser makes user from userinfo claims, id hashed from subject claim unique for the issuer
+func (p Params) mapUser(data provider.UserData, _ []byte) token.User {
+	user := token.User{
+		ID:      p.Name + "_" + token.HashID(sha1.New(), data.Value("sub")), //nolint:gosec // not used for security
+		Picture: data.Value(p.AvatarClaim),
+		Email:   data.Value(p.EmailClaim),
+	}
+	if p.NameClaim != "" {
+		user.Name = data.Value(p.NameClaim)
+	}
+	if user.Name == "" {
+		user.Name = data.Value("preferred_username")
+	}
+	if user.Name == "" {
+		user.Name = "noname_" + user.ID[len(p.Name)+1:len(p.Name)+5]
+	}
+	return user
+}
+
+func contains(s string, list []string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-pkgz/auth/provider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiscover(t *testing.T) {
+	var issuer string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/realms/remark/.well-known/openid-configuration":
+			_, _ = fmt.Fprintf(w, `{"issuer":%q,"authorization_endpoint":"%[1]s/auth","token_endpoint":"%[1]s/token",
+				"userinfo_endpoint":"%[1]s/userinfo","jwks_uri":"%[1]s/certs"}`, issuer)
+		case "/other/.well-known/openid-configuration":
+			_, _ = fmt.Fprintf(w, `{"issuer":%q}`, issuer)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	issuer = ts.URL + "/realms/remark"
+
+	opts, err := Discover(context.Background(), http.DefaultClient, Params{Name: "keycloak", Issuer: issuer + "/", Scopes: []string{"profile"}})
+	require.NoError(t, err)
+	assert.Equal(t, issuer+"/auth", opts.Endpoint.AuthURL)
+	assert.Equal(t, issuer+"/token", opts.Endpoint.TokenURL)
+	assert.Equal(t, issuer+"/userinfo", opts.InfoURL)
+	assert.Equal(t, []string{"openid", "profile"}, opts.Scopes)
+	require.NotNil(t, opts.MapUserFn)
+
+	_, err = Discover(context.Background(), http.DefaultClient, Params{Issuer: ts.URL + "/other"})
+	assert.EqualError(t, err, fmt.Sprintf("issuer %q in metadata doesn't match %q", issuer, ts.URL+"/other"))
+
+	_, err = Discover(context.Background(), http.DefaultClient, Params{Issuer: ts.URL + "/bad"})
+	assert.EqualError(t, err, fmt.Sprintf("can't get metadata of %s/bad, status 404 Not Found", ts.URL))
+}
+
+func TestParams_MapUser(t *testing.T) {
+	p := Params{Name: "authentik", NameClaim: "name", AvatarClaim: "picture", EmailClaim: "email"}
+	u := p.mapUser(provider.UserData{"sub": "123", "name": "John", "picture": "https://example.com/pic.png",
+		"email": "john@example.com", "preferred_username": "johnny"}, nil)
+	assert.Equal(t, "authentik_40bd001563085fc35165329ea1ff5c5ecbdbbeef", u.ID)
+	assert.Equal(t, "John", u.Name)
+	assert.Equal(t, "https://example.com/pic.png", u.Picture)
+	assert.Equal(t, "john@example.com", u.Email)
+
+	u = p.mapUser(provider.UserData{"sub": "123", "preferred_username": "johnny"}, nil)
+	assert.Equal(t, "johnny", u.Name, "preferred_username used without name claim")
+	assert.Equal(t, "", u.Picture)
+
+	u = p.mapUser(provider.UserData{"sub": "123"}, nil)
+	assert.Equal(t, "noname_40bd", u.Name)
+}
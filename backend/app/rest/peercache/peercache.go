@@ -0,0 +1,375 @@
+// Package peercache implements distributed loading cache shared by a small set of remark42 nodes without central storage.
+// Each key owned by a single peer selected with rendezvous hashing. On local miss the node asks the owner for the value,
+// and if the owner doesn't have it yet, loads the value locally and pushes it to the owner, so other nodes can reuse it.
+// Values received from owners kept in local LRU as hot copies, flush requests delivered to all peers.
+// Each node counts flushes as generations, pushed value tagged with the generation reported by the owner on miss
+// and rejected if the owner flushed anything since, so the value loaded before the flush is not cached again.
+package peercache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	cache "github.com/go-pkgz/lcw"
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+)
+
+const (
+	tokenHeader      = "X-Remark-Peer"
+	generationHeader = "X-Remark-Peer-Generation"
+)
+
+// Opts defines peers cache parameters
+type Opts struct {
+	Self    string        // url of this node as listed in Peers, i.e. http://node1:8080/api/v1/cache
+	Peers   []string      // urls of all nodes, including self
+	Secret  string        // shared secret, the same for all nodes
+	Timeout time.Duration // timeout for peer requests
+	MaxSize int64         // max size of value pushed by peer, 16M by default
+}
+
+// PeerCache implements loading cache with values shared between peers.
+// Implements http.Handler to serve requests from other peers.
+type PeerCache struct {
+	Opts
+	backend cache.LoadingCache
+	local   *cache.Scache
+	client  http.Client
+	token   string
+	router  chi.Router
+
+	generation uint64       // number of flushes, values loaded before the last flush rejected
+	flushLock  sync.RWMutex // pushed values stored under read lock, flushes under write lock
+}
+
+// New makes PeerCache on top of local backend. Backend used for both owned and hot (received from owners) values.
+func New(backend cache.LoadingCache, opts Opts) (*PeerCache, error) {
+	if opts.Self == "" {
+		return nil, errors.New("self url is required")
+	}
+	if opts.Secret == "" {
+		return nil, errors.New("secret is required")
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = time.Second
+	}
+	if opts.MaxSize == 0 {
+		opts.MaxSize = 16 * 1024 * 1024
+	}
+
+	peers := make([]string, 0, len(opts.Peers)+1)
+	hasSelf := false
+	for _, p := range opts.Peers {
+		p = strings.TrimSuffix(strings.TrimSpace(p), "/")
+		if p == "" {
+			continue
+		}
+		if p == strings.TrimSuffix(opts.Self, "/") {
+			hasSelf = true
+		}
+		peers = append(peers, p)
+	}
+	opts.Self = strings.TrimSuffix(opts.Self, "/")
+	if !hasSelf {
+		peers = append(peers, opts.Self)
+	}
+	opts.Peers = peers
+
+	tkn := sha256.Sum256([]byte("peercache:" + opts.Secret))
+	res := &PeerCache{
+		Opts:    opts,
+		backend: backend,
+		local:   cache.NewScache(backend),
+		client:  http.Client{Timeout: opts.Timeout},
+		token:   hex.EncodeToString(tkn[:]),
+	}
+
+	res.router = chi.NewRouter()
+	res.router.Use(res.auth)
+	res.router.Get("/value", res.getValueCtrl)
+	res.router.Put("/value", res.putValueCtrl)
+	res.router.Get("/keys", res.keysCtrl)
+	res.router.Post("/delete", res.deleteCtrl)
+
+	log.Printf("[INFO] peer cache on %s, peers %v", opts.Self, opts.Peers)
+	return res, nil
+}
+
+// Get returns value from local cache, from the owner peer or loads it with fn
+func (p *PeerCache) Get(key cache.Key, fn func() ([]byte, error)) (data []byte, err error) {
+	keyStr := key.String()
+	if v, ok := p.backend.Peek(keyStr); ok {
+		if data, ok := v.([]byte); ok {
+			return p.local.Get(key, func() ([]byte, error) { return data, nil }) // counts hit and refreshes lru
+		}
+	}
+
+	owner := p.owner(keyStr)
+	if owner == p.Self {
+		return p.local.Get(key, fn)
+	}
+
+	data, found, gen, err := p.fetch(owner, keyStr)
+	if err == nil && found {
+		return p.local.Get(key, func() ([]byte, error) { return data, nil })
+	}
+	if err != nil {
+		log.Printf("[DEBUG] can't get %s from peer %s, %v", keyStr, owner, err)
+	}
+	canPush := err == nil // generation of the owner known
+
+	loaded := false
+	data, err = p.local.Get(key, func() ([]byte, error) {
+		loaded = true
+		return fn()
+	})
+	if err == nil && loaded && canPush {
+		go func() {
+			if e := p.push(owner, keyStr, gen, data); e != nil {
+				log.Printf("[DEBUG] can't push %s to peer %s, %v", keyStr, owner, e)
+			}
+		}()
+	}
+	return data, err
+}
+
+// Flush evicts matched records locally and on all other peers.
+// FlusherRequest can't be transferred as-is, so keys of each peer matched locally and deleted on the peer explicitly.
+func (p *PeerCache) Flush(req cache.FlusherRequest) {
+	p.flushLock.Lock()
+	atomic.AddUint64(&p.generation, 1)
+	p.local.Flush(req)
+	p.flushLock.Unlock()
+
+	var wg sync.WaitGroup
+	for _, peer := range p.Peers {
+		if peer == p.Self {
+			continue
+		}
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			if err := p.flushPeer(peer, req); err != nil {
+				log.Printf("[WARN] can't flush peer %s, %v", peer, err)
+			}
+		}(peer)
+	}
+	wg.Wait()
+}
+
+// Close local cache
+func (p *PeerCache) Close() error {
+	return p.local.Close()
+}
+
+// ServeHTTP handles requests from other peers
+func (p *PeerCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.router.ServeHTTP(w, r)
+}
+
+// owner selects peer responsible for the key with rendezvous (highest random weight) hashing
+func (p *PeerCache) owner(key string) string {
+	var res string
+	var maxWeight uint64
+	for _, peer := range p.Peers {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(peer))
+		_, _ = h.Write([]byte(key))
+		if w := h.Sum64(); res == "" || w > maxWeight {
+			res, maxWeight = peer, w
+		}
+	}
+	return res
+}
+
+// fetch asks peer for the key, found false if peer doesn't have it. Generation of the peer returned on miss.
+func (p *PeerCache) fetch(peer, key string) (data []byte, found bool, gen uint64, err error) {
+	resp, err := p.request(http.MethodGet, peer+"/value?key="+url.QueryEscape(key), nil)
+	if err != nil {
+		return nil, false, 0, err
+	}
+	defer resp.Body.Close() // nolint
+	if resp.StatusCode == http.StatusNotFound {
+		gen, err = strconv.ParseUint(resp.Header.Get(generationHeader), 10, 64)
+		if err != nil {
+			return nil, false, 0, errors.Wrap(err, "can't get generation")
+		}
+		return nil, false, gen, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, 0, errors.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	if data, err = ioutil.ReadAll(resp.Body); err != nil {
+		return nil, false, 0, errors.Wrap(err, "can't read value")
+	}
+	return data, true, 0, nil
+}
+
+// push sends value loaded by this node to the owner peer, tagged with the owner's generation reported on miss
+func (p *PeerCache) push(peer, key string, gen uint64, data []byte) error {
+	resp, err := p.request(http.MethodPut, fmt.Sprintf("%s/value?key=%s&gen=%d", peer, url.QueryEscape(key), gen), data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint
+	if resp.StatusCode == http.StatusConflict {
+		return errors.New("value outdated, flushed on peer")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// flushPeer gets all keys of the peer, matches them with req and deletes matched keys on the peer
+func (p *PeerCache) flushPeer(peer string, req cache.FlusherRequest) error {
+	resp, err := p.request(http.MethodGet, peer+"/keys", nil)
+	if err != nil {
+		return err
+	}
+	keys := []string{}
+	err = json.NewDecoder(resp.Body).Decode(&keys)
+	_ = resp.Body.Close()
+	if err != nil {
+		return errors.Wrap(err, "can't decode keys")
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	// apply flusher request to a throwaway cache with the same keys to get a list of evicted ones
+	probe, err := cache.NewLruCache(cache.MaxKeys(len(keys) + 1))
+	if err != nil {
+		return errors.Wrap(err, "can't make probe cache")
+	}
+	for _, k := range keys {
+		_, _ = probe.Get(k, func() (interface{}, error) { return []byte{}, nil })
+	}
+	cache.NewScache(probe).Flush(req)
+	remaining := map[string]bool{}
+	for _, k := range probe.Keys() {
+		remaining[k] = true
+	}
+	evicted := []string{}
+	for _, k := range keys {
+		if !remaining[k] {
+			evicted = append(evicted, k)
+		}
+	}
+	if len(evicted) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(evicted)
+	if err != nil {
+		return errors.Wrap(err, "can't marshal keys")
+	}
+	if resp, err = p.request(http.MethodPost, peer+"/delete", body); err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (p *PeerCache) request(method, reqURL string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, reqURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't make request to %s", reqURL)
+	}
+	req.Header.Set(tokenHeader, p.token)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "request to %s failed", reqURL)
+	}
+	return resp, nil
+}
+
+// auth middleware rejects requests without valid peer token
+func (p *PeerCache) auth(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(tokenHeader)), []byte(p.token)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// GET /value?key=k - returns value of owned or hot key, 404 with current generation if not cached
+func (p *PeerCache) getValueCtrl(w http.ResponseWriter, r *http.Request) {
+	v, ok := p.backend.Peek(r.URL.Query().Get("key"))
+	data, isBytes := v.([]byte)
+	if !ok || !isBytes {
+		w.Header().Set(generationHeader, strconv.FormatUint(atomic.LoadUint64(&p.generation), 10))
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = w.Write(data)
+}
+
+// PUT /value?key=k&gen=N - stores value loaded by another peer, 409 if flushed after generation N
+func (p *PeerCache) putValueCtrl(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	gen, err := strconv.ParseUint(r.URL.Query().Get("gen"), 10, 64)
+	if key == "" || err != nil {
+		http.Error(w, fmt.Sprintf("bad request, %v", err), http.StatusBadRequest)
+		return
+	}
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, p.MaxSize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("bad request, %v", err), http.StatusBadRequest)
+		return
+	}
+	p.flushLock.RLock()
+	defer p.flushLock.RUnlock()
+	if gen != atomic.LoadUint64(&p.generation) {
+		http.Error(w, "Outdated value", http.StatusConflict)
+		return
+	}
+	_, _ = p.backend.Get(key, func() (interface{}, error) { return data, nil })
+}
+
+// GET /keys - returns list of all keys cached by this node. Requested by peer on flush, so generation
+// changed here as well, values pushed after the list made rejected even if not matched by the flush.
+func (p *PeerCache) keysCtrl(w http.ResponseWriter, _ *http.Request) {
+	p.flushLock.Lock()
+	atomic.AddUint64(&p.generation, 1)
+	p.flushLock.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(p.backend.Keys())
+}
+
+// POST /delete - deletes keys from the list in body
+func (p *PeerCache) deleteCtrl(w http.ResponseWriter, r *http.Request) {
+	keys := []string{}
+	if err := json.NewDecoder(r.Body).Decode(&keys); err != nil {
+		http.Error(w, fmt.Sprintf("bad request, %v", err), http.StatusBadRequest)
+		return
+	}
+	p.flushLock.Lock()
+	defer p.flushLock.Unlock()
+	atomic.AddUint64(&p.generation, 1)
+	for _, k := range keys {
+		p.backend.Delete(k)
+	}
+}
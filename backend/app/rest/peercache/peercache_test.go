@@ -0,0 +1,189 @@
+package peercache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cache "github.com/go-pkgz/lcw"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeerCache_GetShared(t *testing.T) {
+	nodes, teardown := prepNodes(t, 3)
+	defer teardown()
+
+	var loads int32
+	loadFn := func(val string) func() ([]byte, error) {
+		return func() ([]byte, error) {
+			atomic.AddInt32(&loads, 1)
+			return []byte(val), nil
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		key := cache.NewKey("site").ID(fmt.Sprintf("key-%d", i)).Scopes("site", fmt.Sprintf("post-%d", i%2))
+		res, err := nodes[i%3].Get(key, loadFn(fmt.Sprintf("val-%d", i)))
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("val-%d", i), string(res))
+	}
+	assert.Equal(t, int32(20), atomic.LoadInt32(&loads))
+
+	// wait for async pushes to owners
+	time.Sleep(100 * time.Millisecond)
+
+	// all nodes get values from owners, no more loads
+	for _, n := range nodes {
+		for i := 0; i < 20; i++ {
+			key := cache.NewKey("site").ID(fmt.Sprintf("key-%d", i)).Scopes("site", fmt.Sprintf("post-%d", i%2))
+			res, err := n.Get(key, loadFn("bad"))
+			require.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("val-%d", i), string(res))
+		}
+	}
+	assert.Equal(t, int32(20), atomic.LoadInt32(&loads))
+}
+
+func TestPeerCache_Flush(t *testing.T) {
+	nodes, teardown := prepNodes(t, 3)
+	defer teardown()
+
+	for i := 0; i < 10; i++ {
+		key := cache.NewKey("site").ID(fmt.Sprintf("key-%d", i)).Scopes("site", fmt.Sprintf("post-%d", i%2))
+		for _, n := range nodes {
+			_, err := n.Get(key, func() ([]byte, error) { return []byte("val"), nil })
+			require.NoError(t, err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	nodes[0].Flush(cache.Flusher("site").Scopes("post-0"))
+	for _, n := range nodes {
+		for i := 0; i < 10; i++ {
+			key := cache.NewKey("site").ID(fmt.Sprintf("key-%d", i)).Scopes("site", fmt.Sprintf("post-%d", i%2))
+			_, found := n.backend.Peek(key.String())
+			assert.Equal(t, i%2 == 1, found, "key %d on %s", i, n.Self)
+		}
+	}
+
+	nodes[1].Flush(cache.Flusher("site"))
+	for _, n := range nodes {
+		assert.Equal(t, 0, len(n.backend.Keys()), n.Self)
+	}
+}
+
+func TestPeerCache_StalePush(t *testing.T) {
+	nodes, teardown := prepNodes(t, 2)
+	defer teardown()
+
+	var key cache.Key
+	for i := 0; ; i++ {
+		key = cache.NewKey("site").ID(fmt.Sprintf("key-%d", i)).Scopes("site")
+		if nodes[0].owner(key.String()) == nodes[1].Self {
+			break
+		}
+	}
+
+	// flushed while value loaded, push of the loaded value rejected by owner
+	res, err := nodes[0].Get(key, func() ([]byte, error) {
+		nodes[0].Flush(cache.Flusher("site").Scopes("site"))
+		return []byte("stale"), nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "stale", string(res), "returned to the caller anyway")
+	time.Sleep(100 * time.Millisecond)
+	_, found := nodes[1].backend.Peek(key.String())
+	assert.False(t, found, "stale value not pushed to owner")
+
+	nodes[0].Flush(cache.Flusher("site"))
+	_, err = nodes[0].Get(key, func() ([]byte, error) { return []byte("fresh"), nil })
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+	v, found := nodes[1].backend.Peek(key.String())
+	require.True(t, found, "pushed without flush")
+	assert.Equal(t, "fresh", string(v.([]byte)))
+
+	// pushed value limited by size
+	nodes[1].MaxSize = 10
+	resp, err := nodes[0].request(http.MethodPut, fmt.Sprintf("%s/value?key=big&gen=%d", nodes[1].Self,
+		atomic.LoadUint64(&nodes[1].generation)), []byte("more than 10 bytes"))
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	_, found = nodes[1].backend.Peek("big")
+	assert.False(t, found)
+}
+
+func TestPeerCache_PeerDown(t *testing.T) {
+	backend, err := cache.NewLruCache()
+	require.NoError(t, err)
+	pc, err := New(backend, Opts{Self: "http://127.0.0.1:1/cache", Peers: []string{"http://127.0.0.1:2/cache"},
+		Secret: "secret", Timeout: 100 * time.Millisecond})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"http://127.0.0.1:2/cache", "http://127.0.0.1:1/cache"}, pc.Peers, "self added")
+
+	for i := 0; i < 10; i++ {
+		res, e := pc.Get(cache.NewKey().ID(fmt.Sprintf("key-%d", i)), func() ([]byte, error) { return []byte("val"), nil })
+		require.NoError(t, e)
+		assert.Equal(t, "val", string(res))
+	}
+	pc.Flush(cache.Flusher(""))
+	assert.Equal(t, 0, len(backend.Keys()))
+	assert.NoError(t, pc.Close())
+
+	_, err = New(backend, Opts{Secret: "secret"})
+	assert.EqualError(t, err, "self url is required")
+	_, err = New(backend, Opts{Self: "http://127.0.0.1:1"})
+	assert.EqualError(t, err, "secret is required")
+}
+
+func TestPeerCache_Auth(t *testing.T) {
+	nodes, teardown := prepNodes(t, 1)
+	defer teardown()
+
+	resp, err := http.Get(nodes[0].Self + "/keys")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	req, err := http.NewRequest("GET", nodes[0].Self+"/keys", nil)
+	require.NoError(t, err)
+	req.Header.Set(tokenHeader, "bad")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp, err = nodes[0].request("GET", nodes[0].Self+"/value?key=nope", nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func prepNodes(t *testing.T, count int) (nodes []*PeerCache, teardown func()) {
+	servers := make([]*httptest.Server, count)
+	urls := make([]string, count)
+	nodes = make([]*PeerCache, count)
+	for i := 0; i < count; i++ {
+		i := i
+		servers[i] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.StripPrefix("/cache", nodes[i]).ServeHTTP(w, r)
+		}))
+		urls[i] = servers[i].URL + "/cache"
+	}
+	for i := 0; i < count; i++ {
+		backend, err := cache.NewLruCache()
+		require.NoError(t, err)
+		nodes[i], err = New(backend, Opts{Self: urls[i], Peers: urls, Secret: "secret"})
+		require.NoError(t, err)
+	}
+	return nodes, func() {
+		for _, s := range servers {
+			s.Close()
+		}
+	}
+}
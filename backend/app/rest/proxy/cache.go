@@ -0,0 +1,196 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Cache defines interface to keep downloaded remote images with expiration. Each image may have variants,
+// i.e. converted to another format, expiring together with the original.
+type Cache interface {
+	Get(imgURL, variant string) (entry CacheEntry, found bool, err error) // expired entries not found
+	Put(imgURL, variant string, entry CacheEntry) error
+	Purge(imgURL string) (int, error) // removes all variants of the image, all images if url empty
+	Close() error
+}
+
+// CacheEntry is a cached image
+type CacheEntry struct {
+	Data    []byte
+	Expires time.Time
+}
+
+const imagesBktName = "images" // keyed by url + \x00 + variant, value is expiration (unix nano) + image data
+
+// BoltCache implements Cache with bolt DB
+type BoltCache struct {
+	db  *bolt.DB
+	now func() time.Time
+}
+
+// NewBoltCache makes persistent cache of remote images
+func NewBoltCache(fileName string, options bolt.Options) (*BoltCache, error) {
+	db, err := bolt.Open(fileName, 0600, &options) //nolint:gocritic //octalLiteral is OK as FileMode
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to make boltdb for %s", fileName)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, e := tx.CreateBucketIfNotExists([]byte(imagesBktName))
+		return errors.Wrapf(e, "failed to create top level bucket %s", imagesBktName)
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to initialize boltdb db %q buckets", fileName)
+	}
+	return &BoltCache{db: db, now: time.Now}, nil
+}
+
+// Get cached image, expired one not found
+func (b *BoltCache) Get(imgURL, variant string) (entry CacheEntry, found bool, err error) {
+	err = b.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(imagesBktName)).Get(cacheKey(imgURL, variant))
+		if len(v) < 8 {
+			return nil
+		}
+		entry.Expires = time.Unix(0, int64(binary.BigEndian.Uint64(v[:8])))
+		if !b.now().Before(entry.Expires) {
+			return nil
+		}
+		entry.Data, found = append([]byte{}, v[8:]...), true
+		return nil
+	})
+	return entry, found, err
+}
+
+// Put image to cache
+func (b *BoltCache) Put(imgURL, variant string, entry CacheEntry) error {
+	v := make([]byte, 8+len(entry.Data))
+	binary.BigEndian.PutUint64(v[:8], uint64(entry.Expires.UnixNano()))
+	copy(v[8:], entry.Data)
+	return b.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket([]byte(imagesBktName)).Put(cacheKey(imgURL, variant), v)
+		return errors.Wrapf(err, "can't put image %s", imgURL)
+	})
+}
+
+// Purge all variants of the image, all images if url empty. Returns number of removed entries.
+func (b *BoltCache) Purge(imgURL string) (count int, err error) {
+	prefix := []byte{}
+	if imgURL != "" {
+		prefix = cacheKey(imgURL, "")
+	}
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(imagesBktName))
+		keys := [][]byte{}
+		c := bkt.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			keys = append(keys, k)
+		}
+		return deleteKeys(bkt, keys, &count)
+	})
+	return count, err
+}
+
+// Cleanup removes expired images
+func (b *BoltCache) Cleanup() (count int, err error) {
+	now := uint64(b.now().UnixNano())
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(imagesBktName))
+		keys := [][]byte{}
+		c := bkt.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if len(v) < 8 || binary.BigEndian.Uint64(v[:8]) <= now {
+				keys = append(keys, k)
+			}
+		}
+		return deleteKeys(bkt, keys, &count)
+	})
+	return count, err
+}
+
+// Run removes expired images periodically, blocking
+func (b *BoltCache) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			count, err := b.Cleanup()
+			if err != nil {
+				log.Printf("[WARN] can't cleanup image proxy cache, %v", err)
+				continue
+			}
+			if count > 0 {
+				log.Printf("[DEBUG] removed %d expired images from proxy cache", count)
+			}
+		}
+	}
+}
+
+// Close bolt store
+func (b *BoltCache) Close() error {
+	return errors.Wrap(b.db.Close(), "failed to close image proxy cache")
+}
+
+// deleteKeys removes keys collected by cursor, deletion under cursor skips elements
+func deleteKeys(bkt *bolt.Bucket, keys [][]byte, count *int) error {
+	for _, k := range keys {
+		if err := bkt.Delete(k); err != nil {
+			return errors.Wrapf(err, "can't delete %s", string(k))
+		}
+		*count++
+	}
+	return nil
+}
+
+func cacheKey(imgURL, variant string) []byte {
+	return []byte(imgURL + "\x00" + variant)
+}
+
+// cacheTTL returns time to keep the response in shared cache per Cache-Control and Expires headers,
+// zero if the response shouldn't be cached. Default ttl used if the response has no expiration.
+func cacheTTL(h http.Header, now time.Time, defaultTTL time.Duration) time.Duration {
+	maxAge, sharedMaxAge := -1, -1
+	for _, d := range strings.Split(h.Get("Cache-Control"), ",") {
+		name, value := strings.TrimSpace(strings.ToLower(d)), ""
+		if i := strings.Index(name, "="); i >= 0 {
+			name, value = name[:i], strings.Trim(name[i+1:], `"`)
+		}
+		switch name {
+		case "no-store", "no-cache", "private":
+			return 0
+		case "max-age":
+			if v, err := strconv.Atoi(value); err == nil {
+				maxAge = v
+			}
+		case "s-maxage":
+			if v, err := strconv.Atoi(value); err == nil {
+				sharedMaxAge = v
+			}
+		}
+	}
+	switch {
+	case sharedMaxAge >= 0:
+		return time.Duration(sharedMaxAge) * time.Second
+	case maxAge >= 0:
+		return time.Duration(maxAge) * time.Second
+	}
+	if v := h.Get("Expires"); v != "" {
+		exp, err := http.ParseTime(v)
+		if err != nil || !exp.After(now) {
+			return 0 // invalid expires means expired
+		}
+		return exp.Sub(now)
+	}
+	return defaultTTL
+}
@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBoltCache(t *testing.T) {
+	c, teardown := prepCache(t)
+	defer teardown()
+	ts := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return ts }
+
+	_, found, err := c.Get("https://example.com/1.png", "")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	require.NoError(t, c.Put("https://example.com/1.png", "", CacheEntry{Data: []byte("img1"), Expires: ts.Add(time.Hour)}))
+	require.NoError(t, c.Put("https://example.com/1.png", "webp", CacheEntry{Data: []byte("webp1"), Expires: ts.Add(time.Hour)}))
+	require.NoError(t, c.Put("https://example.com/1.png2", "", CacheEntry{Data: []byte("img12"), Expires: ts.Add(time.Hour)}))
+	require.NoError(t, c.Put("https://example.com/2.png", "", CacheEntry{Data: []byte("img2"), Expires: ts.Add(time.Minute)}))
+
+	entry, found, err := c.Get("https://example.com/1.png", "webp")
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, CacheEntry{Data: []byte("webp1"), Expires: ts.Add(time.Hour)}, CacheEntry{Data: entry.Data, Expires: entry.Expires.UTC()})
+
+	ts = ts.Add(2 * time.Minute)
+	_, found, err = c.Get("https://example.com/2.png", "")
+	require.NoError(t, err)
+	assert.False(t, found, "expired")
+	count, err := c.Cleanup()
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	count, err = c.Purge("https://example.com/1.png")
+	require.NoError(t, err)
+	assert.Equal(t, 2, count, "both variants, other url with the same prefix kept")
+	_, found, err = c.Get("https://example.com/1.png2", "")
+	require.NoError(t, err)
+	assert.True(t, found)
+
+	count, err = c.Purge("")
+	require.NoError(t, err)
+	assert.Equal(t, 1, count, "all")
+}
+
+func TestCacheTTL(t *testing.T) {
+	now := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	tbl := []struct {
+		headers map[string]string
+		ttl     time.Duration
+	}{
+		{map[string]string{}, time.Hour},
+		{map[string]string{"Cache-Control": "public, max-age=600"}, 10 * time.Minute},
+		{map[string]string{"Cache-Control": "max-age=600, s-maxage=60"}, time.Minute},
+		{map[string]string{"Cache-Control": "max-age=600, no-cache"}, 0},
+		{map[string]string{"Cache-Control": "no-store"}, 0},
+		{map[string]string{"Cache-Control": "Private, max-age=600"}, 0},
+		{map[string]string{"Cache-Control": "max-age=bad"}, time.Hour},
+		{map[string]string{"Expires": now.Add(time.Minute).Format(http.TimeFormat)}, time.Minute},
+		{map[string]string{"Expires": "0"}, 0},
+		{map[string]string{"Expires": now.Add(time.Minute).Format(http.TimeFormat), "Cache-Control": "max-age=5"}, 5 * time.Second},
+	}
+	for i, tt := range tbl {
+		h := http.Header{}
+		for k, v := range tt.headers {
+			h.Set(k, v)
+		}
+		assert.Equal(t, tt.ttl, cacheTTL(h, now, time.Hour), "case #%d", i)
+	}
+}
+
+func prepCache(t *testing.T) (*BoltCache, func()) {
+	dir, err := ioutil.TempDir("", "img-proxy")
+	require.NoError(t, err)
+	c, err := NewBoltCache(path.Join(dir, "img-proxy.db"), bolt.Options{})
+	require.NoError(t, err)
+	return c, func() {
+		assert.NoError(t, c.Close())
+		_ = os.RemoveAll(dir)
+	}
+}
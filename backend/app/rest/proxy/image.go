@@ -28,6 +28,12 @@ type Image struct {
 	CacheExternal bool
 	Timeout       time.Duration
 	ImageService  *image.Service
+
+	MaxSize      int           // max size of remote image in bytes, not limited if 0
+	ContentTypes []string      // allowed content types of remote images detected by content, not checked if empty
+	WebP         bool          // convert images to webp for clients accepting it
+	Cache        Cache         // optional, keeps remote images with expiration by Cache-Control of the origin
+	CacheTTL     time.Duration // expiration of cached images without Cache-Control and Expires, not cached if 0
 }
 
 // Convert img src links to proxied links depends on enabled options
@@ -96,19 +102,24 @@ func (p Image) Handler(w http.ResponseWriter, r *http.Request) {
 	}
 	// try to load from cache for case it was saved when CacheExternal was enabled
 	img, _ = p.ImageService.Load(imgID)
+	var expires time.Time // set for images kept in the cache with expiration
 	if img == nil {
-		img, err = p.downloadImage(context.Background(), imgURL)
+		img, expires, err = p.loadRemote(r.Context(), imgURL, imgID)
 		if err != nil {
 			rest.SendErrorJSON(w, r, http.StatusNotFound, err, "can't get image "+imgURL, rest.ErrAssetNotFound)
 			return
 		}
-		if p.CacheExternal {
-			p.cacheImage(bytes.NewReader(img), imgID)
-		}
 	}
 
 	// enforce client-side caching
 	etag := `"` + r.URL.Query().Get("src") + `"`
+	if p.WebP {
+		w.Header().Set("Vary", "Accept")
+		if strings.Contains(r.Header.Get("Accept"), "image/webp") {
+			etag = `"` + r.URL.Query().Get("src") + `-webp"`
+			img = p.toWebP(imgURL, img, expires)
+		}
+	}
 	w.Header().Set("Etag", etag)
 	w.Header().Set("Cache-Control", "max-age=2592000") // 30 days
 	if match := r.Header.Get("If-None-Match"); match != "" {
@@ -125,6 +136,67 @@ func (p Image) Handler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// loadRemote gets remote image from the cache or downloads it. Downloaded image saved to image store with
+// CacheExternal, or to the cache with expiration by Cache-Control. Returns expiration of image in the cache.
+func (p Image) loadRemote(ctx context.Context, imgURL, imgID string) (img []byte, expires time.Time, err error) {
+	if p.Cache != nil {
+		entry, found, e := p.Cache.Get(imgURL, "")
+		if e != nil {
+			log.Printf("[WARN] can't get %s from image proxy cache, %v", imgURL, e)
+		}
+		if found {
+			return entry.Data, entry.Expires, nil
+		}
+	}
+
+	img, ttl, err := p.downloadImage(ctx, imgURL)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if p.CacheExternal {
+		p.cacheImage(bytes.NewReader(img), imgID)
+		return img, time.Time{}, nil
+	}
+	if p.Cache != nil && ttl > 0 {
+		expires = time.Now().Add(ttl)
+		if e := p.Cache.Put(imgURL, "", CacheEntry{Data: img, Expires: expires}); e != nil {
+			log.Printf("[WARN] can't put %s to image proxy cache, %v", imgURL, e)
+			return img, time.Time{}, nil
+		}
+	}
+	return img, expires, nil
+}
+
+// toWebP converts image to webp, the result kept in the cache together with the original image if it is cached.
+// Original image returned if it is webp or gif already, or can't be converted.
+func (p Image) toWebP(imgURL string, img []byte, expires time.Time) []byte {
+	if ct := p.ImageService.ImgContentType(img); ct == "image/webp" || ct == "image/gif" {
+		return img
+	}
+	cached := p.Cache != nil && !expires.IsZero()
+	if cached {
+		if entry, found, err := p.Cache.Get(imgURL, "webp"); err == nil && found {
+			return entry.Data
+		}
+	}
+	res := image.ToWebP(img)
+	if cached {
+		if err := p.Cache.Put(imgURL, "webp", CacheEntry{Data: res, Expires: expires}); err != nil {
+			log.Printf("[WARN] can't put webp of %s to image proxy cache, %v", imgURL, err)
+		}
+	}
+	return res
+}
+
+// Purge removes the image from the cache, all images if url empty. Returns number of removed entries,
+// including converted variants of images.
+func (p Image) Purge(imgURL string) (int, error) {
+	if p.Cache == nil {
+		return 0, errors.New("image proxy cache disabled")
+	}
+	return p.Cache.Purge(imgURL)
+}
+
 // cache image from provided Reader using given ID
 func (p Image) cacheImage(r io.Reader, imgID string) {
 	err := p.ImageService.SaveWithID(imgID, r)
@@ -133,8 +205,8 @@ func (p Image) cacheImage(r io.Reader, imgID string) {
 	}
 }
 
-// download an image.
-func (p Image) downloadImage(ctx context.Context, imgURL string) ([]byte, error) {
+// download an image, returns time to keep it in the cache by response headers.
+func (p Image) downloadImage(ctx context.Context, imgURL string) ([]byte, time.Duration, error) {
 	log.Printf("[DEBUG] downloading image %s", imgURL)
 
 	timeout := 60 * time.Second // default
@@ -157,17 +229,44 @@ func (p Image) downloadImage(ctx context.Context, imgURL string) ([]byte, error)
 		return e
 	})
 	if err != nil {
-		return nil, errors.Wrapf(err, "can't download image %s", imgURL)
+		return nil, 0, errors.Wrapf(err, "can't download image %s", imgURL)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, errors.Errorf("got unsuccessful response status %d while fetching %s", resp.StatusCode, imgURL)
+		return nil, 0, errors.Errorf("got unsuccessful response status %d while fetching %s", resp.StatusCode, imgURL)
 	}
 
-	imgData, err := ioutil.ReadAll(resp.Body)
+	if p.MaxSize > 0 && resp.ContentLength > int64(p.MaxSize) {
+		return nil, 0, errors.Errorf("image %s is too large, %d > %d", imgURL, resp.ContentLength, p.MaxSize)
+	}
+	body := io.Reader(resp.Body)
+	if p.MaxSize > 0 {
+		body = io.LimitReader(resp.Body, int64(p.MaxSize)+1)
+	}
+	imgData, err := ioutil.ReadAll(body)
 	if err != nil {
-		return nil, errors.Errorf("unable to read image body")
+		return nil, 0, errors.Errorf("unable to read image body")
+	}
+	if p.MaxSize > 0 && len(imgData) > p.MaxSize {
+		return nil, 0, errors.Errorf("image %s is too large, more than %d", imgURL, p.MaxSize)
+	}
+	if !p.allowedType(imgData) {
+		return nil, 0, errors.Errorf("content type %s of %s is not allowed", p.ImageService.ImgContentType(imgData), imgURL)
+	}
+	return imgData, cacheTTL(resp.Header, time.Now(), p.CacheTTL), nil
+}
+
+// allowedType checks if content type of the image is in the list of allowed types
+func (p Image) allowedType(img []byte) bool {
+	if len(p.ContentTypes) == 0 {
+		return true
+	}
+	ct := http.DetectContentType(img)
+	for _, t := range p.ContentTypes {
+		if strings.EqualFold(strings.TrimSpace(t), ct) {
+			return true
+		}
 	}
-	return imgData, nil
+	return false
 }
@@ -9,6 +9,7 @@ import (
 	"net/http/httptest"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -253,6 +254,101 @@ func TestImage_RoutesTimedOut(t *testing.T) {
 	assert.True(t, strings.Contains(string(b), "deadline exceeded"))
 }
 
+func TestImage_RoutesWithProxyCache(t *testing.T) {
+	imageStore := image.MockStore{}
+	imageStore.On("Load", mock.Anything).Return(nil, nil)
+	cache, teardown := prepCache(t)
+	defer teardown()
+	img := Image{
+		HTTP2HTTPS:   true,
+		RemarkURL:    "https://demo.remark42.com",
+		RoutePath:    "/api/v1/proxy",
+		ImageService: image.NewService(&imageStore, image.ServiceParams{}),
+		MaxSize:      2000,
+		ContentTypes: []string{"image/png", "image/jpeg"},
+		WebP:         true,
+		Cache:        cache,
+		CacheTTL:     time.Hour,
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { img.Handler(w, r) }))
+	defer ts.Close()
+
+	circles, err := ioutil.ReadFile("../../store/image/testdata/circles.png")
+	require.NoError(t, err)
+	var requests int32
+	httpSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		switch r.URL.Path {
+		case "/cached.png":
+			w.Header().Set("Cache-Control", "max-age=600")
+			_, _ = w.Write(gopherPNGBytes())
+		case "/no-store.png":
+			w.Header().Set("Cache-Control", "no-store")
+			_, _ = w.Write(gopherPNGBytes())
+		case "/big.png":
+			_, _ = w.Write(circles)
+		case "/page.html":
+			_, _ = w.Write([]byte("<html><body>not an image</body></html>"))
+		}
+	}))
+	defer httpSrv.Close()
+
+	get := func(name, accept string) (*http.Response, []byte) {
+		req, e := http.NewRequest(http.MethodGet, ts.URL+"/?src="+base64.URLEncoding.EncodeToString([]byte(httpSrv.URL+name)), nil)
+		require.NoError(t, e)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		resp, e := http.DefaultClient.Do(req)
+		require.NoError(t, e)
+		body, e := ioutil.ReadAll(resp.Body)
+		require.NoError(t, e)
+		require.NoError(t, resp.Body.Close())
+		return resp, body
+	}
+
+	resp, body := get("/cached.png", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, gopherPNGBytes(), body)
+	assert.Equal(t, "Accept", resp.Header.Get("Vary"))
+	resp, body = get("/cached.png", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, gopherPNGBytes(), body)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests), "second request served from cache")
+
+	resp, _ = get("/no-store.png", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = get("/no-store.png", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests), "not cached")
+
+	resp, _ = get("/big.png", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "too large")
+	resp, _ = get("/page.html", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "not allowed content type")
+
+	img.MaxSize = 0
+	resp, body = get("/big.png", "image/webp,image/*")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "image/webp", resp.Header.Get("Content-Type"))
+	assert.Equal(t, image.ToWebP(circles), body)
+	assert.True(t, strings.HasSuffix(resp.Header.Get("Etag"), `-webp"`))
+	resp, body = get("/big.png", "image/png")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "image/png", resp.Header.Get("Content-Type"), "original for client without webp support")
+	assert.Equal(t, circles, body)
+	entry, found, err := cache.Get(httpSrv.URL+"/big.png", "webp")
+	require.NoError(t, err)
+	require.True(t, found, "converted image cached")
+	assert.Equal(t, image.ToWebP(circles), entry.Data)
+
+	count, err := img.Purge(httpSrv.URL + "/big.png")
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	_, err = Image{}.Purge("")
+	assert.EqualError(t, err, "image proxy cache disabled")
+}
+
 func TestImage_ConvertProxyMode(t *testing.T) {
 	img := Image{HTTP2HTTPS: true, RoutePath: "/img"}
 	r := img.Convert(`<img src="http://radio-t.com/img3.png"/> xyz <img src="http://images.pexels.com/67636/img4.jpeg">`)
@@ -0,0 +1,253 @@
+// Package rediscache implements loading cache stored in redis and shared by all remark42 nodes.
+// Values kept as redis strings with ttl. For scoped invalidation each scope has a set of cached keys,
+// and all known scopes listed in a separate set, so flush doesn't need to scan the whole keyspace.
+package rediscache
+
+import (
+	"strings"
+	"time"
+
+	cache "github.com/go-pkgz/lcw"
+	log "github.com/go-pkgz/lgr"
+	"github.com/go-redis/redis/v7"
+	"github.com/pkg/errors"
+)
+
+// Opts defines redis cache parameters
+type Opts struct {
+	Addr     string        // redis address, i.e. 127.0.0.1:6379
+	Password string        // optional redis password
+	DB       int           // redis database number
+	Prefix   string        // prefix of all keys made by cache, default "remark42:cache:"
+	TTL      time.Duration // ttl of cached values, default 10m
+	MaxValue int           // max size of cached value, values above not cached. 0 means no limit
+	Timeout  time.Duration // timeout for redis operations, default 1s
+}
+
+// RedisCache implements loading cache on top of redis
+type RedisCache struct {
+	Opts
+	client *redis.Client
+}
+
+// New makes RedisCache and checks connection to redis
+func New(opts Opts) (*RedisCache, error) {
+	if opts.Addr == "" {
+		return nil, errors.New("redis address is required")
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = "remark42:cache:"
+	}
+	if opts.TTL == 0 {
+		opts.TTL = 10 * time.Minute
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = time.Second
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: opts.Addr, Password: opts.Password, DB: opts.DB,
+		DialTimeout: opts.Timeout, ReadTimeout: opts.Timeout, WriteTimeout: opts.Timeout})
+	if err := client.Ping().Err(); err != nil {
+		_ = client.Close()
+		return nil, errors.Wrapf(err, "can't connect to redis %s", opts.Addr)
+	}
+	log.Printf("[INFO] redis cache on %s, prefix %s, ttl %v", opts.Addr, opts.Prefix, opts.TTL)
+	return &RedisCache{Opts: opts, client: client}, nil
+}
+
+// Get returns value from redis or loads it with fn and stores in redis.
+// Redis errors only logged, in this case value loaded with fn, so unavailable redis doesn't break the service.
+func (c *RedisCache) Get(key cache.Key, fn func() ([]byte, error)) (data []byte, err error) {
+	keyStr := key.String()
+	data, err = c.client.Get(c.valueKey(keyStr)).Bytes()
+	if err == nil {
+		return data, nil
+	}
+	if err != redis.Nil {
+		log.Printf("[WARN] can't get %s from redis, %v", keyStr, err)
+	}
+
+	if data, err = fn(); err != nil {
+		return data, err
+	}
+	if c.MaxValue > 0 && len(data) > c.MaxValue {
+		return data, nil
+	}
+	if e := c.set(keyStr, data); e != nil {
+		log.Printf("[WARN] can't set %s in redis, %v", keyStr, e)
+	}
+	return data, nil
+}
+
+// Flush evicts values of requested scopes, or all values made by this cache if no scopes requested.
+// Never flushes the whole redis db, keys of other applications are safe.
+func (c *RedisCache) Flush(req cache.FlusherRequest) {
+	scopes, err := c.client.SMembers(c.scopesKey()).Result()
+	if err != nil {
+		log.Printf("[WARN] can't get cache scopes from redis, %v", err)
+		return
+	}
+
+	purge, matched := matchScopes(req, scopes)
+	if purge {
+		if err = c.purge(); err != nil {
+			log.Printf("[WARN] can't purge redis cache, %v", err)
+		}
+		return
+	}
+	for _, s := range matched {
+		if err = c.flushScope(s); err != nil {
+			log.Printf("[WARN] can't flush redis cache scope %s, %v", s, err)
+		}
+	}
+}
+
+// takeScript implements generic cell rate algorithm, the key keeps theoretical arrival time of the next event.
+// Returns 0 if event allowed or time to wait, all times in milliseconds.
+var takeScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
+local tat = tonumber(redis.call("GET", KEYS[1]) or now)
+if tat < now then tat = now end
+local next = tat + interval
+local wait = next - now - burst * interval
+if wait > 0 then return wait end
+redis.call("SET", KEYS[1], next, "PX", next - now)
+return 0
+`)
+
+// Take consumes one event of the key allowed each interval with burst of events at once, implements rate limit store
+// shared by all nodes. Time of the calling node used, so clocks of nodes expected to be in sync.
+func (c *RedisCache) Take(key string, interval time.Duration, burst int) (allowed bool, retryAfter time.Duration, err error) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	wait, err := takeScript.Run(c.client, []string{c.rateKey(key)}, now, interval.Milliseconds(), burst).Int64()
+	if err != nil {
+		return false, 0, errors.Wrapf(err, "can't take rate limit of %s", key)
+	}
+	return wait == 0, time.Duration(wait) * time.Millisecond, nil
+}
+
+// Peek returns number of events of the key allowed right now and time until the whole burst restored,
+// implements rate limit store shared by all nodes
+func (c *RedisCache) Peek(key string, interval time.Duration, burst int) (remaining int, reset time.Duration, err error) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	tat, err := c.client.Get(c.rateKey(key)).Int64()
+	if err == redis.Nil || (err == nil && tat < now) {
+		return burst, 0, nil
+	}
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "can't get rate limit of %s", key)
+	}
+	ahead := time.Duration(tat-now) * time.Millisecond
+	if remaining = int((time.Duration(burst)*interval - ahead) / interval); remaining < 0 {
+		remaining = 0
+	}
+	return remaining, ahead, nil
+}
+
+// Ping checks connection to redis, used by readiness probe
+func (c *RedisCache) Ping() error {
+	return errors.Wrapf(c.client.Ping().Err(), "can't ping redis %s", c.Addr)
+}
+
+// Close redis client
+func (c *RedisCache) Close() error {
+	return c.client.Close()
+}
+
+// set stores value and adds the key to sets of all its scopes
+func (c *RedisCache) set(keyStr string, data []byte) error {
+	_, err := c.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Set(c.valueKey(keyStr), data, c.TTL)
+		for _, s := range keyScopes(keyStr) {
+			pipe.SAdd(c.scopeKey(s), keyStr)
+			pipe.Expire(c.scopeKey(s), c.TTL) // all values of the scope expired by this time
+			pipe.SAdd(c.scopesKey(), s)
+		}
+		pipe.Expire(c.scopesKey(), c.TTL)
+		return nil
+	})
+	return err
+}
+
+// flushScope deletes all values of the scope. The scope's set renamed first, so keys added concurrently
+// go to the new set and not lost.
+func (c *RedisCache) flushScope(scope string) error {
+	tmpKey := c.scopeKey(scope) + ":flush:" + time.Now().Format(time.RFC3339Nano)
+	if err := c.client.Rename(c.scopeKey(scope), tmpKey).Err(); err != nil {
+		if strings.Contains(err.Error(), "no such key") {
+			return c.client.SRem(c.scopesKey(), scope).Err()
+		}
+		return errors.Wrap(err, "can't rename scope set")
+	}
+	keys, err := c.client.SMembers(tmpKey).Result()
+	if err != nil {
+		return errors.Wrap(err, "can't get keys of scope")
+	}
+	toDelete := make([]string, 0, len(keys)+1)
+	for _, k := range keys {
+		toDelete = append(toDelete, c.valueKey(k))
+	}
+	toDelete = append(toDelete, tmpKey)
+	return c.client.Del(toDelete...).Err()
+}
+
+// purge deletes all keys with cache prefix
+func (c *RedisCache) purge() error {
+	var cursor uint64
+	for {
+		keys, next, err := c.client.Scan(cursor, c.Prefix+"*", 1000).Result()
+		if err != nil {
+			return errors.Wrap(err, "can't scan keys")
+		}
+		if len(keys) > 0 {
+			if err = c.client.Del(keys...).Err(); err != nil {
+				return errors.Wrap(err, "can't delete keys")
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+func (c *RedisCache) valueKey(keyStr string) string { return c.Prefix + "v:" + keyStr }
+func (c *RedisCache) scopeKey(scope string) string  { return c.Prefix + "s:" + scope }
+func (c *RedisCache) scopesKey() string             { return c.Prefix + "scopes" }
+func (c *RedisCache) rateKey(key string) string     { return c.Prefix + "r:" + key }
+
+// keyScopes extracts scopes from key string made as <partition>@@<id>@@<scope1>$$<scope2>...
+func keyScopes(keyStr string) []string {
+	elems := strings.Split(keyStr, "@@")
+	if len(elems) != 3 || elems[2] == "" {
+		return nil
+	}
+	return strings.Split(elems[2], "$$")
+}
+
+// matchScopes returns scopes requested by FlusherRequest, purge is true if the request has no scopes.
+// FlusherRequest doesn't expose scopes, so the request applied to a throwaway cache with a key per known scope,
+// plus a key without scopes evicted on purge only.
+func matchScopes(req cache.FlusherRequest, scopes []string) (purge bool, matched []string) {
+	probe, err := cache.NewLruCache(cache.MaxKeys(len(scopes) + 1))
+	if err != nil {
+		return true, nil
+	}
+	sentinel := cache.NewKey().ID("purge").String()
+	_, _ = probe.Get(sentinel, func() (interface{}, error) { return []byte{}, nil })
+	for _, s := range scopes {
+		_, _ = probe.Get(cache.NewKey().ID(s).Scopes(s).String(), func() (interface{}, error) { return []byte{}, nil })
+	}
+	cache.NewScache(probe).Flush(req)
+	if _, ok := probe.Peek(sentinel); !ok {
+		return true, nil
+	}
+	for _, s := range scopes {
+		if _, ok := probe.Peek(cache.NewKey().ID(s).Scopes(s).String()); !ok {
+			matched = append(matched, s)
+		}
+	}
+	return false, matched
+}
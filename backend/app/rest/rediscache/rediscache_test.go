@@ -0,0 +1,221 @@
+package rediscache
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	cache "github.com/go-pkgz/lcw"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisCache_GetShared(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	// two nodes sharing the same redis
+	c1, err := New(Opts{Addr: srv.Addr()})
+	require.NoError(t, err)
+	defer c1.Close()
+	c2, err := New(Opts{Addr: srv.Addr()})
+	require.NoError(t, err)
+	defer c2.Close()
+
+	var loads int32
+	loadFn := func(val string) func() ([]byte, error) {
+		return func() ([]byte, error) {
+			atomic.AddInt32(&loads, 1)
+			return []byte(val), nil
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		key := cache.NewKey("site").ID(fmt.Sprintf("key-%d", i)).Scopes("site", fmt.Sprintf("post-%d", i%2))
+		res, err := c1.Get(key, loadFn(fmt.Sprintf("val-%d", i)))
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("val-%d", i), string(res))
+	}
+	for i := 0; i < 10; i++ {
+		key := cache.NewKey("site").ID(fmt.Sprintf("key-%d", i)).Scopes("site", fmt.Sprintf("post-%d", i%2))
+		res, err := c2.Get(key, loadFn("bad"))
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("val-%d", i), string(res))
+	}
+	assert.Equal(t, int32(10), atomic.LoadInt32(&loads))
+
+	ttl := srv.TTL("remark42:cache:v:" + cache.NewKey("site").ID("key-1").Scopes("site", "post-1").String())
+	assert.Equal(t, 10*time.Minute, ttl)
+
+	// errors not cached
+	_, err = c1.Get(cache.NewKey("site").ID("err"), func() ([]byte, error) { return nil, errors.New("failed") })
+	assert.EqualError(t, err, "failed")
+	res, err := c2.Get(cache.NewKey("site").ID("err"), func() ([]byte, error) { return []byte("ok"), nil })
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(res))
+}
+
+func TestRedisCache_Flush(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+	require.NoError(t, srv.Set("other-app", "val"))
+
+	c1, err := New(Opts{Addr: srv.Addr(), Prefix: "rc:"})
+	require.NoError(t, err)
+	defer c1.Close()
+	c2, err := New(Opts{Addr: srv.Addr(), Prefix: "rc:"})
+	require.NoError(t, err)
+	defer c2.Close()
+
+	keys := []cache.Key{}
+	for i := 0; i < 10; i++ {
+		key := cache.NewKey("site").ID(fmt.Sprintf("key-%d", i)).Scopes("site", fmt.Sprintf("post-%d", i%2))
+		keys = append(keys, key)
+		_, err = c1.Get(key, func() ([]byte, error) { return []byte("val"), nil })
+		require.NoError(t, err)
+	}
+	noScopes := cache.NewKey("site").ID("no-scopes")
+	_, err = c1.Get(noScopes, func() ([]byte, error) { return []byte("val"), nil })
+	require.NoError(t, err)
+
+	cached := func(key cache.Key) bool {
+		res, e := c2.Get(key, func() ([]byte, error) { return nil, errors.New("not cached") })
+		return e == nil && string(res) == "val"
+	}
+
+	// flush on one node visible to another
+	c2.Flush(cache.Flusher("site").Scopes("post-1"))
+	for i, k := range keys {
+		assert.Equal(t, i%2 == 0, cached(k), "key-%d", i)
+	}
+	assert.True(t, cached(noScopes))
+
+	c1.Flush(cache.Flusher("site").Scopes("unknown"))
+	assert.True(t, cached(keys[0]))
+
+	c1.Flush(cache.Flusher("site").Scopes("site"))
+	for _, k := range keys {
+		assert.False(t, cached(k))
+	}
+	assert.True(t, cached(noScopes))
+
+	_, err = c1.Get(keys[0], func() ([]byte, error) { return []byte("val"), nil })
+	require.NoError(t, err)
+	c1.Flush(cache.Flusher("site"))
+	assert.False(t, cached(keys[0]))
+	assert.False(t, cached(noScopes))
+
+	val, err := srv.Get("other-app")
+	require.NoError(t, err)
+	assert.Equal(t, "val", val, "keys without prefix not touched")
+}
+
+func TestRedisCache_MaxValue(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	c, err := New(Opts{Addr: srv.Addr(), MaxValue: 5})
+	require.NoError(t, err)
+	defer c.Close()
+
+	var loads int32
+	for i := 0; i < 2; i++ {
+		res, err := c.Get(cache.NewKey().ID("big"), func() ([]byte, error) {
+			atomic.AddInt32(&loads, 1)
+			return []byte("1234567890"), nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "1234567890", string(res))
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&loads), "big value not cached")
+}
+
+func TestRedisCache_Failed(t *testing.T) {
+	_, err := New(Opts{})
+	assert.EqualError(t, err, "redis address is required")
+
+	_, err = New(Opts{Addr: "127.0.0.1:1", Timeout: 100 * time.Millisecond})
+	assert.Error(t, err)
+
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	c, err := New(Opts{Addr: srv.Addr(), Timeout: 100 * time.Millisecond})
+	require.NoError(t, err)
+	defer c.Close()
+	assert.NoError(t, c.Ping())
+	srv.Close()
+	assert.Error(t, c.Ping())
+
+	// redis is down, values loaded directly
+	res, err := c.Get(cache.NewKey().ID("k1"), func() ([]byte, error) { return []byte("val"), nil })
+	require.NoError(t, err)
+	assert.Equal(t, "val", string(res))
+	c.Flush(cache.Flusher("").Scopes("s1"))
+}
+
+func TestMatchScopes(t *testing.T) {
+	purge, matched := matchScopes(cache.Flusher("site"), []string{"s1", "s2"})
+	assert.True(t, purge)
+	assert.Nil(t, matched)
+
+	purge, matched = matchScopes(cache.Flusher("site").Scopes("s2", "s3"), []string{"s1", "s2"})
+	assert.False(t, purge)
+	assert.Equal(t, []string{"s2"}, matched)
+
+	purge, matched = matchScopes(cache.Flusher("site").Scopes("s1"), nil)
+	assert.False(t, purge)
+	assert.Nil(t, matched)
+}
+
+func TestRedisCache_Take(t *testing.T) {
+	srv, err := miniredis.Run()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	// two nodes sharing the same limits
+	c1, err := New(Opts{Addr: srv.Addr()})
+	require.NoError(t, err)
+	defer c1.Close()
+	c2, err := New(Opts{Addr: srv.Addr()})
+	require.NoError(t, err)
+	defer c2.Close()
+
+	allowed, _, err := c1.Take("user!!site!!user1", time.Minute, 2)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	allowed, _, err = c2.Take("user!!site!!user1", time.Minute, 2)
+	require.NoError(t, err)
+	assert.True(t, allowed, "burst of 2")
+	allowed, wait, err := c1.Take("user!!site!!user1", time.Minute, 2)
+	require.NoError(t, err)
+	assert.False(t, allowed, "limit reached on another node")
+	assert.True(t, wait > 59*time.Second && wait <= time.Minute, wait)
+	assert.True(t, srv.Exists("remark42:cache:r:user!!site!!user1"))
+	remaining, reset, err := c2.Peek("user!!site!!user1", time.Minute, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 0, remaining)
+	assert.True(t, reset > 119*time.Second && reset <= 2*time.Minute, reset)
+
+	allowed, _, err = c2.Take("user!!site!!user2", time.Minute, 2)
+	require.NoError(t, err)
+	assert.True(t, allowed, "other key")
+	remaining, _, err = c1.Peek("user!!site!!user2", time.Minute, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 1, remaining)
+	remaining, reset, err = c1.Peek("user!!site!!user3", time.Minute, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 2, remaining, "not used")
+	assert.Equal(t, time.Duration(0), reset)
+
+	srv.Close()
+	_, _, err = c1.Take("user!!site!!user1", time.Minute, 2)
+	assert.Error(t, err, "redis down")
+	_, _, err = c1.Peek("user!!site!!user1", time.Minute, 2)
+	assert.Error(t, err, "redis down")
+}
@@ -0,0 +1,364 @@
+// Package saml implements SAML 2.0 service provider as auth provider, so enterprise identity providers,
+// like ADFS, Okta or Shibboleth, can be used to login. Login redirects user to the identity provider,
+// signed assertion posted back to callback url is mapped to the user and issued the same JWT as other providers.
+package saml
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1" //nolint:gosec // used for user id hashing only, like other providers
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-pkgz/auth/logger"
+	"github.com/go-pkgz/auth/provider"
+	"github.com/go-pkgz/auth/token"
+	"github.com/go-pkgz/rest"
+	"github.com/pkg/errors"
+)
+
+// Name of the provider, used in auth urls and as prefix of user ids
+const Name = "saml"
+
+// AdminFlag is a bool attribute of the user granted admin rights by identity provider
+const AdminFlag = "saml_admin"
+
+const requestTTL = 30 * time.Minute
+
+// Params of SAML provider
+type Params struct {
+	URL         string                 // root url of remark42, metadata and callback urls made from it
+	IDPMetadata *saml.EntityDescriptor // metadata of identity provider
+	Cert        *x509.Certificate      // certificate of service provider, published in metadata
+	Key         *rsa.PrivateKey        // private key of service provider, decrypts encrypted assertions
+
+	IDAttr     string // attribute with user id, name id of subject used if empty
+	NameAttr   string // attribute with user name
+	AvatarAttr string // attribute with avatar url
+	EmailAttr  string // attribute with user email
+	AdminAttr  string // attribute granting admin rights, i.e. groups, ignored if empty
+	AdminValue string // value of admin attribute granting admin rights
+
+	Issuer       string // issuer of JWT
+	TokenService provider.TokenService
+	AvatarSaver  provider.AvatarSaver
+	L            logger.L
+}
+
+// Provider is SAML service provider implementing auth provider
+type Provider struct {
+	Params
+	sp *saml.ServiceProvider
+
+	lock     sync.Mutex
+	requests map[string]authRequest // pending requests by relay state
+}
+
+// authRequest is a login waiting for response of identity provider
+type authRequest struct {
+	id      string // id of SAML request, response should be in reply to it
+	from    string
+	site    string
+	session bool
+	expires time.Time
+}
+
+// New makes SAML provider with metadata url <URL>/auth/saml/metadata and callback url <URL>/auth/saml/callback
+func New(p Params) (*Provider, error) {
+	if p.IDPMetadata == nil || p.Cert == nil || p.Key == nil {
+		return nil, errors.New("identity provider metadata, certificate and key required")
+	}
+	root, err := url.Parse(strings.TrimSuffix(p.URL, "/") + "/auth/" + Name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't parse url %s", p.URL)
+	}
+	if p.L == nil {
+		p.L = logger.NoOp
+	}
+	res := &Provider{Params: p, requests: map[string]authRequest{}}
+	res.sp = &saml.ServiceProvider{
+		Key:               p.Key,
+		Certificate:       p.Cert,
+		MetadataURL:       *root.ResolveReference(&url.URL{Path: root.Path + "/metadata"}),
+		AcsURL:            *root.ResolveReference(&url.URL{Path: root.Path + "/callback"}),
+		SloURL:            *root.ResolveReference(&url.URL{Path: root.Path + "/logout"}),
+		IDPMetadata:       p.IDPMetadata,
+		AllowIDPInitiated: false, // login started by remark42 only, it knows site and return url
+	}
+	if res.sp.GetSSOBindingLocation(saml.HTTPRedirectBinding) == "" {
+		return nil, errors.New("identity provider has no sso location with redirect binding")
+	}
+	return res, nil
+}
+
+// Name of the provider
+func (p *Provider) Name() string { return Name }
+
+// LoginHandler redirects user to identity provider with authentication request, keeping request to check the response.
+// GET /login?site=site-id&from=url&session=[0|1]
+func (p *Provider) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	req, err := p.sp.MakeAuthenticationRequest(p.sp.GetSSOBindingLocation(saml.HTTPRedirectBinding))
+	if err != nil {
+		rest.SendErrorJSON(w, r, p.L, http.StatusInternalServerError, err, "failed to make authentication request")
+		return
+	}
+	state, err := randToken()
+	if err != nil {
+		rest.SendErrorJSON(w, r, p.L, http.StatusInternalServerError, err, "failed to make relay state")
+		return
+	}
+	session := r.URL.Query().Get("session") != "" && r.URL.Query().Get("session") != "0"
+	p.track(state, authRequest{id: req.ID, from: r.URL.Query().Get("from"), site: r.URL.Query().Get("site"),
+		session: session, expires: time.Now().Add(requestTTL)})
+
+	loginURL := req.Redirect(state)
+	p.L.Logf("[DEBUG] login with %s, request %s", Name, req.ID)
+	http.Redirect(w, r, loginURL.String(), http.StatusFound)
+}
+
+// AuthHandler validates response of identity provider, sets token of the user and redirects to "from" url.
+// Response posted by browser to callback url.
+// POST /callback
+func (p *Provider) AuthHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		rest.SendErrorJSON(w, r, p.L, http.StatusBadRequest, err, "failed to parse response")
+		return
+	}
+	req, ok := p.pop(r.PostForm.Get("RelayState"))
+	if !ok {
+		rest.SendErrorJSON(w, r, p.L, http.StatusForbidden, nil, "unexpected relay state")
+		return
+	}
+	assertion, err := p.sp.ParseResponse(r, []string{req.id})
+	if err != nil {
+		if ire, ok := err.(*saml.InvalidResponseError); ok {
+			err = ire.PrivateErr
+		}
+		rest.SendErrorJSON(w, r, p.L, http.StatusForbidden, err, "invalid response")
+		return
+	}
+
+	u, err := p.mapUser(assertion)
+	if err != nil {
+		rest.SendErrorJSON(w, r, p.L, http.StatusForbidden, err, "failed to map user")
+		return
+	}
+	if p.AvatarSaver != nil {
+		avatarURL, e := p.AvatarSaver.Put(u, &http.Client{Timeout: 10 * time.Second})
+		if e != nil {
+			rest.SendErrorJSON(w, r, p.L, http.StatusInternalServerError, e, "failed to save avatar to proxy")
+			return
+		}
+		u.Picture = avatarURL
+	}
+
+	cid, err := randToken()
+	if err != nil {
+		rest.SendErrorJSON(w, r, p.L, http.StatusInternalServerError, err, "failed to make claim's id")
+		return
+	}
+	claims := token.Claims{
+		User: &u,
+		StandardClaims: jwt.StandardClaims{
+			Issuer:   p.Issuer,
+			Id:       cid,
+			Audience: req.site,
+		},
+		SessionOnly: req.session,
+	}
+	if _, err = p.TokenService.Set(w, claims); err != nil {
+		rest.SendErrorJSON(w, r, p.L, http.StatusInternalServerError, err, "failed to set token")
+		return
+	}
+	p.L.Logf("[DEBUG] user info %+v", u)
+
+	if req.from != "" {
+		http.Redirect(w, r, req.from, http.StatusSeeOther) // response posted, return url should be get
+		return
+	}
+	rest.RenderJSON(w, &u)
+}
+
+// LogoutHandler resets token of the user, session of identity provider kept.
+// GET /logout
+func (p *Provider) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if _, _, err := p.TokenService.Get(r); err != nil {
+		rest.SendErrorJSON(w, r, p.L, http.StatusForbidden, err, "logout not allowed")
+		return
+	}
+	p.TokenService.Reset(w)
+}
+
+// MetadataHandler returns metadata of service provider, used to register it in identity provider.
+// GET /metadata
+func (p *Provider) MetadataHandler(w http.ResponseWriter, _ *http.Request) {
+	buf, err := xml.MarshalIndent(p.sp.Metadata(), "", "  ")
+	if err != nil {
+		http.Error(w, "can't make metadata", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	_, _ = w.Write(buf)
+}
+
+// mapUser makes user from attributes of the assertion, id hashed from name id or id attribute
+func (p *Provider) mapUser(a *saml.Assertion) (token.User, error) {
+	values := attributes(a)
+	first := func(name string) string {
+		if vv := values[name]; len(vv) > 0 {
+			return vv[0]
+		}
+		return ""
+	}
+
+	id := first(p.IDAttr)
+	if p.IDAttr == "" && a.Subject != nil && a.Subject.NameID != nil {
+		id = a.Subject.NameID.Value
+	}
+	if id == "" {
+		return token.User{}, errors.New("no user id in assertion")
+	}
+
+	u := token.User{
+		ID:      Name + "_" + token.HashID(sha1.New(), id), //nolint:gosec // not used for security
+		Name:    first(p.NameAttr),
+		Picture: first(p.AvatarAttr),
+		Email:   first(p.EmailAttr),
+	}
+	if u.Name == "" {
+		u.Name = "noname_" + u.ID[len(Name)+1:len(Name)+5]
+	}
+	if p.AdminAttr != "" {
+		for _, v := range values[p.AdminAttr] {
+			if strings.EqualFold(v, p.AdminValue) {
+				u.SetAdmin(true)
+				u.SetBoolAttr(AdminFlag, true)
+				break
+			}
+		}
+	}
+	return u, nil
+}
+
+// attributes returns values of all attributes of the assertion by name and friendly name
+func attributes(a *saml.Assertion) map[string][]string {
+	res := map[string][]string{}
+	for _, st := range a.AttributeStatements {
+		for _, attr := range st.Attributes {
+			for _, v := range attr.Values {
+				if attr.Name != "" {
+					res[attr.Name] = append(res[attr.Name], v.Value)
+				}
+				if attr.FriendlyName != "" && attr.FriendlyName != attr.Name {
+					res[attr.FriendlyName] = append(res[attr.FriendlyName], v.Value)
+				}
+			}
+		}
+	}
+	return res
+}
+
+// track keeps pending request and drops expired ones
+func (p *Provider) track(state string, req authRequest) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	now := time.Now()
+	for k, v := range p.requests {
+		if now.After(v.expires) {
+			delete(p.requests, k)
+		}
+	}
+	p.requests[state] = req
+}
+
+// pop returns pending request of relay state, request can be used once
+func (p *Provider) pop(state string) (authRequest, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	req, ok := p.requests[state]
+	delete(p.requests, state)
+	if !ok || time.Now().After(req.expires) {
+		return authRequest{}, false
+	}
+	return req, true
+}
+
+// LoadMetadata loads metadata of identity provider from url or file
+func LoadMetadata(ctx context.Context, client *http.Client, location string) (*saml.EntityDescriptor, error) {
+	var data []byte
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't make metadata request for %s", location)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't get metadata from %s", location)
+		}
+		defer resp.Body.Close() // nolint
+		if resp.StatusCode != http.StatusOK {
+			return nil, errors.Errorf("can't get metadata from %s, status %s", location, resp.Status)
+		}
+		if data, err = ioutil.ReadAll(resp.Body); err != nil {
+			return nil, errors.Wrapf(err, "can't read metadata from %s", location)
+		}
+	} else {
+		var err error
+		if data, err = ioutil.ReadFile(location); err != nil { // nolint:gosec // file from options
+			return nil, errors.Wrapf(err, "can't read metadata file %s", location)
+		}
+	}
+
+	md := saml.EntityDescriptor{}
+	err := xml.Unmarshal(data, &md)
+	if err == nil {
+		return &md, nil
+	}
+	// metadata of some providers wrapped in EntitiesDescriptor
+	entities := saml.EntitiesDescriptor{}
+	if e := xml.Unmarshal(data, &entities); e != nil {
+		return nil, errors.Wrapf(err, "can't parse metadata from %s", location)
+	}
+	for i := range entities.EntityDescriptors {
+		if len(entities.EntityDescriptors[i].IDPSSODescriptors) > 0 {
+			return &entities.EntityDescriptors[i], nil
+		}
+	}
+	return nil, errors.Errorf("no identity provider in metadata from %s", location)
+}
+
+// LoadKeyPair loads PEM encoded certificate and RSA private key of service provider
+func LoadKeyPair(certFile, keyFile string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "can't load key pair from %s and %s", certFile, keyFile)
+	}
+	key, ok := pair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, nil, errors.Errorf("key in %s is not rsa key", keyFile)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "can't parse certificate from %s", certFile)
+	}
+	return cert, key, nil
+}
+
+func randToken() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "can't read random bytes")
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,244 @@
+package saml
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1" //nolint:gosec // used for user id hashing only
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"encoding/xml"
+	"html"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-pkgz/auth/provider"
+	"github.com/go-pkgz/auth/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProvider_Login(t *testing.T) {
+	idp, idpTS := prepIDP(t, &saml.Session{ID: "s1", NameID: "user1@example.com",
+		CustomAttributes: []saml.Attribute{
+			{Name: "displayName", Values: []saml.AttributeValue{{Value: "User One"}}},
+			{Name: "email", Values: []saml.AttributeValue{{Value: "user1@example.com"}}},
+			{Name: "groups", Values: []saml.AttributeValue{{Value: "staff"}, {Value: "remark42-admins"}}},
+		}})
+	defer idpTS.Close()
+
+	tokenService := token.NewService(token.Opts{
+		SecretReader:   token.SecretFunc(func(string) (string, error) { return "secret", nil }),
+		TokenDuration:  time.Minute,
+		CookieDuration: time.Hour,
+		Issuer:         "remark42",
+	})
+	var p *Provider
+	router := chi.NewRouter()
+	router.Get("/auth/saml/metadata", func(w http.ResponseWriter, r *http.Request) { p.MetadataHandler(w, r) })
+	router.HandleFunc("/auth/saml/*", func(w http.ResponseWriter, r *http.Request) { provider.NewService(p).Handler(w, r) })
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	cert, key := makeKeyPair(t, "sp")
+	var err error
+	p, err = New(Params{URL: ts.URL, IDPMetadata: idp.Metadata(), Cert: cert, Key: key, NameAttr: "displayName",
+		EmailAttr: "email", AdminAttr: "groups", AdminValue: "remark42-admins", Issuer: "remark42", TokenService: tokenService})
+	require.NoError(t, err)
+	idp.ServiceProviderProvider = spProvider{p: p}
+
+	client := &http.Client{Timeout: 5 * time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	// metadata of service provider
+	resp, err := client.Get(ts.URL + "/auth/saml/metadata")
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	md := saml.EntityDescriptor{}
+	require.NoError(t, xml.Unmarshal(body, &md))
+	assert.Equal(t, ts.URL+"/auth/saml/metadata", md.EntityID)
+	assert.Equal(t, ts.URL+"/auth/saml/callback", md.SPSSODescriptors[0].AssertionConsumerServices[0].Location)
+
+	// login redirects to identity provider
+	resp, err = client.Get(ts.URL + "/auth/saml/login?site=remark&from=" + url.QueryEscape("https://example.com/post"))
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	loc := resp.Header.Get("Location")
+	assert.True(t, strings.HasPrefix(loc, idpTS.URL+"/sso?"), loc)
+
+	// identity provider returns form posted to callback
+	resp, err = client.Get(loc)
+	require.NoError(t, err)
+	body, err = ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	form := url.Values{}
+	for _, m := range regexp.MustCompile(`name="(\w+)" value="([^"]*)"`).FindAllStringSubmatch(string(body), -1) {
+		form.Set(m[1], html.UnescapeString(m[2]))
+	}
+	require.NotEmpty(t, form.Get("SAMLResponse"))
+
+	resp, err = client.PostForm(ts.URL+"/auth/saml/callback", form)
+	require.NoError(t, err)
+	body, err = ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusSeeOther, resp.StatusCode, string(body))
+	assert.Equal(t, "https://example.com/post", resp.Header.Get("Location"))
+
+	var jwtCookie string
+	for _, c := range resp.Cookies() {
+		if c.Name == "JWT" {
+			jwtCookie = c.Value
+		}
+	}
+	require.NotEmpty(t, jwtCookie)
+	claims, err := tokenService.Parse(jwtCookie)
+	require.NoError(t, err)
+	assert.Equal(t, "remark", claims.Audience)
+	assert.Equal(t, "saml_"+token.HashID(sha1.New(), "user1@example.com"), claims.User.ID)
+	assert.Equal(t, "User One", claims.User.Name)
+	assert.Equal(t, "user1@example.com", claims.User.Email)
+	assert.True(t, claims.User.IsAdmin())
+	assert.True(t, claims.User.BoolAttr(AdminFlag))
+
+	// response can't be used twice
+	resp, err = client.PostForm(ts.URL+"/auth/saml/callback", form)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestProvider_mapUser(t *testing.T) {
+	p := Provider{Params: Params{NameAttr: "cn", AdminAttr: "role", AdminValue: "admin"}}
+	a := &saml.Assertion{Subject: &saml.Subject{NameID: &saml.NameID{Value: "id1"}},
+		AttributeStatements: []saml.AttributeStatement{{Attributes: []saml.Attribute{
+			{Name: "urn:oid:2.5.4.3", FriendlyName: "cn", Values: []saml.AttributeValue{{Value: "John"}}},
+			{Name: "role", Values: []saml.AttributeValue{{Value: "user"}}},
+			{Name: "uid", Values: []saml.AttributeValue{{Value: "jdoe"}}},
+		}}}}
+	u, err := p.mapUser(a)
+	require.NoError(t, err)
+	assert.Equal(t, "saml_"+token.HashID(sha1.New(), "id1"), u.ID)
+	assert.Equal(t, "John", u.Name, "by friendly name")
+	assert.False(t, u.IsAdmin())
+
+	p.IDAttr, p.NameAttr = "uid", "displayName"
+	u, err = p.mapUser(a)
+	require.NoError(t, err)
+	assert.Equal(t, "saml_"+token.HashID(sha1.New(), "jdoe"), u.ID, "by id attribute")
+	assert.Equal(t, "noname_"+u.ID[5:9], u.Name)
+
+	p.IDAttr = "employeeNumber"
+	_, err = p.mapUser(a)
+	assert.EqualError(t, err, "no user id in assertion")
+}
+
+func TestLoadMetadata(t *testing.T) {
+	idp, idpTS := prepIDP(t, nil)
+	defer idpTS.Close()
+
+	md, err := LoadMetadata(context.Background(), http.DefaultClient, idpTS.URL+"/metadata")
+	require.NoError(t, err)
+	assert.Equal(t, idpTS.URL+"/metadata", md.EntityID)
+
+	entities, err := xml.Marshal(saml.EntitiesDescriptor{EntityDescriptors: []saml.EntityDescriptor{*idp.Metadata()}})
+	require.NoError(t, err)
+	f, err := ioutil.TempFile("", "idp-metadata")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.Write(entities)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	md, err = LoadMetadata(context.Background(), http.DefaultClient, f.Name())
+	require.NoError(t, err)
+	assert.Equal(t, idpTS.URL+"/metadata", md.EntityID, "wrapped in entities descriptor")
+
+	_, err = LoadMetadata(context.Background(), http.DefaultClient, idpTS.URL+"/bad")
+	assert.Error(t, err)
+	_, err = LoadMetadata(context.Background(), http.DefaultClient, "/tmp/no-such-file.xml")
+	assert.Error(t, err)
+}
+
+func TestLoadKeyPair(t *testing.T) {
+	cert, key := makeKeyPair(t, "sp")
+	dir, err := ioutil.TempDir("", "saml")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := dir+"/sp.crt", dir+"/sp.key"
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0600) //nolint:gocritic //octalLiteral is OK as FileMode
+	require.NoError(t, err)
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600) //nolint:gocritic //octalLiteral is OK as FileMode
+	require.NoError(t, err)
+
+	c, k, err := LoadKeyPair(certFile, keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, cert.Raw, c.Raw)
+	assert.Equal(t, key.N, k.N)
+
+	_, _, err = LoadKeyPair(certFile, dir+"/bad.key")
+	assert.Error(t, err)
+}
+
+// prepIDP makes identity provider logging in all users with the session
+func prepIDP(t *testing.T, session *saml.Session) (*saml.IdentityProvider, *httptest.Server) {
+	cert, key := makeKeyPair(t, "idp")
+	idp := &saml.IdentityProvider{Key: key, Certificate: cert, SessionProvider: sessionProvider{session: session}}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { idp.Handler().ServeHTTP(w, r) }))
+	idp.MetadataURL = mustURL(t, ts.URL+"/metadata")
+	idp.SSOURL = mustURL(t, ts.URL+"/sso")
+	return idp, ts
+}
+
+func makeKeyPair(t *testing.T, name string) (*x509.Certificate, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	tmpl := x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: name},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, key
+}
+
+func mustURL(t *testing.T, s string) url.URL {
+	u, err := url.Parse(s)
+	require.NoError(t, err)
+	return *u
+}
+
+type sessionProvider struct{ session *saml.Session }
+
+func (s sessionProvider) GetSession(w http.ResponseWriter, _ *http.Request, _ *saml.IdpAuthnRequest) *saml.Session {
+	if s.session == nil {
+		http.Error(w, "no session", http.StatusForbidden)
+	}
+	return s.session
+}
+
+type spProvider struct{ p *Provider }
+
+func (s spProvider) GetServiceProvider(_ *http.Request, id string) (*saml.EntityDescriptor, error) {
+	if id != s.p.sp.MetadataURL.String() {
+		return nil, os.ErrNotExist
+	}
+	return s.p.sp.Metadata(), nil
+}
@@ -0,0 +1,419 @@
+// Package sessions keeps sessions of users server-side, to end them after idle time set per site, to revoke them
+// and to refresh them with opaque refresh tokens. Session is identified by id of its tokens, kept by auth on refresh.
+// Session expiration slides forward on each refresh of the token, tokens of ended or revoked sessions rejected.
+package sessions
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/go-pkgz/auth/token"
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	sessionsBktName = "sessions" // keyed by id
+	usersBktName    = "users"    // index keyed by siteID!!userID!!id
+	refreshBktName  = "refresh"  // index keyed by hash of refresh token, value is id
+	metaBktName     = "meta"     // time of the first start
+)
+
+// ErrNotFound returned for unknown sessions and refresh tokens
+var ErrNotFound = errors.New("session not found")
+
+// ErrEnded returned for expired or revoked sessions
+var ErrEnded = errors.New("session ended")
+
+// Session of user, started by login
+type Session struct {
+	ID          string      `json:"id"` // id of tokens of the session
+	SiteID      string      `json:"site"`
+	UserID      string      `json:"user_id"`
+	User        *token.User `json:"user,omitempty"` // claims of the user, new token made with them on refresh
+	Created     time.Time   `json:"created"`
+	Refreshed   time.Time   `json:"refreshed"`
+	Expires     time.Time   `json:"expires"` // moved forward on each refresh
+	Revoked     bool        `json:"revoked,omitempty"`
+	RefreshHash string      `json:"refresh_hash,omitempty"`
+}
+
+// Params of sessions
+type Params struct {
+	TTL  time.Duration // idle time ending session, used for sites without own ttl
+	Keep time.Duration // ended sessions kept to reject their tokens, should cover lifetime of auth cookie
+}
+
+// Service keeps sessions in bolt db
+type Service struct {
+	SiteTTL func(siteID string) time.Duration // optional, idle time ending sessions of the site, Params.TTL used if 0
+
+	params Params
+	db     *bolt.DB
+	since  time.Time // tokens issued before it made without sessions and adopted
+}
+
+// NewService makes sessions service with persistent store
+func NewService(fileName string, options bolt.Options, params Params) (*Service, error) {
+	db, err := bolt.Open(fileName, 0600, &options) //nolint:gocritic //octalLiteral is OK as FileMode
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to make boltdb for %s", fileName)
+	}
+	res := Service{params: params, db: db}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bktName := range []string{sessionsBktName, usersBktName, refreshBktName, metaBktName} {
+			if _, e := tx.CreateBucketIfNotExists([]byte(bktName)); e != nil {
+				return errors.Wrapf(e, "failed to create top level bucket %s", bktName)
+			}
+		}
+		meta := tx.Bucket([]byte(metaBktName))
+		if v := meta.Get([]byte("since")); v != nil {
+			return res.since.UnmarshalText(v)
+		}
+		res.since = time.Now()
+		v, e := res.since.MarshalText()
+		if e != nil {
+			return e
+		}
+		return meta.Put([]byte("since"), v)
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, errors.Wrapf(err, "failed to initialize boltdb db %q buckets", fileName)
+	}
+	return &res, nil
+}
+
+// Validate checks session of the token, used by validator of auth on each request. Unknown session of a new token
+// started, expired token of active session slides session expiration forward. Tokens of revoked, ended or
+// removed sessions rejected. Nil service accepts all tokens.
+func (s *Service) Validate(claims token.Claims) bool {
+	if s == nil || claims.User == nil || claims.Id == "" {
+		return true
+	}
+	now := time.Now()
+	ok, update := false, false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		sess, e := getSession(tx, claims.Id)
+		if e != nil && e != ErrNotFound {
+			return e
+		}
+		var upd *Session
+		ok, upd = s.check(sess, claims, now)
+		update = upd != nil
+		return nil
+	})
+	if err != nil {
+		log.Printf("[WARN] can't check session %s, %v", claims.Id, err)
+		return false
+	}
+	if !update {
+		return ok
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		sess, e := getSession(tx, claims.Id)
+		if e != nil && e != ErrNotFound {
+			return e
+		}
+		var upd *Session
+		if ok, upd = s.check(sess, claims, now); upd == nil {
+			return nil
+		}
+		return putSession(tx, *upd)
+	})
+	if err != nil {
+		log.Printf("[WARN] can't update session %s, %v", claims.Id, err)
+	}
+	return ok
+}
+
+// Issue makes refresh token of the session of the token, replacing previous one. Returns the token and
+// expiration of the session.
+func (s *Service) Issue(claims token.Claims) (refreshToken string, expires time.Time, err error) {
+	if claims.User == nil || claims.Id == "" {
+		return "", time.Time{}, errors.New("token without session")
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		sess, e := getSession(tx, claims.Id)
+		if e != nil {
+			return e
+		}
+		if sess.Revoked || !sess.Expires.After(time.Now()) {
+			return ErrEnded
+		}
+		if refreshToken, e = setRefreshToken(tx, &sess); e != nil {
+			return e
+		}
+		expires = sess.Expires
+		return putSession(tx, sess)
+	})
+	return refreshToken, expires, err
+}
+
+// Refresh exchanges refresh token for new one, session expiration moved forward. Returns the session with
+// claims of the user to make new token. Refresh token can be used only once.
+func (s *Service) Refresh(refreshToken string) (sess Session, newToken string, err error) {
+	hash := hashToken(refreshToken)
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		id := tx.Bucket([]byte(refreshBktName)).Get([]byte(hash))
+		if id == nil {
+			return ErrNotFound
+		}
+		var e error
+		if sess, e = getSession(tx, string(id)); e != nil {
+			return e
+		}
+		now := time.Now()
+		if sess.Revoked || !sess.Expires.After(now) || sess.User == nil {
+			return ErrEnded
+		}
+		if newToken, e = setRefreshToken(tx, &sess); e != nil {
+			return e
+		}
+		sess.Refreshed = now
+		sess.Expires = now.Add(s.ttl(sess.SiteID))
+		return putSession(tx, sess)
+	})
+	return sess, newToken, err
+}
+
+// List returns active sessions of the user, newest first, without claims and refresh tokens
+func (s *Service) List(siteID, userID string) ([]Session, error) {
+	res := []Session{}
+	now := time.Now()
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for _, id := range userSessions(tx, siteID, userID) {
+			sess, e := getSession(tx, id)
+			if e == ErrNotFound {
+				continue
+			}
+			if e != nil {
+				return e
+			}
+			if sess.Revoked || !sess.Expires.After(now) {
+				continue
+			}
+			sess.User, sess.RefreshHash = nil, ""
+			res = append(res, sess)
+		}
+		return nil
+	})
+	sort.Slice(res, func(i, j int) bool { return res[i].Created.After(res[j].Created) })
+	return res, errors.Wrapf(err, "can't list sessions of %s", userID)
+}
+
+// Revoke session of the user, ErrNotFound if the user has no such session
+func (s *Service) Revoke(siteID, userID, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		sess, err := getSession(tx, id)
+		if err != nil {
+			return err
+		}
+		if sess.SiteID != siteID || sess.UserID != userID {
+			return ErrNotFound
+		}
+		return revoke(tx, sess)
+	})
+}
+
+// RevokeUser revokes all sessions of the user, returns number of revoked sessions
+func (s *Service) RevokeUser(siteID, userID string) (count int, err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		for _, id := range userSessions(tx, siteID, userID) {
+			sess, e := getSession(tx, id)
+			if e == ErrNotFound {
+				continue
+			}
+			if e != nil {
+				return e
+			}
+			if sess.Revoked {
+				continue
+			}
+			if e = revoke(tx, sess); e != nil {
+				return e
+			}
+			count++
+		}
+		return nil
+	})
+	if err == nil && count > 0 {
+		log.Printf("[INFO] %d sessions of %s revoked on %s", count, userID, siteID)
+	}
+	return count, errors.Wrapf(err, "can't revoke sessions of %s", userID)
+}
+
+// Cleanup removes sessions ended before the time, returns number of removed sessions
+func (s *Service) Cleanup(before time.Time) (count int, err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		ended := []Session{}
+		e := tx.Bucket([]byte(sessionsBktName)).ForEach(func(k, v []byte) error {
+			sess := Session{}
+			if json.Unmarshal(v, &sess) != nil {
+				sess = Session{ID: string(k)} // broken session removed too
+			}
+			if sess.Expires.Before(before) {
+				ended = append(ended, sess)
+			}
+			return nil
+		})
+		if e != nil {
+			return e
+		}
+		for _, sess := range ended {
+			if e = deleteSession(tx, sess); e != nil {
+				return e
+			}
+		}
+		count = len(ended)
+		return nil
+	})
+	return count, errors.Wrap(err, "can't cleanup sessions")
+}
+
+// Run removes ended sessions periodically, kept for Params.Keep to reject their tokens. Blocks till ctx canceled.
+func (s *Service) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			count, err := s.Cleanup(time.Now().Add(-s.params.Keep))
+			if err != nil {
+				log.Printf("[WARN] %v", err)
+				continue
+			}
+			if count > 0 {
+				log.Printf("[DEBUG] %d ended sessions removed", count)
+			}
+		}
+	}
+}
+
+// Close bolt store
+func (s *Service) Close() error {
+	return errors.Wrap(s.db.Close(), "failed to close sessions store")
+}
+
+// check decides if token accepted, returns updated session to save if changed. Unknown session started for
+// not expired token or adopted for token issued before sessions enabled.
+func (s *Service) check(sess Session, claims token.Claims, now time.Time) (ok bool, upd *Session) {
+	expired := !claims.VerifyExpiresAt(now.Unix(), true)
+	switch {
+	case sess.ID == "":
+		if expired && claims.IssuedAt >= s.since.Unix() {
+			return false, nil // ended and removed
+		}
+		sess = Session{ID: claims.Id, SiteID: claims.Audience, UserID: claims.User.ID, Created: now}
+	case sess.Revoked, !sess.Expires.After(now):
+		return false, nil
+	case !expired:
+		return true, nil // expiration moved on refresh of token only
+	}
+	user := *claims.User
+	sess.User = &user
+	sess.Refreshed = now
+	sess.Expires = now.Add(s.ttl(sess.SiteID))
+	return true, &sess
+}
+
+func (s *Service) ttl(siteID string) time.Duration {
+	if s.SiteTTL != nil {
+		if ttl := s.SiteTTL(siteID); ttl > 0 {
+			return ttl
+		}
+	}
+	return s.params.TTL
+}
+
+// getSession returns zero session with ErrNotFound for unknown id
+func getSession(tx *bolt.Tx, id string) (res Session, err error) {
+	data := tx.Bucket([]byte(sessionsBktName)).Get([]byte(id))
+	if data == nil {
+		return Session{}, ErrNotFound
+	}
+	return res, errors.Wrapf(json.Unmarshal(data, &res), "can't unmarshal session %s", id)
+}
+
+func putSession(tx *bolt.Tx, sess Session) error {
+	data, err := json.Marshal(sess)
+	if err != nil {
+		return errors.Wrapf(err, "can't marshal session %s", sess.ID)
+	}
+	if err = tx.Bucket([]byte(sessionsBktName)).Put([]byte(sess.ID), data); err != nil {
+		return errors.Wrapf(err, "can't put session %s", sess.ID)
+	}
+	return errors.Wrapf(tx.Bucket([]byte(usersBktName)).Put(userKey(sess), []byte{}), "can't index session %s", sess.ID)
+}
+
+func deleteSession(tx *bolt.Tx, sess Session) error {
+	if sess.RefreshHash != "" {
+		if err := tx.Bucket([]byte(refreshBktName)).Delete([]byte(sess.RefreshHash)); err != nil {
+			return errors.Wrapf(err, "can't delete refresh token of %s", sess.ID)
+		}
+	}
+	if sess.SiteID != "" {
+		if err := tx.Bucket([]byte(usersBktName)).Delete(userKey(sess)); err != nil {
+			return errors.Wrapf(err, "can't delete index of %s", sess.ID)
+		}
+	}
+	return errors.Wrapf(tx.Bucket([]byte(sessionsBktName)).Delete([]byte(sess.ID)), "can't delete session %s", sess.ID)
+}
+
+// revoke marks session revoked and ended now, kept till cleanup to reject its tokens
+func revoke(tx *bolt.Tx, sess Session) error {
+	if sess.RefreshHash != "" {
+		if err := tx.Bucket([]byte(refreshBktName)).Delete([]byte(sess.RefreshHash)); err != nil {
+			return errors.Wrapf(err, "can't delete refresh token of %s", sess.ID)
+		}
+	}
+	sess.Revoked, sess.RefreshHash, sess.Expires = true, "", time.Now()
+	return putSession(tx, sess)
+}
+
+// setRefreshToken makes new refresh token of the session, previous one removed
+func setRefreshToken(tx *bolt.Tx, sess *Session) (string, error) {
+	bkt := tx.Bucket([]byte(refreshBktName))
+	if sess.RefreshHash != "" {
+		if err := bkt.Delete([]byte(sess.RefreshHash)); err != nil {
+			return "", errors.Wrapf(err, "can't delete refresh token of %s", sess.ID)
+		}
+	}
+	rnd := make([]byte, 32)
+	if _, err := rand.Read(rnd); err != nil {
+		return "", errors.Wrap(err, "can't make refresh token")
+	}
+	res := base64.RawURLEncoding.EncodeToString(rnd)
+	sess.RefreshHash = hashToken(res)
+	return res, errors.Wrapf(bkt.Put([]byte(sess.RefreshHash), []byte(sess.ID)), "can't put refresh token of %s", sess.ID)
+}
+
+// userSessions returns ids of sessions of the user, ordered by id
+func userSessions(tx *bolt.Tx, siteID, userID string) (res []string) {
+	prefix := []byte(siteID + "!!" + userID + "!!")
+	c := tx.Bucket([]byte(usersBktName)).Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		res = append(res, string(k[len(prefix):]))
+	}
+	return res
+}
+
+func userKey(sess Session) []byte {
+	return []byte(sess.SiteID + "!!" + sess.UserID + "!!" + sess.ID)
+}
+
+// hashToken returns hash of refresh token, tokens not stored as is
+func hashToken(tkn string) string {
+	h := sha256.Sum256([]byte(tkn))
+	return hex.EncodeToString(h[:])
+}
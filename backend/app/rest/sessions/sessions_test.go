@@ -0,0 +1,180 @@
+package sessions
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-pkgz/auth/token"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestService_Validate(t *testing.T) {
+	svc, teardown := prepService(t, Params{TTL: time.Hour})
+	defer teardown()
+
+	assert.True(t, svc.Validate(claims("sess1", "user1", time.Minute)), "new session started")
+	list, err := svc.List("site1", "user1")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(list))
+	assert.Equal(t, "sess1", list[0].ID)
+	assert.Nil(t, list[0].User, "claims not listed")
+	expires := list[0].Expires
+	assert.True(t, expires.After(time.Now().Add(59*time.Minute)))
+
+	// expired token refreshed, session expiration moved
+	time.Sleep(10 * time.Millisecond)
+	assert.True(t, svc.Validate(claims("sess1", "user1", -time.Minute)))
+	list, err = svc.List("site1", "user1")
+	require.NoError(t, err)
+	assert.True(t, list[0].Expires.After(expires), "sliding expiration")
+
+	// per-site ttl
+	svc.SiteTTL = func(siteID string) time.Duration { return time.Nanosecond }
+	assert.True(t, svc.Validate(claims("sess1", "user1", -time.Minute)))
+	time.Sleep(time.Millisecond)
+	assert.False(t, svc.Validate(claims("sess1", "user1", time.Minute)), "ended by idle time of the site")
+	assert.False(t, svc.Validate(claims("sess1", "user1", -time.Minute)))
+
+	// unknown session with expired token issued after start
+	assert.False(t, svc.Validate(claims("sess2", "user1", -time.Minute)))
+	// issued before sessions enabled, adopted
+	old := claims("sess3", "user1", -time.Minute)
+	old.IssuedAt = time.Now().Add(-time.Hour).Unix()
+	svc.SiteTTL = nil
+	assert.True(t, svc.Validate(old))
+
+	assert.True(t, svc.Validate(token.Claims{StandardClaims: jwt.StandardClaims{Id: "sess4"}}), "token without user")
+	var nilSvc *Service
+	assert.True(t, nilSvc.Validate(claims("sess1", "user1", time.Minute)))
+}
+
+func TestService_Revoke(t *testing.T) {
+	svc, teardown := prepService(t, Params{TTL: time.Hour})
+	defer teardown()
+
+	for _, id := range []string{"sess1", "sess2", "sess3"} {
+		require.True(t, svc.Validate(claims(id, "user1", time.Minute)))
+	}
+	require.True(t, svc.Validate(claims("sess4", "user2", time.Minute)))
+
+	assert.Equal(t, ErrNotFound, svc.Revoke("site1", "user2", "sess1"), "session of other user")
+	require.NoError(t, svc.Revoke("site1", "user1", "sess1"))
+	assert.False(t, svc.Validate(claims("sess1", "user1", time.Minute)))
+	list, err := svc.List("site1", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(list))
+
+	count, err := svc.RevokeUser("site1", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.False(t, svc.Validate(claims("sess2", "user1", time.Minute)))
+	assert.True(t, svc.Validate(claims("sess4", "user2", time.Minute)))
+}
+
+func TestService_Refresh(t *testing.T) {
+	svc, teardown := prepService(t, Params{TTL: time.Hour})
+	defer teardown()
+
+	_, _, err := svc.Issue(claims("sess1", "user1", time.Minute))
+	assert.Equal(t, ErrNotFound, err, "session not started")
+	require.True(t, svc.Validate(claims("sess1", "user1", time.Minute)))
+	refreshToken, expires, err := svc.Issue(claims("sess1", "user1", time.Minute))
+	require.NoError(t, err)
+	assert.NotEmpty(t, refreshToken)
+	assert.True(t, expires.After(time.Now()))
+
+	sess, newToken, err := svc.Refresh(refreshToken)
+	require.NoError(t, err)
+	assert.Equal(t, "sess1", sess.ID)
+	require.NotNil(t, sess.User)
+	assert.Equal(t, "user1", sess.User.ID)
+	assert.NotEqual(t, refreshToken, newToken)
+	assert.False(t, sess.Expires.Before(expires))
+
+	_, _, err = svc.Refresh(refreshToken)
+	assert.Equal(t, ErrNotFound, err, "used once")
+
+	err = svc.db.View(func(tx *bolt.Tx) error {
+		s, e := getSession(tx, "sess1")
+		assert.NotContains(t, s.RefreshHash, newToken, "token not kept as is")
+		return e
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, svc.Revoke("site1", "user1", "sess1"))
+	_, _, err = svc.Refresh(newToken)
+	assert.Equal(t, ErrNotFound, err, "removed on revoke")
+	_, _, err = svc.Issue(claims("sess1", "user1", time.Minute))
+	assert.Equal(t, ErrEnded, err)
+}
+
+func TestService_Run(t *testing.T) {
+	svc, teardown := prepService(t, Params{TTL: time.Hour, Keep: time.Hour})
+	defer teardown()
+
+	require.True(t, svc.Validate(claims("sess1", "user1", time.Minute)))
+	require.True(t, svc.Validate(claims("sess2", "user1", time.Minute)))
+	refreshToken, _, err := svc.Issue(claims("sess2", "user1", time.Minute))
+	require.NoError(t, err)
+	err = svc.db.Update(func(tx *bolt.Tx) error {
+		s, e := getSession(tx, "sess2")
+		require.NoError(t, e)
+		s.Expires = time.Now().Add(-2 * time.Hour)
+		return putSession(tx, s)
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	svc.Run(ctx, 10*time.Millisecond)
+
+	err = svc.db.View(func(tx *bolt.Tx) error {
+		_, e := getSession(tx, "sess2")
+		assert.Equal(t, ErrNotFound, e)
+		assert.Equal(t, []string{"sess1"}, userSessions(tx, "site1", "user1"), "index cleaned")
+		return nil
+	})
+	require.NoError(t, err)
+	_, _, err = svc.Refresh(refreshToken)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestService_Since(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sessions")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	svc, err := NewService(path.Join(dir, "sessions.db"), bolt.Options{}, Params{})
+	require.NoError(t, err)
+	since := svc.since
+	require.NoError(t, svc.Close())
+
+	svc, err = NewService(path.Join(dir, "sessions.db"), bolt.Options{}, Params{})
+	require.NoError(t, err)
+	defer svc.Close()
+	assert.True(t, since.Equal(svc.since), "time of the first start kept")
+}
+
+func claims(id, userID string, ttl time.Duration) token.Claims {
+	return token.Claims{User: &token.User{ID: userID, Name: "name " + userID},
+		StandardClaims: jwt.StandardClaims{Id: id, Audience: "site1", IssuedAt: time.Now().Unix(),
+			ExpiresAt: time.Now().Add(ttl).Unix()}}
+}
+
+func prepService(t *testing.T, params Params) (*Service, func()) {
+	dir, err := ioutil.TempDir("", "sessions")
+	require.NoError(t, err)
+	svc, err := NewService(path.Join(dir, "sessions.db"), bolt.Options{}, params)
+	require.NoError(t, err)
+	return svc, func() {
+		assert.NoError(t, svc.Close())
+		_ = os.RemoveAll(dir)
+	}
+}
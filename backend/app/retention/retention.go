@@ -0,0 +1,184 @@
+// Package retention expires old comments by retention policy of the site, for privacy policies keeping comments
+// for a limited time only. Comments older than retention age of the site anonymized or deleted in background,
+// pinned comments and comments already deleted or anonymized kept as is. Expired comments can be reported
+// without changes, and the whole service can run in dry-run mode only logging what would be expired.
+package retention
+
+import (
+	"context"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/service"
+)
+
+// Action on expired comments
+type Action string
+
+// Action enum
+const (
+	Anonymize Action = "anonymize" // comment re-attributed to anonymous user, text kept
+	Delete    Action = "delete"    // comment deleted with user's data, placeholder kept for replies
+)
+
+// Store defines interface of data store with comments to expire
+type Store interface {
+	List(siteID string, limit, skip int) ([]store.PostInfo, error)
+	Find(locator store.Locator, sortMethod string, user store.User) ([]store.Comment, error)
+	Delete(locator store.Locator, commentID string, mode store.DeleteMode) error
+	AnonymizeComments(siteID, userID string, ids []string) (string, error)
+}
+
+// Params of Service
+type Params struct {
+	Interval time.Duration                                 // interval of expired comments check, 1 hour by default
+	DryRun   bool                                          // expired comments only logged, not changed
+	Sites    func() []string                               // sites to check
+	Policy   func(siteID string) (days int, action string) // retention of the site, comments kept if days is 0
+}
+
+// Expired is a comment to expire
+type Expired struct {
+	Locator   store.Locator `json:"locator"`
+	ID        string        `json:"id"`
+	UserID    string        `json:"user_id"`
+	Timestamp time.Time     `json:"time"`
+}
+
+// Report of expired comments of the site
+type Report struct {
+	SiteID   string    `json:"site"`
+	Days     int       `json:"days"` // 0 if retention disabled
+	Action   Action    `json:"action"`
+	Before   time.Time `json:"before,omitempty"` // comments made before this time expired
+	Comments []Expired `json:"comments"`
+	Pinned   int       `json:"pinned"` // number of expired pinned comments, kept as is
+	DryRun   bool      `json:"dry_run"`
+}
+
+// Service expires old comments of sites
+type Service struct {
+	Params
+	store Store
+	now   func() time.Time
+}
+
+// NewService makes retention service
+func NewService(dataStore Store, params Params) *Service {
+	if params.Interval <= 0 {
+		params.Interval = time.Hour
+	}
+	if params.Sites == nil {
+		params.Sites = func() []string { return nil }
+	}
+	if params.Policy == nil {
+		params.Policy = func(string) (int, string) { return 0, "" }
+	}
+	return &Service{Params: params, store: dataStore, now: time.Now}
+}
+
+// Report returns comments of the site expired by its retention policy, nothing changed
+func (s *Service) Report(siteID string) (Report, error) {
+	days, action := s.Policy(siteID)
+	res := Report{SiteID: siteID, Days: days, Action: Action(action), Comments: []Expired{}, DryRun: true}
+	if res.Action == "" {
+		res.Action = Anonymize
+	}
+	if res.Action != Anonymize && res.Action != Delete {
+		return res, errors.Errorf("unknown retention action %q of %s", res.Action, siteID)
+	}
+	if days <= 0 {
+		return res, nil
+	}
+	res.Before = s.now().AddDate(0, 0, -days)
+
+	posts, err := s.store.List(siteID, 0, 0)
+	if err != nil {
+		return res, errors.Wrapf(err, "can't list posts of %s", siteID)
+	}
+	for _, p := range posts {
+		comments, e := s.store.Find(store.Locator{SiteID: siteID, URL: p.URL}, "time", store.User{})
+		if e != nil {
+			return res, errors.Wrapf(e, "can't get comments of %s", p.URL)
+		}
+		for _, c := range comments {
+			if !c.Timestamp.Before(res.Before) || c.Deleted || service.IsAnonymized(c.User) {
+				continue
+			}
+			if c.Pin {
+				res.Pinned++
+				continue
+			}
+			res.Comments = append(res.Comments, Expired{Locator: c.Locator, ID: c.ID, UserID: c.User.ID,
+				Timestamp: c.Timestamp})
+		}
+	}
+	return res, nil
+}
+
+// Expire applies retention policy of the site to its expired comments, only reports them in dry-run mode.
+// Comments failed to expire logged and retried on the next check.
+func (s *Service) Expire(siteID string) (Report, error) {
+	res, err := s.Report(siteID)
+	if err != nil || len(res.Comments) == 0 {
+		return res, err
+	}
+	res.DryRun = s.DryRun
+	if s.DryRun {
+		log.Printf("[INFO] retention of %s, dry-run, %d comments made before %s would be %s", siteID,
+			len(res.Comments), res.Before.Format(time.RFC3339), res.Action.past())
+		return res, nil
+	}
+
+	if res.Action == Delete {
+		for _, c := range res.Comments {
+			if e := s.store.Delete(c.Locator, c.ID, store.HardDelete); e != nil {
+				log.Printf("[WARN] can't delete expired comment %s of %s, %v", c.ID, siteID, e)
+				continue
+			}
+			log.Printf("[INFO] audit: expired comment %s of %s deleted", c.ID, siteID)
+		}
+	} else {
+		ids := map[string][]string{} // comment ids by user id
+		for _, c := range res.Comments {
+			ids[c.UserID] = append(ids[c.UserID], c.ID)
+		}
+		for userID, userIDs := range ids {
+			if _, e := s.store.AnonymizeComments(siteID, userID, userIDs); e != nil {
+				log.Printf("[WARN] can't anonymize expired comments of %s on %s, %v", userID, siteID, e)
+			}
+		}
+	}
+	log.Printf("[INFO] retention of %s, %d comments made before %s %s, %d pinned kept", siteID, len(res.Comments),
+		res.Before.Format(time.RFC3339), res.Action.past(), res.Pinned)
+	return res, nil
+}
+
+// Run expires comments of all sites periodically, blocking
+func (s *Service) Run(ctx context.Context) {
+	log.Printf("[INFO] retention of comments checked every %v, dry-run=%v", s.Interval, s.DryRun)
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		for _, siteID := range s.Sites() {
+			if _, err := s.Expire(siteID); err != nil {
+				log.Printf("[WARN] can't expire comments of %s, %v", siteID, err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a Action) past() string {
+	if a == Delete {
+		return "deleted"
+	}
+	return "anonymized"
+}
@@ -0,0 +1,151 @@
+package retention
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/engine"
+	"github.com/umputun/remark42/backend/app/store/service"
+)
+
+var ts = time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func TestService_Report(t *testing.T) {
+	dataStore := prepStore(t)
+	days, action := 30, ""
+	svc := NewService(dataStore, Params{Policy: func(string) (int, string) { return days, action }})
+	svc.now = func() time.Time { return ts }
+
+	res, err := svc.Report("radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, Anonymize, res.Action)
+	assert.Equal(t, ts.AddDate(0, 0, -30), res.Before)
+	assert.True(t, res.DryRun)
+	assert.Equal(t, 1, res.Pinned)
+	require.Equal(t, 2, len(res.Comments))
+	assert.Equal(t, "id-1", res.Comments[0].ID)
+	assert.Equal(t, "user1", res.Comments[0].UserID)
+	assert.Equal(t, "id-2", res.Comments[1].ID)
+
+	days = 0
+	res, err = svc.Report("radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, []Expired{}, res.Comments, "retention disabled")
+
+	_, err = NewService(dataStore, Params{Policy: func(string) (int, string) { return 1, "" }}).Report("bad")
+	assert.Error(t, err)
+}
+
+func TestService_ExpireAnonymize(t *testing.T) {
+	dataStore := prepStore(t)
+	svc := NewService(dataStore, Params{Policy: func(string) (int, string) { return 30, "anonymize" }})
+	svc.now = func() time.Time { return ts }
+
+	res, err := svc.Expire("radio-t")
+	require.NoError(t, err)
+	assert.False(t, res.DryRun)
+	assert.Equal(t, 2, len(res.Comments))
+
+	c := get(t, dataStore, "id-1")
+	assert.True(t, service.IsAnonymized(c.User), c.User.ID)
+	assert.Equal(t, "", c.User.IP)
+	assert.Equal(t, "old text 1", c.Text, "text kept")
+	c2 := get(t, dataStore, "id-2")
+	assert.True(t, service.IsAnonymized(c2.User), c2.User.ID)
+	assert.NotEqual(t, c.User.ID, c2.User.ID, "anonymous user per user")
+	assert.Equal(t, "user1", get(t, dataStore, "id-3").User.ID, "pinned kept")
+	assert.Equal(t, "user1", get(t, dataStore, "id-4").User.ID, "recent kept")
+
+	res, err = svc.Expire("radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(res.Comments), "anonymized comments not expired again")
+}
+
+func TestService_ExpireDelete(t *testing.T) {
+	dataStore := prepStore(t)
+	svc := NewService(dataStore, Params{Policy: func(string) (int, string) { return 30, "delete" }})
+	svc.now = func() time.Time { return ts }
+
+	_, err := svc.Expire("radio-t")
+	require.NoError(t, err)
+	c := get(t, dataStore, "id-1")
+	assert.True(t, c.Deleted)
+	assert.Equal(t, "", c.Text)
+	assert.Equal(t, "deleted", c.User.ID)
+	assert.True(t, get(t, dataStore, "id-2").Deleted)
+	assert.False(t, get(t, dataStore, "id-3").Deleted, "pinned kept")
+	assert.False(t, get(t, dataStore, "id-4").Deleted, "recent kept")
+
+	res, err := svc.Expire("radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(res.Comments), "deleted comments not expired again")
+
+	svc.Policy = func(string) (int, string) { return 1, "archive" }
+	_, err = svc.Expire("radio-t")
+	assert.EqualError(t, err, `unknown retention action "archive" of radio-t`)
+}
+
+func TestService_ExpireDryRun(t *testing.T) {
+	dataStore := prepStore(t)
+	svc := NewService(dataStore, Params{DryRun: true, Policy: func(string) (int, string) { return 30, "delete" }})
+	svc.now = func() time.Time { return ts }
+
+	res, err := svc.Expire("radio-t")
+	require.NoError(t, err)
+	assert.True(t, res.DryRun)
+	assert.Equal(t, 2, len(res.Comments))
+	assert.False(t, get(t, dataStore, "id-1").Deleted, "nothing changed")
+}
+
+func TestService_Run(t *testing.T) {
+	dataStore := prepStore(t)
+	svc := NewService(dataStore, Params{Interval: 10 * time.Millisecond, Sites: func() []string { return []string{"radio-t"} },
+		Policy: func(string) (int, string) { return 30, "delete" }})
+	svc.now = func() time.Time { return ts }
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	svc.Run(ctx)
+	assert.True(t, get(t, dataStore, "id-1").Deleted)
+	assert.True(t, get(t, dataStore, "id-2").Deleted)
+}
+
+func get(t *testing.T, dataStore *service.DataStore, id string) store.Comment {
+	c, err := dataStore.Get(store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/1"}, id, store.User{})
+	require.NoError(t, err)
+	return c
+}
+
+// prepStore makes data store with two expired comments, expired pinned comment and recent comment
+func prepStore(t *testing.T) *service.DataStore {
+	dbFile := os.TempDir() + "/remark-retention-test.db"
+	_ = os.Remove(dbFile)
+	b, err := engine.NewBoltDB(bolt.Options{}, engine.BoltSite{FileName: dbFile, SiteID: "radio-t"})
+	require.NoError(t, err)
+	dataStore := &service.DataStore{Engine: b, AdminStore: admin.NewStaticStore("12345", nil, []string{}, ""), MaxVotes: -1}
+	t.Cleanup(func() {
+		assert.NoError(t, dataStore.Close())
+		_ = os.Remove(dbFile)
+	})
+
+	locator := store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/1"}
+	for _, c := range []store.Comment{
+		{ID: "id-1", Text: "old text 1", Timestamp: ts.AddDate(0, -3, 0), User: store.User{ID: "user1", Name: "user1", IP: "ip1"}},
+		{ID: "id-2", Text: "old text 2", Timestamp: ts.AddDate(0, -2, 0), User: store.User{ID: "user2", Name: "user2"}},
+		{ID: "id-3", Text: "old pinned", Timestamp: ts.AddDate(0, -2, 0), User: store.User{ID: "user1", Name: "user1"}, Pin: true},
+		{ID: "id-4", Text: "recent", Timestamp: ts.AddDate(0, 0, -1), User: store.User{ID: "user1", Name: "user1"}},
+	} {
+		c.Locator = locator
+		_, err = b.Create(c)
+		require.NoError(t, err)
+	}
+	return dataStore
+}
@@ -0,0 +1,190 @@
+// Package roles keeps per-site roles of admin users. Owners have full access, moderators act on comments and users
+// but can't change settings of the site or export data, viewers have read-only access to admin data.
+// Admins set on start (i.e. with ADMIN_SHARED_ID) are owners of all sites, other roles assigned by owners with admin api.
+package roles
+
+import (
+	"sort"
+	"sync"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+)
+
+// Role of the user on a site
+type Role string
+
+// enum of all roles
+const (
+	None      Role = ""
+	Owner     Role = "owner"
+	Moderator Role = "moderator"
+	Viewer    Role = "viewer"
+)
+
+// Permission required for admin action
+type Permission int
+
+// enum of all permissions, each one includes the previous ones
+const (
+	View     Permission = iota // read admin data, like pending comments, reports and audit log
+	Moderate                   // act on comments and users, like delete, block or approve
+	Manage                     // change settings of the site, export and import data, assign roles
+)
+
+// Can checks if the role has the permission
+func (r Role) Can(p Permission) bool {
+	switch r {
+	case Owner:
+		return true
+	case Moderator:
+		return p <= Moderate
+	case Viewer:
+		return p == View
+	}
+	return false
+}
+
+// Valid checks if the role is one of known roles
+func (r Role) Valid() bool {
+	return r == Owner || r == Moderator || r == Viewer
+}
+
+// Assignment of the role to user
+type Assignment struct {
+	UserID string `json:"user_id"`
+	Role   Role   `json:"role"`
+	Static bool   `json:"static,omitempty"` // owner set on start, can't be changed with api
+}
+
+// Store defines interface to keep role assignments per site
+type Store interface {
+	List(siteID string) (map[string]Role, error) // returns empty map for unknown site
+	Set(siteID, userID string, role Role) error  // None removes assignment
+}
+
+// Service provides roles of users, assignments loaded from store and cached per site
+type Service struct {
+	store  Store
+	admins func(siteID string) ([]string, error) // admins set on start, owners regardless of assignments
+
+	lock  sync.RWMutex
+	cache map[string]map[string]Role
+}
+
+// NewService makes roles service for assignments from the store. admins func returns admins set on start,
+// i.e. Admins of admin.Store, optional.
+func NewService(st Store, admins func(siteID string) ([]string, error)) *Service {
+	return &Service{store: st, admins: admins, cache: map[string]map[string]Role{}}
+}
+
+// Role returns role of the user on the site, None if not assigned. Errors of store logged.
+func (s *Service) Role(siteID, userID string) Role {
+	if s.isStatic(siteID, userID) {
+		return Owner
+	}
+	assignments, err := s.assignments(siteID)
+	if err != nil {
+		log.Printf("[WARN] can't get roles of %s, %v", siteID, err)
+		return None
+	}
+	return assignments[userID]
+}
+
+// List returns assignments of the site sorted by user id, static owners included
+func (s *Service) List(siteID string) ([]Assignment, error) {
+	assignments, err := s.assignments(siteID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't get roles of %s", siteID)
+	}
+	static, err := s.staticOwners(siteID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't get admins of %s", siteID)
+	}
+	res := make([]Assignment, 0, len(assignments)+len(static))
+	seen := map[string]bool{}
+	for _, userID := range static {
+		if !seen[userID] {
+			res = append(res, Assignment{UserID: userID, Role: Owner, Static: true})
+			seen[userID] = true
+		}
+	}
+	for userID, role := range assignments {
+		if !seen[userID] {
+			res = append(res, Assignment{UserID: userID, Role: role})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].UserID < res[j].UserID })
+	return res, nil
+}
+
+// Assign the role to the user on the site, None removes assignment. Roles of static owners can't be changed.
+func (s *Service) Assign(siteID, userID string, role Role) error {
+	if siteID == "" || userID == "" {
+		return errors.New("site and user required for role")
+	}
+	if role != None && !role.Valid() {
+		return errors.Errorf("invalid role %q", role)
+	}
+	if s.isStatic(siteID, userID) {
+		return errors.Errorf("role of %s set on start and can't be changed", userID)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.store.Set(siteID, userID, role); err != nil {
+		return errors.Wrapf(err, "can't set role of %s on %s", userID, siteID)
+	}
+	delete(s.cache, siteID) // reloaded on the next use
+	log.Printf("[INFO] role of %s on %s set to %q", userID, siteID, role)
+	return nil
+}
+
+// Flush cached assignments of the site, for changes made bypassing the service, i.e. applied by replication
+func (s *Service) Flush(siteID string) {
+	s.lock.Lock()
+	delete(s.cache, siteID)
+	s.lock.Unlock()
+}
+
+// staticOwners returns admins set on start
+func (s *Service) staticOwners(siteID string) ([]string, error) {
+	if s.admins == nil {
+		return nil, nil
+	}
+	return s.admins(siteID)
+}
+
+// isStatic checks if the user is admin set on start, errors logged
+func (s *Service) isStatic(siteID, userID string) bool {
+	admins, err := s.staticOwners(siteID)
+	if err != nil {
+		log.Printf("[WARN] can't get admins of %s, %v", siteID, err)
+		return false
+	}
+	for _, a := range admins {
+		if a == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// assignments returns roles of the site, loaded from store on the first call
+func (s *Service) assignments(siteID string) (map[string]Role, error) {
+	s.lock.RLock()
+	res, ok := s.cache[siteID]
+	s.lock.RUnlock()
+	if ok {
+		return res, nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	res, err := s.store.List(siteID)
+	if err != nil {
+		return nil, err
+	}
+	s.cache[siteID] = res
+	return res, nil
+}
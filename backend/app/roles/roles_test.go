@@ -0,0 +1,105 @@
+package roles
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+func TestRole_Can(t *testing.T) {
+	tbl := []struct {
+		role                   Role
+		view, moderate, manage bool
+	}{
+		{Owner, true, true, true},
+		{Moderator, true, true, false},
+		{Viewer, true, false, false},
+		{None, false, false, false},
+		{Role("blah"), false, false, false},
+	}
+	for _, tt := range tbl {
+		assert.Equal(t, tt.view, tt.role.Can(View), tt.role)
+		assert.Equal(t, tt.moderate, tt.role.Can(Moderate), tt.role)
+		assert.Equal(t, tt.manage, tt.role.Can(Manage), tt.role)
+	}
+}
+
+func TestService_Assign(t *testing.T) {
+	eng, err := engine.NewMemory("", "site1", "site2")
+	require.NoError(t, err)
+	s := NewService(NewEngineStore(eng), func(siteID string) ([]string, error) { return []string{"admin1"}, nil })
+
+	assert.Equal(t, Owner, s.Role("site1", "admin1"), "static owner")
+	assert.Equal(t, None, s.Role("site1", "user1"))
+
+	require.NoError(t, s.Assign("site1", "user1", Moderator))
+	require.NoError(t, s.Assign("site1", "user2", Viewer))
+	require.NoError(t, s.Assign("site2", "user1", Owner))
+	assert.Equal(t, Moderator, s.Role("site1", "user1"))
+	assert.Equal(t, Viewer, s.Role("site1", "user2"))
+	assert.Equal(t, Owner, s.Role("site2", "user1"), "roles are per site")
+
+	list, err := s.List("site1")
+	require.NoError(t, err)
+	assert.Equal(t, []Assignment{{UserID: "admin1", Role: Owner, Static: true}, {UserID: "user1", Role: Moderator},
+		{UserID: "user2", Role: Viewer}}, list)
+
+	require.NoError(t, s.Assign("site1", "user1", None))
+	assert.Equal(t, None, s.Role("site1", "user1"), "removed")
+	require.NoError(t, s.Assign("site1", "user1", None), "missing assignment removed")
+
+	assert.EqualError(t, s.Assign("site1", "admin1", Viewer), "role of admin1 set on start and can't be changed")
+	assert.EqualError(t, s.Assign("site1", "user1", Role("blah")), `invalid role "blah"`)
+	assert.Error(t, s.Assign("", "user1", Viewer))
+
+	assert.EqualError(t, s.Assign("site3", "user3", Viewer), `can't set role of user3 on site3: site "site3" not found`)
+	assert.Equal(t, None, s.Role("site3", "user2"), "store error logged")
+	_, err = s.List("site3")
+	assert.Error(t, err)
+
+	s = NewService(NewEngineStore(eng), func(string) ([]string, error) { return nil, errors.New("admins failed") })
+	assert.Equal(t, None, s.Role("site1", "admin1"))
+	_, err = s.List("site1")
+	assert.EqualError(t, err, "can't get admins of site1: admins failed")
+}
+
+func TestService_Flush(t *testing.T) {
+	eng, err := engine.NewMemory("", "site1")
+	require.NoError(t, err)
+	st := NewEngineStore(eng)
+	s := NewService(st, nil)
+	require.NoError(t, s.Assign("site1", "user1", Viewer))
+	assert.Equal(t, Viewer, s.Role("site1", "user1"))
+
+	require.NoError(t, st.Set("site1", "user1", Moderator), "changed bypassing the service")
+	assert.Equal(t, Viewer, s.Role("site1", "user1"), "cached")
+	s.Flush("site1")
+	assert.Equal(t, Moderator, s.Role("site1", "user1"))
+}
+
+func TestEngineStore(t *testing.T) {
+	eng, err := engine.NewMemory("", "site1", "site2")
+	require.NoError(t, err)
+	st := NewEngineStore(eng)
+
+	roles, err := st.List("site1")
+	require.NoError(t, err)
+	assert.Empty(t, roles)
+
+	require.NoError(t, st.Set("site1", "user1", Moderator))
+	require.NoError(t, st.Set("site1", "user2", Viewer))
+	require.NoError(t, st.Set("site2", "user1", Owner))
+	require.NoError(t, st.Set("site1", "user2", None))
+	assert.Error(t, st.Set("", "user1", Viewer))
+
+	roles, err = st.List("site1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]Role{"user1": Moderator}, roles)
+	roles, err = st.List("site2")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]Role{"user1": Owner}, roles)
+}
@@ -0,0 +1,43 @@
+package roles
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// EngineStore implements Store with records of store engine, roles kept along with comments of the site
+type EngineStore struct {
+	records engine.Records // keyed by userID
+}
+
+// NewEngineStore makes store of role assignments kept by eng
+func NewEngineStore(eng engine.Interface) *EngineStore {
+	return &EngineStore{records: engine.Records{Engine: eng, Kind: engine.Roles}}
+}
+
+// List role assignments of the site, empty map returned for site without assignments
+func (e *EngineStore) List(siteID string) (map[string]Role, error) {
+	res := map[string]Role{}
+	err := e.records.List(siteID, "", func(userID string, unmarshal func(v interface{}) error) error {
+		var role Role
+		if err := unmarshal(&role); err != nil {
+			return err
+		}
+		res[userID] = role
+		return nil
+	})
+	return res, err
+}
+
+// Set role of the user on the site, None removes assignment
+func (e *EngineStore) Set(siteID, userID string, role Role) error {
+	if siteID == "" || userID == "" {
+		return errors.New("site and user required for role")
+	}
+	if role == None {
+		_, err := e.records.Delete(siteID, userID)
+		return err
+	}
+	return e.records.Set(siteID, userID, role)
+}
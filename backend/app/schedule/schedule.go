@@ -0,0 +1,116 @@
+// Package schedule keeps per-post windows of commenting set by admins. Comments of scheduled post open at the given
+// time and close after the given number of days, independently of read-only age of the site.
+package schedule
+
+import (
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+)
+
+// Schedule of the post, zero OpenAt opens comments immediately and zero CloseAt keeps them open
+type Schedule struct {
+	SiteID     string    `json:"site"`
+	URL        string    `json:"url"`
+	OpenAt     time.Time `json:"open_at"`
+	CloseAfter int       `json:"close_after"` // days after open, 0 for never
+	CloseAt    time.Time `json:"close_at"`    // calculated from OpenAt, or from time of set if OpenAt is zero
+}
+
+// State of the post at the moment
+type State int
+
+// enum of all states
+const (
+	Open      State = iota // comments accepted
+	NotOpened              // comments not accepted yet
+	Closed                 // comments not accepted anymore
+)
+
+// Store defines interface to keep schedules per post
+type Store interface {
+	Get(siteID, url string) (sch Schedule, found bool, err error)
+	Set(sch Schedule) error
+	Delete(siteID, url string) error
+	List(siteID string) ([]Schedule, error)
+}
+
+// Service sets schedules of posts and checks state of the post
+type Service struct {
+	store Store
+	now   func() time.Time
+}
+
+// NewService makes schedule service with the store
+func NewService(st Store) *Service {
+	return &Service{store: st, now: time.Now}
+}
+
+// Set schedule of the post, comments open at openAt and close after closeAfter days.
+// Close time counted from now if openAt is zero.
+func (s *Service) Set(siteID, url string, openAt time.Time, closeAfter int) (Schedule, error) {
+	if url == "" {
+		return Schedule{}, errors.New("post url is required")
+	}
+	if closeAfter < 0 {
+		return Schedule{}, errors.Errorf("negative close after %d", closeAfter)
+	}
+	if openAt.IsZero() && closeAfter == 0 {
+		return Schedule{}, errors.New("neither open time nor close after set")
+	}
+	sch := Schedule{SiteID: siteID, URL: url, OpenAt: openAt, CloseAfter: closeAfter}
+	if closeAfter > 0 {
+		base := openAt
+		if base.IsZero() {
+			base = s.now()
+		}
+		sch.CloseAt = base.AddDate(0, 0, closeAfter)
+	}
+	if err := s.store.Set(sch); err != nil {
+		return Schedule{}, errors.Wrapf(err, "can't set schedule of %s", url)
+	}
+	return sch, nil
+}
+
+// Get schedule of the post, found false for not scheduled post
+func (s *Service) Get(siteID, url string) (Schedule, bool, error) {
+	sch, found, err := s.store.Get(siteID, url)
+	return sch, found, errors.Wrapf(err, "can't get schedule of %s", url)
+}
+
+// Delete schedule of the post, comments of the post open as usual
+func (s *Service) Delete(siteID, url string) error {
+	return errors.Wrapf(s.store.Delete(siteID, url), "can't delete schedule of %s", url)
+}
+
+// List schedules of all posts of the site
+func (s *Service) List(siteID string) ([]Schedule, error) {
+	res, err := s.store.List(siteID)
+	return res, errors.Wrapf(err, "can't list schedules of %s", siteID)
+}
+
+// Check returns state of the post with time of the next change, open time for not opened post and close time
+// for open one. Not scheduled posts are open, as well as posts with failed check. Safe to call on nil Service.
+func (s *Service) Check(siteID, url string) (state State, at time.Time) {
+	if s == nil || url == "" {
+		return Open, time.Time{}
+	}
+	sch, found, err := s.store.Get(siteID, url)
+	if err != nil {
+		log.Printf("[WARN] can't get schedule of %s, %v", url, err)
+		return Open, time.Time{}
+	}
+	if !found {
+		return Open, time.Time{}
+	}
+	now := s.now()
+	switch {
+	case !sch.OpenAt.IsZero() && now.Before(sch.OpenAt):
+		return NotOpened, sch.OpenAt
+	case !sch.CloseAt.IsZero() && !now.Before(sch.CloseAt):
+		return Closed, sch.CloseAt
+	default:
+		return Open, sch.CloseAt
+	}
+}
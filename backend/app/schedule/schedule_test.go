@@ -0,0 +1,109 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+func TestService_SetCheck(t *testing.T) {
+	var s *Service
+	state, _ := s.Check("site", "https://example.com/1")
+	assert.Equal(t, Open, state, "nil service")
+
+	s = prepService(t)
+	ts := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return ts }
+
+	_, err := s.Set("site", "", ts, 1)
+	assert.Error(t, err, "no url")
+	_, err = s.Set("site", "https://example.com/1", ts, -1)
+	assert.Error(t, err, "negative close after")
+	_, err = s.Set("site", "https://example.com/1", time.Time{}, 0)
+	assert.Error(t, err, "empty schedule")
+
+	state, _ = s.Check("site", "https://example.com/1")
+	assert.Equal(t, Open, state, "not scheduled")
+
+	openAt := ts.Add(time.Hour)
+	sch, err := s.Set("site", "https://example.com/1", openAt, 2)
+	require.NoError(t, err)
+	assert.Equal(t, openAt.AddDate(0, 0, 2), sch.CloseAt, "closed after open")
+
+	state, at := s.Check("site", "https://example.com/1")
+	assert.Equal(t, NotOpened, state)
+	assert.Equal(t, openAt, at)
+
+	ts = openAt
+	state, at = s.Check("site", "https://example.com/1")
+	assert.Equal(t, Open, state)
+	assert.Equal(t, sch.CloseAt, at)
+
+	ts = sch.CloseAt
+	state, at = s.Check("site", "https://example.com/1")
+	assert.Equal(t, Closed, state)
+	assert.Equal(t, sch.CloseAt, at)
+
+	sch, err = s.Set("site", "https://example.com/2", time.Time{}, 1)
+	require.NoError(t, err)
+	assert.Equal(t, ts.AddDate(0, 0, 1), sch.CloseAt, "closed after set")
+	state, _ = s.Check("site", "https://example.com/2")
+	assert.Equal(t, Open, state)
+	state, _ = s.Check("site2", "https://example.com/2")
+	assert.Equal(t, Open, state, "schedules per site")
+
+	res, err := s.List("site")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(res))
+	assert.Equal(t, "https://example.com/1", res[0].URL)
+	assert.Equal(t, 2, res[0].CloseAfter)
+
+	require.NoError(t, s.Delete("site", "https://example.com/1"))
+	_, found, err := s.Get("site", "https://example.com/1")
+	require.NoError(t, err)
+	assert.False(t, found)
+	state, _ = s.Check("site", "https://example.com/1")
+	assert.Equal(t, Open, state, "schedule deleted")
+
+	sch, found, err = s.Get("site", "https://example.com/2")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 1, sch.CloseAfter)
+}
+
+func TestEngineStore_Remap(t *testing.T) {
+	eng, err := engine.NewMemory("", "site1")
+	require.NoError(t, err)
+	st := NewEngineStore(eng)
+	sch := Schedule{SiteID: "site1", URL: "https://example.com/old", CloseAfter: 1}
+	require.NoError(t, st.Set(sch))
+	_, err = eng.Create(store.Comment{ID: "c1", Locator: store.Locator{SiteID: "site1", URL: sch.URL}, Text: "text",
+		User: store.User{ID: "user1"}})
+	require.NoError(t, err)
+	_, err = eng.Remap(engine.RemapRequest{Locator: store.Locator{SiteID: "site1"},
+		URLs: map[string]string{"https://example.com/old": "https://example.com/new"}})
+	require.NoError(t, err)
+
+	_, found, err := st.Get("site1", "https://example.com/old")
+	require.NoError(t, err)
+	assert.False(t, found, "moved")
+	res, found, err := st.Get("site1", "https://example.com/new")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "https://example.com/new", res.URL)
+	list, err := st.List("site1")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(list))
+	assert.Equal(t, "https://example.com/new", list[0].URL)
+}
+
+func prepService(t *testing.T) *Service {
+	eng, err := engine.NewMemory("", "site", "site1", "site2")
+	require.NoError(t, err)
+	return NewService(NewEngineStore(eng))
+}
@@ -0,0 +1,49 @@
+package schedule
+
+import (
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// EngineStore implements Store with records of store engine, schedules kept along with comments of the site
+// and moved with them by remap
+type EngineStore struct {
+	records engine.Records // keyed by url
+}
+
+// NewEngineStore makes store of schedules kept by eng
+func NewEngineStore(eng engine.Interface) *EngineStore {
+	return &EngineStore{records: engine.Records{Engine: eng, Kind: engine.Schedules}}
+}
+
+// Get schedule of the post
+func (e *EngineStore) Get(siteID, url string) (sch Schedule, found bool, err error) {
+	found, err = e.records.Get(siteID, url, &sch)
+	sch.URL = url // record of remapped post keeps the old url
+	return sch, found, err
+}
+
+// Set schedule of the post
+func (e *EngineStore) Set(sch Schedule) error {
+	return e.records.Set(sch.SiteID, sch.URL, sch)
+}
+
+// Delete schedule of the post, missing one ignored
+func (e *EngineStore) Delete(siteID, url string) error {
+	_, err := e.records.Delete(siteID, url)
+	return err
+}
+
+// List schedules of the site
+func (e *EngineStore) List(siteID string) (res []Schedule, err error) {
+	res = []Schedule{}
+	err = e.records.List(siteID, "", func(url string, unmarshal func(v interface{}) error) error {
+		sch := Schedule{}
+		if err := unmarshal(&sch); err != nil {
+			return err
+		}
+		sch.URL = url
+		res = append(res, sch)
+		return nil
+	})
+	return res, err
+}
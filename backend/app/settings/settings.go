@@ -0,0 +1,317 @@
+// Package settings keeps per-site settings changed by admins at runtime, like read-only age of posts,
+// max comment size, email notifications, score thresholds, captcha, two-factor auth of admins, math in comments,
+// lifetime of sessions, max depth of replies, translation of comments, policy for toxic comments, retention
+// of old comments and watched keywords.
+// Overrides kept in Store, sites without overrides use defaults set on start. Services read settings on each use,
+// so changes applied without restart. Settings cached for a minute, so changes made by other instances sharing
+// the store applied within it.
+package settings
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+)
+
+// toxicityPolicies are valid policies for toxic comments, empty one is the same as "none"
+var toxicityPolicies = map[string]bool{"": true, "none": true, "annotate": true, "flag": true, "hold": true}
+
+// retentionActions are valid actions on expired comments
+var retentionActions = map[string]bool{"anonymize": true, "delete": true}
+
+// Values are effective settings of a site
+type Values struct {
+	ReadOnlyAge        int      `json:"readonly_age"`        // age of post in days to turn it read-only, 0 disables
+	MaxCommentSize     int      `json:"max_comment_size"`    // max size of comment in runes
+	EmailNotifications bool     `json:"email_notifications"` // email notifications of users enabled
+	LowScore           int      `json:"low_score"`           // score threshold to collapse comment
+	CriticalScore      int      `json:"critical_score"`      // score threshold to hide comment by community
+	Captcha            bool     `json:"captcha"`             // captcha required from anonymous users
+	CaptchaScore       float64  `json:"captcha_score"`       // min captcha score of providers with scores, 0 accepts any
+	AdminTwoFactor     bool     `json:"admin_2fa"`           // admins required to enroll and verify two-factor auth
+	Math               bool     `json:"math"`                // math in comments kept as is for rendering by client
+	SessionTTL         int      `json:"session_ttl"`         // idle time in minutes ending sessions of users, 0 uses default
+	MaxReplyDepth      int      `json:"max_reply_depth"`     // max nesting level of replies, deeper replies flattened, 0 unlimited
+	Translation        bool     `json:"translation"`         // comments translated on request of readers
+	Toxicity           string   `json:"toxicity"`            // policy for toxic comments, "none", "annotate", "flag" or "hold"
+	ToxicityThreshold  float64  `json:"toxicity_threshold"`  // toxicity score of comment to flag or hold it
+	RetentionDays      int      `json:"retention_days"`      // age of comment in days to expire it, 0 keeps comments forever
+	RetentionAction    string   `json:"retention_action"`    // action on expired comments, "anonymize" or "delete"
+	Keywords           []string `json:"keywords"`            // watched keywords and phrases triggering admin alerts
+}
+
+// Overrides of default settings for a site, nil fields use defaults
+type Overrides struct {
+	ReadOnlyAge        *int      `json:"readonly_age,omitempty"`
+	MaxCommentSize     *int      `json:"max_comment_size,omitempty"`
+	EmailNotifications *bool     `json:"email_notifications,omitempty"`
+	LowScore           *int      `json:"low_score,omitempty"`
+	CriticalScore      *int      `json:"critical_score,omitempty"`
+	Captcha            *bool     `json:"captcha,omitempty"`
+	CaptchaScore       *float64  `json:"captcha_score,omitempty"`
+	AdminTwoFactor     *bool     `json:"admin_2fa,omitempty"`
+	Math               *bool     `json:"math,omitempty"`
+	SessionTTL         *int      `json:"session_ttl,omitempty"`
+	MaxReplyDepth      *int      `json:"max_reply_depth,omitempty"`
+	Translation        *bool     `json:"translation,omitempty"`
+	Toxicity           *string   `json:"toxicity,omitempty"`
+	ToxicityThreshold  *float64  `json:"toxicity_threshold,omitempty"`
+	RetentionDays      *int      `json:"retention_days,omitempty"`
+	RetentionAction    *string   `json:"retention_action,omitempty"`
+	Keywords           *[]string `json:"keywords,omitempty"` // empty list removes default keywords
+}
+
+// Store defines interface to keep overrides per site
+type Store interface {
+	Get(siteID string) (Overrides, error) // returns empty overrides for unknown site
+	Set(siteID string, overrides Overrides) error
+}
+
+// Service provides effective settings of sites, overrides loaded from store and cached
+type Service struct {
+	store    Store
+	defaults Values
+
+	lock     sync.RWMutex
+	cache    map[string]cachedValues
+	cacheTTL time.Duration
+	now      func() time.Time
+}
+
+// cachedValues are effective settings of a site with time of loading from store
+type cachedValues struct {
+	Values
+	loaded time.Time
+}
+
+// NewService makes settings service with default values. Store is optional, without it
+// defaults can't be changed.
+func NewService(st Store, defaults Values) *Service {
+	return &Service{store: st, defaults: defaults, cache: map[string]cachedValues{}, cacheTTL: time.Minute, now: time.Now}
+}
+
+// Get returns effective settings of the site. Errors of store logged and defaults returned.
+func (s *Service) Get(siteID string) Values {
+	if s.store == nil {
+		return s.defaults
+	}
+	s.lock.RLock()
+	cached, ok := s.cache[siteID]
+	s.lock.RUnlock()
+	if ok && s.now().Sub(cached.loaded) < s.cacheTTL {
+		return cached.Values
+	}
+
+	overrides, err := s.store.Get(siteID)
+	if err != nil {
+		log.Printf("[WARN] can't get settings of %s, %v", siteID, err)
+		if ok {
+			return cached.Values
+		}
+		return s.defaults
+	}
+	res := s.apply(overrides)
+	s.lock.Lock()
+	s.cache[siteID] = cachedValues{Values: res, loaded: s.now()}
+	s.lock.Unlock()
+	return res
+}
+
+// Defaults returns default settings, used by sites without overrides
+func (s *Service) Defaults() Values {
+	return s.defaults
+}
+
+// Overrides returns overrides of the site
+func (s *Service) Overrides(siteID string) (Overrides, error) {
+	if s.store == nil {
+		return Overrides{}, nil
+	}
+	return s.store.Get(siteID)
+}
+
+// Set validates and saves overrides of the site, replacing previous ones. Returns effective settings.
+// Email notifications can be enabled only if available by defaults, i.e. email configured.
+func (s *Service) Set(siteID string, overrides Overrides) (Values, error) {
+	if s.store == nil {
+		return Values{}, errors.New("runtime settings disabled")
+	}
+	if overrides.ReadOnlyAge != nil && *overrides.ReadOnlyAge < 0 {
+		return Values{}, errors.Errorf("invalid readonly_age %d", *overrides.ReadOnlyAge)
+	}
+	if overrides.MaxCommentSize != nil && *overrides.MaxCommentSize <= 0 {
+		return Values{}, errors.Errorf("invalid max_comment_size %d", *overrides.MaxCommentSize)
+	}
+	if overrides.EmailNotifications != nil && *overrides.EmailNotifications && !s.defaults.EmailNotifications {
+		return Values{}, errors.New("email notifications not available")
+	}
+	if overrides.CaptchaScore != nil && (*overrides.CaptchaScore < 0 || *overrides.CaptchaScore > 1) {
+		return Values{}, errors.Errorf("invalid captcha_score %v", *overrides.CaptchaScore)
+	}
+	if overrides.SessionTTL != nil && *overrides.SessionTTL <= 0 {
+		return Values{}, errors.Errorf("invalid session_ttl %d", *overrides.SessionTTL)
+	}
+	if overrides.MaxReplyDepth != nil && *overrides.MaxReplyDepth < 0 {
+		return Values{}, errors.Errorf("invalid max_reply_depth %d", *overrides.MaxReplyDepth)
+	}
+	if overrides.Toxicity != nil && !toxicityPolicies[*overrides.Toxicity] {
+		return Values{}, errors.Errorf("invalid toxicity %q", *overrides.Toxicity)
+	}
+	if overrides.ToxicityThreshold != nil && (*overrides.ToxicityThreshold < 0 || *overrides.ToxicityThreshold > 1) {
+		return Values{}, errors.Errorf("invalid toxicity_threshold %v", *overrides.ToxicityThreshold)
+	}
+	if overrides.RetentionDays != nil && *overrides.RetentionDays < 0 {
+		return Values{}, errors.Errorf("invalid retention_days %d", *overrides.RetentionDays)
+	}
+	if overrides.RetentionAction != nil && !retentionActions[*overrides.RetentionAction] {
+		return Values{}, errors.Errorf("invalid retention_action %q", *overrides.RetentionAction)
+	}
+	if overrides.Keywords != nil {
+		for _, k := range *overrides.Keywords {
+			if strings.TrimSpace(k) == "" {
+				return Values{}, errors.New("empty keyword")
+			}
+		}
+	}
+	if res := s.apply(overrides); res.CriticalScore > res.LowScore {
+		return Values{}, errors.Errorf("critical_score %d above low_score %d", res.CriticalScore, res.LowScore)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.store.Set(siteID, overrides); err != nil {
+		return Values{}, errors.Wrapf(err, "can't save settings of %s", siteID)
+	}
+	res := s.apply(overrides)
+	s.cache[siteID] = cachedValues{Values: res, loaded: s.now()}
+	log.Printf("[INFO] settings of %s updated, %+v", siteID, res)
+	return res, nil
+}
+
+// ReadOnlyAge returns age of post in days to turn it read-only, 0 if disabled
+func (s *Service) ReadOnlyAge(siteID string) int {
+	return s.Get(siteID).ReadOnlyAge
+}
+
+// MaxCommentSize returns max size of comment
+func (s *Service) MaxCommentSize(siteID string) int {
+	return s.Get(siteID).MaxCommentSize
+}
+
+// EmailNotifications checks if email notifications of users enabled
+func (s *Service) EmailNotifications(siteID string) bool {
+	return s.Get(siteID).EmailNotifications
+}
+
+// ScoreThresholds returns scores to collapse comment and to hide it by community
+func (s *Service) ScoreThresholds(siteID string) (low, critical int) {
+	v := s.Get(siteID)
+	return v.LowScore, v.CriticalScore
+}
+
+// Captcha returns whether captcha required from anonymous users and min captcha score
+func (s *Service) Captcha(siteID string) (enabled bool, minScore float64) {
+	v := s.Get(siteID)
+	return v.Captcha, v.CaptchaScore
+}
+
+// AdminTwoFactor checks if admins of the site required to use two-factor auth
+func (s *Service) AdminTwoFactor(siteID string) bool {
+	return s.Get(siteID).AdminTwoFactor
+}
+
+// Math checks if math in comments of the site kept as is
+func (s *Service) Math(siteID string) bool {
+	return s.Get(siteID).Math
+}
+
+// SessionTTL returns idle time ending sessions of users of the site, 0 if not set
+func (s *Service) SessionTTL(siteID string) time.Duration {
+	return time.Duration(s.Get(siteID).SessionTTL) * time.Minute
+}
+
+// MaxReplyDepth returns max nesting level of replies of the site, 0 if unlimited
+func (s *Service) MaxReplyDepth(siteID string) int {
+	return s.Get(siteID).MaxReplyDepth
+}
+
+// Translation checks if comments of the site translated on request
+func (s *Service) Translation(siteID string) bool {
+	return s.Get(siteID).Translation
+}
+
+// Toxicity returns policy for toxic comments of the site and score threshold to flag or hold them
+func (s *Service) Toxicity(siteID string) (policy string, threshold float64) {
+	v := s.Get(siteID)
+	return v.Toxicity, v.ToxicityThreshold
+}
+
+// Retention returns age of comments of the site in days to expire them, 0 if disabled, and action on them
+func (s *Service) Retention(siteID string) (days int, action string) {
+	v := s.Get(siteID)
+	return v.RetentionDays, v.RetentionAction
+}
+
+// Keywords returns watched keywords of the site triggering admin alerts
+func (s *Service) Keywords(siteID string) []string {
+	return s.Get(siteID).Keywords
+}
+
+func (s *Service) apply(overrides Overrides) Values {
+	res := s.defaults
+	if overrides.ReadOnlyAge != nil {
+		res.ReadOnlyAge = *overrides.ReadOnlyAge
+	}
+	if overrides.MaxCommentSize != nil {
+		res.MaxCommentSize = *overrides.MaxCommentSize
+	}
+	if overrides.EmailNotifications != nil {
+		res.EmailNotifications = *overrides.EmailNotifications
+	}
+	if overrides.LowScore != nil {
+		res.LowScore = *overrides.LowScore
+	}
+	if overrides.CriticalScore != nil {
+		res.CriticalScore = *overrides.CriticalScore
+	}
+	if overrides.Captcha != nil {
+		res.Captcha = *overrides.Captcha
+	}
+	if overrides.CaptchaScore != nil {
+		res.CaptchaScore = *overrides.CaptchaScore
+	}
+	if overrides.AdminTwoFactor != nil {
+		res.AdminTwoFactor = *overrides.AdminTwoFactor
+	}
+	if overrides.Math != nil {
+		res.Math = *overrides.Math
+	}
+	if overrides.SessionTTL != nil {
+		res.SessionTTL = *overrides.SessionTTL
+	}
+	if overrides.MaxReplyDepth != nil {
+		res.MaxReplyDepth = *overrides.MaxReplyDepth
+	}
+	if overrides.Translation != nil {
+		res.Translation = *overrides.Translation
+	}
+	if overrides.Toxicity != nil {
+		res.Toxicity = *overrides.Toxicity
+	}
+	if overrides.ToxicityThreshold != nil {
+		res.ToxicityThreshold = *overrides.ToxicityThreshold
+	}
+	if overrides.RetentionDays != nil {
+		res.RetentionDays = *overrides.RetentionDays
+	}
+	if overrides.RetentionAction != nil {
+		res.RetentionAction = *overrides.RetentionAction
+	}
+	if overrides.Keywords != nil {
+		res.Keywords = *overrides.Keywords
+	}
+	return res
+}
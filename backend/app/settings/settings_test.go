@@ -0,0 +1,275 @@
+package settings
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+func TestService_Get(t *testing.T) {
+	defaults := Values{ReadOnlyAge: 10, MaxCommentSize: 2048, EmailNotifications: true}
+	age, enabled := 0, false
+	st := &memStore{overrides: map[string]Overrides{"site1": {ReadOnlyAge: &age, EmailNotifications: &enabled}}}
+	s := NewService(st, defaults)
+
+	assert.Equal(t, Values{ReadOnlyAge: 0, MaxCommentSize: 2048, EmailNotifications: false}, s.Get("site1"))
+	assert.Equal(t, defaults, s.Get("site2"), "no overrides")
+	assert.Equal(t, defaults, s.Defaults())
+
+	size := 100
+	res, err := s.Set("site2", Overrides{MaxCommentSize: &size})
+	require.NoError(t, err)
+	assert.Equal(t, Values{ReadOnlyAge: 10, MaxCommentSize: 100, EmailNotifications: true}, res)
+	assert.Equal(t, 100, s.MaxCommentSize("site2"), "cache updated")
+	assert.Equal(t, 10, s.ReadOnlyAge("site2"))
+	assert.True(t, s.EmailNotifications("site2"))
+	overrides, err := s.Overrides("site2")
+	require.NoError(t, err)
+	assert.Equal(t, Overrides{MaxCommentSize: &size}, overrides)
+
+	st.err = errors.New("failed")
+	assert.Equal(t, 0, s.ReadOnlyAge("site1"), "cached")
+	assert.Equal(t, defaults, s.Get("site3"), "defaults on store error")
+	_, err = s.Set("site2", Overrides{})
+	assert.EqualError(t, err, "can't save settings of site2: failed")
+}
+
+func TestService_CacheExpiration(t *testing.T) {
+	st := &memStore{overrides: map[string]Overrides{}}
+	s := NewService(st, Values{MaxCommentSize: 2048})
+	ts := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return ts }
+	assert.Equal(t, 2048, s.MaxCommentSize("site1"))
+
+	size := 100
+	st.overrides["site1"] = Overrides{MaxCommentSize: &size} // changed by other instance
+	ts = ts.Add(30 * time.Second)
+	assert.Equal(t, 2048, s.MaxCommentSize("site1"), "cached")
+	ts = ts.Add(31 * time.Second)
+	assert.Equal(t, 100, s.MaxCommentSize("site1"), "reloaded")
+
+	st.err = errors.New("failed")
+	ts = ts.Add(time.Hour)
+	assert.Equal(t, 100, s.MaxCommentSize("site1"), "cached kept on store error")
+}
+
+func TestService_SetValidation(t *testing.T) {
+	s := NewService(&memStore{overrides: map[string]Overrides{}}, Values{MaxCommentSize: 2048, LowScore: -5, CriticalScore: -10})
+	neg, zero, enabled := -1, 0, true
+	_, err := s.Set("site1", Overrides{ReadOnlyAge: &neg})
+	assert.EqualError(t, err, "invalid readonly_age -1")
+	_, err = s.Set("site1", Overrides{MaxCommentSize: &zero})
+	assert.EqualError(t, err, "invalid max_comment_size 0")
+	_, err = s.Set("site1", Overrides{EmailNotifications: &enabled})
+	assert.EqualError(t, err, "email notifications not available")
+	_, err = s.Set("site1", Overrides{CriticalScore: &neg})
+	assert.EqualError(t, err, "critical_score -1 above low_score -5")
+	_, err = s.Set("site1", Overrides{ReadOnlyAge: &zero})
+	assert.NoError(t, err)
+}
+
+func TestService_ScoreThresholds(t *testing.T) {
+	s := NewService(&memStore{overrides: map[string]Overrides{}}, Values{LowScore: -5, CriticalScore: -10})
+	low, critical := s.ScoreThresholds("site1")
+	assert.Equal(t, -5, low)
+	assert.Equal(t, -10, critical)
+
+	newLow, newCritical := -2, -3
+	_, err := s.Set("site1", Overrides{LowScore: &newLow, CriticalScore: &newCritical})
+	require.NoError(t, err)
+	low, critical = s.ScoreThresholds("site1")
+	assert.Equal(t, -2, low)
+	assert.Equal(t, -3, critical)
+	low, _ = s.ScoreThresholds("site2")
+	assert.Equal(t, -5, low)
+}
+
+func TestService_Captcha(t *testing.T) {
+	s := NewService(&memStore{overrides: map[string]Overrides{}}, Values{Captcha: true})
+	enabled, minScore := s.Captcha("site1")
+	assert.True(t, enabled)
+	assert.Equal(t, 0.0, minScore)
+
+	disabled, score, bad := false, 0.5, 1.5
+	_, err := s.Set("site1", Overrides{CaptchaScore: &bad})
+	assert.EqualError(t, err, "invalid captcha_score 1.5")
+	_, err = s.Set("site1", Overrides{CaptchaScore: &score})
+	require.NoError(t, err)
+	enabled, minScore = s.Captcha("site1")
+	assert.True(t, enabled)
+	assert.Equal(t, 0.5, minScore)
+	_, err = s.Set("site2", Overrides{Captcha: &disabled})
+	require.NoError(t, err)
+	enabled, _ = s.Captcha("site2")
+	assert.False(t, enabled)
+}
+
+func TestService_AdminTwoFactor(t *testing.T) {
+	s := NewService(&memStore{overrides: map[string]Overrides{}}, Values{})
+	assert.False(t, s.AdminTwoFactor("site1"))
+
+	enforced := true
+	_, err := s.Set("site1", Overrides{AdminTwoFactor: &enforced})
+	require.NoError(t, err)
+	assert.True(t, s.AdminTwoFactor("site1"))
+	assert.False(t, s.AdminTwoFactor("site2"))
+}
+
+func TestService_Math(t *testing.T) {
+	s := NewService(&memStore{overrides: map[string]Overrides{}}, Values{Math: true})
+	assert.True(t, s.Math("site1"))
+
+	disabled := false
+	_, err := s.Set("site1", Overrides{Math: &disabled})
+	require.NoError(t, err)
+	assert.False(t, s.Math("site1"))
+	assert.True(t, s.Math("site2"))
+}
+
+func TestService_Translation(t *testing.T) {
+	s := NewService(&memStore{overrides: map[string]Overrides{}}, Values{})
+	assert.False(t, s.Translation("site1"))
+
+	enabled := true
+	_, err := s.Set("site1", Overrides{Translation: &enabled})
+	require.NoError(t, err)
+	assert.True(t, s.Translation("site1"))
+	assert.False(t, s.Translation("site2"))
+}
+
+func TestService_SessionTTL(t *testing.T) {
+	s := NewService(&memStore{overrides: map[string]Overrides{}}, Values{SessionTTL: 60})
+	assert.Equal(t, time.Hour, s.SessionTTL("site1"))
+
+	short, invalid := 15, 0
+	_, err := s.Set("site1", Overrides{SessionTTL: &invalid})
+	assert.EqualError(t, err, "invalid session_ttl 0")
+	_, err = s.Set("site1", Overrides{SessionTTL: &short})
+	require.NoError(t, err)
+	assert.Equal(t, 15*time.Minute, s.SessionTTL("site1"))
+	assert.Equal(t, time.Hour, s.SessionTTL("site2"))
+}
+
+func TestService_Toxicity(t *testing.T) {
+	s := NewService(&memStore{overrides: map[string]Overrides{}}, Values{Toxicity: "annotate", ToxicityThreshold: 0.8})
+	policy, threshold := s.Toxicity("site1")
+	assert.Equal(t, "annotate", policy)
+	assert.Equal(t, 0.8, threshold)
+
+	invalidPolicy, invalidThreshold := "ban", 1.5
+	_, err := s.Set("site1", Overrides{Toxicity: &invalidPolicy})
+	assert.EqualError(t, err, `invalid toxicity "ban"`)
+	_, err = s.Set("site1", Overrides{ToxicityThreshold: &invalidThreshold})
+	assert.EqualError(t, err, "invalid toxicity_threshold 1.5")
+
+	hold, lower := "hold", 0.6
+	_, err = s.Set("site1", Overrides{Toxicity: &hold, ToxicityThreshold: &lower})
+	require.NoError(t, err)
+	policy, threshold = s.Toxicity("site1")
+	assert.Equal(t, "hold", policy)
+	assert.Equal(t, 0.6, threshold)
+	policy, threshold = s.Toxicity("site2")
+	assert.Equal(t, "annotate", policy)
+	assert.Equal(t, 0.8, threshold)
+}
+
+func TestService_Retention(t *testing.T) {
+	s := NewService(&memStore{overrides: map[string]Overrides{}}, Values{RetentionAction: "anonymize"})
+	days, action := s.Retention("site1")
+	assert.Equal(t, 0, days)
+	assert.Equal(t, "anonymize", action)
+
+	invalidDays, invalidAction := -1, "archive"
+	_, err := s.Set("site1", Overrides{RetentionDays: &invalidDays})
+	assert.EqualError(t, err, "invalid retention_days -1")
+	_, err = s.Set("site1", Overrides{RetentionAction: &invalidAction})
+	assert.EqualError(t, err, `invalid retention_action "archive"`)
+
+	year, del := 365, "delete"
+	_, err = s.Set("site1", Overrides{RetentionDays: &year, RetentionAction: &del})
+	require.NoError(t, err)
+	days, action = s.Retention("site1")
+	assert.Equal(t, 365, days)
+	assert.Equal(t, "delete", action)
+	days, _ = s.Retention("site2")
+	assert.Equal(t, 0, days)
+}
+
+func TestService_Keywords(t *testing.T) {
+	s := NewService(&memStore{overrides: map[string]Overrides{}}, Values{Keywords: []string{"acme"}})
+	assert.Equal(t, []string{"acme"}, s.Keywords("site1"))
+
+	invalid, site, none := []string{"law suit", " "}, []string{"law suit", "bitcoin"}, []string{}
+	_, err := s.Set("site1", Overrides{Keywords: &invalid})
+	assert.EqualError(t, err, "empty keyword")
+	_, err = s.Set("site1", Overrides{Keywords: &site})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"law suit", "bitcoin"}, s.Keywords("site1"))
+	_, err = s.Set("site2", Overrides{Keywords: &none})
+	require.NoError(t, err)
+	assert.Empty(t, s.Keywords("site2"), "default keywords removed")
+	assert.Equal(t, []string{"acme"}, s.Keywords("site3"))
+}
+
+func TestService_MaxReplyDepth(t *testing.T) {
+	s := NewService(&memStore{overrides: map[string]Overrides{}}, Values{MaxReplyDepth: 3})
+	assert.Equal(t, 3, s.MaxReplyDepth("site1"))
+
+	unlimited, invalid := 0, -1
+	_, err := s.Set("site1", Overrides{MaxReplyDepth: &invalid})
+	assert.EqualError(t, err, "invalid max_reply_depth -1")
+	_, err = s.Set("site1", Overrides{MaxReplyDepth: &unlimited})
+	require.NoError(t, err)
+	assert.Equal(t, 0, s.MaxReplyDepth("site1"))
+	assert.Equal(t, 3, s.MaxReplyDepth("site2"))
+}
+
+func TestService_NoStore(t *testing.T) {
+	defaults := Values{ReadOnlyAge: 10, MaxCommentSize: 2048}
+	s := NewService(nil, defaults)
+	assert.Equal(t, defaults, s.Get("site1"))
+	overrides, err := s.Overrides("site1")
+	require.NoError(t, err)
+	assert.Equal(t, Overrides{}, overrides)
+	_, err = s.Set("site1", Overrides{})
+	assert.EqualError(t, err, "runtime settings disabled")
+}
+
+func TestEngineStore(t *testing.T) {
+	eng, err := engine.NewMemory("", "site1")
+	require.NoError(t, err)
+	st := NewEngineStore(eng)
+	overrides, err := st.Get("site1")
+	require.NoError(t, err)
+	assert.Equal(t, Overrides{}, overrides, "empty for site without overrides")
+
+	age, enabled := 5, false
+	require.NoError(t, st.Set("site1", Overrides{ReadOnlyAge: &age, EmailNotifications: &enabled}))
+	assert.EqualError(t, st.Set("", Overrides{}), "site id required for settings")
+	overrides, err = st.Get("site1")
+	require.NoError(t, err)
+	assert.Equal(t, Overrides{ReadOnlyAge: &age, EmailNotifications: &enabled}, overrides)
+
+	_, err = st.Get("bad")
+	assert.EqualError(t, err, `can't get settings of bad: site "bad" not found`)
+}
+
+type memStore struct {
+	overrides map[string]Overrides
+	err       error
+}
+
+func (m *memStore) Get(siteID string) (Overrides, error) { return m.overrides[siteID], m.err }
+
+func (m *memStore) Set(siteID string, overrides Overrides) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.overrides[siteID] = overrides
+	return nil
+}
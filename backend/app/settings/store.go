@@ -0,0 +1,34 @@
+package settings
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+const overridesKey = "overrides" // single record of the site
+
+// EngineStore implements Store with records of store engine, overrides kept along with comments of the site
+type EngineStore struct {
+	records engine.Records
+}
+
+// NewEngineStore makes store of settings overrides kept by eng
+func NewEngineStore(eng engine.Interface) *EngineStore {
+	return &EngineStore{records: engine.Records{Engine: eng, Kind: engine.Settings}}
+}
+
+// Get overrides of the site, empty overrides returned for site without them
+func (e *EngineStore) Get(siteID string) (Overrides, error) {
+	res := Overrides{}
+	_, err := e.records.Get(siteID, overridesKey, &res)
+	return res, errors.Wrapf(err, "can't get settings of %s", siteID)
+}
+
+// Set overrides of the site, replacing previous ones
+func (e *EngineStore) Set(siteID string, overrides Overrides) error {
+	if siteID == "" {
+		return errors.New("site id required for settings")
+	}
+	return e.records.Set(siteID, overridesKey, overrides)
+}
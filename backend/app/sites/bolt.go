@@ -0,0 +1,73 @@
+package sites
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+const sitesBktName = "sites"
+
+// BoltStore implements Store with bolt DB, sites keyed by id
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore makes persistent store of provisioned sites
+func NewBoltStore(fileName string, options bolt.Options) (*BoltStore, error) {
+	db, err := bolt.Open(fileName, 0600, &options) //nolint:gocritic //octalLiteral is OK as FileMode
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to make boltdb for %s", fileName)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, e := tx.CreateBucketIfNotExists([]byte(sitesBktName))
+		return errors.Wrapf(e, "failed to create top level bucket %s", sitesBktName)
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to initialize boltdb db %q buckets", fileName)
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// List returns all sites sorted by id
+func (b *BoltStore) List() ([]Site, error) {
+	res := []Site{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(sitesBktName)).ForEach(func(k, v []byte) error {
+			site := Site{}
+			if err := json.Unmarshal(v, &site); err != nil {
+				return errors.Wrapf(err, "can't unmarshal site %s", string(k))
+			}
+			res = append(res, site)
+			return nil
+		})
+	})
+	return res, err
+}
+
+// Set site, replacing previous one with the same id
+func (b *BoltStore) Set(site Site) error {
+	if site.ID == "" {
+		return errors.New("site id required")
+	}
+	data, err := json.Marshal(site)
+	if err != nil {
+		return errors.Wrapf(err, "can't marshal site %s", site.ID)
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(sitesBktName)).Put([]byte(site.ID), data)
+	})
+}
+
+// Delete site
+func (b *BoltStore) Delete(siteID string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(sitesBktName)).Delete([]byte(siteID))
+	})
+}
+
+// Close bolt db
+func (b *BoltStore) Close() error {
+	return b.db.Close()
+}
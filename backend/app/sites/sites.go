@@ -0,0 +1,339 @@
+// Package sites provisions sites at runtime, in addition to static sites from configuration. Each provisioned site
+// has its own secret, admins and admin email, can be disabled or deleted with all its comments. Service implements
+// admin.Store, static sites served by admin store from configuration.
+package sites
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/mail"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// Site is a provisioned or static site
+type Site struct {
+	ID         string    `json:"id"`
+	Secret     string    `json:"secret,omitempty"` // returned on creation and rotation only
+	Admins     []string  `json:"admins"`
+	AdminEmail string    `json:"admin_email,omitempty"`
+	Disabled   bool      `json:"disabled"`
+	Static     bool      `json:"static,omitempty"` // from configuration, can't be changed at runtime
+	Created    time.Time `json:"created,omitempty"`
+}
+
+// Update of provisioned site, nil fields kept as is
+type Update struct {
+	Admins     *[]string `json:"admins,omitempty"`
+	AdminEmail *string   `json:"admin_email,omitempty"`
+	Disabled   *bool     `json:"disabled,omitempty"`
+}
+
+// Store defines interface to keep provisioned sites
+type Store interface {
+	List() ([]Site, error)
+	Set(site Site) error
+	Delete(siteID string) error
+	Close() error
+}
+
+// ErrNotFound returned for unknown site
+var ErrNotFound = errors.New("site not found")
+
+// ErrStatic returned on attempt to change static site
+var ErrStatic = errors.New("static site can't be changed")
+
+// reSiteID allows letters, digits, ".", "_" and "-", site id used as file name by bolt engine
+var reSiteID = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,63}$`)
+
+const minSecretLen = 16
+
+// Service keeps provisioned sites and makes their storage. Thread safe.
+type Service struct {
+	store   Store
+	admins  admin.Store
+	storage engine.SiteManager
+	static  []string
+
+	lock    sync.RWMutex
+	sites   map[string]Site
+	created func(siteID string)
+}
+
+// NewService makes service with provisioned sites loaded from store, storage of each site opened.
+// Static sites and their admins served by admins store.
+func NewService(st Store, admins admin.Store, storage engine.SiteManager, static []string) (*Service, error) {
+	res := &Service{store: st, admins: admins, storage: storage, static: static, sites: map[string]Site{}}
+	list, err := st.List()
+	if err != nil {
+		return nil, errors.Wrap(err, "can't load sites")
+	}
+	for _, site := range list {
+		if res.isStatic(site.ID) {
+			log.Printf("[WARN] provisioned site %s ignored, the same static site configured", site.ID)
+			continue
+		}
+		if err = storage.AddSite(site.ID); err != nil {
+			return nil, errors.Wrapf(err, "can't open storage of site %s", site.ID)
+		}
+		res.sites[site.ID] = site
+	}
+	log.Printf("[INFO] %d provisioned sites, static %v", len(res.sites), static)
+	return res, nil
+}
+
+// OnCreate sets func called with id of each created site, i.e. to start its backups
+func (s *Service) OnCreate(fn func(siteID string)) {
+	s.lock.Lock()
+	s.created = fn
+	s.lock.Unlock()
+}
+
+// IDs returns ids of static and provisioned sites, disabled ones included
+func (s *Service) IDs() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	res := append([]string{}, s.static...)
+	for id := range s.sites {
+		res = append(res, id)
+	}
+	sort.Strings(res[len(s.static):])
+	return res
+}
+
+// List returns static sites followed by provisioned ones sorted by id, secrets not included
+func (s *Service) List() []Site {
+	res := make([]Site, 0, len(s.static))
+	for _, id := range s.static {
+		admins, _ := s.admins.Admins(id)
+		email, _ := s.admins.Email(id)
+		res = append(res, Site{ID: id, Admins: admins, AdminEmail: email, Static: true})
+	}
+	s.lock.RLock()
+	provisioned := make([]Site, 0, len(s.sites))
+	for _, site := range s.sites {
+		site.Secret = ""
+		provisioned = append(provisioned, site)
+	}
+	s.lock.RUnlock()
+	sort.Slice(provisioned, func(i, j int) bool { return provisioned[i].ID < provisioned[j].ID })
+	return append(res, provisioned...)
+}
+
+// Create provisions the site and makes its storage. Secret generated if not set. Returns site with the secret.
+func (s *Service) Create(site Site) (Site, error) {
+	if !reSiteID.MatchString(site.ID) {
+		return Site{}, errors.Errorf("invalid site id %q", site.ID)
+	}
+	if site.Secret == "" {
+		site.Secret = newSecret()
+	}
+	if len(site.Secret) < minSecretLen {
+		return Site{}, errors.Errorf("secret should be at least %d characters", minSecretLen)
+	}
+	if err := validateEmail(site.AdminEmail); err != nil {
+		return Site{}, err
+	}
+	if site.Admins == nil {
+		site.Admins = []string{}
+	}
+	site.Static, site.Created = false, time.Now()
+
+	s.lock.Lock()
+	if s.isStatic(site.ID) || s.provisioned(site.ID) {
+		s.lock.Unlock()
+		return Site{}, errors.Errorf("site %s already exists", site.ID)
+	}
+	if err := s.storage.AddSite(site.ID); err != nil {
+		s.lock.Unlock()
+		return Site{}, errors.Wrapf(err, "can't make storage of site %s", site.ID)
+	}
+	if err := s.store.Set(site); err != nil {
+		if e := s.storage.RemoveSite(site.ID); e != nil {
+			log.Printf("[WARN] can't remove storage of site %s, %v", site.ID, e)
+		}
+		s.lock.Unlock()
+		return Site{}, errors.Wrapf(err, "can't save site %s", site.ID)
+	}
+	s.sites[site.ID] = site
+	created := s.created
+	s.lock.Unlock()
+
+	log.Printf("[INFO] audit: site %s provisioned, admins %v", site.ID, site.Admins)
+	if created != nil {
+		created(site.ID)
+	}
+	return site, nil
+}
+
+// Update admins, admin email or disabled status of provisioned site
+func (s *Service) Update(siteID string, upd Update) (Site, error) {
+	if upd.AdminEmail != nil {
+		if err := validateEmail(*upd.AdminEmail); err != nil {
+			return Site{}, err
+		}
+	}
+	return s.change(siteID, func(site *Site) {
+		if upd.Admins != nil {
+			site.Admins = append([]string{}, *upd.Admins...)
+		}
+		if upd.AdminEmail != nil {
+			site.AdminEmail = *upd.AdminEmail
+		}
+		if upd.Disabled != nil {
+			site.Disabled = *upd.Disabled
+		}
+	})
+}
+
+// RotateSecret replaces secret of provisioned site with a new one, tokens signed with the old secret
+// can't be verified anymore. Returns site with the new secret.
+func (s *Service) RotateSecret(siteID string) (Site, error) {
+	return s.change(siteID, func(site *Site) { site.Secret = newSecret() })
+}
+
+// Delete provisioned site with its storage, all comments of the site removed
+func (s *Service) Delete(siteID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.isStatic(siteID) {
+		return ErrStatic
+	}
+	if !s.provisioned(siteID) {
+		return ErrNotFound
+	}
+	if err := s.store.Delete(siteID); err != nil {
+		return errors.Wrapf(err, "can't delete site %s", siteID)
+	}
+	delete(s.sites, siteID)
+	if err := s.storage.RemoveSite(siteID); err != nil {
+		return errors.Wrapf(err, "can't remove storage of site %s", siteID)
+	}
+	log.Printf("[INFO] audit: site %s deleted", siteID)
+	return nil
+}
+
+// Secret returns secret of provisioned site, false for static and unknown sites. Safe to call on nil Service.
+func (s *Service) Secret(siteID string) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	site, ok := s.sites[siteID]
+	return site.Secret, ok
+}
+
+// Key returns secret of provisioned site, or key of admins store for other sites
+func (s *Service) Key(siteID string) (string, error) {
+	if secret, ok := s.Secret(siteID); ok {
+		return secret, nil
+	}
+	return s.admins.Key(siteID)
+}
+
+// Admins returns admins of provisioned site, or admins of static sites
+func (s *Service) Admins(siteID string) ([]string, error) {
+	if site, ok := s.get(siteID); ok {
+		return site.Admins, nil
+	}
+	return s.admins.Admins(siteID)
+}
+
+// Email returns admin email of provisioned site, or admin email of static sites
+func (s *Service) Email(siteID string) (string, error) {
+	if site, ok := s.get(siteID); ok {
+		return site.AdminEmail, nil
+	}
+	return s.admins.Email(siteID)
+}
+
+// Enabled checks if provisioned site not disabled, or if static site enabled
+func (s *Service) Enabled(siteID string) (bool, error) {
+	if site, ok := s.get(siteID); ok {
+		return !site.Disabled, nil
+	}
+	return s.admins.Enabled(siteID)
+}
+
+// OnEvent passes events to admins store
+func (s *Service) OnEvent(siteID string, et admin.EventType) error {
+	return s.admins.OnEvent(siteID, et)
+}
+
+// Close store of sites
+func (s *Service) Close() error {
+	return s.store.Close()
+}
+
+// change applies fn to provisioned site and saves it. Returns changed site with secret.
+func (s *Service) change(siteID string, fn func(site *Site)) (Site, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.isStatic(siteID) {
+		return Site{}, ErrStatic
+	}
+	site, ok := s.sites[siteID]
+	if !ok {
+		return Site{}, ErrNotFound
+	}
+	fn(&site)
+	if err := s.store.Set(site); err != nil {
+		return Site{}, errors.Wrapf(err, "can't save site %s", siteID)
+	}
+	s.sites[siteID] = site
+	log.Printf("[INFO] audit: site %s updated, admins %v, disabled %v", siteID, site.Admins, site.Disabled)
+	return site, nil
+}
+
+func (s *Service) get(siteID string) (Site, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	site, ok := s.sites[siteID]
+	return site, ok
+}
+
+func (s *Service) isStatic(siteID string) bool {
+	for _, id := range s.static {
+		if strings.EqualFold(id, siteID) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Service) provisioned(siteID string) bool {
+	for id := range s.sites {
+		if strings.EqualFold(id, siteID) {
+			return true
+		}
+	}
+	return false
+}
+
+func validateEmail(email string) error {
+	if email == "" {
+		return nil
+	}
+	if _, err := mail.ParseAddress(email); err != nil {
+		return errors.Errorf("invalid admin email %q", email)
+	}
+	return nil
+}
+
+func newSecret() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("[ERROR] can't make random secret, %v", err)
+	}
+	return hex.EncodeToString(b)
+}
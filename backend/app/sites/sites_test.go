@@ -0,0 +1,131 @@
+package sites
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestService_CreateUpdateDelete(t *testing.T) {
+	storage := &mockStorage{sites: map[string]bool{}}
+	s, st := prepService(t, storage)
+	created := []string{}
+	s.OnCreate(func(siteID string) { created = append(created, siteID) })
+
+	site, err := s.Create(Site{ID: "blog1", Admins: []string{"github_1"}, AdminEmail: "admin@example.com"})
+	require.NoError(t, err)
+	assert.Len(t, site.Secret, 64, "secret generated")
+	assert.False(t, site.Created.IsZero())
+	assert.True(t, storage.sites["blog1"], "storage made")
+	assert.Equal(t, []string{"blog1"}, created)
+
+	key, err := s.Key("blog1")
+	require.NoError(t, err)
+	assert.Equal(t, site.Secret, key)
+	key, err = s.Key("remark")
+	require.NoError(t, err)
+	assert.Equal(t, "static-secret", key, "static site served by admin store")
+	admins, err := s.Admins("blog1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"github_1"}, admins)
+	email, err := s.Email("blog1")
+	require.NoError(t, err)
+	assert.Equal(t, "admin@example.com", email)
+	assert.Equal(t, []string{"remark", "blog1"}, s.IDs())
+
+	list := s.List()
+	require.Len(t, list, 2)
+	assert.True(t, list[0].Static)
+	assert.Equal(t, []string{"static_admin"}, list[0].Admins)
+	assert.Equal(t, "", list[1].Secret, "secret not listed")
+
+	disabled := true
+	site, err = s.Update("blog1", Update{Disabled: &disabled})
+	require.NoError(t, err)
+	assert.True(t, site.Disabled)
+	assert.Equal(t, []string{"github_1"}, site.Admins, "admins kept")
+	enabled, err := s.Enabled("blog1")
+	require.NoError(t, err)
+	assert.False(t, enabled)
+
+	rotated, err := s.RotateSecret("blog1")
+	require.NoError(t, err)
+	assert.NotEqual(t, key, rotated.Secret)
+
+	// reload from store
+	s2, err := NewService(st, s.admins, storage, []string{"remark"})
+	require.NoError(t, err)
+	secret, ok := s2.Secret("blog1")
+	assert.True(t, ok)
+	assert.Equal(t, rotated.Secret, secret)
+
+	require.NoError(t, s.Delete("blog1"))
+	assert.False(t, storage.sites["blog1"], "storage removed")
+	_, ok = s.Secret("blog1")
+	assert.False(t, ok)
+	assert.Equal(t, ErrNotFound, s.Delete("blog1"))
+	assert.Equal(t, ErrStatic, s.Delete("remark"))
+	_, err = s.Update("remark", Update{Disabled: &disabled})
+	assert.Equal(t, ErrStatic, err)
+
+	var nilService *Service
+	_, ok = nilService.Secret("blog1")
+	assert.False(t, ok)
+}
+
+func TestService_CreateValidation(t *testing.T) {
+	storage := &mockStorage{sites: map[string]bool{}}
+	s, _ := prepService(t, storage)
+
+	_, err := s.Create(Site{ID: "../blog"})
+	assert.EqualError(t, err, `invalid site id "../blog"`)
+	_, err = s.Create(Site{ID: "blog", Secret: "short"})
+	assert.EqualError(t, err, "secret should be at least 16 characters")
+	_, err = s.Create(Site{ID: "blog", AdminEmail: "bad"})
+	assert.EqualError(t, err, `invalid admin email "bad"`)
+	_, err = s.Create(Site{ID: "Remark"})
+	assert.EqualError(t, err, "site Remark already exists")
+
+	storage.err = errors.New("disk full")
+	_, err = s.Create(Site{ID: "blog"})
+	assert.EqualError(t, err, "can't make storage of site blog: disk full")
+	assert.Equal(t, []string{"remark"}, s.IDs())
+}
+
+func prepService(t *testing.T, storage *mockStorage) (*Service, *BoltStore) {
+	tmp, err := ioutil.TempDir("", "sites")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(tmp) })
+	st, err := NewBoltStore(tmp+"/sites.db", bolt.Options{})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = st.Close() })
+	admins := admin.NewStaticStore("static-secret", []string{"remark"}, []string{"static_admin"}, "static@example.com")
+	s, err := NewService(st, admins, storage, []string{"remark"})
+	require.NoError(t, err)
+	return s, st
+}
+
+type mockStorage struct {
+	sites map[string]bool
+	err   error
+}
+
+func (m *mockStorage) AddSite(siteID string) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.sites[siteID] = true
+	return nil
+}
+
+func (m *mockStorage) RemoveSite(siteID string) error {
+	delete(m.sites, siteID)
+	return nil
+}
@@ -0,0 +1,156 @@
+// Package slowlog logs store operations slower and api responses larger than configured thresholds, with site,
+// route and post url in fields of the message, and keeps the latest of them in memory for diagnostics.
+// All methods are safe to call on nil Recorder, so logging is optional for callers.
+package slowlog
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/umputun/remark42/backend/app/logging"
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// Kind of recorded operation
+type Kind string
+
+// enum of all kinds of operations
+const (
+	KindStore    Kind = "store"    // call of store engine
+	KindResponse Kind = "response" // response of api
+)
+
+// Op is a recorded operation exceeding the threshold
+type Op struct {
+	Kind     Kind      `json:"kind"`
+	Name     string    `json:"name"` // method of store engine or of http request
+	Site     string    `json:"site,omitempty"`
+	URL      string    `json:"url,omitempty"`   // url of post
+	Route    string    `json:"route,omitempty"` // route pattern of api, responses only
+	Duration int64     `json:"duration_ms"`
+	Size     int       `json:"size,omitempty"` // size of response in bytes, responses only
+	Time     time.Time `json:"time"`
+}
+
+// Params of Recorder
+type Params struct {
+	StoreThreshold    time.Duration // store operations taking longer logged, disabled if 0
+	ResponseThreshold int           // responses larger than this number of bytes logged, disabled if 0
+	Keep              int           // number of the latest operations of each kind kept in memory, 100 if 0
+}
+
+// Recorder logs and keeps operations exceeding thresholds
+type Recorder struct {
+	Params
+	now func() time.Time
+
+	lock sync.Mutex
+	ops  map[Kind]*ring
+}
+
+// ring keeps the latest operations, the oldest overwritten when full
+type ring struct {
+	ops  []Op
+	next int
+}
+
+// New makes Recorder with params
+func New(params Params) *Recorder {
+	if params.Keep <= 0 {
+		params.Keep = 100
+	}
+	return &Recorder{Params: params, now: time.Now, ops: map[Kind]*ring{}}
+}
+
+// Store observes duration of store engine call, used as engine.ObserveFunc
+func (r *Recorder) Store(op string, locator store.Locator, d time.Duration) {
+	if r == nil || r.StoreThreshold <= 0 || d < r.StoreThreshold {
+		return
+	}
+	rec := Op{Kind: KindStore, Name: op, Site: locator.SiteID, URL: locator.URL, Duration: d.Milliseconds(),
+		Time: r.now()}
+	r.add(rec)
+	ctx := logging.WithFields(context.Background(),
+		logging.Fields{Site: rec.Site, URL: rec.URL, Duration: rec.Duration})
+	logging.Printf(ctx, "[WARN] slow store operation %s, site %q, url %q, %v", op, rec.Site, rec.URL, d)
+}
+
+// Middleware observes size of api responses, route taken from chi and url of post from query
+func (r *Recorder) Middleware(next http.Handler) http.Handler {
+	if r == nil || r.ResponseThreshold <= 0 {
+		return next
+	}
+	fn := func(w http.ResponseWriter, req *http.Request) {
+		st := r.now()
+		ww := middleware.NewWrapResponseWriter(w, req.ProtoMajor)
+		next.ServeHTTP(ww, req)
+		if ww.BytesWritten() <= r.ResponseThreshold {
+			return
+		}
+		route := req.URL.Path
+		if rctx := chi.RouteContext(req.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		rec := Op{Kind: KindResponse, Name: req.Method, Site: req.URL.Query().Get("site"),
+			URL: req.URL.Query().Get("url"), Route: route, Duration: r.now().Sub(st).Milliseconds(),
+			Size: ww.BytesWritten(), Time: r.now()}
+		r.add(rec)
+		fields := logging.FromContext(req.Context())
+		fields.Site, fields.Route, fields.URL, fields.Duration, fields.Size = rec.Site, rec.Route, rec.URL, rec.Duration,
+			rec.Size
+		logging.Printf(logging.WithFields(req.Context(), fields), "[WARN] large response %s %s, site %q, url %q, %d bytes",
+			rec.Name, rec.Route, rec.Site, rec.URL, rec.Size)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// Top returns up to limit kept operations of the kind, the slowest store operations or the largest responses first.
+// Operations of all sites returned if siteID is empty, all kept operations if limit is 0.
+func (r *Recorder) Top(kind Kind, siteID string, limit int) []Op {
+	res := []Op{}
+	if r == nil {
+		return res
+	}
+	r.lock.Lock()
+	if rg, ok := r.ops[kind]; ok {
+		for _, op := range rg.ops {
+			if siteID == "" || op.Site == siteID {
+				res = append(res, op)
+			}
+		}
+	}
+	r.lock.Unlock()
+
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].Size != res[j].Size {
+			return res[i].Size > res[j].Size
+		}
+		return res[i].Duration > res[j].Duration
+	})
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+	}
+	return res
+}
+
+func (r *Recorder) add(op Op) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	rg, ok := r.ops[op.Kind]
+	if !ok {
+		rg = &ring{ops: make([]Op, 0, r.Keep)}
+		r.ops[op.Kind] = rg
+	}
+	if len(rg.ops) < r.Keep {
+		rg.ops = append(rg.ops, op)
+		return
+	}
+	rg.ops[rg.next] = op
+	rg.next = (rg.next + 1) % r.Keep
+}
@@ -0,0 +1,75 @@
+package slowlog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestRecorder_Store(t *testing.T) {
+	r := New(Params{StoreThreshold: 100 * time.Millisecond, Keep: 2})
+	ts := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return ts }
+
+	r.Store("Find", store.Locator{SiteID: "site1", URL: "https://example.com/1"}, 50*time.Millisecond)
+	assert.Equal(t, []Op{}, r.Top(KindStore, "", 0), "faster than threshold")
+
+	r.Store("Find", store.Locator{SiteID: "site1", URL: "https://example.com/1"}, 200*time.Millisecond)
+	r.Store("Info", store.Locator{SiteID: "site2"}, 300*time.Millisecond)
+	assert.Equal(t, []Op{
+		{Kind: KindStore, Name: "Info", Site: "site2", Duration: 300, Time: ts},
+		{Kind: KindStore, Name: "Find", Site: "site1", URL: "https://example.com/1", Duration: 200, Time: ts},
+	}, r.Top(KindStore, "", 0))
+	assert.Equal(t, 1, len(r.Top(KindStore, "", 1)))
+	assert.Equal(t, 1, len(r.Top(KindStore, "site1", 0)))
+
+	r.Store("Count", store.Locator{SiteID: "site1"}, 150*time.Millisecond) // overwrites the oldest
+	ops := r.Top(KindStore, "", 0)
+	require.Equal(t, 2, len(ops))
+	assert.Equal(t, "Info", ops[0].Name)
+	assert.Equal(t, "Count", ops[1].Name)
+	assert.Equal(t, []Op{}, r.Top(KindResponse, "", 0))
+}
+
+func TestRecorder_Middleware(t *testing.T) {
+	r := New(Params{ResponseThreshold: 10})
+	router := chi.NewRouter()
+	router.Use(r.Middleware)
+	router.Get("/api/v1/find", func(w http.ResponseWriter, req *http.Request) {
+		_, _ = w.Write([]byte(strings.Repeat("x", 20)))
+	})
+	router.Get("/api/v1/small", func(w http.ResponseWriter, req *http.Request) { _, _ = w.Write([]byte("ok")) })
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	for _, u := range []string{"/api/v1/find?site=site1&url=https://example.com/1", "/api/v1/small?site=site1"} {
+		resp, err := http.Get(ts.URL + u)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	ops := r.Top(KindResponse, "site1", 0)
+	require.Equal(t, 1, len(ops))
+	assert.Equal(t, "GET", ops[0].Name)
+	assert.Equal(t, "/api/v1/find", ops[0].Route)
+	assert.Equal(t, "https://example.com/1", ops[0].URL)
+	assert.Equal(t, 20, ops[0].Size)
+}
+
+func TestRecorder_Nil(t *testing.T) {
+	var r *Recorder
+	r.Store("Find", store.Locator{}, time.Hour)
+	assert.Equal(t, []Op{}, r.Top(KindStore, "", 0))
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+	assert.NotNil(t, r.Middleware(h))
+	assert.NotNil(t, New(Params{}).Middleware(h), "disabled responses passed through")
+}
@@ -0,0 +1,108 @@
+package spam
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Akismet checks comments with Akismet API, see https://akismet.com/developers/
+type Akismet struct {
+	APIKey string
+	API    string // optional, https://rest.akismet.com/1.1/ by default
+	Client http.Client
+}
+
+const akismetAPI = "https://rest.akismet.com/1.1/"
+
+// Check comment with comment-check call. Comments with "discard" pro-tip reported as blatant spam.
+func (a *Akismet) Check(ctx context.Context, req Request) (Verdict, error) {
+	resp, body, err := a.call(ctx, "comment-check", req)
+	if err != nil {
+		return Ham, err
+	}
+	switch body {
+	case "true":
+		if resp.Header.Get("X-akismet-pro-tip") == "discard" {
+			return Blatant, nil
+		}
+		return Spam, nil
+	case "false":
+		return Ham, nil
+	}
+	return Ham, errors.Errorf("unexpected akismet response %q, %s", body, resp.Header.Get("X-akismet-debug-help"))
+}
+
+// Submit missed spam with submit-spam call or false positive with submit-ham
+func (a *Akismet) Submit(ctx context.Context, req Request, spam bool) error {
+	method := "submit-ham"
+	if spam {
+		method = "submit-spam"
+	}
+	_, _, err := a.call(ctx, method, req)
+	return err
+}
+
+// String representation of Akismet
+func (a *Akismet) String() string {
+	return "akismet"
+}
+
+func (a *Akismet) call(ctx context.Context, method string, req Request) (*http.Response, string, error) {
+	api := a.API
+	if api == "" {
+		api = akismetAPI
+	}
+	c := req.Comment
+	params := url.Values{
+		"api_key":         {a.APIKey},
+		"blog":            {blogURL(c.Locator.URL)},
+		"user_ip":         {req.UserIP},
+		"user_agent":      {req.UserAgent},
+		"referrer":        {req.Referrer},
+		"permalink":       {c.Locator.URL},
+		"comment_type":    {"comment"},
+		"comment_author":  {c.User.Name},
+		"comment_content": {text(c)},
+	}
+	if c.ParentID != "" {
+		params.Set("comment_type", "reply")
+	}
+	if !c.Timestamp.IsZero() {
+		params.Set("comment_date_gmt", c.Timestamp.UTC().Format(time.RFC3339))
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(api, "/")+"/"+method,
+		strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "can't make akismet %s request", method)
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := a.Client.Do(httpReq)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "akismet %s request failed", method)
+	}
+	defer resp.Body.Close() // nolint
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "can't read akismet %s response", method)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", errors.Errorf("akismet %s error, status %d, %s", method, resp.StatusCode, string(body))
+	}
+	return resp, strings.TrimSpace(string(body)), nil
+}
+
+// blogURL returns front page url of the site, i.e. https://example.com for https://example.com/post/1
+func blogURL(postURL string) string {
+	u, err := url.Parse(postURL)
+	if err != nil || u.Host == "" {
+		return postURL
+	}
+	return u.Scheme + "://" + u.Host
+}
@@ -0,0 +1,97 @@
+package spam
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestAkismet_Check(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "/1.1/comment-check", r.URL.Path)
+		assert.Equal(t, "key123", r.PostForm.Get("api_key"))
+		assert.Equal(t, "https://example.com", r.PostForm.Get("blog"))
+		assert.Equal(t, "https://example.com/post/1", r.PostForm.Get("permalink"))
+		assert.Equal(t, "1.2.3.4", r.PostForm.Get("user_ip"))
+		assert.Equal(t, "agent", r.PostForm.Get("user_agent"))
+		assert.Equal(t, "user name", r.PostForm.Get("comment_author"))
+		switch r.PostForm.Get("comment_content") {
+		case "viagra-test-123":
+			_, _ = w.Write([]byte("true"))
+		case "blatant":
+			assert.Equal(t, "reply", r.PostForm.Get("comment_type"))
+			assert.Equal(t, "2021-05-04T10:11:12Z", r.PostForm.Get("comment_date_gmt"))
+			w.Header().Set("X-akismet-pro-tip", "discard")
+			_, _ = w.Write([]byte("true"))
+		case "bad":
+			w.Header().Set("X-akismet-debug-help", "Empty \"blog\" value")
+			_, _ = w.Write([]byte("invalid"))
+		case "fail":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			assert.Equal(t, "comment", r.PostForm.Get("comment_type"))
+			_, _ = w.Write([]byte("false"))
+		}
+	}))
+	defer ts.Close()
+
+	a := &Akismet{APIKey: "key123", API: ts.URL + "/1.1/"}
+	assert.Equal(t, "akismet", a.String())
+	req := func(text string) Request {
+		return Request{UserIP: "1.2.3.4", UserAgent: "agent", Comment: store.Comment{Orig: text,
+			Locator: store.Locator{SiteID: "site", URL: "https://example.com/post/1"}, User: store.User{Name: "user name"}}}
+	}
+
+	v, err := a.Check(context.Background(), req("viagra-test-123"))
+	require.NoError(t, err)
+	assert.Equal(t, Spam, v)
+
+	v, err = a.Check(context.Background(), req("some text"))
+	require.NoError(t, err)
+	assert.Equal(t, Ham, v)
+
+	r := req("blatant")
+	r.Comment.ParentID = "p1"
+	r.Comment.Timestamp = time.Date(2021, 5, 4, 10, 11, 12, 0, time.UTC)
+	v, err = a.Check(context.Background(), r)
+	require.NoError(t, err)
+	assert.Equal(t, Blatant, v)
+
+	_, err = a.Check(context.Background(), req("bad"))
+	assert.EqualError(t, err, `unexpected akismet response "invalid", Empty "blog" value`)
+	_, err = a.Check(context.Background(), req("fail"))
+	assert.EqualError(t, err, "akismet comment-check error, status 500, ")
+}
+
+func TestAkismet_Submit(t *testing.T) {
+	var calls []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		calls = append(calls, r.URL.Path+" "+r.PostForm.Get("comment_content"))
+		_, _ = w.Write([]byte("Thanks for making the web a better place."))
+	}))
+	defer ts.Close()
+
+	a := &Akismet{APIKey: "key123", API: ts.URL}
+	c := store.Comment{Text: "<p>some <b>text</b></p>", Locator: store.Locator{URL: "https://example.com/post/1"}}
+	require.NoError(t, a.Submit(context.Background(), Request{Comment: c}, true))
+	require.NoError(t, a.Submit(context.Background(), Request{Comment: c}, false))
+	assert.Equal(t, []string{"/submit-spam some text", "/submit-ham some text"}, calls)
+
+	a.API = "http://127.0.0.1:1"
+	assert.Error(t, a.Submit(context.Background(), Request{Comment: c}, true))
+}
+
+func TestBlogURL(t *testing.T) {
+	assert.Equal(t, "https://example.com", blogURL("https://example.com/post/1?x=1"))
+	assert.Equal(t, "http://example.com:8080", blogURL("http://example.com:8080/"))
+	assert.Equal(t, "post", blogURL("post"))
+}
@@ -0,0 +1,104 @@
+package spam
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Remote checks comments with generic REST API. Comment sent with POST as JSON to {API}/check,
+// and response should be {"spam": true|false, "blatant": true|false}. Feedback sent the same way
+// to {API}/spam and {API}/ham, any 2xx response accepted.
+type Remote struct {
+	API    string
+	Token  string // optional, sent as bearer token
+	Client http.Client
+}
+
+// RemoteRequest is a body of requests to remote spam API
+type RemoteRequest struct {
+	SiteID    string `json:"site"`
+	URL       string `json:"url"`
+	CommentID string `json:"id,omitempty"`
+	ParentID  string `json:"pid,omitempty"`
+	UserID    string `json:"user_id"`
+	UserName  string `json:"user_name"`
+	UserIP    string `json:"user_ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	Referrer  string `json:"referrer,omitempty"`
+	Text      string `json:"text"`
+}
+
+// Check comment with {API}/check call
+func (r *Remote) Check(ctx context.Context, req Request) (Verdict, error) {
+	body, err := r.call(ctx, "check", req)
+	if err != nil {
+		return Ham, err
+	}
+	resp := struct {
+		Spam    bool `json:"spam"`
+		Blatant bool `json:"blatant"`
+	}{}
+	if err = json.Unmarshal(body, &resp); err != nil {
+		return Ham, errors.Wrap(err, "can't decode spam check response")
+	}
+	switch {
+	case resp.Blatant:
+		return Blatant, nil
+	case resp.Spam:
+		return Spam, nil
+	}
+	return Ham, nil
+}
+
+// Submit missed spam to {API}/spam or false positive to {API}/ham
+func (r *Remote) Submit(ctx context.Context, req Request, spam bool) error {
+	method := "ham"
+	if spam {
+		method = "spam"
+	}
+	_, err := r.call(ctx, method, req)
+	return err
+}
+
+// String representation of Remote
+func (r *Remote) String() string {
+	return "remote spam api " + r.API
+}
+
+func (r *Remote) call(ctx context.Context, method string, req Request) ([]byte, error) {
+	c := req.Comment
+	body, err := json.Marshal(RemoteRequest{SiteID: c.Locator.SiteID, URL: c.Locator.URL, CommentID: c.ID,
+		ParentID: c.ParentID, UserID: c.User.ID, UserName: c.User.Name, UserIP: req.UserIP, UserAgent: req.UserAgent,
+		Referrer: req.Referrer, Text: text(c)})
+	if err != nil {
+		return nil, errors.Wrap(err, "can't marshal spam request")
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(r.API, "/")+"/"+method,
+		bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't make spam %s request", method)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if r.Token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+r.Token)
+	}
+	resp, err := r.Client.Do(httpReq)
+	if err != nil {
+		return nil, errors.Wrapf(err, "spam %s request failed", method)
+	}
+	defer resp.Body.Close() // nolint
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't read spam %s response", method)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, errors.Errorf("spam %s error, status %d, %s", method, resp.StatusCode, string(respBody))
+	}
+	return respBody, nil
+}
@@ -0,0 +1,67 @@
+package spam
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestRemote(t *testing.T) {
+	var feedback []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		req := RemoteRequest{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "site", req.SiteID)
+		assert.Equal(t, "user1", req.UserID)
+		switch r.URL.Path {
+		case "/api/check":
+			assert.Equal(t, "1.2.3.4", req.UserIP)
+			switch req.Text {
+			case "spam":
+				_, _ = w.Write([]byte(`{"spam": true}`))
+			case "blatant":
+				_, _ = w.Write([]byte(`{"spam": true, "blatant": true}`))
+			case "bad":
+				_, _ = w.Write([]byte(`not json`))
+			default:
+				_, _ = w.Write([]byte(`{"spam": false}`))
+			}
+		case "/api/spam", "/api/ham":
+			feedback = append(feedback, r.URL.Path+" "+req.CommentID)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	r := &Remote{API: ts.URL + "/api/", Token: "secret"}
+	assert.Equal(t, "remote spam api "+ts.URL+"/api/", r.String())
+	req := func(text string) Request {
+		return Request{UserIP: "1.2.3.4", Comment: store.Comment{ID: "c1", Orig: text, Locator: store.Locator{SiteID: "site"},
+			User: store.User{ID: "user1"}}}
+	}
+
+	for text, verdict := range map[string]Verdict{"spam": Spam, "blatant": Blatant, "ham": Ham} {
+		v, err := r.Check(context.Background(), req(text))
+		require.NoError(t, err)
+		assert.Equal(t, verdict, v, text)
+	}
+	_, err := r.Check(context.Background(), req("bad"))
+	assert.Error(t, err)
+
+	require.NoError(t, r.Submit(context.Background(), req("text"), true))
+	require.NoError(t, r.Submit(context.Background(), req("text"), false))
+	assert.Equal(t, []string{"/api/spam c1", "/api/ham c1"}, feedback)
+
+	r.API = ts.URL + "/bad"
+	assert.EqualError(t, r.Submit(context.Background(), req("text"), true), "spam spam error, status 404, ")
+}
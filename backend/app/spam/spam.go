@@ -0,0 +1,141 @@
+// Package spam checks new comments with external spam detection services, like Akismet,
+// and sends admins' feedback about missed spam and false positives back to them.
+package spam
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/go-pkgz/lcw"
+	log "github.com/go-pkgz/lgr"
+	"github.com/microcosm-cc/bluemonday"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// Verdict of spam check
+type Verdict int
+
+// Verdict enum
+const (
+	Ham     Verdict = iota // not a spam
+	Spam                   // suspected spam, should be held for moderation or rejected
+	Blatant                // obvious spam, should be rejected without moderation
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case Spam:
+		return "spam"
+	case Blatant:
+		return "blatant spam"
+	default:
+		return "ham"
+	}
+}
+
+// Request for spam check, comment with details of client's http request
+type Request struct {
+	Comment   store.Comment
+	UserIP    string
+	UserAgent string
+	Referrer  string
+}
+
+// Checker defines interface of spam detection service
+type Checker interface {
+	fmt.Stringer
+	Check(ctx context.Context, req Request) (Verdict, error)
+	Submit(ctx context.Context, req Request, spam bool) error // send feedback about missed spam or false positive
+}
+
+// Params of Service
+type Params struct {
+	Timeout time.Duration // timeout of a single call to checker, default 5s
+	Reject  bool          // reject suspected spam instead of holding it for moderation
+}
+
+// Service wraps Checker with timeouts and keeps requests of recent comments to send them with feedback.
+// Errors of checker don't block comments, they treated as ham.
+type Service struct {
+	Params
+	checker Checker
+	reqs    lcw.LoadingCache
+}
+
+const (
+	feedbackTTL     = 7 * 24 * time.Hour // requests of new comments kept for feedback
+	feedbackMaxReqs = 10000
+)
+
+// NewService makes spam service for checker
+func NewService(checker Checker, params Params) *Service {
+	if params.Timeout <= 0 {
+		params.Timeout = 5 * time.Second
+	}
+	res := &Service{Params: params, checker: checker}
+	var err error
+	if res.reqs, err = lcw.NewExpirableCache(lcw.TTL(feedbackTTL), lcw.MaxKeys(feedbackMaxReqs)); err != nil {
+		log.Printf("[WARN] can't make spam requests cache, %v", err)
+		res.reqs = lcw.NewNopCache()
+	}
+	log.Printf("[INFO] spam checker %s, reject=%v", checker, params.Reject)
+	return res
+}
+
+// Check comment request, returns Ham on checker errors
+func (s *Service) Check(req Request) Verdict {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+	verdict, err := s.checker.Check(ctx, req)
+	if err != nil {
+		log.Printf("[WARN] can't check comment of %s on %s for spam, %v", req.Comment.User.ID, req.Comment.Locator.URL, err)
+		return Ham
+	}
+	if verdict != Ham {
+		log.Printf("[INFO] comment of %s on %s detected as %s", req.Comment.User.ID, req.Comment.Locator.URL, verdict)
+	}
+	return verdict
+}
+
+// Keep request of saved comment to send it with feedback later
+func (s *Service) Keep(commentID string, req Request) {
+	_, _ = s.reqs.Get(commentID, func() (interface{}, error) { return req, nil })
+}
+
+// Feedback sends admin's decision about the comment to checker. Details of client's request used
+// if kept, otherwise only the comment itself sent.
+func (s *Service) Feedback(comment store.Comment, spam bool) error {
+	req := Request{Comment: comment}
+	if v, ok := s.reqs.Peek(comment.ID); ok {
+		if kept, ok := v.(Request); ok {
+			kept.Comment = comment
+			req = kept
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+	log.Printf("[INFO] comment %s reported to %s as spam=%v", comment.ID, s.checker, spam)
+	return s.checker.Submit(ctx, req, spam)
+}
+
+// Close stops cleanup of kept requests, safe to call on nil Service
+func (s *Service) Close() {
+	if s == nil {
+		return
+	}
+	if err := s.reqs.Close(); err != nil {
+		log.Printf("[WARN] can't close spam requests cache, %v", err)
+	}
+}
+
+// text returns original text of the comment, or rendered one with html tags stripped
+func text(c store.Comment) string {
+	if c.Orig != "" {
+		return c.Orig
+	}
+	return strings.TrimSpace(html.UnescapeString(bluemonday.StrictPolicy().Sanitize(c.Text)))
+}
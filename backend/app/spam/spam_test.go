@@ -0,0 +1,65 @@
+package spam
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestService_Check(t *testing.T) {
+	checker := &mockChecker{verdict: Spam}
+	svc := NewService(checker, Params{})
+	defer svc.Close()
+	assert.Equal(t, "mock", checker.String())
+
+	req := Request{UserIP: "1.2.3.4", Comment: store.Comment{Orig: "text"}}
+	assert.Equal(t, Spam, svc.Check(req))
+	checker.err = errors.New("failed")
+	assert.Equal(t, Ham, svc.Check(req), "errors treated as ham")
+	assert.Equal(t, "ham", Ham.String())
+	assert.Equal(t, "spam", Spam.String())
+	assert.Equal(t, "blatant spam", Blatant.String())
+}
+
+func TestService_Feedback(t *testing.T) {
+	checker := &mockChecker{}
+	svc := NewService(checker, Params{})
+	defer svc.Close()
+
+	svc.Keep("c1", Request{UserIP: "1.2.3.4", UserAgent: "agent", Comment: store.Comment{ID: "c1", Orig: "old text"}})
+	require.NoError(t, svc.Feedback(store.Comment{ID: "c1", Orig: "new text"}, true))
+	require.Equal(t, 1, len(checker.submitted))
+	assert.Equal(t, "1.2.3.4", checker.submitted[0].UserIP, "kept request used")
+	assert.Equal(t, "agent", checker.submitted[0].UserAgent)
+	assert.Equal(t, "new text", checker.submitted[0].Comment.Orig, "with current comment")
+
+	require.NoError(t, svc.Feedback(store.Comment{ID: "c2", Orig: "text"}, false))
+	require.Equal(t, 2, len(checker.submitted))
+	assert.Equal(t, "", checker.submitted[1].UserIP)
+	assert.Equal(t, "c2", checker.submitted[1].Comment.ID)
+}
+
+func TestText(t *testing.T) {
+	assert.Equal(t, "orig", text(store.Comment{Orig: "orig", Text: "<p>text</p>"}))
+	assert.Equal(t, "text & more", text(store.Comment{Text: "<p>text &amp; <b>more</b></p>"}))
+}
+
+type mockChecker struct {
+	verdict   Verdict
+	err       error
+	submitted []Request
+}
+
+func (m *mockChecker) Check(context.Context, Request) (Verdict, error) { return m.verdict, m.err }
+
+func (m *mockChecker) Submit(_ context.Context, req Request, _ bool) error {
+	m.submitted = append(m.submitted, req)
+	return m.err
+}
+
+func (m *mockChecker) String() string { return "mock" }
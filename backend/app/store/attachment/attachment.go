@@ -0,0 +1,222 @@
+// Package attachment handles non-image files attached to comments, like logs and patches in support-style discussions.
+// Files of allowed extensions saved to own store, separate from pictures, with the same two-stage scheme: uploaded
+// files kept in staging and committed once comment with the link to file submitted, the rest cleaned up.
+// Uploaded files optionally checked by Scanner, i.e. with antivirus, before saving.
+package attachment
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+	"github.com/rs/xid"
+
+	"github.com/umputun/remark42/backend/app/store/image"
+)
+
+// Scanner checks uploaded file, i.e. for viruses, returns error if the file rejected
+type Scanner interface {
+	Scan(ctx context.Context, name string, data []byte) error
+}
+
+// Params of Service
+type Params struct {
+	Extensions   []string      // allowed extensions of files with dot, like ".log", attachments disabled if empty
+	MaxSize      int           // max size of file
+	API          string        // attachment api matching path, like "https://example.com/api/v1/file/"
+	EditDuration time.Duration // edit period for comments, uploaded files committed after it
+	Scanner      Scanner       // optional, uploaded files rejected by scanner not saved
+}
+
+// Service stores attachments in store separate from images. Staging, commit and cleanup of files
+// done by image.Service made for the same store, without image processing.
+type Service struct {
+	Params
+	store  image.Store
+	images *image.Service
+}
+
+// NewService makes attachments service for store, extensions of params normalized to lower case with dot
+func NewService(s image.Store, p Params) *Service {
+	exts := make([]string, 0, len(p.Extensions))
+	for _, ext := range p.Extensions {
+		if ext = strings.ToLower(strings.TrimSpace(ext)); ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		exts = append(exts, ext)
+	}
+	p.Extensions = exts
+	return &Service{Params: p, store: s, images: image.NewService(s, image.ServiceParams{EditDuration: p.EditDuration})}
+}
+
+// Enabled checks if any extensions allowed
+func (s *Service) Enabled() bool {
+	return s != nil && len(s.Extensions) > 0
+}
+
+// Allowed checks if file name has allowed extension
+func (s *Service) Allowed(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	for _, e := range s.Extensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// Save checks and stores file uploaded by user to staging. Id of the file is user/guid.ext, with extension of the name.
+func (s *Service) Save(ctx context.Context, userID, name string, r io.Reader) (id string, err error) {
+	if !s.Allowed(name) {
+		return "", errors.Errorf("file type of %q not allowed", name)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r, int64(s.MaxSize)+1))
+	if err != nil {
+		return "", errors.Wrapf(err, "can't read %s", name)
+	}
+	if len(data) > s.MaxSize {
+		return "", errors.Errorf("file is too large (limit=%d)", s.MaxSize)
+	}
+	if len(data) == 0 {
+		return "", errors.New("file is empty")
+	}
+	if s.Scanner != nil {
+		if err = s.Scanner.Scan(ctx, name, data); err != nil {
+			log.Printf("[WARN] attachment %s of %s rejected by scanner, %v", name, userID, err)
+			return "", errors.Wrapf(err, "file %s rejected", name)
+		}
+	}
+
+	id = userID + "/" + xid.New().String() + strings.ToLower(path.Ext(name))
+	if err = s.store.Save(id, data); err != nil {
+		return "", errors.Wrapf(err, "can't save %s", name)
+	}
+	log.Printf("[INFO] attachment %s of %s saved as %s, size=%d", name, userID, id, len(data))
+	return id, nil
+}
+
+// Load returns content of the file
+func (s *Service) Load(id string) ([]byte, error) {
+	return s.store.Load(id)
+}
+
+// ContentType returns content type of the file. Files with valid utf-8 are plain text, like logs and patches,
+// the rest typed by extension of id, binary if unknown. Types of system mime database not used for text files,
+// as they vary between systems.
+func (s *Service) ContentType(id string, data []byte) string {
+	if utf8.Valid(data) {
+		return "text/plain; charset=utf-8"
+	}
+	if ct := mime.TypeByExtension(path.Ext(id)); ct != "" {
+		return ct
+	}
+	return "application/octet-stream"
+}
+
+// Extract gets ids of files linked from the comment html, links are API/user/id/name
+func (s *Service) Extract(commentHTML string) (ids []string) {
+	if !s.Enabled() || s.API == "" {
+		return nil
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(commentHTML))
+	if err != nil {
+		log.Printf("[ERROR] can't parse comment html to get attachments: %q, error: %v", commentHTML, err)
+		return nil
+	}
+	seen := map[string]bool{}
+	doc.Find("a").Each(func(_ int, sl *goquery.Selection) {
+		href, ok := sl.Attr("href")
+		if !ok || !strings.HasPrefix(href, s.API) {
+			return
+		}
+		elems := strings.Split(strings.TrimPrefix(href, s.API), "/")
+		if len(elems) < 2 {
+			return
+		}
+		user, e1 := url.PathUnescape(elems[0])
+		file, e2 := url.PathUnescape(elems[1])
+		if e1 != nil || e2 != nil || user == "" || file == "" {
+			return
+		}
+		if id := user + "/" + file; !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	})
+	return ids
+}
+
+// Submit files for delayed commit, after edit duration
+func (s *Service) Submit(idsFn func() []string) {
+	s.images.Submit(idsFn)
+}
+
+// SubmitAndCommit files immediately
+func (s *Service) SubmitAndCommit(idsFn func() []string) error {
+	return s.images.SubmitAndCommit(idsFn)
+}
+
+// ResetCleanupTimer resets cleanup timer of staged file, called on comment preview
+func (s *Service) ResetCleanupTimer(id string) error {
+	return s.images.ResetCleanupTimer(id)
+}
+
+// Info returns meta information about storage
+func (s *Service) Info() (image.StoreInfo, error) {
+	return s.images.Info()
+}
+
+// Cleanup runs periodic cleanup of staged files, blocking
+func (s *Service) Cleanup(ctx context.Context) {
+	s.images.Cleanup(ctx)
+}
+
+// Close commits submitted files and closes the store
+func (s *Service) Close(ctx context.Context) {
+	s.images.Close(ctx)
+}
+
+// CommandScanner scans file with shell command getting content of the file on stdin, like
+// "clamdscan --no-summary -". File rejected if command exits with non-zero code.
+type CommandScanner struct {
+	Command string
+	Timeout time.Duration // 5s by default, as uploads time out in 10s
+}
+
+// Scan runs the command with file content on stdin and file name in ATTACHMENT_NAME environment variable
+func (c *CommandScanner) Scan(ctx context.Context, name string, data []byte) error {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", c.Command) //nolint:gosec // command set by admin
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Env = append(os.Environ(), "ATTACHMENT_NAME="+name)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "scan failed: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// String describes scanner for logs
+func (c *CommandScanner) String() string {
+	return "command " + c.Command
+}
@@ -0,0 +1,126 @@
+package attachment
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store/image"
+)
+
+func TestService_Save(t *testing.T) {
+	svc := prepService(t, nil)
+	assert.True(t, svc.Enabled())
+	assert.Equal(t, []string{".txt", ".log", ".patch"}, svc.Extensions, "normalized")
+
+	id, err := svc.Save(context.Background(), "user1", "Crash.LOG", strings.NewReader("panic: something"))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(id, "user1/"), id)
+	assert.True(t, strings.HasSuffix(id, ".log"), id)
+	data, err := svc.Load(id)
+	require.NoError(t, err)
+	assert.Equal(t, "panic: something", string(data))
+
+	_, err = svc.Save(context.Background(), "user1", "run.sh", strings.NewReader("rm -rf /"))
+	assert.EqualError(t, err, `file type of "run.sh" not allowed`)
+	_, err = svc.Save(context.Background(), "user1", "big.txt", strings.NewReader(strings.Repeat("x", 101)))
+	assert.EqualError(t, err, "file is too large (limit=100)")
+	_, err = svc.Save(context.Background(), "user1", "empty.txt", strings.NewReader(""))
+	assert.EqualError(t, err, "file is empty")
+
+	assert.False(t, (&Service{}).Enabled())
+	assert.False(t, (*Service)(nil).Enabled())
+}
+
+func TestService_SaveScanned(t *testing.T) {
+	scanned := []string{}
+	svc := prepService(t, scannerFunc(func(_ context.Context, name string, data []byte) error {
+		scanned = append(scanned, name)
+		if strings.Contains(string(data), "EICAR") {
+			return errors.New("infected")
+		}
+		return nil
+	}))
+
+	_, err := svc.Save(context.Background(), "user1", "ok.txt", strings.NewReader("clean"))
+	require.NoError(t, err)
+	_, err = svc.Save(context.Background(), "user1", "bad.txt", strings.NewReader("EICAR test"))
+	assert.EqualError(t, err, "file bad.txt rejected: infected")
+	assert.Equal(t, []string{"ok.txt", "bad.txt"}, scanned)
+}
+
+func TestService_Extract(t *testing.T) {
+	svc := prepService(t, nil)
+	html := `<p>see <a href="https://remark42.example.com/api/v1/file/user1/abc.log/crash.log">crash.log</a>,
+		<a href="https://remark42.example.com/api/v1/file/github_1/def.patch/fix%20it.patch">patch</a>,
+		again <a href="https://remark42.example.com/api/v1/file/user1/abc.log/crash.log">crash.log</a>,
+		<a href="https://example.com/api/v1/file/user2/xyz.txt/a.txt">other site</a>
+		<a href="https://remark42.example.com/api/v1/file/bad">bad</a>
+		<img src="https://remark42.example.com/api/v1/file/user3/img.txt/a.txt"></p>`
+	assert.Equal(t, []string{"user1/abc.log", "github_1/def.patch"}, svc.Extract(html))
+
+	svc.Extensions = nil
+	assert.Nil(t, svc.Extract(html), "disabled")
+}
+
+func TestService_ContentType(t *testing.T) {
+	svc := prepService(t, nil)
+	assert.Equal(t, "text/plain; charset=utf-8", svc.ContentType("user1/abc.txt", []byte("text")))
+	assert.Equal(t, "text/plain; charset=utf-8", svc.ContentType("user1/abc.unknown-ext", []byte("текст")))
+	assert.Equal(t, "application/octet-stream", svc.ContentType("user1/abc.unknown-ext", []byte{0xff, 0xfe, 0x00}))
+	assert.Equal(t, "application/zip", svc.ContentType("user1/abc.zip", []byte{'P', 'K', 0x03, 0x04, 0xff}))
+}
+
+func TestService_Commit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "attachments")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	fs := &image.FileSystem{Location: filepath.Join(dir, "files"), Staging: filepath.Join(dir, "staging")}
+	svc := NewService(fs, Params{Extensions: []string{".txt"}, MaxSize: 100, EditDuration: 10 * time.Millisecond})
+
+	id, err := svc.Save(context.Background(), "user1", "a.txt", strings.NewReader("text"))
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dir, "staging", id))
+	require.NoError(t, err, "staged")
+
+	svc.Submit(func() []string { return []string{id} })
+	svc.Close(context.Background())
+	_, err = os.Stat(filepath.Join(dir, "files", id))
+	assert.NoError(t, err, "committed")
+	data, err := svc.Load(id)
+	require.NoError(t, err)
+	assert.Equal(t, "text", string(data))
+}
+
+func TestCommandScanner_Scan(t *testing.T) {
+	scanner := CommandScanner{Command: `grep -q EICAR && { echo "infected $ATTACHMENT_NAME"; exit 1; } || exit 0`}
+	assert.NoError(t, scanner.Scan(context.Background(), "ok.txt", []byte("clean")))
+	err := scanner.Scan(context.Background(), "bad.txt", []byte("EICAR test"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "infected bad.txt")
+
+	scanner = CommandScanner{Command: "sleep 1", Timeout: 10 * time.Millisecond}
+	assert.Error(t, scanner.Scan(context.Background(), "slow.txt", []byte("text")), "timeout")
+	assert.Equal(t, "command sleep 1", scanner.String())
+}
+
+type scannerFunc func(ctx context.Context, name string, data []byte) error
+
+func (f scannerFunc) Scan(ctx context.Context, name string, data []byte) error { return f(ctx, name, data) }
+
+func prepService(t *testing.T, scanner Scanner) *Service {
+	dir, err := ioutil.TempDir("", "attachments")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	fs := &image.FileSystem{Location: filepath.Join(dir, "files"), Staging: filepath.Join(dir, "staging")}
+	return NewService(fs, Params{Extensions: []string{".txt", "LOG", " .patch", ""}, MaxSize: 100,
+		API: "https://remark42.example.com/api/v1/file/", EditDuration: time.Minute, Scanner: scanner})
+}
@@ -0,0 +1,69 @@
+package store
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/alecthomas/chroma"
+	"github.com/alecthomas/chroma/formatters/html"
+	"github.com/alecthomas/chroma/styles"
+	"github.com/pkg/errors"
+)
+
+// ValidCodeStyle checks if the style is known to chroma
+func ValidCodeStyle(style string) bool {
+	_, ok := styles.Registry[style]
+	return ok
+}
+
+// CodeCSS returns css for code highlighted by CommentFormatter with chroma classes, in the chroma style
+func CodeCSS(style string) ([]byte, error) {
+	if !ValidCodeStyle(style) {
+		return nil, errors.Errorf("unknown code style %q", style)
+	}
+	buf := bytes.Buffer{}
+	if err := html.New(html.WithClasses(true)).WriteCSS(&buf, styles.Get(style)); err != nil {
+		return nil, errors.Wrapf(err, "can't make css of code style %s", style)
+	}
+	return buf.Bytes(), nil
+}
+
+// InlineCodeStyle replaces classes of highlighted code in comment's html with inline styles of the chroma style,
+// for places without site's css, like emails. Returns the original html for unknown style or html can't be parsed.
+func InlineCodeStyle(commentHTML, style string) string {
+	if !ValidCodeStyle(style) || !strings.Contains(commentHTML, `class="chroma"`) {
+		return commentHTML
+	}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(commentHTML))
+	if err != nil {
+		return commentHTML
+	}
+
+	st := styles.Get(style)
+	bg := st.Get(chroma.Background)
+	css := map[string]string{} // class to inline style
+	for tt, class := range chroma.StandardTypes {
+		if tt == chroma.Background || class == "" {
+			continue
+		}
+		css[class] = html.StyleEntryToCSS(st.Get(tt).Sub(bg))
+	}
+
+	doc.Find("pre.chroma").Each(func(_ int, pre *goquery.Selection) {
+		pre.RemoveAttr("class")
+		pre.SetAttr("style", html.StyleEntryToCSS(bg))
+		pre.Find("span[class]").Each(func(_ int, span *goquery.Selection) {
+			class, _ := span.Attr("class")
+			span.RemoveAttr("class")
+			if s := css[class]; s != "" {
+				span.SetAttr("style", s)
+			}
+		})
+	})
+	res, err := doc.Find("body").Html()
+	if err != nil {
+		return commentHTML
+	}
+	return res
+}
@@ -0,0 +1,32 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodeCSS(t *testing.T) {
+	css, err := CodeCSS("github")
+	require.NoError(t, err)
+	assert.Contains(t, string(css), ".chroma { background-color: #ffffff")
+	assert.Contains(t, string(css), ".chroma .kd { color: #000000; font-weight: bold }")
+
+	_, err = CodeCSS("blah")
+	assert.EqualError(t, err, `unknown code style "blah"`)
+}
+
+func TestInlineCodeStyle(t *testing.T) {
+	f := NewCommentFormatter()
+	text := f.FormatText("smth\n```go\nfunc main() {}\n```")
+	require.Contains(t, text, `<span class="kd">func</span>`)
+
+	res := InlineCodeStyle(text, "github")
+	assert.Equal(t, `<p>smth</p>
+<pre style="background-color: #ffffff"><span style="color: #000000; font-weight: bold">func</span> <span style="color: #990000; font-weight: bold">main</span><span>(</span><span>)</span> <span>{</span><span>}</span>
+</pre>`, res)
+
+	assert.Equal(t, text, InlineCodeStyle(text, "blah"), "unknown style")
+	assert.Equal(t, "<p>text</p>\n", InlineCodeStyle("<p>text</p>\n", "github"), "no code")
+}
@@ -16,21 +16,42 @@ type Comment struct {
 	ParentID    string                 `json:"pid"`
 	Text        string                 `json:"text"`
 	Orig        string                 `json:"orig,omitempty"`
+	Raw         string                 `json:"raw,omitempty"` // original markdown as posted, not sanitized, returned on request only
 	User        User                   `json:"user"`
 	Locator     Locator                `json:"locator"`
 	Score       int                    `json:"score"`
 	Votes       map[string]bool        `json:"votes,omitempty"`
 	VotedIPs    map[string]VotedIPInfo `json:"voted_ips,omitempty"` // voted ips (hashes) with TS
 	Vote        int                    `json:"vote"`                // vote for the current user, -1/1/0.
+	Reactions   map[string]int         `json:"reactions,omitempty"` // number of reactions by name, like "heart"
+	Reactors    map[string][]string    `json:"reactors,omitempty"`  // reactions of users by user id, hidden from users
+	Reacted     []string               `json:"reacted,omitempty"`   // reactions of the current user
 	Controversy float64                `json:"controversy,omitempty"`
+	Quality     float64                `json:"quality,omitempty"` // composite quality score, see service.quality
+	Best        float64                `json:"best,omitempty"`    // lower bound of Wilson score of votes, see service.best
 	Timestamp   time.Time              `json:"time" bson:"time"`
 	Edit        *Edit                  `json:"edit,omitempty" bson:"edit,omitempty"` // pointer to have empty default in json response
 	Pin         bool                   `json:"pin,omitempty" bson:"pin,omitempty"`
 	Deleted     bool                   `json:"delete,omitempty" bson:"delete"`
+	DeletedAt   *time.Time             `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"` // not set for comments deleted before it was added
 	Imported    bool                   `json:"imported,omitempty" bson:"imported"`
+	Pending     bool                   `json:"pending,omitempty" bson:"pending,omitempty"` // held for moderation, suspected spam
+	Community   string                 `json:"community,omitempty" bson:"-"`               // state set by votes, see CommunityHidden
 	PostTitle   string                 `json:"title,omitempty" bson:"title"`
+	Labels      []string               `json:"labels,omitempty" bson:"labels,omitempty"`           // set by moderators, like "question"
+	Revisions   []Revision             `json:"revisions,omitempty" bson:"revisions,omitempty"`     // previous texts, hidden from users
+	ExternalID  string                 `json:"external_id,omitempty" bson:"external_id,omitempty"` // set by integrations, hidden from users
+	Reports     []Report               `json:"reports,omitempty" bson:"reports,omitempty"`         // reports of users to moderators, hidden from users
+	Webmention  string                 `json:"webmention,omitempty" bson:"webmention,omitempty"`   // source url of received webmention the comment made from
+	Toxicity    float64                `json:"toxicity,omitempty" bson:"toxicity,omitempty"`       // toxicity score from 0 to 1, hidden from users
 }
 
+// states of comment set by community votes, unlike pending or deleted ones set by moderators
+const (
+	CommunityCollapsed = "collapsed" // score at or below low threshold
+	CommunityHidden    = "hidden"    // score at or below critical threshold
+)
+
 // Locator keeps site and url of the post
 type Locator struct {
 	SiteID string `json:"site,omitempty" bson:"site"`
@@ -43,13 +64,33 @@ type Edit struct {
 	Summary   string    `json:"summary"`
 }
 
+// Revision keeps text of the comment replaced by edit
+type Revision struct {
+	Text      string    `json:"text"`
+	Orig      string    `json:"orig,omitempty"`
+	Editor    string    `json:"editor"`            // id of user made the edit
+	Timestamp time.Time `json:"time"`              // time of the edit
+	Summary   string    `json:"summary,omitempty"` // summary of the edit
+}
+
+// Report of the comment made by user to moderators
+type Report struct {
+	UserID    string    `json:"user_id"`
+	Reason    string    `json:"reason"`
+	Timestamp time.Time `json:"time"`
+}
+
 // PostInfo holds summary for given post url
 type PostInfo struct {
-	URL      string    `json:"url"`
-	Count    int       `json:"count"`
-	ReadOnly bool      `json:"read_only,omitempty" bson:"read_only,omitempty"`
-	FirstTS  time.Time `json:"first_time,omitempty" bson:"first_time,omitempty"`
-	LastTS   time.Time `json:"last_time,omitempty" bson:"last_time,omitempty"`
+	URL      string     `json:"url"`
+	Count    int        `json:"count"`
+	ReadOnly bool       `json:"read_only,omitempty" bson:"read_only,omitempty"`
+	SlowMode bool       `json:"slow_mode,omitempty" bson:"slow_mode,omitempty"`
+	FirstTS  time.Time  `json:"first_time,omitempty" bson:"first_time,omitempty"`
+	LastTS   time.Time  `json:"last_time,omitempty" bson:"last_time,omitempty"`
+	OpenAt   *time.Time `json:"open_at,omitempty" bson:"open_at,omitempty"`   // scheduled open time of not opened post
+	CloseAt  *time.Time `json:"close_at,omitempty" bson:"close_at,omitempty"` // scheduled close time of the post
+	Interval int        `json:"interval,omitempty" bson:"interval,omitempty"` // minutes between comments of each user, 0 for no limit
 }
 
 // BlockedUser holds id and ts for blocked user
@@ -89,18 +130,35 @@ func (c *Comment) PrepareUntrusted() {
 	c.Edit = nil
 	c.Pin = false
 	c.Deleted = false
+	c.DeletedAt = nil
+	c.Pending = false
+	c.Revisions = nil
+	c.Reactions, c.Reactors, c.Reacted = nil, nil, nil
+	c.Labels = nil
+	c.ExternalID = ""
+	c.Reports = nil
+	c.Raw = ""
 }
 
 // SetDeleted clears comment info, reset to deleted state. hard flag will clear all user info as well
 func (c *Comment) SetDeleted(mode DeleteMode) {
+	if !c.Deleted { // time of the first deletion kept
+		ts := time.Now()
+		c.DeletedAt = &ts
+	}
 	c.Text = ""
 	c.Orig = ""
+	c.Raw = ""
 	c.Score = 0
 	c.Votes = map[string]bool{}
 	c.VotedIPs = make(map[string]VotedIPInfo)
 	c.Edit = nil
 	c.Deleted = true
 	c.Pin = false
+	c.Revisions = nil
+	c.Reactions, c.Reactors = nil, nil
+	c.Labels = nil
+	c.Reports = nil
 
 	if mode == HardDelete {
 		c.User.Name = "deleted"
@@ -120,14 +178,20 @@ func (c *Comment) Sanitize() {
 		"|kd|kn|kp|kr|kt|n|na|nb|bp|nc|no|nd|ni|ne|nf|fm|py|nl|nn|nx|nt|nv|vc|vg" +
 		"|vi|vm|l|ld|s|sa|sb|sc|dl|sd|s2|se|sh|si|sx|sr|s1|ss|m|mb|mf|mh|mi|il" +
 		"|mo|o|ow|p|c|ch|cm|cp|cpf|c1|cs|g|gd|ge|gr|gh|gi|go|gp|gs|gu|gt|gl)$"
-	p.AllowAttrs("class").Matching(regexp.MustCompile(codeSpanClassRegex)).OnElements("span")
+	const mathSpanClassRegex = "^math-(inline|display)$" // math kept as is, see CommentFormatter.FormatSiteText
+	p.AllowAttrs("class").Matching(regexp.MustCompile(codeSpanClassRegex + "|" + mathSpanClassRegex)).OnElements("span")
 	p.AllowAttrs("loading").Matching(regexp.MustCompile("^(lazy|eager)$")).OnElements("img")
 	c.Text = p.Sanitize(c.Text)
 	c.Orig = p.Sanitize(c.Orig)
 	c.User.ID = template.HTMLEscapeString(c.User.ID)
 	c.User.Name = c.escapeHTMLWithSome(c.User.Name)
 	c.User.Picture = c.SanitizeAsURL(c.User.Picture)
-	c.Locator.URL = c.SanitizeAsURL(c.Locator.URL)
+	if c.Webmention != "" {
+		c.Webmention = c.SanitizeAsURL(c.Webmention)
+	}
+	if !c.Locator.IsVirtual() || !reVirtualKey.MatchString(c.Locator.Key()) {
+		c.Locator.URL = c.SanitizeAsURL(c.Locator.URL) // url of valid virtual locator is safe, other schemes dropped
+	}
 }
 
 // Snippet from comment's text
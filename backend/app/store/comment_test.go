@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestComment_Sanitize(t *testing.T) {
@@ -93,9 +94,15 @@ func TestComment_PrepareUntrusted(t *testing.T) {
 		Deleted:   true,
 		Timestamp: time.Date(2018, 1, 1, 9, 30, 0, 0, time.Local),
 		Votes:     map[string]bool{"uu": true},
+		Revisions: []Revision{{Text: "old"}},
+		Reactions: map[string]int{"heart": 10},
+		Reactors:  map[string][]string{"uu": {"heart"}},
+		Labels:    []string{"question"},
+		Raw:       "raw",
 	}
 
 	comment.PrepareUntrusted()
+	assert.Equal(t, "", comment.Raw)
 	assert.Equal(t, "", comment.ID)
 	assert.Equal(t, "p123", comment.ParentID)
 	assert.Equal(t, "blah", comment.Text)
@@ -105,8 +112,11 @@ func TestComment_PrepareUntrusted(t *testing.T) {
 	assert.Equal(t, false, comment.Deleted)
 	assert.Equal(t, make(map[string]bool), comment.Votes)
 	assert.Equal(t, make(map[string]VotedIPInfo), comment.VotedIPs)
+	assert.Nil(t, comment.Reactions)
+	assert.Nil(t, comment.Reactors)
+	assert.Nil(t, comment.Labels)
 	assert.Equal(t, User{ID: "username"}, comment.User)
-
+	assert.Nil(t, comment.Revisions)
 }
 
 func TestComment_SetDeleted(t *testing.T) {
@@ -121,19 +131,29 @@ func TestComment_SetDeleted(t *testing.T) {
 		Timestamp: time.Date(2018, 1, 1, 9, 30, 0, 0, time.Local),
 		Votes:     map[string]bool{"uu": true},
 		Pin:       true,
+		Revisions: []Revision{{Text: "old"}},
+		Raw:       "blah",
 	}
 
 	comment.SetDeleted(SoftDelete)
 
 	assert.Equal(t, "", comment.Text)
 	assert.Equal(t, "", comment.Orig)
+	assert.Equal(t, "", comment.Raw)
 	assert.Equal(t, map[string]bool{}, comment.Votes)
 	assert.Equal(t, map[string]VotedIPInfo{}, comment.VotedIPs)
 	assert.Equal(t, 0, comment.Score)
 	assert.True(t, comment.Deleted)
 	assert.Nil(t, comment.Edit)
 	assert.False(t, comment.Pin)
+	assert.Nil(t, comment.Revisions)
 	assert.Equal(t, User{Name: "username", ID: "userid", Picture: "pic", Admin: false, Blocked: false, IP: "123"}, comment.User)
+	require.NotNil(t, comment.DeletedAt)
+	deletedAt := *comment.DeletedAt
+	assert.WithinDuration(t, time.Now(), deletedAt, time.Second)
+
+	comment.SetDeleted(HardDelete)
+	assert.Equal(t, deletedAt, *comment.DeletedAt, "time of the first deletion kept")
 }
 
 func TestComment_SetDeletedHard(t *testing.T) {
@@ -4,7 +4,11 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/go-pkgz/lgr"
@@ -26,8 +30,15 @@ import (
 //  - blocking info sits in "block" bucket. Key is userID, value - ts
 //  - counts per post to keep number of comments. Key is post url, value - count
 //  - readonly per post to keep status of manually set RO posts. Key is post url, value - ts
+//  - slowmode per post to keep status of posts with delayed visibility of new comments. Key is post url, value - ts
+//  - shadowed per user to keep status of shadow-banned users. Key is userID, value - ts
+//  - records of other services in "records" bucket. Each kind makes its own bucket and each k:v pair is key:value
 type BoltDB struct {
-	dbs map[string]*bolt.DB
+	dbs     map[string]*bolt.DB
+	lazy    map[string]string // files of sites opened on first use
+	options bolt.Options
+	dir     string // directory of sites added at runtime, the same as of the first site
+	lock    sync.RWMutex
 }
 
 const (
@@ -40,6 +51,9 @@ const (
 	infoBucketName        = "info"
 	readonlyBucketName    = "readonly"
 	verifiedBucketName    = "verified"
+	slowModeBucketName    = "slowmode"
+	shadowedBucketName    = "shadowed"
+	recordsBucketName     = "records"
 
 	tsNano = "2006-01-02T15:04:05.000000000Z07:00"
 )
@@ -48,40 +62,198 @@ const (
 type BoltSite struct {
 	FileName string // full path to boltdb
 	SiteID   string // ID of given site
+	Lazy     bool   // open file on first use instead of start
 }
 
 // NewBoltDB makes persistent boltdb-based store. For each site new boltdb file created
 func NewBoltDB(options bolt.Options, sites ...BoltSite) (*BoltDB, error) {
 	log.Printf("[INFO] bolt store for sites %+v, options %+v", sites, options)
-	result := BoltDB{dbs: make(map[string]*bolt.DB)}
-	for _, site := range sites {
-		db, err := bolt.Open(site.FileName, 0600, &options) //nolint:gocritic //octalLiteral is OK as FileMode
-		if err != nil {
-			return nil, errors.Wrapf(err, "failed to make boltdb for %s", site.FileName)
+	result := BoltDB{dbs: make(map[string]*bolt.DB), lazy: make(map[string]string), options: options}
+	for i, site := range sites {
+		if i == 0 {
+			result.dir = filepath.Dir(site.FileName)
 		}
-
-		// make top-level buckets
-		topBuckets := []string{postsBucketName, lastBucketName, userBucketName, userDetailsBucketName,
-			blocksBucketName, infoBucketName, readonlyBucketName, verifiedBucketName}
-		err = db.Update(func(tx *bolt.Tx) error {
-			for _, bktName := range topBuckets {
-				if _, e := tx.CreateBucketIfNotExists([]byte(bktName)); e != nil {
-					return errors.Wrapf(e, "failed to create top level bucket %s", bktName)
-				}
-			}
-			return nil
-		})
-
+		if site.Lazy {
+			result.lazy[site.SiteID] = site.FileName
+			continue
+		}
+		db, err := openBoltSite(site.FileName, options)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to create top level bucket)")
+			_ = result.Close()
+			return nil, err
 		}
-
 		result.dbs[site.SiteID] = db
 		log.Printf("[DEBUG] bolt store created for %s", site.SiteID)
 	}
 	return &result, nil
 }
 
+// AddSite opens boltdb file of the site in directory of other sites, named {siteID}.db
+func (b *BoltDB) AddSite(siteID string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if _, ok := b.dbs[siteID]; ok {
+		return nil
+	}
+	if _, ok := b.lazy[siteID]; ok {
+		return nil
+	}
+	db, err := openBoltSite(filepath.Join(b.dir, siteID+".db"), b.options)
+	if err != nil {
+		return err
+	}
+	b.dbs[siteID] = db
+	log.Printf("[INFO] bolt store added for %s", siteID)
+	return nil
+}
+
+// RemoveSite closes boltdb of the site and removes its file
+func (b *BoltDB) RemoveSite(siteID string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	fileName, err := b.detach(siteID)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(fileName); err != nil {
+		return errors.Wrapf(err, "can't remove %s", fileName)
+	}
+	log.Printf("[INFO] bolt store removed for %s", siteID)
+	return nil
+}
+
+// DetachSite closes boltdb of the site and stops serving it, the file kept as is and can be moved to another instance.
+// Returns the file name.
+func (b *BoltDB) DetachSite(siteID string) (string, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	fileName, err := b.detach(siteID)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("[INFO] bolt store detached for %s, file %s", siteID, fileName)
+	return fileName, nil
+}
+
+// BackupSite writes consistent copy of the site's boltdb to w, writes of the site not blocked
+func (b *BoltDB) BackupSite(siteID string, w io.Writer) error {
+	bdb, err := b.db(siteID)
+	if err != nil {
+		return err
+	}
+	return bdb.View(func(tx *bolt.Tx) error {
+		_, e := tx.WriteTo(w)
+		return errors.Wrapf(e, "can't write backup of site %s", siteID)
+	})
+}
+
+// RestoreSite replaces boltdb of the site with the copy made by BackupSite. The copy checked and written
+// next to the current file first, so the current data kept if the copy is broken.
+func (b *BoltDB) RestoreSite(siteID string, r io.Reader) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	fileName := b.lazy[siteID]
+	if db, ok := b.dbs[siteID]; ok {
+		fileName = db.Path()
+	}
+	if fileName == "" {
+		return errors.Errorf("site %q not found", siteID)
+	}
+
+	tmpName := fileName + ".restore"
+	if err := writeBoltCopy(tmpName, r, b.options); err != nil {
+		_ = os.Remove(tmpName)
+		return errors.Wrapf(err, "can't restore site %s", siteID)
+	}
+	if _, err := b.detach(siteID); err != nil {
+		_ = os.Remove(tmpName)
+		return err
+	}
+	if err := os.Rename(tmpName, fileName); err != nil {
+		return errors.Wrapf(err, "can't replace %s", fileName)
+	}
+	db, err := openBoltSite(fileName, b.options)
+	if err != nil {
+		return err
+	}
+	b.dbs[siteID] = db
+	log.Printf("[INFO] bolt store restored for %s", siteID)
+	return nil
+}
+
+// detach closes boltdb of the site and removes it from served sites, returns the file name. Should be called under lock.
+func (b *BoltDB) detach(siteID string) (string, error) {
+	if fileName, ok := b.lazy[siteID]; ok {
+		delete(b.lazy, siteID)
+		return fileName, nil
+	}
+	db, ok := b.dbs[siteID]
+	if !ok {
+		return "", errors.Errorf("site %q not found", siteID)
+	}
+	delete(b.dbs, siteID)
+	fileName := db.Path()
+	if err := db.Close(); err != nil {
+		return "", errors.Wrapf(err, "can't close site %s", siteID)
+	}
+	return fileName, nil
+}
+
+// writeBoltCopy writes content of r to the file and checks it is a valid boltdb
+func writeBoltCopy(fileName string, r io.Reader, options bolt.Options) error {
+	fh, err := os.OpenFile(fileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600) //nolint:gocritic //octalLiteral is OK as FileMode
+	if err != nil {
+		return errors.Wrapf(err, "can't make %s", fileName)
+	}
+	if _, err = io.Copy(fh, r); err != nil {
+		_ = fh.Close()
+		return errors.Wrapf(err, "can't write %s", fileName)
+	}
+	if err = fh.Close(); err != nil {
+		return errors.Wrapf(err, "can't close %s", fileName)
+	}
+	db, err := bolt.Open(fileName, 0600, &options) //nolint:gocritic //octalLiteral is OK as FileMode
+	if err != nil {
+		return errors.Wrap(err, "invalid boltdb")
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(postsBucketName)) == nil {
+			return errors.Errorf("no %s bucket", postsBucketName)
+		}
+		return nil
+	})
+	if e := db.Close(); e != nil && err == nil {
+		err = e
+	}
+	return errors.Wrap(err, "invalid boltdb")
+}
+
+// openBoltSite opens boltdb file and makes its top-level buckets
+func openBoltSite(fileName string, options bolt.Options) (*bolt.DB, error) {
+	db, err := bolt.Open(fileName, 0600, &options) //nolint:gocritic //octalLiteral is OK as FileMode
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to make boltdb for %s", fileName)
+	}
+
+	// make top-level buckets
+	topBuckets := []string{postsBucketName, lastBucketName, userBucketName, userDetailsBucketName,
+		blocksBucketName, infoBucketName, readonlyBucketName, verifiedBucketName, slowModeBucketName,
+		shadowedBucketName, recordsBucketName}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bktName := range topBuckets {
+			if _, e := tx.CreateBucketIfNotExists([]byte(bktName)); e != nil {
+				return errors.Wrapf(e, "failed to create top level bucket %s", bktName)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, errors.Wrap(err, "failed to create top level bucket)")
+	}
+	return db, nil
+}
+
 // Create saves new comment to store. Adds to posts bucket, reference to last and user bucket and increments count bucket
 func (b *BoltDB) Create(comment store.Comment) (commentID string, err error) {
 	bdb, err := b.db(comment.Locator.SiteID)
@@ -206,17 +378,108 @@ func (b *BoltDB) Flag(req FlagRequest) (val bool, err error) {
 	return b.setFlag(req)
 }
 
+// Reattribute moves all comments of req.FromID, or only req.IDs if set, to req.To user and moves references
+// in users bucket. Returns ids of moved comments.
+func (b *BoltDB) Reattribute(req ReattributeRequest) (ids []string, err error) {
+	if err = req.validate(); err != nil {
+		return nil, err
+	}
+	bdb, err := b.db(req.Locator.SiteID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = bdb.Update(func(tx *bolt.Tx) error {
+		usersBkt := tx.Bucket([]byte(userBucketName))
+		fromBkt := usersBkt.Bucket([]byte(req.FromID))
+		if fromBkt == nil {
+			return errors.Errorf("no comments for user %s in store", req.FromID)
+		}
+		toBkt, e := b.getUserBucket(tx, req.To.ID)
+		if e != nil {
+			return e
+		}
+
+		keys, refs := [][]byte{}, [][]byte{}
+		e = fromBkt.ForEach(func(k, v []byte) error {
+			keys, refs = append(keys, append([]byte{}, k...)), append(refs, append([]byte{}, v...))
+			return nil
+		})
+		if e != nil {
+			return errors.Wrapf(e, "can't list comments of %s", req.FromID)
+		}
+
+		for i, ref := range refs {
+			url, commentID, e := b.parseRef(ref)
+			if e != nil {
+				return e
+			}
+			if !req.selected(commentID) {
+				continue
+			}
+			postBkt, e := b.getPostBucket(tx, url)
+			if e != nil {
+				return e
+			}
+			comment := store.Comment{}
+			if e = b.load(postBkt, commentID, &comment); e != nil {
+				return errors.Wrapf(e, "can't load comment %s", commentID)
+			}
+			comment.User = req.user(comment.User)
+			if e = b.save(postBkt, commentID, comment); e != nil {
+				return e
+			}
+			if e = toBkt.Put(keys[i], ref); e != nil {
+				return errors.Wrapf(e, "failed to put user comment %s for %s", commentID, req.To.ID)
+			}
+			if e = fromBkt.Delete(keys[i]); e != nil {
+				return errors.Wrapf(e, "failed to delete user comment %s for %s", commentID, req.FromID)
+			}
+			ids = append(ids, commentID)
+		}
+		if len(ids) < len(refs) {
+			return nil // some comments left with the user
+		}
+		return errors.Wrapf(usersBkt.DeleteBucket([]byte(req.FromID)), "failed to delete user bucket for %s", req.FromID)
+	})
+	return ids, err
+}
+
+// Remap moves all comments of posts to new urls in a single transaction, along with references to the comments
+// in last and users buckets, post info and flags. Returns ids of moved comments.
+func (b *BoltDB) Remap(req RemapRequest) (ids []string, err error) {
+	if err = req.validate(); err != nil {
+		return nil, err
+	}
+	bdb, err := b.db(req.Locator.SiteID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = bdb.Update(func(tx *bolt.Tx) error {
+		for _, from := range req.froms() {
+			moved, e := b.remapPost(tx, from, req.URLs[from])
+			if e != nil {
+				return errors.Wrapf(e, "can't remap %s to %s", from, req.URLs[from])
+			}
+			ids = append(ids, moved...)
+		}
+		return nil
+	})
+	return ids, err
+}
+
 // UserDetail sets or gets single detail value, or gets all details for requested site.
 // UserDetail returns list even for single entry request is a compromise in order to have both single detail getting and setting
 // and all site's details listing under the same function (and not to extend interface by two separate functions).
 func (b *BoltDB) UserDetail(req UserDetailRequest) ([]UserDetailEntry, error) {
 	switch req.Detail {
-	case UserEmail:
+	case UserEmail, UserConsent, UserProfile:
 		if req.UserID == "" {
 			return nil, errors.New("userid cannot be empty in request for single detail")
 		}
 
-		if req.Update == "" { // read detail value, no update requested
+		if req.Update == "" && req.Profile == nil { // read detail value, no update requested
 			return b.getUserDetail(req)
 		}
 
@@ -233,6 +496,67 @@ func (b *BoltDB) UserDetail(req UserDetailRequest) ([]UserDetailEntry, error) {
 	}
 }
 
+// Record sets, gets, lists or deletes records of the site, bucket of the kind made on first write
+func (b *BoltDB) Record(req RecordRequest) (res []RecordEntry, err error) {
+	if err = req.validate(); err != nil {
+		return nil, err
+	}
+	bdb, err := b.db(req.Locator.SiteID)
+	if err != nil {
+		return nil, err
+	}
+
+	res = []RecordEntry{}
+	if req.Op == RecordGet || req.Op == RecordList {
+		err = bdb.View(func(tx *bolt.Tx) error {
+			bkt := tx.Bucket([]byte(recordsBucketName)).Bucket([]byte(req.Kind))
+			if bkt == nil {
+				return nil
+			}
+			if req.Op == RecordGet {
+				if v := bkt.Get([]byte(req.Key)); v != nil {
+					res = append(res, RecordEntry{Key: req.Key, Value: append(json.RawMessage{}, v...)})
+				}
+				return nil
+			}
+			c := bkt.Cursor()
+			for k, v := c.Seek([]byte(req.Key)); k != nil && strings.HasPrefix(string(k), req.Key); k, v = c.Next() {
+				res = append(res, RecordEntry{Key: string(k), Value: append(json.RawMessage{}, v...)})
+			}
+			return nil
+		})
+		return res, errors.Wrapf(err, "failed to get %s records", req.Kind)
+	}
+
+	err = bdb.Update(func(tx *bolt.Tx) error {
+		bkt, e := tx.Bucket([]byte(recordsBucketName)).CreateBucketIfNotExists([]byte(req.Kind))
+		if e != nil {
+			return errors.Wrapf(e, "failed to make bucket of %s records", req.Kind)
+		}
+		old := bkt.Get([]byte(req.Key))
+		switch req.Op {
+		case RecordAdd, RecordSet:
+			if req.Op == RecordAdd && old != nil {
+				return nil
+			}
+			if e = bkt.Put([]byte(req.Key), req.Value); e != nil {
+				return errors.Wrapf(e, "failed to put %s", req.Key)
+			}
+			res = append(res, RecordEntry{Key: req.Key, Value: req.Value})
+		case RecordDelete:
+			if old == nil {
+				return nil
+			}
+			res = append(res, RecordEntry{Key: req.Key, Value: append(json.RawMessage{}, old...)})
+			if e = bkt.Delete([]byte(req.Key)); e != nil {
+				return errors.Wrapf(e, "failed to delete %s", req.Key)
+			}
+		}
+		return nil
+	})
+	return res, errors.Wrapf(err, "failed to update %s records", req.Kind)
+}
+
 // Update for locator.URL with mutable part of comment
 func (b *BoltDB) Update(comment store.Comment) error {
 
@@ -362,9 +686,12 @@ func (b *BoltDB) ListFlags(req FlagRequest) (res []interface{}, err error) {
 
 	res = []interface{}{}
 	switch req.Flag {
-	case Verified:
+	case Verified, Shadowed:
 		err = bdb.View(func(tx *bolt.Tx) error {
-			usersBkt := tx.Bucket([]byte(verifiedBucketName))
+			usersBkt, errBkt := b.flagBucket(tx, req.Flag)
+			if errBkt != nil {
+				return errBkt
+			}
 			_ = usersBkt.ForEach(func(k, _ []byte) error {
 				res = append(res, string(k))
 				return nil
@@ -411,6 +738,8 @@ func (b *BoltDB) Delete(req DeleteRequest) error {
 		return b.deleteUserDetail(bdb, req.UserID, req.UserDetail)
 	case req.Locator.URL != "" && req.CommentID != "" && req.UserDetail == "": // delete comment
 		return b.deleteComment(bdb, req.Locator, req.CommentID, req.DeleteMode)
+	case req.Locator.URL != "" && req.CommentID == "" && req.UserID == "" && req.UserDetail == "": // delete post
+		return b.deletePost(bdb, req.Locator)
 	case req.Locator.SiteID != "" && req.UserID != "" && req.CommentID == "" && req.UserDetail == "": // delete user
 		return b.deleteUser(bdb, req.Locator.SiteID, req.UserID, req.DeleteMode)
 	case req.Locator.SiteID != "" && req.Locator.URL == "" && req.CommentID == "" && req.UserID == "" && req.UserDetail == "": // delete site
@@ -420,8 +749,26 @@ func (b *BoltDB) Delete(req DeleteRequest) error {
 	return errors.Errorf("invalid delete request %+v", req)
 }
 
+// Snapshot writes consistent copy of site's db to w. Started called with size of the copy as soon as read
+// transaction opened, changes made after this point are not included in the copy.
+func (b *BoltDB) Snapshot(siteID string, w io.Writer, started func(size int64)) error {
+	bdb, err := b.db(siteID)
+	if err != nil {
+		return err
+	}
+	return bdb.View(func(tx *bolt.Tx) error {
+		if started != nil {
+			started(tx.Size())
+		}
+		_, e := tx.WriteTo(w)
+		return errors.Wrapf(e, "can't write snapshot of %s", siteID)
+	})
+}
+
 // Close boltdb store
 func (b *BoltDB) Close() error {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
 	errs := new(multierror.Error)
 	for site, db := range b.dbs {
 		err := errors.Wrapf(db.Close(), "can't close site %s", site)
@@ -642,6 +989,10 @@ func (b *BoltDB) flagBucket(tx *bolt.Tx, flag Flag) (bkt *bolt.Bucket, err error
 		bkt = tx.Bucket([]byte(blocksBucketName))
 	case Verified:
 		bkt = tx.Bucket([]byte(verifiedBucketName))
+	case SlowMode:
+		bkt = tx.Bucket([]byte(slowModeBucketName))
+	case Shadowed:
+		bkt = tx.Bucket([]byte(shadowedBucketName))
 	default:
 		return nil, errors.Errorf("unsupported flag %v", flag)
 	}
@@ -668,6 +1019,10 @@ func (b *BoltDB) getUserDetail(req UserDetailRequest) (result []UserDetailEntry,
 			switch req.Detail {
 			case UserEmail:
 				result = []UserDetailEntry{{UserID: req.UserID, Email: entry.Email}}
+			case UserConsent:
+				result = []UserDetailEntry{{UserID: req.UserID, Consent: entry.Consent, ConsentTime: entry.ConsentTime}}
+			case UserProfile:
+				result = []UserDetailEntry{{UserID: req.UserID, Profile: entry.Profile}}
 			}
 		}
 		return nil
@@ -708,6 +1063,15 @@ func (b *BoltDB) setUserDetail(req UserDetailRequest) (result []UserDetailEntry,
 	switch req.Detail {
 	case UserEmail:
 		entry.Email = req.Update
+	case UserConsent:
+		ts := time.Now()
+		if req.Time != nil {
+			ts = *req.Time
+		}
+		ts = ts.UTC()
+		entry.Consent, entry.ConsentTime = req.Update, &ts
+	case UserProfile:
+		entry.Profile = req.Profile
 	}
 
 	err = bdb.Update(func(tx *bolt.Tx) error {
@@ -726,9 +1090,9 @@ func (b *BoltDB) listDetails(loc store.Locator) (result []UserDetailEntry, err e
 	}
 
 	err = bdb.View(func(tx *bolt.Tx) error {
-		var entry UserDetailEntry
 		bucket := tx.Bucket([]byte(userDetailsBucketName))
 		return bucket.ForEach(func(userID, value []byte) error {
+			var entry UserDetailEntry
 			if err = json.Unmarshal(value, &entry); err != nil {
 				return errors.Wrap(e, "failed to unmarshal entry")
 			}
@@ -765,6 +1129,10 @@ func (b *BoltDB) deleteUserDetail(bdb *bolt.DB, userID string, userDetail UserDe
 	switch userDetail {
 	case UserEmail:
 		entry.Email = ""
+	case UserConsent:
+		entry.Consent, entry.ConsentTime = "", nil
+	case UserProfile:
+		entry.Profile = nil
 	case AllUserDetails:
 		entry = UserDetailEntry{UserID: userID}
 	}
@@ -822,7 +1190,157 @@ func (b *BoltDB) deleteComment(bdb *bolt.DB, locator store.Locator, commentID st
 	})
 }
 
-// deleteAll removes all top-level buckets for given siteID
+// deletePost removes post bucket with all comments, references to them from last and users buckets
+// and post info. Read-only flag of the post kept.
+func (b *BoltDB) deletePost(bdb *bolt.DB, locator store.Locator) error {
+
+	return bdb.Update(func(tx *bolt.Tx) error {
+
+		postBkt, e := b.getPostBucket(tx, locator.URL)
+		if e != nil {
+			return e
+		}
+
+		lastBkt := tx.Bucket([]byte(lastBucketName))
+		usersBkt := tx.Bucket([]byte(userBucketName))
+		e = postBkt.ForEach(func(_ []byte, commentVal []byte) error {
+			comment := store.Comment{}
+			if err := json.Unmarshal(commentVal, &comment); err != nil {
+				return errors.Wrap(err, "failed to unmarshal")
+			}
+			commentTS := []byte(comment.Timestamp.Format(tsNano))
+			if err := lastBkt.Delete(commentTS); err != nil {
+				return errors.Wrapf(err, "can't delete comment %s from bucket %s", comment.ID, lastBucketName)
+			}
+			if userBkt := usersBkt.Bucket([]byte(comment.User.ID)); userBkt != nil {
+				if err := userBkt.Delete(commentTS); err != nil {
+					return errors.Wrapf(err, "can't delete comment %s from user %s", comment.ID, comment.User.ID)
+				}
+			}
+			return nil
+		})
+		if e != nil {
+			return errors.Wrapf(e, "failed to delete references to comments of %s", locator.URL)
+		}
+
+		if e = tx.Bucket([]byte(postsBucketName)).DeleteBucket([]byte(locator.URL)); e != nil {
+			return errors.Wrapf(e, "failed to delete bucket %s", locator.URL)
+		}
+		return errors.Wrapf(tx.Bucket([]byte(infoBucketName)).Delete([]byte(locator.URL)),
+			"failed to delete info for %s", locator.URL)
+	})
+}
+
+// remapPost moves comments of the post to another url. Comments, references and info merged with the post
+// of new url if it has comments already, flags and records of new url kept. Should run in update tx.
+func (b *BoltDB) remapPost(tx *bolt.Tx, from, to string) (ids []string, err error) {
+	postsBkt := tx.Bucket([]byte(postsBucketName))
+	fromBkt := postsBkt.Bucket([]byte(from))
+	if fromBkt == nil {
+		return nil, nil // no comments for the post
+	}
+	toBkt, err := b.makePostBucket(tx, to)
+	if err != nil {
+		return nil, err
+	}
+
+	lastBkt := tx.Bucket([]byte(lastBucketName))
+	usersBkt := tx.Bucket([]byte(userBucketName))
+	err = fromBkt.ForEach(func(k, v []byte) error {
+		comment := store.Comment{}
+		if e := json.Unmarshal(v, &comment); e != nil {
+			return errors.Wrap(e, "failed to unmarshal")
+		}
+		if toBkt.Get(k) != nil {
+			return errors.Errorf("key %s already in store", comment.ID)
+		}
+		comment.Locator.URL = to
+		if e := b.save(toBkt, comment.ID, comment); e != nil {
+			return e
+		}
+
+		// references kept only for comments having them, hard-deleted comments have no references in last bucket
+		ref, commentTS := b.makeRef(comment), []byte(comment.Timestamp.Format(tsNano))
+		if lastBkt.Get(commentTS) != nil {
+			if e := lastBkt.Put(commentTS, ref); e != nil {
+				return errors.Wrapf(e, "can't put reference %s to %s", ref, lastBucketName)
+			}
+		}
+		if userBkt := usersBkt.Bucket([]byte(comment.User.ID)); userBkt != nil && userBkt.Get(commentTS) != nil {
+			if e := userBkt.Put(commentTS, ref); e != nil {
+				return errors.Wrapf(e, "failed to put user comment %s for %s", comment.ID, comment.User.ID)
+			}
+		}
+		ids = append(ids, comment.ID)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = postsBkt.DeleteBucket([]byte(from)); err != nil {
+		return nil, errors.Wrapf(err, "failed to delete bucket %s", from)
+	}
+
+	infoBkt := tx.Bucket([]byte(infoBucketName))
+	info := store.PostInfo{}
+	if e := b.load(infoBkt, from, &info); e == nil {
+		toInfo := store.PostInfo{}
+		if e = b.load(infoBkt, to, &toInfo); e == nil {
+			info.Count += toInfo.Count
+			if toInfo.FirstTS.Before(info.FirstTS) {
+				info.FirstTS = toInfo.FirstTS
+			}
+			if toInfo.LastTS.After(info.LastTS) {
+				info.LastTS = toInfo.LastTS
+			}
+		}
+		info.URL = to
+		if err = b.save(infoBkt, to, &info); err != nil {
+			return nil, err
+		}
+		if err = infoBkt.Delete([]byte(from)); err != nil {
+			return nil, errors.Wrapf(err, "failed to delete info for %s", from)
+		}
+	}
+
+	for _, name := range []string{readonlyBucketName, slowModeBucketName} {
+		flagBkt := tx.Bucket([]byte(name))
+		val := flagBkt.Get([]byte(from))
+		if val == nil {
+			continue
+		}
+		if flagBkt.Get([]byte(to)) == nil {
+			if err = flagBkt.Put([]byte(to), append([]byte{}, val...)); err != nil {
+				return nil, errors.Wrapf(err, "failed to move %s flag of %s", name, from)
+			}
+		}
+		if err = flagBkt.Delete([]byte(from)); err != nil {
+			return nil, errors.Wrapf(err, "failed to delete %s flag of %s", name, from)
+		}
+	}
+
+	for _, kind := range postRecordKinds {
+		kindBkt := tx.Bucket([]byte(recordsBucketName)).Bucket([]byte(kind))
+		if kindBkt == nil {
+			continue
+		}
+		val := kindBkt.Get([]byte(from))
+		if val == nil {
+			continue
+		}
+		if kindBkt.Get([]byte(to)) == nil {
+			if err = kindBkt.Put([]byte(to), append([]byte{}, val...)); err != nil {
+				return nil, errors.Wrapf(err, "failed to move %s record of %s", kind, from)
+			}
+		}
+		if err = kindBkt.Delete([]byte(from)); err != nil {
+			return nil, errors.Wrapf(err, "failed to delete %s record of %s", kind, from)
+		}
+	}
+	return ids, nil
+}
+
+// deleteAll removes all top-level buckets for given siteID, except flags and records
 func (b *BoltDB) deleteAll(bdb *bolt.DB, siteID string) error {
 
 	// delete all buckets except blocked users
@@ -1016,10 +1534,31 @@ func (b *BoltDB) setInfo(tx *bolt.Tx, comment store.Comment) (store.PostInfo, er
 }
 
 func (b *BoltDB) db(siteID string) (*bolt.DB, error) {
-	if res, ok := b.dbs[siteID]; ok {
+	b.lock.RLock()
+	res, ok := b.dbs[siteID]
+	b.lock.RUnlock()
+	if ok {
 		return res, nil
 	}
-	return nil, errors.Errorf("site %q not found", siteID)
+
+	// open lazy site on first use
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if res, ok = b.dbs[siteID]; ok {
+		return res, nil
+	}
+	fileName, ok := b.lazy[siteID]
+	if !ok {
+		return nil, errors.Errorf("site %q not found", siteID)
+	}
+	res, err := openBoltSite(fileName, b.options)
+	if err != nil {
+		return nil, err
+	}
+	delete(b.lazy, siteID)
+	b.dbs[siteID] = res
+	log.Printf("[DEBUG] bolt store opened for %s", siteID)
+	return res, nil
 }
 
 // makeRef creates reference combining url and comment id
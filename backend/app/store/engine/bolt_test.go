@@ -1,8 +1,12 @@
 package engine
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -41,6 +45,86 @@ func TestBoltDB_CreateAndFind(t *testing.T) {
 	assert.NoError(t, b.Close())
 }
 
+func TestBoltDB_AddRemoveSite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bolt-sites")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	b, err := NewBoltDB(bolt.Options{}, BoltSite{FileName: dir + "/radio-t.db", SiteID: "radio-t"})
+	require.NoError(t, err)
+	defer b.Close()
+
+	comment := store.Comment{ID: "c1", Text: "text", Locator: store.Locator{URL: "https://blog.example.com/1", SiteID: "blog"},
+		User: store.User{ID: "user1"}}
+	_, err = b.Create(comment)
+	assert.EqualError(t, err, `site "blog" not found`)
+
+	require.NoError(t, b.AddSite("blog"))
+	require.NoError(t, b.AddSite("blog"), "no-op for existing site")
+	_, err = b.Create(comment)
+	require.NoError(t, err)
+	_, err = os.Stat(dir + "/blog.db")
+	assert.NoError(t, err, "file made next to other sites")
+
+	require.NoError(t, b.RemoveSite("blog"))
+	_, err = os.Stat(dir + "/blog.db")
+	assert.True(t, os.IsNotExist(err), "file removed")
+	_, err = b.Get(GetRequest{Locator: comment.Locator, CommentID: "c1"})
+	assert.EqualError(t, err, `site "blog" not found`)
+	assert.Error(t, b.RemoveSite("blog"))
+}
+
+func TestBoltDB_LazyBackupRestoreDetach(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bolt-isolation")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	b, err := NewBoltDB(bolt.Options{}, BoltSite{FileName: dir + "/radio-t.db", SiteID: "radio-t"},
+		BoltSite{FileName: dir + "/blog/blog.db", SiteID: "blog", Lazy: true})
+	require.NoError(t, err, "lazy site not opened on start, its directory missing")
+	defer b.Close()
+
+	comment := store.Comment{ID: "c1", Text: "text", Locator: store.Locator{URL: "https://blog.example.com/1", SiteID: "blog"},
+		User: store.User{ID: "user1"}}
+	_, err = b.Create(comment)
+	assert.Error(t, err, "can't open file in missing directory")
+	require.NoError(t, os.Mkdir(dir+"/blog", 0700))
+	_, err = b.Create(comment)
+	require.NoError(t, err, "opened on first use")
+
+	backup := bytes.Buffer{}
+	require.NoError(t, b.BackupSite("blog", &backup))
+	_, err = b.Create(store.Comment{ID: "c2", Text: "text", Locator: comment.Locator, User: store.User{ID: "user1"}})
+	require.NoError(t, err)
+
+	assert.Error(t, b.RestoreSite("blog", strings.NewReader("not a bolt file")))
+	count, err := b.Count(FindRequest{Locator: comment.Locator})
+	require.NoError(t, err)
+	assert.Equal(t, 2, count, "kept on broken copy")
+
+	require.NoError(t, b.RestoreSite("blog", &backup))
+	count, err = b.Count(FindRequest{Locator: comment.Locator})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count, "restored from copy")
+	_, err = os.Stat(dir + "/blog/blog.db.restore")
+	assert.True(t, os.IsNotExist(err), "temporary copy renamed")
+
+	fileName, err := b.DetachSite("blog")
+	require.NoError(t, err)
+	assert.Equal(t, dir+"/blog/blog.db", fileName)
+	_, err = os.Stat(fileName)
+	assert.NoError(t, err, "file kept")
+	_, err = b.Count(FindRequest{Locator: comment.Locator})
+	assert.EqualError(t, err, `site "blog" not found`)
+	_, err = b.DetachSite("blog")
+	assert.Error(t, err)
+
+	_, err = b.Create(store.Comment{ID: "c1", Text: "text", Locator: store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"},
+		User: store.User{ID: "user1"}})
+	assert.NoError(t, err, "other site served")
+	assert.EqualError(t, b.BackupSite("blog", &backup), `site "blog" not found`)
+}
+
 func TestBoltDB_CreateFailedReadOnly(t *testing.T) {
 	var b, teardown = prep(t)
 	defer teardown()
@@ -574,6 +658,36 @@ func TestBolt_FlagListVerified(t *testing.T) {
 	assert.Error(t, err, "site \"radio-t-bad\" not found", "fail on wrong site")
 }
 
+func TestBolt_FlagShadowed(t *testing.T) {
+
+	b, teardown := prep(t)
+	defer teardown()
+
+	setShadowed := func(user string, status FlagStatus) error {
+		req := FlagRequest{Flag: Shadowed, Locator: store.Locator{SiteID: "radio-t"}, UserID: user, Update: status}
+		_, err := b.Flag(req)
+		return err
+	}
+
+	assert.NoError(t, setShadowed("u1", FlagTrue))
+	assert.NoError(t, setShadowed("u2", FlagTrue))
+	v, err := b.Flag(FlagRequest{Flag: Shadowed, Locator: store.Locator{SiteID: "radio-t"}, UserID: "u1"})
+	assert.NoError(t, err)
+	assert.True(t, v, "u1 shadowed")
+
+	ids, err := b.ListFlags(FlagRequest{Flag: Shadowed, Locator: store.Locator{SiteID: "radio-t"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"u1", "u2"}, ids)
+
+	assert.NoError(t, setShadowed("u1", FlagFalse))
+	v, err = b.Flag(FlagRequest{Flag: Shadowed, Locator: store.Locator{SiteID: "radio-t"}, UserID: "u1"})
+	assert.NoError(t, err)
+	assert.False(t, v, "u1 not shadowed anymore")
+	ids, err = b.ListFlags(FlagRequest{Flag: Shadowed, Locator: store.Locator{SiteID: "radio-t"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"u2"}, ids)
+}
+
 func TestBolt_FlagListBlocked(t *testing.T) {
 
 	b, teardown := prep(t)
@@ -667,6 +781,264 @@ func TestBoltDB_UserDetail(t *testing.T) {
 	}
 }
 
+func TestBoltDB_Record(t *testing.T) {
+	b, teardown := prep(t)
+	defer teardown()
+
+	loc := store.Locator{SiteID: "radio-t"}
+	res, err := b.Record(RecordRequest{Op: RecordGet, Kind: Drafts, Locator: loc, Key: "k1"})
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	res, err = b.Record(RecordRequest{Op: RecordSet, Kind: Drafts, Locator: loc, Key: "k1", Value: json.RawMessage(`"v1"`)})
+	require.NoError(t, err)
+	assert.Equal(t, []RecordEntry{{Key: "k1", Value: json.RawMessage(`"v1"`)}}, res)
+	res, err = b.Record(RecordRequest{Op: RecordAdd, Kind: Drafts, Locator: loc, Key: "k1", Value: json.RawMessage(`"v2"`)})
+	require.NoError(t, err)
+	assert.Empty(t, res, "used key not added")
+	res, err = b.Record(RecordRequest{Op: RecordAdd, Kind: Drafts, Locator: loc, Key: "k2", Value: json.RawMessage(`"v2"`)})
+	require.NoError(t, err)
+	assert.Equal(t, []RecordEntry{{Key: "k2", Value: json.RawMessage(`"v2"`)}}, res)
+	_, err = b.Record(RecordRequest{Op: RecordSet, Kind: Drafts, Locator: loc, Key: "x1", Value: json.RawMessage(`3`)})
+	require.NoError(t, err)
+	_, err = b.Record(RecordRequest{Op: RecordSet, Kind: "other", Locator: loc, Key: "k3", Value: json.RawMessage(`"v3"`)})
+	require.NoError(t, err)
+
+	res, err = b.Record(RecordRequest{Op: RecordGet, Kind: Drafts, Locator: loc, Key: "k1"})
+	require.NoError(t, err)
+	assert.Equal(t, []RecordEntry{{Key: "k1", Value: json.RawMessage(`"v1"`)}}, res)
+	res, err = b.Record(RecordRequest{Op: RecordList, Kind: Drafts, Locator: loc, Key: "k"})
+	require.NoError(t, err)
+	assert.Equal(t, []RecordEntry{{Key: "k1", Value: json.RawMessage(`"v1"`)}, {Key: "k2", Value: json.RawMessage(`"v2"`)}}, res)
+	res, err = b.Record(RecordRequest{Op: RecordList, Kind: Drafts, Locator: loc})
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(res))
+
+	res, err = b.Record(RecordRequest{Op: RecordDelete, Kind: Drafts, Locator: loc, Key: "k1"})
+	require.NoError(t, err)
+	assert.Equal(t, []RecordEntry{{Key: "k1", Value: json.RawMessage(`"v1"`)}}, res)
+	res, err = b.Record(RecordRequest{Op: RecordDelete, Kind: Drafts, Locator: loc, Key: "k1"})
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	require.NoError(t, b.Delete(DeleteRequest{Locator: loc}))
+	res, err = b.Record(RecordRequest{Op: RecordList, Kind: Drafts, Locator: loc})
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(res), "records kept on deletion of all comments")
+
+	_, err = b.Record(RecordRequest{Op: RecordGet, Kind: Drafts, Locator: store.Locator{SiteID: "bad"}, Key: "k1"})
+	assert.EqualError(t, err, `site "bad" not found`)
+	_, err = b.Record(RecordRequest{Op: RecordGet, Kind: Drafts, Locator: loc})
+	assert.EqualError(t, err, "no key of drafts record")
+	_, err = b.Record(RecordRequest{Op: RecordSet, Kind: Drafts, Locator: loc, Key: "k1", Value: json.RawMessage(`bad`)})
+	assert.EqualError(t, err, "invalid value of drafts record k1")
+	_, err = b.Record(RecordRequest{Op: "bad", Kind: Drafts, Locator: loc, Key: "k1"})
+	assert.EqualError(t, err, `invalid record operation "bad"`)
+}
+
+func TestBoltDB_UserConsent(t *testing.T) {
+	b, teardown := prep(t)
+	defer teardown()
+
+	loc := store.Locator{SiteID: "radio-t"}
+	_, err := b.UserDetail(UserDetailRequest{Locator: loc, UserID: "u1", Detail: UserEmail, Update: "test@example.com"})
+	require.NoError(t, err)
+	res, err := b.UserDetail(UserDetailRequest{Locator: loc, UserID: "u1", Detail: UserConsent, Update: "v1"})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, "test@example.com", res[0].Email, "email kept")
+	assert.Equal(t, "v1", res[0].Consent)
+	require.NotNil(t, res[0].ConsentTime)
+	assert.WithinDuration(t, time.Now(), *res[0].ConsentTime, time.Second)
+
+	res, err = b.UserDetail(UserDetailRequest{Locator: loc, UserID: "u1", Detail: UserConsent})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, "", res[0].Email)
+	assert.Equal(t, "v1", res[0].Consent)
+	assert.NotNil(t, res[0].ConsentTime)
+
+	require.NoError(t, b.Delete(DeleteRequest{Locator: loc, UserID: "u1", UserDetail: UserEmail}))
+	res, err = b.UserDetail(UserDetailRequest{Locator: loc, Detail: AllUserDetails})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res), "entry with consent kept")
+	assert.Equal(t, "v1", res[0].Consent)
+
+	ts := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	res, err = b.UserDetail(UserDetailRequest{Locator: loc, UserID: "u1", Detail: UserConsent, Update: "v2", Time: &ts})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, ts, *res[0].ConsentTime, "time set from request")
+
+	require.NoError(t, b.Delete(DeleteRequest{Locator: loc, UserID: "u1", UserDetail: UserConsent}))
+	res, err = b.UserDetail(UserDetailRequest{Locator: loc, Detail: AllUserDetails})
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(res), "empty entry removed")
+}
+
+func TestBoltDB_UserProfile(t *testing.T) {
+	b, teardown := prep(t)
+	defer teardown()
+
+	loc := store.Locator{SiteID: "radio-t"}
+	_, err := b.UserDetail(UserDetailRequest{Locator: loc, UserID: "u1", Detail: UserEmail, Update: "test@example.com"})
+	require.NoError(t, err)
+	profile := store.Profile{DisplayName: "John", Website: "https://example.com", Bio: "bio"}
+	res, err := b.UserDetail(UserDetailRequest{Locator: loc, UserID: "u1", Detail: UserProfile, Profile: &profile})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, "test@example.com", res[0].Email, "email kept")
+	assert.Equal(t, &profile, res[0].Profile)
+
+	res, err = b.UserDetail(UserDetailRequest{Locator: loc, UserID: "u1", Detail: UserProfile})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, UserDetailEntry{UserID: "u1", Profile: &profile}, res[0])
+
+	require.NoError(t, b.Delete(DeleteRequest{Locator: loc, UserID: "u1", UserDetail: UserEmail}))
+	require.NoError(t, b.Delete(DeleteRequest{Locator: loc, UserID: "u1", UserDetail: UserProfile}))
+	res, err = b.UserDetail(UserDetailRequest{Locator: loc, Detail: AllUserDetails})
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(res), "empty entry removed")
+}
+
+func TestBoltDB_Reattribute(t *testing.T) {
+	b, teardown := prep(t)
+	defer teardown()
+
+	loc := store.Locator{SiteID: "radio-t"}
+	_, err := b.Create(store.Comment{ID: "id-3", Text: "text 3", Timestamp: time.Date(2017, 12, 20, 15, 18, 24, 0, time.Local),
+		Locator: store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}, User: store.User{ID: "user2", Name: "user2"}})
+	require.NoError(t, err)
+
+	ids, err := b.Reattribute(ReattributeRequest{Locator: loc, FromID: "user1",
+		To: store.User{ID: "user2", Name: "new name", Picture: "http://example.com/pic.png"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id-1", "id-2"}, ids)
+
+	res, err := b.Find(FindRequest{Locator: loc, UserID: "user2"})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(res), "comments moved to user2")
+	for _, c := range res {
+		assert.Equal(t, "user2", c.User.ID)
+	}
+	c, err := b.Get(GetRequest{Locator: store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"}, CommentID: "id-1"})
+	require.NoError(t, err)
+	assert.Equal(t, store.User{ID: "user2", Name: "new name", Picture: "http://example.com/pic.png"}, c.User)
+
+	_, err = b.Count(FindRequest{Locator: loc, UserID: "user1"})
+	assert.Error(t, err, "nothing left for user1")
+
+	_, err = b.Create(store.Comment{ID: "id-4", Text: "text 4", Timestamp: time.Date(2017, 12, 20, 15, 18, 25, 0, time.Local),
+		Locator: store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"},
+		User:    store.User{ID: "user3", Name: "user3", IP: "ip-hash", Website: "https://example.com"}})
+	require.NoError(t, err)
+	ids, err = b.Reattribute(ReattributeRequest{Locator: loc, FromID: "user3", To: store.User{ID: "anon", Name: "deleted"},
+		Anonymize: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id-4"}, ids)
+	c, err = b.Get(GetRequest{Locator: store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/2"}, CommentID: "id-4"})
+	require.NoError(t, err)
+	assert.Equal(t, store.User{ID: "anon", Name: "deleted"}, c.User, "other fields cleared")
+	assert.Equal(t, "text 4", c.Text)
+
+	_, err = b.Reattribute(ReattributeRequest{Locator: loc, FromID: "user1", To: store.User{ID: "user2"}})
+	assert.EqualError(t, err, "no comments for user user1 in store")
+	_, err = b.Reattribute(ReattributeRequest{Locator: loc, FromID: "user2", To: store.User{ID: "user2"}})
+	assert.EqualError(t, err, `invalid reattribute request from "user2" to "user2"`)
+	_, err = b.Reattribute(ReattributeRequest{Locator: store.Locator{SiteID: "bad"}, FromID: "user2", To: store.User{ID: "user3"}})
+	assert.EqualError(t, err, `site "bad" not found`)
+}
+
+func TestBoltDB_ReattributeIDs(t *testing.T) {
+	b, teardown := prep(t)
+	defer teardown()
+
+	loc := store.Locator{SiteID: "radio-t"}
+	ids, err := b.Reattribute(ReattributeRequest{Locator: loc, FromID: "user1", To: store.User{ID: "anon", Name: "deleted"},
+		Anonymize: true, IDs: []string{"id-2", "id-100"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id-2"}, ids)
+
+	res, err := b.Find(FindRequest{Locator: loc, UserID: "user1"})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res), "not selected comment kept")
+	assert.Equal(t, "id-1", res[0].ID)
+	res, err = b.Find(FindRequest{Locator: loc, UserID: "anon"})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, "id-2", res[0].ID)
+
+	ids, err = b.Reattribute(ReattributeRequest{Locator: loc, FromID: "user1", To: store.User{ID: "anon"}, IDs: []string{"id-1"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id-1"}, ids)
+	_, err = b.Count(FindRequest{Locator: loc, UserID: "user1"})
+	assert.Error(t, err, "nothing left for user1")
+}
+
+func TestBoltDB_Remap(t *testing.T) {
+	b, teardown := prep(t)
+	defer teardown()
+
+	loc := store.Locator{SiteID: "radio-t"}
+	_, err := b.Create(store.Comment{ID: "id-3", Text: "text 3", Timestamp: time.Date(2017, 12, 20, 15, 18, 24, 0, time.Local),
+		Locator: store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}, User: store.User{ID: "user2", Name: "user2"}})
+	require.NoError(t, err)
+	_, err = b.Flag(FlagRequest{Flag: ReadOnly, Locator: store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, Update: FlagTrue})
+	require.NoError(t, err)
+	_, err = b.Record(RecordRequest{Op: RecordSet, Kind: Intervals, Locator: store.Locator{SiteID: "radio-t"},
+		Key: "https://radio-t.com", Value: json.RawMessage(`10`)})
+	require.NoError(t, err)
+
+	// both posts moved to the same url, merged
+	ids, err := b.Remap(RemapRequest{Locator: loc, URLs: map[string]string{"https://radio-t.com": "https://new.radio-t.com",
+		"https://radio-t.com/2": "https://new.radio-t.com", "https://radio-t.com/unknown": "https://new.radio-t.com/3"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id-1", "id-2", "id-3"}, ids)
+
+	newLoc := store.Locator{URL: "https://new.radio-t.com", SiteID: "radio-t"}
+	res, err := b.Find(FindRequest{Locator: newLoc, Sort: "time"})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(res))
+	for _, c := range res {
+		assert.Equal(t, newLoc, c.Locator)
+	}
+	_, err = b.Find(FindRequest{Locator: store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}})
+	assert.EqualError(t, err, "no bucket https://radio-t.com in store", "old post removed")
+
+	info, err := b.Info(InfoRequest{Locator: newLoc})
+	require.NoError(t, err)
+	assert.Equal(t, 3, info[0].Count)
+	assert.Equal(t, "https://new.radio-t.com", info[0].URL)
+	assert.Equal(t, time.Date(2017, 12, 20, 15, 18, 22, 0, time.Local).Unix(), info[0].FirstTS.Unix())
+	assert.Equal(t, time.Date(2017, 12, 20, 15, 18, 24, 0, time.Local).Unix(), info[0].LastTS.Unix())
+	assert.True(t, info[0].ReadOnly, "read-only flag moved")
+	intervals, err := b.Record(RecordRequest{Op: RecordList, Kind: Intervals, Locator: store.Locator{SiteID: "radio-t"}})
+	require.NoError(t, err)
+	assert.Equal(t, []RecordEntry{{Key: "https://new.radio-t.com", Value: json.RawMessage(`10`)}}, intervals, "interval moved")
+	list, err := b.Info(InfoRequest{Locator: loc})
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(list))
+
+	last, err := b.Find(FindRequest{Locator: loc, Sort: "-time", Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(last))
+	assert.Equal(t, "https://new.radio-t.com", last[0].Locator.URL, "last references moved")
+	user, err := b.Find(FindRequest{Locator: loc, UserID: "user1", Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(user))
+	assert.Equal(t, "https://new.radio-t.com", user[0].Locator.URL, "user references moved")
+
+	_, err = b.Remap(RemapRequest{Locator: loc})
+	assert.EqualError(t, err, "no urls to remap")
+	_, err = b.Remap(RemapRequest{Locator: loc, URLs: map[string]string{"a": "a"}})
+	assert.EqualError(t, err, `invalid remap from "a" to "a"`)
+	_, err = b.Remap(RemapRequest{Locator: loc, URLs: map[string]string{"a": "b", "b": "c"}})
+	assert.EqualError(t, err, `invalid remap to "b", the url remapped itself`)
+	_, err = b.Remap(RemapRequest{Locator: store.Locator{SiteID: "bad"}, URLs: map[string]string{"a": "b"}})
+	assert.EqualError(t, err, `site "bad" not found`)
+}
+
 func TestBolt_DeleteComment(t *testing.T) {
 
 	b, teardown := prep(t)
@@ -764,6 +1136,46 @@ func TestBolt_DeleteAll(t *testing.T) {
 	assert.EqualError(t, err, `site "bad" not found`)
 }
 
+func TestBolt_DeletePost(t *testing.T) {
+
+	b, teardown := prep(t)
+	defer teardown()
+
+	comment := store.Comment{ID: "id-3", Text: "other post", Timestamp: time.Date(2017, 12, 20, 15, 18, 24, 0, time.Local),
+		Locator: store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}, User: store.User{ID: "user1", Name: "user name"}}
+	_, err := b.Create(comment)
+	require.NoError(t, err)
+	_, err = b.Flag(FlagRequest{Flag: ReadOnly, Locator: store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"}, Update: FlagTrue})
+	require.NoError(t, err)
+
+	err = b.Delete(DeleteRequest{Locator: store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"}})
+	require.NoError(t, err)
+
+	comments, err := b.Find(FindRequest{Locator: store.Locator{SiteID: "radio-t"}, Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(comments), "only comment of other post left in last")
+	assert.Equal(t, "id-3", comments[0].ID)
+
+	comments, err = b.Find(FindRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "user1", Limit: 10})
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(comments), "only comment of other post left for user")
+
+	_, err = b.Find(FindRequest{Locator: store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"}})
+	assert.Error(t, err, "post removed")
+
+	info, err := b.Info(InfoRequest{Locator: store.Locator{SiteID: "radio-t"}})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(info))
+	assert.Equal(t, "https://radio-t.com/2", info[0].URL)
+
+	ro, err := b.Flag(FlagRequest{Flag: ReadOnly, Locator: store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"}})
+	require.NoError(t, err)
+	assert.True(t, ro, "read-only flag kept")
+
+	err = b.Delete(DeleteRequest{Locator: store.Locator{SiteID: "radio-t", URL: "https://radio-t.com"}})
+	assert.EqualError(t, err, "no bucket https://radio-t.com in store")
+}
+
 func TestBolt_DeleteUserDetail(t *testing.T) {
 	var (
 		createUser = UserDetailRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "user1", Detail: UserEmail, Update: "value1"}
@@ -914,6 +1326,30 @@ func TestBoltDB_ref(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestBoltDB_Snapshot(t *testing.T) {
+	b, teardown := prep(t)
+	defer teardown()
+
+	snapFile := "/tmp/test-remark-snapshot.db"
+	defer os.Remove(snapFile)
+	fh, err := os.Create(snapFile)
+	require.NoError(t, err)
+	var size int64
+	require.NoError(t, b.Snapshot("radio-t", fh, func(sz int64) { size = sz }))
+	require.NoError(t, fh.Close())
+	st, err := os.Stat(snapFile)
+	require.NoError(t, err)
+	assert.Equal(t, st.Size(), size)
+	assert.Error(t, b.Snapshot("bad", ioutil.Discard, nil))
+
+	snap, err := NewBoltDB(bolt.Options{}, BoltSite{FileName: snapFile, SiteID: "radio-t"})
+	require.NoError(t, err)
+	defer snap.Close()
+	res, err := snap.Find(FindRequest{Locator: store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, Sort: "time"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(res))
+}
+
 func TestBoltDB_NewFailed(t *testing.T) {
 	_, err := NewBoltDB(bolt.Options{}, BoltSite{FileName: "/tmp/no-such-place/tmp.db", SiteID: "radio-t"})
 	assert.EqualError(t, err, "failed to make boltdb for /tmp/no-such-place/tmp.db: open /tmp/no-such-place/tmp.db: no such file or directory")
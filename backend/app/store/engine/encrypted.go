@@ -0,0 +1,292 @@
+package engine
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// encryptedPrefix marks encrypted values, values without it stored before encryption enabled and read as is
+const encryptedPrefix = "enc:v1:"
+
+// Encrypted wraps engine and encrypts texts of comments and emails of users at rest with AES-GCM.
+// Encryption is transparent for callers, everything else passed to the wrapped engine unchanged.
+type Encrypted struct {
+	Interface
+	aead cipher.AEAD
+}
+
+// EncryptReport is the result of EncryptSite call
+type EncryptReport struct {
+	Comments int `json:"comments"` // comments encrypted
+	Emails   int `json:"emails"`   // emails encrypted
+}
+
+// NewEncrypted makes Encrypted on top of eng with 32 bytes key (AES-256)
+func NewEncrypted(eng Interface, key []byte) (*Encrypted, error) {
+	if len(key) != 32 {
+		return nil, errors.Errorf("invalid encryption key size %d, 32 bytes expected", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't make cipher")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't make gcm")
+	}
+	return &Encrypted{Interface: eng, aead: aead}, nil
+}
+
+// Create encrypts comment and saves it
+func (e *Encrypted) Create(comment store.Comment) (commentID string, err error) {
+	if comment, err = e.encryptComment(comment); err != nil {
+		return "", err
+	}
+	return e.Interface.Create(comment)
+}
+
+// Update encrypts comment and saves it
+func (e *Encrypted) Update(comment store.Comment) (err error) {
+	if comment, err = e.encryptComment(comment); err != nil {
+		return err
+	}
+	return e.Interface.Update(comment)
+}
+
+// Get comment and decrypt it
+func (e *Encrypted) Get(req GetRequest) (store.Comment, error) {
+	comment, err := e.Interface.Get(req)
+	if err != nil {
+		return comment, err
+	}
+	return e.decryptComment(comment)
+}
+
+// Find comments and decrypt them
+func (e *Encrypted) Find(req FindRequest) ([]store.Comment, error) {
+	comments, err := e.Interface.Find(req)
+	if err != nil {
+		return comments, err
+	}
+	for i := range comments {
+		if comments[i], err = e.decryptComment(comments[i]); err != nil {
+			return nil, err
+		}
+	}
+	return comments, nil
+}
+
+// UserDetail encrypts email set and decrypts emails returned
+func (e *Encrypted) UserDetail(req UserDetailRequest) ([]UserDetailEntry, error) {
+	if req.Detail == UserEmail && req.Update != "" {
+		enc, err := e.encrypt(req.Update)
+		if err != nil {
+			return nil, err
+		}
+		req.Update = enc
+	}
+	res, err := e.Interface.UserDetail(req)
+	if err != nil {
+		return res, err
+	}
+	for i := range res {
+		if res[i].Email, err = e.decrypt(res[i].Email); err != nil {
+			return nil, errors.Wrapf(err, "can't decrypt email of %s", res[i].UserID)
+		}
+	}
+	return res, nil
+}
+
+// Integrity passes the check to the wrapped engine, texts are not checked
+func (e *Encrypted) Integrity(req IntegrityRequest) (IntegrityReport, error) {
+	checker, ok := e.Interface.(IntegrityChecker)
+	if !ok {
+		return IntegrityReport{}, errors.New("integrity check not supported by engine")
+	}
+	return checker.Integrity(req)
+}
+
+// AddSite makes storage of the site with underlying engine, if it supports sites added at runtime
+func (e *Encrypted) AddSite(siteID string) error {
+	sm, ok := e.Interface.(SiteManager)
+	if !ok {
+		return errors.New("engine doesn't support sites added at runtime")
+	}
+	return sm.AddSite(siteID)
+}
+
+// RemoveSite removes storage of the site with underlying engine, if it supports sites removed at runtime
+func (e *Encrypted) RemoveSite(siteID string) error {
+	sm, ok := e.Interface.(SiteManager)
+	if !ok {
+		return errors.New("engine doesn't support sites removed at runtime")
+	}
+	return sm.RemoveSite(siteID)
+}
+
+// DetachSite stops serving the site with underlying engine, if it keeps sites isolated
+func (e *Encrypted) DetachSite(siteID string) (string, error) {
+	sd, ok := e.Interface.(SiteDetacher)
+	if !ok {
+		return "", errors.New("engine doesn't support detaching of sites")
+	}
+	return sd.DetachSite(siteID)
+}
+
+// BackupSite copies storage of the site with underlying engine as stored, values kept encrypted in the copy.
+// Unlike export, the copy can be read with the same key only.
+func (e *Encrypted) BackupSite(siteID string, w io.Writer) error {
+	sb, ok := e.Interface.(SiteBackuper)
+	if !ok {
+		return errors.New("engine doesn't support backup of sites")
+	}
+	return sb.BackupSite(siteID, w)
+}
+
+// RestoreSite replaces storage of the site with underlying engine, the copy should be encrypted with the same key
+func (e *Encrypted) RestoreSite(siteID string, r io.Reader) error {
+	sb, ok := e.Interface.(SiteBackuper)
+	if !ok {
+		return errors.New("engine doesn't support restore of sites")
+	}
+	return sb.RestoreSite(siteID, r)
+}
+
+// EncryptSite encrypts comments and emails of the site stored before encryption enabled.
+// Values encrypted already skipped, so it's safe to run it again after failure.
+func (e *Encrypted) EncryptSite(siteID string) (EncryptReport, error) {
+	report := EncryptReport{}
+	posts, err := e.Interface.Info(InfoRequest{Locator: store.Locator{SiteID: siteID}})
+	if err != nil {
+		return report, errors.Wrapf(err, "can't list posts of %s", siteID)
+	}
+	for _, post := range posts {
+		comments, e2 := e.Interface.Find(FindRequest{Locator: store.Locator{SiteID: siteID, URL: post.URL}})
+		if e2 != nil {
+			return report, errors.Wrapf(e2, "can't find comments of %s", post.URL)
+		}
+		for _, c := range comments {
+			if !e.plainComment(c) {
+				continue
+			}
+			c, _ = e.mapComment(c, func(s string) (string, error) { // partially encrypted, plain values kept
+				if res, err := e.decrypt(s); err == nil {
+					return res, nil
+				}
+				return s, nil
+			})
+			if e2 = e.Update(c); e2 != nil {
+				return report, errors.Wrapf(e2, "can't encrypt comment %s", c.ID)
+			}
+			report.Comments++
+		}
+	}
+
+	details, err := e.Interface.UserDetail(UserDetailRequest{Detail: AllUserDetails, Locator: store.Locator{SiteID: siteID}})
+	if err != nil {
+		return report, errors.Wrapf(err, "can't list user details of %s", siteID)
+	}
+	for _, d := range details {
+		if d.Email == "" || strings.HasPrefix(d.Email, encryptedPrefix) {
+			continue
+		}
+		if _, err = e.UserDetail(UserDetailRequest{Detail: UserEmail, Locator: store.Locator{SiteID: siteID},
+			UserID: d.UserID, Update: d.Email}); err != nil {
+			return report, errors.Wrapf(err, "can't encrypt email of %s", d.UserID)
+		}
+		report.Emails++
+	}
+	return report, nil
+}
+
+// plainComment checks if comment has text stored without encryption, text only looking like encrypted is plain too
+func (e *Encrypted) plainComment(c store.Comment) bool {
+	plain := func(s string) bool {
+		_, err := e.decrypt(s)
+		return s != "" && (!strings.HasPrefix(s, encryptedPrefix) || err != nil)
+	}
+	if plain(c.Text) || plain(c.Orig) || plain(c.Raw) {
+		return true
+	}
+	for _, r := range c.Revisions {
+		if plain(r.Text) || plain(r.Orig) {
+			return true
+		}
+	}
+	return false
+}
+
+// encryptComment encrypts texts of the comment and its revisions, revisions copied to keep caller's comment intact
+func (e *Encrypted) encryptComment(c store.Comment) (store.Comment, error) {
+	return e.mapComment(c, e.encrypt)
+}
+
+// decryptComment decrypts texts of the comment and its revisions
+func (e *Encrypted) decryptComment(c store.Comment) (store.Comment, error) {
+	res, err := e.mapComment(c, e.decrypt)
+	return res, errors.Wrapf(err, "can't decrypt comment %s", c.ID)
+}
+
+// mapComment applies fn to texts of the comment and its revisions
+func (e *Encrypted) mapComment(c store.Comment, fn func(string) (string, error)) (_ store.Comment, err error) {
+	for _, s := range []*string{&c.Text, &c.Orig, &c.Raw} {
+		if *s, err = fn(*s); err != nil {
+			return c, err
+		}
+	}
+	if c.Revisions != nil {
+		revisions := make([]store.Revision, len(c.Revisions))
+		for i, r := range c.Revisions {
+			if r.Text, err = fn(r.Text); err != nil {
+				return c, err
+			}
+			if r.Orig, err = fn(r.Orig); err != nil {
+				return c, err
+			}
+			revisions[i] = r
+		}
+		c.Revisions = revisions
+	}
+	return c, nil
+}
+
+// encrypt returns prefixed base64 of nonce and sealed value, empty value returned as is.
+// Values looking like encrypted encrypted too, otherwise users could store value failing decryption.
+func (e *Encrypted) encrypt(s string) (string, error) {
+	if s == "" {
+		return s, nil
+	}
+	nonce := make([]byte, e.aead.NonceSize(), e.aead.NonceSize()+len(s)+e.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", errors.Wrap(err, "can't make nonce")
+	}
+	return encryptedPrefix + base64.RawStdEncoding.EncodeToString(e.aead.Seal(nonce, nonce, []byte(s), nil)), nil
+}
+
+// decrypt returns value encrypted by encrypt, values without prefix returned as is
+func (e *Encrypted) decrypt(s string) (string, error) {
+	if !strings.HasPrefix(s, encryptedPrefix) {
+		return s, nil
+	}
+	data, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(s, encryptedPrefix))
+	if err != nil {
+		return "", errors.Wrap(err, "can't decode encrypted value")
+	}
+	if len(data) < e.aead.NonceSize() {
+		return "", errors.New("encrypted value is too short")
+	}
+	nonce, sealed := data[:e.aead.NonceSize()], data[e.aead.NonceSize():]
+	res, err := e.aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "can't decrypt value, wrong key")
+	}
+	return string(res), nil
+}
@@ -0,0 +1,146 @@
+package engine
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestEncrypted_CreateAndFind(t *testing.T) {
+	b, teardown := prep(t)
+	defer teardown()
+	enc, err := NewEncrypted(b, testKey)
+	require.NoError(t, err)
+
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	c := store.Comment{ID: "id-3", Text: "<p>secret</p>", Orig: "secret", Locator: locator, User: store.User{ID: "user1"},
+		Revisions: []store.Revision{{Text: "<p>old</p>", Orig: "old"}}, Timestamp: time.Now()}
+	_, err = enc.Create(c)
+	require.NoError(t, err)
+	assert.Equal(t, "<p>old</p>", c.Revisions[0].Text, "caller's comment intact")
+
+	raw, err := b.Get(GetRequest{Locator: locator, CommentID: "id-3"})
+	require.NoError(t, err)
+	for _, s := range []string{raw.Text, raw.Orig, raw.Revisions[0].Text, raw.Revisions[0].Orig} {
+		assert.True(t, strings.HasPrefix(s, encryptedPrefix), s)
+	}
+	assert.Equal(t, "", raw.Raw, "empty kept")
+
+	res, err := enc.Get(GetRequest{Locator: locator, CommentID: "id-3"})
+	require.NoError(t, err)
+	assert.Equal(t, "<p>secret</p>", res.Text)
+	assert.Equal(t, "secret", res.Orig)
+	assert.Equal(t, []store.Revision{{Text: "<p>old</p>", Orig: "old"}}, res.Revisions)
+
+	res.Text = "<p>updated</p>"
+	require.NoError(t, enc.Update(res))
+	comments, err := enc.Find(FindRequest{Locator: locator, Sort: "time"})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(comments))
+	assert.Equal(t, "some text2", comments[1].Text, "stored before encryption, read as is")
+	assert.Equal(t, "<p>updated</p>", comments[2].Text)
+
+	other, err := NewEncrypted(b, []byte("0123456789abcdef0123456789abcdeX"))
+	require.NoError(t, err)
+	_, err = other.Get(GetRequest{Locator: locator, CommentID: "id-3"})
+	assert.EqualError(t, err, "can't decrypt comment id-3: can't decrypt value, wrong key: cipher: message authentication failed")
+	_, err = other.Find(FindRequest{Locator: locator})
+	assert.Error(t, err)
+}
+
+func TestEncrypted_BackupSite(t *testing.T) {
+	b, teardown := prep(t)
+	defer teardown()
+	enc, err := NewEncrypted(b, testKey)
+	require.NoError(t, err)
+
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	_, err = enc.Create(store.Comment{ID: "id-3", Text: "<p>top secret</p>", Orig: "top secret", Locator: locator,
+		User: store.User{ID: "user1"}, Timestamp: time.Now()})
+	require.NoError(t, err)
+
+	backup := bytes.Buffer{}
+	require.NoError(t, enc.BackupSite("radio-t", &backup))
+	assert.NotContains(t, backup.String(), "top secret", "copy encrypted as stored")
+	assert.Contains(t, backup.String(), encryptedPrefix)
+
+	_, err = enc.Create(store.Comment{ID: "id-4", Text: "after backup", Locator: locator, User: store.User{ID: "user1"}})
+	require.NoError(t, err)
+	require.NoError(t, enc.RestoreSite("radio-t", &backup))
+	res, err := enc.Get(GetRequest{Locator: locator, CommentID: "id-3"})
+	require.NoError(t, err)
+	assert.Equal(t, "<p>top secret</p>", res.Text, "restored copy decrypted with the same key")
+	count, err := enc.Count(FindRequest{Locator: locator})
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+}
+
+func TestEncrypted_UserDetail(t *testing.T) {
+	b, teardown := prep(t)
+	defer teardown()
+	enc, err := NewEncrypted(b, testKey)
+	require.NoError(t, err)
+
+	req := UserDetailRequest{Detail: UserEmail, Locator: store.Locator{SiteID: "radio-t"}, UserID: "user1", Update: "me@example.com"}
+	res, err := enc.UserDetail(req)
+	require.NoError(t, err)
+	assert.Equal(t, []UserDetailEntry{{UserID: "user1", Email: "me@example.com"}}, res)
+
+	req.Update = ""
+	raw, err := b.UserDetail(req)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(raw))
+	assert.True(t, strings.HasPrefix(raw[0].Email, encryptedPrefix), raw[0].Email)
+
+	res, err = enc.UserDetail(UserDetailRequest{Detail: AllUserDetails, Locator: store.Locator{SiteID: "radio-t"}})
+	require.NoError(t, err)
+	assert.Equal(t, []UserDetailEntry{{UserID: "user1", Email: "me@example.com"}}, res)
+}
+
+func TestEncrypted_EncryptSite(t *testing.T) {
+	b, teardown := prep(t)
+	defer teardown()
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	_, err := b.UserDetail(UserDetailRequest{Detail: UserEmail, Locator: locator, UserID: "user1", Update: "me@example.com"})
+	require.NoError(t, err)
+	_, err = b.Create(store.Comment{ID: "id-3", Text: "enc:v1:looks encrypted", Locator: locator, User: store.User{ID: "user2"}})
+	require.NoError(t, err)
+
+	enc, err := NewEncrypted(b, testKey)
+	require.NoError(t, err)
+	report, err := enc.EncryptSite("radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, EncryptReport{Comments: 3, Emails: 1}, report)
+
+	comments, err := b.Find(FindRequest{Locator: locator})
+	require.NoError(t, err)
+	for _, c := range comments {
+		assert.False(t, enc.plainComment(c), c.ID)
+	}
+	c, err := enc.Get(GetRequest{Locator: locator, CommentID: "id-3"})
+	require.NoError(t, err)
+	assert.Equal(t, "enc:v1:looks encrypted", c.Text)
+	c, err = enc.Get(GetRequest{Locator: locator, CommentID: "id-1"})
+	require.NoError(t, err)
+	assert.Equal(t, `some text, <a href="http://radio-t.com">link</a>`, c.Text)
+
+	report, err = enc.EncryptSite("radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, EncryptReport{}, report, "nothing left to encrypt")
+
+	_, err = enc.EncryptSite("bad")
+	assert.Error(t, err)
+}
+
+func TestNewEncrypted(t *testing.T) {
+	_, err := NewEncrypted(nil, []byte("short"))
+	assert.EqualError(t, err, "invalid encryption key size 5, 32 bytes expected")
+}
@@ -4,10 +4,14 @@ package engine
 // Includes default implementation with boltdb
 
 import (
+	"encoding/json"
+	"io"
 	"sort"
 	"strings"
 	"time"
 
+	"github.com/pkg/errors"
+
 	"github.com/umputun/remark42/backend/app/store"
 )
 
@@ -24,16 +28,40 @@ type Interface interface {
 	Count(req FindRequest) (int, error)                         // get count for post or user
 	Delete(req DeleteRequest) error                             // Delete post(s), user, comment, user details, or everything
 	Flag(req FlagRequest) (bool, error)                         // set and get flags
-	ListFlags(req FlagRequest) ([]interface{}, error)           // get list of flagged keys, like blocked & verified user
+	ListFlags(req FlagRequest) ([]interface{}, error)           // get list of flagged keys, like blocked, verified & shadowed user
+	Reattribute(req ReattributeRequest) ([]string, error)       // move all comments of one user to another, returns ids
+	Remap(req RemapRequest) ([]string, error)                   // move all comments of posts to new urls, returns ids
 
 	// UserDetail sets or gets single detail value, or gets all details for requested site
 	// Returns list even for single entry request is a compromise in order to have both single detail getting and setting
 	// and all site's details listing under the same function (and not to extend interface by two separate functions)
 	UserDetail(req UserDetailRequest) ([]UserDetailEntry, error)
 
+	// Record sets, gets, lists or deletes records kept by other services along with comments of the site,
+	// like drafts and settings. Returns list for the same reason as UserDetail does.
+	Record(req RecordRequest) ([]RecordEntry, error)
+
 	Close() error // close storage engine
 }
 
+// SiteManager implemented by engines able to add and remove sites at runtime
+type SiteManager interface {
+	AddSite(siteID string) error    // make storage of the site, no-op for existing one
+	RemoveSite(siteID string) error // close storage of the site and remove all its data
+}
+
+// SiteDetacher implemented by engines keeping data of each site isolated, so a single site can be moved
+// to another instance without touching others
+type SiteDetacher interface {
+	DetachSite(siteID string) (location string, err error) // stop serving the site, data kept in returned location
+}
+
+// SiteBackuper implemented by engines able to copy storage of a single site as is
+type SiteBackuper interface {
+	BackupSite(siteID string, w io.Writer) error  // write consistent copy of the site's storage
+	RestoreSite(siteID string, r io.Reader) error // replace storage of the site with copy made by BackupSite
+}
+
 // GetRequest is the input for Get func
 type GetRequest struct {
 	Locator   store.Locator `json:"locator"`
@@ -67,6 +95,24 @@ type DeleteRequest struct {
 	DeleteMode store.DeleteMode `json:"del_mode"`
 }
 
+// ReattributeRequest is the input for Reattribute, moves all comments of the site from user FromID to user To.
+// ID, Name and Picture of To set to moved comments, other user's fields kept unless Anonymize set.
+type ReattributeRequest struct {
+	Locator   store.Locator `json:"locator"` // site only, URL ignored
+	FromID    string        `json:"from_id"`
+	To        store.User    `json:"to"`
+	Anonymize bool          `json:"anonymize,omitempty"` // clear all other user's fields, like ip
+	IDs       []string      `json:"ids,omitempty"`       // move only comments with these ids, all comments if empty
+}
+
+// RemapRequest is the input for Remap, moves all comments of the site's posts to new urls, i.e. after domain change
+// or permalink restructure. Info, read-only and slow mode flags of posts moved along, comments of post moved to
+// url with existing comments merged with them.
+type RemapRequest struct {
+	Locator store.Locator     `json:"locator"` // site only, URL ignored
+	URLs    map[string]string `json:"urls"`    // new url by old url
+}
+
 // Flag defines type of binary attribute
 type Flag string
 
@@ -85,12 +131,18 @@ const (
 	ReadOnly = Flag("readonly")
 	Verified = Flag("verified")
 	Blocked  = Flag("blocked")
+	SlowMode = Flag("slowmode")
+	Shadowed = Flag("shadowed") // shadow-banned user, comments seen by the user only
 )
 
 // All possible user details
 const (
 	// UserEmail is a user email
 	UserEmail = UserDetail("email")
+	// UserConsent is a version of legal terms accepted by user, time of consent set from request's Time
+	UserConsent = UserDetail("consent")
+	// UserProfile is a profile edited by user, set from request's Profile
+	UserProfile = UserDetail("profile")
 	// AllUserDetails used for listing and deletion requests
 	AllUserDetails = UserDetail("all")
 )
@@ -109,16 +161,177 @@ type UserDetail string
 
 // UserDetailEntry contains single user details entry
 type UserDetailEntry struct {
-	UserID string `json:"user_id"`         // duplicate user's id to use this structure not only embedded but separately
-	Email  string `json:"email,omitempty"` // UserEmail
+	UserID      string         `json:"user_id"`                // duplicate user's id to use this structure not only embedded but separately
+	Email       string         `json:"email,omitempty"`        // UserEmail
+	Consent     string         `json:"consent,omitempty"`      // UserConsent
+	ConsentTime *time.Time     `json:"consent_time,omitempty"` // time of the last UserConsent update
+	Profile     *store.Profile `json:"profile,omitempty"`      // UserProfile
 }
 
 // UserDetailRequest is the input for both get/set for details, like email
 type UserDetailRequest struct {
-	Detail  UserDetail    `json:"detail"`           // detail name
-	Locator store.Locator `json:"locator"`          // post locator
-	UserID  string        `json:"user_id"`          // user id for get\set
-	Update  string        `json:"update,omitempty"` // update value
+	Detail  UserDetail     `json:"detail"`            // detail name
+	Locator store.Locator  `json:"locator"`           // post locator
+	UserID  string         `json:"user_id"`           // user id for get\set
+	Update  string         `json:"update,omitempty"`  // update value
+	Time    *time.Time     `json:"time,omitempty"`    // time of update for UserConsent, current time if not set
+	Profile *store.Profile `json:"profile,omitempty"` // update value for UserProfile
+}
+
+// RecordKind defines kind of records, records of each kind kept separately
+type RecordKind string
+
+// RecordOp defines operation of Record request
+type RecordOp string
+
+// Enum of record operations
+const (
+	RecordGet    = RecordOp("get")    // get record by key, empty result for missing one
+	RecordList   = RecordOp("list")   // list records with key prefix sorted by key, all records of the kind for empty key
+	RecordSet    = RecordOp("set")    // set record, existing one replaced
+	RecordAdd    = RecordOp("add")    // set record if the key not used yet, empty result for used one
+	RecordDelete = RecordOp("delete") // delete record, returns the deleted one, empty result for missing one
+)
+
+// Enum of all record kinds
+const (
+	Drafts    = RecordKind("drafts")    // unsent comments of users
+	Settings  = RecordKind("settings")  // overrides of default settings of the site
+	Intervals = RecordKind("intervals") // minutes between comments of each user to the post, by post url
+
+	Roles        = RecordKind("roles")         // roles of users on the site, by user id
+	TwoFactor    = RecordKind("two_factor")    // two-factor auth enrollments of admins, by user id
+	APITokens    = RecordKind("api_tokens")    // api tokens of the site, by token id
+	Links        = RecordKind("links")         // identities linked to canonical users, by linked id
+	LinkRequests = RecordKind("link_requests") // pending requests to link identities, by code
+
+	Bookmarks      = RecordKind("bookmarks")       // bookmarks of users, by userID!!commentID
+	BookmarksIndex = RecordKind("bookmarks_index") // bookmarked comments of posts, by url!!commentID!!userID
+	Schedules      = RecordKind("schedules")       // schedules of posts, by post url
+
+	ModerationRules = RecordKind("moderation_rules") // rules of moderation of the site, single record
+	VerifiedRules   = RecordKind("verified_rules")   // rules granting verified flag, single record
+	TrustRules      = RecordKind("trust_rules")      // rules of trust levels of the site, single record
+	TrustUsers      = RecordKind("trust_users")      // trust records of users, by user id
+	Votes           = RecordKind("votes")            // recorded votes analysed for fraud, by time!!commentID!!userID
+
+	Fingerprints      = RecordKind("fingerprints")       // hashed ip and agent of comments, by comment id
+	FingerprintHashes = RecordKind("fingerprint_hashes") // comment ids of fingerprints, by hash!!time!!commentID
+	FingerprintBlocks = RecordKind("fingerprint_blocks") // blocked hashes, by hash
+
+	Deletions = RecordKind("deletions") // scheduled deletions of accounts, by user id
+	Audit     = RecordKind("audit")     // log of moderation actions, by timestamp!!id
+
+	Followers    = RecordKind("followers")     // followers of comment authors, by authorID!!userID
+	Following    = RecordKind("following")     // authors followed by users, by userID!!authorID
+	AdminPrefs   = RecordKind("admin_prefs")   // notification preferences of admins, by email
+	Deliveries   = RecordKind("deliveries")    // emails notified about comments, by commentID!!email
+	Bounces      = RecordKind("bounces")       // bounced email addresses, by email
+	SendStatuses = RecordKind("send_statuses") // send statuses of notifications, by time!!id
+	ReplyTickets = RecordKind("reply_tickets") // tickets of reply addresses of notifications, by token
+
+	ExternalIDs = RecordKind("external_ids") // comments of external ids set by integrations, by external id
+	Trash       = RecordKind("trash")        // soft-deleted comments kept for undelete, by comment id
+
+	FederatedPosts     = RecordKind("ap_posts")     // locators of posts federated with activitypub, by post key
+	FederatedFollowers = RecordKind("ap_followers") // remote followers of posts, by postKey!!actorID
+	FederatedNotes     = RecordKind("ap_notes")     // comments made from remote notes, by note id
+	FederatedComments  = RecordKind("ap_comments")  // remote notes of comments, by comment id
+	FederatedKeys      = RecordKind("ap_keys")      // private key of actors, single record kept in each site
+)
+
+// postRecordKinds are kinds of records keyed by post url, moved with comments of the post by Remap
+var postRecordKinds = []RecordKind{Intervals, Schedules}
+
+// RecordEntry contains single record
+type RecordEntry struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+// RecordRequest is the input of Record, value is any JSON
+type RecordRequest struct {
+	Op      RecordOp        `json:"op"`
+	Kind    RecordKind      `json:"kind"`
+	Locator store.Locator   `json:"locator"`         // site only, URL ignored
+	Key     string          `json:"key,omitempty"`   // key of the record, prefix of keys for RecordList
+	Value   json.RawMessage `json:"value,omitempty"` // value for RecordSet and RecordAdd
+}
+
+// validate checks both users set and different
+func (r ReattributeRequest) validate() error {
+	if r.FromID == "" || r.To.ID == "" || r.FromID == r.To.ID {
+		return errors.Errorf("invalid reattribute request from %q to %q", r.FromID, r.To.ID)
+	}
+	return nil
+}
+
+// validate checks kind and key set, and value set for writes
+func (r RecordRequest) validate() error {
+	if r.Kind == "" {
+		return errors.New("no kind of records")
+	}
+	if r.Key == "" && r.Op != RecordList {
+		return errors.Errorf("no key of %s record", r.Kind)
+	}
+	switch r.Op {
+	case RecordGet, RecordList, RecordDelete:
+		return nil
+	case RecordSet, RecordAdd:
+		if !json.Valid(r.Value) {
+			return errors.Errorf("invalid value of %s record %s", r.Kind, r.Key)
+		}
+		return nil
+	}
+	return errors.Errorf("invalid record operation %q", r.Op)
+}
+
+// validate checks urls set, changed and not chained, i.e. new url is not moved itself
+func (r RemapRequest) validate() error {
+	if len(r.URLs) == 0 {
+		return errors.New("no urls to remap")
+	}
+	for from, to := range r.URLs {
+		if from == "" || to == "" || from == to {
+			return errors.Errorf("invalid remap from %q to %q", from, to)
+		}
+		if _, ok := r.URLs[to]; ok {
+			return errors.Errorf("invalid remap to %q, the url remapped itself", to)
+		}
+	}
+	return nil
+}
+
+// froms returns old urls sorted, to move posts in the same order
+func (r RemapRequest) froms() []string {
+	res := make([]string, 0, len(r.URLs))
+	for from := range r.URLs {
+		res = append(res, from)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// user returns author of the moved comment made by u
+// selected checks if the comment moved by the request
+func (r ReattributeRequest) selected(commentID string) bool {
+	if len(r.IDs) == 0 {
+		return true
+	}
+	for _, id := range r.IDs {
+		if id == commentID {
+			return true
+		}
+	}
+	return false
+}
+
+func (r ReattributeRequest) user(u store.User) store.User {
+	if r.Anonymize {
+		return store.User{ID: r.To.ID, Name: r.To.Name, Picture: r.To.Picture}
+	}
+	u.ID, u.Name, u.Picture = r.To.ID, r.To.Name, r.To.Picture
+	return u
 }
 
 const (
@@ -161,6 +374,30 @@ func SortComments(comments []store.Comment, sortFld string) []store.Comment {
 			}
 			return comments[i].Controversy < comments[j].Controversy
 
+		case "+best", "-best", "best":
+			if strings.HasPrefix(sortFld, "-") {
+				if comments[i].Best == comments[j].Best {
+					return comments[i].Timestamp.Before(comments[j].Timestamp)
+				}
+				return comments[i].Best > comments[j].Best
+			}
+			if comments[i].Best == comments[j].Best {
+				return comments[i].Timestamp.Before(comments[j].Timestamp)
+			}
+			return comments[i].Best < comments[j].Best
+
+		case "+quality", "-quality", "quality":
+			if strings.HasPrefix(sortFld, "-") {
+				if comments[i].Quality == comments[j].Quality {
+					return comments[i].Timestamp.Before(comments[j].Timestamp)
+				}
+				return comments[i].Quality > comments[j].Quality
+			}
+			if comments[i].Quality == comments[j].Quality {
+				return comments[i].Timestamp.Before(comments[j].Timestamp)
+			}
+			return comments[i].Quality < comments[j].Quality
+
 		default:
 			return comments[i].Timestamp.Before(comments[j].Timestamp)
 		}
@@ -193,6 +193,75 @@ func (_m *MockInterface) ListFlags(req FlagRequest) ([]interface{}, error) {
 	return r0, r1
 }
 
+// Reattribute provides a mock function with given fields: req
+func (_m *MockInterface) Reattribute(req ReattributeRequest) ([]string, error) {
+	ret := _m.Called(req)
+
+	var r0 []string
+	if rf, ok := ret.Get(0).(func(ReattributeRequest) []string); ok {
+		r0 = rf(req)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(ReattributeRequest) error); ok {
+		r1 = rf(req)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Record provides a mock function with given fields: req
+func (_m *MockInterface) Record(req RecordRequest) ([]RecordEntry, error) {
+	ret := _m.Called(req)
+
+	var r0 []RecordEntry
+	if rf, ok := ret.Get(0).(func(RecordRequest) []RecordEntry); ok {
+		r0 = rf(req)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]RecordEntry)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(RecordRequest) error); ok {
+		r1 = rf(req)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Remap provides a mock function with given fields: req
+func (_m *MockInterface) Remap(req RemapRequest) ([]string, error) {
+	ret := _m.Called(req)
+
+	var r0 []string
+	if rf, ok := ret.Get(0).(func(RemapRequest) []string); ok {
+		r0 = rf(req)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(RemapRequest) error); ok {
+		r1 = rf(req)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Update provides a mock function with given fields: comment
 func (_m *MockInterface) Update(comment store.Comment) error {
 	ret := _m.Called(comment)
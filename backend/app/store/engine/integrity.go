@@ -0,0 +1,356 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// IntegrityChecker is implemented by engines able to verify (and optionally repair) consistency of stored data
+type IntegrityChecker interface {
+	Integrity(req IntegrityRequest) (IntegrityReport, error)
+}
+
+// IntegrityRequest is the input for Integrity call
+type IntegrityRequest struct {
+	SiteID string `json:"site_id"`
+	Repair bool   `json:"repair"` // fix detected problems in place
+}
+
+// IntegrityIssueKind defines type of problem found by integrity check
+type IntegrityIssueKind string
+
+// enum of all integrity issue kinds
+const (
+	IssueBrokenRecord      IntegrityIssueKind = "broken_record"      // comment can't be unmarshaled
+	IssueBrokenLocator     IntegrityIssueKind = "broken_locator"     // comment's locator doesn't match the post it stored in
+	IssueDanglingParent    IntegrityIssueKind = "dangling_parent"    // parent comment doesn't exist
+	IssueOrphanedVotes     IntegrityIssueKind = "orphaned_votes"     // votes or score left on deleted comment
+	IssueDanglingReference IntegrityIssueKind = "dangling_reference" // reference in last or users bucket points to missing comment
+	IssueCountMismatch     IntegrityIssueKind = "count_mismatch"     // post info count differs from the number of comments
+)
+
+// IntegrityIssue describes a single problem found by integrity check
+type IntegrityIssue struct {
+	Kind      IntegrityIssueKind `json:"kind"`
+	URL       string             `json:"url,omitempty"`
+	CommentID string             `json:"comment_id,omitempty"`
+	Details   string             `json:"details"`
+	Repaired  bool               `json:"repaired"`
+}
+
+// IntegrityReport is the result of integrity check for a site
+type IntegrityReport struct {
+	SiteID   string           `json:"site_id"`
+	Posts    int              `json:"posts"`
+	Comments int              `json:"comments"`
+	Issues   []IntegrityIssue `json:"issues"`
+	Repaired int              `json:"repaired"`
+}
+
+// Integrity checks all comments and references of the site for consistency. With req.Repair set
+// the problems fixed in the same transaction, i.e. either all or none of the fixes applied.
+func (b *BoltDB) Integrity(req IntegrityRequest) (IntegrityReport, error) {
+	report := IntegrityReport{SiteID: req.SiteID, Issues: []IntegrityIssue{}}
+
+	bdb, err := b.db(req.SiteID)
+	if err != nil {
+		return report, err
+	}
+
+	checkFn := func(tx *bolt.Tx) error {
+		existing, e := b.checkPosts(tx, req, &report)
+		if e != nil {
+			return e
+		}
+		for _, bktName := range []string{lastBucketName, userBucketName} {
+			if e = b.checkRefs(tx, bktName, existing, req.Repair, &report); e != nil {
+				return e
+			}
+		}
+		return nil
+	}
+
+	if req.Repair {
+		err = bdb.Update(checkFn)
+	} else {
+		err = bdb.View(checkFn)
+	}
+	if err != nil {
+		return report, errors.Wrapf(err, "integrity check failed for site %s", req.SiteID)
+	}
+
+	for _, issue := range report.Issues {
+		if issue.Repaired {
+			report.Repaired++
+		}
+	}
+	log.Printf("[INFO] integrity check for %s, posts=%d, comments=%d, issues=%d, repaired=%d",
+		req.SiteID, report.Posts, report.Comments, len(report.Issues), report.Repaired)
+	return report, nil
+}
+
+// checkPosts validates every comment in every post bucket as well as post counts.
+// Returns set of existing references (url!!id) used to validate last and users buckets.
+func (b *BoltDB) checkPosts(tx *bolt.Tx, req IntegrityRequest, report *IntegrityReport) (map[string]bool, error) {
+	existing := map[string]bool{}
+	postsBkt := tx.Bucket([]byte(postsBucketName))
+	infoBkt := tx.Bucket([]byte(infoBucketName))
+
+	postURLs := []string{}
+	err := postsBkt.ForEach(func(k, v []byte) error {
+		if v == nil { // nested bucket
+			postURLs = append(postURLs, string(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "can't list posts")
+	}
+
+	for _, postURL := range postURLs {
+		report.Posts++
+		postBkt := postsBkt.Bucket([]byte(postURL))
+
+		// collect all comments first, bolt doesn't allow modification of bucket during ForEach
+		comments := map[string]store.Comment{}
+		broken := []string{}
+		err = postBkt.ForEach(func(k, v []byte) error {
+			comment := store.Comment{}
+			if e := json.Unmarshal(v, &comment); e != nil {
+				broken = append(broken, string(k))
+				return nil
+			}
+			comments[string(k)] = comment
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't read comments for %s", postURL)
+		}
+
+		for _, key := range broken {
+			issue := IntegrityIssue{Kind: IssueBrokenRecord, URL: postURL, CommentID: key, Details: "can't unmarshal comment"}
+			if req.Repair {
+				if err = postBkt.Delete([]byte(key)); err != nil {
+					return nil, errors.Wrapf(err, "can't delete broken comment %s", key)
+				}
+				issue.Repaired = true
+			}
+			report.Issues = append(report.Issues, issue)
+		}
+
+		keys := make([]string, 0, len(comments))
+		for key := range comments {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys) // stable order of issues in report
+
+		active := 0
+		for _, key := range keys {
+			comment := comments[key]
+			report.Comments++
+			existing[postURL+"!!"+key] = true
+			if !comment.Deleted {
+				active++
+			}
+			issues := b.checkComment(req.SiteID, postURL, key, &comment, comments)
+			if len(issues) == 0 {
+				continue
+			}
+			if req.Repair {
+				if err = b.save(postBkt, key, comment); err != nil {
+					return nil, errors.Wrapf(err, "can't save repaired comment %s", key)
+				}
+				for i := range issues {
+					issues[i].Repaired = true
+				}
+			}
+			report.Issues = append(report.Issues, issues...)
+		}
+
+		info := store.PostInfo{}
+		if e := b.load(infoBkt, postURL, &info); e != nil {
+			info = store.PostInfo{URL: postURL}
+		}
+		if info.Count != active {
+			issue := IntegrityIssue{Kind: IssueCountMismatch, URL: postURL,
+				Details: fmt.Sprintf("stored count %d, actual %d", info.Count, active)}
+			if req.Repair {
+				info.Count = active
+				if err = b.save(infoBkt, postURL, &info); err != nil {
+					return nil, errors.Wrapf(err, "can't save info for %s", postURL)
+				}
+				issue.Repaired = true
+			}
+			report.Issues = append(report.Issues, issue)
+		}
+	}
+	return existing, nil
+}
+
+// checkComment detects problems with a single comment and fixes them in the passed comment.
+// Caller is responsible for saving the fixed comment.
+func (b *BoltDB) checkComment(siteID, postURL, key string, c *store.Comment, comments map[string]store.Comment) []IntegrityIssue {
+	issues := []IntegrityIssue{}
+
+	if c.Locator.SiteID != siteID || c.Locator.URL != postURL || c.ID != key {
+		issues = append(issues, IntegrityIssue{Kind: IssueBrokenLocator, URL: postURL, CommentID: key,
+			Details: fmt.Sprintf("comment %s with locator %s/%s stored as %s in %s", c.ID, c.Locator.SiteID, c.Locator.URL, key, postURL)})
+		c.Locator = store.Locator{SiteID: siteID, URL: postURL}
+		c.ID = key
+	}
+
+	if c.ParentID != "" {
+		if _, ok := comments[c.ParentID]; !ok {
+			issues = append(issues, IntegrityIssue{Kind: IssueDanglingParent, URL: postURL, CommentID: key,
+				Details: fmt.Sprintf("parent %s not found", c.ParentID)})
+			c.ParentID = ""
+		}
+	}
+
+	if c.Deleted && (c.Score != 0 || len(c.Votes) > 0 || len(c.VotedIPs) > 0) {
+		issues = append(issues, IntegrityIssue{Kind: IssueOrphanedVotes, URL: postURL, CommentID: key,
+			Details: fmt.Sprintf("deleted comment has score %d and %d votes", c.Score, len(c.Votes))})
+		c.Score = 0
+		c.Votes = map[string]bool{}
+		c.VotedIPs = make(map[string]store.VotedIPInfo)
+	}
+
+	return issues
+}
+
+// checkRefs validates references stored in last (flat) or users (nested per user) bucket
+func (b *BoltDB) checkRefs(tx *bolt.Tx, bktName string, existing map[string]bool, repair bool, report *IntegrityReport) error {
+
+	check := func(bkt *bolt.Bucket) error {
+		dangling := [][]byte{}
+		err := bkt.ForEach(func(k, v []byte) error {
+			if v == nil {
+				return nil
+			}
+			url, id, e := b.parseRef(v)
+			if e != nil || !existing[url+"!!"+id] {
+				dangling = append(dangling, append([]byte{}, k...))
+				report.Issues = append(report.Issues, IntegrityIssue{Kind: IssueDanglingReference, URL: url, CommentID: id,
+					Details: fmt.Sprintf("reference %q in %s bucket points to missing comment", string(v), bktName), Repaired: repair})
+			}
+			return nil
+		})
+		if err != nil || !repair {
+			return err
+		}
+		for _, k := range dangling {
+			if err = bkt.Delete(k); err != nil {
+				return errors.Wrapf(err, "can't delete reference %s from %s", string(k), bktName)
+			}
+		}
+		return nil
+	}
+
+	topBkt := tx.Bucket([]byte(bktName))
+	if bktName != userBucketName {
+		return check(topBkt)
+	}
+
+	userIDs := [][]byte{}
+	if err := topBkt.ForEach(func(k, v []byte) error {
+		if v == nil {
+			userIDs = append(userIDs, append([]byte{}, k...))
+		}
+		return nil
+	}); err != nil {
+		return errors.Wrapf(err, "can't list %s bucket", bktName)
+	}
+	for _, userID := range userIDs {
+		if err := check(topBkt.Bucket(userID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CompactReport is the result of bolt file compaction
+type CompactReport struct {
+	FileName   string `json:"file_name"`
+	SizeBefore int64  `json:"size_before"`
+	SizeAfter  int64  `json:"size_after"`
+}
+
+// CompactBoltDB copies all buckets of src bolt file to the fresh file and replaces src with it.
+// The file can't be opened by anyone else during compaction, i.e. server should be stopped.
+func CompactBoltDB(fileName string, options bolt.Options) (CompactReport, error) {
+	report := CompactReport{FileName: fileName}
+	fi, err := os.Stat(fileName)
+	if err != nil {
+		return report, errors.Wrapf(err, "can't stat %s", fileName)
+	}
+	report.SizeBefore = fi.Size()
+
+	src, err := bolt.Open(fileName, 0600, &options) //nolint:gocritic //octalLiteral is OK as FileMode
+	if err != nil {
+		return report, errors.Wrapf(err, "can't open %s", fileName)
+	}
+
+	tmpName := fileName + ".compact"
+	dst, err := bolt.Open(tmpName, 0600, &options) //nolint:gocritic //octalLiteral is OK as FileMode
+	if err != nil {
+		_ = src.Close()
+		return report, errors.Wrapf(err, "can't open %s", tmpName)
+	}
+
+	err = src.View(func(srcTx *bolt.Tx) error {
+		return dst.Update(func(dstTx *bolt.Tx) error {
+			return srcTx.ForEach(func(name []byte, srcBkt *bolt.Bucket) error {
+				dstBkt, e := dstTx.CreateBucket(name)
+				if e != nil {
+					return errors.Wrapf(e, "can't create bucket %s", string(name))
+				}
+				dstBkt.FillPercent = 1.0 // keys copied in order, pack pages fully
+				return copyBucket(dstBkt, srcBkt)
+			})
+		})
+	})
+
+	if e := src.Close(); e != nil {
+		log.Printf("[WARN] can't close %s, %v", fileName, e)
+	}
+	if e := dst.Close(); e != nil && err == nil {
+		err = errors.Wrapf(e, "can't close %s", tmpName)
+	}
+	if err != nil {
+		_ = os.Remove(tmpName)
+		return report, errors.Wrapf(err, "failed to compact %s", fileName)
+	}
+
+	if err = os.Rename(tmpName, fileName); err != nil {
+		return report, errors.Wrapf(err, "can't replace %s with compacted file", fileName)
+	}
+	if fi, err = os.Stat(fileName); err != nil {
+		return report, errors.Wrapf(err, "can't stat %s", fileName)
+	}
+	report.SizeAfter = fi.Size()
+	log.Printf("[INFO] compacted %s, %d -> %d bytes", fileName, report.SizeBefore, report.SizeAfter)
+	return report, nil
+}
+
+// copyBucket recursively copies all keys and nested buckets from src to dst
+func copyBucket(dst, src *bolt.Bucket) error {
+	return src.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return dst.Put(k, v)
+		}
+		nestedDst, err := dst.CreateBucket(k)
+		if err != nil {
+			return errors.Wrapf(err, "can't create nested bucket %s", string(k))
+		}
+		nestedDst.FillPercent = 1.0
+		return copyBucket(nestedDst, src.Bucket(k))
+	})
+}
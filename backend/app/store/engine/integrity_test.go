@@ -0,0 +1,148 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestBoltDB_IntegrityClean(t *testing.T) {
+	b, teardown := prep(t)
+	defer teardown()
+
+	report, err := b.Integrity(IntegrityRequest{SiteID: "radio-t"})
+	require.NoError(t, err)
+	assert.Equal(t, "radio-t", report.SiteID)
+	assert.Equal(t, 1, report.Posts)
+	assert.Equal(t, 2, report.Comments)
+	assert.Equal(t, 0, len(report.Issues))
+
+	_, err = b.Integrity(IntegrityRequest{SiteID: "bad"})
+	assert.EqualError(t, err, `site "bad" not found`)
+}
+
+func TestBoltDB_IntegrityRepair(t *testing.T) {
+	b, teardown := prep(t)
+	defer teardown()
+
+	// break the store directly
+	err := b.dbs["radio-t"].Update(func(tx *bolt.Tx) error {
+		postBkt, e := b.getPostBucket(tx, "https://radio-t.com")
+		require.NoError(t, e)
+		c := store.Comment{}
+		require.NoError(t, b.load(postBkt, "id-2", &c))
+		c.ParentID = "no-such-parent"
+		c.Locator.URL = "https://wrong.example.com"
+		require.NoError(t, b.save(postBkt, "id-2", c))
+
+		deleted := store.Comment{ID: "id-3", Locator: store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"},
+			Deleted: true, Score: 2, Votes: map[string]bool{"u1": true, "u2": true}, Timestamp: time.Now()}
+		require.NoError(t, b.save(postBkt, "id-3", deleted))
+		require.NoError(t, postBkt.Put([]byte("id-4"), []byte("{bad json")))
+
+		lastBkt := tx.Bucket([]byte(lastBucketName))
+		require.NoError(t, lastBkt.Put([]byte("2019-01-01"), []byte("https://radio-t.com!!id-missing")))
+		userBkt, e := b.getUserBucket(tx, "user1")
+		require.NoError(t, e)
+		return userBkt.Put([]byte("2019-01-02"), []byte("bad-ref"))
+	})
+	require.NoError(t, err)
+
+	report, err := b.Integrity(IntegrityRequest{SiteID: "radio-t"})
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Comments)
+	assert.Equal(t, 0, report.Repaired)
+	kinds := map[IntegrityIssueKind]int{}
+	for _, issue := range report.Issues {
+		kinds[issue.Kind]++
+		assert.False(t, issue.Repaired)
+	}
+	assert.Equal(t, map[IntegrityIssueKind]int{IssueBrokenRecord: 1, IssueBrokenLocator: 1, IssueDanglingParent: 1,
+		IssueOrphanedVotes: 1, IssueDanglingReference: 2}, kinds, "count matches as id-3 is deleted")
+	for i := 0; i < 10; i++ {
+		again, e := b.Integrity(IntegrityRequest{SiteID: "radio-t"})
+		require.NoError(t, e)
+		assert.Equal(t, report.Issues, again.Issues, "stable order of issues")
+	}
+
+	report, err = b.Integrity(IntegrityRequest{SiteID: "radio-t", Repair: true})
+	require.NoError(t, err)
+	assert.Equal(t, 6, len(report.Issues))
+	assert.Equal(t, 6, report.Repaired)
+
+	c, err := b.Get(getReq(store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, "id-2"))
+	require.NoError(t, err)
+	assert.Equal(t, "", c.ParentID)
+	assert.Equal(t, "https://radio-t.com", c.Locator.URL)
+
+	c, err = b.Get(getReq(store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, "id-3"))
+	require.NoError(t, err)
+	assert.Equal(t, 0, c.Score)
+	assert.Equal(t, 0, len(c.Votes))
+
+	report, err = b.Integrity(IntegrityRequest{SiteID: "radio-t"})
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(report.Issues), "all fixed")
+}
+
+func TestBoltDB_IntegrityCountMismatch(t *testing.T) {
+	b, teardown := prep(t)
+	defer teardown()
+
+	err := b.dbs["radio-t"].Update(func(tx *bolt.Tx) error {
+		_, e := b.count(tx, "https://radio-t.com", 5)
+		return e
+	})
+	require.NoError(t, err)
+
+	report, err := b.Integrity(IntegrityRequest{SiteID: "radio-t", Repair: true})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(report.Issues))
+	assert.Equal(t, IntegrityIssue{Kind: IssueCountMismatch, URL: "https://radio-t.com",
+		Details: "stored count 7, actual 2", Repaired: true}, report.Issues[0])
+
+	count, err := b.Count(FindRequest{Locator: store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}})
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func TestCompactBoltDB(t *testing.T) {
+	b, _ := prep(t)
+	defer os.Remove(testDB)
+	for i := 0; i < 100; i++ {
+		_, err := b.Create(store.Comment{ID: fmt.Sprintf("del-%d", i),
+			Text: "some long text to fill pages", Timestamp: time.Now(),
+			Locator: store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}, User: store.User{ID: "user2"}})
+		require.NoError(t, err)
+	}
+	require.NoError(t, b.Delete(DeleteRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "user2", DeleteMode: store.HardDelete}))
+	require.NoError(t, b.Close())
+
+	report, err := CompactBoltDB(testDB, bolt.Options{})
+	require.NoError(t, err)
+	assert.Equal(t, testDB, report.FileName)
+	assert.True(t, report.SizeAfter <= report.SizeBefore, "%+v", report)
+	_, err = os.Stat(testDB + ".compact")
+	assert.True(t, os.IsNotExist(err))
+
+	// reopen and verify data survived
+	b, err = NewBoltDB(bolt.Options{}, BoltSite{FileName: testDB, SiteID: "radio-t"})
+	require.NoError(t, err)
+	defer b.Close()
+	comments, err := b.Find(FindRequest{Locator: store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, Sort: "time"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(comments))
+	integrity, err := b.Integrity(IntegrityRequest{SiteID: "radio-t"})
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(integrity.Issues))
+
+	_, err = CompactBoltDB("/tmp/no-such-remark.db", bolt.Options{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,908 @@
+package engine
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// Memory implements store.Interface keeping all data in memory, for tests, demos and ephemeral environments. Thread safe.
+// Comments and user details kept serialized, the same way bolt keeps them, so callers never share data with the store.
+// With snapshot file set, data of all sites loaded from the file on start and written to it by Save and on Close.
+type Memory struct {
+	sites    map[string]*memSite
+	snapshot string // file of snapshot, data not persisted if empty
+	lock     sync.RWMutex
+}
+
+// memSite keeps all data of the site, serialized to snapshot as is
+type memSite struct {
+	Posts   map[string]map[string]json.RawMessage     `json:"posts"`   // comments by id, by url of post
+	Info    map[string]store.PostInfo                 `json:"info"`    // info by url of post, count, first and last time
+	Flags   map[Flag]map[string]time.Time             `json:"flags"`   // time of setting by url or user id, expiration for blocked
+	Details map[string]json.RawMessage                `json:"details"` // user details by user id
+	Records map[RecordKind]map[string]json.RawMessage `json:"records"` // records of other services by key, by kind
+}
+
+// NewMemory makes in-memory store for sites. Data loaded from snapshot file if it's set and exists.
+func NewMemory(snapshot string, sites ...string) (*Memory, error) {
+	log.Printf("[INFO] memory store for sites %+v, snapshot %q", sites, snapshot)
+	result := Memory{sites: map[string]*memSite{}, snapshot: snapshot}
+	if snapshot != "" {
+		data, err := ioutil.ReadFile(snapshot) // nolint:gosec // file set by admin
+		switch {
+		case err == nil:
+			if err = json.Unmarshal(data, &result.sites); err != nil {
+				return nil, errors.Wrapf(err, "can't load snapshot %s", snapshot)
+			}
+			for siteID, s := range result.sites {
+				result.sites[siteID] = s.init()
+			}
+			log.Printf("[INFO] memory store loaded from %s, %d sites", snapshot, len(result.sites))
+		case !os.IsNotExist(err):
+			return nil, errors.Wrapf(err, "can't read snapshot %s", snapshot)
+		}
+	}
+	for _, siteID := range sites {
+		if _, ok := result.sites[siteID]; !ok {
+			result.sites[siteID] = (&memSite{}).init()
+		}
+	}
+	return &result, nil
+}
+
+// Create saves new comment to store and updates post info
+func (m *Memory) Create(comment store.Comment) (commentID string, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	s, err := m.site(comment.Locator.SiteID)
+	if err != nil {
+		return "", err
+	}
+
+	if s.checkFlag(FlagRequest{Locator: comment.Locator, Flag: ReadOnly}) {
+		return "", errors.Errorf("post %s is read-only", comment.Locator.URL)
+	}
+
+	post, ok := s.Posts[comment.Locator.URL]
+	if !ok {
+		post = map[string]json.RawMessage{}
+		s.Posts[comment.Locator.URL] = post
+	}
+	// check if key already in store, reject doubles
+	if _, ok = post[comment.ID]; ok {
+		return "", errors.Errorf("key %s already in store", comment.ID)
+	}
+	if err = s.save(comment); err != nil {
+		return "", err
+	}
+
+	info, ok := s.Info[comment.Locator.URL]
+	if !ok {
+		info = store.PostInfo{URL: comment.Locator.URL, FirstTS: comment.Timestamp}
+	}
+	info.Count++
+	info.LastTS = comment.Timestamp
+	s.Info[comment.Locator.URL] = info
+	return comment.ID, nil
+}
+
+// Get returns comment for locator.URL and commentID string
+func (m *Memory) Get(req GetRequest) (comment store.Comment, err error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	s, err := m.site(req.Locator.SiteID)
+	if err != nil {
+		return comment, err
+	}
+	return s.load(req.Locator.URL, req.CommentID)
+}
+
+// Find returns all comments for given request and sorts results
+func (m *Memory) Find(req FindRequest) (comments []store.Comment, err error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	s, err := m.site(req.Locator.SiteID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case req.Locator.SiteID != "" && req.Locator.URL != "": // find post comments, i.e. for site and url
+		if _, ok := s.Posts[req.Locator.URL]; !ok {
+			return nil, errors.Errorf("no bucket %s in store", req.Locator.URL)
+		}
+		comments, err = s.comments(func(c store.Comment) bool {
+			return req.Since.IsZero() || c.Timestamp.After(req.Since)
+		}, req.Locator.URL)
+	case req.Locator.SiteID != "" && req.Locator.URL == "" && req.UserID == "": // find last comments for site
+		comments, err = s.lastComments(req.Limit, req.Since)
+	case req.Locator.SiteID != "" && req.UserID != "": // find comments for user
+		comments, err = s.userComments(req.UserID, req.Limit, req.Skip)
+	default:
+		comments = []store.Comment{}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return SortComments(comments, req.Sort), nil
+}
+
+// Flag sets and gets flag values
+func (m *Memory) Flag(req FlagRequest) (val bool, err error) {
+	if req.Update == FlagNonSet { // read flag value, no update requested
+		m.lock.RLock()
+		defer m.lock.RUnlock()
+		s, err := m.site(req.Locator.SiteID)
+		if err != nil {
+			return false, err
+		}
+		return s.checkFlag(req), nil
+	}
+
+	// write flag value
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	s, err := m.site(req.Locator.SiteID)
+	if err != nil {
+		return false, err
+	}
+	return s.setFlag(req)
+}
+
+// Reattribute moves all comments of req.FromID, or only req.IDs if set, to req.To user, returns ids of moved comments
+func (m *Memory) Reattribute(req ReattributeRequest) (ids []string, err error) {
+	if err = req.validate(); err != nil {
+		return nil, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	s, err := m.site(req.Locator.SiteID)
+	if err != nil {
+		return nil, err
+	}
+
+	comments, err := s.comments(func(c store.Comment) bool { return c.User.ID == req.FromID })
+	if err != nil {
+		return nil, err
+	}
+	if len(comments) == 0 {
+		return nil, errors.Errorf("no comments for user %s in store", req.FromID)
+	}
+	SortComments(comments, "time")
+	for _, c := range comments {
+		if !req.selected(c.ID) {
+			continue
+		}
+		c.User = req.user(c.User)
+		if err = s.save(c); err != nil {
+			return nil, err
+		}
+		ids = append(ids, c.ID)
+	}
+	return ids, nil
+}
+
+// Remap moves all comments of posts to new urls, along with post info and flags. Returns ids of moved comments.
+func (m *Memory) Remap(req RemapRequest) (ids []string, err error) {
+	if err = req.validate(); err != nil {
+		return nil, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	s, err := m.site(req.Locator.SiteID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, from := range req.froms() {
+		moved, e := s.remapPost(from, req.URLs[from])
+		if e != nil {
+			return nil, errors.Wrapf(e, "can't remap %s to %s", from, req.URLs[from])
+		}
+		ids = append(ids, moved...)
+	}
+	return ids, nil
+}
+
+// Record sets, gets, lists or deletes records of the site
+func (m *Memory) Record(req RecordRequest) ([]RecordEntry, error) {
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	s, err := m.site(req.Locator.SiteID)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []RecordEntry{}
+	records := s.Records[req.Kind]
+	old, found := records[req.Key]
+	switch req.Op {
+	case RecordGet:
+		if found {
+			res = append(res, RecordEntry{Key: req.Key, Value: old})
+		}
+	case RecordList:
+		for k, v := range records {
+			if strings.HasPrefix(k, req.Key) {
+				res = append(res, RecordEntry{Key: k, Value: v})
+			}
+		}
+		sort.Slice(res, func(i, j int) bool { return res[i].Key < res[j].Key })
+	case RecordSet, RecordAdd:
+		if req.Op == RecordAdd && found {
+			return res, nil
+		}
+		if records == nil {
+			records = map[string]json.RawMessage{}
+			s.Records[req.Kind] = records
+		}
+		records[req.Key] = append(json.RawMessage{}, req.Value...)
+		res = append(res, RecordEntry{Key: req.Key, Value: req.Value})
+	case RecordDelete:
+		if found {
+			delete(records, req.Key)
+			res = append(res, RecordEntry{Key: req.Key, Value: old})
+		}
+	}
+	return res, nil
+}
+
+// UserDetail sets or gets single detail value, or gets all details for requested site.
+// UserDetail returns list even for single entry request is a compromise in order to have both single detail getting and setting
+// and all site's details listing under the same function (and not to extend interface by two separate functions).
+func (m *Memory) UserDetail(req UserDetailRequest) ([]UserDetailEntry, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	s, err := m.site(req.Locator.SiteID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch req.Detail {
+	case UserEmail, UserConsent, UserProfile:
+		if req.UserID == "" {
+			return nil, errors.New("userid cannot be empty in request for single detail")
+		}
+
+		if req.Update == "" && req.Profile == nil { // read detail value, no update requested
+			return s.getUserDetail(req)
+		}
+
+		return s.setUserDetail(req)
+	case AllUserDetails:
+		// list of all details returned in case request is a read request
+		// (Update is not set) and does not have UserID
+		if req.Update == "" && req.UserID == "" { // read list of all details
+			return s.listDetails()
+		}
+		return nil, errors.New("unsupported request with userdetail all")
+	default:
+		return nil, errors.Errorf("unsupported detail %q", req.Detail)
+	}
+}
+
+// Update for locator.URL with mutable part of comment
+func (m *Memory) Update(comment store.Comment) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	s, err := m.site(comment.Locator.SiteID)
+	if err != nil {
+		return err
+	}
+
+	curComment, err := s.load(comment.Locator.URL, comment.ID)
+	if err != nil {
+		return err
+	}
+
+	// preserve immutable fields
+	comment.ParentID = curComment.ParentID
+	comment.Locator = curComment.Locator
+	comment.Timestamp = curComment.Timestamp
+	comment.User = curComment.User
+
+	return s.save(comment)
+}
+
+// Count returns number of comments for post or user
+func (m *Memory) Count(req FindRequest) (count int, err error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	s, err := m.site(req.Locator.SiteID)
+	if err != nil {
+		return 0, err
+	}
+
+	if req.Locator.URL != "" { // comment's count for post
+		return s.Info[req.Locator.URL].Count, nil
+	}
+
+	if req.UserID != "" { // comment's count for user
+		comments, e := s.comments(func(c store.Comment) bool { return c.User.ID == req.UserID })
+		if e != nil {
+			return 0, e
+		}
+		if len(comments) == 0 {
+			return 0, errors.Errorf("no comments for user %s in store for %s site", req.UserID, req.Locator.SiteID)
+		}
+		return len(comments), nil
+	}
+
+	return 0, errors.Errorf("invalid count request %+v", req)
+}
+
+// Info get post(s) meta info
+func (m *Memory) Info(req InfoRequest) ([]store.PostInfo, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	s, err := m.site(req.Locator.SiteID)
+	if err != nil {
+		return []store.PostInfo{}, err
+	}
+
+	if req.Locator.URL != "" { // post info
+		info, ok := s.Info[req.Locator.URL]
+		if !ok {
+			err = errors.Errorf("can't load info for %s: no value for %s", req.Locator.URL, req.Locator.URL)
+		}
+
+		// set read-only from age and manual flag
+		readOnlyAge := req.ReadOnlyAge
+		info.ReadOnly = readOnlyAge > 0 && !info.FirstTS.IsZero() && info.FirstTS.AddDate(0, 0, readOnlyAge).Before(time.Now())
+		if s.checkFlag(FlagRequest{Locator: req.Locator, Flag: ReadOnly}) {
+			info.ReadOnly = true
+		}
+		return []store.PostInfo{info}, err
+	}
+
+	if req.Locator.URL == "" && req.Locator.SiteID != "" { // site info (list), ordered by url desc as bolt does
+		urls := make([]string, 0, len(s.Info))
+		for url := range s.Info {
+			urls = append(urls, url)
+		}
+		sort.Sort(sort.Reverse(sort.StringSlice(urls)))
+		list := []store.PostInfo{}
+		for i, url := range urls {
+			if i < req.Skip {
+				continue
+			}
+			list = append(list, s.Info[url])
+			if req.Limit > 0 && len(list) >= req.Limit {
+				break
+			}
+		}
+		return list, nil
+	}
+
+	return nil, errors.Errorf("invalid info request %+v", req)
+}
+
+// ListFlags get list of flagged keys, like blocked & verified user
+func (m *Memory) ListFlags(req FlagRequest) (res []interface{}, err error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	s, err := m.site(req.Locator.SiteID)
+	if err != nil {
+		return nil, err
+	}
+
+	res = []interface{}{}
+	switch req.Flag {
+	case Verified, Shadowed:
+		for _, key := range s.flagKeys(req.Flag) {
+			res = append(res, key)
+		}
+		return res, nil
+	case Blocked:
+		for _, key := range s.flagKeys(Blocked) {
+			until := s.Flags[Blocked][key]
+			if !time.Now().Before(until) {
+				continue
+			}
+			// get user name from comment user section
+			user := store.BlockedUser{ID: key, Until: until}
+			if userComments, errUser := s.userComments(key, 1, 0); errUser == nil && len(userComments) > 0 {
+				user.Name = userComments[0].User.Name
+			}
+			res = append(res, user)
+		}
+		return res, nil
+	}
+	return nil, errors.Errorf("flag %s not listable", req.Flag)
+}
+
+// Delete post(s), user, comment, user details, or everything
+func (m *Memory) Delete(req DeleteRequest) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	s, err := m.site(req.Locator.SiteID)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case req.UserDetail != "": // delete user detail
+		return s.deleteUserDetail(req.UserID, req.UserDetail)
+	case req.Locator.URL != "" && req.CommentID != "" && req.UserDetail == "": // delete comment
+		return s.deleteComment(req.Locator, req.CommentID, req.DeleteMode)
+	case req.Locator.URL != "" && req.CommentID == "" && req.UserID == "" && req.UserDetail == "": // delete post
+		if _, ok := s.Posts[req.Locator.URL]; !ok {
+			return errors.Errorf("no bucket %s in store", req.Locator.URL)
+		}
+		delete(s.Posts, req.Locator.URL)
+		delete(s.Info, req.Locator.URL)
+		return nil
+	case req.Locator.SiteID != "" && req.UserID != "" && req.CommentID == "" && req.UserDetail == "": // delete user
+		return s.deleteUser(req.UserID, req.DeleteMode)
+	case req.Locator.SiteID != "" && req.Locator.URL == "" && req.CommentID == "" && req.UserID == "" && req.UserDetail == "": // delete site
+		// flags and records kept, the same as by other engines
+		s.Posts, s.Info, s.Details = nil, nil, nil
+		s.init()
+		return nil
+	}
+
+	return errors.Errorf("invalid delete request %+v", req)
+}
+
+// AddSite makes empty storage of the site, no-op for existing one
+func (m *Memory) AddSite(siteID string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.sites[siteID]; !ok {
+		m.sites[siteID] = (&memSite{}).init()
+	}
+	return nil
+}
+
+// RemoveSite removes all data of the site, including flags
+func (m *Memory) RemoveSite(siteID string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, err := m.site(siteID); err != nil {
+		return err
+	}
+	delete(m.sites, siteID)
+	return nil
+}
+
+// Save writes snapshot of all sites to the file, replaced atomically. No-op if snapshot file not set.
+func (m *Memory) Save() error {
+	if m.snapshot == "" {
+		return nil
+	}
+	m.lock.RLock()
+	data, err := json.Marshal(m.sites)
+	m.lock.RUnlock()
+	if err != nil {
+		return errors.Wrap(err, "can't marshal snapshot")
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(m.snapshot), filepath.Base(m.snapshot)+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "can't make temp file of snapshot")
+	}
+	defer os.Remove(tmp.Name()) // nolint
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return errors.Wrapf(err, "can't write snapshot %s", tmp.Name())
+	}
+	if err = tmp.Close(); err != nil {
+		return errors.Wrapf(err, "can't close snapshot %s", tmp.Name())
+	}
+	return errors.Wrapf(os.Rename(tmp.Name(), m.snapshot), "can't save snapshot %s", m.snapshot)
+}
+
+// Close saves snapshot if snapshot file set
+func (m *Memory) Close() error {
+	return m.Save()
+}
+
+func (m *Memory) site(siteID string) (*memSite, error) {
+	s, ok := m.sites[siteID]
+	if !ok {
+		return nil, errors.Errorf("site %q not found", siteID)
+	}
+	return s, nil
+}
+
+// init makes missing maps, i.e. of site loaded from snapshot
+func (s *memSite) init() *memSite {
+	if s.Posts == nil {
+		s.Posts = map[string]map[string]json.RawMessage{}
+	}
+	if s.Info == nil {
+		s.Info = map[string]store.PostInfo{}
+	}
+	if s.Flags == nil {
+		s.Flags = map[Flag]map[string]time.Time{}
+	}
+	if s.Details == nil {
+		s.Details = map[string]json.RawMessage{}
+	}
+	if s.Records == nil {
+		s.Records = map[RecordKind]map[string]json.RawMessage{}
+	}
+	return s
+}
+
+// load returns comment of the post
+func (s *memSite) load(url, commentID string) (comment store.Comment, err error) {
+	post, ok := s.Posts[url]
+	if !ok {
+		return comment, errors.Errorf("no bucket %s in store", url)
+	}
+	data, ok := post[commentID]
+	if !ok {
+		return comment, errors.Errorf("no value for %s", commentID)
+	}
+	err = errors.Wrap(json.Unmarshal(data, &comment), "failed to unmarshal")
+	return comment, err
+}
+
+// save puts comment to its post, the post should exist
+func (s *memSite) save(comment store.Comment) error {
+	post, ok := s.Posts[comment.Locator.URL]
+	if !ok {
+		return errors.Errorf("no bucket %s in store", comment.Locator.URL)
+	}
+	data, err := json.Marshal(comment)
+	if err != nil {
+		return errors.Wrap(err, "can't marshal comment")
+	}
+	post[comment.ID] = data
+	return nil
+}
+
+// comments returns comments of the site, or of the given posts only, matched by fn
+func (s *memSite) comments(fn func(c store.Comment) bool, urls ...string) ([]store.Comment, error) {
+	if len(urls) == 0 {
+		for url := range s.Posts {
+			urls = append(urls, url)
+		}
+	}
+	res := []store.Comment{}
+	for _, url := range urls {
+		for _, data := range s.Posts[url] {
+			comment := store.Comment{}
+			if err := json.Unmarshal(data, &comment); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal")
+			}
+			if fn(comment) {
+				res = append(res, comment)
+			}
+		}
+	}
+	return res, nil
+}
+
+// lastComments returns up to max last not deleted comments, newest first
+func (s *memSite) lastComments(max int, since time.Time) ([]store.Comment, error) {
+	if max > lastLimit || max == 0 {
+		max = lastLimit
+	}
+	comments, err := s.comments(func(c store.Comment) bool {
+		return !c.Deleted && (since.IsZero() || c.Timestamp.After(since))
+	})
+	if err != nil {
+		return nil, err
+	}
+	SortComments(comments, "-time")
+	if len(comments) > max {
+		comments = comments[:max]
+	}
+	return comments, nil
+}
+
+// userComments returns comments of the user, newest first
+func (s *memSite) userComments(userID string, limit, skip int) ([]store.Comment, error) {
+	if limit == 0 || limit > userLimit {
+		limit = userLimit
+	}
+	comments, err := s.comments(func(c store.Comment) bool { return c.User.ID == userID })
+	if err != nil {
+		return nil, err
+	}
+	if len(comments) == 0 {
+		return nil, errors.Errorf("no comments for user %s in store", userID)
+	}
+	SortComments(comments, "-time")
+	if skip >= len(comments) {
+		return []store.Comment{}, nil
+	}
+	comments = comments[skip:]
+	if len(comments) > limit {
+		comments = comments[:limit]
+	}
+	return comments, nil
+}
+
+func (s *memSite) checkFlag(req FlagRequest) bool {
+	key := req.Locator.URL
+	if req.UserID != "" {
+		key = req.UserID
+	}
+	ts, ok := s.Flags[req.Flag][key]
+	if !ok {
+		return false
+	}
+	if req.Flag == Blocked {
+		return time.Now().Before(ts)
+	}
+	return true
+}
+
+func (s *memSite) setFlag(req FlagRequest) (bool, error) {
+	switch req.Flag {
+	case ReadOnly, Blocked, Verified, SlowMode, Shadowed:
+	default:
+		return false, errors.Errorf("unsupported flag %v", req.Flag)
+	}
+
+	key := req.Locator.URL
+	if req.UserID != "" {
+		key = req.UserID
+	}
+
+	switch req.Update {
+	case FlagTrue:
+		ts := time.Now() // for blocked flag it is expiration time, for others time of setting
+		if req.Flag == Blocked {
+			ts = time.Now().AddDate(100, 0, 0) // permanent is 100 year
+			if req.TTL > 0 {
+				ts = time.Now().Add(req.TTL)
+			}
+		}
+		if s.Flags[req.Flag] == nil {
+			s.Flags[req.Flag] = map[string]time.Time{}
+		}
+		s.Flags[req.Flag][key] = ts
+		return true, nil
+	case FlagFalse:
+		delete(s.Flags[req.Flag], key)
+	}
+	return false, nil
+}
+
+// flagKeys returns sorted keys of the flag
+func (s *memSite) flagKeys(flag Flag) []string {
+	res := make([]string, 0, len(s.Flags[flag]))
+	for key := range s.Flags[flag] {
+		res = append(res, key)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// userDetail returns details of the user, empty if not set
+func (s *memSite) userDetail(userID string) (entry UserDetailEntry, err error) {
+	data, ok := s.Details[userID]
+	if !ok {
+		return entry, nil
+	}
+	err = errors.Wrap(json.Unmarshal(data, &entry), "failed to unmarshal entry")
+	return entry, err
+}
+
+// getUserDetail returns UserDetailEntry with requested userDetail (omitting other details)
+// as an only element of the slice.
+func (s *memSite) getUserDetail(req UserDetailRequest) (result []UserDetailEntry, err error) {
+	if _, ok := s.Details[req.UserID]; !ok { // return no error in case of absent entry
+		return result, nil
+	}
+	entry, err := s.userDetail(req.UserID)
+	if err != nil {
+		return result, err
+	}
+	switch req.Detail {
+	case UserEmail:
+		return []UserDetailEntry{{UserID: req.UserID, Email: entry.Email}}, nil
+	case UserConsent:
+		return []UserDetailEntry{{UserID: req.UserID, Consent: entry.Consent, ConsentTime: entry.ConsentTime}}, nil
+	case UserProfile:
+		return []UserDetailEntry{{UserID: req.UserID, Profile: entry.Profile}}, nil
+	}
+	return result, nil
+}
+
+// setUserDetail sets requested userDetail, returning complete updated UserDetailEntry as an only
+// element of the slice in case of success
+func (s *memSite) setUserDetail(req UserDetailRequest) (result []UserDetailEntry, err error) {
+	entry, err := s.userDetail(req.UserID)
+	if err != nil {
+		return result, err
+	}
+	entry.UserID = req.UserID
+
+	switch req.Detail {
+	case UserEmail:
+		entry.Email = req.Update
+	case UserConsent:
+		ts := time.Now()
+		if req.Time != nil {
+			ts = *req.Time
+		}
+		ts = ts.UTC()
+		entry.Consent, entry.ConsentTime = req.Update, &ts
+	case UserProfile:
+		entry.Profile = req.Profile
+	}
+
+	if err = s.saveUserDetail(entry); err != nil {
+		return result, errors.Wrapf(err, "failed to update detail %s for %s", req.Detail, req.UserID)
+	}
+	return []UserDetailEntry{entry}, nil
+}
+
+// listDetails lists all available users details, ordered by user id
+func (s *memSite) listDetails() (result []UserDetailEntry, err error) {
+	ids := make([]string, 0, len(s.Details))
+	for userID := range s.Details {
+		ids = append(ids, userID)
+	}
+	sort.Strings(ids)
+	for _, userID := range ids {
+		entry, e := s.userDetail(userID)
+		if e != nil {
+			return nil, e
+		}
+		result = append(result, entry)
+	}
+	return result, nil
+}
+
+func (s *memSite) saveUserDetail(entry UserDetailEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "can't marshal entry")
+	}
+	s.Details[entry.UserID] = data
+	return nil
+}
+
+// deleteUserDetail deletes requested UserDetail or whole UserDetailEntry.
+// Entry removed if it has no details left.
+func (s *memSite) deleteUserDetail(userID string, userDetail UserDetail) error {
+	switch userDetail {
+	case UserEmail, UserConsent, UserProfile, AllUserDetails:
+	default:
+		return errors.Errorf("unsupported detail %q", userDetail)
+	}
+	if _, ok := s.Details[userID]; !ok {
+		return nil // absent entry means that we should not do anything
+	}
+	entry, err := s.userDetail(userID)
+	if err != nil {
+		return err
+	}
+
+	switch userDetail {
+	case UserEmail:
+		entry.Email = ""
+	case UserConsent:
+		entry.Consent, entry.ConsentTime = "", nil
+	case UserProfile:
+		entry.Profile = nil
+	case AllUserDetails:
+		entry = UserDetailEntry{UserID: userID}
+	}
+
+	if entry == (UserDetailEntry{UserID: userID}) {
+		delete(s.Details, userID)
+		return nil
+	}
+	return errors.Wrapf(s.saveUserDetail(entry), "failed to update detail %s for %s", userDetail, userID)
+}
+
+// deleteComment marks comment as deleted, clears its fields and decrements post count
+func (s *memSite) deleteComment(locator store.Locator, commentID string, mode store.DeleteMode) error {
+	comment, err := s.load(locator.URL, commentID)
+	if err != nil {
+		return errors.Wrapf(err, "can't load key %s from %s", commentID, locator.URL)
+	}
+
+	if !comment.Deleted {
+		info := s.Info[locator.URL]
+		info.Count--
+		s.Info[locator.URL] = info
+	}
+
+	// set deleted status and clear fields
+	comment.SetDeleted(mode)
+	return errors.Wrapf(s.save(comment), "can't save deleted comment %s", commentID)
+}
+
+// deleteUser marks all comments of the user as deleted and removes user details
+func (s *memSite) deleteUser(userID string, mode store.DeleteMode) error {
+	comments, err := s.comments(func(c store.Comment) bool { return c.User.ID == userID })
+	if err != nil {
+		return err
+	}
+	log.Printf("[DEBUG] comments for removal=%d", len(comments))
+	if len(comments) == 0 {
+		return errors.Errorf("unknown user %s", userID)
+	}
+	for _, c := range comments {
+		if err = s.deleteComment(c.Locator, c.ID, mode); err != nil {
+			return errors.Wrapf(err, "failed to delete comment %s", c.ID)
+		}
+	}
+	return s.deleteUserDetail(userID, AllUserDetails)
+}
+
+// remapPost moves comments of the post to another url, merged with comments of the url if any. Flags of new url kept.
+func (s *memSite) remapPost(from, to string) (ids []string, err error) {
+	if _, ok := s.Posts[from]; !ok {
+		return nil, nil // no comments for the post
+	}
+	comments, err := s.comments(func(store.Comment) bool { return true }, from)
+	if err != nil {
+		return nil, err
+	}
+	SortComments(comments, "time")
+
+	if _, ok := s.Posts[to]; !ok {
+		s.Posts[to] = map[string]json.RawMessage{}
+	}
+	for _, c := range comments {
+		c.Locator.URL = to
+		if err = s.save(c); err != nil {
+			return nil, errors.Wrapf(err, "failed to move comment %s", c.ID)
+		}
+		ids = append(ids, c.ID)
+	}
+	delete(s.Posts, from)
+
+	if fromInfo, ok := s.Info[from]; ok {
+		info, exists := s.Info[to]
+		if !exists {
+			info = store.PostInfo{URL: to, FirstTS: fromInfo.FirstTS, LastTS: fromInfo.LastTS}
+		}
+		info.Count += fromInfo.Count
+		if fromInfo.FirstTS.Before(info.FirstTS) {
+			info.FirstTS = fromInfo.FirstTS
+		}
+		if fromInfo.LastTS.After(info.LastTS) {
+			info.LastTS = fromInfo.LastTS
+		}
+		s.Info[to] = info
+		delete(s.Info, from)
+	}
+
+	for _, flag := range []Flag{ReadOnly, SlowMode} {
+		ts, ok := s.Flags[flag][from]
+		if !ok {
+			continue
+		}
+		if _, exists := s.Flags[flag][to]; !exists {
+			s.Flags[flag][to] = ts
+		}
+		delete(s.Flags[flag], from)
+	}
+
+	for _, kind := range postRecordKinds {
+		val, ok := s.Records[kind][from]
+		if !ok {
+			continue
+		}
+		if _, exists := s.Records[kind][to]; !exists {
+			s.Records[kind][to] = val
+		}
+		delete(s.Records[kind], from)
+	}
+	return ids, nil
+}
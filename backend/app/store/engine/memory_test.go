@@ -0,0 +1,567 @@
+package engine
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestMemory_CreateAndFind(t *testing.T) {
+	m, teardown := prepMemory(t)
+	defer teardown()
+
+	var _ Interface = m
+
+	req := FindRequest{Locator: store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, Sort: "time"}
+	res, err := m.Find(req)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(res))
+	assert.Equal(t, `some text, <a href="http://radio-t.com">link</a>`, res[0].Text)
+	assert.Equal(t, "user1", res[0].User.ID)
+	assert.True(t, res[0].Timestamp.Equal(time.Date(2017, 12, 20, 15, 18, 22, 0, time.Local)))
+
+	_, err = m.Create(store.Comment{ID: res[0].ID, Locator: store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}})
+	assert.EqualError(t, err, "key id-1 already in store")
+
+	req = FindRequest{Locator: store.Locator{URL: "https://radio-t.com", SiteID: "radio-t-bad"}, Sort: "time"}
+	_, err = m.Find(req)
+	assert.EqualError(t, err, `site "radio-t-bad" not found`)
+
+	_, err = m.Find(FindRequest{Locator: store.Locator{URL: "https://radio-t.com/unknown", SiteID: "radio-t"}})
+	assert.Error(t, err, "unknown post")
+
+	res, err = m.Find(FindRequest{Locator: store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"},
+		Since: time.Date(2017, 12, 20, 15, 18, 22, 0, time.Local)})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, "id-2", res[0].ID)
+
+	res, err = m.Find(FindRequest{Locator: store.Locator{SiteID: "radio-t"}, Sort: "-time", Limit: 1})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, "id-2", res[0].ID, "last comments")
+
+	res, err = m.Find(FindRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "user1", Limit: 5, Skip: 1})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, "id-1", res[0].ID, "user comments, newest skipped")
+
+	_, err = m.Find(FindRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "user-unknown"})
+	assert.EqualError(t, err, "no comments for user user-unknown in store")
+}
+
+func TestMemory_GetUpdateCount(t *testing.T) {
+	m, teardown := prepMemory(t)
+	defer teardown()
+
+	loc := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	comment, err := m.Get(GetRequest{Locator: loc, CommentID: "id-2"})
+	require.NoError(t, err)
+	assert.Equal(t, "some text2", comment.Text)
+
+	_, err = m.Get(GetRequest{Locator: loc, CommentID: "id-1111"})
+	assert.EqualError(t, err, "no value for id-1111")
+
+	comment.Text = "updated text"
+	comment.User.ID = "user-changed"
+	require.NoError(t, m.Update(comment))
+	comment, err = m.Get(GetRequest{Locator: loc, CommentID: "id-2"})
+	require.NoError(t, err)
+	assert.Equal(t, "updated text", comment.Text)
+	assert.Equal(t, "user1", comment.User.ID, "immutable user kept")
+
+	assert.Error(t, m.Update(store.Comment{ID: "id-1111", Locator: loc}))
+
+	count, err := m.Count(FindRequest{Locator: loc})
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	count, err = m.Count(FindRequest{Locator: store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/unknown"}})
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+	count, err = m.Count(FindRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "user1"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	_, err = m.Count(FindRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "user-unknown"})
+	assert.Error(t, err)
+}
+
+func TestMemory_Info(t *testing.T) {
+	m, teardown := prepMemory(t)
+	defer teardown()
+
+	_, err := m.Create(store.Comment{ID: "id-3", Text: "text 3", Timestamp: time.Date(2017, 12, 21, 10, 0, 0, 0, time.UTC),
+		Locator: store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}, User: store.User{ID: "user2"}})
+	require.NoError(t, err)
+
+	info, err := m.Info(InfoRequest{Locator: store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, ReadOnlyAge: 10})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(info))
+	assert.Equal(t, "https://radio-t.com", info[0].URL)
+	assert.Equal(t, 2, info[0].Count)
+	assert.True(t, info[0].ReadOnly, "old post")
+	assert.True(t, info[0].FirstTS.Equal(time.Date(2017, 12, 20, 15, 18, 22, 0, time.Local)))
+	assert.True(t, info[0].LastTS.Equal(time.Date(2017, 12, 20, 15, 18, 23, 0, time.Local)))
+
+	_, err = m.Info(InfoRequest{Locator: store.Locator{URL: "https://radio-t.com/unknown", SiteID: "radio-t"}})
+	assert.Error(t, err)
+
+	list, err := m.Info(InfoRequest{Locator: store.Locator{SiteID: "radio-t"}})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(list))
+	assert.Equal(t, "https://radio-t.com/2", list[0].URL)
+	assert.Equal(t, 1, list[0].Count)
+
+	list, err = m.Info(InfoRequest{Locator: store.Locator{SiteID: "radio-t"}, Limit: 1, Skip: 1})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(list))
+	assert.Equal(t, "https://radio-t.com", list[0].URL)
+}
+
+func TestMemory_Flags(t *testing.T) {
+	m, teardown := prepMemory(t)
+	defer teardown()
+
+	loc := store.Locator{URL: "https://radio-t.com/ro", SiteID: "radio-t"}
+	v, err := m.Flag(FlagRequest{Locator: loc, Flag: ReadOnly, Update: FlagTrue})
+	require.NoError(t, err)
+	assert.True(t, v)
+	_, err = m.Create(store.Comment{ID: "id-ro", Locator: loc, User: store.User{ID: "user1"}})
+	assert.EqualError(t, err, "post https://radio-t.com/ro is read-only")
+	v, err = m.Flag(FlagRequest{Locator: loc, Flag: ReadOnly, Update: FlagFalse})
+	require.NoError(t, err)
+	assert.False(t, v)
+	v, err = m.Flag(FlagRequest{Locator: loc, Flag: ReadOnly})
+	require.NoError(t, err)
+	assert.False(t, v)
+
+	siteLoc := store.Locator{SiteID: "radio-t"}
+	_, err = m.Flag(FlagRequest{Locator: siteLoc, UserID: "user1", Flag: Verified, Update: FlagTrue})
+	require.NoError(t, err)
+	verified, err := m.ListFlags(FlagRequest{Locator: siteLoc, Flag: Verified})
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{"user1"}, verified)
+
+	_, err = m.Flag(FlagRequest{Locator: siteLoc, UserID: "user1", Flag: Blocked, Update: FlagTrue, TTL: 100 * time.Millisecond})
+	require.NoError(t, err)
+	_, err = m.Flag(FlagRequest{Locator: siteLoc, UserID: "user2", Flag: Blocked, Update: FlagTrue})
+	require.NoError(t, err)
+	v, err = m.Flag(FlagRequest{Locator: siteLoc, UserID: "user1", Flag: Blocked})
+	require.NoError(t, err)
+	assert.True(t, v)
+	blocked, err := m.ListFlags(FlagRequest{Locator: siteLoc, Flag: Blocked})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(blocked))
+	assert.Equal(t, "user1", blocked[0].(store.BlockedUser).ID)
+	assert.Equal(t, "user name", blocked[0].(store.BlockedUser).Name)
+
+	time.Sleep(150 * time.Millisecond)
+	v, err = m.Flag(FlagRequest{Locator: siteLoc, UserID: "user1", Flag: Blocked})
+	require.NoError(t, err)
+	assert.False(t, v, "block expired")
+	blocked, err = m.ListFlags(FlagRequest{Locator: siteLoc, Flag: Blocked})
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(blocked))
+
+	_, err = m.ListFlags(FlagRequest{Locator: siteLoc, Flag: ReadOnly})
+	assert.EqualError(t, err, "flag readonly not listable")
+	_, err = m.Flag(FlagRequest{Locator: siteLoc, Flag: "bad", Update: FlagTrue})
+	assert.EqualError(t, err, "unsupported flag bad")
+}
+
+func TestMemory_UserDetail(t *testing.T) {
+	m, teardown := prepMemory(t)
+	defer teardown()
+
+	loc := store.Locator{SiteID: "radio-t"}
+	res, err := m.UserDetail(UserDetailRequest{Locator: loc, UserID: "user1", Detail: UserEmail})
+	require.NoError(t, err)
+	assert.Nil(t, res)
+
+	res, err = m.UserDetail(UserDetailRequest{Locator: loc, UserID: "user1", Detail: UserEmail, Update: "u1@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, []UserDetailEntry{{UserID: "user1", Email: "u1@example.com"}}, res)
+	_, err = m.UserDetail(UserDetailRequest{Locator: loc, UserID: "user2", Detail: UserEmail, Update: "u2@example.com"})
+	require.NoError(t, err)
+
+	res, err = m.UserDetail(UserDetailRequest{Locator: loc, UserID: "user1", Detail: UserEmail})
+	require.NoError(t, err)
+	assert.Equal(t, []UserDetailEntry{{UserID: "user1", Email: "u1@example.com"}}, res)
+
+	res, err = m.UserDetail(UserDetailRequest{Locator: loc, Detail: AllUserDetails})
+	require.NoError(t, err)
+	assert.Equal(t, []UserDetailEntry{{UserID: "user1", Email: "u1@example.com"}, {UserID: "user2", Email: "u2@example.com"}}, res)
+
+	require.NoError(t, m.Delete(DeleteRequest{Locator: loc, UserID: "user1", UserDetail: UserEmail}))
+	res, err = m.UserDetail(UserDetailRequest{Locator: loc, UserID: "user1", Detail: UserEmail})
+	require.NoError(t, err)
+	assert.Nil(t, res)
+
+	_, err = m.UserDetail(UserDetailRequest{Locator: loc, Detail: UserEmail})
+	assert.EqualError(t, err, "userid cannot be empty in request for single detail")
+	_, err = m.UserDetail(UserDetailRequest{Locator: loc, Detail: "bad"})
+	assert.EqualError(t, err, `unsupported detail "bad"`)
+}
+
+func TestMemory_Record(t *testing.T) {
+	m, teardown := prepMemory(t)
+	defer teardown()
+
+	loc := store.Locator{SiteID: "radio-t"}
+	res, err := m.Record(RecordRequest{Op: RecordGet, Kind: Drafts, Locator: loc, Key: "k1"})
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	res, err = m.Record(RecordRequest{Op: RecordSet, Kind: Drafts, Locator: loc, Key: "k1", Value: json.RawMessage(`"v1"`)})
+	require.NoError(t, err)
+	assert.Equal(t, []RecordEntry{{Key: "k1", Value: json.RawMessage(`"v1"`)}}, res)
+	res, err = m.Record(RecordRequest{Op: RecordAdd, Kind: Drafts, Locator: loc, Key: "k1", Value: json.RawMessage(`"v2"`)})
+	require.NoError(t, err)
+	assert.Empty(t, res, "used key not added")
+	res, err = m.Record(RecordRequest{Op: RecordAdd, Kind: Drafts, Locator: loc, Key: "k2", Value: json.RawMessage(`"v2"`)})
+	require.NoError(t, err)
+	assert.Equal(t, []RecordEntry{{Key: "k2", Value: json.RawMessage(`"v2"`)}}, res)
+	_, err = m.Record(RecordRequest{Op: RecordSet, Kind: Drafts, Locator: loc, Key: "x1", Value: json.RawMessage(`3`)})
+	require.NoError(t, err)
+	_, err = m.Record(RecordRequest{Op: RecordSet, Kind: "other", Locator: loc, Key: "k3", Value: json.RawMessage(`"v3"`)})
+	require.NoError(t, err)
+
+	res, err = m.Record(RecordRequest{Op: RecordGet, Kind: Drafts, Locator: loc, Key: "k1"})
+	require.NoError(t, err)
+	assert.Equal(t, []RecordEntry{{Key: "k1", Value: json.RawMessage(`"v1"`)}}, res)
+	res, err = m.Record(RecordRequest{Op: RecordList, Kind: Drafts, Locator: loc, Key: "k"})
+	require.NoError(t, err)
+	assert.Equal(t, []RecordEntry{{Key: "k1", Value: json.RawMessage(`"v1"`)}, {Key: "k2", Value: json.RawMessage(`"v2"`)}}, res)
+	res, err = m.Record(RecordRequest{Op: RecordList, Kind: Drafts, Locator: loc})
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(res))
+
+	res, err = m.Record(RecordRequest{Op: RecordDelete, Kind: Drafts, Locator: loc, Key: "k1"})
+	require.NoError(t, err)
+	assert.Equal(t, []RecordEntry{{Key: "k1", Value: json.RawMessage(`"v1"`)}}, res)
+	res, err = m.Record(RecordRequest{Op: RecordDelete, Kind: Drafts, Locator: loc, Key: "k1"})
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	require.NoError(t, m.Delete(DeleteRequest{Locator: loc}))
+	res, err = m.Record(RecordRequest{Op: RecordList, Kind: Drafts, Locator: loc})
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(res), "records kept on deletion of all comments")
+
+	_, err = m.Record(RecordRequest{Op: RecordGet, Kind: Drafts, Locator: store.Locator{SiteID: "bad"}, Key: "k1"})
+	assert.EqualError(t, err, `site "bad" not found`)
+	_, err = m.Record(RecordRequest{Op: RecordGet, Kind: Drafts, Locator: loc})
+	assert.EqualError(t, err, "no key of drafts record")
+	_, err = m.Record(RecordRequest{Op: RecordSet, Kind: Drafts, Locator: loc, Key: "k1", Value: json.RawMessage(`bad`)})
+	assert.EqualError(t, err, "invalid value of drafts record k1")
+	_, err = m.Record(RecordRequest{Op: "bad", Kind: Drafts, Locator: loc, Key: "k1"})
+	assert.EqualError(t, err, `invalid record operation "bad"`)
+}
+
+func TestMemory_UserConsent(t *testing.T) {
+	m, teardown := prepMemory(t)
+	defer teardown()
+
+	loc := store.Locator{SiteID: "radio-t"}
+	_, err := m.UserDetail(UserDetailRequest{Locator: loc, UserID: "user1", Detail: UserEmail, Update: "u1@example.com"})
+	require.NoError(t, err)
+	res, err := m.UserDetail(UserDetailRequest{Locator: loc, UserID: "user1", Detail: UserConsent, Update: "v1"})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, "u1@example.com", res[0].Email, "email kept")
+	assert.Equal(t, "v1", res[0].Consent)
+	require.NotNil(t, res[0].ConsentTime)
+	assert.WithinDuration(t, time.Now(), *res[0].ConsentTime, time.Minute)
+
+	res, err = m.UserDetail(UserDetailRequest{Locator: loc, UserID: "user1", Detail: UserConsent})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, "", res[0].Email)
+	assert.Equal(t, "v1", res[0].Consent)
+
+	require.NoError(t, m.Delete(DeleteRequest{Locator: loc, UserID: "user1", UserDetail: UserEmail}))
+	res, err = m.UserDetail(UserDetailRequest{Locator: loc, Detail: AllUserDetails})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res), "entry with consent kept")
+	assert.Equal(t, "v1", res[0].Consent)
+
+	ts := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	res, err = m.UserDetail(UserDetailRequest{Locator: loc, UserID: "user1", Detail: UserConsent, Update: "v2", Time: &ts})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, ts, *res[0].ConsentTime, "time set from request")
+
+	require.NoError(t, m.Delete(DeleteRequest{Locator: loc, UserID: "user1", UserDetail: UserConsent}))
+	res, err = m.UserDetail(UserDetailRequest{Locator: loc, Detail: AllUserDetails})
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(res), "empty entry removed")
+}
+
+func TestMemory_UserProfile(t *testing.T) {
+	m, teardown := prepMemory(t)
+	defer teardown()
+
+	loc := store.Locator{SiteID: "radio-t"}
+	_, err := m.UserDetail(UserDetailRequest{Locator: loc, UserID: "user1", Detail: UserEmail, Update: "u1@example.com"})
+	require.NoError(t, err)
+	profile := store.Profile{DisplayName: "John", Website: "https://example.com", Bio: "bio"}
+	res, err := m.UserDetail(UserDetailRequest{Locator: loc, UserID: "user1", Detail: UserProfile, Profile: &profile})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, "u1@example.com", res[0].Email, "email kept")
+	assert.Equal(t, &profile, res[0].Profile)
+
+	res, err = m.UserDetail(UserDetailRequest{Locator: loc, UserID: "user1", Detail: UserProfile})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, UserDetailEntry{UserID: "user1", Profile: &profile}, res[0])
+
+	require.NoError(t, m.Delete(DeleteRequest{Locator: loc, UserID: "user1", UserDetail: UserEmail}))
+	require.NoError(t, m.Delete(DeleteRequest{Locator: loc, UserID: "user1", UserDetail: UserProfile}))
+	res, err = m.UserDetail(UserDetailRequest{Locator: loc, Detail: AllUserDetails})
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(res), "empty entry removed")
+}
+
+func TestMemory_Reattribute(t *testing.T) {
+	m, teardown := prepMemory(t)
+	defer teardown()
+
+	loc := store.Locator{SiteID: "radio-t"}
+	ids, err := m.Reattribute(ReattributeRequest{Locator: loc, FromID: "user1",
+		To: store.User{ID: "user2", Name: "new name", Picture: "http://example.com/pic.png"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id-1", "id-2"}, ids)
+
+	res, err := m.Find(FindRequest{Locator: loc, UserID: "user2"})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(res))
+	assert.Equal(t, store.User{ID: "user2", Name: "new name", Picture: "http://example.com/pic.png"}, res[0].User)
+	_, err = m.Count(FindRequest{Locator: loc, UserID: "user1"})
+	assert.EqualError(t, err, "no comments for user user1 in store for radio-t site")
+
+	ids, err = m.Reattribute(ReattributeRequest{Locator: loc, FromID: "user2", To: store.User{ID: "anon"}, IDs: []string{"id-2"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id-2"}, ids, "only selected comment moved")
+	count, err := m.Count(FindRequest{Locator: loc, UserID: "user2"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	_, err = m.Reattribute(ReattributeRequest{Locator: loc, FromID: "user1", To: store.User{ID: "user2"}})
+	assert.EqualError(t, err, "no comments for user user1 in store")
+	_, err = m.Reattribute(ReattributeRequest{Locator: loc, FromID: "user2", To: store.User{ID: "user2"}})
+	assert.EqualError(t, err, `invalid reattribute request from "user2" to "user2"`)
+}
+
+func TestMemory_Remap(t *testing.T) {
+	m, teardown := prepMemory(t)
+	defer teardown()
+
+	loc := store.Locator{SiteID: "radio-t"}
+	_, err := m.Flag(FlagRequest{Flag: ReadOnly, Locator: store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, Update: FlagTrue})
+	require.NoError(t, err)
+	_, err = m.Record(RecordRequest{Op: RecordSet, Kind: Intervals, Locator: store.Locator{SiteID: "radio-t"},
+		Key: "https://radio-t.com", Value: json.RawMessage(`10`)})
+	require.NoError(t, err)
+	ids, err := m.Remap(RemapRequest{Locator: loc, URLs: map[string]string{"https://radio-t.com": "https://new.radio-t.com"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id-1", "id-2"}, ids)
+
+	newLoc := store.Locator{URL: "https://new.radio-t.com", SiteID: "radio-t"}
+	res, err := m.Find(FindRequest{Locator: newLoc, Sort: "time"})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(res))
+	assert.Equal(t, newLoc, res[0].Locator)
+	info, err := m.Info(InfoRequest{Locator: newLoc})
+	require.NoError(t, err)
+	assert.Equal(t, 2, info[0].Count)
+	assert.True(t, info[0].ReadOnly)
+	intervals, err := m.Record(RecordRequest{Op: RecordList, Kind: Intervals, Locator: store.Locator{SiteID: "radio-t"}})
+	require.NoError(t, err)
+	assert.Equal(t, []RecordEntry{{Key: "https://new.radio-t.com", Value: json.RawMessage(`10`)}}, intervals, "interval moved")
+	count, err := m.Count(FindRequest{Locator: store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}})
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	_, err = m.Remap(RemapRequest{Locator: loc, URLs: map[string]string{"a": "a"}})
+	assert.EqualError(t, err, `invalid remap from "a" to "a"`)
+}
+
+func TestMemory_Delete(t *testing.T) {
+	m, teardown := prepMemory(t)
+	defer teardown()
+
+	loc := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	require.NoError(t, m.Delete(DeleteRequest{Locator: loc, CommentID: "id-1", DeleteMode: store.SoftDelete}))
+	comment, err := m.Get(GetRequest{Locator: loc, CommentID: "id-1"})
+	require.NoError(t, err)
+	assert.True(t, comment.Deleted)
+	assert.Equal(t, "", comment.Text)
+	assert.Equal(t, "user1", comment.User.ID)
+	count, err := m.Count(FindRequest{Locator: loc})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	last, err := m.Find(FindRequest{Locator: store.Locator{SiteID: "radio-t"}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(last), "deleted comment not in last")
+
+	// delete again doesn't change count
+	require.NoError(t, m.Delete(DeleteRequest{Locator: loc, CommentID: "id-1", DeleteMode: store.SoftDelete}))
+	count, err = m.Count(FindRequest{Locator: loc})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	_, err = m.UserDetail(UserDetailRequest{Locator: loc, UserID: "user1", Detail: UserEmail, Update: "u1@example.com"})
+	require.NoError(t, err)
+	require.NoError(t, m.Delete(DeleteRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "user1", DeleteMode: store.HardDelete}))
+	comment, err = m.Get(GetRequest{Locator: loc, CommentID: "id-2"})
+	require.NoError(t, err)
+	assert.Equal(t, "deleted", comment.User.ID)
+	_, err = m.Find(FindRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "user1"})
+	assert.Error(t, err, "no comments for hard-deleted user")
+	details, err := m.UserDetail(UserDetailRequest{Locator: loc, UserID: "user1", Detail: UserEmail})
+	require.NoError(t, err)
+	assert.Nil(t, details)
+	assert.EqualError(t, m.Delete(DeleteRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "user-unknown"}),
+		"unknown user user-unknown")
+
+	_, err = m.Flag(FlagRequest{Locator: loc, Flag: ReadOnly, Update: FlagTrue})
+	require.NoError(t, err)
+	require.NoError(t, m.Delete(DeleteRequest{Locator: loc}))
+	_, err = m.Find(FindRequest{Locator: loc})
+	assert.Error(t, err, "post removed")
+	ro, err := m.Flag(FlagRequest{Locator: loc, Flag: ReadOnly})
+	require.NoError(t, err)
+	assert.True(t, ro, "read-only flag kept")
+	assert.Error(t, m.Delete(DeleteRequest{Locator: loc}), "no post")
+
+	_, err = m.Create(store.Comment{ID: "id-3", Timestamp: time.Now(), Locator: store.Locator{URL: "https://radio-t.com/2",
+		SiteID: "radio-t"}, User: store.User{ID: "user2"}})
+	require.NoError(t, err)
+	require.NoError(t, m.Delete(DeleteRequest{Locator: store.Locator{SiteID: "radio-t"}}))
+	list, err := m.Info(InfoRequest{Locator: store.Locator{SiteID: "radio-t"}})
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(list), "site cleaned")
+}
+
+func TestMemory_Snapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "memory")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	snapshot := filepath.Join(dir, "snapshot.json")
+
+	m, err := NewMemory(snapshot, "radio-t")
+	require.NoError(t, err)
+	loc := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	_, err = m.Create(store.Comment{ID: "id-1", Text: "text", Timestamp: time.Date(2017, 12, 20, 15, 18, 22, 0, time.UTC),
+		Locator: loc, User: store.User{ID: "user1", Name: "user name"}})
+	require.NoError(t, err)
+	_, err = m.Flag(FlagRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "user1", Flag: Verified, Update: FlagTrue})
+	require.NoError(t, err)
+	_, err = m.UserDetail(UserDetailRequest{Locator: loc, UserID: "user1", Detail: UserEmail, Update: "u1@example.com"})
+	require.NoError(t, err)
+	require.NoError(t, m.Close())
+
+	m, err = NewMemory(snapshot, "radio-t", "site2")
+	require.NoError(t, err)
+	comment, err := m.Get(GetRequest{Locator: loc, CommentID: "id-1"})
+	require.NoError(t, err)
+	assert.Equal(t, "text", comment.Text)
+	count, err := m.Count(FindRequest{Locator: loc})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	verified, err := m.Flag(FlagRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "user1", Flag: Verified})
+	require.NoError(t, err)
+	assert.True(t, verified)
+	details, err := m.UserDetail(UserDetailRequest{Locator: loc, UserID: "user1", Detail: UserEmail})
+	require.NoError(t, err)
+	assert.Equal(t, []UserDetailEntry{{UserID: "user1", Email: "u1@example.com"}}, details)
+	list, err := m.Info(InfoRequest{Locator: store.Locator{SiteID: "site2"}})
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(list), "new site added")
+	require.NoError(t, m.Close())
+
+	require.NoError(t, ioutil.WriteFile(snapshot, []byte("bad"), 0o600))
+	_, err = NewMemory(snapshot, "radio-t")
+	assert.Error(t, err)
+
+	m, err = NewMemory("", "radio-t")
+	require.NoError(t, err)
+	assert.NoError(t, m.Close(), "nothing saved without snapshot")
+}
+
+func TestMemory_Sites(t *testing.T) {
+	m, teardown := prepMemory(t)
+	defer teardown()
+
+	var _ SiteManager = m
+	loc := store.Locator{URL: "https://example.com", SiteID: "site2"}
+	_, err := m.Create(store.Comment{ID: "id-1", Locator: loc, User: store.User{ID: "user1"}})
+	assert.EqualError(t, err, `site "site2" not found`)
+
+	require.NoError(t, m.AddSite("site2"))
+	_, err = m.Create(store.Comment{ID: "id-1", Locator: loc, User: store.User{ID: "user1"}})
+	require.NoError(t, err)
+	require.NoError(t, m.AddSite("site2"), "existing site kept")
+	count, err := m.Count(FindRequest{Locator: loc})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	require.NoError(t, m.RemoveSite("site2"))
+	_, err = m.Get(GetRequest{Locator: loc, CommentID: "id-1"})
+	assert.EqualError(t, err, `site "site2" not found`)
+	assert.EqualError(t, m.RemoveSite("site2"), `site "site2" not found`)
+}
+
+func TestMemory_CommentsNotShared(t *testing.T) {
+	m, teardown := prepMemory(t)
+	defer teardown()
+
+	loc := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	comment, err := m.Get(GetRequest{Locator: loc, CommentID: "id-1"})
+	require.NoError(t, err)
+	comment.Votes = map[string]bool{"user2": true}
+	require.NoError(t, m.Update(comment))
+	comment.Votes["user3"] = true
+
+	comment, err = m.Get(GetRequest{Locator: loc, CommentID: "id-1"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"user2": true}, comment.Votes, "changes of caller not seen by store")
+}
+
+func prepMemory(t *testing.T) (m *Memory, teardown func()) {
+	m, err := NewMemory("", "radio-t")
+	require.NoError(t, err)
+
+	comment := store.Comment{
+		ID:        "id-1",
+		Text:      `some text, <a href="http://radio-t.com">link</a>`,
+		Timestamp: time.Date(2017, 12, 20, 15, 18, 22, 0, time.Local),
+		Locator:   store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"},
+		User:      store.User{ID: "user1", Name: "user name"},
+	}
+	_, err = m.Create(comment)
+	require.NoError(t, err)
+
+	comment = store.Comment{
+		ID:        "id-2",
+		Text:      "some text2",
+		Timestamp: time.Date(2017, 12, 20, 15, 18, 23, 0, time.Local),
+		Locator:   store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"},
+		User:      store.User{ID: "user1", Name: "user name"},
+	}
+	_, err = m.Create(comment)
+	require.NoError(t, err)
+
+	teardown = func() {
+		require.NoError(t, m.Close())
+	}
+	return m, teardown
+}
@@ -0,0 +1,71 @@
+package engine
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// Records gives access to records of a single kind kept by engine, values marshaled to JSON.
+// Used by services keeping their data along with comments of the site.
+type Records struct {
+	Engine Interface
+	Kind   RecordKind
+}
+
+// Get unmarshals value of the record to v, found false for missing record
+func (r Records) Get(siteID, key string, v interface{}) (found bool, err error) {
+	res, err := r.Engine.Record(RecordRequest{Op: RecordGet, Kind: r.Kind, Locator: store.Locator{SiteID: siteID}, Key: key})
+	if err != nil || len(res) == 0 {
+		return false, err
+	}
+	return true, errors.Wrapf(json.Unmarshal(res[0].Value, v), "can't unmarshal %s record %s", r.Kind, key)
+}
+
+// Set marshals v and saves it as value of the record, existing one replaced
+func (r Records) Set(siteID, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrapf(err, "can't marshal %s record %s", r.Kind, key)
+	}
+	_, err = r.Engine.Record(RecordRequest{Op: RecordSet, Kind: r.Kind, Locator: store.Locator{SiteID: siteID},
+		Key: key, Value: data})
+	return err
+}
+
+// Add marshals v and saves it as value of the record if the key not used yet, added false for used one
+func (r Records) Add(siteID, key string, v interface{}) (added bool, err error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return false, errors.Wrapf(err, "can't marshal %s record %s", r.Kind, key)
+	}
+	res, err := r.Engine.Record(RecordRequest{Op: RecordAdd, Kind: r.Kind, Locator: store.Locator{SiteID: siteID},
+		Key: key, Value: data})
+	return len(res) > 0, err
+}
+
+// Delete the record, found false for missing one
+func (r Records) Delete(siteID, key string) (found bool, err error) {
+	res, err := r.Engine.Record(RecordRequest{Op: RecordDelete, Kind: r.Kind, Locator: store.Locator{SiteID: siteID}, Key: key})
+	return len(res) > 0, err
+}
+
+// List records with key prefix, sorted by key. Calls fn with key and unmarshal func of each record.
+func (r Records) List(siteID, prefix string, fn func(key string, unmarshal func(v interface{}) error) error) error {
+	res, err := r.Engine.Record(RecordRequest{Op: RecordList, Kind: r.Kind, Locator: store.Locator{SiteID: siteID}, Key: prefix})
+	if err != nil {
+		return err
+	}
+	for _, entry := range res {
+		value, key := entry.Value, entry.Key
+		unmarshal := func(v interface{}) error {
+			return errors.Wrapf(json.Unmarshal(value, v), "can't unmarshal %s record %s", r.Kind, key)
+		}
+		if err = fn(key, unmarshal); err != nil {
+			return err
+		}
+	}
+	return nil
+}
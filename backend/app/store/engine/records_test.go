@@ -0,0 +1,57 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecords(t *testing.T) {
+	m, err := NewMemory("", "site1")
+	require.NoError(t, err)
+	type value struct {
+		Name string `json:"name"`
+	}
+	r := Records{Engine: m, Kind: Drafts}
+
+	v := value{}
+	found, err := r.Get("site1", "k1", &v)
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	require.NoError(t, r.Set("site1", "k1", value{Name: "n1"}))
+	added, err := r.Add("site1", "k1", value{Name: "other"})
+	require.NoError(t, err)
+	assert.False(t, added, "used key")
+	added, err = r.Add("site1", "k2", value{Name: "n2"})
+	require.NoError(t, err)
+	assert.True(t, added)
+
+	found, err = r.Get("site1", "k1", &v)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, value{Name: "n1"}, v)
+
+	var list []value
+	err = r.List("site1", "", func(key string, unmarshal func(v interface{}) error) error {
+		v := value{}
+		if err := unmarshal(&v); err != nil {
+			return err
+		}
+		list = append(list, v)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []value{{Name: "n1"}, {Name: "n2"}}, list)
+
+	found, err = r.Delete("site1", "k1")
+	require.NoError(t, err)
+	assert.True(t, found)
+	found, err = r.Delete("site1", "k1")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	_, err = r.Get("bad", "k1", &v)
+	assert.EqualError(t, err, `site "bad" not found`)
+}
@@ -82,6 +82,26 @@ func (r *RPC) ListFlags(req FlagRequest) (list []interface{}, err error) {
 	return list, err
 }
 
+// Reattribute moves all comments of one user to another
+func (r *RPC) Reattribute(req ReattributeRequest) (ids []string, err error) {
+	resp, err := r.Call("store.reattribute", req)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(*resp.Result, &ids)
+	return ids, err
+}
+
+// Remap moves all comments of posts to new urls
+func (r *RPC) Remap(req RemapRequest) (ids []string, err error) {
+	resp, err := r.Call("store.remap", req)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(*resp.Result, &ids)
+	return ids, err
+}
+
 // UserDetail sets or gets single detail value, or gets all details for requested site.
 // UserDetail returns list even for single entry request is a compromise in order to have both single detail getting and setting
 // and all site's details listing under the same function (and not to extend interface by two separate functions).
@@ -110,6 +130,16 @@ func (r *RPC) Delete(req DeleteRequest) error {
 	return err
 }
 
+// Record sets, gets, lists or deletes records of the site
+func (r *RPC) Record(req RecordRequest) (result []RecordEntry, err error) {
+	resp, err := r.Call("store.record", req)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(*resp.Result, &result)
+	return result, err
+}
+
 // Close storage engine
 func (r *RPC) Close() error {
 	_, err := r.Call("store.close")
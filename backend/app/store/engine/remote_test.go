@@ -138,6 +138,27 @@ func TestRemote_ListFlag(t *testing.T) {
 	assert.Equal(t, []interface{}{map[string]interface{}{"ID": "id1"}, map[string]interface{}{"ID": "id2"}}, res)
 }
 
+func TestRemote_Reattribute(t *testing.T) {
+	ts := testServer(t, `{"method":"store.reattribute","params":{"locator":{"site":"site_id","url":""},"from_id":"u1","to":{"name":"user2","id":"u2","picture":"","admin":false}},"id":1}`, `{"result":["id1","id2"]}`)
+	defer ts.Close()
+	c := RPC{Client: jrpc.Client{API: ts.URL, Client: http.Client{}}}
+
+	res, err := c.Reattribute(ReattributeRequest{Locator: store.Locator{SiteID: "site_id"}, FromID: "u1",
+		To: store.User{ID: "u2", Name: "user2"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"id1", "id2"}, res)
+}
+
+func TestRemote_Remap(t *testing.T) {
+	ts := testServer(t, `{"method":"store.remap","params":{"locator":{"site":"site_id","url":""},"urls":{"http://a.com":"http://b.com"}},"id":1}`, `{"result":["id1","id2"]}`)
+	defer ts.Close()
+	c := RPC{Client: jrpc.Client{API: ts.URL, Client: http.Client{}}}
+
+	res, err := c.Remap(RemapRequest{Locator: store.Locator{SiteID: "site_id"}, URLs: map[string]string{"http://a.com": "http://b.com"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"id1", "id2"}, res)
+}
+
 func TestRemote_UserDetail(t *testing.T) {
 	ts := testServer(t, `{"method":"store.user_detail","params":{"detail":"email","locator":{"url":"http://example.com/url"},"user_id":"username"},"id":1}`, `{"result":[{"user_id":"u1","email":"test_email@example.com"}]}`)
 	defer ts.Close()
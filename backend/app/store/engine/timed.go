@@ -0,0 +1,161 @@
+package engine
+
+import (
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// ObserveFunc receives duration of engine call by name of the method, with locator of the request
+type ObserveFunc func(op string, locator store.Locator, d time.Duration)
+
+// Timed wraps engine and reports duration of each call of Interface methods to observe func, i.e. to log slow calls.
+// Management of sites passed to the wrapped engine without timing.
+type Timed struct {
+	Interface
+	observe ObserveFunc
+}
+
+// NewTimed makes Timed on top of eng
+func NewTimed(eng Interface, observe ObserveFunc) *Timed {
+	return &Timed{Interface: eng, observe: observe}
+}
+
+// Create comment, timed
+func (t *Timed) Create(comment store.Comment) (commentID string, err error) {
+	defer t.since("Create", comment.Locator, time.Now())
+	return t.Interface.Create(comment)
+}
+
+// Update comment, timed
+func (t *Timed) Update(comment store.Comment) error {
+	defer t.since("Update", comment.Locator, time.Now())
+	return t.Interface.Update(comment)
+}
+
+// Get comment, timed
+func (t *Timed) Get(req GetRequest) (store.Comment, error) {
+	defer t.since("Get", req.Locator, time.Now())
+	return t.Interface.Get(req)
+}
+
+// Find comments, timed
+func (t *Timed) Find(req FindRequest) ([]store.Comment, error) {
+	defer t.since("Find", req.Locator, time.Now())
+	return t.Interface.Find(req)
+}
+
+// Info of posts, timed
+func (t *Timed) Info(req InfoRequest) ([]store.PostInfo, error) {
+	defer t.since("Info", req.Locator, time.Now())
+	return t.Interface.Info(req)
+}
+
+// Count comments, timed
+func (t *Timed) Count(req FindRequest) (int, error) {
+	defer t.since("Count", req.Locator, time.Now())
+	return t.Interface.Count(req)
+}
+
+// Delete post, user, comment or user details, timed
+func (t *Timed) Delete(req DeleteRequest) error {
+	defer t.since("Delete", req.Locator, time.Now())
+	return t.Interface.Delete(req)
+}
+
+// Flag sets or gets flag, timed
+func (t *Timed) Flag(req FlagRequest) (bool, error) {
+	defer t.since("Flag", req.Locator, time.Now())
+	return t.Interface.Flag(req)
+}
+
+// ListFlags of the site, timed
+func (t *Timed) ListFlags(req FlagRequest) ([]interface{}, error) {
+	defer t.since("ListFlags", req.Locator, time.Now())
+	return t.Interface.ListFlags(req)
+}
+
+// Reattribute comments of the user, timed
+func (t *Timed) Reattribute(req ReattributeRequest) ([]string, error) {
+	defer t.since("Reattribute", req.Locator, time.Now())
+	return t.Interface.Reattribute(req)
+}
+
+// Remap comments of posts to new urls, timed
+func (t *Timed) Remap(req RemapRequest) ([]string, error) {
+	defer t.since("Remap", req.Locator, time.Now())
+	return t.Interface.Remap(req)
+}
+
+// UserDetail sets or gets details of users, timed
+func (t *Timed) UserDetail(req UserDetailRequest) ([]UserDetailEntry, error) {
+	defer t.since("UserDetail", req.Locator, time.Now())
+	return t.Interface.UserDetail(req)
+}
+
+// Record sets, gets, lists or deletes records of the site, timed
+func (t *Timed) Record(req RecordRequest) ([]RecordEntry, error) {
+	defer t.since("Record", req.Locator, time.Now())
+	return t.Interface.Record(req)
+}
+
+// Integrity passes the check to the wrapped engine
+func (t *Timed) Integrity(req IntegrityRequest) (IntegrityReport, error) {
+	checker, ok := t.Interface.(IntegrityChecker)
+	if !ok {
+		return IntegrityReport{}, errors.New("integrity check not supported by engine")
+	}
+	return checker.Integrity(req)
+}
+
+// AddSite makes storage of the site with underlying engine, if it supports sites added at runtime
+func (t *Timed) AddSite(siteID string) error {
+	sm, ok := t.Interface.(SiteManager)
+	if !ok {
+		return errors.New("engine doesn't support sites added at runtime")
+	}
+	return sm.AddSite(siteID)
+}
+
+// RemoveSite removes storage of the site with underlying engine, if it supports sites removed at runtime
+func (t *Timed) RemoveSite(siteID string) error {
+	sm, ok := t.Interface.(SiteManager)
+	if !ok {
+		return errors.New("engine doesn't support sites removed at runtime")
+	}
+	return sm.RemoveSite(siteID)
+}
+
+// DetachSite stops serving the site with underlying engine, if it keeps sites isolated
+func (t *Timed) DetachSite(siteID string) (string, error) {
+	sd, ok := t.Interface.(SiteDetacher)
+	if !ok {
+		return "", errors.New("engine doesn't support detaching of sites")
+	}
+	return sd.DetachSite(siteID)
+}
+
+// BackupSite copies storage of the site with underlying engine
+func (t *Timed) BackupSite(siteID string, w io.Writer) error {
+	sb, ok := t.Interface.(SiteBackuper)
+	if !ok {
+		return errors.New("engine doesn't support backup of sites")
+	}
+	return sb.BackupSite(siteID, w)
+}
+
+// RestoreSite replaces storage of the site with underlying engine
+func (t *Timed) RestoreSite(siteID string, r io.Reader) error {
+	sb, ok := t.Interface.(SiteBackuper)
+	if !ok {
+		return errors.New("engine doesn't support restore of sites")
+	}
+	return sb.RestoreSite(siteID, r)
+}
+
+func (t *Timed) since(op string, locator store.Locator, st time.Time) {
+	t.observe(op, locator, time.Since(st))
+}
@@ -0,0 +1,41 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestTimed(t *testing.T) {
+	b, teardown := prep(t)
+	defer teardown()
+
+	type call struct {
+		op      string
+		locator store.Locator
+	}
+	var calls []call
+	timed := NewTimed(b, func(op string, locator store.Locator, d time.Duration) {
+		assert.True(t, d >= 0)
+		calls = append(calls, call{op: op, locator: locator})
+	})
+
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	comments, err := timed.Find(FindRequest{Locator: locator, Sort: "time"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(comments))
+	count, err := timed.Count(FindRequest{Locator: locator})
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	_, err = timed.Info(InfoRequest{Locator: store.Locator{SiteID: "radio-t"}})
+	require.NoError(t, err)
+	assert.Equal(t, []call{{"Find", locator}, {"Count", locator}, {"Info", store.Locator{SiteID: "radio-t"}}}, calls)
+
+	_, err = timed.Integrity(IntegrityRequest{SiteID: "radio-t"})
+	assert.NoError(t, err, "passed to bolt")
+	assert.Equal(t, 3, len(calls), "not timed")
+}
@@ -0,0 +1,123 @@
+// Package events implements in-memory bus of comment events. Comment service publishes created, edited and
+// deleted comments, subscribers get events of a single post, i.e. to stream live updates to clients.
+package events
+
+import (
+	"sync"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// Kind of event
+type Kind string
+
+// Kind enum
+const (
+	Created Kind = "create"
+	Updated Kind = "update"
+	Deleted Kind = "delete"
+)
+
+// Event of the comment, comment is prepared for public view
+type Event struct {
+	Kind    Kind          `json:"kind"`
+	Comment store.Comment `json:"comment"`
+}
+
+// ErrTooManySubscribers returned by Subscribe if the bus reached max number of subscribers
+var ErrTooManySubscribers = errors.New("too many subscribers")
+
+// Bus delivers events to subscribers of the post. Publish never blocks, events dropped for subscribers
+// not reading them fast enough. Thread safe.
+type Bus struct {
+	maxSubscribers int
+	bufferSize     int
+
+	lock   sync.Mutex
+	subs   map[store.Locator]map[chan Event]struct{}
+	count  int
+	closed bool
+}
+
+const defaultBufferSize = 16
+
+// NewBus makes bus with limited number of subscribers, 0 means unlimited
+func NewBus(maxSubscribers int) *Bus {
+	return &Bus{maxSubscribers: maxSubscribers, bufferSize: defaultBufferSize,
+		subs: map[store.Locator]map[chan Event]struct{}{}}
+}
+
+// Subscribe to events of the post. Returned channel closed by unsubscribe func or on closing of the bus.
+func (b *Bus) Subscribe(locator store.Locator) (events <-chan Event, unsubscribe func(), err error) {
+	key := store.Locator{SiteID: locator.SiteID, URL: locator.URL}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.closed {
+		return nil, nil, errors.New("bus closed")
+	}
+	if b.maxSubscribers > 0 && b.count >= b.maxSubscribers {
+		return nil, nil, ErrTooManySubscribers
+	}
+	ch := make(chan Event, b.bufferSize)
+	if b.subs[key] == nil {
+		b.subs[key] = map[chan Event]struct{}{}
+	}
+	b.subs[key][ch] = struct{}{}
+	b.count++
+
+	unsubscribe = func() {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		if _, ok := b.subs[key][ch]; !ok {
+			return // already unsubscribed or closed
+		}
+		delete(b.subs[key], ch)
+		if len(b.subs[key]) == 0 {
+			delete(b.subs, key)
+		}
+		b.count--
+		close(ch)
+	}
+	return ch, unsubscribe, nil
+}
+
+// Publish event to subscribers of comment's post
+func (b *Bus) Publish(e Event) {
+	key := store.Locator{SiteID: e.Comment.Locator.SiteID, URL: e.Comment.Locator.URL}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for ch := range b.subs[key] {
+		select {
+		case ch <- e:
+		default:
+			log.Printf("[DEBUG] subscriber of %s is slow, %s event of comment %s dropped", key.URL, e.Kind, e.Comment.ID)
+		}
+	}
+}
+
+// Subscribers returns number of active subscribers
+func (b *Bus) Subscribers() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.count
+}
+
+// Close the bus and channels of all subscribers, safe to call on nil Bus
+func (b *Bus) Close() {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for key, subs := range b.subs {
+		for ch := range subs {
+			close(ch)
+		}
+		delete(b.subs, key)
+	}
+	b.count = 0
+	b.closed = true
+}
@@ -0,0 +1,68 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestBus_PublishSubscribe(t *testing.T) {
+	bus := NewBus(0)
+	post1 := store.Locator{SiteID: "remark", URL: "https://example.com/1"}
+	post2 := store.Locator{SiteID: "remark", URL: "https://example.com/2"}
+
+	ch1, unsub1, err := bus.Subscribe(post1)
+	require.NoError(t, err)
+	ch2, unsub2, err := bus.Subscribe(post2)
+	require.NoError(t, err)
+	assert.Equal(t, 2, bus.Subscribers())
+
+	bus.Publish(Event{Kind: Created, Comment: store.Comment{ID: "c1", Locator: post1}})
+	bus.Publish(Event{Kind: Deleted, Comment: store.Comment{ID: "c2", Locator: post2}})
+	bus.Publish(Event{Kind: Updated, Comment: store.Comment{ID: "c3", Locator: store.Locator{SiteID: "other", URL: post1.URL}}})
+
+	assert.Equal(t, Event{Kind: Created, Comment: store.Comment{ID: "c1", Locator: post1}}, <-ch1)
+	assert.Equal(t, Event{Kind: Deleted, Comment: store.Comment{ID: "c2", Locator: post2}}, <-ch2)
+	assert.Empty(t, ch1, "event of other site not delivered")
+
+	unsub1()
+	unsub1() // second call ignored
+	_, ok := <-ch1
+	assert.False(t, ok, "channel closed")
+	assert.Equal(t, 1, bus.Subscribers())
+	bus.Publish(Event{Kind: Created, Comment: store.Comment{ID: "c4", Locator: post1}})
+
+	bus.Close()
+	_, ok = <-ch2
+	assert.False(t, ok, "channel closed with the bus")
+	unsub2() // safe after closing
+	assert.Equal(t, 0, bus.Subscribers())
+	_, _, err = bus.Subscribe(post1)
+	assert.Error(t, err)
+
+	var nilBus *Bus
+	nilBus.Close()
+}
+
+func TestBus_Limits(t *testing.T) {
+	bus := NewBus(1)
+	defer bus.Close()
+	post := store.Locator{SiteID: "remark", URL: "https://example.com/1"}
+	ch, unsub, err := bus.Subscribe(post)
+	require.NoError(t, err)
+	_, _, err = bus.Subscribe(post)
+	assert.Equal(t, ErrTooManySubscribers, err)
+
+	for i := 0; i < defaultBufferSize+5; i++ { // never blocks
+		bus.Publish(Event{Kind: Updated, Comment: store.Comment{ID: "c1", Locator: post}})
+	}
+	assert.Equal(t, defaultBufferSize, len(ch), "events over buffer size dropped")
+
+	unsub()
+	_, unsub, err = bus.Subscribe(post)
+	require.NoError(t, err, "allowed after unsubscribe")
+	unsub()
+}
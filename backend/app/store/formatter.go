@@ -1,7 +1,10 @@
 package store
 
 import (
+	"fmt"
+	"html/template"
 	"net/url"
+	"regexp"
 	"strings"
 
 	"github.com/Depado/bfchroma"
@@ -12,6 +15,8 @@ import (
 
 // CommentFormatter implements all generic formatting ops on comment
 type CommentFormatter struct {
+	Math func(siteID string) bool // optional, checks if math passed through untouched on the site
+
 	converters []CommentConverter
 }
 
@@ -36,10 +41,24 @@ func NewCommentFormatter(converters ...CommentConverter) *CommentFormatter {
 
 // Format comment fields
 func (f *CommentFormatter) Format(c Comment) Comment {
-	c.Text = f.FormatText(c.Text)
+	c.Text = f.FormatSiteText(c.Locator.SiteID, c.Text)
 	return c
 }
 
+// FormatSiteText formats text of the site's comment, the same as FormatText but keeps math of sites with math enabled.
+// Math in $...$ and $$...$$ kept as is, wrapped with span of math-inline or math-display class for rendering by client.
+func (f *CommentFormatter) FormatSiteText(siteID, txt string) (res string) {
+	if f.Math == nil || !f.Math(siteID) {
+		return f.FormatText(txt)
+	}
+	txt, math := extractMath(txt)
+	res = f.FormatText(txt)
+	for i, m := range math {
+		res = strings.Replace(res, mathPlaceholder(i), m, 1)
+	}
+	return res
+}
+
 // FormatText converts text with markdown processor, applies external converters and shortens links
 func (f *CommentFormatter) FormatText(txt string) (res string) {
 	mdExt := bf.NoIntraEmphasis | bf.Tables | bf.FencedCode |
@@ -63,6 +82,31 @@ func (f *CommentFormatter) FormatText(txt string) (res string) {
 	return res
 }
 
+// reMath matches code spans and blocks to skip them, display math and inline math. Inline math can't start or end
+// with space to leave alone texts with prices, like "$5 and $10"
+var reMath = regexp.MustCompile("(?s)(```.*?```|`[^`\\n]*`)|\\$\\$(.+?)\\$\\$|\\$([^\\s$](?:[^$\\n]*[^\\s$])?)\\$")
+
+// extractMath replaces math of the text with placeholders, returns the text and html of replaced math
+func extractMath(txt string) (res string, math []string) {
+	res = reMath.ReplaceAllStringFunc(txt, func(m string) string {
+		sm := reMath.FindStringSubmatch(m)
+		switch {
+		case sm[1] != "": // code kept as is
+			return m
+		case sm[2] != "":
+			math = append(math, `<span class="math-display">`+template.HTMLEscapeString(m)+`</span>`)
+		default:
+			math = append(math, `<span class="math-inline">`+template.HTMLEscapeString(m)+`</span>`)
+		}
+		return mathPlaceholder(len(math) - 1)
+	})
+	return res, math
+}
+
+func mathPlaceholder(i int) string {
+	return fmt.Sprintf("REMARKMATH%dX", i)
+}
+
 // Shortens all the automatic links in HTML: auto link has equal "href" and "text" attributes.
 func (f *CommentFormatter) shortenAutoLinks(commentHTML string, max int) (resHTML string) {
 	doc, err := goquery.NewDocumentFromReader(strings.NewReader(commentHTML))
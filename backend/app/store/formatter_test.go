@@ -77,6 +77,33 @@ func TestFormatter_FormatComment(t *testing.T) {
 	assert.Equal(t, exp, f.Format(comment))
 }
 
+func TestFormatter_FormatSiteText(t *testing.T) {
+	tbl := []struct {
+		in, out string
+		name    string
+	}{
+		{"area is $\\pi r^2$, *ok*", `<p>area is <span class="math-inline">$\pi r^2$</span>, <em>ok</em></p>` + "\n", "inline"},
+		{"sum\n\n$$\n\\sum_{i=1}^n x_i < 1\n$$", "<p>sum</p>\n\n<p>" + `<span class="math-display">$$
+\sum_{i=1}^n x_i &lt; 1
+$$</span></p>` + "\n", "display"},
+		{"$5 and $10", "<p>$5 and $10</p>\n", "prices"},
+		{"`$x_1$` and $x_2$", `<p><code>$x_1$</code> and <span class="math-inline">$x_2$</span></p>` + "\n", "code"},
+		{"$<script>alert(1)</script>$", `<p><span class="math-inline">$&lt;script&gt;alert(1)&lt;/script&gt;$</span></p>` + "\n", "escaped"},
+	}
+	f := NewCommentFormatter()
+	f.Math = func(siteID string) bool { return siteID == "site1" }
+	for _, tt := range tbl {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.out, f.FormatSiteText("site1", tt.in))
+			c := Comment{Text: f.FormatSiteText("site1", tt.in)}
+			c.Sanitize()
+			assert.Equal(t, tt.out, c.Text, "kept by sanitizer")
+		})
+	}
+	assert.Equal(t, "<p>$a <em>x</em>$</p>\n", f.FormatSiteText("site2", "$a _x_$"), "math disabled")
+}
+
 func TestFormatter_ShortenAutoLinks(t *testing.T) {
 	f := NewCommentFormatter(nil)
 	tbl := []struct {
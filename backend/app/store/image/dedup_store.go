@@ -0,0 +1,285 @@
+package image
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	dedupRefsBktName  = "refs"
+	dedupBlobsBktName = "blobs"
+	dedupBlobPrefix   = "blobs/" // prefix of blob id in underlying store
+)
+
+// Dedup is an image Store keeping images by content hash on top of another store. Identical images saved
+// with different ids stored once, as a single blob. Index of image ids and blobs with reference counts kept
+// in bolt DB. Images saved before deduplication enabled loaded and committed by their own ids.
+//
+// Blob committed with the first committed image referencing it. Staging images not committed in time
+// removed by Cleanup, and blob dropped from the index once no image references it, so the underlying
+// store cleans up its staging copy.
+type Dedup struct {
+	store Store
+	db    *bolt.DB
+}
+
+// dedupRef is an image id pointing to the blob
+type dedupRef struct {
+	Hash      string    `json:"hash"`
+	Committed bool      `json:"committed"`
+	Timestamp time.Time `json:"ts"` // time of save or reset of cleanup timer, used for cleanup of staging images
+}
+
+// dedupBlob is a stored image content with number of image ids referencing it
+type dedupBlob struct {
+	Refs      int  `json:"refs"`
+	Committed bool `json:"committed"`
+}
+
+// NewDedup makes deduplicating store on top of the store, with index in bolt DB file
+func NewDedup(store Store, fileName string, options bolt.Options) (*Dedup, error) {
+	db, err := bolt.Open(fileName, 0600, &options) //nolint:gocritic //octalLiteral is OK as FileMode
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to make boltdb for %s", fileName)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bkt := range []string{dedupRefsBktName, dedupBlobsBktName} {
+			if _, e := tx.CreateBucketIfNotExists([]byte(bkt)); e != nil {
+				return errors.Wrapf(e, "failed to create top level bucket %s", bkt)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to initialize boltdb db %q buckets", fileName)
+	}
+	return &Dedup{store: store, db: db}, nil
+}
+
+// Save image with given id, content saved to underlying store only if there is no blob with the same hash
+func (d *Dedup) Save(id string, img []byte) error {
+	sum := sha256.Sum256(img)
+	hash := hex.EncodeToString(sum[:])
+	return d.db.Update(func(tx *bolt.Tx) error {
+		refs, blobs := tx.Bucket([]byte(dedupRefsBktName)), tx.Bucket([]byte(dedupBlobsBktName))
+		if old, ok := d.getRef(refs, id); ok { // image re-saved, release the old blob
+			if old.Hash == hash {
+				return d.resetTimer(refs, id, old)
+			}
+			if err := d.release(blobs, old.Hash); err != nil {
+				return err
+			}
+		}
+
+		blob, exists := d.getBlob(blobs, hash)
+		switch {
+		case !exists:
+			if err := d.store.Save(blobID(hash), img); err != nil {
+				return errors.Wrapf(err, "can't save blob of %s", id)
+			}
+		case !blob.Committed:
+			if err := d.store.ResetCleanupTimer(blobID(hash)); err != nil {
+				return errors.Wrapf(err, "can't reset cleanup timer of blob %s", hash)
+			}
+			log.Printf("[DEBUG] image %s deduplicated with staging blob %s", id, hash)
+		default:
+			log.Printf("[DEBUG] image %s deduplicated with blob %s", id, hash)
+		}
+
+		blob.Refs++
+		if err := putJSON(blobs, hash, blob); err != nil {
+			return err
+		}
+		return putJSON(refs, id, dedupRef{Hash: hash, Timestamp: time.Now()})
+	})
+}
+
+// Load image by id
+func (d *Dedup) Load(id string) ([]byte, error) {
+	ref, ok := d.ref(id)
+	if !ok {
+		return d.store.Load(id)
+	}
+	return d.store.Load(blobID(ref.Hash))
+}
+
+// Commit image, commits the blob if it is not committed yet
+func (d *Dedup) Commit(id string) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		refs, blobs := tx.Bucket([]byte(dedupRefsBktName)), tx.Bucket([]byte(dedupBlobsBktName))
+		ref, ok := d.getRef(refs, id)
+		if !ok {
+			return d.store.Commit(id)
+		}
+		if ref.Committed {
+			return nil
+		}
+		blob, exists := d.getBlob(blobs, ref.Hash)
+		if !exists {
+			return errors.Errorf("blob %s of image %s not found", ref.Hash, id)
+		}
+		if !blob.Committed {
+			if err := d.store.Commit(blobID(ref.Hash)); err != nil {
+				return errors.Wrapf(err, "can't commit blob of %s", id)
+			}
+			blob.Committed = true
+			if err := putJSON(blobs, ref.Hash, blob); err != nil {
+				return err
+			}
+		}
+		ref.Committed = true
+		return putJSON(refs, id, ref)
+	})
+}
+
+// ResetCleanupTimer resets cleanup timer for the image and its blob
+func (d *Dedup) ResetCleanupTimer(id string) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		refs := tx.Bucket([]byte(dedupRefsBktName))
+		ref, ok := d.getRef(refs, id)
+		if !ok {
+			return d.store.ResetCleanupTimer(id)
+		}
+		return d.resetTimer(refs, id, ref)
+	})
+}
+
+// Cleanup removes staging images older than ttl, releasing their blobs, and runs cleanup of underlying store.
+// Blobs left without references dropped from the index, their staging copies removed by underlying store.
+func (d *Dedup) Cleanup(ctx context.Context, ttl time.Duration) error {
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		refs, blobs := tx.Bucket([]byte(dedupRefsBktName)), tx.Bucket([]byte(dedupBlobsBktName))
+		var expired []string
+		err := refs.ForEach(func(k, v []byte) error {
+			ref := dedupRef{}
+			if err := json.Unmarshal(v, &ref); err != nil {
+				return errors.Wrapf(err, "can't unmarshal image %s", string(k))
+			}
+			if !ref.Committed && time.Since(ref.Timestamp) > ttl {
+				expired = append(expired, string(k))
+				if err := d.release(blobs, ref.Hash); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, id := range expired {
+			log.Printf("[INFO] remove staging image %s", id)
+			if err = refs.Delete([]byte(id)); err != nil {
+				return errors.Wrapf(err, "failed to remove image %s", id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to cleanup images index")
+	}
+	return d.store.Cleanup(ctx, ttl)
+}
+
+// Info returns meta information about underlying storage
+func (d *Dedup) Info() (StoreInfo, error) {
+	return d.store.Info()
+}
+
+// PresignedURL returns temporary direct url of the image blob if underlying store supports it
+func (d *Dedup) PresignedURL(id string) (string, error) {
+	p, ok := d.store.(Presigner)
+	if !ok {
+		return "", nil
+	}
+	if ref, found := d.ref(id); found {
+		return p.PresignedURL(blobID(ref.Hash))
+	}
+	return p.PresignedURL(id)
+}
+
+// Close index db
+func (d *Dedup) Close() error {
+	return d.db.Close()
+}
+
+// ref returns the image reference, false if the image saved before deduplication enabled or not found
+func (d *Dedup) ref(id string) (ref dedupRef, ok bool) {
+	_ = d.db.View(func(tx *bolt.Tx) error {
+		ref, ok = d.getRef(tx.Bucket([]byte(dedupRefsBktName)), id)
+		return nil
+	})
+	return ref, ok
+}
+
+func (d *Dedup) getRef(bkt *bolt.Bucket, id string) (ref dedupRef, ok bool) {
+	v := bkt.Get([]byte(id))
+	if v == nil {
+		return ref, false
+	}
+	if err := json.Unmarshal(v, &ref); err != nil {
+		log.Printf("[WARN] can't unmarshal image %s, %v", id, err)
+		return ref, false
+	}
+	return ref, true
+}
+
+func (d *Dedup) getBlob(bkt *bolt.Bucket, hash string) (blob dedupBlob, ok bool) {
+	v := bkt.Get([]byte(hash))
+	if v == nil {
+		return blob, false
+	}
+	if err := json.Unmarshal(v, &blob); err != nil {
+		log.Printf("[WARN] can't unmarshal blob %s, %v", hash, err)
+		return blob, false
+	}
+	return blob, true
+}
+
+// resetTimer updates timestamp of staging image and resets cleanup timer of its staging blob
+func (d *Dedup) resetTimer(refs *bolt.Bucket, id string, ref dedupRef) error {
+	if ref.Committed {
+		return nil
+	}
+	if err := d.store.ResetCleanupTimer(blobID(ref.Hash)); err != nil {
+		return errors.Wrapf(err, "can't reset cleanup timer of %s", id)
+	}
+	ref.Timestamp = time.Now()
+	return putJSON(refs, id, ref)
+}
+
+// release decrements references of the blob. Staging blob without references dropped from the index,
+// committed one kept as underlying store doesn't support removal of committed images.
+func (d *Dedup) release(blobs *bolt.Bucket, hash string) error {
+	blob, ok := d.getBlob(blobs, hash)
+	if !ok {
+		return nil
+	}
+	blob.Refs--
+	if blob.Refs <= 0 && !blob.Committed {
+		log.Printf("[DEBUG] blob %s unreferenced", hash)
+		return errors.Wrapf(blobs.Delete([]byte(hash)), "failed to remove blob %s", hash)
+	}
+	if blob.Refs < 0 {
+		blob.Refs = 0
+	}
+	return putJSON(blobs, hash, blob)
+}
+
+func blobID(hash string) string {
+	return dedupBlobPrefix + hash
+}
+
+func putJSON(bkt *bolt.Bucket, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrapf(err, "can't marshal %s", key)
+	}
+	return errors.Wrapf(bkt.Put([]byte(key), data), "can't put %s", key)
+}
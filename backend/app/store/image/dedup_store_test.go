@@ -0,0 +1,151 @@
+package image
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestDedup_SaveLoadCommit(t *testing.T) {
+	svc, fs, teardown := prepareDedupTest(t)
+	defer teardown()
+
+	require.NoError(t, svc.Save("user1/img1.png", gopherPNGBytes()))
+	require.NoError(t, svc.Save("user2/img2.png", gopherPNGBytes()))
+	assert.Equal(t, 1, countFiles(t, fs.Staging), "identical images stored once")
+
+	for _, id := range []string{"user1/img1.png", "user2/img2.png"} {
+		img, err := svc.Load(id)
+		require.NoError(t, err)
+		assert.Equal(t, gopherPNGBytes(), img)
+	}
+
+	require.NoError(t, svc.Commit("user1/img1.png"))
+	assert.Equal(t, 0, countFiles(t, fs.Staging))
+	assert.Equal(t, 1, countFiles(t, fs.Location))
+	require.NoError(t, svc.Commit("user2/img2.png"), "blob already committed")
+	require.NoError(t, svc.Commit("user2/img2.png"), "image already committed")
+	img, err := svc.Load("user2/img2.png")
+	require.NoError(t, err)
+	assert.Equal(t, gopherPNGBytes(), img)
+
+	require.NoError(t, svc.Save("user3/img3.png", gopherPNGBytes()))
+	assert.Equal(t, 0, countFiles(t, fs.Staging), "committed blob reused")
+	require.NoError(t, svc.Commit("user3/img3.png"))
+	assert.Equal(t, 1, countFiles(t, fs.Location))
+
+	_, err = svc.Load("user1/no-such-img.png")
+	assert.Error(t, err)
+}
+
+func TestDedup_Legacy(t *testing.T) {
+	svc, fs, teardown := prepareDedupTest(t)
+	defer teardown()
+
+	require.NoError(t, fs.Save("user1/legacy.png", gopherPNGBytes()), "saved before dedup enabled")
+	img, err := svc.Load("user1/legacy.png")
+	require.NoError(t, err)
+	assert.Equal(t, gopherPNGBytes(), img)
+	require.NoError(t, svc.ResetCleanupTimer("user1/legacy.png"))
+	require.NoError(t, svc.Commit("user1/legacy.png"))
+	_, err = os.Stat(fs.location(fs.Location, "user1/legacy.png"))
+	assert.NoError(t, err)
+
+	assert.Error(t, svc.Commit("user1/no-such-img.png"))
+	url, err := svc.PresignedURL("user1/legacy.png")
+	require.NoError(t, err)
+	assert.Empty(t, url, "fs store can't presign")
+}
+
+func TestDedup_Resave(t *testing.T) {
+	svc, fs, teardown := prepareDedupTest(t)
+	defer teardown()
+
+	require.NoError(t, svc.Save("user1/img1.png", gopherPNGBytes()))
+	require.NoError(t, svc.Save("user1/img1.png", gopherPNGBytes()), "the same content")
+	assert.Equal(t, 1, countFiles(t, fs.Staging))
+
+	require.NoError(t, svc.Save("user1/img1.png", []byte("other content")))
+	img, err := svc.Load("user1/img1.png")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("other content"), img)
+
+	time.Sleep(150 * time.Millisecond) // fs store delays cleanup for 100ms
+	require.NoError(t, svc.Cleanup(context.Background(), 10*time.Millisecond))
+	assert.Equal(t, 0, countFiles(t, fs.Staging), "both blobs removed from staging")
+	_, err = svc.Load("user1/img1.png")
+	assert.Error(t, err)
+}
+
+func TestDedup_Cleanup(t *testing.T) {
+	svc, fs, teardown := prepareDedupTest(t)
+	defer teardown()
+
+	require.NoError(t, svc.Save("user1/img1.png", gopherPNGBytes()))
+	require.NoError(t, svc.Save("user1/img2.png", []byte("some other image")))
+	time.Sleep(200 * time.Millisecond)
+	require.NoError(t, svc.Save("user2/img3.png", gopherPNGBytes()), "resets timer of staging blob")
+	require.NoError(t, svc.Commit("user1/img2.png"))
+
+	require.NoError(t, svc.Cleanup(context.Background(), 150*time.Millisecond))
+	_, err := svc.Load("user1/img1.png")
+	assert.Error(t, err, "expired staging image removed")
+	img, err := svc.Load("user2/img3.png")
+	require.NoError(t, err, "blob kept for recent image")
+	assert.Equal(t, gopherPNGBytes(), img)
+	img, err = svc.Load("user1/img2.png")
+	require.NoError(t, err, "committed image kept")
+	assert.Equal(t, []byte("some other image"), img)
+	assert.Equal(t, 1, countFiles(t, fs.Staging))
+
+	time.Sleep(300 * time.Millisecond) // fs store delays cleanup for 100ms
+	require.NoError(t, svc.Cleanup(context.Background(), 150*time.Millisecond))
+	_, err = svc.Load("user2/img3.png")
+	assert.Error(t, err)
+	assert.Equal(t, 0, countFiles(t, fs.Staging), "unreferenced blob removed")
+	assert.Equal(t, 1, countFiles(t, fs.Location))
+
+	info, err := svc.Info()
+	require.NoError(t, err)
+	assert.True(t, info.FirstStagingImageTS.IsZero())
+}
+
+func TestDedup_ServiceClose(t *testing.T) {
+	svc, _, teardown := prepareDedupTest(t)
+	defer teardown()
+
+	imgSvc := NewService(svc, ServiceParams{EditDuration: time.Millisecond})
+	imgSvc.Close(context.Background())
+	assert.Error(t, svc.Save("user1/img1.png", gopherPNGBytes()), "index db closed with service")
+}
+
+func prepareDedupTest(t *testing.T) (svc *Dedup, fs *FileSystem, teardown func()) {
+	fs, fsTeardown := prepareImageTest(t)
+	dbFile := filepath.Join(os.TempDir(), "test-dedup.db")
+	_ = os.Remove(dbFile)
+	svc, err := NewDedup(fs, dbFile, bolt.Options{})
+	require.NoError(t, err)
+	return svc, fs, func() {
+		_ = svc.Close()
+		_ = os.Remove(dbFile)
+		fsTeardown()
+	}
+}
+
+// countFiles returns number of files in the directory and its subdirectories
+func countFiles(t *testing.T, dir string) (res int) {
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			res++
+		}
+		return err
+	})
+	require.NoError(t, err)
+	return res
+}
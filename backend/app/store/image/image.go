@@ -1,5 +1,5 @@
-// Package image handles storing, resizing and retrieval of images
-// Provides Store with Save and Load implementations on top of local file system and bolt db.
+// Package image handles storing, resizing, re-encoding and retrieval of images
+// Provides Store with Save and Load implementations on top of local file system, bolt db and S3.
 // Service object encloses Store and add common methods, this is the one consumer should use.
 package image
 
@@ -12,9 +12,9 @@ import (
 	"encoding/base64"
 	"fmt"
 	"image"
-	// support gif and jpeg images decoding
+	// support gif images decoding
 	_ "image/gif"
-	_ "image/jpeg"
+	"image/jpeg"
 	"image/png"
 	"io"
 	"io/ioutil"
@@ -32,6 +32,8 @@ import (
 	"github.com/pkg/errors"
 	"github.com/rs/xid"
 	"golang.org/x/image/draw"
+	// support webp images decoding
+	_ "golang.org/x/image/webp"
 )
 
 // Service wraps Store with common functions needed for any store implementation
@@ -56,6 +58,9 @@ type ServiceParams struct {
 	MaxSize      int
 	MaxHeight    int
 	MaxWidth     int
+	Format       string // format to re-encode images to, png, jpeg or webp. Empty means png for resized images only
+	ThumbHeight  int    // max height of thumbnail, thumbnails not made if 0
+	ThumbWidth   int    // max width of thumbnail, thumbnails not made if 0
 }
 
 // StoreInfo contains image store meta information
@@ -80,8 +85,18 @@ type Store interface {
 	Cleanup(ctx context.Context, ttl time.Duration) error // run removal loop for old images on staging
 }
 
+// Presigner is implemented by stores able to give temporary direct urls to images, like S3
+type Presigner interface {
+	PresignedURL(id string) (string, error) // url of the image, empty if disabled
+}
+
 const submitQueueSize = 5000
 
+const (
+	thumbnailSuffix = "-thumb" // suffix of thumbnail id, added to id of the image
+	jpegQuality     = 85
+)
+
 type submitReq struct {
 	idsFn func() (ids []string)
 	TS    time.Time
@@ -100,6 +115,12 @@ func (s *Service) SubmitAndCommit(idsFn func() []string) error {
 		if err != nil {
 			errs = multierror.Append(errs, errors.Wrapf(err, "failed to commit image %s", id))
 		}
+		if s.thumbnails() {
+			// thumbnail is not made for small images and ones uploaded before thumbnails enabled
+			if err = s.store.Commit(ThumbnailID(id)); err != nil {
+				log.Printf("[DEBUG] thumbnail of %s not committed, %v", id, err)
+			}
+		}
 	}
 	return errs.ErrorOrNil()
 }
@@ -138,6 +159,9 @@ func (s *Service) Submit(idsFn func() []string) {
 	// to prevent them from being cleaned up while waiting for EditDuration to expire
 	for _, imgID := range idsFn() {
 		_ = s.store.ResetCleanupTimer(imgID)
+		if s.thumbnails() {
+			_ = s.store.ResetCleanupTimer(ThumbnailID(imgID))
+		}
 	}
 
 	s.submitCh <- submitReq{idsFn: idsFn, TS: time.Now()}
@@ -199,8 +223,11 @@ func (s *Service) Cleanup(ctx context.Context) {
 	}
 }
 
-// ResetCleanupTimer resets cleanup timer for the image
+// ResetCleanupTimer resets cleanup timer for the image and its thumbnail
 func (s *Service) ResetCleanupTimer(id string) error {
+	if s.thumbnails() {
+		_ = s.store.ResetCleanupTimer(ThumbnailID(id))
+	}
 	return s.store.ResetCleanupTimer(id)
 }
 
@@ -234,6 +261,12 @@ func (s *Service) Close(ctx context.Context) {
 		close(s.submitCh)
 	}
 	s.wg.Wait()
+
+	if closer, ok := s.store.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Printf("[WARN] can't close image store, %v", err)
+		}
+	}
 }
 
 // Load wraps storage Load function.
@@ -241,6 +274,30 @@ func (s *Service) Load(id string) ([]byte, error) {
 	return s.store.Load(id)
 }
 
+// LoadThumbnail loads thumbnail of the image, falls back to the image itself if there is no thumbnail
+func (s *Service) LoadThumbnail(id string) ([]byte, error) {
+	if s.thumbnails() {
+		if img, err := s.store.Load(ThumbnailID(id)); err == nil {
+			return img, nil
+		}
+	}
+	return s.store.Load(id)
+}
+
+// ThumbnailID returns id of thumbnail for the image id
+func ThumbnailID(id string) string {
+	return id + thumbnailSuffix
+}
+
+// PresignedURL returns temporary direct url of the image if store supports it, empty string otherwise
+func (s *Service) PresignedURL(id string) (string, error) {
+	p, ok := s.store.(Presigner)
+	if !ok {
+		return "", nil
+	}
+	return p.PresignedURL(id)
+}
+
 // Save wraps storage Save function, validating and resizing the image before calling it.
 func (s *Service) Save(userID string, r io.Reader) (id string, err error) {
 	id = path.Join(userID, guid())
@@ -248,12 +305,23 @@ func (s *Service) Save(userID string, r io.Reader) (id string, err error) {
 }
 
 // SaveWithID wraps storage Save function, validating and resizing the image before calling it.
+// Saves thumbnail of the image as well, if enabled and the image is larger than thumbnail.
 func (s *Service) SaveWithID(id string, r io.Reader) error {
 	img, err := s.prepareImage(r)
 	if err != nil {
 		return err
 	}
-	return s.store.Save(id, img)
+	if err = s.store.Save(id, img); err != nil {
+		return err
+	}
+	if !s.thumbnails() {
+		return nil
+	}
+	thumb := resize(img, s.ThumbWidth, s.ThumbHeight, s.Format)
+	if bytes.Equal(thumb, img) {
+		return nil
+	}
+	return errors.Wrapf(s.store.Save(ThumbnailID(id), thumb), "can't save thumbnail of %s", id)
 }
 
 // ImgContentType returns content type for provided image
@@ -273,20 +341,25 @@ func (s *Service) prepareImage(r io.Reader) ([]byte, error) {
 		return nil, errors.Wrapf(err, "can't load image")
 	}
 
-	data = resize(data, s.MaxWidth, s.MaxHeight)
+	data = resize(data, s.MaxWidth, s.MaxHeight, s.Format)
 	return data, nil
 }
 
-// resize an image of supported format (PNG, JPG, GIF) to the size of "limit" px of
-// the biggest side (width or height) preserving aspect ratio.
-// Returns original data if resizing is not needed or failed.
-// If resized the result will be for png format
-func resize(data []byte, limitW, limitH int) []byte {
-	if data == nil || limitW <= 0 || limitH <= 0 {
+func (s *Service) thumbnails() bool {
+	return s.ThumbWidth > 0 && s.ThumbHeight > 0
+}
+
+// resize an image of supported format (PNG, JPG, GIF, WebP) to the size of "limit" px of
+// the biggest side (width or height) preserving aspect ratio, and re-encode it to the format.
+// With empty format the result will be for png format if resized, and original data otherwise.
+// Returns original data if resizing and re-encoding are not needed or failed, and if not resized
+// image re-encoded to a larger one. GIF images re-encoded only on resizing to keep animation.
+func resize(data []byte, limitW, limitH int, format string) []byte {
+	if data == nil || (limitW <= 0 || limitH <= 0) && format == "" {
 		return data
 	}
 
-	src, _, err := image.Decode(bytes.NewBuffer(data))
+	src, srcFormat, err := image.Decode(bytes.NewBuffer(data))
 	if err != nil {
 		log.Printf("[WARN] can't decode image, %s", err)
 		return data
@@ -294,21 +367,60 @@ func resize(data []byte, limitW, limitH int) []byte {
 
 	bounds := src.Bounds()
 	w, h := bounds.Dx(), bounds.Dy()
-	if w <= limitW && h <= limitH || w <= 0 || h <= 0 {
-		log.Printf("[DEBUG] resizing image is smaller that the limit or has 0 size")
+	if w <= 0 || h <= 0 {
+		log.Printf("[DEBUG] resizing image has 0 size")
+		return data
+	}
+	scale := limitW > 0 && limitH > 0 && (w > limitW || h > limitH)
+	if !scale && (format == "" || format == srcFormat || srcFormat == "gif") {
+		log.Printf("[DEBUG] resizing image is smaller that the limit and has the same format")
 		return data
 	}
 
-	newW, newH := getProportionalSizes(w, h, limitW, limitH)
-	m := image.NewRGBA(image.Rect(0, 0, newW, newH))
-	draw.CatmullRom.Scale(m, m.Bounds(), src, src.Bounds(), draw.Src, nil)
+	var m image.Image = src
+	if scale {
+		newW, newH := getProportionalSizes(w, h, limitW, limitH)
+		dst := image.NewRGBA(image.Rect(0, 0, newW, newH))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Src, nil)
+		m = dst
+	}
 
-	var out bytes.Buffer
-	if err = png.Encode(&out, m); err != nil {
-		log.Printf("[WARN] can't encode resized image to png, %s", err)
+	out, err := encode(m, format)
+	if err != nil {
+		log.Printf("[WARN] can't encode resized image, %s", err)
+		return data
+	}
+	if !scale && len(out) >= len(data) {
+		log.Printf("[DEBUG] re-encoded to %s image is larger than original, %d > %d", format, len(out), len(data))
 		return data
 	}
-	return out.Bytes()
+	return out
+}
+
+// ToWebP re-encodes image to WebP format. Returns original data if the image can't be decoded, is WebP or GIF
+// already (GIF kept to preserve animation), or if WebP version is not smaller.
+func ToWebP(data []byte) []byte {
+	return resize(data, 0, 0, "webp")
+}
+
+// encode image to the format, png if empty
+func encode(m image.Image, format string) ([]byte, error) {
+	var out bytes.Buffer
+	var err error
+	switch format {
+	case "", "png":
+		err = png.Encode(&out, m)
+	case "jpeg":
+		err = jpeg.Encode(&out, m, &jpeg.Options{Quality: jpegQuality})
+	case "webp":
+		err = encodeWebP(&out, m)
+	default:
+		return nil, errors.Errorf("unsupported image format %q", format)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't encode image to %s", format)
+	}
+	return out.Bytes(), nil
 }
 
 // getProportionalSizes returns width and height resized by both dimensions proportionally
@@ -38,7 +38,7 @@ func TestService_Resize(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 1462, len(img))
 
-	img = resize(img, 32, 32)
+	img = resize(img, 32, 32, "")
 	assert.Equal(t, 1135, len(img))
 }
 
@@ -51,7 +51,7 @@ func TestService_ResizeJpeg(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 23983, len(img))
 
-	img = resize(img, 400, 300)
+	img = resize(img, 400, 300, "")
 	assert.Equal(t, 10918, len(img))
 }
 
@@ -187,16 +187,16 @@ func TestService_Info(t *testing.T) {
 
 func TestService_resize(t *testing.T) {
 	// reader is nil
-	resized := resize(nil, 100, 100)
+	resized := resize(nil, 100, 100, "")
 	assert.Nil(t, resized)
 
 	// negative limit error
-	resized = resize([]byte("some picture bin data"), -1, -1)
+	resized = resize([]byte("some picture bin data"), -1, -1, "")
 	require.NotNil(t, resized)
 	assert.Equal(t, resized, []byte("some picture bin data"))
 
 	// decode error
-	resized = resize([]byte("invalid image content"), 100, 100)
+	resized = resize([]byte("invalid image content"), 100, 100, "")
 	assert.NotNil(t, resized)
 	assert.Equal(t, resized, []byte("invalid image content"))
 
@@ -213,12 +213,12 @@ func TestService_resize(t *testing.T) {
 		require.NoError(t, err, "can't open test file %s", c.file)
 
 		// no need for resize, image dimensions are smaller than resize limit
-		resized = resize(img, 800, 800)
+		resized = resize(img, 800, 800, "")
 		assert.NotNil(t, resized, "file %s", c.file)
 		assert.Equal(t, resized, img)
 
 		// resizing to half of width
-		resized = resize(img, 400, 400)
+		resized = resize(img, 400, 400, "")
 		assert.NotNil(t, resized, "file %s", c.file)
 		imgRz, format, err := image.Decode(bytes.NewBuffer(resized))
 		assert.NoError(t, err, "file %s", c.file)
@@ -230,6 +230,85 @@ func TestService_resize(t *testing.T) {
 
 }
 
+func TestService_resizeFormat(t *testing.T) {
+	png, err := ioutil.ReadFile("testdata/circles.png")
+	require.NoError(t, err)
+	jpg, err := ioutil.ReadFile("testdata/circles.jpg")
+	require.NoError(t, err)
+
+	tbl := []struct {
+		data          []byte
+		limit         int
+		format, resFm string
+		same          bool
+	}{
+		{png, 0, "webp", "webp", false},
+		{png, 400, "webp", "webp", false},
+		{png, 0, "png", "png", true},
+		{png, 0, "jpeg", "png", true}, // jpeg is larger than original drawing
+		{jpg, 0, "jpeg", "jpeg", true},
+		{jpg, 0, "webp", "jpeg", true}, // lossless webp is larger than original jpeg
+		{jpg, 400, "webp", "webp", false},
+		{jpg, 400, "jpeg", "jpeg", false},
+		{png, 0, "bad", "png", true},
+	}
+
+	for i, tt := range tbl {
+		res := resize(tt.data, tt.limit, tt.limit, tt.format)
+		assert.Equal(t, tt.same, bytes.Equal(tt.data, res), "case #%d", i)
+		m, format, err := image.Decode(bytes.NewReader(res))
+		require.NoError(t, err, "case #%d", i)
+		assert.Equal(t, tt.resFm, format, "case #%d", i)
+		if tt.limit > 0 {
+			assert.Equal(t, 400, m.Bounds().Dx()+m.Bounds().Dy()-300, "case #%d, resized to 400x300 or 300x400", i)
+		}
+	}
+}
+
+func TestService_SaveThumbnail(t *testing.T) {
+	fs, teardown := prepareImageTest(t)
+	defer teardown()
+	svc := NewService(fs, ServiceParams{MaxSize: 50000, MaxWidth: 2400, MaxHeight: 900, Format: "webp",
+		ThumbWidth: 200, ThumbHeight: 200})
+
+	fh, err := os.Open("testdata/circles.png")
+	require.NoError(t, err)
+	defer fh.Close()
+	id, err := svc.Save("user1", fh)
+	require.NoError(t, err)
+
+	img, err := svc.Load(id)
+	require.NoError(t, err)
+	assert.Equal(t, "image/webp", svc.ImgContentType(img))
+	m, _, err := image.Decode(bytes.NewReader(img))
+	require.NoError(t, err)
+	assert.Equal(t, 800, m.Bounds().Dx(), "not resized")
+
+	thumb, err := svc.LoadThumbnail(id)
+	require.NoError(t, err)
+	m, format, err := image.Decode(bytes.NewReader(thumb))
+	require.NoError(t, err)
+	assert.Equal(t, "webp", format)
+	assert.Equal(t, 200, m.Bounds().Dx())
+	assert.Equal(t, 150, m.Bounds().Dy())
+
+	require.NoError(t, svc.SubmitAndCommit(func() []string { return []string{id} }))
+	_, err = os.Stat(fs.location(fs.Location, ThumbnailID(id)))
+	assert.NoError(t, err, "thumbnail committed")
+
+	// small image has no thumbnail, image itself loaded
+	id, err = svc.Save("user1", gopherPNG())
+	require.NoError(t, err)
+	_, err = fs.Load(ThumbnailID(id))
+	assert.Error(t, err)
+	thumb, err = svc.LoadThumbnail(id)
+	require.NoError(t, err)
+	img, err = svc.Load(id)
+	require.NoError(t, err)
+	assert.Equal(t, img, thumb)
+	assert.NoError(t, svc.SubmitAndCommit(func() []string { return []string{id} }), "missing thumbnail ignored")
+}
+
 func TestGetProportionalSizes(t *testing.T) {
 	tbl := []struct {
 		inpW, inpH     int
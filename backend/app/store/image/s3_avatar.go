@@ -0,0 +1,120 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1" //nolint:gosec // not used for cryptography
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/go-pkgz/auth/token"
+	log "github.com/go-pkgz/lgr"
+	"github.com/minio/minio-go/v7"
+	"github.com/pkg/errors"
+)
+
+// S3Avatars implements avatar.Store of go-pkgz/auth with S3-compatible object storage.
+// Avatars kept under <prefix>avatars/ with the same ids as in other avatar stores.
+type S3Avatars struct {
+	S3Params
+	client *minio.Client
+}
+
+const s3AvatarsDir = "avatars/"
+
+var reS3AvatarID = regexp.MustCompile(`^[a-fA-F0-9]{40}\.image$`)
+
+// NewS3Avatars makes S3 avatar store and checks bucket, making it if missing
+func NewS3Avatars(params S3Params) (*S3Avatars, error) {
+	client, err := newS3Client(&params)
+	if err != nil {
+		return nil, err
+	}
+	return &S3Avatars{S3Params: params, client: client}, nil
+}
+
+// Put avatar for the user, returns avatar id (base name)
+func (s *S3Avatars) Put(userID string, reader io.Reader) (avatarID string, err error) {
+	avatarID = s3AvatarID(userID) + ".image"
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return "", errors.Wrapf(err, "can't read avatar %s", avatarID)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+	_, err = s.client.PutObject(ctx, s.Bucket, s.Prefix+s3AvatarsDir+avatarID, bytes.NewReader(data), int64(len(data)),
+		minio.PutObjectOptions{ContentType: http.DetectContentType(data)})
+	return avatarID, errors.Wrapf(err, "can't save avatar %s", avatarID)
+}
+
+// Get avatar reader for avatar id
+func (s *S3Avatars) Get(avatarID string) (reader io.ReadCloser, size int, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+	obj, err := s.client.GetObject(ctx, s.Bucket, s.Prefix+s3AvatarsDir+avatarID, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "can't load avatar %s", avatarID)
+	}
+	defer obj.Close() // nolint
+	data, err := ioutil.ReadAll(obj)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "can't load avatar %s", avatarID)
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), len(data), nil
+}
+
+// ID returns a fingerprint of the avatar content, etag of the object
+func (s *S3Avatars) ID(avatarID string) (id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+	info, err := s.client.StatObject(ctx, s.Bucket, s.Prefix+s3AvatarsDir+avatarID, minio.StatObjectOptions{})
+	if err != nil {
+		log.Printf("[DEBUG] can't get avatar info '%s', %s", avatarID, err)
+		return s3AvatarID(avatarID)
+	}
+	return strings.Trim(info.ETag, `"`)
+}
+
+// Remove avatar from the storage
+func (s *S3Avatars) Remove(avatarID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+	key := s.Prefix + s3AvatarsDir + avatarID
+	if _, err := s.client.StatObject(ctx, s.Bucket, key, minio.StatObjectOptions{}); err != nil {
+		return errors.Wrapf(err, "avatar key not found, %s", avatarID)
+	}
+	return errors.Wrapf(s.client.RemoveObject(ctx, s.Bucket, key, minio.RemoveObjectOptions{}),
+		"can't delete avatar object %s", avatarID)
+}
+
+// List all avatar ids
+func (s *S3Avatars) List() (ids []string, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+	for obj := range s.client.ListObjects(ctx, s.Bucket, minio.ListObjectsOptions{Prefix: s.Prefix + s3AvatarsDir, Recursive: true}) {
+		if obj.Err != nil {
+			return nil, errors.Wrap(obj.Err, "failed to list")
+		}
+		ids = append(ids, strings.TrimPrefix(obj.Key, s.Prefix+s3AvatarsDir))
+	}
+	return ids, nil
+}
+
+// Close does nothing, client has no persistent connections to close
+func (s *S3Avatars) Close() error { return nil }
+
+func (s *S3Avatars) String() string {
+	return fmt.Sprintf("s3, endpoint=%s, bucket=%s, prefix=%s", s.Endpoint, s.Bucket, s.Prefix+s3AvatarsDir)
+}
+
+// s3AvatarID makes avatar id from user id the same way as avatar stores of go-pkgz/auth do
+func s3AvatarID(id string) string {
+	if reS3AvatarID.MatchString(id) {
+		return strings.TrimSuffix(id, ".image") // already encoded, strip .image
+	}
+	return token.HashID(sha1.New(), id) //nolint:gosec // not used for cryptography
+}
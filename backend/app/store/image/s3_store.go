@@ -0,0 +1,213 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/hashicorp/go-multierror"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/pkg/errors"
+)
+
+// S3Params defines connection and layout parameters of S3-compatible object storage
+type S3Params struct {
+	Endpoint   string        // host[:port] of the storage, i.e. s3.amazonaws.com or minio:9000
+	AccessKey  string        // access key id
+	SecretKey  string        // secret access key
+	Region     string        // optional region, used for bucket creation and signing
+	Bucket     string        // bucket name, created if missing
+	Prefix     string        // optional prefix of all keys, i.e. "remark42/"
+	Secure     bool          // use https
+	PresignTTL time.Duration // lifetime of presigned urls, presigned urls disabled if 0
+	Timeout    time.Duration // timeout of a single request, default 30s
+}
+
+// S3 provides image Store for S3-compatible object storage (AWS S3, MinIO, etc).
+// Staged images kept under <prefix>staging/ and committed ones under <prefix>images/,
+// last modification time of staged object is used as insertion time for cleanup.
+type S3 struct {
+	S3Params
+	client *minio.Client
+}
+
+const (
+	s3StagingDir = "staging/"
+	s3ImagesDir  = "images/"
+)
+
+// NewS3Storage makes S3 image store and checks bucket, making it if missing
+func NewS3Storage(params S3Params) (*S3, error) {
+	client, err := newS3Client(&params)
+	if err != nil {
+		return nil, err
+	}
+	return &S3{S3Params: params, client: client}, nil
+}
+
+// newS3Client makes minio client for S3 params and checks bucket, making it if missing.
+// Sets default timeout if not defined.
+func newS3Client(params *S3Params) (*minio.Client, error) {
+	if params.Endpoint == "" || params.Bucket == "" {
+		return nil, errors.New("s3 endpoint and bucket are required")
+	}
+	if params.Timeout <= 0 {
+		params.Timeout = 30 * time.Second
+	}
+	client, err := minio.New(params.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(params.AccessKey, params.SecretKey, ""),
+		Secure: params.Secure,
+		Region: params.Region,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't make s3 client for %s", params.Endpoint)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), params.Timeout)
+	defer cancel()
+	exists, err := client.BucketExists(ctx, params.Bucket)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't check bucket %s", params.Bucket)
+	}
+	if !exists {
+		if err = client.MakeBucket(ctx, params.Bucket, minio.MakeBucketOptions{Region: params.Region}); err != nil {
+			return nil, errors.Wrapf(err, "can't make bucket %s", params.Bucket)
+		}
+		log.Printf("[INFO] bucket %s created", params.Bucket)
+	}
+	return client, nil
+}
+
+// Save image with given id to staging
+func (s *S3) Save(id string, img []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+	_, err := s.client.PutObject(ctx, s.Bucket, s.key(s3StagingDir, id), bytes.NewReader(img), int64(len(img)),
+		minio.PutObjectOptions{ContentType: http.DetectContentType(img)})
+	if err != nil {
+		return errors.Wrapf(err, "can't save image %s", id)
+	}
+	log.Printf("[DEBUG] image %s saved to s3 staging, size=%d", id, len(img))
+	return nil
+}
+
+// Commit image by copying it from staging to permanent location and removing staged object
+func (s *S3) Commit(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+	src := minio.CopySrcOptions{Bucket: s.Bucket, Object: s.key(s3StagingDir, id)}
+	dst := minio.CopyDestOptions{Bucket: s.Bucket, Object: s.key(s3ImagesDir, id)}
+	if _, err := s.client.CopyObject(ctx, dst, src); err != nil {
+		return errors.Wrapf(err, "failed to commit image %s", id)
+	}
+	err := s.client.RemoveObject(ctx, s.Bucket, s.key(s3StagingDir, id), minio.RemoveObjectOptions{})
+	return errors.Wrapf(err, "failed to remove staged image %s", id)
+}
+
+// ResetCleanupTimer resets cleanup timer for the image by copying staged object to itself,
+// which updates object's modification time
+func (s *S3) ResetCleanupTimer(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+	key := s.key(s3StagingDir, id)
+	info, err := s.client.StatObject(ctx, s.Bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "can't get image stats for %s", id)
+	}
+	src := minio.CopySrcOptions{Bucket: s.Bucket, Object: key}
+	dst := minio.CopyDestOptions{Bucket: s.Bucket, Object: key, ReplaceMetadata: true,
+		UserMetadata: map[string]string{"Content-Type": info.ContentType, "Ts": time.Now().Format(time.RFC3339Nano)}}
+	_, err = s.client.CopyObject(ctx, dst, src)
+	return errors.Wrapf(err, "problem updating %s modification time", id)
+}
+
+// Load image from permanent location or from staging
+func (s *S3) Load(id string) ([]byte, error) {
+	data, err := s.load(s.key(s3ImagesDir, id))
+	if err == nil {
+		return data, nil
+	}
+	if data, err = s.load(s.key(s3StagingDir, id)); err != nil {
+		return nil, errors.Wrapf(err, "can't load image %s", id)
+	}
+	return data, nil
+}
+
+// PresignedURL returns temporary url for direct access to image in the storage,
+// empty string if presigned urls disabled
+func (s *S3) PresignedURL(id string) (string, error) {
+	if s.PresignTTL <= 0 {
+		return "", nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+
+	key := s.key(s3ImagesDir, id)
+	if _, err := s.client.StatObject(ctx, s.Bucket, key, minio.StatObjectOptions{}); err != nil {
+		key = s.key(s3StagingDir, id)
+		if _, err = s.client.StatObject(ctx, s.Bucket, key, minio.StatObjectOptions{}); err != nil {
+			return "", errors.Wrapf(err, "can't find image %s", id)
+		}
+	}
+	u, err := s.client.PresignedGetObject(ctx, s.Bucket, key, s.PresignTTL, url.Values{})
+	if err != nil {
+		return "", errors.Wrapf(err, "can't make presigned url for %s", id)
+	}
+	return u.String(), nil
+}
+
+// Cleanup removes staged images older than ttl
+func (s *S3) Cleanup(ctx context.Context, ttl time.Duration) error {
+	errs := new(multierror.Error)
+	for obj := range s.client.ListObjects(ctx, s.Bucket, minio.ListObjectsOptions{Prefix: s.key(s3StagingDir, ""), Recursive: true}) {
+		if obj.Err != nil {
+			return errors.Wrap(obj.Err, "can't list staged images")
+		}
+		age := time.Since(obj.LastModified)
+		if age <= ttl {
+			continue
+		}
+		log.Printf("[INFO] remove staging image %s, age %v", obj.Key, age)
+		if err := s.client.RemoveObject(ctx, s.Bucket, obj.Key, minio.RemoveObjectOptions{}); err != nil {
+			errs = multierror.Append(errs, errors.Wrapf(err, "failed to remove image %s", obj.Key))
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+// Info returns meta information about storage
+func (s *S3) Info() (StoreInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+	var ts time.Time
+	for obj := range s.client.ListObjects(ctx, s.Bucket, minio.ListObjectsOptions{Prefix: s.key(s3StagingDir, ""), Recursive: true}) {
+		if obj.Err != nil {
+			return StoreInfo{}, errors.Wrap(obj.Err, "problem retrieving first timestamp from staging images")
+		}
+		if ts.IsZero() || obj.LastModified.Before(ts) {
+			ts = obj.LastModified
+		}
+	}
+	return StoreInfo{FirstStagingImageTS: ts}, nil
+}
+
+func (s *S3) load(key string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+	obj, err := s.client.GetObject(ctx, s.Bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close() // nolint
+	return ioutil.ReadAll(obj)
+}
+
+func (s *S3) key(dir, id string) string {
+	return s.Prefix + dir + strings.TrimPrefix(id, "/")
+}
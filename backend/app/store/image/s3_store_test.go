@@ -0,0 +1,173 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestS3Store_SaveCommitLoad(t *testing.T) {
+	svc, _, teardown := prepareS3ImageStorageTest(t)
+	defer teardown()
+
+	id := "user1/test_img"
+	require.NoError(t, svc.Save(id, gopherPNGBytes()))
+
+	data, err := svc.Load(id)
+	require.NoError(t, err, "staged image loaded")
+	assert.Equal(t, gopherPNGBytes(), data)
+
+	require.NoError(t, svc.Commit(id))
+	data, err = svc.Load(id)
+	require.NoError(t, err)
+	assert.Equal(t, gopherPNGBytes(), data)
+	_, err = svc.load(svc.key(s3StagingDir, id))
+	assert.Error(t, err, "staged image removed on commit")
+
+	_, err = svc.Load("user1/bad")
+	assert.EqualError(t, err, "can't load image user1/bad: The specified key does not exist.")
+	assert.Error(t, svc.Commit("user1/bad"))
+}
+
+func TestS3Store_Cleanup(t *testing.T) {
+	svc, clock, teardown := prepareS3ImageStorageTest(t)
+	defer teardown()
+
+	require.NoError(t, svc.Save("user1/img1", gopherPNGBytes()))
+	clock.Advance(time.Minute)
+	require.NoError(t, svc.Save("user1/img2", gopherPNGBytes()))
+	require.NoError(t, svc.Save("user1/img3", gopherPNGBytes()))
+	require.NoError(t, svc.Commit("user1/img3"))
+
+	info, err := svc.Info()
+	require.NoError(t, err)
+	assert.Equal(t, clock.Now().Add(-time.Minute).Unix(), info.FirstStagingImageTS.Unix())
+
+	clock.Advance(time.Minute)
+	require.NoError(t, svc.ResetCleanupTimer("user1/img1"))
+	assert.Error(t, svc.ResetCleanupTimer("user1/img3"), "not in staging")
+
+	// fake s3 clock started 10 minutes ago, img1 reset 8 minutes ago and img2 saved 9 minutes ago
+	require.NoError(t, svc.Cleanup(context.Background(), 8*time.Minute+30*time.Second))
+	_, err = svc.Load("user1/img1")
+	assert.NoError(t, err, "cleanup timer reset")
+	_, err = svc.Load("user1/img2")
+	assert.Error(t, err, "removed from staging")
+	_, err = svc.Load("user1/img3")
+	assert.NoError(t, err, "committed image kept")
+}
+
+func TestS3Store_PresignedURL(t *testing.T) {
+	svc, _, teardown := prepareS3ImageStorageTest(t)
+	defer teardown()
+
+	require.NoError(t, svc.Save("user1/img1", gopherPNGBytes()))
+	u, err := svc.PresignedURL("user1/img1")
+	require.NoError(t, err)
+	assert.Equal(t, "", u, "presigned urls disabled")
+
+	svc.PresignTTL = time.Hour
+	imgSvc := NewService(svc, ServiceParams{})
+	u, err = imgSvc.PresignedURL("user1/img1")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(u, "http://"+svc.Endpoint+"/test-bucket/pictures/staging/user1/img1?"), u)
+	assert.Contains(t, u, "X-Amz-Expires=3600")
+
+	resp, err := http.Get(u)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, gopherPNGBytes(), body)
+	assert.Equal(t, "image/png", resp.Header.Get("Content-Type"))
+
+	require.NoError(t, svc.Commit("user1/img1"))
+	u, err = svc.PresignedURL("user1/img1")
+	require.NoError(t, err)
+	assert.Contains(t, u, "/test-bucket/pictures/images/user1/img1?")
+
+	_, err = svc.PresignedURL("user1/bad")
+	assert.Error(t, err)
+
+	u, err = NewService(&FileSystem{}, ServiceParams{}).PresignedURL("user1/img1")
+	require.NoError(t, err)
+	assert.Equal(t, "", u, "not supported by store")
+}
+
+func TestS3Avatars(t *testing.T) {
+	svc, _, teardown := prepareS3ImageStorageTest(t)
+	defer teardown()
+
+	ava, err := NewS3Avatars(svc.S3Params)
+	require.NoError(t, err)
+	assert.Equal(t, "s3, endpoint="+svc.Endpoint+", bucket=test-bucket, prefix=pictures/avatars/", ava.String())
+
+	id, err := ava.Put("user1", bytes.NewReader(gopherPNGBytes()))
+	require.NoError(t, err)
+	assert.Equal(t, "b3daa77b4c04a9551b8781d03191fe098f325e67.image", id)
+	id2, err := ava.Put(id, bytes.NewReader([]byte("some data")))
+	require.NoError(t, err)
+	assert.Equal(t, id, id2, "encoded id kept")
+	_, err = ava.Put("user2", bytes.NewReader(gopherPNGBytes()))
+	require.NoError(t, err)
+
+	r, size, err := ava.Get(id)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "some data", string(data))
+	assert.Equal(t, 9, size)
+	_, _, err = ava.Get("bad.image")
+	assert.Error(t, err)
+
+	assert.Equal(t, "1e50210a0202497fb79bc38b6ade6c34", ava.ID(id), "md5 of content")
+	assert.Equal(t, "731c1e77ac2a18d451d0900a5fb2d54c2593db10", ava.ID("bad.image"))
+
+	ids, err := ava.List()
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(ids))
+
+	require.NoError(t, ava.Remove(id))
+	assert.Error(t, ava.Remove(id))
+	ids, err = ava.List()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a1881c06eec96db9901c7bbfe41c42a3f08e9cb4.image"}, ids)
+	assert.NoError(t, ava.Close())
+}
+
+func TestS3Store_New(t *testing.T) {
+	_, err := NewS3Storage(S3Params{Bucket: "bucket"})
+	assert.EqualError(t, err, "s3 endpoint and bucket are required")
+	_, err = NewS3Storage(S3Params{Endpoint: "127.0.0.1:1", Bucket: "bucket", Timeout: time.Second})
+	assert.Error(t, err)
+}
+
+func prepareS3ImageStorageTest(t *testing.T) (svc *S3, clock gofakes3.TimeSourceAdvancer, teardown func()) {
+	clock = gofakes3.FixedTimeSource(time.Now().Add(-10 * time.Minute))
+	faker := gofakes3.New(s3mem.New(s3mem.WithTimeSource(clock)), gofakes3.WithTimeSource(clock),
+		gofakes3.WithTimeSkewLimit(0))
+	handler := faker.Server()
+	// minio client always sends delimiter param, and fake s3 treats empty delimiter as set one
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if q := r.URL.Query(); q.Get("delimiter") == "" {
+			q.Del("delimiter")
+			r.URL.RawQuery = q.Encode()
+		}
+		handler.ServeHTTP(w, r)
+	}))
+
+	svc, err := NewS3Storage(S3Params{Endpoint: strings.TrimPrefix(ts.URL, "http://"), Bucket: "test-bucket",
+		Prefix: "pictures/", AccessKey: "key", SecretKey: "secret", Region: "us-east-1"})
+	require.NoError(t, err)
+	return svc, clock, ts.Close
+}
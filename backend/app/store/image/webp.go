@@ -0,0 +1,472 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/draw"
+	"io"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// encodeWebP writes image in lossless WebP (VP8L) format. The encoder is intentionally simple: it applies
+// subtract-green and "select" predictor transforms, finds backward references greedily and writes them
+// with a single set of Huffman codes, without color cache and per-tile codes. It is pure go, without
+// cgo dependencies, and makes files comparable to png.
+func encodeWebP(w io.Writer, m image.Image) error {
+	b := m.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width <= 0 || height <= 0 || width > 1<<14 || height > 1<<14 {
+		return errors.Errorf("can't encode %dx%d image to webp", width, height)
+	}
+
+	src, ok := m.(*image.NRGBA)
+	if !ok || src.Stride != 4*width {
+		src = image.NewNRGBA(image.Rect(0, 0, width, height))
+		draw.Draw(src, src.Bounds(), m, b.Min, draw.Src)
+	}
+	pix := make([]byte, len(src.Pix))
+	copy(pix, src.Pix)
+
+	hasAlpha := false
+	for p := 0; p < len(pix); p += 4 {
+		if pix[p+3] != 0xff {
+			hasAlpha = true
+		}
+		pix[p+0] -= pix[p+1] // subtract green from red and blue
+		pix[p+2] -= pix[p+1]
+	}
+	res := webpPredict(pix, width, height)
+
+	bw := &webpBitWriter{}
+	bw.write(0x2f, 8) // vp8l signature
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	if hasAlpha {
+		bw.write(1, 1)
+	} else {
+		bw.write(0, 1)
+	}
+	bw.write(0, 3) // version
+
+	bw.write(1, 1) // subtract green transform
+	bw.write(2, 2)
+	bw.write(1, 1) // predictor transform with the same mode for all tiles, encoded by single-symbol codes
+	bw.write(0, 2)
+	bw.write(webpPredictorBits-2, 3)
+	bw.write(0, 1) // no color cache for tiles image
+	bw.writeSimpleCode(webpSelectPredictor)
+	for i := 0; i < 4; i++ {
+		bw.writeSimpleCode(0)
+	}
+	bw.write(0, 1) // no more transforms
+
+	bw.write(0, 1) // no color cache
+	bw.write(0, 1) // no meta prefix codes
+	tokens := webpBackRefs(res, width)
+	hist := [5][]int{make([]int, 256+24), make([]int, 256), make([]int, 256), make([]int, 256), make([]int, 40)}
+	for _, t := range tokens {
+		if t.length == 0 {
+			hist[0][res[t.pos+1]]++
+			hist[1][res[t.pos+0]]++
+			hist[2][res[t.pos+2]]++
+			hist[3][res[t.pos+3]]++
+			continue
+		}
+		lenSym, _, _ := webpPrefix(t.length)
+		distSym, _, _ := webpPrefix(t.distCode)
+		hist[0][256+lenSym]++
+		hist[4][distSym]++
+	}
+	var codes [5]webpCode
+	for i := range hist {
+		codes[i] = bw.writeCode(hist[i])
+	}
+	for _, t := range tokens {
+		if t.length == 0 {
+			codes[0].write(bw, int(res[t.pos+1]))
+			codes[1].write(bw, int(res[t.pos+0]))
+			codes[2].write(bw, int(res[t.pos+2]))
+			codes[3].write(bw, int(res[t.pos+3]))
+			continue
+		}
+		lenSym, lenBits, lenExtra := webpPrefix(t.length)
+		codes[0].write(bw, 256+lenSym)
+		bw.write(lenExtra, lenBits)
+		distSym, distBits, distExtra := webpPrefix(t.distCode)
+		codes[4].write(bw, distSym)
+		bw.write(distExtra, distBits)
+	}
+	data := bw.bytes()
+
+	size := len(data)
+	if size%2 == 1 {
+		data = append(data, 0) // chunks padded to even size
+	}
+	hdr := make([]byte, 20)
+	copy(hdr[0:], "RIFF")
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(12+len(data)))
+	copy(hdr[8:], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(hdr[16:], uint32(size))
+	if _, err := w.Write(hdr); err != nil {
+		return errors.Wrap(err, "can't write webp header")
+	}
+	_, err := w.Write(data)
+	return errors.Wrap(err, "can't write webp data")
+}
+
+const (
+	webpPredictorBits   = 4  // log2 of predictor tile size
+	webpSelectPredictor = 11 // Select(L, T, TL) predictor mode
+)
+
+// webpPredict returns residuals of pixels (in RGBA order) predicted the way vp8l decoder does,
+// i.e. first pixel by opaque black, first row by left, first column by top pixel and the rest by "select"
+func webpPredict(pix []byte, width, height int) []byte {
+	res := make([]byte, len(pix))
+	stride := 4 * width
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			p := y*stride + 4*x
+			var pred [4]byte
+			switch {
+			case x == 0 && y == 0:
+				pred[3] = 0xff
+			case y == 0:
+				copy(pred[:], pix[p-4:p])
+			case x == 0:
+				copy(pred[:], pix[p-stride:p-stride+4])
+			default:
+				l, t, tl := pix[p-4:p], pix[p-stride:p-stride+4], pix[p-stride-4:p-stride]
+				distL, distT := 0, 0
+				for i := 0; i < 4; i++ {
+					distL += webpAbs(int(tl[i]) - int(t[i]))
+					distT += webpAbs(int(tl[i]) - int(l[i]))
+				}
+				if distL < distT {
+					copy(pred[:], l)
+				} else {
+					copy(pred[:], t)
+				}
+			}
+			for i := 0; i < 4; i++ {
+				res[p+i] = pix[p+i] - pred[i]
+			}
+		}
+	}
+	return res
+}
+
+const (
+	webpMinMatch   = 3       // shortest backward reference worth writing instead of literals
+	webpMaxMatch   = 4096    // longest backward reference allowed by format
+	webpMaxDist    = 1 << 19 // backward references window, in pixels
+	webpHashBits   = 16
+	webpChainDepth = 16 // number of previous positions with the same hash checked for a match
+)
+
+// webpToken is either a literal pixel at pos (in bytes) or a backward reference with length in pixels
+// and vp8l distance code
+type webpToken struct {
+	pos      int
+	length   int
+	distCode int
+}
+
+// webpBackRefs splits pixels to literals and greedy LZ77 backward references. Candidates for a match are
+// the previous pixel, the pixel above and previous positions with the same hash of two pixels.
+func webpBackRefs(pix []byte, width int) []webpToken {
+	n := len(pix) / 4
+	argb := make([]uint32, n)
+	for i := range argb {
+		argb[i] = binary.LittleEndian.Uint32(pix[4*i:])
+	}
+	hash := func(i int) uint32 {
+		return (argb[i]*0x1e35a7bd ^ argb[i+1]*0x9e3779b1) >> (32 - webpHashBits)
+	}
+	head := make([]int32, 1<<webpHashBits) // last position+1 with the hash
+	prev := make([]int32, n)               // previous position+1 with the same hash
+	insert := func(i int) {
+		if i+1 < n {
+			h := hash(i)
+			prev[i] = head[h]
+			head[h] = int32(i + 1)
+		}
+	}
+	matchLen := func(i, dist int) int {
+		l := 0
+		for i+l < n && l < webpMaxMatch && argb[i+l] == argb[i+l-dist] {
+			l++
+		}
+		return l
+	}
+
+	tokens := make([]webpToken, 0, n/2)
+	for i := 0; i < n; {
+		bestLen, bestDist := 0, 0
+		try := func(dist int) {
+			if dist <= 0 || dist > i || dist > webpMaxDist {
+				return
+			}
+			if l := matchLen(i, dist); l > bestLen {
+				bestLen, bestDist = l, dist
+			}
+		}
+		try(1)
+		try(width)
+		if i+1 < n {
+			for p, depth := head[hash(i)], 0; p > 0 && depth < webpChainDepth; p, depth = prev[p-1], depth+1 {
+				try(i - int(p-1))
+			}
+		}
+
+		if bestLen < webpMinMatch {
+			tokens = append(tokens, webpToken{pos: 4 * i})
+			insert(i)
+			i++
+			continue
+		}
+		distCode := bestDist + 120 // plain distances follow 120 codes of 2d neighbourhood
+		switch bestDist {
+		case width:
+			distCode = 1 // pixel above
+		case 1:
+			distCode = 2 // pixel on the left
+		}
+		tokens = append(tokens, webpToken{pos: 4 * i, length: bestLen, distCode: distCode})
+		for j := i; j < i+bestLen; j++ {
+			insert(j)
+		}
+		i += bestLen
+	}
+	return tokens
+}
+
+// webpPrefix returns prefix code symbol, number of extra bits and extra bits value for
+// backward reference length or distance code, reverse of lz77Param of vp8l decoder
+func webpPrefix(v int) (symbol int, nBits uint, extra uint32) {
+	v--
+	if v < 4 {
+		return v, 0, 0
+	}
+	h := uint(0)
+	for x := v; x > 1; x >>= 1 {
+		h++ // position of the highest bit
+	}
+	second := (v >> (h - 1)) & 1
+	nBits = h - 1
+	return int(2*h) + second, nBits, uint32(v - (2+second)<<nBits)
+}
+
+// webpBitWriter packs bits starting from the least significant one, as vp8l requires
+type webpBitWriter struct {
+	buf   bytes.Buffer
+	acc   uint64
+	nBits uint
+}
+
+func (b *webpBitWriter) write(v uint32, n uint) {
+	b.acc |= uint64(v) << b.nBits
+	b.nBits += n
+	for b.nBits >= 8 {
+		b.buf.WriteByte(byte(b.acc))
+		b.acc >>= 8
+		b.nBits -= 8
+	}
+}
+
+func (b *webpBitWriter) bytes() []byte {
+	if b.nBits > 0 {
+		b.buf.WriteByte(byte(b.acc))
+		b.acc, b.nBits = 0, 0
+	}
+	return b.buf.Bytes()
+}
+
+// writeSimpleCode writes code with a single symbol, taking no bits per symbol
+func (b *webpBitWriter) writeSimpleCode(symbol uint32) {
+	b.write(1, 1) // simple code
+	b.write(0, 1) // one symbol
+	if symbol < 2 {
+		b.write(0, 1)
+		b.write(symbol, 1)
+		return
+	}
+	b.write(1, 1)
+	b.write(symbol, 8)
+}
+
+// writeCode writes prefix code for the histogram and returns the code to write symbols with
+func (b *webpBitWriter) writeCode(hist []int) webpCode {
+	var used []int
+	for s, f := range hist {
+		if f > 0 {
+			used = append(used, s)
+		}
+	}
+
+	switch {
+	case len(used) == 0:
+		b.writeSimpleCode(0)
+		return webpCode{}
+	case len(used) == 1 && used[0] < 256:
+		b.writeSimpleCode(uint32(used[0]))
+		return webpCode{}
+	case len(used) == 2 && used[1] < 256:
+		b.write(1, 1) // simple code
+		b.write(1, 1) // two symbols
+		b.write(1, 1) // first symbol is 8 bits
+		b.write(uint32(used[0]), 8)
+		b.write(uint32(used[1]), 8)
+		code := webpCode{lengths: make([]uint, len(hist)), codes: make([]uint32, len(hist))}
+		code.lengths[used[0]], code.lengths[used[1]] = 1, 1
+		code.codes[used[1]] = 1
+		return code
+	}
+
+	lengths := webpHuffmanLengths(hist, 15)
+	clHist := make([]int, 16)
+	for _, l := range lengths {
+		clHist[l]++
+	}
+	nonZero := 0
+	for _, f := range clHist {
+		if f > 0 {
+			nonZero++
+		}
+	}
+	if nonZero == 1 { // code lengths code needs two symbols to be a complete tree
+		if clHist[0] == 0 {
+			clHist[0] = 1
+		} else {
+			clHist[1] = 1
+		}
+	}
+	clCode := newWebpCode(webpHuffmanLengths(clHist, 7))
+
+	order := [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	b.write(0, 1)  // normal code
+	b.write(15, 4) // all 19 code lengths code lengths written
+	for _, s := range order {
+		l := uint32(0)
+		if s < len(clCode.lengths) {
+			l = uint32(clCode.lengths[s])
+		}
+		b.write(l, 3)
+	}
+	b.write(0, 1) // code lengths of all symbols written
+	for _, l := range lengths {
+		clCode.write(b, int(l))
+	}
+	if len(used) == 1 {
+		return webpCode{}
+	}
+	return newWebpCode(lengths)
+}
+
+// webpCode is a canonical prefix code, code of zero length written as nothing
+type webpCode struct {
+	lengths []uint
+	codes   []uint32 // bit-reversed codes, ready to be written lsb first
+}
+
+func newWebpCode(lengths []uint) webpCode {
+	res := webpCode{lengths: lengths, codes: make([]uint32, len(lengths))}
+	var count [16]uint32
+	for _, l := range lengths {
+		count[l]++
+	}
+	count[0] = 0
+	var next [16]uint32
+	code := uint32(0)
+	for l := 1; l < 16; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+	for s, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		c := next[l]
+		next[l]++
+		rev := uint32(0)
+		for i := uint(0); i < l; i++ {
+			rev = rev<<1 | (c>>i)&1
+		}
+		res.codes[s] = rev
+	}
+	return res
+}
+
+func (c webpCode) write(b *webpBitWriter, symbol int) {
+	if c.lengths == nil {
+		return
+	}
+	b.write(c.codes[symbol], c.lengths[symbol])
+}
+
+// webpHuffmanLengths makes code lengths of Huffman code for the histogram, limited by maxLen.
+// Frequencies are flattened until the longest code fits the limit.
+func webpHuffmanLengths(hist []int, maxLen uint) []uint {
+	type node struct {
+		weight int
+		parent int
+	}
+	for shift := uint(0); ; shift++ {
+		var nodes []node
+		leaves := make([]int, len(hist)) // node index of each used symbol, -1 for unused
+		for s, f := range hist {
+			leaves[s] = -1
+			if f == 0 {
+				continue
+			}
+			w := f >> shift
+			if w == 0 {
+				w = 1
+			}
+			leaves[s] = len(nodes)
+			nodes = append(nodes, node{weight: w, parent: -1})
+		}
+
+		active := make([]int, len(nodes))
+		for i := range active {
+			active[i] = i
+		}
+		for len(active) > 1 {
+			sort.SliceStable(active, func(i, j int) bool { return nodes[active[i]].weight < nodes[active[j]].weight })
+			a, b := active[0], active[1]
+			nodes = append(nodes, node{weight: nodes[a].weight + nodes[b].weight, parent: -1})
+			nodes[a].parent, nodes[b].parent = len(nodes)-1, len(nodes)-1
+			active = append(active[2:], len(nodes)-1)
+		}
+
+		lengths := make([]uint, len(hist))
+		fits := true
+		for s, n := range leaves {
+			if n < 0 {
+				continue
+			}
+			for p := nodes[n].parent; p >= 0; p = nodes[p].parent {
+				lengths[s]++
+			}
+			if lengths[s] == 0 {
+				lengths[s] = 1 // the only symbol, declared with non-zero length but takes no bits
+			}
+			if lengths[s] > maxLen {
+				fits = false
+			}
+		}
+		if fits {
+			return lengths
+		}
+	}
+}
+
+func webpAbs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
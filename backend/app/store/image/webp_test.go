@@ -0,0 +1,97 @@
+package image
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/color/palette"
+	gifenc "image/gif"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/image/webp"
+)
+
+func TestEncodeWebP(t *testing.T) {
+	gopher, _, err := image.Decode(gopherPNG())
+	require.NoError(t, err)
+	fh, err := os.Open("testdata/circles.png")
+	require.NoError(t, err)
+	defer fh.Close()
+	circles, _, err := image.Decode(fh)
+	require.NoError(t, err)
+
+	solid := image.NewNRGBA(image.Rect(0, 0, 100, 70)) // long backward references
+	twoColors := image.NewNRGBA(image.Rect(0, 0, 5, 5))
+	noise := image.NewNRGBA(image.Rect(0, 0, 64, 33))
+	rnd := rand.New(rand.NewSource(1))
+	for y := 0; y < 70; y++ {
+		for x := 0; x < 100; x++ {
+			solid.Set(x, y, color.NRGBA{R: 10, G: 20, B: 30, A: 255})
+		}
+	}
+	twoColors.Set(2, 2, color.NRGBA{R: 255, A: 255})
+	rnd.Read(noise.Pix)
+
+	tbl := []struct {
+		name string
+		img  image.Image
+	}{
+		{"gopher", gopher}, {"circles", circles}, {"solid", solid}, {"two colors", twoColors}, {"noise", noise},
+		{"1x1", image.NewNRGBA(image.Rect(0, 0, 1, 1))}, {"sub-image", noise.SubImage(image.Rect(3, 5, 20, 17))},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, encodeWebP(&buf, tt.img))
+			assert.Equal(t, "image/webp", (&Service{}).ImgContentType(buf.Bytes()))
+
+			res, err := webp.Decode(&buf)
+			require.NoError(t, err)
+			b := tt.img.Bounds()
+			require.Equal(t, b.Dx(), res.Bounds().Dx())
+			require.Equal(t, b.Dy(), res.Bounds().Dy())
+			for y := 0; y < b.Dy(); y++ {
+				for x := 0; x < b.Dx(); x++ {
+					exp := color.NRGBAModel.Convert(tt.img.At(b.Min.X+x, b.Min.Y+y))
+					require.Equal(t, exp, res.At(x, y), "pixel %d:%d", x, y)
+				}
+			}
+		})
+	}
+
+	assert.Error(t, encodeWebP(&bytes.Buffer{}, image.NewNRGBA(image.Rect(0, 0, 0, 10))))
+}
+
+func TestToWebP(t *testing.T) {
+	circles, err := ioutil.ReadFile("testdata/circles.png")
+	require.NoError(t, err)
+	res := ToWebP(circles)
+	assert.Equal(t, "image/webp", (&Service{}).ImgContentType(res))
+	assert.True(t, len(res) < len(circles))
+	assert.Equal(t, res, ToWebP(res), "webp kept")
+
+	var gif bytes.Buffer
+	require.NoError(t, gifenc.Encode(&gif, image.NewPaletted(image.Rect(0, 0, 10, 10), palette.Plan9), nil))
+	assert.Equal(t, gif.Bytes(), ToWebP(gif.Bytes()), "gif kept")
+	assert.Equal(t, []byte("not an image"), ToWebP([]byte("not an image")))
+}
+
+func TestWebpHuffmanLengths(t *testing.T) {
+	hist := make([]int, 20)
+	for i := range hist {
+		hist[i] = 1 << uint(i) // skewed distribution makes 19 bits long codes without limit
+	}
+	lengths := webpHuffmanLengths(hist, 7)
+	kraft := 0.0
+	for _, l := range lengths {
+		assert.True(t, l > 0 && l <= 7, "length %d", l)
+		kraft += 1 / float64(uint(1)<<l)
+	}
+	assert.Equal(t, 1.0, kraft, "complete tree")
+	assert.Equal(t, []uint{0, 0, 1, 0}, webpHuffmanLengths([]int{0, 0, 5, 0}, 15), "single symbol")
+}
@@ -0,0 +1,142 @@
+package replication
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	opsBktName     = "ops"     // keyed by big-endian seq, ordered
+	cursorsBktName = "cursors" // keyed by siteID, seq of the last op reflected in site's db, standby only
+)
+
+// BoltLog keeps operation log of primary and replication cursors of standby in bolt DB
+type BoltLog struct {
+	db *bolt.DB
+}
+
+// NewBoltLog makes persistent operation log
+func NewBoltLog(fileName string, options bolt.Options) (*BoltLog, error) {
+	db, err := bolt.Open(fileName, 0600, &options) //nolint:gocritic //octalLiteral is OK as FileMode
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to make boltdb for %s", fileName)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bktName := range []string{opsBktName, cursorsBktName} {
+			if _, e := tx.CreateBucketIfNotExists([]byte(bktName)); e != nil {
+				return errors.Wrapf(e, "failed to create top level bucket %s", bktName)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to initialize boltdb db %q buckets", fileName)
+	}
+	return &BoltLog{db: db}, nil
+}
+
+// Append adds op to the log, returns op with assigned seq
+func (b *BoltLog) Append(op Op) (Op, error) {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(opsBktName))
+		seq, e := bkt.NextSequence()
+		if e != nil {
+			return errors.Wrap(e, "can't get next seq")
+		}
+		op.Seq = seq
+		data, e := json.Marshal(op)
+		if e != nil {
+			return errors.Wrap(e, "can't marshal op")
+		}
+		return errors.Wrapf(bkt.Put(seqKey(seq), data), "can't put op #%d", seq)
+	})
+	return op, err
+}
+
+// List returns up to limit ops with seq greater than after
+func (b *BoltLog) List(after uint64, limit int) (ops []Op, err error) {
+	ops = []Op{}
+	err = b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(opsBktName)).Cursor()
+		for k, v := c.Seek(seqKey(after + 1)); k != nil && len(ops) < limit; k, v = c.Next() {
+			var op Op
+			if e := json.Unmarshal(v, &op); e != nil {
+				return errors.Wrapf(e, "can't unmarshal op #%d", binary.BigEndian.Uint64(k))
+			}
+			ops = append(ops, op)
+		}
+		return nil
+	})
+	return ops, err
+}
+
+// Bounds returns seq of the first kept and the last recorded ops.
+// First is last+1 for empty log, last is kept even if all ops removed by cleanup.
+func (b *BoltLog) Bounds() (first, last uint64, err error) {
+	err = b.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(opsBktName))
+		last = bkt.Sequence()
+		first = last + 1
+		if k, _ := bkt.Cursor().First(); k != nil {
+			first = binary.BigEndian.Uint64(k)
+		}
+		return nil
+	})
+	return first, last, err
+}
+
+// Cleanup removes ops recorded before the given time, returns number of removed ops
+func (b *BoltLog) Cleanup(before time.Time) (count int, err error) {
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(opsBktName)).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.First() {
+			var op Op
+			if e := json.Unmarshal(v, &op); e != nil {
+				return errors.Wrapf(e, "can't unmarshal op #%d", binary.BigEndian.Uint64(k))
+			}
+			if !op.Time.Before(before) {
+				return nil
+			}
+			if e := c.Delete(); e != nil {
+				return errors.Wrapf(e, "can't delete op #%d", op.Seq)
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Cursor returns seq of the last op reflected in site's db, found false if site never synced
+func (b *BoltLog) Cursor(siteID string) (seq uint64, found bool, err error) {
+	err = b.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte(cursorsBktName)).Get([]byte(siteID)); v != nil {
+			seq, found = binary.BigEndian.Uint64(v), true
+		}
+		return nil
+	})
+	return seq, found, err
+}
+
+// SetCursor sets seq of the last op reflected in site's db
+func (b *BoltLog) SetCursor(siteID string, seq uint64) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket([]byte(cursorsBktName)).Put([]byte(siteID), seqKey(seq))
+		return errors.Wrapf(err, "can't put cursor of %s", siteID)
+	})
+}
+
+// Close bolt log
+func (b *BoltLog) Close() error {
+	return errors.Wrap(b.db.Close(), "failed to close replication log")
+}
+
+func seqKey(seq uint64) []byte {
+	res := make([]byte, 8)
+	binary.BigEndian.PutUint64(res, seq)
+	return res
+}
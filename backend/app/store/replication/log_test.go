@@ -0,0 +1,77 @@
+package replication
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBoltLog(t *testing.T) {
+	oplog, teardown := prepLog(t)
+	defer teardown()
+
+	first, last, err := oplog.Bounds()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), first)
+	assert.Equal(t, uint64(0), last)
+
+	ts := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		op, e := oplog.Append(Op{Time: ts.Add(time.Duration(i) * time.Minute), Method: MethodCreate, SiteID: "site1",
+			Data: []byte(`{"id":"c1"}`)})
+		require.NoError(t, e)
+		assert.Equal(t, uint64(i+1), op.Seq)
+	}
+
+	ops, err := oplog.List(2, 2)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(ops))
+	assert.Equal(t, uint64(3), ops[0].Seq)
+	assert.Equal(t, uint64(4), ops[1].Seq)
+	assert.Equal(t, `{"id":"c1"}`, string(ops[0].Data))
+	ops, err = oplog.List(5, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(ops))
+
+	count, err := oplog.Cleanup(ts.Add(2 * time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	first, last, err = oplog.Bounds()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), first)
+	assert.Equal(t, uint64(5), last)
+
+	count, err = oplog.Cleanup(ts.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+	first, last, err = oplog.Bounds()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(6), first, "empty log")
+	assert.Equal(t, uint64(5), last, "seq kept")
+
+	_, found, err := oplog.Cursor("site1")
+	require.NoError(t, err)
+	assert.False(t, found)
+	require.NoError(t, oplog.SetCursor("site1", 12))
+	cur, found, err := oplog.Cursor("site1")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, uint64(12), cur)
+}
+
+func prepLog(t *testing.T) (*BoltLog, func()) {
+	dir, err := ioutil.TempDir("", "replication")
+	require.NoError(t, err)
+	oplog, err := NewBoltLog(path.Join(dir, "replication.db"), bolt.Options{})
+	require.NoError(t, err)
+	return oplog, func() {
+		assert.NoError(t, oplog.Close())
+		_ = os.RemoveAll(dir)
+	}
+}
@@ -0,0 +1,273 @@
+package replication
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+const maxListLimit = 1000
+
+// PrimaryParams defines parameters of the primary node
+type PrimaryParams struct {
+	Secret    string        // shared secret, the same for primary and standby nodes
+	Retention time.Duration // ops older than retention removed from the log, standby falling behind it needs resync
+}
+
+// Primary wraps engine of the primary node. Each successful change recorded to the log in the same order as made.
+// Implements http.Handler to serve the log and snapshots to standby nodes.
+type Primary struct {
+	engine.Interface
+	PrimaryParams
+	log    *BoltLog
+	lock   sync.Mutex // serializes changes with their recording and snapshot start
+	token  string
+	router chi.Router
+}
+
+// NewPrimary makes Primary on top of eng, Snapshotter required to bootstrap standby nodes
+func NewPrimary(eng engine.Interface, oplog *BoltLog, params PrimaryParams) (*Primary, error) {
+	if params.Secret == "" {
+		return nil, errors.New("secret is required")
+	}
+	if params.Retention == 0 {
+		params.Retention = 7 * 24 * time.Hour
+	}
+	res := &Primary{Interface: eng, PrimaryParams: params, log: oplog, token: makeToken(params.Secret)}
+
+	res.router = chi.NewRouter()
+	res.router.Use(res.auth)
+	res.router.Get("/log", res.logCtrl)
+	res.router.Get("/snapshot", res.snapshotCtrl)
+	return res, nil
+}
+
+// Create comment and record it
+func (p *Primary) Create(comment store.Comment) (commentID string, err error) {
+	err = p.record(MethodCreate, comment.Locator.SiteID, &comment, func() error {
+		commentID, err = p.Interface.Create(comment)
+		comment.ID = commentID
+		return err
+	})
+	return commentID, err
+}
+
+// Update comment and record it
+func (p *Primary) Update(comment store.Comment) error {
+	return p.record(MethodUpdate, comment.Locator.SiteID, comment, func() error {
+		return p.Interface.Update(comment)
+	})
+}
+
+// Delete and record it
+func (p *Primary) Delete(req engine.DeleteRequest) error {
+	return p.record(MethodDelete, req.Locator.SiteID, req, func() error {
+		return p.Interface.Delete(req)
+	})
+}
+
+// Flag gets or sets flag, set recorded
+func (p *Primary) Flag(req engine.FlagRequest) (status bool, err error) {
+	if req.Update == engine.FlagNonSet {
+		return p.Interface.Flag(req)
+	}
+	err = p.record(MethodFlag, req.Locator.SiteID, req, func() error {
+		status, err = p.Interface.Flag(req)
+		return err
+	})
+	return status, err
+}
+
+// Reattribute comments and record it
+func (p *Primary) Reattribute(req engine.ReattributeRequest) (ids []string, err error) {
+	err = p.record(MethodReattribute, req.Locator.SiteID, req, func() error {
+		ids, err = p.Interface.Reattribute(req)
+		return err
+	})
+	return ids, err
+}
+
+// Remap comments to new urls and record it
+func (p *Primary) Remap(req engine.RemapRequest) (ids []string, err error) {
+	err = p.record(MethodRemap, req.Locator.SiteID, req, func() error {
+		ids, err = p.Interface.Remap(req)
+		return err
+	})
+	return ids, err
+}
+
+// UserDetail gets or sets user detail, set recorded. Time of consent fixed before recording to keep it on standby.
+func (p *Primary) UserDetail(req engine.UserDetailRequest) (res []engine.UserDetailEntry, err error) {
+	if !isDetailUpdate(req) {
+		return p.Interface.UserDetail(req)
+	}
+	if req.Detail == engine.UserConsent && req.Time == nil {
+		now := time.Now()
+		req.Time = &now
+	}
+	err = p.record(MethodUserDetail, req.Locator.SiteID, req, func() error {
+		res, err = p.Interface.UserDetail(req)
+		return err
+	})
+	return res, err
+}
+
+// Record gets, lists or changes records, changes recorded
+func (p *Primary) Record(req engine.RecordRequest) (res []engine.RecordEntry, err error) {
+	if !isRecordUpdate(req) {
+		return p.Interface.Record(req)
+	}
+	err = p.record(MethodRecord, req.Locator.SiteID, req, func() error {
+		res, err = p.Interface.Record(req)
+		return err
+	})
+	return res, err
+}
+
+// Run removes expired ops from the log periodically, blocking
+func (p *Primary) Run(ctx context.Context) {
+	log.Printf("[INFO] replication primary, log retention %v", p.Retention)
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		count, err := p.log.Cleanup(time.Now().Add(-p.Retention))
+		if err != nil {
+			log.Printf("[WARN] can't cleanup replication log, %v", err)
+		}
+		if count > 0 {
+			log.Printf("[DEBUG] %d ops removed from replication log", count)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Status returns seq range of the log
+func (p *Primary) Status() Status {
+	first, last, err := p.log.Bounds()
+	if err != nil {
+		log.Printf("[WARN] can't get replication log bounds, %v", err)
+	}
+	return Status{Role: "primary", First: first, Last: last}
+}
+
+// Close engine and the log
+func (p *Primary) Close() error {
+	err := p.Interface.Close()
+	if e := p.log.Close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+// ServeHTTP handles requests from standby nodes
+func (p *Primary) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.router.ServeHTTP(w, r)
+}
+
+// record makes change under lock and appends it to the log if succeeded.
+// Failed append doesn't fail the change already made, standby can't follow it reliably and should be resynced.
+func (p *Primary) record(method, siteID string, req interface{}, change func() error) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if err := change(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(req)
+	if err == nil {
+		_, err = p.log.Append(Op{Time: time.Now(), Method: method, SiteID: siteID, Data: data})
+	}
+	if err != nil {
+		log.Printf("[ERROR] %s on %s not recorded to replication log, standby should be resynced, %v", method, siteID, err)
+	}
+	return nil
+}
+
+func (p *Primary) auth(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(tokenHeader)), []byte(p.token)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// GET /log?after=seq&limit=n - returns ops after seq, 410 if some of them removed from the log already
+func (p *Primary) logCtrl(w http.ResponseWriter, r *http.Request) {
+	after, err := strconv.ParseUint(r.URL.Query().Get("after"), 10, 64)
+	if err != nil {
+		http.Error(w, "Bad after", http.StatusBadRequest)
+		return
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > maxListLimit {
+		limit = maxListLimit
+	}
+
+	first, last, err := p.log.Bounds()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if after+1 < first {
+		http.Error(w, "Ops removed from the log, resync required", http.StatusGone)
+		return
+	}
+	ops, err := p.log.List(after, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(seqHeader, strconv.FormatUint(last, 10))
+	_ = json.NewEncoder(w).Encode(ops)
+}
+
+// GET /snapshot?site=siteID - streams consistent copy of site's db, seq of the last op included sent in header
+func (p *Primary) snapshotCtrl(w http.ResponseWriter, r *http.Request) {
+	snap, ok := p.Interface.(Snapshotter)
+	if !ok {
+		http.Error(w, "Snapshots not supported by engine", http.StatusNotImplemented)
+		return
+	}
+
+	p.lock.Lock()
+	locked := true
+	started := func(size int64) {
+		if _, last, err := p.log.Bounds(); err == nil {
+			w.Header().Set(seqHeader, strconv.FormatUint(last, 10))
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		p.lock.Unlock()
+		locked = false
+	}
+	err := snap.Snapshot(r.URL.Query().Get("site"), w, started)
+	if !locked {
+		if err != nil { // truncated by content length, status can't be changed after streaming started
+			log.Printf("[WARN] can't stream snapshot, %v", err)
+		}
+		return
+	}
+	p.lock.Unlock()
+	if err != nil {
+		log.Printf("[WARN] can't make snapshot, %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
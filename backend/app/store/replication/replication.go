@@ -0,0 +1,131 @@
+// Package replication implements warm standby of bolt store. Primary node wraps its engine, records each change
+// to the operation log and serves the log with snapshots of site's dbs to standby nodes.
+// Standby node bootstraps missing dbs from snapshots, follows the log of the primary and applies changes locally,
+// rejecting own writes till promoted to primary.
+package replication
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+const (
+	tokenHeader = "X-Remark-Replica"
+	seqHeader   = "X-Replication-Seq"
+)
+
+// enum of replicated methods of engine.Interface
+const (
+	MethodCreate      = "create"
+	MethodUpdate      = "update"
+	MethodDelete      = "delete"
+	MethodFlag        = "flag"
+	MethodReattribute = "reattribute"
+	MethodRemap       = "remap"
+	MethodUserDetail  = "user_detail"
+	MethodRecord      = "record"
+)
+
+// Op is a single change of the store, Data is the json of method's argument
+type Op struct {
+	Seq    uint64          `json:"seq"`
+	Time   time.Time       `json:"time"`
+	Method string          `json:"method"`
+	SiteID string          `json:"site"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// Status of replication node
+type Status struct {
+	Role     string     `json:"role"`               // primary or standby
+	Primary  string     `json:"primary,omitempty"`  // url of the followed primary, standby only
+	First    uint64     `json:"first,omitempty"`    // first op kept in the log, primary only
+	Last     uint64     `json:"last"`               // last recorded op for primary, last applied op for standby
+	Synced   *time.Time `json:"synced,omitempty"`   // time of the last successful poll of the primary, standby only
+	Promoted bool       `json:"promoted,omitempty"` // standby promoted to primary
+	Error    string     `json:"error,omitempty"`    // last replication error, standby only
+
+	Unreplicated []string `json:"unreplicated,omitempty"` // files of data kept by standby only, not replicated from primary
+}
+
+// Snapshotter makes consistent copy of site's db, implemented by engine.BoltDB
+type Snapshotter interface {
+	Snapshot(siteID string, w io.Writer, started func(size int64)) error
+}
+
+// apply makes change of the op on eng
+func (op Op) apply(eng engine.Interface) (err error) {
+	switch op.Method {
+	case MethodCreate:
+		var c store.Comment
+		if err = op.decode(&c); err == nil {
+			_, err = eng.Create(c)
+		}
+	case MethodUpdate:
+		var c store.Comment
+		if err = op.decode(&c); err == nil {
+			err = eng.Update(c)
+		}
+	case MethodDelete:
+		var req engine.DeleteRequest
+		if err = op.decode(&req); err == nil {
+			err = eng.Delete(req)
+		}
+	case MethodFlag:
+		var req engine.FlagRequest
+		if err = op.decode(&req); err == nil {
+			_, err = eng.Flag(req)
+		}
+	case MethodReattribute:
+		var req engine.ReattributeRequest
+		if err = op.decode(&req); err == nil {
+			_, err = eng.Reattribute(req)
+		}
+	case MethodRemap:
+		var req engine.RemapRequest
+		if err = op.decode(&req); err == nil {
+			_, err = eng.Remap(req)
+		}
+	case MethodUserDetail:
+		var req engine.UserDetailRequest
+		if err = op.decode(&req); err == nil {
+			_, err = eng.UserDetail(req)
+		}
+	case MethodRecord:
+		var req engine.RecordRequest
+		if err = op.decode(&req); err == nil {
+			_, err = eng.Record(req)
+		}
+	default:
+		return errors.Errorf("unknown method %q of op #%d", op.Method, op.Seq)
+	}
+	return errors.Wrapf(err, "can't apply %s op #%d", op.Method, op.Seq)
+}
+
+func (op Op) decode(v interface{}) error {
+	return errors.Wrapf(json.Unmarshal(op.Data, v), "can't decode %s op #%d", op.Method, op.Seq)
+}
+
+// isDetailUpdate checks if request sets user detail rather than reads it
+func isDetailUpdate(req engine.UserDetailRequest) bool {
+	return req.Detail != engine.AllUserDetails && (req.Update != "" || req.Profile != nil)
+}
+
+// isRecordUpdate checks if record request changes records
+func isRecordUpdate(req engine.RecordRequest) bool {
+	return req.Op != engine.RecordGet && req.Op != engine.RecordList
+}
+
+// makeToken derives token of replication requests from the shared secret
+func makeToken(secret string) string {
+	tkn := sha256.Sum256([]byte("replication:" + secret))
+	return hex.EncodeToString(tkn[:])
+}
@@ -0,0 +1,197 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+func TestReplication(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replication")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// primary with one comment made before the standby bootstrapped
+	primaryLog, err := NewBoltLog(path.Join(dir, "primary-log.db"), bolt.Options{})
+	require.NoError(t, err)
+	primaryEng, err := engine.NewBoltDB(bolt.Options{}, engine.BoltSite{FileName: path.Join(dir, "primary.db"), SiteID: "site1"})
+	require.NoError(t, err)
+	primary, err := NewPrimary(primaryEng, primaryLog, PrimaryParams{Secret: "secret"})
+	require.NoError(t, err)
+	defer primary.Close()
+	ts := httptest.NewServer(primary)
+	defer ts.Close()
+
+	loc := store.Locator{SiteID: "site1", URL: "https://example.com/post1"}
+	_, err = primary.Create(store.Comment{ID: "c1", Locator: loc, Text: "first", User: store.User{ID: "user1"},
+		Timestamp: time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)})
+	require.NoError(t, err)
+
+	// standby bootstrapped from snapshot
+	standbyLog, err := NewBoltLog(path.Join(dir, "standby-log.db"), bolt.Options{})
+	require.NoError(t, err)
+	params := StandbyParams{Primary: ts.URL + "/", Secret: "secret"}
+	site := engine.BoltSite{FileName: path.Join(dir, "standby.db"), SiteID: "site1"}
+	require.NoError(t, Bootstrap(standbyLog, []engine.BoltSite{site}, params))
+	require.NoError(t, Bootstrap(standbyLog, []engine.BoltSite{site}, params), "bootstrapped already")
+	standbyEng, err := engine.NewBoltDB(bolt.Options{}, site)
+	require.NoError(t, err)
+	flushed := 0
+	params.Flush = func(siteID string) {
+		assert.Equal(t, "site1", siteID)
+		flushed++
+	}
+	require.NoError(t, ioutil.WriteFile(path.Join(dir, "sessions.db"), []byte("local"), 0o600))
+	params.Unreplicated = []string{path.Join(dir, "sessions.db"), path.Join(dir, "missing.db")}
+	standby, err := NewStandby(standbyEng, standbyLog, []string{"site1"}, params)
+	require.NoError(t, err)
+	assert.Equal(t, []string{path.Join(dir, "sessions.db")}, standby.Status().Unreplicated, "existing files only")
+	defer standby.Close()
+
+	c, err := standby.Get(engine.GetRequest{Locator: loc, CommentID: "c1"})
+	require.NoError(t, err)
+	assert.Equal(t, "first", c.Text)
+	assert.Equal(t, uint64(1), standby.Status().Last)
+
+	// changes made on primary after the snapshot
+	_, err = primary.Create(store.Comment{ID: "c2", Locator: loc, Text: "second", User: store.User{ID: "user2"},
+		Timestamp: time.Date(2021, 5, 1, 11, 0, 0, 0, time.UTC)})
+	require.NoError(t, err)
+	c.Text = "first edited"
+	require.NoError(t, primary.Update(c))
+	_, err = primary.Flag(engine.FlagRequest{Flag: engine.Blocked, Locator: store.Locator{SiteID: "site1"},
+		UserID: "user2", Update: engine.FlagTrue})
+	require.NoError(t, err)
+	_, err = primary.UserDetail(engine.UserDetailRequest{Detail: engine.UserConsent, Locator: store.Locator{SiteID: "site1"},
+		UserID: "user1", Update: "v1"})
+	require.NoError(t, err)
+	_, err = primary.Reattribute(engine.ReattributeRequest{Locator: store.Locator{SiteID: "site1"}, FromID: "user1",
+		To: store.User{ID: "user3", Name: "user three"}})
+	require.NoError(t, err)
+	require.NoError(t, primary.Delete(engine.DeleteRequest{Locator: loc, CommentID: "c2", DeleteMode: store.HardDelete}))
+	_, err = primary.Flag(engine.FlagRequest{Flag: engine.Blocked, Locator: store.Locator{SiteID: "site1"}, UserID: "user2"})
+	require.NoError(t, err)
+	_, err = primary.Record(engine.RecordRequest{Op: engine.RecordSet, Kind: engine.Drafts, Locator: store.Locator{SiteID: "site1"},
+		Key: "k1", Value: json.RawMessage(`"v1"`)})
+	require.NoError(t, err)
+	_, err = primary.Record(engine.RecordRequest{Op: engine.RecordList, Kind: engine.Drafts, Locator: store.Locator{SiteID: "site1"}})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(8), primary.Status().Last, "reads not recorded")
+
+	count, err := standby.sync(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 7, count)
+	assert.Equal(t, 1, flushed)
+	status := standby.Status()
+	assert.Equal(t, uint64(8), status.Last)
+	assert.NotNil(t, status.Synced)
+	assert.Equal(t, "", status.Error)
+
+	c, err = standby.Get(engine.GetRequest{Locator: loc, CommentID: "c1"})
+	require.NoError(t, err)
+	assert.Equal(t, "first edited", c.Text)
+	assert.Equal(t, "user3", c.User.ID)
+	c2, err := standby.Get(engine.GetRequest{Locator: loc, CommentID: "c2"})
+	require.NoError(t, err)
+	assert.Equal(t, "", c2.Text, "deleted")
+	blocked, err := standby.Flag(engine.FlagRequest{Flag: engine.Blocked, Locator: store.Locator{SiteID: "site1"}, UserID: "user2"})
+	require.NoError(t, err)
+	assert.True(t, blocked)
+	details, err := standby.UserDetail(engine.UserDetailRequest{Detail: engine.UserConsent,
+		Locator: store.Locator{SiteID: "site1"}, UserID: "user1"})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(details))
+	assert.Equal(t, "v1", details[0].Consent)
+	records, err := standby.Record(engine.RecordRequest{Op: engine.RecordGet, Kind: engine.Drafts,
+		Locator: store.Locator{SiteID: "site1"}, Key: "k1"})
+	require.NoError(t, err)
+	assert.Equal(t, []engine.RecordEntry{{Key: "k1", Value: json.RawMessage(`"v1"`)}}, records)
+
+	count, err = standby.sync(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, count, "nothing new")
+
+	// local changes rejected till promotion
+	_, err = standby.Create(store.Comment{ID: "c3", Locator: loc, Text: "local", User: store.User{ID: "user1"}})
+	assert.Equal(t, ErrStandby, err)
+	assert.Equal(t, ErrStandby, standby.Delete(engine.DeleteRequest{Locator: loc, CommentID: "c1"}))
+	_, err = standby.UserDetail(engine.UserDetailRequest{Detail: engine.UserEmail, Locator: store.Locator{SiteID: "site1"},
+		UserID: "user1", Update: "user1@example.com"})
+	assert.Equal(t, ErrStandby, err)
+	_, err = standby.Record(engine.RecordRequest{Op: engine.RecordDelete, Kind: engine.Drafts,
+		Locator: store.Locator{SiteID: "site1"}, Key: "k1"})
+	assert.Equal(t, ErrStandby, err)
+
+	err = standby.Promote(false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "data of "+path.Join(dir, "sessions.db")+" not replicated from primary")
+	assert.False(t, standby.Status().Promoted, "refused with unreplicated data")
+	require.NoError(t, standby.Promote(true))
+	assert.True(t, standby.Status().Promoted)
+	require.NoError(t, standby.Promote(false), "promoted already")
+	_, err = standby.Create(store.Comment{ID: "c3", Locator: loc, Text: "local", User: store.User{ID: "user1"}})
+	assert.NoError(t, err)
+	_, err = primary.Create(store.Comment{ID: "c4", Locator: loc, Text: "after promotion", User: store.User{ID: "user1"}})
+	require.NoError(t, err)
+	_, err = standby.sync(context.Background())
+	require.NoError(t, err)
+	_, err = standby.Get(engine.GetRequest{Locator: loc, CommentID: "c4"})
+	assert.Error(t, err, "promoted standby doesn't follow primary")
+}
+
+func TestPrimary_Log(t *testing.T) {
+	oplog, teardown := prepLog(t)
+	defer teardown()
+	primary, err := NewPrimary(&engine.MockInterface{}, oplog, PrimaryParams{Secret: "secret"})
+	require.NoError(t, err)
+	ts := httptest.NewServer(primary)
+	defer ts.Close()
+
+	for i := 0; i < 3; i++ {
+		_, err = oplog.Append(Op{Time: time.Date(2021, 5, 1, 10, i, 0, 0, time.UTC), Method: MethodUpdate, SiteID: "site1"})
+		require.NoError(t, err)
+	}
+	_, err = oplog.Cleanup(time.Date(2021, 5, 1, 10, 1, 0, 0, time.UTC))
+	require.NoError(t, err)
+
+	get := func(u, token string) int {
+		req, e := http.NewRequest(http.MethodGet, ts.URL+u, nil)
+		require.NoError(t, e)
+		req.Header.Set(tokenHeader, token)
+		resp, e := http.DefaultClient.Do(req)
+		require.NoError(t, e)
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+	token := makeToken("secret")
+	assert.Equal(t, http.StatusUnauthorized, get("/log?after=1", "bad"))
+	assert.Equal(t, http.StatusOK, get("/log?after=1", token))
+	assert.Equal(t, http.StatusOK, get("/log?after=3", token))
+	assert.Equal(t, http.StatusGone, get("/log?after=0", token), "op #1 removed")
+	assert.Equal(t, http.StatusBadRequest, get("/log", token))
+	assert.Equal(t, http.StatusNotImplemented, get("/snapshot?site=site1", token), "mock engine can't make snapshots")
+
+	standby, err := NewStandby(&engine.MockInterface{}, oplog, []string{"site1"}, StandbyParams{Primary: ts.URL, Secret: "secret"})
+	require.NoError(t, err)
+	_, err = standby.sync(context.Background())
+	assert.EqualError(t, err, "op #1 removed from primary's log, standby should be resynced")
+	assert.Equal(t, err.Error(), standby.Status().Error)
+
+	_, err = NewPrimary(&engine.MockInterface{}, oplog, PrimaryParams{})
+	assert.Error(t, err)
+	_, err = NewStandby(&engine.MockInterface{}, oplog, nil, StandbyParams{Secret: "secret"})
+	assert.Error(t, err)
+}
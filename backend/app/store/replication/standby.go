@@ -0,0 +1,406 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// ErrStandby returned for changes made on standby node before its promotion
+var ErrStandby = errors.New("store is read-only on standby node")
+
+// StandbyParams defines parameters of the standby node
+type StandbyParams struct {
+	Primary  string              // url of primary's replication api, i.e. https://remark42.example.com/api/v1/replication
+	Secret   string              // shared secret, the same for primary and standby nodes
+	Interval time.Duration       // interval of primary polling
+	Timeout  time.Duration       // timeout of log requests, snapshots downloaded without timeout
+	Flush    func(siteID string) // optional, called after changes of the site applied, i.e. to flush cache
+
+	// Unreplicated are files of the node's data kept out of the engine, i.e. sessions, not replicated from primary.
+	// Existing ones reported in status, promotion refused while any exists unless forced.
+	Unreplicated []string
+}
+
+// Standby wraps engine of the standby node. Follows the log of the primary and applies its changes locally,
+// own changes rejected with ErrStandby till promotion.
+type Standby struct {
+	engine.Interface
+	StandbyParams
+	log    *BoltLog
+	sites  []string
+	client http.Client
+	token  string
+
+	lock     sync.Mutex // serializes applying of changes with promotion
+	promoted bool
+	synced   *time.Time
+	lastErr  error
+}
+
+// NewStandby makes Standby on top of eng for the given sites, all of them bootstrapped already
+func NewStandby(eng engine.Interface, oplog *BoltLog, sites []string, params StandbyParams) (*Standby, error) {
+	params, err := params.prep()
+	if err != nil {
+		return nil, err
+	}
+	res := &Standby{Interface: eng, StandbyParams: params, log: oplog, sites: sites,
+		client: http.Client{Timeout: params.Timeout}, token: makeToken(params.Secret)}
+	if local := res.unreplicated(); len(local) > 0 {
+		log.Printf("[WARN] data of %s kept by this node only, not replicated from primary", strings.Join(local, ", "))
+	}
+	return res, nil
+}
+
+// Bootstrap downloads snapshots of sites without local db from the primary and sets their cursors.
+// Should be called before opening of the engine. Site's db without cursor not replaced, remove it to resync.
+func Bootstrap(oplog *BoltLog, sites []engine.BoltSite, params StandbyParams) error {
+	params, err := params.prep()
+	if err != nil {
+		return err
+	}
+	token := makeToken(params.Secret)
+	for _, site := range sites {
+		_, found, err := oplog.Cursor(site.SiteID)
+		if err != nil {
+			return err
+		}
+		_, statErr := os.Stat(site.FileName)
+		if found && statErr == nil {
+			continue
+		}
+		if statErr == nil {
+			return errors.Errorf("db %s of %s exists but never synced with primary, remove it to bootstrap",
+				site.FileName, site.SiteID)
+		}
+
+		seq, err := download(site, params.Primary, token)
+		if err != nil {
+			return errors.Wrapf(err, "can't bootstrap %s", site.SiteID)
+		}
+		if err = oplog.SetCursor(site.SiteID, seq); err != nil {
+			return err
+		}
+		log.Printf("[INFO] site %s bootstrapped from %s, op #%d", site.SiteID, params.Primary, seq)
+	}
+	return nil
+}
+
+// Create rejected till promotion
+func (s *Standby) Create(comment store.Comment) (commentID string, err error) {
+	if !s.Promoted() {
+		return "", ErrStandby
+	}
+	return s.Interface.Create(comment)
+}
+
+// Update rejected till promotion
+func (s *Standby) Update(comment store.Comment) error {
+	if !s.Promoted() {
+		return ErrStandby
+	}
+	return s.Interface.Update(comment)
+}
+
+// Delete rejected till promotion
+func (s *Standby) Delete(req engine.DeleteRequest) error {
+	if !s.Promoted() {
+		return ErrStandby
+	}
+	return s.Interface.Delete(req)
+}
+
+// Flag gets flag, set rejected till promotion
+func (s *Standby) Flag(req engine.FlagRequest) (bool, error) {
+	if req.Update != engine.FlagNonSet && !s.Promoted() {
+		return false, ErrStandby
+	}
+	return s.Interface.Flag(req)
+}
+
+// Reattribute rejected till promotion
+func (s *Standby) Reattribute(req engine.ReattributeRequest) ([]string, error) {
+	if !s.Promoted() {
+		return nil, ErrStandby
+	}
+	return s.Interface.Reattribute(req)
+}
+
+// Remap rejected till promotion
+func (s *Standby) Remap(req engine.RemapRequest) ([]string, error) {
+	if !s.Promoted() {
+		return nil, ErrStandby
+	}
+	return s.Interface.Remap(req)
+}
+
+// UserDetail gets user detail, set rejected till promotion
+func (s *Standby) UserDetail(req engine.UserDetailRequest) ([]engine.UserDetailEntry, error) {
+	if isDetailUpdate(req) && !s.Promoted() {
+		return nil, ErrStandby
+	}
+	return s.Interface.UserDetail(req)
+}
+
+// Record gets or lists records, changes rejected till promotion
+func (s *Standby) Record(req engine.RecordRequest) ([]engine.RecordEntry, error) {
+	if isRecordUpdate(req) && !s.Promoted() {
+		return nil, ErrStandby
+	}
+	return s.Interface.Record(req)
+}
+
+// Run polls the primary and applies its changes till promotion or ctx cancellation, blocking
+func (s *Standby) Run(ctx context.Context) {
+	log.Printf("[INFO] replication standby of %s, sites %v", s.Primary, s.sites)
+	for {
+		count, err := s.sync(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("[WARN] replication from %s failed, %v", s.Primary, err)
+		}
+		if s.Promoted() {
+			return
+		}
+		if count == maxListLimit && err == nil {
+			continue // more ops waiting
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.Interval):
+		}
+	}
+}
+
+// Promote stops following the primary and allows changes. Old primary should be resynced as standby after it.
+// Refused while any of unreplicated files exists, as its data differs from the primary's one, unless forced.
+func (s *Standby) Promote(force bool) error {
+	local := s.unreplicated()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.promoted {
+		return nil
+	}
+	if len(local) > 0 {
+		if !force {
+			return errors.Errorf("data of %s not replicated from primary, promote with force to keep local data as is",
+				strings.Join(local, ", "))
+		}
+		log.Printf("[WARN] standby promoted with local data of %s, not replicated from primary", strings.Join(local, ", "))
+	}
+	s.promoted = true
+	log.Printf("[INFO] audit: standby of %s promoted to primary", s.Primary)
+	return nil
+}
+
+// Promoted checks if standby promoted to primary
+func (s *Standby) Promoted() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.promoted
+}
+
+// Status returns the last applied op and result of the last poll
+func (s *Standby) Status() Status {
+	last, err := s.cursor()
+	if err != nil {
+		log.Printf("[WARN] can't get replication cursor, %v", err)
+	}
+	local := s.unreplicated()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	res := Status{Role: "standby", Primary: s.Primary, Last: last, Synced: s.synced, Promoted: s.promoted,
+		Unreplicated: local}
+	if s.lastErr != nil {
+		res.Error = s.lastErr.Error()
+	}
+	return res
+}
+
+// unreplicated returns existing files of unreplicated data
+func (s *Standby) unreplicated() (res []string) {
+	for _, f := range s.Unreplicated {
+		if _, err := os.Stat(f); err == nil {
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
+// Close engine and the log
+func (s *Standby) Close() error {
+	err := s.Interface.Close()
+	if e := s.log.Close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+// sync fetches ops after the lowest cursor of sites and applies them, returns number of fetched ops.
+// Each site skips ops already reflected in its db, i.e. included in snapshot.
+func (s *Standby) sync(ctx context.Context) (count int, err error) {
+	after, err := s.cursor()
+	if err == nil {
+		var ops []Op
+		if ops, err = s.fetch(ctx, after); err == nil {
+			count = len(ops)
+			err = s.apply(ops)
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.lastErr = err
+	if err == nil {
+		now := time.Now()
+		s.synced = &now
+	}
+	return count, err
+}
+
+// apply ops not reflected in sites yet. Cursors of all sites moved to the last op, as none of ops skipped.
+func (s *Standby) apply(ops []Op) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.promoted || len(ops) == 0 {
+		return nil
+	}
+
+	cursors := map[string]uint64{}
+	for _, site := range s.sites {
+		if cursors[site], _, err = s.log.Cursor(site); err != nil {
+			return err
+		}
+	}
+
+	changed := map[string]bool{}
+	for _, op := range ops {
+		cur, ok := cursors[op.SiteID]
+		if !ok || op.Seq <= cur {
+			continue
+		}
+		if err = op.apply(s.Interface); err != nil {
+			break
+		}
+		cursors[op.SiteID], changed[op.SiteID] = op.Seq, true
+	}
+	if err == nil {
+		for site, cur := range cursors {
+			if cur < ops[len(ops)-1].Seq {
+				cursors[site] = ops[len(ops)-1].Seq
+			}
+		}
+	}
+
+	for site, cur := range cursors {
+		if e := s.log.SetCursor(site, cur); e != nil && err == nil {
+			err = e
+		}
+		if changed[site] && s.Flush != nil {
+			s.Flush(site)
+		}
+	}
+	return err
+}
+
+// cursor returns the lowest cursor of sites, all ops up to it reflected in all sites
+func (s *Standby) cursor() (res uint64, err error) {
+	for i, site := range s.sites {
+		cur, _, err := s.log.Cursor(site)
+		if err != nil {
+			return 0, err
+		}
+		if i == 0 || cur < res {
+			res = cur
+		}
+	}
+	return res, nil
+}
+
+// fetch ops after seq from the primary
+func (s *Standby) fetch(ctx context.Context, after uint64) (ops []Op, err error) {
+	u := fmt.Sprintf("%s/log?after=%d&limit=%d", s.Primary, after, maxListLimit)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't make log request")
+	}
+	req.Header.Set(tokenHeader, s.token)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't get log")
+	}
+	defer resp.Body.Close() // nolint
+	if resp.StatusCode == http.StatusGone {
+		return nil, errors.Errorf("op #%d removed from primary's log, standby should be resynced", after+1)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return ops, errors.Wrap(json.NewDecoder(resp.Body).Decode(&ops), "can't decode log")
+}
+
+// download snapshot of the site to its file, returns seq of the last op included
+func download(site engine.BoltSite, primary, token string) (seq uint64, err error) {
+	req, err := http.NewRequest(http.MethodGet, primary+"/snapshot?site="+url.QueryEscape(site.SiteID), nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "can't make snapshot request")
+	}
+	req.Header.Set(tokenHeader, token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, errors.Wrap(err, "can't get snapshot")
+	}
+	defer resp.Body.Close() // nolint
+	if resp.StatusCode != http.StatusOK {
+		return 0, errors.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	if seq, err = strconv.ParseUint(resp.Header.Get(seqHeader), 10, 64); err != nil {
+		return 0, errors.Wrap(err, "bad seq of snapshot")
+	}
+
+	tmpFile := site.FileName + ".tmp"
+	fh, err := os.OpenFile(tmpFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600) //nolint:gocritic //octalLiteral is OK as FileMode
+	if err != nil {
+		return 0, errors.Wrap(err, "can't create snapshot file")
+	}
+	_, err = io.Copy(fh, resp.Body)
+	if e := fh.Close(); e != nil && err == nil {
+		err = e
+	}
+	if err != nil {
+		_ = os.Remove(tmpFile)
+		return 0, errors.Wrap(err, "can't save snapshot")
+	}
+	return seq, errors.Wrap(os.Rename(tmpFile, site.FileName), "can't rename snapshot file")
+}
+
+func (p StandbyParams) prep() (StandbyParams, error) {
+	if p.Primary == "" {
+		return p, errors.New("primary url is required")
+	}
+	p.Primary = strings.TrimSuffix(p.Primary, "/")
+	if p.Secret == "" {
+		return p, errors.New("secret is required")
+	}
+	if p.Interval == 0 {
+		p.Interval = time.Second
+	}
+	if p.Timeout == 0 {
+		p.Timeout = 30 * time.Second
+	}
+	return p, nil
+}
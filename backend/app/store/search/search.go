@@ -0,0 +1,398 @@
+// Package search provides full-text search over comments. Each site has its own bleve index,
+// stored on disk in IndexPath or kept in memory if path not set. Index updated by service.DataStore
+// on comment changes and can be rebuilt from the store with service.DataStore.RebuildSearchIndex.
+package search
+
+import (
+	"html"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/analysis/analyzer/keyword"
+	_ "github.com/blevesearch/bleve/analysis/lang/de" // register analyzers
+	_ "github.com/blevesearch/bleve/analysis/lang/en"
+	_ "github.com/blevesearch/bleve/analysis/lang/es"
+	_ "github.com/blevesearch/bleve/analysis/lang/fr"
+	_ "github.com/blevesearch/bleve/analysis/lang/ru"
+	"github.com/blevesearch/bleve/mapping"
+	blevesearch "github.com/blevesearch/bleve/search"
+	htmlHighlighter "github.com/blevesearch/bleve/search/highlight/highlighter/html"
+	"github.com/blevesearch/bleve/search/query"
+	log "github.com/go-pkgz/lgr"
+	"github.com/hashicorp/go-multierror"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// Analyzers supported by search service
+var Analyzers = []string{"standard", "en", "ru", "de", "fr", "es"}
+
+const (
+	defaultLimit        = 20
+	maxLimit            = 100
+	deleteBatch         = 1000
+	defaultSimilarLimit = 5
+	maxSimilarText      = 2000 // draft text cut to this number of runes
+)
+
+// Params defines search service parameters
+type Params struct {
+	IndexPath string // directory of indexes, in-memory indexes used if empty
+	Analyzer  string // text analyzer, one of Analyzers, "standard" by default
+}
+
+// Service manages search indexes of all sites
+type Service struct {
+	Params
+	lock    sync.RWMutex
+	indexes map[string]bleve.Index
+}
+
+// Request to search comments of the site. Query uses bleve query string syntax,
+// i.e. `+golang -java "exact phrase"`. SortBy is "time", "-time", or empty for sorting by relevance
+type Request struct {
+	SiteID string
+	Query  string
+	Label  string // only comments with the label, query can be empty if set
+	SortBy string
+	Limit  int
+	Skip   int
+}
+
+// SimilarRequest to find comments of the post similar to the draft text
+type SimilarRequest struct {
+	SiteID string
+	URL    string
+	Text   string // draft text, markdown or html
+	Limit  int
+}
+
+// Hit is a single comment matched the query
+type Hit struct {
+	ID         string   `json:"id"`
+	URL        string   `json:"url"`
+	Score      float64  `json:"score"`
+	Highlights []string `json:"highlights,omitempty"` // fragments of text with matched terms marked by <mark>
+}
+
+// Result of search request, Total is a number of all matched comments, Hits limited by request's Limit
+type Result struct {
+	Total uint64 `json:"total"`
+	Hits  []Hit  `json:"hits"`
+}
+
+// document is a comment representation stored in index
+type document struct {
+	URL    string    `json:"url"`
+	User   string    `json:"user"`
+	Text   string    `json:"text"`
+	Time   time.Time `json:"time"`
+	Labels []string  `json:"label"`
+}
+
+// NewService makes search service and opens (or creates) index for each site
+func NewService(sites []string, params Params) (*Service, error) {
+	if params.Analyzer == "" {
+		params.Analyzer = "standard"
+	}
+	res := &Service{Params: params, indexes: map[string]bleve.Index{}}
+	for _, site := range sites {
+		idx, err := res.openIndex(site)
+		if err != nil {
+			_ = res.Close()
+			return nil, errors.Wrapf(err, "can't open search index for %s", site)
+		}
+		res.indexes[site] = idx
+	}
+	log.Printf("[INFO] search service for sites %v, path %q, analyzer %s", sites, params.IndexPath, params.Analyzer)
+	return res, nil
+}
+
+// Index adds or updates comment in the index of its site, deleted comments removed from index
+func (s *Service) Index(comment store.Comment) error {
+	if comment.Deleted {
+		return s.Delete(comment.Locator.SiteID, comment.ID)
+	}
+	idx, err := s.index(comment.Locator.SiteID)
+	if err != nil {
+		return err
+	}
+	return idx.Index(comment.ID, makeDocument(comment))
+}
+
+// IndexBatch adds comments to the index in a single batch. All comments should belong to the same site.
+func (s *Service) IndexBatch(comments []store.Comment) error {
+	if len(comments) == 0 {
+		return nil
+	}
+	idx, err := s.index(comments[0].Locator.SiteID)
+	if err != nil {
+		return err
+	}
+	batch := idx.NewBatch()
+	for _, c := range comments {
+		if c.Deleted {
+			batch.Delete(c.ID)
+			continue
+		}
+		if err = batch.Index(c.ID, makeDocument(c)); err != nil {
+			return errors.Wrapf(err, "can't add %s to batch", c.ID)
+		}
+	}
+	return idx.Batch(batch)
+}
+
+// Delete removes comment from the index
+func (s *Service) Delete(siteID, commentID string) error {
+	idx, err := s.index(siteID)
+	if err != nil {
+		return err
+	}
+	return idx.Delete(commentID)
+}
+
+// DeletePost removes all comments of the post from the index
+func (s *Service) DeletePost(locator store.Locator) error {
+	return s.deleteByField(locator.SiteID, "url", locator.URL)
+}
+
+// DeleteUser removes all comments of the user from the index
+func (s *Service) DeleteUser(siteID, userID string) error {
+	return s.deleteByField(siteID, "user", userID)
+}
+
+// Clear removes all comments of the site from the index
+func (s *Service) Clear(siteID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	idx, ok := s.indexes[siteID]
+	if !ok {
+		return errors.Errorf("site %s not found", siteID)
+	}
+	if err := idx.Close(); err != nil {
+		return errors.Wrapf(err, "can't close search index for %s", siteID)
+	}
+	if s.IndexPath != "" {
+		if err := os.RemoveAll(s.indexPath(siteID)); err != nil {
+			return errors.Wrapf(err, "can't remove search index for %s", siteID)
+		}
+	}
+	idx, err := s.openIndex(siteID)
+	if err != nil {
+		delete(s.indexes, siteID)
+		return errors.Wrapf(err, "can't make search index for %s", siteID)
+	}
+	s.indexes[siteID] = idx
+	return nil
+}
+
+// Search comments matching the query
+func (s *Service) Search(req Request) (Result, error) {
+	idx, err := s.index(req.SiteID)
+	if err != nil {
+		return Result{}, err
+	}
+	if req.Limit <= 0 {
+		req.Limit = defaultLimit
+	}
+	if req.Limit > maxLimit {
+		req.Limit = maxLimit
+	}
+	if req.Skip < 0 {
+		req.Skip = 0
+	}
+
+	var q query.Query = bleve.NewMatchAllQuery()
+	if req.Query != "" {
+		q = bleve.NewQueryStringQuery(req.Query)
+	}
+	if req.Label != "" {
+		lq := bleve.NewTermQuery(req.Label)
+		lq.SetField("label")
+		q = bleve.NewConjunctionQuery(q, lq)
+	}
+	sreq := bleve.NewSearchRequestOptions(q, req.Limit, req.Skip, false)
+	sreq.Fields = []string{"url"}
+	sreq.Highlight = bleve.NewHighlightWithStyle(htmlHighlighter.Name)
+	sreq.Highlight.AddField("text")
+	switch req.SortBy {
+	case "time", "+time":
+		sreq.SortBy([]string{"time", "_id"})
+	case "-time":
+		sreq.SortBy([]string{"-time", "_id"})
+	}
+
+	sres, err := idx.Search(sreq)
+	if err != nil {
+		return Result{}, errors.Wrapf(err, "can't search for %q", req.Query)
+	}
+	res := Result{Total: sres.Total, Hits: make([]Hit, 0, len(sres.Hits))}
+	for _, h := range sres.Hits {
+		res.Hits = append(res.Hits, makeHit(h))
+	}
+	return res, nil
+}
+
+// Similar finds comments of the post sharing terms with the draft text, most similar first. Draft text matched as
+// plain words, query string syntax not applied.
+func (s *Service) Similar(req SimilarRequest) (Result, error) {
+	idx, err := s.index(req.SiteID)
+	if err != nil {
+		return Result{}, err
+	}
+	if req.Limit <= 0 {
+		req.Limit = defaultSimilarLimit
+	}
+	if req.Limit > maxLimit {
+		req.Limit = maxLimit
+	}
+	text := []rune(strings.TrimSpace(html.UnescapeString(textPolicy.Sanitize(req.Text))))
+	if len(text) > maxSimilarText {
+		text = text[:maxSimilarText]
+	}
+	if len(text) == 0 {
+		return Result{Hits: []Hit{}}, nil
+	}
+
+	mq := bleve.NewMatchQuery(string(text))
+	mq.SetField("text")
+	uq := bleve.NewTermQuery(req.URL)
+	uq.SetField("url")
+	sreq := bleve.NewSearchRequestOptions(bleve.NewConjunctionQuery(mq, uq), req.Limit, 0, false)
+	sreq.Fields = []string{"url"}
+	sreq.Highlight = bleve.NewHighlightWithStyle(htmlHighlighter.Name)
+	sreq.Highlight.AddField("text")
+
+	sres, err := idx.Search(sreq)
+	if err != nil {
+		return Result{}, errors.Wrapf(err, "can't search similar to draft for %s", req.URL)
+	}
+	res := Result{Total: sres.Total, Hits: make([]Hit, 0, len(sres.Hits))}
+	for _, h := range sres.Hits {
+		res.Hits = append(res.Hits, makeHit(h))
+	}
+	return res, nil
+}
+
+// Close all indexes
+func (s *Service) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	errs := new(multierror.Error)
+	for site, idx := range s.indexes {
+		if err := idx.Close(); err != nil {
+			errs = multierror.Append(errs, errors.Wrapf(err, "can't close search index for %s", site))
+		}
+	}
+	s.indexes = map[string]bleve.Index{}
+	return errs.ErrorOrNil()
+}
+
+// deleteByField removes all documents with exact value of the keyword field
+func (s *Service) deleteByField(siteID, field, value string) error {
+	idx, err := s.index(siteID)
+	if err != nil {
+		return err
+	}
+	q := bleve.NewTermQuery(value)
+	q.SetField(field)
+	for {
+		sres, err := idx.Search(bleve.NewSearchRequestOptions(q, deleteBatch, 0, false))
+		if err != nil {
+			return errors.Wrapf(err, "can't find documents with %s=%s", field, value)
+		}
+		if len(sres.Hits) == 0 {
+			return nil
+		}
+		batch := idx.NewBatch()
+		for _, h := range sres.Hits {
+			batch.Delete(h.ID)
+		}
+		if err = idx.Batch(batch); err != nil {
+			return errors.Wrapf(err, "can't delete documents with %s=%s", field, value)
+		}
+	}
+}
+
+func (s *Service) index(siteID string) (bleve.Index, error) {
+	if s == nil {
+		return nil, errors.New("search disabled")
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	idx, ok := s.indexes[siteID]
+	if !ok {
+		return nil, errors.Errorf("site %s not found", siteID)
+	}
+	return idx, nil
+}
+
+// openIndex opens existing index of the site or creates a new one
+func (s *Service) openIndex(siteID string) (bleve.Index, error) {
+	if s.IndexPath == "" {
+		return bleve.NewMemOnly(s.indexMapping())
+	}
+	path := s.indexPath(siteID)
+	if _, err := os.Stat(path); err == nil {
+		return bleve.Open(path)
+	}
+	if err := os.MkdirAll(s.IndexPath, 0700); err != nil {
+		return nil, errors.Wrapf(err, "can't make directory %s", s.IndexPath)
+	}
+	return bleve.New(path, s.indexMapping())
+}
+
+func (s *Service) indexPath(siteID string) string {
+	return filepath.Join(s.IndexPath, siteID+".bleve")
+}
+
+func (s *Service) indexMapping() mapping.IndexMapping {
+	keywordField := bleve.NewTextFieldMapping()
+	keywordField.Analyzer = keyword.Name
+	keywordField.IncludeInAll = false
+
+	textField := bleve.NewTextFieldMapping()
+	textField.Analyzer = s.Analyzer
+
+	timeField := bleve.NewDateTimeFieldMapping()
+	timeField.IncludeInAll = false
+
+	doc := bleve.NewDocumentMapping()
+	doc.AddFieldMappingsAt("url", keywordField)
+	doc.AddFieldMappingsAt("user", keywordField)
+	doc.AddFieldMappingsAt("text", textField)
+	doc.AddFieldMappingsAt("time", timeField)
+	doc.AddFieldMappingsAt("label", keywordField)
+
+	res := bleve.NewIndexMapping()
+	res.DefaultMapping = doc
+	res.DefaultAnalyzer = s.Analyzer
+	res.DefaultField = "text"
+	return res
+}
+
+var textPolicy = bluemonday.StrictPolicy()
+
+func makeDocument(comment store.Comment) document {
+	return document{
+		URL:    comment.Locator.URL,
+		User:   comment.User.ID,
+		Text:   strings.TrimSpace(html.UnescapeString(textPolicy.Sanitize(comment.Text))),
+		Time:   comment.Timestamp,
+		Labels: comment.Labels,
+	}
+}
+
+func makeHit(h *blevesearch.DocumentMatch) Hit {
+	res := Hit{ID: h.ID, Score: h.Score, Highlights: h.Fragments["text"]}
+	if u, ok := h.Fields["url"].(string); ok {
+		res.URL = u
+	}
+	return res
+}
@@ -0,0 +1,227 @@
+package search
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestService_IndexAndSearch(t *testing.T) {
+	svc, err := NewService([]string{"site1", "site2"}, Params{Analyzer: "en"})
+	require.NoError(t, err)
+	defer svc.Close()
+
+	for _, c := range testComments() {
+		require.NoError(t, svc.Index(c))
+	}
+
+	res, err := svc.Search(Request{SiteID: "site1", Query: "running"})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), res.Total, "stemmed by en analyzer")
+	ids := []string{res.Hits[0].ID, res.Hits[1].ID}
+	assert.ElementsMatch(t, []string{"c1", "c3"}, ids)
+	assert.Equal(t, "https://example.com/post1", hitByID(res, "c1").URL)
+	assert.Equal(t, []string{"Some <mark>runs</mark> &amp; tests"}, hitByID(res, "c1").Highlights)
+
+	res, err = svc.Search(Request{SiteID: "site1", Query: "running", SortBy: "-time"})
+	require.NoError(t, err)
+	assert.Equal(t, "c3", res.Hits[0].ID)
+	assert.Equal(t, "c1", res.Hits[1].ID)
+
+	res, err = svc.Search(Request{SiteID: "site1", Query: "running", SortBy: "+time", Limit: 1, Skip: 1})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), res.Total)
+	require.Equal(t, 1, len(res.Hits))
+	assert.Equal(t, "c3", res.Hits[0].ID)
+
+	res, err = svc.Search(Request{SiteID: "site1", Query: `+run -post`})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res.Hits))
+	assert.Equal(t, "c1", res.Hits[0].ID)
+
+	res, err = svc.Search(Request{SiteID: "site2", Query: "running"})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), res.Total, "only comments of the site matched")
+	assert.Equal(t, "c4", res.Hits[0].ID)
+
+	// update and delete
+	c := testComments()[0]
+	c.Text = "<p>completely different</p>"
+	require.NoError(t, svc.Index(c))
+	c3 := testComments()[2]
+	c3.Deleted = true
+	require.NoError(t, svc.Index(c3))
+	res, err = svc.Search(Request{SiteID: "site1", Query: "running"})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), res.Total)
+
+	_, err = svc.Search(Request{SiteID: "bad", Query: "running"})
+	assert.EqualError(t, err, "site bad not found")
+	_, err = svc.Search(Request{SiteID: "site1", Query: "text:"})
+	assert.Error(t, err)
+}
+
+func TestService_DeleteByField(t *testing.T) {
+	svc, err := NewService([]string{"site1"}, Params{})
+	require.NoError(t, err)
+	defer svc.Close()
+
+	comments := []store.Comment{}
+	for i := 0; i < 10; i++ {
+		comments = append(comments, store.Comment{ID: fmt.Sprintf("id-%d", i), Text: "message",
+			Locator: store.Locator{SiteID: "site1", URL: fmt.Sprintf("https://example.com/%d", i%2)},
+			User:    store.User{ID: fmt.Sprintf("user%d", i%3)}, Timestamp: time.Now()})
+	}
+	require.NoError(t, svc.IndexBatch(comments))
+	count := func() uint64 {
+		res, e := svc.Search(Request{SiteID: "site1", Query: "message"})
+		require.NoError(t, e)
+		return res.Total
+	}
+	assert.Equal(t, uint64(10), count())
+
+	require.NoError(t, svc.DeletePost(store.Locator{SiteID: "site1", URL: "https://example.com/1"}))
+	assert.Equal(t, uint64(5), count())
+
+	require.NoError(t, svc.DeleteUser("site1", "user0")) // id-0, id-6 left from user0
+	assert.Equal(t, uint64(3), count())
+
+	require.NoError(t, svc.Delete("site1", "id-2"))
+	assert.Equal(t, uint64(2), count())
+
+	require.NoError(t, svc.Clear("site1"))
+	assert.Equal(t, uint64(0), count())
+	assert.EqualError(t, svc.Clear("bad"), "site bad not found")
+}
+
+func TestService_Persistent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "search")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	svc, err := NewService([]string{"site1"}, Params{IndexPath: dir})
+	require.NoError(t, err)
+	require.NoError(t, svc.IndexBatch(testComments()[:2]))
+	require.NoError(t, svc.Close())
+
+	svc, err = NewService([]string{"site1"}, Params{IndexPath: dir})
+	require.NoError(t, err)
+	res, err := svc.Search(Request{SiteID: "site1", Query: "tests"})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), res.Total)
+
+	require.NoError(t, svc.Clear("site1"))
+	res, err = svc.Search(Request{SiteID: "site1", Query: "tests"})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), res.Total)
+	require.NoError(t, svc.Close())
+
+	_, err = NewService([]string{"site1"}, Params{Analyzer: "bad"})
+	assert.Error(t, err)
+}
+
+func TestService_Nil(t *testing.T) {
+	var svc *Service
+	_, err := svc.Search(Request{SiteID: "site1", Query: "test"})
+	assert.EqualError(t, err, "search disabled")
+	assert.EqualError(t, svc.Index(store.Comment{ID: "c1"}), "search disabled")
+}
+
+func TestService_SearchLabel(t *testing.T) {
+	svc, err := NewService([]string{"site1", "site2"}, Params{Analyzer: "en"})
+	require.NoError(t, err)
+	defer svc.Close()
+
+	comments := testComments()
+	comments[0].Labels = []string{"bug-report", "question"}
+	comments[2].Labels = []string{"question"}
+	for _, c := range comments {
+		require.NoError(t, svc.Index(c))
+	}
+
+	res, err := svc.Search(Request{SiteID: "site1", Label: "question", SortBy: "time"})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(res.Hits), "empty query matches all labeled")
+	assert.Equal(t, "c1", res.Hits[0].ID)
+	assert.Equal(t, "c3", res.Hits[1].ID)
+
+	res, err = svc.Search(Request{SiteID: "site1", Label: "question", Query: "post"})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res.Hits))
+	assert.Equal(t, "c3", res.Hits[0].ID)
+
+	res, err = svc.Search(Request{SiteID: "site1", Query: "label:bug-report"})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res.Hits), "label in query string")
+	assert.Equal(t, "c1", res.Hits[0].ID)
+
+	res, err = svc.Search(Request{SiteID: "site1", Label: "testimonial"})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), res.Total)
+}
+
+func TestService_Similar(t *testing.T) {
+	svc, err := NewService([]string{"site1", "site2"}, Params{Analyzer: "en"})
+	require.NoError(t, err)
+	defer svc.Close()
+
+	for _, c := range testComments() {
+		require.NoError(t, svc.Index(c))
+	}
+	require.NoError(t, svc.Index(store.Comment{ID: "c5", Text: "<p>run tests with coverage</p>",
+		Locator: store.Locator{SiteID: "site1", URL: "https://example.com/post1"}}))
+
+	res, err := svc.Similar(SimilarRequest{SiteID: "site1", URL: "https://example.com/post1", Text: "How can I run **tests** with coverage?"})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), res.Total, "only comments of the post")
+	assert.Equal(t, "c5", res.Hits[0].ID, "most similar first")
+	assert.Equal(t, "c1", res.Hits[1].ID)
+	assert.Equal(t, "https://example.com/post1", res.Hits[0].URL)
+	assert.NotEmpty(t, res.Hits[0].Highlights)
+
+	res, err = svc.Similar(SimilarRequest{SiteID: "site1", URL: "https://example.com/post1", Text: "tests", Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), res.Total)
+	assert.Equal(t, 1, len(res.Hits))
+
+	res, err = svc.Similar(SimilarRequest{SiteID: "site1", URL: "https://example.com/post1", Text: "+text: -tests"})
+	require.NoError(t, err, "query string syntax not applied")
+	assert.Equal(t, uint64(2), res.Total)
+
+	res, err = svc.Similar(SimilarRequest{SiteID: "site1", URL: "https://example.com/post1", Text: " <p></p> "})
+	require.NoError(t, err)
+	assert.Equal(t, Result{Hits: []Hit{}}, res, "empty draft")
+
+	_, err = svc.Similar(SimilarRequest{SiteID: "bad", URL: "https://example.com/post1", Text: "tests"})
+	assert.EqualError(t, err, "site bad not found")
+}
+
+func testComments() []store.Comment {
+	ts := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	return []store.Comment{
+		{ID: "c1", Text: "<p>Some <b>runs</b> &amp; tests</p>", Timestamp: ts,
+			Locator: store.Locator{SiteID: "site1", URL: "https://example.com/post1"}, User: store.User{ID: "u1"}},
+		{ID: "c2", Text: "<p>nothing interesting</p>", Timestamp: ts.Add(time.Minute),
+			Locator: store.Locator{SiteID: "site1", URL: "https://example.com/post1"}, User: store.User{ID: "u2"}},
+		{ID: "c3", Text: "<p>running the post</p>", Timestamp: ts.Add(2 * time.Minute),
+			Locator: store.Locator{SiteID: "site1", URL: "https://example.com/post2"}, User: store.User{ID: "u1"}},
+		{ID: "c4", Text: "<p>running on another site</p>", Timestamp: ts.Add(3 * time.Minute),
+			Locator: store.Locator{SiteID: "site2", URL: "https://example.com/post1"}, User: store.User{ID: "u1"}},
+	}
+}
+
+func hitByID(res Result, id string) Hit {
+	for _, h := range res.Hits {
+		if h.ID == id {
+			return h
+		}
+	}
+	return Hit{}
+}
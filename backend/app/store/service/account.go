@@ -0,0 +1,123 @@
+package service
+
+import (
+	"strings"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+	"github.com/umputun/remark42/backend/app/store/search"
+)
+
+// deletedUserName is a name of anonymous user owning comments of deleted accounts
+const deletedUserName = "deleted user"
+
+// UserVote is a vote of the user for the comment
+type UserVote struct {
+	Locator   store.Locator `json:"locator"`
+	CommentID string        `json:"comment_id"`
+	Value     bool          `json:"value"` // true for upvote
+}
+
+// UserData is personal data kept about the user on the site, except comments, for export by user's request
+type UserData struct {
+	Details engine.UserDetailEntry `json:"details"` // email, consent and profile
+	Votes   []UserVote             `json:"votes"`
+}
+
+// UserData returns stored details and all votes of the user
+func (s *DataStore) UserData(siteID, userID string) (res UserData, err error) {
+	res = UserData{Details: engine.UserDetailEntry{UserID: userID}, Votes: []UserVote{}}
+	for _, detail := range []engine.UserDetail{engine.UserEmail, engine.UserConsent, engine.UserProfile} {
+		entries, e := s.Engine.UserDetail(engine.UserDetailRequest{Detail: detail, Locator: store.Locator{SiteID: siteID}, UserID: userID})
+		if e != nil {
+			return res, errors.Wrapf(e, "can't get %s of %s", detail, userID)
+		}
+		if len(entries) == 0 {
+			continue
+		}
+		switch detail {
+		case engine.UserEmail:
+			res.Details.Email = entries[0].Email
+		case engine.UserConsent:
+			res.Details.Consent, res.Details.ConsentTime = entries[0].Consent, entries[0].ConsentTime
+		case engine.UserProfile:
+			res.Details.Profile = entries[0].Profile
+		}
+	}
+
+	posts, err := s.Engine.Info(engine.InfoRequest{Locator: store.Locator{SiteID: siteID}})
+	if err != nil {
+		return res, errors.Wrapf(err, "can't get posts of %s", siteID)
+	}
+	for _, p := range posts {
+		comments, e := s.Engine.Find(engine.FindRequest{Locator: store.Locator{SiteID: siteID, URL: p.URL}, Sort: "time"})
+		if e != nil {
+			return res, errors.Wrapf(e, "can't get comments of %s", p.URL)
+		}
+		for _, c := range comments {
+			if v, ok := c.Votes[userID]; ok {
+				res.Votes = append(res.Votes, UserVote{Locator: c.Locator, CommentID: c.ID, Value: v})
+			}
+		}
+	}
+	return res, nil
+}
+
+// AnonymizeUser removes personal data of the user from the site. Comments kept, re-attributed to a new anonymous
+// user with all other user's fields cleared, votes moved to the same anonymous user, and user details
+// (email, consent, profile) removed. Returns id of the anonymous user.
+func (s *DataStore) AnonymizeUser(siteID, userID string) (string, error) {
+	anon := store.User{ID: "deleted_" + store.EncodeID(uuid.New().String()), Name: deletedUserName}
+
+	req := engine.ReattributeRequest{Locator: store.Locator{SiteID: siteID}, FromID: userID, To: anon, Anonymize: true}
+	ids, err := s.Engine.Reattribute(req)
+	if err != nil {
+		// engines report user without comments as error, re-checked to tell it from failed reattribute
+		left, e := s.Engine.Find(engine.FindRequest{Locator: store.Locator{SiteID: siteID}, UserID: userID, Limit: 1})
+		if e == nil && len(left) > 0 {
+			return "", errors.Wrapf(err, "can't anonymize comments of %s", userID)
+		}
+		ids = []string{}
+	}
+
+	votes, err := s.reattributeVotes(siteID, userID, anon.ID, false)
+	if err != nil {
+		return "", err
+	}
+	if err = s.DeleteUserDetail(siteID, userID, engine.AllUserDetails); err != nil {
+		return "", errors.Wrapf(err, "can't delete details of %s", userID)
+	}
+
+	s.updateSearchIndex(func(svc *search.Service) error { return svc.DeleteUser(siteID, userID) })
+	s.reindexUser(siteID, anon.ID)
+	log.Printf("[INFO] audit: user %s of %s anonymized as %s, %d comments, %d votes", userID, siteID, anon.ID,
+		len(ids), len(votes))
+	return anon.ID, nil
+}
+
+// AnonymizeComments re-attributes comments of the user with given ids to a new anonymous user, with all other
+// user's fields cleared. Other comments, votes and details of the user kept. Returns id of the anonymous user.
+func (s *DataStore) AnonymizeComments(siteID, userID string, ids []string) (string, error) {
+	if len(ids) == 0 {
+		return "", errors.New("no comments to anonymize")
+	}
+	anon := store.User{ID: "deleted_" + store.EncodeID(uuid.New().String()), Name: deletedUserName}
+	req := engine.ReattributeRequest{Locator: store.Locator{SiteID: siteID}, FromID: userID, To: anon, Anonymize: true,
+		IDs: ids}
+	moved, err := s.Engine.Reattribute(req)
+	if err != nil {
+		return "", errors.Wrapf(err, "can't anonymize comments of %s", userID)
+	}
+	s.reindexUser(siteID, anon.ID)
+	log.Printf("[INFO] audit: %d comments of user %s of %s anonymized as %s", len(moved), userID, siteID, anon.ID)
+	return anon.ID, nil
+}
+
+// IsAnonymized checks if the user is an anonymous user made by AnonymizeUser or AnonymizeComments
+func IsAnonymized(user store.User) bool {
+	return strings.HasPrefix(user.ID, "deleted_")
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+func TestService_UserDataAndAnonymize(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), MaxVotes: -1,
+		ConsentVersions: map[string]string{"radio-t": "v1"}}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	// user1 has two comments from prepStoreEngine and votes for the comment of user2
+	id3, err := b.Create(store.Comment{Text: "user2 comment", Locator: locator, User: store.User{ID: "user2", Name: "user2"}})
+	require.NoError(t, err)
+	_, err = b.Vote(VoteReq{Locator: locator, CommentID: id3, UserID: "user1", UserIP: "1", Val: true})
+	require.NoError(t, err)
+	_, err = b.Vote(VoteReq{Locator: locator, CommentID: "id-1", UserID: "user2", UserIP: "2", Val: false})
+	require.NoError(t, err)
+	_, err = b.SetUserEmail("radio-t", "user1", "user1@example.com")
+	require.NoError(t, err)
+	_, err = b.SetUserConsent("radio-t", "user1", "v1")
+	require.NoError(t, err)
+
+	data, err := b.UserData("radio-t", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, "user1", data.Details.UserID)
+	assert.Equal(t, "user1@example.com", data.Details.Email)
+	assert.Equal(t, "v1", data.Details.Consent)
+	assert.NotNil(t, data.Details.ConsentTime)
+	assert.Equal(t, []UserVote{{Locator: locator, CommentID: id3, Value: true}}, data.Votes)
+
+	anonID, err := b.AnonymizeUser("radio-t", "user1")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(anonID, "deleted_"), anonID)
+
+	c, err := b.Engine.Get(engine.GetRequest{Locator: locator, CommentID: "id-1"})
+	require.NoError(t, err)
+	assert.Equal(t, store.User{ID: anonID, Name: "deleted user"}, c.User, "comment anonymized")
+	assert.Equal(t, "some text, <a href=\"http://radio-t.com\">link</a>", c.Text, "text kept")
+	assert.Equal(t, -1, c.Score, "votes for the comment kept")
+	c, err = b.Engine.Get(engine.GetRequest{Locator: locator, CommentID: id3})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{anonID: true}, c.Votes, "vote moved to anonymous user")
+	assert.Equal(t, 1, c.Score)
+
+	data, err = b.UserData("radio-t", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, UserData{Details: engine.UserDetailEntry{UserID: "user1"}, Votes: []UserVote{}}, data, "nothing left")
+	_, err = b.Engine.Find(engine.FindRequest{Locator: store.Locator{SiteID: "radio-t"}, UserID: "user1"})
+	assert.Error(t, err, "no comments of user1")
+
+	anonID2, err := b.AnonymizeUser("radio-t", "user5")
+	require.NoError(t, err, "user without comments")
+	assert.NotEqual(t, anonID, anonID2)
+}
+
+func TestService_AnonymizeComments(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), MaxVotes: -1}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	_, err := b.SetUserEmail("radio-t", "user1", "user1@example.com")
+	require.NoError(t, err)
+
+	anonID, err := b.AnonymizeComments("radio-t", "user1", []string{"id-1"})
+	require.NoError(t, err)
+	assert.True(t, IsAnonymized(store.User{ID: anonID}), anonID)
+
+	c, err := b.Engine.Get(engine.GetRequest{Locator: locator, CommentID: "id-1"})
+	require.NoError(t, err)
+	assert.Equal(t, store.User{ID: anonID, Name: "deleted user"}, c.User, "comment anonymized")
+	c, err = b.Engine.Get(engine.GetRequest{Locator: locator, CommentID: "id-2"})
+	require.NoError(t, err)
+	assert.Equal(t, "user1", c.User.ID, "other comment kept")
+	assert.False(t, IsAnonymized(c.User))
+	email, err := b.GetUserEmail("radio-t", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, "user1@example.com", email, "details kept")
+
+	_, err = b.AnonymizeComments("radio-t", "user1", nil)
+	assert.EqualError(t, err, "no comments to anonymize")
+	_, err = b.AnonymizeComments("radio-t", "user100", []string{"id-2"})
+	assert.Error(t, err)
+}
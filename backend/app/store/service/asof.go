@@ -0,0 +1,53 @@
+package service
+
+import (
+	"time"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// FindAsOf returns comments of the post as they were at asOf time, for moderators reviewing disputes about
+// edited comments. Comments created later skipped, edits made later rolled back with edit history and votes
+// made later subtracted from the score. Comments deleted later shown with deletion time, their text is not kept.
+// Text of comments edited with history disabled can't be restored, it is the current one with edit time after asOf.
+func (s *DataStore) FindAsOf(locator store.Locator, sortMethod string, asOf time.Time) ([]store.Comment, error) {
+	comments, err := s.Engine.Find(engine.FindRequest{Locator: locator, Sort: sortMethod})
+	if err != nil {
+		return nil, err
+	}
+	res := make([]store.Comment, 0, len(comments))
+	for _, c := range comments {
+		if c.Timestamp.After(asOf) {
+			continue
+		}
+		res = append(res, commentAsOf(c, asOf))
+	}
+	return s.alterComments(res, store.User{Admin: true}), nil
+}
+
+// commentAsOf restores text, edit and score of the comment at asOf time
+func commentAsOf(c store.Comment, asOf time.Time) store.Comment {
+	for i, r := range c.Revisions {
+		if !r.Timestamp.After(asOf) {
+			continue
+		}
+		// the first revision replaced after asOf keeps the text shown at asOf
+		c.Text, c.Orig, c.Edit = r.Text, r.Orig, nil
+		if i > 0 {
+			c.Edit = &store.Edit{Timestamp: c.Revisions[i-1].Timestamp, Summary: c.Revisions[i-1].Summary}
+		}
+		break
+	}
+	for _, v := range c.VotedIPs {
+		if !v.Timestamp.After(asOf) {
+			continue
+		}
+		if v.Value {
+			c.Score--
+			continue
+		}
+		c.Score++
+	}
+	return c
+}
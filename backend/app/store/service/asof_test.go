@@ -0,0 +1,67 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestService_FindAsOf(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), MaxRevisions: 10,
+		MaxVotes: -1}
+	defer b.Close()
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	id, err := b.Create(store.Comment{Text: "<p>original</p>", Orig: "original", Locator: locator, User: store.User{ID: "user2"}})
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	_, err = b.EditComment(locator, id, EditRequest{Orig: "first edit", Text: "<p>first edit</p>", Summary: "typo", UserID: "user2"})
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	afterFirstEdit := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	_, err = b.EditComment(locator, id, EditRequest{Orig: "second edit", Text: "<p>second edit</p>", UserID: "user2"})
+	require.NoError(t, err)
+	_, err = b.Vote(VoteReq{Locator: locator, CommentID: id, UserID: "user3", UserIP: "127.0.0.1", Val: true})
+	require.NoError(t, err)
+	id2, err := b.Create(store.Comment{Text: "later", Locator: locator, User: store.User{ID: "user3"}})
+	require.NoError(t, err)
+	require.NoError(t, b.Delete(locator, "id-2", store.SoftDelete))
+
+	res, err := b.FindAsOf(locator, "time", afterFirstEdit)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(res), "comment created later skipped")
+	assert.Equal(t, "id-1", res[0].ID)
+	assert.Equal(t, "id-2", res[1].ID)
+	assert.True(t, res[1].Deleted)
+	require.NotNil(t, res[1].DeletedAt)
+	assert.True(t, res[1].DeletedAt.After(afterFirstEdit), "deleted later")
+	assert.Equal(t, id, res[2].ID)
+	assert.Equal(t, "<p>first edit</p>", res[2].Text)
+	assert.Equal(t, "first edit", res[2].Orig)
+	require.NotNil(t, res[2].Edit)
+	assert.Equal(t, "typo", res[2].Edit.Summary)
+	assert.Equal(t, 0, res[2].Score, "later vote not counted")
+	assert.Nil(t, res[2].Revisions, "revisions hidden")
+
+	res, err = b.FindAsOf(locator, "time", afterFirstEdit.Add(-15*time.Millisecond))
+	require.NoError(t, err)
+	require.Equal(t, 3, len(res))
+	assert.Equal(t, "original", res[2].Orig)
+	assert.Nil(t, res[2].Edit, "not edited yet")
+
+	res, err = b.FindAsOf(locator, "time", time.Now())
+	require.NoError(t, err)
+	require.Equal(t, 4, len(res))
+	assert.Equal(t, "second edit", res[2].Orig)
+	assert.Equal(t, 1, res[2].Score)
+	assert.Equal(t, id2, res[3].ID)
+}
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"math"
+	"time"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// ScorePolicy defines how comments collapsed and hidden by community votes. Thresholds are per-site,
+// negative score decays with age of the comment, so downvoted comments restored over time.
+type ScorePolicy struct {
+	Thresholds     func(siteID string) (low, critical int) // score thresholds of the site
+	HalfLife       time.Duration                           // half-life of negative score, 0 disables decay
+	ExemptVerified bool                                    // comments of verified users never collapsed or hidden
+}
+
+// state returns community state of the comment, empty if comment shown as is
+func (p *ScorePolicy) state(c store.Comment, now time.Time) string {
+	if p == nil || p.Thresholds == nil || c.Deleted || c.Score >= 0 {
+		return ""
+	}
+	if p.ExemptVerified && c.User.Verified {
+		return ""
+	}
+
+	score := float64(c.Score)
+	if age := now.Sub(c.Timestamp); p.HalfLife > 0 && age > 0 {
+		score *= math.Exp2(-float64(age) / float64(p.HalfLife))
+	}
+	low, critical := p.Thresholds(c.Locator.SiteID)
+	switch {
+	case score <= float64(critical):
+		return store.CommunityHidden
+	case score <= float64(low):
+		return store.CommunityCollapsed
+	}
+	return ""
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestScorePolicy_State(t *testing.T) {
+	now := time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC)
+	thresholds := func(siteID string) (low, critical int) {
+		if siteID == "strict" {
+			return -1, -2
+		}
+		return -5, -10
+	}
+	p := &ScorePolicy{Thresholds: thresholds, HalfLife: 24 * time.Hour, ExemptVerified: true}
+
+	tbl := []struct {
+		name string
+		c    store.Comment
+		res  string
+	}{
+		{name: "positive", c: store.Comment{Score: 5, Timestamp: now}},
+		{name: "above low", c: store.Comment{Score: -4, Timestamp: now}},
+		{name: "low", c: store.Comment{Score: -5, Timestamp: now}, res: store.CommunityCollapsed},
+		{name: "critical", c: store.Comment{Score: -10, Timestamp: now}, res: store.CommunityHidden},
+		{name: "per-site thresholds", c: store.Comment{Score: -2, Timestamp: now, Locator: store.Locator{SiteID: "strict"}},
+			res: store.CommunityHidden},
+		{name: "decayed to low", c: store.Comment{Score: -12, Timestamp: now.Add(-24 * time.Hour)}, res: store.CommunityCollapsed},
+		{name: "decayed to normal", c: store.Comment{Score: -12, Timestamp: now.Add(-48 * time.Hour)}},
+		{name: "verified exempt", c: store.Comment{Score: -20, Timestamp: now, User: store.User{Verified: true}}},
+		{name: "deleted", c: store.Comment{Score: -20, Timestamp: now, Deleted: true}},
+	}
+	for _, tt := range tbl {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.res, p.state(tt.c, now))
+		})
+	}
+
+	p = &ScorePolicy{Thresholds: thresholds}
+	assert.Equal(t, store.CommunityHidden, p.state(store.Comment{Score: -20, User: store.User{Verified: true}}, now),
+		"no decay and exemptions")
+	p = nil
+	assert.Equal(t, "", p.state(store.Comment{Score: -20}, now), "no policy")
+}
+
+func TestService_CommunityState(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), MaxVotes: -1,
+		ScorePolicy: &ScorePolicy{Thresholds: func(string) (int, int) { return -1, -2 }}}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	id, err := b.Create(store.Comment{Text: "some comment", Locator: locator, User: store.User{ID: "user2"}})
+	require.NoError(t, err)
+	_, err = b.Vote(VoteReq{Locator: locator, CommentID: id, UserID: "user3", Val: false})
+	require.NoError(t, err)
+	c, err := b.Get(locator, id, store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, store.CommunityCollapsed, c.Community)
+
+	_, err = b.Vote(VoteReq{Locator: locator, CommentID: id, UserID: "user4", Val: false})
+	require.NoError(t, err)
+	res, err := b.Find(locator, "time", store.User{})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(res))
+	assert.Equal(t, store.CommunityHidden, res[2].Community)
+	assert.Equal(t, "", res[0].Community)
+
+	b.ScorePolicy.ExemptVerified = true
+	require.NoError(t, b.SetVerified("radio-t", "user2", true))
+	c, err = b.Get(locator, id, store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, "", c.Community, "verified user exempt")
+}
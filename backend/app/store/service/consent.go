@@ -0,0 +1,75 @@
+package service
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// ConsentVersion returns version of legal terms users of the site should accept before commenting, empty if not required
+func (s *DataStore) ConsentVersion(siteID string) string {
+	return s.ConsentVersions[siteID]
+}
+
+// HasConsent checks if user accepted the current version of site's legal terms, always true if consent not required
+func (s *DataStore) HasConsent(siteID, userID string) (bool, error) {
+	version := s.ConsentVersion(siteID)
+	if version == "" {
+		return true, nil
+	}
+	consent, err := s.GetUserConsent(siteID, userID)
+	if err != nil {
+		return false, err
+	}
+	return consent.Consent == version, nil
+}
+
+// GetUserConsent gets version and time of user's consent, empty if user never accepted legal terms
+func (s *DataStore) GetUserConsent(siteID, userID string) (engine.UserDetailEntry, error) {
+	res, err := s.Engine.UserDetail(engine.UserDetailRequest{Detail: engine.UserConsent,
+		Locator: store.Locator{SiteID: siteID}, UserID: userID})
+	if err != nil {
+		return engine.UserDetailEntry{}, err
+	}
+	if len(res) == 1 {
+		return res[0], nil
+	}
+	return engine.UserDetailEntry{UserID: userID}, nil
+}
+
+// SetUserConsent records user's consent with the current version of site's legal terms
+func (s *DataStore) SetUserConsent(siteID, userID, version string) (engine.UserDetailEntry, error) {
+	if current := s.ConsentVersion(siteID); current == "" || version != current {
+		return engine.UserDetailEntry{}, errors.Errorf("consent version %q doesn't match required %q", version, current)
+	}
+	ts := time.Now()
+	res, err := s.Engine.UserDetail(engine.UserDetailRequest{Detail: engine.UserConsent,
+		Locator: store.Locator{SiteID: siteID}, UserID: userID, Update: version, Time: &ts})
+	if err != nil {
+		return engine.UserDetailEntry{}, err
+	}
+	if len(res) != 1 {
+		return engine.UserDetailEntry{}, errors.Errorf("no consent set for %s", userID)
+	}
+	return engine.UserDetailEntry{UserID: userID, Consent: res[0].Consent, ConsentTime: res[0].ConsentTime}, nil
+}
+
+// Consents lists consents of all users of the site, with any version of legal terms
+func (s *DataStore) Consents(siteID string) ([]engine.UserDetailEntry, error) {
+	details, err := s.Engine.UserDetail(engine.UserDetailRequest{Detail: engine.AllUserDetails,
+		Locator: store.Locator{SiteID: siteID}})
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't list user details for %s", siteID)
+	}
+	res := []engine.UserDetailEntry{}
+	for _, d := range details {
+		if d.Consent == "" {
+			continue
+		}
+		res = append(res, engine.UserDetailEntry{UserID: d.UserID, Consent: d.Consent, ConsentTime: d.ConsentTime})
+	}
+	return res, nil
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+func TestService_Consent(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+
+	ok, err := b.HasConsent("radio-t", "user1")
+	require.NoError(t, err)
+	assert.True(t, ok, "consent not required")
+	_, err = b.SetUserConsent("radio-t", "user1", "v1")
+	assert.EqualError(t, err, `consent version "v1" doesn't match required ""`)
+
+	b.ConsentVersions = map[string]string{"radio-t": "v1"}
+	assert.Equal(t, "v1", b.ConsentVersion("radio-t"))
+	ok, err = b.HasConsent("radio-t", "user1")
+	require.NoError(t, err)
+	assert.False(t, ok, "no consent yet")
+
+	_, err = b.SetUserConsent("radio-t", "user1", "v0")
+	assert.EqualError(t, err, `consent version "v0" doesn't match required "v1"`)
+	consent, err := b.SetUserConsent("radio-t", "user1", "v1")
+	require.NoError(t, err)
+	assert.Equal(t, "v1", consent.Consent)
+	require.NotNil(t, consent.ConsentTime)
+	assert.WithinDuration(t, time.Now(), *consent.ConsentTime, time.Second)
+	_, err = b.SetUserEmail("radio-t", "user2", "user2@example.com")
+	require.NoError(t, err)
+
+	ok, err = b.HasConsent("radio-t", "user1")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	consent, err = b.GetUserConsent("radio-t", "user2")
+	require.NoError(t, err)
+	assert.Equal(t, engine.UserDetailEntry{UserID: "user2"}, consent)
+
+	consents, err := b.Consents("radio-t")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(consents), "user without consent skipped")
+	assert.Equal(t, "user1", consents[0].UserID)
+	assert.Equal(t, "", consents[0].Email, "only consent listed")
+
+	b.ConsentVersions["radio-t"] = "v2"
+	ok, err = b.HasConsent("radio-t", "user1")
+	require.NoError(t, err)
+	assert.False(t, ok, "new version of terms should be accepted again")
+
+	_, err = b.Consents("bad")
+	assert.Error(t, err)
+}
+
+func TestService_ConsentMetas(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+
+	ts := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	um := UserMetaData{ID: "user1", Details: engine.UserDetailEntry{UserID: "user1", Consent: "v1", ConsentTime: &ts}}
+	require.NoError(t, b.SetMetas("radio-t", []UserMetaData{um}, nil))
+
+	umetas, _, err := b.Metas("radio-t")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(umetas))
+	assert.Equal(t, um.Details, umetas[0].Details, "consent restored with original time")
+}
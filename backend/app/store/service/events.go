@@ -0,0 +1,23 @@
+package service
+
+import (
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/events"
+)
+
+// publish sends event of the comment to Events bus, if set. Comment prepared for public view.
+func (s *DataStore) publish(kind events.Kind, comment store.Comment) {
+	if s.Events == nil {
+		return
+	}
+	if kind == events.Deleted { // only id and locator of deleted comment sent
+		comment = store.Comment{ID: comment.ID, Locator: comment.Locator, Deleted: true}
+	}
+	s.Events.Publish(events.Event{Kind: kind, Comment: s.alterComment(comment, nonAdminUser)})
+}
+
+// Visible checks if the comment shown to the user, i.e. it is not pending, not delayed by slow mode of the post
+// and not made by shadow-banned user
+func (s *DataStore) Visible(comment store.Comment, user store.User) bool {
+	return len(s.hideShadowBanned(s.hideDelayed(hidePending([]store.Comment{comment}, user), user), user)) == 1
+}
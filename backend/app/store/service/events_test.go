@@ -0,0 +1,79 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/events"
+)
+
+func TestService_Events(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	bus := events.NewBus(0)
+	defer bus.Close()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), Events: bus}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	evCh, unsubscribe, err := bus.Subscribe(locator)
+	require.NoError(t, err)
+	defer unsubscribe()
+
+	id, err := b.Create(store.Comment{Text: "new comment", Locator: locator, User: store.User{ID: "user2", IP: "127.0.0.1"}})
+	require.NoError(t, err)
+	ev := <-evCh
+	assert.Equal(t, events.Created, ev.Kind)
+	assert.Equal(t, id, ev.Comment.ID)
+	assert.Equal(t, "new comment", ev.Comment.Text)
+	assert.Equal(t, "", ev.Comment.User.IP, "ip hidden")
+
+	_, err = b.EditComment(locator, id, EditRequest{Text: "edited text", Orig: "edited text"})
+	require.NoError(t, err)
+	ev = <-evCh
+	assert.Equal(t, events.Updated, ev.Kind)
+	assert.Equal(t, "edited text", ev.Comment.Text)
+	assert.Nil(t, ev.Comment.Revisions)
+
+	require.NoError(t, b.SetPending(locator, id, true))
+	ev = <-evCh
+	assert.Equal(t, events.Updated, ev.Kind)
+	assert.True(t, ev.Comment.Pending)
+
+	require.NoError(t, b.Delete(locator, id, store.SoftDelete))
+	ev = <-evCh
+	assert.Equal(t, events.Event{Kind: events.Deleted, Comment: store.Comment{ID: id, Locator: locator, Deleted: true}}, ev)
+
+	_, err = b.EditComment(locator, "id-2", EditRequest{Delete: true})
+	require.NoError(t, err)
+	ev = <-evCh
+	assert.Equal(t, events.Deleted, ev.Kind)
+	assert.Equal(t, "id-2", ev.Comment.ID)
+	assert.Equal(t, "", ev.Comment.Text)
+	assert.Empty(t, evCh)
+}
+
+func TestService_Visible(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), SlowModeDelay: time.Minute}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	c := store.Comment{ID: "c1", Locator: locator, User: store.User{ID: "user2"}, Timestamp: time.Now()}
+	assert.True(t, b.Visible(c, store.User{}))
+	c.Pending = true
+	assert.False(t, b.Visible(c, store.User{}))
+	assert.False(t, b.Visible(c, store.User{ID: "user1"}))
+	assert.True(t, b.Visible(c, store.User{ID: "user2"}), "visible for author")
+	assert.True(t, b.Visible(c, store.User{ID: "admin", Admin: true}), "visible for admin")
+
+	c.Pending = false
+	require.NoError(t, b.SetSlowMode(locator, true))
+	assert.False(t, b.Visible(c, store.User{}), "delayed in slow mode")
+	assert.True(t, b.Visible(c, store.User{ID: "user2"}))
+	c.Timestamp = time.Now().Add(-2 * time.Minute)
+	assert.True(t, b.Visible(c, store.User{}))
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// CommentStatus is moderation status of comment
+type CommentStatus string
+
+// enum of all comment statuses
+const (
+	StatusPublished CommentStatus = "published" // neither pending nor deleted
+	StatusPending   CommentStatus = "pending"   // held for moderation
+	StatusDeleted   CommentStatus = "deleted"
+	StatusReported  CommentStatus = "reported" // not deleted, reported by users
+)
+
+// Valid checks if the status is one of known statuses
+func (s CommentStatus) Valid() bool {
+	return s == StatusPublished || s == StatusPending || s == StatusDeleted || s == StatusReported
+}
+
+// StatusOf returns moderation status of the comment, reported comments are published or pending as well
+func StatusOf(c store.Comment) CommentStatus {
+	switch {
+	case c.Deleted:
+		return StatusDeleted
+	case c.Pending:
+		return StatusPending
+	}
+	return StatusPublished
+}
+
+// ExportFilter selects comments of the site for export, empty fields match all
+type ExportFilter struct {
+	UserID string
+	URL    string
+	From   time.Time     // created at or after
+	To     time.Time     // created before
+	Status CommentStatus // all comments, including deleted, if empty
+}
+
+// match checks if the comment matched by filter, locator and start of time range not checked
+func (f ExportFilter) match(c store.Comment) bool {
+	switch {
+	case f.UserID != "" && c.User.ID != f.UserID, !f.To.IsZero() && !c.Timestamp.Before(f.To):
+		return false
+	case f.Status == StatusReported:
+		return !c.Deleted && len(c.Reports) > 0
+	case f.Status != "":
+		return StatusOf(c) == f.Status
+	}
+	return true
+}
+
+// UserSummary is activity of the user in comments matched by export filter
+type UserSummary struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Comments int       `json:"comments"`
+	Pending  int       `json:"pending"`
+	Deleted  int       `json:"deleted"`
+	Reported int       `json:"reported"`
+	Score    int       `json:"score"`
+	First    time.Time `json:"first"`
+	Last     time.Time `json:"last"`
+	Verified bool      `json:"verified"`
+	Blocked  bool      `json:"blocked"`
+}
+
+// ExportComments passes comments of the site matched by filter to fn, ordered by url of post and by time in the post.
+// Comments loaded post by post, so the whole site isn't kept in memory. The order is stable, suitable for paging.
+// Error of fn stops the export and returned as is.
+func (s *DataStore) ExportComments(siteID string, filter ExportFilter, fn func(store.Comment) error) error {
+	urls := []string{filter.URL}
+	if filter.URL == "" {
+		posts, err := s.Engine.Info(engine.InfoRequest{Locator: store.Locator{SiteID: siteID}})
+		if err != nil {
+			return errors.Wrapf(err, "can't get posts of %s", siteID)
+		}
+		urls = make([]string, 0, len(posts))
+		for _, p := range posts {
+			if !filter.From.IsZero() && p.LastTS.Before(filter.From) {
+				continue
+			}
+			urls = append(urls, p.URL)
+		}
+		sort.Strings(urls)
+	}
+
+	for _, url := range urls {
+		req := engine.FindRequest{Locator: store.Locator{SiteID: siteID, URL: url}, Sort: "time"}
+		if !filter.From.IsZero() {
+			req.Since = filter.From.Add(-time.Nanosecond)
+		}
+		comments, err := s.Engine.Find(req)
+		if err != nil {
+			return errors.Wrapf(err, "can't get comments of %s", url)
+		}
+		for _, c := range comments {
+			if !filter.match(c) {
+				continue
+			}
+			if err = fn(c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ExportUsers returns summaries of users with comments matched by filter, sorted by user id.
+// Name and verified flag taken from the latest comment of the user.
+func (s *DataStore) ExportUsers(siteID string, filter ExportFilter) ([]UserSummary, error) {
+	users := map[string]*UserSummary{}
+	err := s.ExportComments(siteID, filter, func(c store.Comment) error {
+		u, ok := users[c.User.ID]
+		if !ok {
+			u = &UserSummary{ID: c.User.ID, First: c.Timestamp}
+			users[c.User.ID] = u
+		}
+		if !c.Timestamp.Before(u.Last) {
+			u.Name, u.Verified, u.Last = c.User.Name, c.User.Verified, c.Timestamp
+		}
+		if c.Timestamp.Before(u.First) {
+			u.First = c.Timestamp
+		}
+		u.Comments++
+		u.Score += c.Score
+		switch StatusOf(c) {
+		case StatusPending:
+			u.Pending++
+		case StatusDeleted:
+			u.Deleted++
+		}
+		if !c.Deleted && len(c.Reports) > 0 {
+			u.Reported++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]UserSummary, 0, len(users))
+	for _, u := range users {
+		u.Blocked = s.IsBlocked(siteID, u.ID)
+		res = append(res, *u)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res, nil
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestService_ExportComments(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+
+	// user1 has two comments of https://radio-t.com from prepStoreEngine
+	ts := time.Date(2018, 1, 1, 10, 0, 0, 0, time.UTC)
+	post2 := store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}
+	post1 := store.Locator{URL: "https://radio-t.com/1", SiteID: "radio-t"}
+	for _, c := range []store.Comment{
+		{ID: "id-3", Text: "pending", Locator: post2, User: store.User{ID: "user2"}, Timestamp: ts, Pending: true},
+		{ID: "id-4", Text: "deleted", Locator: post2, User: store.User{ID: "user2"}, Timestamp: ts.Add(time.Minute)},
+		{ID: "id-5", Text: "reported", Locator: post1, User: store.User{ID: "user3"}, Timestamp: ts.Add(time.Hour)},
+	} {
+		_, err := b.Create(c)
+		require.NoError(t, err)
+	}
+	require.NoError(t, b.Delete(post2, "id-4", store.SoftDelete))
+	_, err := b.Report(ReportReq{Locator: post1, CommentID: "id-5", UserID: "user1", Reason: "spam"})
+	require.NoError(t, err)
+
+	export := func(filter ExportFilter) (ids []string) {
+		ids = []string{}
+		err := b.ExportComments("radio-t", filter, func(c store.Comment) error {
+			ids = append(ids, c.ID)
+			return nil
+		})
+		require.NoError(t, err)
+		return ids
+	}
+	assert.Equal(t, []string{"id-1", "id-2", "id-5", "id-3", "id-4"}, export(ExportFilter{}), "ordered by post url and time")
+	assert.Equal(t, []string{"id-3", "id-4"}, export(ExportFilter{URL: "https://radio-t.com/2"}))
+	assert.Equal(t, []string{"id-3", "id-4"}, export(ExportFilter{UserID: "user2"}))
+	assert.Equal(t, []string{"id-5", "id-3", "id-4"}, export(ExportFilter{From: ts}))
+	assert.Equal(t, []string{"id-3"}, export(ExportFilter{From: ts, To: ts.Add(time.Minute)}))
+	assert.Equal(t, []string{"id-1", "id-2", "id-5"}, export(ExportFilter{Status: StatusPublished}))
+	assert.Equal(t, []string{"id-3"}, export(ExportFilter{Status: StatusPending}))
+	assert.Equal(t, []string{"id-4"}, export(ExportFilter{Status: StatusDeleted}))
+	assert.Equal(t, []string{"id-5"}, export(ExportFilter{Status: StatusReported}))
+
+	stop := errors.New("stop")
+	count := 0
+	err = b.ExportComments("radio-t", ExportFilter{}, func(c store.Comment) error {
+		count++
+		return stop
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestService_ExportUsers(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+
+	ts := time.Date(2018, 1, 1, 10, 0, 0, 0, time.UTC)
+	post := store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}
+	_, err := b.Create(store.Comment{ID: "id-3", Text: "pending", Locator: post, Timestamp: ts, Pending: true,
+		User: store.User{ID: "user1", Name: "new name", Verified: true}})
+	require.NoError(t, err)
+	_, err = b.Create(store.Comment{ID: "id-4", Text: "msg", Locator: post, User: store.User{ID: "user2"}, Timestamp: ts})
+	require.NoError(t, err)
+	require.NoError(t, b.SetBlock("radio-t", "user2", true, 0))
+
+	users, err := b.ExportUsers("radio-t", ExportFilter{})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(users))
+	assert.True(t, users[0].First.Equal(time.Date(2017, 12, 20, 15, 18, 22, 0, time.Local)), users[0].First)
+	assert.True(t, users[0].Last.Equal(ts), users[0].Last)
+	users[0].First, users[0].Last, users[1].First, users[1].Last = time.Time{}, time.Time{}, time.Time{}, time.Time{}
+	assert.Equal(t, UserSummary{ID: "user1", Name: "new name", Comments: 3, Pending: 1, Verified: true}, users[0])
+	assert.Equal(t, UserSummary{ID: "user2", Comments: 1, Blocked: true}, users[1])
+
+	users, err = b.ExportUsers("radio-t", ExportFilter{Status: StatusPending})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(users))
+	assert.Equal(t, "user1", users[0].ID)
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"unicode"
+
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// ErrExternalIDExists returned by Create if external id of the comment used by another comment of the site
+var ErrExternalIDExists = errors.New("external id already used")
+
+const maxExternalIDLen = 128
+
+// ExternalIDs defines unique index of external ids of comments, set by integrations to correlate comments
+// with records in their own systems, like CRM or ticket ids. Ids unique per site.
+type ExternalIDs interface {
+	Reserve(siteID, externalID string, locator store.Locator, commentID string) error // ErrExternalIDExists if used
+	Lookup(siteID, externalID string) (locator store.Locator, commentID string, err error)
+	Release(siteID, externalID string) error
+}
+
+// FindByExternalID returns comment with the external id, user used to alter comment like with Get
+func (s *DataStore) FindByExternalID(siteID, externalID string, user store.User) (store.Comment, error) {
+	if s.ExternalIDs == nil {
+		return store.Comment{}, errors.New("external ids disabled")
+	}
+	locator, commentID, err := s.ExternalIDs.Lookup(siteID, externalID)
+	if err != nil {
+		return store.Comment{}, err
+	}
+	return s.Get(locator, commentID, user)
+}
+
+// validateExternalID checks external id can be set, empty id is valid
+func (s *DataStore) validateExternalID(externalID string) error {
+	if externalID == "" {
+		return nil
+	}
+	if s.ExternalIDs == nil {
+		return errors.New("external ids disabled")
+	}
+	if len(externalID) > maxExternalIDLen {
+		return errors.Errorf("external id longer than %d", maxExternalIDLen)
+	}
+	for _, r := range externalID {
+		if !unicode.IsPrint(r) || unicode.IsSpace(r) {
+			return errors.Errorf("external id %q has spaces or non-printable characters", externalID)
+		}
+	}
+	return nil
+}
+
+// reserveExternalID adds external id of the new comment to the index, returns release func to call
+// if comment not created. Does nothing for comment without external id.
+func (s *DataStore) reserveExternalID(comment store.Comment) (release func(), err error) {
+	if comment.ExternalID == "" {
+		return func() {}, nil
+	}
+	if err = s.validateExternalID(comment.ExternalID); err != nil {
+		return nil, err
+	}
+	siteID := comment.Locator.SiteID
+	if err = s.ExternalIDs.Reserve(siteID, comment.ExternalID, comment.Locator, comment.ID); err != nil {
+		return nil, err
+	}
+	return func() { _ = s.ExternalIDs.Release(siteID, comment.ExternalID) }, nil
+}
+
+// EngineExternalIDs implements ExternalIDs with records of store engine, ids kept along with comments of the site
+type EngineExternalIDs struct {
+	records engine.Records // keyed by externalID
+}
+
+type externalRef struct {
+	Locator   store.Locator `json:"locator"`
+	CommentID string        `json:"comment_id"`
+}
+
+// NewEngineExternalIDs makes index of external ids kept by eng
+func NewEngineExternalIDs(eng engine.Interface) *EngineExternalIDs {
+	return &EngineExternalIDs{records: engine.Records{Engine: eng, Kind: engine.ExternalIDs}}
+}
+
+// Reserve adds external id of the comment, fails with ErrExternalIDExists if it's used by another comment
+func (e *EngineExternalIDs) Reserve(siteID, externalID string, locator store.Locator, commentID string) error {
+	added, err := e.records.Add(siteID, externalID, externalRef{Locator: locator, CommentID: commentID})
+	if err != nil {
+		return errors.Wrapf(err, "can't reserve external id %s", externalID)
+	}
+	if !added {
+		return ErrExternalIDExists
+	}
+	return nil
+}
+
+// Lookup returns locator and id of the comment with external id
+func (e *EngineExternalIDs) Lookup(siteID, externalID string) (locator store.Locator, commentID string, err error) {
+	ref := externalRef{}
+	found, err := e.records.Get(siteID, externalID, &ref)
+	if err != nil {
+		return store.Locator{}, "", err
+	}
+	if !found {
+		return store.Locator{}, "", errors.Errorf("no comment with external id %s", externalID)
+	}
+	return ref.Locator, ref.CommentID, nil
+}
+
+// Release removes external id, i.e. used by comment not created
+func (e *EngineExternalIDs) Release(siteID, externalID string) error {
+	_, err := e.records.Delete(siteID, externalID)
+	return err
+}
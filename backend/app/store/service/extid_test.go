@@ -0,0 +1,49 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestService_ExternalIDs(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	externalIDs := NewEngineExternalIDs(eng)
+	n := 0
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), MaxVotes: -1, ExternalIDs: externalIDs,
+		NewID: func() string { n++; return fmt.Sprintf("gen-%d", n) }}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	id, err := b.Create(store.Comment{Text: "from crm", Locator: locator, User: store.User{ID: "admin"}, ExternalID: "CRM-123"})
+	require.NoError(t, err)
+	assert.Equal(t, "gen-1", id, "id from generator")
+
+	_, err = b.Create(store.Comment{Text: "again", Locator: locator, User: store.User{ID: "admin"}, ExternalID: "CRM-123"})
+	assert.Equal(t, ErrExternalIDExists, err)
+	_, err = b.Create(store.Comment{ID: "gen-1", Text: "dup id", Locator: locator, User: store.User{ID: "admin"}, ExternalID: "CRM-124"})
+	assert.Error(t, err, "duplicate comment id")
+	_, _, err = externalIDs.Lookup("radio-t", "CRM-124")
+	assert.Error(t, err, "released for not created comment")
+	_, err = b.Create(store.Comment{Text: "bad", Locator: locator, User: store.User{ID: "admin"}, ExternalID: "CRM 1"})
+	assert.EqualError(t, err, `external id "CRM 1" has spaces or non-printable characters`)
+
+	c, err := b.FindByExternalID("radio-t", "CRM-123", store.User{Admin: true})
+	require.NoError(t, err)
+	assert.Equal(t, "gen-1", c.ID)
+	assert.Equal(t, "CRM-123", c.ExternalID)
+	c, err = b.Get(locator, "gen-1", store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, "", c.ExternalID, "hidden from users")
+	_, err = b.FindByExternalID("radio-t", "CRM-999", store.User{})
+	assert.EqualError(t, err, "no comment with external id CRM-999")
+
+	b.ExternalIDs = nil
+	assert.EqualError(t, b.ValidateComment(&store.Comment{Orig: "text", User: store.User{ID: "u", Name: "n"}, ExternalID: "x"}),
+		"external ids disabled")
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// HistoryEntry is an edit of the comment, with replaced text and diff made by the edit
+type HistoryEntry struct {
+	store.Revision
+	Diff string `json:"diff"` // unified diff of original text, from replaced one to the next revision
+}
+
+// History returns edits of the comment, the oldest first. Empty for comments never edited
+// or edited with history disabled.
+func (s *DataStore) History(locator store.Locator, commentID string) ([]HistoryEntry, error) {
+	c, err := s.Engine.Get(engine.GetRequest{Locator: locator, CommentID: commentID})
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]HistoryEntry, len(c.Revisions))
+	for i, r := range c.Revisions {
+		next := revisionText(c.Text, c.Orig)
+		if i < len(c.Revisions)-1 {
+			next = revisionText(c.Revisions[i+1].Text, c.Revisions[i+1].Orig)
+		}
+		diff, e := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(revisionText(r.Text, r.Orig)),
+			B:        difflib.SplitLines(next),
+			FromFile: "before",
+			ToFile:   "after",
+			Context:  3,
+		})
+		if e != nil {
+			return nil, errors.Wrapf(e, "can't make diff for %s", commentID)
+		}
+		res[i] = HistoryEntry{Revision: r, Diff: diff}
+	}
+	return res, nil
+}
+
+// addRevision keeps current text of the comment as revision replaced by the edit, the oldest revisions dropped
+// above MaxRevisions
+func (s *DataStore) addRevision(c *store.Comment, req EditRequest, ts time.Time) {
+	if s.MaxRevisions <= 0 {
+		return
+	}
+	c.Revisions = append(c.Revisions, store.Revision{Text: c.Text, Orig: c.Orig, Editor: req.UserID,
+		Timestamp: ts, Summary: req.Summary})
+	if len(c.Revisions) > s.MaxRevisions {
+		c.Revisions = c.Revisions[len(c.Revisions)-s.MaxRevisions:]
+	}
+}
+
+// revisionText returns original markdown text if kept, rendered one otherwise
+func revisionText(text, orig string) string {
+	if orig != "" {
+		return orig
+	}
+	return text
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestService_History(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), MaxRevisions: 2}
+	defer b.Close()
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	id, err := b.Create(store.Comment{Text: "<p>line 1\nline 2</p>", Orig: "line 1\nline 2", Locator: locator,
+		User: store.User{ID: "user1"}})
+	require.NoError(t, err)
+
+	history, err := b.History(locator, id)
+	require.NoError(t, err)
+	assert.Equal(t, []HistoryEntry{}, history, "not edited")
+
+	comment, err := b.EditComment(locator, id, EditRequest{Orig: "line 1\nline 2 edited", Text: "<p>line 1\nline 2 edited</p>",
+		Summary: "first edit", UserID: "user1"})
+	require.NoError(t, err)
+	assert.Nil(t, comment.Revisions, "revisions not returned with comment")
+	_, err = b.EditComment(locator, id, EditRequest{Orig: "line 1\nline 2 edited again", Text: "<p>...</p>", UserID: "admin"})
+	require.NoError(t, err)
+
+	c, err := b.Get(locator, id, store.User{Admin: true})
+	require.NoError(t, err)
+	assert.Nil(t, c.Revisions, "revisions hidden by Get")
+
+	history, err = b.History(locator, id)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(history))
+	assert.Equal(t, "line 1\nline 2", history[0].Orig)
+	assert.Equal(t, "user1", history[0].Editor)
+	assert.Equal(t, "first edit", history[0].Summary)
+	assert.Equal(t, "--- before\n+++ after\n@@ -1,2 +1,2 @@\n line 1\n-line 2\n+line 2 edited\n", history[0].Diff)
+	assert.Equal(t, "line 1\nline 2 edited", history[1].Orig)
+	assert.Equal(t, "admin", history[1].Editor)
+	assert.Equal(t, "--- before\n+++ after\n@@ -1,2 +1,2 @@\n line 1\n-line 2 edited\n+line 2 edited again\n", history[1].Diff)
+	assert.True(t, history[0].Timestamp.Before(history[1].Timestamp))
+
+	_, err = b.EditComment(locator, id, EditRequest{Orig: "final", Text: "<p>final</p>", UserID: "user1"})
+	require.NoError(t, err)
+	history, err = b.History(locator, id)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(history), "the oldest revision dropped")
+	assert.Equal(t, "line 1\nline 2 edited", history[0].Orig)
+	assert.Equal(t, "line 1\nline 2 edited again", history[1].Orig)
+
+	// deleted comment loses its history
+	_, err = b.EditComment(locator, id, EditRequest{Delete: true})
+	require.NoError(t, err)
+	history, err = b.History(locator, id)
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(history))
+
+	// history disabled
+	b.MaxRevisions = 0
+	_, err = b.EditComment(locator, "id-1", EditRequest{Orig: "edited", Text: "<p>edited</p>", UserID: "user1"})
+	require.NoError(t, err)
+	history, err = b.History(locator, "id-1")
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(history))
+
+	_, err = b.History(locator, "bad-id")
+	assert.Error(t, err)
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// intervalScan is the max number of recent comments of the user checked for the last comment to the post with interval
+const intervalScan = 100
+
+// PostInterval is the min interval between comments of each user to the post
+type PostInterval struct {
+	URL     string `json:"url"`
+	Minutes int    `json:"interval"`
+}
+
+// Interval returns minutes between comments of each user to the post, 0 if not limited
+func (s *DataStore) Interval(locator store.Locator) int {
+	if locator.URL == "" {
+		return 0
+	}
+	var minutes int
+	if _, err := s.intervals().Get(locator.SiteID, locator.URL, &minutes); err != nil {
+		log.Printf("[WARN] can't get interval of %s, %v", locator.URL, err)
+		return 0
+	}
+	return minutes
+}
+
+// SetInterval sets minutes between comments of each user to the post, 0 removes the limit
+func (s *DataStore) SetInterval(locator store.Locator, minutes int) error {
+	if locator.URL == "" {
+		return errors.New("post url is required")
+	}
+	if minutes < 0 {
+		return errors.Errorf("negative interval %d", minutes)
+	}
+	if minutes == 0 {
+		_, err := s.intervals().Delete(locator.SiteID, locator.URL)
+		return errors.Wrapf(err, "can't reset interval of %s", locator.URL)
+	}
+	return errors.Wrapf(s.intervals().Set(locator.SiteID, locator.URL, minutes), "can't set interval of %s", locator.URL)
+}
+
+// Intervals lists posts of the site with interval set
+func (s *DataStore) Intervals(siteID string) ([]PostInterval, error) {
+	res := []PostInterval{}
+	err := s.intervals().List(siteID, "", func(url string, unmarshal func(v interface{}) error) error {
+		pi := PostInterval{URL: url}
+		if err := unmarshal(&pi.Minutes); err != nil {
+			return err
+		}
+		res = append(res, pi)
+		return nil
+	})
+	return res, errors.Wrapf(err, "can't list intervals of %s", siteID)
+}
+
+// NextComment returns time the user can comment the post with interval, zero time if the user can comment now
+func (s *DataStore) NextComment(locator store.Locator, user store.User) time.Time {
+	minutes := s.Interval(locator)
+	if minutes <= 0 {
+		return time.Time{}
+	}
+	interval := time.Duration(minutes) * time.Minute
+	since := time.Now().Add(-interval)
+	comments, err := s.User(locator.SiteID, user.ID, intervalScan, 0, user)
+	if err != nil {
+		log.Printf("[WARN] can't get comments of %s, %v", user.ID, err)
+		return time.Time{}
+	}
+	for _, c := range comments { // newest first
+		if c.Timestamp.Before(since) {
+			break
+		}
+		if c.Locator.URL == locator.URL {
+			return c.Timestamp.Add(interval)
+		}
+	}
+	return time.Time{}
+}
+
+func (s *DataStore) intervals() engine.Records {
+	return engine.Records{Engine: s.Engine, Kind: engine.Intervals}
+}
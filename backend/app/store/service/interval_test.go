@@ -0,0 +1,42 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestService_Interval(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	assert.Equal(t, 0, b.Interval(locator))
+	assert.True(t, b.NextComment(locator, store.User{ID: "user2"}).IsZero(), "not limited")
+	assert.EqualError(t, b.SetInterval(locator, -1), "negative interval -1")
+	assert.EqualError(t, b.SetInterval(store.Locator{SiteID: "radio-t"}, 10), "post url is required")
+	require.NoError(t, b.SetInterval(locator, 10))
+	assert.Equal(t, 10, b.Interval(locator))
+	list, err := b.Intervals("radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, []PostInterval{{URL: "https://radio-t.com", Minutes: 10}}, list)
+
+	assert.True(t, b.NextComment(locator, store.User{ID: "user2"}).IsZero(), "no recent comments")
+	ts := time.Now().Add(-time.Minute).Truncate(time.Second)
+	_, err = b.Create(store.Comment{Text: "new comment", Locator: locator, User: store.User{ID: "user2"}, Timestamp: ts})
+	require.NoError(t, err)
+	assert.Equal(t, ts.Add(10*time.Minute).Unix(), b.NextComment(locator, store.User{ID: "user2"}).Unix())
+	assert.True(t, b.NextComment(store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"},
+		store.User{ID: "user2"}).IsZero(), "other post not limited")
+	assert.True(t, b.NextComment(locator, store.User{ID: "user3"}).IsZero(), "other user not limited")
+
+	require.NoError(t, b.SetInterval(locator, 0))
+	assert.Equal(t, 0, b.Interval(locator))
+	assert.True(t, b.NextComment(locator, store.User{ID: "user2"}).IsZero(), "limit reset")
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+	"github.com/umputun/remark42/backend/app/store/events"
+	"github.com/umputun/remark42/backend/app/store/search"
+)
+
+var labelRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// SetLabel adds label to the comment or removes it. Labels set by moderators to triage comments,
+// like "question" or "bug-report". Label is lowercased and can have letters, digits, "-" and "_".
+func (s *DataStore) SetLabel(locator store.Locator, commentID, label string, status bool) (comment store.Comment, err error) {
+	label, err = normalizeLabel(label)
+	if err != nil {
+		return comment, err
+	}
+
+	cLock := s.getScopedLocks(locator.URL)
+	cLock.Lock()
+	defer cLock.Unlock()
+
+	comment, err = s.Engine.Get(engine.GetRequest{Locator: locator, CommentID: commentID})
+	if err != nil {
+		return comment, err
+	}
+	if comment.Deleted {
+		return comment, errors.Errorf("can't label deleted comment %s", commentID)
+	}
+
+	labels := make([]string, 0, len(comment.Labels)+1)
+	for _, l := range comment.Labels {
+		if l != label {
+			labels = append(labels, l)
+		}
+	}
+	if status {
+		labels = append(labels, label)
+		sort.Strings(labels)
+	}
+	if len(labels) == 0 {
+		labels = nil
+	}
+	comment.Labels = labels
+	comment.Locator = locator
+	if err = s.Engine.Update(comment); err != nil {
+		return comment, err
+	}
+	s.updateSearchIndex(func(svc *search.Service) error { return svc.Index(comment) })
+	s.publish(events.Updated, comment)
+	return comment, nil
+}
+
+// Labeled returns comments with the label, for all posts of the site if locator.URL not set.
+// Comments sorted by time, deleted ones skipped.
+func (s *DataStore) Labeled(locator store.Locator, label string) ([]store.Comment, error) {
+	label, err := normalizeLabel(label)
+	if err != nil {
+		return nil, err
+	}
+
+	urls := []string{locator.URL}
+	if locator.URL == "" {
+		posts, e := s.Engine.Info(engine.InfoRequest{Locator: store.Locator{SiteID: locator.SiteID}})
+		if e != nil {
+			return nil, errors.Wrapf(e, "can't get posts of %s", locator.SiteID)
+		}
+		urls = make([]string, 0, len(posts))
+		for _, p := range posts {
+			urls = append(urls, p.URL)
+		}
+	}
+
+	res := []store.Comment{}
+	for _, url := range urls {
+		comments, e := s.Engine.Find(engine.FindRequest{Locator: store.Locator{SiteID: locator.SiteID, URL: url}, Sort: "time"})
+		if e != nil {
+			return nil, errors.Wrapf(e, "can't get comments of %s", url)
+		}
+		for _, c := range comments {
+			if !c.Deleted && hasLabel(c, label) {
+				res = append(res, c)
+			}
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Timestamp.Before(res[j].Timestamp) })
+	return s.alterComments(res, store.User{Admin: true}), nil
+}
+
+func normalizeLabel(label string) (string, error) {
+	res := strings.ToLower(strings.TrimSpace(label))
+	if !labelRe.MatchString(res) {
+		return "", errors.Errorf("invalid label %q", label)
+	}
+	return res, nil
+}
+
+func hasLabel(c store.Comment, label string) bool {
+	for _, l := range c.Labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestService_SetLabel(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	c, err := b.SetLabel(locator, "id-1", " Question ", true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"question"}, c.Labels)
+	c, err = b.SetLabel(locator, "id-1", "bug-report", true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bug-report", "question"}, c.Labels, "sorted")
+	c, err = b.SetLabel(locator, "id-1", "question", true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bug-report", "question"}, c.Labels, "no duplicates")
+
+	res, err := b.Get(locator, "id-1", store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bug-report", "question"}, res.Labels, "stored")
+
+	c, err = b.SetLabel(locator, "id-1", "question", false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bug-report"}, c.Labels)
+	c, err = b.SetLabel(locator, "id-1", "bug-report", false)
+	require.NoError(t, err)
+	assert.Nil(t, c.Labels)
+
+	_, err = b.SetLabel(locator, "id-1", "bad label", true)
+	assert.EqualError(t, err, `invalid label "bad label"`)
+	_, err = b.SetLabel(locator, "id-1", "", true)
+	assert.Error(t, err)
+	_, err = b.SetLabel(locator, "bad-id", "question", true)
+	assert.Error(t, err)
+
+	require.NoError(t, b.Delete(locator, "id-2", store.SoftDelete))
+	_, err = b.SetLabel(locator, "id-2", "question", true)
+	assert.EqualError(t, err, "can't label deleted comment id-2")
+}
+
+func TestService_Labeled(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	locator2 := store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}
+
+	id3, err := b.Create(store.Comment{Text: "another post", Locator: locator2, User: store.User{ID: "user2"}})
+	require.NoError(t, err)
+	for _, id := range []string{"id-1", "id-2"} {
+		_, err = b.SetLabel(locator, id, "question", true)
+		require.NoError(t, err)
+	}
+	_, err = b.SetLabel(locator2, id3, "question", true)
+	require.NoError(t, err)
+	_, err = b.SetLabel(locator, "id-1", "testimonial", true)
+	require.NoError(t, err)
+
+	res, err := b.Labeled(store.Locator{SiteID: "radio-t"}, "question")
+	require.NoError(t, err)
+	require.Equal(t, 3, len(res), "all posts of the site")
+	assert.Equal(t, "id-1", res[0].ID)
+	assert.Equal(t, "id-2", res[1].ID)
+	assert.Equal(t, id3, res[2].ID)
+
+	res, err = b.Labeled(locator2, "Question")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res), "single post")
+	assert.Equal(t, id3, res[0].ID)
+
+	res, err = b.Labeled(store.Locator{SiteID: "radio-t"}, "testimonial")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, "id-1", res[0].ID)
+
+	require.NoError(t, b.Delete(locator, "id-1", store.SoftDelete))
+	res, err = b.Labeled(store.Locator{SiteID: "radio-t"}, "testimonial")
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(res), "deleted skipped")
+
+	_, err = b.Labeled(store.Locator{SiteID: "radio-t"}, "bad label")
+	assert.Error(t, err)
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+	"github.com/umputun/remark42/backend/app/store/events"
+)
+
+// SetPending sets or clears pending status of the comment. Pending comments are held for moderation,
+// only admins and authors see them.
+func (s *DataStore) SetPending(locator store.Locator, commentID string, status bool) error {
+	comment, err := s.Engine.Get(engine.GetRequest{Locator: locator, CommentID: commentID})
+	if err != nil {
+		return err
+	}
+	comment.Pending = status
+	comment.Locator = locator
+	if err = s.Engine.Update(comment); err != nil {
+		return err
+	}
+	s.publish(events.Updated, comment)
+	return nil
+}
+
+// PendingComments returns pending comments among last comments of the site, the most recent first
+func (s *DataStore) PendingComments(siteID string) ([]store.Comment, error) {
+	comments, err := s.Engine.Find(engine.FindRequest{Locator: store.Locator{SiteID: siteID}, Sort: "-time",
+		Limit: maxLastCommentsReply})
+	if err != nil {
+		return nil, err
+	}
+	res := []store.Comment{}
+	for _, c := range comments {
+		if c.Pending && !c.Deleted {
+			res = append(res, c)
+		}
+	}
+	return s.alterComments(res, store.User{Admin: true}), nil
+}
+
+// hidePending removes pending comments from the list. Admins see all comments, users see own pending comments.
+// Empty user hides pending comments of everyone.
+func hidePending(comments []store.Comment, user store.User) []store.Comment {
+	if user.Admin {
+		return comments
+	}
+	var res []store.Comment
+	for i, c := range comments {
+		visible := !c.Pending || (user.ID != "" && c.User.ID == user.ID)
+		if res == nil && !visible {
+			res = append(make([]store.Comment, 0, len(comments)), comments[:i]...) // copy on first hidden
+		}
+		if res != nil && visible {
+			res = append(res, c)
+		}
+	}
+	if res == nil {
+		return comments
+	}
+	return res
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestService_Pending(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	id, err := b.Create(store.Comment{Text: "spam comment", Locator: locator, User: store.User{ID: "user2"}, Pending: true})
+	require.NoError(t, err)
+
+	res, err := b.Find(locator, "time", store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(res), "pending comment hidden for anonymous")
+	res, err = b.Find(locator, "time", store.User{ID: "user1"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(res), "pending comment hidden for other users")
+	res, err = b.Find(locator, "time", store.User{ID: "user2"})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(res), "pending comment visible for author")
+	assert.True(t, res[2].Pending)
+	res, err = b.Find(locator, "time", store.User{ID: "admin", Admin: true})
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(res), "pending comment visible for admin")
+
+	last, err := b.Last("radio-t", 10, time.Time{}, store.User{ID: "user2"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(last), "hidden for everyone in last comments")
+
+	pending, err := b.PendingComments("radio-t")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(pending))
+	assert.Equal(t, id, pending[0].ID)
+
+	require.NoError(t, b.SetPending(locator, id, false))
+	res, err = b.Find(locator, "time", store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(res), "approved comment visible")
+	pending, err = b.PendingComments("radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(pending))
+	assert.Error(t, b.SetPending(locator, "bad-id", false))
+}
+
+func TestService_hidePending(t *testing.T) {
+	comments := []store.Comment{{ID: "1"}, {ID: "2", Pending: true, User: store.User{ID: "u1"}}, {ID: "3"}}
+	assert.Equal(t, comments, hidePending(comments, store.User{Admin: true}))
+	assert.Equal(t, comments, hidePending(comments, store.User{ID: "u1"}))
+	assert.Equal(t, []store.Comment{{ID: "1"}, {ID: "3"}}, hidePending(comments, store.User{ID: "u2"}))
+	assert.Equal(t, []store.Comment{{ID: "1"}, {ID: "3"}}, hidePending(comments, store.User{}))
+	assert.Nil(t, hidePending(nil, store.User{}))
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// limits of profile fields, in runes
+const (
+	maxDisplayNameLen = 64
+	maxWebsiteLen     = 256
+	maxBioLen         = 500
+)
+
+// GetUserProfile gets profile of the user, empty if never set
+func (s *DataStore) GetUserProfile(siteID, userID string) (store.Profile, error) {
+	res, err := s.Engine.UserDetail(engine.UserDetailRequest{Detail: engine.UserProfile,
+		Locator: store.Locator{SiteID: siteID}, UserID: userID})
+	if err != nil {
+		return store.Profile{}, err
+	}
+	if len(res) == 1 && res[0].Profile != nil {
+		return *res[0].Profile, nil
+	}
+	return store.Profile{}, nil
+}
+
+// SetUserProfile validates and sets profile of the user, empty profile removes it. Returns sanitized profile.
+// Display names from RestrictedNames allowed to admins only.
+func (s *DataStore) SetUserProfile(siteID string, user store.User, profile store.Profile) (store.Profile, error) {
+	profile, err := s.prepProfile(profile, user.Admin)
+	if err != nil {
+		return store.Profile{}, err
+	}
+
+	if profile == (store.Profile{}) {
+		if err = s.DeleteUserDetail(siteID, user.ID, engine.UserProfile); err != nil {
+			return store.Profile{}, errors.Wrapf(err, "can't delete profile of %s", user.ID)
+		}
+		log.Printf("[INFO] audit: profile of %s removed on %s", user.ID, siteID)
+		return profile, nil
+	}
+
+	_, err = s.Engine.UserDetail(engine.UserDetailRequest{Detail: engine.UserProfile,
+		Locator: store.Locator{SiteID: siteID}, UserID: user.ID, Profile: &profile})
+	if err != nil {
+		return store.Profile{}, errors.Wrapf(err, "can't set profile of %s", user.ID)
+	}
+	log.Printf("[INFO] audit: profile of %s changed on %s, display name %q, website %q, bio of %d chars",
+		user.ID, siteID, profile.DisplayName, profile.Website, utf8.RuneCountInString(profile.Bio))
+	return profile, nil
+}
+
+// prepProfile trims and sanitizes profile fields, returns error for invalid ones
+func (s *DataStore) prepProfile(profile store.Profile, admin bool) (store.Profile, error) {
+	policy := bluemonday.StrictPolicy()
+	profile.DisplayName = strings.TrimSpace(policy.Sanitize(profile.DisplayName))
+	profile.Bio = strings.TrimSpace(policy.Sanitize(profile.Bio))
+	profile.Website = strings.TrimSpace(profile.Website)
+
+	if utf8.RuneCountInString(profile.DisplayName) > maxDisplayNameLen {
+		return profile, errors.Errorf("display name is longer than %d characters", maxDisplayNameLen)
+	}
+	if strings.IndexFunc(profile.DisplayName, unicode.IsControl) >= 0 {
+		return profile, errors.New("display name has control characters")
+	}
+	if !admin {
+		for _, name := range s.RestrictedNames {
+			if strings.EqualFold(profile.DisplayName, strings.TrimSpace(name)) {
+				return profile, errors.Errorf("display name %q is restricted", profile.DisplayName)
+			}
+		}
+	}
+
+	if utf8.RuneCountInString(profile.Bio) > maxBioLen {
+		return profile, errors.Errorf("bio is longer than %d characters", maxBioLen)
+	}
+
+	if profile.Website != "" {
+		if utf8.RuneCountInString(profile.Website) > maxWebsiteLen {
+			return profile, errors.Errorf("website is longer than %d characters", maxWebsiteLen)
+		}
+		u, err := url.Parse(profile.Website)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return profile, errors.Errorf("website %q is not a valid http(s) url", profile.Website)
+		}
+		profile.Website = u.String()
+	}
+	return profile, nil
+}
+
+// prepProfileUser sets name, website and bio of the comment's author from user's profile
+func (s *DataStore) prepProfileUser(c store.Comment) store.Comment {
+	res, err := s.Engine.UserDetail(engine.UserDetailRequest{Detail: engine.UserProfile,
+		Locator: store.Locator{SiteID: c.Locator.SiteID}, UserID: c.User.ID})
+	if err != nil || len(res) != 1 || res[0].Profile == nil {
+		return c
+	}
+	if res[0].Profile.DisplayName != "" {
+		c.User.Name = res[0].Profile.DisplayName
+	}
+	c.User.Website, c.User.Bio = res[0].Profile.Website, res[0].Profile.Bio
+	return c
+}
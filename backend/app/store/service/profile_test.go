@@ -0,0 +1,65 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestService_UserProfile(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), RestrictedNames: []string{"admin"}}
+	user := store.User{ID: "user1", Name: "user one"}
+
+	res, err := b.GetUserProfile("radio-t", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, store.Profile{}, res, "not set")
+
+	res, err = b.SetUserProfile("radio-t", user, store.Profile{DisplayName: " John <b>Doe</b> ",
+		Website: "https://example.com/john", Bio: "writes <script>alert(1)</script>code"})
+	require.NoError(t, err)
+	expected := store.Profile{DisplayName: "John Doe", Website: "https://example.com/john", Bio: "writes code"}
+	assert.Equal(t, expected, res, "sanitized")
+	res, err = b.GetUserProfile("radio-t", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, expected, res)
+
+	comments, err := b.User("radio-t", "user1", 10, 0, store.User{})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(comments))
+	assert.Equal(t, "John Doe", comments[0].User.Name, "display name shown")
+	assert.Equal(t, "https://example.com/john", comments[0].User.Website)
+	assert.Equal(t, "writes code", comments[0].User.Bio)
+
+	tbl := []store.Profile{
+		{DisplayName: strings.Repeat("n", 65)},
+		{DisplayName: "bad\tname"},
+		{DisplayName: "Admin"},
+		{Bio: strings.Repeat("b", 501)},
+		{Website: "javascript:alert(1)"},
+		{Website: "example.com"},
+		{Website: "https://example.com/" + strings.Repeat("w", 256)},
+	}
+	for i, tt := range tbl {
+		_, err = b.SetUserProfile("radio-t", user, tt)
+		assert.Error(t, err, "case #%d", i)
+	}
+	_, err = b.SetUserProfile("radio-t", store.User{ID: "user2", Admin: true}, store.Profile{DisplayName: "Admin"})
+	assert.NoError(t, err, "restricted names allowed to admins")
+
+	res, err = b.SetUserProfile("radio-t", user, store.Profile{Bio: "  "})
+	require.NoError(t, err)
+	assert.Equal(t, store.Profile{}, res)
+	res, err = b.GetUserProfile("radio-t", "user1")
+	require.NoError(t, err)
+	assert.Equal(t, store.Profile{}, res, "empty profile removed")
+	comments, err = b.User("radio-t", "user1", 10, 0, store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, "user name", comments[0].User.Name, "original name")
+}
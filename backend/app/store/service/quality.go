@@ -0,0 +1,102 @@
+package service
+
+import (
+	"math"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/microcosm-cc/bluemonday"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// weights of quality components, sum of positive ones is 1
+const (
+	qualityLengthWeight  = 0.2
+	qualityLinksWeight   = 0.2 // penalty
+	qualityVotesWeight   = 0.35
+	qualityKarmaWeight   = 0.15
+	qualityRepliesWeight = 0.3
+)
+
+const (
+	qualityLengthNorm  = 1000 // text length (in runes) getting max length component
+	qualityRepliesNorm = 20   // number of replies getting max replies component
+	qualityVotesNorm   = 5    // score giving ~76% of votes component
+	qualityKarmaNorm   = 50   // karma giving ~76% of karma component
+	qualityKarmaLimit  = 100  // number of last user's comments used for karma
+)
+
+var qualityLinkRe = regexp.MustCompile(`(?i)<a\s`)
+
+// quality makes composite score of the comment from its length, ratio of links to words, votes score,
+// author's karma (total score of author's comments) and number of direct replies. Result is in [-70, 100] range,
+// long, upvoted, discussed comments of authors with good karma get the highest score and link dumps the lowest.
+func quality(c store.Comment, replies, karma int) float64 {
+	text := strings.TrimSpace(bluemonday.StrictPolicy().Sanitize(c.Text))
+	length := utf8.RuneCountInString(text)
+	words := len(strings.Fields(text))
+	links := len(qualityLinkRe.FindAllStringIndex(c.Text, -1))
+
+	lengthScore := math.Min(math.Log1p(float64(length))/math.Log1p(qualityLengthNorm), 1)
+	linksPenalty := 0.0
+	if links > 0 {
+		linksPenalty = math.Min(10*float64(links)/float64(words+1), 1) // one link per ten words is max penalty
+	}
+	votesScore := math.Tanh(float64(c.Score) / qualityVotesNorm)
+	karmaScore := math.Tanh(float64(karma) / qualityKarmaNorm)
+	repliesScore := math.Min(math.Log1p(float64(replies))/math.Log1p(qualityRepliesNorm), 1)
+
+	res := qualityLengthWeight*lengthScore - qualityLinksWeight*linksPenalty + qualityVotesWeight*votesScore +
+		qualityKarmaWeight*karmaScore + qualityRepliesWeight*repliesScore
+	return math.Round(res*10000) / 100 // percents with 2 decimals
+}
+
+// updateQuality sets quality of the comment, with number of replies counted in post's comments and karma
+// from last comments of the author. Errors only logged, quality computed with available data.
+func (s *DataStore) updateQuality(c *store.Comment) {
+	replies := 0
+	comments, err := s.Engine.Find(engine.FindRequest{Locator: c.Locator, Sort: "time"})
+	if err != nil {
+		log.Printf("[WARN] can't get comments of %s for quality, %v", c.Locator.URL, err)
+	}
+	for _, r := range comments {
+		if r.ParentID == c.ID && !r.Deleted {
+			replies++
+		}
+	}
+	c.Quality = quality(*c, replies, s.karma(c.Locator.SiteID, c.User.ID))
+}
+
+// updateParentQuality recalculates quality of the parent comment on reply
+func (s *DataStore) updateParentQuality(c store.Comment) {
+	if c.ParentID == "" {
+		return
+	}
+	parent, err := s.Engine.Get(engine.GetRequest{Locator: c.Locator, CommentID: c.ParentID})
+	if err != nil {
+		log.Printf("[WARN] can't get parent comment %s for quality, %v", c.ParentID, err)
+		return
+	}
+	parent.Locator = c.Locator
+	s.updateQuality(&parent)
+	if err = s.Engine.Update(parent); err != nil {
+		log.Printf("[WARN] can't update quality of %s, %v", parent.ID, err)
+	}
+}
+
+// karma of the user is a total score of user's last comments on the site
+func (s *DataStore) karma(siteID, userID string) (res int) {
+	comments, err := s.Engine.Find(engine.FindRequest{Locator: store.Locator{SiteID: siteID}, UserID: userID,
+		Limit: qualityKarmaLimit})
+	if err != nil {
+		return 0 // no comments of new user reported as error by some engines
+	}
+	for _, c := range comments {
+		res += c.Score
+	}
+	return res
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestService_quality(t *testing.T) {
+	long := "<p>" + strings.Repeat("word ", 200) + "</p>"
+	links := `<p><a href="http://a.com">a</a> <a href="http://b.com">b</a> see</p>`
+
+	tbl := []struct {
+		comment        store.Comment
+		replies, karma int
+		res            float64
+	}{
+		{store.Comment{Text: ""}, 0, 0, 0},
+		{store.Comment{Text: long}, 0, 0, 20},
+		{store.Comment{Text: links}, 0, 0, -13.98},
+		{store.Comment{Text: "<p>short</p>"}, 0, 0, 5.19},
+		{store.Comment{Text: "<p>short</p>", Score: 5}, 0, 0, 31.84},
+		{store.Comment{Text: "<p>short</p>", Score: -5}, 0, 0, -21.46},
+		{store.Comment{Text: "<p>short</p>"}, 0, 50, 16.61},
+		{store.Comment{Text: "<p>short</p>"}, 20, 0, 35.19},
+		{store.Comment{Text: long, Score: 1000}, 100, 1000, 100},
+	}
+
+	for i, tt := range tbl {
+		assert.InDelta(t, tt.res, quality(tt.comment, tt.replies, tt.karma), 0.01, "case #%d", i)
+	}
+}
+
+func TestService_QualitySort(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), MaxVotes: -1}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	// comments made before quality scoring get it on the fly
+	res, err := b.Find(locator, "-quality", store.User{})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(res))
+	assert.Equal(t, "id-2", res[0].ID, "no links")
+	assert.Equal(t, "id-1", res[1].ID)
+	assert.True(t, res[0].Quality > 0)
+
+	id, err := b.Create(store.Comment{Text: "<p>long and thoughtful message about the topic</p>", Locator: locator,
+		User: store.User{ID: "user2", Name: "user2"}})
+	require.NoError(t, err)
+	c, err := b.Get(locator, id, store.User{})
+	require.NoError(t, err)
+	assert.InDelta(t, 10.95, c.Quality, 0.01)
+
+	// vote raises quality
+	c, err = b.Vote(VoteReq{Locator: locator, CommentID: id, UserID: "user1", Val: true})
+	require.NoError(t, err)
+	assert.InDelta(t, 17.86, c.Quality, 0.01)
+
+	// reply raises quality of the parent, karma of the author counted for new comment
+	_, err = b.Create(store.Comment{ParentID: id, Text: "<p>reply</p>", Locator: locator, User: store.User{ID: "user2"}})
+	require.NoError(t, err)
+	c, err = b.Get(locator, id, store.User{})
+	require.NoError(t, err)
+	assert.InDelta(t, 24.99, c.Quality, 0.01)
+
+	res, err = b.Find(locator, "-quality", store.User{})
+	require.NoError(t, err)
+	require.Equal(t, 4, len(res))
+	assert.Equal(t, id, res[0].ID)
+	assert.Equal(t, "id-1", res[3].ID)
+
+	res, err = b.Find(locator, "+quality", store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, "id-1", res[0].ID)
+	assert.Equal(t, id, res[3].ID)
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// ReactReq is the request to add or remove reaction to the comment
+type ReactReq struct {
+	Locator   store.Locator
+	CommentID string
+	UserID    string
+	Reaction  string // name of reaction, one of DataStore.Reactions
+	Remove    bool   // remove reaction of the user instead of adding it
+}
+
+// React adds reaction of the user to the comment, or removes it. Each user can set multiple different reactions
+// to the comment, but every reaction only once.
+func (s *DataStore) React(req ReactReq) (comment store.Comment, err error) {
+	if !s.isAllowedReaction(req.Reaction) {
+		return comment, errors.Errorf("unknown reaction %q", req.Reaction)
+	}
+
+	cLock := s.getScopedLocks(req.Locator.URL) // the same lock used by Vote
+	cLock.Lock()
+	defer cLock.Unlock()
+
+	comment, err = s.Engine.Get(engine.GetRequest{Locator: req.Locator, CommentID: req.CommentID})
+	if err != nil {
+		return comment, err
+	}
+
+	if comment.User.ID == req.UserID && req.UserID != "dev" {
+		return comment, errors.Errorf("user %s can not react to his own comment %s", req.UserID, req.CommentID)
+	}
+
+	if comment.Reactors == nil {
+		comment.Reactors = map[string][]string{}
+	}
+	if comment.Reactions == nil {
+		comment.Reactions = map[string]int{}
+	}
+
+	userReactions := comment.Reactors[req.UserID]
+	idx := -1
+	for i, r := range userReactions {
+		if r == req.Reaction {
+			idx = i
+			break
+		}
+	}
+
+	switch {
+	case req.Remove && idx < 0:
+		return comment, errors.Errorf("user %s has no reaction %s for %s", req.UserID, req.Reaction, req.CommentID)
+	case req.Remove:
+		userReactions = append(userReactions[:idx], userReactions[idx+1:]...)
+		comment.Reactions[req.Reaction]--
+		if comment.Reactions[req.Reaction] <= 0 {
+			delete(comment.Reactions, req.Reaction)
+		}
+	case idx >= 0:
+		return comment, errors.Errorf("user %s already reacted %s for %s", req.UserID, req.Reaction, req.CommentID)
+	default:
+		userReactions = append(userReactions, req.Reaction)
+		comment.Reactions[req.Reaction]++
+	}
+
+	if len(userReactions) == 0 {
+		delete(comment.Reactors, req.UserID)
+	} else {
+		comment.Reactors[req.UserID] = userReactions
+	}
+	comment.Reacted = userReactions
+	comment.Locator = req.Locator
+	log.Printf("[DEBUG] reaction %s of %s for comment %s, remove=%v", req.Reaction, req.UserID, req.CommentID, req.Remove)
+	return comment, s.Engine.Update(comment)
+}
+
+// isAllowedReaction checks if reaction is in the list of configured reactions
+func (s *DataStore) isAllowedReaction(reaction string) bool {
+	for _, r := range s.Reactions {
+		if r == reaction {
+			return true
+		}
+	}
+	return false
+}
+
+// prepare reactions info for client view, hides reactions of other users
+func (s *DataStore) prepReactions(c store.Comment, user store.User) store.Comment {
+	c.Reacted = nil
+	if r, ok := c.Reactors[user.ID]; ok && user.ID != "" {
+		c.Reacted = r
+	}
+	c.Reactors = nil // hide reactors list
+	return c
+}
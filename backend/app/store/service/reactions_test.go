@@ -0,0 +1,62 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestService_React(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), Reactions: []string{"like", "heart"}}
+	defer b.Close()
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	c, err := b.React(ReactReq{Locator: locator, CommentID: "id-1", UserID: "user2", Reaction: "heart"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"heart": 1}, c.Reactions)
+	assert.Equal(t, []string{"heart"}, c.Reacted)
+
+	_, err = b.React(ReactReq{Locator: locator, CommentID: "id-1", UserID: "user2", Reaction: "heart"})
+	assert.EqualError(t, err, "user user2 already reacted heart for id-1")
+	_, err = b.React(ReactReq{Locator: locator, CommentID: "id-1", UserID: "user2", Reaction: "laugh"})
+	assert.EqualError(t, err, `unknown reaction "laugh"`)
+	_, err = b.React(ReactReq{Locator: locator, CommentID: "id-1", UserID: "user1", Reaction: "like"})
+	assert.EqualError(t, err, "user user1 can not react to his own comment id-1")
+	_, err = b.React(ReactReq{Locator: locator, CommentID: "id-1", UserID: "user3", Reaction: "like", Remove: true})
+	assert.EqualError(t, err, "user user3 has no reaction like for id-1")
+	_, err = b.React(ReactReq{Locator: locator, CommentID: "no-such-id", UserID: "user3", Reaction: "like"})
+	assert.Error(t, err)
+
+	_, err = b.React(ReactReq{Locator: locator, CommentID: "id-1", UserID: "user2", Reaction: "like"})
+	require.NoError(t, err)
+	_, err = b.React(ReactReq{Locator: locator, CommentID: "id-1", UserID: "user3", Reaction: "heart"})
+	require.NoError(t, err)
+
+	res, err := b.Get(locator, "id-1", store.User{ID: "user2"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"heart": 2, "like": 1}, res.Reactions)
+	assert.Equal(t, []string{"heart", "like"}, res.Reacted)
+	assert.Nil(t, res.Reactors, "reactors hidden")
+	res, err = b.Get(locator, "id-1", store.User{})
+	require.NoError(t, err)
+	assert.Nil(t, res.Reacted, "no reactions of anonymous reader")
+
+	c, err = b.React(ReactReq{Locator: locator, CommentID: "id-1", UserID: "user2", Reaction: "heart", Remove: true})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"heart": 1, "like": 1}, c.Reactions)
+	assert.Equal(t, []string{"like"}, c.Reacted)
+	c, err = b.React(ReactReq{Locator: locator, CommentID: "id-1", UserID: "user2", Reaction: "like", Remove: true})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"heart": 1}, c.Reactions)
+	assert.Empty(t, c.Reacted)
+
+	b.Reactions = nil
+	_, err = b.React(ReactReq{Locator: locator, CommentID: "id-1", UserID: "user2", Reaction: "heart"})
+	assert.Error(t, err, "reactions disabled")
+}
@@ -0,0 +1,229 @@
+package service
+
+import (
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+	"github.com/umputun/remark42/backend/app/store/search"
+)
+
+// ReattributeResult describes changes made by Reattribute, or to be made in dry-run mode
+type ReattributeResult struct {
+	From     string              `json:"from"`
+	To       store.User          `json:"to"`
+	Comments []string            `json:"comments"` // ids of re-attributed comments
+	Votes    []string            `json:"votes"`    // ids of comments with re-attributed votes
+	Details  []engine.UserDetail `json:"details"`  // moved user details, like email subscription
+	DryRun   bool                `json:"dry_run"`
+}
+
+// Reattribute moves all comments of user fromID to user toID, along with the user's votes and user details
+// (email subscription, consent) not set for the new user yet. Name and picture of the new user taken from
+// the latest comment of the new user, and kept from the old user if the new one has no comments.
+// In dry-run mode nothing changed, the result reports what would be changed. Each change logged for audit.
+func (s *DataStore) Reattribute(siteID, fromID, toID string, dryRun bool) (res ReattributeResult, err error) {
+	res = ReattributeResult{From: fromID, DryRun: dryRun, Comments: []string{}, Votes: []string{},
+		Details: []engine.UserDetail{}}
+	if fromID == "" || toID == "" || fromID == toID {
+		return res, errors.Errorf("invalid reattribute request from %q to %q", fromID, toID)
+	}
+
+	lastReq := engine.FindRequest{Locator: store.Locator{SiteID: siteID}, UserID: fromID, Sort: "-time", Limit: 1}
+	last, err := s.Engine.Find(lastReq)
+	if err != nil || len(last) == 0 {
+		return res, errors.Errorf("no comments for user %s", fromID)
+	}
+	res.To = last[0].User
+	res.To.ID = toID
+	lastReq.UserID = toID
+	if toLast, e := s.Engine.Find(lastReq); e == nil && len(toLast) > 0 {
+		res.To = toLast[0].User
+	}
+	res.To.IP = "" // only id, name and picture re-attributed
+
+	if dryRun {
+		comments, e := s.userComments(siteID, fromID)
+		if e != nil {
+			return res, e
+		}
+		for _, c := range comments {
+			res.Comments = append(res.Comments, c.ID)
+		}
+	} else {
+		req := engine.ReattributeRequest{Locator: store.Locator{SiteID: siteID}, FromID: fromID, To: res.To}
+		if res.Comments, err = s.Engine.Reattribute(req); err != nil {
+			return res, errors.Wrapf(err, "can't reattribute comments of %s", fromID)
+		}
+		for _, id := range res.Comments {
+			log.Printf("[INFO] audit: comment %s of %s re-attributed from %s to %s", id, siteID, fromID, toID)
+		}
+	}
+
+	if res.Votes, err = s.reattributeVotes(siteID, fromID, toID, dryRun); err != nil {
+		return res, err
+	}
+	if res.Details, err = s.reattributeDetails(siteID, fromID, toID, dryRun); err != nil {
+		return res, err
+	}
+	if !dryRun {
+		s.reindexUser(siteID, toID)
+	}
+	log.Printf("[INFO] user %s of %s re-attributed to %s, dry-run=%v, %d comments, %d votes, details %v",
+		fromID, siteID, toID, dryRun, len(res.Comments), len(res.Votes), res.Details)
+	return res, nil
+}
+
+// MergeUser moves comments, votes and user details of fromID to toID, used to merge linked identities.
+// Unlike Reattribute, user without comments is not an error, votes and details of such user moved anyway.
+func (s *DataStore) MergeUser(siteID, fromID, toID string) (ReattributeResult, error) {
+	res := ReattributeResult{From: fromID, To: store.User{ID: toID}, Comments: []string{}, Votes: []string{},
+		Details: []engine.UserDetail{}}
+	if fromID == "" || toID == "" || fromID == toID {
+		return res, errors.Errorf("invalid merge request from %q to %q", fromID, toID)
+	}
+	// some engines report user without comments as error
+	last, err := s.Engine.Find(engine.FindRequest{Locator: store.Locator{SiteID: siteID}, UserID: fromID, Limit: 1})
+	if err == nil && len(last) > 0 {
+		return s.Reattribute(siteID, fromID, toID, false)
+	}
+
+	if res.Votes, err = s.reattributeVotes(siteID, fromID, toID, false); err != nil {
+		return res, err
+	}
+	if res.Details, err = s.reattributeDetails(siteID, fromID, toID, false); err != nil {
+		return res, err
+	}
+	log.Printf("[INFO] user %s of %s without comments merged to %s, %d votes, details %v",
+		fromID, siteID, toID, len(res.Votes), res.Details)
+	return res, nil
+}
+
+// userComments returns all comments of the user, loaded page by page
+func (s *DataStore) userComments(siteID, userID string) ([]store.Comment, error) {
+	const pageSize = 500
+	res := []store.Comment{}
+	for skip := 0; ; skip += pageSize {
+		comments, err := s.Engine.Find(engine.FindRequest{Locator: store.Locator{SiteID: siteID}, UserID: userID,
+			Sort: "time", Limit: pageSize, Skip: skip})
+		if err != nil {
+			return res, errors.Wrapf(err, "can't get comments of %s", userID)
+		}
+		res = append(res, comments...)
+		if len(comments) < pageSize {
+			return res, nil
+		}
+	}
+}
+
+// reattributeVotes moves votes of fromID to toID in all comments of the site. If both users voted for the same
+// comment, the vote of fromID dropped and the score updated. Returns ids of changed comments.
+func (s *DataStore) reattributeVotes(siteID, fromID, toID string, dryRun bool) ([]string, error) {
+	posts, err := s.Engine.Info(engine.InfoRequest{Locator: store.Locator{SiteID: siteID}})
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't get posts of %s", siteID)
+	}
+	res := []string{}
+	for _, p := range posts {
+		changed, e := s.reattributePostVotes(store.Locator{SiteID: siteID, URL: p.URL}, fromID, toID, dryRun)
+		res = append(res, changed...)
+		if e != nil {
+			return res, e
+		}
+	}
+	return res, nil
+}
+
+// reattributePostVotes moves votes of fromID to toID in comments of a single post, under post's lock
+func (s *DataStore) reattributePostVotes(locator store.Locator, fromID, toID string, dryRun bool) ([]string, error) {
+	lock := s.getScopedLocks(locator.URL) // the same lock used by Vote
+	lock.Lock()
+	defer lock.Unlock()
+
+	comments, err := s.Engine.Find(engine.FindRequest{Locator: locator, Sort: "time"})
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't get comments of %s", locator.URL)
+	}
+	res := []string{}
+	for _, c := range comments {
+		v, voted := c.Votes[fromID]
+		if !voted {
+			continue
+		}
+		res = append(res, c.ID)
+		if dryRun {
+			continue
+		}
+		delete(c.Votes, fromID)
+		if _, dbl := c.Votes[toID]; dbl {
+			if v {
+				c.Score--
+			} else {
+				c.Score++
+			}
+		} else {
+			c.Votes[toID] = v
+		}
+		c.Controversy = s.controversy(s.upsAndDowns(c))
+		c.Best = best(s.upsAndDowns(c))
+		if err = s.Engine.Update(c); err != nil {
+			return res, errors.Wrapf(err, "can't update votes of comment %s", c.ID)
+		}
+		log.Printf("[INFO] audit: vote for comment %s of %s re-attributed from %s to %s", c.ID, locator.SiteID, fromID, toID)
+	}
+	return res, nil
+}
+
+// reattributeDetails moves user details of fromID to toID, details already set for toID kept.
+// Returns list of moved details.
+func (s *DataStore) reattributeDetails(siteID, fromID, toID string, dryRun bool) ([]engine.UserDetail, error) {
+	res := []engine.UserDetail{}
+	empty := func(entries []engine.UserDetailEntry) bool {
+		return len(entries) == 0 || (entries[0].Email == "" && entries[0].Consent == "" && entries[0].Profile == nil)
+	}
+	for _, detail := range []engine.UserDetail{engine.UserEmail, engine.UserConsent, engine.UserProfile} {
+		from, err := s.Engine.UserDetail(engine.UserDetailRequest{Detail: detail, Locator: store.Locator{SiteID: siteID}, UserID: fromID})
+		if err != nil {
+			return res, errors.Wrapf(err, "can't get %s of %s", detail, fromID)
+		}
+		to, err := s.Engine.UserDetail(engine.UserDetailRequest{Detail: detail, Locator: store.Locator{SiteID: siteID}, UserID: toID})
+		if err != nil {
+			return res, errors.Wrapf(err, "can't get %s of %s", detail, toID)
+		}
+		if empty(from) || !empty(to) {
+			continue
+		}
+		res = append(res, detail)
+		if dryRun {
+			continue
+		}
+		req := engine.UserDetailRequest{Detail: detail, Locator: store.Locator{SiteID: siteID}, UserID: toID,
+			Update: from[0].Email}
+		switch detail {
+		case engine.UserConsent:
+			req.Update, req.Time = from[0].Consent, from[0].ConsentTime
+		case engine.UserProfile:
+			req.Update, req.Profile = "", from[0].Profile
+		}
+		if _, err = s.Engine.UserDetail(req); err != nil {
+			return res, errors.Wrapf(err, "can't set %s of %s", detail, toID)
+		}
+		if err = s.DeleteUserDetail(siteID, fromID, detail); err != nil {
+			return res, errors.Wrapf(err, "can't delete %s of %s", detail, fromID)
+		}
+		log.Printf("[INFO] audit: %s of %s re-attributed from %s to %s", detail, siteID, fromID, toID)
+	}
+	return res, nil
+}
+
+// reindexUser updates search index for all comments of the user
+func (s *DataStore) reindexUser(siteID, userID string) {
+	s.updateSearchIndex(func(svc *search.Service) error {
+		comments, err := s.userComments(siteID, userID)
+		if err != nil {
+			return err
+		}
+		return svc.IndexBatch(comments)
+	})
+}
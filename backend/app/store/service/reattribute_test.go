@@ -0,0 +1,127 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+func TestService_Reattribute(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), MaxVotes: -1,
+		ConsentVersions: map[string]string{"radio-t": "v1"}}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	// user1 has two comments from prepStoreEngine, user2 votes for them and user3 votes for both user's comments
+	id3, err := b.Create(store.Comment{Text: "user2 comment", Locator: locator, User: store.User{ID: "user2", Name: "new name"}})
+	require.NoError(t, err)
+	_, err = b.Vote(VoteReq{Locator: locator, CommentID: "id-1", UserID: "user2", UserIP: "1", Val: true})
+	require.NoError(t, err)
+	_, err = b.Vote(VoteReq{Locator: locator, CommentID: id3, UserID: "user1", UserIP: "2", Val: true})
+	require.NoError(t, err)
+	_, err = b.Vote(VoteReq{Locator: locator, CommentID: "id-1", UserID: "user3", UserIP: "3", Val: false})
+	require.NoError(t, err)
+	_, err = b.Vote(VoteReq{Locator: locator, CommentID: id3, UserID: "user3", UserIP: "4", Val: false})
+	require.NoError(t, err)
+	_, err = b.SetUserEmail("radio-t", "user3", "user3@example.com")
+	require.NoError(t, err)
+	_, err = b.SetUserConsent("radio-t", "user3", "v1")
+	require.NoError(t, err)
+	_, err = b.SetUserConsent("radio-t", "user4", "v1")
+	require.NoError(t, err)
+
+	res, err := b.Reattribute("radio-t", "user1", "user2", true)
+	require.NoError(t, err)
+	assert.Equal(t, ReattributeResult{From: "user1", To: store.User{ID: "user2", Name: "new name"}, DryRun: true,
+		Comments: []string{"id-1", "id-2"}, Votes: []string{id3}, Details: []engine.UserDetail{}}, res)
+	c, err := b.Engine.Get(engine.GetRequest{Locator: locator, CommentID: "id-1"})
+	require.NoError(t, err)
+	assert.Equal(t, "user1", c.User.ID, "nothing changed in dry-run mode")
+
+	res, err = b.Reattribute("radio-t", "user1", "user2", false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id-1", "id-2"}, res.Comments)
+	assert.Equal(t, []string{id3}, res.Votes)
+	c, err = b.Engine.Get(engine.GetRequest{Locator: locator, CommentID: "id-1"})
+	require.NoError(t, err)
+	assert.Equal(t, store.User{ID: "user2", Name: "new name"}, c.User)
+	c, err = b.Engine.Get(engine.GetRequest{Locator: locator, CommentID: id3})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"user2": true, "user3": false}, c.Votes, "vote moved")
+	assert.Equal(t, 0, c.Score)
+	comments, err := b.User("radio-t", "user2", 0, 0, store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(comments))
+
+	// user3 votes moved to user2 voted for the same comment already, email moved, consent kept by user4
+	res, err = b.Reattribute("radio-t", "user3", "user2", false)
+	assert.EqualError(t, err, "no comments for user user3", "no own comments")
+	_, err = b.Create(store.Comment{Text: "user3 comment", Locator: locator, User: store.User{ID: "user3"}})
+	require.NoError(t, err)
+	_, err = b.SetUserConsent("radio-t", "user2", "v1")
+	require.NoError(t, err)
+	res, err = b.Reattribute("radio-t", "user3", "user2", false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id-1", id3}, res.Votes)
+	assert.Equal(t, []engine.UserDetail{engine.UserEmail}, res.Details)
+	c, err = b.Engine.Get(engine.GetRequest{Locator: locator, CommentID: id3})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"user2": true}, c.Votes, "double vote dropped")
+	assert.Equal(t, 1, c.Score)
+	email, err := b.GetUserEmail("radio-t", "user2")
+	require.NoError(t, err)
+	assert.Equal(t, "user3@example.com", email)
+	email, err = b.GetUserEmail("radio-t", "user3")
+	require.NoError(t, err)
+	assert.Equal(t, "", email)
+	consent, err := b.GetUserConsent("radio-t", "user3")
+	require.NoError(t, err)
+	assert.Equal(t, "v1", consent.Consent, "consent of user2 set already, kept for user3")
+
+	_, err = b.Reattribute("radio-t", "user2", "user2", false)
+	assert.Error(t, err)
+	_, err = b.Reattribute("radio-t", "", "user2", false)
+	assert.Error(t, err)
+}
+
+func TestService_MergeUser(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), MaxVotes: -1}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	// user3 without comments votes and subscribed by email
+	_, err := b.Vote(VoteReq{Locator: locator, CommentID: "id-1", UserID: "user3", UserIP: "3", Val: true})
+	require.NoError(t, err)
+	_, err = b.SetUserEmail("radio-t", "user3", "user3@example.com")
+	require.NoError(t, err)
+
+	res, err := b.MergeUser("radio-t", "user3", "user2")
+	require.NoError(t, err)
+	assert.Equal(t, []string{}, res.Comments)
+	assert.Equal(t, []string{"id-1"}, res.Votes)
+	assert.Equal(t, []engine.UserDetail{engine.UserEmail}, res.Details)
+	c, err := b.Engine.Get(engine.GetRequest{Locator: locator, CommentID: "id-1"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"user2": true}, c.Votes, "vote moved")
+	email, err := b.GetUserEmail("radio-t", "user2")
+	require.NoError(t, err)
+	assert.Equal(t, "user3@example.com", email)
+
+	// user1 with comments merged with comments
+	res, err = b.MergeUser("radio-t", "user1", "user2")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id-1", "id-2"}, res.Comments)
+	count, err := b.UserCount("radio-t", "user2")
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	_, err = b.MergeUser("radio-t", "user2", "user2")
+	assert.Error(t, err)
+}
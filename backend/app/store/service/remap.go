@@ -0,0 +1,74 @@
+package service
+
+import (
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+	"github.com/umputun/remark42/backend/app/store/search"
+)
+
+// RemapResult describes posts moved by RemapURLs, or to be moved in dry-run mode
+type RemapResult struct {
+	Posts    []RemappedPost `json:"posts"`
+	Comments int            `json:"comments"` // number of moved comments
+	DryRun   bool           `json:"dry_run"`
+}
+
+// RemappedPost is a single post moved to the new url
+type RemappedPost struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Comments int    `json:"comments"`
+}
+
+// RemapURLs moves comments of all posts of the site to urls made by mapURL, posts with url not changed kept as is.
+// Unlike remap with export and import, all posts moved by engine in a single transaction, along with info and flags
+// of the posts. Search index of moved comments updated. In dry-run mode nothing changed, the result reports posts
+// to be moved with number of their comments.
+func (s *DataStore) RemapURLs(siteID string, mapURL func(url string) string, dryRun bool) (res RemapResult, err error) {
+	res = RemapResult{Posts: []RemappedPost{}, DryRun: dryRun}
+	posts, err := s.Engine.Info(engine.InfoRequest{Locator: store.Locator{SiteID: siteID}})
+	if err != nil {
+		return res, errors.Wrapf(err, "can't get posts of %s", siteID)
+	}
+
+	req := engine.RemapRequest{Locator: store.Locator{SiteID: siteID}, URLs: map[string]string{}}
+	for _, p := range posts {
+		to := mapURL(p.URL)
+		if to == "" || to == p.URL {
+			continue
+		}
+		req.URLs[p.URL] = to
+		res.Posts = append(res.Posts, RemappedPost{From: p.URL, To: to, Comments: p.Count})
+		res.Comments += p.Count
+	}
+	if len(req.URLs) == 0 || dryRun {
+		return res, nil
+	}
+
+	ids, err := s.Engine.Remap(req)
+	if err != nil {
+		return RemapResult{Posts: []RemappedPost{}}, errors.Wrapf(err, "can't remap posts of %s", siteID)
+	}
+	res.Comments = len(ids)
+	for _, p := range res.Posts {
+		log.Printf("[INFO] audit: post %s of %s remapped to %s", p.From, siteID, p.To)
+	}
+
+	s.updateSearchIndex(func(svc *search.Service) error {
+		for _, p := range res.Posts {
+			comments, e := s.Engine.Find(engine.FindRequest{Locator: store.Locator{SiteID: siteID, URL: p.To}, Sort: "time"})
+			if e != nil {
+				return e
+			}
+			if e = svc.IndexBatch(comments); e != nil {
+				return e
+			}
+		}
+		return nil
+	})
+	log.Printf("[INFO] remapped %d posts of %s, %d comments", len(res.Posts), siteID, res.Comments)
+	return res, nil
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/search"
+)
+
+func TestService_RemapURLs(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	var err error
+	b.SearchService, err = search.NewService([]string{"radio-t"}, search.Params{})
+	require.NoError(t, err)
+	defer b.Close()
+
+	_, err = b.Create(store.Comment{Text: "other post", User: store.User{ID: "user2", Name: "user2"},
+		Locator: store.Locator{URL: "https://example.com/2", SiteID: "radio-t"}})
+	require.NoError(t, err)
+	_, err = b.RebuildSearchIndex("radio-t")
+	require.NoError(t, err)
+	mapURL := func(url string) string {
+		return strings.Replace(url, "https://radio-t.com", "https://new.radio-t.com", 1)
+	}
+
+	res, err := b.RemapURLs("radio-t", mapURL, true)
+	require.NoError(t, err)
+	assert.Equal(t, RemapResult{Posts: []RemappedPost{{From: "https://radio-t.com", To: "https://new.radio-t.com", Comments: 2}},
+		Comments: 2, DryRun: true}, res)
+	comments, err := b.Find(store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, "time", store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(comments), "not moved in dry-run")
+
+	res, err = b.RemapURLs("radio-t", mapURL, false)
+	require.NoError(t, err)
+	assert.Equal(t, RemapResult{Posts: []RemappedPost{{From: "https://radio-t.com", To: "https://new.radio-t.com", Comments: 2}},
+		Comments: 2}, res)
+	comments, err = b.Find(store.Locator{URL: "https://new.radio-t.com", SiteID: "radio-t"}, "time", store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(comments))
+	comments, err = b.Find(store.Locator{URL: "https://example.com/2", SiteID: "radio-t"}, "time", store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(comments), "other post kept")
+
+	found, err := b.Search(search.Request{SiteID: "radio-t", Query: "text text2"}, store.User{})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(found.Comments), "search index updated")
+	assert.Equal(t, "https://new.radio-t.com", found.Comments[0].Locator.URL)
+
+	res, err = b.RemapURLs("radio-t", mapURL, false)
+	require.NoError(t, err)
+	assert.Equal(t, RemapResult{Posts: []RemappedPost{}}, res, "nothing to remap")
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+	"github.com/umputun/remark42/backend/app/store/events"
+)
+
+const maxReportReason = 500 // max length of report reason, in runes
+
+// ReportReq is the request to report the comment to moderators
+type ReportReq struct {
+	Locator   store.Locator
+	CommentID string
+	UserID    string
+	Reason    string
+}
+
+// Report adds report of the user to the comment. Each user can report the comment once.
+// Comment moved to pending once reported by ReportThreshold users, comments of admins are never moved.
+func (s *DataStore) Report(req ReportReq) (comment store.Comment, err error) {
+	reason := strings.TrimSpace(req.Reason)
+	if reason == "" {
+		return comment, errors.New("reason of report is required")
+	}
+	if r := []rune(reason); len(r) > maxReportReason {
+		reason = string(r[:maxReportReason])
+	}
+
+	cLock := s.getScopedLocks(req.Locator.URL) // the same lock used by Vote
+	cLock.Lock()
+	defer cLock.Unlock()
+
+	comment, err = s.Engine.Get(engine.GetRequest{Locator: req.Locator, CommentID: req.CommentID})
+	if err != nil {
+		return comment, err
+	}
+	if comment.Deleted {
+		return comment, errors.Errorf("comment %s deleted", req.CommentID)
+	}
+	if comment.User.ID == req.UserID {
+		return comment, errors.Errorf("user %s can not report own comment %s", req.UserID, req.CommentID)
+	}
+	for _, r := range comment.Reports {
+		if r.UserID == req.UserID {
+			return comment, errors.Errorf("user %s already reported comment %s", req.UserID, req.CommentID)
+		}
+	}
+
+	comment.Reports = append(comment.Reports, store.Report{UserID: req.UserID, Reason: reason, Timestamp: time.Now()})
+	comment.Locator = req.Locator
+	held := !comment.Pending && s.ReportThreshold > 0 && len(comment.Reports) >= s.ReportThreshold &&
+		!s.IsAdmin(req.Locator.SiteID, comment.User.ID)
+	if held {
+		comment.Pending = true
+	}
+	if err = s.Engine.Update(comment); err != nil {
+		return comment, err
+	}
+	if held {
+		log.Printf("[INFO] audit: comment %s moved to pending after %d reports", comment.ID, len(comment.Reports))
+		s.publish(events.Updated, comment)
+	}
+	return comment, nil
+}
+
+// ReportedComments returns not deleted comments of the site with reports of users, the most reported first
+func (s *DataStore) ReportedComments(siteID string) ([]store.Comment, error) {
+	posts, err := s.Engine.Info(engine.InfoRequest{Locator: store.Locator{SiteID: siteID}})
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't get posts of %s", siteID)
+	}
+	res := []store.Comment{}
+	for _, p := range posts {
+		comments, e := s.Engine.Find(engine.FindRequest{Locator: store.Locator{SiteID: siteID, URL: p.URL}, Sort: "time"})
+		if e != nil {
+			return nil, errors.Wrapf(e, "can't get comments of %s", p.URL)
+		}
+		for _, c := range comments {
+			if !c.Deleted && len(c.Reports) > 0 {
+				res = append(res, c)
+			}
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		if len(res[i].Reports) != len(res[j].Reports) {
+			return len(res[i].Reports) > len(res[j].Reports)
+		}
+		return res[i].Timestamp.After(res[j].Timestamp)
+	})
+	return s.alterComments(res, store.User{Admin: true}), nil
+}
+
+// DismissReports removes all reports of the comment, pending status kept as is
+func (s *DataStore) DismissReports(locator store.Locator, commentID string) error {
+	cLock := s.getScopedLocks(locator.URL)
+	cLock.Lock()
+	defer cLock.Unlock()
+
+	comment, err := s.Engine.Get(engine.GetRequest{Locator: locator, CommentID: commentID})
+	if err != nil {
+		return err
+	}
+	comment.Reports = nil
+	comment.Locator = locator
+	return s.Engine.Update(comment)
+}
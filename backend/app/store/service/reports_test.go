@@ -0,0 +1,69 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestService_Report(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticStore("secret 123", []string{"radio-t"}, []string{"admin"}, ""),
+		ReportThreshold: 2}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	_, err := b.Report(ReportReq{Locator: locator, CommentID: "id-1", UserID: "user2", Reason: " "})
+	assert.Error(t, err, "reason required")
+	_, err = b.Report(ReportReq{Locator: locator, CommentID: "id-1", UserID: "user1", Reason: "spam"})
+	assert.Error(t, err, "own comment")
+	_, err = b.Report(ReportReq{Locator: locator, CommentID: "id-bad", UserID: "user2", Reason: "spam"})
+	assert.Error(t, err, "unknown comment")
+
+	c, err := b.Report(ReportReq{Locator: locator, CommentID: "id-1", UserID: "user2", Reason: strings.Repeat("x", 600)})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(c.Reports))
+	assert.Equal(t, "user2", c.Reports[0].UserID)
+	assert.Equal(t, maxReportReason, len(c.Reports[0].Reason), "reason truncated")
+	assert.False(t, c.Pending, "below threshold")
+	_, err = b.Report(ReportReq{Locator: locator, CommentID: "id-1", UserID: "user2", Reason: "spam"})
+	assert.Error(t, err, "reported already")
+
+	_, err = b.Report(ReportReq{Locator: locator, CommentID: "id-2", UserID: "user3", Reason: "offensive"})
+	require.NoError(t, err)
+	c, err = b.Report(ReportReq{Locator: locator, CommentID: "id-2", UserID: "user4", Reason: "spam"})
+	require.NoError(t, err)
+	assert.True(t, c.Pending, "moved to pending by threshold")
+
+	res, err := b.Find(locator, "time", store.User{})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res), "pending comment hidden")
+	assert.Nil(t, res[0].Reports, "reports hidden from users")
+
+	reported, err := b.ReportedComments("radio-t")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(reported))
+	assert.Equal(t, "id-2", reported[0].ID, "the most reported first")
+	assert.Equal(t, 2, len(reported[0].Reports))
+	assert.Equal(t, "id-1", reported[1].ID)
+
+	require.NoError(t, b.DismissReports(locator, "id-2"))
+	reported, err = b.ReportedComments("radio-t")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(reported))
+	assert.Equal(t, "id-1", reported[0].ID)
+	assert.Error(t, b.DismissReports(locator, "id-bad"))
+
+	adminComment, err := b.Create(store.Comment{Text: "admin comment", Locator: locator, User: store.User{ID: "admin"}})
+	require.NoError(t, err)
+	_, err = b.Report(ReportReq{Locator: locator, CommentID: adminComment, UserID: "user3", Reason: "spam"})
+	require.NoError(t, err)
+	c, err = b.Report(ReportReq{Locator: locator, CommentID: adminComment, UserID: "user4", Reason: "spam"})
+	require.NoError(t, err)
+	assert.False(t, c.Pending, "comments of admins not moved to pending")
+}
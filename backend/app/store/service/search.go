@@ -0,0 +1,114 @@
+package service
+
+import (
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+	"github.com/umputun/remark42/backend/app/store/search"
+)
+
+// SearchResult is a page of comments matched search query
+type SearchResult struct {
+	Total    uint64          `json:"total"`
+	Comments []SearchComment `json:"comments"`
+}
+
+// SearchComment is a comment matched search query with fragments of text containing matched terms
+type SearchComment struct {
+	store.Comment
+	Score      float64  `json:"score"`
+	Highlights []string `json:"highlights,omitempty"`
+}
+
+// Search comments of the site with full-text search index. Comments loaded from the engine,
+// the ones missing or deleted since indexing skipped.
+func (s *DataStore) Search(req search.Request, user store.User) (SearchResult, error) {
+	res := SearchResult{Comments: []SearchComment{}}
+	if s.SearchService == nil {
+		return res, errors.New("search disabled")
+	}
+
+	found, err := s.SearchService.Search(req)
+	if err != nil {
+		return res, err
+	}
+	return s.searchResult(req.SiteID, found, user), nil
+}
+
+// Similar returns comments of the post similar to the draft text, for suggesting existing answers before posting
+func (s *DataStore) Similar(req search.SimilarRequest, user store.User) (SearchResult, error) {
+	if s.SearchService == nil {
+		return SearchResult{Comments: []SearchComment{}}, errors.New("search disabled")
+	}
+	found, err := s.SearchService.Similar(req)
+	if err != nil {
+		return SearchResult{Comments: []SearchComment{}}, err
+	}
+	return s.searchResult(req.SiteID, found, user), nil
+}
+
+// RebuildSearchIndex drops search index of the site and indexes all comments from the engine again.
+// Returns number of indexed comments.
+func (s *DataStore) RebuildSearchIndex(siteID string) (int, error) {
+	if s.SearchService == nil {
+		return 0, errors.New("search disabled")
+	}
+	if err := s.SearchService.Clear(siteID); err != nil {
+		return 0, errors.Wrapf(err, "can't clear search index of %s", siteID)
+	}
+
+	posts, err := s.Engine.Info(engine.InfoRequest{Locator: store.Locator{SiteID: siteID}})
+	if err != nil {
+		return 0, errors.Wrapf(err, "can't get posts of %s", siteID)
+	}
+	count := 0
+	for _, p := range posts {
+		comments, e := s.Engine.Find(engine.FindRequest{Locator: store.Locator{SiteID: siteID, URL: p.URL}, Sort: "time"})
+		if e != nil {
+			return count, errors.Wrapf(e, "can't get comments of %s", p.URL)
+		}
+		if e = s.SearchService.IndexBatch(comments); e != nil {
+			return count, errors.Wrapf(e, "can't index comments of %s", p.URL)
+		}
+		count += len(comments)
+	}
+	log.Printf("[INFO] search index of %s rebuilt, %d comments in %d posts", siteID, count, len(posts))
+	return count, nil
+}
+
+// searchResult loads comments of search hits, the ones missing or deleted since indexing skipped.
+// Comments of shadow-banned users skipped for everyone except admins and the authors.
+func (s *DataStore) searchResult(siteID string, found search.Result, user store.User) SearchResult {
+	res := SearchResult{Total: found.Total, Comments: []SearchComment{}}
+	banned := map[string]bool{}
+	if !user.Admin {
+		banned = s.shadowBanned(siteID)
+	}
+	for _, h := range found.Hits {
+		locator := store.Locator{SiteID: siteID, URL: h.URL}
+		c, e := s.Engine.Get(engine.GetRequest{Locator: locator, CommentID: h.ID})
+		if e != nil || c.Deleted {
+			log.Printf("[DEBUG] skip search hit %s, %v", h.ID, e)
+			continue
+		}
+		if banned[c.User.ID] && c.User.ID != user.ID {
+			continue
+		}
+		res.Comments = append(res.Comments, SearchComment{Comment: s.alterComment(c, user), Score: h.Score,
+			Highlights: h.Highlights})
+	}
+	return res
+}
+
+// updateSearchIndex applies fn to search index if search enabled. Index errors only logged,
+// index can be fixed later with RebuildSearchIndex.
+func (s *DataStore) updateSearchIndex(fn func(svc *search.Service) error) {
+	if s.SearchService == nil {
+		return
+	}
+	if err := fn(s.SearchService); err != nil {
+		log.Printf("[WARN] can't update search index, %v", err)
+	}
+}
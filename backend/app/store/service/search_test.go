@@ -0,0 +1,103 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/search"
+)
+
+func TestService_Search(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+
+	_, err := b.Search(search.Request{SiteID: "radio-t", Query: "text"}, store.User{})
+	assert.EqualError(t, err, "search disabled")
+	_, err = b.RebuildSearchIndex("radio-t")
+	assert.EqualError(t, err, "search disabled")
+
+	b.SearchService, err = search.NewService([]string{"radio-t"}, search.Params{})
+	require.NoError(t, err)
+	defer b.Close()
+
+	res, err := b.Search(search.Request{SiteID: "radio-t", Query: "text"}, store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), res.Total, "comments made before search enabled not indexed")
+
+	count, err := b.RebuildSearchIndex("radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	res, err = b.Search(search.Request{SiteID: "radio-t", Query: "text text2", SortBy: "time"}, store.User{})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(res.Comments))
+	assert.Equal(t, "id-1", res.Comments[0].ID)
+	assert.Equal(t, "id-2", res.Comments[1].ID)
+	assert.Equal(t, "user name", res.Comments[0].User.Name)
+	assert.Equal(t, []string{"some <mark>text</mark>, link"}, res.Comments[0].Highlights)
+
+	// new comment indexed
+	id, err := b.Create(store.Comment{Text: "new message", User: store.User{ID: "user2", Name: "user2"},
+		Locator: store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}})
+	require.NoError(t, err)
+	res, err = b.Search(search.Request{SiteID: "radio-t", Query: "message"}, store.User{})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res.Comments))
+	assert.Equal(t, id, res.Comments[0].ID)
+
+	// edited comment re-indexed
+	_, err = b.EditComment(store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}, id,
+		EditRequest{Text: "edited post", Orig: "edited post"})
+	require.NoError(t, err)
+	res, err = b.Search(search.Request{SiteID: "radio-t", Query: "message"}, store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), res.Total)
+	res, err = b.Search(search.Request{SiteID: "radio-t", Query: "edited"}, store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), res.Total)
+
+	// deleted comments removed from index
+	require.NoError(t, b.Delete(store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}, id, store.SoftDelete))
+	res, err = b.Search(search.Request{SiteID: "radio-t", Query: "edited"}, store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), res.Total)
+
+	require.NoError(t, b.DeleteUser("radio-t", "user1", store.HardDelete))
+	res, err = b.Search(search.Request{SiteID: "radio-t", Query: "text text2"}, store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), res.Total)
+
+	_, err = b.Search(search.Request{SiteID: "radio-t", Query: "text:"}, store.User{})
+	assert.Error(t, err)
+}
+
+func TestService_Similar(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+
+	req := search.SimilarRequest{SiteID: "radio-t", URL: "https://radio-t.com", Text: "some more text"}
+	_, err := b.Similar(req, store.User{})
+	assert.EqualError(t, err, "search disabled")
+
+	b.SearchService, err = search.NewService([]string{"radio-t"}, search.Params{})
+	require.NoError(t, err)
+	defer b.Close()
+	_, err = b.RebuildSearchIndex("radio-t")
+	require.NoError(t, err)
+
+	res, err := b.Similar(req, store.User{})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res.Comments), "text2 is a different term")
+	assert.Equal(t, "id-1", res.Comments[0].ID)
+	assert.Equal(t, "user name", res.Comments[0].User.Name)
+
+	req.URL = "https://radio-t.com/2"
+	res, err = b.Similar(req, store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, SearchResult{Comments: []SearchComment{}}, res, "no comments of other post")
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// SentimentAnalyzer scores comment text with lexicon of word valences, AFINN-like model.
+// Each word scored from -5 (very negative) to 5 (very positive), negation flips score of the next word.
+type SentimentAnalyzer struct {
+	lexicon map[string]int
+}
+
+// SentimentStats aggregates scores of comments
+type SentimentStats struct {
+	Comments int     `json:"comments"`
+	Score    float64 `json:"score"` // average normalized score, from -1 to 1
+	Positive int     `json:"positive"`
+	Negative int     `json:"negative"`
+	Neutral  int     `json:"neutral"`
+}
+
+// PostSentiment is sentiment stats for a post
+type PostSentiment struct {
+	URL string `json:"url"`
+	SentimentStats
+}
+
+// DaySentiment is sentiment stats for a day
+type DaySentiment struct {
+	Day string `json:"day"` // in 2006-01-02 format, UTC
+	SentimentStats
+}
+
+// SentimentTrends is sentiment of site or post comments aggregated per post and per day
+type SentimentTrends struct {
+	SiteID string          `json:"site"`
+	URL    string          `json:"url,omitempty"`
+	Since  time.Time       `json:"since"`
+	Total  SentimentStats  `json:"total"`
+	Posts  []PostSentiment `json:"posts"`
+	Days   []DaySentiment  `json:"days"`
+}
+
+// neutralThreshold defines normalized score range (-threshold, threshold) treated as neutral
+const neutralThreshold = 0.05
+
+// negations flip score of the next scored word
+var negations = map[string]bool{"not": true, "no": true, "never": true, "don't": true, "dont": true,
+	"doesn't": true, "doesnt": true, "isn't": true, "isnt": true, "wasn't": true, "wasnt": true,
+	"can't": true, "cant": true, "won't": true, "wont": true, "nothing": true, "nobody": true}
+
+// defaultSentimentLexicon is a compact set of common english words with AFINN valences
+var defaultSentimentLexicon = map[string]int{
+	"abandon": -2, "abuse": -3, "absurd": -2, "accept": 1, "accurate": 2, "admire": 3, "agree": 1, "amazing": 4,
+	"angry": -3, "annoying": -2, "awesome": 4, "awful": -3, "bad": -3, "beautiful": 3, "best": 3, "better": 2,
+	"boring": -3, "brilliant": 4, "broken": -1, "bug": -2, "cool": 1, "crap": -3, "crazy": -2, "cry": -1,
+	"damn": -4, "delight": 3, "disagree": -2, "disappointed": -2, "disaster": -2, "disgusting": -3, "dislike": -2,
+	"dumb": -3, "easy": 1, "enjoy": 2, "error": -2, "excellent": 3, "exciting": 3, "fail": -2, "failed": -2,
+	"fake": -3, "fantastic": 4, "fault": -2, "fine": 2, "fun": 4, "funny": 4, "glad": 3, "good": 3, "great": 3,
+	"happy": 3, "harm": -2, "hate": -3, "helpful": 2, "horrible": -3, "idiot": -3, "impressive": 3,
+	"interesting": 2, "lame": -2, "like": 2, "liked": 2, "lol": 3, "love": 3, "loved": 3, "mess": -2,
+	"mistake": -2, "nice": 3, "outstanding": 5, "pathetic": -2, "perfect": 3, "pity": -2, "pleasant": 3,
+	"poor": -2, "problem": -2, "recommend": 2, "ridiculous": -3, "right": 1, "sad": -2, "shame": -2,
+	"smart": 1, "sorry": -1, "stupid": -2, "success": 2, "super": 3, "superb": 5, "terrible": -3,
+	"thank": 2, "thanks": 2, "thx": 2, "ugly": -3, "useful": 2, "useless": -2, "waste": -1, "weak": -2,
+	"win": 4, "wonderful": 4, "worse": -3, "worst": -3, "wow": 4, "wrong": -2, "yay": 2,
+}
+
+// NewSentimentAnalyzer makes analyzer with built-in lexicon extended (or overridden) by extra words
+func NewSentimentAnalyzer(extra map[string]int) *SentimentAnalyzer {
+	res := SentimentAnalyzer{lexicon: make(map[string]int, len(defaultSentimentLexicon)+len(extra))}
+	for k, v := range defaultSentimentLexicon {
+		res.lexicon[k] = v
+	}
+	for k, v := range extra {
+		res.lexicon[strings.ToLower(k)] = v
+	}
+	return &res
+}
+
+// LoadSentimentLexicon reads lexicon in AFINN format, i.e. "word<tab>score" per line. Empty lines and lines
+// started with # ignored.
+func LoadSentimentLexicon(r io.Reader) (map[string]int, error) {
+	res := map[string]int{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		l := strings.TrimSpace(scanner.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		pos := strings.LastIndexAny(l, "\t ")
+		if pos <= 0 {
+			return nil, errors.Errorf("invalid lexicon line %d: %q", line, l)
+		}
+		score, err := strconv.Atoi(l[pos+1:])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid score in lexicon line %d", line)
+		}
+		res[strings.ToLower(strings.TrimSpace(l[:pos]))] = score
+	}
+	return res, errors.Wrap(scanner.Err(), "can't read lexicon")
+}
+
+// Score returns normalized sentiment score of the text, from -1 (negative) to 1 (positive)
+func (a *SentimentAnalyzer) Score(text string) float64 {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '\''
+	})
+
+	sum, negate := 0, false
+	for _, w := range words {
+		w = strings.Trim(w, "'")
+		if negations[w] {
+			negate = true
+			continue
+		}
+		score, ok := a.lexicon[w]
+		if !ok {
+			continue
+		}
+		if negate {
+			score, negate = -score, false
+		}
+		sum += score
+	}
+	if sum == 0 {
+		return 0
+	}
+	// normalization makes score approach -1 or 1 for texts with many strong words
+	return float64(sum) / math.Sqrt(float64(sum*sum)+15)
+}
+
+// add comment score to stats
+func (s *SentimentStats) add(score float64) {
+	s.Score = (s.Score*float64(s.Comments) + score) / float64(s.Comments+1)
+	s.Comments++
+	switch {
+	case score >= neutralThreshold:
+		s.Positive++
+	case score <= -neutralThreshold:
+		s.Negative++
+	default:
+		s.Neutral++
+	}
+}
+
+// SentimentTrends scores comments of the site (or a single post if locator.URL set) created after since time,
+// and aggregates them per post and per day. Deleted comments ignored.
+func (s *DataStore) SentimentTrends(locator store.Locator, since time.Time) (SentimentTrends, error) {
+	res := SentimentTrends{SiteID: locator.SiteID, URL: locator.URL, Since: since, Posts: []PostSentiment{}, Days: []DaySentiment{}}
+	if s.Sentiment == nil {
+		return res, errors.New("sentiment analysis disabled")
+	}
+
+	urls := []string{locator.URL}
+	if locator.URL == "" {
+		posts, err := s.Engine.Info(engine.InfoRequest{Locator: store.Locator{SiteID: locator.SiteID}})
+		if err != nil {
+			return res, errors.Wrapf(err, "can't get posts of %s", locator.SiteID)
+		}
+		urls = make([]string, 0, len(posts))
+		for _, p := range posts {
+			urls = append(urls, p.URL)
+		}
+	}
+
+	days := map[string]*SentimentStats{}
+	for _, url := range urls {
+		comments, err := s.Engine.Find(engine.FindRequest{Locator: store.Locator{SiteID: locator.SiteID, URL: url}, Since: since})
+		if err != nil {
+			return res, errors.Wrapf(err, "can't get comments of %s", url)
+		}
+		post := PostSentiment{URL: url}
+		for _, c := range comments {
+			if c.Deleted {
+				continue
+			}
+			text := c.Orig
+			if text == "" {
+				text = c.Text
+			}
+			score := s.Sentiment.Score(text)
+			post.add(score)
+			res.Total.add(score)
+			day := c.Timestamp.UTC().Format("2006-01-02")
+			if _, ok := days[day]; !ok {
+				days[day] = &SentimentStats{}
+			}
+			days[day].add(score)
+		}
+		if post.Comments > 0 {
+			res.Posts = append(res.Posts, post)
+		}
+	}
+
+	for day, stats := range days {
+		res.Days = append(res.Days, DaySentiment{Day: day, SentimentStats: *stats})
+	}
+	sort.Slice(res.Days, func(i, j int) bool { return res.Days[i].Day < res.Days[j].Day })
+	sort.Slice(res.Posts, func(i, j int) bool { return res.Posts[i].Comments > res.Posts[j].Comments })
+	return res, nil
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestSentimentAnalyzer_Score(t *testing.T) {
+	a := NewSentimentAnalyzer(map[string]int{"Meh": -1, "bad": 1})
+
+	tbl := []struct {
+		text string
+		res  float64
+	}{
+		{"", 0},
+		{"some neutral text about the weather", 0},
+		{"This is GREAT, thanks!", 5 / math.Sqrt(40)},
+		{"this is not good", -3 / math.Sqrt(24)},
+		{"terrible, awful and ugly", -9 / math.Sqrt(96)},
+		{"meh", -1 / 4.0},
+		{"bad", 1 / 4.0},
+		{"good but wrong", 1 / 4.0},
+		{"don't like it", -2 / math.Sqrt(19)},
+	}
+
+	for _, tt := range tbl {
+		assert.InDelta(t, tt.res, a.Score(tt.text), 0.001, tt.text)
+	}
+}
+
+func TestLoadSentimentLexicon(t *testing.T) {
+	lexicon, err := LoadSentimentLexicon(strings.NewReader("# comment\nabandon\t-2\n\ncan't stand\t-3\nWow 4\n"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"abandon": -2, "can't stand": -3, "wow": 4}, lexicon)
+
+	_, err = LoadSentimentLexicon(strings.NewReader("abandon\tbad\n"))
+	assert.Error(t, err)
+	_, err = LoadSentimentLexicon(strings.NewReader("abandon\n"))
+	assert.EqualError(t, err, `invalid lexicon line 1: "abandon"`)
+}
+
+func TestService_SentimentTrends(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+
+	_, err := b.SentimentTrends(store.Locator{SiteID: "radio-t"}, time.Time{})
+	assert.EqualError(t, err, "sentiment analysis disabled")
+	b.Sentiment = NewSentimentAnalyzer(nil)
+
+	day1 := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	day2 := time.Date(2020, 5, 2, 10, 0, 0, 0, time.UTC)
+	for i, c := range []store.Comment{
+		{Orig: "great post, thanks", Timestamp: day1, Locator: store.Locator{URL: "https://radio-t.com/1"}},
+		{Orig: "awful and wrong", Timestamp: day1.Add(time.Hour), Locator: store.Locator{URL: "https://radio-t.com/1"}},
+		{Orig: "just a text", Timestamp: day2, Locator: store.Locator{URL: "https://radio-t.com/1"}},
+		{Orig: "love it", Timestamp: day2, Locator: store.Locator{URL: "https://radio-t.com/2"}},
+		{Orig: "hate it", Timestamp: day2, Locator: store.Locator{URL: "https://radio-t.com/2"}, Deleted: true},
+	} {
+		c.ID = "s-" + string(rune('a'+i))
+		c.Text = c.Orig
+		c.Locator.SiteID = "radio-t"
+		c.User = store.User{ID: "user1", Name: "user name"}
+		_, err = eng.Create(c)
+		require.NoError(t, err)
+	}
+
+	trends, err := b.SentimentTrends(store.Locator{SiteID: "radio-t"}, day1.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 4, trends.Total.Comments, "old and deleted comments ignored")
+	assert.Equal(t, 2, trends.Total.Positive)
+	assert.Equal(t, 1, trends.Total.Negative)
+	assert.Equal(t, 1, trends.Total.Neutral)
+
+	require.Equal(t, 2, len(trends.Posts))
+	assert.Equal(t, "https://radio-t.com/1", trends.Posts[0].URL)
+	assert.Equal(t, 3, trends.Posts[0].Comments)
+	assert.Equal(t, "https://radio-t.com/2", trends.Posts[1].URL)
+	assert.Equal(t, 1, trends.Posts[1].Comments)
+	assert.True(t, trends.Posts[1].Score > 0.5)
+
+	require.Equal(t, 2, len(trends.Days))
+	assert.Equal(t, "2020-05-01", trends.Days[0].Day)
+	assert.Equal(t, 2, trends.Days[0].Comments)
+	assert.Equal(t, 1, trends.Days[0].Positive)
+	assert.Equal(t, 1, trends.Days[0].Negative)
+	assert.Equal(t, "2020-05-02", trends.Days[1].Day)
+	assert.Equal(t, 2, trends.Days[1].Comments)
+
+	trends, err = b.SentimentTrends(store.Locator{SiteID: "radio-t", URL: "https://radio-t.com/1"}, day2.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, trends.Total.Comments)
+	assert.Equal(t, 1, trends.Total.Neutral)
+	require.Equal(t, 1, len(trends.Days))
+
+	_, err = b.SentimentTrends(store.Locator{SiteID: "bad-site"}, time.Time{})
+	assert.Error(t, err)
+}
@@ -3,6 +3,8 @@
 package service
 
 import (
+	"context"
+	"io"
 	"math"
 	"sort"
 	"strings"
@@ -15,10 +17,14 @@ import (
 	"github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
 
+	"github.com/umputun/remark42/backend/app/logging"
 	"github.com/umputun/remark42/backend/app/store"
 	"github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/attachment"
 	"github.com/umputun/remark42/backend/app/store/engine"
+	"github.com/umputun/remark42/backend/app/store/events"
 	"github.com/umputun/remark42/backend/app/store/image"
+	"github.com/umputun/remark42/backend/app/store/search"
 )
 
 // DataStore wraps store.Interface with additional methods
@@ -36,7 +42,25 @@ type DataStore struct {
 	TitleExtractor         *TitleExtractor
 	RestrictedWordsMatcher *RestrictedWordsMatcher
 	ImageService           *image.Service
-	AdminEdits             bool // allow admin unlimited edits
+	AdminEdits             bool               // allow admin unlimited edits
+	Sentiment              *SentimentAnalyzer // optional, enables sentiment trends
+	SearchService          *search.Service    // optional, enables full-text search
+	SlowModeDelay          time.Duration      // delay of public visibility of new comments in slow mode posts, 0 disables
+	ConsentVersions        map[string]string  // version of legal terms users should accept before commenting, per site
+	MaxRevisions           int                // max number of kept revisions of edited comment, 0 disables edit history
+	Events                 *events.Bus        // optional, receives events of created, edited and deleted comments
+	Reactions              []string           // allowed reactions to comments, like "heart", reactions disabled if empty
+	SiteSettings           SiteSettings       // optional, per-site settings changed at runtime, overrides MaxCommentSize
+	ScorePolicy            *ScorePolicy       // optional, sets community state of low-score comments
+	ExternalIDs            ExternalIDs        // optional, unique index of external ids of comments set by integrations
+	NewID                  func() string      // optional, generates ids of new comments, uuid by default
+	RestrictedNames        []string           // names prohibited as display name in profile of non-admin users
+	ReportThreshold        int                // number of user reports moving comment to pending, 0 disables
+	Trash                  Trash              // optional, keeps soft-deleted comments to undelete them
+	TrashRetention         time.Duration      // time soft-deleted comments kept in trash
+
+	// optional, non-image files attached to comments, staged and committed like images
+	AttachmentService *attachment.Service
 
 	// granular locks
 	scopedLocks struct {
@@ -59,6 +83,7 @@ type UserMetaData struct {
 		Until  time.Time `json:"until"`
 	} `json:"blocked"`
 	Verified bool                   `json:"verified"`
+	Shadowed bool                   `json:"shadowed,omitempty"`
 	Details  engine.UserDetailEntry `json:"details,omitempty"`
 }
 
@@ -69,8 +94,17 @@ type PostMetaData struct {
 }
 
 const defaultCommentMaxSize = 2000
+
+// SiteSettings provides per-site settings changed at runtime
+type SiteSettings interface {
+	MaxCommentSize(siteID string) int
+	MaxReplyDepth(siteID string) int // max nesting level of replies, 0 unlimited
+}
+
 const maxLastCommentsReply = 5000
 
+const maxReplyDepthLookup = 1000 // max number of ancestors checked by limitDepth, protects from loops of parents
+
 // UnlimitedVotes doesn't restrict MaxVotes
 const UnlimitedVotes = -1
 
@@ -81,7 +115,13 @@ var ErrRestrictedWordsFound = errors.New("comment contains restricted words")
 
 // Create prepares comment and forward to Interface.Create
 func (s *DataStore) Create(comment store.Comment) (commentID string, err error) {
+	return s.CreateContext(context.Background(), comment)
+}
+
+// CreateContext is Create logging with fields of the caller's request kept in ctx
+func (s *DataStore) CreateContext(ctx context.Context, comment store.Comment) (commentID string, err error) {
 
+	comment = s.limitDepth(comment)
 	if comment, err = s.prepareNewComment(comment); err != nil {
 		return "", errors.Wrap(err, "failed to prepare comment")
 	}
@@ -91,22 +131,36 @@ func (s *DataStore) Create(comment store.Comment) (commentID string, err error)
 	}
 
 	func() { // keep input title and set to extracted if missing
-		if s.TitleExtractor == nil || comment.PostTitle != "" {
+		if s.TitleExtractor == nil || comment.PostTitle != "" || comment.Locator.IsVirtual() {
 			return
 		}
 		title, e := s.TitleExtractor.Get(comment.Locator.URL)
 		if e != nil {
-			log.Printf("[WARN] failed to set title, %v", e)
+			logging.Printf(ctx, "[WARN] failed to set title, %v", e)
 			return
 		}
 		comment.PostTitle = title
 	}()
 
+	release, err := s.reserveExternalID(comment)
+	if err != nil {
+		return "", err
+	}
+
+	comment.Quality = quality(comment, 0, s.karma(comment.Locator.SiteID, comment.User.ID))
 	commentID, err = s.Engine.Create(comment)
 	s.submitImages(comment)
+	if err != nil {
+		release()
+	}
+	if err == nil {
+		s.updateSearchIndex(func(svc *search.Service) error { return svc.Index(comment) })
+		s.updateParentQuality(comment)
+		s.publish(events.Created, comment)
+	}
 
 	if e := s.AdminStore.OnEvent(comment.Locator.SiteID, admin.EvCreate); e != nil {
-		log.Printf("[WARN] failed to send create event, %s", e)
+		logging.Printf(ctx, "[WARN] failed to send create event, %s", e)
 	}
 	return commentID, err
 }
@@ -126,7 +180,13 @@ func (s *DataStore) FindSince(locator store.Locator, sortMethod string, user sto
 	}
 
 	changedSort := false
-	// sets votes controversy for comments added prior to #274
+	replies := map[string]int{} // used for quality of comments added before quality scoring
+	for _, c := range comments {
+		if c.ParentID != "" && !c.Deleted {
+			replies[c.ParentID]++
+		}
+	}
+	// sets votes controversy for comments added prior to #274 and best score for comments voted before it was added
 	// also sanitizes locator.URL for comments added prior to #927
 	for i, c := range comments {
 		if c.Controversy == 0 && len(c.Votes) > 0 {
@@ -135,6 +195,17 @@ func (s *DataStore) FindSince(locator store.Locator, sortMethod string, user sto
 				changedSort = true
 			}
 		}
+		if c.Best == 0 && len(c.Votes) > 0 {
+			if c.Best = best(s.upsAndDowns(c)); c.Best != 0 && !changedSort && strings.Contains(sortMethod, "best") {
+				changedSort = true
+			}
+		}
+		if c.Quality == 0 && !c.Deleted {
+			c.Quality = quality(c, replies[c.ID], 0) // karma skipped, too expensive for each comment
+			if !changedSort && strings.Contains(sortMethod, "quality") {
+				changedSort = true
+			}
+		}
 		comments[i] = s.alterComment(c, user)
 	}
 
@@ -143,7 +214,10 @@ func (s *DataStore) FindSince(locator store.Locator, sortMethod string, user sto
 		comments = engine.SortComments(comments, sortMethod)
 	}
 
-	return comments, nil
+	if s.SlowModeDelay > 0 {
+		comments = s.hideDelayed(comments, user)
+	}
+	return s.hideShadowBanned(hidePending(comments, user), user), nil
 }
 
 // Get comment by ID
@@ -158,7 +232,11 @@ func (s *DataStore) Get(locator store.Locator, commentID string, user store.User
 // Put updates comment, mutable parts only
 func (s *DataStore) Put(locator store.Locator, comment store.Comment) error {
 	comment.Locator = locator
-	return s.Engine.Update(comment)
+	if err := s.Engine.Update(comment); err != nil {
+		return err
+	}
+	s.updateSearchIndex(func(svc *search.Service) error { return svc.Index(comment) })
+	return nil
 }
 
 // GetUserEmail gets user email
@@ -194,6 +272,23 @@ func (s *DataStore) SetUserEmail(siteID, userID, value string) (string, error) {
 	return "", nil
 }
 
+// UserEmails lists emails of all users of the site with email set
+func (s *DataStore) UserEmails(siteID string) ([]engine.UserDetailEntry, error) {
+	details, err := s.Engine.UserDetail(engine.UserDetailRequest{Detail: engine.AllUserDetails,
+		Locator: store.Locator{SiteID: siteID}})
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't list user details for %s", siteID)
+	}
+	res := []engine.UserDetailEntry{}
+	for _, d := range details {
+		if d.Email == "" {
+			continue
+		}
+		res = append(res, engine.UserDetailEntry{UserID: d.UserID, Email: d.Email})
+	}
+	return res, nil
+}
+
 // DeleteUserDetail deletes user detail
 func (s *DataStore) DeleteUserDetail(siteID, userID string, detail engine.UserDetail) error {
 	return s.Engine.Delete(engine.DeleteRequest{
@@ -203,7 +298,26 @@ func (s *DataStore) DeleteUserDetail(siteID, userID string, detail engine.UserDe
 	})
 }
 
-// ResubmitStagingImages retrieves timestamp of the oldest image in staging and
+// UnsubscribeEmail removes email from all users of the site subscribed with it, returns list of affected user ids
+func (s *DataStore) UnsubscribeEmail(siteID, email string) ([]string, error) {
+	details, err := s.Engine.UserDetail(engine.UserDetailRequest{Detail: engine.AllUserDetails, Locator: store.Locator{SiteID: siteID}})
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't list user details for %s", siteID)
+	}
+	res := []string{}
+	for _, d := range details {
+		if d.Email == "" || !strings.EqualFold(strings.TrimSpace(d.Email), strings.TrimSpace(email)) {
+			continue
+		}
+		if err = s.DeleteUserDetail(siteID, d.UserID, engine.UserEmail); err != nil {
+			return res, errors.Wrapf(err, "can't unsubscribe user %s", d.UserID)
+		}
+		res = append(res, d.UserID)
+	}
+	return res, nil
+}
+
+// ResubmitStagingImages retrieves timestamp of the oldest image (or attachment) in staging and
 // calls s.submitImages on all comments newer than it
 func (s *DataStore) ResubmitStagingImages(sites []string) error {
 	info, err := s.ImageService.Info()
@@ -211,6 +325,15 @@ func (s *DataStore) ResubmitStagingImages(sites []string) error {
 		return err
 	}
 	ts := info.FirstStagingImageTS
+	if s.AttachmentService.Enabled() {
+		filesInfo, e := s.AttachmentService.Info()
+		if e != nil {
+			return e
+		}
+		if !filesInfo.FirstStagingImageTS.IsZero() && (ts.IsZero() || filesInfo.FirstStagingImageTS.Before(ts)) {
+			ts = filesInfo.FirstStagingImageTS
+		}
+	}
 	if ts.IsZero() {
 		return nil
 	}
@@ -226,37 +349,89 @@ func (s *DataStore) ResubmitStagingImages(sites []string) error {
 	return result.ErrorOrNil()
 }
 
-// submitImages initiated delayed commit of all images from the comment uploaded to remark42
+// submitImages initiated delayed commit of all images and attachments from the comment uploaded to remark42
 func (s *DataStore) submitImages(comment store.Comment) {
-	idsFn := func() []string { // get all ids from comment's text
-		// this can be called after last edit, we have to retrieve fresh comment
-		cc, err := s.Engine.Get(engine.GetRequest{Locator: comment.Locator, CommentID: comment.ID})
-		if err != nil {
-			log.Printf("[WARN] can't get comment's %s text for image extraction, %v", comment.ID, err)
-			return nil
-		}
-		imgIDs := s.ImageService.ExtractPictures(cc.Text)
-		if len(imgIDs) > 0 {
-			log.Printf("[DEBUG] image ids extracted from %s - %+v", comment.ID, imgIDs)
+	// extract returns function getting ids from comment's text
+	extract := func(kind string, fn func(commentHTML string) []string) func() []string {
+		return func() []string {
+			// this can be called after last edit, we have to retrieve fresh comment
+			cc, err := s.Engine.Get(engine.GetRequest{Locator: comment.Locator, CommentID: comment.ID})
+			if err != nil {
+				log.Printf("[WARN] can't get comment's %s text for %s extraction, %v", comment.ID, kind, err)
+				return nil
+			}
+			ids := fn(cc.Text)
+			if len(ids) > 0 {
+				log.Printf("[DEBUG] %s ids extracted from %s - %+v", kind, comment.ID, ids)
+			}
+			return ids
 		}
-		return imgIDs
 	}
 
+	idsFn := extract("image", s.ImageService.ExtractPictures)
 	var err error
 	if comment.Imported {
 		err = s.ImageService.SubmitAndCommit(idsFn)
 	} else {
 		s.ImageService.Submit(idsFn)
 	}
-
 	if err != nil {
 		log.Printf("[WARN] failed to commit comment's images: %v", err)
 	}
+
+	if !s.AttachmentService.Enabled() {
+		return
+	}
+	filesFn := extract("attachment", s.AttachmentService.Extract)
+	if comment.Imported {
+		if err = s.AttachmentService.SubmitAndCommit(filesFn); err != nil {
+			log.Printf("[WARN] failed to commit comment's attachments: %v", err)
+		}
+		return
+	}
+	s.AttachmentService.Submit(filesFn)
+}
+
+// limitDepth attaches reply nested deeper than allowed for the site to the ancestor on the last allowed level,
+// with "replying to @user" marker of the replied comment added to the text
+func (s *DataStore) limitDepth(comment store.Comment) store.Comment {
+	if s.SiteSettings == nil || comment.ParentID == "" {
+		return comment
+	}
+	maxDepth := s.SiteSettings.MaxReplyDepth(comment.Locator.SiteID)
+	if maxDepth <= 0 {
+		return comment
+	}
+
+	// ancestors from parent to top-level comment, level of the reply is the number of ancestors
+	ancestors := []store.Comment{}
+	for parentID := comment.ParentID; parentID != "" && len(ancestors) < maxReplyDepthLookup; {
+		parent, err := s.Engine.Get(engine.GetRequest{Locator: comment.Locator, CommentID: parentID})
+		if err != nil {
+			log.Printf("[WARN] can't get parent %s of comment %s, %v", parentID, comment.ID, err)
+			return comment
+		}
+		ancestors = append(ancestors, parent)
+		parentID = parent.ParentID
+	}
+	if len(ancestors) <= maxDepth {
+		return comment
+	}
+
+	comment.ParentID = ancestors[len(ancestors)-maxDepth].ID
+	if name := ancestors[0].User.Name; name != "" {
+		comment.Text = "<p>replying to @" + name + "</p>" + comment.Text
+		comment.Orig = "replying to @" + name + "\n\n" + comment.Orig
+	}
+	return comment
 }
 
 // prepareNewComment sets new comment fields, hashing and sanitizing data
 func (s *DataStore) prepareNewComment(comment store.Comment) (store.Comment, error) {
 	// fill ID and time if empty
+	if comment.ID == "" && s.NewID != nil {
+		comment.ID = s.NewID()
+	}
 	if comment.ID == "" {
 		comment.ID = uuid.New().String()
 	}
@@ -267,6 +442,9 @@ func (s *DataStore) prepareNewComment(comment store.Comment) (store.Comment, err
 	if comment.Votes == nil {
 		comment.Votes = make(map[string]bool)
 	}
+	if comment.Raw == "" {
+		comment.Raw = comment.Orig // kept as is, Orig sanitized below
+	}
 	comment.Sanitize() // clear potentially dangerous js from all parts of comment
 
 	secret, err := s.getSecret(comment.Locator.SiteID)
@@ -280,15 +458,25 @@ func (s *DataStore) prepareNewComment(comment store.Comment) (store.Comment, err
 // DeleteAll removes all data from site
 func (s *DataStore) DeleteAll(siteID string) error {
 	req := engine.DeleteRequest{Locator: store.Locator{SiteID: siteID}}
-	return s.Engine.Delete(req)
+	if err := s.Engine.Delete(req); err != nil {
+		return err
+	}
+	s.updateSearchIndex(func(svc *search.Service) error { return svc.Clear(siteID) })
+	return nil
 }
 
-// SetPin pin/un-pin comment as special
+// SetPin pin/un-pin comment as special. Only top-level comments can be pinned, pinned comments go first in the tree.
 func (s *DataStore) SetPin(locator store.Locator, commentID string, status bool) error {
 	comment, err := s.Engine.Get(engine.GetRequest{Locator: locator, CommentID: commentID})
 	if err != nil {
 		return err
 	}
+	if status && comment.ParentID != "" {
+		return errors.Errorf("reply %s can't be pinned, only top-level comments", commentID)
+	}
+	if status && comment.Deleted {
+		return errors.Errorf("deleted comment %s can't be pinned", commentID)
+	}
 	comment.Pin = status
 	comment.Locator = locator
 	return s.Engine.Update(comment)
@@ -305,6 +493,11 @@ type VoteReq struct {
 
 // Vote for comment by id and locator
 func (s *DataStore) Vote(req VoteReq) (comment store.Comment, err error) {
+	return s.VoteContext(context.Background(), req)
+}
+
+// VoteContext is Vote logging with fields of the caller's request kept in ctx
+func (s *DataStore) VoteContext(ctx context.Context, req VoteReq) (comment store.Comment, err error) {
 
 	cLock := s.getScopedLocks(req.Locator.URL) // get lock for URL scope
 	cLock.Lock()                               // prevents race on voting
@@ -384,11 +577,13 @@ func (s *DataStore) Vote(req VoteReq) (comment store.Comment, err error) {
 	}
 
 	if e := s.AdminStore.OnEvent(comment.Locator.SiteID, admin.EvVote); e != nil {
-		log.Printf("[WARN] failed to send vote event, %s", e)
+		logging.Printf(ctx, "[WARN] failed to send vote event, %s", e)
 	}
 
 	comment.Controversy = s.controversy(s.upsAndDowns(comment))
+	comment.Best = best(s.upsAndDowns(comment))
 	comment.Locator = req.Locator
+	s.updateQuality(&comment)
 	return comment, s.Engine.Update(comment)
 }
 
@@ -424,6 +619,23 @@ func (s *DataStore) controversy(ups, downs int) float64 {
 	return math.Pow(float64(magnitude), balance)
 }
 
+// best calculates lower bound of Wilson score confidence interval for a Bernoulli parameter, i.e. "best" sort of reddit.
+// Comments with few votes get low score until enough votes collected, unlike raw score or ratio of ups.
+// source - https://github.com/reddit-archive/reddit/blob/master/r2/r2/lib/db/_sorts.pyx#L70
+func best(ups, downs int) float64 {
+	n := float64(ups + downs)
+	if n == 0 {
+		return 0
+	}
+
+	const z = 1.281551565545 // 80% confidence
+	p := float64(ups) / n
+	left := p + z*z/(2*n)
+	right := z * math.Sqrt(p*(1-p)/n+z*z/(4*n*n))
+	under := 1 + z*z/n
+	return (left - right) / under
+}
+
 // EditRequest contains fields needed for comment update
 type EditRequest struct {
 	Text    string
@@ -431,6 +643,7 @@ type EditRequest struct {
 	Summary string
 	Delete  bool
 	Admin   bool
+	UserID  string // editor, kept in edit history
 }
 
 // EditComment to edit text and update Edit info
@@ -465,27 +678,42 @@ func (s *DataStore) EditComment(locator store.Locator, commentID string, req Edi
 		if e := s.AdminStore.OnEvent(comment.Locator.SiteID, admin.EvDelete); e != nil {
 			log.Printf("[WARN] failed to send delete event, %s", e)
 		}
+		drop := s.trashComment(locator, commentID, store.SoftDelete)
 		comment.Deleted = true
 		delReq := engine.DeleteRequest{Locator: locator, CommentID: commentID, DeleteMode: store.SoftDelete}
-		return comment, s.Engine.Delete(delReq)
+		if err = s.Engine.Delete(delReq); err != nil {
+			drop()
+			return comment, err
+		}
+		s.updateSearchIndex(func(svc *search.Service) error { return svc.Delete(locator.SiteID, commentID) })
+		s.publish(events.Deleted, comment)
+		return comment, nil
 	}
 
 	if s.RestrictedWordsMatcher != nil && s.RestrictedWordsMatcher.Match(comment.Locator.SiteID, req.Text) {
 		return comment, ErrRestrictedWordsFound
 	}
 
+	editTime := time.Now()
+	s.addRevision(&comment, req, editTime)
 	comment.Text = req.Text
-	comment.Orig = req.Orig
-	comment.Edit = &store.Edit{Timestamp: time.Now(), Summary: req.Summary}
+	comment.Orig, comment.Raw = req.Orig, req.Orig
+	comment.Edit = &store.Edit{Timestamp: editTime, Summary: req.Summary}
 	comment.Locator = locator
 	comment.Sanitize()
+	s.updateQuality(&comment)
 
 	if e := s.AdminStore.OnEvent(comment.Locator.SiteID, admin.EvUpdate); e != nil {
 		log.Printf("[WARN] failed to send update event, %s", e)
 	}
 
-	err = s.Engine.Update(comment)
-	return comment, err
+	if err = s.Engine.Update(comment); err != nil {
+		return comment, err
+	}
+	s.updateSearchIndex(func(svc *search.Service) error { return svc.Index(comment) })
+	s.publish(events.Updated, comment)
+	comment.Revisions = nil // available with History only
+	return comment, nil
 }
 
 // HasReplies checks if there is any reply to the comments
@@ -564,6 +792,9 @@ func (s *DataStore) SetTitle(locator store.Locator, commentID string) (comment s
 	if s.TitleExtractor == nil {
 		return comment, errors.New("no title extractor")
 	}
+	if locator.IsVirtual() {
+		return comment, errors.New("no page of virtual locator to get title from")
+	}
 
 	comment, err = s.Engine.Get(engine.GetRequest{Locator: locator, CommentID: commentID})
 	if err != nil {
@@ -595,10 +826,7 @@ func (s *DataStore) Counts(siteID string, postIDs []string) ([]store.PostInfo, e
 
 // ValidateComment checks if comment size below max and user fields set
 func (s *DataStore) ValidateComment(c *store.Comment) error {
-	maxSize := s.MaxCommentSize
-	if s.MaxCommentSize <= 0 {
-		maxSize = defaultCommentMaxSize
-	}
+	maxSize := s.maxCommentSize(c.Locator.SiteID)
 	if c.Orig == "" {
 		return errors.New("empty comment text")
 	}
@@ -608,7 +836,7 @@ func (s *DataStore) ValidateComment(c *store.Comment) error {
 	if c.User.ID == "" || c.User.Name == "" {
 		return errors.Errorf("empty user info")
 	}
-	return nil
+	return s.validateExternalID(c.ExternalID)
 }
 
 // IsAdmin checks if usesID in the list of admins
@@ -712,14 +940,75 @@ func (s *DataStore) Delete(locator store.Locator, commentID string, mode store.D
 	if e := s.AdminStore.OnEvent(locator.SiteID, admin.EvDelete); e != nil {
 		log.Printf("[WARN] failed to send delete event, %s", e)
 	}
+	drop := s.trashComment(locator, commentID, mode)
 	req := engine.DeleteRequest{Locator: locator, CommentID: commentID, DeleteMode: mode}
-	return s.Engine.Delete(req)
+	if err := s.Engine.Delete(req); err != nil {
+		drop()
+		return err
+	}
+	s.updateSearchIndex(func(svc *search.Service) error { return svc.Delete(locator.SiteID, commentID) })
+	s.publish(events.Deleted, store.Comment{ID: commentID, Locator: locator})
+	return nil
+}
+
+// DeletePost removes post with all comments
+func (s *DataStore) DeletePost(locator store.Locator) error {
+	if locator.URL == "" {
+		return errors.New("post url is required")
+	}
+	req := engine.DeleteRequest{Locator: locator}
+	if err := s.Engine.Delete(req); err != nil {
+		return err
+	}
+	s.updateSearchIndex(func(svc *search.Service) error { return svc.DeletePost(locator) })
+	return nil
 }
 
 // DeleteUser removes all comments from user
 func (s *DataStore) DeleteUser(siteID, userID string, mode store.DeleteMode) error {
 	req := engine.DeleteRequest{Locator: store.Locator{SiteID: siteID}, UserID: userID, DeleteMode: mode}
-	return s.Engine.Delete(req)
+	if err := s.Engine.Delete(req); err != nil {
+		return err
+	}
+	s.updateSearchIndex(func(svc *search.Service) error { return svc.DeleteUser(siteID, userID) })
+	return nil
+}
+
+// Integrity runs consistency check for the site and repairs found problems if req.Repair set.
+// Supported by engines implementing engine.IntegrityChecker only.
+func (s *DataStore) Integrity(req engine.IntegrityRequest) (engine.IntegrityReport, error) {
+	checker, ok := s.Engine.(engine.IntegrityChecker)
+	if !ok {
+		return engine.IntegrityReport{}, errors.New("integrity check is not supported by store engine")
+	}
+	return checker.Integrity(req)
+}
+
+// BackupSite writes copy of the site's storage as is, if supported by store engine
+func (s *DataStore) BackupSite(siteID string, w io.Writer) error {
+	backuper, ok := s.Engine.(engine.SiteBackuper)
+	if !ok {
+		return errors.New("backup of site storage is not supported by store engine")
+	}
+	return backuper.BackupSite(siteID, w)
+}
+
+// RestoreSite replaces storage of the site with copy made by BackupSite, if supported by store engine
+func (s *DataStore) RestoreSite(siteID string, r io.Reader) error {
+	backuper, ok := s.Engine.(engine.SiteBackuper)
+	if !ok {
+		return errors.New("restore of site storage is not supported by store engine")
+	}
+	return backuper.RestoreSite(siteID, r)
+}
+
+// DetachSite stops serving the site keeping its storage, returns location of the storage
+func (s *DataStore) DetachSite(siteID string) (string, error) {
+	detacher, ok := s.Engine.(engine.SiteDetacher)
+	if !ok {
+		return "", errors.New("detaching of sites is not supported by store engine")
+	}
+	return detacher.DetachSite(siteID)
 }
 
 // List of commented posts
@@ -784,6 +1073,20 @@ func (s *DataStore) Metas(siteID string) (umetas []UserMetaData, pmetas []PostMe
 		m[v] = val
 	}
 
+	// process shadow-banned users
+	shadowed, err := s.ShadowBannedUsers(siteID)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "can't get list of shadow-banned users for %s", siteID)
+	}
+	for _, userID := range shadowed {
+		val, ok := m[userID]
+		if !ok {
+			val = UserMetaData{ID: userID}
+		}
+		val.Shadowed = true
+		m[userID] = val
+	}
+
 	// process users details
 	usersDetails, err := s.Engine.UserDetail(engine.UserDetailRequest{Locator: store.Locator{SiteID: siteID}, Detail: engine.AllUserDetails})
 	if err != nil {
@@ -825,17 +1128,38 @@ func (s *DataStore) SetMetas(siteID string, umetas []UserMetaData, pmetas []Post
 		if um.Verified {
 			errs = multierror.Append(errs, s.SetVerified(siteID, um.ID, true))
 		}
+		if um.Shadowed {
+			errs = multierror.Append(errs, s.SetShadowBan(siteID, um.ID, true))
+		}
 		// this code doesn't delete user details in case they are not set in import but present in DB already
 		if um.Details.Email != "" {
 			req := engine.UserDetailRequest{Locator: store.Locator{SiteID: siteID}, UserID: um.ID, Detail: engine.UserEmail, Update: um.Details.Email}
 			_, err := s.Engine.UserDetail(req)
 			errs = multierror.Append(errs, err)
 		}
+		if um.Details.Consent != "" {
+			req := engine.UserDetailRequest{Locator: store.Locator{SiteID: siteID}, UserID: um.ID, Detail: engine.UserConsent,
+				Update: um.Details.Consent, Time: um.Details.ConsentTime}
+			_, err := s.Engine.UserDetail(req)
+			errs = multierror.Append(errs, err)
+		}
+		if um.Details.Profile != nil {
+			req := engine.UserDetailRequest{Locator: store.Locator{SiteID: siteID}, UserID: um.ID, Detail: engine.UserProfile,
+				Profile: um.Details.Profile}
+			_, err := s.Engine.UserDetail(req)
+			errs = multierror.Append(errs, err)
+		}
 	}
 
 	return errs.ErrorOrNil()
 }
 
+// Dump returns comments of the post as stored, sorted by time. Unlike Find, comments keep votes, voted ips,
+// reactions of users and revisions, used by backups.
+func (s *DataStore) Dump(locator store.Locator) ([]store.Comment, error) {
+	return s.Engine.Find(engine.FindRequest{Locator: locator, Sort: "time"})
+}
+
 // User gets comment for given userID on siteID
 func (s *DataStore) User(siteID, userID string, limit, skip int, user store.User) ([]store.Comment, error) {
 	req := engine.FindRequest{Locator: store.Locator{SiteID: siteID}, UserID: userID,
@@ -844,7 +1168,67 @@ func (s *DataStore) User(siteID, userID string, limit, skip int, user store.User
 	if err != nil {
 		return comments, err
 	}
-	return s.alterComments(comments, user), nil
+	return s.alterComments(s.hideShadowBanned(comments, user), user), nil
+}
+
+// UserLimits describes what user allowed to do on the site
+type UserLimits struct {
+	Blocked        bool         `json:"blocked"`
+	Verified       bool         `json:"verified"`
+	MaxCommentSize int          `json:"max_comment_size"`
+	MaxVotes       int          `json:"max_votes"`     // max votes per comment, -1 for unlimited
+	EditDuration   int          `json:"edit_duration"` // in seconds, 0 for unlimited
+	Edits          []EditWindow `json:"edits"`
+}
+
+// EditWindow describes if user's comment can be edited and for how long
+type EditWindow struct {
+	ID        string     `json:"id"`
+	URL       string     `json:"url"`
+	Editable  bool       `json:"editable"`
+	Until     *time.Time `json:"until,omitempty"` // nil for unlimited edit
+	Remaining int        `json:"remaining"`       // seconds left to edit, -1 for unlimited
+}
+
+// UserLimits returns limits for the user and edit windows for up to recent latest user's comments
+func (s *DataStore) UserLimits(siteID, userID string, recent int) (UserLimits, error) {
+	res := UserLimits{
+		Blocked:        s.IsBlocked(siteID, userID),
+		Verified:       s.IsVerified(siteID, userID),
+		MaxCommentSize: s.maxCommentSize(siteID),
+		MaxVotes:       s.MaxVotes,
+		EditDuration:   int(s.EditDuration.Seconds()),
+		Edits:          []EditWindow{},
+	}
+	if s.MaxVotes < 0 {
+		res.MaxVotes = UnlimitedVotes
+	}
+
+	comments, err := s.Engine.Find(engine.FindRequest{Locator: store.Locator{SiteID: siteID}, UserID: userID,
+		Limit: recent, Sort: "-time"})
+	if err != nil {
+		return res, errors.Wrapf(err, "can't get comments of %s", userID)
+	}
+
+	for _, c := range comments {
+		if c.Deleted {
+			continue
+		}
+		w := EditWindow{ID: c.ID, URL: c.Locator.URL, Remaining: -1, Editable: !s.HasReplies(c)}
+		if s.EditDuration > 0 {
+			until := c.Timestamp.Add(s.EditDuration)
+			w.Until = &until
+			w.Remaining = int(time.Until(until).Seconds())
+			if w.Remaining <= 0 {
+				w.Remaining, w.Editable = 0, false
+			}
+		}
+		if !w.Editable {
+			w.Remaining = 0
+		}
+		res.Edits = append(res.Edits, w)
+	}
+	return res, nil
 }
 
 // UserCount is comments count by user
@@ -860,7 +1244,101 @@ func (s *DataStore) Last(siteID string, limit int, since time.Time, user store.U
 	if err != nil {
 		return comments, err
 	}
-	return s.alterComments(comments, user), nil
+	if s.SlowModeDelay > 0 { // last comments are the same for all users
+		comments = s.hideDelayed(comments, store.User{})
+	}
+	return s.alterComments(s.hideShadowBanned(hidePending(comments, store.User{}), store.User{}), user), nil
+}
+
+// Created returns comments of the site created within [from, to) period, sorted by time.
+// Deleted, pending and shadow-banned comments skipped. Used to send notifications again after outage.
+func (s *DataStore) Created(siteID string, from, to time.Time) ([]store.Comment, error) {
+	posts, err := s.Engine.Info(engine.InfoRequest{Locator: store.Locator{SiteID: siteID}})
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't get posts of %s", siteID)
+	}
+	res := []store.Comment{}
+	for _, p := range posts {
+		if p.LastTS.Before(from) {
+			continue
+		}
+		comments, e := s.Engine.Find(engine.FindRequest{Locator: store.Locator{SiteID: siteID, URL: p.URL}, Sort: "time", Since: from.Add(-time.Nanosecond)})
+		if e != nil {
+			return nil, errors.Wrapf(e, "can't get comments of %s", p.URL)
+		}
+		for _, c := range comments {
+			if !c.Deleted && !c.Pending && c.Timestamp.Before(to) {
+				res = append(res, c)
+			}
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Timestamp.Before(res[j].Timestamp) })
+	return s.hideShadowBanned(res, store.User{}), nil
+}
+
+// CommentFilter selects comments of the site, empty fields match all
+type CommentFilter struct {
+	UserID  string
+	URL     string
+	From    time.Time // created at or after
+	To      time.Time // created before
+	Pending bool      // pending comments only
+}
+
+// Matched returns comments of the site matching the filter, sorted by time, up to limit if limit > 0.
+// Deleted comments skipped, pending and shadow-banned included. Used for bulk moderation.
+func (s *DataStore) Matched(siteID string, filter CommentFilter, limit int) ([]store.Comment, error) {
+	urls := []string{filter.URL}
+	if filter.URL == "" {
+		posts, err := s.Engine.Info(engine.InfoRequest{Locator: store.Locator{SiteID: siteID}})
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't get posts of %s", siteID)
+		}
+		urls = make([]string, 0, len(posts))
+		for _, p := range posts {
+			if !filter.From.IsZero() && p.LastTS.Before(filter.From) {
+				continue
+			}
+			urls = append(urls, p.URL)
+		}
+	}
+
+	res := []store.Comment{}
+	for _, url := range urls {
+		req := engine.FindRequest{Locator: store.Locator{SiteID: siteID, URL: url}, Sort: "time"}
+		if !filter.From.IsZero() {
+			req.Since = filter.From.Add(-time.Nanosecond)
+		}
+		comments, err := s.Engine.Find(req)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't get comments of %s", url)
+		}
+		for _, c := range comments {
+			switch {
+			case c.Deleted, filter.UserID != "" && c.User.ID != filter.UserID,
+				!filter.To.IsZero() && !c.Timestamp.Before(filter.To), filter.Pending && !c.Pending:
+				continue
+			}
+			res = append(res, c)
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Timestamp.Before(res[j].Timestamp) })
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+// maxCommentSize returns max comment size of the site, from runtime settings if set
+func (s *DataStore) maxCommentSize(siteID string) int {
+	res := s.MaxCommentSize
+	if s.SiteSettings != nil {
+		res = s.SiteSettings.MaxCommentSize(siteID)
+	}
+	if res <= 0 {
+		return defaultCommentMaxSize
+	}
+	return res
 }
 
 // Close store service
@@ -872,6 +1350,9 @@ func (s *DataStore) Close() error {
 	if s.TitleExtractor != nil {
 		errs = multierror.Append(errs, s.TitleExtractor.Close())
 	}
+	if s.SearchService != nil {
+		errs = multierror.Append(errs, s.SearchService.Close())
+	}
 	errs = multierror.Append(errs, s.Engine.Close())
 	return errs.ErrorOrNil()
 }
@@ -929,10 +1410,19 @@ func (s *DataStore) alterComment(c store.Comment, user store.User) (res store.Co
 	// hide info from non-admins
 	if !user.Admin {
 		c.User.IP = ""
+		c.ExternalID = ""
+		c.Reports = nil
+		c.Toxicity = 0
 	}
+	c.Revisions = nil // available with History only
 
+	c.Community = s.ScorePolicy.state(c, time.Now())
+	c = s.prepProfileUser(c)
 	c = s.prepVotes(c, user)
-	c.Locator.URL = c.SanitizeAsURL(c.Locator.URL) // urls prior to #927
+	c = s.prepReactions(c, user)
+	if !c.Locator.IsVirtual() { // virtual locators sanitized on creation, unknown scheme dropped by url sanitizer
+		c.Locator.URL = c.SanitizeAsURL(c.Locator.URL) // urls prior to #927
+	}
 	return c
 }
 
@@ -19,11 +19,13 @@ import (
 	"github.com/go-pkgz/lgr"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 	bolt "go.etcd.io/bbolt"
 
 	"github.com/umputun/remark42/backend/app/store"
 	"github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/attachment"
 	"github.com/umputun/remark42/backend/app/store/engine"
 	"github.com/umputun/remark42/backend/app/store/image"
 )
@@ -101,6 +103,39 @@ func TestService_CreateFromPartial(t *testing.T) {
 	assert.Equal(t, comment.Votes, res.Votes)
 }
 
+func TestService_CreateMaxReplyDepth(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), SiteSettings: replyDepth(2)}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+	create := func(id, parentID, name string) store.Comment {
+		_, err := b.Create(store.Comment{ID: id, ParentID: parentID, Text: "<p>text</p>", Orig: "text",
+			User: store.User{ID: "user-" + id, Name: name}, Locator: locator})
+		require.NoError(t, err)
+		res, err := b.Engine.Get(getReq(locator, id))
+		require.NoError(t, err)
+		return res
+	}
+
+	create("top", "", "top")
+	assert.Equal(t, "top", create("r1", "top", "first").ParentID)
+	assert.Equal(t, "r1", create("r2", "r1", "second").ParentID)
+
+	c := create("r3", "r2", "third")
+	assert.Equal(t, "r1", c.ParentID, "attached to the last allowed level")
+	assert.Equal(t, "<p>replying to @second</p><p>text</p>", c.Text)
+	assert.Equal(t, "replying to @second\n\ntext", c.Orig)
+
+	c = create("r4", "r3", "")
+	assert.Equal(t, "r1", c.ParentID)
+	assert.Equal(t, "replying to @third\n\ntext", c.Orig)
+
+	b.SiteSettings = replyDepth(0)
+	c = create("r5", "r2", "")
+	assert.Equal(t, "r2", c.ParentID, "unlimited")
+	assert.Equal(t, "text", c.Orig)
+}
+
 func TestService_CreateFromPartialWithTitle(t *testing.T) {
 	ks := admin.NewStaticKeyStore("secret 123")
 	eng, teardown := prepStoreEngine(t)
@@ -144,6 +179,16 @@ func TestService_CreateFromPartialWithTitle(t *testing.T) {
 	assert.NoError(t, err)
 	t.Logf("%+v", res)
 	assert.Equal(t, "post blah", res.PostTitle, "keep comment title")
+
+	comment.PostTitle = ""
+	comment.Locator = store.Locator{URL: "virtual:post-42", SiteID: "radio-t"}
+	id, err = b.Create(comment)
+	require.NoError(t, err)
+	res, err = b.Engine.Get(getReq(comment.Locator, id))
+	require.NoError(t, err)
+	assert.Equal(t, "", res.PostTitle, "no page of virtual locator")
+	_, err = b.SetTitle(comment.Locator, id)
+	assert.EqualError(t, err, "no page of virtual locator to get title from")
 }
 
 func TestService_SetTitle(t *testing.T) {
@@ -615,6 +660,29 @@ func TestService_Controversy(t *testing.T) {
 	}
 }
 
+func TestService_Best(t *testing.T) {
+	tbl := []struct {
+		ups, downs int
+		res        float64
+	}{
+		{0, 0, 0},
+		{1, 0, 0.378},
+		{10, 0, 0.859},
+		{2, 1, 0.321},
+		{10, 5, 0.501},
+		{100, 50, 0.616},
+		{0, 10, 0},
+		{1000, 10, 0.985},
+	}
+
+	for i, tt := range tbl {
+		tt := tt
+		t.Run(fmt.Sprintf("check-%d-%d:%d", i, tt.ups, tt.downs), func(t *testing.T) {
+			assert.InDelta(t, tt.res, best(tt.ups, tt.downs), 0.001)
+		})
+	}
+}
+
 func TestService_Pin(t *testing.T) {
 
 	eng, teardown := prepStoreEngine(t)
@@ -639,6 +707,17 @@ func TestService_Pin(t *testing.T) {
 	c, err = b.Engine.Get(getReq(store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, res[0].ID))
 	assert.NoError(t, err)
 	assert.Equal(t, false, c.Pin)
+
+	replyID, err := b.Create(store.Comment{Text: "reply", ParentID: res[0].ID, User: store.User{ID: "user2"},
+		Locator: store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}})
+	require.NoError(t, err)
+	err = b.SetPin(store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, replyID, true)
+	assert.EqualError(t, err, "reply "+replyID+" can't be pinned, only top-level comments")
+
+	err = b.Delete(store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, res[1].ID, store.SoftDelete)
+	require.NoError(t, err)
+	err = b.SetPin(store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, res[1].ID, true)
+	assert.EqualError(t, err, "deleted comment "+res[1].ID+" can't be pinned")
 }
 
 func TestService_EditComment(t *testing.T) {
@@ -655,11 +734,12 @@ func TestService_EditComment(t *testing.T) {
 	assert.Nil(t, res[0].Edit)
 
 	comment, err := b.EditComment(store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, res[0].ID,
-		EditRequest{Orig: "yyy", Text: "xxx", Summary: "my edit"})
+		EditRequest{Orig: "yyy <script>alert(1)</script>", Text: "xxx", Summary: "my edit"})
 	assert.NoError(t, err)
 	assert.Equal(t, "my edit", comment.Edit.Summary)
 	assert.Equal(t, "xxx", comment.Text)
-	assert.Equal(t, "yyy", comment.Orig)
+	assert.Equal(t, "yyy ", comment.Orig)
+	assert.Equal(t, "yyy <script>alert(1)</script>", comment.Raw, "raw markdown not sanitized")
 
 	c, err := b.Engine.Get(getReq(store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, res[0].ID))
 	assert.NoError(t, err)
@@ -791,6 +871,30 @@ func TestService_ValidateComment(t *testing.T) {
 	}
 }
 
+func TestService_ValidateCommentSiteSettings(t *testing.T) {
+	b := DataStore{MaxCommentSize: 2000, AdminStore: admin.NewStaticKeyStore("secret 123"),
+		SiteSettings: siteSettingsFunc(func(siteID string) int {
+			if siteID == "small" {
+				return 10
+			}
+			return 2000
+		})}
+	c := store.Comment{Orig: "something blah", User: store.User{ID: "myid", Name: "name"}, Locator: store.Locator{SiteID: "small"}}
+	assert.EqualError(t, b.ValidateComment(&c), "comment text exceeded max allowed size 10 (14)")
+	c.Locator.SiteID = "other"
+	assert.NoError(t, b.ValidateComment(&c))
+}
+
+type siteSettingsFunc func(siteID string) int
+
+func (f siteSettingsFunc) MaxCommentSize(siteID string) int { return f(siteID) }
+func (f siteSettingsFunc) MaxReplyDepth(string) int         { return 0 }
+
+type replyDepth int
+
+func (d replyDepth) MaxCommentSize(string) int { return 0 }
+func (d replyDepth) MaxReplyDepth(string) int  { return int(d) }
+
 func TestService_Counts(t *testing.T) {
 
 	b, teardown := prepStoreEngine(t) // two comments for https://radio-t.com
@@ -934,6 +1038,72 @@ func TestService_UserDetailsOperations(t *testing.T) {
 	assert.Empty(t, result)
 }
 
+func TestService_UnsubscribeEmail(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+
+	_, err := b.SetUserEmail("radio-t", "u1", "test@example.com")
+	require.NoError(t, err)
+	_, err = b.SetUserEmail("radio-t", "u2", "Test@Example.com")
+	require.NoError(t, err)
+	_, err = b.SetUserEmail("radio-t", "u3", "other@example.com")
+	require.NoError(t, err)
+
+	users, err := b.UnsubscribeEmail("radio-t", "test@example.com")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"u1", "u2"}, users)
+
+	for _, userID := range []string{"u1", "u2"} {
+		email, e := b.GetUserEmail("radio-t", userID)
+		require.NoError(t, e)
+		assert.Empty(t, email)
+	}
+	email, err := b.GetUserEmail("radio-t", "u3")
+	require.NoError(t, err)
+	assert.Equal(t, "other@example.com", email)
+
+	users, err = b.UnsubscribeEmail("radio-t", "test@example.com")
+	require.NoError(t, err)
+	assert.Empty(t, users)
+
+	_, err = b.UnsubscribeEmail("bad-site", "test@example.com")
+	assert.Error(t, err)
+}
+
+func TestService_UserEmails(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+
+	_, err := b.SetUserEmail("radio-t", "u1", "u1@example.com")
+	require.NoError(t, err)
+	b.ConsentVersions = map[string]string{"radio-t": "v1"}
+	_, err = b.SetUserConsent("radio-t", "u2", "v1")
+	require.NoError(t, err)
+
+	emails, err := b.UserEmails("radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, []engine.UserDetailEntry{{UserID: "u1", Email: "u1@example.com"}}, emails, "user without email skipped")
+
+	_, err = b.UserEmails("bad-site")
+	assert.Error(t, err)
+}
+
+func TestService_Integrity(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+
+	report, err := b.Integrity(engine.IntegrityRequest{SiteID: "radio-t"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Comments)
+
+	b = DataStore{Engine: &engine.MockInterface{}, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	_, err = b.Integrity(engine.IntegrityRequest{SiteID: "radio-t"})
+	assert.EqualError(t, err, "integrity check is not supported by store engine")
+}
+
 func TestService_IsAdmin(t *testing.T) {
 
 	// two comments for https://radio-t.com
@@ -1090,6 +1260,14 @@ func TestService_Find(t *testing.T) {
 	assert.InDelta(t, 1.73, res[0].Controversy, 0.01)
 	assert.Equal(t, "id-1", res[1].ID)
 	assert.InDelta(t, 0, res[1].Controversy, 0.01)
+
+	// make sure Best altered
+	res, err = b.Find(store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, "-best", store.User{})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(res))
+	assert.Equal(t, "123456", res[0].ID)
+	assert.InDelta(t, 0.321, res[0].Best, 0.001)
+	assert.InDelta(t, 0, res[1].Best, 0.001)
 }
 
 func TestService_FindSince(t *testing.T) {
@@ -1111,6 +1289,91 @@ func TestService_FindSince(t *testing.T) {
 	assert.Equal(t, "id-2", res[0].ID)
 }
 
+func TestService_Created(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+
+	_, err := b.Create(store.Comment{ID: "id-3", Text: "another post", Timestamp: time.Date(2017, 12, 20, 15, 18, 24, 0, time.Local),
+		Locator: store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}, User: store.User{ID: "user2"}})
+	require.NoError(t, err)
+	_, err = b.Create(store.Comment{ID: "id-4", Text: "pending", Timestamp: time.Date(2017, 12, 20, 15, 18, 23, 0, time.Local),
+		Locator: store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}, User: store.User{ID: "user2"}, Pending: true})
+	require.NoError(t, err)
+
+	res, err := b.Created("radio-t", time.Date(2017, 12, 20, 15, 18, 23, 0, time.Local), time.Date(2017, 12, 20, 16, 0, 0, 0, time.Local))
+	require.NoError(t, err)
+	require.Equal(t, 2, len(res), "id-1 before period, pending id-4 skipped")
+	assert.Equal(t, "id-2", res[0].ID, "from is inclusive")
+	assert.Equal(t, "id-3", res[1].ID)
+
+	res, err = b.Created("radio-t", time.Date(2017, 12, 20, 15, 0, 0, 0, time.Local), time.Date(2017, 12, 20, 15, 18, 23, 0, time.Local))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res), "to is exclusive")
+	assert.Equal(t, "id-1", res[0].ID)
+
+	require.NoError(t, b.Delete(store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, "id-1", store.SoftDelete))
+	res, err = b.Created("radio-t", time.Date(2017, 12, 20, 15, 0, 0, 0, time.Local), time.Date(2017, 12, 20, 15, 18, 23, 0, time.Local))
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(res), "deleted skipped")
+
+	_, err = b.Created("bad-site", time.Time{}, time.Now())
+	assert.Error(t, err)
+}
+
+func TestService_Matched(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+
+	_, err := b.Create(store.Comment{ID: "id-3", Text: "another post", Timestamp: time.Date(2017, 12, 20, 15, 18, 25, 0, time.Local),
+		Locator: store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}, User: store.User{ID: "user2"}})
+	require.NoError(t, err)
+	_, err = b.Create(store.Comment{ID: "id-4", Text: "pending", Timestamp: time.Date(2017, 12, 20, 15, 18, 24, 0, time.Local),
+		Locator: store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}, User: store.User{ID: "user2"}, Pending: true})
+	require.NoError(t, err)
+
+	ids := func(comments []store.Comment) (res []string) {
+		for _, c := range comments {
+			res = append(res, c.ID)
+		}
+		return res
+	}
+
+	res, err := b.Matched("radio-t", CommentFilter{}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id-1", "id-2", "id-4", "id-3"}, ids(res), "all sorted by time")
+
+	res, err = b.Matched("radio-t", CommentFilter{}, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id-1", "id-2"}, ids(res), "limited")
+
+	res, err = b.Matched("radio-t", CommentFilter{UserID: "user2"}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id-4", "id-3"}, ids(res), "by user")
+
+	res, err = b.Matched("radio-t", CommentFilter{URL: "https://radio-t.com"}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id-1", "id-2"}, ids(res), "by post")
+
+	res, err = b.Matched("radio-t", CommentFilter{Pending: true}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id-4"}, ids(res), "pending only")
+
+	res, err = b.Matched("radio-t", CommentFilter{From: time.Date(2017, 12, 20, 15, 18, 23, 0, time.Local),
+		To: time.Date(2017, 12, 20, 15, 18, 25, 0, time.Local)}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id-2", "id-4"}, ids(res), "from inclusive, to exclusive")
+
+	require.NoError(t, b.Delete(store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, "id-1", store.SoftDelete))
+	res, err = b.Matched("radio-t", CommentFilter{URL: "https://radio-t.com"}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id-2"}, ids(res), "deleted skipped")
+
+	_, err = b.Matched("bad-site", CommentFilter{}, 0)
+	assert.Error(t, err)
+}
+
 func TestService_Info(t *testing.T) {
 
 	// two comments for https://radio-t.com, no reply
@@ -1277,6 +1540,60 @@ func TestService_UserComments(t *testing.T) {
 	assert.Equal(t, "id-1", cc[1].ID, "reverse sort")
 }
 
+func TestService_UserLimits(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, EditDuration: time.Hour, MaxVotes: -5,
+		AdminStore: admin.NewStaticStore("secret 123", nil, []string{"user2"}, "user@email.com")}
+
+	for _, c := range []store.Comment{
+		{ID: "id-3", Text: "text 3", User: store.User{ID: "user1", Name: "user name"}},
+		{ID: "id-4", Text: "text 4", User: store.User{ID: "user1", Name: "user name"}},
+		{ID: "id-5", Text: "reply", ParentID: "id-3", User: store.User{ID: "user2", Name: "user2"}},
+	} {
+		c.Locator = store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+		_, err := b.Create(c)
+		require.NoError(t, err)
+	}
+	_, err := b.Create(store.Comment{ID: "id-6", Text: "text 6", User: store.User{ID: "user1", Name: "user name"},
+		Locator: store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}})
+	require.NoError(t, err)
+	_, err = b.EditComment(store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}, "id-6", EditRequest{Delete: true})
+	require.NoError(t, err)
+
+	limits, err := b.UserLimits("radio-t", "user1", 10)
+	require.NoError(t, err)
+	assert.False(t, limits.Blocked)
+	assert.Equal(t, defaultCommentMaxSize, limits.MaxCommentSize)
+	assert.Equal(t, UnlimitedVotes, limits.MaxVotes)
+	assert.Equal(t, 3600, limits.EditDuration)
+	require.Equal(t, 4, len(limits.Edits), "deleted id-6 skipped")
+
+	byID := map[string]EditWindow{}
+	for _, w := range limits.Edits {
+		byID[w.ID] = w
+	}
+	assert.True(t, byID["id-4"].Editable)
+	assert.True(t, byID["id-4"].Remaining > 3500 && byID["id-4"].Remaining <= 3600, byID["id-4"].Remaining)
+	require.NotNil(t, byID["id-4"].Until)
+	assert.True(t, byID["id-4"].Until.After(time.Now()))
+	assert.False(t, byID["id-3"].Editable, "has reply")
+	assert.Equal(t, 0, byID["id-3"].Remaining)
+	assert.False(t, byID["id-1"].Editable, "too old")
+	assert.Equal(t, 0, byID["id-1"].Remaining)
+
+	b.EditDuration = 0
+	require.NoError(t, b.SetBlock("radio-t", "user1", true, time.Hour))
+	limits, err = b.UserLimits("radio-t", "user1", 2)
+	require.NoError(t, err)
+	assert.True(t, limits.Blocked)
+	require.Equal(t, 1, len(limits.Edits))
+	assert.Equal(t, EditWindow{ID: "id-4", URL: "https://radio-t.com", Editable: true, Remaining: -1}, limits.Edits[0])
+
+	_, err = b.UserLimits("bad-site", "user1", 1)
+	assert.Error(t, err)
+}
+
 func TestService_UserCount(t *testing.T) {
 
 	// two comments for https://radio-t.com, no reply
@@ -1455,6 +1772,40 @@ func TestService_ResubmitStagingImages(t *testing.T) {
 	mockStoreError.AssertNumberOfCalls(t, "Info", 1)
 }
 
+func TestService_submitAttachments(t *testing.T) {
+	imgStore := image.MockStore{}
+	imgSvc := image.NewService(&imgStore, image.ServiceParams{EditDuration: 10 * time.Millisecond,
+		ImageAPI: "http://127.0.0.1:8080/api/v1/picture/"})
+	defer imgSvc.Close(context.TODO())
+	filesStore := image.MockStore{}
+	filesStore.On("ResetCleanupTimer", "dev_user/bqf122eq9r8ad657n3ng.log").Once().Return(nil)
+	filesStore.On("Commit", "dev_user/bqf122eq9r8ad657n3ng.log").Once().Return(nil)
+	filesSvc := attachment.NewService(&filesStore, attachment.Params{Extensions: []string{".log"},
+		API: "http://127.0.0.1:8080/api/v1/file/", EditDuration: 10 * time.Millisecond})
+
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, EditDuration: 10 * time.Millisecond, ImageService: imgSvc, AttachmentService: filesSvc}
+	comment := store.Comment{
+		ID:        "id-0",
+		Text:      `<a href="http://127.0.0.1:8080/api/v1/file/dev_user/bqf122eq9r8ad657n3ng.log/crash.log">crash.log</a>`,
+		Timestamp: time.Date(2017, 12, 20, 15, 18, 22, 0, time.Local),
+		Locator:   store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"},
+		User:      store.User{ID: "user1", Name: "user name"},
+	}
+	_, err := b.Engine.Create(comment)
+	require.NoError(t, err)
+
+	// only attachments staged
+	imgStore.On("Info").Once().Return(image.StoreInfo{}, nil)
+	filesStore.On("Info").Once().Return(image.StoreInfo{FirstStagingImageTS: time.Time{}.Add(time.Second)}, nil)
+	require.NoError(t, b.ResubmitStagingImages([]string{"radio-t"}))
+	filesSvc.Close(context.TODO())
+	filesStore.AssertNumberOfCalls(t, "ResetCleanupTimer", 1)
+	filesStore.AssertNumberOfCalls(t, "Commit", 1)
+	imgStore.AssertNumberOfCalls(t, "Commit", 0)
+}
+
 func TestService_ResubmitStagingImages_EngineError(t *testing.T) {
 	mockStore := image.MockStore{}
 	imgSvc := image.NewService(&mockStore,
@@ -1485,6 +1836,7 @@ func TestService_alterComment(t *testing.T) {
 	engineMock := engine.MockInterface{}
 	engineMock.On("Flag", engine.FlagRequest{Flag: engine.Blocked, UserID: "devid"}).Return(false, nil)
 	engineMock.On("Flag", engine.FlagRequest{Flag: engine.Verified, UserID: "devid"}).Return(false, nil)
+	engineMock.On("UserDetail", mock.Anything).Return(nil, nil)
 	svc := DataStore{Engine: &engineMock}
 
 	r := svc.alterComment(store.Comment{ID: "123", User: store.User{IP: "127.0.0.1", ID: "devid"},
@@ -1499,6 +1851,7 @@ func TestService_alterComment(t *testing.T) {
 	engineMock = engine.MockInterface{}
 	engineMock.On("Flag", engine.FlagRequest{Flag: engine.Blocked, UserID: "devid"}).Return(false, nil)
 	engineMock.On("Flag", engine.FlagRequest{Flag: engine.Verified, UserID: "devid"}).Return(true, nil)
+	engineMock.On("UserDetail", mock.Anything).Return(nil, nil)
 	svc = DataStore{Engine: &engineMock}
 	r = svc.alterComment(store.Comment{ID: "123", User: store.User{IP: "127.0.0.1", ID: "devid", Verified: true}},
 		store.User{Name: "dev", ID: "devid", Admin: false})
@@ -1507,6 +1860,7 @@ func TestService_alterComment(t *testing.T) {
 	engineMock = engine.MockInterface{}
 	engineMock.On("Flag", engine.FlagRequest{Flag: engine.Blocked, UserID: "devid"}).Return(true, nil)
 	engineMock.On("Flag", engine.FlagRequest{Flag: engine.Verified, UserID: "devid"}).Return(false, nil)
+	engineMock.On("UserDetail", mock.Anything).Return(nil, nil)
 	svc = DataStore{Engine: &engineMock}
 	r = svc.alterComment(store.Comment{ID: "123", User: store.User{IP: "127.0.0.1", ID: "devid", Verified: true},
 		Locator: store.Locator{URL: "javascript:alert('XSS1')"}},
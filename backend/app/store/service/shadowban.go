@@ -0,0 +1,73 @@
+package service
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// IsShadowBanned checks if user shadow-banned, i.e. comments of the user accepted but seen by the user only
+func (s *DataStore) IsShadowBanned(siteID, userID string) bool {
+	req := engine.FlagRequest{Locator: store.Locator{SiteID: siteID}, UserID: userID, Flag: engine.Shadowed}
+	shadowed, err := s.Engine.Flag(req)
+	return err == nil && shadowed
+}
+
+// SetShadowBan set/reset shadow-ban status for user
+func (s *DataStore) SetShadowBan(siteID, userID string, status bool) error {
+	shadowStatus := engine.FlagFalse
+	if status {
+		shadowStatus = engine.FlagTrue
+	}
+	req := engine.FlagRequest{Locator: store.Locator{SiteID: siteID}, UserID: userID, Flag: engine.Shadowed, Update: shadowStatus}
+	_, err := s.Engine.Flag(req)
+	return err
+}
+
+// ShadowBannedUsers returns ids of all shadow-banned users for given siteID
+func (s *DataStore) ShadowBannedUsers(siteID string) ([]string, error) {
+	list, err := s.Engine.ListFlags(engine.FlagRequest{Locator: store.Locator{SiteID: siteID}, Flag: engine.Shadowed})
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't get list of shadow-banned users for %s", siteID)
+	}
+	res := make([]string, 0, len(list))
+	for _, v := range list {
+		if userID, ok := v.(string); ok {
+			res = append(res, userID)
+		}
+	}
+	return res, nil
+}
+
+// hideShadowBanned removes comments of shadow-banned users from the list of site's comments.
+// Admins see all comments, users see own comments. Empty user hides comments of all shadow-banned users.
+func (s *DataStore) hideShadowBanned(comments []store.Comment, user store.User) []store.Comment {
+	if user.Admin || len(comments) == 0 {
+		return comments
+	}
+	banned := s.shadowBanned(comments[0].Locator.SiteID)
+	if len(banned) == 0 {
+		return comments
+	}
+	res := make([]store.Comment, 0, len(comments))
+	for _, c := range comments {
+		if !banned[c.User.ID] || (user.ID != "" && c.User.ID == user.ID) {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+// shadowBanned returns set of shadow-banned users of the site, empty on error
+func (s *DataStore) shadowBanned(siteID string) map[string]bool {
+	res := map[string]bool{}
+	users, err := s.ShadowBannedUsers(siteID)
+	if err != nil {
+		return res
+	}
+	for _, userID := range users {
+		res[userID] = true
+	}
+	return res
+}
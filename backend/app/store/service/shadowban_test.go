@@ -0,0 +1,77 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestService_ShadowBan(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	assert.False(t, b.IsShadowBanned("radio-t", "user2"))
+	require.NoError(t, b.SetShadowBan("radio-t", "user2", true))
+	assert.True(t, b.IsShadowBanned("radio-t", "user2"))
+	users, err := b.ShadowBannedUsers("radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user2"}, users)
+
+	id, err := b.Create(store.Comment{Text: "troll comment", Locator: locator, User: store.User{ID: "user2"}})
+	require.NoError(t, err, "comment of shadow-banned user accepted")
+
+	res, err := b.Find(locator, "time", store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(res), "hidden for anonymous")
+	res, err = b.Find(locator, "time", store.User{ID: "user1"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(res), "hidden for other users")
+	res, err = b.Find(locator, "time", store.User{ID: "user2"})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(res), "visible for author")
+	assert.Equal(t, id, res[2].ID)
+	res, err = b.Find(locator, "time", store.User{ID: "admin", Admin: true})
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(res), "visible for admin")
+
+	last, err := b.Last("radio-t", 10, time.Time{}, store.User{ID: "user2"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(last), "hidden for everyone in last comments")
+	userComments, err := b.User("radio-t", "user2", 10, 0, store.User{ID: "user1"})
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(userComments), "hidden in user's comments for others")
+	userComments, err = b.User("radio-t", "user2", 10, 0, store.User{ID: "user2"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(userComments), "visible in user's comments for author")
+
+	c, err := b.Get(locator, id, store.User{})
+	require.NoError(t, err)
+	assert.False(t, b.Visible(c, store.User{ID: "user1"}))
+	assert.True(t, b.Visible(c, store.User{ID: "user2"}))
+	created, err := b.Created("radio-t", time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(created), "not notified again")
+
+	viewers, err := b.PersonalViewers(locator)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user2"}, viewers, "shadow-banned author sees the post differently")
+
+	require.NoError(t, b.SetShadowBan("radio-t", "user2", false))
+	assert.False(t, b.IsShadowBanned("radio-t", "user2"))
+	res, err = b.Find(locator, "time", store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(res), "visible for all after shadow-ban lifted")
+	users, err = b.ShadowBannedUsers("radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, []string{}, users)
+
+	_, err = b.ShadowBannedUsers("bad")
+	assert.Error(t, err)
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"time"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// IsSlowMode checks if post in slow mode, i.e. new comments shown publicly after SlowModeDelay only
+func (s *DataStore) IsSlowMode(locator store.Locator) bool {
+	if s.SlowModeDelay <= 0 || locator.URL == "" {
+		return false
+	}
+	slow, err := s.Engine.Flag(engine.FlagRequest{Locator: locator, Flag: engine.SlowMode})
+	return err == nil && slow
+}
+
+// SetSlowMode set/reset slow mode flag of the post
+func (s *DataStore) SetSlowMode(locator store.Locator, status bool) error {
+	slowStatus := engine.FlagFalse
+	if status {
+		slowStatus = engine.FlagTrue
+	}
+	_, err := s.Engine.Flag(engine.FlagRequest{Locator: locator, Flag: engine.SlowMode, Update: slowStatus})
+	return err
+}
+
+// hideDelayed removes comments made less than SlowModeDelay ago from the list of post's comments.
+// Admins see all comments, users see own comments immediately. Empty user hides delayed comments of everyone.
+func (s *DataStore) hideDelayed(comments []store.Comment, user store.User) []store.Comment {
+	if user.Admin || len(comments) == 0 {
+		return comments
+	}
+	visibleTS := time.Now().Add(-s.SlowModeDelay)
+	slowPosts := map[store.Locator]bool{}
+	res := make([]store.Comment, 0, len(comments))
+	for _, c := range comments {
+		if c.Timestamp.Before(visibleTS) || (user.ID != "" && c.User.ID == user.ID) {
+			res = append(res, c)
+			continue
+		}
+		locator := store.Locator{SiteID: c.Locator.SiteID, URL: c.Locator.URL}
+		slow, ok := slowPosts[locator]
+		if !ok {
+			slow = s.IsSlowMode(locator)
+			slowPosts[locator] = slow
+		}
+		if !slow {
+			res = append(res, c)
+		}
+	}
+	return res
+}
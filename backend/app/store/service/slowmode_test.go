@@ -0,0 +1,63 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestService_SlowMode(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123")}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	require.NoError(t, b.SetSlowMode(locator, true))
+	assert.False(t, b.IsSlowMode(locator), "slow mode disabled without delay")
+	b.SlowModeDelay = time.Minute
+	assert.True(t, b.IsSlowMode(locator))
+	assert.False(t, b.IsSlowMode(store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}))
+
+	id, err := b.Create(store.Comment{Text: "new comment", Locator: locator, User: store.User{ID: "user2"}})
+	require.NoError(t, err)
+	_, err = b.Create(store.Comment{Text: "new comment on another post", User: store.User{ID: "user2"},
+		Locator: store.Locator{URL: "https://radio-t.com/2", SiteID: "radio-t"}})
+	require.NoError(t, err)
+
+	res, err := b.Find(locator, "time", store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(res), "new comment hidden for anonymous")
+	res, err = b.Find(locator, "time", store.User{ID: "user1"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(res), "new comment hidden for other users")
+	res, err = b.Find(locator, "time", store.User{ID: "user2"})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(res), "new comment visible for author")
+	assert.Equal(t, id, res[2].ID)
+	res, err = b.Find(locator, "time", store.User{ID: "admin", Admin: true})
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(res), "new comment visible for admin")
+
+	last, err := b.Last("radio-t", 10, time.Time{}, store.User{ID: "user2"})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(last), "hidden for everyone in last comments")
+	assert.Equal(t, "https://radio-t.com/2", last[0].Locator.URL)
+
+	b.SlowModeDelay = time.Millisecond
+	time.Sleep(5 * time.Millisecond)
+	res, err = b.Find(locator, "time", store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(res), "visible for all after delay")
+
+	b.SlowModeDelay = time.Minute
+	require.NoError(t, b.SetSlowMode(locator, false))
+	assert.False(t, b.IsSlowMode(locator))
+	res, err = b.Find(locator, "time", store.User{})
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(res))
+}
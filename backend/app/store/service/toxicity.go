@@ -0,0 +1,73 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+	"github.com/umputun/remark42/backend/app/store/events"
+)
+
+// ToxicityReporter is user id of reports added to comments flagged by toxicity score
+const ToxicityReporter = "toxicity"
+
+// ToxicityReq is the request to set toxicity score of the comment
+type ToxicityReq struct {
+	Locator   store.Locator
+	CommentID string
+	Score     float64 // from 0 to 1
+	Flag      bool    // report comment to moderators
+	Hold      bool    // move comment to pending
+}
+
+// SetToxicity sets toxicity score of the comment. Flagged comment reported to moderators once,
+// with ToxicityReporter as the reporter, and held comment moved to pending. Returns comment prepared for admins.
+func (s *DataStore) SetToxicity(req ToxicityReq) (comment store.Comment, err error) {
+	if req.Score < 0 || req.Score > 1 {
+		return comment, errors.Errorf("invalid toxicity score %v", req.Score)
+	}
+
+	cLock := s.getScopedLocks(req.Locator.URL)
+	cLock.Lock()
+	defer cLock.Unlock()
+
+	comment, err = s.Engine.Get(engine.GetRequest{Locator: req.Locator, CommentID: req.CommentID})
+	if err != nil {
+		return comment, err
+	}
+	if comment.Deleted {
+		return comment, errors.Errorf("comment %s deleted", req.CommentID)
+	}
+
+	comment.Toxicity = req.Score
+	comment.Locator = req.Locator
+	if req.Flag && !hasReport(comment, ToxicityReporter) {
+		comment.Reports = append(comment.Reports, store.Report{UserID: ToxicityReporter,
+			Reason: fmt.Sprintf("toxicity score %.2f", req.Score), Timestamp: time.Now()})
+	}
+	held := req.Hold && !comment.Pending
+	if held {
+		comment.Pending = true
+	}
+	if err = s.Engine.Update(comment); err != nil {
+		return comment, err
+	}
+	if held {
+		log.Printf("[INFO] audit: comment %s moved to pending with toxicity score %.2f", comment.ID, req.Score)
+		s.publish(events.Updated, comment)
+	}
+	return s.alterComment(comment, store.User{Admin: true}), nil
+}
+
+func hasReport(c store.Comment, userID string) bool {
+	for _, r := range c.Reports {
+		if r.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
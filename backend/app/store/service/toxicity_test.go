@@ -0,0 +1,55 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestService_SetToxicity(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticStore("secret 123", []string{"radio-t"}, []string{"admin"}, "")}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	_, err := b.SetToxicity(ToxicityReq{Locator: locator, CommentID: "id-1", Score: 1.5})
+	assert.Error(t, err, "invalid score")
+	_, err = b.SetToxicity(ToxicityReq{Locator: locator, CommentID: "id-bad", Score: 0.5})
+	assert.Error(t, err, "unknown comment")
+
+	c, err := b.SetToxicity(ToxicityReq{Locator: locator, CommentID: "id-1", Score: 0.25})
+	require.NoError(t, err)
+	assert.Equal(t, 0.25, c.Toxicity)
+	assert.Empty(t, c.Reports)
+	assert.False(t, c.Pending)
+
+	c, err = b.SetToxicity(ToxicityReq{Locator: locator, CommentID: "id-1", Score: 0.9, Flag: true})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(c.Reports))
+	assert.Equal(t, ToxicityReporter, c.Reports[0].UserID)
+	assert.Equal(t, "toxicity score 0.90", c.Reports[0].Reason)
+	assert.False(t, c.Pending)
+	c, err = b.SetToxicity(ToxicityReq{Locator: locator, CommentID: "id-1", Score: 0.95, Flag: true})
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(c.Reports), "flagged once")
+
+	c, err = b.SetToxicity(ToxicityReq{Locator: locator, CommentID: "id-2", Score: 0.8, Hold: true})
+	require.NoError(t, err)
+	assert.True(t, c.Pending)
+	assert.Empty(t, c.Reports)
+
+	c, err = b.Get(locator, "id-2", store.User{ID: "user2"})
+	require.NoError(t, err)
+	assert.Equal(t, 0.0, c.Toxicity, "hidden from users")
+	c, err = b.Get(locator, "id-2", store.User{ID: "admin", Admin: true})
+	require.NoError(t, err)
+	assert.Equal(t, 0.8, c.Toxicity)
+
+	require.NoError(t, b.Delete(locator, "id-2", store.SoftDelete))
+	_, err = b.SetToxicity(ToxicityReq{Locator: locator, CommentID: "id-2", Score: 0.5})
+	assert.Error(t, err, "deleted comment")
+}
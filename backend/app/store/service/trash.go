@@ -0,0 +1,214 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+	"github.com/umputun/remark42/backend/app/store/events"
+	"github.com/umputun/remark42/backend/app/store/search"
+)
+
+// Trash defines store of soft-deleted comments kept as they were before deletion, to undelete them
+// within retention window. Comments keyed by site and id.
+type Trash interface {
+	Put(comment store.Comment, deletedAt time.Time) error
+	Get(siteID, commentID string) (TrashedComment, error)
+	List(siteID string) ([]TrashedComment, error)
+	Remove(siteID, commentID string) error
+	Expire(before time.Time) (int, error) // removes comments deleted before the time, returns number of removed
+}
+
+// TrashedComment is a soft-deleted comment with time of deletion
+type TrashedComment struct {
+	Comment   store.Comment `json:"comment"`
+	DeletedAt time.Time     `json:"deleted_at"`
+}
+
+// ErrNotInTrash returned by Undelete for comments not deleted, or deleted before the retention window
+var ErrNotInTrash = errors.New("comment not found in trash")
+
+// trashComment keeps comment before soft deletion if trash enabled, returns func to drop it if deletion failed
+func (s *DataStore) trashComment(locator store.Locator, commentID string, mode store.DeleteMode) (drop func()) {
+	drop = func() {}
+	if s.Trash == nil || mode != store.SoftDelete {
+		return drop
+	}
+	comment, err := s.Engine.Get(engine.GetRequest{Locator: locator, CommentID: commentID})
+	if err != nil || comment.Deleted {
+		return drop
+	}
+	comment.Locator = locator
+	if err = s.Trash.Put(comment, time.Now()); err != nil {
+		log.Printf("[WARN] can't keep deleted comment %s in trash, %v", commentID, err)
+		return drop
+	}
+	return func() { _ = s.Trash.Remove(locator.SiteID, commentID) }
+}
+
+// DeletedComments returns soft-deleted comments of the site kept in trash, recently deleted first
+func (s *DataStore) DeletedComments(siteID string) ([]TrashedComment, error) {
+	if s.Trash == nil {
+		return nil, errors.New("trash disabled")
+	}
+	list, err := s.Trash.List(siteID)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]TrashedComment, 0, len(list))
+	for _, c := range list {
+		if !s.trashExpired(c) { // not purged by janitor yet
+			res = append(res, c)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].DeletedAt.After(res[j].DeletedAt) })
+	return res, nil
+}
+
+// Undelete restores soft-deleted comment from trash, the comment and its replies tree stay in place.
+// Votes and reactions made before deletion restored too.
+func (s *DataStore) Undelete(locator store.Locator, commentID string) (store.Comment, error) {
+	if s.Trash == nil {
+		return store.Comment{}, errors.New("trash disabled")
+	}
+	cLock := s.getScopedLocks(locator.URL)
+	cLock.Lock()
+	defer cLock.Unlock()
+
+	trashed, err := s.Trash.Get(locator.SiteID, commentID)
+	if err != nil {
+		return store.Comment{}, err
+	}
+	if trashed.Comment.Locator.URL != locator.URL || s.trashExpired(trashed) {
+		return store.Comment{}, ErrNotInTrash
+	}
+	current, err := s.Engine.Get(engine.GetRequest{Locator: locator, CommentID: commentID})
+	if err != nil {
+		return store.Comment{}, errors.Wrapf(err, "can't get comment %s", commentID)
+	}
+	if !current.Deleted {
+		_ = s.Trash.Remove(locator.SiteID, commentID)
+		return store.Comment{}, errors.Errorf("comment %s is not deleted", commentID)
+	}
+	if current.User.ID != trashed.Comment.User.ID {
+		return store.Comment{}, errors.Errorf("comment %s deleted with its user, can't be restored", commentID)
+	}
+
+	comment := trashed.Comment
+	comment.Locator = locator
+	comment.Deleted, comment.DeletedAt = false, nil
+	if err = s.Engine.Update(comment); err != nil {
+		return store.Comment{}, errors.Wrapf(err, "can't restore comment %s", commentID)
+	}
+	if err = s.Trash.Remove(locator.SiteID, commentID); err != nil {
+		log.Printf("[WARN] can't remove restored comment %s from trash, %v", commentID, err)
+	}
+	s.updateSearchIndex(func(svc *search.Service) error { return svc.Index(comment) })
+	s.publish(events.Updated, comment)
+	return comment, nil
+}
+
+// trashExpired checks if comment deleted before the retention window
+func (s *DataStore) trashExpired(c TrashedComment) bool {
+	return c.DeletedAt.Before(time.Now().Add(-s.TrashRetention))
+}
+
+// RunTrashJanitor removes comments deleted before the retention window from trash periodically, blocking.
+// Removed comments can't be undeleted anymore.
+func (s *DataStore) RunTrashJanitor(ctx context.Context, interval time.Duration) {
+	log.Printf("[INFO] trash of deleted comments with retention %v", s.TrashRetention)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		count, err := s.Trash.Expire(time.Now().Add(-s.TrashRetention))
+		if err != nil {
+			log.Printf("[WARN] can't purge trash, %v", err)
+		}
+		if count > 0 {
+			log.Printf("[INFO] purged %d deleted comments from trash", count)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// EngineTrash implements Trash with records of store engine, comments kept along with comments of their site.
+// Comments expired in all sites.
+type EngineTrash struct {
+	records engine.Records // keyed by commentID
+	sites   func() []string
+}
+
+// NewEngineTrash makes trash of deleted comments kept by eng for sites
+func NewEngineTrash(eng engine.Interface, sites func() []string) *EngineTrash {
+	return &EngineTrash{records: engine.Records{Engine: eng, Kind: engine.Trash}, sites: sites}
+}
+
+// Put keeps deleted comment, replaces comment deleted before with the same id
+func (e *EngineTrash) Put(comment store.Comment, deletedAt time.Time) error {
+	return e.records.Set(comment.Locator.SiteID, comment.ID, TrashedComment{Comment: comment, DeletedAt: deletedAt})
+}
+
+// Get deleted comment, ErrNotInTrash if it's not kept
+func (e *EngineTrash) Get(siteID, commentID string) (res TrashedComment, err error) {
+	found, err := e.records.Get(siteID, commentID, &res)
+	if err != nil {
+		return TrashedComment{}, err
+	}
+	if !found {
+		return TrashedComment{}, ErrNotInTrash
+	}
+	return res, nil
+}
+
+// List deleted comments of the site
+func (e *EngineTrash) List(siteID string) ([]TrashedComment, error) {
+	res := []TrashedComment{}
+	err := e.records.List(siteID, "", func(_ string, unmarshal func(v interface{}) error) error {
+		item := TrashedComment{}
+		if err := unmarshal(&item); err != nil {
+			return err
+		}
+		res = append(res, item)
+		return nil
+	})
+	return res, err
+}
+
+// Remove deleted comment, i.e. restored one
+func (e *EngineTrash) Remove(siteID, commentID string) error {
+	_, err := e.records.Delete(siteID, commentID)
+	return err
+}
+
+// Expire removes comments of all sites deleted before the time
+func (e *EngineTrash) Expire(before time.Time) (count int, err error) {
+	for _, siteID := range e.sites() {
+		expired := []string{}
+		err = e.records.List(siteID, "", func(key string, unmarshal func(v interface{}) error) error {
+			item := TrashedComment{}
+			if unmarshal(&item) != nil || item.DeletedAt.Before(before) {
+				expired = append(expired, key) // broken records removed too
+			}
+			return nil
+		})
+		if err != nil {
+			return count, err
+		}
+		for _, key := range expired {
+			if _, err = e.records.Delete(siteID, key); err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+func TestService_Undelete(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	trash := NewEngineTrash(eng, func() []string { return []string{"radio-t"} })
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), MaxVotes: -1, Trash: trash,
+		TrashRetention: time.Hour}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	_, err := b.Vote(VoteReq{Locator: locator, CommentID: "id-1", UserID: "user2", Val: true})
+	require.NoError(t, err)
+	require.NoError(t, b.Delete(locator, "id-1", store.SoftDelete))
+	require.NoError(t, b.Delete(locator, "id-1", store.SoftDelete), "deleted again")
+	c, err := b.Get(locator, "id-1", store.User{})
+	require.NoError(t, err)
+	assert.True(t, c.Deleted)
+	assert.Equal(t, "", c.Text)
+
+	deleted, err := b.DeletedComments("radio-t")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(deleted))
+	assert.Equal(t, "id-1", deleted[0].Comment.ID)
+	assert.Equal(t, `some text, <a href="http://radio-t.com">link</a>`, deleted[0].Comment.Text, "kept before deletion")
+
+	_, err = b.Undelete(store.Locator{URL: "https://radio-t.com/other", SiteID: "radio-t"}, "id-1")
+	assert.Equal(t, ErrNotInTrash, err, "wrong post")
+
+	c, err = b.Undelete(locator, "id-1")
+	require.NoError(t, err)
+	assert.False(t, c.Deleted)
+	c, err = b.Get(locator, "id-1", store.User{})
+	require.NoError(t, err)
+	assert.False(t, c.Deleted)
+	assert.Nil(t, c.DeletedAt)
+	assert.Equal(t, `some text, <a href="http://radio-t.com">link</a>`, c.Text)
+	assert.Equal(t, 1, c.Score, "votes restored")
+
+	_, err = b.Undelete(locator, "id-1")
+	assert.Equal(t, ErrNotInTrash, err, "removed from trash")
+	deleted, err = b.DeletedComments("radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(deleted))
+
+	// hard deletion not kept
+	require.NoError(t, b.Delete(locator, "id-2", store.HardDelete))
+	_, err = b.Undelete(locator, "id-2")
+	assert.Equal(t, ErrNotInTrash, err)
+
+	// deleted by user with edit request
+	c, err = b.Get(locator, "id-1", store.User{})
+	require.NoError(t, err)
+	_, err = b.EditComment(locator, "id-1", EditRequest{Delete: true})
+	require.NoError(t, err)
+	deleted, err = b.DeletedComments("radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(deleted))
+
+	// expired
+	b.TrashRetention = time.Nanosecond
+	_, err = b.Undelete(locator, "id-1")
+	assert.Equal(t, ErrNotInTrash, err)
+	deleted, err = b.DeletedComments("radio-t")
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(deleted))
+
+	b.Trash = nil
+	_, err = b.Undelete(locator, "id-1")
+	assert.EqualError(t, err, "trash disabled")
+}
+
+func TestService_RunTrashJanitor(t *testing.T) {
+	eng, err := engine.NewMemory("", "s1", "s2")
+	require.NoError(t, err)
+	trash := NewEngineTrash(eng, func() []string { return []string{"s1", "s2"} })
+	now := time.Now()
+	require.NoError(t, trash.Put(store.Comment{ID: "c1", Locator: store.Locator{SiteID: "s1", URL: "u1"}}, now.Add(-2*time.Hour)))
+	require.NoError(t, trash.Put(store.Comment{ID: "c2", Locator: store.Locator{SiteID: "s1", URL: "u1"}}, now))
+	require.NoError(t, trash.Put(store.Comment{ID: "c3", Locator: store.Locator{SiteID: "s2", URL: "u1"}}, now))
+
+	b := DataStore{Trash: trash, TrashRetention: time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	b.RunTrashJanitor(ctx, 10*time.Millisecond)
+
+	list, err := trash.List("s1")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(list))
+	assert.Equal(t, "c2", list[0].Comment.ID)
+	list, err = trash.List("s2")
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(list))
+}
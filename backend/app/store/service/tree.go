@@ -5,23 +5,36 @@ import (
 	"strings"
 	"time"
 
+	"github.com/pkg/errors"
+
 	"github.com/umputun/remark42/backend/app/store"
 )
 
 // Tree is formatter making tree from the list of comments
 type Tree struct {
-	Nodes []*Node        `json:"comments"`
-	Info  store.PostInfo `json:"info,omitempty"`
+	Nodes  []*Node        `json:"comments"`
+	Info   store.PostInfo `json:"info,omitempty"`
+	Cursor string         `json:"cursor,omitempty"` // cursor of the next page, set by Page if there are more nodes
+	More   int            `json:"more,omitempty"`   // number of nodes after the page
 }
 
 // Node is a comment with optional replies
 type Node struct {
 	Comment    store.Comment `json:"comment"`
 	Replies    []*Node       `json:"replies,omitempty"`
+	More       int           `json:"more,omitempty"` // number of replies cut by Page, can be loaded with Branch
 	tsModified time.Time
 	tsCreated  time.Time
 }
 
+// PageRequest defines a page of the tree. Cursor is the id of the last node of the previous page,
+// stays valid while nodes added or removed, unlike offset.
+type PageRequest struct {
+	Limit   int    // max number of nodes, all if 0
+	Cursor  string // id of the last node of the previous page, from the first node if empty
+	Replies int    // max number of replies on each level, all if 0
+}
+
 // recurData wraps all fields used in recursive processing as intermediate results
 type recurData struct {
 	tsModified time.Time
@@ -78,6 +91,115 @@ func MakeTree(comments []store.Comment, sortType string, readOnlyAge int) *Tree
 	return &res
 }
 
+// Page cuts the tree to the page of nodes after req.Cursor, with replies on each level limited by req.Replies.
+// Cursor of the next page and number of nodes left set in the tree, number of cut replies in each node.
+func (t *Tree) Page(req PageRequest) error {
+	start := 0
+	if req.Cursor != "" {
+		start = -1
+		for i, n := range t.Nodes {
+			if n.Comment.ID == req.Cursor {
+				start = i + 1
+				break
+			}
+		}
+		if start < 0 {
+			return errors.Errorf("cursor %s not found", req.Cursor)
+		}
+	}
+
+	total := len(t.Nodes)
+	t.Nodes, t.Cursor, t.More = t.Nodes[start:], "", 0
+	if req.Limit > 0 && len(t.Nodes) > req.Limit {
+		t.Nodes = t.Nodes[:req.Limit]
+		t.Cursor = t.Nodes[len(t.Nodes)-1].Comment.ID
+		t.More = total - start - req.Limit
+	}
+	if req.Replies > 0 {
+		for _, n := range t.Nodes {
+			n.limitReplies(req.Replies)
+		}
+	}
+	return nil
+}
+
+// Branch makes tree of replies to the comment, paginated with req. Used to load replies cut by Page,
+// with req.Cursor set to the last loaded reply. Replies sorted by time.
+func (t *Tree) Branch(commentID string, req PageRequest) (*Tree, error) {
+	node := findNode(t.Nodes, commentID)
+	if node == nil {
+		return nil, errors.Errorf("comment %s not found", commentID)
+	}
+	res := &Tree{Nodes: node.Replies, Info: t.Info}
+	if res.Nodes == nil {
+		res.Nodes = []*Node{}
+	}
+	return res, res.Page(req)
+}
+
+// limitReplies cuts replies on each level of the node to max
+func (n *Node) limitReplies(max int) {
+	if len(n.Replies) > max {
+		n.More = len(n.Replies) - max
+		n.Replies = n.Replies[:max]
+	}
+	for _, r := range n.Replies {
+		r.limitReplies(max)
+	}
+}
+
+// Flatten moves replies nested deeper than maxDepth to their ancestor on the level maxDepth-1, sorted by time.
+// Used for comments made before the limit set, new replies attached to allowed level on creation.
+func (t *Tree) Flatten(maxDepth int) {
+	if maxDepth <= 0 {
+		return
+	}
+	for _, n := range t.Nodes {
+		n.flatten(0, maxDepth)
+	}
+}
+
+// flatten makes replies of the node on level maxDepth-1 flat, level of top-level node is 0
+func (n *Node) flatten(level, maxDepth int) {
+	if level < maxDepth-1 {
+		for _, r := range n.Replies {
+			r.flatten(level+1, maxDepth)
+		}
+		return
+	}
+
+	var descendants func(nodes []*Node) []*Node
+	descendants = func(nodes []*Node) []*Node {
+		res := []*Node{}
+		for _, r := range nodes {
+			res = append(res, r)
+			res = append(res, descendants(r.Replies)...)
+			r.Replies = nil
+		}
+		return res
+	}
+	if len(n.Replies) == 0 {
+		return
+	}
+	n.Replies = descendants(n.Replies)
+	sort.SliceStable(n.Replies, func(i, j int) bool {
+		return n.Replies[i].Comment.Timestamp.Before(n.Replies[j].Comment.Timestamp)
+	})
+}
+
+// findNode looks for node with the comment id recursively
+func findNode(nodes []*Node, commentID string) *Node {
+	for _, n := range nodes {
+		if n.Comment.ID == commentID {
+			return n
+		}
+		if res := findNode(n.Replies, commentID); res != nil {
+			return res
+		}
+	}
+	return nil
+}
+
 // proc makes tree for one top-level comment recursively
 func (t *Tree) proc(comments []store.Comment, node *Node, rd *recurData, parentID string) (result *Node, modified, created time.Time) {
 
@@ -121,11 +243,14 @@ func (t *Tree) filter(comments []store.Comment, fn func(comment store.Comment) b
 	return f
 }
 
-// sort list of nodes, i.e. top-level comments
-// time sort uses tsModified from latest reply
+// sort list of nodes, i.e. top-level comments. Pinned comments go first, sorted the same way.
+// time sort uses tsModified from latest reply. Sort is stable to keep pages consistent.
 func (t *Tree) sortNodes(sortType string) {
 
-	sort.Slice(t.Nodes, func(i, j int) bool {
+	sort.SliceStable(t.Nodes, func(i, j int) bool {
+		if t.Nodes[i].Comment.Pin != t.Nodes[j].Comment.Pin {
+			return t.Nodes[i].Comment.Pin
+		}
 		switch sortType {
 		case "+time", "-time", "time":
 			if strings.HasPrefix(sortType, "-") {
@@ -163,6 +288,30 @@ func (t *Tree) sortNodes(sortType string) {
 			}
 			return t.Nodes[i].Comment.Controversy < t.Nodes[j].Comment.Controversy
 
+		case "+best", "-best", "best":
+			if strings.HasPrefix(sortType, "-") {
+				if t.Nodes[i].Comment.Best == t.Nodes[j].Comment.Best {
+					return t.Nodes[i].Comment.Timestamp.Before(t.Nodes[j].Comment.Timestamp)
+				}
+				return t.Nodes[i].Comment.Best > t.Nodes[j].Comment.Best
+			}
+			if t.Nodes[i].Comment.Best == t.Nodes[j].Comment.Best {
+				return t.Nodes[i].Comment.Timestamp.Before(t.Nodes[j].Comment.Timestamp)
+			}
+			return t.Nodes[i].Comment.Best < t.Nodes[j].Comment.Best
+
+		case "+quality", "-quality", "quality":
+			if strings.HasPrefix(sortType, "-") {
+				if t.Nodes[i].Comment.Quality == t.Nodes[j].Comment.Quality {
+					return t.Nodes[i].Comment.Timestamp.Before(t.Nodes[j].Comment.Timestamp)
+				}
+				return t.Nodes[i].Comment.Quality > t.Nodes[j].Comment.Quality
+			}
+			if t.Nodes[i].Comment.Quality == t.Nodes[j].Comment.Quality {
+				return t.Nodes[i].Comment.Timestamp.Before(t.Nodes[j].Comment.Timestamp)
+			}
+			return t.Nodes[i].Comment.Quality < t.Nodes[j].Comment.Quality
+
 		default:
 			return t.Nodes[i].Comment.Timestamp.Before(t.Nodes[j].Comment.Timestamp)
 		}
@@ -53,6 +53,114 @@ func TestMakeTree(t *testing.T) {
 	assert.Equal(t, store.PostInfo{URL: "url", Count: 12, FirstTS: ts(46, 1), LastTS: ts(47, 22), ReadOnly: true}, res.Info)
 }
 
+func TestTreePage(t *testing.T) {
+	ts := func(min int, sec int) time.Time { return time.Date(2017, 12, 25, 19, min, sec, 0, time.UTC) }
+	comments := []store.Comment{
+		{ID: "14", ParentID: "1", Timestamp: ts(46, 14)},
+		{ID: "1", Timestamp: ts(46, 1)},
+		{ID: "2", Timestamp: ts(47, 2)},
+		{ID: "11", ParentID: "1", Timestamp: ts(46, 11)},
+		{ID: "13", ParentID: "1", Timestamp: ts(46, 13)},
+		{ID: "12", ParentID: "1", Timestamp: ts(46, 12)},
+		{ID: "131", ParentID: "13", Timestamp: ts(46, 31)},
+		{ID: "132", ParentID: "13", Timestamp: ts(46, 32)},
+		{ID: "21", ParentID: "2", Timestamp: ts(47, 21)},
+		{ID: "3", Timestamp: ts(47, 22)},
+		{ID: "4", Timestamp: ts(47, 23)},
+	}
+	ids := func(nodes []*Node) (res []string) {
+		for _, n := range nodes {
+			res = append(res, n.Comment.ID)
+		}
+		return res
+	}
+
+	res := MakeTree(comments, "time", 0)
+	require.NoError(t, res.Page(PageRequest{Limit: 2, Replies: 2}))
+	assert.Equal(t, []string{"1", "2"}, ids(res.Nodes))
+	assert.Equal(t, "2", res.Cursor)
+	assert.Equal(t, 2, res.More)
+	assert.Equal(t, []string{"11", "12"}, ids(res.Nodes[0].Replies))
+	assert.Equal(t, 2, res.Nodes[0].More, "13 and 14 cut")
+	assert.Equal(t, 0, res.Nodes[1].More)
+
+	res = MakeTree(comments, "time", 0)
+	require.NoError(t, res.Page(PageRequest{Limit: 2, Cursor: "2"}))
+	assert.Equal(t, []string{"3", "4"}, ids(res.Nodes))
+	assert.Equal(t, "", res.Cursor, "last page")
+	assert.Equal(t, 0, res.More)
+
+	res = MakeTree(comments, "-time", 0)
+	require.NoError(t, res.Page(PageRequest{Limit: 1, Cursor: "3"}))
+	assert.Equal(t, []string{"2"}, ids(res.Nodes))
+	assert.Equal(t, "2", res.Cursor)
+	assert.Equal(t, 1, res.More)
+
+	res = MakeTree(comments, "time", 0)
+	require.NoError(t, res.Page(PageRequest{}))
+	assert.Equal(t, 4, len(res.Nodes), "no limits")
+	assert.Equal(t, 4, len(res.Nodes[0].Replies))
+
+	res = MakeTree(comments, "time", 0)
+	assert.EqualError(t, res.Page(PageRequest{Cursor: "bad"}), "cursor bad not found")
+
+	// load more replies
+	res = MakeTree(comments, "time", 0)
+	branch, err := res.Branch("1", PageRequest{Cursor: "12", Limit: 1, Replies: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"13"}, ids(branch.Nodes))
+	assert.Equal(t, "13", branch.Cursor)
+	assert.Equal(t, 1, branch.More)
+	assert.Equal(t, []string{"131"}, ids(branch.Nodes[0].Replies))
+	assert.Equal(t, 1, branch.Nodes[0].More)
+
+	res = MakeTree(comments, "time", 0)
+	branch, err = res.Branch("13", PageRequest{Cursor: "131"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"132"}, ids(branch.Nodes), "nested branch")
+
+	res = MakeTree(comments, "time", 0)
+	branch, err = res.Branch("3", PageRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, []*Node{}, branch.Nodes, "no replies")
+
+	_, err = res.Branch("bad", PageRequest{})
+	assert.EqualError(t, err, "comment bad not found")
+}
+
+func TestTreeFlatten(t *testing.T) {
+	ts := func(min int, sec int) time.Time { return time.Date(2017, 12, 25, 19, min, sec, 0, time.UTC) }
+	comments := []store.Comment{
+		{ID: "1", Timestamp: ts(46, 1)},
+		{ID: "11", ParentID: "1", Timestamp: ts(46, 11)},
+		{ID: "111", ParentID: "11", Timestamp: ts(46, 31)},
+		{ID: "1111", ParentID: "111", Timestamp: ts(46, 41)},
+		{ID: "112", ParentID: "11", Timestamp: ts(46, 32)},
+		{ID: "12", ParentID: "1", Timestamp: ts(46, 12)},
+		{ID: "2", Timestamp: ts(47, 2)},
+	}
+	ids := func(nodes []*Node) (res []string) {
+		for _, n := range nodes {
+			res = append(res, n.Comment.ID)
+		}
+		return res
+	}
+
+	res := MakeTree(comments, "time", 0)
+	res.Flatten(0)
+	assert.Equal(t, []string{"1111"}, ids(res.Nodes[0].Replies[0].Replies[0].Replies), "unlimited")
+
+	res.Flatten(2)
+	assert.Equal(t, []string{"11", "12"}, ids(res.Nodes[0].Replies))
+	assert.Equal(t, []string{"111", "112", "1111"}, ids(res.Nodes[0].Replies[0].Replies), "sorted by time")
+	assert.Empty(t, res.Nodes[0].Replies[0].Replies[0].Replies)
+
+	res = MakeTree(comments, "time", 0)
+	res.Flatten(1)
+	assert.Equal(t, []string{"11", "12", "111", "112", "1111"}, ids(res.Nodes[0].Replies))
+	assert.Empty(t, res.Nodes[1].Replies)
+}
+
 func TestMakeEmptySubtree(t *testing.T) {
 	loc := store.Locator{URL: "url", SiteID: "site"}
 	ts := func(min int, sec int) time.Time { return time.Date(2017, 12, 25, 19, min, sec, 0, time.UTC) }
@@ -156,6 +264,17 @@ func TestTreeSortNodes(t *testing.T) {
 	res = MakeTree(comments, "undefined", 0)
 	t.Log(res.Nodes[0].Comment.ID, res.Nodes[0].tsModified)
 	assert.Equal(t, "1", res.Nodes[0].Comment.ID)
+	comments[12].Pin, comments[13].Pin = true, true // 3 and 6 pinned
+	res = MakeTree(comments, "-score", 0)
+	assert.Equal(t, "3", res.Nodes[0].Comment.ID, "pinned first")
+	assert.Equal(t, "6", res.Nodes[1].Comment.ID)
+	assert.Equal(t, "2", res.Nodes[2].Comment.ID)
+	assert.Equal(t, "1", res.Nodes[3].Comment.ID)
+
+	res = MakeTree(comments, "-time", 0)
+	assert.Equal(t, "6", res.Nodes[0].Comment.ID, "pinned sorted the same way")
+	assert.Equal(t, "3", res.Nodes[1].Comment.ID)
+	assert.Equal(t, "4", res.Nodes[2].Comment.ID)
 }
 
 func BenchmarkTree(b *testing.B) {
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"sort"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// PersonalViewers returns sorted ids of users seeing the post differently from other users with the same role:
+// voters and reactors see their own votes and reactions, authors of pending comments and shadow-banned authors
+// see these comments.
+// All other users see the same comments and can share rendered response.
+func (s *DataStore) PersonalViewers(locator store.Locator) ([]string, error) {
+	comments, err := s.Engine.Find(engine.FindRequest{Locator: locator, Sort: "time"})
+	if err != nil {
+		return nil, err
+	}
+	banned := s.shadowBanned(locator.SiteID)
+	viewers := map[string]bool{}
+	for _, c := range comments {
+		for userID := range c.Votes {
+			viewers[userID] = true
+		}
+		for userID := range c.Reactors {
+			viewers[userID] = true
+		}
+		if c.Pending || banned[c.User.ID] {
+			viewers[c.User.ID] = true
+		}
+	}
+	res := make([]string, 0, len(viewers))
+	for userID := range viewers {
+		res = append(res, userID)
+	}
+	sort.Strings(res)
+	return res, nil
+}
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+)
+
+func TestService_PersonalViewers(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), MaxVotes: -1, Reactions: []string{"heart"}}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	res, err := b.PersonalViewers(locator)
+	require.NoError(t, err)
+	assert.Equal(t, []string{}, res, "nobody voted yet")
+
+	_, err = b.Vote(VoteReq{Locator: locator, CommentID: "id-1", UserID: "user3", UserIP: "1", Val: true})
+	require.NoError(t, err)
+	_, err = b.React(ReactReq{Locator: locator, CommentID: "id-2", UserID: "user2", Reaction: "heart"})
+	require.NoError(t, err)
+	_, err = b.Create(store.Comment{Text: "pending", Locator: locator, User: store.User{ID: "user4"}, Pending: true})
+	require.NoError(t, err)
+	_, err = b.Create(store.Comment{Text: "approved", Locator: locator, User: store.User{ID: "user5"}})
+	require.NoError(t, err)
+
+	res, err = b.PersonalViewers(locator)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user2", "user3", "user4"}, res)
+
+	_, err = b.PersonalViewers(store.Locator{URL: "https://radio-t.com/other", SiteID: "radio-t"})
+	assert.Error(t, err, "unknown post")
+}
@@ -0,0 +1,41 @@
+package service
+
+import (
+	log "github.com/go-pkgz/lgr"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// VoidVote removes vote of the user for the comment and reverts the score, as if the user never voted.
+// Used to void fraudulent votes, returns unchanged comment and false if the user has no vote for the comment.
+func (s *DataStore) VoidVote(locator store.Locator, commentID, userID string) (comment store.Comment, voided bool, err error) {
+	cLock := s.getScopedLocks(locator.URL)
+	cLock.Lock()
+	defer cLock.Unlock()
+
+	comment, err = s.Engine.Get(engine.GetRequest{Locator: locator, CommentID: commentID})
+	if err != nil {
+		return comment, false, err
+	}
+	val, ok := comment.Votes[userID]
+	if !ok {
+		return comment, false, nil
+	}
+	delete(comment.Votes, userID)
+	if val {
+		comment.Score--
+	} else {
+		comment.Score++
+	}
+	comment.Vote = 0
+	comment.Controversy = s.controversy(s.upsAndDowns(comment))
+	comment.Best = best(s.upsAndDowns(comment))
+	comment.Locator = locator
+	s.updateQuality(&comment)
+	if err = s.Engine.Update(comment); err != nil {
+		return comment, false, err
+	}
+	log.Printf("[INFO] audit: vote %v of %s for comment %s of %s voided", val, userID, commentID, locator.SiteID)
+	return comment, true, nil
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+func TestService_VoidVote(t *testing.T) {
+	eng, teardown := prepStoreEngine(t)
+	defer teardown()
+	b := DataStore{Engine: eng, AdminStore: admin.NewStaticKeyStore("secret 123"), MaxVotes: -1}
+	locator := store.Locator{URL: "https://radio-t.com", SiteID: "radio-t"}
+
+	for i, u := range []string{"user2", "user3", "user4"} {
+		_, err := b.Vote(VoteReq{Locator: locator, CommentID: "id-1", UserID: u, UserIP: string(rune('1' + i)), Val: u != "user4"})
+		require.NoError(t, err)
+	}
+	c, err := b.Engine.Get(engine.GetRequest{Locator: locator, CommentID: "id-1"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, c.Score)
+
+	c, voided, err := b.VoidVote(locator, "id-1", "user2")
+	require.NoError(t, err)
+	assert.True(t, voided)
+	assert.Equal(t, 0, c.Score)
+	assert.Equal(t, map[string]bool{"user3": true, "user4": false}, c.Votes)
+
+	c, voided, err = b.VoidVote(locator, "id-1", "user4")
+	require.NoError(t, err)
+	assert.True(t, voided)
+	assert.Equal(t, 1, c.Score)
+	assert.Equal(t, 0.0, c.Controversy)
+
+	c, err = b.Engine.Get(engine.GetRequest{Locator: locator, CommentID: "id-1"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, c.Score, "stored")
+	assert.Equal(t, map[string]bool{"user3": true}, c.Votes)
+
+	_, voided, err = b.VoidVote(locator, "id-1", "user2")
+	require.NoError(t, err)
+	assert.False(t, voided, "no vote to void")
+
+	_, _, err = b.VoidVote(locator, "bad-id", "user3")
+	assert.Error(t, err)
+}
@@ -24,6 +24,17 @@ type User struct {
 	Verified          bool   `json:"verified,omitempty"`
 	EmailSubscription bool   `json:"email_subscription,omitempty"`
 	SiteID            string `json:"site_id,omitempty"`
+	Role              string `json:"role,omitempty"`    // role of admin on the site, set in user info only
+	Website           string `json:"website,omitempty"` // from user's profile
+	Bio               string `json:"bio,omitempty"`     // from user's profile
+	Karma             int    `json:"karma,omitempty"`   // set in comments with reputation enabled, see reputation.Karma
+}
+
+// Profile of the user, edited by the user and shown with comments
+type Profile struct {
+	DisplayName string `json:"display_name,omitempty"` // replaces name of the user in comments
+	Website     string `json:"website,omitempty"`
+	Bio         string `json:"bio,omitempty"`
 }
 
 var reValidSha = regexp.MustCompile("^[a-fA-F0-9]{40}$")
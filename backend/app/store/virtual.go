@@ -0,0 +1,74 @@
+package store
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// VirtualPrefix starts url of virtual locator. Virtual locator identifies the thread by the arbitrary stable key
+// instead of a real url, like a screen of single-page or mobile app, i.e. "virtual:product/123"
+const VirtualPrefix = "virtual:"
+
+// CommentAnchor appended to the post url by ui to navigate to the comment, <post-url>#remark42__comment-<comment-id>
+const CommentAnchor = "#remark42__comment-"
+
+var reVirtualKey = regexp.MustCompile(`^[\w.~:/@-]{1,256}$`)
+
+// VirtualLocator makes locator of the thread with the key, error if key is empty, longer than 256 characters
+// or has characters other than letters, digits and "_.~:/@-"
+func VirtualLocator(siteID, key string) (Locator, error) {
+	if !reVirtualKey.MatchString(key) {
+		return Locator{}, fmt.Errorf("invalid virtual key %q", key)
+	}
+	return Locator{SiteID: siteID, URL: VirtualPrefix + key}, nil
+}
+
+// IsVirtual checks if locator identifies the thread by the key instead of a real url
+func (l Locator) IsVirtual() bool {
+	return strings.HasPrefix(l.URL, VirtualPrefix)
+}
+
+// Key of the virtual locator, empty for locators with real url
+func (l Locator) Key() string {
+	if !l.IsVirtual() {
+		return ""
+	}
+	return strings.TrimPrefix(l.URL, VirtualPrefix)
+}
+
+// Links keeps per-site templates of canonical links to threads of virtual locators, site:template.
+// Template has {key} placeholder replaced by the escaped key and optional {id} placeholder replaced by the
+// comment id, i.e. https://app.example.com/products/{key}?comment={id}. Comment anchor appended if {id} not set.
+type Links map[string]string
+
+// Post returns canonical link to the post. For virtual locator it's made from the template of the site,
+// empty if not set. Url of other locators returned as is.
+func (l Links) Post(locator Locator) string {
+	if !locator.IsVirtual() {
+		return locator.URL
+	}
+	tmpl, ok := l[locator.SiteID]
+	if !ok {
+		return ""
+	}
+	link := strings.ReplaceAll(tmpl, "{key}", url.PathEscape(locator.Key()))
+	return strings.ReplaceAll(link, "{id}", "")
+}
+
+// Comment returns canonical link to the comment, empty if link to the post unknown
+func (l Links) Comment(locator Locator, commentID string) string {
+	if !locator.IsVirtual() {
+		return locator.URL + CommentAnchor + commentID
+	}
+	tmpl, ok := l[locator.SiteID]
+	if !ok {
+		return ""
+	}
+	link := strings.ReplaceAll(tmpl, "{key}", url.PathEscape(locator.Key()))
+	if !strings.Contains(tmpl, "{id}") {
+		return link + CommentAnchor + commentID
+	}
+	return strings.ReplaceAll(link, "{id}", url.QueryEscape(commentID))
+}
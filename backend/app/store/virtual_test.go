@@ -0,0 +1,53 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocator_Virtual(t *testing.T) {
+	l, err := VirtualLocator("site", "product/123")
+	require.NoError(t, err)
+	assert.Equal(t, Locator{SiteID: "site", URL: "virtual:product/123"}, l)
+	assert.True(t, l.IsVirtual())
+	assert.Equal(t, "product/123", l.Key())
+
+	l = Locator{SiteID: "site", URL: "https://example.com/post"}
+	assert.False(t, l.IsVirtual())
+	assert.Equal(t, "", l.Key())
+
+	for _, key := range []string{"", "with space", "<b>", string(make([]byte, 257))} {
+		_, err = VirtualLocator("site", key)
+		assert.Error(t, err, key)
+	}
+
+	c := Comment{Locator: Locator{URL: "virtual:screen.main~1"}}
+	c.Sanitize()
+	assert.Equal(t, "virtual:screen.main~1", c.Locator.URL, "kept by sanitize")
+	c = Comment{Locator: Locator{URL: "virtual:<script>"}}
+	c.Sanitize()
+	assert.Equal(t, "", c.Locator.URL, "invalid virtual url dropped")
+}
+
+func TestLinks(t *testing.T) {
+	links := Links{"site1": "https://app.example.com/p/{key}", "site2": "https://example.com/app?thread={key}&comment={id}"}
+
+	l := Locator{SiteID: "site1", URL: "https://example.com/post"}
+	assert.Equal(t, "https://example.com/post", links.Post(l))
+	assert.Equal(t, "https://example.com/post#remark42__comment-c1", links.Comment(l, "c1"))
+
+	l = Locator{SiteID: "site1", URL: "virtual:product/123"}
+	assert.Equal(t, "https://app.example.com/p/product%2F123", links.Post(l))
+	assert.Equal(t, "https://app.example.com/p/product%2F123#remark42__comment-c1", links.Comment(l, "c1"))
+
+	l = Locator{SiteID: "site2", URL: "virtual:product-1"}
+	assert.Equal(t, "https://example.com/app?thread=product-1&comment=", links.Post(l))
+	assert.Equal(t, "https://example.com/app?thread=product-1&comment=c1", links.Comment(l, "c1"))
+
+	l = Locator{SiteID: "site3", URL: "virtual:product-1"}
+	assert.Equal(t, "", links.Post(l), "no template")
+	assert.Equal(t, "", links.Comment(l, "c1"))
+	assert.Equal(t, "", Links(nil).Comment(l, "c1"))
+}
@@ -0,0 +1,32 @@
+package totp
+
+import (
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// EngineStore implements Store with records of store engine, enrollments kept along with comments of the site
+type EngineStore struct {
+	records engine.Records // keyed by userID
+}
+
+// NewEngineStore makes store of enrollments kept by eng
+func NewEngineStore(eng engine.Interface) *EngineStore {
+	return &EngineStore{records: engine.Records{Engine: eng, Kind: engine.TwoFactor}}
+}
+
+// Get enrollment of the user
+func (s *EngineStore) Get(siteID, userID string) (e Enrollment, found bool, err error) {
+	found, err = s.records.Get(siteID, userID, &e)
+	return e, found, err
+}
+
+// Set enrollment of the user
+func (s *EngineStore) Set(siteID, userID string, e Enrollment) error {
+	return s.records.Set(siteID, userID, e)
+}
+
+// Delete enrollment of the user, missing enrollment ignored
+func (s *EngineStore) Delete(siteID, userID string) error {
+	_, err := s.records.Delete(siteID, userID)
+	return err
+}
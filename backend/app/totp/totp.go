@@ -0,0 +1,292 @@
+// Package totp implements two-factor auth of admins with time-based one-time passwords (RFC 6238) generated by
+// authenticator apps. Admin enrolls with a secret shown as QR code, confirms it with the first code and gets backup
+// codes for the case of lost device. Secrets and hashed backup codes kept in Store per site and user.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec // sha1 is the default algorithm of authenticator apps
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+	"github.com/skip2/go-qrcode"
+)
+
+// attributes of token user
+const (
+	VerifiedFlag = "totp_verified" // code verified in this session
+	PendingFlag  = "totp_pending"  // admin should verify code, admin rights not granted till then
+)
+
+const (
+	period      = 30 // seconds of each code
+	digits      = 6
+	backupCodes = 10
+)
+
+// errors returned to users
+var (
+	ErrInvalidCode = errors.New("invalid code")
+	ErrNotEnrolled = errors.New("two-factor auth not enrolled")
+	ErrEnrolled    = errors.New("two-factor auth already enrolled")
+	ErrLocked      = errors.New("too many invalid codes, try later")
+)
+
+// Enrollment of the user
+type Enrollment struct {
+	Secret      string    `json:"secret"`       // base32 encoded secret
+	Confirmed   bool      `json:"confirmed"`    // confirmed by the first code, not used for verification till then
+	BackupCodes []string  `json:"backup_codes"` // sha256 hashes of unused backup codes
+	LastStep    int64     `json:"last_step"`    // time step of the last accepted code, the same code can't be used twice
+	Created     time.Time `json:"created"`
+	Failures    int       `json:"failures,omitempty"`     // invalid codes in a row
+	LockedUntil time.Time `json:"locked_until,omitempty"` // verification rejected till the time after too many failures
+}
+
+// Store defines interface to keep enrollments per site and user
+type Store interface {
+	Get(siteID, userID string) (e Enrollment, found bool, err error)
+	Set(siteID, userID string, e Enrollment) error
+	Delete(siteID, userID string) error
+}
+
+// Secret of new enrollment shown to the user
+type Secret struct {
+	Secret string `json:"secret"` // base32 encoded, for manual entry
+	URL    string `json:"url"`    // otpauth url
+	QR     string `json:"qr"`     // qr code of the url, png data url
+}
+
+// Params of service
+type Params struct {
+	Issuer      string                   // name shown in authenticator app, "remark42" by default
+	Sites       func(siteID string) bool // checks if two-factor auth required from all admins of the site, optional
+	MaxFailures int                      // invalid codes in a row locking verification, 5 by default
+	Lockout     time.Duration            // verification locked for, 15 minutes by default
+}
+
+// Service manages enrollments and verifies codes
+type Service struct {
+	Params
+	store Store
+	lock  sync.Mutex // serializes changes of enrollments, i.e. use of backup codes
+	now   func() time.Time
+}
+
+// NewService makes two-factor auth service with enrollments kept in the store
+func NewService(st Store, params Params) *Service {
+	res := Service{Params: params, store: st, now: time.Now}
+	if res.Issuer == "" {
+		res.Issuer = "remark42"
+	}
+	if res.MaxFailures <= 0 {
+		res.MaxFailures = 5
+	}
+	if res.Lockout <= 0 {
+		res.Lockout = 15 * time.Minute
+	}
+	return &res
+}
+
+// Required checks if the admin should verify the code to get admin rights, either enrolled or enforced for the site.
+// Always false for nil service.
+func (s *Service) Required(siteID, userID string) bool {
+	if s == nil {
+		return false
+	}
+	return s.Enforced(siteID) || s.Enrolled(siteID, userID)
+}
+
+// Enforced checks if two-factor auth required from all admins of the site
+func (s *Service) Enforced(siteID string) bool {
+	return s.Sites != nil && s.Sites(siteID)
+}
+
+// Enrolled checks if the user has confirmed enrollment. Errors of store logged and treated as enrolled,
+// to keep admin rights closed.
+func (s *Service) Enrolled(siteID, userID string) bool {
+	e, found, err := s.store.Get(siteID, userID)
+	if err != nil {
+		log.Printf("[WARN] can't get two-factor auth enrollment of %s, %v", userID, err)
+		return true
+	}
+	return found && e.Confirmed
+}
+
+// Enroll makes a new secret for the user, account is the name shown in authenticator app.
+// Previous unconfirmed secret replaced, confirmed one should be disabled first.
+func (s *Service) Enroll(siteID, userID, account string) (Secret, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if e, found, err := s.store.Get(siteID, userID); err != nil || (found && e.Confirmed) {
+		if err != nil {
+			return Secret{}, err
+		}
+		return Secret{}, ErrEnrolled
+	}
+
+	key := make([]byte, 20)
+	if _, err := rand.Read(key); err != nil {
+		return Secret{}, errors.Wrap(err, "can't make secret")
+	}
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(key)
+	if err := s.store.Set(siteID, userID, Enrollment{Secret: secret, Created: s.now()}); err != nil {
+		return Secret{}, err
+	}
+
+	label := url.PathEscape(s.Issuer + ":" + account)
+	params := url.Values{"secret": {secret}, "issuer": {s.Issuer}, "algorithm": {"SHA1"},
+		"digits": {fmt.Sprintf("%d", digits)}, "period": {fmt.Sprintf("%d", period)}}
+	res := Secret{Secret: secret, URL: "otpauth://totp/" + label + "?" + params.Encode()}
+	png, err := qrcode.Encode(res.URL, qrcode.Medium, 256)
+	if err != nil {
+		return Secret{}, errors.Wrap(err, "can't make qr code")
+	}
+	res.QR = "data:image/png;base64," + base64.StdEncoding.EncodeToString(png)
+	log.Printf("[INFO] two-factor auth enrollment of %s started for %s", userID, siteID)
+	return res, nil
+}
+
+// Confirm enrollment with the code made by authenticator app, returns backup codes. Each backup code can be used
+// once instead of the code of authenticator app, codes shown only once and kept hashed.
+func (s *Service) Confirm(siteID, userID, code string) ([]string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	e, found, err := s.store.Get(siteID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrNotEnrolled
+	}
+	if e.Confirmed {
+		return nil, ErrEnrolled
+	}
+	step, ok := s.validate(e, code)
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	res := make([]string, backupCodes)
+	e.BackupCodes = make([]string, backupCodes)
+	for i := range res {
+		b := make([]byte, 5)
+		if _, err = rand.Read(b); err != nil {
+			return nil, errors.Wrap(err, "can't make backup code")
+		}
+		c := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+		res[i] = c[:4] + "-" + c[4:]
+		e.BackupCodes[i] = hashCode(res[i])
+	}
+	e.Confirmed, e.LastStep = true, step
+	if err = s.store.Set(siteID, userID, e); err != nil {
+		return nil, err
+	}
+	log.Printf("[INFO] two-factor auth of %s enrolled for %s", userID, siteID)
+	return res, nil
+}
+
+// Verify the code of authenticator app or one of backup codes, used backup code removed.
+// After MaxFailures invalid codes in a row verification of the user locked for Lockout, even with valid code.
+func (s *Service) Verify(siteID, userID, code string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	e, found, err := s.store.Get(siteID, userID)
+	if err != nil {
+		return err
+	}
+	if !found || !e.Confirmed {
+		return ErrNotEnrolled
+	}
+	now := s.now()
+	if now.Before(e.LockedUntil) {
+		return ErrLocked
+	}
+	if step, ok := s.validate(e, code); ok {
+		e.LastStep, e.Failures = step, 0
+		return s.store.Set(siteID, userID, e)
+	}
+
+	hash := hashCode(code)
+	for i, h := range e.BackupCodes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			e.BackupCodes, e.Failures = append(e.BackupCodes[:i], e.BackupCodes[i+1:]...), 0
+			log.Printf("[INFO] backup code of %s used for %s, %d left", userID, siteID, len(e.BackupCodes))
+			return s.store.Set(siteID, userID, e)
+		}
+	}
+
+	if e.Failures++; e.Failures >= s.MaxFailures {
+		e.Failures, e.LockedUntil = 0, now.Add(s.Lockout)
+		log.Printf("[WARN] two-factor auth of %s locked for %s till %s, too many invalid codes", userID, siteID,
+			e.LockedUntil.Format(time.RFC3339))
+	}
+	if err = s.store.Set(siteID, userID, e); err != nil {
+		return err
+	}
+	return ErrInvalidCode
+}
+
+// Disable two-factor auth of the user, the code verified first
+func (s *Service) Disable(siteID, userID, code string) error {
+	if err := s.Verify(siteID, userID, code); err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.store.Delete(siteID, userID); err != nil {
+		return err
+	}
+	log.Printf("[INFO] two-factor auth of %s disabled for %s", userID, siteID)
+	return nil
+}
+
+// validate the code against current time step, one step before and after allowed for clock drift.
+// Returns matched step, codes of already used steps rejected.
+func (s *Service) validate(e Enrollment, code string) (int64, bool) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(e.Secret)
+	if err != nil || len(code) != digits {
+		return 0, false
+	}
+	now := s.now().Unix() / period
+	for step := now - 1; step <= now+1; step++ {
+		if step <= e.LastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(hotp(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// hotp makes code for the counter, RFC 4226
+func hotp(key []byte, counter int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	_, _ = mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", digits, value%1000000)
+}
+
+// hashCode makes hash of backup code, case and separators ignored
+func hashCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	h := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,149 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+func TestService_EnrollAndVerify(t *testing.T) {
+	st := prepStore(t)
+	svc := NewService(st, Params{})
+	ts := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	svc.now = func() time.Time { return ts }
+
+	_, err := svc.Confirm("site1", "admin1", "123456")
+	assert.Equal(t, ErrNotEnrolled, err)
+	assert.Equal(t, ErrNotEnrolled, svc.Verify("site1", "admin1", "123456"))
+
+	secret, err := svc.Enroll("site1", "admin1", "Admin One")
+	require.NoError(t, err)
+	assert.Equal(t, 32, len(secret.Secret))
+	assert.True(t, strings.HasPrefix(secret.URL, "otpauth://totp/remark42:Admin%20One?"), secret.URL)
+	assert.Contains(t, secret.URL, "secret="+secret.Secret)
+	assert.True(t, strings.HasPrefix(secret.QR, "data:image/png;base64,"))
+	assert.False(t, svc.Enrolled("site1", "admin1"), "not confirmed yet")
+	assert.Equal(t, ErrNotEnrolled, svc.Verify("site1", "admin1", code(t, secret.Secret, ts)))
+
+	_, err = svc.Confirm("site1", "admin1", "000000")
+	assert.Equal(t, ErrInvalidCode, err)
+	backup, err := svc.Confirm("site1", "admin1", code(t, secret.Secret, ts))
+	require.NoError(t, err)
+	assert.Equal(t, 10, len(backup))
+	assert.True(t, svc.Enrolled("site1", "admin1"))
+	assert.False(t, svc.Enrolled("site2", "admin1"), "enrolled per site")
+	_, err = svc.Enroll("site1", "admin1", "Admin One")
+	assert.Equal(t, ErrEnrolled, err)
+
+	assert.Equal(t, ErrInvalidCode, svc.Verify("site1", "admin1", code(t, secret.Secret, ts)), "code used already")
+	assert.NoError(t, svc.Verify("site1", "admin1", code(t, secret.Secret, ts.Add(30*time.Second))), "next step")
+	ts = ts.Add(5 * time.Minute)
+	assert.NoError(t, svc.Verify("site1", "admin1", code(t, secret.Secret, ts.Add(-30*time.Second))), "clock drift")
+	assert.Equal(t, ErrInvalidCode, svc.Verify("site1", "admin1", code(t, secret.Secret, ts.Add(-time.Minute))))
+
+	assert.NoError(t, svc.Verify("site1", "admin1", strings.ToUpper(backup[0])))
+	assert.Equal(t, ErrInvalidCode, svc.Verify("site1", "admin1", backup[0]), "backup code used once")
+	assert.NoError(t, svc.Verify("site1", "admin1", strings.Replace(backup[1], "-", "", 1)))
+
+	assert.Equal(t, ErrInvalidCode, svc.Disable("site1", "admin1", "bad"))
+	assert.NoError(t, svc.Disable("site1", "admin1", backup[2]))
+	assert.False(t, svc.Enrolled("site1", "admin1"))
+}
+
+func TestService_VerifyLockout(t *testing.T) {
+	st := prepStore(t)
+	svc := NewService(st, Params{MaxFailures: 3, Lockout: 10 * time.Minute})
+	ts := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	svc.now = func() time.Time { return ts }
+	secret, err := svc.Enroll("site1", "admin1", "Admin One")
+	require.NoError(t, err)
+	backup, err := svc.Confirm("site1", "admin1", code(t, secret.Secret, ts))
+	require.NoError(t, err)
+
+	// failures in a row counted, valid code resets them
+	assert.Equal(t, ErrInvalidCode, svc.Verify("site1", "admin1", "000000"))
+	assert.Equal(t, ErrInvalidCode, svc.Verify("site1", "admin1", "000001"))
+	assert.NoError(t, svc.Verify("site1", "admin1", backup[0]))
+	assert.Equal(t, ErrInvalidCode, svc.Verify("site1", "admin1", "000002"))
+	assert.Equal(t, ErrInvalidCode, svc.Verify("site1", "admin1", "000003"))
+
+	assert.Equal(t, ErrInvalidCode, svc.Verify("site1", "admin1", "000004"), "third failure in a row locks")
+	ts = ts.Add(30 * time.Second)
+	assert.Equal(t, ErrLocked, svc.Verify("site1", "admin1", code(t, secret.Secret, ts)), "valid code rejected while locked")
+	assert.Equal(t, ErrLocked, svc.Verify("site1", "admin1", backup[1]))
+	assert.Equal(t, ErrLocked, svc.Disable("site1", "admin1", backup[1]))
+
+	ts = ts.Add(10 * time.Minute)
+	assert.NoError(t, svc.Verify("site1", "admin1", code(t, secret.Secret, ts)), "unlocked after lockout")
+}
+
+func TestService_Required(t *testing.T) {
+	st := prepStore(t)
+	svc := NewService(st, Params{Issuer: "blog", Sites: func(siteID string) bool { return siteID == "site2" }})
+	require.NoError(t, st.Set("site1", "admin1", Enrollment{Secret: "AAAA", Confirmed: true}))
+	require.NoError(t, st.Set("site1", "admin2", Enrollment{Secret: "AAAA"}))
+
+	assert.True(t, svc.Required("site1", "admin1"), "enrolled")
+	assert.False(t, svc.Required("site1", "admin2"), "not confirmed")
+	assert.True(t, svc.Required("site2", "admin2"), "enforced for site")
+	assert.False(t, svc.Enforced("site1"))
+
+	secret, err := svc.Enroll("site1", "admin2", "admin2")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(secret.URL, "otpauth://totp/blog:admin2?"), secret.URL)
+
+	var nilSvc *Service
+	assert.False(t, nilSvc.Required("site1", "admin1"))
+}
+
+func TestHOTP(t *testing.T) {
+	// test vectors from RFC 4226, appendix D
+	key := []byte("12345678901234567890")
+	expected := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for i, exp := range expected {
+		assert.Equal(t, exp, hotp(key, int64(i)), i)
+	}
+}
+
+func TestEngineStore(t *testing.T) {
+	st := prepStore(t)
+
+	_, found, err := st.Get("site1", "user1")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	e := Enrollment{Secret: "secret", Confirmed: true, BackupCodes: []string{"h1"}, LastStep: 123,
+		Created: time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)}
+	require.NoError(t, st.Set("site1", "user1", e))
+	res, found, err := st.Get("site1", "user1")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, e, res)
+	_, found, err = st.Get("site2", "user1")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	require.NoError(t, st.Delete("site1", "user1"))
+	require.NoError(t, st.Delete("site1", "user1"))
+	_, found, err = st.Get("site1", "user1")
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func code(t *testing.T, secret string, ts time.Time) string {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	require.NoError(t, err)
+	return hotp(key, ts.Unix()/period)
+}
+
+func prepStore(t *testing.T) *EngineStore {
+	eng, err := engine.NewMemory("", "site1", "site2")
+	require.NoError(t, err)
+	return NewEngineStore(eng)
+}
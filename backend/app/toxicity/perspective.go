@@ -0,0 +1,97 @@
+package toxicity
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+const perspectiveAPI = "https://commentanalyzer.googleapis.com/v1alpha1/comments:analyze"
+
+// Perspective scores comments with Perspective API, or any service compatible with its analyze call.
+// Comments not stored by the service.
+type Perspective struct {
+	APIKey string
+	API    string // optional, default is Google's analyze endpoint
+	Client http.Client
+}
+
+type perspectiveRequest struct {
+	Comment struct {
+		Text string `json:"text"`
+	} `json:"comment"`
+	RequestedAttributes map[string]struct{} `json:"requestedAttributes"`
+	DoNotStore          bool                `json:"doNotStore"`
+}
+
+type perspectiveResponse struct {
+	AttributeScores map[string]struct {
+		SummaryScore struct {
+			Value float64 `json:"value"`
+		} `json:"summaryScore"`
+	} `json:"attributeScores"`
+}
+
+// Score text with TOXICITY attribute of the analyze call
+func (p *Perspective) Score(ctx context.Context, text string) (float64, error) {
+	req := perspectiveRequest{RequestedAttributes: map[string]struct{}{"TOXICITY": {}}, DoNotStore: true}
+	req.Comment.Text = text
+	body, err := json.Marshal(req)
+	if err != nil {
+		return 0, errors.Wrap(err, "can't marshal perspective request")
+	}
+
+	u, err := url.Parse(p.api())
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid perspective api url %s", p.api())
+	}
+	if p.APIKey != "" {
+		q := u.Query()
+		q.Set("key", p.APIKey)
+		u.RawQuery = q.Encode()
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return 0, errors.Wrap(err, "can't make perspective request")
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	resp, err := p.Client.Do(httpReq)
+	if err != nil {
+		return 0, errors.Wrap(err, "perspective request failed")
+	}
+	defer resp.Body.Close() // nolint
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, errors.Wrap(err, "can't read perspective response")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, errors.Errorf("perspective error, status %d, %s", resp.StatusCode, string(respBody))
+	}
+
+	res := perspectiveResponse{}
+	if err = json.Unmarshal(respBody, &res); err != nil {
+		return 0, errors.Wrap(err, "can't decode perspective response")
+	}
+	score, ok := res.AttributeScores["TOXICITY"]
+	if !ok {
+		return 0, errors.New("no toxicity score in perspective response")
+	}
+	return score.SummaryScore.Value, nil
+}
+
+// String representation of Perspective
+func (p *Perspective) String() string {
+	return "perspective api " + p.api()
+}
+
+func (p *Perspective) api() string {
+	if p.API != "" {
+		return p.API
+	}
+	return perspectiveAPI
+}
@@ -0,0 +1,49 @@
+package toxicity
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPerspective(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1alpha1/comments:analyze", r.URL.Path)
+		assert.Equal(t, "secret", r.URL.Query().Get("key"))
+		req := perspectiveRequest{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.True(t, req.DoNotStore)
+		assert.Contains(t, req.RequestedAttributes, "TOXICITY")
+		switch req.Comment.Text {
+		case "toxic":
+			_, _ = w.Write([]byte(`{"attributeScores":{"TOXICITY":{"summaryScore":{"value":0.93,"type":"PROBABILITY"}}}}`))
+		case "bad":
+			_, _ = w.Write([]byte(`not json`))
+		case "empty":
+			_, _ = w.Write([]byte(`{"attributeScores":{}}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer ts.Close()
+
+	p := &Perspective{APIKey: "secret", API: ts.URL + "/v1alpha1/comments:analyze"}
+	assert.Equal(t, "perspective api "+ts.URL+"/v1alpha1/comments:analyze", p.String())
+	score, err := p.Score(context.Background(), "toxic")
+	require.NoError(t, err)
+	assert.Equal(t, 0.93, score)
+
+	_, err = p.Score(context.Background(), "bad")
+	assert.Error(t, err)
+	_, err = p.Score(context.Background(), "empty")
+	assert.EqualError(t, err, "no toxicity score in perspective response")
+	_, err = p.Score(context.Background(), "other")
+	assert.Contains(t, err.Error(), "perspective error, status 400")
+
+	assert.Equal(t, "perspective api "+perspectiveAPI, (&Perspective{}).String())
+}
@@ -0,0 +1,32 @@
+package toxicity
+
+import (
+	"context"
+	"strings"
+	"unicode"
+)
+
+// Stub scores comments locally, without network calls, for tests and development. Text with any of Words
+// scored 1, any other text scored 0. Words matched case-insensitive.
+type Stub struct {
+	Words []string
+}
+
+// Score text by presence of listed words
+func (s *Stub) Score(_ context.Context, text string) (float64, error) {
+	words := map[string]bool{}
+	for _, w := range s.Words {
+		words[strings.ToLower(strings.TrimSpace(w))] = true
+	}
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		if words[w] {
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+// String representation of Stub
+func (s *Stub) String() string {
+	return "stub scorer"
+}
@@ -0,0 +1,197 @@
+// Package toxicity scores new comments with external services, like Perspective API, in background.
+// Depending on policy of the site the score only kept with the comment, or comments with score at or above
+// threshold reported to moderators or held for moderation.
+package toxicity
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/microcosm-cc/bluemonday"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/service"
+)
+
+// Scorer defines interface of toxicity scoring service
+type Scorer interface {
+	fmt.Stringer
+	Score(ctx context.Context, text string) (float64, error) // returns score of plain text, from 0 to 1
+}
+
+// Policy for toxic comments of a site
+type Policy string
+
+// Policy enum
+const (
+	None     Policy = "none"     // comments not scored
+	Annotate Policy = "annotate" // score kept with the comment only
+	Flag     Policy = "flag"     // comment reported to moderators
+	Hold     Policy = "hold"     // comment held for moderation
+)
+
+// Store defines interface to save scores of comments
+type Store interface {
+	SetToxicity(req service.ToxicityReq) (store.Comment, error)
+}
+
+// Params of Service
+type Params struct {
+	Timeout   time.Duration                                          // timeout of a single call to scorer, default 5s
+	Workers   int                                                    // number of concurrent calls to scorer, default 1
+	QueueSize int                                                    // max number of comments waiting for scoring, default 1000
+	Policy    func(siteID string) (policy string, threshold float64) // policy of the site, Annotate for all sites if not set
+}
+
+// Service scores new comments in background and applies policy of the site to them.
+// Errors of scorer logged and comments left as is.
+type Service struct {
+	Params
+	scorer Scorer
+	store  Store
+	queue  chan job
+	wg     sync.WaitGroup
+
+	lock   sync.RWMutex
+	closed bool
+}
+
+type job struct {
+	comment store.Comment
+	done    func(store.Comment)
+}
+
+// NewService makes toxicity service for scorer and starts its workers
+func NewService(scorer Scorer, dataStore Store, params Params) *Service {
+	if params.Timeout <= 0 {
+		params.Timeout = 5 * time.Second
+	}
+	if params.Workers <= 0 {
+		params.Workers = 1
+	}
+	if params.QueueSize <= 0 {
+		params.QueueSize = 1000
+	}
+	res := &Service{Params: params, scorer: scorer, store: dataStore, queue: make(chan job, params.QueueSize)}
+	res.wg.Add(params.Workers)
+	for i := 0; i < params.Workers; i++ {
+		go func() {
+			defer res.wg.Done()
+			for j := range res.queue {
+				res.score(j)
+			}
+		}()
+	}
+	log.Printf("[INFO] toxicity scorer %s, workers=%d", scorer, params.Workers)
+	return res
+}
+
+// Policy returns policy for toxic comments of the site and score threshold, None for nil Service
+func (s *Service) Policy(siteID string) (Policy, float64) {
+	if s == nil {
+		return None, 0
+	}
+	if s.Params.Policy == nil {
+		return Annotate, 0
+	}
+	policy, threshold := s.Params.Policy(siteID)
+	switch Policy(policy) {
+	case Annotate, Flag, Hold:
+		return Policy(policy), threshold
+	}
+	return None, threshold
+}
+
+// Submit queues comment for scoring, never blocks. Optional done func called with updated comment once it scored,
+// or with the original one on errors. Returns false if comment not queued, i.e. for sites with None policy,
+// for full queue or closed service. Safe to call on nil Service.
+func (s *Service) Submit(comment store.Comment, done func(store.Comment)) bool {
+	if policy, _ := s.Policy(comment.Locator.SiteID); policy == None {
+		return false
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.closed {
+		return false
+	}
+	select {
+	case s.queue <- job{comment: comment, done: done}:
+		return true
+	default:
+		log.Printf("[WARN] toxicity queue is full, comment %s not scored", comment.ID)
+		return false
+	}
+}
+
+// Close stops accepting comments and waits for queued ones to be scored, safe to call on nil Service
+func (s *Service) Close() {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.queue)
+	s.lock.Unlock()
+	s.wg.Wait()
+}
+
+func (s *Service) score(j job) {
+	res := j.comment
+	defer func() {
+		if j.done != nil {
+			j.done(res)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+	score, err := s.scorer.Score(ctx, text(j.comment))
+	if err != nil {
+		log.Printf("[WARN] can't score toxicity of comment %s with %s, %v", j.comment.ID, s.scorer, err)
+		return
+	}
+	score = clamp(score)
+
+	// policy checked again, it could be changed while comment waited in the queue
+	policy, threshold := s.Policy(j.comment.Locator.SiteID)
+	toxic := score >= threshold
+	req := service.ToxicityReq{Locator: j.comment.Locator, CommentID: j.comment.ID, Score: score,
+		Flag: policy == Flag && toxic, Hold: policy == Hold && toxic}
+	c, err := s.store.SetToxicity(req)
+	if err != nil {
+		log.Printf("[WARN] can't set toxicity of comment %s, %v", j.comment.ID, err)
+		return
+	}
+	if req.Flag || req.Hold {
+		log.Printf("[INFO] comment %s of %s on %s scored %.2f, flag=%v, hold=%v", c.ID, c.User.ID, c.Locator.URL,
+			score, req.Flag, req.Hold)
+	}
+	res = c
+}
+
+func clamp(score float64) float64 {
+	if score < 0 {
+		return 0
+	}
+	if score > 1 {
+		return 1
+	}
+	return score
+}
+
+// text returns original text of the comment, or rendered one with html tags stripped
+func text(c store.Comment) string {
+	if c.Orig != "" {
+		return c.Orig
+	}
+	return strings.TrimSpace(html.UnescapeString(bluemonday.StrictPolicy().Sanitize(c.Text)))
+}
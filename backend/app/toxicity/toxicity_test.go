@@ -0,0 +1,136 @@
+package toxicity
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/service"
+)
+
+func TestService_Submit(t *testing.T) {
+	st := &mockStore{}
+	policies := map[string]string{"annotate": "annotate", "flag": "flag", "hold": "hold", "none": "none"}
+	svc := NewService(&failingScorer{Scorer: &Stub{Words: []string{"idiot"}}}, st, Params{Workers: 2,
+		Policy: func(siteID string) (string, float64) { return policies[siteID], 0.8 }})
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	done := map[string]store.Comment{}
+	submit := func(site, id, text string) bool {
+		wg.Add(1)
+		ok := svc.Submit(store.Comment{ID: id, Text: "<p>" + text + "</p>", Locator: store.Locator{SiteID: site}},
+			func(c store.Comment) {
+				lock.Lock()
+				done[c.ID] = c
+				lock.Unlock()
+				wg.Done()
+			})
+		if !ok {
+			wg.Done()
+		}
+		return ok
+	}
+
+	assert.False(t, submit("none", "c0", "you idiot"), "none policy")
+	assert.False(t, submit("unknown", "c0", "you idiot"), "no policy")
+	assert.True(t, submit("annotate", "c1", "you Idiot!"))
+	assert.True(t, submit("flag", "c2", "you idiot"))
+	assert.True(t, submit("flag", "c3", "nice post"))
+	assert.True(t, submit("hold", "c4", "idiot"))
+	assert.True(t, submit("hold", "c5", "error"))
+	wg.Wait()
+	svc.Close()
+	assert.False(t, submit("annotate", "c6", "closed"), "closed")
+
+	reqs := st.reqs()
+	require.Equal(t, 4, len(reqs), "errors not saved")
+	assert.Equal(t, service.ToxicityReq{Locator: store.Locator{SiteID: "annotate"}, CommentID: "c1", Score: 1}, reqs["c1"])
+	assert.Equal(t, service.ToxicityReq{Locator: store.Locator{SiteID: "flag"}, CommentID: "c2", Score: 1, Flag: true}, reqs["c2"])
+	assert.Equal(t, service.ToxicityReq{Locator: store.Locator{SiteID: "flag"}, CommentID: "c3", Score: 0}, reqs["c3"])
+	assert.Equal(t, service.ToxicityReq{Locator: store.Locator{SiteID: "hold"}, CommentID: "c4", Score: 1, Hold: true}, reqs["c4"])
+
+	require.Equal(t, 5, len(done), "done called for all queued comments")
+	assert.Equal(t, 1.0, done["c4"].Toxicity)
+	assert.True(t, done["c4"].Pending)
+	assert.Equal(t, 0.0, done["c5"].Toxicity, "original comment on errors")
+}
+
+func TestService_QueueFull(t *testing.T) {
+	scorer := &blockingScorer{release: make(chan struct{})}
+	svc := NewService(scorer, &mockStore{}, Params{QueueSize: 1})
+	assert.True(t, svc.Submit(store.Comment{ID: "c1"}, nil))
+	time.Sleep(10 * time.Millisecond) // picked by worker
+	assert.True(t, svc.Submit(store.Comment{ID: "c2"}, nil))
+	assert.False(t, svc.Submit(store.Comment{ID: "c3"}, nil), "queue is full")
+	close(scorer.release)
+	svc.Close()
+	svc.Close()
+}
+
+func TestService_Nil(t *testing.T) {
+	var svc *Service
+	policy, _ := svc.Policy("site")
+	assert.Equal(t, None, policy)
+	assert.False(t, svc.Submit(store.Comment{ID: "c1"}, nil))
+	svc.Close()
+}
+
+func TestStub(t *testing.T) {
+	s := &Stub{Words: []string{"Idiot", " stupid"}}
+	for text, expected := range map[string]float64{"you idiot": 1, "so STUPID.": 1, "idiotic": 0, "": 0} {
+		score, err := s.Score(context.Background(), text)
+		require.NoError(t, err)
+		assert.Equal(t, expected, score, text)
+	}
+	assert.Equal(t, "stub scorer", s.String())
+}
+
+type mockStore struct {
+	lock sync.Mutex
+	data map[string]service.ToxicityReq
+}
+
+func (m *mockStore) SetToxicity(req service.ToxicityReq) (store.Comment, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.data == nil {
+		m.data = map[string]service.ToxicityReq{}
+	}
+	m.data[req.CommentID] = req
+	return store.Comment{ID: req.CommentID, Locator: req.Locator, Toxicity: req.Score, Pending: req.Hold}, nil
+}
+
+func (m *mockStore) reqs() map[string]service.ToxicityReq {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.data
+}
+
+type blockingScorer struct {
+	release chan struct{}
+}
+
+func (b *blockingScorer) Score(context.Context, string) (float64, error) {
+	<-b.release
+	return 0, nil
+}
+
+func (b *blockingScorer) String() string { return "blocking" }
+
+type failingScorer struct {
+	Scorer
+}
+
+func (f *failingScorer) Score(ctx context.Context, text string) (float64, error) {
+	if text == "error" {
+		return 0, errors.New("failed")
+	}
+	return f.Scorer.Score(ctx, text)
+}
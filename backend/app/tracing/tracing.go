@@ -0,0 +1,111 @@
+// Package tracing sets up OpenTelemetry tracing with OTLP exporter and provides http middleware starting
+// span of each request. Other packages start child spans with the global tracer provider, which is a no-op
+// one if tracing is not set up, so instrumentation costs nothing when disabled.
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlphttp"
+	"go.opentelemetry.io/otel/propagation"
+	sdkresource "go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const serviceName = "remark42"
+
+// Params of OTLP exporter
+type Params struct {
+	Endpoint    string            // host:port of OTLP collector
+	Protocol    string            // grpc or http
+	Insecure    bool              // disables TLS
+	Headers     map[string]string // sent with each export, i.e. auth token of collector
+	SampleRatio float64           // ratio of sampled traces, not sampled parent keeps trace not sampled
+	Version     string            // version of remark42 reported in resource of spans
+}
+
+// Setup sets global tracer provider exporting spans to OTLP collector and W3C trace context propagation.
+// Returned function flushes spans and stops exporter, should be called on shutdown.
+func Setup(ctx context.Context, p Params) (shutdown func(ctx context.Context) error, err error) {
+	var driver otlp.ProtocolDriver
+	switch p.Protocol {
+	case "grpc", "":
+		opts := []otlpgrpc.Option{otlpgrpc.WithEndpoint(p.Endpoint), otlpgrpc.WithHeaders(p.Headers)}
+		if p.Insecure {
+			opts = append(opts, otlpgrpc.WithInsecure())
+		}
+		driver = otlpgrpc.NewDriver(opts...)
+	case "http":
+		opts := []otlphttp.Option{otlphttp.WithEndpoint(p.Endpoint), otlphttp.WithHeaders(p.Headers)}
+		if p.Insecure {
+			opts = append(opts, otlphttp.WithInsecure())
+		}
+		driver = otlphttp.NewDriver(opts...)
+	default:
+		return nil, errors.Errorf("unsupported otlp protocol %q", p.Protocol)
+	}
+
+	exporter, err := otlp.NewExporter(ctx, driver)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't make otlp exporter for %s", p.Endpoint)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(p.SampleRatio))),
+		sdktrace.WithResource(sdkresource.NewWithAttributes(semconv.ServiceNameKey.String(serviceName),
+			semconv.ServiceVersionKey.String(p.Version))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return provider.Shutdown, nil
+}
+
+const instrumentationName = "github.com/umputun/remark42/backend/app/tracing"
+
+// Middleware starts server span of the request, continuing trace of the caller if propagated in headers.
+// Span named by route pattern, not by url, to group requests of the same handler.
+func Middleware(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := otel.Tracer(instrumentationName).Start(ctx, r.Method+" "+r.URL.Path, trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest(serviceName, "", r)...))
+		defer span.End()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			span.SetName(r.Method + " " + rctx.RoutePattern())
+			span.SetAttributes(semconv.HTTPRouteKey.String(rctx.RoutePattern()))
+		}
+		code := ww.Status()
+		if code == 0 {
+			code = http.StatusOK
+		}
+		span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(code)...)
+		span.SetStatus(semconv.SpanStatusFromHTTPStatusCode(code))
+	}
+	return http.HandlerFunc(fn)
+}
+
+// Span runs fn in child span of the context, error returned by fn recorded in the span
+func Span(ctx context.Context, name string, fn func(ctx context.Context) error) error {
+	ctx, span := otel.Tracer(instrumentationName).Start(ctx, name)
+	defer span.End()
+	err := fn(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
@@ -0,0 +1,83 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestMiddleware(t *testing.T) {
+	exporter := setupExporter(t)
+
+	router := chi.NewRouter()
+	router.Use(Middleware)
+	router.Get("/api/v1/id/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_ = Span(r.Context(), "store.get", func(context.Context) error { return nil })
+		w.WriteHeader(http.StatusNotFound)
+	})
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	req, err := http.NewRequest("GET", ts.URL+"/api/v1/id/123", nil)
+	require.NoError(t, err)
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	spans := exporter.GetSpans()
+	require.Equal(t, 2, len(spans))
+	assert.Equal(t, "store.get", spans[0].Name)
+	assert.Equal(t, "GET /api/v1/id/{id}", spans[1].Name)
+	assert.Equal(t, spans[1].SpanContext.SpanID(), spans[0].Parent.SpanID(), "child of request span")
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", spans[1].SpanContext.TraceID().String(), "trace of caller")
+	assert.Contains(t, spans[1].Attributes, semconv.HTTPRouteKey.String("/api/v1/id/{id}"))
+	assert.Contains(t, spans[1].Attributes, semconv.HTTPStatusCodeKey.Int(http.StatusNotFound))
+}
+
+func TestSpan(t *testing.T) {
+	exporter := setupExporter(t)
+
+	err := Span(context.Background(), "email.send", func(context.Context) error { return errors.New("failed") })
+	assert.EqualError(t, err, "failed")
+	spans := exporter.GetSpans()
+	require.Equal(t, 1, len(spans))
+	assert.Equal(t, "email.send", spans[0].Name)
+	assert.Equal(t, codes.Error, spans[0].StatusCode)
+	assert.Equal(t, "failed", spans[0].StatusMessage)
+}
+
+func TestSetup(t *testing.T) {
+	_, err := Setup(context.Background(), Params{Protocol: "bad"})
+	assert.EqualError(t, err, `unsupported otlp protocol "bad"`)
+
+	shutdown, err := Setup(context.Background(), Params{Endpoint: "127.0.0.1:1", Protocol: "http", Insecure: true})
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, shutdown(ctx))
+}
+
+// setupExporter sets global provider recording spans in memory
+func setupExporter(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(trace.NewNoopTracerProvider()) })
+	return exporter
+}
@@ -0,0 +1,67 @@
+package translate
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DeepL translates with DeepL API, see https://www.deepl.com/docs-api
+type DeepL struct {
+	APIKey string
+	API    string // optional, https://api-free.deepl.com for free keys ending with ":fx", https://api.deepl.com otherwise
+	Client http.Client
+}
+
+// Translate html text to the language, source language detected by DeepL
+func (d *DeepL) Translate(ctx context.Context, html, lang string) (string, error) {
+	api := d.API
+	if api == "" {
+		api = "https://api.deepl.com"
+		if strings.HasSuffix(d.APIKey, ":fx") {
+			api = "https://api-free.deepl.com"
+		}
+	}
+	params := url.Values{"text": {html}, "target_lang": {strings.ToUpper(lang)}, "tag_handling": {"html"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(api, "/")+"/v2/translate",
+		strings.NewReader(params.Encode()))
+	if err != nil {
+		return "", errors.Wrap(err, "can't make deepl request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "DeepL-Auth-Key "+d.APIKey)
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "deepl request failed")
+	}
+	defer resp.Body.Close() // nolint
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", errors.Wrap(err, "can't read deepl response")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("deepl rejected translation with status %d, %s", resp.StatusCode, data)
+	}
+	res := struct {
+		Translations []struct {
+			Text string `json:"text"`
+		} `json:"translations"`
+	}{}
+	if err = json.Unmarshal(data, &res); err != nil {
+		return "", errors.Wrap(err, "can't unmarshal deepl response")
+	}
+	if len(res.Translations) == 0 {
+		return "", errors.New("no translations in deepl response")
+	}
+	return res.Translations[0].Text, nil
+}
+
+// String representation of DeepL
+func (d *DeepL) String() string {
+	return "deepl"
+}
@@ -0,0 +1,43 @@
+package translate
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeepL_Translate(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "/v2/translate", r.URL.Path)
+		assert.Equal(t, "DeepL-Auth-Key key123:fx", r.Header.Get("Authorization"))
+		assert.Equal(t, "PT-BR", r.PostForm.Get("target_lang"))
+		assert.Equal(t, "html", r.PostForm.Get("tag_handling"))
+		switch r.PostForm.Get("text") {
+		case "fail":
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"message":"forbidden"}`))
+		case "empty":
+			_, _ = w.Write([]byte(`{"translations":[]}`))
+		default:
+			assert.Equal(t, "<p>hello</p>", r.PostForm.Get("text"))
+			_, _ = w.Write([]byte(`{"translations":[{"detected_source_language":"EN","text":"<p>olá</p>"}]}`))
+		}
+	}))
+	defer ts.Close()
+
+	d := &DeepL{APIKey: "key123:fx", API: ts.URL}
+	assert.Equal(t, "deepl", d.String())
+	res, err := d.Translate(context.Background(), "<p>hello</p>", "pt-br")
+	require.NoError(t, err)
+	assert.Equal(t, "<p>olá</p>", res)
+
+	_, err = d.Translate(context.Background(), "fail", "pt-br")
+	assert.EqualError(t, err, `deepl rejected translation with status 403, {"message":"forbidden"}`)
+	_, err = d.Translate(context.Background(), "empty", "pt-br")
+	assert.EqualError(t, err, "no translations in deepl response")
+}
@@ -0,0 +1,62 @@
+package translate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// LibreTranslate translates with LibreTranslate API, see https://libretranslate.com/docs
+type LibreTranslate struct {
+	API    string // url of the server, i.e. https://libretranslate.com
+	APIKey string // optional, required by some servers
+	Client http.Client
+}
+
+// Translate html text to the language, source language detected by the server
+func (l *LibreTranslate) Translate(ctx context.Context, html, lang string) (string, error) {
+	body, err := json.Marshal(struct {
+		Q      string `json:"q"`
+		Source string `json:"source"`
+		Target string `json:"target"`
+		Format string `json:"format"`
+		APIKey string `json:"api_key,omitempty"`
+	}{Q: html, Source: "auto", Target: lang, Format: "html", APIKey: l.APIKey})
+	if err != nil {
+		return "", errors.Wrap(err, "can't marshal libretranslate request")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(l.API, "/")+"/translate", bytes.NewReader(body))
+	if err != nil {
+		return "", errors.Wrap(err, "can't make libretranslate request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := l.Client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "libretranslate request failed")
+	}
+	defer resp.Body.Close() // nolint
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", errors.Wrap(err, "can't read libretranslate response")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("libretranslate rejected translation with status %d, %s", resp.StatusCode, data)
+	}
+	res := struct {
+		TranslatedText string `json:"translatedText"`
+	}{}
+	if err = json.Unmarshal(data, &res); err != nil {
+		return "", errors.Wrap(err, "can't unmarshal libretranslate response")
+	}
+	return res.TranslatedText, nil
+}
+
+// String representation of LibreTranslate
+func (l *LibreTranslate) String() string {
+	return "libretranslate " + l.API
+}
@@ -0,0 +1,43 @@
+package translate
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLibreTranslate_Translate(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/translate", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		req := map[string]string{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		if req["q"] == "fail" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"bad request"}`))
+			return
+		}
+		assert.Equal(t, map[string]string{"q": "<p>hello</p>", "source": "auto", "target": "de", "format": "html",
+			"api_key": "key123"}, req)
+		_, _ = w.Write([]byte(`{"translatedText":"<p>hallo</p>"}`))
+	}))
+	defer ts.Close()
+
+	l := &LibreTranslate{API: ts.URL + "/", APIKey: "key123"}
+	assert.Equal(t, "libretranslate "+ts.URL+"/", l.String())
+	res, err := l.Translate(context.Background(), "<p>hello</p>", "de")
+	require.NoError(t, err)
+	assert.Equal(t, "<p>hallo</p>", res)
+
+	_, err = l.Translate(context.Background(), "fail", "de")
+	assert.EqualError(t, err, `libretranslate rejected translation with status 400, {"error":"bad request"}`)
+
+	l.API = "http://127.0.0.1:1"
+	_, err = l.Translate(context.Background(), "<p>hello</p>", "de")
+	assert.Error(t, err)
+}
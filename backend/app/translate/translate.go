@@ -0,0 +1,104 @@
+// Package translate translates comments on request of readers with external translation services,
+// like LibreTranslate or DeepL. Translations cached per comment, version of its text and language.
+package translate
+
+import (
+	"context"
+	"crypto/sha1" //nolint:gosec // used for cache keys only
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-pkgz/lcw"
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// Translator defines interface of translation service
+type Translator interface {
+	fmt.Stringer
+	Translate(ctx context.Context, html, lang string) (string, error) // translate html text to the language
+}
+
+// Params of Service
+type Params struct {
+	Timeout  time.Duration // timeout of a single call to translator, default 10s
+	CacheTTL time.Duration // time translations kept in cache, default 24h
+	MaxKeys  int           // max number of cached translations, default 10000
+}
+
+// Service wraps Translator with timeouts and cache of translations
+type Service struct {
+	Params
+	translator Translator
+	cache      lcw.LoadingCache
+}
+
+// ErrLanguage returned for invalid target language
+var ErrLanguage = errors.New("invalid language")
+
+// reLang matches language code, like "de" or "pt-br"
+var reLang = regexp.MustCompile(`^[a-z]{2,3}(-[a-z]{2,4})?$`)
+
+// NewService makes translation service for translator
+func NewService(translator Translator, params Params) *Service {
+	if params.Timeout <= 0 {
+		params.Timeout = 10 * time.Second
+	}
+	if params.CacheTTL <= 0 {
+		params.CacheTTL = 24 * time.Hour
+	}
+	if params.MaxKeys <= 0 {
+		params.MaxKeys = 10000
+	}
+	res := &Service{Params: params, translator: translator}
+	var err error
+	if res.cache, err = lcw.NewExpirableCache(lcw.TTL(params.CacheTTL), lcw.MaxKeys(params.MaxKeys)); err != nil {
+		log.Printf("[WARN] can't make translations cache, %v", err)
+		res.cache = lcw.NewNopCache()
+	}
+	log.Printf("[INFO] translator %s, cache ttl=%v", translator, params.CacheTTL)
+	return res
+}
+
+// Translate returns html text of the comment translated to the language, sanitized the same way as comments.
+// Failed translations not cached.
+func (s *Service) Translate(comment store.Comment, lang string) (string, error) {
+	lang = strings.ToLower(lang)
+	if !reLang.MatchString(lang) {
+		return "", ErrLanguage
+	}
+	if strings.TrimSpace(comment.Text) == "" {
+		return "", nil
+	}
+	// text hashed to key, so edited comment translated again
+	key := fmt.Sprintf("%s!!%s!!%s!!%x", comment.Locator.SiteID, comment.ID, lang, sha1.Sum([]byte(comment.Text))) //nolint:gosec
+	res, err := s.cache.Get(key, func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+		defer cancel()
+		text, err := s.translator.Translate(ctx, comment.Text, lang)
+		if err != nil {
+			return nil, err
+		}
+		c := store.Comment{Text: text}
+		c.Sanitize() // translator's response is not trusted
+		return c.Text, nil
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "can't translate comment %s to %s with %s", comment.ID, lang, s.translator)
+	}
+	return res.(string), nil
+}
+
+// Close stops cleanup of cached translations, safe to call on nil Service
+func (s *Service) Close() {
+	if s == nil {
+		return
+	}
+	if err := s.cache.Close(); err != nil {
+		log.Printf("[WARN] can't close translations cache, %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package translate
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestService_Translate(t *testing.T) {
+	tr := &mockTranslator{}
+	s := NewService(tr, Params{})
+	defer s.Close()
+	assert.Equal(t, 10*time.Second, s.Timeout)
+	c := store.Comment{ID: "c1", Locator: store.Locator{SiteID: "remark"}, Text: "<p>hello</p>"}
+
+	res, err := s.Translate(c, "DE")
+	require.NoError(t, err)
+	assert.Equal(t, "<p>[de] hello</p>", res)
+	res, err = s.Translate(c, "de")
+	require.NoError(t, err)
+	assert.Equal(t, "<p>[de] hello</p>", res)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&tr.calls), "cached")
+
+	res, err = s.Translate(c, "pt-br")
+	require.NoError(t, err)
+	assert.Equal(t, "<p>[pt-br] hello</p>", res)
+
+	c.Text = "<p>hello, edited</p>"
+	res, err = s.Translate(c, "de")
+	require.NoError(t, err)
+	assert.Equal(t, "<p>[de] hello, edited</p>", res, "edited comment translated again")
+	assert.Equal(t, int32(3), atomic.LoadInt32(&tr.calls))
+
+	c.Text = `<p>xss <script>alert(1)</script><a href="javascript:alert(1)">link</a></p>`
+	res, err = s.Translate(c, "de")
+	require.NoError(t, err)
+	assert.Equal(t, "<p>[de] xss link</p>", res, "translation sanitized")
+
+	for _, lang := range []string{"", "german", "d", "de_DE", "de-", "../x"} {
+		_, err = s.Translate(c, lang)
+		assert.Equal(t, ErrLanguage, err, lang)
+	}
+
+	res, err = s.Translate(store.Comment{ID: "c2", Text: " "}, "de")
+	require.NoError(t, err)
+	assert.Equal(t, "", res, "empty text not translated")
+
+	c.Text = "fail"
+	_, err = s.Translate(c, "de")
+	assert.EqualError(t, err, "can't translate comment c1 to de with mock: failed")
+	calls := atomic.LoadInt32(&tr.calls)
+	_, err = s.Translate(c, "de")
+	assert.Error(t, err)
+	assert.Equal(t, calls+1, atomic.LoadInt32(&tr.calls), "errors not cached")
+
+	var nilService *Service
+	nilService.Close()
+}
+
+func TestService_Timeout(t *testing.T) {
+	s := NewService(&mockTranslator{delay: time.Second}, Params{Timeout: 50 * time.Millisecond})
+	defer s.Close()
+	st := time.Now()
+	_, err := s.Translate(store.Comment{ID: "c1", Text: "hello"}, "de")
+	assert.Error(t, err)
+	assert.True(t, time.Since(st) < 500*time.Millisecond)
+}
+
+type mockTranslator struct {
+	calls int32
+	delay time.Duration
+}
+
+func (m *mockTranslator) Translate(ctx context.Context, html, lang string) (string, error) {
+	atomic.AddInt32(&m.calls, 1)
+	if html == "fail" {
+		return "", errors.New("failed")
+	}
+	select {
+	case <-time.After(m.delay):
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	return "<p>[" + lang + "] " + html[3:], nil
+}
+
+func (m *mockTranslator) String() string { return "mock" }
@@ -0,0 +1,59 @@
+package trust
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+const rulesKey = "rules" // single record of the site
+
+// EngineStore implements Store with records of store engine, rules and users kept along with comments of the site
+type EngineStore struct {
+	rules engine.Records
+	users engine.Records
+}
+
+// NewEngineStore makes store of trust rules and records of users kept by eng
+func NewEngineStore(eng engine.Interface) *EngineStore {
+	return &EngineStore{
+		rules: engine.Records{Engine: eng, Kind: engine.TrustRules},
+		users: engine.Records{Engine: eng, Kind: engine.TrustUsers},
+	}
+}
+
+// Rules of the site, nil for site without rules
+func (e *EngineStore) Rules(siteID string) (*Rules, error) {
+	res := Rules{}
+	found, err := e.rules.Get(siteID, rulesKey, &res)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// SetRules of the site, replacing previous ones
+func (e *EngineStore) SetRules(siteID string, rules Rules) error {
+	if siteID == "" {
+		return errors.New("site id required for trust rules")
+	}
+	return e.rules.Set(siteID, rulesKey, rules)
+}
+
+// User returns trust record of the user, nil for unknown user
+func (e *EngineStore) User(siteID, userID string) (*User, error) {
+	res := User{}
+	found, err := e.users.Get(siteID, userID, &res)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// SetUser saves trust record of the user
+func (e *EngineStore) SetUser(siteID, userID string, user User) error {
+	if siteID == "" || userID == "" {
+		return errors.New("site and user id required for trust")
+	}
+	return e.users.Set(siteID, userID, user)
+}
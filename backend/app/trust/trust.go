@@ -0,0 +1,219 @@
+// Package trust holds comments of new users for pre-moderation. On sites with threshold set, comments of a user
+// go to the pending queue till the threshold of them approved by admins, after that the user trusted automatically
+// and new comments published immediately. Admins can trust or distrust users manually, distrusted users are always
+// pre-moderated. Users without trust record get the count of their published comments, so regular commenters
+// are not held once pre-moderation enabled.
+package trust
+
+import (
+	"sync"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// Level of trust set by admin
+type Level string
+
+// enum of trust levels
+const (
+	LevelAuto      = Level("auto")      // trusted after threshold of approved comments
+	LevelTrusted   = Level("trusted")   // never pre-moderated
+	LevelUntrusted = Level("untrusted") // always pre-moderated
+)
+
+// Rules of a site, Threshold is the number of approved comments to trust the user, 0 disables pre-moderation
+type Rules struct {
+	Threshold int `json:"threshold"`
+}
+
+// User is trust record of a user
+type User struct {
+	Level    Level `json:"level"`
+	Approved int   `json:"approved"` // approved and published comments
+}
+
+// Status of user's trust on the site
+type Status struct {
+	UserID    string `json:"user_id"`
+	Level     Level  `json:"level"`
+	Approved  int    `json:"approved"`
+	Threshold int    `json:"threshold"`
+	Trusted   bool   `json:"trusted"` // comments published without pre-moderation
+}
+
+// Store defines interface to keep rules per site and trust records of users
+type Store interface {
+	Rules(siteID string) (*Rules, error) // returns nil for site without own rules
+	SetRules(siteID string, rules Rules) error
+	User(siteID, userID string) (*User, error) // returns nil for unknown user
+	SetUser(siteID, userID string, user User) error
+}
+
+// DataService defines subset of data store used to seed trust records of users
+type DataService interface {
+	User(siteID, userID string, limit, skip int, user store.User) ([]store.Comment, error)
+}
+
+// Service checks trust of users, default rules used by sites without own rules
+type Service struct {
+	store    Store
+	data     DataService
+	defaults Rules
+
+	lock sync.Mutex // serializes updates of users' records
+}
+
+// NewService makes service with store, data service and default rules
+func NewService(st Store, data DataService, defaults Rules) *Service {
+	return &Service{store: st, data: data, defaults: defaults}
+}
+
+// Rules returns effective rules of the site
+func (s *Service) Rules(siteID string) (Rules, error) {
+	rules, err := s.store.Rules(siteID)
+	if err != nil {
+		return Rules{}, errors.Wrapf(err, "can't get trust rules of %s", siteID)
+	}
+	if rules == nil {
+		return s.defaults, nil
+	}
+	return *rules, nil
+}
+
+// SetRules validates and saves rules of the site, applied to comments posted after the call
+func (s *Service) SetRules(siteID string, rules Rules) (Rules, error) {
+	if rules.Threshold < 0 {
+		return Rules{}, errors.Errorf("invalid threshold %d", rules.Threshold)
+	}
+	if err := s.store.SetRules(siteID, rules); err != nil {
+		return Rules{}, errors.Wrapf(err, "can't save trust rules of %s", siteID)
+	}
+	log.Printf("[INFO] trust rules of %s updated, threshold %d", siteID, rules.Threshold)
+	return rules, nil
+}
+
+// Hold checks if new comment of the user should be held for pre-moderation. Errors logged and comment not held.
+func (s *Service) Hold(siteID, userID string) bool {
+	st, err := s.Status(siteID, userID)
+	if err != nil {
+		log.Printf("[WARN] can't check trust of %s on %s, %v", userID, siteID, err)
+		return false
+	}
+	return !st.Trusted
+}
+
+// Status returns trust of the user on the site
+func (s *Service) Status(siteID, userID string) (Status, error) {
+	rules, err := s.Rules(siteID)
+	if err != nil {
+		return Status{}, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	u, err := s.user(siteID, userID, rules)
+	if err != nil {
+		return Status{}, err
+	}
+	return u.status(userID, rules), nil
+}
+
+// SetLevel sets trust level of the user manually, LevelAuto returns the user to threshold of approved comments
+func (s *Service) SetLevel(siteID, userID string, level Level) (Status, error) {
+	switch level {
+	case LevelAuto, LevelTrusted, LevelUntrusted:
+	default:
+		return Status{}, errors.Errorf("invalid trust level %q", level)
+	}
+	rules, err := s.Rules(siteID)
+	if err != nil {
+		return Status{}, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	u, err := s.user(siteID, userID, rules)
+	if err != nil {
+		return Status{}, err
+	}
+	u.Level = level
+	if err = s.store.SetUser(siteID, userID, u); err != nil {
+		return Status{}, errors.Wrapf(err, "can't save trust of %s on %s", userID, siteID)
+	}
+	log.Printf("[INFO] trust level of %s on %s set to %s", userID, siteID, level)
+	return u.status(userID, rules), nil
+}
+
+// Approved counts approved comment of the user, called on approval of pending comment.
+// Returns true if the user trusted automatically by this call, errors logged.
+func (s *Service) Approved(siteID, userID string) bool {
+	rules, err := s.Rules(siteID)
+	if err != nil {
+		log.Printf("[WARN] can't count approved comment of %s, %v", userID, err)
+		return false
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	u, err := s.user(siteID, userID, rules)
+	if err != nil {
+		log.Printf("[WARN] can't count approved comment of %s on %s, %v", userID, siteID, err)
+		return false
+	}
+	wasTrusted := u.status(userID, rules).Trusted
+	u.Approved++
+	if err = s.store.SetUser(siteID, userID, u); err != nil {
+		log.Printf("[WARN] can't save trust of %s on %s, %v", userID, siteID, err)
+		return false
+	}
+	if !wasTrusted && u.status(userID, rules).Trusted {
+		log.Printf("[INFO] user %s of %s trusted after %d approved comments", userID, siteID, u.Approved)
+		return true
+	}
+	return false
+}
+
+// user returns trust record of the user, new record seeded with published comments of the user.
+// Only recent comments counted, twice the threshold to skip pending and deleted ones. Called under the lock.
+func (s *Service) user(siteID, userID string, rules Rules) (User, error) {
+	u, err := s.store.User(siteID, userID)
+	if err != nil {
+		return User{}, errors.Wrapf(err, "can't get trust of %s on %s", userID, siteID)
+	}
+	if u != nil {
+		return *u, nil
+	}
+	res := User{Level: LevelAuto}
+	if s.data == nil || rules.Threshold == 0 {
+		return res, nil
+	}
+	comments, err := s.data.User(siteID, userID, rules.Threshold*2, 0, store.User{Admin: true})
+	if err != nil { // engine reports user without comments as error
+		log.Printf("[DEBUG] no comments of %s on %s, %v", userID, siteID, err)
+		comments = nil
+	}
+	for _, c := range comments {
+		if !c.Pending && !c.Deleted {
+			res.Approved++
+		}
+	}
+	if err = s.store.SetUser(siteID, userID, res); err != nil { // seeded once, approvals counted from now on
+		return User{}, errors.Wrapf(err, "can't save trust of %s on %s", userID, siteID)
+	}
+	return res, nil
+}
+
+// status of the user with the rules
+func (u User) status(userID string, rules Rules) Status {
+	res := Status{UserID: userID, Level: u.Level, Approved: u.Approved, Threshold: rules.Threshold}
+	switch u.Level {
+	case LevelTrusted:
+		res.Trusted = true
+	case LevelUntrusted:
+		res.Trusted = false
+	default:
+		res.Level = LevelAuto
+		res.Trusted = rules.Threshold == 0 || u.Approved >= rules.Threshold
+	}
+	return res
+}
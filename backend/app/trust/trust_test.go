@@ -0,0 +1,166 @@
+package trust
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+func TestService_Hold(t *testing.T) {
+	st := newMemStore()
+	s := NewService(st, &mockData{}, Rules{Threshold: 2})
+
+	rules, err := s.Rules("site1")
+	require.NoError(t, err)
+	assert.Equal(t, Rules{Threshold: 2}, rules, "defaults")
+
+	assert.True(t, s.Hold("site1", "u1"), "new user held")
+	assert.False(t, s.Approved("site1", "u1"))
+	assert.True(t, s.Hold("site1", "u1"), "one comment approved")
+	assert.True(t, s.Approved("site1", "u1"), "trusted by the second approval")
+	assert.False(t, s.Hold("site1", "u1"))
+	assert.False(t, s.Approved("site1", "u1"), "already trusted")
+
+	status, err := s.Status("site1", "u1")
+	require.NoError(t, err)
+	assert.Equal(t, Status{UserID: "u1", Level: LevelAuto, Approved: 3, Threshold: 2, Trusted: true}, status)
+
+	_, err = s.SetRules("site2", Rules{Threshold: 0})
+	require.NoError(t, err)
+	assert.False(t, s.Hold("site2", "u2"), "pre-moderation disabled")
+	_, err = s.SetRules("site2", Rules{Threshold: -1})
+	assert.EqualError(t, err, "invalid threshold -1")
+}
+
+func TestService_SetLevel(t *testing.T) {
+	s := NewService(newMemStore(), nil, Rules{Threshold: 1})
+
+	status, err := s.SetLevel("site1", "u1", LevelTrusted)
+	require.NoError(t, err)
+	assert.Equal(t, Status{UserID: "u1", Level: LevelTrusted, Threshold: 1, Trusted: true}, status)
+	assert.False(t, s.Hold("site1", "u1"), "trusted manually")
+
+	_, err = s.SetLevel("site1", "u1", LevelUntrusted)
+	require.NoError(t, err)
+	assert.False(t, s.Approved("site1", "u1"), "approvals don't trust distrusted user")
+	assert.True(t, s.Hold("site1", "u1"))
+	_, err = s.SetRules("site1", Rules{Threshold: 0})
+	require.NoError(t, err)
+	assert.True(t, s.Hold("site1", "u1"), "distrusted user held with pre-moderation disabled")
+
+	status, err = s.SetLevel("site1", "u1", LevelAuto)
+	require.NoError(t, err)
+	assert.Equal(t, Status{UserID: "u1", Level: LevelAuto, Approved: 1, Threshold: 0, Trusted: true}, status)
+
+	_, err = s.SetLevel("site1", "u1", "blah")
+	assert.EqualError(t, err, `invalid trust level "blah"`)
+}
+
+func TestService_Seed(t *testing.T) {
+	data := &mockData{comments: []store.Comment{{ID: "1"}, {ID: "2", Pending: true}, {ID: "3", Deleted: true}, {ID: "4"}}}
+	st := newMemStore()
+	s := NewService(st, data, Rules{Threshold: 2})
+
+	status, err := s.Status("site1", "u1")
+	require.NoError(t, err)
+	assert.Equal(t, Status{UserID: "u1", Level: LevelAuto, Approved: 2, Threshold: 2, Trusted: true}, status,
+		"published comments counted")
+	assert.Equal(t, 4, data.limit)
+	assert.Equal(t, &User{Level: LevelAuto, Approved: 2}, st.users["site1/u1"])
+
+	data.comments = nil
+	assert.True(t, s.Hold("site1", "u2"))
+	assert.Equal(t, &User{Level: LevelAuto}, st.users["site1/u2"], "seeded once")
+	data.comments = []store.Comment{{ID: "5"}}
+	s.Approved("site1", "u2")
+	assert.Equal(t, &User{Level: LevelAuto, Approved: 1}, st.users["site1/u2"], "approved comment not counted twice")
+
+	data.err = errors.New("no comments for user u3 in store")
+	assert.True(t, s.Hold("site1", "u3"), "user without comments")
+	assert.Equal(t, &User{Level: LevelAuto}, st.users["site1/u3"])
+
+	st.err = errors.New("store failed")
+	_, err = s.Status("site1", "u1")
+	assert.EqualError(t, err, "can't get trust rules of site1: store failed")
+	assert.False(t, s.Approved("site1", "u1"))
+	_, err = s.SetRules("site1", Rules{})
+	assert.EqualError(t, err, "can't save trust rules of site1: store failed")
+}
+
+func TestEngineStore(t *testing.T) {
+	eng, err := engine.NewMemory("", "site1", "site2")
+	require.NoError(t, err)
+	st := NewEngineStore(eng)
+
+	rules, err := st.Rules("site1")
+	require.NoError(t, err)
+	assert.Nil(t, rules)
+	u, err := st.User("site1", "u1")
+	require.NoError(t, err)
+	assert.Nil(t, u)
+
+	require.NoError(t, st.SetRules("site1", Rules{Threshold: 3}))
+	require.NoError(t, st.SetUser("site1", "u1", User{Level: LevelTrusted, Approved: 2}))
+	assert.Error(t, st.SetRules("", Rules{}))
+	assert.Error(t, st.SetUser("site1", "", User{}))
+
+	rules, err = st.Rules("site1")
+	require.NoError(t, err)
+	assert.Equal(t, &Rules{Threshold: 3}, rules)
+	u, err = st.User("site1", "u1")
+	require.NoError(t, err)
+	assert.Equal(t, &User{Level: LevelTrusted, Approved: 2}, u)
+	u, err = st.User("site2", "u1")
+	require.NoError(t, err)
+	assert.Nil(t, u, "other site")
+	_, err = st.User("site3", "u1")
+	assert.EqualError(t, err, `site "site3" not found`)
+}
+
+type memStore struct {
+	rules map[string]*Rules
+	users map[string]*User
+	err   error
+}
+
+func newMemStore() *memStore {
+	return &memStore{rules: map[string]*Rules{}, users: map[string]*User{}}
+}
+
+func (m *memStore) Rules(siteID string) (*Rules, error) { return m.rules[siteID], m.err }
+
+func (m *memStore) SetRules(siteID string, rules Rules) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.rules[siteID] = &rules
+	return nil
+}
+
+func (m *memStore) User(siteID, userID string) (*User, error) {
+	return m.users[siteID+"/"+userID], m.err
+}
+
+func (m *memStore) SetUser(siteID, userID string, user User) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.users[siteID+"/"+userID] = &user
+	return nil
+}
+
+type mockData struct {
+	comments []store.Comment
+	limit    int
+	err      error
+}
+
+func (m *mockData) User(_, _ string, limit, _ int, _ store.User) ([]store.Comment, error) {
+	m.limit = limit
+	return m.comments, m.err
+}
@@ -0,0 +1,39 @@
+package verified
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+const rulesKey = "rules" // single record of the site
+
+// EngineStore implements Store with records of store engine, rules kept along with comments of the site
+type EngineStore struct {
+	records engine.Records
+}
+
+// NewEngineStore makes store of verification rules kept by eng
+func NewEngineStore(eng engine.Interface) *EngineStore {
+	return &EngineStore{records: engine.Records{Engine: eng, Kind: engine.VerifiedRules}}
+}
+
+// Get rules of the site, empty rules returned for site without rules
+func (e *EngineStore) Get(siteID string) (Rules, error) {
+	res := Rules{}
+	if _, err := e.records.Get(siteID, rulesKey, &res); err != nil {
+		return Rules{Domains: []string{}}, err
+	}
+	if res.Domains == nil {
+		res.Domains = []string{}
+	}
+	return res, nil
+}
+
+// Set rules of the site, replacing previous ones
+func (e *EngineStore) Set(siteID string, rules Rules) error {
+	if siteID == "" {
+		return errors.New("site id required for verification rules")
+	}
+	return e.records.Set(siteID, rulesKey, rules)
+}
@@ -0,0 +1,189 @@
+// Package verified grants "verified" flag to users matched by admin-managed per-site rules, i.e. users with email
+// of the company domain reported by auth provider, or users confirmed their email with verification round-trip.
+// Rules checked on each login and email confirmation, existing users re-evaluated on demand. The flag is only granted,
+// never revoked by rules, so it can be reset by admin manually.
+package verified
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// Rules of a site to grant verified flag
+type Rules struct {
+	Domains        []string `json:"domains"`         // email domains, subdomains matched too
+	ConfirmedEmail bool     `json:"confirmed_email"` // users with email confirmed by verification round-trip
+}
+
+// Store defines interface to keep rules per site
+type Store interface {
+	Get(siteID string) (Rules, error) // returns empty rules for unknown site
+	Set(siteID string, rules Rules) error
+}
+
+// DataService defines subset of data store used to check and grant verified flag
+type DataService interface {
+	IsVerified(siteID, userID string) bool
+	SetVerified(siteID, userID string, status bool) error
+	UserEmails(siteID string) ([]engine.UserDetailEntry, error)
+}
+
+// Service evaluates users against rules loaded from store, rules cached per site and replaced on update
+type Service struct {
+	Flush func(siteID, userID string) // optional, called after flag granted to purge cached comments of the user
+
+	store Store
+	data  DataService
+
+	lock  sync.RWMutex
+	rules map[string]Rules
+}
+
+var domainRe = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)+$`)
+
+// NewService makes service for rules from the store
+func NewService(st Store, data DataService) *Service {
+	return &Service{store: st, data: data, rules: map[string]Rules{}}
+}
+
+// Rules returns rules of the site
+func (s *Service) Rules(siteID string) (Rules, error) {
+	return s.store.Get(siteID)
+}
+
+// SetRules validates and saves rules of the site, new rules used for users evaluated after the call.
+// Returns normalized rules, i.e. with lower-cased and sorted domains without duplicates.
+func (s *Service) SetRules(siteID string, rules Rules) (Rules, error) {
+	domains := make([]string, 0, len(rules.Domains))
+	seen := map[string]bool{}
+	for _, d := range rules.Domains {
+		d = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(d)), "@")
+		if !domainRe.MatchString(d) {
+			return Rules{}, errors.Errorf("invalid domain %q", d)
+		}
+		if !seen[d] {
+			domains = append(domains, d)
+			seen[d] = true
+		}
+	}
+	sort.Strings(domains)
+	rules.Domains = domains
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.store.Set(siteID, rules); err != nil {
+		return Rules{}, errors.Wrapf(err, "can't save verification rules of %s", siteID)
+	}
+	s.rules[siteID] = rules
+	log.Printf("[INFO] verification rules of %s updated, domains %v, confirmed email %v", siteID, rules.Domains, rules.ConfirmedEmail)
+	return rules, nil
+}
+
+// Evaluate grants verified flag to the user matched by rules of the site. authEmail is the email reported
+// by auth provider, confirmedEmail is the one confirmed by user with verification round-trip, both optional.
+// Returns true if the flag granted by this call, errors logged.
+func (s *Service) Evaluate(siteID, userID, authEmail, confirmedEmail string) bool {
+	rules, err := s.siteRules(siteID)
+	if err != nil {
+		log.Printf("[WARN] can't get verification rules of %s, %v", siteID, err)
+		return false
+	}
+	reason := rules.match(authEmail, confirmedEmail)
+	if reason == "" || s.data.IsVerified(siteID, userID) {
+		return false
+	}
+	if err := s.data.SetVerified(siteID, userID, true); err != nil {
+		log.Printf("[WARN] can't set verified flag of %s on %s, %v", userID, siteID, err)
+		return false
+	}
+	log.Printf("[INFO] user %s of %s verified, %s", userID, siteID, reason)
+	if s.Flush != nil {
+		s.Flush(siteID, userID)
+	}
+	return true
+}
+
+// Reevaluate checks existing users of the site with confirmed emails against rules, returns ids of users
+// granted verified flag
+func (s *Service) Reevaluate(siteID string) ([]string, error) {
+	rules, err := s.siteRules(siteID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't get verification rules of %s", siteID)
+	}
+	res := []string{}
+	if len(rules.Domains) == 0 && !rules.ConfirmedEmail {
+		return res, nil
+	}
+	details, err := s.data.UserEmails(siteID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't list emails of %s", siteID)
+	}
+	for _, d := range details {
+		if s.Evaluate(siteID, d.UserID, "", d.Email) {
+			res = append(res, d.UserID)
+		}
+	}
+	log.Printf("[INFO] verification rules of %s re-evaluated for %d users, %d verified", siteID, len(details), len(res))
+	return res, nil
+}
+
+// FlushRules drops cached rules of the site, for rules changed bypassing the service, i.e. by replication
+func (s *Service) FlushRules(siteID string) {
+	s.lock.Lock()
+	delete(s.rules, siteID)
+	s.lock.Unlock()
+}
+
+// siteRules returns rules of the site, loaded from store on the first call
+func (s *Service) siteRules(siteID string) (Rules, error) {
+	s.lock.RLock()
+	r, ok := s.rules[siteID]
+	s.lock.RUnlock()
+	if ok {
+		return r, nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	r, err := s.store.Get(siteID)
+	if err != nil {
+		return Rules{}, err
+	}
+	s.rules[siteID] = r
+	return r, nil
+}
+
+// match returns human-readable reason if any of emails matched by rules, empty string otherwise
+func (r Rules) match(authEmail, confirmedEmail string) string {
+	for _, email := range []string{authEmail, confirmedEmail} {
+		if d := r.matchDomain(email); d != "" {
+			return "email domain " + d
+		}
+	}
+	if r.ConfirmedEmail && confirmedEmail != "" {
+		return "confirmed email"
+	}
+	return ""
+}
+
+// matchDomain returns domain rule matched by email, empty string if none
+func (r Rules) matchDomain(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return ""
+	}
+	host := strings.ToLower(strings.TrimSpace(email[at+1:]))
+	for _, d := range r.Domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return d
+		}
+	}
+	return ""
+}
@@ -0,0 +1,156 @@
+package verified
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+func TestService_Evaluate(t *testing.T) {
+	data := &mockData{verified: map[string]bool{}}
+	s := NewService(&memStore{rules: map[string]Rules{}}, data)
+	flushed := []string{}
+	s.Flush = func(siteID, userID string) { flushed = append(flushed, siteID+"/"+userID) }
+
+	assert.False(t, s.Evaluate("site1", "u1", "u1@example.com", "u1@example.com"), "no rules")
+
+	rules, err := s.SetRules("site1", Rules{Domains: []string{" Example.com", "@corp.example.org", "example.com"}})
+	require.NoError(t, err)
+	assert.Equal(t, Rules{Domains: []string{"corp.example.org", "example.com"}}, rules, "normalized")
+
+	assert.True(t, s.Evaluate("site1", "u1", "u1@Example.com", ""), "auth email matched")
+	assert.True(t, data.verified["site1/u1"])
+	assert.False(t, s.Evaluate("site1", "u1", "u1@example.com", ""), "already verified")
+	assert.True(t, s.Evaluate("site1", "u2", "", "u2@dev.corp.example.org"), "subdomain of confirmed email matched")
+	assert.False(t, s.Evaluate("site1", "u3", "u3@badexample.com", "u3@example.com.evil.org"))
+	assert.False(t, s.Evaluate("site1", "u3", "", "u3@gmail.com"), "confirmed email not enough")
+	assert.False(t, s.Evaluate("site2", "u4", "u4@example.com", ""), "other site")
+	assert.Equal(t, []string{"site1/u1", "site1/u2"}, flushed)
+
+	_, err = s.SetRules("site1", Rules{ConfirmedEmail: true})
+	require.NoError(t, err)
+	assert.True(t, s.Evaluate("site1", "u3", "", "u3@gmail.com"), "confirmed email")
+	assert.False(t, s.Evaluate("site1", "u5", "u5@gmail.com", ""), "auth email is not confirmed")
+
+	data.err = errors.New("failed")
+	assert.False(t, s.Evaluate("site1", "u6", "", "u6@gmail.com"), "set error")
+	assert.False(t, data.verified["site1/u6"])
+
+	for _, d := range []string{"", "example", "exa mple.com", "-example.com", "*.example.com"} {
+		_, err = s.SetRules("site1", Rules{Domains: []string{d}})
+		assert.Error(t, err, d)
+	}
+}
+
+func TestService_Reevaluate(t *testing.T) {
+	data := &mockData{verified: map[string]bool{"site1/u1": true}, emails: []engine.UserDetailEntry{
+		{UserID: "u1", Email: "u1@example.com"}, {UserID: "u2", Email: "u2@example.com"}, {UserID: "u3", Email: "u3@gmail.com"}}}
+	st := &memStore{rules: map[string]Rules{}}
+	s := NewService(st, data)
+
+	granted, err := s.Reevaluate("site1")
+	require.NoError(t, err)
+	assert.Empty(t, granted, "no rules")
+
+	st.rules["site1"] = Rules{Domains: []string{"example.com"}}
+	granted, err = s.Reevaluate("site2")
+	require.NoError(t, err)
+	assert.Empty(t, granted)
+
+	s = NewService(st, data)
+	granted, err = s.Reevaluate("site1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"u2"}, granted, "rules loaded from store, verified user skipped")
+
+	_, err = s.SetRules("site1", Rules{ConfirmedEmail: true})
+	require.NoError(t, err)
+	granted, err = s.Reevaluate("site1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"u3"}, granted)
+
+	data.listErr = errors.New("list failed")
+	_, err = s.Reevaluate("site1")
+	assert.EqualError(t, err, "can't list emails of site1: list failed")
+
+	st.err = errors.New("store failed")
+	_, err = NewService(st, data).Reevaluate("site1")
+	assert.EqualError(t, err, "can't get verification rules of site1: store failed")
+	_, err = s.SetRules("site1", Rules{})
+	assert.EqualError(t, err, "can't save verification rules of site1: store failed")
+	assert.False(t, NewService(st, data).Evaluate("site1", "u4", "u4@example.com", ""), "store error logged")
+}
+
+func TestService_FlushRules(t *testing.T) {
+	st := prepStore(t)
+	s := NewService(st, &mockData{verified: map[string]bool{}})
+	assert.False(t, s.Evaluate("site1", "u1", "u1@example.com", ""), "no rules")
+
+	require.NoError(t, st.Set("site1", Rules{Domains: []string{"example.com"}}), "changed bypassing the service")
+	assert.False(t, s.Evaluate("site1", "u1", "u1@example.com", ""), "rules cached")
+	s.FlushRules("site1")
+	assert.True(t, s.Evaluate("site1", "u1", "u1@example.com", ""))
+}
+
+func TestEngineStore(t *testing.T) {
+	st := prepStore(t)
+
+	rules, err := st.Get("site1")
+	require.NoError(t, err)
+	assert.Equal(t, Rules{Domains: []string{}}, rules)
+
+	require.NoError(t, st.Set("site1", Rules{Domains: []string{"example.com"}, ConfirmedEmail: true}))
+	require.NoError(t, st.Set("site2", Rules{ConfirmedEmail: true}))
+	assert.Error(t, st.Set("", Rules{}))
+	assert.EqualError(t, st.Set("site3", Rules{}), `site "site3" not found`)
+
+	rules, err = st.Get("site1")
+	require.NoError(t, err)
+	assert.Equal(t, Rules{Domains: []string{"example.com"}, ConfirmedEmail: true}, rules)
+	rules, err = st.Get("site2")
+	require.NoError(t, err)
+	assert.Equal(t, Rules{Domains: []string{}, ConfirmedEmail: true}, rules)
+}
+
+func prepStore(t *testing.T) *EngineStore {
+	eng, err := engine.NewMemory("", "site1", "site2")
+	require.NoError(t, err)
+	return NewEngineStore(eng)
+}
+
+type memStore struct {
+	rules map[string]Rules
+	err   error
+}
+
+func (m *memStore) Get(siteID string) (Rules, error) { return m.rules[siteID], m.err }
+
+func (m *memStore) Set(siteID string, rules Rules) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.rules[siteID] = rules
+	return nil
+}
+
+type mockData struct {
+	verified map[string]bool
+	emails   []engine.UserDetailEntry
+	err      error
+	listErr  error
+}
+
+func (m *mockData) IsVerified(siteID, userID string) bool { return m.verified[siteID+"/"+userID] }
+
+func (m *mockData) SetVerified(siteID, userID string, status bool) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.verified[siteID+"/"+userID] = status
+	return nil
+}
+
+func (m *mockData) UserEmails(string) ([]engine.UserDetailEntry, error) { return m.emails, m.listErr }
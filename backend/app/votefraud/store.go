@@ -0,0 +1,95 @@
+package votefraud
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+// keyTimeFormat is sortable fixed-width format of time in keys
+const keyTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+// EngineStore implements Store with records of store engine, votes kept along with comments of their site.
+// Detector analyses votes of all sites, so votes listed and cleaned up in all sites.
+type EngineStore struct {
+	records engine.Records // keyed by time!!commentID!!userID
+	sites   func() []string
+}
+
+// NewEngineStore makes store of recorded votes kept by eng for sites
+func NewEngineStore(eng engine.Interface, sites func() []string) *EngineStore {
+	return &EngineStore{records: engine.Records{Engine: eng, Kind: engine.Votes}, sites: sites}
+}
+
+// Add vote to the store
+func (e *EngineStore) Add(v Vote) error {
+	return errors.Wrapf(e.records.Set(v.SiteID, voteKey(v), v), "can't put vote of %s", v.UserID)
+}
+
+// List votes of all sites recorded since the time
+func (e *EngineStore) List(since time.Time) ([]Vote, error) {
+	res := []Vote{}
+	err := e.each(func(key string, v Vote) error {
+		if key >= since.UTC().Format(keyTimeFormat) {
+			res = append(res, v)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "can't list votes")
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Timestamp.Before(res[j].Timestamp) })
+	return res, nil
+}
+
+// Delete votes from the store, missing votes ignored
+func (e *EngineStore) Delete(votes []Vote) error {
+	for _, v := range votes {
+		if _, err := e.records.Delete(v.SiteID, voteKey(v)); err != nil {
+			return errors.Wrapf(err, "can't delete vote of %s", v.UserID)
+		}
+	}
+	return nil
+}
+
+// Cleanup removes votes of all sites recorded before the time, returns number of removed votes
+func (e *EngineStore) Cleanup(before time.Time) (count int, err error) {
+	expired := []Vote{}
+	err = e.each(func(key string, v Vote) error {
+		if key < before.UTC().Format(keyTimeFormat) {
+			expired = append(expired, v)
+		}
+		return nil
+	})
+	if err == nil {
+		err = e.Delete(expired)
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "can't cleanup votes")
+	}
+	return len(expired), nil
+}
+
+// each calls fn for every vote of all sites
+func (e *EngineStore) each(fn func(key string, v Vote) error) error {
+	for _, siteID := range e.sites() {
+		err := e.records.List(siteID, "", func(key string, unmarshal func(v interface{}) error) error {
+			v := Vote{}
+			if err := unmarshal(&v); err != nil {
+				return errors.Wrapf(err, "can't unmarshal vote %s", key)
+			}
+			return fn(key, v)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func voteKey(v Vote) string {
+	return v.Timestamp.UTC().Format(keyTimeFormat) + "!!" + v.CommentID + "!!" + v.UserID
+}
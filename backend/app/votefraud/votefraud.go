@@ -0,0 +1,342 @@
+// Package votefraud records votes and analyses them in background for suspicious patterns: many voters from
+// one IP or subnet, reciprocal voting rings and bursts of votes for a single comment. Findings of the last
+// analysis kept in memory and resolved by admin, either by voiding flagged votes or by dismissing the finding.
+package votefraud
+
+import (
+	"context"
+	"crypto/sha1" //nolint:gosec // used for finding id only
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// Vote is a single recorded vote
+type Vote struct {
+	SiteID    string    `json:"site"`
+	URL       string    `json:"url"`
+	CommentID string    `json:"comment_id"`
+	AuthorID  string    `json:"author_id"` // author of voted comment
+	UserID    string    `json:"user_id"`   // voter
+	IP        string    `json:"ip"`        // hashed ip of the voter
+	Subnet    string    `json:"subnet"`    // hashed /24 subnet for ipv4 and /48 for ipv6
+	Value     bool      `json:"value"`
+	Timestamp time.Time `json:"time"`
+}
+
+// Kind of suspicious pattern
+type Kind string
+
+// enum of all kinds
+const (
+	KindIP     Kind = "ip"     // many voters from one ip
+	KindSubnet Kind = "subnet" // many voters from one subnet
+	KindRing   Kind = "ring"   // two users upvoting each other
+	KindBurst  Kind = "burst"  // many votes for one comment in short period
+)
+
+// Finding describes suspicious votes
+type Finding struct {
+	ID       string    `json:"id"`
+	Kind     Kind      `json:"kind"`
+	Key      string    `json:"key"`   // ip or subnet hash, comment id for burst, pair of user ids for ring
+	Users    []string  `json:"users"` // voters
+	Votes    []Vote    `json:"votes"`
+	Detected time.Time `json:"detected"`
+}
+
+// Store defines interface to keep recorded votes
+type Store interface {
+	Add(v Vote) error
+	List(since time.Time) ([]Vote, error) // votes of all sites, ordered by time
+	Delete(votes []Vote) error
+	Cleanup(before time.Time) (int, error) // removes votes recorded before the time
+}
+
+// Params of detector, zero values replaced by defaults
+type Params struct {
+	Secret       string        // secret used to hash ip addresses
+	Window       time.Duration // period of analysed votes, 24h by default
+	Interval     time.Duration // interval between analysis runs, 10m by default
+	IPVoters     int           // number of different voters from one ip, 3 by default
+	SubnetVoters int           // number of different voters from one subnet, 10 by default
+	RingVotes    int           // number of upvotes each of two users gave to the other one, 3 by default
+	BurstVotes   int           // number of votes for one comment within BurstPeriod, 10 by default
+	BurstPeriod  time.Duration // 1m by default
+}
+
+// Detector records votes and finds suspicious patterns periodically
+type Detector struct {
+	Params
+	store Store
+
+	lock     sync.RWMutex
+	findings map[string][]Finding // by site id
+}
+
+// NewDetector makes detector for votes recorded in the store
+func NewDetector(st Store, params Params) *Detector {
+	res := Detector{Params: params, store: st, findings: map[string][]Finding{}}
+	if res.Window <= 0 {
+		res.Window = 24 * time.Hour
+	}
+	if res.Interval <= 0 {
+		res.Interval = 10 * time.Minute
+	}
+	if res.IPVoters <= 0 {
+		res.IPVoters = 3
+	}
+	if res.SubnetVoters <= 0 {
+		res.SubnetVoters = 10
+	}
+	if res.RingVotes <= 0 {
+		res.RingVotes = 3
+	}
+	if res.BurstVotes <= 0 {
+		res.BurstVotes = 10
+	}
+	if res.BurstPeriod <= 0 {
+		res.BurstPeriod = time.Minute
+	}
+	return &res
+}
+
+// Record vote of the user for the comment made from the ip. Does nothing for nil detector.
+func (d *Detector) Record(comment store.Comment, userID, ip string, val bool) {
+	if d == nil {
+		return
+	}
+	v := Vote{SiteID: comment.Locator.SiteID, URL: comment.Locator.URL, CommentID: comment.ID,
+		AuthorID: comment.User.ID, UserID: userID, Value: val, Timestamp: time.Now()}
+	if ip != "" {
+		v.IP = store.HashValue(ip, d.Secret)
+		v.Subnet = store.HashValue(subnet(ip), d.Secret)
+	}
+	if err := d.store.Add(v); err != nil {
+		log.Printf("[WARN] can't record vote of %s for %s, %v", userID, comment.ID, err)
+	}
+}
+
+// Run analysis periodically till context canceled, votes older than analysed window removed
+func (d *Detector) Run(ctx context.Context) {
+	log.Printf("[INFO] vote fraud detector activated, every %v for last %v", d.Interval, d.Window)
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+	for {
+		if err := d.Analyze(); err != nil {
+			log.Printf("[WARN] vote fraud analysis failed, %v", err)
+		}
+		if n, err := d.store.Cleanup(time.Now().Add(-d.Window)); err != nil {
+			log.Printf("[WARN] can't cleanup recorded votes, %v", err)
+		} else if n > 0 {
+			log.Printf("[DEBUG] removed %d recorded votes", n)
+		}
+		select {
+		case <-ctx.Done():
+			log.Print("[INFO] vote fraud detector terminated")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Analyze votes recorded within window and replace findings of all sites
+func (d *Detector) Analyze() error {
+	votes, err := d.store.List(time.Now().Add(-d.Window))
+	if err != nil {
+		return errors.Wrap(err, "can't list recorded votes")
+	}
+	bySite := map[string][]Vote{}
+	for _, v := range votes {
+		bySite[v.SiteID] = append(bySite[v.SiteID], v)
+	}
+
+	findings := map[string][]Finding{}
+	for siteID, siteVotes := range bySite {
+		res := d.sharedAddress(KindIP, siteVotes, func(v Vote) string { return v.IP }, d.IPVoters)
+		res = append(res, d.sharedAddress(KindSubnet, siteVotes, func(v Vote) string { return v.Subnet }, d.SubnetVoters)...)
+		res = append(res, d.rings(siteVotes)...)
+		res = append(res, d.bursts(siteVotes)...)
+		if len(res) > 0 {
+			log.Printf("[INFO] %d suspicious voting patterns found for %s", len(res), siteID)
+			findings[siteID] = res
+		}
+	}
+
+	d.lock.Lock()
+	d.findings = findings
+	d.lock.Unlock()
+	return nil
+}
+
+// Findings of the last analysis for the site
+func (d *Detector) Findings(siteID string) []Finding {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	res := make([]Finding, len(d.findings[siteID]))
+	copy(res, d.findings[siteID])
+	return res
+}
+
+// Finding returns finding of the site by id
+func (d *Detector) Finding(siteID, id string) (Finding, bool) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	for _, f := range d.findings[siteID] {
+		if f.ID == id {
+			return f, true
+		}
+	}
+	return Finding{}, false
+}
+
+// Resolve removes finding along with its recorded votes, so the same votes not flagged again
+func (d *Detector) Resolve(siteID, id string) error {
+	f, ok := d.Finding(siteID, id)
+	if !ok {
+		return errors.Errorf("no finding %s for %s", id, siteID)
+	}
+	if err := d.store.Delete(f.Votes); err != nil {
+		return errors.Wrapf(err, "can't delete votes of finding %s", id)
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	res := []Finding{}
+	for _, other := range d.findings[siteID] {
+		if other.ID != id {
+			res = append(res, other)
+		}
+	}
+	d.findings[siteID] = res
+	return nil
+}
+
+// sharedAddress finds addresses used by at least minVoters different voters
+func (d *Detector) sharedAddress(kind Kind, votes []Vote, addr func(Vote) string, minVoters int) []Finding {
+	byAddr := map[string][]Vote{}
+	for _, v := range votes {
+		if a := addr(v); a != "" {
+			byAddr[a] = append(byAddr[a], v)
+		}
+	}
+	res := []Finding{}
+	for a, vv := range byAddr {
+		if len(voters(vv)) >= minVoters {
+			res = append(res, newFinding(kind, a, vv))
+		}
+	}
+	sortFindings(res)
+	return res
+}
+
+// rings finds pairs of users upvoting each other at least RingVotes times
+func (d *Detector) rings(votes []Vote) []Finding {
+	byPair := map[[2]string][]Vote{} // voter, author
+	for _, v := range votes {
+		if v.Value && v.AuthorID != "" && v.UserID != v.AuthorID {
+			pair := [2]string{v.UserID, v.AuthorID}
+			byPair[pair] = append(byPair[pair], v)
+		}
+	}
+	res := []Finding{}
+	for pair, vv := range byPair {
+		if pair[0] > pair[1] { // each ring reported once, from the pair with lower voter id
+			continue
+		}
+		back := byPair[[2]string{pair[1], pair[0]}]
+		if len(comments(vv)) < d.RingVotes || len(comments(back)) < d.RingVotes {
+			continue
+		}
+		ringVotes := append(append([]Vote{}, vv...), back...)
+		sort.Slice(ringVotes, func(i, j int) bool { return ringVotes[i].Timestamp.Before(ringVotes[j].Timestamp) })
+		res = append(res, newFinding(KindRing, pair[0]+","+pair[1], ringVotes))
+	}
+	sortFindings(res)
+	return res
+}
+
+// bursts finds comments with at least BurstVotes votes within BurstPeriod, all votes of the busiest period reported
+func (d *Detector) bursts(votes []Vote) []Finding {
+	byComment := map[string][]Vote{}
+	for _, v := range votes {
+		byComment[v.CommentID] = append(byComment[v.CommentID], v)
+	}
+	res := []Finding{}
+	for id, vv := range byComment {
+		sort.Slice(vv, func(i, j int) bool { return vv[i].Timestamp.Before(vv[j].Timestamp) })
+		bestFrom, bestTo := 0, 0
+		for from, to := 0, 0; to < len(vv); to++ {
+			for vv[to].Timestamp.Sub(vv[from].Timestamp) > d.BurstPeriod {
+				from++
+			}
+			if to-from > bestTo-bestFrom {
+				bestFrom, bestTo = from, to
+			}
+		}
+		if burst := vv[bestFrom : bestTo+1]; len(voters(burst)) >= d.BurstVotes {
+			res = append(res, newFinding(KindBurst, id, burst))
+		}
+	}
+	sortFindings(res)
+	return res
+}
+
+func newFinding(kind Kind, key string, votes []Vote) Finding {
+	h := sha1.Sum([]byte(string(kind) + "!!" + key)) //nolint:gosec // not a security hash
+	return Finding{ID: fmt.Sprintf("%x", h)[:12], Kind: kind, Key: key, Users: voters(votes), Votes: votes,
+		Detected: time.Now()}
+}
+
+// sortFindings puts findings with more votes first
+func sortFindings(ff []Finding) {
+	sort.Slice(ff, func(i, j int) bool {
+		if len(ff[i].Votes) != len(ff[j].Votes) {
+			return len(ff[i].Votes) > len(ff[j].Votes)
+		}
+		return ff[i].Key < ff[j].Key
+	})
+}
+
+// voters returns sorted unique voters
+func voters(votes []Vote) []string {
+	return unique(votes, func(v Vote) string { return v.UserID })
+}
+
+// comments returns sorted unique ids of voted comments
+func comments(votes []Vote) []string {
+	return unique(votes, func(v Vote) string { return v.CommentID })
+}
+
+func unique(votes []Vote, fn func(Vote) string) []string {
+	seen := map[string]bool{}
+	res := []string{}
+	for _, v := range votes {
+		if k := fn(v); !seen[k] {
+			seen[k] = true
+			res = append(res, k)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// subnet returns /24 network for ipv4 and /48 for ipv6, unparsable ip returned as is
+func subnet(ip string) string {
+	parsed := net.ParseIP(strings.TrimSpace(ip))
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String() + "/48"
+}
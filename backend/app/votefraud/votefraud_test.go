@@ -0,0 +1,178 @@
+package votefraud
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/engine"
+)
+
+func TestDetector_IPAndSubnet(t *testing.T) {
+	st := prepStore(t)
+	d := NewDetector(st, Params{Secret: "secret", SubnetVoters: 4, BurstVotes: 100})
+
+	c := store.Comment{ID: "c1", Locator: store.Locator{SiteID: "site1", URL: "https://example.com/1"}, User: store.User{ID: "author"}}
+	for i := 1; i <= 3; i++ {
+		d.Record(c, fmt.Sprintf("user%d", i), "10.0.0.1", true) // same ip
+	}
+	d.Record(c, "user4", "10.0.0.25", true) // same subnet
+	d.Record(c, "user5", "10.0.1.1", true)
+	d.Record(c, "user6", "2001:db8::1", false)
+	c.Locator.SiteID = "site2"
+	d.Record(c, "user1", "10.0.0.1", true)
+
+	require.NoError(t, d.Analyze())
+	ff := d.Findings("site1")
+	require.Equal(t, 2, len(ff))
+	assert.Equal(t, KindIP, ff[0].Kind)
+	assert.Equal(t, store.HashValue("10.0.0.1", "secret"), ff[0].Key)
+	assert.Equal(t, []string{"user1", "user2", "user3"}, ff[0].Users)
+	assert.Equal(t, 3, len(ff[0].Votes))
+	assert.Equal(t, KindSubnet, ff[1].Kind)
+	assert.Equal(t, store.HashValue("10.0.0.0/24", "secret"), ff[1].Key)
+	assert.Equal(t, []string{"user1", "user2", "user3", "user4"}, ff[1].Users)
+	assert.Equal(t, 0, len(d.Findings("site2")))
+
+	f, ok := d.Finding("site1", ff[0].ID)
+	require.True(t, ok)
+	assert.Equal(t, ff[0], f)
+	_, ok = d.Finding("site2", ff[0].ID)
+	assert.False(t, ok)
+
+	require.NoError(t, d.Resolve("site1", ff[0].ID))
+	assert.Equal(t, 1, len(d.Findings("site1")))
+	require.NoError(t, d.Analyze())
+	assert.Equal(t, 0, len(d.Findings("site1")), "votes of resolved finding not flagged again")
+	assert.Error(t, d.Resolve("site1", ff[0].ID))
+}
+
+func TestDetector_Rings(t *testing.T) {
+	st := prepStore(t)
+	d := NewDetector(st, Params{Secret: "secret", BurstVotes: 100})
+
+	ts := time.Now().Add(-time.Hour)
+	vote := func(voter, author, commentID string, val bool) {
+		ts = ts.Add(time.Second)
+		require.NoError(t, st.Add(Vote{SiteID: "site1", CommentID: commentID, AuthorID: author, UserID: voter,
+			Value: val, Timestamp: ts}))
+	}
+	for i := 0; i < 3; i++ {
+		vote("user1", "user2", fmt.Sprintf("u2-%d", i), true)
+		vote("user2", "user1", fmt.Sprintf("u1-%d", i), true)
+		vote("user3", "user1", fmt.Sprintf("u1-%d", i), true)
+		vote("user1", "user3", fmt.Sprintf("u3-%d", i), i > 0) // one downvote only
+		vote("user4", "user1", "u1-0", true)                   // the same comment only
+		vote("user1", "user4", fmt.Sprintf("u4-%d", i), true)
+	}
+
+	require.NoError(t, d.Analyze())
+	ff := d.Findings("site1")
+	require.Equal(t, 1, len(ff))
+	assert.Equal(t, KindRing, ff[0].Kind)
+	assert.Equal(t, "user1,user2", ff[0].Key)
+	assert.Equal(t, []string{"user1", "user2"}, ff[0].Users)
+	assert.Equal(t, 6, len(ff[0].Votes))
+}
+
+func TestDetector_Bursts(t *testing.T) {
+	st := prepStore(t)
+	d := NewDetector(st, Params{Secret: "secret", BurstVotes: 4, BurstPeriod: time.Minute})
+
+	ts := time.Now().Add(-time.Hour)
+	for i := 0; i < 10; i++ {
+		step := 30 * time.Second // c2 gets votes slower than burst
+		if i >= 3 && i < 8 {
+			step = 5 * time.Second // 7 votes for c1 within a minute
+		}
+		ts = ts.Add(step)
+		require.NoError(t, st.Add(Vote{SiteID: "site1", CommentID: "c1", UserID: fmt.Sprintf("user%d", i),
+			Value: true, Timestamp: ts}))
+		require.NoError(t, st.Add(Vote{SiteID: "site1", CommentID: "c2", UserID: fmt.Sprintf("user%d", i),
+			Value: true, Timestamp: ts.Add(time.Duration(i) * 30 * time.Second)}))
+	}
+
+	require.NoError(t, d.Analyze())
+	ff := d.Findings("site1")
+	require.Equal(t, 1, len(ff))
+	assert.Equal(t, KindBurst, ff[0].Kind)
+	assert.Equal(t, "c1", ff[0].Key)
+	assert.Equal(t, []string{"user1", "user2", "user3", "user4", "user5", "user6", "user7"}, ff[0].Users)
+}
+
+func TestDetector_Run(t *testing.T) {
+	st := prepStore(t)
+	d := NewDetector(st, Params{Secret: "secret", Window: time.Hour, Interval: 10 * time.Millisecond})
+
+	require.NoError(t, st.Add(Vote{SiteID: "site1", CommentID: "c1", UserID: "old", Timestamp: time.Now().Add(-2 * time.Hour)}))
+	c := store.Comment{ID: "c1", Locator: store.Locator{SiteID: "site1"}}
+	for i := 0; i < 3; i++ {
+		d.Record(c, fmt.Sprintf("user%d", i), "10.0.0.1", true)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	d.Run(ctx)
+	assert.Equal(t, 1, len(d.Findings("site1")))
+	votes, err := st.List(time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(votes), "old vote removed")
+}
+
+func TestDetector_Nil(t *testing.T) {
+	var d *Detector
+	d.Record(store.Comment{ID: "c1"}, "user1", "10.0.0.1", true)
+}
+
+func TestEngineStore(t *testing.T) {
+	st := prepStore(t)
+
+	ts := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	votes := []Vote{
+		{SiteID: "site1", CommentID: "c1", UserID: "user1", Timestamp: ts.Add(2 * time.Second)},
+		{SiteID: "site1", CommentID: "c1", UserID: "user2", Timestamp: ts},
+		{SiteID: "site2", CommentID: "c2", UserID: "user1", Timestamp: ts.Add(time.Second)},
+	}
+	for _, v := range votes {
+		require.NoError(t, st.Add(v))
+	}
+
+	res, err := st.List(ts.Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, []Vote{votes[2], votes[0]}, res, "ordered by time")
+
+	require.NoError(t, st.Delete([]Vote{votes[2], {SiteID: "site2", UserID: "unknown"}}))
+	res, err = st.List(time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, []Vote{votes[1], votes[0]}, res)
+
+	n, err := st.Cleanup(ts.Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	res, err = st.List(time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, []Vote{votes[0]}, res)
+}
+
+func TestSubnet(t *testing.T) {
+	tbl := []struct{ ip, subnet string }{
+		{"192.168.1.77", "192.168.1.0/24"},
+		{"2001:db8:abcd:12::1", "2001:db8:abcd::/48"},
+		{"::ffff:10.1.2.3", "10.1.2.0/24"},
+		{"bad", "bad"},
+	}
+	for _, tt := range tbl {
+		assert.Equal(t, tt.subnet, subnet(tt.ip), tt.ip)
+	}
+}
+
+func prepStore(t *testing.T) *EngineStore {
+	eng, err := engine.NewMemory("", "site1", "site2")
+	require.NoError(t, err)
+	return NewEngineStore(eng, func() []string { return []string{"site1", "site2"} })
+}
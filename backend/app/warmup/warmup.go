@@ -0,0 +1,299 @@
+// Package warmup pre-populates cache with comment trees and counts of posts listed in sitemap of the site,
+// on start and periodically, to avoid slow responses with cold cache, i.e. after deploy. Requests made with
+// the handler of the rest server, so cached responses are the same as for real visitors.
+package warmup
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/go-pkgz/syncs"
+	"github.com/pkg/errors"
+)
+
+const (
+	maxSitemapSize  = 50 * 1024 * 1024 // max uncompressed size of sitemap by the protocol
+	maxSitemapDepth = 2                // sitemap index with sitemaps, nested indexes ignored
+)
+
+// Params of warmup
+type Params struct {
+	Sitemaps    map[string]string // sitemap url per site
+	Interval    time.Duration     // interval between warmups, warmed on start only if 0
+	Sorts       []string          // sorts of warmed comment trees, frontend default used if empty
+	MaxURLs     int               // max number of warmed urls of the site, all if 0
+	Concurrency int               // number of parallel requests, 1 if 0
+}
+
+// Status of warmup of the site
+type Status struct {
+	SiteID   string    `json:"site"`
+	Sitemap  string    `json:"sitemap"`
+	Running  bool      `json:"running"`
+	Started  time.Time `json:"started,omitempty"`
+	Finished time.Time `json:"finished,omitempty"`
+	URLs     int       `json:"urls"`   // urls found in sitemap
+	Warmed   int       `json:"warmed"` // urls with cached comments and counts
+	Failed   int       `json:"failed"`
+	Error    string    `json:"error,omitempty"` // error of sitemap loading
+}
+
+// Service warms cache of configured sites
+type Service struct {
+	params Params
+	client *http.Client
+
+	lock    sync.Mutex
+	handler http.Handler // set by rest server once routes made
+	ready   chan struct{}
+	ctx     context.Context // context of Run, used by warmups started by admin
+	status  map[string]Status
+}
+
+// NewService makes warmup service for sites with sitemaps
+func NewService(params Params) *Service {
+	if len(params.Sorts) == 0 {
+		params.Sorts = []string{"-active"}
+	}
+	if params.Concurrency <= 0 {
+		params.Concurrency = 1
+	}
+	res := Service{params: params, client: &http.Client{Timeout: 30 * time.Second}, ready: make(chan struct{}),
+		status: map[string]Status{}}
+	for siteID, sitemap := range params.Sitemaps {
+		res.status[siteID] = Status{SiteID: siteID, Sitemap: sitemap}
+	}
+	return &res
+}
+
+// SetHandler sets handler of warmup requests, warmup starts once it set
+func (s *Service) SetHandler(h http.Handler) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.handler == nil {
+		close(s.ready)
+	}
+	s.handler = h
+}
+
+// Run warms all sites once handler set, and then periodically. Blocks till ctx canceled.
+func (s *Service) Run(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-s.ready:
+	}
+	s.lock.Lock()
+	s.ctx = ctx
+	s.lock.Unlock()
+
+	s.warmAll(ctx)
+	if s.params.Interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.params.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.warmAll(ctx)
+		}
+	}
+}
+
+// Trigger starts warmup of the site in background, rejected if the site has no sitemap or warmup in progress
+func (s *Service) Trigger(siteID string) error {
+	s.lock.Lock()
+	ctx := s.ctx
+	s.lock.Unlock()
+	if ctx == nil {
+		return errors.New("warmup not started")
+	}
+	if !s.start(siteID) {
+		return errors.Errorf("can't start warmup of %s", siteID)
+	}
+	go s.warm(ctx, siteID)
+	return nil
+}
+
+// Status returns status of warmup of the site, false if the site has no sitemap
+func (s *Service) Status(siteID string) (Status, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	res, ok := s.status[siteID]
+	return res, ok
+}
+
+func (s *Service) warmAll(ctx context.Context) {
+	for siteID := range s.params.Sitemaps {
+		if ctx.Err() != nil {
+			return
+		}
+		if s.start(siteID) {
+			s.warm(ctx, siteID)
+		}
+	}
+}
+
+// start marks warmup of the site running, false if not configured or already running
+func (s *Service) start(siteID string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	st, ok := s.status[siteID]
+	if !ok || st.Running {
+		return false
+	}
+	s.status[siteID] = Status{SiteID: siteID, Sitemap: st.Sitemap, Running: true, Started: time.Now()}
+	return true
+}
+
+// warm loads urls from sitemap of the site and requests comments and counts of each url
+func (s *Service) warm(ctx context.Context, siteID string) {
+	var warmed, failed int64
+	st, _ := s.Status(siteID)
+	defer func() {
+		st.Running, st.Finished = false, time.Now()
+		st.Warmed, st.Failed = int(atomic.LoadInt64(&warmed)), int(atomic.LoadInt64(&failed))
+		s.lock.Lock()
+		s.status[siteID] = st
+		s.lock.Unlock()
+		if st.Error == "" {
+			log.Printf("[INFO] warmup of %s completed in %v, %d urls warmed, %d failed", siteID,
+				st.Finished.Sub(st.Started).Truncate(time.Millisecond), st.Warmed, st.Failed)
+		}
+	}()
+
+	urls, err := s.loadSitemap(ctx, st.Sitemap, 1)
+	if err != nil {
+		log.Printf("[WARN] can't load sitemap of %s, %v", siteID, err)
+		st.Error = err.Error()
+		return
+	}
+	if s.params.MaxURLs > 0 && len(urls) > s.params.MaxURLs {
+		urls = urls[:s.params.MaxURLs]
+	}
+	st.URLs = len(urls)
+	s.lock.Lock()
+	s.status[siteID] = st
+	s.lock.Unlock()
+
+	grp := syncs.NewSizedGroup(s.params.Concurrency)
+	for _, u := range urls {
+		if ctx.Err() != nil {
+			break
+		}
+		u := u
+		grp.Go(func(context.Context) {
+			if e := s.warmURL(ctx, siteID, u); e != nil {
+				log.Printf("[DEBUG] can't warm %s, %v", u, e)
+				atomic.AddInt64(&failed, 1)
+				return
+			}
+			atomic.AddInt64(&warmed, 1)
+		})
+	}
+	grp.Wait()
+}
+
+// warmURL requests comment tree of the post with each sort and count of comments
+func (s *Service) warmURL(ctx context.Context, siteID, postURL string) error {
+	paths := []string{}
+	for _, sort := range s.params.Sorts {
+		q := url.Values{"site": {siteID}, "url": {postURL}, "sort": {sort}, "format": {"tree"}}
+		paths = append(paths, "/api/v1/find?"+q.Encode())
+	}
+	paths = append(paths, "/api/v1/counts?"+url.Values{"site": {siteID}, "url": {postURL}}.Encode())
+
+	s.lock.Lock()
+	h := s.handler
+	s.lock.Unlock()
+	for _, p := range paths {
+		req, err := http.NewRequest(http.MethodGet, p, nil)
+		if err != nil {
+			return errors.Wrapf(err, "can't make request %s", p)
+		}
+		w := &discardWriter{header: http.Header{}, code: http.StatusOK}
+		h.ServeHTTP(w, req.WithContext(ctx))
+		if w.code != http.StatusOK {
+			return errors.Errorf("request %s failed with %d", p, w.code)
+		}
+	}
+	return nil
+}
+
+// loadSitemap returns urls from sitemap, urls of sitemap index loaded recursively
+func (s *Service) loadSitemap(ctx context.Context, sitemapURL string, depth int) ([]string, error) {
+	req, err := http.NewRequest(http.MethodGet, sitemapURL, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't make request to %s", sitemapURL)
+	}
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't get %s", sitemapURL)
+	}
+	defer resp.Body.Close() // nolint
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("can't get %s, status %d", sitemapURL, resp.StatusCode)
+	}
+
+	var body io.Reader = resp.Body
+	if strings.HasSuffix(req.URL.Path, ".gz") || resp.Header.Get("Content-Type") == "application/x-gzip" {
+		gz, e := gzip.NewReader(resp.Body)
+		if e != nil {
+			return nil, errors.Wrapf(e, "can't decompress %s", sitemapURL)
+		}
+		defer gz.Close() // nolint
+		body = gz
+	}
+
+	type loc struct {
+		Loc string `xml:"loc"`
+	}
+	doc := struct {
+		URLs     []loc `xml:"url"`     // urlset
+		Sitemaps []loc `xml:"sitemap"` // sitemapindex
+	}{}
+	if err = xml.NewDecoder(io.LimitReader(body, maxSitemapSize)).Decode(&doc); err != nil {
+		return nil, errors.Wrapf(err, "can't parse %s", sitemapURL)
+	}
+
+	res := []string{}
+	for _, u := range doc.URLs {
+		if u.Loc = strings.TrimSpace(u.Loc); u.Loc != "" {
+			res = append(res, u.Loc)
+		}
+	}
+	if depth >= maxSitemapDepth {
+		return res, nil
+	}
+	for _, sm := range doc.Sitemaps {
+		urls, e := s.loadSitemap(ctx, strings.TrimSpace(sm.Loc), depth+1)
+		if e != nil {
+			log.Printf("[WARN] can't load sitemap %s of index %s, %v", sm.Loc, sitemapURL, e)
+			continue
+		}
+		res = append(res, urls...)
+	}
+	return res, nil
+}
+
+// discardWriter keeps status code of response, body discarded
+type discardWriter struct {
+	header http.Header
+	code   int
+}
+
+func (d *discardWriter) Header() http.Header         { return d.header }
+func (d *discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (d *discardWriter) WriteHeader(code int)        { d.code = code }
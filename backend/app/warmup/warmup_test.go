@@ -0,0 +1,122 @@
+package warmup
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_Run(t *testing.T) {
+	ts := sitemapServer(t)
+	defer ts.Close()
+
+	var lock sync.Mutex
+	requests := map[string]int{}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests[r.URL.Path+"?"+r.URL.RawQuery]++
+		lock.Unlock()
+		if r.URL.Query().Get("url") == "https://example.com/broken" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte("{}"))
+	})
+
+	svc := NewService(Params{Sitemaps: map[string]string{"site1": ts.URL + "/sitemap.xml", "site2": ts.URL + "/missing.xml"},
+		Sorts: []string{"-active", "+time"}, Concurrency: 2})
+	st, ok := svc.Status("site1")
+	require.True(t, ok)
+	assert.False(t, st.Running)
+	_, ok = svc.Status("site3")
+	assert.False(t, ok)
+	assert.Error(t, svc.Trigger("site1"), "not started")
+
+	svc.SetHandler(handler)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	svc.Run(ctx) // no interval, warms once
+
+	st, _ = svc.Status("site1")
+	assert.False(t, st.Running)
+	assert.Equal(t, 4, st.URLs, "urls of index and gzipped sitemap")
+	assert.Equal(t, 3, st.Warmed)
+	assert.Equal(t, 1, st.Failed)
+	assert.Empty(t, st.Error)
+	assert.False(t, st.Finished.Before(st.Started))
+
+	lock.Lock()
+	assert.Equal(t, 1, requests["/api/v1/find?format=tree&site=site1&sort=-active&url=https%3A%2F%2Fexample.com%2F1"])
+	assert.Equal(t, 1, requests["/api/v1/find?format=tree&site=site1&sort=%2Btime&url=https%3A%2F%2Fexample.com%2F1"])
+	assert.Equal(t, 1, requests["/api/v1/counts?site=site1&url=https%3A%2F%2Fexample.com%2F3"])
+	lock.Unlock()
+
+	st, _ = svc.Status("site2")
+	assert.Contains(t, st.Error, "status 404")
+
+	// triggered by admin with context of Run
+	require.NoError(t, svc.Trigger("site1"))
+	assert.Eventually(t, func() bool {
+		st, _ = svc.Status("site1")
+		return !st.Running && st.Warmed == 3
+	}, time.Second, 10*time.Millisecond)
+	lock.Lock()
+	assert.Equal(t, 2, requests["/api/v1/counts?site=site1&url=https%3A%2F%2Fexample.com%2F3"])
+	lock.Unlock()
+	assert.Error(t, svc.Trigger("site3"), "no sitemap")
+}
+
+func TestService_MaxURLs(t *testing.T) {
+	ts := sitemapServer(t)
+	defer ts.Close()
+	svc := NewService(Params{Sitemaps: map[string]string{"site1": ts.URL + "/posts.xml"}, MaxURLs: 1})
+	svc.SetHandler(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	svc.Run(context.Background())
+	st, _ := svc.Status("site1")
+	assert.Equal(t, 1, st.URLs)
+	assert.Equal(t, 1, st.Warmed)
+}
+
+func TestService_RunCanceled(t *testing.T) {
+	svc := NewService(Params{Sitemaps: map[string]string{"site1": "http://127.0.0.1:1/sitemap.xml"}})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	svc.Run(ctx) // returns without handler set
+	st, _ := svc.Status("site1")
+	assert.True(t, st.Started.IsZero())
+}
+
+func sitemapServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sitemap.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>http://%s/posts.xml</loc></sitemap>
+  <sitemap><loc>http://%s/more.xml.gz</loc></sitemap>
+  <sitemap><loc>http://%s/missing.xml</loc></sitemap>
+</sitemapindex>`, r.Host, r.Host, r.Host)
+	})
+	mux.HandleFunc("/posts.xml", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>https://example.com/1</loc><lastmod>2021-05-01</lastmod></url>
+  <url><loc> https://example.com/2 </loc></url>
+</urlset>`))
+	})
+	mux.HandleFunc("/more.xml.gz", func(w http.ResponseWriter, r *http.Request) {
+		gz := gzip.NewWriter(w)
+		_, err := gz.Write([]byte(`<urlset><url><loc>https://example.com/3</loc></url>` +
+			`<url><loc>https://example.com/broken</loc></url></urlset>`))
+		require.NoError(t, err)
+		require.NoError(t, gz.Close())
+	})
+	return httptest.NewServer(mux)
+}
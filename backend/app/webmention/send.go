@@ -0,0 +1,167 @@
+package webmention
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	log "github.com/go-pkgz/lgr"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/notify"
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// Send sends mentions to pages linked by new comment, implements notify.Destination.
+// Links to the post itself and to remark42 skipped, as well as pages without webmention endpoint.
+func (s *Service) Send(ctx context.Context, req notify.Request) error {
+	c := req.Comment
+	if !s.SendMentions || req.Moderation != "" || c.ID == "" || c.Pending || c.Deleted || c.Webmention != "" {
+		return nil // held comment sent on approval
+	}
+	targets := s.targets(c)
+	if len(targets) == 0 {
+		return nil
+	}
+	source := s.sourceURL(c)
+	errs := new(multierror.Error)
+	for _, target := range targets {
+		if err := s.sendMention(ctx, source, target); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	log.Printf("[DEBUG] mentions of %d pages sent for comment %s, %d failed", len(targets), c.ID, errs.Len())
+	return errs.ErrorOrNil()
+}
+
+// SendVerification implements notify.Destination, verification messages not sent as mentions
+func (s *Service) SendVerification(_ context.Context, _ notify.VerificationRequest) error {
+	return nil
+}
+
+// String implements notify.Destination
+func (s *Service) String() string {
+	return "webmention: " + s.URL
+}
+
+// targets returns external links of the comment, up to MaxLinks
+func (s *Service) targets(c store.Comment) []string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(c.Text))
+	if err != nil {
+		return nil
+	}
+	res := []string{}
+	seen := map[string]bool{}
+	doc.Find("a[href]").EachWithBreak(func(_ int, a *goquery.Selection) bool {
+		href, _ := a.Attr("href")
+		u, e := url.Parse(href)
+		if e != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return true
+		}
+		if seen[href] || sameURL(href, c.Locator.URL) || strings.HasPrefix(href, s.URL+"/") {
+			return true
+		}
+		seen[href] = true
+		res = append(res, href)
+		return len(res) < s.MaxLinks
+	})
+	return res
+}
+
+// sourceURL returns url of the comment page, source of mentions
+func (s *Service) sourceURL(c store.Comment) string {
+	q := url.Values{"site": {c.Locator.SiteID}, "url": {c.Locator.URL}, "id": {c.ID}}
+	return s.URL + wmPath + "/comment?" + q.Encode()
+}
+
+// sendMention discovers endpoint of the target and sends the mention to it, nothing sent if target has no endpoint
+func (s *Service) sendMention(ctx context.Context, source, target string) error {
+	endpoint, err := s.discover(ctx, target)
+	if err != nil {
+		return err
+	}
+	if endpoint == "" {
+		log.Printf("[DEBUG] no webmention endpoint of %s", target)
+		return nil
+	}
+	form := url.Values{"source": {source}, "target": {target}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return errors.Wrapf(err, "can't make request to %s", endpoint)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "can't send mention of %s", target)
+	}
+	defer resp.Body.Close() // nolint
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxBodySize))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("can't send mention of %s, status %s", target, resp.Status)
+	}
+	log.Printf("[INFO] mention of %s sent to %s", target, endpoint)
+	return nil
+}
+
+var reLinkHeader = regexp.MustCompile(`<([^>]*)>\s*;[^,]*rel="?[^",]*\bwebmention\b`)
+
+// discover returns webmention endpoint of the target from Link header or link and a elements with webmention rel,
+// empty if target has no endpoint. Relative endpoint resolved against the target.
+func (s *Service) discover(ctx context.Context, target string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return "", errors.Wrapf(err, "can't make request to %s", target)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", errors.Wrapf(err, "can't fetch %s", target)
+	}
+	defer resp.Body.Close() // nolint
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("can't fetch %s, status %s", target, resp.Status)
+	}
+	base := resp.Request.URL // after redirects
+
+	endpoint, found := "", false
+	for _, h := range resp.Header.Values("Link") {
+		if m := reLinkHeader.FindStringSubmatch(h); m != nil {
+			endpoint, found = m[1], true
+			break
+		}
+	}
+	if !found && strings.Contains(resp.Header.Get("Content-Type"), "html") {
+		doc, e := goquery.NewDocumentFromReader(io.LimitReader(resp.Body, maxBodySize))
+		if e != nil {
+			return "", errors.Wrapf(e, "can't parse %s", target)
+		}
+		doc.Find("link[href], a[href]").EachWithBreak(func(_ int, sel *goquery.Selection) bool {
+			if contains(strings.Fields(sel.AttrOr("rel", "")), "webmention") {
+				endpoint, found = sel.AttrOr("href", ""), true
+			}
+			return !found
+		})
+	}
+	if !found {
+		return "", nil
+	}
+	u, err := base.Parse(endpoint) // empty href is the target itself
+	if err != nil {
+		return "", errors.Wrapf(err, "bad webmention endpoint %q of %s", endpoint, target)
+	}
+	return u.String(), nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
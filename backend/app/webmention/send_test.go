@@ -0,0 +1,72 @@
+package webmention
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/remark42/backend/app/notify"
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+func TestService_Send(t *testing.T) {
+	svc, _, _ := prepService(t, false)
+	received := make(chan url.Values, 10)
+	var target *httptest.Server
+	target = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/header":
+			w.Header().Set("Link", `<`+target.URL+`/endpoint>; rel="webmention"`)
+		case "/html":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte(`<html><head><link rel="stylesheet" href="/style.css"><link rel="webmention" href="endpoint"></head></html>`))
+		case "/endpoint":
+			require.NoError(t, r.ParseForm())
+			received <- r.PostForm
+			w.WriteHeader(http.StatusAccepted)
+		case "/none":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte(`<html><body>no endpoint</body></html>`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer target.Close()
+
+	c := store.Comment{ID: "c1", Locator: store.Locator{SiteID: "remark", URL: "https://example.com/post1"},
+		Text: `<p><a href="` + target.URL + `/header">1</a> <a href="` + target.URL + `/html">2</a> <a href="` +
+			target.URL + `/none">3</a> <a href="https://example.com/post1#x">post</a> <a href="/rel">rel</a> <a href="` +
+			svc.URL + `/web/x">own</a> <a href="` + target.URL + `/header">dup</a></p>`}
+	require.NoError(t, svc.Send(context.Background(), notify.Request{Comment: c}))
+	require.Equal(t, 2, len(received))
+	source := svc.URL + "/api/v1/webmention/comment?id=c1&site=remark&url=https%3A%2F%2Fexample.com%2Fpost1"
+	assert.Equal(t, url.Values{"source": {source}, "target": {target.URL + "/header"}}, <-received)
+	assert.Equal(t, url.Values{"source": {source}, "target": {target.URL + "/html"}}, <-received)
+
+	for _, req := range []notify.Request{
+		{Comment: store.Comment{ID: "c2", Text: c.Text, Pending: true}},
+		{Comment: store.Comment{ID: "c3", Text: c.Text, Webmention: "https://blog.example.org"}},
+		{Comment: c, Moderation: "approved"},
+	} {
+		require.NoError(t, svc.Send(context.Background(), req))
+	}
+	svc.SendMentions = false
+	require.NoError(t, svc.Send(context.Background(), notify.Request{Comment: c}))
+	assert.Equal(t, 0, len(received), "nothing sent")
+
+	svc.SendMentions = true
+	c.Text = `<a href="` + target.URL + `/missing">x</a>`
+	assert.Error(t, svc.Send(context.Background(), notify.Request{Comment: c}), "target not found")
+}
+
+func TestService_targets(t *testing.T) {
+	svc := &Service{Params: Params{URL: "https://remark42.example.com", MaxLinks: 2}}
+	c := store.Comment{Locator: store.Locator{URL: "https://example.com/post1"},
+		Text: `<a href="https://a.com">a</a> <a href="mailto:x@a.com">m</a> <a href="https://b.com/x">b</a> <a href="https://c.com">c</a>`}
+	assert.Equal(t, []string{"https://a.com", "https://b.com/x"}, svc.targets(c))
+}
@@ -0,0 +1,176 @@
+package webmention
+
+import (
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+var (
+	errGone   = errors.New("source is gone")
+	errNoLink = errors.New("source doesn't link to target")
+)
+
+// sourcePage keeps details of the mentioning page, from microformats of h-entry or html meta
+type sourcePage struct {
+	title   string
+	author  string
+	excerpt string
+}
+
+// text of the comment made from the mention, excerpt of the page quoted and followed by the link to the page
+func (p sourcePage) text(source string) string {
+	title := p.title
+	if title == "" {
+		title = source
+	}
+	link := "[" + strings.NewReplacer("[", `\[`, "]", `\]`).Replace(title) + "](" + source + ")"
+	if p.excerpt == "" {
+		return link
+	}
+	return "> " + p.excerpt + "\n\n" + link
+}
+
+// fetchSource loads source page, errGone if it's removed and errNoLink if it doesn't link to the target
+func (s *Service) fetchSource(source, target string) (sourcePage, error) {
+	ctx, cancel := s.withContext()
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return sourcePage{}, errors.Wrapf(err, "can't make request to %s", source)
+	}
+	req.Header.Set("Accept", "text/html")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return sourcePage{}, errors.Wrapf(err, "can't fetch %s", source)
+	}
+	defer resp.Body.Close() // nolint
+	if resp.StatusCode == http.StatusGone || resp.StatusCode == http.StatusNotFound {
+		return sourcePage{}, errGone
+	}
+	if resp.StatusCode != http.StatusOK {
+		return sourcePage{}, errors.Errorf("can't fetch %s, status %s", source, resp.Status)
+	}
+
+	body := io.LimitReader(resp.Body, maxBodySize)
+	if !strings.Contains(resp.Header.Get("Content-Type"), "html") {
+		data, e := ioutil.ReadAll(body)
+		if e != nil {
+			return sourcePage{}, errors.Wrapf(e, "can't read %s", source)
+		}
+		if !strings.Contains(string(data), target) {
+			return sourcePage{}, errNoLink
+		}
+		return sourcePage{}, nil
+	}
+
+	doc, err := goquery.NewDocumentFromReader(body)
+	if err != nil {
+		return sourcePage{}, errors.Wrapf(err, "can't parse %s", source)
+	}
+	base, _ := url.Parse(source)
+	linked := doc.Find("a[href], link[href]").FilterFunction(func(_ int, sel *goquery.Selection) bool {
+		href, _ := sel.Attr("href")
+		u, e := base.Parse(href)
+		return e == nil && sameURL(u.String(), target)
+	}).Length() > 0
+	if !linked {
+		return sourcePage{}, errNoLink
+	}
+	return parsePage(doc), nil
+}
+
+// parsePage extracts title, author and excerpt of the page from h-entry, meta tags used if page has no h-entry
+func parsePage(doc *goquery.Document) sourcePage {
+	res := sourcePage{}
+	if entry := doc.Find(".h-entry").First(); entry.Length() > 0 {
+		author := entry.Find(".p-author").First()
+		if name := author.Find(".p-name").First(); name.Length() > 0 {
+			author = name
+		}
+		res.author = clean(author.Text())
+		res.title = clean(entry.Find(".p-name").FilterFunction(func(_ int, sel *goquery.Selection) bool {
+			return sel.ParentsFiltered(".p-author").Length() == 0 && !sel.HasClass("p-author")
+		}).First().Text())
+		content := entry.Find(".p-summary").First()
+		if content.Length() == 0 {
+			content = entry.Find(".e-content").First()
+		}
+		res.excerpt = clean(content.Text())
+	}
+	if res.title == "" {
+		res.title = clean(doc.Find("title").First().Text())
+	}
+	if res.author == "" {
+		res.author = clean(doc.Find(`meta[name="author"]`).AttrOr("content", ""))
+	}
+	if res.excerpt == "" {
+		res.excerpt = clean(doc.Find(`meta[name="description"]`).AttrOr("content", ""))
+	}
+	if r := []rune(res.excerpt); len(r) > maxExcerpt {
+		res.excerpt = strings.TrimSpace(string(r[:maxExcerpt])) + "…"
+	}
+	return res
+}
+
+var commentTmpl = template.Must(template.New("comment").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Comment by {{.Name}}</title>
+</head>
+<body>
+<article class="h-entry">
+<p class="p-author h-card"><span class="p-name">{{.Name}}</span></p>
+<div class="e-content">{{.Text}}</div>
+<p><a class="u-url" href="{{.Link}}">{{.Link}}</a>, <time class="dt-published" datetime="{{.Time}}">{{.Time}}</time></p>
+</article>
+</body>
+</html>
+`))
+
+// GET /comment?site=siteID&url=post-url&id=commentID - page of the comment with h-entry, source of mentions sent
+func (s *Service) commentCtrl(w http.ResponseWriter, r *http.Request) {
+	locator := store.Locator{SiteID: r.URL.Query().Get("site"), URL: r.URL.Query().Get("url")}
+	c, err := s.DataService.Get(locator, r.URL.Query().Get("id"), store.User{})
+	if err != nil || c.Pending {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	if c.Deleted {
+		http.Error(w, "Comment deleted", http.StatusGone) // receivers remove the mention
+		return
+	}
+	data := struct {
+		Name, Text template.HTML
+		Link, Time string
+	}{
+		Name: template.HTML(c.User.Name), //nolint:gosec // escaped by sanitizer of comments
+		Text: template.HTML(c.Text),      //nolint:gosec // sanitized comment
+		Link: s.Links.Comment(c.Locator, c.ID),
+		Time: c.Timestamp.UTC().Format("2006-01-02T15:04:05Z"),
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err = commentTmpl.Execute(w, data); err != nil {
+		log.Printf("[WARN] can't render comment %s, %v", c.ID, err)
+	}
+}
+
+// sameURL compares urls ignoring fragment and trailing slash
+func sameURL(a, b string) bool {
+	norm := func(u string) string { return strings.TrimSuffix(strings.SplitN(u, "#", 2)[0], "/") }
+	return norm(a) == norm(b)
+}
+
+func clean(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
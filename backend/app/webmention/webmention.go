@@ -0,0 +1,223 @@
+// Package webmention receives and sends webmentions. Pages linking to posts of a site notify remark42 about the link,
+// the source page fetched and verified in background, and becomes comment of the post with excerpt of the page.
+// Mentions received again kept as is, removed if the source page is gone or doesn't link to the post anymore.
+// New comments linking to other sites sent as webmentions to endpoints of linked pages, with server-rendered
+// page of the comment as the source.
+package webmention
+
+import (
+	"context"
+	"crypto/sha1" //nolint:gosec // used for user id, not for security
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-pkgz/auth/token"
+	log "github.com/go-pkgz/lgr"
+	"github.com/pkg/errors"
+
+	"github.com/umputun/remark42/backend/app/publicnet"
+	"github.com/umputun/remark42/backend/app/store"
+)
+
+// DataService defines subset of service.DataStore used to find and remove comments of mentions
+type DataService interface {
+	Get(locator store.Locator, commentID string, user store.User) (store.Comment, error)
+	Find(locator store.Locator, sortMethod string, user store.User) ([]store.Comment, error)
+	Delete(locator store.Locator, commentID string, mode store.DeleteMode) error
+}
+
+// Creator creates comments going through the same checks as comments of web widget, implemented by rest api
+type Creator interface {
+	CreateComment(ctx context.Context, comment store.Comment) (store.Comment, error)
+}
+
+// Params of the service
+type Params struct {
+	URL          string            // root url of remark42, source pages of sent mentions served under URL/api/v1/webmention
+	Hosts        map[string]string // site to host of its posts, mentions of other targets rejected
+	Trusted      bool              // publish received mentions without moderation
+	SendMentions bool              // send mentions for links of new comments
+	Timeout      time.Duration     // timeout of requests to other sites
+	MaxLinks     int               // max number of links of the comment mentions sent to
+	Links        store.Links       // canonical links to threads of virtual locators
+}
+
+// Service receives mentions with http handler mounted to /api/v1/webmention and verifies them in background.
+// Implements notify.Destination to send mentions for new comments.
+type Service struct {
+	Params
+	DataService DataService
+	Creator     Creator
+
+	client *http.Client
+	router chi.Router
+	queue  chan Mention
+	wg     sync.WaitGroup
+	once   sync.Once
+}
+
+// Mention received from other site
+type Mention struct {
+	SiteID string
+	Source string // url of page linking to the target
+	Target string // url of the post
+}
+
+const (
+	userPrefix   = "webmention_" // user id prefix of mentioning sites, user per host of source
+	wmPath       = "/api/v1/webmention"
+	maxBodySize  = 1024 * 1024
+	maxExcerpt   = 500
+	queueSize    = 100
+	defaultLinks = 5
+)
+
+// NewService makes service and starts verification of received mentions
+func NewService(dataService DataService, params Params) (*Service, error) {
+	u, err := url.Parse(params.URL)
+	if err != nil || u.Host == "" {
+		return nil, errors.Errorf("invalid url %q", params.URL)
+	}
+	params.URL = strings.TrimSuffix(params.URL, "/")
+	if params.Timeout == 0 {
+		params.Timeout = 10 * time.Second
+	}
+	if params.MaxLinks == 0 {
+		params.MaxLinks = defaultLinks
+	}
+	res := &Service{Params: params, DataService: dataService, queue: make(chan Mention, queueSize),
+		client: publicnet.NewClient(params.Timeout)}
+
+	res.router = chi.NewRouter()
+	res.router.Post("/", res.receiveCtrl)
+	res.router.Get("/comment", res.commentCtrl)
+
+	res.wg.Add(1)
+	go func() {
+		defer res.wg.Done()
+		for m := range res.queue {
+			if err := res.Verify(m); err != nil {
+				log.Printf("[WARN] mention of %s by %s rejected, %v", m.Target, m.Source, err)
+			}
+		}
+	}()
+	return res, nil
+}
+
+// ServeHTTP handles received mentions and source pages of sent ones
+func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+// Close stops verification of received mentions, waits for mentions in queue
+func (s *Service) Close() {
+	s.once.Do(func() { close(s.queue) })
+	s.wg.Wait()
+}
+
+// POST /?site=siteID, form with source and target - accepts mention for verification in background
+func (s *Service) receiveCtrl(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad form", http.StatusBadRequest)
+		return
+	}
+	m := Mention{SiteID: r.URL.Query().Get("site"), Source: r.PostForm.Get("source"), Target: r.PostForm.Get("target")}
+	if err := s.validate(m); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m.Target = strings.SplitN(m.Target, "#", 2)[0]
+	select {
+	case s.queue <- m:
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		http.Error(w, "Too many mentions, try later", http.StatusServiceUnavailable)
+	}
+}
+
+// validate checks that source and target are urls and target is a post of the site
+func (s *Service) validate(m Mention) error {
+	host, ok := s.Hosts[m.SiteID]
+	if !ok {
+		return errors.Errorf("mentions of site %q not accepted", m.SiteID)
+	}
+	src, err := url.Parse(m.Source)
+	if err != nil || (src.Scheme != "http" && src.Scheme != "https") || src.Host == "" {
+		return errors.New("source is not url")
+	}
+	target, err := url.Parse(m.Target)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return errors.New("target is not url")
+	}
+	if !strings.EqualFold(target.Host, host) {
+		return errors.Errorf("target is not a post of %s", m.SiteID)
+	}
+	if strings.SplitN(m.Source, "#", 2)[0] == strings.SplitN(m.Target, "#", 2)[0] {
+		return errors.New("source is the same as target")
+	}
+	return nil
+}
+
+// Verify fetches source of the mention and creates comment on the target post if source links to it.
+// Comment of the mention received before removed if source is gone or doesn't link to the target anymore.
+func (s *Service) Verify(m Mention) error {
+	locator := store.Locator{SiteID: m.SiteID, URL: m.Target}
+	existing := s.existing(locator, m.Source)
+	page, err := s.fetchSource(m.Source, m.Target)
+	if err == errGone || err == errNoLink {
+		if existing != nil {
+			log.Printf("[INFO] remove mention %s of %s by %s, %v", existing.ID, m.Target, m.Source, err)
+			return errors.Wrapf(s.DataService.Delete(locator, existing.ID, store.SoftDelete), "can't remove mention %s", existing.ID)
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		log.Printf("[DEBUG] mention of %s by %s received already as %s", m.Target, m.Source, existing.ID)
+		return nil
+	}
+
+	src, _ := url.Parse(m.Source)
+	name := page.author
+	if name == "" {
+		name = src.Host
+	}
+	comment := store.Comment{
+		Locator:    locator,
+		Text:       page.text(m.Source),
+		User:       store.User{ID: userPrefix + token.HashID(sha1.New(), strings.ToLower(src.Host)), Name: name, Website: m.Source}, //nolint:gosec // not used for security
+		Webmention: m.Source,
+		Pending:    !s.Trusted,
+	}
+	res, err := s.Creator.CreateComment(context.Background(), comment)
+	if err != nil {
+		return err
+	}
+	log.Printf("[INFO] comment %s created from mention of %s by %s, pending %v", res.ID, m.Target, m.Source, res.Pending)
+	return nil
+}
+
+// existing returns comment of the mention received from the source before, nil if not received
+func (s *Service) existing(locator store.Locator, source string) *store.Comment {
+	comments, err := s.DataService.Find(locator, "time", store.User{Admin: true})
+	if err != nil {
+		return nil // post without comments
+	}
+	for i := range comments {
+		if comments[i].Webmention == source && !comments[i].Deleted {
+			return &comments[i]
+		}
+	}
+	return nil
+}
+
+// withContext returns context limited by timeout of the service
+func (s *Service) withContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), s.Timeout)
+}
@@ -0,0 +1,204 @@
+package webmention
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/umputun/remark42/backend/app/store"
+	"github.com/umputun/remark42/backend/app/store/admin"
+	"github.com/umputun/remark42/backend/app/store/engine"
+	"github.com/umputun/remark42/backend/app/store/service"
+)
+
+const sourcePageHTML = `<html><head><title>Page title</title></head><body>
+<article class="h-entry">
+<h1 class="p-name">Reply to the post</h1>
+<p class="p-author h-card"><a class="p-name u-url" href="https://blog.example.org">Blog Author</a></p>
+<div class="e-content">Nice    post, see <a href="https://example.com/post1#remark42">it</a></div>
+</article></body></html>`
+
+func TestService_Receive(t *testing.T) {
+	svc, ts, dataStore := prepService(t, false)
+	var page atomic.Value
+	page.Store(sourcePageHTML)
+	src := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(page.Load().(string)))
+	}))
+	defer src.Close()
+
+	post := func(site, source, target string) int {
+		resp, err := http.PostForm(ts.URL+"/api/v1/webmention?site="+site, url.Values{"source": {source}, "target": {target}})
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusBadRequest, post("other", src.URL+"/reply", "https://example.com/post1"))
+	assert.Equal(t, http.StatusBadRequest, post("remark", "bad", "https://example.com/post1"))
+	assert.Equal(t, http.StatusAccepted, post("remark", src.URL+"/reply", "https://example.com/post1#remark42"))
+
+	locator := store.Locator{SiteID: "remark", URL: "https://example.com/post1"}
+	comments := []store.Comment{}
+	require.Eventually(t, func() bool {
+		comments, _ = dataStore.Find(locator, "time", store.User{Admin: true})
+		return len(comments) == 1
+	}, time.Second, 10*time.Millisecond)
+	c := comments[0]
+	assert.Equal(t, src.URL+"/reply", c.Webmention)
+	assert.True(t, c.Pending, "held for moderation")
+	assert.True(t, strings.HasPrefix(c.User.ID, userPrefix), c.User.ID)
+	assert.Equal(t, "Blog Author", c.User.Name)
+	assert.Equal(t, src.URL+"/reply", c.User.Website)
+	assert.Contains(t, c.Text, "<blockquote>")
+	assert.Contains(t, c.Text, "Nice post, see it")
+	assert.Contains(t, c.Text, `<a href="`+src.URL+`/reply" rel="nofollow">Reply to the post</a>`)
+
+	// received again, kept as is
+	require.NoError(t, svc.Verify(Mention{SiteID: "remark", Source: src.URL + "/reply", Target: locator.URL}))
+	comments, err := dataStore.Find(locator, "time", store.User{Admin: true})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(comments))
+	assert.Equal(t, c.ID, comments[0].ID)
+
+	// source doesn't link to the post anymore
+	page.Store(`<html><body><p>no links</p></body></html>`)
+	require.NoError(t, svc.Verify(Mention{SiteID: "remark", Source: src.URL + "/reply", Target: locator.URL}))
+	deleted, err := dataStore.Get(locator, c.ID, store.User{})
+	require.NoError(t, err)
+	assert.True(t, deleted.Deleted)
+
+	err = svc.Verify(Mention{SiteID: "remark", Source: src.URL + "/reply", Target: locator.URL})
+	assert.Equal(t, errNoLink, err, "nothing to remove")
+}
+
+func TestService_VerifyTrusted(t *testing.T) {
+	svc, _, dataStore := prepService(t, true)
+	src := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gone" {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("see https://example.com/post1"))
+	}))
+	defer src.Close()
+
+	locator := store.Locator{SiteID: "remark", URL: "https://example.com/post1"}
+	require.NoError(t, svc.Verify(Mention{SiteID: "remark", Source: src.URL + "/note", Target: locator.URL}))
+	comments, err := dataStore.Find(locator, "time", store.User{})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(comments), "published without moderation")
+	assert.False(t, comments[0].Pending)
+	assert.Equal(t, strings.TrimPrefix(src.URL, "http://"), comments[0].User.Name, "host of source without author")
+
+	assert.Equal(t, errGone, svc.Verify(Mention{SiteID: "remark", Source: src.URL + "/gone", Target: locator.URL}))
+}
+
+func TestService_validate(t *testing.T) {
+	svc := &Service{Params: Params{Hosts: map[string]string{"remark": "example.com"}}}
+	tbl := []struct {
+		m  Mention
+		ok bool
+	}{
+		{Mention{SiteID: "remark", Source: "https://blog.example.org/p", Target: "https://example.com/post1"}, true},
+		{Mention{SiteID: "remark", Source: "https://blog.example.org/p", Target: "https://EXAMPLE.com/post1#c"}, true},
+		{Mention{SiteID: "other", Source: "https://blog.example.org/p", Target: "https://example.com/post1"}, false},
+		{Mention{SiteID: "remark", Source: "ftp://blog.example.org/p", Target: "https://example.com/post1"}, false},
+		{Mention{SiteID: "remark", Source: "https://blog.example.org/p", Target: "/post1"}, false},
+		{Mention{SiteID: "remark", Source: "https://blog.example.org/p", Target: "https://other.com/post1"}, false},
+		{Mention{SiteID: "remark", Source: "https://example.com/post1#a", Target: "https://example.com/post1"}, false},
+	}
+	for i, tt := range tbl {
+		err := svc.validate(tt.m)
+		assert.Equal(t, tt.ok, err == nil, "case %d, %v", i, err)
+	}
+}
+
+func TestService_CommentPage(t *testing.T) {
+	_, ts, dataStore := prepService(t, false)
+	locator := store.Locator{SiteID: "remark", URL: "https://example.com/post1"}
+	id, err := dataStore.Create(store.Comment{Locator: locator, Text: "<p>some text</p>", User: store.User{ID: "user1", Name: "User &lt;1&gt;"}})
+	require.NoError(t, err)
+	pendingID, err := dataStore.Create(store.Comment{Locator: locator, Text: "held", User: store.User{ID: "user1"}, Pending: true})
+	require.NoError(t, err)
+
+	get := func(id string) (int, string) {
+		resp, e := http.Get(ts.URL + "/api/v1/webmention/comment?site=remark&url=https://example.com/post1&id=" + id)
+		require.NoError(t, e)
+		defer resp.Body.Close()
+		body, e := ioutil.ReadAll(resp.Body)
+		require.NoError(t, e)
+		return resp.StatusCode, string(body)
+	}
+	code, body := get(id)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `<span class="p-name">User &lt;1&gt;</span>`)
+	assert.Contains(t, body, `<div class="e-content"><p>some text</p></div>`)
+	assert.Contains(t, body, `href="https://example.com/post1#remark42__comment-`+id+`"`)
+
+	code, _ = get(pendingID)
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = get("bad")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	require.NoError(t, dataStore.Delete(locator, id, store.SoftDelete))
+	code, _ = get(id)
+	assert.Equal(t, http.StatusGone, code)
+}
+
+func prepService(t *testing.T, trusted bool) (*Service, *httptest.Server, *service.DataStore) {
+	dbFile := os.TempDir() + "/remark-webmention-test.db"
+	_ = os.Remove(dbFile)
+	b, err := engine.NewBoltDB(bolt.Options{}, engine.BoltSite{FileName: dbFile, SiteID: "remark"})
+	require.NoError(t, err)
+	dataStore := &service.DataStore{Engine: b, AdminStore: admin.NewStaticStore("12345", nil, []string{}, ""),
+		MaxCommentSize: 1000, MaxVotes: -1}
+
+	router := chi.NewRouter()
+	ts := httptest.NewServer(router)
+	svc, err := NewService(dataStore, Params{URL: ts.URL, Hosts: map[string]string{"remark": "example.com"},
+		Trusted: trusted, SendMentions: true})
+	require.NoError(t, err)
+	svc.client = &http.Client{Timeout: time.Second} // test servers are on loopback
+	svc.Creator = dataCreator{dataStore}
+	router.Mount("/api/v1/webmention", svc)
+
+	t.Cleanup(func() {
+		ts.Close()
+		svc.Close()
+		assert.NoError(t, dataStore.Close())
+		_ = os.Remove(dbFile)
+	})
+	return svc, ts, dataStore
+}
+
+// dataCreator saves comments to data store with basic checks, stands for shared creation path of rest api
+type dataCreator struct {
+	dataStore *service.DataStore
+}
+
+func (c dataCreator) CreateComment(_ context.Context, comment store.Comment) (store.Comment, error) {
+	comment.Orig = comment.Text
+	if err := c.dataStore.ValidateComment(&comment); err != nil {
+		return store.Comment{}, err
+	}
+	comment = store.NewCommentFormatter().Format(comment)
+	id, err := c.dataStore.Create(comment)
+	if err != nil {
+		return store.Comment{}, err
+	}
+	return c.dataStore.Get(comment.Locator, id, comment.User)
+}